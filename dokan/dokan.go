@@ -81,6 +81,12 @@ func (fi *FileInfo) IsRequestorUserSidEqualTo(sid *winacl.SID) bool {
 	return fi.isRequestorUserSidEqualTo(sid)
 }
 
+// ProcessID returns the ID of the process that made the filesystem
+// request.
+func (fi *FileInfo) ProcessID() uint32 {
+	return uint32(fi.ptr.ProcessId)
+}
+
 // NumberOfFileHandles returns the number of open file handles for
 // this filesystem.
 func (fi *FileInfo) NumberOfFileHandles() uint32 {
@@ -121,14 +121,15 @@ type FileAttribute uint32
 
 // File attribute bit masks - same as syscall but provided for all platforms.
 const (
-	FileAttributeReadonly     = FileAttribute(0x00000001)
-	FileAttributeHidden       = FileAttribute(0x00000002)
-	FileAttributeSystem       = FileAttribute(0x00000004)
-	FileAttributeDirectory    = FileAttribute(0x00000010)
-	FileAttributeArchive      = FileAttribute(0x00000020)
-	FileAttributeNormal       = FileAttribute(0x00000080)
-	FileAttributeReparsePoint = FileAttribute(0x00000400)
-	IOReparseTagSymlink       = 0xA000000C
+	FileAttributeReadonly          = FileAttribute(0x00000001)
+	FileAttributeHidden            = FileAttribute(0x00000002)
+	FileAttributeSystem            = FileAttribute(0x00000004)
+	FileAttributeDirectory         = FileAttribute(0x00000010)
+	FileAttributeArchive           = FileAttribute(0x00000020)
+	FileAttributeNormal            = FileAttribute(0x00000080)
+	FileAttributeReparsePoint      = FileAttribute(0x00000400)
+	FileAttributeNotContentIndexed = FileAttribute(0x00002000)
+	IOReparseTagSymlink            = 0xA000000C
 )
 
 // File is the interface for files and directories.
@@ -281,6 +282,8 @@ const (
 	ErrFileAlreadyExists = NtStatus(0xC0000035)
 	// ErrNotSameDevice - MoveFile is denied, please use copy+delete.
 	ErrNotSameDevice = NtStatus(0xC00000D4)
+	// ErrMediaWriteProtected - the volume is read-only (EROFS).
+	ErrMediaWriteProtected = NtStatus(0xC00000A2)
 	// StatusBufferOverflow - buffer space too short for return value.
 	StatusBufferOverflow = NtStatus(0x80000005)
 	// StatusObjectNameExists - already exists, may be non-fatal...
@@ -0,0 +1,103 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// kbfsbench turns the output of `go test -bench` into JSON, and
+// optionally compares it against the JSON from an earlier run, so
+// that performance regressions can be caught before a release.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const usageStr = `Usage:
+  go test -run=XXX -bench=. -benchmem ./libkbfs | \
+      kbfsbench [-o results.json] [-baseline old.json [-threshold 0.1]]
+
+kbfsbench reads benchmark output from stdin, and writes the results
+as JSON to -o (or stdout).  If -baseline is given, it also prints
+every benchmark that's slower than in the baseline by more than
+-threshold, and exits with status 2 if there are any.
+
+`
+
+var out = flag.String("o", "", "File to write the JSON results to")
+var baseline = flag.String("baseline", "",
+	"JSON results of an earlier run to compare against")
+var threshold = flag.Float64("threshold", 0.1,
+	"Fraction by which a benchmark may get slower before it counts "+
+		"as a regression")
+
+func readBaseline(path string) ([]result, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var results []result
+	err = json.Unmarshal(buf, &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Define this so deferred functions get executed before exit.
+func realMain() (exitStatus int) {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usageStr)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if len(flag.Args()) > 0 {
+		flag.Usage()
+		return 1
+	}
+
+	results, err := parseResults(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't parse results: %v\n", err)
+		return 1
+	}
+
+	buf, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't encode results: %v\n", err)
+		return 1
+	}
+	buf = append(buf, '\n')
+	if *out == "" {
+		_, err = os.Stdout.Write(buf)
+	} else {
+		err = ioutil.WriteFile(*out, buf, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't write results: %v\n", err)
+		return 1
+	}
+
+	if *baseline == "" {
+		return 0
+	}
+	old, err := readBaseline(*baseline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't read baseline: %v\n", err)
+		return 1
+	}
+	regressions := findRegressions(old, results, *threshold)
+	for _, r := range regressions {
+		fmt.Fprintf(os.Stderr, "Regression: %s\n", r)
+	}
+	if len(regressions) > 0 {
+		return 2
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(realMain())
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// result is the machine-readable form of one line of `go test -bench`
+// output.
+type result struct {
+	Name        string
+	Iterations  int64
+	NsPerOp     float64
+	MBPerSec    float64 `json:",omitempty"`
+	BytesPerOp  int64   `json:",omitempty"`
+	AllocsPerOp int64   `json:",omitempty"`
+}
+
+// parseResults parses all the benchmark result lines in the given
+// `go test -bench` output, and ignores everything else.
+func parseResults(r io.Reader) ([]result, error) {
+	var results []result
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// A result line has a name, an iteration count, and
+		// then value-unit pairs.
+		if len(fields) < 4 || len(fields)%2 != 0 ||
+			!strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		iterations, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		res := result{Name: fields[0], Iterations: iterations}
+		for i := 2; i < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, errors.Wrapf(err,
+					"couldn't parse %q in %q", fields[i], scanner.Text())
+			}
+			switch fields[i+1] {
+			case "ns/op":
+				res.NsPerOp = value
+			case "MB/s":
+				res.MBPerSec = value
+			case "B/op":
+				res.BytesPerOp = int64(value)
+			case "allocs/op":
+				res.AllocsPerOp = int64(value)
+			}
+		}
+		results = append(results, res)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// regression describes a benchmark that got slower.
+type regression struct {
+	Name           string
+	OldNsPerOp     float64
+	NewNsPerOp     float64
+	FractionSlower float64
+}
+
+func (r regression) String() string {
+	return fmt.Sprintf("%s: %.0f ns/op -> %.0f ns/op (%+.1f%%)",
+		r.Name, r.OldNsPerOp, r.NewNsPerOp, 100*r.FractionSlower)
+}
+
+// findRegressions returns all the benchmarks in current that are
+// slower than the same benchmark in baseline by more than threshold
+// (as a fraction of the baseline time), in the order they appear in
+// current.  Benchmarks that are only in one of the two are ignored.
+func findRegressions(
+	baseline, current []result, threshold float64) []regression {
+	old := make(map[string]result, len(baseline))
+	for _, res := range baseline {
+		old[res.Name] = res
+	}
+	var regressions []regression
+	for _, res := range current {
+		oldRes, ok := old[res.Name]
+		if !ok || oldRes.NsPerOp <= 0 {
+			continue
+		}
+		slower := (res.NsPerOp - oldRes.NsPerOp) / oldRes.NsPerOp
+		if slower > threshold {
+			regressions = append(regressions, regression{
+				Name:           res.Name,
+				OldNsPerOp:     oldRes.NsPerOp,
+				NewNsPerOp:     res.NsPerOp,
+				FractionSlower: slower,
+			})
+		}
+	}
+	return regressions
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testBenchOutput = `goos: linux
+goarch: amd64
+pkg: github.com/keybase/kbfs/libkbfs
+BenchmarkFolderBlockOpsWrite/size=4096-8    	     100	   2503129 ns/op	   1.64 MB/s	  599051 B/op	    1336 allocs/op
+BenchmarkCRLargeBranch/branchSize=10-8      	      43	   6386825 ns/op	 1702273 B/op	    8625 allocs/op
+BenchmarkEncryptBlock-8                     	    1000	      1500 ns/op
+--- FAIL: BenchmarkJournalFlush
+PASS
+ok  	github.com/keybase/kbfs/libkbfs	66.563s
+`
+
+func TestParseResults(t *testing.T) {
+	results, err := parseResults(strings.NewReader(testBenchOutput))
+	require.NoError(t, err)
+	require.Equal(t, []result{
+		{
+			Name:        "BenchmarkFolderBlockOpsWrite/size=4096-8",
+			Iterations:  100,
+			NsPerOp:     2503129,
+			MBPerSec:    1.64,
+			BytesPerOp:  599051,
+			AllocsPerOp: 1336,
+		},
+		{
+			Name:        "BenchmarkCRLargeBranch/branchSize=10-8",
+			Iterations:  43,
+			NsPerOp:     6386825,
+			BytesPerOp:  1702273,
+			AllocsPerOp: 8625,
+		},
+		{
+			Name:       "BenchmarkEncryptBlock-8",
+			Iterations: 1000,
+			NsPerOp:    1500,
+		},
+	}, results)
+}
+
+func TestFindRegressions(t *testing.T) {
+	baseline := []result{
+		{Name: "BenchmarkA", NsPerOp: 100},
+		{Name: "BenchmarkB", NsPerOp: 100},
+		{Name: "BenchmarkC", NsPerOp: 100},
+	}
+	current := []result{
+		{Name: "BenchmarkA", NsPerOp: 105},
+		{Name: "BenchmarkB", NsPerOp: 150},
+		{Name: "BenchmarkC", NsPerOp: 50},
+		{Name: "BenchmarkD", NsPerOp: 1000},
+	}
+	regressions := findRegressions(baseline, current, 0.1)
+	require.Equal(t, []regression{{
+		Name:           "BenchmarkB",
+		OldNsPerOp:     100,
+		NewNsPerOp:     150,
+		FractionSlower: 0.5,
+	}}, regressions)
+}
@@ -30,3 +30,34 @@ func TestCodecEncodeMap(t *testing.T) {
 
 	require.Equal(t, b1, b2)
 }
+
+// TestCodecDecodeHugeLengths tests that codec.Decode() rejects data
+// whose lengths don't fit in it, instead of trying to allocate them.
+func TestCodecDecodeHugeLengths(t *testing.T) {
+	codec := NewMsgpack()
+
+	var b []byte
+	err := codec.Decode([]byte{0xc6, 0xff, 0xff, 0xff, 0xff, 0x01}, &b)
+	require.Error(t, err)
+
+	var s string
+	err = codec.Decode([]byte{0xdb, 0xff, 0xff, 0xff, 0xff, 0x61}, &s)
+	require.Error(t, err)
+
+	var a []int
+	err = codec.Decode([]byte{0xdd, 0xff, 0xff, 0xff, 0xff, 0x01}, &a)
+	require.Error(t, err)
+
+	// The same, but nested in a map.
+	var m map[string][]byte
+	err = codec.Decode([]byte{
+		0x81, 0xa1, 0x61, 0xc6, 0xff, 0xff, 0xff, 0xff, 0x01}, &m)
+	require.Error(t, err)
+
+	// Lengths that do fit are fine.
+	buf, err := codec.Encode(map[string][]byte{"a": {1, 2, 3}})
+	require.NoError(t, err)
+	err = codec.Decode(buf, &m)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{"a": {1, 2, 3}}, m)
+}
@@ -26,6 +26,11 @@ var version = flag.Bool("version", false, "Print version")
 var mountFlags = flag.Int64("mount-flags", int64(libdokan.DefaultMountFlags), "Dokan mount flags")
 var dokandll = flag.String("dokan-dll", "", "Absolute path of dokan dll to load")
 var servicemount = flag.Bool("mount-from-service", false, "get mount path from service")
+var denyExecutables = flag.String("deny-executables", "", "comma-separated executables, by full path or base name, whose processes may not use the mount")
+var atime = flag.String("atime", libfs.NoAtime.String(), "when reads update the access times of files, which are only kept in memory: noatime, relatime, strictatime")
+var ctime = flag.String("ctime", "kbfs", "what to report as the creation times of files: kbfs (the times KBFS stores), mtime (the modification times)")
+var remoteMtimes = flag.String("remote-mtimes", "exact", "how to report modification times in the future, from writers with skewed clocks: exact, clamp (to the current time)")
+var indexerOpsPerSecond = flag.Float64("indexer-ops-per-second", 10, "how many requests a second desktop search indexers (Spotlight, Windows Search, Tracker, Baloo) may make of the mount; 0 means no limit")
 
 const usageFormatStr = `Usage:
   kbfsdokan -version
@@ -34,6 +39,9 @@ To run against remote KBFS servers:
   kbfsdokan
     [-runtime-dir=path/to/dir] [-label=label] [-mount-type=force]
     [-mount-flags=n] [-dokan-dll=path/to/dokan.dll]
+    [-deny-executables=name,...] [-indexer-ops-per-second=n]
+    [-atime=noatime|relatime|strictatime] [-ctime=kbfs|mtime]
+    [-remote-mtimes=exact|clamp]
 %s
     -mount-from-service | /path/to/mountpoint
 
@@ -41,6 +49,9 @@ To run in a local testing environment:
   kbfsdokan
     [-runtime-dir=path/to/dir] [-label=label] [-mount-type=force]
     [-mount-flags=n] [-dokan-dll=path/to/dokan.dll]
+    [-deny-executables=name,...] [-indexer-ops-per-second=n]
+    [-atime=noatime|relatime|strictatime] [-ctime=kbfs|mtime]
+    [-remote-mtimes=exact|clamp]
 %s
     -mount-from-service | /path/to/mountpoint
 
@@ -87,6 +98,11 @@ func start() *libfs.Error {
 		return libfs.InitError("extra arguments specified (flags go before the first argument)")
 	}
 
+	timePolicy, err := libfs.ParseTimePolicy(*atime, *ctime, *remoteMtimes)
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+
 	var mounter libdokan.Mounter
 	if *mountType == "force" {
 		mounter = libdokan.NewForceMounter(mountpoint)
@@ -104,6 +120,9 @@ func start() *libfs.Error {
 			MountFlags: dokan.MountFlag(*mountFlags),
 			DllPath:    *dokandll,
 		},
+		DeniedExecutables:   libfs.ParseDeniedExecutables(*denyExecutables),
+		IndexerOpsPerSecond: *indexerOpsPerSecond,
+		TimePolicy:          timePolicy,
 	}
 
 	return libdokan.Start(mounter, options, ctx)
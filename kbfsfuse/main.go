@@ -10,6 +10,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
 
 	"bazil.org/fuse"
 
@@ -24,19 +25,52 @@ var runtimeDir = flag.String("runtime-dir", os.Getenv("KEYBASE_RUNTIME_DIR"), "r
 var label = flag.String("label", os.Getenv("KEYBASE_LABEL"), "label to help identify if running as a service")
 var mountType = flag.String("mount-type", defaultMountType, "mount type: default, force, none")
 var version = flag.Bool("version", false, "Print version")
+var takeover = flag.Bool("takeover", false, "take over the mount of a kbfsfuse already running with the same runtime directory")
+var denyExecutables = flag.String("deny-executables", "", "comma-separated executables, by full path or base name, whose processes may not use the mount")
+var indexerOpsPerSecond = flag.Float64("indexer-ops-per-second", 10, "how many requests a second desktop search indexers (Spotlight, Windows Search, Tracker, Baloo) may make of the mount; 0 means no limit")
+var atime = flag.String("atime", libfs.NoAtime.String(), "when reads update the access times of files, which are only kept in memory: noatime, relatime, strictatime")
+var ctime = flag.String("ctime", "kbfs", "what to report as the change times of files: kbfs (the times KBFS stores), mtime (the modification times)")
+var remoteMtimes = flag.String("remote-mtimes", "exact", "how to report modification times in the future, from writers with skewed clocks: exact, clamp (to the current time)")
+var localObjects = flag.Bool("local-objects", false, "let FIFOs and unix sockets be created, backed by objects local to this mount and recorded in the folder as placeholder symlinks")
+var volumePluginSocket = flag.String("volume-plugin-socket", "", "serve the Docker volume plugin API on this unix socket, e.g. /run/docker/plugins/kbfs.sock")
+var volumeMountRoot = flag.String("volume-mount-root", "/var/lib/kbfs/volumes", "directory under which the volume plugin mounts volumes")
+var serviceConfigPath = flag.String("config", "", "run headless from this JSON service config, reread on SIGHUP, instead of a mountpoint argument")
+var nameConflicts = flag.String("name-conflicts", defaultNameConflicts(), "names to treat as the same, and show with a disambiguating suffix: exact, unicode (normalization-insensitive), case (case- and normalization-insensitive)")
+
+// defaultNameConflicts returns the name conflict policy matching
+// how applications on this platform compare names.
+func defaultNameConflicts() string {
+	if runtime.GOOS == "darwin" {
+		return libfs.CaseInsensitiveNames.String()
+	}
+	return libfs.ExactNames.String()
+}
 
 const usageFormatStr = `Usage:
   kbfsfuse -version
 
+To run headless from a service config, with the same flags as below:
+  kbfsfuse -config=path/to/config.json
+
 To run against remote KBFS servers:
   kbfsfuse
     [-runtime-dir=path/to/dir] [-label=label] [-mount-type=force]
+    [-takeover] [-name-conflicts=exact|unicode|case]
+    [-deny-executables=name,...] [-indexer-ops-per-second=n]
+    [-atime=noatime|relatime|strictatime] [-ctime=kbfs|mtime]
+    [-remote-mtimes=exact|clamp] [-local-objects]
+    [-volume-plugin-socket=path] [-volume-mount-root=path/to/dir]
 %s
     %s/path/to/mountpoint
 
 To run in a local testing environment:
   kbfsfuse
     [-runtime-dir=path/to/dir] [-label=label] [-mount-type=force]
+    [-takeover] [-name-conflicts=exact|unicode|case]
+    [-deny-executables=name,...] [-indexer-ops-per-second=n]
+    [-atime=noatime|relatime|strictatime] [-ctime=kbfs|mtime]
+    [-remote-mtimes=exact|clamp] [-local-objects]
+    [-volume-plugin-socket=path] [-volume-mount-root=path/to/dir]
 %s
     %s/path/to/mountpoint
 
@@ -67,7 +101,19 @@ func start() *libfs.Error {
 		return nil
 	}
 
-	if len(flag.Args()) < 1 {
+	// A service config names the mountpoint itself, if any.
+	var serviceConfig *libfuse.ServiceConfig
+	if *serviceConfigPath != "" {
+		var err error
+		serviceConfig, err = libfuse.LoadServiceConfig(*serviceConfigPath)
+		if err != nil {
+			return libfs.InitError(err.Error())
+		}
+		if len(flag.Args()) > 0 {
+			fmt.Print(getUsageString(ctx))
+			return libfs.InitError("the mountpoint of a service goes in its config")
+		}
+	} else if len(flag.Args()) < 1 {
 		fmt.Print(getUsageString(ctx))
 		return libfs.InitError("no mount specified")
 	}
@@ -84,9 +130,30 @@ func start() *libfs.Error {
 			fuseLog, false /* superVerbose */)
 	}
 
+	nameConflictPolicy, err := libfs.ParseNameConflictPolicy(*nameConflicts)
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+
+	timePolicy, err := libfs.ParseTimePolicy(*atime, *ctime, *remoteMtimes)
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+
 	mountpoint := flag.Arg(0)
+	if serviceConfig != nil {
+		mountpoint = serviceConfig.Mountpoint
+		if serviceConfig.VolumePluginSocket != "" {
+			*volumePluginSocket = serviceConfig.VolumePluginSocket
+		}
+		if serviceConfig.VolumeMountRoot != "" {
+			*volumeMountRoot = serviceConfig.VolumeMountRoot
+		}
+	}
 	var mounter libfuse.Mounter
-	if *mountType == "force" {
+	if mountpoint == "" {
+		mounter = libfuse.NewNoopMounter()
+	} else if *mountType == "force" {
 		mounter = libfuse.NewForceMounter(mountpoint, *platformParams)
 	} else if *mountType == "none" {
 		mounter = libfuse.NewNoopMounter()
@@ -99,6 +166,17 @@ func start() *libfs.Error {
 		PlatformParams: *platformParams,
 		RuntimeDir:     *runtimeDir,
 		Label:          *label,
+		Takeover:       *takeover,
+
+		NameConflictPolicy:  nameConflictPolicy,
+		DeniedExecutables:   libfs.ParseDeniedExecutables(*denyExecutables),
+		IndexerOpsPerSecond: *indexerOpsPerSecond,
+		TimePolicy:          timePolicy,
+		LocalObjects:        *localObjects,
+
+		VolumePluginSocket: *volumePluginSocket,
+		VolumeMountRoot:    *volumeMountRoot,
+		ServiceConfig:      serviceConfig,
 	}
 
 	return libfuse.Start(mounter, options, ctx)
@@ -0,0 +1,158 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func archiveExport(ctx context.Context, config libkbfs.Config,
+	tlfPath, archivePath string, start, end int64) error {
+	tlfID, err := getTlfID(ctx, config, tlfPath)
+	if err != nil {
+		return err
+	}
+
+	key, err := libkbfs.MakeRandomTLFArchiveKey()
+	if err != nil {
+		return err
+	}
+
+	// Don't overwrite an existing archive, since its key would be
+	// lost with it.
+	f, err := os.OpenFile(
+		archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	summary, err := libkbfs.ExportTLFArchive(ctx, config, tlfID,
+		libkbfs.MetadataRevision(start), libkbfs.MetadataRevision(end),
+		key, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return err
+	}
+
+	fmt.Printf("Exported %s to %s: %s\n", tlfPath, archivePath, summary)
+	fmt.Printf("Archive key (keep it somewhere safe; the archive can't "+
+		"be read without it):\n%s\n", key)
+	return nil
+}
+
+func archiveImport(ctx context.Context, config libkbfs.Config,
+	archivePath, keyStr string) error {
+	key, err := libkbfs.ParseTLFArchiveKey(keyStr)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	summary, err := libkbfs.ImportTLFArchive(ctx, config, key, f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %s: %s\n", archivePath, summary)
+	return nil
+}
+
+func archiveRestore(ctx context.Context, config libkbfs.Config,
+	archivePath, dirPath, keyStr string) error {
+	key, err := libkbfs.ParseTLFArchiveKey(keyStr)
+	if err != nil {
+		return err
+	}
+
+	p, err := fsrpc.NewPath(dirPath)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("%q is not a path in a TLF", dirPath)
+	}
+	dir, ei, err := p.GetNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	if ei.Type != libkbfs.Dir {
+		return fmt.Errorf("%q is not a directory", dirPath)
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	summary, err := libkbfs.RestoreTLFArchive(ctx, config, key, f, dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %s into %s\n", summary, dirPath)
+	return nil
+}
+
+const archiveUsageStr = `Usage:
+  kbfstool archive export [-start rev] [-end rev] /keybase/[public|private]/user1,assertion2 <archive file>
+  kbfstool archive import -key <archive key> <archive file>
+  kbfstool archive restore -key <archive key> <archive file> /keybase/[public|private]/user1,assertion2[/path/to/dir]
+
+`
+
+func archive(ctx context.Context, config libkbfs.Config, args []string) (
+	exitStatus int) {
+	if len(args) < 1 {
+		fmt.Print(archiveUsageStr)
+		return 1
+	}
+
+	cmd := args[0]
+	flags := flag.NewFlagSet("kbfs archive "+cmd, flag.ContinueOnError)
+	start := flags.Int64("start", int64(libkbfs.MetadataRevisionInitial),
+		"The first revision to export.")
+	end := flags.Int64("end", int64(libkbfs.MetadataRevisionUninitialized),
+		"The last revision to export; defaults to the current head.")
+	keyStr := flags.String("key", "",
+		"The key printed when the archive was exported.")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		printError("archive", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	switch {
+	case cmd == "export" && len(inputs) == 2:
+		err = archiveExport(ctx, config, inputs[0], inputs[1], *start, *end)
+	case cmd == "import" && len(inputs) == 1 && *keyStr != "":
+		err = archiveImport(ctx, config, inputs[0], *keyStr)
+	case cmd == "restore" && len(inputs) == 2 && *keyStr != "":
+		err = archiveRestore(ctx, config, inputs[0], inputs[1], *keyStr)
+	default:
+		fmt.Print(archiveUsageStr)
+		return 1
+	}
+	if err != nil {
+		printError("archive", err)
+		return 1
+	}
+
+	return 0
+}
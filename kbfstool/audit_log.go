@@ -0,0 +1,130 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func auditLogExport(ctx context.Context, config libkbfs.Config,
+	tlfPath, logPath string, start, end int64) error {
+	tlfID, err := getTlfID(ctx, config, tlfPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(logPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Continue an existing log from where it ends, once it's been
+	// verified.
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var prev libkbfs.TLFAuditLogHead
+	if fi.Size() > 0 {
+		prev, _, err = libkbfs.VerifyTLFAuditLog(f)
+		if err != nil {
+			return err
+		}
+		start = int64(prev.Revision) + 1
+	}
+
+	head, err := libkbfs.ExportTLFAuditLog(ctx, config, tlfID,
+		libkbfs.MetadataRevision(start), libkbfs.MetadataRevision(end),
+		prev, f)
+	if err != nil {
+		// Drop anything half-written, so the log stays sealed.
+		if truncErr := f.Truncate(fi.Size()); truncErr != nil {
+			printError("audit-log", truncErr)
+		}
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported revisions %d to %d of %s to %s\n",
+		start, head.Revision, tlfPath, logPath)
+	return nil
+}
+
+func auditLogVerify(logPath string) error {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head, signers, err := libkbfs.VerifyTLFAuditLog(f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is intact, up to revision %d of TLF %s\n",
+		logPath, head.Revision, head.TlfID)
+	fmt.Printf("Sealed by:\n")
+	for _, key := range signers {
+		fmt.Printf("  %s\n", key)
+	}
+	return nil
+}
+
+const auditLogUsageStr = `Usage:
+  kbfstool audit-log export [-start rev] [-end rev] /keybase/[public|private]/user1,assertion2 <log file>
+  kbfstool audit-log verify <log file>
+
+Export appends the operations in the given revisions to the log file,
+followed by a seal signed by this device.  If the log file already
+has revisions in it, the export continues from the one after them.
+
+`
+
+func auditLog(ctx context.Context, config libkbfs.Config, args []string) (
+	exitStatus int) {
+	if len(args) < 1 {
+		fmt.Print(auditLogUsageStr)
+		return 1
+	}
+
+	cmd := args[0]
+	flags := flag.NewFlagSet("kbfs audit-log "+cmd, flag.ContinueOnError)
+	start := flags.Int64("start", int64(libkbfs.MetadataRevisionInitial),
+		"The first revision to export to a new log.")
+	end := flags.Int64("end", int64(libkbfs.MetadataRevisionUninitialized),
+		"The last revision to export; defaults to the current head.")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		printError("audit-log", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	switch {
+	case cmd == "export" && len(inputs) == 2:
+		err = auditLogExport(ctx, config, inputs[0], inputs[1], *start, *end)
+	case cmd == "verify" && len(inputs) == 1:
+		err = auditLogVerify(inputs[0])
+	default:
+		fmt.Print(auditLogUsageStr)
+		return 1
+	}
+	if err != nil {
+		printError("audit-log", err)
+		return 1
+	}
+
+	return 0
+}
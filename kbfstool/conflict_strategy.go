@@ -0,0 +1,102 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func conflictStrategyOne(ctx context.Context, config libkbfs.Config,
+	tlfPath, prefix, strategyName string, clear bool) error {
+	p, err := fsrpc.NewPath(tlfPath)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) > 0 {
+		return fmt.Errorf("%q is not the root path of a TLF", tlfPath)
+	}
+
+	n, _, err := p.GetNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	fb := n.GetFolderBranch()
+
+	if clear {
+		err = config.KBFSOps().ClearConflictStrategy(ctx, fb, prefix)
+		if err != nil {
+			return err
+		}
+	} else if strategyName != "" {
+		strategy, err := libkbfs.ParseConflictStrategy(strategyName)
+		if err != nil {
+			return err
+		}
+		err = config.KBFSOps().SetConflictStrategy(ctx, fb, prefix, strategy)
+		if err != nil {
+			return err
+		}
+	}
+
+	rules, err := config.KBFSOps().GetConflictStrategies(ctx, fb)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		fmt.Printf("%s: every conflict is resolved manually\n", tlfPath)
+		return nil
+	}
+	for _, rule := range rules {
+		fmt.Printf("%s/%s: %s\n", tlfPath, rule.PathPrefix, rule.Strategy)
+	}
+	return nil
+}
+
+const conflictStrategyUsageStr = `Usage:
+  kbfstool conflict-strategy [-prefix <path>] [-set manual|ours|theirs|merge | -clear] /keybase/[public|private]/user1,assertion2
+
+Conflicting changes to a file are resolved with the strategy of the
+longest matching prefix:
+  manual   keep both versions, renaming the local one (the default)
+  ours     keep the local version
+  theirs   keep the remote version
+  merge    apply the local writes to the remote version if they don't
+           overlap, otherwise keep both versions
+
+`
+
+func conflictStrategy(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	flags := flag.NewFlagSet(
+		"kbfs conflict-strategy", flag.ContinueOnError)
+	prefix := flags.String("prefix", "",
+		"The path within the folder that the rule covers.")
+	set := flags.String("set", "", "Set the strategy for the prefix.")
+	clear := flags.Bool("clear", false, "Remove the rule for the prefix.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("conflict-strategy", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 1 || (*clear && *set != "") {
+		fmt.Print(conflictStrategyUsageStr)
+		return 1
+	}
+
+	err = conflictStrategyOne(ctx, config, inputs[0], *prefix, *set, *clear)
+	if err != nil {
+		printError("conflict-strategy", err)
+		return 1
+	}
+
+	return 0
+}
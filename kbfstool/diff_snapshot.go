@@ -0,0 +1,110 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// resolveSnapshotOrRevision returns the revision of the named
+// snapshot of the given folder or, if there's no such snapshot and
+// the name is a number, that revision.
+func resolveSnapshotOrRevision(ctx context.Context, config libkbfs.Config,
+	fb libkbfs.FolderBranch, name string) (libkbfs.MetadataRevision, error) {
+	rev, err := getSnapshotRevision(ctx, config, fb, name)
+	if _, ok := err.(libkbfs.NoSuchSnapshotError); !ok {
+		return rev, err
+	}
+	n, parseErr := strconv.ParseInt(name, 10, 64)
+	if parseErr != nil {
+		return libkbfs.MetadataRevisionUninitialized, err
+	}
+	return libkbfs.MetadataRevision(n), nil
+}
+
+func diffSnapshotOne(ctx context.Context, config libkbfs.Config,
+	tlfPath, from, to string) error {
+	fb, _, err := getSnapshotFolderBranch(ctx, config, tlfPath)
+	if err != nil {
+		return err
+	}
+	oldRev, err := resolveSnapshotOrRevision(ctx, config, fb, from)
+	if err != nil {
+		return err
+	}
+	newRev, err := resolveSnapshotOrRevision(ctx, config, fb, to)
+	if err != nil {
+		return err
+	}
+
+	diff, err := libkbfs.DiffTLFRevisions(ctx, config, fb.Tlf, oldRev, newRev)
+	if err != nil {
+		return err
+	}
+	for _, e := range diff.Entries {
+		var change string
+		switch e.Change {
+		case libkbfs.RevisionDiffAdded:
+			change = "+"
+		case libkbfs.RevisionDiffRemoved:
+			change = "-"
+		case libkbfs.RevisionDiffRenamed:
+			change = "R"
+		default:
+			change = "M"
+		}
+		path := e.Path
+		if e.Type == libkbfs.Dir {
+			path += "/"
+		}
+		if e.Change == libkbfs.RevisionDiffRenamed {
+			oldPath := e.OldPath
+			if e.Type == libkbfs.Dir {
+				oldPath += "/"
+			}
+			path = oldPath + " -> " + path
+		}
+		fmt.Printf("%s %s\t%+d\n", change, path, e.ByteDelta())
+	}
+	fmt.Printf("Revision %d to %d: %d entries changed, %+d bytes\n",
+		oldRev, newRev, len(diff.Entries), diff.ByteDelta())
+	return nil
+}
+
+const diffSnapshotUsageStr = `Usage:
+  kbfstool diff-snapshot /keybase/[public|private]/user1,assertion2 <from> <to>
+
+<from> and <to> are snapshot names or revision numbers.
+
+`
+
+func diffSnapshot(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs diff-snapshot", flag.ContinueOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		printError("diff-snapshot", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 3 {
+		fmt.Print(diffSnapshotUsageStr)
+		return 1
+	}
+
+	err = diffSnapshotOne(ctx, config, inputs[0], inputs[1], inputs[2])
+	if err != nil {
+		printError("diff-snapshot", err)
+		return 1
+	}
+
+	return 0
+}
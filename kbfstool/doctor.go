@@ -0,0 +1,60 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const doctorUsageStr = `Usage:
+  kbfstool doctor
+
+Checks the Keybase service connection, the session, the journal
+directory, the free disk space and the file system driver, and says
+how to fix whatever is wrong.  Exits with status 1 if any check
+found an error.
+
+`
+
+func printHealthFinding(f libkbfs.HealthFinding) {
+	fmt.Printf("[%s] %s: %s\n", f.Severity, f.Check, f.Problem)
+	if f.Advice != "" {
+		fmt.Printf("    %s\n", f.Advice)
+	}
+}
+
+// doctorInitFailed reports a failure to initialize KBFS in the same
+// form as the other findings, since that's usually the most
+// important one.
+func doctorInitFailed(err error) (exitStatus int) {
+	printHealthFinding(libkbfs.HealthFinding{
+		Check:    "init",
+		Severity: libkbfs.HealthError,
+		Problem:  fmt.Sprintf("KBFS couldn't start: %v", err),
+		Advice:   "Make sure the Keybase service is running, e.g. with `keybase service`",
+	})
+	return 1
+}
+
+func doctor(ctx context.Context, config libkbfs.Config, args []string) (
+	exitStatus int) {
+	if len(args) != 0 {
+		fmt.Print(doctorUsageStr)
+		return 1
+	}
+
+	findings := libkbfs.CheckHealth(ctx, config, libfs.CheckDriverHealth)
+	for _, f := range findings {
+		printHealthFinding(f)
+	}
+	if libkbfs.HealthWorstSeverity(findings) == libkbfs.HealthError {
+		return 1
+	}
+	return 0
+}
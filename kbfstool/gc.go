@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func gcVerifyOne(ctx context.Context, config libkbfs.Config,
+	tlfPath string, dryRun bool) error {
+	tlfID, err := getTlfID(ctx, config, tlfPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recomputing block references for %s...\n", tlfPath)
+
+	audit, err := libkbfs.AuditBlockRefs(ctx, config, tlfID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", audit)
+	for _, ptr := range audit.Leaked {
+		fmt.Printf("Leaked reference: %v\n", ptr)
+	}
+
+	if dryRun {
+		fmt.Print("Dry-run set; not doing anything\n")
+		return nil
+	}
+
+	fmt.Print("Reconciling with the block server...\n")
+
+	return libkbfs.RepairBlockRefs(ctx, config, audit)
+}
+
+const gcUsageStr = `Usage:
+  kbfstool gc --verify [-d] /keybase/[public|private]/user1,assertion2
+
+`
+
+func gc(ctx context.Context, config libkbfs.Config, args []string) (
+	exitStatus int) {
+	flags := flag.NewFlagSet("kbfs gc", flag.ContinueOnError)
+	verify := flags.Bool("verify", false,
+		"Recompute block references from the MD history, and archive or "+
+			"delete any that the block server should no longer have.")
+	dryRun := flags.Bool("d", false, "Dry run: don't actually do anything.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("gc", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 1 || !*verify {
+		// Regular quota reclamation happens in the background of
+		// a running KBFS instance; only verification is done here.
+		fmt.Print(gcUsageStr)
+		return 1
+	}
+
+	err = gcVerifyOne(ctx, config, inputs[0], *dryRun)
+	if err != nil {
+		printError("gc", err)
+		return 1
+	}
+
+	fmt.Print("\n")
+
+	return 0
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+)
+
+const journalUsageStr = `Usage:
+  kbfstool journal dump [-decode] [tlfID]
+
+Lists what's waiting to be uploaded from the local journals, of every
+folder or just the one with the given ID.  With -decode, each
+revision's MD is read too, along with the operations it makes in
+public folders.  Only local files are read, so this works on a copy
+of a storage root.  KBFS must not be running.
+
+`
+
+func printBlockJournalDump(name string,
+	entries []libkbfs.BlockJournalDumpEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	fmt.Printf("  %s:\n", name)
+	for _, e := range entries {
+		if e.Err != "" {
+			fmt.Printf("    %d: error: %s\n", e.Ordinal, e.Err)
+			continue
+		}
+		var notes []string
+		if e.Op == "mdRevisionMarker" {
+			notes = append(notes, fmt.Sprintf("revision %d", e.Revision))
+		}
+		if e.Ignored {
+			notes = append(notes, "ignored")
+		}
+		if e.IsLocalSquash {
+			notes = append(notes, "local squash")
+		}
+		fmt.Printf("    %d: %s", e.Ordinal, e.Op)
+		if len(notes) > 0 {
+			fmt.Printf(" (%s)", strings.Join(notes, ", "))
+		}
+		fmt.Print("\n")
+		for _, b := range e.Blocks {
+			fmt.Printf("      %s", b.ID)
+			if b.Size > 0 {
+				fmt.Printf(" %d bytes", b.Size)
+			} else if b.Size < 0 {
+				fmt.Print(" data missing")
+			}
+			fmt.Printf(" %v\n", b.Contexts)
+		}
+	}
+}
+
+func printMDJournalDump(entries []libkbfs.MDJournalDumpEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	fmt.Print("  MDs:\n")
+	for _, e := range entries {
+		if e.Err != "" && e.ID == (libkbfs.MdID{}) {
+			fmt.Printf("    revision %d: error: %s\n", e.Revision, e.Err)
+			continue
+		}
+		fmt.Printf("    revision %d: %s", e.Revision, e.ID)
+		if e.IsLocalSquash {
+			fmt.Print(" (local squash)")
+		}
+		fmt.Print("\n")
+		if e.Err != "" {
+			fmt.Printf("      error: %s\n", e.Err)
+			continue
+		}
+		if e.Timestamp.IsZero() {
+			// Not decoded.
+			continue
+		}
+		fmt.Printf("      %s by %s, %s, branch %s\n",
+			e.Timestamp, e.Writer, e.MergeStatus, e.BranchID)
+		fmt.Printf("      prev root %s\n", e.PrevRoot)
+		fmt.Printf("      ref %d bytes, unref %d bytes, disk usage %d bytes\n",
+			e.RefBytes, e.UnrefBytes, e.DiskUsage)
+		if len(e.Flags) > 0 {
+			fmt.Printf("      flags: %s\n", strings.Join(e.Flags, ", "))
+		}
+		if e.OpsErr != "" {
+			fmt.Printf("      ops not shown: %s\n", e.OpsErr)
+		}
+		for _, op := range e.Ops {
+			fmt.Printf("      %s\n", op)
+		}
+	}
+}
+
+func journalDump(storageRoot string, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs journal dump", flag.ContinueOnError)
+	decode := flags.Bool("decode", false,
+		"Decode each revision's MD, and public folders' operations.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("journal dump", err)
+		return 1
+	}
+
+	if len(flags.Args()) > 1 {
+		fmt.Print(journalUsageStr)
+		return 1
+	}
+
+	tlfID := tlf.NullID
+	if len(flags.Args()) == 1 {
+		tlfID, err = tlf.ParseID(flags.Arg(0))
+		if err != nil {
+			printError("journal dump", err)
+			return 1
+		}
+	}
+
+	dumps, err := libkbfs.DumpJournals(storageRoot, tlfID, *decode)
+	if err != nil {
+		printError("journal dump", err)
+		return 1
+	}
+
+	if len(dumps) == 0 {
+		fmt.Print("No journals found\n")
+		return 0
+	}
+
+	for _, d := range dumps {
+		fmt.Printf("%s (user %s, device key %s)\n", d.TlfID, d.UID,
+			d.VerifyingKey)
+		fmt.Printf("  dir: %s\n", d.Dir)
+		fmt.Printf("  unflushed: %d bytes, stored: %d bytes\n",
+			d.UnflushedBytes, d.StoredBytes)
+		printBlockJournalDump("blocks", d.Blocks)
+		printBlockJournalDump("deferred GC", d.DeferredGC)
+		printMDJournalDump(d.MDs)
+	}
+
+	return 0
+}
+
+// journal runs before libkbfs.Init, since initializing KBFS opens
+// the journals being read, and may start flushing them.
+func journal(storageRoot string, args []string) (exitStatus int) {
+	if len(args) < 1 {
+		fmt.Print(journalUsageStr)
+		return 1
+	}
+
+	cmd := args[0]
+	args = args[1:]
+
+	switch cmd {
+	case "dump":
+		return journalDump(storageRoot, args)
+	default:
+		printError("journal", fmt.Errorf("unknown command %q", cmd))
+		return 1
+	}
+}
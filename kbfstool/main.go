@@ -41,6 +41,25 @@ The possible commands are:
   read		Dump file to stdout
   write		Write stdin to file
   md            Operate on metadata objects
+  gc            Verify and repair block references
+  archive       Export a folder's history to an encrypted archive, and
+                import or restore one
+  audit-log     Export a signed log of the operations in a folder, or
+                verify one
+  retention     Display or change a folder's history retention
+  pin           List, pin or unpin revisions kept from quota reclamation
+  snapshot      Create, list, delete or browse named snapshots of a folder
+  diff-snapshot List what changed between two snapshots or revisions
+  restore       Restore a file to its contents as of a revision or snapshot
+  recovery      Display what the last unclean shutdown left behind
+  saved-changes List or delete local changes saved before being discarded
+  conflict-strategy
+                List or set how conflicting changes to files are resolved
+  doctor        Check that KBFS can run, and say how to fix it if not
+  migrate       Move the disk caches and journals to a new storage root
+  journal       List what's waiting to be uploaded from the local journals
+  settings      List or change the settings of the running KBFS
+  shrink-cache  Evict blocks from the disk block cache to free up space
 
 `
 
@@ -71,14 +90,36 @@ func realMain() (exitStatus int) {
 
 	log := logger.NewWithCallDepth("", 1)
 
+	if flag.Arg(0) == "migrate" {
+		return migrate(context.Background(), log, kbfsParams.StorageRoot,
+			flag.Args()[1:])
+	}
+	if flag.Arg(0) == "journal" {
+		return journal(kbfsParams.StorageRoot, flag.Args()[1:])
+	}
+	if flag.Arg(0) == "settings" {
+		return settings(kbfsParams.StorageRoot, flag.Args()[1:])
+	}
+
 	// Pause journal background work, since it may interfere with
 	// an existing kbfs daemon instance.
 	kbfsParams.TLFJournalBackgroundWorkStatus =
 		libkbfs.TLFJournalBackgroundWorkPaused
+	// Leave the unclean shutdown tracking and the settings to the
+	// daemon.
+	kbfsParams.TrackCleanShutdown = false
+	kbfsParams.ServeSettings = false
+	if flag.Arg(0) == "shrink-cache" {
+		// The cache has to be open to be shrunk.
+		kbfsParams.EnableDiskCache = true
+	}
 	// TODO: Turn off the rekey queue and other background tasks.
 
 	config, err := libkbfs.Init(kbCtx, *kbfsParams, nil, nil, log)
 	if err != nil {
+		if flag.Arg(0) == "doctor" {
+			return doctorInitFailed(err)
+		}
 		printError("kbfs", err)
 		return 1
 	}
@@ -107,6 +148,32 @@ func realMain() (exitStatus int) {
 		return write(ctx, config, args)
 	case "md":
 		return mdMain(ctx, config, args)
+	case "gc":
+		return gc(ctx, config, args)
+	case "archive":
+		return archive(ctx, config, args)
+	case "audit-log":
+		return auditLog(ctx, config, args)
+	case "retention":
+		return retention(ctx, config, args)
+	case "pin":
+		return pin(ctx, config, args)
+	case "snapshot":
+		return snapshot(ctx, config, args)
+	case "diff-snapshot":
+		return diffSnapshot(ctx, config, args)
+	case "restore":
+		return restore(ctx, config, args)
+	case "recovery":
+		return recovery(ctx, config, args)
+	case "saved-changes":
+		return savedChanges(ctx, config, args)
+	case "conflict-strategy":
+		return conflictStrategy(ctx, config, args)
+	case "doctor":
+		return doctor(ctx, config, args)
+	case "shrink-cache":
+		return shrinkCache(ctx, config, args)
 	default:
 		printError("kbfs", fmt.Errorf("unknown command '%s'", cmd))
 		return 1
@@ -115,6 +115,7 @@ func mdCheckChain(ctx context.Context, config libkbfs.Config,
 	fmt.Printf("Checking chain from rev %d to %d...\n",
 		minRevision, irmd.Revision())
 	gcUnrefs := make(map[libkbfs.BlockRef]bool)
+	fetchedDownTo := irmd.Revision()
 	for {
 		rootPtr := irmd.Data().Dir.BlockPointer
 		if !rootPtr.Ref().IsValid() {
@@ -160,7 +161,11 @@ func mdCheckChain(ctx context.Context, config libkbfs.Config,
 			fmt.Printf("Fetching rev %d...\n", irmd.Revision()-1)
 		}
 
-		// TODO: Getting in chunks would be faster.
+		if irmd.Revision()-1 < fetchedDownTo {
+			fetchedDownTo = mdGetChunkBefore(ctx, config, irmd.TlfID(),
+				irmd.BID(), irmd.Revision()-1, minRevision)
+		}
+
 		irmdPrev, err := mdGet(ctx, config, irmd.TlfID(),
 			irmd.BID(), irmd.Revision()-1)
 		if err != nil {
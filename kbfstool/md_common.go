@@ -136,6 +136,35 @@ func mdGet(ctx context.Context, config libkbfs.Config, tlfID tlf.ID,
 	return libkbfs.ImmutableRootMetadata{}, nil
 }
 
+// mdGetChunkSize is the number of revisions mdGetChunkBefore asks
+// the server for at once.
+const mdGetChunkSize = 100
+
+// mdGetChunkBefore fetches the revisions from rev back to (but not
+// past) minRev, up to mdGetChunkSize of them, in a single request.
+// They end up in the MD cache, so walking back through them with
+// mdGet afterwards doesn't hit the server again.  It returns the
+// earliest revision that was requested.
+func mdGetChunkBefore(ctx context.Context, config libkbfs.Config,
+	tlfID tlf.ID, branchID libkbfs.BranchID,
+	rev, minRev libkbfs.MetadataRevision) libkbfs.MetadataRevision {
+	start := rev - mdGetChunkSize + 1
+	if start < minRev {
+		start = minRev
+	}
+	if start < libkbfs.MetadataRevisionInitial {
+		start = libkbfs.MetadataRevisionInitial
+	}
+	// Any errors will be reported by the following mdGet calls.
+	if branchID == libkbfs.NullBranchID {
+		_, _ = config.MDOps().GetRange(ctx, tlfID, start, rev)
+	} else {
+		_, _ = config.MDOps().GetUnmergedRange(
+			ctx, tlfID, branchID, start, rev)
+	}
+	return start
+}
+
 func mdParseAndGet(ctx context.Context, config libkbfs.Config, input string) (
 	libkbfs.ImmutableRootMetadata, error) {
 	matches := mdGetRegexp.FindStringSubmatch(input)
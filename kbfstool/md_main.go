@@ -15,6 +15,8 @@ The possible subcommands are:
   check	      Check metadata objects and their associated blocks for errors
   reset	      Reset a broken top-level folder
   force-qr    Append a fake quota reclamation record to the folder history
+  membership-impact
+	      Preview the rekey work a change of folder members would need
 `
 
 func mdMain(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
@@ -35,6 +37,8 @@ func mdMain(ctx context.Context, config libkbfs.Config, args []string) (exitStat
 		return mdReset(ctx, config, args)
 	case "force-qr":
 		return mdForceQR(ctx, config, args)
+	case "membership-impact":
+		return mdMembershipImpact(ctx, config, args)
 	default:
 		printError("md", fmt.Errorf("unknown command '%s'", cmd))
 		return 1
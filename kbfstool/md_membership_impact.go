@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func resolveUsers(ctx context.Context, config libkbfs.Config,
+	list string) ([]keybase1.UID, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var uids []keybase1.UID
+	for _, assertion := range strings.Split(list, ",") {
+		_, uid, err := config.KBPKI().Resolve(ctx, assertion)
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+func mdMembershipImpactOne(ctx context.Context, config libkbfs.Config,
+	tlfPath string, addWriters, addReaders, remove string) error {
+	handle, err := parseTLFPath(ctx, config.KBPKI(), tlfPath)
+	if err != nil {
+		return err
+	}
+
+	var change libkbfs.MembershipChange
+	change.AddWriters, err = resolveUsers(ctx, config, addWriters)
+	if err != nil {
+		return err
+	}
+	change.AddReaders, err = resolveUsers(ctx, config, addReaders)
+	if err != nil {
+		return err
+	}
+	change.Remove, err = resolveUsers(ctx, config, remove)
+	if err != nil {
+		return err
+	}
+
+	_, irmd, err := config.MDOps().GetForHandle(ctx, handle, libkbfs.Merged)
+	if err != nil {
+		return err
+	}
+	if irmd == (libkbfs.ImmutableRootMetadata{}) {
+		return fmt.Errorf("%q has no history yet", tlfPath)
+	}
+
+	impact, err := libkbfs.PreviewMembershipChange(
+		ctx, config.KBPKI(), irmd.ReadOnlyRootMetadata, change)
+	if err != nil {
+		return err
+	}
+
+	name := func(uid keybase1.UID) string {
+		n, err := config.KBPKI().GetNormalizedUsername(ctx, uid)
+		if err != nil {
+			return uid.String()
+		}
+		return n.String()
+	}
+
+	fmt.Printf("%s (TLF ID %s) at revision %d, key generation %d:\n",
+		tlfPath, impact.TlfID, impact.Revision,
+		impact.LatestKeyGeneration)
+	if impact.NewKeyGeneration {
+		fmt.Printf("  A new key generation (%d) will be made; revisions "+
+			"after %d will be encrypted with it\n",
+			impact.LatestKeyGeneration+1, impact.Revision)
+	} else {
+		fmt.Print("  No new key generation is needed\n")
+	}
+	for uid, n := range impact.DevicesToKey {
+		fmt.Printf("  %s: %d device(s) will get keys for all of history\n",
+			name(uid), n)
+	}
+	for _, uid := range impact.RemovedKeepHistory {
+		fmt.Printf("  %s: can still read revisions up to %d\n",
+			name(uid), impact.Revision)
+	}
+	fmt.Printf("  Disk usage stays at %d bytes\n", impact.DiskUsage)
+	return nil
+}
+
+const mdMembershipImpactUsageStr = `Usage:
+  kbfstool md membership-impact [-add-writers u1,u2] [-add-readers u3] [-remove u4] /keybase/[public|private]/user1,assertion2
+
+`
+
+func mdMembershipImpact(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	flags := flag.NewFlagSet(
+		"kbfs md membership-impact", flag.ContinueOnError)
+	addWriters := flags.String("add-writers", "",
+		"Comma-separated users to add as writers.")
+	addReaders := flags.String("add-readers", "",
+		"Comma-separated users to add as readers.")
+	remove := flags.String("remove", "",
+		"Comma-separated users to remove.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("md membership-impact", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 1 {
+		fmt.Print(mdMembershipImpactUsageStr)
+		return 1
+	}
+
+	err = mdMembershipImpactOne(
+		ctx, config, inputs[0], *addWriters, *addReaders, *remove)
+	if err != nil {
+		printError("md membership-impact", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const migrateUsageStr = `Usage:
+  kbfstool migrate [-from /path/to/old/root] -to /path/to/new/root
+
+KBFS must not be running.  Use the same directory for -from and -to
+to convert the disk block caches to the current format in place.
+
+`
+
+// migrate runs before libkbfs.Init, since initializing KBFS opens the
+// disk caches and journals being moved.
+func migrate(ctx context.Context, log logger.Logger, storageRoot string,
+	args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs migrate", flag.ContinueOnError)
+	from := flags.String("from", storageRoot,
+		"The storage root to move the disk caches and journals from.")
+	to := flags.String("to", "",
+		"The storage root to move the disk caches and journals to.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("migrate", err)
+		return 1
+	}
+
+	if len(flags.Args()) != 0 || *to == "" || *from == "" {
+		fmt.Print(migrateUsageStr)
+		return 1
+	}
+
+	report, err := libkbfs.MigrateStorage(ctx, log, *from, *to)
+	if err != nil {
+		printError("migrate", err)
+		return 1
+	}
+
+	fmt.Printf("Migrated %s to %s\n", report.OldRoot, report.NewRoot)
+	for _, c := range report.DiskCaches {
+		fmt.Printf("%s: %d blocks (%d bytes), version %d -> %d",
+			c.Dir, c.NumBlocks, c.Bytes, c.FromVersion, c.ToVersion)
+		if c.NumCorrupt > 0 {
+			fmt.Printf(", %d corrupt blocks dropped", c.NumCorrupt)
+		}
+		fmt.Print("\n")
+	}
+	if report.NumFiles > 0 {
+		fmt.Printf("Other files: %d (%d bytes)\n",
+			report.NumFiles, report.Bytes)
+	}
+
+	return 0
+}
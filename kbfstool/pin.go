@@ -0,0 +1,94 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func pinOne(ctx context.Context, config libkbfs.Config,
+	tlfPath string, pinRev, unpinRev int64) error {
+	p, err := fsrpc.NewPath(tlfPath)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) > 0 {
+		return fmt.Errorf("%q is not the root path of a TLF", tlfPath)
+	}
+
+	n, _, err := p.GetNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	fb := n.GetFolderBranch()
+
+	if pinRev > 0 {
+		err = config.KBFSOps().PinRevision(
+			ctx, fb, libkbfs.MetadataRevision(pinRev))
+		if err != nil {
+			return err
+		}
+	}
+	if unpinRev > 0 {
+		err = config.KBFSOps().UnpinRevision(
+			ctx, fb, libkbfs.MetadataRevision(unpinRev))
+		if err != nil {
+			return err
+		}
+	}
+
+	revs, err := config.KBFSOps().GetPinnedRevisions(ctx, fb)
+	if err != nil {
+		return err
+	}
+	if len(revs) == 0 {
+		fmt.Printf("%s: no pinned revisions\n", tlfPath)
+		return nil
+	}
+	fmt.Printf("%s: pinned revisions", tlfPath)
+	for _, rev := range revs {
+		fmt.Printf(" %d", rev)
+	}
+	fmt.Printf("\n")
+	return nil
+}
+
+const pinUsageStr = `Usage:
+  kbfstool pin [-pin <revision>] [-unpin <revision>] /keybase/[public|private]/user1,assertion2
+
+`
+
+func pin(ctx context.Context, config libkbfs.Config, args []string) (
+	exitStatus int) {
+	flags := flag.NewFlagSet("kbfs pin", flag.ContinueOnError)
+	pinRev := flags.Int64("pin", 0,
+		"Pin a revision, so that quota reclamation keeps everything "+
+			"needed to reconstruct it.")
+	unpinRev := flags.Int64("unpin", 0, "Unpin a revision.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("pin", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 1 {
+		fmt.Print(pinUsageStr)
+		return 1
+	}
+
+	err = pinOne(ctx, config, inputs[0], *pinRev, *unpinRev)
+	if err != nil {
+		printError("pin", err)
+		return 1
+	}
+
+	return 0
+}
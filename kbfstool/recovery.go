@@ -0,0 +1,51 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const recoveryUsageStr = `Usage:
+  kbfstool recovery
+
+`
+
+func recovery(ctx context.Context, config libkbfs.Config, args []string) (
+	exitStatus int) {
+	if len(args) != 0 {
+		fmt.Print(recoveryUsageStr)
+		return 1
+	}
+
+	report, err := libkbfs.ReadRecoveryReport(config.StorageRoot())
+	if err != nil {
+		printError("recovery", err)
+		return 1
+	}
+	if report == nil {
+		fmt.Printf("The last shutdown was clean\n")
+		return 0
+	}
+
+	fmt.Printf("KBFS (pid %d, started %s) didn't shut down cleanly; "+
+		"detected at %s\n", report.PreviousPID, report.PreviousStart,
+		report.DetectedAt)
+	for _, t := range report.Tlfs {
+		fmt.Printf("%s: %d revisions (%d bytes) recovered from the journal",
+			t.TlfID, t.RecoveredRevisions, t.UnflushedBytes)
+		if t.DirtyWritesLost {
+			fmt.Printf(", unsynced writes lost")
+		}
+		if t.ConflictLikely {
+			fmt.Printf(", conflict likely")
+		}
+		fmt.Printf("\n")
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const restoreUsageStr = `Usage:
+  kbfstool restore (-rev revision | -snapshot name) /keybase/[public|private]/user1,assertion2/path/to/file
+
+Rewrites the file to its contents as of the given revision or named
+snapshot of its folder, recreating it if it's been removed since.
+
+`
+
+func restoreFile(ctx context.Context, config libkbfs.Config,
+	pathStr string, rev libkbfs.MetadataRevision, snapshotName string) error {
+	p, err := fsrpc.NewPath(pathStr)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) == 0 {
+		return fmt.Errorf("%q is not a file in a TLF", pathStr)
+	}
+	dir, name, err := p.DirAndBasename()
+	if err != nil {
+		return err
+	}
+	dirNode, err := dir.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	if snapshotName != "" {
+		rev, err = getSnapshotRevision(
+			ctx, config, dirNode.GetFolderBranch(), snapshotName)
+		if err != nil {
+			return err
+		}
+	}
+
+	fileNode, err := config.KBFSOps().RestoreFile(ctx, dirNode, name, rev)
+	if err != nil {
+		return err
+	}
+	ei, err := config.KBFSOps().Stat(ctx, fileNode)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s to revision %d (%d bytes)\n", p, rev, ei.Size)
+	return nil
+}
+
+func restore(ctx context.Context, config libkbfs.Config, args []string) (
+	exitStatus int) {
+	flags := flag.NewFlagSet("kbfs restore", flag.ContinueOnError)
+	rev := flags.Int64("rev", int64(libkbfs.MetadataRevisionUninitialized),
+		"The revision to restore the file to.")
+	snapshotName := flags.String("snapshot", "",
+		"The named snapshot to restore the file to.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("restore", err)
+		return 1
+	}
+
+	haveRev := libkbfs.MetadataRevision(*rev) !=
+		libkbfs.MetadataRevisionUninitialized
+	if len(flags.Args()) != 1 || haveRev == (*snapshotName != "") {
+		fmt.Print(restoreUsageStr)
+		return 1
+	}
+
+	err = restoreFile(ctx, config, flags.Arg(0),
+		libkbfs.MetadataRevision(*rev), *snapshotName)
+	if err != nil {
+		printError("restore", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func retentionOne(ctx context.Context, config libkbfs.Config,
+	tlfPath string, setStr string) error {
+	p, err := fsrpc.NewPath(tlfPath)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) > 0 {
+		return fmt.Errorf("%q is not the root path of a TLF", tlfPath)
+	}
+
+	n, _, err := p.GetNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	fb := n.GetFolderBranch()
+
+	if setStr != "" {
+		retention, err := libkbfs.ParseHistoryRetention(setStr)
+		if err != nil {
+			return err
+		}
+		err = config.KBFSOps().SetHistoryRetention(ctx, fb, retention)
+		if err != nil {
+			return err
+		}
+	}
+
+	retention, err := config.KBFSOps().GetHistoryRetention(ctx, fb)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: history retention %s\n", tlfPath, retention)
+	return nil
+}
+
+const retentionUsageStr = `Usage:
+  kbfstool retention [-set all|latest|default|<days>d] /keybase/[public|private]/user1,assertion2
+
+`
+
+func retention(ctx context.Context, config libkbfs.Config, args []string) (
+	exitStatus int) {
+	flags := flag.NewFlagSet("kbfs retention", flag.ContinueOnError)
+	setStr := flags.String("set", "",
+		"Change how long history that's no longer referenced is kept: "+
+			"all of it, only the latest revision, the default, or a "+
+			"number of days.  Only writers may change it.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("retention", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 1 {
+		fmt.Print(retentionUsageStr)
+		return 1
+	}
+
+	err = retentionOne(ctx, config, inputs[0], *setStr)
+	if err != nil {
+		printError("retention", err)
+		return 1
+	}
+
+	return 0
+}
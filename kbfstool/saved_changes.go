@@ -0,0 +1,83 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func savedChangesList(config libkbfs.Config) error {
+	states, err := libkbfs.ListCRSavedStates(config.StorageRoot())
+	if err != nil {
+		return err
+	}
+	if len(states) == 0 {
+		fmt.Printf("No local changes have been saved\n")
+		return nil
+	}
+	for _, state := range states {
+		fmt.Printf("%s: revision %d of %s (branch %s, from revision %d), "+
+			"saved at %s\n", state.ID, state.Revision, state.TlfID,
+			state.BranchID, state.BranchPoint, state.SavedAt)
+		dir := libkbfs.CRSavedStatePath(config.StorageRoot(), state.ID)
+		for _, f := range state.Files {
+			switch {
+			case f.Type == libkbfs.Sym:
+				fmt.Printf("  %s -> %s\n", f.Path, f.SymPath)
+			case f.Skipped:
+				fmt.Printf("  %s (%d bytes): not saved, too big\n",
+					f.Path, f.Size)
+			default:
+				fmt.Printf("  %s (%d bytes): %s\n", f.Path, f.Size,
+					filepath.Join(dir, filepath.FromSlash(f.Path)))
+			}
+		}
+	}
+	return nil
+}
+
+const savedChangesUsageStr = `Usage:
+  kbfstool saved-changes list
+  kbfstool saved-changes delete <id>
+
+`
+
+func savedChanges(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	if len(args) < 1 {
+		fmt.Print(savedChangesUsageStr)
+		return 1
+	}
+
+	cmd := args[0]
+	flags := flag.NewFlagSet("kbfs saved-changes "+cmd, flag.ContinueOnError)
+	err := flags.Parse(args[1:])
+	if err != nil {
+		printError("saved-changes", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	switch {
+	case cmd == "list" && len(inputs) == 0:
+		err = savedChangesList(config)
+	case cmd == "delete" && len(inputs) == 1:
+		err = libkbfs.DeleteCRSavedState(config.StorageRoot(), inputs[0])
+	default:
+		fmt.Print(savedChangesUsageStr)
+		return 1
+	}
+	if err != nil {
+		printError("saved-changes", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+const settingsUsageStr = `Usage:
+  kbfstool settings list
+  kbfstool settings get <name>
+  kbfstool settings set <name> <value>
+  kbfstool settings reset <name>
+
+Lists or changes the settings of the KBFS running with the same
+storage root, without restarting it.  Changed settings are saved, and
+applied on top of the flags whenever KBFS starts; reset goes back to
+the value from the flags or the defaults.
+
+`
+
+func printSetting(s libkbfs.Setting) {
+	fmt.Printf("%s = %s", s.Name, s.Value)
+	if s.Persisted {
+		fmt.Print(" (saved)")
+	}
+	fmt.Print("\n")
+}
+
+// settings runs before libkbfs.Init, since it talks to the KBFS
+// that's already running, rather than starting another one.
+func settings(storageRoot string, args []string) (exitStatus int) {
+	if len(args) < 1 {
+		fmt.Print(settingsUsageStr)
+		return 1
+	}
+
+	cmd := args[0]
+	args = args[1:]
+
+	var s libkbfs.Setting
+	var err error
+	switch {
+	case cmd == "list" && len(args) == 0:
+		list, err := libkbfs.ListSettings(storageRoot)
+		if err != nil {
+			printError("settings", err)
+			return 1
+		}
+		for _, s := range list {
+			printSetting(s)
+			fmt.Printf("    %s\n", s.Doc)
+		}
+		return 0
+	case cmd == "get" && len(args) == 1:
+		s, err = libkbfs.GetSetting(storageRoot, args[0])
+	case cmd == "set" && len(args) == 2:
+		s, err = libkbfs.SetSetting(storageRoot, args[0], args[1])
+	case cmd == "reset" && len(args) == 1:
+		s, err = libkbfs.ResetSetting(storageRoot, args[0])
+	default:
+		fmt.Print(settingsUsageStr)
+		return 1
+	}
+	if err != nil {
+		printError("settings", err)
+		return 1
+	}
+	printSetting(s)
+	return 0
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// parseByteSize parses a byte count with an optional K, M or G
+// suffix, in powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%d is negative", n)
+	}
+	return n * multiplier, nil
+}
+
+const shrinkCacheUsageStr = `Usage:
+  kbfstool shrink-cache [-q] <size>
+
+Evicts blocks from the disk block cache, least recently used first,
+until it takes up at most <size> bytes.  <size> may end in K, M or G,
+and 0 empties the cache.  KBFS must not be running, since it keeps
+the cache open.
+
+`
+
+func shrinkCache(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs shrink-cache", flag.ContinueOnError)
+	quiet := flags.Bool("q", false, "Don't print progress.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("shrink-cache", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 1 {
+		fmt.Print(shrinkCacheUsageStr)
+		return 1
+	}
+	targetBytes, err := parseByteSize(inputs[0])
+	if err != nil {
+		printError("shrink-cache", err)
+		return 1
+	}
+
+	var progressFn func(libkbfs.DiskBlockCacheShrinkProgress)
+	if !*quiet {
+		progressFn = func(p libkbfs.DiskBlockCacheShrinkProgress) {
+			fmt.Printf("Evicted %d blocks (%d bytes); %d bytes left\n",
+				p.BlocksEvicted, p.BytesEvicted, p.CurrentBytes)
+		}
+	}
+	progress, err := libkbfs.ShrinkDiskBlockCache(
+		ctx, config, targetBytes, progressFn)
+	if err != nil {
+		printError("shrink-cache", err)
+		return 1
+	}
+
+	fmt.Printf("Shrank the disk block cache from %d to %d bytes, "+
+		"evicting %d blocks\n", progress.StartBytes, progress.CurrentBytes,
+		progress.BlocksEvicted)
+	return 0
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// getSnapshotFolderBranch returns the folder-branch of the TLF that
+// the given path is in, and the components of the path within it.
+func getSnapshotFolderBranch(ctx context.Context, config libkbfs.Config,
+	pathStr string) (libkbfs.FolderBranch, []string, error) {
+	p, err := fsrpc.NewPath(pathStr)
+	if err != nil {
+		return libkbfs.FolderBranch{}, nil, err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return libkbfs.FolderBranch{}, nil,
+			fmt.Errorf("%q is not in a TLF", pathStr)
+	}
+	components := p.TLFComponents
+	p.TLFComponents = nil
+	n, _, err := p.GetNode(ctx, config)
+	if err != nil {
+		return libkbfs.FolderBranch{}, nil, err
+	}
+	return n.GetFolderBranch(), components, nil
+}
+
+// getSnapshotRevision returns the revision of the named snapshot of
+// the given folder.
+func getSnapshotRevision(ctx context.Context, config libkbfs.Config,
+	fb libkbfs.FolderBranch, name string) (libkbfs.MetadataRevision, error) {
+	snaps, err := config.KBFSOps().GetSnapshots(ctx, fb)
+	if err != nil {
+		return libkbfs.MetadataRevisionUninitialized, err
+	}
+	for _, snap := range snaps {
+		if snap.Name == name {
+			return snap.Revision, nil
+		}
+	}
+	return libkbfs.MetadataRevisionUninitialized,
+		libkbfs.NoSuchSnapshotError{Name: name}
+}
+
+func snapshotList(ctx context.Context, config libkbfs.Config,
+	tlfPath string) error {
+	fb, _, err := getSnapshotFolderBranch(ctx, config, tlfPath)
+	if err != nil {
+		return err
+	}
+	snaps, err := config.KBFSOps().GetSnapshots(ctx, fb)
+	if err != nil {
+		return err
+	}
+	for _, snap := range snaps {
+		fmt.Printf("%s\t%d\t%s\n", snap.Name, snap.Revision,
+			time.Unix(0, snap.Ctime).Format(time.RFC3339))
+	}
+	return nil
+}
+
+func snapshotLs(ctx context.Context, config libkbfs.Config,
+	name, dirPath string) error {
+	fb, components, err := getSnapshotFolderBranch(ctx, config, dirPath)
+	if err != nil {
+		return err
+	}
+	rev, err := getSnapshotRevision(ctx, config, fb, name)
+	if err != nil {
+		return err
+	}
+
+	children, err := libkbfs.GetDirChildrenAtRevision(
+		ctx, config, fb.Tlf, rev, components)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ei := children[name]
+		switch ei.Type {
+		case libkbfs.Dir:
+			name += "/"
+		case libkbfs.Sym:
+			name += " -> " + ei.SymPath
+		}
+		fmt.Printf("%s\t%d\t%s\n", ei.Type, ei.Size, name)
+	}
+	return nil
+}
+
+const snapshotUsageStr = `Usage:
+  kbfstool snapshot list /keybase/[public|private]/user1,assertion2
+  kbfstool snapshot create [-rev revision] /keybase/[public|private]/user1,assertion2 <name>
+  kbfstool snapshot delete /keybase/[public|private]/user1,assertion2 <name>
+  kbfstool snapshot ls <name> /keybase/[public|private]/user1,assertion2[/path/to/dir]
+
+`
+
+func snapshot(ctx context.Context, config libkbfs.Config, args []string) (
+	exitStatus int) {
+	if len(args) < 1 {
+		fmt.Print(snapshotUsageStr)
+		return 1
+	}
+
+	cmd := args[0]
+	flags := flag.NewFlagSet("kbfs snapshot "+cmd, flag.ContinueOnError)
+	rev := flags.Int64("rev", int64(libkbfs.MetadataRevisionUninitialized),
+		"The revision to snapshot; defaults to the current head.")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		printError("snapshot", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	switch {
+	case cmd == "list" && len(inputs) == 1:
+		err = snapshotList(ctx, config, inputs[0])
+	case cmd == "create" && len(inputs) == 2:
+		var fb libkbfs.FolderBranch
+		fb, _, err = getSnapshotFolderBranch(ctx, config, inputs[0])
+		if err == nil {
+			err = config.KBFSOps().CreateSnapshot(
+				ctx, fb, inputs[1], libkbfs.MetadataRevision(*rev))
+		}
+	case cmd == "delete" && len(inputs) == 2:
+		var fb libkbfs.FolderBranch
+		fb, _, err = getSnapshotFolderBranch(ctx, config, inputs[0])
+		if err == nil {
+			err = config.KBFSOps().DeleteSnapshot(ctx, fb, inputs[1])
+		}
+	case cmd == "ls" && len(inputs) == 2:
+		err = snapshotLs(ctx, config, inputs[0], inputs[1])
+	default:
+		fmt.Print(snapshotUsageStr)
+		return 1
+	}
+	if err != nil {
+		printError("snapshot", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// AbortOpsFile represents a write-only file where any write of at
+// least one byte aborts all the requests in flight on the folder,
+// such as a directory listing stuck waiting on the server.
+type AbortOpsFile struct {
+	folder *Folder
+	specialWriteFile
+}
+
+// WriteFile implements writes for dokan.
+func (f *AbortOpsFile) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.folder.fs.logEnter(ctx, "AbortOpsFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+	folderBranch := f.folder.getFolderBranch()
+	if folderBranch == (libkbfs.FolderBranch{}) {
+		// Nothing to do.
+		return len(bs), nil
+	}
+
+	aborted := f.folder.fs.ops.AbortTLF(folderBranch.Tlf)
+	f.folder.fs.log.CDebugf(ctx, "Aborted %d ops on %s",
+		aborted, folderBranch.Tlf)
+	return len(bs), nil
+}
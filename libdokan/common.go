@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
 )
 
@@ -31,29 +32,33 @@ const (
 	CtxIDKey CtxTagKey = iota
 )
 
-// eiToStat converts from a libkbfs.EntryInfo and error to a *dokan.Stat and error.
+// eiToStat converts from a libkbfs.EntryInfo and error to a *dokan.Stat and error,
+// reporting times as the policy decides, given the in-memory access time atime.
 // Note that handling symlinks to directories requires extra processing not done here.
-func eiToStat(ei libkbfs.EntryInfo, err error) (*dokan.Stat, error) {
+func eiToStat(ei libkbfs.EntryInfo, err error,
+	policy libfs.TimePolicy, atime time.Time) (*dokan.Stat, error) {
 	if err != nil {
 		return nil, errToDokan(err)
 	}
 	st := &dokan.Stat{}
-	fillStat(st, &ei)
+	fillStat(st, &ei, policy, atime)
 	return st, nil
 }
 
 // fillStat fill a dokan.Stat from a libkbfs.DirEntry.
 // Note that handling symlinks to directories requires extra processing not done here.
-func fillStat(a *dokan.Stat, de *libkbfs.EntryInfo) {
+func fillStat(a *dokan.Stat, de *libkbfs.EntryInfo,
+	policy libfs.TimePolicy, atime time.Time) {
 	a.FileSize = int64(de.Size)
-	a.LastWrite = time.Unix(0, de.Mtime)
-	a.LastAccess = a.LastWrite
-	a.Creation = time.Unix(0, de.Ctime)
+	a.LastWrite, a.Creation, a.LastAccess = policy.Times(
+		*de, atime, time.Now())
+	// Dokan reports zero links as one.
+	a.NumberOfLinks = de.Nlink
 	switch de.Type {
 	case libkbfs.File, libkbfs.Exec:
-		a.FileAttributes = dokan.FileAttributeNormal
+		a.FileAttributes = fileAttributes
 	case libkbfs.Dir:
-		a.FileAttributes = dokan.FileAttributeDirectory
+		a.FileAttributes = dirAttributes
 	case libkbfs.Sym:
 		a.FileAttributes = dokan.FileAttributeReparsePoint
 		a.ReparsePointTag = dokan.IOReparseTagSymlink
@@ -69,23 +74,39 @@ func errToDokan(err error) error {
 		return dokan.ErrObjectNameNotFound
 	case libkbfs.MDServerErrorUnauthorized:
 		return dokan.ErrAccessDenied
+	case libkbfs.TlfFrozenError:
+		return dokan.ErrMediaWriteProtected
+	case libkbfs.TlfAppendOnlyError:
+		return dokan.ErrAccessDenied
+	case libkbfs.DeviceRevokedError:
+		return dokan.ErrAccessDenied
 	case nil:
 		return nil
 	}
 	return err
 }
 
+// Everything on the mount is marked as not to be content-indexed, so
+// that Windows Search doesn't read every file and download all its
+// blocks.  FileAttributeNormal is only valid on its own, so files get
+// just the not-indexed attribute instead.
+const (
+	fileAttributes = dokan.FileAttributeNotContentIndexed
+	dirAttributes  = dokan.FileAttributeDirectory |
+		dokan.FileAttributeNotContentIndexed
+)
+
 // defaultDirectoryInformation returns default directory information.
 func defaultDirectoryInformation() (*dokan.Stat, error) {
 	var st dokan.Stat
-	st.FileAttributes = dokan.FileAttributeDirectory
+	st.FileAttributes = dirAttributes
 	return &st, nil
 }
 
 // defaultFileInformation returns default file information.
 func defaultFileInformation() (*dokan.Stat, error) {
 	var st dokan.Stat
-	st.FileAttributes = dokan.FileAttributeNormal
+	st.FileAttributes = fileAttributes
 	return &st, nil
 }
 
@@ -106,6 +106,13 @@ func (f *Folder) getFolderBranch() libkbfs.FolderBranch {
 	return f.folderBranch
 }
 
+// beginOp registers an op on this folder with the FS's op registry,
+// so that it can be aborted through AbortOpsFileName or by a forced
+// unmount. The returned function must be called when the op is done.
+func (f *Folder) beginOp(ctx context.Context) (context.Context, func()) {
+	return f.fs.ops.Begin(ctx, f.getFolderBranch().Tlf)
+}
+
 // forgetNode forgets a formerly active child with basename name.
 func (f *Folder) forgetNode(ctx context.Context, node libkbfs.Node) {
 	f.mu.Lock()
@@ -234,8 +241,11 @@ func newDir(folder *Folder, node libkbfs.Node, name string, parent libkbfs.Node)
 func (d *Dir) GetFileInformation(ctx context.Context, fi *dokan.FileInfo) (st *dokan.Stat, err error) {
 	d.folder.fs.logEnter(ctx, "Dir GetFileInformation")
 	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
-	return eiToStat(d.folder.fs.config.KBFSOps().Stat(ctx, d.node))
+	ei, err := d.folder.fs.config.KBFSOps().Stat(ctx, d.node)
+	return eiToStat(ei, err, d.folder.fs.timePolicy, time.Time{})
 }
 
 // SetFileAttributes for Dokan.
@@ -260,8 +270,24 @@ func lastStr(strs []string) string {
 }
 
 // open tries to open a file.
+// resolveName returns the KBFS name of the entry in d that Windows
+// means by the given name.  If there is no such entry, it returns the
+// name a new entry should get.
+func (d *Dir) resolveName(ctx context.Context, name string) (string, error) {
+	children, err := d.folder.fs.config.KBFSOps().GetDirChildren(ctx, d.node)
+	if err != nil {
+		return "", err
+	}
+	if kbfsName, ok := d.folder.fs.nameConflictView(children).Resolve(name); ok {
+		return kbfsName, nil
+	}
+	return unescapeWindowsName(name), nil
+}
+
 func (d *Dir) open(ctx context.Context, oc *openContext, path []string) (dokan.File, bool, error) {
 	d.folder.fs.log.CDebugf(ctx, "Dir openDir %v", path)
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	specialNode := handleTLFSpecialFile(lastStr(path), d.folder)
 	if specialNode != nil {
@@ -288,6 +314,12 @@ func (d *Dir) open(ctx context.Context, oc *openContext, path []string) (dokan.F
 			path[0] = hit
 		}
 
+		name, err := d.resolveName(ctx, path[0])
+		if err != nil {
+			return nil, false, err
+		}
+		path[0] = name
+
 		leaf := len(path) == 1
 
 		// Check if this is a per-file metainformation file, if so
@@ -307,6 +339,19 @@ func (d *Dir) open(ctx context.Context, oc *openContext, path []string) (dokan.F
 			return &SpecialReadFile{read: fileInfo(nmd).read, fs: d.folder.fs}, false, nil
 		}
 
+		// Check if this is a file for removing a directory tree.
+		if leaf && strings.HasPrefix(path[0], libfs.RemoveTreePrefix) {
+			if err := oc.ReturningFileAllowed(); err != nil {
+				return nil, false, err
+			}
+			name := path[0][len(libfs.RemoveTreePrefix):]
+			_, _, err := d.folder.fs.config.KBFSOps().Lookup(ctx, d.node, name)
+			if err != nil {
+				return nil, false, err
+			}
+			return &RemoveTreeFile{dir: d, name: name}, false, nil
+		}
+
 		newNode, de, err := d.folder.fs.config.KBFSOps().Lookup(ctx, d.node, path[0])
 
 		// If we are in the final component, check if it is a creation.
@@ -396,6 +441,7 @@ func openFile(ctx context.Context, oc *openContext, path []string, f *File) (dok
 	if err != nil {
 		return nil, false, err
 	}
+	f.open(oc)
 	return f, false, nil
 }
 
@@ -436,6 +482,8 @@ func getExclFromOpenContext(oc *openContext) libkbfs.Excl {
 func (d *Dir) create(ctx context.Context, oc *openContext, name string) (f dokan.File, isDir bool, err error) {
 	d.folder.fs.log.CDebugf(ctx, "Dir Create %s", name)
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	isExec := false // Windows lacks executable modes.
 	excl := getExclFromOpenContext(oc)
@@ -447,12 +495,15 @@ func (d *Dir) create(ctx context.Context, oc *openContext, name string) (f dokan
 
 	child := newFile(d.folder, newNode, name, d.node)
 	d.folder.lockedAddNode(newNode, child)
+	child.open(oc)
 	return child, false, nil
 }
 
 func (d *Dir) mkdir(ctx context.Context, oc *openContext, name string) (f *Dir, isDir bool, err error) {
 	d.folder.fs.log.CDebugf(ctx, "Dir Mkdir %s", name)
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	newNode, _, err := d.folder.fs.config.KBFSOps().CreateDir(
 		ctx, d.node, name)
@@ -469,6 +520,8 @@ func (d *Dir) mkdir(ctx context.Context, oc *openContext, name string) (f *Dir,
 func (d *Dir) FindFiles(ctx context.Context, fi *dokan.FileInfo, ignored string, callback func(*dokan.NamedStat) error) (err error) {
 	d.folder.fs.logEnter(ctx, "Dir FindFiles")
 	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	children, err := d.folder.fs.config.KBFSOps().GetDirChildren(ctx, d.node)
 	if err != nil {
@@ -477,11 +530,12 @@ func (d *Dir) FindFiles(ctx context.Context, fi *dokan.FileInfo, ignored string,
 
 	empty := true
 	var ns dokan.NamedStat
+	view := d.folder.fs.nameConflictView(children)
 	for name, de := range children {
 		empty = false
-		ns.Name = name
+		ns.Name = view.DisplayName(name)
 		// TODO perhaps resolve symlinks here?
-		fillStat(&ns.Stat, &de)
+		fillStat(&ns.Stat, &de, d.folder.fs.timePolicy, time.Time{})
 		err = callback(&ns)
 		if err != nil {
 			return err
@@ -498,6 +552,8 @@ func (d *Dir) FindFiles(ctx context.Context, fi *dokan.FileInfo, ignored string,
 func (d *Dir) CanDeleteDirectory(ctx context.Context, fi *dokan.FileInfo) (err error) {
 	d.folder.fs.logEnterf(ctx, "Dir CanDeleteDirectory %q", d.name)
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	children, err := d.folder.fs.config.KBFSOps().GetDirChildren(ctx, d.node)
 	if err != nil {
@@ -522,6 +578,8 @@ func (d *Dir) Cleanup(ctx context.Context, fi *dokan.FileInfo) {
 		d.folder.fs.logEnterf(ctx, "Dir Cleanup %q", d.name)
 	}
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	if fi != nil && fi.IsDeleteOnClose() && d.parent != nil {
 		// renameAndDeletionLock should be the first lock to be grabbed in libdokan.
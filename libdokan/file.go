@@ -5,7 +5,10 @@
 package libdokan
 
 import (
+	"time"
+
 	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
 	"golang.org/x/net/context"
 )
@@ -13,10 +16,15 @@ import (
 // File represents KBFS files.
 type File struct {
 	FSO
+	// handles tracks the handles open on this file, which are all
+	// the file itself.
+	handles libfs.NodeHandles
+
+	atime libfs.AccessTime
 }
 
 func newFile(folder *Folder, node libkbfs.Node, name string, parent libkbfs.Node) *File {
-	f := &File{FSO{
+	f := &File{FSO: FSO{
 		name:   name,
 		parent: parent,
 		folder: folder,
@@ -26,12 +34,35 @@ func newFile(folder *Folder, node libkbfs.Node, name string, parent libkbfs.Node
 	return f
 }
 
+// open registers a new handle on f with the FS.
+func (f *File) open(oc *openContext) {
+	f.handles.Open(f.folder.fs.handles, libfs.OpenHandle{
+		TLF:    f.folder.getFolderBranch().Tlf,
+		Folder: string(f.folder.name()),
+		Name:   f.name,
+		PID:    oc.processID(),
+		Write:  oc.isWriteAccess(),
+		Sync:   oc.isWriteThrough(),
+	})
+}
+
+// checkOpen returns an error if a handle on f has been force-closed.
+func (f *File) checkOpen() error {
+	if f.handles.Closed() {
+		return dokan.ErrAccessDenied
+	}
+	return nil
+}
+
 // GetFileInformation for dokan.
 func (f *File) GetFileInformation(ctx context.Context, fi *dokan.FileInfo) (a *dokan.Stat, err error) {
 	f.folder.fs.logEnter(ctx, "File GetFileInformation")
 	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
 
-	a, err = eiToStat(f.folder.fs.config.KBFSOps().Stat(ctx, f.node))
+	ei, err := f.folder.fs.config.KBFSOps().Stat(ctx, f.node)
+	a, err = eiToStat(ei, err, f.folder.fs.timePolicy, f.atime.Get())
 	if a != nil {
 		f.folder.fs.log.CDebugf(ctx, "File GetFileInformation node=%v => %v", f.node, *a)
 	} else {
@@ -54,8 +85,11 @@ func (f *File) Cleanup(ctx context.Context, fi *dokan.FileInfo) {
 	var err error
 	f.folder.fs.logEnter(ctx, "File Cleanup")
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
 
-	f.folder.fs.log.CDebugf(ctx, "Cleanup %v", *f)
+	f.folder.fs.log.CDebugf(ctx, "Cleanup %v", f.FSO)
+	f.handles.Release(f.folder.fs.handles)
 	if fi != nil && fi.IsDeleteOnClose() {
 		// renameAndDeletionLock should be the first lock to be grabbed in libdokan.
 		f.folder.fs.renameAndDeletionLock.Lock()
@@ -77,7 +111,14 @@ func (f *File) Cleanup(ctx context.Context, fi *dokan.FileInfo) {
 func (f *File) FlushFileBuffers(ctx context.Context, fi *dokan.FileInfo) (err error) {
 	f.folder.fs.logEnter(ctx, "File FlushFileBuffers")
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
+	if err := f.checkOpen(); err != nil {
+		return err
+	}
 
+	ctx = libkbfs.NewContextWithFsyncDurability(
+		ctx, f.handles.FsyncDurability())
 	return f.folder.fs.config.KBFSOps().Sync(ctx, f.node)
 }
 
@@ -85,18 +126,50 @@ func (f *File) FlushFileBuffers(ctx context.Context, fi *dokan.FileInfo) (err er
 func (f *File) ReadFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
 	f.folder.fs.logEnter(ctx, "ReadFile")
 	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
+	if err := f.checkOpen(); err != nil {
+		return 0, err
+	}
 
 	var nlarge int64
 	nlarge, err = f.folder.fs.config.KBFSOps().Read(ctx, f.node, bs, offset)
+	if err == nil {
+		f.updateAtime(ctx)
+	}
 
 	// This is safe since length of slices always fits into an int
 	return int(nlarge), err
 }
 
+// updateAtime updates the in-memory access time of f after a read,
+// as the FS's time policy decides.
+func (f *File) updateAtime(ctx context.Context) {
+	policy := f.folder.fs.timePolicy
+	if policy.Atime == libfs.NoAtime {
+		return
+	}
+	ei, err := f.folder.fs.config.KBFSOps().Stat(ctx, f.node)
+	if err != nil {
+		// The read itself succeeded, so just leave the
+		// access time alone.
+		f.folder.fs.log.CDebugf(ctx, "Couldn't stat for atime: %+v", err)
+		return
+	}
+	now := time.Now()
+	mtime, ctime, _ := policy.Times(ei, time.Time{}, now)
+	f.atime.OnRead(policy, mtime, ctime, now)
+}
+
 // WriteFile for dokan writes.
 func (f *File) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
 	f.folder.fs.logEnter(ctx, "WriteFile")
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
+	if err := f.checkOpen(); err != nil {
+		return 0, err
+	}
 
 	if offset == -1 {
 		ei, err := f.folder.fs.config.KBFSOps().Stat(ctx, f.node)
@@ -114,6 +187,11 @@ func (f *File) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, off
 func (f *File) SetEndOfFile(ctx context.Context, fi *dokan.FileInfo, length int64) (err error) {
 	f.folder.fs.logEnter(ctx, "File SetEndOfFile")
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
+	if err := f.checkOpen(); err != nil {
+		return err
+	}
 
 	return f.folder.fs.config.KBFSOps().Truncate(ctx, f.node, uint64(length))
 }
@@ -124,6 +202,8 @@ func (f *File) SetEndOfFile(ctx context.Context, fi *dokan.FileInfo, length int6
 func (f *File) SetAllocationSize(ctx context.Context, fi *dokan.FileInfo, newSize int64) (err error) {
 	f.folder.fs.logEnter(ctx, "File SetAllocationSize")
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
 
 	ei, err := f.folder.fs.config.KBFSOps().Stat(ctx, f.node)
 	if err != nil {
@@ -195,7 +195,7 @@ func (fl *FolderList) FindFiles(ctx context.Context, fi *dokan.FileInfo, ignored
 		}
 	}
 	var ns dokan.NamedStat
-	ns.FileAttributes = dokan.FileAttributeDirectory
+	ns.FileAttributes = dirAttributes
 	empty := true
 	for _, fav := range favs {
 		if fav.Public != fl.public {
@@ -266,7 +266,20 @@ func clearFolderListCacheLoop(ctx context.Context, r *Root) {
 }
 
 // update things after user changed.
-func (fl *FolderList) userChanged(ctx context.Context, _, newUser libkb.NormalizedUsername) {
+func (fl *FolderList) userChanged(ctx context.Context, oldUser, newUser libkb.NormalizedUsername) {
+	if !fl.public && oldUser != "" && oldUser != newUser {
+		// The previous user's private folders must not stay
+		// reachable through nodes opened on their behalf, and
+		// libkbfs shuts them down anyway once someone else logs in.
+		fl.fs.log.CDebugf(ctx, "Forgetting the private folders of %s", oldUser)
+		func() {
+			fl.mu.Lock()
+			defer fl.mu.Unlock()
+			fl.folders = make(map[string]fileOpener)
+		}()
+		fl.clearAliasCache()
+		return
+	}
 	var fs []*Folder
 	func() {
 		fl.mu.Lock()
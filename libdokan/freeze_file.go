@@ -0,0 +1,40 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// FreezeFile represents a write-only file where any write of at
+// least one byte either freezes the folder (marks it read-only for
+// all devices) or unfreezes it.
+type FreezeFile struct {
+	folder *Folder
+	freeze bool
+	specialWriteFile
+}
+
+// WriteFile implements writes for dokan.
+func (f *FreezeFile) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.folder.fs.logEnter(ctx, "FreezeFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+	err = f.folder.fs.config.KBFSOps().SetTlfFrozen(
+		ctx, f.folder.getFolderBranch(), f.freeze)
+	if err != nil {
+		return 0, err
+	}
+	if f.freeze {
+		// Files open for writing can't be written anymore.
+		f.folder.fs.handles.ForceCloseTLF(
+			f.folder.getFolderBranch().Tlf, true)
+	}
+	return len(bs), nil
+}
@@ -35,6 +35,25 @@ type FS struct {
 
 	// remoteStatus is the current status of remote connections.
 	remoteStatus libfs.RemoteStatus
+
+	// nameConflicts decides which entry names Windows can't tell
+	// apart.
+	nameConflicts libfs.NameConflictPolicy
+
+	// processPolicy, if non-nil, decides which processes may use
+	// the mount.
+	processPolicy *libfs.ProcessPolicy
+
+	// ops tracks the requests in flight on each TLF, so that they
+	// can be aborted.
+	ops *libfs.OpRegistry
+
+	// handles tracks the open files, so that they can be listed
+	// and force-closed.
+	handles *libfs.OpenHandleRegistry
+
+	// timePolicy decides how file times are reported.
+	timePolicy libfs.TimePolicy
 }
 
 // DefaultMountFlags are the default mount flags for libdokan.
@@ -54,6 +73,9 @@ func NewFS(ctx context.Context, config libkbfs.Config, log logger.Logger) (*FS,
 		config:        config,
 		log:           log,
 		notifications: libfs.NewFSNotifications(log),
+		nameConflicts: libfs.CaseInsensitiveNames,
+		ops:           libfs.NewOpRegistry(),
+		handles:       libfs.NewOpenHandleRegistry(),
 	}
 
 	f.root = &Root{
@@ -78,6 +100,16 @@ func NewFS(ctx context.Context, config libkbfs.Config, log logger.Logger) (*FS,
 	return f, nil
 }
 
+func (f *FS) nameConflictView(
+	children map[string]libkbfs.EntryInfo) libfs.NameConflictView {
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	return libfs.MakeEscapedNameConflictView(
+		f.nameConflicts, escapeWindowsName, names)
+}
+
 // Adds log tags etc
 func wrapContext(ctx context.Context, f *FS) context.Context {
 	ctx = context.WithValue(ctx, libfs.CtxAppIDKey, f)
@@ -185,6 +217,14 @@ func (oc *openContext) isCreateDirectory() bool {
 
 const fileDirectoryFile = 1
 
+// isWriteThrough checks whether the file is opened for writes that
+// must reach stable storage before they complete.
+func (oc *openContext) isWriteThrough() bool {
+	return oc.CreateOptions&fileWriteThrough != 0
+}
+
+const fileWriteThrough = 2
+
 // isCreation checks the flags whether a file creation is wanted.
 func (oc *openContext) isCreation() bool {
 	switch oc.CreateDisposition {
@@ -237,6 +277,29 @@ func (oc *openContext) mayNotBeDirectory() bool {
 	return oc.CreateOptions&dokan.FileNonDirectoryFile != 0
 }
 
+// Access mask bits that allow writing to a file.
+const (
+	fileWriteData  = 0x2
+	fileAppendData = 0x4
+	genericAll     = 0x10000000
+	genericWrite   = 0x40000000
+)
+
+// isWriteAccess checks the desired access whether writing is wanted.
+func (oc *openContext) isWriteAccess() bool {
+	return oc.DesiredAccess&
+		(fileWriteData|fileAppendData|genericAll|genericWrite) != 0
+}
+
+// processID returns the process doing the open, or 0 for synthetic
+// opens.
+func (oc *openContext) processID() uint32 {
+	if oc.fi == nil {
+		return 0
+	}
+	return oc.fi.ProcessID()
+}
+
 func newSyntheticOpenContext() *openContext {
 	var oc openContext
 	oc.CreateData = &dokan.CreateData{}
@@ -252,6 +315,11 @@ func (f *FS) CreateFile(ctx context.Context, fi *dokan.FileInfo, cd *dokan.Creat
 		f.log.Errorf("Refusing access: SID match error")
 		return nil, false, dokan.ErrAccessDenied
 	}
+	// Every open goes through here, so this is the one place
+	// to check the process.
+	if !f.processPolicy.Allowed(ctx, fi.ProcessID(), "open") {
+		return nil, false, dokan.ErrAccessDenied
+	}
 	f.logEnter(ctx, "FS CreateFile")
 	return f.openRaw(ctx, fi, cd)
 }
@@ -315,6 +383,10 @@ func (f *FS) open(ctx context.Context, oc *openContext, ps []string) (dokan.File
 			folder: &Folder{fs: f}, // fake Folder for logging, etc.
 			action: libfs.JournalDisableAuto,
 		})
+	case libfs.ProcessDenialsFileName == ps[0]:
+		return oc.returnFileNoCleanup(&SpecialReadFile{
+			read: libfs.GetEncodedProcessDenials(f.processPolicy),
+			fs:   f})
 	case libfs.EnableBlockPrefetchingFileName == ps[0]:
 		return oc.returnFileNoCleanup(&PrefetchFile{
 			fs:     f,
@@ -480,7 +552,14 @@ func (f *FS) MoveFile(ctx context.Context, source *dokan.FileInfo, targetPath st
 	// overwritten node, if any, will be removed from Folder.nodes, if
 	// it is there in the first place, by its Forget
 
-	dstName := dstPath[len(dstPath)-1]
+	srcName, err = srcDirD.resolveName(ctx, srcName)
+	if err != nil {
+		return err
+	}
+	dstName, err := ddst.resolveName(ctx, dstPath[len(dstPath)-1])
+	if err != nil {
+		return err
+	}
 	f.log.CDebugf(ctx, "FS Rename KBFSOps().Rename(ctx,%v,%v,%v,%v)", srcParent, srcName, ddst.node, dstName)
 	if err := srcFolder.fs.config.KBFSOps().Rename(
 		ctx, srcParent, srcName, ddst.node, dstName); err != nil {
@@ -581,6 +660,11 @@ func (f *FS) stringReadFile(contents string) dokan.File {
 // UserChanged is called from libfs.
 func (f *FS) UserChanged(ctx context.Context, oldName, newName libkb.NormalizedUsername) {
 	f.log.CDebugf(ctx, "User changed: %q -> %q", oldName, newName)
+	if oldName != "" {
+		// The old user's files shouldn't stay usable.
+		n := f.handles.ForceCloseAll()
+		f.log.CDebugf(ctx, "Force-closed %d open files", n)
+	}
 	f.root.public.userChanged(ctx, oldName, newName)
 	f.root.private.userChanged(ctx, oldName, newName)
 }
@@ -603,7 +687,7 @@ func (r *Root) GetFileInformation(ctx context.Context, fi *dokan.FileInfo) (*dok
 func (r *Root) FindFiles(ctx context.Context, fi *dokan.FileInfo, ignored string, callback func(*dokan.NamedStat) error) error {
 	var ns dokan.NamedStat
 	var err error
-	ns.FileAttributes = dokan.FileAttributeDirectory
+	ns.FileAttributes = dirAttributes
 	ename, esize := r.private.fs.remoteStatus.ExtraFileNameAndSize()
 	switch ename {
 	case "":
@@ -622,7 +706,7 @@ func (r *Root) FindFiles(ctx context.Context, fi *dokan.FileInfo, ignored string
 	}
 	if ename != "" {
 		ns.Name = ename
-		ns.FileAttributes = dokan.FileAttributeNormal
+		ns.FileAttributes = fileAttributes
 		ns.FileSize = esize
 		err = callback(&ns)
 		if err != nil {
@@ -0,0 +1,44 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"strings"
+
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// FsyncDurabilityFile is a special file used to set what fsync
+// guarantees for files in a TLF.
+type FsyncDurabilityFile struct {
+	specialWriteFile
+	folder *Folder
+}
+
+// WriteFile implements writes for dokan.
+func (f *FsyncDurabilityFile) WriteFile(ctx context.Context,
+	fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.folder.fs.logEnter(ctx, "FsyncDurabilityFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+
+	durability, err := libkbfs.ParseFsyncDurability(
+		strings.TrimSpace(string(bs)))
+	if err != nil {
+		return 0, err
+	}
+
+	err = f.folder.fs.config.KBFSOps().SetFsyncDurability(
+		ctx, f.folder.getFolderBranch(), durability)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(bs), nil
+}
@@ -761,30 +761,18 @@ func TestRenameCrossFolder(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err := ioutil.Rename(p1, p2)
-	if err == nil {
-		t.Fatalf("expected an error from rename: %v", err)
-	}
-	lerr, ok := errors.Cause(err).(*os.LinkError)
-	if !ok {
-		t.Fatalf("expected a LinkError from rename: %v", err)
-	}
-	if g, e := lerr.Op, "rename"; g != e {
-		t.Errorf("wrong LinkError.Op: %q != %q", g, e)
-	}
-	if g, e := lerr.Old, p1; g != e {
-		t.Errorf("wrong LinkError.Old: %q != %q", g, e)
-	}
-	if g, e := lerr.New, p2; g != e {
-		t.Errorf("wrong LinkError.New: %q != %q", g, e)
+	if err := ioutil.Rename(p1, p2); err != nil {
+		t.Fatal(err)
 	}
 
-	checkDir(t, filepath.Join(mnt.Dir, PrivateName, "jdoe"), map[string]fileInfoCheck{
-		"old": nil,
+	checkDir(t, filepath.Join(mnt.Dir, PrivateName, "jdoe"), map[string]fileInfoCheck{})
+	checkDir(t, filepath.Join(mnt.Dir, PrivateName, "wsmith,jdoe"), map[string]fileInfoCheck{
+		"new": func(fi os.FileInfo) error {
+			return mustBeFileWithSize(fi, int64(len(input)))
+		},
 	})
-	checkDir(t, filepath.Join(mnt.Dir, PrivateName, "wsmith,jdoe"), map[string]fileInfoCheck{})
 
-	buf, err := ioutil.ReadFile(p1)
+	buf, err := ioutil.ReadFile(p2)
 	if err != nil {
 		t.Errorf("read error: %v", err)
 	}
@@ -792,8 +780,8 @@ func TestRenameCrossFolder(t *testing.T) {
 		t.Errorf("bad file contents: %q != %q", g, e)
 	}
 
-	if _, err := ioutil.ReadFile(p2); !ioutil.IsNotExist(err) {
-		t.Errorf("new name exists even on error: %v", err)
+	if _, err := ioutil.ReadFile(p1); !ioutil.IsNotExist(err) {
+		t.Errorf("old name still exists: %v", err)
 	}
 }
 
@@ -997,6 +985,40 @@ func TestRemoveDirNotEmpty(t *testing.T) {
 
 }
 
+func TestRemoveDirTree(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	mnt, _, cancelFn := makeFS(t, ctx, config)
+	defer mnt.Close()
+	defer cancelFn()
+
+	p := filepath.Join(mnt.Dir, PrivateName, "jdoe", "mydir")
+	if err := ioutil.Mkdir(p, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.Mkdir(filepath.Join(p, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	pFile := filepath.Join(p, "sub", "myfile")
+	if err := ioutil.WriteFile(pFile, []byte("i'm not important"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	removeTree := filepath.Join(mnt.Dir, PrivateName, "jdoe",
+		libfs.RemoveTreePrefix+"mydir")
+	if err := ioutil.WriteFile(removeTree, []byte("1"), 0222); err != nil {
+		t.Fatal(err)
+	}
+
+	checkDir(t, filepath.Join(mnt.Dir, PrivateName, "jdoe"), map[string]fileInfoCheck{})
+
+	if _, err := ioutil.Stat(p); !ioutil.IsNotExist(err) {
+		t.Errorf("dir still exists: %v", err)
+	}
+}
+
 func TestRemoveFileWhileOpenWriting(t *testing.T) {
 	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
 	defer libkbfs.CleanupCancellationDelayer(ctx)
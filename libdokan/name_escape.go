@@ -0,0 +1,88 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Names that Windows can't show are escaped by replacing the
+// offending characters with private-use characters, at
+// windowsEscapeBase plus the original character.  That's the same
+// mapping Cygwin and WSL use, so such names look the same to tools
+// that know it.
+const windowsEscapeBase = 0xf000
+
+// windowsReservedNames are the device names that Windows won't open
+// as files, whatever their extension.
+var windowsReservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+func isWindowsInvalidRune(r rune) bool {
+	return r < ' ' || strings.ContainsRune(`"*:<>?\|`, r)
+}
+
+func escapeWindowsRune(r rune) rune {
+	return windowsEscapeBase + r
+}
+
+// escapeWindowsName returns a name for the KBFS entry name that
+// Windows accepts: characters Windows doesn't allow in names,
+// trailing dots and spaces, and the last character of a reserved
+// device name (like "con" in "con.txt") are escaped.  Names that are
+// already valid are returned unchanged.
+func escapeWindowsName(name string) string {
+	runes := []rune(name)
+	changed := false
+	for i, r := range runes {
+		if isWindowsInvalidRune(r) {
+			runes[i] = escapeWindowsRune(r)
+			changed = true
+		}
+	}
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] != '.' && runes[i] != ' ' {
+			break
+		}
+		runes[i] = escapeWindowsRune(runes[i])
+		changed = true
+	}
+
+	// Windows ignores trailing spaces on the part of the name
+	// before the first dot when matching device names.
+	base := string(runes)
+	if i := strings.IndexRune(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	trimmed := strings.TrimRight(base, " ")
+	if windowsReservedNames[strings.ToLower(trimmed)] {
+		last := utf8.RuneCountInString(trimmed) - 1
+		runes[last] = escapeWindowsRune(runes[last])
+		changed = true
+	}
+
+	if !changed {
+		return name
+	}
+	return string(runes)
+}
+
+// unescapeWindowsName undoes escapeWindowsName on a name that
+// Windows gave us for a new entry, so that a file created as
+// "a\uf03ab" on Windows is called "a:b" everywhere else.
+func unescapeWindowsName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r > windowsEscapeBase && r < windowsEscapeBase+0x80 {
+			return r - windowsEscapeBase
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,32 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscapeWindowsName(t *testing.T) {
+	for name, escaped := range map[string]string{
+		"plain.txt":     "plain.txt",
+		"a:b":           "a\uf03ab",
+		`what?<>*|"\`:   "what\uf03f\uf03c\uf03e\uf02a\uf07c\uf022\uf05c",
+		"tab\there":     "tab\uf009here",
+		"trailing. . ":  "trailing\uf02e\uf020\uf02e\uf020",
+		"con":           "co\uf06e",
+		"CON.txt":       "CO\uf04e.txt",
+		"aux .tar.gz":   "au\uf078 .tar.gz",
+		"com1":          "com\uf031",
+		"lpt9.":         "lpt9\uf02e",
+		"console":       "console",
+		"nul is a word": "nul is a word",
+		"a.con":         "a.con",
+	} {
+		require.Equal(t, escaped, escapeWindowsName(name), name)
+		require.Equal(t, name, unescapeWindowsName(escaped), name)
+	}
+}
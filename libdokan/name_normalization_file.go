@@ -0,0 +1,44 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"strings"
+
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// NameNormalizationFile is a special file used to set the unicode
+// normalization form of new entry names in a TLF.
+type NameNormalizationFile struct {
+	specialWriteFile
+	folder *Folder
+}
+
+// WriteFile implements writes for dokan.
+func (f *NameNormalizationFile) WriteFile(ctx context.Context,
+	fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.folder.fs.logEnter(ctx, "NameNormalizationFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+
+	normalization, err := libkbfs.ParseNameNormalization(
+		strings.TrimSpace(string(bs)))
+	if err != nil {
+		return 0, err
+	}
+
+	err = f.folder.fs.config.KBFSOps().SetTlfNameNormalization(
+		ctx, f.folder.getFolderBranch(), normalization)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(bs), nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// PauseQuotaReclamationFile represents a write-only file where any
+// write of at least one byte either pauses or resumes periodic quota
+// reclamation for the folder.
+type PauseQuotaReclamationFile struct {
+	folder *Folder
+	pause  bool
+	specialWriteFile
+}
+
+// WriteFile implements writes for dokan.
+func (f *PauseQuotaReclamationFile) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.folder.fs.logEnter(ctx, "PauseQuotaReclamationFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+	err = f.folder.fs.config.KBFSOps().SetQuotaReclamationPaused(
+		ctx, f.folder.getFolderBranch(), f.pause)
+	if err != nil {
+		return 0, err
+	}
+	return len(bs), nil
+}
+
+// QuotaReclamationMinHeadAgeFile is a special file used to set how
+// old the head of a TLF must be before quota reclamation runs.
+type QuotaReclamationMinHeadAgeFile struct {
+	folder *Folder
+	specialWriteFile
+}
+
+// WriteFile implements writes for dokan.
+func (f *QuotaReclamationMinHeadAgeFile) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.folder.fs.logEnter(ctx, "QuotaReclamationMinHeadAgeFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+
+	var age time.Duration
+	s := strings.TrimSpace(string(bs))
+	if s != "default" {
+		age, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = f.folder.fs.config.KBFSOps().SetQuotaReclamationMinHeadAge(
+		ctx, f.folder.getFolderBranch(), age)
+	if err != nil {
+		return 0, err
+	}
+	return len(bs), nil
+}
@@ -26,7 +26,7 @@ func (f *ReclaimQuotaFile) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs
 	if len(bs) == 0 {
 		return 0, nil
 	}
-	err = libkbfs.ForceQuotaReclamationForTesting(
-		f.folder.fs.config, f.folder.getFolderBranch())
+	err = f.folder.fs.config.KBFSOps().ForceQuotaReclamation(
+		ctx, f.folder.getFolderBranch())
 	return len(bs), err
 }
@@ -0,0 +1,42 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// RemoveTreeFile represents a write-only file where any write of at
+// least one byte removes the directory named name in dir, along with
+// everything in it.  Unlike deleting it from Explorer, which removes
+// one entry at a time, the whole tree usually goes away in a single
+// revision of the folder.
+type RemoveTreeFile struct {
+	dir  *Dir
+	name string
+	specialWriteFile
+}
+
+// WriteFile implements writes for dokan.
+func (f *RemoveTreeFile) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	folder := f.dir.folder
+	folder.fs.logEnterf(ctx, "RemoveTreeFile Write %q", f.name)
+	defer func() { folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+
+	// renameAndDeletionLock should be the first lock to be grabbed in libdokan.
+	folder.fs.renameAndDeletionLock.Lock()
+	defer folder.fs.renameAndDeletionLock.Unlock()
+	err = folder.fs.config.KBFSOps().RemoveDirTree(ctx, f.dir.node, f.name)
+	if err != nil {
+		return 0, errToDokan(err)
+	}
+	folder.fs.NotificationGroupWait()
+	return len(bs), nil
+}
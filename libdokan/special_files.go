@@ -82,6 +82,70 @@ func handleTLFSpecialFile(name string, folder *Folder) dokan.File {
 			folder: folder,
 			action: libfs.JournalDisable,
 		}
+
+	case libfs.FsyncDurabilityFileName:
+		return &FsyncDurabilityFile{
+			folder: folder,
+		}
+
+	case libfs.NameNormalizationFileName:
+		return &NameNormalizationFile{
+			folder: folder,
+		}
+
+	case libfs.FreezeFileName:
+		return &FreezeFile{
+			folder: folder,
+			freeze: true,
+		}
+
+	case libfs.UnfreezeFileName:
+		return &FreezeFile{
+			folder: folder,
+		}
+
+	case libfs.AbortOpsFileName:
+		return &AbortOpsFile{
+			folder: folder,
+		}
+
+	case libfs.EnableAppendOnlyFileName:
+		return &AppendOnlyFile{
+			folder:     folder,
+			appendOnly: true,
+		}
+
+	case libfs.DisableAppendOnlyFileName:
+		return &AppendOnlyFile{
+			folder: folder,
+		}
+
+	case libfs.PauseQuotaReclamationFileName:
+		return &PauseQuotaReclamationFile{
+			folder: folder,
+			pause:  true,
+		}
+
+	case libfs.ResumeQuotaReclamationFileName:
+		return &PauseQuotaReclamationFile{
+			folder: folder,
+		}
+
+	case libfs.QuotaReclamationMinHeadAgeFileName:
+		return &QuotaReclamationMinHeadAgeFile{
+			folder: folder,
+		}
+
+	case libfs.EnableSyncFileName:
+		return &SyncFile{
+			folder: folder,
+			synced: true,
+		}
+
+	case libfs.DisableSyncFileName:
+		return &SyncFile{
+			folder: folder,
+		}
 	}
 
 	return nil
@@ -23,6 +23,14 @@ type StartOptions struct {
 	RuntimeDir  string
 	Label       string
 	DokanConfig dokan.Config
+	// DeniedExecutables lists the executables, by full path or
+	// base name, whose processes may not use the mount.
+	DeniedExecutables []string
+	// IndexerOpsPerSecond limits the requests of known desktop
+	// search indexers.  Zero means no limit.
+	IndexerOpsPerSecond float64
+	// TimePolicy decides how the mount reports file times.
+	TimePolicy libfs.TimePolicy
 }
 
 // Start the filesystem
@@ -35,7 +43,20 @@ func Start(mounter Mounter, options StartOptions, kbCtx libkbfs.Context) *libfs.
 		return libfs.InitError(err.Error())
 	}
 
+	// Keep track of the requests in flight and the open files, so
+	// that a forced unmount doesn't leave processes stuck on them.
+	ops := libfs.NewOpRegistry()
+	handles := libfs.NewOpenHandleRegistry()
+	dm, ok := mounter.(*DefaultMounter)
+	force := ok && dm.force
+
 	onInterruptFn := func() {
+		if force {
+			n := ops.AbortAll()
+			log.Debug("Aborted %d in-flight ops before unmounting", n)
+			n = handles.ForceCloseAll()
+			log.Debug("Force-closed %d open files before unmounting", n)
+		}
 		mounter.Unmount()
 	}
 
@@ -62,10 +83,16 @@ func Start(mounter Mounter, options StartOptions, kbCtx libkbfs.Context) *libfs.
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	libfs.LogHealthCheck(ctx, config, log)
 	fs, err := NewFS(ctx, config, log)
 	if err != nil {
 		return libfs.InitError(err.Error())
 	}
+	fs.processPolicy = libfs.NewProcessPolicy(
+		log, options.DeniedExecutables, options.IndexerOpsPerSecond)
+	fs.timePolicy = options.TimePolicy
+	fs.ops = ops
+	fs.handles = handles
 	options.DokanConfig.FileSystem = fs
 	options.DokanConfig.Path = mounter.Dir()
 	if options.DokanConfig.Path == "" {
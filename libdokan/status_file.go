@@ -16,7 +16,7 @@ import (
 func NewNonTLFStatusFile(fs *FS) *SpecialReadFile {
 	return &SpecialReadFile{
 		read: func(ctx context.Context) ([]byte, time.Time, error) {
-			return libfs.GetEncodedStatus(ctx, fs.config)
+			return libfs.GetEncodedStatus(ctx, fs.config, fs.handles)
 		},
 		fs: fs,
 	}
@@ -28,7 +28,8 @@ func NewTLFStatusFile(folder *Folder) *SpecialReadFile {
 	return &SpecialReadFile{
 		read: func(ctx context.Context) ([]byte, time.Time, error) {
 			return libfs.GetEncodedFolderStatus(
-				ctx, folder.fs.config, folder.getFolderBranch())
+				ctx, folder.fs.config, folder.getFolderBranch(),
+				folder.fs.handles)
 		},
 		fs: folder.fs,
 	}
@@ -0,0 +1,35 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SyncFile represents a write-only file where any write of at least
+// one byte either starts or stops keeping the folder synced on this
+// device.
+type SyncFile struct {
+	folder *Folder
+	synced bool
+	specialWriteFile
+}
+
+// WriteFile implements writes for dokan.
+func (f *SyncFile) WriteFile(ctx context.Context, fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.folder.fs.logEnter(ctx, "SyncFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+	err = f.folder.fs.config.KBFSOps().SetTlfSynced(
+		ctx, f.folder.getFolderBranch(), f.synced)
+	if err != nil {
+		return 0, err
+	}
+	return len(bs), nil
+}
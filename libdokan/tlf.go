@@ -75,6 +75,10 @@ func (tlf *TLF) loadDirHelper(ctx context.Context, info string,
 
 		tlf.folder.reportErr(ctx, mode, err)
 	}()
+	// The folder's ID isn't known until its root node is loaded, so
+	// only an abort of all the ops on the mount can cut this short.
+	ctx, done := tlf.folder.beginOp(ctx)
+	defer done()
 
 	handle, err := tlf.folder.resolve(ctx)
 	if err != nil {
@@ -61,6 +61,65 @@ const ResumeJournalBackgroundWorkFileName = ".kbfs_resume_journal_background_wor
 // file. It can be reached anywhere within a top-level folder.
 const DisableJournalFileName = ".kbfs_disable_journal"
 
+// FsyncDurabilityFileName is the name of the file that sets what
+// fsync guarantees for a TLF; write "journal", "server", "none" or
+// "default" to it.  It can be reached anywhere within a top-level
+// folder.
+const FsyncDurabilityFileName = ".kbfs_fsync_durability"
+
+// FreezeFileName is the name of the file that marks a TLF as
+// read-only for all devices.  It can be reached anywhere within a
+// top-level folder.
+const FreezeFileName = ".kbfs_freeze"
+
+// UnfreezeFileName is the name of the file that makes a frozen TLF
+// writable again.  It can be reached anywhere within a top-level
+// folder.
+const UnfreezeFileName = ".kbfs_unfreeze"
+
+// EnableAppendOnlyFileName is the name of the file that marks a TLF
+// as append-only for all devices: new entries can be created and
+// files appended to, but nothing can be modified or removed.  It can
+// be reached anywhere within a top-level folder.
+const EnableAppendOnlyFileName = ".kbfs_enable_append_only"
+
+// DisableAppendOnlyFileName is the name of the file that clears the
+// append-only mark from a TLF.  It can be reached anywhere within a
+// top-level folder.
+const DisableAppendOnlyFileName = ".kbfs_disable_append_only"
+
+// NameNormalizationFileName is the name of the file that sets the
+// unicode normalization form in which a TLF stores new entry names,
+// for all devices; write "nfc" or "nfd" to it.  It can be reached
+// anywhere within a top-level folder.
+const NameNormalizationFileName = ".kbfs_name_normalization"
+
+// PauseQuotaReclamationFileName is the name of the file that stops
+// periodic quota reclamation for a TLF.  It can be reached anywhere
+// within a top-level folder.
+const PauseQuotaReclamationFileName = ".kbfs_pause_quota_reclamation"
+
+// ResumeQuotaReclamationFileName is the name of the file that
+// restarts periodic quota reclamation for a TLF.  It can be reached
+// anywhere within a top-level folder.
+const ResumeQuotaReclamationFileName = ".kbfs_resume_quota_reclamation"
+
+// QuotaReclamationMinHeadAgeFileName is the name of the file that
+// sets how old a TLF's head must be before quota reclamation runs;
+// write a duration like "30m", or "default".  It can be reached
+// anywhere within a top-level folder.
+const QuotaReclamationMinHeadAgeFileName = ".kbfs_quota_reclamation_min_head_age"
+
+// EnableSyncFileName is the name of the file that keeps a TLF synced
+// on this device, in its own partition of the disk block cache.  It
+// can be reached anywhere within a top-level folder.
+const EnableSyncFileName = ".kbfs_enable_sync"
+
+// DisableSyncFileName is the name of the file that stops keeping a
+// TLF synced on this device.  It can be reached anywhere within a
+// top-level folder.
+const DisableSyncFileName = ".kbfs_disable_sync"
+
 // EnableAutoJournalsFileName is the name of the KBFS-wide
 // auto-journal-enabling file.  It's accessible anywhere outside a TLF.
 const EnableAutoJournalsFileName = ".kbfs_enable_auto_journals"
@@ -86,9 +145,30 @@ const EnableDebugServerFileName = ".kbfs_enable_debug_server"
 // debug HTTP server. It's accessible anywhere outside a TLF.
 const DisableDebugServerFileName = ".kbfs_disable_debug_server"
 
+// ProcessDenialsFileName is the name of the file listing the most
+// recent requests refused by the mount's process policy.  It's
+// accessible anywhere outside a TLF.
+const ProcessDenialsFileName = ".kbfs_process_denials"
+
 // EditHistoryName is the name of the KBFS TLF edit history file --
 // it can be reached anywhere within a top-level folder.
 const EditHistoryName = ".kbfs_edit_history"
 
 // FileInfoPrefix is the prefix of the per-file metadata files.
 const FileInfoPrefix = ".kbfs_fileinfo_"
+
+// RemoveTreePrefix is the prefix of the per-directory files that,
+// when written to, remove the directory along with everything in it,
+// in a single revision of the folder.
+const RemoveTreePrefix = ".kbfs_remove_tree_"
+
+// ArchivedRevisionsDirName is the name of the read-only directory
+// listing the recent revisions of a TLF, each as a subdirectory
+// holding the whole folder as of that revision.  It can be reached
+// anywhere within a top-level folder.
+const ArchivedRevisionsDirName = ".kbfs_archived"
+
+// AbortOpsFileName is the name of the file that aborts all the
+// operations in flight on a top-level folder when written to. It can
+// be reached anywhere within a top-level folder.
+const AbortOpsFileName = ".kbfs_abort_ops"
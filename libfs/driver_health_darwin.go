@@ -0,0 +1,49 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const kbfuseInfoPlist = "/Library/Filesystems/kbfuse.fs/Contents/Info.plist"
+
+var bundleVersionRegexp = regexp.MustCompile(
+	`<key>CFBundleVersion</key>\s*<string>([^<]*)</string>`)
+
+// CheckDriverHealth is a libkbfs.HealthCheck that checks that the
+// kbfuse file system bundle is installed, and reports its version.
+func CheckDriverHealth(
+	ctx context.Context, config libkbfs.Config) libkbfs.HealthFinding {
+	const check = "kbfuse"
+	plist, err := ioutil.ReadFile(kbfuseInfoPlist)
+	if err != nil {
+		return libkbfs.HealthFinding{
+			Check:    check,
+			Severity: libkbfs.HealthError,
+			Problem:  fmt.Sprintf("kbfuse isn't installed: %v", err),
+			Advice:   "Reinstall Keybase, or run `keybase install --components=fuse`",
+		}
+	}
+	match := bundleVersionRegexp.FindSubmatch(plist)
+	if match == nil {
+		return libkbfs.HealthFinding{
+			Check:    check,
+			Severity: libkbfs.HealthWarning,
+			Problem:  "kbfuse is installed, but its version is unknown",
+			Advice:   "Reinstall Keybase, or run `keybase install --components=fuse`",
+		}
+	}
+	return libkbfs.HealthFinding{
+		Check:    check,
+		Severity: libkbfs.HealthOK,
+		Problem:  fmt.Sprintf("kbfuse %s is installed", match[1]),
+	}
+}
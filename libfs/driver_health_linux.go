@@ -0,0 +1,46 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// CheckDriverHealth is a libkbfs.HealthCheck that checks that the
+// FUSE kernel module is loaded, and reports its version.
+func CheckDriverHealth(
+	ctx context.Context, config libkbfs.Config) libkbfs.HealthFinding {
+	const check = "fuse"
+	_, err := ioutil.Stat("/dev/fuse")
+	if err != nil {
+		return libkbfs.HealthFinding{
+			Check:    check,
+			Severity: libkbfs.HealthError,
+			Problem:  fmt.Sprintf("No FUSE device: %v", err),
+			Advice:   "Install FUSE, and load its module with `modprobe fuse`",
+		}
+	}
+	// The version file is missing when FUSE is built into the
+	// kernel rather than loaded as a module.
+	version, err := ioutil.ReadFile("/sys/module/fuse/version")
+	if err != nil {
+		return libkbfs.HealthFinding{
+			Check:    check,
+			Severity: libkbfs.HealthOK,
+			Problem:  "FUSE is available",
+		}
+	}
+	return libkbfs.HealthFinding{
+		Check:    check,
+		Severity: libkbfs.HealthOK,
+		Problem: fmt.Sprintf("FUSE %s is available",
+			strings.TrimSpace(string(version))),
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!windows
+
+package libfs
+
+import (
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// CheckDriverHealth is a libkbfs.HealthCheck for the mount's file
+// system driver.  There's nothing to check on this platform.
+func CheckDriverHealth(
+	ctx context.Context, config libkbfs.Config) libkbfs.HealthFinding {
+	return libkbfs.HealthFinding{
+		Check:    "driver",
+		Severity: libkbfs.HealthOK,
+		Problem:  "No driver check for this platform",
+	}
+}
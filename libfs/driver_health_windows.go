@@ -0,0 +1,42 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// CheckDriverHealth is a libkbfs.HealthCheck that checks that the
+// Dokan driver and library are installed.
+func CheckDriverHealth(
+	ctx context.Context, config libkbfs.Config) libkbfs.HealthFinding {
+	const check = "dokan"
+	system32 := filepath.Join(os.Getenv("SystemRoot"), "System32")
+	for _, path := range []string{
+		filepath.Join(system32, "drivers", "dokan1.sys"),
+		filepath.Join(system32, "dokan1.dll"),
+	} {
+		_, err := ioutil.Stat(path)
+		if err != nil {
+			return libkbfs.HealthFinding{
+				Check:    check,
+				Severity: libkbfs.HealthError,
+				Problem:  fmt.Sprintf("Dokan isn't installed: %v", err),
+				Advice:   "Reinstall Keybase to install the Dokan driver",
+			}
+		}
+	}
+	return libkbfs.HealthFinding{
+		Check:    check,
+		Severity: libkbfs.HealthOK,
+		Problem:  "Dokan is installed",
+	}
+}
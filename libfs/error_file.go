@@ -16,7 +16,13 @@ import (
 type JSONReportedError struct {
 	Time  time.Time
 	Error string
-	Stack []goerrors.StackFrame
+	// Code, Name and Params describe the error in a form that front
+	// ends can turn into a localized message; see
+	// libkbfs.ErrorToStatus.
+	Code   int
+	Name   string
+	Params map[string]string `json:",omitempty"`
+	Stack  []goerrors.StackFrame
 }
 
 func convertStack(stack []uintptr) []goerrors.StackFrame {
@@ -38,6 +44,15 @@ func GetEncodedErrors(config libkbfs.Config) func(context.Context) ([]byte, time
 		for i, e := range errors {
 			jsonErrors[i].Time = e.Time
 			jsonErrors[i].Error = e.Error.Error()
+			status := libkbfs.ErrorToStatus(e.Error)
+			jsonErrors[i].Code = status.Code
+			jsonErrors[i].Name = status.Name
+			if len(status.Fields) > 0 {
+				jsonErrors[i].Params = make(map[string]string)
+				for _, kv := range status.Fields {
+					jsonErrors[i].Params[kv.Key] = kv.Value
+				}
+			}
 			jsonErrors[i].Stack = convertStack(e.Stack)
 		}
 		data, err := PrettyJSON(jsonErrors)
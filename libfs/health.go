@@ -0,0 +1,27 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// LogHealthCheck runs the KBFS health checks, including the one for
+// the file system driver, and logs whatever they find.  Mounts run it
+// at startup, so that the log of a misbehaving mount says what was
+// already wrong with it.
+func LogHealthCheck(
+	ctx context.Context, config libkbfs.Config, log logger.Logger) {
+	for _, f := range libkbfs.CheckHealth(ctx, config, CheckDriverHealth) {
+		if f.Severity == libkbfs.HealthOK {
+			log.CDebugf(ctx, "Health check %s: %s", f.Check, f.Problem)
+			continue
+		}
+		log.CWarningf(ctx, "Health check %s (%s): %s; %s",
+			f.Check, f.Severity, f.Problem, f.Advice)
+	}
+}
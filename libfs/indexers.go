@@ -0,0 +1,45 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// knownIndexers are the base names of the executables of the desktop
+// search indexers that crawl mounted volumes: Spotlight's on macOS,
+// Windows Search's, and Tracker's and Baloo's on Linux.
+var knownIndexers = []string{
+	"mds", "mds_stores", "mdworker", "mdworker_shared",
+	"SearchIndexer", "SearchProtocolHost", "SearchFilterHost",
+	"tracker-miner-fs", "tracker-extract",
+	"tracker-miner-fs-3", "tracker-extract-3",
+	"baloo_file", "baloo_file_extractor",
+}
+
+// indexerOptOutFileNames are the names of the marker files that
+// tell indexers to skip a volume or directory: Spotlight's, which
+// it looks for at the root of a volume, and Tracker's, which it
+// looks for in each directory it crawls.
+var indexerOptOutFileNames = map[string]bool{
+	".metadata_never_index": true,
+	".trackerignore":        true,
+}
+
+// IsIndexerOptOutFileName returns whether name is the name of a
+// marker file that keeps indexers from crawling the directory it's
+// in.  Mounts show these files, empty, so that indexers don't try to
+// download every block of every TLF.
+func IsIndexerOptOutFileName(name string) bool {
+	return indexerOptOutFileNames[name]
+}
+
+// ReadEmpty is the read function of an empty special file, like an
+// indexer opt-out marker.
+func ReadEmpty(context.Context) ([]byte, time.Time, error) {
+	return nil, time.Time{}, nil
+}
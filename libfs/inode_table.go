@@ -0,0 +1,251 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// RootInode is the inode number of the root of a mount.
+const RootInode = 1
+
+// nextInodeKey holds the next inode number to hand out.  It's
+// shorter than any entry key, so it can't collide with one.
+var nextInodeKey = []byte("next")
+
+var inodeTableOptions = &opt.Options{
+	Compression:            opt.NoCompression,
+	OpenFilesCacheCapacity: 10,
+}
+
+// InodeTable assigns inode numbers to the entries of a mount, keyed
+// by the inode number of their parent directory and their name.  A
+// directory keeps its inode number when it's renamed, so its
+// descendants keep theirs too.  KBFS has no stable IDs of its own for
+// files, so entries renamed or removed by other devices get new
+// numbers, and the mount has to Remove the old ones once it notices.
+//
+// If the table is opened from a directory, the numbers survive
+// remounts and restarts, which NFS re-exports, backup tools and some
+// databases rely on.  The table can also find the parent and name of
+// a number, so that an NFS file handle can be turned back into a path.
+type InodeTable struct {
+	lock sync.Mutex
+	stor storage.Storage
+	db   *leveldb.DB
+}
+
+func newInodeTable(stor storage.Storage) (*InodeTable, error) {
+	db, err := leveldb.Open(stor, inodeTableOptions)
+	if _, ok := err.(*storage.ErrCorrupted); ok {
+		db, err = leveldb.Recover(stor, inodeTableOptions)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &InodeTable{stor: stor, db: db}, nil
+}
+
+// NewInodeTable returns an InodeTable kept only in memory.
+func NewInodeTable() *InodeTable {
+	t, err := newInodeTable(storage.NewMemStorage())
+	if err != nil {
+		// Opening memory storage can't fail.
+		panic(err)
+	}
+	return t
+}
+
+// OpenInodeTable opens the InodeTable saved in the given directory,
+// creating it if needed.  Only one process may have it open at once.
+func OpenInodeTable(dir string) (*InodeTable, error) {
+	stor, err := storage.OpenFile(dir, false)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't open inode table %s: %v", dir, err)
+	}
+	t, err := newInodeTable(stor)
+	if err != nil {
+		stor.Close()
+		return nil, fmt.Errorf("Couldn't open inode table %s: %v", dir, err)
+	}
+	return t, nil
+}
+
+func inodeKey(parent uint64, name string) []byte {
+	key := make([]byte, 8+len(name))
+	binary.BigEndian.PutUint64(key, parent)
+	copy(key[8:], name)
+	return key
+}
+
+// parentKey returns the key of the parent and name of the given
+// inode number, which is stored as the value.  It's shorter than any
+// entry key, since names aren't empty, and it sorts first among the
+// entries of the inode, if it's a directory.
+func parentKey(inode uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, inode)
+	return key
+}
+
+func (t *InodeTable) getLocked(key []byte) (uint64, bool, error) {
+	buf, err := t.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	if len(buf) != 8 {
+		return 0, false, fmt.Errorf("Bad inode table value %x", buf)
+	}
+	return binary.BigEndian.Uint64(buf), true, nil
+}
+
+// Inode returns the inode number of the entry with the given name in
+// the directory with the given inode number, assigning a new one if
+// the entry doesn't have one yet.
+func (t *InodeTable) Inode(parent uint64, name string) (uint64, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	key := inodeKey(parent, name)
+	inode, ok, err := t.getLocked(key)
+	if err != nil || ok {
+		return inode, err
+	}
+
+	inode, ok, err = t.getLocked(nextInodeKey)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		inode = RootInode + 1
+	}
+	var buf, nextBuf [8]byte
+	binary.BigEndian.PutUint64(buf[:], inode)
+	binary.BigEndian.PutUint64(nextBuf[:], inode+1)
+	var batch leveldb.Batch
+	batch.Put(key, buf[:])
+	batch.Put(parentKey(inode), inodeKey(parent, name))
+	batch.Put(nextInodeKey, nextBuf[:])
+	err = t.db.Write(&batch, nil)
+	if err != nil {
+		return 0, err
+	}
+	return inode, nil
+}
+
+// Parent returns the inode number of the parent of the entry with the
+// given inode number, and the entry's name.  ok is false if the number
+// isn't in use.
+func (t *InodeTable) Parent(inode uint64) (
+	parent uint64, name string, ok bool, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	buf, err := t.db.Get(parentKey(inode), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, "", false, nil
+	} else if err != nil {
+		return 0, "", false, err
+	}
+	if len(buf) <= 8 {
+		return 0, "", false, fmt.Errorf("Bad inode table value %x", buf)
+	}
+	return binary.BigEndian.Uint64(buf), string(buf[8:]), true, nil
+}
+
+// Rename moves the inode number of the given entry to its new parent
+// and name, replacing any number the new name had.
+func (t *InodeTable) Rename(oldParent uint64, oldName string,
+	newParent uint64, newName string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	oldKey := inodeKey(oldParent, oldName)
+	newKey := inodeKey(newParent, newName)
+	if string(oldKey) == string(newKey) {
+		return nil
+	}
+	inode, ok, err := t.getLocked(oldKey)
+	if err != nil {
+		return err
+	}
+	var batch leveldb.Batch
+	// Forget whatever the new name had; if the old entry was never
+	// numbered, the new one will get a fresh number when it's
+	// looked up.
+	err = t.removeLocked(&batch, newKey)
+	if err != nil {
+		return err
+	}
+	if ok {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], inode)
+		batch.Delete(oldKey)
+		batch.Put(newKey, buf[:])
+		batch.Put(parentKey(inode), newKey)
+	}
+	return t.db.Write(&batch, nil)
+}
+
+// Remove forgets the inode number of the given entry, along with
+// those of everything under it, so that entries later created with
+// the same names get new ones.  It does nothing if the entry was
+// never numbered.
+func (t *InodeTable) Remove(parent uint64, name string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var batch leveldb.Batch
+	err := t.removeLocked(&batch, inodeKey(parent, name))
+	if err != nil || batch.Len() == 0 {
+		return err
+	}
+	return t.db.Write(&batch, nil)
+}
+
+// removeLocked adds the deletion of the entry with the given key,
+// and of everything under it, to batch.
+func (t *InodeTable) removeLocked(batch *leveldb.Batch, key []byte) error {
+	inode, ok, err := t.getLocked(key)
+	if err != nil || !ok {
+		return err
+	}
+
+	batch.Delete(key)
+	// The entries under a directory are keyed by its inode number,
+	// and so is its own parent key.
+	dirs := []uint64{inode}
+	for len(dirs) > 0 {
+		iter := t.db.NewIterator(util.BytesPrefix(parentKey(dirs[0])), nil)
+		dirs = dirs[1:]
+		for iter.Next() {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+			if value := iter.Value(); len(value) == 8 {
+				dirs = append(dirs, binary.BigEndian.Uint64(value))
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the table.
+func (t *InodeTable) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	err := t.db.Close()
+	if err != nil {
+		return err
+	}
+	return t.stor.Close()
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInodeTable(t *testing.T) {
+	inodes := NewInodeTable()
+	defer inodes.Close()
+
+	a, err := inodes.Inode(RootInode, "a")
+	require.NoError(t, err)
+	require.Equal(t, uint64(RootInode+1), a)
+	b, err := inodes.Inode(a, "b")
+	require.NoError(t, err)
+	require.NotEqual(t, a, b)
+	again, err := inodes.Inode(RootInode, "a")
+	require.NoError(t, err)
+	require.Equal(t, a, again)
+
+	// Renaming a directory keeps the numbers of its entries.
+	require.NoError(t, inodes.Rename(RootInode, "a", RootInode, "c"))
+	c, err := inodes.Inode(RootInode, "c")
+	require.NoError(t, err)
+	require.Equal(t, a, c)
+	again, err = inodes.Inode(c, "b")
+	require.NoError(t, err)
+	require.Equal(t, b, again)
+	newA, err := inodes.Inode(RootInode, "a")
+	require.NoError(t, err)
+	require.NotEqual(t, a, newA)
+
+	// Renaming over an entry replaces its number.
+	require.NoError(t, inodes.Rename(RootInode, "c", RootInode, "a"))
+	again, err = inodes.Inode(RootInode, "a")
+	require.NoError(t, err)
+	require.Equal(t, a, again)
+
+	require.NoError(t, inodes.Remove(a, "b"))
+	again, err = inodes.Inode(a, "b")
+	require.NoError(t, err)
+	require.NotEqual(t, b, again)
+
+	// Removing an entry that was never numbered does nothing.
+	require.NoError(t, inodes.Remove(a, "nonexistent"))
+}
+
+func TestInodeTableRemoveTree(t *testing.T) {
+	inodes := NewInodeTable()
+	defer inodes.Close()
+
+	a, err := inodes.Inode(RootInode, "a")
+	require.NoError(t, err)
+	b, err := inodes.Inode(a, "b")
+	require.NoError(t, err)
+	_, err = inodes.Inode(b, "c")
+	require.NoError(t, err)
+	d, err := inodes.Inode(RootInode, "d")
+	require.NoError(t, err)
+
+	// Removing a directory forgets everything under it.
+	require.NoError(t, inodes.Remove(RootInode, "a"))
+	var numEntries int
+	iter := inodes.db.NewIterator(nil, nil)
+	for iter.Next() {
+		if string(iter.Key()) != string(nextInodeKey) {
+			numEntries++
+		}
+	}
+	iter.Release()
+	require.NoError(t, iter.Error())
+	// Only "d" and its parent key are left.
+	require.Equal(t, 2, numEntries)
+	again, err := inodes.Inode(RootInode, "d")
+	require.NoError(t, err)
+	require.Equal(t, d, again)
+}
+
+func TestInodeTableParent(t *testing.T) {
+	inodes := NewInodeTable()
+	defer inodes.Close()
+
+	a, err := inodes.Inode(RootInode, "a")
+	require.NoError(t, err)
+	b, err := inodes.Inode(a, "b")
+	require.NoError(t, err)
+	parent, name, ok, err := inodes.Parent(b)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, a, parent)
+	require.Equal(t, "b", name)
+
+	c, err := inodes.Inode(RootInode, "c")
+	require.NoError(t, err)
+	require.NoError(t, inodes.Rename(a, "b", RootInode, "c"))
+	parent, name, ok, err = inodes.Parent(b)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(RootInode), parent)
+	require.Equal(t, "c", name)
+	// The entry renamed over is forgotten.
+	_, _, ok, err = inodes.Parent(c)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, inodes.Remove(RootInode, "c"))
+	_, _, ok, err = inodes.Parent(b)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestInodeTablePersistence(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "inode_table")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, ioutil.RemoveAll(tempdir))
+	}()
+
+	inodes, err := OpenInodeTable(tempdir)
+	require.NoError(t, err)
+	a, err := inodes.Inode(RootInode, "a")
+	require.NoError(t, err)
+	require.NoError(t, inodes.Close())
+
+	inodes, err = OpenInodeTable(tempdir)
+	require.NoError(t, err)
+	defer inodes.Close()
+	again, err := inodes.Inode(RootInode, "a")
+	require.NoError(t, err)
+	require.Equal(t, a, again)
+	b, err := inodes.Inode(RootInode, "b")
+	require.NoError(t, err)
+	require.Equal(t, a+1, b)
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// localObjectTargetPrefix starts the symlink targets that record, in
+// a TLF, the FIFOs and unix sockets created on a mount.  KBFS can't
+// sync those, so each mount that supports them backs them with its
+// own local objects; other mounts just see dangling symlinks.
+const localObjectTargetPrefix = ".kbfs_local_object:"
+
+// localObjectTypes maps the type names used in placeholder targets to
+// the file modes they stand for.
+var localObjectTypes = map[string]os.FileMode{
+	"fifo":   os.ModeNamedPipe,
+	"socket": os.ModeSocket,
+}
+
+// LocalObjectTarget returns the target of the placeholder symlink
+// recording a local object with the given mode, which must be a FIFO
+// or a socket.  The permission bits of mode are kept in the target.
+func LocalObjectTarget(mode os.FileMode) (string, error) {
+	for name, typ := range localObjectTypes {
+		if mode&os.ModeType == typ {
+			return fmt.Sprintf("%s%s:%04o",
+				localObjectTargetPrefix, name, mode.Perm()), nil
+		}
+	}
+	return "", fmt.Errorf("Can't make a local object of mode %s", mode)
+}
+
+// ParseLocalObjectTarget returns the mode of the local object that
+// the placeholder symlink target stands for, or false if target isn't
+// one.
+func ParseLocalObjectTarget(target string) (os.FileMode, bool) {
+	if !strings.HasPrefix(target, localObjectTargetPrefix) {
+		return 0, false
+	}
+	parts := strings.SplitN(target[len(localObjectTargetPrefix):], ":", 2)
+	if len(parts) != 2 {
+		return 0, false
+	}
+	typ, ok := localObjectTypes[parts[0]]
+	if !ok {
+		return 0, false
+	}
+	perm, err := strconv.ParseUint(parts[1], 8, 32)
+	if err != nil {
+		return 0, false
+	}
+	return typ | (os.FileMode(perm) & os.ModePerm), true
+}
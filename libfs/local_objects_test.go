@@ -0,0 +1,41 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalObjectTarget(t *testing.T) {
+	for _, mode := range []os.FileMode{
+		os.ModeNamedPipe | 0644,
+		os.ModeSocket | 0755,
+	} {
+		target, err := LocalObjectTarget(mode)
+		require.NoError(t, err)
+		parsed, ok := ParseLocalObjectTarget(target)
+		require.True(t, ok)
+		require.Equal(t, mode, parsed)
+	}
+
+	_, err := LocalObjectTarget(0644)
+	require.Error(t, err)
+	_, err = LocalObjectTarget(os.ModeDevice | 0644)
+	require.Error(t, err)
+
+	for _, target := range []string{
+		"../some/file",
+		".kbfs_local_object:",
+		".kbfs_local_object:fifo",
+		".kbfs_local_object:device:0644",
+		".kbfs_local_object:fifo:0999",
+	} {
+		_, ok := ParseLocalObjectTarget(target)
+		require.False(t, ok, target)
+	}
+}
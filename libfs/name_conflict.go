@@ -0,0 +1,194 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NameConflictPolicy decides which entry names a mount's platform
+// can't tell apart.  KBFS itself compares names byte for byte, so a
+// directory written from Linux can hold both "README" and "readme",
+// or an NFC and an NFD spelling of "café"; on a case-insensitive or
+// normalization-insensitive platform, one of those entries would
+// shadow the other.
+type NameConflictPolicy interface {
+	// Key returns the form under which the platform compares
+	// names; two names with the same key collide.
+	Key(name string) string
+	// String returns the name of the policy, as accepted by
+	// ParseNameConflictPolicy.
+	String() string
+}
+
+type exactNames struct{}
+
+func (exactNames) Key(name string) string { return name }
+func (exactNames) String() string         { return "exact" }
+
+type unicodeNames struct{}
+
+func (unicodeNames) Key(name string) string { return norm.NFC.String(name) }
+func (unicodeNames) String() string         { return "unicode" }
+
+type caseInsensitiveNames struct{}
+
+func (caseInsensitiveNames) Key(name string) string {
+	// Lower-case first, since case mapping can produce
+	// decomposed sequences.
+	return norm.NFC.String(strings.ToLower(name))
+}
+func (caseInsensitiveNames) String() string { return "case" }
+
+var (
+	// ExactNames is the policy for platforms that compare names
+	// byte for byte, like Linux.  Under it, no names collide.
+	ExactNames NameConflictPolicy = exactNames{}
+	// UnicodeNames is the policy for platforms that treat
+	// canonically-equivalent unicode names as the same name.
+	UnicodeNames NameConflictPolicy = unicodeNames{}
+	// CaseInsensitiveNames is the policy for platforms that
+	// ignore both case and unicode normalization, like macOS and
+	// Windows.
+	CaseInsensitiveNames NameConflictPolicy = caseInsensitiveNames{}
+)
+
+// ParseNameConflictPolicy returns the policy with the given name:
+// "exact", "unicode" or "case".
+func ParseNameConflictPolicy(s string) (NameConflictPolicy, error) {
+	for _, p := range []NameConflictPolicy{
+		ExactNames, UnicodeNames, CaseInsensitiveNames} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown name conflict policy %q", s)
+}
+
+// DisambiguatedName returns the name to show for the nth (starting
+// at 1) later entry that collides with an earlier one.  The suffix
+// goes before the extension, so that the entry still opens with the
+// same application.
+func DisambiguatedName(name string, n int) string {
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i:]
+	}
+	return fmt.Sprintf("%s (name conflict %d)%s", base, n, ext)
+}
+
+// NameConflictView is a view of the entry names of one directory
+// under a NameConflictPolicy, in which no two names collide.  A view
+// may also escape names that the platform can't represent at all
+// (see MakeEscapedNameConflictView).
+type NameConflictView struct {
+	policy NameConflictPolicy
+	// displayNames maps each KBFS name that had to be renamed to
+	// the name shown for it.
+	displayNames map[string]string
+	// names maps the key of each shown name to its KBFS name.
+	names map[string]string
+}
+
+// MakeNameConflictView returns the view of the given entry names
+// under the given policy.  Within each group of colliding names, the
+// bytewise-smallest one keeps its name, and the others are shown
+// under DisambiguatedName, numbered in bytewise order and skipping
+// any names that are already taken.  That makes the result the same
+// on every device, however the directory is listed.
+func MakeNameConflictView(
+	policy NameConflictPolicy, names []string) NameConflictView {
+	return MakeEscapedNameConflictView(policy, nil, names)
+}
+
+// MakeEscapedNameConflictView is like MakeNameConflictView, but first
+// passes each name through escape, for platforms that can't
+// represent some names at all.  The escaped names then collide, and
+// are disambiguated, like any others; in particular an escaped name
+// never shadows an entry that already has that name.  A nil escape
+// leaves names as they are.
+func MakeEscapedNameConflictView(policy NameConflictPolicy,
+	escape func(string) string, names []string) NameConflictView {
+	v := NameConflictView{
+		policy: policy,
+		names:  make(map[string]string, len(names)),
+	}
+	if escape == nil && policy == ExactNames {
+		for _, name := range names {
+			v.names[name] = name
+		}
+		return v
+	}
+
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+	setDisplayName := func(name, display string) {
+		if display == name {
+			return
+		}
+		if v.displayNames == nil {
+			v.displayNames = make(map[string]string)
+		}
+		v.displayNames[name] = display
+	}
+	var losers []string
+	// Unescaped names go first, so that they keep their names
+	// even if some other name escapes to the same thing.
+	for _, escaped := range []bool{false, true} {
+		for _, name := range sorted {
+			display := name
+			if escape != nil {
+				display = escape(name)
+			}
+			if (display != name) != escaped {
+				continue
+			}
+			key := policy.Key(display)
+			if _, ok := v.names[key]; ok {
+				losers = append(losers, name)
+				continue
+			}
+			v.names[key] = name
+			setDisplayName(name, display)
+		}
+	}
+	for _, name := range losers {
+		display := name
+		if escape != nil {
+			display = escape(name)
+		}
+		for n := 1; ; n++ {
+			disambiguated := DisambiguatedName(display, n)
+			key := policy.Key(disambiguated)
+			if _, ok := v.names[key]; ok {
+				continue
+			}
+			v.names[key] = name
+			setDisplayName(name, disambiguated)
+			break
+		}
+	}
+	return v
+}
+
+// DisplayName returns the name to show for the given KBFS name.
+func (v NameConflictView) DisplayName(name string) string {
+	if display, ok := v.displayNames[name]; ok {
+		return display
+	}
+	return name
+}
+
+// Resolve returns the KBFS name of the entry that the platform would
+// match to the given name, and whether there is one.
+func (v NameConflictView) Resolve(name string) (string, bool) {
+	kbfsName, ok := v.names[v.policy.Key(name)]
+	return kbfsName, ok
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameConflictViewExact(t *testing.T) {
+	v := MakeNameConflictView(ExactNames, []string{"README", "readme"})
+	require.Equal(t, "readme", v.DisplayName("readme"))
+	name, ok := v.Resolve("README")
+	require.True(t, ok)
+	require.Equal(t, "README", name)
+	_, ok = v.Resolve("Readme")
+	require.False(t, ok)
+}
+
+func TestNameConflictViewCaseInsensitive(t *testing.T) {
+	const nfc, nfd = "café.txt", "café.txt"
+	names := []string{
+		"readme.md", "README.md", "Readme (name conflict 1).md",
+		nfd, nfc, ".bashrc", ".BASHRC",
+	}
+	v := MakeNameConflictView(CaseInsensitiveNames, names)
+
+	// The bytewise-smallest name of each group keeps its name.
+	require.Equal(t, "README.md", v.DisplayName("README.md"))
+	require.Equal(t, nfd, v.DisplayName(nfd))
+	require.Equal(t, ".BASHRC", v.DisplayName(".BASHRC"))
+	// The others get a suffix, skipping suffixes already taken.
+	require.Equal(t, "readme (name conflict 2).md",
+		v.DisplayName("readme.md"))
+	require.Equal(t, "café (name conflict 1).txt", v.DisplayName(nfc))
+	require.Equal(t, ".bashrc (name conflict 1)", v.DisplayName(".bashrc"))
+
+	for _, name := range names {
+		kbfsName, ok := v.Resolve(v.DisplayName(name))
+		require.True(t, ok)
+		require.Equal(t, name, kbfsName)
+	}
+	kbfsName, ok := v.Resolve("ReadMe.MD")
+	require.True(t, ok)
+	require.Equal(t, "README.md", kbfsName)
+	_, ok = v.Resolve("other")
+	require.False(t, ok)
+
+	// The result doesn't depend on the listing order.
+	reversed := make([]string, len(names))
+	for i, name := range names {
+		reversed[len(names)-1-i] = name
+	}
+	require.Equal(t, v, MakeNameConflictView(CaseInsensitiveNames, reversed))
+}
+
+func TestParseNameConflictPolicy(t *testing.T) {
+	for _, p := range []NameConflictPolicy{
+		ExactNames, UnicodeNames, CaseInsensitiveNames} {
+		parsed, err := ParseNameConflictPolicy(p.String())
+		require.NoError(t, err)
+		require.Equal(t, p, parsed)
+	}
+	_, err := ParseNameConflictPolicy("bogus")
+	require.Error(t, err)
+}
+
+func TestNameConflictViewEscaped(t *testing.T) {
+	escape := func(name string) string {
+		if name == "a:b" {
+			return "a_b"
+		}
+		return name
+	}
+	names := []string{"a:b", "A_B", "c"}
+	v := MakeEscapedNameConflictView(CaseInsensitiveNames, escape, names)
+
+	// An escaped name never takes the place of an entry that
+	// already has its escaped name.
+	require.Equal(t, "A_B", v.DisplayName("A_B"))
+	require.Equal(t, "a_b (name conflict 1)", v.DisplayName("a:b"))
+	require.Equal(t, "c", v.DisplayName("c"))
+	for _, name := range names {
+		kbfsName, ok := v.Resolve(v.DisplayName(name))
+		require.True(t, ok)
+		require.Equal(t, name, kbfsName)
+	}
+	_, ok := v.Resolve("a:b")
+	require.False(t, ok)
+}
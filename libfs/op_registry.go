@@ -0,0 +1,105 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"sync"
+
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// OpRegistry keeps track of the file system operations in flight on
+// each TLF, so that they can be aborted all at once -- for example,
+// when a user gives up on an `ls` stuck waiting on the server, or
+// when the mount is being unmounted by force.
+//
+// Aborting an op cancels its context right away, even if the
+// context's cancellation would otherwise be delayed (see
+// libkbfs.EnableDelayedCancellationWithGracePeriod), since the point
+// is to get the op out of the kernel as soon as possible.
+type OpRegistry struct {
+	lock   sync.Mutex
+	nextID uint64
+	ops    map[tlf.ID]map[uint64]context.CancelFunc
+}
+
+// NewOpRegistry returns a new, empty OpRegistry.
+func NewOpRegistry() *OpRegistry {
+	return &OpRegistry{
+		ops: make(map[tlf.ID]map[uint64]context.CancelFunc),
+	}
+}
+
+// Begin registers an op on the given TLF, and returns the context
+// the op should use, along with a function that must be called when
+// the op is done. An op that doesn't belong to a known TLF yet may
+// pass tlf.NullID; it can then only be aborted by AbortAll.
+func (r *OpRegistry) Begin(ctx context.Context, tlfID tlf.ID) (
+	context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	id := r.nextID
+	r.nextID++
+	tlfOps := r.ops[tlfID]
+	if tlfOps == nil {
+		tlfOps = make(map[uint64]context.CancelFunc)
+		r.ops[tlfID] = tlfOps
+	}
+	tlfOps[id] = cancel
+
+	return ctx, func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if tlfOps, ok := r.ops[tlfID]; ok {
+			delete(tlfOps, id)
+			if len(tlfOps) == 0 {
+				delete(r.ops, tlfID)
+			}
+		}
+		cancel()
+	}
+}
+
+// AbortTLF cancels every op in flight on the given TLF, and returns
+// how many there were.
+func (r *OpRegistry) AbortTLF(tlfID tlf.ID) int {
+	r.lock.Lock()
+	tlfOps := r.ops[tlfID]
+	delete(r.ops, tlfID)
+	r.lock.Unlock()
+
+	for _, cancel := range tlfOps {
+		cancel()
+	}
+	return len(tlfOps)
+}
+
+// AbortAll cancels every op in flight, on any TLF, and returns how
+// many there were.
+func (r *OpRegistry) AbortAll() int {
+	r.lock.Lock()
+	ops := r.ops
+	r.ops = make(map[tlf.ID]map[uint64]context.CancelFunc)
+	r.lock.Unlock()
+
+	n := 0
+	for _, tlfOps := range ops {
+		for _, cancel := range tlfOps {
+			cancel()
+		}
+		n += len(tlfOps)
+	}
+	return n
+}
+
+// Count returns the number of ops in flight on the given TLF.
+func (r *OpRegistry) Count(tlfID tlf.ID) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.ops[tlfID])
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestOpRegistryAbortTLF(t *testing.T) {
+	r := NewOpRegistry()
+	tlfID1 := tlf.FakeID(1, false)
+	tlfID2 := tlf.FakeID(2, false)
+
+	ctx1, done1 := r.Begin(context.Background(), tlfID1)
+	ctx2, done2 := r.Begin(context.Background(), tlfID1)
+	ctx3, done3 := r.Begin(context.Background(), tlfID2)
+	defer done3()
+	require.Equal(t, 2, r.Count(tlfID1))
+	require.Equal(t, 1, r.Count(tlfID2))
+
+	done1()
+	require.Equal(t, context.Canceled, ctx1.Err())
+	require.Equal(t, 1, r.Count(tlfID1))
+
+	require.Equal(t, 1, r.AbortTLF(tlfID1))
+	require.Equal(t, context.Canceled, ctx2.Err())
+	require.NoError(t, ctx3.Err())
+	require.Equal(t, 0, r.Count(tlfID1))
+	require.Equal(t, 1, r.Count(tlfID2))
+
+	// Finishing an aborted op is a no-op.
+	done2()
+	require.Equal(t, 0, r.AbortTLF(tlfID1))
+	require.Equal(t, 1, r.Count(tlfID2))
+}
+
+func TestOpRegistryAbortAll(t *testing.T) {
+	r := NewOpRegistry()
+	ctx1, done1 := r.Begin(context.Background(), tlf.FakeID(1, false))
+	defer done1()
+	ctx2, done2 := r.Begin(context.Background(), tlf.NullID)
+	defer done2()
+
+	require.Equal(t, 2, r.AbortAll())
+	require.Equal(t, context.Canceled, ctx1.Err())
+	require.Equal(t, context.Canceled, ctx2.Err())
+	require.Equal(t, 0, r.AbortAll())
+
+	// Ops begun after the abort aren't affected by it.
+	ctx3, done3 := r.Begin(context.Background(), tlf.NullID)
+	defer done3()
+	require.NoError(t, ctx3.Err())
+}
@@ -0,0 +1,207 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+)
+
+// OpenHandle describes a file that's open on a mount.
+type OpenHandle struct {
+	ID uint64
+	// TLF is the ID of the folder the file is in, and Folder is
+	// its name.
+	TLF    tlf.ID
+	Folder string
+	// Name is the name of the file, as of when it was opened.
+	Name string
+	// PID is the process that opened the file, or 0 if it's not
+	// known.
+	PID uint32
+	// Write is whether the file was opened for writing.
+	Write bool
+	// Sync is whether the file was opened for synchronous writes
+	// (O_SYNC, or FILE_WRITE_THROUGH on Windows), which makes
+	// syncs of it wait for the servers.
+	Sync   bool
+	Opened time.Time
+}
+
+type openHandle struct {
+	OpenHandle
+	forceClose func()
+}
+
+// OpenHandleRegistry keeps track of the files open on a mount, so
+// that they can be listed in status output and force-closed -- for
+// example, before a forced unmount, when the logged-in user changes,
+// or when a folder is frozen.
+//
+// Force-closing a handle can't take it away from the process that
+// holds it; it only makes the file system fail any further requests
+// made with it.
+type OpenHandleRegistry struct {
+	lock    sync.Mutex
+	nextID  uint64
+	handles map[uint64]openHandle
+}
+
+// NewOpenHandleRegistry returns a new, empty OpenHandleRegistry.
+func NewOpenHandleRegistry() *OpenHandleRegistry {
+	return &OpenHandleRegistry{
+		handles: make(map[uint64]openHandle),
+	}
+}
+
+// add registers h, filling in its ID and Opened time, and returns
+// its ID. forceClose is called if it's force-closed.
+func (r *OpenHandleRegistry) add(h OpenHandle, forceClose func()) uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.nextID++
+	h.ID = r.nextID
+	h.Opened = time.Now()
+	r.handles[h.ID] = openHandle{h, forceClose}
+	return h.ID
+}
+
+func (r *OpenHandleRegistry) remove(id uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.handles, id)
+}
+
+// Handles returns the open handles in the given TLF, or all of them
+// if tlfID is tlf.NullID, in the order they were opened.
+func (r *OpenHandleRegistry) Handles(tlfID tlf.ID) []OpenHandle {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var handles []OpenHandle
+	for _, h := range r.handles {
+		if tlfID == tlf.NullID || h.TLF == tlfID {
+			handles = append(handles, h.OpenHandle)
+		}
+	}
+	sort.Slice(handles, func(i, j int) bool {
+		return handles[i].ID < handles[j].ID
+	})
+	return handles
+}
+
+// forceClose unregisters and force-closes the handles for which
+// match returns true, and returns how many there were.
+func (r *OpenHandleRegistry) forceClose(match func(OpenHandle) bool) int {
+	var closed []openHandle
+	func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		for id, h := range r.handles {
+			if match(h.OpenHandle) {
+				closed = append(closed, h)
+				delete(r.handles, id)
+			}
+		}
+	}()
+
+	for _, h := range closed {
+		h.forceClose()
+	}
+	return len(closed)
+}
+
+// ForceCloseTLF force-closes the handles open in the given TLF, or
+// just the ones open for writing if writersOnly is true, and returns
+// how many there were.
+func (r *OpenHandleRegistry) ForceCloseTLF(
+	tlfID tlf.ID, writersOnly bool) int {
+	return r.forceClose(func(h OpenHandle) bool {
+		return h.TLF == tlfID && (h.Write || !writersOnly)
+	})
+}
+
+// ForceCloseAll force-closes every open handle, and returns how many
+// there were.
+func (r *OpenHandleRegistry) ForceCloseAll() int {
+	return r.forceClose(func(OpenHandle) bool { return true })
+}
+
+// NodeHandles keeps track of the handles open on a single node, for
+// file systems where the node itself serves as the handle. Since the
+// handles can't be told apart, force-closing any one of them closes
+// the node for all of them, until it's opened again, and a sync of
+// the node uses the strongest durability any of them asked for.
+type NodeHandles struct {
+	lock   sync.Mutex
+	ids    []uint64
+	syncs  map[uint64]bool
+	closed bool
+}
+
+// Open registers a new handle on the node with r.
+func (n *NodeHandles) Open(r *OpenHandleRegistry, h OpenHandle) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.closed = false
+	var id uint64
+	id = r.add(h, func() {
+		n.lock.Lock()
+		defer n.lock.Unlock()
+		for i, openID := range n.ids {
+			if openID == id {
+				n.ids = append(n.ids[:i], n.ids[i+1:]...)
+				break
+			}
+		}
+		delete(n.syncs, id)
+		n.closed = true
+	})
+	n.ids = append(n.ids, id)
+	if h.Sync {
+		if n.syncs == nil {
+			n.syncs = make(map[uint64]bool)
+		}
+		n.syncs[id] = true
+	}
+}
+
+// Release unregisters one of the handles on the node from r, if any
+// are left.
+func (n *NodeHandles) Release(r *OpenHandleRegistry) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if len(n.ids) == 0 {
+		return
+	}
+	id := n.ids[len(n.ids)-1]
+	n.ids = n.ids[:len(n.ids)-1]
+	delete(n.syncs, id)
+	r.remove(id)
+}
+
+// FsyncDurability returns the durability level that syncs of the
+// node should use for its open handles: FsyncDurabilityServer if
+// any of them was opened for synchronous writes, and otherwise
+// FsyncDurabilityDefault, which defers to the TLF's setting.
+func (n *NodeHandles) FsyncDurability() libkbfs.FsyncDurability {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if len(n.syncs) > 0 {
+		return libkbfs.FsyncDurabilityServer
+	}
+	return libkbfs.FsyncDurabilityDefault
+}
+
+// Closed returns whether a handle on the node has been force-closed
+// since the node was last opened.
+func (n *NodeHandles) Closed() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.closed
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenHandleRegistry(t *testing.T) {
+	r := NewOpenHandleRegistry()
+	tlfID1 := tlf.FakeID(1, false)
+	tlfID2 := tlf.FakeID(2, false)
+
+	var reader, writer, other NodeHandles
+	reader.Open(r, OpenHandle{TLF: tlfID1, Name: "a", PID: 10})
+	writer.Open(r, OpenHandle{TLF: tlfID1, Name: "b", Write: true})
+	other.Open(r, OpenHandle{TLF: tlfID2, Name: "c", Write: true})
+
+	handles := r.Handles(tlfID1)
+	require.Len(t, handles, 2)
+	require.Equal(t, "a", handles[0].Name)
+	require.Equal(t, uint32(10), handles[0].PID)
+	require.Equal(t, "b", handles[1].Name)
+	require.False(t, handles[0].Opened.IsZero())
+	require.Len(t, r.Handles(tlf.NullID), 3)
+
+	t.Log("Only writers are closed when asked")
+	require.Equal(t, 1, r.ForceCloseTLF(tlfID1, true))
+	require.False(t, reader.Closed())
+	require.True(t, writer.Closed())
+	require.False(t, other.Closed())
+	require.Len(t, r.Handles(tlfID1), 1)
+
+	t.Log("Releasing a force-closed handle is a no-op")
+	writer.Release(r)
+	require.Len(t, r.Handles(tlf.NullID), 2)
+
+	t.Log("Reopening clears the closed state")
+	writer.Open(r, OpenHandle{TLF: tlfID1, Name: "b", Write: true})
+	require.False(t, writer.Closed())
+
+	require.Equal(t, 3, r.ForceCloseAll())
+	require.True(t, reader.Closed())
+	require.True(t, writer.Closed())
+	require.True(t, other.Closed())
+	require.Len(t, r.Handles(tlf.NullID), 0)
+}
+
+func TestNodeHandlesRelease(t *testing.T) {
+	r := NewOpenHandleRegistry()
+	tlfID := tlf.FakeID(1, false)
+
+	var n NodeHandles
+	n.Open(r, OpenHandle{TLF: tlfID})
+	n.Open(r, OpenHandle{TLF: tlfID})
+	require.Len(t, r.Handles(tlfID), 2)
+
+	n.Release(r)
+	require.Len(t, r.Handles(tlfID), 1)
+	n.Release(r)
+	n.Release(r)
+	require.Len(t, r.Handles(tlfID), 0)
+	require.False(t, n.Closed())
+	require.Equal(t, 0, r.ForceCloseTLF(tlfID, false))
+}
+
+func TestNodeHandlesFsyncDurability(t *testing.T) {
+	r := NewOpenHandleRegistry()
+	tlfID := tlf.FakeID(1, false)
+
+	var n NodeHandles
+	n.Open(r, OpenHandle{TLF: tlfID})
+	require.Equal(t, libkbfs.FsyncDurabilityDefault, n.FsyncDurability())
+	n.Open(r, OpenHandle{TLF: tlfID, Sync: true})
+	require.Equal(t, libkbfs.FsyncDurabilityServer, n.FsyncDurability())
+
+	n.Release(r)
+	require.Equal(t, libkbfs.FsyncDurabilityDefault, n.FsyncDurability())
+
+	// Force-closing forgets synchronous handles too.
+	n.Open(r, OpenHandle{TLF: tlfID, Sync: true})
+	require.Equal(t, 2, r.ForceCloseTLF(tlfID, false))
+	require.Equal(t, libkbfs.FsyncDurabilityDefault, n.FsyncDurability())
+}
@@ -0,0 +1,212 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/sysutils"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// processDenialLogSize is how many of the most recent denials a
+// ProcessPolicy remembers.
+const processDenialLogSize = 100
+
+// ProcessDenial describes a request that a ProcessPolicy refused.
+type ProcessDenial struct {
+	Time     time.Time
+	PID      uint32
+	ExecPath string
+	// Op is the kind of request that was refused, like "lookup"
+	// or "read".
+	Op string
+}
+
+// ProcessPolicy decides which processes may use a mount, by the
+// executable they are running, so that tools like backup programs
+// and indexers can be kept from crawling KBFS and downloading every
+// block.  It keeps a log of the most recent requests it denied.  It
+// can also throttle the requests of known desktop search indexers,
+// for when they ignore the opt-out markers (see
+// IsIndexerOptOutFileName).
+//
+// A request whose process can't be identified (for example, because
+// it has already exited) is allowed.
+type ProcessPolicy struct {
+	log logger.Logger
+	// denied holds the executables to deny, as full paths or as
+	// base names with or without their extension.
+	denied map[string]bool
+	// throttled holds the executables whose requests are
+	// limited by limiter, if it is non-nil.
+	throttled map[string]bool
+	limiter   *rate.Limiter
+	// getExecPath is sysutils.GetExecPathFromPID, except in tests.
+	getExecPath func(pid uint32) (string, error)
+
+	lock sync.Mutex
+	// denials is a ring buffer of the most recent denials; next
+	// is where the next one goes.
+	denials []ProcessDenial
+	next    int
+	// logged holds the executables whose denials have already
+	// been logged as warnings.
+	logged map[string]bool
+}
+
+// ParseDeniedExecutables splits a comma-separated list of
+// executables, as given on the command line.
+func ParseDeniedExecutables(s string) []string {
+	var execs []string
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e != "" {
+			execs = append(execs, e)
+		}
+	}
+	return execs
+}
+
+// normalizeExecName returns the form under which executable names
+// are compared on this platform.
+func normalizeExecName(name string) string {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+func makeExecSet(execs []string) map[string]bool {
+	set := make(map[string]bool, len(execs))
+	for _, e := range execs {
+		set[normalizeExecName(e)] = true
+	}
+	return set
+}
+
+// NewProcessPolicy returns a policy denying the given executables,
+// each given either as a full path or as a base name, with or
+// without its extension, and letting known indexers make at most
+// indexerOpsPerSecond requests a second, or any number if it's
+// zero.  It returns nil if that allows everything, and a nil policy
+// allows everything.
+func NewProcessPolicy(log logger.Logger, deniedExecs []string,
+	indexerOpsPerSecond float64) *ProcessPolicy {
+	if len(deniedExecs) == 0 && indexerOpsPerSecond <= 0 {
+		return nil
+	}
+	p := &ProcessPolicy{
+		log:         log,
+		denied:      makeExecSet(deniedExecs),
+		getExecPath: sysutils.GetExecPathFromPID,
+		denials:     make([]ProcessDenial, 0, processDenialLogSize),
+		logged:      make(map[string]bool),
+	}
+	if indexerOpsPerSecond > 0 {
+		p.throttled = makeExecSet(knownIndexers)
+		burst := int(indexerOpsPerSecond)
+		if burst < 1 {
+			burst = 1
+		}
+		p.limiter = rate.NewLimiter(rate.Limit(indexerOpsPerSecond), burst)
+	}
+	return p
+}
+
+// matches returns whether the executable at execPath is in set.
+func matches(set map[string]bool, execPath string) bool {
+	base := filepath.Base(execPath)
+	for _, name := range []string{
+		execPath, base, strings.TrimSuffix(base, filepath.Ext(base))} {
+		if set[normalizeExecName(name)] {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed returns whether the process with the given PID may make a
+// request of the given kind.  If not, the denial is logged.  If the
+// process is a throttled indexer, Allowed waits for its turn, and
+// returns false if ctx ends first.
+func (p *ProcessPolicy) Allowed(
+	ctx context.Context, pid uint32, op string) bool {
+	if p == nil {
+		return true
+	}
+	execPath, err := p.getExecPath(pid)
+	if err != nil {
+		p.log.CDebugf(ctx, "Allowing %s for unknown process %d: %v",
+			op, pid, err)
+		return true
+	}
+	if !matches(p.denied, execPath) {
+		if p.limiter == nil || !matches(p.throttled, execPath) {
+			return true
+		}
+		if err := p.limiter.Wait(ctx); err != nil {
+			p.log.CDebugf(ctx, "Gave up on throttled %s for %s (PID %d): "+
+				"%v", op, execPath, pid, err)
+			return false
+		}
+		return true
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	d := ProcessDenial{
+		Time:     time.Now(),
+		PID:      pid,
+		ExecPath: execPath,
+		Op:       op,
+	}
+	if len(p.denials) < processDenialLogSize {
+		p.denials = append(p.denials, d)
+	} else {
+		p.denials[p.next] = d
+	}
+	p.next = (p.next + 1) % processDenialLogSize
+	// Warn once per executable, since a crawler makes a lot of
+	// requests.
+	if !p.logged[execPath] {
+		p.logged[execPath] = true
+		p.log.CWarningf(ctx, "Denying access to %s (PID %d)", execPath, pid)
+	} else {
+		p.log.CDebugf(ctx, "Denying %s to %s (PID %d)", op, execPath, pid)
+	}
+	return false
+}
+
+// RecentDenials returns the logged denials, oldest first.
+func (p *ProcessPolicy) RecentDenials() []ProcessDenial {
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	recent := make([]ProcessDenial, 0, len(p.denials))
+	if len(p.denials) == processDenialLogSize {
+		recent = append(recent, p.denials[p.next:]...)
+		return append(recent, p.denials[:p.next]...)
+	}
+	return append(recent, p.denials...)
+}
+
+// GetEncodedProcessDenials returns a function that returns the
+// recent denials of the given policy, as JSON.
+func GetEncodedProcessDenials(p *ProcessPolicy) func(context.Context) (
+	[]byte, time.Time, error) {
+	return func(context.Context) ([]byte, time.Time, error) {
+		data, err := PrettyJSON(p.RecentDenials())
+		return data, time.Time{}, err
+	}
+}
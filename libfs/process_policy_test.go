@@ -0,0 +1,85 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestProcessPolicy(t *testing.T) {
+	require.Nil(t, NewProcessPolicy(logger.NewTestLogger(t), nil, 0))
+	var nilPolicy *ProcessPolicy
+	require.True(t, nilPolicy.Allowed(context.Background(), 1, "read"))
+
+	p := NewProcessPolicy(logger.NewTestLogger(t),
+		ParseDeniedExecutables("backupd, /usr/bin/indexer,,"), 0)
+	execPaths := map[uint32]string{
+		1: "/opt/backup/bin/backupd",
+		2: "/usr/bin/indexer",
+		3: "/usr/local/bin/indexer",
+		4: "/usr/bin/backupd.bin",
+		5: "/bin/ls",
+	}
+	p.getExecPath = func(pid uint32) (string, error) {
+		if execPath, ok := execPaths[pid]; ok {
+			return execPath, nil
+		}
+		return "", errors.New("no such process")
+	}
+
+	ctx := context.Background()
+	require.False(t, p.Allowed(ctx, 1, "lookup"))
+	require.False(t, p.Allowed(ctx, 2, "read"))
+	require.True(t, p.Allowed(ctx, 3, "read"))
+	// Base names match with or without their extension.
+	require.False(t, p.Allowed(ctx, 4, "readdir"))
+	require.True(t, p.Allowed(ctx, 5, "read"))
+	// Unidentified processes are allowed.
+	require.True(t, p.Allowed(ctx, 6, "read"))
+
+	denials := p.RecentDenials()
+	require.Len(t, denials, 3)
+	require.Equal(t, uint32(1), denials[0].PID)
+	require.Equal(t, "/opt/backup/bin/backupd", denials[0].ExecPath)
+	require.Equal(t, "lookup", denials[0].Op)
+	require.Equal(t, uint32(4), denials[2].PID)
+
+	// Only the most recent denials are kept.
+	for i := 0; i < processDenialLogSize; i++ {
+		require.False(t, p.Allowed(ctx, 2, "read"))
+	}
+	denials = p.RecentDenials()
+	require.Len(t, denials, processDenialLogSize)
+	for _, d := range denials {
+		require.Equal(t, uint32(2), d.PID)
+	}
+}
+
+func TestProcessPolicyThrottlesIndexers(t *testing.T) {
+	p := NewProcessPolicy(logger.NewTestLogger(t), nil, 1)
+	execPaths := map[uint32]string{
+		1: "/usr/libexec/tracker-extract",
+		2: "/bin/ls",
+	}
+	p.getExecPath = func(pid uint32) (string, error) {
+		return execPaths[pid], nil
+	}
+
+	// A throttled request gives up once ctx ends.
+	ctx := context.Background()
+	require.True(t, p.Allowed(ctx, 1, "read"))
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.False(t, p.Allowed(ctx, 1, "read"))
+	// Other processes aren't throttled.
+	require.True(t, p.Allowed(ctx, 2, "read"))
+	// Throttling isn't denial.
+	require.Len(t, p.RecentDenials(), 0)
+}
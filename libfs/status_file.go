@@ -8,13 +8,20 @@ import (
 	"time"
 
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
 	"golang.org/x/net/context"
 )
 
+type folderStatusWithHandles struct {
+	libkbfs.FolderBranchStatus
+	OpenHandles []OpenHandle
+}
+
 // GetEncodedFolderStatus returns serialized JSON containing status information
-// for a folder
+// for a folder, including the files open in it according to handles,
+// if it's non-nil.
 func GetEncodedFolderStatus(ctx context.Context, config libkbfs.Config,
-	folderBranch libkbfs.FolderBranch) (
+	folderBranch libkbfs.FolderBranch, handles *OpenHandleRegistry) (
 	data []byte, t time.Time, err error) {
 	var status libkbfs.FolderBranchStatus
 	status, _, err = config.KBFSOps().FolderStatus(ctx, folderBranch)
@@ -22,18 +29,32 @@ func GetEncodedFolderStatus(ctx context.Context, config libkbfs.Config,
 		return nil, time.Time{}, err
 	}
 
-	data, err = PrettyJSON(status)
+	withHandles := folderStatusWithHandles{FolderBranchStatus: status}
+	if handles != nil {
+		withHandles.OpenHandles = handles.Handles(folderBranch.Tlf)
+	}
+	data, err = PrettyJSON(withHandles)
 	return
 }
 
+type statusWithHandles struct {
+	libkbfs.KBFSStatus
+	OpenHandles []OpenHandle
+}
+
 // GetEncodedStatus returns serialized JSON containing top-level KBFS status
-// information
-func GetEncodedStatus(ctx context.Context, config libkbfs.Config) (
-	data []byte, t time.Time, err error) {
+// information, including all the files open according to handles, if
+// it's non-nil.
+func GetEncodedStatus(ctx context.Context, config libkbfs.Config,
+	handles *OpenHandleRegistry) (data []byte, t time.Time, err error) {
 	status, _, err := config.KBFSOps().Status(ctx)
 	if err != nil {
 		config.Reporter().ReportErr(ctx, "", false, libkbfs.ReadMode, err)
 	}
-	data, err = PrettyJSON(status)
+	withHandles := statusWithHandles{KBFSStatus: status}
+	if handles != nil {
+		withHandles.OpenHandles = handles.Handles(tlf.NullID)
+	}
+	data, err = PrettyJSON(withHandles)
 	return
 }
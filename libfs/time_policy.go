@@ -0,0 +1,148 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// AtimeMode decides when a mount updates the access times of files.
+// KBFS doesn't store access times, so they're only kept in memory,
+// for as long as the kernel keeps the file's node around.
+type AtimeMode int
+
+const (
+	// NoAtime never updates access times, and reports a file's
+	// modification time as its access time.
+	NoAtime AtimeMode = iota
+	// RelAtime updates a file's access time when it's read, if
+	// the access time is older than the file's modification or
+	// change time, or more than a day old.
+	RelAtime
+	// StrictAtime updates a file's access time every time it's
+	// read.
+	StrictAtime
+)
+
+func (m AtimeMode) String() string {
+	switch m {
+	case NoAtime:
+		return "noatime"
+	case RelAtime:
+		return "relatime"
+	case StrictAtime:
+		return "strictatime"
+	}
+	return fmt.Sprintf("AtimeMode(%d)", int(m))
+}
+
+// relAtimeInterval is how old an access time can get under RelAtime
+// before a read updates it regardless of the other times.
+const relAtimeInterval = 24 * time.Hour
+
+// TimePolicy decides how a mount reports the times of files.  Its
+// zero value reports the times KBFS stores as they are.
+type TimePolicy struct {
+	Atime AtimeMode
+	// CtimeFromMtime, if true, reports a file's modification
+	// time as its change time, for tools that would otherwise
+	// see every metadata update (like a rename) as a change.
+	CtimeFromMtime bool
+	// ClampFutureMtimes, if true, reports modification times in
+	// the future -- which come from writers whose clocks are
+	// ahead of this one -- as the current time, since build
+	// tools like make misbehave on them.
+	ClampFutureMtimes bool
+}
+
+// ParseTimePolicy returns the policy for the given option values, as
+// taken on the command line: atime is "noatime", "relatime" or
+// "strictatime", ctime is "kbfs" or "mtime", and remoteMtimes is
+// "exact" or "clamp".
+func ParseTimePolicy(atime, ctime, remoteMtimes string) (
+	p TimePolicy, err error) {
+	switch atime {
+	case NoAtime.String():
+		p.Atime = NoAtime
+	case RelAtime.String():
+		p.Atime = RelAtime
+	case StrictAtime.String():
+		p.Atime = StrictAtime
+	default:
+		return TimePolicy{}, fmt.Errorf("Unknown atime mode %q", atime)
+	}
+
+	switch ctime {
+	case "kbfs":
+	case "mtime":
+		p.CtimeFromMtime = true
+	default:
+		return TimePolicy{}, fmt.Errorf("Unknown ctime mode %q", ctime)
+	}
+
+	switch remoteMtimes {
+	case "exact":
+	case "clamp":
+		p.ClampFutureMtimes = true
+	default:
+		return TimePolicy{}, fmt.Errorf(
+			"Unknown remote mtimes mode %q", remoteMtimes)
+	}
+	return p, nil
+}
+
+// Times returns the modification, change and access times to report
+// for the entry ei, as of now, given its in-memory access time (which
+// is zero if it has none).
+func (p TimePolicy) Times(ei libkbfs.EntryInfo, atime, now time.Time) (
+	mtime, ctime, reportedAtime time.Time) {
+	mtime = time.Unix(0, ei.Mtime)
+	if p.ClampFutureMtimes && mtime.After(now) {
+		mtime = now
+	}
+	ctime = time.Unix(0, ei.Ctime)
+	if p.CtimeFromMtime {
+		ctime = mtime
+	}
+	if p.Atime == NoAtime || atime.IsZero() {
+		return mtime, ctime, mtime
+	}
+	return mtime, ctime, atime
+}
+
+// AccessTime is the in-memory access time of a file.
+type AccessTime struct {
+	lock sync.Mutex
+	t    time.Time
+}
+
+// Get returns the access time, or zero if the file hasn't been read
+// since the policy started tracking it.
+func (a *AccessTime) Get() time.Time {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.t
+}
+
+// OnRead updates the access time, as the policy decides, for a read
+// at now of a file whose reported modification and change times are
+// mtime and ctime.
+func (a *AccessTime) OnRead(p TimePolicy, mtime, ctime, now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	switch p.Atime {
+	case StrictAtime:
+		a.t = now
+	case RelAtime:
+		if !a.t.After(mtime) || !a.t.After(ctime) ||
+			now.Sub(a.t) >= relAtimeInterval {
+			a.t = now
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimePolicy(t *testing.T) {
+	p, err := ParseTimePolicy("noatime", "kbfs", "exact")
+	require.NoError(t, err)
+	require.Equal(t, TimePolicy{}, p)
+
+	p, err = ParseTimePolicy("relatime", "mtime", "clamp")
+	require.NoError(t, err)
+	require.Equal(t, TimePolicy{
+		Atime:             RelAtime,
+		CtimeFromMtime:    true,
+		ClampFutureMtimes: true,
+	}, p)
+
+	_, err = ParseTimePolicy("atime", "kbfs", "exact")
+	require.Error(t, err)
+	_, err = ParseTimePolicy("noatime", "atime", "exact")
+	require.Error(t, err)
+	_, err = ParseTimePolicy("noatime", "kbfs", "round")
+	require.Error(t, err)
+}
+
+func TestTimePolicyTimes(t *testing.T) {
+	now := time.Unix(1000, 0)
+	future := now.Add(time.Hour)
+	ei := libkbfs.EntryInfo{
+		Mtime: future.UnixNano(),
+		Ctime: now.Add(-time.Hour).UnixNano(),
+	}
+	atime := now.Add(-time.Minute)
+
+	mtime, ctime, reportedAtime := TimePolicy{}.Times(ei, atime, now)
+	require.Equal(t, future, mtime)
+	require.Equal(t, now.Add(-time.Hour), ctime)
+	require.Equal(t, future, reportedAtime)
+
+	p := TimePolicy{
+		Atime:             StrictAtime,
+		CtimeFromMtime:    true,
+		ClampFutureMtimes: true,
+	}
+	mtime, ctime, reportedAtime = p.Times(ei, atime, now)
+	require.Equal(t, now, mtime)
+	require.Equal(t, now, ctime)
+	require.Equal(t, atime, reportedAtime)
+
+	// Without an access time, the mtime stands in.
+	_, _, reportedAtime = p.Times(ei, time.Time{}, now)
+	require.Equal(t, now, reportedAtime)
+}
+
+func TestAccessTimeOnRead(t *testing.T) {
+	mtime := time.Unix(1000, 0)
+	ctime := mtime
+	now := mtime.Add(time.Hour)
+
+	var a AccessTime
+	a.OnRead(TimePolicy{}, mtime, ctime, now)
+	require.True(t, a.Get().IsZero())
+
+	rel := TimePolicy{Atime: RelAtime}
+	a.OnRead(rel, mtime, ctime, now)
+	require.Equal(t, now, a.Get())
+	// Newer than the other times and less than a day old.
+	a.OnRead(rel, mtime, ctime, now.Add(time.Hour))
+	require.Equal(t, now, a.Get())
+	// Older than a modification.
+	a.OnRead(rel, now.Add(2*time.Hour), ctime, now.Add(3*time.Hour))
+	require.Equal(t, now.Add(3*time.Hour), a.Get())
+	// More than a day old.
+	later := now.Add(30 * time.Hour)
+	a.OnRead(rel, mtime, ctime, later)
+	require.Equal(t, later, a.Get())
+
+	strict := TimePolicy{Atime: StrictAtime}
+	a.OnRead(strict, mtime, ctime, later.Add(time.Second))
+	require.Equal(t, later.Add(time.Second), a.Get())
+}
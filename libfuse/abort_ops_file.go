@@ -0,0 +1,53 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// AbortOpsFile represents a write-only file where any write of at
+// least one byte aborts all the requests in flight on the folder,
+// such as an `ls` stuck waiting on the server.
+type AbortOpsFile struct {
+	folder *Folder
+}
+
+var _ fs.Node = (*AbortOpsFile)(nil)
+
+// Attr implements the fs.Node interface for AbortOpsFile.
+func (f *AbortOpsFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*AbortOpsFile)(nil)
+
+var _ fs.HandleWriter = (*AbortOpsFile)(nil)
+
+// Write implements the fs.HandleWriter interface for AbortOpsFile.
+func (f *AbortOpsFile) Write(ctx context.Context, req *fuse.WriteRequest,
+	resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "AbortOpsFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+	folderBranch := f.folder.getFolderBranch()
+	if folderBranch == (libkbfs.FolderBranch{}) {
+		// Nothing to do.
+		resp.Size = len(req.Data)
+		return nil
+	}
+
+	n := f.folder.fs.ops.AbortTLF(folderBranch.Tlf)
+	f.folder.fs.log.CDebugf(ctx, "Aborted %d ops on %s", n, folderBranch.Tlf)
+	resp.Size = len(req.Data)
+	return nil
+}
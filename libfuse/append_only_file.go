@@ -0,0 +1,51 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// AppendOnlyFile represents a write-only file where any write of at
+// least one byte either marks the folder as append-only for all
+// devices, or clears that mark.
+type AppendOnlyFile struct {
+	folder     *Folder
+	appendOnly bool
+}
+
+var _ fs.Node = (*AppendOnlyFile)(nil)
+
+// Attr implements the fs.Node interface for AppendOnlyFile.
+func (f *AppendOnlyFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*AppendOnlyFile)(nil)
+
+var _ fs.HandleWriter = (*AppendOnlyFile)(nil)
+
+// Write implements the fs.HandleWriter interface for AppendOnlyFile.
+func (f *AppendOnlyFile) Write(ctx context.Context, req *fuse.WriteRequest,
+	resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "AppendOnlyFile (appendOnly: %t) Write",
+		f.appendOnly)
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+	err = f.folder.fs.config.KBFSOps().SetTlfAppendOnly(
+		ctx, f.folder.getFolderBranch(), f.appendOnly)
+	if err != nil {
+		return err
+	}
+	resp.Size = len(req.Data)
+	return nil
+}
@@ -0,0 +1,281 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// maxArchivedRevisionsListed is how many of the latest revisions
+// ArchivedRevisionsDir lists.  Older ones can still be looked up by
+// number.
+const maxArchivedRevisionsListed = 50
+
+// fillArchivedAttr sets the attributes of a read-only node from the
+// entry info it had as of its revision.
+func fillArchivedAttr(ei libkbfs.EntryInfo, a *fuse.Attr) {
+	// The entry can never change.
+	a.Valid = 1 * time.Hour
+	a.Size = ei.Size
+	a.Blocks = getNumBlocksFromSize(ei.Size)
+	a.Mtime = time.Unix(0, ei.Mtime)
+	a.Ctime = time.Unix(0, ei.Ctime)
+	a.Uid = uint32(os.Getuid())
+	switch ei.Type {
+	case libkbfs.Dir:
+		a.Mode = os.ModeDir | 0500
+	case libkbfs.Exec:
+		a.Mode = 0500
+	case libkbfs.Sym:
+		a.Mode = os.ModeSymlink | 0777
+	default:
+		a.Mode = 0400
+	}
+}
+
+// makeArchivedNode returns the node for the entry at the given path
+// of an archived revision.
+func makeArchivedNode(folder *Folder, rev *libkbfs.ArchivedRevision,
+	components []string, ei libkbfs.EntryInfo) fs.Node {
+	switch ei.Type {
+	case libkbfs.Dir:
+		return &ArchivedDir{folder, rev, components}
+	case libkbfs.Sym:
+		return &ArchivedSymlink{ei}
+	default:
+		return &ArchivedFile{folder, rev, components}
+	}
+}
+
+// ArchivedRevisionsDir is a read-only directory with a subdirectory
+// for each revision of a TLF, named by its revision number, holding
+// the whole folder as of that revision.  It lists only the latest
+// revisions, and each subdirectory's contents are only read as they
+// are looked up.
+type ArchivedRevisionsDir struct {
+	folder *Folder
+}
+
+var _ fs.Node = (*ArchivedRevisionsDir)(nil)
+
+// Attr implements the fs.Node interface for ArchivedRevisionsDir.
+func (d *ArchivedRevisionsDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	// New revisions can show up at any time.
+	a.Valid = 0
+	a.Mode = os.ModeDir | 0500
+	a.Uid = uint32(os.Getuid())
+	return nil
+}
+
+var _ fs.NodeRequestLookuper = (*ArchivedRevisionsDir)(nil)
+
+// Lookup implements the fs.NodeRequestLookuper interface for
+// ArchivedRevisionsDir.
+func (d *ArchivedRevisionsDir) Lookup(ctx context.Context,
+	req *fuse.LookupRequest, resp *fuse.LookupResponse) (
+	node fs.Node, err error) {
+	d.folder.fs.log.CDebugf(ctx, "ArchivedRevisionsDir Lookup %s", req.Name)
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	revNum, err := strconv.ParseInt(req.Name, 10, 64)
+	if err != nil || libkbfs.MetadataRevision(revNum) <
+		libkbfs.MetadataRevisionInitial {
+		return nil, fuse.ENOENT
+	}
+	rev, err := libkbfs.GetArchivedRevision(ctx, d.folder.fs.config,
+		d.folder.getFolderBranch().Tlf, libkbfs.MetadataRevision(revNum))
+	if err != nil {
+		return nil, err
+	}
+	return &ArchivedDir{d.folder, rev, nil}, nil
+}
+
+var _ fs.Handle = (*ArchivedRevisionsDir)(nil)
+
+var _ fs.HandleReadDirAller = (*ArchivedRevisionsDir)(nil)
+
+// ReadDirAll implements the fs.HandleReadDirAller interface for
+// ArchivedRevisionsDir.
+func (d *ArchivedRevisionsDir) ReadDirAll(ctx context.Context) (
+	res []fuse.Dirent, err error) {
+	d.folder.fs.log.CDebugf(ctx, "ArchivedRevisionsDir ReadDirAll")
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	revs, err := libkbfs.GetRecentRevisions(ctx, d.folder.fs.config,
+		d.folder.getFolderBranch().Tlf, maxArchivedRevisionsListed)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range revs {
+		res = append(res, fuse.Dirent{
+			Type: fuse.DT_Dir,
+			Name: strconv.FormatInt(int64(rev), 10),
+		})
+	}
+	return res, nil
+}
+
+// ArchivedDir is a read-only directory as of an archived revision.
+type ArchivedDir struct {
+	folder     *Folder
+	rev        *libkbfs.ArchivedRevision
+	components []string
+}
+
+var _ fs.Node = (*ArchivedDir)(nil)
+
+// Attr implements the fs.Node interface for ArchivedDir.
+func (d *ArchivedDir) Attr(ctx context.Context, a *fuse.Attr) (err error) {
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ei, err := d.rev.Lookup(ctx, d.components)
+	if err != nil {
+		return err
+	}
+	fillArchivedAttr(ei, a)
+	return nil
+}
+
+var _ fs.NodeRequestLookuper = (*ArchivedDir)(nil)
+
+// Lookup implements the fs.NodeRequestLookuper interface for
+// ArchivedDir.
+func (d *ArchivedDir) Lookup(ctx context.Context, req *fuse.LookupRequest,
+	resp *fuse.LookupResponse) (node fs.Node, err error) {
+	d.folder.fs.log.CDebugf(ctx, "ArchivedDir Lookup %s", req.Name)
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	components := make([]string, len(d.components), len(d.components)+1)
+	copy(components, d.components)
+	components = append(components, req.Name)
+	ei, err := d.rev.Lookup(ctx, components)
+	if isNoSuchNameError(err) {
+		return nil, fuse.ENOENT
+	} else if err != nil {
+		return nil, err
+	}
+	return makeArchivedNode(d.folder, d.rev, components, ei), nil
+}
+
+var _ fs.Handle = (*ArchivedDir)(nil)
+
+var _ fs.HandleReadDirAller = (*ArchivedDir)(nil)
+
+// ReadDirAll implements the fs.HandleReadDirAller interface for
+// ArchivedDir.
+func (d *ArchivedDir) ReadDirAll(ctx context.Context) (
+	res []fuse.Dirent, err error) {
+	d.folder.fs.log.CDebugf(ctx, "ArchivedDir ReadDirAll")
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	children, err := d.rev.GetDirChildren(ctx, d.components)
+	if err != nil {
+		return nil, err
+	}
+	for name, ei := range children {
+		fde := fuse.Dirent{Name: name}
+		switch ei.Type {
+		case libkbfs.File, libkbfs.Exec:
+			fde.Type = fuse.DT_File
+		case libkbfs.Dir:
+			fde.Type = fuse.DT_Dir
+		case libkbfs.Sym:
+			fde.Type = fuse.DT_Link
+		}
+		res = append(res, fde)
+	}
+	return res, nil
+}
+
+// ArchivedFile is a read-only file as of an archived revision.
+type ArchivedFile struct {
+	folder     *Folder
+	rev        *libkbfs.ArchivedRevision
+	components []string
+}
+
+var _ fs.Node = (*ArchivedFile)(nil)
+
+// Attr implements the fs.Node interface for ArchivedFile.
+func (f *ArchivedFile) Attr(ctx context.Context, a *fuse.Attr) (err error) {
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ei, err := f.rev.Lookup(ctx, f.components)
+	if err != nil {
+		return err
+	}
+	fillArchivedAttr(ei, a)
+	return nil
+}
+
+var _ fs.NodeOpener = (*ArchivedFile)(nil)
+
+// Open implements the fs.NodeOpener interface for ArchivedFile.
+func (f *ArchivedFile) Open(ctx context.Context, req *fuse.OpenRequest,
+	resp *fuse.OpenResponse) (handle fs.Handle, err error) {
+	f.folder.fs.log.CDebugf(ctx, "ArchivedFile Open")
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(syscall.EROFS)
+	}
+	af, err := f.rev.OpenFile(ctx, f.components)
+	if err != nil {
+		return nil, err
+	}
+	// The contents can never change.
+	resp.Flags |= fuse.OpenKeepCache
+	return &archivedFileHandle{f.folder, af}, nil
+}
+
+// archivedFileHandle is an open ArchivedFile.
+type archivedFileHandle struct {
+	folder *Folder
+	file   *libkbfs.ArchivedFile
+}
+
+var _ fs.HandleReader = (*archivedFileHandle)(nil)
+
+// Read implements the fs.HandleReader interface for
+// archivedFileHandle.
+func (h *archivedFileHandle) Read(ctx context.Context, req *fuse.ReadRequest,
+	resp *fuse.ReadResponse) (err error) {
+	defer func() { h.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	n, err := h.file.Read(ctx, resp.Data[:cap(resp.Data)], req.Offset)
+	if err != nil {
+		return err
+	}
+	resp.Data = resp.Data[:n]
+	return nil
+}
+
+// ArchivedSymlink is a symlink as of an archived revision.
+type ArchivedSymlink struct {
+	ei libkbfs.EntryInfo
+}
+
+var _ fs.Node = (*ArchivedSymlink)(nil)
+
+// Attr implements the fs.Node interface for ArchivedSymlink.
+func (s *ArchivedSymlink) Attr(ctx context.Context, a *fuse.Attr) error {
+	fillArchivedAttr(s.ei, a)
+	return nil
+}
+
+var _ fs.NodeReadlinker = (*ArchivedSymlink)(nil)
+
+// Readlink implements the fs.NodeReadlinker interface for
+// ArchivedSymlink.
+func (s *ArchivedSymlink) Readlink(ctx context.Context,
+	req *fuse.ReadlinkRequest) (string, error) {
+	return s.ei.SymPath, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+const benchmarkReadFileSize = 16 * 1024 * 1024
+
+func benchmarkReadSequential(b *testing.B, readSize int) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(b, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, b, config)
+	mnt, _, cancelFn := makeFS(b, ctx, config)
+	defer mnt.Close()
+	defer cancelFn()
+
+	p := path.Join(mnt.Dir, PrivateName, "jdoe", "bench")
+	data := make([]byte, benchmarkReadFileSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Sync(); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	buf := make([]byte, readSize)
+	b.SetBytes(benchmarkReadFileSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// The kernel drops its cached pages of the file when it's
+		// opened, so every iteration reads through KBFS.
+		f, err := os.Open(p)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			_, err := f.Read(buf)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+		f.Close()
+	}
+	b.StopTimer()
+}
+
+// BenchmarkReadSequential measures the throughput of reading a
+// synced file from start to end through the mount, in reads of
+// different sizes.
+func BenchmarkReadSequential(b *testing.B) {
+	for _, size := range []int{4 * 1024, 128 * 1024, 1024 * 1024} {
+		size := size // capture range variable.
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			benchmarkReadSequential(b, size)
+		})
+	}
+}
@@ -124,6 +124,13 @@ func (f *Folder) getFolderBranch() libkbfs.FolderBranch {
 	return f.folderBranch
 }
 
+// beginOp registers an op on this folder with the FS's op registry,
+// so that it can be aborted through AbortOpsFileName or by a forced
+// unmount. The returned function must be called when the op is done.
+func (f *Folder) beginOp(ctx context.Context) (context.Context, func()) {
+	return f.fs.ops.Begin(ctx, f.getFolderBranch().Tlf)
+}
+
 // forgetNode forgets a formerly active child with basename name.
 func (f *Folder) forgetNode(node libkbfs.Node) {
 	f.nodesMu.Lock()
@@ -266,6 +273,9 @@ func (f *Folder) batchChangesInvalidate(ctx context.Context,
 					// TODO we have no mechanism to do anything about this
 					f.fs.log.CErrorf(ctx, "FUSE invalidate error: %v", err)
 				}
+				if dir, ok := n.(*Dir); ok {
+					f.forgetRemovedInode(ctx, dir, name)
+				}
 			}
 
 		case len(v.FileUpdated) > 0:
@@ -289,6 +299,17 @@ func (f *Folder) batchChangesInvalidate(ctx context.Context,
 	}
 }
 
+// forgetRemovedInode forgets the inode number of the given entry if
+// it no longer exists, so that a new entry with the same name doesn't
+// inherit it.
+func (f *Folder) forgetRemovedInode(ctx context.Context, dir *Dir,
+	name string) {
+	_, _, err := f.fs.config.KBFSOps().Lookup(ctx, dir.node, name)
+	if isNoSuchNameError(err) {
+		f.fs.removeInode(ctx, dir.inode, name)
+	}
+}
+
 // TlfHandleChange is called when the name of a folder changes.
 // Note that newHandle may be nil. Then the handle in the folder is used.
 // This is used on e.g. logout/login.
@@ -367,15 +388,18 @@ func (f *Folder) writePermMode(ctx context.Context,
 
 // fillAttrWithUIDAndWritePerm sets attributes based on the entry info, and
 // pops in correct UID and write permissions. It only handles fields common to
-// all entryinfo types.
-func (f *Folder) fillAttrWithUIDAndWritePerm(
-	ctx context.Context, ei *libkbfs.EntryInfo, a *fuse.Attr) (err error) {
+// all entryinfo types. atime is the entry's in-memory access time, if any.
+func (f *Folder) fillAttrWithUIDAndWritePerm(ctx context.Context,
+	ei *libkbfs.EntryInfo, atime time.Time, a *fuse.Attr) (err error) {
 	a.Valid = 1 * time.Minute
 
 	a.Size = ei.Size
 	a.Blocks = getNumBlocksFromSize(ei.Size)
-	a.Mtime = time.Unix(0, ei.Mtime)
-	a.Ctime = time.Unix(0, ei.Ctime)
+	a.Mtime, a.Ctime, a.Atime = f.fs.timePolicy.Times(
+		*ei, atime, time.Now())
+	if ei.Nlink > 0 {
+		a.Nlink = ei.Nlink
+	}
 
 	a.Uid = uint32(os.Getuid())
 
@@ -438,12 +462,16 @@ type DirInterface interface {
 type Dir struct {
 	folder *Folder
 	node   libkbfs.Node
+	// inode is the inode number of this directory, which keys the
+	// inode numbers of its entries.
+	inode uint64
 }
 
-func newDir(folder *Folder, node libkbfs.Node) *Dir {
+func newDir(folder *Folder, node libkbfs.Node, inode uint64) *Dir {
 	d := &Dir{
 		folder: folder,
 		node:   node,
+		inode:  inode,
 	}
 	return d
 }
@@ -468,6 +496,8 @@ func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 
 	d.folder.fs.log.CDebugf(ctx, "Dir Attr")
 	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
@@ -487,7 +517,8 @@ func (d *Dir) attr(ctx context.Context, a *fuse.Attr) (err error) {
 		}
 		return err
 	}
-	if err = d.folder.fillAttrWithUIDAndWritePerm(ctx, &de, a); err != nil {
+	err = d.folder.fillAttrWithUIDAndWritePerm(ctx, &de, time.Time{}, a)
+	if err != nil {
 		return err
 	}
 
@@ -495,6 +526,23 @@ func (d *Dir) attr(ctx context.Context, a *fuse.Attr) (err error) {
 	return nil
 }
 
+// resolveName returns the KBFS name of the entry in d that the
+// kernel means by the given name, under the mount's name conflict
+// policy.  If there is no such entry, it returns the name unchanged.
+func (d *Dir) resolveName(ctx context.Context, name string) (string, error) {
+	if d.folder.fs.nameConflicts == libfs.ExactNames {
+		return name, nil
+	}
+	children, err := d.folder.fs.config.KBFSOps().GetDirChildren(ctx, d.node)
+	if err != nil {
+		return "", err
+	}
+	if kbfsName, ok := d.folder.fs.nameConflictView(children).Resolve(name); ok {
+		return kbfsName, nil
+	}
+	return name, nil
+}
+
 // Lookup implements the fs.NodeRequestLookuper interface for Dir.
 func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (node fs.Node, err error) {
 	ctx = d.folder.fs.maybeStartTrace(ctx, "Dir.Lookup",
@@ -502,7 +550,12 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
 
 	d.folder.fs.log.CDebugf(ctx, "Dir Lookup %s", req.Name)
+	if err := d.folder.fs.checkProcess(ctx, "lookup"); err != nil {
+		return nil, err
+	}
 	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
@@ -520,7 +573,11 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 	// Check if this is a per-file metainformation file, if so
 	// return the corresponding SpecialReadFile.
 	if strings.HasPrefix(req.Name, libfs.FileInfoPrefix) {
-		node, _, err := d.folder.fs.config.KBFSOps().Lookup(ctx, d.node, req.Name[len(libfs.FileInfoPrefix):])
+		name, err := d.resolveName(ctx, req.Name[len(libfs.FileInfoPrefix):])
+		if err != nil {
+			return nil, err
+		}
+		node, _, err := d.folder.fs.config.KBFSOps().Lookup(ctx, d.node, name)
 		if err != nil {
 			return nil, err
 		}
@@ -531,9 +588,33 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 		return &SpecialReadFile{fileInfo(nmd).read}, nil
 	}
 
-	newNode, de, err := d.folder.fs.config.KBFSOps().Lookup(ctx, d.node, req.Name)
+	// Check if this is a file for removing a directory tree.
+	if strings.HasPrefix(req.Name, libfs.RemoveTreePrefix) {
+		name := req.Name[len(libfs.RemoveTreePrefix):]
+		resolved, err := d.resolveName(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		_, _, err = d.folder.fs.config.KBFSOps().Lookup(ctx, d.node, resolved)
+		if _, ok := err.(libkbfs.NoSuchNameError); ok {
+			return nil, fuse.ENOENT
+		} else if err != nil {
+			return nil, err
+		}
+		resp.EntryValid = 0
+		return &RemoveTreeFile{dir: d, name: name}, nil
+	}
+
+	name, err := d.resolveName(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	newNode, de, err := d.folder.fs.config.KBFSOps().Lookup(ctx, d.node, name)
 	if err != nil {
 		if _, ok := err.(libkbfs.NoSuchNameError); ok {
+			// The entry may have been removed by another device.
+			d.folder.fs.removeInode(ctx, d.inode, req.Name)
 			return nil, fuse.ENOENT
 		}
 		return nil, err
@@ -565,14 +646,15 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 		return child, nil
 
 	case libkbfs.Dir:
-		child := newDir(d.folder, newNode)
+		child := newDir(d.folder, newNode,
+			d.folder.fs.inode(ctx, d.inode, req.Name))
 		d.folder.nodes[newNode.GetID()] = child
 		return child, nil
 
 	case libkbfs.Sym:
 		child := &Symlink{
 			parent: d,
-			name:   req.Name,
+			name:   name,
 		}
 		// a Symlink is never included in Folder.nodes, as it doesn't
 		// have a libkbfs.Node to keep track of renames.
@@ -599,6 +681,8 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 
 	d.folder.fs.log.CDebugf(ctx, "Dir Create %s", req.Name)
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	isExec := (req.Mode.Perm() & 0100) != 0
 	excl := getEXCLFromCreateRequest(req)
@@ -626,6 +710,7 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 	d.folder.nodesMu.Lock()
 	d.folder.nodes[newNode.GetID()] = child
 	d.folder.nodesMu.Unlock()
+	child.open(req.Pid, req.Flags)
 	return child, child, nil
 }
 
@@ -638,6 +723,8 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (
 
 	d.folder.fs.log.CDebugf(ctx, "Dir Mkdir %s", req.Name)
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
@@ -652,7 +739,8 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (
 		return nil, err
 	}
 
-	child := newDir(d.folder, newNode)
+	child := newDir(d.folder, newNode,
+		d.folder.fs.inode(ctx, d.inode, req.Name))
 	d.folder.nodesMu.Lock()
 	d.folder.nodes[newNode.GetID()] = child
 	d.folder.nodesMu.Unlock()
@@ -670,6 +758,8 @@ func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (
 	d.folder.fs.log.CDebugf(ctx, "Dir Symlink %s -> %s",
 		req.NewName, req.Target)
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
@@ -690,6 +780,98 @@ func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (
 	return child, nil
 }
 
+// Link implements the fs.NodeLinker interface for Dir.
+func (d *Dir) Link(ctx context.Context, req *fuse.LinkRequest,
+	old fs.Node) (node fs.Node, err error) {
+	ctx = d.folder.fs.maybeStartTrace(ctx, "Dir.Link",
+		fmt.Sprintf("%s %s", d.node.GetBasename(), req.NewName))
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Link %s", req.NewName)
+	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
+
+	oldFile, ok := old.(*File)
+	if !ok {
+		// Only KBFS files can be linked; directories and
+		// special files can't.
+		return nil, fuse.Errno(syscall.EPERM)
+	}
+
+	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
+	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
+		ctx, d.folder.fs.config.DelayedCancellationGracePeriod())
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.folder.fs.config.KBFSOps().Link(
+		ctx, oldFile.node, d.node, req.NewName)
+	if err != nil {
+		return nil, err
+	}
+
+	newNode, _, err := d.folder.fs.config.KBFSOps().Lookup(
+		ctx, d.node, req.NewName)
+	if err != nil {
+		return nil, err
+	}
+
+	d.folder.nodesMu.Lock()
+	defer d.folder.nodesMu.Unlock()
+	if n, ok := d.folder.nodes[newNode.GetID()]; ok {
+		return n, nil
+	}
+	child := &File{
+		folder: d.folder,
+		node:   newNode,
+	}
+	d.folder.nodes[newNode.GetID()] = child
+	return child, nil
+}
+
+// Mknod implements the fs.NodeMknoder interface for Dir.  Only FIFOs
+// and sockets can be made, and only if the FS backs them with local
+// objects.
+func (d *Dir) Mknod(ctx context.Context, req *fuse.MknodRequest) (
+	node fs.Node, err error) {
+	ctx = d.folder.fs.maybeStartTrace(ctx, "Dir.Mknod",
+		fmt.Sprintf("%s %s %s", d.node.GetBasename(), req.Name, req.Mode))
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Mknod %s %s", req.Name, req.Mode)
+	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
+
+	if !d.folder.fs.localObjects {
+		return nil, fuse.Errno(syscall.ENOTSUP)
+	}
+	target, err := libfs.LocalObjectTarget(req.Mode &^ req.Umask)
+	if err != nil {
+		return nil, fuse.Errno(syscall.ENOTSUP)
+	}
+
+	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
+	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
+		ctx, d.folder.fs.config.DelayedCancellationGracePeriod())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.folder.fs.config.KBFSOps().CreateLink(
+		ctx, d.node, req.Name, target); err != nil {
+		return nil, err
+	}
+
+	child := &Symlink{
+		parent: d,
+		name:   req.Name,
+	}
+	return child, nil
+}
+
 // Rename implements the fs.NodeRenamer interface for Dir.
 func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest,
 	newDir fs.Node) (err error) {
@@ -701,6 +883,8 @@ func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest,
 	d.folder.fs.log.CDebugf(ctx, "Dir Rename %s -> %s",
 		req.OldName, req.NewName)
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	var realNewDir *Dir
 	switch newDir := newDir.(type) {
@@ -724,11 +908,18 @@ func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest,
 		return fuse.Errno(syscall.EIO)
 	}
 
+	oldName, err := d.resolveName(ctx, req.OldName)
+	if err != nil {
+		return err
+	}
+
 	err = d.folder.fs.config.KBFSOps().Rename(ctx,
-		d.node, req.OldName, realNewDir.node, req.NewName)
+		d.node, oldName, realNewDir.node, req.NewName)
 
 	switch e := err.(type) {
 	case nil:
+		d.folder.fs.renameInode(
+			ctx, d.inode, req.OldName, realNewDir.inode, req.NewName)
 		return nil
 	case libkbfs.RenameAcrossDirsError:
 		var execPathErr error
@@ -752,6 +943,8 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) (err error) {
 
 	d.folder.fs.log.CDebugf(ctx, "Dir Remove %s", req.Name)
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
@@ -763,15 +956,21 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) (err error) {
 	// node will be removed from Folder.nodes, if it is there in the
 	// first place, by its Forget
 
+	name, err := d.resolveName(ctx, req.Name)
+	if err != nil {
+		return err
+	}
+
 	if req.Dir {
-		err = d.folder.fs.config.KBFSOps().RemoveDir(ctx, d.node, req.Name)
+		err = d.folder.fs.config.KBFSOps().RemoveDir(ctx, d.node, name)
 	} else {
-		err = d.folder.fs.config.KBFSOps().RemoveEntry(ctx, d.node, req.Name)
+		err = d.folder.fs.config.KBFSOps().RemoveEntry(ctx, d.node, name)
 	}
 	if err != nil {
 		return err
 	}
 
+	d.folder.fs.removeInode(ctx, d.inode, req.Name)
 	return nil
 }
 
@@ -782,16 +981,22 @@ func (d *Dir) ReadDirAll(ctx context.Context) (res []fuse.Dirent, err error) {
 	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
 
 	d.folder.fs.log.CDebugf(ctx, "Dir ReadDirAll")
+	if err := d.folder.fs.checkProcess(ctx, "readdir"); err != nil {
+		return nil, err
+	}
 	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	children, err := d.folder.fs.config.KBFSOps().GetDirChildren(ctx, d.node)
 	if err != nil {
 		return nil, err
 	}
+	view := d.folder.fs.nameConflictView(children)
 
 	for name, ei := range children {
 		fde := fuse.Dirent{
-			Name: name,
+			Name: view.DisplayName(name),
 		}
 		switch ei.Type {
 		case libkbfs.File, libkbfs.Exec:
@@ -800,6 +1005,13 @@ func (d *Dir) ReadDirAll(ctx context.Context) (res []fuse.Dirent, err error) {
 			fde.Type = fuse.DT_Dir
 		case libkbfs.Sym:
 			fde.Type = fuse.DT_Link
+			if mode, ok := d.folder.fs.localObjectMode(ei); ok {
+				if mode&os.ModeNamedPipe != 0 {
+					fde.Type = fuse.DT_FIFO
+				} else {
+					fde.Type = fuse.DT_Socket
+				}
+			}
 		}
 		res = append(res, fde)
 	}
@@ -820,6 +1032,8 @@ func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.
 
 	d.folder.fs.log.CDebugf(ctx, "Dir SetAttr %s", valid)
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := d.folder.beginOp(ctx)
+	defer done()
 
 	if valid.Mode() {
 		// You can't set the mode on KBFS directories, but we don't
@@ -0,0 +1,74 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"golang.org/x/net/context"
+)
+
+// exportFS is a view of an FS that can find its nodes again from
+// their inode numbers, so that the kernel can give out file handles
+// for it that stay valid after it forgets a node, or after a remount.
+// That's what re-exporting the mount over NFS needs.
+type exportFS struct {
+	*FS
+}
+
+var _ fs.FSNodeResolver = exportFS{}
+
+// ResolveInode implements the fs.FSNodeResolver interface for
+// exportFS.  It finds the path of the inode in the inode table, and
+// looks it up from the root.
+func (e exportFS) ResolveInode(
+	ctx context.Context, inode uint64) (fs.Node, error) {
+	var names []string
+	for inode != libfs.RootInode {
+		parent, name, ok, err := e.inodes.Parent(inode)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fuse.ESTALE
+		}
+		names = append(names, name)
+		inode = parent
+	}
+
+	var node fs.Node = &e.root
+	for i := len(names) - 1; i >= 0; i-- {
+		lookuper, ok := node.(fs.NodeRequestLookuper)
+		if !ok {
+			return nil, fuse.ESTALE
+		}
+		child, err := lookuper.Lookup(
+			ctx, &fuse.LookupRequest{Name: names[i]},
+			&fuse.LookupResponse{})
+		if err == fuse.ENOENT {
+			return nil, fuse.ESTALE
+		} else if err != nil {
+			return nil, err
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// ParentInode implements the fs.FSNodeResolver interface for
+// exportFS.
+func (e exportFS) ParentInode(
+	ctx context.Context, inode uint64) (uint64, error) {
+	if inode == libfs.RootInode {
+		return inode, nil
+	}
+	parent, _, ok, err := e.inodes.Parent(inode)
+	if err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, fuse.ESTALE
+	}
+	return parent, nil
+}
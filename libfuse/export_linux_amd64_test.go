@@ -0,0 +1,160 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// fileHandle is a struct file_handle, as used by name_to_handle_at(2)
+// and open_by_handle_at(2), with room for a FUSE file handle.
+type fileHandle struct {
+	bytes      uint32
+	handleType int32
+	handle     [32]byte
+}
+
+// These aren't in the syscall package, and the vendored
+// golang.org/x/sys/unix is too old to have them.
+const (
+	atFDCWD           = -100
+	sysNameToHandleAt = 303
+	sysOpenByHandleAt = 304
+)
+
+func nameToHandle(t *testing.T, p string) *fileHandle {
+	fh := &fileHandle{bytes: 32}
+	pathBytes, err := syscall.BytePtrFromString(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mountID int32
+	dirfd := atFDCWD
+	_, _, errno := syscall.Syscall6(sysNameToHandleAt,
+		uintptr(dirfd), uintptr(unsafe.Pointer(pathBytes)),
+		uintptr(unsafe.Pointer(fh)), uintptr(unsafe.Pointer(&mountID)),
+		0, 0)
+	if errno != 0 {
+		t.Fatalf("name_to_handle_at %s: %v", p, errno)
+	}
+	return fh
+}
+
+func openByHandle(mountDir string, fh *fileHandle, flags int) (
+	*os.File, error) {
+	mount, err := os.Open(mountDir)
+	if err != nil {
+		return nil, err
+	}
+	defer mount.Close()
+	fd, _, errno := syscall.Syscall(sysOpenByHandleAt,
+		mount.Fd(), uintptr(unsafe.Pointer(fh)), uintptr(flags))
+	if errno != 0 {
+		return nil, errno
+	}
+	return os.NewFile(fd, "handle"), nil
+}
+
+func TestFileHandlesSurviveRemount(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "inodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ioutil.RemoveAll(tempdir)
+
+	params := PlatformParams{NFSExport: true}
+	inodes, err := libfs.OpenInodeTable(tempdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt, _, cancelFn := makeFSWithPlatformParams(
+		t, ctx, config, inodes, params)
+	root := path.Join(mnt.Dir, PrivateName, "jdoe")
+	if err := ioutil.Mkdir(path.Join(root, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const input = "hello"
+	if err := ioutil.WriteFile(
+		path.Join(root, "d", "f"), []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(
+		path.Join(root, "d", "g"), []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dirHandle := nameToHandle(t, path.Join(root, "d"))
+	fileHandle := nameToHandle(t, path.Join(root, "d", "f"))
+	removedHandle := nameToHandle(t, path.Join(root, "d", "g"))
+	if err := ioutil.Remove(path.Join(root, "d", "g")); err != nil {
+		t.Fatal(err)
+	}
+	cancelFn()
+	mnt.Close()
+	if err := inodes.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	inodes, err = libfs.OpenInodeTable(tempdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inodes.Close()
+	mnt, _, cancelFn = makeFSWithPlatformParams(
+		t, ctx, config, inodes, params)
+	defer mnt.Close()
+	defer cancelFn()
+
+	// The kernel hasn't seen any of these nodes since the remount,
+	// so it has to ask the new server for them.
+	f, err := openByHandle(mnt.Dir, fileHandle, os.O_RDONLY)
+	if err == syscall.EPERM {
+		t.Skip("Opening by handle needs CAP_DAC_READ_SEARCH")
+	} else if err != nil {
+		t.Fatalf("Couldn't open file by handle: %v", err)
+	}
+	defer f.Close()
+	buf := make([]byte, len(input)+1)
+	n, err := f.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := string(buf[:n]), input; g != e {
+		t.Errorf("read wrong content: %q != %q", g, e)
+	}
+
+	// Opening a directory makes the kernel connect it to the root,
+	// by looking up ".." and reading the parents.
+	d, err := openByHandle(
+		mnt.Dir, dirHandle, os.O_RDONLY|syscall.O_DIRECTORY)
+	if err != nil {
+		t.Fatalf("Couldn't open dir by handle: %v", err)
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "f" {
+		t.Errorf("read wrong entries: %v", names)
+	}
+
+	_, err = openByHandle(mnt.Dir, removedHandle, os.O_RDONLY)
+	if err != syscall.ESTALE {
+		t.Errorf("Opening a removed file by handle gave %v, not ESTALE",
+			err)
+	}
+}
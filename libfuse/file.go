@@ -8,9 +8,12 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
 	"golang.org/x/net/context"
 )
@@ -59,13 +62,20 @@ type File struct {
 	node   libkbfs.Node
 
 	eiCache eiCacheHolder
+
+	// handles tracks the handles open on this file, which are all
+	// the file itself.
+	handles libfs.NodeHandles
+
+	atime libfs.AccessTime
 }
 
 var _ fs.Node = (*File)(nil)
 
 func (f *File) fillAttrWithMode(
 	ctx context.Context, ei *libkbfs.EntryInfo, a *fuse.Attr) (err error) {
-	if err = f.folder.fillAttrWithUIDAndWritePerm(ctx, ei, a); err != nil {
+	err = f.folder.fillAttrWithUIDAndWritePerm(ctx, ei, f.atime.Get(), a)
+	if err != nil {
 		return err
 	}
 	a.Mode |= 0400
@@ -83,6 +93,8 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 
 	f.folder.fs.log.CDebugf(ctx, "File Attr")
 	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
 
 	if reqID, ok := ctx.Value(CtxIDKey).(string); ok {
 		if ei := f.eiCache.getAndDestroyIfMatches(reqID); ei != nil {
@@ -173,6 +185,8 @@ var _ fs.NodeFsyncer = (*File)(nil)
 
 func (f *File) sync(ctx context.Context) error {
 	f.eiCache.destroy()
+	ctx = libkbfs.NewContextWithFsyncDurability(
+		ctx, f.handles.FsyncDurability())
 	err := f.folder.fs.config.KBFSOps().Sync(ctx, f.node)
 	if err != nil {
 		return err
@@ -189,6 +203,11 @@ func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) (err error) {
 
 	f.folder.fs.log.CDebugf(ctx, "File Fsync")
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
+	if err := f.checkOpen(); err != nil {
+		return err
+	}
 
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
@@ -200,8 +219,45 @@ func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) (err error) {
 	return f.sync(ctx)
 }
 
+var _ fs.NodeOpener = (*File)(nil)
+
+// Open implements the fs.NodeOpener interface for File.
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest,
+	resp *fuse.OpenResponse) (fs.Handle, error) {
+	f.open(req.Pid, req.Flags)
+	return f, nil
+}
+
+// open registers a new handle on f with the FS.
+func (f *File) open(pid uint32, flags fuse.OpenFlags) {
+	f.handles.Open(f.folder.fs.handles, libfs.OpenHandle{
+		TLF:    f.folder.getFolderBranch().Tlf,
+		Folder: string(f.folder.name()),
+		Name:   f.node.GetBasename(),
+		PID:    pid,
+		Write:  !flags.IsReadOnly(),
+		Sync:   flags&fuse.OpenSync != 0,
+	})
+}
+
+// checkOpen returns an error if a handle on f has been force-closed.
+func (f *File) checkOpen() error {
+	if f.handles.Closed() {
+		return fuse.Errno(syscall.EBADF)
+	}
+	return nil
+}
+
 var _ fs.Handle = (*File)(nil)
 
+var _ fs.HandleReleaser = (*File)(nil)
+
+// Release implements the fs.HandleReleaser interface for File.
+func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	f.handles.Release(f.folder.fs.handles)
+	return nil
+}
+
 var _ fs.HandleReader = (*File)(nil)
 
 // Read implements the fs.HandleReader interface for File.
@@ -214,17 +270,47 @@ func (f *File) Read(ctx context.Context, req *fuse.ReadRequest,
 	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
 
 	f.folder.fs.log.CDebugf(ctx, "File Read off=%d sz=%d", off, sz)
+	if err := f.folder.fs.checkProcess(ctx, "read"); err != nil {
+		return err
+	}
 	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
+	if err := f.checkOpen(); err != nil {
+		return err
+	}
 
+	// Read straight into the reply message, which is then sent to
+	// the kernel without another copy.
 	n, err := f.folder.fs.config.KBFSOps().Read(
 		ctx, f.node, resp.Data[:sz], off)
 	if err != nil {
 		return err
 	}
 	resp.Data = resp.Data[:n]
+	f.updateAtime(ctx)
 	return nil
 }
 
+// updateAtime updates the in-memory access time of f after a read,
+// as the FS's time policy decides.
+func (f *File) updateAtime(ctx context.Context) {
+	policy := f.folder.fs.timePolicy
+	if policy.Atime == libfs.NoAtime {
+		return
+	}
+	ei, err := f.folder.fs.config.KBFSOps().Stat(ctx, f.node)
+	if err != nil {
+		// The read itself succeeded, so just leave the
+		// access time alone.
+		f.folder.fs.log.CDebugf(ctx, "Couldn't stat for atime: %+v", err)
+		return
+	}
+	now := time.Now()
+	mtime, ctime, _ := policy.Times(ei, time.Time{}, now)
+	f.atime.OnRead(policy, mtime, ctime, now)
+}
+
 var _ fs.HandleWriter = (*File)(nil)
 
 // Write implements the fs.HandleWriter interface for File.
@@ -237,6 +323,11 @@ func (f *File) Write(ctx context.Context, req *fuse.WriteRequest,
 
 	f.folder.fs.log.CDebugf(ctx, "File Write sz=%d ", sz)
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
+	if err := f.checkOpen(); err != nil {
+		return err
+	}
 
 	f.eiCache.destroy()
 	if err := f.folder.fs.config.KBFSOps().Write(
@@ -259,6 +350,11 @@ func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) (err error) {
 	// I'm not sure about the guarantees from KBFSOps, so we don't
 	// differentiate between Flush and Fsync.
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
+	if err := f.checkOpen(); err != nil {
+		return err
+	}
 
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
@@ -282,6 +378,8 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest,
 
 	f.folder.fs.log.CDebugf(ctx, "File SetAttr %s", valid)
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	ctx, done := f.folder.beginOp(ctx)
+	defer done()
 
 	f.eiCache.destroy()
 
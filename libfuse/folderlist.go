@@ -13,6 +13,7 @@ import (
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
 	"golang.org/x/net/context"
 )
@@ -33,6 +34,15 @@ type FolderList struct {
 
 var _ fs.NodeAccesser = (*FolderList)(nil)
 
+// inode returns the inode number of this folder list.
+func (fl *FolderList) inode(ctx context.Context) uint64 {
+	name := PrivateName
+	if fl.public {
+		name = PublicName
+	}
+	return fl.fs.inode(ctx, libfs.RootInode, name)
+}
+
 // Access implements fs.NodeAccesser interface for *FolderList.
 func (*FolderList) Access(ctx context.Context, r *fuse.AccessRequest) error {
 	if int(r.Uid) != os.Getuid() &&
@@ -198,7 +208,8 @@ func (fl *FolderList) Lookup(ctx context.Context, req *fuse.LookupRequest, resp
 	if err != nil {
 		return nil, err
 	}
-	child := newTLF(fl, h, h.GetPreferredFormat(session.Name))
+	child := newTLF(fl, h, h.GetPreferredFormat(session.Name),
+		fl.fs.inode(ctx, fl.inode(ctx), req.Name))
 	fl.folders[req.Name] = child
 	return child, nil
 }
@@ -327,8 +338,36 @@ func (fl *FolderList) updateTlfName(ctx context.Context, oldName string,
 	}
 }
 
+// forgetAllFolders forgets every folder in the list, and tells the
+// kernel to forget them too.
+func (fl *FolderList) forgetAllFolders(ctx context.Context) {
+	var names []string
+	func() {
+		fl.mu.Lock()
+		defer fl.mu.Unlock()
+		for name := range fl.folders {
+			names = append(names, name)
+		}
+		fl.folders = make(map[string]*TLF)
+	}()
+	for _, name := range names {
+		if err := fl.fs.fuse.InvalidateEntry(fl, name); err != nil && err != fuse.ErrNotCached {
+			fl.fs.log.CErrorf(ctx, "FUSE invalidate error for %s: %v",
+				name, err)
+		}
+	}
+}
+
 // update things after user changed.
-func (fl *FolderList) userChanged(ctx context.Context, _, newUser libkb.NormalizedUsername) {
+func (fl *FolderList) userChanged(ctx context.Context, oldUser, newUser libkb.NormalizedUsername) {
+	if !fl.public && oldUser != "" && oldUser != newUser {
+		// The previous user's private folders must not stay
+		// reachable through nodes looked up on their behalf, and
+		// libkbfs shuts them down anyway once someone else logs in.
+		fl.fs.log.CDebugf(ctx, "Forgetting the private folders of %s", oldUser)
+		fl.forgetAllFolders(ctx)
+		return
+	}
 	var fs []*Folder
 	func() {
 		fl.mu.Lock()
@@ -0,0 +1,55 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// FreezeFile represents a write-only file where any write of at
+// least one byte either freezes the folder (marks it read-only for
+// all devices) or unfreezes it.
+type FreezeFile struct {
+	folder *Folder
+	freeze bool
+}
+
+var _ fs.Node = (*FreezeFile)(nil)
+
+// Attr implements the fs.Node interface for FreezeFile.
+func (f *FreezeFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*FreezeFile)(nil)
+
+var _ fs.HandleWriter = (*FreezeFile)(nil)
+
+// Write implements the fs.HandleWriter interface for FreezeFile.
+func (f *FreezeFile) Write(ctx context.Context, req *fuse.WriteRequest,
+	resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "FreezeFile (freeze: %t) Write", f.freeze)
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+	err = f.folder.fs.config.KBFSOps().SetTlfFrozen(
+		ctx, f.folder.getFolderBranch(), f.freeze)
+	if err != nil {
+		return err
+	}
+	if f.freeze {
+		// Files open for writing can't be written anymore.
+		f.folder.fs.handles.ForceCloseTLF(
+			f.folder.getFolderBranch().Tlf, true)
+	}
+	resp.Size = len(req.Data)
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -21,6 +22,7 @@ import (
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/sysutils"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	"golang.org/x/net/trace"
@@ -54,7 +56,38 @@ type FS struct {
 
 	platformParams PlatformParams
 
+	// nameConflicts decides which entry names the kernel (or the
+	// applications above it) can't tell apart.  It is set before
+	// serving, and never changed after.
+	nameConflicts libfs.NameConflictPolicy
+
+	// processPolicy, if non-nil, decides which processes may use
+	// the mount.  It is set before serving, and never changed
+	// after.
+	processPolicy *libfs.ProcessPolicy
+
 	quotaUsage *libkbfs.EventuallyConsistentQuotaUsage
+
+	// ops tracks the requests in flight on each TLF, so that they
+	// can be aborted.
+	ops *libfs.OpRegistry
+
+	// handles tracks the open files, so that they can be listed
+	// and force-closed.
+	handles *libfs.OpenHandleRegistry
+
+	// timePolicy decides how file times are reported.  It is set
+	// before serving, and never changed after.
+	timePolicy libfs.TimePolicy
+
+	// localObjects, if true, lets FIFOs and sockets be created on
+	// the mount, backed by local objects.  It is set before
+	// serving, and never changed after.
+	localObjects bool
+
+	// inodes assigns the inode numbers of the entries on the
+	// mount.  It is set before serving, and never changed after.
+	inodes *libfs.InodeTable
 }
 
 func makeTraceHandler(renderFn func(http.ResponseWriter, *http.Request, bool)) func(http.ResponseWriter, *http.Request) {
@@ -113,7 +146,11 @@ func NewFS(config libkbfs.Config, conn *fuse.Conn, debug bool, platformParams Pl
 		debugServer:    debugServer,
 		notifications:  libfs.NewFSNotifications(log),
 		platformParams: platformParams,
+		nameConflicts:  libfs.ExactNames,
 		quotaUsage:     libkbfs.NewEventuallyConsistentQuotaUsage(config, "FS"),
+		ops:            libfs.NewOpRegistry(),
+		handles:        libfs.NewOpenHandleRegistry(),
+		inodes:         libfs.NewInodeTable(),
 	}
 	fs.root.private = &FolderList{
 		fs:      fs,
@@ -214,6 +251,110 @@ func (f *FS) SetFuseConn(fuse *fs.Server, conn *fuse.Conn) {
 	f.conn = conn
 }
 
+// SetNameConflictPolicy sets the policy for showing entries whose
+// names the platform can't tell apart.  It must be called before
+// Serve.
+func (f *FS) SetNameConflictPolicy(policy libfs.NameConflictPolicy) {
+	f.nameConflicts = policy
+}
+
+// SetProcessPolicy sets the policy deciding which processes may use
+// the mount.  It must be called before Serve.
+func (f *FS) SetProcessPolicy(policy *libfs.ProcessPolicy) {
+	f.processPolicy = policy
+}
+
+// SetTimePolicy sets the policy deciding how file times are
+// reported.  It must be called before Serve.
+func (f *FS) SetTimePolicy(policy libfs.TimePolicy) {
+	f.timePolicy = policy
+}
+
+// SetLocalObjects sets whether FIFOs and sockets can be created on
+// the mount, as mount-local objects recorded in the TLF by
+// placeholders.  It must be called before Serve.
+func (f *FS) SetLocalObjects(enabled bool) {
+	f.localObjects = enabled
+}
+
+// localObjectMode returns the mode of the local object that the
+// entry ei is a placeholder for, or false if it isn't one or local
+// objects aren't enabled.
+func (f *FS) localObjectMode(ei libkbfs.EntryInfo) (os.FileMode, bool) {
+	if !f.localObjects || ei.Type != libkbfs.Sym {
+		return 0, false
+	}
+	return libfs.ParseLocalObjectTarget(ei.SymPath)
+}
+
+// SetOpRegistry sets the registry tracking the requests in flight on
+// each TLF, so that they can be aborted from outside the FS.  It must
+// be called before Serve.
+func (f *FS) SetOpRegistry(ops *libfs.OpRegistry) {
+	f.ops = ops
+}
+
+// SetOpenHandleRegistry sets the registry tracking the open files, so
+// that they can be force-closed from outside the FS.  It must be
+// called before Serve.
+func (f *FS) SetOpenHandleRegistry(handles *libfs.OpenHandleRegistry) {
+	f.handles = handles
+}
+
+// SetInodeTable sets the table assigning the inode numbers of the
+// entries on the mount, e.g. to one saved on disk so that they
+// survive remounts.  It must be called before Serve.
+func (f *FS) SetInodeTable(inodes *libfs.InodeTable) {
+	f.inodes = inodes
+}
+
+// inode returns the inode number of the entry with the given name in
+// the directory with the given inode number.  If the inode table
+// fails, it falls back to a number derived from the parent and name,
+// as if there were no table.
+func (f *FS) inode(ctx context.Context, parent uint64, name string) uint64 {
+	inode, err := f.inodes.Inode(parent, name)
+	if err != nil {
+		f.log.CWarningf(ctx, "Couldn't get inode for %q in %d: %+v",
+			name, parent, err)
+		return fs.GenerateDynamicInode(parent, name)
+	}
+	return inode
+}
+
+// renameInode moves the inode number of a renamed entry.
+func (f *FS) renameInode(ctx context.Context, oldParent uint64,
+	oldName string, newParent uint64, newName string) {
+	err := f.inodes.Rename(oldParent, oldName, newParent, newName)
+	if err != nil {
+		f.log.CWarningf(ctx, "Couldn't rename inode for %q in %d: %+v",
+			oldName, oldParent, err)
+	}
+}
+
+// removeInode forgets the inode number of a removed entry.
+func (f *FS) removeInode(ctx context.Context, parent uint64, name string) {
+	err := f.inodes.Remove(parent, name)
+	if err != nil {
+		f.log.CWarningf(ctx, "Couldn't remove inode for %q in %d: %+v",
+			name, parent, err)
+	}
+}
+
+// GenerateInode implements the fs.FSInodeGenerator interface for FS.
+func (f *FS) GenerateInode(parentInode uint64, name string) uint64 {
+	return f.inode(context.Background(), parentInode, name)
+}
+
+func (f *FS) nameConflictView(
+	children map[string]libkbfs.EntryInfo) libfs.NameConflictView {
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	return libfs.MakeNameConflictView(f.nameConflicts, names)
+}
+
 // NotificationGroupWait - wait on the notification group.
 func (f *FS) NotificationGroupWait() {
 	f.notifications.Wait()
@@ -298,10 +439,40 @@ func (f *FS) maybeFinishTrace(ctx context.Context, err error) {
 	}
 }
 
+// withProcessName tags ctx with the name of the process that made
+// req, so that the server usage it causes can be attributed to it.
+func (f *FS) withProcessName(
+	ctx context.Context, req fuse.Request) context.Context {
+	pid := req.Hdr().Pid
+	execPath, err := sysutils.GetExecPathFromPID(pid)
+	if err != nil {
+		f.log.CDebugf(ctx, "Getting exec path for PID %d error: %v",
+			pid, err)
+		return ctx
+	}
+	return libkbfs.NewContextWithProcessName(ctx, filepath.Base(execPath))
+}
+
 // Serve FS. Will block.
 func (f *FS) Serve(ctx context.Context) error {
+	if f.platformParams.exportsOverNFS() {
+		return f.serve(ctx, exportFS{f})
+	}
+	return f.serve(ctx, f)
+}
+
+// serve serves the given file system, which is f or a view of it,
+// until it's unmounted.
+func (f *FS) serve(ctx context.Context, filesys fs.FS) error {
 	srv := fs.New(f.conn, &fs.Config{
-		WithContext: func(ctx context.Context, _ fuse.Request) context.Context {
+		WithContext: func(ctx context.Context, req fuse.Request) context.Context {
+			if f.config.TLFUsageByProcess() {
+				ctx = f.withProcessName(ctx, req)
+			}
+			if f.processPolicy != nil {
+				ctx = context.WithValue(
+					ctx, ctxProcessIDKey, req.Hdr().Pid)
+			}
 			return f.WithContext(ctx)
 		},
 	})
@@ -311,12 +482,17 @@ func (f *FS) Serve(ctx context.Context) error {
 	f.remoteStatus.Init(ctx, f.log, f.config, f)
 	// Blocks forever, unless an interrupt signal is received
 	// (handled by libkbfs.Init).
-	return srv.Serve(f)
+	return srv.Serve(filesys)
 }
 
 // UserChanged is called from libfs.
 func (f *FS) UserChanged(ctx context.Context, oldName, newName libkb.NormalizedUsername) {
 	f.log.CDebugf(ctx, "User changed: %q -> %q", oldName, newName)
+	if oldName != "" {
+		// The old user's files shouldn't stay usable.
+		n := f.handles.ForceCloseAll()
+		f.log.CDebugf(ctx, "Force-closed %d open files", n)
+	}
 	f.root.public.userChanged(ctx, oldName, newName)
 	f.root.private.userChanged(ctx, oldName, newName)
 }
@@ -327,6 +503,8 @@ var _ fs.FS = (*FS)(nil)
 
 var _ fs.FSStatfser = (*FS)(nil)
 
+var _ fs.FSInodeGenerator = (*FS)(nil)
+
 func (f *FS) reportErr(ctx context.Context,
 	mode libkbfs.ErrorModeType, err error) {
 	if err == nil {
@@ -423,6 +601,10 @@ func (r *Root) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.L
 		return specialNode, nil
 	}
 
+	if libfs.IsIndexerOptOutFileName(req.Name) {
+		return &SpecialReadFile{read: libfs.ReadEmpty}, nil
+	}
+
 	platformNode, err := r.platformLookup(ctx, req, resp)
 	if platformNode != nil || err != nil {
 		return platformNode, err
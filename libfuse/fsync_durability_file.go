@@ -0,0 +1,59 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// FsyncDurabilityFile is a special file used to set what fsync
+// guarantees for files in a TLF.
+type FsyncDurabilityFile struct {
+	folder *Folder
+}
+
+var _ fs.Node = (*FsyncDurabilityFile)(nil)
+
+// Attr implements the fs.Node interface for FsyncDurabilityFile.
+func (f *FsyncDurabilityFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*FsyncDurabilityFile)(nil)
+
+var _ fs.HandleWriter = (*FsyncDurabilityFile)(nil)
+
+// Write implements the fs.HandleWriter interface for
+// FsyncDurabilityFile.
+func (f *FsyncDurabilityFile) Write(ctx context.Context,
+	req *fuse.WriteRequest, resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "FsyncDurabilityFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+
+	durability, err := libkbfs.ParseFsyncDurability(
+		strings.TrimSpace(string(req.Data)))
+	if err != nil {
+		return err
+	}
+
+	err = f.folder.fs.config.KBFSOps().SetFsyncDurability(
+		ctx, f.folder.getFolderBranch(), durability)
+	if err != nil {
+		return err
+	}
+
+	resp.Size = len(req.Data)
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+// handoffSocketName is the name of the Unix socket, in the runtime
+// directory, on which a running kbfsfuse listens for a newer
+// instance that wants to take over its mount.
+const handoffSocketName = "kbfs.handoff"
+
+// handoffTimeout bounds how long a new instance waits for the
+// running one to drain its in-flight operations and release its
+// mount and local state.
+const handoffTimeout = 2 * time.Minute
+
+// handoffRequest is sent by the instance that wants to take over.
+type handoffRequest struct {
+	PID     int
+	Version string
+}
+
+// handoffReply is sent back by the running instance.  If Released is
+// false, the running instance refused to hand off (e.g., because
+// files are still open in the mount) and keeps serving; otherwise it
+// has unmounted and shut down, and Error describes anything that
+// went wrong while shutting down.
+type handoffReply struct {
+	Released bool
+	Error    string `json:",omitempty"`
+}
+
+func handoffSocketPath(runtimeDir string) string {
+	return filepath.Join(runtimeDir, handoffSocketName)
+}
+
+// requestHandoff asks the kbfsfuse instance using the given runtime
+// directory to drain its in-flight operations, unmount, and release
+// its journals and disk caches, so that this instance can mount in
+// its place.  It returns nil right away if no instance is running.
+//
+// TODO: Hand the FUSE connection itself over (e.g., passing the
+// /dev/fuse descriptor over the socket), so that open file handles
+// survive the handoff.  bazil.org/fuse can't yet serve a connection
+// it didn't mount, so for now the running instance refuses to hand
+// off while anything is open in its mount.
+func requestHandoff(runtimeDir string, log logger.Logger) error {
+	conn, err := net.Dial("unix", handoffSocketPath(runtimeDir))
+	if err != nil {
+		log.Debug("No running instance to take over from: %v", err)
+		return nil
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(handoffTimeout))
+	if err != nil {
+		return err
+	}
+
+	log.Debug("Asking the running instance to hand off its mount")
+	err = json.NewEncoder(conn).Encode(handoffRequest{
+		PID:     os.Getpid(),
+		Version: libkbfs.VersionString(),
+	})
+	if err != nil {
+		return err
+	}
+	var reply handoffReply
+	err = json.NewDecoder(conn).Decode(&reply)
+	if err != nil {
+		return errors.Wrap(err, "Couldn't get a handoff reply")
+	}
+	if !reply.Released {
+		return errors.Errorf(
+			"The running instance refused to hand off: %s", reply.Error)
+	}
+	if reply.Error != "" {
+		log.Warning("The running instance had trouble shutting down: %s",
+			reply.Error)
+	}
+	log.Debug("The running instance has released its mount")
+	return nil
+}
+
+// handoffListener waits for a newer kbfsfuse instance to request a
+// handoff.  Once one does, it calls release to unmount, which drains
+// the in-flight operations and makes the FUSE server return; the
+// caller must then shut down and call finish.
+type handoffListener struct {
+	log      logger.Logger
+	listener net.Listener
+	release  func() error
+
+	lock    sync.Mutex
+	pending net.Conn
+}
+
+// listenForHandoffs starts listening for handoff requests in the
+// given runtime directory.
+func listenForHandoffs(runtimeDir string, log logger.Logger,
+	release func() error) (*handoffListener, error) {
+	p := handoffSocketPath(runtimeDir)
+	// A socket left behind by an instance that didn't exit
+	// cleanly would make listening fail.
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", p)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(p, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	h := &handoffListener{
+		log:      log,
+		listener: l,
+		release:  release,
+	}
+	go h.accept()
+	return h, nil
+}
+
+func (h *handoffListener) reply(conn net.Conn, reply handoffReply) {
+	err := json.NewEncoder(conn).Encode(reply)
+	if err != nil {
+		h.log.Debug("Couldn't send handoff reply: %v", err)
+	}
+}
+
+func (h *handoffListener) accept() {
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			// The listener was closed.
+			return
+		}
+		var req handoffRequest
+		err = json.NewDecoder(conn).Decode(&req)
+		if err != nil {
+			h.log.Debug("Bad handoff request: %v", err)
+			conn.Close()
+			continue
+		}
+		h.log.Debug("Handoff requested by pid %d (version %s)",
+			req.PID, req.Version)
+
+		h.lock.Lock()
+		h.pending = conn
+		h.lock.Unlock()
+		err = h.release()
+		if err != nil {
+			h.log.Debug("Refusing handoff: %v", err)
+			h.lock.Lock()
+			h.pending = nil
+			h.lock.Unlock()
+			h.reply(conn, handoffReply{Error: err.Error()})
+			conn.Close()
+			continue
+		}
+		return
+	}
+}
+
+// requested returns true if a handoff was requested and the mount
+// released for it.
+func (h *handoffListener) requested() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.pending != nil
+}
+
+// finish tells the new instance that the handoff is complete, with
+// the given shutdown error, if any.
+func (h *handoffListener) finish(shutdownErr error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.pending == nil {
+		return
+	}
+	reply := handoffReply{Released: true}
+	if shutdownErr != nil {
+		reply.Error = shutdownErr.Error()
+	}
+	h.reply(h.pending, reply)
+	h.pending.Close()
+	h.pending = nil
+}
+
+// Close stops listening for handoff requests.
+func (h *handoffListener) Close() error {
+	return h.listener.Close()
+}
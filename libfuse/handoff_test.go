@@ -0,0 +1,56 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandoff(t *testing.T) {
+	runtimeDir, err := ioutil.TempDir(os.TempDir(), "kbfs_handoff")
+	require.NoError(t, err)
+	defer os.RemoveAll(runtimeDir)
+	log := logger.NewTestLogger(t)
+
+	// Nothing to take over from.
+	err = requestHandoff(runtimeDir, log)
+	require.NoError(t, err)
+
+	// The first attempt fails to release, e.g. because of open
+	// files; the second one succeeds.
+	releaseErr := errors.New("files still open")
+	releaseErrs := make(chan error, 2)
+	releaseErrs <- releaseErr
+	releaseErrs <- nil
+	released := make(chan struct{}, 2)
+	h, err := listenForHandoffs(runtimeDir, log, func() error {
+		defer func() { released <- struct{}{} }()
+		return <-releaseErrs
+	})
+	require.NoError(t, err)
+	defer h.Close()
+
+	err = requestHandoff(runtimeDir, log)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), releaseErr.Error())
+	<-released
+	require.False(t, h.requested())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- requestHandoff(runtimeDir, log)
+	}()
+	<-released
+	require.True(t, h.requested())
+	h.finish(nil)
+	require.NoError(t, <-done)
+	require.False(t, h.requested())
+}
@@ -32,17 +32,34 @@ import (
 
 func makeFS(t testing.TB, ctx context.Context, config *libkbfs.ConfigLocal) (
 	*fstestutil.Mount, *FS, func()) {
+	return makeFSWithInodeTable(t, ctx, config, libfs.NewInodeTable())
+}
+
+func makeFSWithInodeTable(t testing.TB, ctx context.Context,
+	config *libkbfs.ConfigLocal, inodes *libfs.InodeTable) (
+	*fstestutil.Mount, *FS, func()) {
+	return makeFSWithPlatformParams(t, ctx, config, inodes, PlatformParams{})
+}
+
+func makeFSWithPlatformParams(t testing.TB, ctx context.Context,
+	config *libkbfs.ConfigLocal, inodes *libfs.InodeTable,
+	platformParams PlatformParams) (*fstestutil.Mount, *FS, func()) {
 	log := logger.NewTestLogger(t)
 	debugLog := log.CloneWithAddedDepth(1)
 	fuse.Debug = MakeFuseDebugFn(debugLog, false /* superVerbose */)
 
 	// TODO duplicates main() in kbfsfuse/main.go too much
 	filesys := &FS{
-		config:        config,
-		log:           log,
-		errLog:        log,
-		notifications: libfs.NewFSNotifications(log),
-		quotaUsage:    libkbfs.NewEventuallyConsistentQuotaUsage(config, "FSTest"),
+		config:         config,
+		log:            log,
+		errLog:         log,
+		notifications:  libfs.NewFSNotifications(log),
+		nameConflicts:  libfs.ExactNames,
+		quotaUsage:     libkbfs.NewEventuallyConsistentQuotaUsage(config, "FSTest"),
+		ops:            libfs.NewOpRegistry(),
+		handles:        libfs.NewOpenHandleRegistry(),
+		inodes:         inodes,
+		platformParams: platformParams,
 	}
 	filesys.root.private = &FolderList{
 		fs:      filesys,
@@ -59,9 +76,15 @@ func makeFS(t testing.TB, ctx context.Context, config *libkbfs.ConfigLocal) (
 	fn := func(mnt *fstestutil.Mount) fs.FS {
 		filesys.fuse = mnt.Server
 		filesys.conn = mnt.Conn
+		if platformParams.exportsOverNFS() {
+			return exportFS{filesys}
+		}
 		return filesys
 	}
 	options := GetPlatformSpecificMountOptionsForTest()
+	if platformParams.exportsOverNFS() {
+		options = append(options, fuse.ExportSupport())
+	}
 	mnt, err := fstestutil.MountedFuncT(t, fn, &fs.Config{
 		WithContext: func(ctx context.Context, req fuse.Request) context.Context {
 			return filesys.WithContext(ctx)
@@ -922,33 +945,18 @@ func TestRenameCrossFolder(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err := ioutil.Rename(p1, p2)
-	if err == nil {
-		t.Fatalf("expected an error from rename: %v", err)
-	}
-	lerr, ok := errors.Cause(err).(*os.LinkError)
-	if !ok {
-		t.Fatalf("expected a LinkError from rename: %v", err)
-	}
-	if g, e := lerr.Op, "rename"; g != e {
-		t.Errorf("wrong LinkError.Op: %q != %q", g, e)
-	}
-	if g, e := lerr.Old, p1; g != e {
-		t.Errorf("wrong LinkError.Old: %q != %q", g, e)
-	}
-	if g, e := lerr.New, p2; g != e {
-		t.Errorf("wrong LinkError.New: %q != %q", g, e)
-	}
-	if g, e := lerr.Err, syscall.EXDEV; g != e {
-		t.Errorf("expected EXDEV: %T %v", lerr.Err, lerr.Err)
+	if err := ioutil.Rename(p1, p2); err != nil {
+		t.Fatal(err)
 	}
 
-	checkDir(t, path.Join(mnt.Dir, PrivateName, "jdoe"), map[string]fileInfoCheck{
-		"old": nil,
+	checkDir(t, path.Join(mnt.Dir, PrivateName, "jdoe"), map[string]fileInfoCheck{})
+	checkDir(t, path.Join(mnt.Dir, PrivateName, "wsmith,jdoe"), map[string]fileInfoCheck{
+		"new": func(fi os.FileInfo) error {
+			return mustBeFileWithSize(fi, int64(len(input)))
+		},
 	})
-	checkDir(t, path.Join(mnt.Dir, PrivateName, "wsmith,jdoe"), map[string]fileInfoCheck{})
 
-	buf, err := ioutil.ReadFile(p1)
+	buf, err := ioutil.ReadFile(p2)
 	if err != nil {
 		t.Errorf("read error: %v", err)
 	}
@@ -956,8 +964,8 @@ func TestRenameCrossFolder(t *testing.T) {
 		t.Errorf("bad file contents: %q != %q", g, e)
 	}
 
-	if _, err := ioutil.ReadFile(p2); !ioutil.IsNotExist(err) {
-		t.Errorf("new name exists even on error: %v", err)
+	if _, err := ioutil.ReadFile(p1); !ioutil.IsNotExist(err) {
+		t.Errorf("old name still exists: %v", err)
 	}
 }
 
@@ -1181,6 +1189,40 @@ func TestRemoveDirNotEmpty(t *testing.T) {
 	}
 }
 
+func TestRemoveDirTree(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	mnt, _, cancelFn := makeFS(t, ctx, config)
+	defer mnt.Close()
+	defer cancelFn()
+
+	p := path.Join(mnt.Dir, PrivateName, "jdoe", "mydir")
+	if err := ioutil.Mkdir(p, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.Mkdir(path.Join(p, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	pFile := path.Join(p, "sub", "myfile")
+	if err := ioutil.WriteFile(pFile, []byte("i'm not important"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	removeTree := path.Join(mnt.Dir, PrivateName, "jdoe",
+		libfs.RemoveTreePrefix+"mydir")
+	if err := ioutil.WriteFile(removeTree, []byte("1"), 0222); err != nil {
+		t.Fatal(err)
+	}
+
+	checkDir(t, path.Join(mnt.Dir, PrivateName, "jdoe"), map[string]fileInfoCheck{})
+
+	if _, err := ioutil.Stat(p); !ioutil.IsNotExist(err) {
+		t.Errorf("dir still exists: %v", err)
+	}
+}
+
 func TestRemoveFileWhileOpenSetEx(t *testing.T) {
 	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
 	defer libkbfs.CleanupCancellationDelayer(ctx)
@@ -3682,3 +3724,134 @@ func TestKbfsFileInfo(t *testing.T) {
 		t.Fatalf("Expected user1, %v raw %X", dst, bs)
 	}
 }
+
+func inodeOf(t *testing.T, p string) uint64 {
+	fi, err := ioutil.Lstat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Sys().(*syscall.Stat_t).Ino
+}
+
+func TestInodesSurviveRenameAndRemount(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "inodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ioutil.RemoveAll(tempdir)
+
+	inodes, err := libfs.OpenInodeTable(tempdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt, _, cancelFn := makeFSWithInodeTable(t, ctx, config, inodes)
+	root := path.Join(mnt.Dir, PrivateName, "jdoe")
+	if err := ioutil.Mkdir(path.Join(root, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(
+		path.Join(root, "d", "f"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dirInode := inodeOf(t, path.Join(root, "d"))
+	fileInode := inodeOf(t, path.Join(root, "d", "f"))
+	if err := ioutil.Rename(
+		path.Join(root, "d"), path.Join(root, "e")); err != nil {
+		t.Fatal(err)
+	}
+	if g, e := inodeOf(t, path.Join(root, "e")), dirInode; g != e {
+		t.Errorf("renamed dir inode %d != %d", g, e)
+	}
+	cancelFn()
+	mnt.Close()
+	if err := inodes.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	inodes, err = libfs.OpenInodeTable(tempdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inodes.Close()
+	mnt, _, cancelFn = makeFSWithInodeTable(t, ctx, config, inodes)
+	defer mnt.Close()
+	defer cancelFn()
+	root = path.Join(mnt.Dir, PrivateName, "jdoe")
+	if g, e := inodeOf(t, path.Join(root, "e")), dirInode; g != e {
+		t.Errorf("remounted dir inode %d != %d", g, e)
+	}
+	if g, e := inodeOf(t, path.Join(root, "e", "f")), fileInode; g != e {
+		t.Errorf("remounted file inode %d != %d", g, e)
+	}
+
+	// A new file under the same name gets a new number.
+	if err := ioutil.Remove(path.Join(root, "e", "f")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(
+		path.Join(root, "e", "f"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if inodeOf(t, path.Join(root, "e", "f")) == fileInode {
+		t.Errorf("recreated file reused inode %d", fileInode)
+	}
+}
+
+func TestInodeForgottenAfterRemoteRemove(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config1 := libkbfs.MakeTestConfigOrBust(t, "user1", "user2")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config1)
+	inodes := libfs.NewInodeTable()
+	defer inodes.Close()
+	mnt1, fs1, cancelFn1 := makeFSWithInodeTable(t, ctx, config1, inodes)
+	defer mnt1.Close()
+	defer cancelFn1()
+
+	config2 := libkbfs.ConfigAsUser(config1, "user2")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config2)
+	mnt2, _, cancelFn2 := makeFS(t, ctx, config2)
+	defer mnt2.Close()
+	defer cancelFn2()
+
+	if !mnt1.Conn.Protocol().HasInvalidate() {
+		t.Skip("Old FUSE protocol")
+	}
+
+	root1 := path.Join(mnt1.Dir, PrivateName, "user1,user2")
+	if err := ioutil.Mkdir(path.Join(root1, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.Mkdir(path.Join(root1, "d", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dirInode := inodeOf(t, path.Join(root1, "d"))
+	subInode := inodeOf(t, path.Join(root1, "d", "sub"))
+
+	root2 := path.Join(mnt2.Dir, PrivateName, "user1,user2")
+	if err := ioutil.Remove(path.Join(root2, "d", "sub")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.Remove(path.Join(root2, "d")); err != nil {
+		t.Fatal(err)
+	}
+	syncFolderToServer(t, "user1,user2", fs1)
+
+	// New entries under the old names get new numbers.
+	if err := ioutil.Mkdir(path.Join(root1, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.Mkdir(path.Join(root1, "d", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if inodeOf(t, path.Join(root1, "d")) == dirInode {
+		t.Errorf("recreated dir reused inode %d", dirInode)
+	}
+	if inodeOf(t, path.Join(root1, "d", "sub")) == subInode {
+		t.Errorf("recreated subdir reused inode %d", subInode)
+	}
+}
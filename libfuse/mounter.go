@@ -130,6 +130,9 @@ func fuseMountDir(dir string, platformParams PlatformParams) (*fuse.Conn, error)
 	if err != nil {
 		return nil, err
 	}
+	if platformParams.exportsOverNFS() {
+		options = append(options, fuse.ExportSupport())
+	}
 	c, err := fuse.Mount(dir, options...)
 	if err != nil {
 		err = translatePlatformSpecificError(err, platformParams)
@@ -0,0 +1,59 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// NameNormalizationFile is a special file used to set the unicode
+// normalization form of new entry names in a TLF.
+type NameNormalizationFile struct {
+	folder *Folder
+}
+
+var _ fs.Node = (*NameNormalizationFile)(nil)
+
+// Attr implements the fs.Node interface for NameNormalizationFile.
+func (f *NameNormalizationFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*NameNormalizationFile)(nil)
+
+var _ fs.HandleWriter = (*NameNormalizationFile)(nil)
+
+// Write implements the fs.HandleWriter interface for
+// NameNormalizationFile.
+func (f *NameNormalizationFile) Write(ctx context.Context,
+	req *fuse.WriteRequest, resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "NameNormalizationFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+
+	n, err := libkbfs.ParseNameNormalization(
+		strings.TrimSpace(string(req.Data)))
+	if err != nil {
+		return err
+	}
+
+	err = f.folder.fs.config.KBFSOps().SetTlfNameNormalization(
+		ctx, f.folder.getFolderBranch(), n)
+	if err != nil {
+		return err
+	}
+
+	resp.Size = len(req.Data)
+	return nil
+}
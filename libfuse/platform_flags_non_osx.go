@@ -10,16 +10,22 @@ import "flag"
 
 // PlatformParams contains all platform-specific parameters to be
 // passed to New{Default,Force}Mounter.
-type PlatformParams struct{}
+type PlatformParams struct {
+	NFSExport bool
+}
 
 func (p PlatformParams) shouldAppendPlatformRootDirs() bool {
 	return false
 }
 
+func (p PlatformParams) exportsOverNFS() bool {
+	return p.NFSExport
+}
+
 // GetPlatformUsageString returns a string to be included in a usage
 // string corresponding to the flags added by AddPlatformFlags.
 func GetPlatformUsageString() string {
-	return ""
+	return "[-nfs-export]\n    "
 }
 
 // AddPlatformFlags adds platform-specific flags to the given FlagSet
@@ -27,5 +33,8 @@ func GetPlatformUsageString() string {
 // given FlagSet is parsed.
 func AddPlatformFlags(flags *flag.FlagSet) *PlatformParams {
 	var params PlatformParams
+	flags.BoolVar(&params.NFSExport, "nfs-export", false,
+		"let the mount be re-exported over NFS, by giving the kernel "+
+			"file handles that survive restarts")
 	return &params
 }
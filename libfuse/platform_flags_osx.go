@@ -19,6 +19,10 @@ func (p PlatformParams) shouldAppendPlatformRootDirs() bool {
 	return p.UseLocal
 }
 
+func (p PlatformParams) exportsOverNFS() bool {
+	return false
+}
+
 // GetPlatformUsageString returns a string to be included in a usage
 // string corresponding to the flags added by AddPlatformFlags.
 func GetPlatformUsageString() string {
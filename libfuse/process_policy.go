@@ -0,0 +1,34 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"syscall"
+
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+type ctxProcessIDKeyType int
+
+// ctxProcessIDKey holds the PID of the process that made a request,
+// when the mount has a process policy.
+const ctxProcessIDKey ctxProcessIDKeyType = iota
+
+// checkProcess returns EACCES if the mount's process policy doesn't
+// allow the process that made the request in ctx to do op.
+func (f *FS) checkProcess(ctx context.Context, op string) error {
+	if f.processPolicy == nil {
+		return nil
+	}
+	pid, ok := ctx.Value(ctxProcessIDKey).(uint32)
+	if !ok {
+		return nil
+	}
+	if !f.processPolicy.Allowed(ctx, pid, op) {
+		return fuse.Errno(syscall.EACCES)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"strings"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// PauseQuotaReclamationFile represents a write-only file where any
+// write of at least one byte either pauses or resumes periodic quota
+// reclamation for the folder.
+type PauseQuotaReclamationFile struct {
+	folder *Folder
+	pause  bool
+}
+
+var _ fs.Node = (*PauseQuotaReclamationFile)(nil)
+
+// Attr implements the fs.Node interface for PauseQuotaReclamationFile.
+func (f *PauseQuotaReclamationFile) Attr(
+	ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*PauseQuotaReclamationFile)(nil)
+
+var _ fs.HandleWriter = (*PauseQuotaReclamationFile)(nil)
+
+// Write implements the fs.HandleWriter interface for
+// PauseQuotaReclamationFile.
+func (f *PauseQuotaReclamationFile) Write(ctx context.Context,
+	req *fuse.WriteRequest, resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "PauseQuotaReclamationFile (pause: %t) Write",
+		f.pause)
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+	err = f.folder.fs.config.KBFSOps().SetQuotaReclamationPaused(
+		ctx, f.folder.getFolderBranch(), f.pause)
+	if err != nil {
+		return err
+	}
+	resp.Size = len(req.Data)
+	return nil
+}
+
+// QuotaReclamationMinHeadAgeFile is a special file used to set how
+// old the head of a TLF must be before quota reclamation runs.
+type QuotaReclamationMinHeadAgeFile struct {
+	folder *Folder
+}
+
+var _ fs.Node = (*QuotaReclamationMinHeadAgeFile)(nil)
+
+// Attr implements the fs.Node interface for
+// QuotaReclamationMinHeadAgeFile.
+func (f *QuotaReclamationMinHeadAgeFile) Attr(
+	ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*QuotaReclamationMinHeadAgeFile)(nil)
+
+var _ fs.HandleWriter = (*QuotaReclamationMinHeadAgeFile)(nil)
+
+// Write implements the fs.HandleWriter interface for
+// QuotaReclamationMinHeadAgeFile.
+func (f *QuotaReclamationMinHeadAgeFile) Write(ctx context.Context,
+	req *fuse.WriteRequest, resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "QuotaReclamationMinHeadAgeFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+
+	var age time.Duration
+	s := strings.TrimSpace(string(req.Data))
+	if s != "default" {
+		age, err = time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = f.folder.fs.config.KBFSOps().SetQuotaReclamationMinHeadAge(
+		ctx, f.folder.getFolderBranch(), age)
+	if err != nil {
+		return err
+	}
+
+	resp.Size = len(req.Data)
+	return nil
+}
@@ -41,8 +41,8 @@ func (f *ReclaimQuotaFile) Write(ctx context.Context, req *fuse.WriteRequest,
 	if len(req.Data) == 0 {
 		return nil
 	}
-	err = libkbfs.ForceQuotaReclamationForTesting(
-		f.folder.fs.config, f.folder.getFolderBranch())
+	err = f.folder.fs.config.KBFSOps().ForceQuotaReclamation(
+		ctx, f.folder.getFolderBranch())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,63 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// RemoveTreeFile represents a write-only file where any write of at
+// least one byte removes the directory named name in dir, along with
+// everything in it.  Unlike an `rm -r`, which removes one entry at a
+// time, the whole tree usually goes away in a single revision of the
+// folder.
+type RemoveTreeFile struct {
+	dir  *Dir
+	name string
+}
+
+var _ fs.Node = (*RemoveTreeFile)(nil)
+
+// Attr implements the fs.Node interface for RemoveTreeFile.
+func (f *RemoveTreeFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*RemoveTreeFile)(nil)
+
+var _ fs.HandleWriter = (*RemoveTreeFile)(nil)
+
+// Write implements the fs.HandleWriter interface for RemoveTreeFile.
+func (f *RemoveTreeFile) Write(ctx context.Context, req *fuse.WriteRequest,
+	resp *fuse.WriteResponse) (err error) {
+	folder := f.dir.folder
+	folder.fs.log.CDebugf(ctx, "RemoveTreeFile Write %s", f.name)
+	defer func() { folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+
+	name, err := f.dir.resolveName(ctx, f.name)
+	if err != nil {
+		return err
+	}
+	// Use a context with a nil CtxAppIDKey value so that the kernel
+	// is told about the removals, since it didn't make them itself.
+	removeCtx := context.WithValue(ctx, libfs.CtxAppIDKey, nil)
+	err = folder.fs.config.KBFSOps().RemoveDirTree(removeCtx, f.dir.node, name)
+	if err != nil {
+		return err
+	}
+	folder.fs.removeInode(ctx, f.dir.inode, f.name)
+	folder.fs.NotificationGroupWait()
+	resp.Size = len(req.Data)
+	return nil
+}
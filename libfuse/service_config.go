@@ -0,0 +1,319 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ServiceConfig declares how a headless kbfsfuse, e.g. one serving a
+// server or a bot, is set up.  It's read from a JSON file, like:
+//
+//	{
+//	  "Sync": ["private/bot", "private/alice,bot"],
+//	  "CleanBlockCacheBytes": 268435456,
+//	  "BandwidthCaps": "1M/0",
+//	  "VolumePluginSocket": "/run/docker/plugins/kbfs.sock",
+//	  "Volumes": {"src": {"path": "private/alice,bot/src", "ro": "true"}}
+//	}
+//
+// Sending the process SIGHUP rereads the file.  The folders to sync,
+// the cache size, the bandwidth caps and the volumes take effect
+// right away; the mount and the volume plugin socket only take
+// effect on restart.
+type ServiceConfig struct {
+	// Mountpoint, if set, is where all of KBFS is mounted.  If
+	// it's empty, KBFS isn't mounted, and is only reachable
+	// through the volume plugin and the local RPCs.
+	Mountpoint string
+	// Sync lists the folders to keep synced on this device, like
+	// "private/alice,bob" or "public/alice".  The disk block
+	// cache must be partitioned for them.
+	Sync []string
+	// CleanBlockCacheBytes, if non-zero, is the capacity of the
+	// in-memory block cache.
+	CleanBlockCacheBytes uint64
+	// BandwidthCaps, if set, holds all block server traffic to
+	// the given upload/download rates, like "1M/0", whatever the
+	// network type.
+	BandwidthCaps string
+	// VolumePluginSocket, if set, is the unix socket to serve the
+	// Docker volume plugin API on.
+	VolumePluginSocket string
+	// VolumeMountRoot, if set, is the directory under which the
+	// volume plugin mounts volumes.
+	VolumeMountRoot string
+	// Volumes are created in the volume plugin, by name, with the
+	// options `docker volume create -o` takes.  Volumes dropped
+	// from the file aren't removed.
+	Volumes map[string]map[string]string
+
+	// path is the file the config was read from.
+	path string
+	// The parsed forms of the fields above.
+	sync    []serviceFolder
+	caps    *libkbfs.BandwidthCaps
+	volumes []volumeSpec
+}
+
+// serviceFolder is a folder named in ServiceConfig.Sync.
+type serviceFolder struct {
+	name   string
+	public bool
+}
+
+func (f serviceFolder) String() string {
+	if f.public {
+		return PublicName + "/" + f.name
+	}
+	return PrivateName + "/" + f.name
+}
+
+func parseServiceFolder(p string) (serviceFolder, error) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) != 2 || parts[1] == "" {
+		return serviceFolder{}, errors.Errorf(
+			"%q doesn't name a folder, like %s/alice,bob", p, PrivateName)
+	}
+	switch parts[0] {
+	case PrivateName:
+		return serviceFolder{parts[1], false}, nil
+	case PublicName:
+		return serviceFolder{parts[1], true}, nil
+	}
+	return serviceFolder{}, errors.Errorf(
+		"%q must start with %s or %s", p, PrivateName, PublicName)
+}
+
+// LoadServiceConfig reads and checks the ServiceConfig in the given
+// file.  Unknown fields are errors, so that typos don't go unnoticed.
+func LoadServiceConfig(path string) (*ServiceConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	c := ServiceConfig{path: path}
+	err = dec.Decode(&c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't parse %s", path)
+	}
+
+	for _, p := range c.Sync {
+		f, err := parseServiceFolder(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Bad Sync entry in %s", path)
+		}
+		c.sync = append(c.sync, f)
+	}
+	if c.BandwidthCaps != "" {
+		caps, err := libkbfs.ParseBandwidthCaps(c.BandwidthCaps)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Bad BandwidthCaps in %s", path)
+		}
+		c.caps = &caps
+	}
+	if len(c.Volumes) > 0 && c.VolumePluginSocket == "" {
+		return nil, errors.Errorf(
+			"Volumes in %s need a VolumePluginSocket", path)
+	}
+	for name, opts := range c.Volumes {
+		spec, err := parseVolumeSpec(name, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Bad volume in %s", path)
+		}
+		c.volumes = append(c.volumes, spec)
+	}
+	sort.Slice(c.volumes, func(i, j int) bool {
+		return c.volumes[i].Name < c.volumes[j].Name
+	})
+	return &c, nil
+}
+
+// service applies a ServiceConfig, and its reloads, to a running
+// KBFS instance.
+type service struct {
+	config  libkbfs.Config
+	log     logger.Logger
+	path    string
+	volumes *VolumeDriver
+	// defaultCacheBytes is the block cache capacity to go back to
+	// when CleanBlockCacheBytes is dropped.
+	defaultCacheBytes uint64
+
+	lock    sync.Mutex
+	current *ServiceConfig
+	// synced holds the folders this service has started syncing.
+	synced map[serviceFolder]libkbfs.FolderBranch
+}
+
+func newService(config libkbfs.Config, log logger.Logger, path string,
+	volumes *VolumeDriver) *service {
+	return &service{
+		config:            config,
+		log:               log,
+		path:              path,
+		volumes:           volumes,
+		defaultCacheBytes: config.BlockCache().GetCleanBytesCapacity(),
+		synced:            make(map[serviceFolder]libkbfs.FolderBranch),
+	}
+}
+
+func (s *service) folderBranch(ctx context.Context, f serviceFolder) (
+	libkbfs.FolderBranch, error) {
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, s.config.KBPKI(), f.name, f.public)
+	if err != nil {
+		return libkbfs.FolderBranch{}, err
+	}
+	node, _, err := s.config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	if err != nil {
+		return libkbfs.FolderBranch{}, err
+	}
+	return node.GetFolderBranch(), nil
+}
+
+// applySyncLocked starts syncing the folders in c.Sync, and stops
+// syncing the ones this service started that aren't listed anymore.
+func (s *service) applySyncLocked(
+	ctx context.Context, c *ServiceConfig) (errs []error) {
+	wanted := make(map[serviceFolder]bool, len(c.sync))
+	for _, f := range c.sync {
+		wanted[f] = true
+		if _, ok := s.synced[f]; ok {
+			continue
+		}
+		fb, err := s.folderBranch(ctx, f)
+		if err == nil {
+			err = s.config.KBFSOps().SetTlfSynced(ctx, fb, true)
+		}
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "Couldn't sync %s", f))
+			continue
+		}
+		s.log.CDebugf(ctx, "Syncing %s", f)
+		s.synced[f] = fb
+	}
+	for f, fb := range s.synced {
+		if wanted[f] {
+			continue
+		}
+		err := s.config.KBFSOps().SetTlfSynced(ctx, fb, false)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(
+				err, "Couldn't stop syncing %s", f))
+			continue
+		}
+		s.log.CDebugf(ctx, "Stopped syncing %s", f)
+		delete(s.synced, f)
+	}
+	return errs
+}
+
+// apply makes the running instance match c, as far as it can
+// without a restart.  It applies as much of c as it can even if some
+// of it fails, and returns the first failure.
+func (s *service) apply(ctx context.Context, c *ServiceConfig) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if prev := s.current; prev != nil {
+		if prev.Mountpoint != c.Mountpoint ||
+			prev.VolumePluginSocket != c.VolumePluginSocket ||
+			prev.VolumeMountRoot != c.VolumeMountRoot {
+			s.log.CWarningf(ctx, "The mount and volume plugin settings "+
+				"in %s only change on restart", s.path)
+		}
+	}
+
+	capacity := c.CleanBlockCacheBytes
+	if capacity == 0 {
+		capacity = s.defaultCacheBytes
+	}
+	s.config.BlockCache().SetCleanBytesCapacity(capacity)
+
+	// Only lift caps that an earlier version of the file set, so
+	// that overrides from elsewhere survive.
+	if c.caps != nil {
+		s.config.KBFSOps().OverrideBandwidthCaps(ctx, c.caps)
+	} else if s.current != nil && s.current.caps != nil {
+		s.config.KBFSOps().OverrideBandwidthCaps(ctx, nil)
+	}
+
+	errs := s.applySyncLocked(ctx, c)
+	for _, spec := range c.volumes {
+		if s.volumes == nil {
+			errs = append(errs, errors.Errorf(
+				"Can't create volume %s without a volume plugin",
+				spec.Name))
+			break
+		}
+		err := s.volumes.createVolume(spec)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.current = c
+
+	for _, err := range errs {
+		s.log.CWarningf(ctx, "Error applying %s: %+v", s.path, err)
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// reload rereads the config file and applies it.  If the file can't
+// be read, the running config is left as it is.
+func (s *service) reload(ctx context.Context) error {
+	c, err := LoadServiceConfig(s.path)
+	if err != nil {
+		return err
+	}
+	return s.apply(ctx, c)
+}
+
+// reloadOnHangup reloads the config whenever the process gets
+// SIGHUP, until the returned function is called.
+func (s *service) reloadOnHangup(ctx context.Context) (stop func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hup:
+				s.log.CDebugf(ctx, "Reloading %s", s.path)
+				err := s.reload(ctx)
+				if err != nil {
+					s.log.CWarningf(ctx, "Couldn't reload %s: %+v",
+						s.path, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(hup)
+		close(done)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestLoadServiceConfig(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "kbfs_service")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	path := filepath.Join(tempdir, "config.json")
+
+	load := func(s string) (*ServiceConfig, error) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(s), 0600))
+		return LoadServiceConfig(path)
+	}
+
+	c, err := load(`{
+		"Sync": ["/private/alice,bob/", "public/alice"],
+		"BandwidthCaps": "1M/0",
+		"VolumePluginSocket": "/tmp/kbfs.sock",
+		"Volumes": {
+			"b": {"path": "public/alice"},
+			"a": {"path": "private/alice/dir", "ro": "true"}
+		}
+	}`)
+	require.NoError(t, err)
+	require.Equal(t, []serviceFolder{
+		{"alice,bob", false}, {"alice", true}}, c.sync)
+	require.Equal(t, &libkbfs.BandwidthCaps{
+		UploadBytesPerSecond: 1024 * 1024}, c.caps)
+	require.Equal(t, []volumeSpec{
+		{Name: "a", Path: "private/alice/dir", ReadOnly: true},
+		{Name: "b", Path: "public/alice"},
+	}, c.volumes)
+
+	for _, bad := range []string{
+		`{"Snyc": ["private/alice"]}`,
+		`{"Sync": ["private"]}`,
+		`{"Sync": ["shared/alice"]}`,
+		`{"Sync": ["private/alice/dir"]}`,
+		`{"BandwidthCaps": "1M"}`,
+		`{"Volumes": {"a": {"path": "private/alice"}}}`,
+		`{"VolumePluginSocket": "/tmp/kbfs.sock",
+		  "Volumes": {"a": {"path": "private/alice", "rw": "true"}}}`,
+	} {
+		_, err = load(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestServiceApply(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	log := logger.NewTestLogger(t)
+
+	tempdir, err := ioutil.TempDir(os.TempDir(), "kbfs_service")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	path := filepath.Join(tempdir, "config.json")
+	write := func(s string) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(s), 0600))
+	}
+
+	volumes, err := newVolumeDriver(log, "", tempdir,
+		func(context.Context, string, volumeSpec) (func() error, error) {
+			return func() error { return nil }, nil
+		})
+	require.NoError(t, err)
+	defaultCapacity := config.BlockCache().GetCleanBytesCapacity()
+	svc := newService(config, log, path, volumes)
+
+	write(`{
+		"CleanBlockCacheBytes": 1048576,
+		"BandwidthCaps": "1M/2M",
+		"VolumePluginSocket": "/tmp/kbfs.sock",
+		"Volumes": {"home": {"path": "private/jdoe"}}
+	}`)
+	require.NoError(t, svc.reload(ctx))
+	require.Equal(t, uint64(1048576),
+		config.BlockCache().GetCleanBytesCapacity())
+	status, _, err := config.KBFSOps().Status(ctx)
+	require.NoError(t, err)
+	require.True(t, status.Bandwidth.Overridden)
+	require.Equal(t, libkbfs.BandwidthCaps{
+		UploadBytesPerSecond:   1024 * 1024,
+		DownloadBytesPerSecond: 2 * 1024 * 1024,
+	}, status.Bandwidth.Caps)
+	require.Contains(t, volumes.volumes, "home")
+
+	// A bad file leaves the running config alone.
+	write(`{"CleanBlockCacheBytes": -1}`)
+	require.Error(t, svc.reload(ctx))
+	require.Equal(t, uint64(1048576),
+		config.BlockCache().GetCleanBytesCapacity())
+
+	// Dropped settings go back to their defaults.  The test config
+	// has no disk block cache to sync folders into, so syncing
+	// fails, but everything else still applies.
+	write(`{"Sync": ["private/jdoe"]}`)
+	require.Error(t, svc.reload(ctx))
+	require.Equal(t, defaultCapacity,
+		config.BlockCache().GetCleanBytesCapacity())
+	status, _, err = config.KBFSOps().Status(ctx)
+	require.NoError(t, err)
+	require.False(t, status.Bandwidth.Overridden)
+	require.Len(t, svc.synced, 0)
+	require.Contains(t, volumes.volumes, "home")
+}
@@ -60,6 +60,11 @@ func handleNonTLFSpecialFile(
 	case libfs.DisableBlockPrefetchingFileName:
 		return &PrefetchFile{fs: fs, enable: false}
 
+	case libfs.ProcessDenialsFileName:
+		*entryValid = 0
+		return &SpecialReadFile{
+			read: libfs.GetEncodedProcessDenials(fs.processPolicy)}
+
 	case libfs.EnableDebugServerFileName:
 		return &DebugServerFile{fs: fs, enable: true}
 	case libfs.DisableDebugServerFileName:
@@ -87,6 +92,11 @@ func handleTLFSpecialFile(
 	case libfs.EditHistoryName:
 		return NewTlfEditHistoryFile(folder, entryValid)
 
+	case libfs.ArchivedRevisionsDirName:
+		return &ArchivedRevisionsDir{
+			folder: folder,
+		}
+
 	case libfs.UnstageFileName:
 		return &UnstageFile{
 			folder: folder,
@@ -151,6 +161,70 @@ func handleTLFSpecialFile(
 			folder: folder,
 			action: libfs.JournalDisable,
 		}
+
+	case libfs.FsyncDurabilityFileName:
+		return &FsyncDurabilityFile{
+			folder: folder,
+		}
+
+	case libfs.NameNormalizationFileName:
+		return &NameNormalizationFile{
+			folder: folder,
+		}
+
+	case libfs.FreezeFileName:
+		return &FreezeFile{
+			folder: folder,
+			freeze: true,
+		}
+
+	case libfs.UnfreezeFileName:
+		return &FreezeFile{
+			folder: folder,
+		}
+
+	case libfs.AbortOpsFileName:
+		return &AbortOpsFile{
+			folder: folder,
+		}
+
+	case libfs.EnableAppendOnlyFileName:
+		return &AppendOnlyFile{
+			folder:     folder,
+			appendOnly: true,
+		}
+
+	case libfs.DisableAppendOnlyFileName:
+		return &AppendOnlyFile{
+			folder: folder,
+		}
+
+	case libfs.PauseQuotaReclamationFileName:
+		return &PauseQuotaReclamationFile{
+			folder: folder,
+			pause:  true,
+		}
+
+	case libfs.ResumeQuotaReclamationFileName:
+		return &PauseQuotaReclamationFile{
+			folder: folder,
+		}
+
+	case libfs.QuotaReclamationMinHeadAgeFileName:
+		return &QuotaReclamationMinHeadAgeFile{
+			folder: folder,
+		}
+
+	case libfs.EnableSyncFileName:
+		return &SyncFile{
+			folder: folder,
+			synced: true,
+		}
+
+	case libfs.DisableSyncFileName:
+		return &SyncFile{
+			folder: folder,
+		}
 	}
 	return nil
 }
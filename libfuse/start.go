@@ -5,8 +5,10 @@
 package libfuse
 
 import (
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/kbfs/libfs"
@@ -21,6 +23,46 @@ type StartOptions struct {
 	PlatformParams PlatformParams
 	RuntimeDir     string
 	Label          string
+	// Takeover asks an instance that's already running with the
+	// same RuntimeDir to hand off its mount before this one mounts.
+	Takeover bool
+	// NameConflictPolicy decides which entry names the mount
+	// treats as the same name.  If nil, names are compared byte
+	// for byte.
+	NameConflictPolicy libfs.NameConflictPolicy
+	// DeniedExecutables lists the executables, by full path or
+	// base name, whose processes may not use the mount.
+	DeniedExecutables []string
+	// IndexerOpsPerSecond limits the requests of known desktop
+	// search indexers.  Zero means no limit.
+	IndexerOpsPerSecond float64
+	// TimePolicy decides how the mount reports file times.
+	TimePolicy libfs.TimePolicy
+	// LocalObjects, if true, lets FIFOs and sockets be created on
+	// the mount.  They're backed by objects local to the mount,
+	// and recorded in the TLF as placeholder symlinks.
+	LocalObjects bool
+	// VolumePluginSocket, if set, is the unix socket to serve the
+	// Docker volume plugin API on.
+	VolumePluginSocket string
+	// VolumeMountRoot is the directory under which the volume
+	// plugin mounts volumes.
+	VolumeMountRoot string
+	// ServiceConfig, if set, is applied once KBFS is up, and
+	// reread from its file on SIGHUP.  Its mount and volume plugin
+	// settings must already be reflected in the other options.
+	ServiceConfig *ServiceConfig
+}
+
+// inodeTableDir returns the directory holding the inode table for
+// the mount at mountDir, or "" if there's no storage root to keep it
+// in.  Each mount point gets its own table.
+func inodeTableDir(storageRoot, mountDir string) string {
+	if storageRoot == "" {
+		return ""
+	}
+	return filepath.Join(
+		storageRoot, "kbfs_inodes", url.PathEscape(mountDir))
 }
 
 // Start the filesystem
@@ -33,6 +75,16 @@ func Start(mounter Mounter, options StartOptions, kbCtx libkbfs.Context) *libfs.
 		return libfs.InitError(err.Error())
 	}
 
+	if options.Takeover {
+		if options.RuntimeDir == "" {
+			return libfs.InitError("takeover needs a runtime directory")
+		}
+		err := requestHandoff(options.RuntimeDir, log)
+		if err != nil {
+			return libfs.InitError(err.Error())
+		}
+	}
+
 	if options.RuntimeDir != "" {
 		info := libkb.NewServiceInfo(libkbfs.Version, libkbfs.PrereleaseBuild, options.Label, os.Getpid())
 		err := info.WriteFile(path.Join(options.RuntimeDir, "kbfs.info"), log)
@@ -50,6 +102,35 @@ func Start(mounter Mounter, options StartOptions, kbCtx libkbfs.Context) *libfs.
 	}
 	defer libkbfs.Shutdown()
 
+	libfs.LogHealthCheck(context.Background(), config, log)
+
+	var volumes *VolumeDriver
+	if options.VolumePluginSocket != "" {
+		volumes, err = NewVolumeDriver(config, kbCtx, options.KbfsParams,
+			options.VolumeMountRoot, options.PlatformParams)
+		if err != nil {
+			return libfs.InitError(err.Error())
+		}
+		l, err := volumes.Listen(options.VolumePluginSocket)
+		if err != nil {
+			return libfs.InitError(err.Error())
+		}
+		defer l.Close()
+		// Volumes must be unmounted before KBFS shuts down.
+		defer volumes.Shutdown(context.Background())
+		log.Debug("Serving the volume plugin on %s",
+			options.VolumePluginSocket)
+	}
+
+	if options.ServiceConfig != nil {
+		ctx := context.Background()
+		svc := newService(config, log, options.ServiceConfig.path, volumes)
+		// Failures are logged, and the rest of the config still
+		// applies, so that one bad folder doesn't stop a server.
+		_ = svc.apply(ctx, options.ServiceConfig)
+		defer svc.reloadOnHangup(ctx)()
+	}
+
 	log.Debug("Mounting: %s", mounter.Dir())
 	c, err := mounter.Mount()
 	if err != nil {
@@ -57,9 +138,21 @@ func Start(mounter Mounter, options StartOptions, kbCtx libkbfs.Context) *libfs.
 	}
 	defer mounter.Unmount()
 
+	// Keep track of the requests in flight and the open files, so
+	// that a forced unmount doesn't leave processes stuck on them.
+	ops := libfs.NewOpRegistry()
+	handles := libfs.NewOpenHandleRegistry()
+	_, force := mounter.(ForceMounter)
+
 	done := make(chan struct{})
 	if c != nil { // c can be nil for NoopMounter
 		interruptFn = func() {
+			if force {
+				n := ops.AbortAll()
+				log.Debug("Aborted %d in-flight ops before unmounting", n)
+				n = handles.ForceCloseAll()
+				log.Debug("Force-closed %d open files before unmounting", n)
+			}
 			if unmountErr := mounter.Unmount(); unmountErr != nil {
 				log.Debug("Unmounting error: %v", unmountErr)
 			}
@@ -77,8 +170,42 @@ func Start(mounter Mounter, options StartOptions, kbCtx libkbfs.Context) *libfs.
 			return libfs.MountError(err.Error())
 		}
 
+		var handoff *handoffListener
+		if options.RuntimeDir != "" {
+			handoff, err = listenForHandoffs(
+				options.RuntimeDir, log, mounter.Unmount)
+			if err != nil {
+				log.Warning("Couldn't listen for handoffs: %v", err)
+			} else {
+				defer handoff.Close()
+			}
+		}
+
 		log.Debug("Creating filesystem")
 		fs := NewFS(config, c, options.KbfsParams.Debug, options.PlatformParams)
+		if options.NameConflictPolicy != nil {
+			fs.SetNameConflictPolicy(options.NameConflictPolicy)
+		}
+		fs.SetProcessPolicy(libfs.NewProcessPolicy(
+			log, options.DeniedExecutables, options.IndexerOpsPerSecond))
+		fs.SetTimePolicy(options.TimePolicy)
+		fs.SetLocalObjects(options.LocalObjects)
+		fs.SetOpRegistry(ops)
+		fs.SetOpenHandleRegistry(handles)
+		// Open the inode table only now, since an instance handing
+		// off its mount keeps it open until it has unmounted.
+		var inodes *libfs.InodeTable
+		if dir := inodeTableDir(
+			config.StorageRoot(), mounter.Dir()); dir != "" {
+			inodes, err = libfs.OpenInodeTable(dir)
+			if err != nil {
+				log.Warning("Couldn't open the inode table, so inode "+
+					"numbers won't survive remounts: %v", err)
+				inodes = nil
+			} else {
+				fs.SetInodeTable(inodes)
+			}
+		}
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 		ctx = context.WithValue(ctx, libfs.CtxAppIDKey, fs)
@@ -86,6 +213,26 @@ func Start(mounter Mounter, options StartOptions, kbCtx libkbfs.Context) *libfs.
 		if err = fs.Serve(ctx); err != nil {
 			return libfs.MountError(err.Error())
 		}
+
+		// Shutting down stops the journals and closes the disk
+		// caches, so that an instance taking over can open them,
+		// and records that this was a clean shutdown.
+		log.Debug("Shutting down")
+		if volumes != nil {
+			volumes.Shutdown(ctx)
+		}
+		shutdownErr := config.Shutdown(ctx)
+		if shutdownErr != nil {
+			log.Warning("Error shutting down: %+v", shutdownErr)
+		}
+		if inodes != nil {
+			if err := inodes.Close(); err != nil {
+				log.Warning("Error closing the inode table: %+v", err)
+			}
+		}
+		if handoff != nil && handoff.requested() {
+			handoff.finish(shutdownErr)
+		}
 	} else {
 		<-done
 	}
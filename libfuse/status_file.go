@@ -18,7 +18,7 @@ func NewNonTLFStatusFile(fs *FS, entryValid *time.Duration) *SpecialReadFile {
 	*entryValid = 0
 	return &SpecialReadFile{
 		read: func(ctx context.Context) ([]byte, time.Time, error) {
-			return libfs.GetEncodedStatus(ctx, fs.config)
+			return libfs.GetEncodedStatus(ctx, fs.config, fs.handles)
 		},
 	}
 }
@@ -31,7 +31,8 @@ func NewTLFStatusFile(
 	return &SpecialReadFile{
 		read: func(ctx context.Context) ([]byte, time.Time, error) {
 			return libfs.GetEncodedFolderStatus(
-				ctx, folder.fs.config, folder.getFolderBranch())
+				ctx, folder.fs.config, folder.getFolderBranch(),
+				folder.fs.handles)
 		},
 	}
 }
@@ -0,0 +1,97 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"fmt"
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"golang.org/x/net/context"
+)
+
+// subtreeFS is a view of an FS rooted at one of its directories.
+type subtreeFS struct {
+	*FS
+	root fs.Node
+	// rootInode is the inode number the root has in the full FS,
+	// which keys the inode numbers of its entries.
+	rootInode uint64
+}
+
+var _ fs.FS = (*subtreeFS)(nil)
+
+var _ fs.FSInodeGenerator = (*subtreeFS)(nil)
+
+// Root implements the fs.FS interface for subtreeFS.
+func (s *subtreeFS) Root() (fs.Node, error) {
+	return s.root, nil
+}
+
+// GenerateInode implements the fs.FSInodeGenerator interface for
+// subtreeFS.
+func (s *subtreeFS) GenerateInode(parentInode uint64, name string) uint64 {
+	if parentInode == libfs.RootInode {
+		parentInode = s.rootInode
+	}
+	return s.FS.GenerateInode(parentInode, name)
+}
+
+// subtree looks up the directory at the given path, e.g.
+// "private/alice,bob/src", and returns a view of f rooted at it.
+func (f *FS) subtree(ctx context.Context, p string) (*subtreeFS, error) {
+	ctx = f.WithContext(ctx)
+	var node fs.Node = &f.root
+	inode := uint64(libfs.RootInode)
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		lookuper, ok := node.(fs.NodeRequestLookuper)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a directory", p)
+		}
+		child, err := lookuper.Lookup(
+			ctx, &fuse.LookupRequest{Name: name}, &fuse.LookupResponse{})
+		if alias, ok := child.(*Alias); ok && err == nil {
+			// Follow TLF names to their preferred form.
+			name = alias.realPath
+			child, err = lookuper.Lookup(
+				ctx, &fuse.LookupRequest{Name: name},
+				&fuse.LookupResponse{})
+		}
+		if err != nil {
+			return nil, err
+		}
+		node = child
+		inode = f.inode(ctx, inode, name)
+	}
+
+	switch node := node.(type) {
+	case *TLF:
+		// Make sure the TLF can be read before mounting it.  One
+		// that hasn't been created yet is shown as empty.
+		if _, _, err := node.loadDirAllowNonexistent(ctx); err != nil {
+			return nil, err
+		}
+	case *Dir:
+	default:
+		return nil, fmt.Errorf("%s is not a folder or directory", p)
+	}
+	return &subtreeFS{FS: f, root: node, rootInode: inode}, nil
+}
+
+// ServeSubtree serves the directory at the given path under the root
+// of f, e.g. "private/alice,bob/src", as the root of the mount.  It
+// blocks until the mount is unmounted.
+func (f *FS) ServeSubtree(ctx context.Context, p string) error {
+	sub, err := f.subtree(ctx, p)
+	if err != nil {
+		return err
+	}
+	return f.serve(ctx, sub)
+}
@@ -7,6 +7,7 @@ package libfuse
 import (
 	"os"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -41,7 +42,15 @@ func (s *Symlink) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 		return err
 	}
 
-	s.parent.folder.fillAttrWithUIDAndWritePerm(ctx, &de, a)
+	s.parent.folder.fillAttrWithUIDAndWritePerm(ctx, &de, time.Time{}, a)
+	if mode, ok := s.parent.folder.fs.localObjectMode(de); ok {
+		// The kernel handles the I/O on FIFOs and sockets
+		// itself, so reporting the mode is all it takes.
+		a.Mode = mode
+		a.Size = 0
+		a.Blocks = 0
+		return nil
+	}
 	a.Mode = os.ModeSymlink | 0777
 	return nil
 }
@@ -61,5 +70,8 @@ func (s *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (link
 	if de.Type != libkbfs.Sym {
 		return "", fuse.Errno(syscall.EINVAL)
 	}
+	if _, ok := s.parent.folder.fs.localObjectMode(de); ok {
+		return "", fuse.Errno(syscall.EINVAL)
+	}
 	return de.SymPath, nil
 }
@@ -0,0 +1,50 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SyncFile represents a write-only file where any write of at least
+// one byte either starts or stops keeping the folder synced on this
+// device.
+type SyncFile struct {
+	folder *Folder
+	synced bool
+}
+
+var _ fs.Node = (*SyncFile)(nil)
+
+// Attr implements the fs.Node interface for SyncFile.
+func (f *SyncFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*SyncFile)(nil)
+
+var _ fs.HandleWriter = (*SyncFile)(nil)
+
+// Write implements the fs.HandleWriter interface for SyncFile.
+func (f *SyncFile) Write(ctx context.Context, req *fuse.WriteRequest,
+	resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "SyncFile (synced: %t) Write", f.synced)
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+	err = f.folder.fs.config.KBFSOps().SetTlfSynced(
+		ctx, f.folder.getFolderBranch(), f.synced)
+	if err != nil {
+		return err
+	}
+	resp.Size = len(req.Data)
+	return nil
+}
@@ -21,16 +21,19 @@ import (
 // Dir.
 type TLF struct {
 	folder *Folder
+	// inode is the inode number of the TLF root directory.
+	inode uint64
 
 	dirLock sync.RWMutex
 	dir     *Dir
 }
 
 func newTLF(fl *FolderList, h *libkbfs.TlfHandle,
-	name libkbfs.PreferredTlfName) *TLF {
+	name libkbfs.PreferredTlfName, inode uint64) *TLF {
 	folder := newFolder(fl, h, name)
 	tlf := &TLF{
 		folder: folder,
+		inode:  inode,
 	}
 	return tlf
 }
@@ -59,6 +62,11 @@ func (tlf *TLF) log() logger.Logger {
 
 func (tlf *TLF) loadDirHelper(ctx context.Context, mode libkbfs.ErrorModeType,
 	filterErr bool) (dir *Dir, exitEarly bool, err error) {
+	// Loading the TLF fetches its metadata, so check before that.
+	if err := tlf.folder.fs.checkProcess(ctx, "load folder"); err != nil {
+		return nil, false, err
+	}
+
 	dir = tlf.getStoredDir()
 	if dir != nil {
 		return dir, false, nil
@@ -81,6 +89,10 @@ func (tlf *TLF) loadDirHelper(ctx context.Context, mode libkbfs.ErrorModeType,
 		}
 		tlf.folder.reportErr(ctx, mode, err)
 	}()
+	// The folder's ID isn't known until its root node is loaded, so
+	// only an abort of all the ops on the mount can cut this short.
+	ctx, done := tlf.folder.beginOp(ctx)
+	defer done()
 
 	handle, err := tlf.folder.resolve(ctx)
 	if err != nil {
@@ -112,7 +124,7 @@ func (tlf *TLF) loadDirHelper(ctx context.Context, mode libkbfs.ErrorModeType,
 	}
 
 	tlf.folder.nodes[rootNode.GetID()] = tlf
-	tlf.dir = newDir(tlf.folder, rootNode)
+	tlf.dir = newDir(tlf.folder, rootNode, tlf.inode)
 
 	return tlf.dir, false, nil
 }
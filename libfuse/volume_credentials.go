@@ -0,0 +1,166 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sync"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// volumeKBContext is a libkbfs.Context that talks to the Keybase
+// service listening on socketPath, rather than the one the driver's
+// own KBFS instance uses.  So the KBFS instance made with it is
+// logged in as whoever is logged in to that service, e.g. a CI bot
+// logged in with `keybase oneshot` in a sidecar container.
+type volumeKBContext struct {
+	libkbfs.Context
+	socketPath string
+
+	lock sync.Mutex
+	conn net.Conn
+	xp   rpc.Transporter
+}
+
+var _ libkbfs.Context = (*volumeKBContext)(nil)
+
+// ConfigureSocketInfo implements the libkbfs.Context interface for
+// volumeKBContext.  The socket is fixed, so there's nothing to do.
+func (c *volumeKBContext) ConfigureSocketInfo() error {
+	return nil
+}
+
+// GetSocket implements the libkbfs.Context interface for
+// volumeKBContext.  Like libkb's, it reuses the connection until it
+// breaks.
+func (c *volumeKBContext) GetSocket(clearError bool) (
+	conn net.Conn, xp rpc.Transporter, isNew bool, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.xp != nil && c.xp.IsConnected() {
+		return c.conn, c.xp, false, nil
+	}
+	conn, err = net.Dial("unix", c.socketPath)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	c.conn = conn
+	c.xp = rpc.NewTransport(conn, c.NewRPCLogFactory(), libkb.WrapError)
+	return c.conn, c.xp, true, nil
+}
+
+// volumeConfigInitFn makes a KBFS instance that uses the Keybase
+// service listening on socketPath.
+type volumeConfigInitFn func(socketPath string) (libkbfs.Config, error)
+
+// makeVolumeConfigInitFn returns a volumeConfigInitFn that starts
+// KBFS instances with the given params, except that each one keeps
+// its caches and journal under its own storage root.
+func makeVolumeConfigInitFn(kbCtx libkbfs.Context,
+	params libkbfs.InitParams, log logger.Logger) volumeConfigInitFn {
+	return func(socketPath string) (libkbfs.Config, error) {
+		p := params
+		if p.StorageRoot != "" {
+			p.StorageRoot = filepath.Join(p.StorageRoot,
+				"kbfs_volume_users", url.PathEscape(socketPath))
+		}
+		// These are served by the driver's own instance.
+		p.ServeSettings = false
+		p.TrackCleanShutdown = false
+		return libkbfs.Init(&volumeKBContext{
+			Context:    kbCtx,
+			socketPath: socketPath,
+		}, p, nil, nil, log)
+	}
+}
+
+type volumeConfig struct {
+	config libkbfs.Config
+	refs   int
+}
+
+// volumeConfigs hands out the KBFS instances that volumes are served
+// from.  Volumes without their own Keybase service socket share the
+// driver's instance, and the others share one instance per socket,
+// which is shut down once none of its volumes are mounted.
+type volumeConfigs struct {
+	log    logger.Logger
+	config libkbfs.Config
+	initFn volumeConfigInitFn
+
+	lock    sync.Mutex
+	configs map[string]*volumeConfig
+}
+
+func newVolumeConfigs(log logger.Logger, config libkbfs.Config,
+	initFn volumeConfigInitFn) *volumeConfigs {
+	return &volumeConfigs{
+		log:     log,
+		config:  config,
+		initFn:  initFn,
+		configs: make(map[string]*volumeConfig),
+	}
+}
+
+// get returns the KBFS instance for the Keybase service listening on
+// socketPath, or the driver's own if socketPath is empty, along with
+// a function to call once the volume is unmounted.
+func (vcs *volumeConfigs) get(ctx context.Context, socketPath string) (
+	config libkbfs.Config, release func(), err error) {
+	if socketPath == "" {
+		return vcs.config, func() {}, nil
+	}
+
+	vcs.lock.Lock()
+	defer vcs.lock.Unlock()
+	vc, ok := vcs.configs[socketPath]
+	if !ok {
+		vcs.log.CDebugf(ctx, "Starting KBFS for the Keybase service at %s",
+			socketPath)
+		config, err := vcs.initFn(socketPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf(
+				"Couldn't start KBFS for the Keybase service at %s: %v",
+				socketPath, err)
+		}
+		vc = &volumeConfig{config: config}
+		vcs.configs[socketPath] = vc
+	}
+	vc.refs++
+	// The volume outlives the request that mounted it.
+	return vc.config, func() {
+		vcs.release(context.Background(), socketPath)
+	}, nil
+}
+
+func (vcs *volumeConfigs) release(ctx context.Context, socketPath string) {
+	vcs.lock.Lock()
+	defer vcs.lock.Unlock()
+	vc, ok := vcs.configs[socketPath]
+	if !ok {
+		return
+	}
+	vc.refs--
+	if vc.refs > 0 {
+		return
+	}
+	delete(vcs.configs, socketPath)
+	vcs.log.CDebugf(ctx, "Shutting down KBFS for the Keybase service at %s",
+		socketPath)
+	err := vc.config.Shutdown(ctx)
+	if err != nil {
+		vcs.log.CWarningf(ctx,
+			"Couldn't shut down KBFS for the Keybase service at %s: %+v",
+			socketPath, err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVolumeSpecKeybaseSocket(t *testing.T) {
+	spec, err := parseVolumeSpec("ci", map[string]string{
+		"path":           "private/bot",
+		"keybase-socket": "/run/keybase-ci//keybased.sock",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "/run/keybase-ci/keybased.sock", spec.KeybaseSocket)
+
+	_, err = parseVolumeSpec("ci", map[string]string{
+		"path":           "private/bot",
+		"keybase-socket": "keybased.sock",
+	})
+	require.Error(t, err)
+}
+
+func TestVolumeConfigs(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	started := make(map[string]libkbfs.Config)
+	initFn := func(socketPath string) (libkbfs.Config, error) {
+		if socketPath == "/bad" {
+			return nil, errors.New("no service")
+		}
+		require.NotContains(t, started, socketPath)
+		c := libkbfs.MakeTestConfigOrBust(t, "bot")
+		started[socketPath] = c
+		return c, nil
+	}
+	vcs := newVolumeConfigs(logger.NewTestLogger(t), config, initFn)
+
+	// Volumes without a socket use the driver's own instance.
+	c, release, err := vcs.get(ctx, "")
+	require.NoError(t, err)
+	require.True(t, c == config)
+	release()
+
+	// Volumes with the same socket share an instance, until the last
+	// of them is released.
+	c1, release1, err := vcs.get(ctx, "/a")
+	require.NoError(t, err)
+	c2, release2, err := vcs.get(ctx, "/a")
+	require.NoError(t, err)
+	require.True(t, c1 == c2)
+	require.True(t, c1 == started["/a"])
+	c3, release3, err := vcs.get(ctx, "/b")
+	require.NoError(t, err)
+	require.False(t, c1 == c3)
+
+	release1()
+	require.Contains(t, vcs.configs, "/a")
+	release2()
+	require.NotContains(t, vcs.configs, "/a")
+	release3()
+	require.Len(t, vcs.configs, 0)
+
+	// A new instance is started for the next volume.
+	delete(started, "/a")
+	c4, release4, err := vcs.get(ctx, "/a")
+	require.NoError(t, err)
+	require.False(t, c1 == c4)
+	release4()
+
+	_, _, err = vcs.get(ctx, "/bad")
+	require.Error(t, err)
+	require.Len(t, vcs.configs, 0)
+}
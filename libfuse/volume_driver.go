@@ -0,0 +1,536 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"bazil.org/fuse"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const (
+	// volumePluginContentType is the content type of the responses
+	// of the Docker plugin API.
+	volumePluginContentType = "application/vnd.docker.plugins.v1+json"
+	// volumeStateFilename is the name of the file in the storage
+	// root that records the created volumes.
+	volumeStateFilename = "kbfs_volumes.json"
+)
+
+// volumeSpec is what a volume exposes, as given by the options it
+// was created with, e.g. with
+//
+//	docker volume create -d kbfs -o path=private/alice,bob/src -o ro=true
+type volumeSpec struct {
+	Name string
+	// Path is the folder or directory the volume exposes, relative
+	// to the root of KBFS, e.g. "private/alice,bob/src".
+	Path string
+	// ReadOnly is whether the volume is mounted read-only.
+	ReadOnly bool
+	// AllowOther is whether users other than the one the driver
+	// runs as can use the volume, e.g. containers running as
+	// non-root users.
+	AllowOther bool
+	// KeybaseSocket, if set, is the socket of the Keybase service
+	// whose logged-in user the volume is accessed as.  Otherwise
+	// it's accessed as the driver's user.
+	KeybaseSocket string
+}
+
+func parseVolumeSpec(name string, opts map[string]string) (
+	volumeSpec, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name[0] == '.' {
+		return volumeSpec{}, fmt.Errorf("Invalid volume name %q", name)
+	}
+	spec := volumeSpec{Name: name}
+	for k, v := range opts {
+		var err error
+		switch k {
+		case "path":
+			spec.Path = strings.Trim(v, "/")
+		case "ro":
+			spec.ReadOnly, err = strconv.ParseBool(v)
+		case "allow-other":
+			spec.AllowOther, err = strconv.ParseBool(v)
+		case "keybase-socket":
+			if !filepath.IsAbs(v) {
+				return volumeSpec{}, fmt.Errorf(
+					"The keybase-socket option must be an absolute path")
+			}
+			spec.KeybaseSocket = filepath.Clean(v)
+		default:
+			return volumeSpec{}, fmt.Errorf("Unknown volume option %q", k)
+		}
+		if err != nil {
+			return volumeSpec{}, fmt.Errorf(
+				"Invalid value %q for volume option %q", v, k)
+		}
+	}
+	parts := strings.SplitN(spec.Path, "/", 3)
+	if len(parts) < 2 || (parts[0] != PrivateName &&
+		parts[0] != PublicName) || parts[1] == "" {
+		return volumeSpec{}, fmt.Errorf(
+			"The path option must name a folder, like %s/alice,bob/dir",
+			PrivateName)
+	}
+	return spec, nil
+}
+
+// volumeMountFn mounts the volume with the given spec on dir, and
+// returns a function that unmounts it.
+type volumeMountFn func(ctx context.Context, dir string, spec volumeSpec) (
+	unmount func() error, err error)
+
+type volume struct {
+	spec volumeSpec
+	// mounts holds the IDs of the containers using the volume.
+	mounts map[string]bool
+	// unmount is non-nil while the volume is mounted.
+	unmount func() error
+}
+
+// VolumeDriver serves the Docker volume plugin API, exposing KBFS
+// folders, or directories within them, as container volumes.  Each
+// volume in use gets its own FUSE mount, so read-only volumes are
+// enforced by the kernel.
+//
+// Volumes are accessed as the Keybase user that the driver's KBFS
+// instance is logged in as, unless they're created with the socket
+// of another Keybase service, in which case they're served by a
+// separate KBFS instance logged in as that service's user.
+type VolumeDriver struct {
+	log       logger.Logger
+	mountRoot string
+	// statePath is empty if the volumes aren't saved.
+	statePath string
+	mountFn   volumeMountFn
+	mux       *http.ServeMux
+
+	lock     sync.Mutex
+	volumes  map[string]*volume
+	shutdown bool
+}
+
+var _ http.Handler = (*VolumeDriver)(nil)
+
+func newVolumeDriver(log logger.Logger, storageRoot, mountRoot string,
+	mountFn volumeMountFn) (*VolumeDriver, error) {
+	d := &VolumeDriver{
+		log:       log,
+		mountRoot: mountRoot,
+		mountFn:   mountFn,
+		mux:       http.NewServeMux(),
+		volumes:   make(map[string]*volume),
+	}
+	if storageRoot != "" {
+		d.statePath = filepath.Join(storageRoot, volumeStateFilename)
+		var specs []volumeSpec
+		err := ioutil.DeserializeFromJSONFile(d.statePath, &specs)
+		if err != nil && !ioutil.IsNotExist(err) {
+			return nil, err
+		}
+		for _, spec := range specs {
+			d.volumes[spec.Name] = &volume{
+				spec:   spec,
+				mounts: make(map[string]bool),
+			}
+		}
+	}
+
+	d.mux.HandleFunc("/Plugin.Activate", d.activate)
+	d.handle("/VolumeDriver.Create", d.create)
+	d.handle("/VolumeDriver.Remove", d.remove)
+	d.handle("/VolumeDriver.Mount", d.mount)
+	d.handle("/VolumeDriver.Unmount", d.unmountVolume)
+	d.handle("/VolumeDriver.Path", d.path)
+	d.handle("/VolumeDriver.Get", d.get)
+	d.handle("/VolumeDriver.List", d.list)
+	d.handle("/VolumeDriver.Capabilities", d.capabilities)
+	return d, nil
+}
+
+// NewVolumeDriver returns a VolumeDriver that mounts volumes in
+// directories under mountRoot, and saves the list of volumes under
+// the storage root of config.  Volumes with their own Keybase
+// service socket are served by KBFS instances started with kbCtx
+// and params.
+func NewVolumeDriver(config libkbfs.Config, kbCtx libkbfs.Context,
+	params libkbfs.InitParams, mountRoot string,
+	platformParams PlatformParams) (*VolumeDriver, error) {
+	log := config.MakeLogger("VOL")
+	configs := newVolumeConfigs(
+		log, config, makeVolumeConfigInitFn(kbCtx, params, log))
+	return newVolumeDriver(log, config.StorageRoot(),
+		mountRoot, makeVolumeMountFn(configs, platformParams))
+}
+
+// makeVolumeMountFn returns a volumeMountFn that serves each volume
+// from its own FS.
+func makeVolumeMountFn(configs *volumeConfigs,
+	platformParams PlatformParams) volumeMountFn {
+	return func(ctx context.Context, dir string, spec volumeSpec) (
+		_ func() error, err error) {
+		config, release, err := configs.get(ctx, spec.KeybaseSocket)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				release()
+			}
+		}()
+
+		filesys := NewFS(config, nil, false, platformParams)
+		// Look up the volume's root before mounting, so that a bad
+		// path fails the mount.
+		sub, err := filesys.subtree(ctx, spec.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		options, err := getPlatformSpecificMountOptions(dir, platformParams)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, fuse.FSName("kbfs"))
+		if spec.ReadOnly {
+			options = append(options, fuse.ReadOnly())
+		}
+		if spec.AllowOther {
+			options = append(options, fuse.AllowOther())
+		}
+		c, err := fuse.Mount(dir, options...)
+		if err != nil {
+			return nil, translatePlatformSpecificError(err, platformParams)
+		}
+		filesys.conn = c
+
+		// The volume outlives the request that mounted it.
+		serveCtx, cancel := context.WithCancel(context.Background())
+		serveCtx = context.WithValue(serveCtx, libfs.CtxAppIDKey, filesys)
+		served := make(chan error, 1)
+		go func() {
+			served <- filesys.serve(serveCtx, sub)
+		}()
+		<-c.Ready
+		if c.MountError != nil {
+			cancel()
+			c.Close()
+			return nil, c.MountError
+		}
+		return func() error {
+			err := doUnmount(dir, false)
+			if err != nil {
+				return err
+			}
+			err = <-served
+			cancel()
+			c.Close()
+			release()
+			return err
+		}, nil
+	}
+}
+
+// volumeRequest is the union of the Docker volume plugin requests.
+type volumeRequest struct {
+	Name string
+	Opts map[string]string
+	ID   string
+}
+
+type volumeInfo struct {
+	Name       string
+	Mountpoint string                 `json:",omitempty"`
+	Status     map[string]interface{} `json:",omitempty"`
+}
+
+type volumeCapabilities struct {
+	Scope string
+}
+
+// volumeResponse is the union of the Docker volume plugin responses.
+type volumeResponse struct {
+	Mountpoint   string              `json:",omitempty"`
+	Volume       *volumeInfo         `json:",omitempty"`
+	Volumes      []volumeInfo        `json:",omitempty"`
+	Capabilities *volumeCapabilities `json:",omitempty"`
+	Err          string
+}
+
+func writeVolumeResponse(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", volumePluginContentType)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handle registers a handler for the given volume plugin endpoint.
+// Errors are reported to Docker in the response, rather than as HTTP
+// errors.
+func (d *VolumeDriver) handle(pattern string,
+	fn func(ctx context.Context, req volumeRequest) (volumeResponse, error)) {
+	d.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.Background()
+		var req volumeRequest
+		// Some requests, like List, have an empty body.
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && r.ContentLength != 0 {
+			writeVolumeResponse(w, volumeResponse{Err: err.Error()})
+			return
+		}
+		d.log.CDebugf(ctx, "%s %s", pattern, req.Name)
+		resp, err := fn(ctx, req)
+		if err != nil {
+			d.log.CDebugf(ctx, "%s %s failed: %+v", pattern, req.Name, err)
+			resp = volumeResponse{Err: err.Error()}
+		}
+		writeVolumeResponse(w, resp)
+	})
+}
+
+// ServeHTTP implements the http.Handler interface for VolumeDriver.
+func (d *VolumeDriver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+func (d *VolumeDriver) activate(w http.ResponseWriter, r *http.Request) {
+	writeVolumeResponse(w, map[string][]string{
+		"Implements": {"VolumeDriver"},
+	})
+}
+
+func (d *VolumeDriver) saveLocked() error {
+	if d.statePath == "" {
+		return nil
+	}
+	specs := make([]volumeSpec, 0, len(d.volumes))
+	for _, v := range d.volumes {
+		specs = append(specs, v.spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+	return ioutil.SerializeToJSONFile(specs, d.statePath)
+}
+
+func (d *VolumeDriver) getLocked(name string) (*volume, error) {
+	v, ok := d.volumes[name]
+	if !ok {
+		return nil, fmt.Errorf("No such volume %q", name)
+	}
+	return v, nil
+}
+
+func (d *VolumeDriver) mountpointLocked(v *volume) string {
+	if v.unmount == nil {
+		return ""
+	}
+	return filepath.Join(d.mountRoot, v.spec.Name)
+}
+
+// createVolume creates the volume with the given spec, unless it
+// already exists with the same spec.
+func (d *VolumeDriver) createVolume(spec volumeSpec) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if v, ok := d.volumes[spec.Name]; ok {
+		if v.spec != spec {
+			return fmt.Errorf(
+				"Volume %q already exists with different options",
+				spec.Name)
+		}
+		return nil
+	}
+	d.volumes[spec.Name] = &volume{spec: spec, mounts: make(map[string]bool)}
+	return d.saveLocked()
+}
+
+func (d *VolumeDriver) create(
+	_ context.Context, req volumeRequest) (volumeResponse, error) {
+	spec, err := parseVolumeSpec(req.Name, req.Opts)
+	if err != nil {
+		return volumeResponse{}, err
+	}
+	return volumeResponse{}, d.createVolume(spec)
+}
+
+func (d *VolumeDriver) remove(
+	_ context.Context, req volumeRequest) (volumeResponse, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v, err := d.getLocked(req.Name)
+	if err != nil {
+		return volumeResponse{}, err
+	}
+	if len(v.mounts) > 0 {
+		return volumeResponse{}, fmt.Errorf(
+			"Volume %q is in use", req.Name)
+	}
+	delete(d.volumes, req.Name)
+	return volumeResponse{}, d.saveLocked()
+}
+
+func (d *VolumeDriver) mount(
+	ctx context.Context, req volumeRequest) (volumeResponse, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.shutdown {
+		return volumeResponse{}, libkbfs.ShutdownHappenedError{}
+	}
+	v, err := d.getLocked(req.Name)
+	if err != nil {
+		return volumeResponse{}, err
+	}
+	if v.unmount == nil {
+		dir := filepath.Join(d.mountRoot, v.spec.Name)
+		err := ioutil.MkdirAll(dir, 0700)
+		if err != nil {
+			return volumeResponse{}, err
+		}
+		v.unmount, err = d.mountFn(ctx, dir, v.spec)
+		if err != nil {
+			return volumeResponse{}, err
+		}
+	}
+	v.mounts[req.ID] = true
+	return volumeResponse{Mountpoint: d.mountpointLocked(v)}, nil
+}
+
+func (d *VolumeDriver) unmountVolume(
+	_ context.Context, req volumeRequest) (volumeResponse, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v, err := d.getLocked(req.Name)
+	if err != nil {
+		return volumeResponse{}, err
+	}
+	delete(v.mounts, req.ID)
+	if len(v.mounts) > 0 || v.unmount == nil {
+		return volumeResponse{}, nil
+	}
+	err = v.unmount()
+	if err != nil {
+		return volumeResponse{}, err
+	}
+	v.unmount = nil
+	return volumeResponse{}, nil
+}
+
+func (d *VolumeDriver) path(
+	_ context.Context, req volumeRequest) (volumeResponse, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v, err := d.getLocked(req.Name)
+	if err != nil {
+		return volumeResponse{}, err
+	}
+	return volumeResponse{Mountpoint: d.mountpointLocked(v)}, nil
+}
+
+func (d *VolumeDriver) infoLocked(v *volume) volumeInfo {
+	return volumeInfo{
+		Name:       v.spec.Name,
+		Mountpoint: d.mountpointLocked(v),
+		Status: map[string]interface{}{
+			"path":           v.spec.Path,
+			"ro":             v.spec.ReadOnly,
+			"allow-other":    v.spec.AllowOther,
+			"keybase-socket": v.spec.KeybaseSocket,
+			"mounts":         len(v.mounts),
+		},
+	}
+}
+
+func (d *VolumeDriver) get(
+	_ context.Context, req volumeRequest) (volumeResponse, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v, err := d.getLocked(req.Name)
+	if err != nil {
+		return volumeResponse{}, err
+	}
+	info := d.infoLocked(v)
+	return volumeResponse{Volume: &info}, nil
+}
+
+func (d *VolumeDriver) list(
+	_ context.Context, _ volumeRequest) (volumeResponse, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	resp := volumeResponse{Volumes: make([]volumeInfo, 0, len(d.volumes))}
+	for _, v := range d.volumes {
+		resp.Volumes = append(resp.Volumes, d.infoLocked(v))
+	}
+	sort.Slice(resp.Volumes, func(i, j int) bool {
+		return resp.Volumes[i].Name < resp.Volumes[j].Name
+	})
+	return resp, nil
+}
+
+func (d *VolumeDriver) capabilities(
+	_ context.Context, _ volumeRequest) (volumeResponse, error) {
+	return volumeResponse{
+		Capabilities: &volumeCapabilities{Scope: "local"},
+	}, nil
+}
+
+// Shutdown unmounts all the mounted volumes, and stops any more from
+// being mounted.  It's idempotent.
+func (d *VolumeDriver) Shutdown(ctx context.Context) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.shutdown = true
+	for _, v := range d.volumes {
+		if v.unmount == nil {
+			continue
+		}
+		err := v.unmount()
+		if err != nil {
+			d.log.CWarningf(ctx, "Couldn't unmount volume %s: %+v",
+				v.spec.Name, err)
+			continue
+		}
+		v.unmount = nil
+		v.mounts = make(map[string]bool)
+	}
+}
+
+// Listen serves the plugin API on the unix socket at socketPath,
+// replacing any socket left there by an earlier instance.  Docker
+// finds plugins by their sockets in /run/docker/plugins.  Closing the
+// returned listener stops serving.
+func (d *VolumeDriver) Listen(socketPath string) (net.Listener, error) {
+	err := os.Remove(socketPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	err = ioutil.MkdirAll(filepath.Dir(socketPath), 0755)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		err := http.Serve(l, d)
+		d.log.Debug("Stopped serving the volume plugin: %v", err)
+	}()
+	return l, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func callVolumeDriver(t *testing.T, d *VolumeDriver, endpoint string,
+	req volumeRequest) volumeResponse {
+	buf, err := json.Marshal(req)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest(
+		http.MethodPost, "/VolumeDriver."+endpoint, bytes.NewReader(buf)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, volumePluginContentType, w.Header().Get("Content-Type"))
+	var resp volumeResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	return resp
+}
+
+func TestVolumeDriver(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "kbfs_volumes")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	storageRoot := filepath.Join(tempdir, "storage")
+	mountRoot := filepath.Join(tempdir, "volumes")
+	log := logger.NewTestLogger(t)
+
+	mounted := make(map[string]volumeSpec)
+	mountFn := func(_ context.Context, dir string, spec volumeSpec) (
+		func() error, error) {
+		require.NotContains(t, mounted, dir)
+		mounted[dir] = spec
+		return func() error {
+			delete(mounted, dir)
+			return nil
+		}, nil
+	}
+	d, err := newVolumeDriver(log, storageRoot, mountRoot, mountFn)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest(
+		http.MethodPost, "/Plugin.Activate", nil))
+	require.JSONEq(t, `{"Implements":["VolumeDriver"]}`, w.Body.String())
+
+	resp := callVolumeDriver(t, d, "Create", volumeRequest{
+		Name: "src",
+		Opts: map[string]string{"path": "/private/alice,bob/src/", "ro": "1"},
+	})
+	require.Equal(t, "", resp.Err)
+	resp = callVolumeDriver(t, d, "Create", volumeRequest{
+		Name: "bad", Opts: map[string]string{"path": "private"},
+	})
+	require.NotEqual(t, "", resp.Err)
+	resp = callVolumeDriver(t, d, "Create", volumeRequest{
+		Name: "bad", Opts: map[string]string{"path": "public/alice", "rw": "1"},
+	})
+	require.NotEqual(t, "", resp.Err)
+	resp = callVolumeDriver(t, d, "Create", volumeRequest{
+		Name: "src", Opts: map[string]string{"path": "private/alice,bob"},
+	})
+	require.NotEqual(t, "", resp.Err)
+	resp = callVolumeDriver(t, d, "Create", volumeRequest{
+		Name: "bad", Opts: map[string]string{
+			"path": "private/alice", "keybase-socket": "keybased.sock",
+		},
+	})
+	require.NotEqual(t, "", resp.Err)
+
+	// Two containers share one mount.
+	dir := filepath.Join(mountRoot, "src")
+	resp = callVolumeDriver(t, d, "Mount", volumeRequest{Name: "src", ID: "1"})
+	require.Equal(t, "", resp.Err)
+	require.Equal(t, dir, resp.Mountpoint)
+	resp = callVolumeDriver(t, d, "Mount", volumeRequest{Name: "src", ID: "2"})
+	require.Equal(t, dir, resp.Mountpoint)
+	require.Equal(t, map[string]volumeSpec{dir: {
+		Name: "src", Path: "private/alice,bob/src", ReadOnly: true,
+	}}, mounted)
+
+	resp = callVolumeDriver(t, d, "Get", volumeRequest{Name: "src"})
+	require.Equal(t, "", resp.Err)
+	require.Equal(t, dir, resp.Volume.Mountpoint)
+	resp = callVolumeDriver(t, d, "Remove", volumeRequest{Name: "src"})
+	require.Contains(t, resp.Err, "in use")
+
+	resp = callVolumeDriver(t, d, "Unmount", volumeRequest{Name: "src", ID: "1"})
+	require.Equal(t, "", resp.Err)
+	require.Len(t, mounted, 1)
+	resp = callVolumeDriver(t, d, "Unmount", volumeRequest{Name: "src", ID: "2"})
+	require.Equal(t, "", resp.Err)
+	require.Len(t, mounted, 0)
+	resp = callVolumeDriver(t, d, "Path", volumeRequest{Name: "src"})
+	require.Equal(t, "", resp.Mountpoint)
+
+	resp = callVolumeDriver(t, d, "Capabilities", volumeRequest{})
+	require.Equal(t, "local", resp.Capabilities.Scope)
+
+	// A restarted driver still has the volume, but not its mounts.
+	resp = callVolumeDriver(t, d, "Mount", volumeRequest{Name: "src", ID: "3"})
+	require.Equal(t, "", resp.Err)
+	d.Shutdown(context.Background())
+	require.Len(t, mounted, 0)
+	resp = callVolumeDriver(t, d, "Mount", volumeRequest{Name: "src", ID: "4"})
+	require.NotEqual(t, "", resp.Err)
+
+	d, err = newVolumeDriver(log, storageRoot, mountRoot, mountFn)
+	require.NoError(t, err)
+	resp = callVolumeDriver(t, d, "List", volumeRequest{})
+	require.Len(t, resp.Volumes, 1)
+	require.Equal(t, "src", resp.Volumes[0].Name)
+	require.Equal(t, "", resp.Volumes[0].Mountpoint)
+	resp = callVolumeDriver(t, d, "Remove", volumeRequest{Name: "src"})
+	require.Equal(t, "", resp.Err)
+	resp = callVolumeDriver(t, d, "List", volumeRequest{})
+	require.Len(t, resp.Volumes, 0)
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"sort"
+	"strconv"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// Extended attributes that say who last changed an entry, so file
+// managers can show it without going through the folder's history.
+const (
+	// lastWriterXattr is the username of the last writer.
+	lastWriterXattr = "user.kbfs.lastwriter"
+	// lastWriterDeviceXattr is the KID of the device they used.
+	lastWriterDeviceXattr = "user.kbfs.lastwriter.device"
+	// lastWriterRevisionXattr is the folder revision of the change.
+	lastWriterRevisionXattr = "user.kbfs.lastwriter.revision"
+)
+
+// xattrs returns the extended attributes of node.  Entries last
+// changed before writers were recorded have none.
+func (f *Folder) xattrs(ctx context.Context, node libkbfs.Node) (
+	map[string]string, error) {
+	ei, err := f.fs.config.KBFSOps().Stat(ctx, node)
+	if err != nil {
+		if isNoSuchNameError(err) {
+			return nil, fuse.ESTALE
+		}
+		return nil, err
+	}
+	if ei.LastWriterUID == "" {
+		return nil, nil
+	}
+	name, err := f.fs.config.KBPKI().GetNormalizedUsername(
+		ctx, ei.LastWriterUID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		lastWriterXattr:       name.String(),
+		lastWriterDeviceXattr: ei.LastWriterKID.String(),
+		lastWriterRevisionXattr: strconv.FormatInt(
+			int64(ei.LastWriterRevision), 10),
+	}, nil
+}
+
+func (f *Folder) getxattr(ctx context.Context, node libkbfs.Node,
+	req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	xattrs, err := f.xattrs(ctx, node)
+	if err != nil {
+		return err
+	}
+	value, ok := xattrs[req.Name]
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+	resp.Xattr = []byte(value)
+	return nil
+}
+
+func (f *Folder) listxattr(ctx context.Context, node libkbfs.Node,
+	resp *fuse.ListxattrResponse) error {
+	xattrs, err := f.xattrs(ctx, node)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resp.Append(names...)
+	return nil
+}
+
+var _ fs.NodeGetxattrer = (*File)(nil)
+
+// Getxattr implements the fs.NodeGetxattrer interface for File.
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest,
+	resp *fuse.GetxattrResponse) (err error) {
+	ctx = f.folder.fs.maybeStartTrace(
+		ctx, "File.Getxattr", f.node.GetBasename()+" "+req.Name)
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	f.folder.fs.log.CDebugf(ctx, "File Getxattr %s", req.Name)
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	return f.folder.getxattr(ctx, f.node, req, resp)
+}
+
+var _ fs.NodeListxattrer = (*File)(nil)
+
+// Listxattr implements the fs.NodeListxattrer interface for File.
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest,
+	resp *fuse.ListxattrResponse) (err error) {
+	ctx = f.folder.fs.maybeStartTrace(
+		ctx, "File.Listxattr", f.node.GetBasename())
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	f.folder.fs.log.CDebugf(ctx, "File Listxattr")
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	return f.folder.listxattr(ctx, f.node, resp)
+}
+
+var _ fs.NodeGetxattrer = (*Dir)(nil)
+
+// Getxattr implements the fs.NodeGetxattrer interface for Dir.
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest,
+	resp *fuse.GetxattrResponse) (err error) {
+	ctx = d.folder.fs.maybeStartTrace(
+		ctx, "Dir.Getxattr", d.node.GetBasename()+" "+req.Name)
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Getxattr %s", req.Name)
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	return d.folder.getxattr(ctx, d.node, req, resp)
+}
+
+var _ fs.NodeListxattrer = (*Dir)(nil)
+
+// Listxattr implements the fs.NodeListxattrer interface for Dir.
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest,
+	resp *fuse.ListxattrResponse) (err error) {
+	ctx = d.folder.fs.maybeStartTrace(
+		ctx, "Dir.Listxattr", d.node.GetBasename())
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Listxattr")
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	return d.folder.listxattr(ctx, d.node, resp)
+}
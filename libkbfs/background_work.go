@@ -0,0 +1,169 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"golang.org/x/net/context"
+)
+
+// platformStatePollPeriod is how often the platform hooks are asked
+// for the power and network state.
+const platformStatePollPeriod = 1 * time.Minute
+
+// PlatformState is the power and network state of the device, as
+// reported by PlatformHooks.
+type PlatformState struct {
+	// BatterySaver is true if the OS is trying to save power,
+	// e.g. Battery Saver on Windows or Low Power Mode on macOS.
+	BatterySaver bool
+	// NetworkMetered is true if the current network connection
+	// is metered, e.g. tethered to a phone.
+	NetworkMetered bool
+	// NetworkType is the kind of interface the current network
+	// connection goes through.
+	NetworkType NetworkType
+}
+
+// BackgroundWorkStatus says whether journal flushing and prefetching
+// are paused, and why.  It is suitable for encoding directly as
+// JSON.
+type BackgroundWorkStatus struct {
+	// PausedByUser is true between calls to PauseBackgroundWork
+	// and ResumeBackgroundWork.
+	PausedByUser bool
+	Platform     PlatformState
+	// PlatformErr is the error from the last poll of the platform
+	// hooks, if any.
+	PlatformErr string `json:",omitempty"`
+	// LowDiskSpace is true while the disk is too full for the
+	// journals to grow.
+	LowDiskSpace bool
+	// FlushingPaused is true if journal flushing is paused, by
+	// the user or by battery saver.
+	FlushingPaused bool
+	// PrefetchingPaused is true if prefetching is paused, by the
+	// user, by battery saver, by a metered network or by low disk
+	// space.
+	PrefetchingPaused bool
+}
+
+// backgroundWorkPauser pauses journal flushing and prefetching when
+// the user asks, or when the platform state calls for it.  Battery
+// saver pauses both; a metered network only pauses prefetching, and
+// stops idle journals from trickle flushing, since unflushed writes
+// aren't safe yet.  Low disk space also only pauses prefetching,
+// since flushing is what frees up the journals' space.
+type backgroundWorkPauser struct {
+	config Config
+	log    logger.Logger
+
+	lock         sync.Mutex
+	userPaused   bool
+	platform     PlatformState
+	platformErr  error
+	lowDiskSpace bool
+	// The states last applied to the journals and the prefetcher.
+	metered           bool
+	flushingPaused    bool
+	prefetchingPaused bool
+}
+
+func newBackgroundWorkPauser(
+	config Config, log logger.Logger) *backgroundWorkPauser {
+	return &backgroundWorkPauser{config: config, log: log}
+}
+
+// applyLocked brings the journals and the prefetcher in line with
+// the current pause reasons.  p.lock must be held.
+func (p *backgroundWorkPauser) applyLocked(ctx context.Context) {
+	flushingPaused := p.userPaused || p.platform.BatterySaver
+	prefetchingPaused := flushingPaused || p.platform.NetworkMetered ||
+		p.lowDiskSpace
+
+	if jServer, err := GetJournalServer(p.config); err == nil {
+		if p.platform.NetworkMetered != p.metered {
+			jServer.SetNetworkMetered(ctx, p.platform.NetworkMetered)
+		}
+		if flushingPaused != p.flushingPaused {
+			if flushingPaused {
+				jServer.PauseAllBackgroundWork(ctx)
+			} else {
+				jServer.ResumeAllBackgroundWork(ctx)
+			}
+		}
+	}
+	p.metered = p.platform.NetworkMetered
+	p.flushingPaused = flushingPaused
+
+	if prefetchingPaused != p.prefetchingPaused {
+		p.log.CDebugf(ctx, "Setting prefetching enabled=%t",
+			!prefetchingPaused)
+		err := p.config.BlockOps().TogglePrefetcher(ctx, !prefetchingPaused)
+		if err != nil {
+			p.log.CDebugf(ctx, "Couldn't toggle the prefetcher: %+v", err)
+		}
+		p.prefetchingPaused = prefetchingPaused
+	}
+}
+
+func (p *backgroundWorkPauser) setUserPaused(
+	ctx context.Context, paused bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.userPaused = paused
+	p.applyLocked(ctx)
+}
+
+func (p *backgroundWorkPauser) setLowDiskSpace(
+	ctx context.Context, lowDiskSpace bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.lowDiskSpace = lowDiskSpace
+	p.applyLocked(ctx)
+}
+
+// pollPlatform asks the platform hooks for the current state, if
+// there are any, and applies it.
+func (p *backgroundWorkPauser) pollPlatform(ctx context.Context) {
+	hooks := p.config.PlatformHooks()
+	if hooks == nil {
+		return
+	}
+	state, err := hooks.State(ctx)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.platformErr = err
+	if err != nil {
+		// Keep the last known state.
+		p.log.CDebugf(ctx, "Couldn't get the platform state: %+v", err)
+		return
+	}
+	if state != p.platform {
+		p.log.CDebugf(ctx, "Platform state changed to %+v", state)
+	}
+	p.platform = state
+	p.applyLocked(ctx)
+}
+
+func (p *backgroundWorkPauser) getStatus() BackgroundWorkStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	status := BackgroundWorkStatus{
+		PausedByUser:      p.userPaused,
+		Platform:          p.platform,
+		LowDiskSpace:      p.lowDiskSpace,
+		FlushingPaused:    p.flushingPaused,
+		PrefetchingPaused: p.prefetchingPaused,
+	}
+	if p.platformErr != nil {
+		status.PlatformErr = p.platformErr.Error()
+	}
+	return status
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testPlatformHooks struct {
+	state PlatformState
+}
+
+func (h *testPlatformHooks) State(_ context.Context) (PlatformState, error) {
+	return h.state, nil
+}
+
+func TestBackgroundWorkPause(t *testing.T) {
+	tempdir, ctx, cancel, config, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	tlfID := tlf.FakeID(2, false)
+	err := jServer.Enable(ctx, tlfID, TLFJournalBackgroundWorkEnabled)
+	require.NoError(t, err)
+	tlfJournal, ok := jServer.getTLFJournal(tlfID)
+	require.True(t, ok)
+	getPauseType := func() tlfJournalPauseType {
+		tlfJournal.pauseLock.Lock()
+		defer tlfJournal.pauseLock.Unlock()
+		return tlfJournal.pauseType
+	}
+
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	kbfsOps.PauseBackgroundWork(ctx)
+	status := kbfsOps.bgWork.getStatus()
+	require.True(t, status.PausedByUser)
+	require.True(t, status.FlushingPaused)
+	require.True(t, status.PrefetchingPaused)
+	jStatus, _ := jServer.Status(ctx)
+	require.True(t, jStatus.AllPaused)
+	require.Equal(t, journalPauseAll, getPauseType())
+
+	// Resuming the single journal doesn't undo the pause of all
+	// of them.
+	jServer.ResumeBackgroundWork(ctx, tlfID)
+	require.Equal(t, journalPauseAll, getPauseType())
+
+	kbfsOps.ResumeBackgroundWork(ctx)
+	status = kbfsOps.bgWork.getStatus()
+	require.False(t, status.FlushingPaused)
+	require.False(t, status.PrefetchingPaused)
+	require.Zero(t, getPauseType())
+
+	// Battery saver pauses everything.
+	// Set new hooks for each state, rather than changing them,
+	// since the platform poll loop may be reading them.
+	config.SetPlatformHooks(
+		&testPlatformHooks{PlatformState{BatterySaver: true}})
+	kbfsOps.bgWork.pollPlatform(ctx)
+	status = kbfsOps.bgWork.getStatus()
+	require.False(t, status.PausedByUser)
+	require.True(t, status.FlushingPaused)
+	require.True(t, status.PrefetchingPaused)
+	require.Equal(t, journalPauseAll, getPauseType())
+
+	// A metered network only pauses prefetching.
+	config.SetPlatformHooks(
+		&testPlatformHooks{PlatformState{NetworkMetered: true}})
+	kbfsOps.bgWork.pollPlatform(ctx)
+	status = kbfsOps.bgWork.getStatus()
+	require.False(t, status.FlushingPaused)
+	require.True(t, status.PrefetchingPaused)
+	require.Zero(t, getPauseType())
+	jStatus, _ = jServer.Status(ctx)
+	require.False(t, jStatus.AllPaused)
+	require.True(t, jStatus.NetworkMetered)
+}
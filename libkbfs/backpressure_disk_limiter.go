@@ -239,11 +239,71 @@ func (bt *backpressureTracker) getStatus() backpressureTrackerStatus {
 	}
 }
 
+// freeSpaceEstimator smooths samples of free resources (either bytes
+// or files) with an exponential moving average, so that resources
+// used only briefly by other applications don't make the backpressure
+// delay oscillate.
+//
+// What's averaged is the resources available to KBFS, i.e. the free
+// resources plus the ones KBFS already uses, since the latter change
+// with every block put and delete, and shouldn't lag behind.
+//
+// Note that this type doesn't do any locking, so it's the caller's
+// responsibility to do so.
+type freeSpaceEstimator struct {
+	clock Clock
+	// halfLife is the time after which a sample has half the
+	// weight it had when it was taken. 0 means no smoothing.
+	halfLife time.Duration
+	// marginFrac is the fraction of the averaged available
+	// resources to hold back, since the average lags behind real
+	// drops in free space.
+	marginFrac float64
+
+	initialized bool
+	lastSample  time.Time
+	available   float64
+}
+
+// estimateFree adds a sample of the free resources, given the ones
+// currently used by KBFS, and returns the estimated free resources.
+func (e *freeSpaceEstimator) estimateFree(free, used int64) int64 {
+	if e.halfLife <= 0 && e.marginFrac == 0 {
+		return free
+	}
+
+	available := float64(free) + float64(used)
+	if !e.initialized || e.halfLife <= 0 {
+		e.available = available
+		e.initialized = true
+		if e.halfLife > 0 {
+			e.lastSample = e.clock.Now()
+		}
+	} else {
+		now := e.clock.Now()
+		if elapsed := now.Sub(e.lastSample); elapsed > 0 {
+			weight := 1 - math.Exp2(-float64(elapsed)/float64(e.halfLife))
+			e.available += weight * (available - e.available)
+			e.lastSample = now
+		}
+	}
+
+	estimate := e.available*(1-e.marginFrac) - float64(used)
+	if estimate <= 0 {
+		return 0
+	}
+	if estimate >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(estimate)
+}
+
 // backpressureDiskLimiter is an implementation of diskLimiter that
 // uses backpressure to slow down block puts before they hit the disk
 // limits.
 type backpressureDiskLimiter struct {
-	log logger.Logger
+	log   logger.Logger
+	clock Clock
 
 	maxDelay            time.Duration
 	delayFn             func(context.Context, time.Duration) error
@@ -251,10 +311,23 @@ type backpressureDiskLimiter struct {
 
 	// lock protects everything in the trackers, including the
 	// (implicit) maximum values of the semaphores, but not the
-	// actual semaphore itself.
+	// actual semaphore itself. It also protects the estimators.
 	lock                                   sync.RWMutex
+	freeByteEstimator, freeFileEstimator   *freeSpaceEstimator
 	journalByteTracker, journalFileTracker *backpressureTracker
+	journalMDByteTracker                   *backpressureTracker
 	diskCacheByteTracker                   *backpressureTracker
+	publicCacheByteTracker                 *backpressureTracker
+	syncCacheByteTracker                   *backpressureTracker
+	readSpillByteTracker                   *backpressureTracker
+
+	// minFreeBytes is the number of free bytes below which block
+	// puts fail, instead of filling up the disk.  lowSpace is
+	// whether the last sample was below it, and lowSpaceCh is
+	// signaled whenever a sample first goes below it.
+	minFreeBytes int64
+	lowSpace     bool
+	lowSpaceCh   chan struct{}
 }
 
 var _ DiskLimiter = (*backpressureDiskLimiter)(nil)
@@ -269,14 +342,33 @@ type backpressureDiskLimiterParams struct {
 	// journalFrac is fraction of the free bytes/files that the
 	// journal is allowed to use.
 	journalFrac float64
+	// journalMDFrac is the fraction of the free bytes that the
+	// journal MDs are allowed to use, on top of journalFrac,
+	// which is then only used by journal blocks.
+	journalMDFrac float64
 	// diskCacheFrac is the fraction of the free bytes that the
 	// disk cache is allowed to use. The disk cache doesn't store
 	// individual files.
 	diskCacheFrac float64
+	// publicCacheFrac is the fraction of the free bytes that the
+	// disk cache partition for other users' public TLFs is
+	// allowed to use, on top of diskCacheFrac.
+	publicCacheFrac float64
+	// syncCacheFrac is the fraction of the free bytes that the
+	// disk cache partition for synced TLFs is allowed to use, on
+	// top of diskCacheFrac and publicCacheFrac.
+	syncCacheFrac float64
+	// readSpillFrac is the fraction of the free bytes that the
+	// blocks spilled by reads too big for the in-memory block
+	// cache are allowed to use, on top of all the disk cache
+	// partitions.
+	readSpillFrac float64
 	// byteLimit is the total cap for free bytes. The journal will
-	// be allowed to use at most journalFrac*byteLimit, and the
-	// disk cache will be allowed to use at most
-	// diskCacheFrac*byteLimit.
+	// be allowed to use at most journalFrac*byteLimit for blocks
+	// and journalMDFrac*byteLimit for MDs, and the disk cache will be allowed to use at most
+	// diskCacheFrac*byteLimit for the working set, plus
+	// publicCacheFrac*byteLimit for other users' public TLFs and
+	// syncCacheFrac*byteLimit for synced TLFs.
 	byteLimit int64
 	// maxFreeFiles is the cap for free files. The journal will be
 	// allowed to use at most journalFrac*fileLimit. This limit
@@ -285,6 +377,9 @@ type backpressureDiskLimiterParams struct {
 	fileLimit int64
 	// maxDelay is the maximum delay used for backpressure.
 	maxDelay time.Duration
+	// clock is used to get the current time when computing
+	// delays.
+	clock Clock
 	// delayFn is a function that takes a context and a duration
 	// and returns after sleeping for that duration, or if the
 	// context is cancelled. Overridable for testing.
@@ -293,6 +388,20 @@ type backpressureDiskLimiterParams struct {
 	// free bytes and files on the disk containing the
 	// journal/disk cache directory. Overridable for testing.
 	freeBytesAndFilesFn func() (int64, int64, error)
+	// freeSpaceHalfLife is the half-life of the moving average of
+	// the samples returned by freeBytesAndFilesFn, which is what
+	// the backpressure is based on. 0 means each sample is used
+	// as is.
+	freeSpaceHalfLife time.Duration
+	// freeSpaceMarginFrac is the fraction of the averaged free
+	// bytes and files, plus the ones used by KBFS, to leave out
+	// as a safety margin.
+	freeSpaceMarginFrac float64
+	// minFreeBytes is the number of free bytes below which the
+	// journals stop growing and the disk cache stops taking new
+	// blocks, so that KBFS never fills up the disk. 0 means no
+	// minimum.
+	minFreeBytes int64
 }
 
 // defaultDiskLimitMaxDelay is the maximum amount to delay a block
@@ -300,8 +409,13 @@ type backpressureDiskLimiterParams struct {
 // tlfJournalConfigAdapter.
 const defaultDiskLimitMaxDelay = 10 * time.Second
 
+// defaultDiskLimitMinFreeBytes is the default minimum of free bytes
+// to leave on the disk, which is above the point at which the OSes
+// start to warn about low disk space.
+const defaultDiskLimitMinFreeBytes = 1 << 30
+
 func makeDefaultBackpressureDiskLimiterParams(
-	storageRoot string) backpressureDiskLimiterParams {
+	storageRoot string, clock Clock) backpressureDiskLimiterParams {
 	return backpressureDiskLimiterParams{
 		// Start backpressure when 50% of free bytes or files
 		// are used...
@@ -311,23 +425,44 @@ func makeDefaultBackpressureDiskLimiterParams(
 		maxThreshold: 0.95,
 		// Cap journal usage to 15% of free bytes and files...
 		journalFrac: 0.15,
+		// ...and give journal MDs 1% of free bytes of their
+		// own, so that a block backlog never keeps them out.
+		journalMDFrac: 0.01,
 		// ...and cap disk cache usage to 10% of free
 		// bytes. The disk cache doesn't store individual
 		// files.
 		diskCacheFrac: 0.10,
+		// ...and cap the cache of other users' public TLFs
+		// to 2% of free bytes.
+		publicCacheFrac: 0.02,
+		// ...and give synced TLFs as much as the working set.
+		syncCacheFrac: 0.10,
+		// ...and cap the blocks spilled by big reads to 2% of
+		// free bytes.
+		readSpillFrac: 0.02,
 		// Set the byte limit to 200 GiB, which translates to
-		// having the journal take up at most 30 GiB, and the
-		// disk cache to take up at most 20 GiB.
+		// having the journal take up at most 30 GiB for blocks
+		// and 2 GiB for MDs, the disk
+		// cache to take up at most 20 GiB for each of the
+		// working set and synced TLFs, and the public cache and
+		// the blocks spilled by reads at most 4 GiB each.
 		byteLimit: 200 * 1024 * 1024 * 1024,
 		// Set the file limit to 6 million files, which
 		// translates to having the journal take up at most
 		// 900k files.
 		fileLimit: 6000000,
 		maxDelay:  defaultDiskLimitMaxDelay,
-		delayFn:   defaultDoDelay,
+		clock:     clock,
+		delayFn:   makeClockDoDelay(clock),
 		freeBytesAndFilesFn: func() (int64, int64, error) {
 			return defaultGetFreeBytesAndFiles(storageRoot)
 		},
+		// Let other applications' usage take a few minutes
+		// to fully count...
+		freeSpaceHalfLife: 1 * time.Minute,
+		// ...and make up for that by leaving 5% out.
+		freeSpaceMarginFrac: 0.05,
+		minFreeBytes:        defaultDiskLimitMinFreeBytes,
 	}
 }
 
@@ -336,10 +471,31 @@ func makeDefaultBackpressureDiskLimiterParams(
 func newBackpressureDiskLimiter(
 	log logger.Logger, params backpressureDiskLimiterParams) (
 	*backpressureDiskLimiter, error) {
-	freeBytes, freeFiles, err := params.freeBytesAndFilesFn()
+	if params.freeSpaceHalfLife < 0 {
+		return nil, errors.Errorf("freeSpaceHalfLife=%s < 0",
+			params.freeSpaceHalfLife)
+	}
+	if params.freeSpaceMarginFrac < 0.0 || params.freeSpaceMarginFrac >= 1.0 {
+		return nil, errors.Errorf("freeSpaceMarginFrac=%f not in [0, 1)",
+			params.freeSpaceMarginFrac)
+	}
+	freeByteEstimator := &freeSpaceEstimator{
+		clock:      params.clock,
+		halfLife:   params.freeSpaceHalfLife,
+		marginFrac: params.freeSpaceMarginFrac,
+	}
+	freeFileEstimator := &freeSpaceEstimator{
+		clock:      params.clock,
+		halfLife:   params.freeSpaceHalfLife,
+		marginFrac: params.freeSpaceMarginFrac,
+	}
+	sampledFreeBytes, sampledFreeFiles, err := params.freeBytesAndFilesFn()
 	if err != nil {
 		return nil, err
 	}
+	// Nothing is used yet.
+	freeBytes := freeByteEstimator.estimateFree(sampledFreeBytes, 0)
+	freeFiles := freeFileEstimator.estimateFree(sampledFreeFiles, 0)
 	// byteLimit and fileLimit must be scaled by the proportion of
 	// the limit that the journal should consume.
 	journalByteLimit := int64((float64(params.byteLimit) * params.journalFrac) + 0.5)
@@ -358,32 +514,80 @@ func newBackpressureDiskLimiter(
 	if err != nil {
 		return nil, err
 	}
+	journalMDByteLimit := int64(
+		(float64(params.byteLimit) * params.journalMDFrac) + 0.5)
+	// MD puts are never delayed, only refused once the limit is
+	// reached.
+	mdByteTracker, err := newBackpressureTracker(
+		1.0, 1.0, params.journalMDFrac, journalMDByteLimit, freeBytes)
+	if err != nil {
+		return nil, err
+	}
 	diskCacheByteLimit := int64((float64(params.byteLimit) * params.diskCacheFrac) + 0.5)
 	diskCacheByteTracker, err := newBackpressureTracker(
 		1.0, 1.0, params.diskCacheFrac, diskCacheByteLimit, freeBytes)
-	bdl := &backpressureDiskLimiter{
-		log, params.maxDelay, params.delayFn, params.freeBytesAndFilesFn, sync.RWMutex{},
-		byteTracker, fileTracker, diskCacheByteTracker,
+	if err != nil {
+		return nil, err
+	}
+	publicCacheByteLimit := int64(
+		(float64(params.byteLimit) * params.publicCacheFrac) + 0.5)
+	publicCacheByteTracker, err := newBackpressureTracker(
+		1.0, 1.0, params.publicCacheFrac, publicCacheByteLimit, freeBytes)
+	if err != nil {
+		return nil, err
 	}
+	syncCacheByteLimit := int64(
+		(float64(params.byteLimit) * params.syncCacheFrac) + 0.5)
+	syncCacheByteTracker, err := newBackpressureTracker(
+		1.0, 1.0, params.syncCacheFrac, syncCacheByteLimit, freeBytes)
+	if err != nil {
+		return nil, err
+	}
+	readSpillByteLimit := int64(
+		(float64(params.byteLimit) * params.readSpillFrac) + 0.5)
+	readSpillByteTracker, err := newBackpressureTracker(
+		1.0, 1.0, params.readSpillFrac, readSpillByteLimit, freeBytes)
+	if err != nil {
+		return nil, err
+	}
+	bdl := &backpressureDiskLimiter{
+		log, params.clock, params.maxDelay, params.delayFn,
+		params.freeBytesAndFilesFn, sync.RWMutex{},
+		freeByteEstimator, freeFileEstimator, byteTracker, fileTracker,
+		mdByteTracker, diskCacheByteTracker,
+		publicCacheByteTracker, syncCacheByteTracker, readSpillByteTracker,
+		params.minFreeBytes, false, make(chan struct{}, 1),
+	}
+	bdl.updateLowSpaceLocked(sampledFreeBytes)
 	return bdl, nil
 }
 
-// defaultDoDelay uses a timer to delay by the given duration.
-func defaultDoDelay(ctx context.Context, delay time.Duration) error {
-	if delay == 0 {
-		return nil
-	}
+// makeClockDoDelay returns a function that uses a timer from the
+// given clock to delay by the given duration.
+func makeClockDoDelay(
+	clock Clock) func(context.Context, time.Duration) error {
+	return func(ctx context.Context, delay time.Duration) error {
+		if delay == 0 {
+			return nil
+		}
 
-	timer := time.NewTimer(delay)
-	select {
-	case <-timer.C:
-		return nil
-	case <-ctx.Done():
-		timer.Stop()
-		return errors.WithStack(ctx.Err())
+		timer := clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.WithStack(ctx.Err())
+		}
 	}
 }
 
+// defaultDoDelay uses a wall-clock timer to delay by the given
+// duration.
+func defaultDoDelay(ctx context.Context, delay time.Duration) error {
+	return makeClockDoDelay(wallClock{})(ctx, delay)
+}
+
 func defaultGetFreeBytesAndFiles(path string) (int64, int64, error) {
 	// getDiskLimits returns availableBytes and availableFiles,
 	// but we want to avoid confusing that with availBytes and
@@ -439,18 +643,33 @@ func (bdl *backpressureDiskLimiter) onJournalDisable(
 	bdl.journalFileTracker.onDisable(journalFiles)
 }
 
+// cacheTrackerLocked returns the tracker for the given disk block
+// cache partition.
+func (bdl *backpressureDiskLimiter) cacheTrackerLocked(
+	typ diskLimitTrackerType) *backpressureTracker {
+	switch typ {
+	case publicCacheLimitTrackerType:
+		return bdl.publicCacheByteTracker
+	case syncCacheLimitTrackerType:
+		return bdl.syncCacheByteTracker
+	case readSpillLimitTrackerType:
+		return bdl.readSpillByteTracker
+	}
+	return bdl.diskCacheByteTracker
+}
+
 func (bdl *backpressureDiskLimiter) onDiskBlockCacheEnable(ctx context.Context,
-	diskCacheBytes int64) {
+	typ diskLimitTrackerType, diskCacheBytes int64) {
 	bdl.lock.Lock()
 	defer bdl.lock.Unlock()
-	bdl.diskCacheByteTracker.onEnable(diskCacheBytes)
+	bdl.cacheTrackerLocked(typ).onEnable(diskCacheBytes)
 }
 
 func (bdl *backpressureDiskLimiter) onDiskBlockCacheDisable(ctx context.Context,
-	diskCacheBytes int64) {
+	typ diskLimitTrackerType, diskCacheBytes int64) {
 	bdl.lock.Lock()
 	defer bdl.lock.Unlock()
-	bdl.diskCacheByteTracker.onDisable(diskCacheBytes)
+	bdl.cacheTrackerLocked(typ).onDisable(diskCacheBytes)
 }
 
 func (bdl *backpressureDiskLimiter) getDelayLocked(
@@ -462,8 +681,9 @@ func (bdl *backpressureDiskLimiter) getDelayLocked(
 	// Set maxDelay to min(bdl.maxDelay, time until deadline - 1s).
 	maxDelay := bdl.maxDelay
 	if deadline, ok := ctx.Deadline(); ok {
-		// Subtract a second to allow for some slack.
-		remainingTime := deadline.Sub(now) - time.Second
+		// Allow for some slack, like the other phases of an
+		// operation do.
+		remainingTime := deadline.Sub(now) - deadlineBudgetSlack
 		if remainingTime < maxDelay {
 			maxDelay = remainingTime
 		}
@@ -482,12 +702,46 @@ func (bdl *backpressureDiskLimiter) updateFreeLocked() (
 		return 0, 0, err
 	}
 
-	bdl.journalFileTracker.updateFree(freeFiles)
-	bdl.journalByteTracker.updateFree(freeBytes + bdl.diskCacheByteTracker.used)
-	bdl.diskCacheByteTracker.updateFree(freeBytes + bdl.journalByteTracker.used)
+	bdl.journalFileTracker.updateFree(bdl.freeFileEstimator.estimateFree(
+		freeFiles, bdl.journalFileTracker.used))
+	// Each byte tracker sees the space used by the others as free,
+	// since it's bounded by its own fraction anyway.
+	byteTrackers := []*backpressureTracker{
+		bdl.journalByteTracker, bdl.journalMDByteTracker,
+		bdl.diskCacheByteTracker, bdl.publicCacheByteTracker, bdl.syncCacheByteTracker,
+		bdl.readSpillByteTracker,
+	}
+	var totalUsed int64
+	for _, bt := range byteTrackers {
+		totalUsed += bt.used
+	}
+	estimatedFreeBytes := bdl.freeByteEstimator.estimateFree(
+		freeBytes, totalUsed)
+	for _, bt := range byteTrackers {
+		bt.updateFree(estimatedFreeBytes + totalUsed - bt.used)
+	}
+	// Never smooth over the minimum, though.
+	bdl.updateLowSpaceLocked(freeBytes)
 	return freeBytes, freeFiles, nil
 }
 
+// updateLowSpaceLocked records whether freeBytes is below the
+// minimum, and signals bdl.lowSpaceCh if it just went below it.
+func (bdl *backpressureDiskLimiter) updateLowSpaceLocked(freeBytes int64) {
+	lowSpace := bdl.minFreeBytes > 0 && freeBytes < bdl.minFreeBytes
+	if lowSpace && !bdl.lowSpace {
+		bdl.log.Warning("Only %d bytes are free on disk, below the "+
+			"minimum of %d", freeBytes, bdl.minFreeBytes)
+		select {
+		case bdl.lowSpaceCh <- struct{}{}:
+		default:
+		}
+	} else if !lowSpace && bdl.lowSpace {
+		bdl.log.Debug("%d bytes are free on disk again", freeBytes)
+	}
+	bdl.lowSpace = lowSpace
+}
+
 func (bdl *backpressureDiskLimiter) beforeBlockPut(
 	ctx context.Context, blockBytes, blockFiles int64) (
 	availableBytes, availableFiles int64, err error) {
@@ -512,8 +766,12 @@ func (bdl *backpressureDiskLimiter) beforeBlockPut(
 		if err != nil {
 			return 0, err
 		}
+		if bdl.lowSpace {
+			return 0, errors.WithStack(DiskSpaceLowError{
+				FreeBytes: freeBytes, MinFreeBytes: bdl.minFreeBytes})
+		}
 
-		delay := bdl.getDelayLocked(ctx, time.Now())
+		delay := bdl.getDelayLocked(ctx, bdl.clock.Now())
 		if delay > 0 {
 			bdl.log.CDebugf(ctx, "Delaying block put of %d bytes and %d files by %f s ("+
 				"journalBytes=%d, freeBytes=%d, "+
@@ -569,18 +827,49 @@ func (bdl *backpressureDiskLimiter) onBlocksDelete(
 	bdl.journalFileTracker.onBlocksDelete(blockFiles)
 }
 
+func (bdl *backpressureDiskLimiter) beforeMDPut(ctx context.Context) (
+	availableBytes int64, err error) {
+	bdl.lock.Lock()
+	defer bdl.lock.Unlock()
+	// Don't check for low space, so that files can still be
+	// removed to free some up.
+	_, _, err = bdl.updateFreeLocked()
+	if err != nil {
+		return bdl.journalMDByteTracker.semaphore.Count(), err
+	}
+	availableBytes = bdl.journalMDByteTracker.semaphore.Count()
+	if availableBytes <= 0 {
+		return availableBytes, errors.WithStack(JournalMDLimitError{
+			UsedBytes:  bdl.journalMDByteTracker.used,
+			LimitBytes: bdl.journalMDByteTracker.semaphoreMax,
+		})
+	}
+	return availableBytes, nil
+}
+
+func (bdl *backpressureDiskLimiter) onJournalMDBytesChange(
+	ctx context.Context, oldBytes, newBytes int64) {
+	bdl.lock.Lock()
+	defer bdl.lock.Unlock()
+	if newBytes > oldBytes {
+		bdl.journalMDByteTracker.onEnable(newBytes - oldBytes)
+	} else if newBytes < oldBytes {
+		bdl.journalMDByteTracker.onDisable(oldBytes - newBytes)
+	}
+}
+
 func (bdl *backpressureDiskLimiter) onDiskBlockCacheDelete(
-	ctx context.Context, blockBytes int64) {
+	ctx context.Context, typ diskLimitTrackerType, blockBytes int64) {
 	if blockBytes == 0 {
 		return
 	}
 	bdl.lock.Lock()
 	defer bdl.lock.Unlock()
-	bdl.diskCacheByteTracker.onBlocksDelete(blockBytes)
+	bdl.cacheTrackerLocked(typ).onBlocksDelete(blockBytes)
 }
 
 func (bdl *backpressureDiskLimiter) beforeDiskBlockCachePut(
-	ctx context.Context, blockBytes int64) (
+	ctx context.Context, typ diskLimitTrackerType, blockBytes int64) (
 	availableBytes int64, err error) {
 	if blockBytes == 0 {
 		// Better to return an error than to panic in ForceAcquire.
@@ -589,19 +878,101 @@ func (bdl *backpressureDiskLimiter) beforeDiskBlockCachePut(
 	}
 	bdl.lock.Lock()
 	defer bdl.lock.Unlock()
-	_, _, err = bdl.updateFreeLocked()
+	freeBytes, _, err := bdl.updateFreeLocked()
 	if err != nil {
 		return 0, err
 	}
+	if bdl.lowSpace {
+		return 0, errors.WithStack(DiskSpaceLowError{
+			FreeBytes: freeBytes, MinFreeBytes: bdl.minFreeBytes})
+	}
 
-	return bdl.diskCacheByteTracker.beforeDiskBlockCachePut(blockBytes), nil
+	return bdl.cacheTrackerLocked(typ).beforeDiskBlockCachePut(blockBytes), nil
 }
 
 func (bdl *backpressureDiskLimiter) afterDiskBlockCachePut(
-	ctx context.Context, blockBytes int64, putData bool) {
+	ctx context.Context, typ diskLimitTrackerType, blockBytes int64,
+	putData bool) {
 	bdl.lock.Lock()
 	defer bdl.lock.Unlock()
-	bdl.diskCacheByteTracker.afterBlockPut(blockBytes, putData)
+	bdl.cacheTrackerLocked(typ).afterBlockPut(blockBytes, putData)
+}
+
+func (bdl *backpressureDiskLimiter) checkLowDiskSpace(
+	ctx context.Context) (freeBytes, minFreeBytes int64, err error) {
+	bdl.lock.Lock()
+	defer bdl.lock.Unlock()
+	freeBytes, _, err = bdl.updateFreeLocked()
+	if err != nil {
+		return 0, 0, err
+	}
+	return freeBytes, bdl.minFreeBytes, nil
+}
+
+func (bdl *backpressureDiskLimiter) lowDiskSpaceCh() <-chan struct{} {
+	return bdl.lowSpaceCh
+}
+
+func (bdl *backpressureDiskLimiter) journalPressure() float64 {
+	bdl.lock.RLock()
+	defer bdl.lock.RUnlock()
+	return math.Max(bdl.journalByteTracker.delayScale(),
+		bdl.journalFileTracker.delayScale())
+}
+
+// getJournalThresholds returns the fractions of the journal's limits
+// at which backpressure starts, and at which it maxes out.
+func (bdl *backpressureDiskLimiter) getJournalThresholds() (
+	minThreshold, maxThreshold float64) {
+	bdl.lock.RLock()
+	defer bdl.lock.RUnlock()
+	return bdl.journalByteTracker.minThreshold,
+		bdl.journalByteTracker.maxThreshold
+}
+
+// setJournalThresholds changes the thresholds of the journal's
+// backpressure, for both bytes and files.
+func (bdl *backpressureDiskLimiter) setJournalThresholds(
+	minThreshold, maxThreshold float64) error {
+	if minThreshold < 0.0 {
+		return errors.Errorf("minThreshold=%f < 0.0", minThreshold)
+	}
+	// Unlike the trackers that are never delayed, the journal's
+	// delay is interpolated between the thresholds, so they can't
+	// be equal.
+	if maxThreshold <= minThreshold {
+		return errors.Errorf("maxThreshold=%f <= minThreshold=%f",
+			maxThreshold, minThreshold)
+	}
+	if 1.0 < maxThreshold {
+		return errors.Errorf("1.0 < maxThreshold=%f", maxThreshold)
+	}
+	bdl.lock.Lock()
+	defer bdl.lock.Unlock()
+	for _, bt := range []*backpressureTracker{
+		bdl.journalByteTracker, bdl.journalFileTracker} {
+		bt.minThreshold = minThreshold
+		bt.maxThreshold = maxThreshold
+	}
+	return nil
+}
+
+func (bdl *backpressureDiskLimiter) getMinFreeBytes() int64 {
+	bdl.lock.RLock()
+	defer bdl.lock.RUnlock()
+	return bdl.minFreeBytes
+}
+
+// setMinFreeBytes changes the number of free bytes below which block
+// puts fail.  It takes effect from the next free space sample.
+func (bdl *backpressureDiskLimiter) setMinFreeBytes(minFreeBytes int64) error {
+	if minFreeBytes < 0 {
+		return errors.Errorf("minFreeBytes=%d < 0", minFreeBytes)
+	}
+	bdl.lock.Lock()
+	defer bdl.lock.Unlock()
+	bdl.minFreeBytes = minFreeBytes
+	return nil
 }
 
 type backpressureDiskLimiterStatus struct {
@@ -610,22 +981,40 @@ type backpressureDiskLimiterStatus struct {
 	// Derived numbers.
 	CurrentDelaySec float64
 
-	ByteTrackerStatus backpressureTrackerStatus
-	FileTrackerStatus backpressureTrackerStatus
+	MinFreeBytes int64
+	LowDiskSpace bool
+
+	ByteTrackerStatus   backpressureTrackerStatus
+	FileTrackerStatus   backpressureTrackerStatus
+	MDByteTrackerStatus backpressureTrackerStatus
+
+	DiskCacheByteTrackerStatus   backpressureTrackerStatus
+	PublicCacheByteTrackerStatus backpressureTrackerStatus
+	SyncCacheByteTrackerStatus   backpressureTrackerStatus
+	ReadSpillByteTrackerStatus   backpressureTrackerStatus
 }
 
 func (bdl *backpressureDiskLimiter) getStatus() interface{} {
 	bdl.lock.RLock()
 	defer bdl.lock.RUnlock()
 
-	currentDelay := bdl.getDelayLocked(context.Background(), time.Now())
+	currentDelay := bdl.getDelayLocked(context.Background(), bdl.clock.Now())
 
 	return backpressureDiskLimiterStatus{
 		Type: "BackpressureDiskLimiter",
 
 		CurrentDelaySec: currentDelay.Seconds(),
 
-		ByteTrackerStatus: bdl.journalByteTracker.getStatus(),
-		FileTrackerStatus: bdl.journalFileTracker.getStatus(),
+		MinFreeBytes: bdl.minFreeBytes,
+		LowDiskSpace: bdl.lowSpace,
+
+		ByteTrackerStatus:   bdl.journalByteTracker.getStatus(),
+		FileTrackerStatus:   bdl.journalFileTracker.getStatus(),
+		MDByteTrackerStatus: bdl.journalMDByteTracker.getStatus(),
+
+		DiskCacheByteTrackerStatus:   bdl.diskCacheByteTracker.getStatus(),
+		PublicCacheByteTrackerStatus: bdl.publicCacheByteTracker.getStatus(),
+		SyncCacheByteTrackerStatus:   bdl.syncCacheByteTracker.getStatus(),
+		ReadSpillByteTrackerStatus:   bdl.readSpillByteTracker.getStatus(),
 	}
 }
@@ -153,15 +153,49 @@ func TestDefaultDoDelayCancel(t *testing.T) {
 	require.Equal(t, ctx.Err(), errors.Cause(err))
 }
 
+// TestClockDoDelay checks that the delay made by makeClockDoDelay
+// follows the given clock rather than the wall clock.
+func TestClockDoDelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), individualTestTimeout)
+	defer cancel()
+
+	clock := newTestClockNow()
+	delayFn := makeClockDoDelay(clock)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- delayFn(ctx, time.Hour)
+	}()
+
+	// Advance the clock until the delay is over; the timer might
+	// not have been made yet on the first few tries.
+	for {
+		clock.Add(time.Hour)
+		select {
+		case err := <-errCh:
+			require.NoError(t, err)
+			return
+		case <-time.After(time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+}
+
 func makeTestBackpressureDiskLimiterParams() backpressureDiskLimiterParams {
 	return backpressureDiskLimiterParams{
-		minThreshold:  0.1,
-		maxThreshold:  0.9,
-		journalFrac:   0.25,
-		diskCacheFrac: 0.1,
-		byteLimit:     400,
-		fileLimit:     40,
-		maxDelay:      8 * time.Second,
+		minThreshold:    0.1,
+		maxThreshold:    0.9,
+		journalFrac:     0.25,
+		journalMDFrac:   0.1,
+		diskCacheFrac:   0.1,
+		publicCacheFrac: 0.05,
+		syncCacheFrac:   0.1,
+		readSpillFrac:   0.1,
+		byteLimit:       400,
+		fileLimit:       40,
+		maxDelay:        8 * time.Second,
+		clock:           wallClock{},
 		delayFn: func(context.Context, time.Duration) error {
 			return nil
 		},
@@ -228,6 +262,171 @@ func TestBackpressureDiskLimiterBeforeBlockPutError(t *testing.T) {
 	require.Equal(t, int64(1), bdl.journalFileTracker.semaphore.Count())
 }
 
+// TestBackpressureDiskLimiterLowSpace checks that block puts fail,
+// and the low space channel is signaled, once the free bytes go
+// below the minimum.
+func TestBackpressureDiskLimiterLowSpace(t *testing.T) {
+	log := logger.NewTestLogger(t)
+	params := makeTestBackpressureDiskLimiterParams()
+	params.byteLimit = 88
+	params.fileLimit = 20
+	params.minFreeBytes = 1000
+	freeBytes := int64(2000)
+	params.freeBytesAndFilesFn = func() (int64, int64, error) {
+		return freeBytes, math.MaxInt64, nil
+	}
+	bdl, err := newBackpressureDiskLimiter(log, params)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, _, err = bdl.beforeBlockPut(ctx, 10, 2)
+	require.NoError(t, err)
+	bdl.afterBlockPut(ctx, 10, 2, true)
+	select {
+	case <-bdl.lowDiskSpaceCh():
+		t.Fatal("Unexpected low space signal")
+	default:
+	}
+
+	freeBytes = 500
+	availBytes, availFiles, err := bdl.beforeBlockPut(ctx, 10, 2)
+	require.Equal(t, DiskSpaceLowError{500, 1000}, errors.Cause(err))
+	// Nothing is acquired for the failed put.
+	require.Equal(t, int64(12), availBytes)
+	require.Equal(t, int64(3), availFiles)
+	_, err = bdl.beforeDiskBlockCachePut(
+		ctx, workingSetCacheLimitTrackerType, 10)
+	require.Equal(t, DiskSpaceLowError{500, 1000}, errors.Cause(err))
+	<-bdl.lowDiskSpaceCh()
+	status := bdl.getStatus().(backpressureDiskLimiterStatus)
+	require.True(t, status.LowDiskSpace)
+
+	free, minFree, err := bdl.checkLowDiskSpace(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(500), free)
+	require.Equal(t, int64(1000), minFree)
+
+	freeBytes = 1500
+	_, _, err = bdl.beforeBlockPut(ctx, 10, 2)
+	require.NoError(t, err)
+	status = bdl.getStatus().(backpressureDiskLimiterStatus)
+	require.False(t, status.LowDiskSpace)
+}
+
+// TestBackpressureDiskLimiterFreeSpaceSmoothing tests that the free
+// bytes seen by the trackers follow the samples with the configured
+// half-life, less the margin, while KBFS's own usage counts right
+// away.
+// TestBackpressureDiskLimiterMDBudget checks that journal MDs have a
+// byte budget that journal blocks can't use up.
+func TestBackpressureDiskLimiterMDBudget(t *testing.T) {
+	log := logger.NewTestLogger(t)
+	params := makeTestBackpressureDiskLimiterParams()
+	params.minFreeBytes = 1000
+	freeBytes := int64(2000)
+	params.freeBytesAndFilesFn = func() (int64, int64, error) {
+		return freeBytes, math.MaxInt64, nil
+	}
+	bdl, err := newBackpressureDiskLimiter(log, params)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	// (byteLimit=400) * (journalFrac=0.25) = 100.
+	availBytes, _ := bdl.onJournalEnable(ctx, 100, 0)
+	require.Equal(t, int64(0), availBytes)
+
+	// (byteLimit=400) * (journalMDFrac=0.1) = 40.
+	availBytes, err = bdl.beforeMDPut(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(40), availBytes)
+
+	bdl.onJournalMDBytesChange(ctx, 0, 30)
+	availBytes, err = bdl.beforeMDPut(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), availBytes)
+
+	bdl.onJournalMDBytesChange(ctx, 30, 45)
+	availBytes, err = bdl.beforeMDPut(ctx)
+	require.Equal(t, JournalMDLimitError{45, 40}, errors.Cause(err))
+	require.Equal(t, int64(-5), availBytes)
+
+	// Low disk space doesn't keep MDs out.
+	bdl.onJournalMDBytesChange(ctx, 45, 20)
+	freeBytes = 500
+	availBytes, err = bdl.beforeMDPut(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(20), availBytes)
+	status := bdl.getStatus().(backpressureDiskLimiterStatus)
+	require.True(t, status.LowDiskSpace)
+	require.Equal(t, int64(20), status.MDByteTrackerStatus.Used)
+
+	bdl.onJournalMDBytesChange(ctx, 20, 0)
+	bdl.onJournalDisable(ctx, 100, 0)
+	status = bdl.getStatus().(backpressureDiskLimiterStatus)
+	require.Equal(t, int64(0), status.MDByteTrackerStatus.Used)
+}
+
+func TestBackpressureDiskLimiterFreeSpaceSmoothing(t *testing.T) {
+	log := logger.NewTestLogger(t)
+	clock := newTestClockNow()
+	params := makeTestBackpressureDiskLimiterParams()
+	params.clock = clock
+	params.freeSpaceHalfLife = time.Minute
+	params.freeSpaceMarginFrac = 0.1
+	freeBytes := int64(1000)
+	params.freeBytesAndFilesFn = func() (int64, int64, error) {
+		return freeBytes, 100, nil
+	}
+	bdl, err := newBackpressureDiskLimiter(log, params)
+	require.NoError(t, err)
+	ctx := context.Background()
+	requireFree := func(expectedBytes, expectedFiles int64) {
+		byteSnapshot, fileSnapshot := bdl.getSnapshotsForTest()
+		require.Equal(t, expectedBytes, byteSnapshot.free)
+		require.Equal(t, expectedFiles, fileSnapshot.free)
+	}
+	requireFree(900, 90)
+
+	t.Log("A brief drop in free space barely counts")
+	freeBytes = 0
+	free, _, err := bdl.checkLowDiskSpace(ctx)
+	require.NoError(t, err)
+	// The raw sample is still reported as is.
+	require.Equal(t, int64(0), free)
+	requireFree(900, 90)
+	clock.Add(time.Minute)
+	_, _, err = bdl.checkLowDiskSpace(ctx)
+	require.NoError(t, err)
+	requireFree(450, 90)
+	freeBytes = 1000
+	clock.Add(time.Minute)
+	_, _, err = bdl.checkLowDiskSpace(ctx)
+	require.NoError(t, err)
+	requireFree(675, 90)
+
+	t.Log("But space used by the journal counts right away")
+	bdl.onJournalEnable(ctx, 100, 10)
+	freeBytes = 900
+	_, _, err = bdl.checkLowDiskSpace(ctx)
+	require.NoError(t, err)
+	// The average of the free bytes plus the journal bytes stays
+	// at 750, and 90% of that, less the journal bytes, is 575.
+	requireFree(575, 80)
+}
+
+func TestBackpressureDiskLimiterFreeSpaceParams(t *testing.T) {
+	log := logger.NewTestLogger(t)
+	params := makeTestBackpressureDiskLimiterParams()
+	params.freeSpaceMarginFrac = 1.0
+	_, err := newBackpressureDiskLimiter(log, params)
+	require.Error(t, err)
+
+	params = makeTestBackpressureDiskLimiterParams()
+	params.freeSpaceHalfLife = -time.Minute
+	_, err = newBackpressureDiskLimiter(log, params)
+	require.Error(t, err)
+}
+
 // TestBackpressureDiskLimiterGetDelay tests the delay calculation,
 // and makes sure it takes into account the context deadline.
 func TestBackpressureDiskLimiterGetDelay(t *testing.T) {
@@ -526,9 +725,11 @@ func TestBackpressureDiskLimiterJournalAndDiskCache(t *testing.T) {
 	for i := 0; i < 2; i++ {
 		// Ensure the disk block cache doesn't interfere with the journal
 		// limits.
-		_, err := bdl.beforeDiskBlockCachePut(ctx, blockBytes)
+		_, err := bdl.beforeDiskBlockCachePut(
+			ctx, workingSetCacheLimitTrackerType, blockBytes)
 		require.NoError(t, err)
-		bdl.afterDiskBlockCachePut(ctx, blockBytes, true)
+		bdl.afterDiskBlockCachePut(
+			ctx, workingSetCacheLimitTrackerType, blockBytes, true)
 		diskCacheBytesPut += blockBytes
 
 		availBytes, _, err :=
@@ -550,9 +751,11 @@ func TestBackpressureDiskLimiterJournalAndDiskCache(t *testing.T) {
 	for i := 1; i < 9; i++ {
 		// Ensure the disk block cache doesn't interfere with the journal
 		// limits.
-		_, err := bdl.beforeDiskBlockCachePut(ctx, blockBytes)
+		_, err := bdl.beforeDiskBlockCachePut(
+			ctx, workingSetCacheLimitTrackerType, blockBytes)
 		require.NoError(t, err)
-		bdl.afterDiskBlockCachePut(ctx, blockBytes, true)
+		bdl.afterDiskBlockCachePut(
+			ctx, workingSetCacheLimitTrackerType, blockBytes, true)
 		diskCacheBytesPut += blockBytes
 
 		availBytes, _, err :=
@@ -0,0 +1,415 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// NetworkType is the kind of network interface that the device's
+// default route goes through, as reported by PlatformHooks.
+type NetworkType int
+
+const (
+	// NetworkTypeUnknown means the platform hooks can't tell.
+	NetworkTypeUnknown NetworkType = iota
+	// NetworkTypeEthernet is a wired connection.
+	NetworkTypeEthernet
+	// NetworkTypeWiFi is a Wi-Fi connection, other than to a
+	// phone's hotspot, where that can be told apart.
+	NetworkTypeWiFi
+	// NetworkTypeTethered is a connection through a phone, over
+	// USB, Bluetooth or its hotspot, or a cellular modem.
+	NetworkTypeTethered
+)
+
+func (t NetworkType) String() string {
+	switch t {
+	case NetworkTypeUnknown:
+		return "unknown"
+	case NetworkTypeEthernet:
+		return "ethernet"
+	case NetworkTypeWiFi:
+		return "wifi"
+	case NetworkTypeTethered:
+		return "tethered"
+	}
+	return fmt.Sprintf("NetworkType(%d)", int(t))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for
+// NetworkType, so that it shows up by name in the status.
+func (t NetworkType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// ParseNetworkType parses the String() form of a NetworkType.
+func ParseNetworkType(s string) (NetworkType, error) {
+	for _, t := range []NetworkType{NetworkTypeUnknown,
+		NetworkTypeEthernet, NetworkTypeWiFi, NetworkTypeTethered} {
+		if strings.ToLower(s) == t.String() {
+			return t, nil
+		}
+	}
+	return NetworkTypeUnknown, errors.Errorf(
+		"Unknown network type %q; must be unknown, ethernet, wifi "+
+			"or tethered", s)
+}
+
+// BandwidthCaps are the rates that block server traffic is held
+// to.  Zero means uncapped.
+type BandwidthCaps struct {
+	UploadBytesPerSecond   int64
+	DownloadBytesPerSecond int64
+}
+
+// formatBandwidthRate formats a rate for BandwidthCaps.String.
+func formatBandwidthRate(bytesPerSecond int64) string {
+	switch {
+	case bytesPerSecond == 0:
+		return "0"
+	case bytesPerSecond%(1024*1024) == 0:
+		return strconv.FormatInt(bytesPerSecond/(1024*1024), 10) + "M"
+	case bytesPerSecond%1024 == 0:
+		return strconv.FormatInt(bytesPerSecond/1024, 10) + "K"
+	}
+	return strconv.FormatInt(bytesPerSecond, 10)
+}
+
+// parseBandwidthRate parses a number of bytes per second, optionally
+// suffixed with K or M for KiB or MiB.
+func parseBandwidthRate(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1024
+		s = strings.TrimSuffix(s, "K")
+	case strings.HasSuffix(s, "M"):
+		mult = 1024 * 1024
+		s = strings.TrimSuffix(s, "M")
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("Bad bandwidth rate %q", s)
+	}
+	return n * mult, nil
+}
+
+// String returns the caps as upload/download, e.g. "256K/0".
+func (c BandwidthCaps) String() string {
+	return formatBandwidthRate(c.UploadBytesPerSecond) + "/" +
+		formatBandwidthRate(c.DownloadBytesPerSecond)
+}
+
+// ParseBandwidthCaps parses the String() form of BandwidthCaps.
+func ParseBandwidthCaps(s string) (BandwidthCaps, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return BandwidthCaps{}, errors.Errorf(
+			"Bandwidth caps %q must be upload/download", s)
+	}
+	up, err := parseBandwidthRate(parts[0])
+	if err != nil {
+		return BandwidthCaps{}, err
+	}
+	down, err := parseBandwidthRate(parts[1])
+	if err != nil {
+		return BandwidthCaps{}, err
+	}
+	return BandwidthCaps{up, down}, nil
+}
+
+// BandwidthPolicy is the caps to apply on each type of network.
+// Network types that aren't listed are uncapped.
+type BandwidthPolicy map[NetworkType]BandwidthCaps
+
+// DefaultBandwidthPolicy returns the policy used unless another one
+// is given: only uploads over tethered connections are capped, since
+// that's where journal flushes run up data bills without the user
+// noticing.
+func DefaultBandwidthPolicy() BandwidthPolicy {
+	return BandwidthPolicy{
+		NetworkTypeTethered: {UploadBytesPerSecond: 256 * 1024},
+	}
+}
+
+// String returns the policy in the form ParseBandwidthPolicy takes.
+func (p BandwidthPolicy) String() string {
+	var entries []string
+	for t, caps := range p {
+		entries = append(entries, t.String()+"="+caps.String())
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// ParseBandwidthPolicy parses a comma-separated list of
+// network=upload/download entries, e.g. "tethered=256K/1M,wifi=0/0",
+// where the rates are bytes per second with an optional K or M
+// suffix, and 0 is uncapped.  "none" is the empty policy.
+func ParseBandwidthPolicy(s string) (BandwidthPolicy, error) {
+	p := make(BandwidthPolicy)
+	if s == "" || s == "none" {
+		return p, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf(
+				"Bandwidth policy entry %q must be network=up/down", entry)
+		}
+		t, err := ParseNetworkType(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		caps, err := ParseBandwidthCaps(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		p[t] = caps
+	}
+	return p, nil
+}
+
+// bandwidthPolicyFlag is for specifying a BandwidthPolicy with the
+// flag package.
+type bandwidthPolicyFlag struct {
+	p *BandwidthPolicy
+}
+
+// String for flag interface.
+func (f bandwidthPolicyFlag) String() string {
+	if f.p == nil {
+		return DefaultBandwidthPolicy().String()
+	}
+	return f.p.String()
+}
+
+// Set for flag interface.
+func (f bandwidthPolicyFlag) Set(raw string) error {
+	p, err := ParseBandwidthPolicy(raw)
+	if err != nil {
+		return err
+	}
+	*f.p = p
+	return nil
+}
+
+// BandwidthStatus says which caps block server traffic is held to,
+// and why.  It is suitable for encoding directly as JSON.
+type BandwidthStatus struct {
+	NetworkType NetworkType
+	// Caps are the caps in effect: the override, if there is one,
+	// and otherwise the policy's caps for the network type.
+	Caps       BandwidthCaps
+	Overridden bool
+}
+
+// bandwidthLimiter holds block server traffic to the caps of the
+// bandwidth policy for the current network type, unless they've
+// been overridden.
+type bandwidthLimiter struct {
+	log logger.Logger
+
+	lock sync.Mutex
+	// up and down are replaced, rather than changed, when the caps
+	// change, since a rate.Limiter's burst is fixed.
+	up          *rate.Limiter
+	down        *rate.Limiter
+	policy      BandwidthPolicy
+	networkType NetworkType
+	override    *BandwidthCaps
+}
+
+func newBandwidthLimiter(log logger.Logger) *bandwidthLimiter {
+	return &bandwidthLimiter{
+		log:    log,
+		up:     makeBandwidthRateLimiter(0),
+		down:   makeBandwidthRateLimiter(0),
+		policy: DefaultBandwidthPolicy(),
+	}
+}
+
+// capsLocked returns the caps in effect.  l.lock must be held.
+func (l *bandwidthLimiter) capsLocked() BandwidthCaps {
+	if l.override != nil {
+		return *l.override
+	}
+	return l.policy[l.networkType]
+}
+
+// makeBandwidthRateLimiter returns a limiter for the given cap.  It
+// lets a second's worth of traffic through at once, so that the cap
+// applies over seconds rather than to each block.
+func makeBandwidthRateLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+// applyLocked updates the rate limiters to the caps in effect.
+// l.lock must be held.
+func (l *bandwidthLimiter) applyLocked(
+	ctx context.Context, oldCaps BandwidthCaps) {
+	caps := l.capsLocked()
+	if caps == oldCaps {
+		return
+	}
+	l.log.CDebugf(ctx, "Bandwidth caps changed from %s to %s "+
+		"(network=%s, overridden=%t)",
+		oldCaps, caps, l.networkType, l.override != nil)
+	if caps.UploadBytesPerSecond != oldCaps.UploadBytesPerSecond {
+		l.up = makeBandwidthRateLimiter(caps.UploadBytesPerSecond)
+	}
+	if caps.DownloadBytesPerSecond != oldCaps.DownloadBytesPerSecond {
+		l.down = makeBandwidthRateLimiter(caps.DownloadBytesPerSecond)
+	}
+}
+
+func (l *bandwidthLimiter) setPolicy(
+	ctx context.Context, policy BandwidthPolicy) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	oldCaps := l.capsLocked()
+	l.policy = policy
+	l.applyLocked(ctx, oldCaps)
+}
+
+func (l *bandwidthLimiter) getPolicy() BandwidthPolicy {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.policy
+}
+
+func (l *bandwidthLimiter) setNetworkType(
+	ctx context.Context, networkType NetworkType) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	oldCaps := l.capsLocked()
+	l.networkType = networkType
+	l.applyLocked(ctx, oldCaps)
+}
+
+// setOverride makes caps apply regardless of the network type, or
+// goes back to the policy if caps is nil.
+func (l *bandwidthLimiter) setOverride(
+	ctx context.Context, caps *BandwidthCaps) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	oldCaps := l.capsLocked()
+	if caps != nil {
+		capsCopy := *caps
+		caps = &capsCopy
+	}
+	l.override = caps
+	l.applyLocked(ctx, oldCaps)
+}
+
+func (l *bandwidthLimiter) getStatus() BandwidthStatus {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return BandwidthStatus{
+		NetworkType: l.networkType,
+		Caps:        l.capsLocked(),
+		Overridden:  l.override != nil,
+	}
+}
+
+func (l *bandwidthLimiter) getLimiter(upload bool) *rate.Limiter {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if upload {
+		return l.up
+	}
+	return l.down
+}
+
+// wait blocks until n more bytes may be uploaded or downloaded, or
+// ctx is done.  Bytes are taken a burst at a time, since blocks can
+// be bigger than a second's worth of traffic.
+func (l *bandwidthLimiter) wait(
+	ctx context.Context, upload bool, n int) error {
+	for n > 0 {
+		// Get the limiter each time, in case the caps changed.
+		limiter := l.getLimiter(upload)
+		chunk := n
+		if limiter.Limit() != rate.Inf && chunk > limiter.Burst() {
+			chunk = limiter.Burst()
+		}
+		err := limiter.WaitN(ctx, chunk)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// The limiter fails right away if the wait would
+			// outlast the deadline.
+			if _, ok := ctx.Deadline(); ok {
+				return context.DeadlineExceeded
+			}
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// blockServerBandwidthLimited delegates to another BlockServer, and
+// holds the blocks it gets and puts to the caps of a
+// bandwidthLimiter.
+type blockServerBandwidthLimited struct {
+	BlockServer
+	limiter *bandwidthLimiter
+}
+
+var _ BlockServer = blockServerBandwidthLimited{}
+
+func newBlockServerBandwidthLimited(delegate BlockServer,
+	limiter *bandwidthLimiter) blockServerBandwidthLimited {
+	return blockServerBandwidthLimited{delegate, limiter}
+}
+
+// Get implements the BlockServer interface for
+// blockServerBandwidthLimited.
+func (b blockServerBandwidthLimited) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	buf, serverHalf, err := b.BlockServer.Get(ctx, tlfID, id, context)
+	if err != nil {
+		return buf, serverHalf, err
+	}
+	// The size isn't known until the block's been fetched, so
+	// charge for it afterwards; that still holds the next fetches
+	// to the cap.
+	err = b.limiter.wait(ctx, false, len(buf))
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	return buf, serverHalf, nil
+}
+
+// Put implements the BlockServer interface for
+// blockServerBandwidthLimited.
+func (b blockServerBandwidthLimited) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	err := b.limiter.wait(ctx, true, len(buf))
+	if err != nil {
+		return err
+	}
+	return b.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestParseBandwidthPolicy(t *testing.T) {
+	policy, err := ParseBandwidthPolicy("tethered=256K/1M,wifi=0/100")
+	require.NoError(t, err)
+	require.Equal(t, BandwidthPolicy{
+		NetworkTypeTethered: {256 * 1024, 1024 * 1024},
+		NetworkTypeWiFi:     {0, 100},
+	}, policy)
+	roundTrip, err := ParseBandwidthPolicy(policy.String())
+	require.NoError(t, err)
+	require.Equal(t, policy, roundTrip)
+
+	policy, err = ParseBandwidthPolicy("none")
+	require.NoError(t, err)
+	require.Len(t, policy, 0)
+
+	for _, bad := range []string{
+		"tethered", "cellular=1/1", "wifi=1", "wifi=1X/0", "wifi=-1/0"} {
+		_, err = ParseBandwidthPolicy(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestKBFSOpsBandwidthCaps(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	policy := BandwidthPolicy{
+		NetworkTypeTethered: {UploadBytesPerSecond: 1024},
+		NetworkTypeWiFi:     {DownloadBytesPerSecond: 2048},
+	}
+	kbfsOps.bandwidth.setPolicy(ctx, policy)
+	getStatus := func() BandwidthStatus {
+		status, _, err := kbfsOps.Status(ctx)
+		require.NoError(t, err)
+		return status.Bandwidth
+	}
+	require.Equal(t, BandwidthStatus{}, getStatus())
+
+	t.Log("The caps follow the network type")
+	setState := func(state PlatformState) {
+		config.SetPlatformHooks(&testPlatformHooks{state})
+		kbfsOps.pollPlatform(ctx)
+	}
+	setState(PlatformState{
+		NetworkMetered: true,
+		NetworkType:    NetworkTypeTethered,
+	})
+	require.Equal(t, BandwidthStatus{
+		NetworkType: NetworkTypeTethered,
+		Caps:        policy[NetworkTypeTethered],
+	}, getStatus())
+
+	setState(PlatformState{NetworkType: NetworkTypeWiFi})
+	require.Equal(t, BandwidthStatus{
+		NetworkType: NetworkTypeWiFi,
+		Caps:        policy[NetworkTypeWiFi],
+	}, getStatus())
+
+	t.Log("An override applies on any network, until it's cleared")
+	override := BandwidthCaps{UploadBytesPerSecond: 10}
+	kbfsOps.OverrideBandwidthCaps(ctx, &override)
+	setState(PlatformState{NetworkType: NetworkTypeEthernet})
+	require.Equal(t, BandwidthStatus{
+		NetworkType: NetworkTypeEthernet,
+		Caps:        override,
+		Overridden:  true,
+	}, getStatus())
+
+	kbfsOps.OverrideBandwidthCaps(ctx, nil)
+	require.Equal(t, BandwidthStatus{
+		NetworkType: NetworkTypeEthernet,
+	}, getStatus())
+}
+
+func TestBlockServerBandwidthLimited(t *testing.T) {
+	ctx := context.Background()
+	limiter := newBandwidthLimiter(newTestLogMaker(t).MakeLogger(""))
+	bserv := newBlockServerBandwidthLimited(
+		NewBlockServerMemory(newTestLogMaker(t).MakeLogger("")), limiter)
+
+	const blockSize = 1000
+	tlfID := tlf.FakeID(1, false)
+	uid := keybase1.MakeTestUID(1)
+	putBlock := func(i byte) (kbfsblock.ID, kbfsblock.Context) {
+		buf := make([]byte, blockSize)
+		buf[0] = i
+		id, err := kbfsblock.MakePermanentID(buf)
+		require.NoError(t, err)
+		bCtx := kbfsblock.MakeFirstContext(uid, keybase1.BlockType_DATA)
+		serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+		require.NoError(t, err)
+		err = bserv.Put(ctx, tlfID, id, bCtx, buf, serverHalf)
+		require.NoError(t, err)
+		return id, bCtx
+	}
+
+	t.Log("Uncapped, puts go straight through")
+	id, bCtx := putBlock(0)
+	_, _, err := bserv.Get(ctx, tlfID, id, bCtx)
+	require.NoError(t, err)
+
+	t.Log("Capped at a block a second, the second put has to wait")
+	caps := BandwidthCaps{
+		UploadBytesPerSecond:   blockSize,
+		DownloadBytesPerSecond: blockSize,
+	}
+	limiter.setOverride(ctx, &caps)
+	putBlock(1)
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	buf := make([]byte, blockSize)
+	buf[0] = 2
+	id2, err := kbfsblock.MakePermanentID(buf)
+	require.NoError(t, err)
+	err = bserv.Put(shortCtx, tlfID, id2, bCtx, buf,
+		kbfscrypto.BlockCryptKeyServerHalf{})
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	t.Log("The same goes for gets")
+	_, _, err = bserv.Get(ctx, tlfID, id, bCtx)
+	require.NoError(t, err)
+	_, _, err = bserv.Get(shortCtx, tlfID, id, bCtx)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
@@ -976,6 +976,51 @@ func (md *BareRootMetadataV2) SetUnmerged() {
 	md.WriterMetadataV2.WFlags |= MetadataFlagUnmerged
 }
 
+// IsFrozen implements the BareRootMetadata interface for BareRootMetadataV2.
+func (md *BareRootMetadataV2) IsFrozen() bool {
+	return (md.WriterMetadataV2.WFlags & MetadataFlagFrozen) != 0
+}
+
+// SetFrozen implements the MutableBareRootMetadata interface for BareRootMetadataV2.
+func (md *BareRootMetadataV2) SetFrozen(frozen bool) {
+	if frozen {
+		md.WriterMetadataV2.WFlags |= MetadataFlagFrozen
+	} else {
+		md.WriterMetadataV2.WFlags &= ^MetadataFlagFrozen
+	}
+}
+
+// IsAppendOnly implements the BareRootMetadata interface for BareRootMetadataV2.
+func (md *BareRootMetadataV2) IsAppendOnly() bool {
+	return (md.WriterMetadataV2.WFlags & MetadataFlagAppendOnly) != 0
+}
+
+// SetAppendOnly implements the MutableBareRootMetadata interface for BareRootMetadataV2.
+func (md *BareRootMetadataV2) SetAppendOnly(appendOnly bool) {
+	if appendOnly {
+		md.WriterMetadataV2.WFlags |= MetadataFlagAppendOnly
+	} else {
+		md.WriterMetadataV2.WFlags &= ^MetadataFlagAppendOnly
+	}
+}
+
+// NameNormalization implements the BareRootMetadata interface for BareRootMetadataV2.
+func (md *BareRootMetadataV2) NameNormalization() NameNormalization {
+	if (md.WriterMetadataV2.WFlags & MetadataFlagNFDNames) != 0 {
+		return NameNormalizationNFD
+	}
+	return NameNormalizationNFC
+}
+
+// SetNameNormalization implements the MutableBareRootMetadata interface for BareRootMetadataV2.
+func (md *BareRootMetadataV2) SetNameNormalization(n NameNormalization) {
+	if n == NameNormalizationNFD {
+		md.WriterMetadataV2.WFlags |= MetadataFlagNFDNames
+	} else {
+		md.WriterMetadataV2.WFlags &= ^MetadataFlagNFDNames
+	}
+}
+
 // SetBranchID implements the MutableBareRootMetadata interface for BareRootMetadataV2.
 func (md *BareRootMetadataV2) SetBranchID(bid BranchID) {
 	md.WriterMetadataV2.BID = bid
@@ -982,6 +982,51 @@ func (md *BareRootMetadataV3) SetUnmerged() {
 	md.WriterMetadata.WFlags |= MetadataFlagUnmerged
 }
 
+// IsFrozen implements the BareRootMetadata interface for BareRootMetadataV3.
+func (md *BareRootMetadataV3) IsFrozen() bool {
+	return (md.WriterMetadata.WFlags & MetadataFlagFrozen) != 0
+}
+
+// SetFrozen implements the MutableBareRootMetadata interface for BareRootMetadataV3.
+func (md *BareRootMetadataV3) SetFrozen(frozen bool) {
+	if frozen {
+		md.WriterMetadata.WFlags |= MetadataFlagFrozen
+	} else {
+		md.WriterMetadata.WFlags &= ^MetadataFlagFrozen
+	}
+}
+
+// IsAppendOnly implements the BareRootMetadata interface for BareRootMetadataV3.
+func (md *BareRootMetadataV3) IsAppendOnly() bool {
+	return (md.WriterMetadata.WFlags & MetadataFlagAppendOnly) != 0
+}
+
+// SetAppendOnly implements the MutableBareRootMetadata interface for BareRootMetadataV3.
+func (md *BareRootMetadataV3) SetAppendOnly(appendOnly bool) {
+	if appendOnly {
+		md.WriterMetadata.WFlags |= MetadataFlagAppendOnly
+	} else {
+		md.WriterMetadata.WFlags &= ^MetadataFlagAppendOnly
+	}
+}
+
+// NameNormalization implements the BareRootMetadata interface for BareRootMetadataV3.
+func (md *BareRootMetadataV3) NameNormalization() NameNormalization {
+	if (md.WriterMetadata.WFlags & MetadataFlagNFDNames) != 0 {
+		return NameNormalizationNFD
+	}
+	return NameNormalizationNFC
+}
+
+// SetNameNormalization implements the MutableBareRootMetadata interface for BareRootMetadataV3.
+func (md *BareRootMetadataV3) SetNameNormalization(n NameNormalization) {
+	if n == NameNormalizationNFD {
+		md.WriterMetadata.WFlags |= MetadataFlagNFDNames
+	} else {
+		md.WriterMetadata.WFlags &= ^MetadataFlagNFDNames
+	}
+}
+
 // SetBranchID implements the MutableBareRootMetadata interface for BareRootMetadataV3.
 func (md *BareRootMetadataV3) SetBranchID(bid BranchID) {
 	md.WriterMetadata.BID = bid
@@ -0,0 +1,330 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// These benchmarks cover the core I/O paths of KBFS, and can be run
+// with:
+//
+//   go test -run=XXX -bench=. -benchmem ./libkbfs | tee new.txt
+//
+// To check for regressions, pipe the output of an older run and a
+// newer run through kbfsbench; see kbfsbench/main.go.
+
+package libkbfs
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// benchmarkIOSizes are the per-operation sizes that the read and
+// write benchmarks are run with.
+var benchmarkIOSizes = []int{4 << 10, 64 << 10, 512 << 10}
+
+// benchmarkFileMask keeps the files written by the benchmarks under
+// 1 MB, so that they measure the steady-state cost of an operation
+// rather than the cost of a file that grows with b.N.
+const benchmarkFileMask = 0xFFFFF
+
+func benchmarkCreateFile(b *testing.B, config Config, ctx context.Context,
+	name string) Node {
+	rootNode := GetRootNodeOrBust(ctx, b, config, "test_user", false)
+	fileNode, _, err := config.KBFSOps().CreateFile(
+		ctx, rootNode, name, false, NoExcl)
+	require.NoError(b, err)
+	return fileNode
+}
+
+func benchmarkFolderBlockOpsWrite(b *testing.B, size int) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(noLogTB{b}, "test_user")
+	defer kbfsTestShutdownNoMocks(b, config, ctx, cancel)
+
+	kbfsOps := config.KBFSOps()
+	fileNode := benchmarkCreateFile(b, config, ctx, "a")
+	buf := make([]byte, size)
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Make each block unique.
+		buf[0] = byte(i)
+		buf[1] = byte(i >> 8)
+		off := (int64(i) * int64(size)) & benchmarkFileMask
+		err := kbfsOps.Write(ctx, fileNode, buf, off)
+		require.NoError(b, err)
+		err = kbfsOps.Sync(ctx, fileNode)
+		require.NoError(b, err)
+	}
+	b.StopTimer()
+}
+
+// BenchmarkFolderBlockOpsWrite measures the throughput of writing and
+// syncing a file, in writes of different sizes.
+func BenchmarkFolderBlockOpsWrite(b *testing.B) {
+	for _, size := range benchmarkIOSizes {
+		size := size // capture range variable.
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			benchmarkFolderBlockOpsWrite(b, size)
+		})
+	}
+}
+
+func benchmarkFolderBlockOpsRead(b *testing.B, size int) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(noLogTB{b}, "test_user")
+	defer kbfsTestShutdownNoMocks(b, config, ctx, cancel)
+
+	kbfsOps := config.KBFSOps()
+	fileNode := benchmarkCreateFile(b, config, ctx, "a")
+	data := make([]byte, benchmarkFileMask+1)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	err := kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(b, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(b, err)
+	buf := make([]byte, size)
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		off := (int64(i) * int64(size)) & benchmarkFileMask
+		n, err := kbfsOps.Read(ctx, fileNode, buf, off)
+		require.NoError(b, err)
+		require.Equal(b, int64(size), n)
+	}
+	b.StopTimer()
+}
+
+// BenchmarkFolderBlockOpsRead measures the throughput of reading a
+// synced file, in reads of different sizes.
+func BenchmarkFolderBlockOpsRead(b *testing.B) {
+	for _, size := range benchmarkIOSizes {
+		size := size // capture range variable.
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			benchmarkFolderBlockOpsRead(b, size)
+		})
+	}
+}
+
+func benchmarkJournalFlushBody(b *testing.B, fileCount, fileSize int) {
+	b.StopTimer()
+
+	tempdir, err := ioutil.TempDir(os.TempDir(), "benchmark_journal")
+	require.NoError(b, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(b, err)
+	}()
+
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(noLogTB{b}, "test_user")
+	defer kbfsTestShutdownNoMocks(b, config, ctx, cancel)
+
+	_, err = config.MakeDiskLimiter(tempdir)
+	require.NoError(b, err)
+	err = config.EnableJournaling(
+		ctx, tempdir, TLFJournalBackgroundWorkPaused)
+	require.NoError(b, err)
+	jServer, err := GetJournalServer(config)
+	require.NoError(b, err)
+
+	rootNode := GetRootNodeOrBust(ctx, b, config, "test_user", false)
+	tlfID := rootNode.GetFolderBranch().Tlf
+	// Getting the root node auto-enables the journal, with background
+	// work enabled.
+	jServer.PauseBackgroundWork(ctx, tlfID)
+
+	// Fill up the journal with a block and a couple of MDs per
+	// file.  Note that the journal squashes the MDs once there are
+	// enough of them.
+	kbfsOps := config.KBFSOps()
+	data := make([]byte, fileSize)
+	for i := 0; i < fileCount; i++ {
+		fileNode, _, err := kbfsOps.CreateFile(
+			ctx, rootNode, fmt.Sprintf("file%d", i), false, NoExcl)
+		require.NoError(b, err)
+		data[0] = byte(i)
+		err = kbfsOps.Write(ctx, fileNode, data, 0)
+		require.NoError(b, err)
+		err = kbfsOps.Sync(ctx, fileNode)
+		require.NoError(b, err)
+	}
+	status, err := jServer.JournalStatus(tlfID)
+	require.NoError(b, err)
+	require.NotEqual(b, int64(0), status.UnflushedBytes)
+
+	// Flushing a journal with enough MDs converts it to a local
+	// squash branch, which then has to be resolved before the rest
+	// is flushed, so include all of that.
+	b.StartTimer()
+	jServer.ResumeBackgroundWork(ctx, tlfID)
+	err = kbfsOps.SyncFromServerForTesting(ctx, rootNode.GetFolderBranch())
+	b.StopTimer()
+	require.NoError(b, err)
+	status, err = jServer.JournalStatus(tlfID)
+	require.NoError(b, err)
+	require.Equal(b, int64(0), status.UnflushedBytes)
+}
+
+// BenchmarkJournalFlush measures the throughput of flushing a journal
+// full of file writes to the servers.
+func BenchmarkJournalFlush(b *testing.B) {
+	const fileSize = 64 << 10
+	for _, fileCount := range []int{1, 10, 100} {
+		fileCount := fileCount // capture range variable.
+		b.Run(fmt.Sprintf("fileCount=%d", fileCount), func(b *testing.B) {
+			b.SetBytes(int64(fileCount * fileSize))
+			b.StopTimer()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				benchmarkJournalFlushBody(b, fileCount, fileSize)
+			}
+		})
+	}
+}
+
+func benchmarkCRLargeBranchBody(b *testing.B, branchSize int) {
+	b.StopTimer()
+
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(
+		noLogTB{b}, userName1, userName2)
+	defer kbfsTestShutdownNoMocks(b, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, b, config2)
+
+	name := userName1.String() + "," + userName2.String()
+
+	rootNode1 := GetRootNodeOrBust(ctx, b, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	dirA1, _, err := kbfsOps1.CreateDir(ctx, rootNode1, "a")
+	require.NoError(b, err)
+
+	rootNode2 := GetRootNodeOrBust(ctx, b, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	dirA2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(b, err)
+	fb := rootNode2.GetFolderBranch()
+
+	c, err := DisableUpdatesForTesting(config2, fb)
+	require.NoError(b, err)
+	err = DisableCRForTesting(config2, fb)
+	require.NoError(b, err)
+
+	// User 1 makes one merged change, and user 2 makes a whole
+	// branch of unmerged ones.
+	_, _, err = kbfsOps1.CreateFile(ctx, dirA1, "merged", false, NoExcl)
+	require.NoError(b, err)
+	for i := 0; i < branchSize; i++ {
+		_, _, err = kbfsOps2.CreateFile(
+			ctx, dirA2, fmt.Sprintf("unmerged%d", i), false, NoExcl)
+		require.NoError(b, err)
+	}
+
+	b.StartTimer()
+	c <- struct{}{}
+	err = RestartCRForTesting(
+		BackgroundContextWithCancellationDelayer(), config2, fb)
+	require.NoError(b, err)
+	err = kbfsOps2.SyncFromServerForTesting(ctx, fb)
+	b.StopTimer()
+	require.NoError(b, err)
+}
+
+// BenchmarkCRLargeBranch measures how long it takes to resolve an
+// unmerged branch with many revisions against a merged change.
+func BenchmarkCRLargeBranch(b *testing.B) {
+	for _, branchSize := range []int{10, 100} {
+		branchSize := branchSize // capture range variable.
+		b.Run(fmt.Sprintf("branchSize=%d", branchSize), func(b *testing.B) {
+			b.StopTimer()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				benchmarkCRLargeBranchBody(b, branchSize)
+			}
+		})
+	}
+}
+
+// benchmarkDiskBlockCacheBlockSize is the size of the blocks that the
+// disk cache benchmarks use.
+const benchmarkDiskBlockCacheBlockSize = 64 << 10
+
+func initDiskBlockCacheBenchmark(b *testing.B) (
+	*DiskBlockCacheStandard, tlf.ID, []byte,
+	kbfscrypto.BlockCryptKeyServerHalf) {
+	config := &testDiskBlockCacheConfig{
+		newTestCodecGetter(),
+		testLogMaker{logger.NewTestLogger(noLogTB{b})},
+		newTestClockGetter(),
+		nil,
+		DiskCacheBackendLevelDB,
+	}
+	cache, err := newDiskBlockCacheStandardForTest(config,
+		testDiskBlockCacheMaxBytes, nil)
+	require.NoError(b, err)
+	buf := make([]byte, benchmarkDiskBlockCacheBlockSize)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(b, err)
+	return cache, tlf.FakeID(1, false), buf, serverHalf
+}
+
+// BenchmarkDiskBlockCachePut measures the throughput of putting new
+// blocks into the disk cache.
+func BenchmarkDiskBlockCachePut(b *testing.B) {
+	cache, tlfID, buf, serverHalf := initDiskBlockCacheBenchmark(b)
+	defer shutdownDiskBlockCacheTest(cache)
+	ctx := context.Background()
+
+	ids := make([]kbfsblock.ID, b.N)
+	for i := range ids {
+		id, err := kbfsblock.MakeTemporaryID()
+		require.NoError(b, err)
+		ids[i] = id
+	}
+
+	b.SetBytes(benchmarkDiskBlockCacheBlockSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := cache.Put(ctx, tlfID, ids[i], buf, serverHalf)
+		require.NoError(b, err)
+	}
+	b.StopTimer()
+}
+
+// BenchmarkDiskBlockCacheGet measures the throughput of getting
+// blocks from the disk cache.
+func BenchmarkDiskBlockCacheGet(b *testing.B) {
+	cache, tlfID, buf, serverHalf := initDiskBlockCacheBenchmark(b)
+	defer shutdownDiskBlockCacheTest(cache)
+	ctx := context.Background()
+
+	const blockCount = 1000
+	ids := make([]kbfsblock.ID, blockCount)
+	for i := range ids {
+		id, err := kbfsblock.MakeTemporaryID()
+		require.NoError(b, err)
+		ids[i] = id
+		err = cache.Put(ctx, tlfID, id, buf, serverHalf)
+		require.NoError(b, err)
+	}
+
+	b.SetBytes(benchmarkDiskBlockCacheBlockSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, err := cache.Get(ctx, tlfID, ids[i%blockCount])
+		require.NoError(b, err)
+	}
+	b.StopTimer()
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import "sync"
+
+// maxPooledBlockBufferSize is the capacity of the largest buffer
+// blockBuffers keeps.  It comfortably fits a maximum-size block,
+// with its encryption and padding overhead, so that one odd huge
+// buffer isn't kept alive by the pool.
+const maxPooledBlockBufferSize = 2 * MaxBlockSizeBytesDefault
+
+// blockBuffers recycles the buffers that hold a block's encoded,
+// encrypted or padded bytes on the way from the disk block cache or
+// block server to a decoded Block.  Those bytes are all dead once
+// the block is decoded, since decoding copies the block contents
+// out, so there's no need to make the garbage collector deal with a
+// few block-sized allocations for every block read.
+var blockBuffers sync.Pool
+
+// getBlockBuffer returns an empty buffer with room for at least size
+// bytes, recycled if possible.
+func getBlockBuffer(size int) []byte {
+	if p, ok := blockBuffers.Get().(*[]byte); ok {
+		if cap(*p) >= size {
+			return (*p)[:0]
+		}
+		// Too small for this block, but fine for the next.
+		blockBuffers.Put(p)
+	}
+	return make([]byte, 0, size)
+}
+
+// putBlockBuffer recycles buf.  The caller must not use buf, or any
+// slice sharing its memory, afterwards.
+func putBlockBuffer(buf []byte) {
+	if cap(buf) == 0 || cap(buf) > maxPooledBlockBufferSize {
+		return
+	}
+	buf = buf[:0]
+	blockBuffers.Put(&buf)
+}
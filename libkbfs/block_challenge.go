@@ -0,0 +1,235 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const (
+	// How many archived block pointers each TLF remembers as
+	// candidates for retrievability challenges.
+	blockChallengeSampleSize = 256
+	// How many candidates each challenge round fetches.
+	blockChallengesPerRound = 8
+)
+
+// BlockChallengeStatus describes the retrievability challenges
+// issued for the archived blocks of a particular folder-branch.  It
+// is suitable for encoding directly as JSON.
+type BlockChallengeStatus struct {
+	// Candidates is the number of archived blocks currently sampled.
+	Candidates int
+	LastRun    time.Time
+	// The totals cover every round since this folder was loaded.
+	// Reclaimed blocks were missing from the server, but had
+	// legitimately been deleted by quota reclamation.
+	TotalChallenged int
+	TotalVerified   int
+	TotalReclaimed  int
+	TotalFailed     int
+	LastFailure     string `json:",omitempty"`
+}
+
+type archivedBlockSample struct {
+	ptr BlockPointer
+	// rev is the revision that unreferenced ptr.
+	rev MetadataRevision
+}
+
+// blockChallenger keeps a uniform random sample of the blocks a TLF
+// has archived, and checks that the block server can still return
+// them intact.  The block server could otherwise lose archived
+// blocks without anyone noticing until someone needs the history.
+type blockChallenger struct {
+	config Config
+	// auditLog records the result of every challenge.
+	auditLog logger.Logger
+	id       tlf.ID
+	randIntn func(n int) int
+
+	lock    sync.Mutex
+	numSeen int
+	sample  []archivedBlockSample
+	status  BlockChallengeStatus
+}
+
+func newBlockChallenger(config Config, id tlf.ID) *blockChallenger {
+	return &blockChallenger{
+		config:   config,
+		auditLog: config.MakeLogger(fmt.Sprintf("AUDIT %s", id.String()[:8])),
+		id:       id,
+		randIntn: rand.Intn,
+	}
+}
+
+// addArchived offers newly-archived pointers to the sample, keeping
+// each one seen so far with equal probability.
+func (bc *blockChallenger) addArchived(
+	ptrs []BlockPointer, rev MetadataRevision) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	for _, ptr := range ptrs {
+		bc.numSeen++
+		s := archivedBlockSample{ptr, rev}
+		if len(bc.sample) < blockChallengeSampleSize {
+			bc.sample = append(bc.sample, s)
+			continue
+		}
+		if i := bc.randIntn(bc.numSeen); i < len(bc.sample) {
+			bc.sample[i] = s
+		}
+	}
+}
+
+func (bc *blockChallenger) removeLocked(ptrs map[BlockPointer]bool) {
+	kept := bc.sample[:0]
+	for _, s := range bc.sample {
+		if !ptrs[s.ptr] {
+			kept = append(kept, s)
+		}
+	}
+	bc.sample = kept
+}
+
+// removeDeleted drops pointers whose references were deleted on
+// purpose, so that they aren't reported as lost.
+func (bc *blockChallenger) removeDeleted(ptrs []BlockPointer) {
+	deleted := make(map[BlockPointer]bool, len(ptrs))
+	for _, ptr := range ptrs {
+		deleted[ptr] = true
+	}
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	bc.removeLocked(deleted)
+}
+
+// pick returns up to n distinct sampled pointers, chosen at random.
+func (bc *blockChallenger) pick(n int) []archivedBlockSample {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	if n > len(bc.sample) {
+		n = len(bc.sample)
+	}
+	picked := make([]archivedBlockSample, 0, n)
+	for _, i := range rand.Perm(len(bc.sample))[:n] {
+		picked = append(picked, bc.sample[i])
+	}
+	return picked
+}
+
+// challenge fetches the block for s from the block server, and
+// checks that its contents still hash to its ID.
+func (bc *blockChallenger) challenge(
+	ctx context.Context, s archivedBlockSample) error {
+	buf, _, err := bc.config.BlockServer().Get(
+		ctx, bc.id, s.ptr.ID, s.ptr.Context)
+	if err != nil {
+		return err
+	}
+	err = kbfsblock.VerifyID(buf, s.ptr.ID)
+	if err != nil {
+		return BlockChallengeFailedError{bc.id, s.ptr, s.rev, err}
+	}
+	return nil
+}
+
+func isMissingBlockError(err error) bool {
+	switch err.(type) {
+	case kbfsblock.BServerErrorBlockNonExistent,
+		kbfsblock.BServerErrorBlockDeleted, blockNonExistentError:
+		return true
+	default:
+		return false
+	}
+}
+
+func isBlockChallengeFailedError(err error) bool {
+	_, ok := err.(BlockChallengeFailedError)
+	return ok
+}
+
+// challengeRound challenges a random selection of the sampled
+// pointers, and returns the ones that failed.  lastGCRev returns the
+// latest revision covered by quota reclamation, which explains a
+// missing block that some other device reclaimed.
+func (bc *blockChallenger) challengeRound(ctx context.Context,
+	lastGCRev func(context.Context) (MetadataRevision, error)) (
+	failed []BlockPointer, err error) {
+	picked := bc.pick(blockChallengesPerRound)
+	if len(picked) == 0 {
+		return nil, nil
+	}
+
+	var verified int
+	reclaimed := make(map[BlockPointer]bool)
+	var lastFailure error
+	defer func() {
+		bc.lock.Lock()
+		defer bc.lock.Unlock()
+		bc.removeLocked(reclaimed)
+		bc.status.LastRun = bc.config.Clock().Now()
+		bc.status.TotalChallenged += verified + len(reclaimed) + len(failed)
+		bc.status.TotalVerified += verified
+		bc.status.TotalReclaimed += len(reclaimed)
+		bc.status.TotalFailed += len(failed)
+		if lastFailure != nil {
+			bc.status.LastFailure = lastFailure.Error()
+		}
+	}()
+
+	gcRev := MetadataRevisionUninitialized
+	for _, s := range picked {
+		err := bc.challenge(ctx, s)
+		switch {
+		case err == nil:
+			bc.auditLog.CDebugf(ctx, "Archived block %v (revision %d) "+
+				"verified", s.ptr, s.rev)
+			verified++
+			continue
+		case isMissingBlockError(err):
+			if gcRev == MetadataRevisionUninitialized {
+				gcRev, err = lastGCRev(ctx)
+				if err != nil {
+					return failed, err
+				}
+			}
+			if s.rev <= gcRev {
+				bc.auditLog.CDebugf(ctx, "Archived block %v (revision %d) "+
+					"was reclaimed at or before revision %d",
+					s.ptr, s.rev, gcRev)
+				reclaimed[s.ptr] = true
+				continue
+			}
+			err = BlockChallengeFailedError{bc.id, s.ptr, s.rev, err}
+		case isBlockChallengeFailedError(err):
+			// The server returned the wrong data.
+		default:
+			// Probably a connection problem, not data loss, so try
+			// again next round.
+			return failed, err
+		}
+		bc.auditLog.CWarningf(ctx, "%v", err)
+		failed = append(failed, s.ptr)
+		lastFailure = err
+	}
+	return failed, nil
+}
+
+func (bc *blockChallenger) getStatus() BlockChallengeStatus {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	status := bc.status
+	status.Candidates = len(bc.sample)
+	return status
+}
@@ -16,6 +16,15 @@ type blockGetter interface {
 	getBlock(context.Context, KeyMetadata, BlockPointer, Block) error
 }
 
+// cachedBlocksGetter is implemented by blockGetters that can get
+// many blocks at once from the disk block cache.
+type cachedBlocksGetter interface {
+	// getCachedBlocks fills in each of the given blocks that's in
+	// the disk block cache, and returns which ones it filled in.
+	getCachedBlocks(ctx context.Context, kmd KeyMetadata,
+		ptrs []BlockPointer, blocks []Block) (found []bool)
+}
+
 // realBlockGetter obtains real blocks using the APIs available in Config.
 type realBlockGetter struct {
 	config blockOpsConfig
@@ -41,3 +50,42 @@ func (bg *realBlockGetter) getBlock(ctx context.Context, kmd KeyMetadata, blockP
 		ctx, bg.config.keyGetter(), bg.config.Codec(), bg.config.cryptoPure(),
 		kmd, blockPtr, block, buf, blockServerHalf)
 }
+
+// getCachedBlocks implements the cachedBlocksGetter interface for
+// realBlockGetter.
+func (bg *realBlockGetter) getCachedBlocks(ctx context.Context,
+	kmd KeyMetadata, ptrs []BlockPointer, blocks []Block) (found []bool) {
+	found = make([]bool, len(ptrs))
+	dbcg, ok := bg.config.(diskBlockCacheGetter)
+	if !ok {
+		return found
+	}
+	dbc := dbcg.DiskBlockCache()
+	if dbc == nil {
+		return found
+	}
+	ids := make([]kbfsblock.ID, 0, len(ptrs))
+	for _, ptr := range ptrs {
+		ids = append(ids, ptr.ID)
+	}
+	cached, err := dbc.GetBatch(ctx, kmd.TlfID(), ids)
+	if err != nil {
+		return found
+	}
+	for i, ptr := range ptrs {
+		c, ok := cached[ptr.ID]
+		if !ok {
+			continue
+		}
+		err := assembleBlock(
+			ctx, bg.config.keyGetter(), bg.config.Codec(),
+			bg.config.cryptoPure(), kmd, ptr, blocks[i], c.Buf, c.ServerHalf)
+		found[i] = err == nil
+	}
+	// The same block may have been requested twice, so only
+	// recycle the buffers once every block is assembled.
+	for _, c := range cached {
+		putBlockBuffer(c.Buf)
+	}
+	return found
+}
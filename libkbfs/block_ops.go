@@ -64,8 +64,13 @@ func (b *BlockOpsStandard) Get(ctx context.Context, kmd KeyMetadata,
 		}
 	}
 
-	errCh := b.queue.Request(ctx, defaultOnDemandRequestPriority, kmd, blockPtr, block, lifetime)
-	return <-errCh
+	return runWithPhaseBudget(ctx, opPhaseBlockFetch,
+		func(ctx context.Context) error {
+			errCh := b.queue.Request(ctx,
+				blockRequestPriorityFromContext(ctx), kmd, blockPtr, block,
+				lifetime)
+			return <-errCh
+		})
 }
 
 // GetEncodedSize implements the BlockOps interface for
@@ -89,7 +94,7 @@ func (b *BlockOpsStandard) GetEncodedSize(ctx context.Context, kmd KeyMetadata,
 	// can't trust the server to report the size without being able
 	// to verify the BlockID.
 	block := NewCommonBlock()
-	errCh := b.queue.Request(ctx, defaultOnDemandRequestPriority, kmd,
+	errCh := b.queue.Request(ctx, blockRequestPriorityFromContext(ctx), kmd,
 		blockPtr, block, NoCacheEntry)
 	err := <-errCh
 	if err != nil {
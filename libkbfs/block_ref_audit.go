@@ -0,0 +1,206 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// BlockRefAudit describes the block references of a TLF as they
+// should exist on the block server, recomputed from the TLF's merged
+// MD history and its current directory tree.  Block references that
+// never made it into the MD history at all (e.g., from a sync that
+// crashed before its MD was put) can't be found this way.
+type BlockRefAudit struct {
+	TlfID tlf.ID
+	// Revision is the merged head that was audited.
+	Revision MetadataRevision
+	// GCRevision is the latest revision covered by quota
+	// reclamation.
+	GCRevision MetadataRevision
+	// Live are the references reachable from the head, which must
+	// be left alone.
+	Live []BlockPointer
+	// ToArchive are the references unreferenced since the last
+	// quota reclamation; they should stay archived until the next
+	// reclamation deletes them.
+	ToArchive []BlockPointer
+	// ToDelete are the references unreferenced at or before the
+	// last quota reclamation, which should already be deleted.
+	ToDelete []BlockPointer
+	// Leaked are the references that the MD history never
+	// unreferenced, but that aren't reachable from the head, so
+	// quota reclamation will never clean them up.
+	Leaked []BlockPointer
+}
+
+func (a BlockRefAudit) String() string {
+	return fmt.Sprintf("TLF %s at revision %d (gc revision %d): %d live, "+
+		"%d to archive, %d to delete, %d leaked", a.TlfID, a.Revision,
+		a.GCRevision, len(a.Live), len(a.ToArchive), len(a.ToDelete),
+		len(a.Leaked))
+}
+
+func getStandardOpsForAudit(
+	ctx context.Context, config Config, tlfID tlf.ID) (
+	*folderBranchOps, error) {
+	kbfsOps, ok := config.KBFSOps().(*KBFSOpsStandard)
+	if !ok {
+		return nil, errors.New("Unexpected KBFSOps type")
+	}
+	fb := FolderBranch{tlfID, MasterBranch}
+	return kbfsOps.getOps(ctx, fb, FavoritesOpNoChange), nil
+}
+
+// AuditBlockRefs recomputes the block references of the given TLF
+// from its entire merged MD history, and compares them with the
+// blocks reachable from its current head.  It loads the whole
+// history into memory, so it's only meant for offline tools.
+func AuditBlockRefs(ctx context.Context, config Config, tlfID tlf.ID) (
+	audit BlockRefAudit, err error) {
+	ops, err := getStandardOpsForAudit(ctx, config, tlfID)
+	if err != nil {
+		return BlockRefAudit{}, err
+	}
+
+	rmds, err := getMergedMDUpdates(ctx, config, tlfID,
+		MetadataRevisionInitial)
+	if err != nil {
+		return BlockRefAudit{}, err
+	}
+	audit.TlfID = tlfID
+	if len(rmds) == 0 {
+		return audit, nil
+	}
+
+	for _, rmd := range rmds {
+		// Don't process copies.
+		if rmd.IsWriterMetadataCopiedSet() {
+			continue
+		}
+		for _, op := range rmd.data.Changes.Ops {
+			if gcOp, ok := op.(*GCOp); ok {
+				audit.GCRevision = gcOp.LatestRev
+			}
+		}
+	}
+
+	// Replay the history.  The last revision in which each pointer
+	// was unreferenced decides what should have happened to it.
+	historyLive := make(map[BlockPointer]bool)
+	unrefRevs := make(map[BlockPointer]MetadataRevision)
+	cleanNow := make(map[BlockPointer]bool)
+	unref := func(ptr BlockPointer, rev MetadataRevision, clean bool) {
+		delete(historyLive, ptr)
+		if ptr == zeroPtr {
+			return
+		}
+		unrefRevs[ptr] = rev
+		if clean {
+			cleanNow[ptr] = true
+		}
+	}
+	for _, rmd := range rmds {
+		if rmd.IsWriterMetadataCopiedSet() {
+			continue
+		}
+		for _, op := range rmd.data.Changes.Ops {
+			opRefs := make(map[BlockPointer]bool)
+			for _, ptr := range op.Refs() {
+				if ptr != zeroPtr {
+					historyLive[ptr] = true
+					opRefs[ptr] = true
+				}
+			}
+			if _, isGCOp := op.(*GCOp); !isGCOp {
+				for _, ptr := range op.Unrefs() {
+					// A pointer referenced and unreferenced within
+					// the same op comes from a failed and retried
+					// sync, and was never visible to anyone.
+					unref(ptr, rmd.Revision(), opRefs[ptr])
+				}
+			}
+			for _, update := range op.allUpdates() {
+				if update.Unref != update.Ref {
+					unref(update.Unref, rmd.Revision(), false)
+				}
+				if update.Ref != zeroPtr {
+					historyLive[update.Ref] = true
+				}
+			}
+		}
+	}
+
+	// Find everything that's reachable from the head.
+	head := rmds[len(rmds)-1]
+	audit.Revision = head.Revision()
+	rootNode, _, _, err := ops.getRootNode(ctx)
+	if err != nil {
+		return BlockRefAudit{}, err
+	}
+	rootPath := ops.nodeCache.PathFromNode(rootNode)
+	reachable := map[BlockPointer]uint32{
+		rootPath.tailPointer(): head.data.Dir.EncodedSize,
+	}
+	sc := NewStateChecker(config)
+	err = sc.findAllBlocksInPath(ctx, makeFBOLockState(), ops,
+		head.ReadOnly(), rootPath, reachable)
+	if err != nil {
+		return BlockRefAudit{}, err
+	}
+
+	for ptr := range reachable {
+		audit.Live = append(audit.Live, ptr)
+	}
+	for ptr := range historyLive {
+		if _, ok := reachable[ptr]; !ok {
+			audit.Leaked = append(audit.Leaked, ptr)
+		}
+	}
+	for ptr, rev := range unrefRevs {
+		if _, ok := reachable[ptr]; ok || historyLive[ptr] {
+			continue
+		}
+		if rev <= audit.GCRevision || cleanNow[ptr] {
+			audit.ToDelete = append(audit.ToDelete, ptr)
+		} else {
+			audit.ToArchive = append(audit.ToArchive, ptr)
+		}
+	}
+	return audit, nil
+}
+
+// RepairBlockRefs reconciles the block server with the given audit:
+// it archives the references that should be archived, and deletes
+// the references that should be deleted or that have leaked.  Both
+// are safe to repeat for references that are already in the right
+// state.
+func RepairBlockRefs(
+	ctx context.Context, config Config, audit BlockRefAudit) error {
+	ops, err := getStandardOpsForAudit(ctx, config, audit.TlfID)
+	if err != nil {
+		return err
+	}
+	if len(audit.ToArchive) > 0 {
+		_, err := ops.fbm.doChunkedDowngrades(
+			ctx, audit.TlfID, audit.ToArchive, true)
+		if err != nil {
+			return err
+		}
+	}
+	toDelete := append(
+		append([]BlockPointer(nil), audit.ToDelete...), audit.Leaked...)
+	if len(toDelete) > 0 {
+		_, err := ops.fbm.deleteBlockRefs(ctx, audit.TlfID, toDelete)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockRefAuditAndRepair(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), false)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+	_, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	err = kbfsOps.SyncFromServerForTesting(ctx, fb)
+	require.NoError(t, err)
+
+	// Everything unreferenced so far is waiting to be reclaimed.
+	audit, err := AuditBlockRefs(ctx, config, fb.Tlf)
+	require.NoError(t, err)
+	require.Equal(t, MetadataRevisionUninitialized, audit.GCRevision)
+	require.Len(t, audit.Live, 2)
+	require.NotEmpty(t, audit.ToArchive)
+	require.Empty(t, audit.ToDelete)
+	require.Empty(t, audit.Leaked)
+
+	// Simulate a reclamation that crashed after recording its gc op,
+	// without deleting anything.
+	head, err := config.MDOps().GetForTLF(ctx, fb.Tlf)
+	require.NoError(t, err)
+	rmdNext, err := head.MakeSuccessor(ctx, config.MetadataVersion(),
+		config.Codec(), config.Crypto(), config.KeyManager(), head.MdID(),
+		true)
+	require.NoError(t, err)
+	rmdNext.AddOp(newGCOp(head.Revision()))
+	rmdNext.SetLastGCRevision(head.Revision())
+	_, err = config.MDOps().Put(ctx, rmdNext)
+	require.NoError(t, err)
+	err = kbfsOps.SyncFromServerForTesting(ctx, fb)
+	require.NoError(t, err)
+
+	audit, err = AuditBlockRefs(ctx, config, fb.Tlf)
+	require.NoError(t, err)
+	require.Equal(t, head.Revision(), audit.GCRevision)
+	require.Empty(t, audit.ToArchive)
+	require.NotEmpty(t, audit.ToDelete)
+
+	bserverLocal, ok := config.BlockServer().(blockServerLocal)
+	require.True(t, ok)
+	preRepairBlocks, err := bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+	require.NoError(t, err)
+	for _, ptr := range audit.ToDelete {
+		require.Contains(t, preRepairBlocks, ptr.ID)
+	}
+
+	err = RepairBlockRefs(ctx, config, audit)
+	require.NoError(t, err)
+	postRepairBlocks, err := bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+	require.NoError(t, err)
+	for _, ptr := range audit.ToDelete {
+		require.NotContains(t, postRepairBlocks, ptr.ID)
+	}
+	for _, ptr := range audit.Live {
+		require.Contains(t, postRepairBlocks, ptr.ID)
+	}
+
+	// Repairing again is harmless.
+	err = RepairBlockRefs(ctx, config, audit)
+	require.NoError(t, err)
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// BlockRequestOp identifies the kind of operation that a block fetch
+// is being done for.  It decides the priority of the fetch in the
+// block retrieval queue, so that background work never gets ahead
+// of interactive requests.
+type BlockRequestOp int
+
+const (
+	// BlockRequestOpDefault is used for fetches that haven't been
+	// tagged with a more specific operation.  They are treated as
+	// on-demand requests.
+	BlockRequestOpDefault BlockRequestOp = iota
+	// BlockRequestOpUserRead is a read of file data on behalf of
+	// the user.
+	BlockRequestOpUserRead
+	// BlockRequestOpReaddir is a directory listing or lookup on
+	// behalf of the user.
+	BlockRequestOpReaddir
+	// BlockRequestOpPrefetch is a speculative fetch to warm up the
+	// caches.
+	BlockRequestOpPrefetch
+	// BlockRequestOpConflictResolution is a fetch done while
+	// resolving conflicts in the background.
+	BlockRequestOpConflictResolution
+	// BlockRequestOpSync is a fetch done while syncing dirty data
+	// to the servers.
+	BlockRequestOpSync
+)
+
+const (
+	// Interactive requests are at or above
+	// defaultOnDemandRequestPriority, so that they trigger
+	// prefetches.
+	userReadRequestPriority = defaultOnDemandRequestPriority + 10
+	readdirRequestPriority  = defaultOnDemandRequestPriority
+	// Background requests are below it, but still ahead of any
+	// prefetches.
+	syncRequestPriority               = defaultOnDemandRequestPriority / 2
+	conflictResolutionRequestPriority = syncRequestPriority - 10
+)
+
+func (o BlockRequestOp) String() string {
+	switch o {
+	case BlockRequestOpDefault:
+		return "default"
+	case BlockRequestOpUserRead:
+		return "user read"
+	case BlockRequestOpReaddir:
+		return "readdir"
+	case BlockRequestOpPrefetch:
+		return "prefetch"
+	case BlockRequestOpConflictResolution:
+		return "conflict resolution"
+	case BlockRequestOpSync:
+		return "sync"
+	default:
+		return fmt.Sprintf("BlockRequestOp(%d)", int(o))
+	}
+}
+
+// priority returns the block retrieval queue priority for fetches
+// done on behalf of o.
+func (o BlockRequestOp) priority() int {
+	switch o {
+	case BlockRequestOpUserRead:
+		return userReadRequestPriority
+	case BlockRequestOpReaddir:
+		return readdirRequestPriority
+	case BlockRequestOpPrefetch:
+		return defaultPrefetchPriority
+	case BlockRequestOpConflictResolution:
+		return conflictResolutionRequestPriority
+	case BlockRequestOpSync:
+		return syncRequestPriority
+	default:
+		return defaultOnDemandRequestPriority
+	}
+}
+
+// CtxBlockRequestOpKeyType is the type for the context key holding
+// the BlockRequestOp for block fetches done with that context.
+type CtxBlockRequestOpKeyType int
+
+const (
+	// CtxBlockRequestOpKey is set in the context of an operation to
+	// tell the block retrieval queue what kind of operation its
+	// block fetches are for.
+	CtxBlockRequestOpKey CtxBlockRequestOpKeyType = iota
+)
+
+// NewContextWithBlockRequestOp returns a context that tags any block
+// fetches done with it as being for the given operation.
+func NewContextWithBlockRequestOp(
+	ctx context.Context, o BlockRequestOp) context.Context {
+	return context.WithValue(ctx, CtxBlockRequestOpKey, o)
+}
+
+// maybeNewContextWithBlockRequestOp is like
+// NewContextWithBlockRequestOp, but leaves ctx alone if it's already
+// been tagged by an outer operation.
+func maybeNewContextWithBlockRequestOp(
+	ctx context.Context, o BlockRequestOp) context.Context {
+	if blockRequestOpFromContext(ctx) != BlockRequestOpDefault {
+		return ctx
+	}
+	return NewContextWithBlockRequestOp(ctx, o)
+}
+
+func blockRequestOpFromContext(ctx context.Context) BlockRequestOp {
+	if o, ok := ctx.Value(CtxBlockRequestOpKey).(BlockRequestOp); ok {
+		return o
+	}
+	return BlockRequestOpDefault
+}
+
+// blockRequestPriorityFromContext returns the block retrieval queue
+// priority for block fetches done with ctx.
+func blockRequestPriorityFromContext(ctx context.Context) int {
+	return blockRequestOpFromContext(ctx).priority()
+}
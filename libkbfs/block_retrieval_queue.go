@@ -19,6 +19,12 @@ const (
 	minimalBlockRetrievalWorkerQueueSize int = 2
 	testBlockRetrievalWorkerQueueSize    int = 5
 	defaultOnDemandRequestPriority       int = 100
+	// Every blockRetrievalStarvationInterval pops, the oldest
+	// non-prefetch retrieval in the heap is popped instead of the
+	// highest-priority one, if it's been passed over for at least
+	// that many pops.  This keeps a steady stream of interactive
+	// requests from starving background work like syncs and CR.
+	blockRetrievalStarvationInterval uint64 = 10
 )
 
 type blockRetrievalPartialConfig interface {
@@ -76,6 +82,9 @@ type blockRetrieval struct {
 	// state of global request counter when this retrieval was created;
 	// maintains FIFO
 	insertionOrder uint64
+	// state of the queue's pop counter when this retrieval was
+	// created; used for starvation protection
+	popCountAtInsert uint64
 }
 
 // blockPtrLookup is used to uniquely identify block retrieval requests. The
@@ -93,14 +102,16 @@ type blockPtrLookup struct {
 // given priority level.
 type blockRetrievalQueue struct {
 	config blockRetrievalConfig
-	// protects ptrs, insertionCount, and the heap
+	// protects ptrs, insertionCount, popCount, and the heap
 	mtx sync.RWMutex
 	// queued or in progress retrievals
 	ptrs map[blockPtrLookup]*blockRetrieval
 	// global counter of insertions to queue
 	// capacity: ~584 years at 1 billion requests/sec
 	insertionCount uint64
-	heap           *blockRetrievalHeap
+	// global counter of pops from the queue
+	popCount uint64
+	heap     *blockRetrievalHeap
 
 	// This is a channel of channels to maximize the time that each request is
 	// in the heap, allowing preemption as long as possible. This way, a
@@ -142,10 +153,38 @@ func newBlockRetrievalQueue(numWorkers int, config blockRetrievalConfig) *blockR
 func (brq *blockRetrievalQueue) popIfNotEmpty() *blockRetrieval {
 	brq.mtx.Lock()
 	defer brq.mtx.Unlock()
-	if brq.heap.Len() > 0 {
-		return heap.Pop(brq.heap).(*blockRetrieval)
+	if brq.heap.Len() == 0 {
+		return nil
 	}
-	return nil
+	brq.popCount++
+	if brq.popCount%blockRetrievalStarvationInterval == 0 {
+		if starved := brq.starvedRetrievalLocked(); starved != nil {
+			return heap.Remove(brq.heap, starved.index).(*blockRetrieval)
+		}
+	}
+	return heap.Pop(brq.heap).(*blockRetrieval)
+}
+
+// starvedRetrievalLocked returns the oldest non-prefetch retrieval in
+// the heap, if it has been waiting for at least
+// blockRetrievalStarvationInterval pops.  Otherwise it returns nil.
+func (brq *blockRetrievalQueue) starvedRetrievalLocked() *blockRetrieval {
+	var oldest *blockRetrieval
+	for _, br := range *brq.heap {
+		if br.priority < conflictResolutionRequestPriority {
+			// Prefetches are allowed to wait indefinitely.
+			continue
+		}
+		if oldest == nil || br.insertionOrder < oldest.insertionOrder {
+			oldest = br
+		}
+	}
+	if oldest == nil ||
+		brq.popCount-oldest.popCountAtInsert <
+			blockRetrievalStarvationInterval {
+		return nil
+	}
+	return oldest
 }
 
 // notifyWorker notifies workers that there is a new request for processing.
@@ -216,12 +255,13 @@ func (brq *blockRetrievalQueue) Request(ctx context.Context, priority int, kmd K
 			}
 			// Add to the heap
 			br = &blockRetrieval{
-				blockPtr:       ptr,
-				kmd:            kmd,
-				index:          -1,
-				priority:       priority,
-				insertionOrder: brq.insertionCount,
-				cacheLifetime:  lifetime,
+				blockPtr:         ptr,
+				kmd:              kmd,
+				index:            -1,
+				priority:         priority,
+				insertionOrder:   brq.insertionCount,
+				popCountAtInsert: brq.popCount,
+				cacheLifetime:    lifetime,
 			}
 			br.ctx, br.cancelFunc = NewCoalescingContext(ctx)
 			brq.insertionCount++
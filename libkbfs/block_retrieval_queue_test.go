@@ -282,3 +282,96 @@ func TestBlockRetrievalQueueCurrentlyProcessingRequest(t *testing.T) {
 	require.Len(t, br.requests, 1)
 	require.Equal(t, block, br.requests[0].block)
 }
+
+func TestBlockRetrievalQueueStarvationProtection(t *testing.T) {
+	t.Log("A steady stream of interactive requests doesn't starve a sync.")
+	q := newBlockRetrievalQueue(0, newTestBlockRetrievalConfig(t, nil))
+	require.NotNil(t, q)
+	defer q.Shutdown()
+
+	ctx := context.Background()
+	block := &FileBlock{}
+	syncPtr := makeRandomBlockPointer(t)
+	_ = q.Request(ctx, syncRequestPriority, makeKMD(), syncPtr, block,
+		NoCacheEntry)
+	numReads := 2 * int(blockRetrievalStarvationInterval)
+	for i := 0; i < numReads; i++ {
+		_ = q.Request(ctx, userReadRequestPriority, makeKMD(),
+			makeRandomBlockPointer(t), block, NoCacheEntry)
+	}
+
+	t.Log("The user reads go first, until the sync has waited too long.")
+	ch := make(chan *blockRetrieval, 1)
+	for i := uint64(1); i < blockRetrievalStarvationInterval; i++ {
+		q.Work(ch)
+		br := <-ch
+		defer q.FinalizeRequest(br, &FileBlock{}, io.EOF)
+		require.Equal(t, userReadRequestPriority, br.priority)
+	}
+	q.Work(ch)
+	br := <-ch
+	defer q.FinalizeRequest(br, &FileBlock{}, io.EOF)
+	require.Equal(t, syncPtr, br.blockPtr)
+
+	t.Log("Then the rest of the user reads are processed.")
+	q.Work(ch)
+	br = <-ch
+	defer q.FinalizeRequest(br, &FileBlock{}, io.EOF)
+	require.Equal(t, userReadRequestPriority, br.priority)
+}
+
+func TestBlockRetrievalQueuePrefetchNotStarvationProtected(t *testing.T) {
+	t.Log("Prefetches keep waiting behind on-demand requests.")
+	q := newBlockRetrievalQueue(0, newTestBlockRetrievalConfig(t, nil))
+	require.NotNil(t, q)
+	defer q.Shutdown()
+
+	ctx := context.Background()
+	block := &FileBlock{}
+	prefetchPtr := makeRandomBlockPointer(t)
+	_ = q.Request(ctx, defaultPrefetchPriority, makeKMD(), prefetchPtr,
+		block, NoCacheEntry)
+	numReads := 2 * int(blockRetrievalStarvationInterval)
+	for i := 0; i < numReads; i++ {
+		_ = q.Request(ctx, defaultOnDemandRequestPriority, makeKMD(),
+			makeRandomBlockPointer(t), block, NoCacheEntry)
+	}
+
+	ch := make(chan *blockRetrieval, 1)
+	for i := 0; i < numReads; i++ {
+		q.Work(ch)
+		br := <-ch
+		defer q.FinalizeRequest(br, &FileBlock{}, io.EOF)
+		require.NotEqual(t, prefetchPtr, br.blockPtr)
+	}
+	q.Work(ch)
+	br := <-ch
+	defer q.FinalizeRequest(br, &FileBlock{}, io.EOF)
+	require.Equal(t, prefetchPtr, br.blockPtr)
+}
+
+func TestBlockRequestPriorityFromContext(t *testing.T) {
+	ctx := context.Background()
+	require.Equal(t, defaultOnDemandRequestPriority,
+		blockRequestPriorityFromContext(ctx))
+
+	readCtx := NewContextWithBlockRequestOp(ctx, BlockRequestOpUserRead)
+	syncCtx := NewContextWithBlockRequestOp(ctx, BlockRequestOpSync)
+	crCtx := NewContextWithBlockRequestOp(
+		ctx, BlockRequestOpConflictResolution)
+	prefetchCtx := NewContextWithBlockRequestOp(ctx, BlockRequestOpPrefetch)
+	require.True(t, blockRequestPriorityFromContext(readCtx) >=
+		defaultOnDemandRequestPriority)
+	require.True(t, blockRequestPriorityFromContext(readCtx) >
+		blockRequestPriorityFromContext(syncCtx))
+	require.True(t, blockRequestPriorityFromContext(syncCtx) >
+		blockRequestPriorityFromContext(crCtx))
+	require.True(t, blockRequestPriorityFromContext(crCtx) >
+		blockRequestPriorityFromContext(prefetchCtx))
+
+	t.Log("An outer operation's tag wins over an inner one.")
+	require.Equal(t, BlockRequestOpSync, blockRequestOpFromContext(
+		maybeNewContextWithBlockRequestOp(syncCtx, BlockRequestOpUserRead)))
+	require.Equal(t, BlockRequestOpUserRead, blockRequestOpFromContext(
+		maybeNewContextWithBlockRequestOp(ctx, BlockRequestOpUserRead)))
+}
@@ -0,0 +1,257 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"container/list"
+	"path/filepath"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// blockSpillCacheConfig specifies the interfaces that a
+// BlockSpillCacheStandard needs to perform its functions.
+type blockSpillCacheConfig interface {
+	codecGetter
+	cryptoPureGetter
+	logMaker
+	diskLimiterGetter
+}
+
+type blockSpillCacheEntry struct {
+	id   kbfsblock.ID
+	size int64
+}
+
+// BlockSpillCacheStandard is the standard implementation for
+// BlockSpillCache.  It keeps each spilled block in its own file,
+// encrypted with a key made up when the cache is created and never
+// written anywhere, so that the decoded blocks can't be read back by
+// anything but this cache.  Its files are accounted for by the disk
+// limiter as a partition of their own.
+type BlockSpillCacheStandard struct {
+	config blockSpillCacheConfig
+	log    logger.Logger
+	dir    string
+	key    kbfscrypto.BlockCryptKey
+
+	// protects everything below, and makes each Put atomic with
+	// respect to its evictions.
+	lock sync.Mutex
+	// entries holds an element of lru for each spilled block; the
+	// most recently used ones are at the front.
+	entries   map[kbfsblock.ID]*list.Element
+	lru       *list.List
+	currBytes int64
+	closed    bool
+}
+
+var _ BlockSpillCache = (*BlockSpillCacheStandard)(nil)
+
+func blockSpillCacheRootFromStorageRoot(storageRoot string) string {
+	return filepath.Join(storageRoot, "kbfs_read_spill")
+}
+
+// newBlockSpillCacheStandard creates a new *BlockSpillCacheStandard
+// keeping its blocks in a new directory under rootPath, which it
+// deletes on Shutdown.  Each process gets a directory of its own.
+func newBlockSpillCacheStandard(config blockSpillCacheConfig,
+	rootPath string) (*BlockSpillCacheStandard, error) {
+	tlfKey, err := kbfscrypto.MakeRandomTLFCryptKey()
+	if err != nil {
+		return nil, err
+	}
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.MkdirAll(rootPath, 0700)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(rootPath, "spill")
+	if err != nil {
+		return nil, err
+	}
+	return &BlockSpillCacheStandard{
+		config:  config,
+		log:     config.MakeLogger("BSC"),
+		dir:     dir,
+		key:     kbfscrypto.UnmaskBlockCryptKey(serverHalf, tlfKey),
+		entries: make(map[kbfsblock.ID]*list.Element),
+		lru:     list.New(),
+	}, nil
+}
+
+func (cache *BlockSpillCacheStandard) blockPath(id kbfsblock.ID) string {
+	return filepath.Join(cache.dir, id.String())
+}
+
+func (cache *BlockSpillCacheStandard) checkOpenLocked(op string) error {
+	if cache.closed {
+		return errors.WithStack(DiskCacheClosedError{op})
+	}
+	return nil
+}
+
+// Get implements the BlockSpillCache interface for
+// BlockSpillCacheStandard.
+func (cache *BlockSpillCacheStandard) Get(
+	ctx context.Context, ptr BlockPointer, block Block) error {
+	err := func() error {
+		cache.lock.Lock()
+		defer cache.lock.Unlock()
+		if err := cache.checkOpenLocked("BlockSpillCache.Get"); err != nil {
+			return err
+		}
+		elem, ok := cache.entries[ptr.ID]
+		if !ok {
+			return NoSuchBlockError{ptr.ID}
+		}
+		cache.lru.MoveToFront(elem)
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	// Read the file without the lock, so that parallel fetches
+	// aren't serialized.  The block may get evicted meanwhile.
+	buf, err := ioutil.ReadFile(cache.blockPath(ptr.ID))
+	if ioutil.IsNotExist(errors.Cause(err)) {
+		return NoSuchBlockError{ptr.ID}
+	} else if err != nil {
+		return err
+	}
+	var encryptedBlock EncryptedBlock
+	err = cache.config.Codec().Decode(buf, &encryptedBlock)
+	if err != nil {
+		return err
+	}
+	return cache.config.cryptoPure().DecryptBlock(
+		encryptedBlock, cache.key, block)
+}
+
+// evictOldestLocked deletes the least recently used spilled block,
+// and returns false if there weren't any.
+func (cache *BlockSpillCacheStandard) evictOldestLocked(
+	ctx context.Context) (bool, error) {
+	elem := cache.lru.Back()
+	if elem == nil {
+		return false, nil
+	}
+	entry := elem.Value.(blockSpillCacheEntry)
+	err := ioutil.Remove(cache.blockPath(entry.id))
+	if err != nil && !ioutil.IsNotExist(errors.Cause(err)) {
+		return false, err
+	}
+	cache.lru.Remove(elem)
+	delete(cache.entries, entry.id)
+	cache.currBytes -= entry.size
+	cache.config.DiskLimiter().onDiskBlockCacheDelete(
+		ctx, readSpillLimitTrackerType, entry.size)
+	return true, nil
+}
+
+// reserveSpaceLocked asks the disk limiter for room for newBytes,
+// evicting spilled blocks until there is some.  Once it returns nil,
+// the caller must call afterDiskBlockCachePut on the limiter with the
+// same number of bytes.
+func (cache *BlockSpillCacheStandard) reserveSpaceLocked(
+	ctx context.Context, newBytes int64) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		bytesAvailable, err := cache.config.DiskLimiter().
+			beforeDiskBlockCachePut(ctx, readSpillLimitTrackerType, newBytes)
+		if err != nil {
+			return err
+		}
+		if bytesAvailable >= 0 {
+			return nil
+		}
+		evicted, err := cache.evictOldestLocked(ctx)
+		if err != nil {
+			return err
+		}
+		if !evicted {
+			return errors.New(
+				"no room in the block spill cache, even when empty")
+		}
+	}
+}
+
+// Put implements the BlockSpillCache interface for
+// BlockSpillCacheStandard.
+func (cache *BlockSpillCacheStandard) Put(
+	ctx context.Context, ptr BlockPointer, block Block) error {
+	// Encrypt outside the lock; the block may end up already
+	// being spilled, but that's rare.
+	_, encryptedBlock, err := cache.config.cryptoPure().EncryptBlock(
+		block, cache.key)
+	if err != nil {
+		return err
+	}
+	buf, err := cache.config.Codec().Encode(encryptedBlock)
+	if err != nil {
+		return err
+	}
+	size := int64(len(buf))
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if err := cache.checkOpenLocked("BlockSpillCache.Put"); err != nil {
+		return err
+	}
+	if elem, ok := cache.entries[ptr.ID]; ok {
+		cache.lru.MoveToFront(elem)
+		return nil
+	}
+	err = cache.reserveSpaceLocked(ctx, size)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(cache.blockPath(ptr.ID), buf, 0600)
+	if err != nil {
+		cache.config.DiskLimiter().afterDiskBlockCachePut(
+			ctx, readSpillLimitTrackerType, size, false)
+		return err
+	}
+	cache.config.DiskLimiter().afterDiskBlockCachePut(
+		ctx, readSpillLimitTrackerType, size, true)
+	cache.entries[ptr.ID] = cache.lru.PushFront(
+		blockSpillCacheEntry{ptr.ID, size})
+	cache.currBytes += size
+	return nil
+}
+
+// Shutdown implements the BlockSpillCache interface for
+// BlockSpillCacheStandard.
+func (cache *BlockSpillCacheStandard) Shutdown(ctx context.Context) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.closed {
+		return
+	}
+	cache.closed = true
+	err := ioutil.RemoveAll(cache.dir)
+	if err != nil {
+		cache.log.CWarningf(ctx, "Couldn't delete spilled blocks: %+v", err)
+	}
+	cache.config.DiskLimiter().onDiskBlockCacheDelete(
+		ctx, readSpillLimitTrackerType, cache.currBytes)
+	cache.entries = nil
+	cache.lru = nil
+	cache.currBytes = 0
+}
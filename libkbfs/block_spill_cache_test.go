@@ -0,0 +1,211 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testBlockSpillCacheConfig struct {
+	codecGetter
+	logMaker
+	limiter DiskLimiter
+}
+
+func (c testBlockSpillCacheConfig) cryptoPure() cryptoPure {
+	return MakeCryptoCommon(c.Codec())
+}
+
+func (c testBlockSpillCacheConfig) DiskLimiter() DiskLimiter {
+	return c.limiter
+}
+
+// makeBlockSpillCacheTestLimiter makes a disk limiter that lets the
+// spill cache use at most spillBytes.
+func makeBlockSpillCacheTestLimiter(t *testing.T, spillBytes int64) (
+	DiskLimiter, error) {
+	const frac = 0.25
+	return newBackpressureDiskLimiter(newTestLogMaker(t).MakeLogger(""),
+		backpressureDiskLimiterParams{
+			minThreshold:    0.5,
+			maxThreshold:    0.95,
+			journalFrac:     frac,
+			journalMDFrac:   frac,
+			diskCacheFrac:   frac,
+			publicCacheFrac: frac,
+			syncCacheFrac:   frac,
+			readSpillFrac:   frac,
+			byteLimit:       int64(float64(spillBytes) / frac),
+			fileLimit:       10000,
+			maxDelay:        time.Second,
+			clock:           wallClock{},
+			delayFn:         defaultDoDelay,
+			freeBytesAndFilesFn: func() (int64, int64, error) {
+				return testDiskBlockCacheMaxBytes, 10000, nil
+			},
+		})
+}
+
+func initBlockSpillCacheTest(t *testing.T, spillBytes int64) (
+	*BlockSpillCacheStandard, string) {
+	limiter, err := makeBlockSpillCacheTestLimiter(t, spillBytes)
+	require.NoError(t, err)
+	config := testBlockSpillCacheConfig{
+		newTestCodecGetter(), newTestLogMaker(t), limiter}
+	tempdir, err := ioutil.TempDir(os.TempDir(), "block_spill_cache")
+	require.NoError(t, err)
+	cache, err := newBlockSpillCacheStandard(config, tempdir)
+	require.NoError(t, err)
+	return cache, tempdir
+}
+
+func shutdownBlockSpillCacheTest(
+	t *testing.T, cache *BlockSpillCacheStandard, tempdir string) {
+	cache.Shutdown(context.Background())
+	err := ioutil.RemoveAll(tempdir)
+	assert.NoError(t, err)
+}
+
+func TestBlockSpillCachePutGet(t *testing.T) {
+	cache, tempdir := initBlockSpillCacheTest(t, testDiskBlockCacheMaxBytes)
+	defer shutdownBlockSpillCacheTest(t, cache, tempdir)
+	ctx := context.Background()
+
+	ptr := makeRandomBlockPointer(t)
+	block := &FileBlock{Contents: []byte("some cleartext contents")}
+	err := cache.Put(ctx, ptr, block)
+	require.NoError(t, err)
+
+	t.Log("The block is encrypted on disk")
+	buf, err := ioutil.ReadFile(cache.blockPath(ptr.ID))
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(buf, block.Contents))
+
+	gotBlock := NewFileBlock()
+	err = cache.Get(ctx, ptr, gotBlock)
+	require.NoError(t, err)
+	require.Equal(t, block.Contents, gotBlock.(*FileBlock).Contents)
+
+	err = cache.Get(ctx, makeRandomBlockPointer(t), NewFileBlock())
+	require.IsType(t, NoSuchBlockError{}, err)
+
+	t.Log("Shutting down deletes the spilled blocks")
+	cache.Shutdown(ctx)
+	_, err = ioutil.Stat(cache.dir)
+	require.True(t, ioutil.IsNotExist(err))
+}
+
+func TestBlockSpillCacheEvicts(t *testing.T) {
+	// Find how big a spilled block is; the padding makes them all
+	// the same size.
+	makeBlock := func() *FileBlock {
+		return &FileBlock{Contents: make([]byte, 100)}
+	}
+	codec := newTestCodecGetter().Codec()
+	_, encryptedBlock, err := MakeCryptoCommon(codec).EncryptBlock(
+		makeBlock(), kbfscrypto.MakeBlockCryptKey([32]byte{}))
+	require.NoError(t, err)
+	buf, err := codec.Encode(encryptedBlock)
+	require.NoError(t, err)
+	size := int64(len(buf))
+
+	// Leave room for two and a half blocks.
+	cache, tempdir := initBlockSpillCacheTest(t, 5*size/2)
+	defer shutdownBlockSpillCacheTest(t, cache, tempdir)
+	ctx := context.Background()
+
+	ptr1 := makeRandomBlockPointer(t)
+	ptr2 := makeRandomBlockPointer(t)
+	ptr3 := makeRandomBlockPointer(t)
+	for _, ptr := range []BlockPointer{ptr1, ptr2} {
+		err := cache.Put(ctx, ptr, makeBlock())
+		require.NoError(t, err)
+	}
+
+	t.Log("Use the first block, so that the second one is evicted")
+	err = cache.Get(ctx, ptr1, NewFileBlock())
+	require.NoError(t, err)
+	err = cache.Put(ctx, ptr3, makeBlock())
+	require.NoError(t, err)
+	err = cache.Get(ctx, ptr2, NewFileBlock())
+	require.IsType(t, NoSuchBlockError{}, err)
+	_, err = ioutil.Stat(cache.blockPath(ptr2.ID))
+	require.True(t, ioutil.IsNotExist(err))
+	for _, ptr := range []BlockPointer{ptr1, ptr3} {
+		err := cache.Get(ctx, ptr, NewFileBlock())
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2*size, cache.currBytes)
+}
+
+func TestKBFSOpsReadSpillsBigReads(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	limiter, err := makeBlockSpillCacheTestLimiter(
+		t, testDiskBlockCacheMaxBytes)
+	require.NoError(t, err)
+	config.lock.Lock()
+	config.diskLimiter = limiter
+	config.lock.Unlock()
+	tempdir, err := ioutil.TempDir(os.TempDir(), "block_spill_cache")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+	spill, err := newBlockSpillCacheStandard(config, tempdir)
+	require.NoError(t, err)
+	config.SetBlockSpillCache(spill)
+
+	// Make a file of many small blocks, with a few levels of
+	// indirection.
+	config.SetBlockSplitter(&BlockSplitterSimple{20, 4, 100 * 1024})
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	data := make([]byte, 200)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	t.Log("Read the whole file through a block cache too small for it")
+	// Keep the prefetcher from filling the new block cache behind
+	// the read's back.
+	err = config.BlockOps().TogglePrefetcher(ctx, false)
+	require.NoError(t, err)
+	config.SetBlockCache(NewBlockCacheStandard(10, 50))
+	buf := make([]byte, len(data))
+	n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, buf)
+	numSpilled := spill.lru.Len()
+	require.NotZero(t, numSpilled)
+
+	t.Log("Reading it again finds all the blocks spilled")
+	buf = make([]byte, len(data))
+	n, err = kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, buf)
+	require.Equal(t, numSpilled, spill.lru.Len())
+}
@@ -162,11 +162,15 @@ func assembleBlock(ctx context.Context, keyGetter blockKeyGetter,
 	blockCryptKey := kbfscrypto.UnmaskBlockCryptKey(
 		blockServerHalf, tlfCryptKey)
 
+	// Decode the encrypted data into a recycled buffer, since it's
+	// dead once the block is decrypted.
 	var encryptedBlock EncryptedBlock
+	encryptedBlock.EncryptedData = getBlockBuffer(len(buf))
 	err = codec.Decode(buf, &encryptedBlock)
 	if err != nil {
 		return err
 	}
+	defer putBlockBuffer(encryptedBlock.EncryptedData)
 
 	// decrypt the block
 	err = cryptoPure.DecryptBlock(encryptedBlock, blockCryptKey, block)
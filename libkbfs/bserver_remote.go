@@ -42,6 +42,7 @@ type blockServerRemoteClientHandler struct {
 	csg           currentSessionGetter
 	authToken     *kbfscrypto.AuthToken
 	srvAddr       string
+	proxy         ProxySpec
 	connOpts      rpc.ConnectionOpts
 	rpcLogFactory *libkb.RPCLogFactory
 	pinger        pinger
@@ -53,7 +54,7 @@ type blockServerRemoteClientHandler struct {
 
 func newBlockServerRemoteClientHandler(name string, log logger.Logger,
 	signer kbfscrypto.Signer, csg currentSessionGetter, srvAddr string,
-	rpcLogFactory *libkb.RPCLogFactory) *blockServerRemoteClientHandler {
+	proxy ProxySpec, rpcLogFactory *libkb.RPCLogFactory) *blockServerRemoteClientHandler {
 	deferLog := log.CloneWithAddedDepth(1)
 	b := &blockServerRemoteClientHandler{
 		name:          name,
@@ -61,6 +62,7 @@ func newBlockServerRemoteClientHandler(name string, log logger.Logger,
 		deferLog:      deferLog,
 		csg:           csg,
 		srvAddr:       srvAddr,
+		proxy:         proxy,
 		rpcLogFactory: rpcLogFactory,
 	}
 
@@ -94,8 +96,8 @@ func (b *blockServerRemoteClientHandler) initNewConnection() {
 		b.conn.Shutdown()
 	}
 
-	b.conn = rpc.NewTLSConnection(
-		b.srvAddr, kbfscrypto.GetRootCerts(b.srvAddr),
+	b.conn = newTLSConnectionViaProxy(
+		b.srvAddr, b.proxy, kbfscrypto.GetRootCerts(b.srvAddr),
 		kbfsblock.BServerErrorUnwrapper{}, b, b.rpcLogFactory, b.log,
 		b.connOpts)
 	b.client = keybase1.BlockClient{Cli: b.conn.GetClient()}
@@ -261,9 +263,11 @@ func (b *blockServerRemoteClientHandler) pingOnce(ctx context.Context) {
 
 type blockServerRemoteConfig interface {
 	diskBlockCacheGetter
+	clockGetter
 	codecGetter
 	signerGetter
 	currentSessionGetterGetter
+	serverProxiesGetter
 	logMaker
 }
 
@@ -278,6 +282,9 @@ type BlockServerRemote struct {
 
 	putConn *blockServerRemoteClientHandler
 	getConn *blockServerRemoteClientHandler
+
+	// diskCachePuts batches the blocks put to the disk block cache.
+	diskCachePuts *diskBlockCachePutBatcher
 }
 
 // Test that BlockServerRemote fully implements the BlockServer interface.
@@ -294,6 +301,8 @@ func NewBlockServerRemote(config blockServerRemoteConfig,
 		log:        log,
 		deferLog:   deferLog,
 		blkSrvAddr: blkSrvAddr,
+
+		diskCachePuts: newDiskBlockCachePutBatcher(config, log),
 	}
 	// Use two separate auth clients -- one for writes and one for
 	// reads.  This allows small reads to avoid getting trapped behind
@@ -301,10 +310,12 @@ func NewBlockServerRemote(config blockServerRemoteConfig,
 	// achieve better prioritization within the actual network.
 	bs.putConn = newBlockServerRemoteClientHandler(
 		"BlockServerRemotePut", log, config.Signer(),
-		config.currentSessionGetter(), blkSrvAddr, rpcLogFactory)
+		config.currentSessionGetter(), blkSrvAddr,
+		config.ServerProxies().BServer, rpcLogFactory)
 	bs.getConn = newBlockServerRemoteClientHandler(
 		"BlockServerRemoteGet", log, config.Signer(),
-		config.currentSessionGetter(), blkSrvAddr, rpcLogFactory)
+		config.currentSessionGetter(), blkSrvAddr,
+		config.ServerProxies().BServer, rpcLogFactory)
 
 	bs.shutdownFn = func() {
 		bs.putConn.shutdown()
@@ -332,6 +343,7 @@ func newBlockServerRemoteWithClient(config blockServerRemoteConfig,
 			deferLog: deferLog,
 			client:   client,
 		},
+		diskCachePuts: newDiskBlockCachePutBatcher(config, log),
 	}
 	return bs
 }
@@ -378,6 +390,9 @@ func (b *BlockServerRemote) Get(ctx context.Context, tlfID tlf.ID, id kbfsblock.
 	buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf, err error) {
 	// TODO: do this in parallel.
 	if b.config.DiskBlockCache() != nil {
+		if block, ok := b.diskCachePuts.get(tlfID, id); ok {
+			return block.Buf, block.ServerHalf, nil
+		}
 		buf, serverHalf, err = b.config.DiskBlockCache().Get(ctx, tlfID, id)
 		if err == nil {
 			return
@@ -391,7 +406,7 @@ func (b *BlockServerRemote) Get(ctx context.Context, tlfID tlf.ID, id kbfsblock.
 				id, tlfID, context, size, err)
 		} else {
 			if b.config.DiskBlockCache() != nil {
-				go b.config.DiskBlockCache().Put(ctx, tlfID, id, buf, serverHalf)
+				b.diskCachePuts.put(tlfID, id, buf, serverHalf)
 			}
 			b.deferLog.CDebugf(
 				ctx, "Get id=%s tlf=%s context=%s sz=%d",
@@ -422,7 +437,7 @@ func (b *BlockServerRemote) Put(ctx context.Context, tlfID tlf.ID, id kbfsblock.
 	bContext kbfsblock.Context, buf []byte,
 	serverHalf kbfscrypto.BlockCryptKeyServerHalf) (err error) {
 	if b.config.DiskBlockCache() != nil {
-		go b.config.DiskBlockCache().Put(ctx, tlfID, id, buf, serverHalf)
+		b.diskCachePuts.put(tlfID, id, buf, serverHalf)
 	}
 	size := len(buf)
 	defer func() {
@@ -635,4 +650,5 @@ func (b *BlockServerRemote) Shutdown(ctx context.Context) {
 	}
 	b.getConn.shutdown()
 	b.putConn.shutdown()
+	b.diskCachePuts.flush()
 }
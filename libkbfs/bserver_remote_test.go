@@ -87,6 +87,14 @@ func (c testBlockServerRemoteConfig) DiskBlockCache() DiskBlockCache {
 	return c.diskBlockCache
 }
 
+func (c testBlockServerRemoteConfig) Clock() Clock {
+	return wallClock{}
+}
+
+func (c testBlockServerRemoteConfig) ServerProxies() ServerProxies {
+	return ServerProxies{}
+}
+
 // Test that putting a block, and getting it back, works
 func TestBServerRemotePutAndGet(t *testing.T) {
 	currentUID := keybase1.MakeTestUID(1)
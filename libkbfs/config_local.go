@@ -35,6 +35,10 @@ const (
 	delayedCancellationGracePeriodDefault = 2 * time.Second
 	// How often do we check for stuff to reclaim?
 	qrPeriodDefault = 1 * time.Minute
+	// How often do we drop unreferenced blocks from the disk cache?
+	diskCacheVerifyPeriodDefault = 6 * time.Hour
+	// How often do we check that archived blocks are retrievable?
+	blockChallengePeriodDefault = 1 * time.Hour
 	// How long must something be unreferenced before we reclaim it?
 	qrUnrefAgeDefault = 1 * time.Minute
 	// How old must the most recent TLF revision be before another
@@ -43,6 +47,9 @@ const (
 	qrMinHeadAgeDefault = 24 * time.Hour
 	// tlfValidDurationDefault is the default for tlf validity before redoing identify.
 	tlfValidDurationDefault = 6 * time.Hour
+	// tlfIdleTimeoutDefault is the default for how long a TLF can
+	// sit unused before its folderBranchOps is evicted from memory.
+	tlfIdleTimeoutDefault = 1 * time.Hour
 )
 
 // ConfigLocal implements the Config interface using purely local
@@ -57,6 +64,7 @@ type ConfigLocal struct {
 	bcache         BlockCache
 	dirtyBcache    DirtyBlockCache
 	diskBlockCache DiskBlockCache
+	diskMDCache    DiskMDCache
 	codec          kbfscodec.Codec
 	mdops          MDOps
 	kops           KeyOps
@@ -75,9 +83,15 @@ type ConfigLocal struct {
 	registry       metrics.Registry
 	loggerFn       func(prefix string) logger.Logger
 	noBGFlush      bool // logic opposite so the default value is the common setting
+	usageByProcess bool
 	rwpWaitTime    time.Duration
 	diskLimiter    DiskLimiter
 
+	// diskPreviewCache is nil unless previews are enabled.
+	diskPreviewCache DiskPreviewCache
+	// blockSpillCache is nil unless read spilling is enabled.
+	blockSpillCache BlockSpillCache
+
 	maxNameBytes uint32
 	maxDirBytes  uint64
 	rekeyQueue   RekeyQueue
@@ -86,6 +100,8 @@ type ConfigLocal struct {
 	qrPeriod                       time.Duration
 	qrUnrefAge                     time.Duration
 	qrMinHeadAge                   time.Duration
+	diskCacheVerifyPeriod          time.Duration
+	blockChallengePeriod           time.Duration
 	delayedCancellationGracePeriod time.Duration
 
 	// allKnownConfigsForTesting is used for testing, and contains all created
@@ -95,9 +111,45 @@ type ConfigLocal struct {
 	// tlfValidDuration is the time TLFs are valid before redoing identification.
 	tlfValidDuration time.Duration
 
+	// tlfIdleTimeout is the time a TLF can go unaccessed before
+	// its in-memory state is evicted.
+	tlfIdleTimeout time.Duration
+
+	// writeCoalescingBytes is the size of the per-file buffer used
+	// to merge small sequential writes.
+	writeCoalescingBytes int64
+
+	// metadataOpDelay is how long setattrs may wait to be written
+	// together.
+	metadataOpDelay time.Duration
+
+	// fsyncDurability is the global default for what Sync guarantees.
+	fsyncDurability FsyncDurability
+
+	// merkleCheckMode is what's done with MD heads that aren't in
+	// the server's Merkle tree.
+	merkleCheckMode MerkleCheckMode
+
+	// identifyPolicy is when TLF users are identified.
+	identifyPolicy IdentifyPolicy
+
+	platformHooks PlatformHooks
+
+	// diskCacheBackend is what disk block caches keep their
+	// databases in.
+	diskCacheBackend DiskCacheBackend
+
+	// serverProxies is how new connections to the MD and block
+	// servers reach them.
+	serverProxies ServerProxies
+
 	// metadataVersion is the version to use when creating new metadata.
 	metadataVersion MetadataVer
 
+	// prefetchIndirectCount is how many of the children of an
+	// indirect block are prefetched when it's read.
+	prefetchIndirectCount int
+
 	mode InitMode
 }
 
@@ -258,6 +310,9 @@ func NewConfigLocal(mode InitMode, loggerFn func(module string) logger.Logger,
 	config.qrPeriod = qrPeriodDefault
 	config.qrUnrefAge = qrUnrefAgeDefault
 	config.qrMinHeadAge = qrMinHeadAgeDefault
+	config.diskCacheVerifyPeriod = diskCacheVerifyPeriodDefault
+	config.blockChallengePeriod = blockChallengePeriodDefault
+	config.platformHooks = newPlatformHooks()
 
 	// Don't bother creating the registry if UseNilMetrics is set.
 	if !metrics.UseNilMetrics {
@@ -266,11 +321,27 @@ func NewConfigLocal(mode InitMode, loggerFn func(module string) logger.Logger,
 	}
 
 	config.tlfValidDuration = tlfValidDurationDefault
+	config.tlfIdleTimeout = tlfIdleTimeoutDefault
 	config.metadataVersion = defaultClientMetadataVer
+	config.prefetchIndirectCount = defaultIndirectPointerPrefetchCount
 
 	return config
 }
 
+// indirectPrefetchCount implements the indirectPrefetchCounter
+// interface for ConfigLocal.
+func (c *ConfigLocal) indirectPrefetchCount() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.prefetchIndirectCount
+}
+
+func (c *ConfigLocal) setIndirectPrefetchCount(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.prefetchIndirectCount = n
+}
+
 // KBFSOps implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) KBFSOps() KBFSOps {
 	c.lock.RLock()
@@ -404,6 +475,27 @@ func (c *ConfigLocal) DiskBlockCache() DiskBlockCache {
 	return c.diskBlockCache
 }
 
+// DiskMDCache implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) DiskMDCache() DiskMDCache {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.diskMDCache
+}
+
+// DiskPreviewCache implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) DiskPreviewCache() DiskPreviewCache {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.diskPreviewCache
+}
+
+// BlockSpillCache implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) BlockSpillCache() BlockSpillCache {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.blockSpillCache
+}
+
 // DiskLimiter implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) DiskLimiter() DiskLimiter {
 	c.lock.RLock()
@@ -661,6 +753,20 @@ func (c *ConfigLocal) SetDoBackgroundFlushes(doBGFlush bool) {
 	c.noBGFlush = !doBGFlush
 }
 
+// TLFUsageByProcess implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) TLFUsageByProcess() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.usageByProcess
+}
+
+// SetTLFUsageByProcess implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetTLFUsageByProcess(byProcess bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.usageByProcess = byProcess
+}
+
 // RekeyWithPromptWaitTime implements the Config interface for
 // ConfigLocal.
 func (c *ConfigLocal) RekeyWithPromptWaitTime() time.Duration {
@@ -697,6 +803,18 @@ func (c *ConfigLocal) QuotaReclamationPeriod() time.Duration {
 	return c.qrPeriod
 }
 
+// DiskCacheVerificationPeriod implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) DiskCacheVerificationPeriod() time.Duration {
+	return c.diskCacheVerifyPeriod
+}
+
+// BlockChallengePeriod implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) BlockChallengePeriod() time.Duration {
+	return c.blockChallengePeriod
+}
+
 // QuotaReclamationMinUnrefAge implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) QuotaReclamationMinUnrefAge() time.Duration {
 	return c.qrUnrefAge
@@ -841,6 +959,137 @@ func (c *ConfigLocal) TLFValidDuration() time.Duration {
 	return c.tlfValidDuration
 }
 
+// SetTLFIdleTimeout implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetTLFIdleTimeout(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.tlfIdleTimeout = d
+}
+
+// TLFIdleTimeout implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) TLFIdleTimeout() time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.tlfIdleTimeout
+}
+
+// SetWriteCoalescingBytes implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetWriteCoalescingBytes(size int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.writeCoalescingBytes = size
+}
+
+// WriteCoalescingBytes implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) WriteCoalescingBytes() int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.writeCoalescingBytes
+}
+
+// SetMetadataOpDelay implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetMetadataOpDelay(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metadataOpDelay = d
+}
+
+// MetadataOpDelay implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) MetadataOpDelay() time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.metadataOpDelay
+}
+
+// SetFsyncDurability implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetFsyncDurability(d FsyncDurability) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fsyncDurability = d
+}
+
+// FsyncDurability implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) FsyncDurability() FsyncDurability {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.fsyncDurability == FsyncDurabilityDefault {
+		return FsyncDurabilityJournal
+	}
+	return c.fsyncDurability
+}
+
+// SetMerkleCheckMode implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetMerkleCheckMode(m MerkleCheckMode) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.merkleCheckMode = m
+}
+
+// MerkleCheckMode implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) MerkleCheckMode() MerkleCheckMode {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.merkleCheckMode
+}
+
+// SetIdentifyPolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetIdentifyPolicy(p IdentifyPolicy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.identifyPolicy = p
+}
+
+// IdentifyPolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) IdentifyPolicy() IdentifyPolicy {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.identifyPolicy
+}
+
+// SetDiskCacheBackend implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetDiskCacheBackend(b DiskCacheBackend) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.diskCacheBackend = b
+}
+
+// DiskCacheBackend implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) DiskCacheBackend() DiskCacheBackend {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.diskCacheBackend
+}
+
+// SetServerProxies implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetServerProxies(p ServerProxies) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.serverProxies = p
+}
+
+// ServerProxies implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) ServerProxies() ServerProxies {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.serverProxies
+}
+
+// SetPlatformHooks implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetPlatformHooks(h PlatformHooks) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.platformHooks = h
+}
+
+// PlatformHooks implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) PlatformHooks() PlatformHooks {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.platformHooks
+}
+
 // Shutdown implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) Shutdown(ctx context.Context) error {
 	c.RekeyQueue().Shutdown()
@@ -906,6 +1155,15 @@ func (c *ConfigLocal) Shutdown(ctx context.Context) error {
 	if c.DiskBlockCache() != nil {
 		c.DiskBlockCache().Shutdown(ctx)
 	}
+	if c.DiskMDCache() != nil {
+		c.DiskMDCache().Shutdown(ctx)
+	}
+	if c.DiskPreviewCache() != nil {
+		c.DiskPreviewCache().Shutdown(ctx)
+	}
+	if c.BlockSpillCache() != nil {
+		c.BlockSpillCache().Shutdown(ctx)
+	}
 
 	if len(errorList) == 1 {
 		return errorList[0]
@@ -948,7 +1206,8 @@ func (c *ConfigLocal) journalizeBcaches(jServer *JournalServer) error {
 
 // MakeDiskLimiter makes a DiskLimiter for use in journaling and disk caching.
 func (c *ConfigLocal) MakeDiskLimiter(configRoot string) (DiskLimiter, error) {
-	params := makeDefaultBackpressureDiskLimiterParams(configRoot)
+	params := makeDefaultBackpressureDiskLimiterParams(
+		configRoot, c.Clock())
 	log := c.MakeLogger("")
 	log.Debug("Setting disk storage byte limit to %d and file limit to %d",
 		params.byteLimit, params.fileLimit)
@@ -1029,5 +1288,37 @@ func (c *ConfigLocal) SetDiskBlockCache(dbc DiskBlockCache) {
 		c.diskBlockCache.Shutdown(ctx)
 	}
 	c.diskBlockCache = dbc
-	c.diskLimiter.onDiskBlockCacheEnable(ctx, dbc.Size())
+	enableDiskBlockCacheLimits(ctx, c.diskLimiter, dbc)
+}
+
+// SetDiskMDCache implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetDiskMDCache(dmc DiskMDCache) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.diskMDCache != nil {
+		c.diskMDCache.Shutdown(context.TODO())
+	}
+	c.diskMDCache = dmc
+}
+
+// SetDiskPreviewCache implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetDiskPreviewCache(dpc DiskPreviewCache) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.diskPreviewCache != nil {
+		c.diskPreviewCache.Shutdown(context.TODO())
+	}
+	c.diskPreviewCache = dpc
+}
+
+// SetBlockSpillCache implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetBlockSpillCache(bsc BlockSpillCache) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.blockSpillCache != nil {
+		c.blockSpillCache.Shutdown(context.TODO())
+	}
+	c.blockSpillCache = bsc
 }
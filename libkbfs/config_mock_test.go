@@ -132,6 +132,19 @@ func NewConfigMock(c *gomock.Controller, ctr *SafeTestReporter) *ConfigMock {
 	config.rwpWaitTime = rekeyWithPromptWaitTimeDefault
 
 	config.qrPeriod = 0 * time.Second // no auto reclamation
+	// The QR loop still makes a timer, which it stops right away.
+	config.mockClock.EXPECT().NewTimer(config.qrPeriod).AnyTimes().Return(
+		wallClock{}.NewTimer(config.qrPeriod))
+	config.mockClock.EXPECT().NewTicker(tlfIdleCheckPeriod).AnyTimes().Return(
+		wallClock{}.NewTicker(tlfIdleCheckPeriod))
+	config.mockClock.EXPECT().NewTicker(lockWatchdogInterval).AnyTimes().Return(
+		wallClock{}.NewTicker(lockWatchdogInterval))
+	config.mockClock.EXPECT().NewTicker(platformStatePollPeriod).AnyTimes().Return(
+		wallClock{}.NewTicker(platformStatePollPeriod))
+	config.mockClock.EXPECT().NewTicker(lowDiskSpaceCheckPeriod).AnyTimes().Return(
+		wallClock{}.NewTicker(lowDiskSpaceCheckPeriod))
+	config.diskCacheVerifyPeriod = 0
+	config.blockChallengePeriod = 0
 	config.qrUnrefAge = qrUnrefAgeDefault
 	config.SetMetadataVersion(defaultClientMetadataVer)
 
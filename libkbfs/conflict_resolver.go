@@ -70,6 +70,17 @@ type ConflictResolver struct {
 	currCancel    context.CancelFunc
 	lockNextTime  bool
 	canceledCount int
+
+	strategies *crStrategyStore
+	// replacedMergedFiles maps the original pointer of each file
+	// whose merged version the current resolution replaces to its
+	// merged most recent pointer, and contentMerges are the files to
+	// merge once the current resolution completes.  They're only used
+	// by the goroutine running doResolve.
+	replacedMergedFiles map[BlockPointer]BlockPointer
+	contentMerges       []crContentMerge
+	// mergeGroup tracks the outstanding content merges.
+	mergeGroup kbfssync.RepeatedWaitGroup
 }
 
 // NewConflictResolver constructs a new ConflictResolver (and launches
@@ -94,6 +105,7 @@ func NewConflictResolver(
 			unmerged: MetadataRevisionUninitialized,
 			merged:   MetadataRevisionUninitialized,
 		},
+		strategies: newCRStrategyStore(config.StorageRoot(), fbo.id()),
 	}
 
 	if config.Mode() != InitMinimal {
@@ -181,6 +193,8 @@ func (cr *ConflictResolver) processInput(baseCtx context.Context,
 	}()
 	for ci := range inputChan {
 		ctx := ctxWithRandomIDReplayable(baseCtx, CtxCRIDKey, CtxCROpID, cr.log)
+		ctx = NewContextWithBlockRequestOp(
+			ctx, BlockRequestOpConflictResolution)
 
 		valid := func() bool {
 			cr.inputLock.Lock()
@@ -1885,6 +1899,8 @@ func (cr *ConflictResolver) getActionsToMerge(
 	mergedPaths map[BlockPointer]path) (
 	map[BlockPointer]crActionList, error) {
 	actionMap := make(map[BlockPointer]crActionList)
+	cr.replacedMergedFiles = make(map[BlockPointer]BlockPointer)
+	cr.contentMerges = nil
 	for unmergedMostRecent, unmergedChain := range unmergedChains.byMostRecent {
 		original := unmergedChain.original
 		// If this is a file that has been deleted in the merged
@@ -1902,13 +1918,33 @@ func (cr *ConflictResolver) getActionsToMerge(
 			continue
 		}
 
+		strategy := ConflictStrategyManual
+		if unmergedChain.isFile() && mergedChain != nil {
+			s, err := cr.strategies.forPath(tlfRelativePath(mergedPath))
+			if err != nil {
+				cr.log.CWarningf(ctx, "Couldn't load conflict strategies, "+
+					"keeping both versions of %v: %+v", mergedPath, err)
+			} else {
+				strategy = s
+			}
+		}
+
 		actions, err := unmergedChain.getActionsToMerge(
 			ctx, cr.config.ConflictRenamer(), mergedPath,
-			mergedChain)
+			mergedChain, strategy)
 		if err != nil {
 			return nil, err
 		}
 
+		switch strategy {
+		case ConflictStrategyLocal:
+			cr.unrefReplacedMergedFile(
+				ctx, unmergedChains, mergedChain, actions)
+		case ConflictStrategyMerge:
+			cr.maybeAddContentMerge(
+				ctx, unmergedChain, mergedChain, mergedPath, actions)
+		}
+
 		if len(actions) > 0 {
 			actionMap[mergedPath.tailPointer()] = actions
 		}
@@ -2007,8 +2043,11 @@ func collapseActions(unmergedChains *crChains, unmergedPaths []path,
 					unmergedChains.byMostRecent[unmergedParent]
 				// If this is a file, only add a new unmerged path if
 				// the parent has ops; otherwise it will confuse the
-				// resolution code and lead to stray blocks.
-				if !chain.isFile() || len(unmergedParentChain.ops) > 0 {
+				// resolution code and lead to stray blocks.  The
+				// parent may not have a chain at all if it was
+				// only changed on the merged branch.
+				if !chain.isFile() || (unmergedParentChain != nil &&
+					len(unmergedParentChain.ops) > 0) {
 					newUnmergedPaths =
 						append(newUnmergedPaths, unmergedParentPath)
 				}
@@ -3384,7 +3423,15 @@ func (cr *ConflictResolver) syncBlocks(ctx context.Context, lState *lockState,
 			cr.log.CDebugf(ctx, "Adding sync op update %v -> %v",
 				so.File.Unref, so.File.Ref)
 			updates[so.File.Unref] = so.File.Ref
-			resOp.AddUpdate(so.File.Unref, so.File.Ref)
+			// A replaced merged file has to be updated from its
+			// merged pointer, which is what other devices know it by.
+			unref := so.File.Unref
+			if mergedMostRecent, ok :=
+				cr.replacedMergedFiles[unref]; ok {
+				unref = mergedMostRecent
+				updates[unref] = so.File.Ref
+			}
+			resOp.AddUpdate(unref, so.File.Ref)
 		}
 	}
 
@@ -3737,9 +3784,47 @@ func (cr *ConflictResolver) finalizeResolution(ctx context.Context,
 // completeResolution pushes all the resolved blocks to the servers,
 // computes all remote and local notifications, and finalizes the
 // resolution process.
+// mergeLinkCounts applies the links added and removed on the
+// unmerged branch, since the branch point, on top of the merged link
+// counts in md.
+func (cr *ConflictResolver) mergeLinkCounts(ctx context.Context,
+	md *RootMetadata, branchPoint MetadataRevision,
+	mostRecentUnmergedMD, mostRecentMergedMD ImmutableRootMetadata) error {
+	unmergedCounts := mostRecentUnmergedMD.data.LinkCounts
+	if len(unmergedCounts) == 0 &&
+		len(mostRecentMergedMD.data.LinkCounts) == 0 {
+		return nil
+	}
+
+	var baseCounts map[string]uint32
+	if mostRecentMergedMD.Revision() == branchPoint {
+		baseCounts = mostRecentMergedMD.data.LinkCounts
+	} else if branchPoint >= MetadataRevisionInitial {
+		baseMD, err := getSingleMD(ctx, cr.config, cr.fbo.id(),
+			NullBranchID, branchPoint, Merged)
+		if err != nil {
+			return err
+		}
+		baseCounts = baseMD.data.LinkCounts
+	}
+
+	for id, n := range unmergedCounts {
+		if delta := int(n) - int(baseCounts[id]); delta != 0 {
+			md.addLinks(id, delta)
+		}
+	}
+	for id, n := range baseCounts {
+		if _, ok := unmergedCounts[id]; !ok {
+			md.addLinks(id, -int(n))
+		}
+	}
+	return nil
+}
+
 func (cr *ConflictResolver) completeResolution(ctx context.Context,
 	lState *lockState, unmergedChains, mergedChains *crChains,
 	unmergedPaths []path, mergedPaths map[BlockPointer]path,
+	branchPoint MetadataRevision,
 	mostRecentUnmergedMD, mostRecentMergedMD ImmutableRootMetadata,
 	lbc localBcache, newFileBlocks fileBlockMap, dirtyBcache DirtyBlockCache,
 	writerLocked bool) (err error) {
@@ -3750,6 +3835,12 @@ func (cr *ConflictResolver) completeResolution(ctx context.Context,
 		return err
 	}
 
+	err = cr.mergeLinkCounts(
+		ctx, md, branchPoint, mostRecentUnmergedMD, mostRecentMergedMD)
+	if err != nil {
+		return err
+	}
+
 	resolvedPaths, err := cr.makePostResolutionPaths(ctx, md, unmergedChains,
 		mergedChains, mergedPaths)
 	if err != nil {
@@ -3975,8 +4066,8 @@ func (cr *ConflictResolver) doResolve(ctx context.Context, ci conflictInput) {
 		newFileBlocks := make(fileBlockMap)
 		err = cr.completeResolution(ctx, lState, unmergedChains,
 			mergedChains, unmergedPaths, mergedPaths,
-			unmergedMDs[len(unmergedMDs)-1], mostRecentMergedMD, lbc,
-			newFileBlocks, nil, doLock)
+			unmergedMDs[0].Revision()-1, unmergedMDs[len(unmergedMDs)-1],
+			mostRecentMergedMD, lbc, newFileBlocks, nil, doLock)
 		return
 	}
 
@@ -4077,12 +4168,28 @@ func (cr *ConflictResolver) doResolve(ctx context.Context, ci conflictInput) {
 	// putting the final resolved MD, and issuing all the local
 	// notifications.
 	err = cr.completeResolution(ctx, lState, unmergedChains, mergedChains,
-		unmergedPaths, mergedPaths, unmergedMDs[len(unmergedMDs)-1],
-		mostRecentMergedMD, lbc, newFileBlocks, dirtyBcache, doLock)
+		unmergedPaths, mergedPaths, unmergedMDs[0].Revision()-1,
+		unmergedMDs[len(unmergedMDs)-1], mostRecentMergedMD, lbc,
+		newFileBlocks, dirtyBcache, doLock)
 	if err != nil {
 		return
 	}
 
+	// The merges write through the regular folder operations, which
+	// may wait for CR, so they can't be part of this resolution.
+	if len(cr.contentMerges) > 0 {
+		merges := cr.contentMerges
+		cr.contentMerges = nil
+		cr.mergeGroup.Add(1)
+		go func() {
+			defer cr.mergeGroup.Done()
+			baseCtx := BackgroundContextWithCancellationDelayer()
+			defer CleanupCancellationDelayer(baseCtx)
+			cr.doContentMerges(ctxWithRandomIDReplayable(
+				baseCtx, CtxCRIDKey, CtxCROpID, cr.log), merges)
+		}()
+	}
+
 	// TODO: If conflict resolution fails after some blocks were put,
 	// remember these and include them in the later resolution so they
 	// don't count against the quota forever.  (Though of course if we
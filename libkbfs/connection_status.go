@@ -12,6 +12,8 @@ import (
 const (
 	KeybaseServiceName     = "keybase-service"
 	MDServiceName          = "md-server"
+	DeviceServiceName      = "device"
+	DiskSpaceServiceName   = "disk-space"
 	LoginStatusUpdateName  = "login"
 	LogoutStatusUpdateName = "logout"
 )
@@ -13,5 +13,9 @@ const RPCReconnectInterval = 2 * time.Second
 // rekey for the same TLF. See fbo.Rekey for more details.
 const rekeyRecheckInterval = 30 * time.Second
 
+// rekeyMaxRetryInterval bounds the backoff between attempts to retry
+// a failed rekey.
+const rekeyMaxRetryInterval = 10 * time.Minute
+
 // rekeyInitialTTL is the maximum number rechecks each rekey request can trigger.
 const rekeyInitialTTL = 4
@@ -392,6 +392,9 @@ func (cuaa *copyUnmergedAttrAction) do(ctx context.Context,
 			mergedEntry.BlockPointer = unmergedEntry.BlockPointer
 		}
 	}
+	if len(cuaa.attr) > 0 {
+		mergedEntry.copyLastWriter(unmergedEntry.EntryInfo)
+	}
 	mergedBlock.Children[cuaa.toName] = mergedEntry
 
 	return nil
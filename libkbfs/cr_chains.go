@@ -133,9 +133,74 @@ func (cc *crChain) getCollapsedWriteRange() []WriteRange {
 	return wr
 }
 
+// canMergeWrites returns whether the writes in this chain can be
+// applied on top of the writes in mergedChain without losing any of
+// them: neither chain may truncate or set attributes on the file,
+// and their writes may not overlap.
+func (cc *crChain) canMergeWrites(mergedChain *crChain) bool {
+	if !cc.isFile() || mergedChain == nil {
+		return false
+	}
+	for _, chain := range []*crChain{cc, mergedChain} {
+		for _, op := range chain.ops {
+			if _, ok := op.(*syncOp); !ok {
+				return false
+			}
+		}
+	}
+	myWriteRange := cc.getCollapsedWriteRange()
+	mergedWriteRange := mergedChain.getCollapsedWriteRange()
+	for _, mine := range myWriteRange {
+		if mine.isTruncate() {
+			return false
+		}
+		for _, theirs := range mergedWriteRange {
+			if theirs.isTruncate() {
+				return false
+			}
+			if mine.Off < theirs.End() && theirs.Off < mine.End() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyConflictStrategy replaces the actions for a conflicting
+// unmerged op on a file, according to strategy.  Only conflicts that
+// would otherwise duplicate the file are affected.
+func (cc *crChain) applyConflictStrategy(strategy ConflictStrategy,
+	unmergedOp op, mergedPath path, opActions crActionList) crActionList {
+	if !cc.isFile() {
+		return opActions
+	}
+	duplicated := false
+	for _, action := range opActions {
+		if _, ok := action.(*renameUnmergedAction); ok {
+			duplicated = true
+			break
+		}
+	}
+	if !duplicated {
+		return opActions
+	}
+
+	switch strategy {
+	case ConflictStrategyLocal:
+		// Act as if there were no merged changes at all.
+		return crActionList{unmergedOp.getDefaultAction(mergedPath)}
+	case ConflictStrategyRemote:
+		return crActionList{&dropUnmergedAction{unmergedOp}}
+	default:
+		// ConflictStrategyMerge still needs the unmerged copy, to
+		// read the unmerged writes from once the resolution is done.
+		return opActions
+	}
+}
+
 func (cc *crChain) getActionsToMerge(
 	ctx context.Context, renamer ConflictRenamer, mergedPath path,
-	mergedChain *crChain) (crActionList, error) {
+	mergedChain *crChain, strategy ConflictStrategy) (crActionList, error) {
 	var actions crActionList
 
 	// If this is a file, determine whether the unmerged chain
@@ -178,7 +243,7 @@ func (cc *crChain) getActionsToMerge(
 		if toSkip[i] {
 			continue
 		}
-		conflict := false
+		var opActions crActionList
 		if mergedChain != nil {
 			for _, mergedOp := range mergedChain.ops {
 				action, err :=
@@ -188,15 +253,17 @@ func (cc *crChain) getActionsToMerge(
 					return nil, err
 				}
 				if action != nil {
-					conflict = true
-					actions = append(actions, action)
+					opActions = append(opActions, action)
 				}
 			}
 		}
 		// no conflicts!
-		if !conflict {
+		if len(opActions) == 0 {
 			actions = append(actions, unmergedOp.getDefaultAction(mergedPath))
+			continue
 		}
+		actions = append(actions, cc.applyConflictStrategy(
+			strategy, unmergedOp, mergedPath, opActions)...)
 	}
 
 	return actions, nil
@@ -589,6 +656,8 @@ func (ccs *crChains) makeChainForOp(op op) error {
 		// ignore rekey op
 	case *GCOp:
 		// ignore gc op
+	case *unknownOp:
+		// ignore ops from newer clients
 	}
 
 	return nil
@@ -0,0 +1,275 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const (
+	// crSavedStatesDirname is the name of the directory in the
+	// storage root that holds local state saved before unmerged
+	// updates were thrown away.
+	crSavedStatesDirname = "kbfs_cr_saved"
+	// crSavedStateManifestFilename is the name of the file that
+	// describes a single saved state.  It's written last, so a
+	// saved state without one is incomplete.
+	crSavedStateManifestFilename = "manifest.json"
+	// crSavedStateFilesDirname holds the saved file contents, under
+	// their paths in the TLF.
+	crSavedStateFilesDirname = "files"
+	// maxCRSavedStateBytes bounds the total size of all saved
+	// states.  The oldest ones are deleted to make room for new
+	// ones.
+	maxCRSavedStateBytes = 1 << 30
+)
+
+// CRSavedFile describes one file or symlink in a saved state.
+type CRSavedFile struct {
+	// Path is relative to the root of the TLF.
+	Path    string
+	Type    EntryType
+	Size    uint64
+	SymPath string `json:",omitempty"`
+	// Skipped is true if the file didn't fit within the size
+	// bound, and so its contents weren't saved.
+	Skipped bool `json:",omitempty"`
+}
+
+// CRSavedState describes the local view of the files that a TLF's
+// unmerged updates changed, saved just before those updates were
+// thrown away.  The saved contents are plaintext, readable only by
+// the local user.
+type CRSavedState struct {
+	ID          string
+	TlfID       tlf.ID
+	BranchID    string
+	BranchPoint MetadataRevision
+	// Revision is the unmerged revision that was saved.
+	Revision MetadataRevision
+	SavedAt  time.Time
+	Bytes    int64
+	Files    []CRSavedFile
+}
+
+func crSavedStatesPath(storageRoot string) string {
+	return filepath.Join(storageRoot, crSavedStatesDirname)
+}
+
+// CRSavedStatePath returns the directory that holds the contents of
+// the saved state with the given ID, under their paths in the TLF.
+func CRSavedStatePath(storageRoot, id string) string {
+	return filepath.Join(
+		crSavedStatesPath(storageRoot), id, crSavedStateFilesDirname)
+}
+
+type crSavedStatesByTime []CRSavedState
+
+func (s crSavedStatesByTime) Len() int      { return len(s) }
+func (s crSavedStatesByTime) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s crSavedStatesByTime) Less(i, j int) bool {
+	return s[i].SavedAt.Before(s[j].SavedAt)
+}
+
+// ListCRSavedStates returns the complete saved states under the given
+// storage root, oldest first.
+func ListCRSavedStates(storageRoot string) ([]CRSavedState, error) {
+	fis, err := ioutil.ReadDir(crSavedStatesPath(storageRoot))
+	if ioutil.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var states []CRSavedState
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		var state CRSavedState
+		err := ioutil.DeserializeFromJSONFile(filepath.Join(
+			crSavedStatesPath(storageRoot), fi.Name(),
+			crSavedStateManifestFilename), &state)
+		if ioutil.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	sort.Sort(crSavedStatesByTime(states))
+	return states, nil
+}
+
+// DeleteCRSavedState deletes the saved state with the given ID.
+func DeleteCRSavedState(storageRoot, id string) error {
+	if id == "" || filepath.Base(id) != id {
+		return fmt.Errorf("Invalid saved state ID %q", id)
+	}
+	p := filepath.Join(crSavedStatesPath(storageRoot), id)
+	if _, err := ioutil.Stat(p); err != nil {
+		return err
+	}
+	return ioutil.RemoveAll(p)
+}
+
+// makeRoomForCRSavedState deletes the oldest saved states until
+// there's room for needed more bytes, and returns how many bytes are
+// left.
+func makeRoomForCRSavedState(ctx context.Context, log logger.Logger,
+	storageRoot string, needed int64) (int64, error) {
+	states, err := ListCRSavedStates(storageRoot)
+	if err != nil {
+		return 0, err
+	}
+	var used int64
+	for _, state := range states {
+		used += state.Bytes
+	}
+	for len(states) > 0 && used+needed > maxCRSavedStateBytes {
+		log.CDebugf(ctx, "Deleting old saved state %s to make room",
+			states[0].ID)
+		err := DeleteCRSavedState(storageRoot, states[0].ID)
+		if err != nil {
+			return 0, err
+		}
+		used -= states[0].Bytes
+		states = states[1:]
+	}
+	return maxCRSavedStateBytes - used, nil
+}
+
+// saveFileAtRevision copies the contents of the file described by de,
+// as of kmd, into a new local file at localPath.
+func saveFileAtRevision(ctx context.Context, config Config,
+	log logger.Logger, kmd ImmutableRootMetadata, de DirEntry,
+	localPath string) error {
+	fd := newFileDataAtRevision(
+		config, log, kmd, de, filepath.Base(localPath))
+
+	err := ioutil.MkdirAll(filepath.Dir(localPath), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.OpenFile(
+		localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	size := int64(de.Size)
+	for off := int64(0); off < size; off += tlfArchiveRestoreChunkSize {
+		end := off + tlfArchiveRestoreChunkSize
+		if end > size {
+			end = size
+		}
+		buf, err := fd.getBytes(ctx, off, end)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// saveUnmergedState saves the files and symlinks that differ between
+// the branch point and the unmerged head, as the unmerged head has
+// them, under the given storage root.
+func saveUnmergedState(ctx context.Context, config Config,
+	log logger.Logger, storageRoot string, bid BranchID,
+	branchPoint, unmergedHead ImmutableRootMetadata) (
+	CRSavedState, error) {
+	// Everything under a renamed directory is saved too.
+	entries, err := diffMDs(ctx, config, branchPoint, unmergedHead, false)
+	if err != nil {
+		return CRSavedState{}, err
+	}
+
+	now := config.Clock().Now()
+	state := CRSavedState{
+		ID: fmt.Sprintf("%s-%d", unmergedHead.TlfID(),
+			now.UnixNano()),
+		TlfID:       unmergedHead.TlfID(),
+		BranchID:    bid.String(),
+		BranchPoint: branchPoint.Revision(),
+		Revision:    unmergedHead.Revision(),
+		SavedAt:     now,
+	}
+	var needed int64
+	for _, e := range entries {
+		if e.Change != RevisionDiffRemoved {
+			needed += int64(e.NewSize)
+		}
+	}
+	remaining, err := makeRoomForCRSavedState(ctx, log, storageRoot, needed)
+	if err != nil {
+		return CRSavedState{}, err
+	}
+
+	// Look up each entry again in the unmerged head, since the diff
+	// doesn't keep the block pointers.
+	filesPath := CRSavedStatePath(storageRoot, state.ID)
+	for _, e := range entries {
+		if e.Change == RevisionDiffRemoved || e.Type == Dir {
+			continue
+		}
+		var components []string
+		name := e.Path
+		if i := strings.LastIndex(e.Path, "/"); i >= 0 {
+			components = strings.Split(e.Path[:i], "/")
+			name = e.Path[i+1:]
+		}
+		dblock, err := getDirBlockAtRevision(
+			ctx, config, unmergedHead, components)
+		if err != nil {
+			return CRSavedState{}, err
+		}
+		de := dblock.Children[name]
+		saved := CRSavedFile{
+			Path:    e.Path,
+			Type:    de.Type,
+			Size:    e.NewSize,
+			SymPath: de.SymPath,
+		}
+		if de.Type != Sym {
+			if int64(de.Size) > remaining {
+				log.CWarningf(ctx, "Not enough room to save %s (%d bytes)",
+					e.Path, de.Size)
+				saved.Skipped = true
+			} else {
+				err := saveFileAtRevision(ctx, config, log, unmergedHead,
+					de, filepath.Join(filesPath, filepath.FromSlash(e.Path)))
+				if err != nil {
+					return CRSavedState{}, err
+				}
+				remaining -= int64(de.Size)
+				state.Bytes += int64(de.Size)
+			}
+		}
+		state.Files = append(state.Files, saved)
+	}
+
+	err = ioutil.SerializeToJSONFile(state, filepath.Join(
+		crSavedStatesPath(storageRoot), state.ID,
+		crSavedStateManifestFilename))
+	if err != nil {
+		return CRSavedState{}, err
+	}
+	return state, nil
+}
@@ -0,0 +1,413 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// ConflictStrategy says what conflict resolution does with a file
+// whose contents or attributes were changed both locally, on the
+// unmerged branch, and remotely, on the merged branch.  Conflicts in
+// the directory structure itself are always resolved by keeping both
+// versions.
+type ConflictStrategy int
+
+const (
+	// ConflictStrategyManual keeps both versions, by renaming the
+	// local one to a conflict name, so that the user can sort them
+	// out.  It's the default.
+	ConflictStrategyManual ConflictStrategy = iota
+	// ConflictStrategyLocal keeps the local version, and throws away
+	// the remote one.
+	ConflictStrategyLocal
+	// ConflictStrategyRemote keeps the remote version, and throws
+	// away the local one.
+	ConflictStrategyRemote
+	// ConflictStrategyMerge writes the local changes into the remote
+	// version, as long as neither branch truncated the file and the
+	// two branches wrote to different parts of it.  Otherwise it
+	// falls back to ConflictStrategyManual.
+	ConflictStrategyMerge
+)
+
+// String implements the fmt.Stringer interface for ConflictStrategy.
+func (s ConflictStrategy) String() string {
+	switch s {
+	case ConflictStrategyManual:
+		return "manual"
+	case ConflictStrategyLocal:
+		return "ours"
+	case ConflictStrategyRemote:
+		return "theirs"
+	case ConflictStrategyMerge:
+		return "merge"
+	}
+	return "<invalid ConflictStrategy>"
+}
+
+// ParseConflictStrategy returns the strategy with the given name, as
+// returned by ConflictStrategy.String.
+func ParseConflictStrategy(s string) (ConflictStrategy, error) {
+	for _, strategy := range []ConflictStrategy{ConflictStrategyManual,
+		ConflictStrategyLocal, ConflictStrategyRemote,
+		ConflictStrategyMerge} {
+		if s == strategy.String() {
+			return strategy, nil
+		}
+	}
+	return ConflictStrategyManual, InvalidConflictStrategyError{s}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for
+// ConflictStrategy.
+func (s ConflictStrategy) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface for
+// ConflictStrategy.
+func (s *ConflictStrategy) UnmarshalText(data []byte) error {
+	strategy, err := ParseConflictStrategy(string(data))
+	if err != nil {
+		return err
+	}
+	*s = strategy
+	return nil
+}
+
+// ConflictStrategyRule sets the conflict strategy for every file
+// under PathPrefix.  The rule with the longest matching prefix wins.
+type ConflictStrategyRule struct {
+	// PathPrefix is relative to the root of the TLF, and is empty
+	// for a rule that covers the whole TLF.
+	PathPrefix string
+	Strategy   ConflictStrategy
+}
+
+type conflictStrategyRulesByPrefix []ConflictStrategyRule
+
+func (r conflictStrategyRulesByPrefix) Len() int      { return len(r) }
+func (r conflictStrategyRulesByPrefix) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r conflictStrategyRulesByPrefix) Less(i, j int) bool {
+	return r[i].PathPrefix < r[j].PathPrefix
+}
+
+// crStrategiesDirname is the name of the directory in the storage
+// root that holds one file of conflict strategy rules per TLF.
+const crStrategiesDirname = "kbfs_cr_strategies"
+
+// cleanConflictStrategyPrefix returns the canonical form of a path
+// prefix within a TLF.
+func cleanConflictStrategyPrefix(prefix string) (string, error) {
+	var components []string
+	for _, c := range strings.Split(prefix, "/") {
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			return "", InvalidConflictStrategyPrefixError{prefix}
+		}
+		components = append(components, c)
+	}
+	return strings.Join(components, "/"), nil
+}
+
+// conflictStrategyRuleMatches returns whether the given rule prefix
+// covers p, a path relative to the root of the TLF.
+func conflictStrategyRuleMatches(prefix, p string) bool {
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// crStrategyStore holds the conflict strategy rules for one TLF,
+// saved under the storage root if there is one, and only in memory
+// otherwise.
+type crStrategyStore struct {
+	// filePath is empty if the rules aren't saved.
+	filePath string
+
+	lock  sync.Mutex
+	rules []ConflictStrategyRule
+}
+
+func newCRStrategyStore(storageRoot string, id tlf.ID) *crStrategyStore {
+	s := &crStrategyStore{}
+	if storageRoot != "" {
+		s.filePath = filepath.Join(
+			storageRoot, crStrategiesDirname, id.String()+".json")
+	}
+	return s
+}
+
+// loadLocked reads the saved rules every time, so that changes made
+// by other processes sharing the storage root, like kbfstool, take
+// effect right away.
+func (s *crStrategyStore) loadLocked() error {
+	if s.filePath == "" {
+		return nil
+	}
+	var rules []ConflictStrategyRule
+	err := ioutil.DeserializeFromJSONFile(s.filePath, &rules)
+	if err != nil && !ioutil.IsNotExist(err) {
+		return err
+	}
+	sort.Sort(conflictStrategyRulesByPrefix(rules))
+	s.rules = rules
+	return nil
+}
+
+func (s *crStrategyStore) getRules() ([]ConflictStrategyRule, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.loadLocked()
+	if err != nil {
+		return nil, err
+	}
+	return append([]ConflictStrategyRule(nil), s.rules...), nil
+}
+
+// setRule replaces the rule for the given prefix, or removes it if
+// rule is nil.
+func (s *crStrategyStore) setRule(
+	prefix string, rule *ConflictStrategyRule) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.loadLocked()
+	if err != nil {
+		return err
+	}
+
+	rules := make([]ConflictStrategyRule, 0, len(s.rules)+1)
+	for _, r := range s.rules {
+		if r.PathPrefix != prefix {
+			rules = append(rules, r)
+		}
+	}
+	if rule != nil {
+		rules = append(rules, *rule)
+	}
+	sort.Sort(conflictStrategyRulesByPrefix(rules))
+
+	if s.filePath != "" {
+		err := ioutil.SerializeToJSONFile(rules, s.filePath)
+		if err != nil {
+			return err
+		}
+	}
+	s.rules = rules
+	return nil
+}
+
+func (s *crStrategyStore) set(
+	prefix string, strategy ConflictStrategy) error {
+	prefix, err := cleanConflictStrategyPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	return s.setRule(prefix, &ConflictStrategyRule{prefix, strategy})
+}
+
+func (s *crStrategyStore) clear(prefix string) error {
+	prefix, err := cleanConflictStrategyPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	return s.setRule(prefix, nil)
+}
+
+// forPath returns the strategy for p, a path relative to the root of
+// the TLF.
+func (s *crStrategyStore) forPath(p string) (ConflictStrategy, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.loadLocked()
+	if err != nil {
+		return ConflictStrategyManual, err
+	}
+	strategy := ConflictStrategyManual
+	longest := -1
+	for _, r := range s.rules {
+		if len(r.PathPrefix) > longest &&
+			conflictStrategyRuleMatches(r.PathPrefix, p) {
+			strategy = r.Strategy
+			longest = len(r.PathPrefix)
+		}
+	}
+	return strategy, nil
+}
+
+// tlfRelativePath returns p relative to the root of its TLF.
+func tlfRelativePath(p path) string {
+	names := make([]string, 0, len(p.path))
+	for _, node := range p.path[1:] {
+		names = append(names, node.Name)
+	}
+	return strings.Join(names, "/")
+}
+
+// unrefReplacedMergedFile unreferences the blocks the merged branch
+// wrote for a file, if actions replace the file with its unmerged
+// version.
+func (cr *ConflictResolver) unrefReplacedMergedFile(ctx context.Context,
+	unmergedChains *crChains, mergedChain *crChain, actions crActionList) {
+	replaced := false
+	for _, action := range actions {
+		if _, ok := action.(*copyUnmergedEntryAction); ok {
+			replaced = true
+			break
+		}
+	}
+	if !replaced || mergedChain == nil {
+		return
+	}
+
+	unrefs := make(map[BlockPointer]bool)
+	if mergedChain.mostRecent != mergedChain.original {
+		unrefs[mergedChain.mostRecent] = true
+	}
+	for _, op := range mergedChain.ops {
+		if so, ok := op.(*syncOp); ok {
+			for _, ptr := range so.Refs() {
+				unrefs[ptr] = true
+			}
+		}
+	}
+	// Blocks that were already replaced on the merged branch have
+	// been unreferenced there.
+	for _, op := range mergedChain.ops {
+		if so, ok := op.(*syncOp); ok {
+			for _, ptr := range so.Unrefs() {
+				delete(unrefs, ptr)
+			}
+		}
+	}
+	for ptr := range unrefs {
+		cr.log.CDebugf(ctx, "Unreferencing replaced merged block %v", ptr)
+		unmergedChains.toUnrefPointers[ptr] = true
+	}
+	if mergedChain.mostRecent != mergedChain.original {
+		cr.replacedMergedFiles[mergedChain.original] = mergedChain.mostRecent
+	}
+}
+
+// crContentMerge is a file whose unmerged writes are to be copied
+// from its conflict copy once a resolution completes.
+type crContentMerge struct {
+	// dir is the path of the file's parent, relative to the root of
+	// the TLF.
+	dir  string
+	name string
+	// copy is resolved by the time the merge happens, so its toName
+	// is the final name of the conflict copy.
+	copy   *renameUnmergedAction
+	writes []WriteRange
+}
+
+// maybeAddContentMerge schedules a content merge for a conflicting
+// file, if its writes can be merged.
+func (cr *ConflictResolver) maybeAddContentMerge(ctx context.Context,
+	unmergedChain, mergedChain *crChain, mergedPath path,
+	actions crActionList) {
+	var rua *renameUnmergedAction
+	for _, action := range actions {
+		if a, ok := action.(*renameUnmergedAction); ok {
+			if rua != nil {
+				// More than one conflict; leave both copies.
+				return
+			}
+			rua = a
+		}
+	}
+	if rua == nil {
+		return
+	}
+	if !unmergedChain.canMergeWrites(mergedChain) {
+		cr.log.CDebugf(ctx, "Can't merge the writes to %v; keeping both "+
+			"versions", mergedPath)
+		return
+	}
+	cr.contentMerges = append(cr.contentMerges, crContentMerge{
+		dir:    tlfRelativePath(*mergedPath.parentPath()),
+		name:   mergedPath.tailName(),
+		copy:   rua,
+		writes: unmergedChain.getCollapsedWriteRange(),
+	})
+}
+
+func (cr *ConflictResolver) doContentMerge(
+	ctx context.Context, m crContentMerge) error {
+	dir, _, _, err := cr.fbo.getRootNode(ctx)
+	if err != nil {
+		return err
+	}
+	if m.dir != "" {
+		for _, name := range strings.Split(m.dir, "/") {
+			dir, _, err = cr.fbo.Lookup(ctx, dir, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	file, _, err := cr.fbo.Lookup(ctx, dir, m.name)
+	if err != nil {
+		return err
+	}
+	conflictCopy, _, err := cr.fbo.Lookup(ctx, dir, m.copy.toName)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range m.writes {
+		for off := w.Off; off < w.End(); off += tlfArchiveRestoreChunkSize {
+			end := off + tlfArchiveRestoreChunkSize
+			if end > w.End() {
+				end = w.End()
+			}
+			buf := make([]byte, end-off)
+			n, err := cr.fbo.Read(ctx, conflictCopy, buf, int64(off))
+			if err != nil {
+				return err
+			}
+			if n != int64(len(buf)) {
+				return fmt.Errorf("Short read of %s at %d: %d of %d bytes",
+					m.copy.toName, off, n, len(buf))
+			}
+			err = cr.fbo.Write(ctx, file, buf, int64(off))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = cr.fbo.Sync(ctx, file)
+	if err != nil {
+		return err
+	}
+	return cr.fbo.RemoveEntry(ctx, dir, m.copy.toName)
+}
+
+// doContentMerges copies the unmerged writes of each given file from
+// its conflict copy, and then removes the copy.  A file that can't be
+// merged keeps its conflict copy.
+func (cr *ConflictResolver) doContentMerges(
+	ctx context.Context, merges []crContentMerge) {
+	for _, m := range merges {
+		cr.log.CDebugf(ctx, "Merging %s into %s/%s", m.copy.toName, m.dir,
+			m.name)
+		err := cr.doContentMerge(ctx, m)
+		if err != nil {
+			cr.log.CWarningf(ctx, "Couldn't merge the local changes to "+
+				"%s/%s; they're still in %s: %+v", m.dir, m.name,
+				m.copy.toName, err)
+		}
+	}
+}
@@ -0,0 +1,624 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const (
+	// crossTLFMovePartialSuffix is appended to the destination name
+	// while a move across TLFs is still copying data.  If the move
+	// is interrupted, the next attempt at the same move picks up
+	// where this partial copy left off, as long as the source
+	// hasn't changed in the meantime.
+	crossTLFMovePartialSuffix = ".kbfs-move-partial"
+	// crossTLFMoveSourceSuffix is appended to the destination name
+	// for a small file recording which version of the source is
+	// being moved.  It's removed once the source is.
+	crossTLFMoveSourceSuffix = ".kbfs-move-source"
+	// crossTLFMoveChunkBytes is how much file data is copied or
+	// compared at a time.
+	crossTLFMoveChunkBytes = 1 << 20
+	// crossTLFMoveMaxSourceBytes bounds the size of a source record
+	// that will be read back.
+	crossTLFMoveMaxSourceBytes = 1 << 10
+)
+
+// CrossTLFMoveStatus describes the progress of a move between two
+// TLFs.
+type CrossTLFMoveStatus struct {
+	From        string
+	To          string
+	TotalBytes  int64
+	CopiedBytes int64
+}
+
+type crossTLFMoveStatusesByTo []CrossTLFMoveStatus
+
+func (s crossTLFMoveStatusesByTo) Len() int {
+	return len(s)
+}
+
+func (s crossTLFMoveStatusesByTo) Less(i, j int) bool {
+	return s[i].To < s[j].To
+}
+
+func (s crossTLFMoveStatusesByTo) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// crossTLFMove copies a file or directory tree from one TLF to
+// another through KBFSOps, so that all of the data is re-encrypted
+// under the destination's keys, and then removes the source.
+type crossTLFMove struct {
+	fs     *KBFSOpsStandard
+	dstTlf tlf.ID
+
+	statusLock sync.Mutex
+	status     CrossTLFMoveStatus
+}
+
+func (m *crossTLFMove) getStatus() CrossTLFMoveStatus {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	return m.status
+}
+
+func (m *crossTLFMove) addTotalBytes(n int64) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	m.status.TotalBytes += n
+}
+
+func (m *crossTLFMove) addCopiedBytes(n int64) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	m.status.CopiedBytes += n
+}
+
+// countBytes adds up the size of all the files under the entry named
+// name in dir, described by ei.
+func (m *crossTLFMove) countBytes(
+	ctx context.Context, dir Node, name string, ei EntryInfo) error {
+	switch ei.Type {
+	case File, Exec:
+		m.addTotalBytes(int64(ei.Size))
+	case Dir:
+		node, _, err := m.fs.Lookup(ctx, dir, name)
+		if err != nil {
+			return err
+		}
+		children, err := m.fs.GetDirChildren(ctx, node)
+		if err != nil {
+			return err
+		}
+		for childName, childEI := range children {
+			err := m.countBytes(ctx, node, childName, childEI)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// throttle waits until the disk limiter would let a block of n bytes
+// into the destination's journal, if it has one, so that a big move
+// can't fill up the journal faster than it can be flushed.
+func (m *crossTLFMove) throttle(ctx context.Context, n int64) error {
+	diskLimiter := m.fs.config.DiskLimiter()
+	if diskLimiter == nil || !TLFJournalEnabled(m.fs.config, m.dstTlf) {
+		return nil
+	}
+	_, _, err := diskLimiter.beforeBlockPut(ctx, n, 1)
+	if err != nil {
+		return err
+	}
+	// Nothing is actually put here; the real block puts will
+	// account for themselves.
+	diskLimiter.afterBlockPut(ctx, n, 1, false)
+	return nil
+}
+
+// removeTree removes the entry named name in dir, described by ei,
+// and everything under it.
+func (m *crossTLFMove) removeTree(
+	ctx context.Context, dir Node, name string, ei EntryInfo) error {
+	if ei.Type != Dir {
+		return m.fs.RemoveEntry(ctx, dir, name)
+	}
+	return m.fs.RemoveDirTree(ctx, dir, name)
+}
+
+// sourceVersion returns a string identifying the current version of
+// the entry named name in dir, described by ei.  Since a change to
+// any file gives it and all of its parent directories new blocks,
+// the ID of the entry's block changes whenever anything under it
+// does.
+func (m *crossTLFMove) sourceVersion(
+	ctx context.Context, dir Node, name string, ei EntryInfo) (
+	string, error) {
+	if ei.Type == Sym {
+		return "sym:" + ei.SymPath, nil
+	}
+	node, _, err := m.fs.Lookup(ctx, dir, name)
+	if err != nil {
+		return "", err
+	}
+	md, err := m.fs.GetNodeMetadata(ctx, node)
+	if err != nil {
+		return "", err
+	}
+	return md.BlockInfo.ID.String(), nil
+}
+
+// readSourceRecord returns the source version recorded in the file
+// named name in dir, or the empty string if there isn't one.
+// children are the current children of dir.
+func (m *crossTLFMove) readSourceRecord(ctx context.Context, dir Node,
+	name string, children map[string]EntryInfo) (string, error) {
+	ei, ok := children[name]
+	if !ok || ei.Type != File || ei.Size > crossTLFMoveMaxSourceBytes {
+		return "", nil
+	}
+	node, _, err := m.fs.Lookup(ctx, dir, name)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, ei.Size)
+	n, err := m.fs.Read(ctx, node, buf, 0)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+// writeSourceRecord records version in a new file named name in dir,
+// replacing the one in children, if any.
+func (m *crossTLFMove) writeSourceRecord(ctx context.Context, dir Node,
+	name string, children map[string]EntryInfo, version string) error {
+	if ei, ok := children[name]; ok {
+		err := m.removeTree(ctx, dir, name, ei)
+		if err != nil {
+			return err
+		}
+	}
+	node, _, err := m.fs.CreateFile(ctx, dir, name, false, NoExcl)
+	if err != nil {
+		return err
+	}
+	err = m.fs.Write(ctx, node, []byte(version), 0)
+	if err != nil {
+		return err
+	}
+	return m.fs.Sync(ctx, node)
+}
+
+// copyFile copies the contents of src, with entry info srcEI, into
+// the file named dstName in dstDir, whose entry info is dstEI if
+// dstExists is true.  The destination is only reused if it was being
+// copied from this same version of the source, so if it's shorter
+// than the source, the copy resumes from the end of the destination.
+func (m *crossTLFMove) copyFile(ctx context.Context, src Node,
+	srcEI EntryInfo, dstDir Node, dstName string, dstEI EntryInfo,
+	dstExists bool) error {
+	var dst Node
+	var off int64
+	if dstExists {
+		var err error
+		dst, _, err = m.fs.Lookup(ctx, dstDir, dstName)
+		if err != nil {
+			return err
+		}
+		off = int64(dstEI.Size)
+		if dstEI.Size > srcEI.Size {
+			// Not a prefix of the source, so start over.
+			err = m.fs.Truncate(ctx, dst, 0)
+			if err != nil {
+				return err
+			}
+			off = 0
+		}
+		m.addCopiedBytes(off)
+	} else {
+		var err error
+		dst, _, err = m.fs.CreateFile(
+			ctx, dstDir, dstName, srcEI.Type == Exec, NoExcl)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, crossTLFMoveChunkBytes)
+	for off < int64(srcEI.Size) {
+		err := m.throttle(ctx, int64(len(buf)))
+		if err != nil {
+			return err
+		}
+		n, err := m.fs.Read(ctx, src, buf, off)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		err = m.fs.Write(ctx, dst, buf[:n], off)
+		if err != nil {
+			return err
+		}
+		off += n
+		m.addCopiedBytes(n)
+	}
+
+	mtime := time.Unix(0, srcEI.Mtime)
+	err := m.fs.SetMtime(ctx, dst, &mtime)
+	if err != nil {
+		return err
+	}
+	return m.fs.Sync(ctx, dst)
+}
+
+// copyEntry copies the entry named srcName in srcDir, described by
+// srcEI, to the entry named dstName in dstDir.  Anything already
+// present at the destination is assumed to be left over from an
+// earlier attempt at copying the same version of the source, and is
+// reused where possible.
+func (m *crossTLFMove) copyEntry(ctx context.Context, srcDir Node,
+	srcName string, srcEI EntryInfo, dstDir Node, dstName string) error {
+	dstChildren, err := m.fs.GetDirChildren(ctx, dstDir)
+	if err != nil {
+		return err
+	}
+	dstEI, dstExists := dstChildren[dstName]
+	sameType := dstEI.Type == srcEI.Type ||
+		(dstEI.Type == File && srcEI.Type == Exec) ||
+		(dstEI.Type == Exec && srcEI.Type == File)
+	if dstExists && !sameType {
+		err := m.removeTree(ctx, dstDir, dstName, dstEI)
+		if err != nil {
+			return err
+		}
+		dstExists = false
+	}
+
+	switch srcEI.Type {
+	case Sym:
+		if dstExists {
+			if dstEI.SymPath == srcEI.SymPath {
+				return nil
+			}
+			err := m.fs.RemoveEntry(ctx, dstDir, dstName)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := m.fs.CreateLink(ctx, dstDir, dstName, srcEI.SymPath)
+		return err
+	case File, Exec:
+		src, _, err := m.fs.Lookup(ctx, srcDir, srcName)
+		if err != nil {
+			return err
+		}
+		err = m.copyFile(
+			ctx, src, srcEI, dstDir, dstName, dstEI, dstExists)
+		if err != nil {
+			return err
+		}
+		if dstExists && dstEI.Type != srcEI.Type {
+			dst, _, err := m.fs.Lookup(ctx, dstDir, dstName)
+			if err != nil {
+				return err
+			}
+			return m.fs.SetEx(ctx, dst, srcEI.Type == Exec)
+		}
+		return nil
+	case Dir:
+		src, _, err := m.fs.Lookup(ctx, srcDir, srcName)
+		if err != nil {
+			return err
+		}
+		var dst Node
+		if dstExists {
+			dst, _, err = m.fs.Lookup(ctx, dstDir, dstName)
+		} else {
+			dst, _, err = m.fs.CreateDir(ctx, dstDir, dstName)
+		}
+		if err != nil {
+			return err
+		}
+		children, err := m.fs.GetDirChildren(ctx, src)
+		if err != nil {
+			return err
+		}
+		// Go in a stable order, so a resumed move finishes off the
+		// same entry it was in the middle of.
+		names := make([]string, 0, len(children))
+		for name := range children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			err := m.copyEntry(ctx, src, name, children[name], dst, name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unexpected entry type %s for %s",
+			srcEI.Type, srcName)
+	}
+}
+
+// verifyFile checks that the file dst has the same contents as src,
+// which is described by srcEI.
+func (m *crossTLFMove) verifyFile(ctx context.Context, src Node,
+	srcEI EntryInfo, dst Node, dstEI EntryInfo, srcPath string) error {
+	if dstEI.Size != srcEI.Size {
+		return CrossTLFMoveMismatchError{srcPath}
+	}
+	srcBuf := make([]byte, crossTLFMoveChunkBytes)
+	dstBuf := make([]byte, crossTLFMoveChunkBytes)
+	for off := int64(0); off < int64(srcEI.Size); {
+		n, err := m.fs.Read(ctx, src, srcBuf, off)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return CrossTLFMoveMismatchError{srcPath}
+		}
+		dstN, err := m.fs.Read(ctx, dst, dstBuf[:n], off)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(srcBuf[:n], dstBuf[:dstN]) {
+			return CrossTLFMoveMismatchError{srcPath}
+		}
+		off += n
+	}
+	return nil
+}
+
+// verifyEntry checks that the entry named dstName in dstDir is an
+// exact copy of the entry named srcName in srcDir, described by
+// srcEI.  srcPath is only used in the returned error.
+func (m *crossTLFMove) verifyEntry(ctx context.Context, srcDir Node,
+	srcName string, srcEI EntryInfo, dstDir Node, dstName string,
+	srcPath string) error {
+	dstChildren, err := m.fs.GetDirChildren(ctx, dstDir)
+	if err != nil {
+		return err
+	}
+	dstEI, ok := dstChildren[dstName]
+	if !ok || dstEI.Type != srcEI.Type {
+		return CrossTLFMoveMismatchError{srcPath}
+	}
+
+	switch srcEI.Type {
+	case Sym:
+		if dstEI.SymPath != srcEI.SymPath {
+			return CrossTLFMoveMismatchError{srcPath}
+		}
+		return nil
+	case File, Exec:
+		src, _, err := m.fs.Lookup(ctx, srcDir, srcName)
+		if err != nil {
+			return err
+		}
+		dst, _, err := m.fs.Lookup(ctx, dstDir, dstName)
+		if err != nil {
+			return err
+		}
+		return m.verifyFile(ctx, src, srcEI, dst, dstEI, srcPath)
+	case Dir:
+		src, _, err := m.fs.Lookup(ctx, srcDir, srcName)
+		if err != nil {
+			return err
+		}
+		dst, _, err := m.fs.Lookup(ctx, dstDir, dstName)
+		if err != nil {
+			return err
+		}
+		srcChildren, err := m.fs.GetDirChildren(ctx, src)
+		if err != nil {
+			return err
+		}
+		dstChildren, err := m.fs.GetDirChildren(ctx, dst)
+		if err != nil {
+			return err
+		}
+		if len(dstChildren) != len(srcChildren) {
+			return CrossTLFMoveMismatchError{srcPath}
+		}
+		for name, ei := range srcChildren {
+			err := m.verifyEntry(
+				ctx, src, name, ei, dst, name, srcPath+"/"+name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unexpected entry type %s for %s",
+			srcEI.Type, srcName)
+	}
+}
+
+func (fs *KBFSOpsStandard) addCrossTLFMove(m *crossTLFMove) {
+	fs.crossTLFMovesLock.Lock()
+	defer fs.crossTLFMovesLock.Unlock()
+	fs.crossTLFMoves[m] = true
+}
+
+func (fs *KBFSOpsStandard) removeCrossTLFMove(m *crossTLFMove) {
+	fs.crossTLFMovesLock.Lock()
+	defer fs.crossTLFMovesLock.Unlock()
+	delete(fs.crossTLFMoves, m)
+}
+
+func (fs *KBFSOpsStandard) getCrossTLFMoveStatuses() []CrossTLFMoveStatus {
+	fs.crossTLFMovesLock.Lock()
+	defer fs.crossTLFMovesLock.Unlock()
+	if len(fs.crossTLFMoves) == 0 {
+		return nil
+	}
+	statuses := make([]CrossTLFMoveStatus, 0, len(fs.crossTLFMoves))
+	for m := range fs.crossTLFMoves {
+		statuses = append(statuses, m.getStatus())
+	}
+	sort.Sort(crossTLFMoveStatusesByTo(statuses))
+	return statuses
+}
+
+// MoveAcrossTLFs implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) MoveAcrossTLFs(
+	ctx context.Context, srcParent Node, srcName string, dstParent Node,
+	dstName string) (err error) {
+	fs.log.CDebugf(ctx, "MoveAcrossTLFs %s/%s -> %s/%s",
+		getNodeIDStr(srcParent), srcName, getNodeIDStr(dstParent), dstName)
+	defer func() { fs.deferLog.CDebugf(ctx, "Done: %+v", err) }()
+
+	srcFB := srcParent.GetFolderBranch()
+	dstFB := dstParent.GetFolderBranch()
+	if srcFB.Tlf == dstFB.Tlf || srcFB.Branch != MasterBranch ||
+		dstFB.Branch != MasterBranch {
+		return RenameAcrossDirsError{}
+	}
+
+	srcOps := fs.getOpsByNode(ctx, srcParent)
+	dstOps := fs.getOpsByNode(ctx, dstParent)
+	srcDirPath, err := srcOps.pathFromNodeForRead(srcParent)
+	if err != nil {
+		return err
+	}
+	dstDirPath, err := dstOps.pathFromNodeForRead(dstParent)
+	if err != nil {
+		return err
+	}
+	srcPath := srcDirPath.ChildPathNoPtr(srcName).String()
+	dstPath := dstDirPath.ChildPathNoPtr(dstName).String()
+
+	// Make sure the source can be removed once it's been copied,
+	// so we don't leave a copy behind in the destination.
+	err = srcOps.checkWritable(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+
+	m := &crossTLFMove{
+		fs:     fs,
+		dstTlf: dstFB.Tlf,
+		status: CrossTLFMoveStatus{From: srcPath, To: dstPath},
+	}
+	fs.addCrossTLFMove(m)
+	defer fs.removeCrossTLFMove(m)
+
+	partialName := dstName + crossTLFMovePartialSuffix
+	sourceName := dstName + crossTLFMoveSourceSuffix
+	srcChildren, err := fs.GetDirChildren(ctx, srcParent)
+	if err != nil {
+		return err
+	}
+	dstChildren, err := fs.GetDirChildren(ctx, dstParent)
+	if err != nil {
+		return err
+	}
+	recorded, err := m.readSourceRecord(ctx, dstParent, sourceName, dstChildren)
+	if err != nil {
+		return err
+	}
+	_, partialExists := dstChildren[partialName]
+	_, dstExists := dstChildren[dstName]
+
+	srcEI, ok := srcChildren[srcName]
+	if !ok {
+		// If an earlier attempt got as far as removing the source,
+		// the verified copy is already in place, and only the
+		// record of the source is left to clean up.  A partial
+		// copy can't be finished without its source, so it's
+		// never put in place.
+		if recorded == "" || !dstExists || partialExists {
+			return NoSuchNameError{srcName}
+		}
+		return fs.RemoveEntry(ctx, dstParent, sourceName)
+	}
+
+	version, err := m.sourceVersion(ctx, srcParent, srcName, srcEI)
+	if err != nil {
+		return err
+	}
+
+	// Copy into a partial entry first, and only give it its real
+	// name once it's been verified, so the destination never shows
+	// an incomplete copy under its final name.  If an earlier
+	// attempt was interrupted after renaming the copy, only the
+	// verification and the removal of the source are left.
+	copyName := dstName
+	if version != recorded || !dstExists || partialExists {
+		if version != recorded {
+			// Whatever was left by an earlier attempt was copied
+			// from some other version of the source, so start
+			// over.
+			if partialExists {
+				err := m.removeTree(ctx, dstParent, partialName,
+					dstChildren[partialName])
+				if err != nil {
+					return err
+				}
+			}
+			err := m.writeSourceRecord(
+				ctx, dstParent, sourceName, dstChildren, version)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = m.countBytes(ctx, srcParent, srcName, srcEI)
+		if err != nil {
+			return err
+		}
+		err = m.copyEntry(
+			ctx, srcParent, srcName, srcEI, dstParent, partialName)
+		if err != nil {
+			return err
+		}
+		copyName = partialName
+	}
+
+	err = m.verifyEntry(
+		ctx, srcParent, srcName, srcEI, dstParent, copyName, srcPath)
+	if _, isMismatch := err.(CrossTLFMoveMismatchError); isMismatch {
+		// Drop the record, so the next attempt starts over rather
+		// than reusing a bad copy.
+		if removeErr := fs.RemoveEntry(
+			ctx, dstParent, sourceName); removeErr != nil {
+			fs.log.CDebugf(ctx, "Couldn't remove %s: %+v",
+				sourceName, removeErr)
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if copyName != dstName {
+		err = fs.Rename(ctx, dstParent, copyName, dstParent, dstName)
+		if err != nil {
+			return err
+		}
+	}
+	err = m.removeTree(ctx, srcParent, srcName, srcEI)
+	if err != nil {
+		return err
+	}
+	return fs.RemoveEntry(ctx, dstParent, sourceName)
+}
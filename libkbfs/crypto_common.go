@@ -252,6 +252,13 @@ func (c CryptoCommon) EncryptPrivateMetadata(
 }
 
 func (c CryptoCommon) decryptData(encryptedData encryptedData, key [32]byte) ([]byte, error) {
+	return c.decryptDataTo(nil, encryptedData, key)
+}
+
+// decryptDataTo is like decryptData, but appends the decrypted data
+// to out, and so uses out's memory if it has room.
+func (c CryptoCommon) decryptDataTo(out []byte, encryptedData encryptedData,
+	key [32]byte) ([]byte, error) {
 	if encryptedData.Version != EncryptionSecretbox {
 		return nil, errors.WithStack(
 			UnknownEncryptionVer{encryptedData.Version})
@@ -265,7 +272,7 @@ func (c CryptoCommon) decryptData(encryptedData encryptedData, key [32]byte) ([]
 	copy(nonce[:], encryptedData.Nonce)
 
 	decryptedData, ok := secretbox.Open(
-		nil, encryptedData.EncryptedData, &nonce, &key)
+		out, encryptedData.EncryptedData, &nonce, &key)
 	if !ok {
 		return nil, errors.WithStack(libkb.DecryptionError{})
 	}
@@ -377,11 +384,15 @@ func (c CryptoCommon) EncryptBlock(block Block, key kbfscrypto.BlockCryptKey) (
 func (c CryptoCommon) DecryptBlock(
 	encryptedBlock EncryptedBlock, key kbfscrypto.BlockCryptKey,
 	block Block) error {
-	paddedBlock, err := c.decryptData(
+	// The padded block is dead once it's decoded, so decrypt it
+	// into a recycled buffer.
+	paddedBlock, err := c.decryptDataTo(
+		getBlockBuffer(len(encryptedBlock.EncryptedData)),
 		encryptedBlock.encryptedData, key.Data())
 	if err != nil {
 		return err
 	}
+	defer putBlockBuffer(paddedBlock)
 
 	encodedBlock, err := c.depadBlock(paddedBlock)
 	if err != nil {
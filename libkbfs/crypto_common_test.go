@@ -404,6 +404,35 @@ func TestDecryptEncryptedBlock(t *testing.T) {
 	require.Equal(t, block, decryptedBlock)
 }
 
+// Test that a block decrypted by crypto.DecryptBlock() doesn't share
+// memory with the buffer it was decrypted into, which gets recycled.
+func TestDecryptBlockRecyclesBuffer(t *testing.T) {
+	c := MakeCryptoCommon(kbfscodec.NewMsgpack())
+
+	cryptKey := makeFakeBlockCryptKey(t)
+
+	block := NewFileBlock().(*FileBlock)
+	block.Contents = bytes.Repeat([]byte{1}, 10000)
+
+	_, encryptedBlock, err := c.EncryptBlock(block, cryptKey)
+	require.NoError(t, err)
+
+	decryptedBlock := NewFileBlock().(*FileBlock)
+	err = c.DecryptBlock(encryptedBlock, cryptKey, decryptedBlock)
+	require.NoError(t, err)
+
+	// Scribble over whatever the pool hands out next.
+	for i := 0; i < 10; i++ {
+		buf := getBlockBuffer(len(encryptedBlock.EncryptedData))
+		buf = buf[:cap(buf)]
+		for j := range buf {
+			buf[j] = 0xff
+		}
+		defer putBlockBuffer(buf)
+	}
+	require.Equal(t, block.Contents, decryptedBlock.Contents)
+}
+
 // Test various failure cases for crypto.DecryptBlock().
 func TestDecryptBlockFailures(t *testing.T) {
 	c := MakeCryptoCommon(kbfscodec.NewMsgpack())
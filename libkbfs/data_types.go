@@ -575,6 +575,40 @@ type EntryInfo struct {
 	Mtime int64
 	// Ctime is in unix nanoseconds
 	Ctime int64
+	// LastWriterUID, LastWriterKID, and LastWriterRevision are the
+	// user and device that last changed the entry, and the revision
+	// they changed it in.  They're set whenever the mtime or ctime
+	// is, and are empty for entries last changed before they were
+	// recorded.  They're claimed by the writer and not verified,
+	// and the revision may be off for changes that went through
+	// conflict resolution.
+	LastWriterUID      keybase1.UID     `codec:",omitempty"`
+	LastWriterKID      keybase1.KID     `codec:",omitempty"`
+	LastWriterRevision MetadataRevision `codec:",omitempty"`
+	// LinkID identifies the group of entries made from the same
+	// file by hard links, or is empty if the file was never linked.
+	// It's the ID of the file's block when it was first linked.
+	LinkID string `codec:",omitempty"`
+	// Nlink is the number of entries in the TLF in this entry's link
+	// group, or zero if it was never linked.  The count is kept once
+	// per TLF in the MD, and is filled in when the entry is read.
+	Nlink uint32 `codec:"-"`
+}
+
+// setLastWriter records the user and device of session as the last
+// writer of ei, in the given revision.
+func (ei *EntryInfo) setLastWriter(
+	session SessionInfo, rev MetadataRevision) {
+	ei.LastWriterUID = session.UID
+	ei.LastWriterKID = session.VerifyingKey.KID()
+	ei.LastWriterRevision = rev
+}
+
+// copyLastWriter sets the last writer of ei to that of other.
+func (ei *EntryInfo) copyLastWriter(other EntryInfo) {
+	ei.LastWriterUID = other.LastWriterUID
+	ei.LastWriterKID = other.LastWriterKID
+	ei.LastWriterRevision = other.LastWriterRevision
 }
 
 // ReportedError represents an error reported by KBFS.
@@ -733,6 +767,65 @@ type RekeyResult struct {
 	NeedsPaperKey bool
 }
 
+// RekeyState is where a TLF is in being rekeyed.
+type RekeyState int
+
+const (
+	// RekeyStatePending means the TLF is waiting for its rekey to
+	// start.
+	RekeyStatePending RekeyState = iota
+	// RekeyStateInProgress means the TLF is being rekeyed.
+	RekeyStateInProgress
+	// RekeyStateRetrying means the last rekey attempt failed, and
+	// another will be made after a backoff.
+	RekeyStateRetrying
+	// RekeyStateNeedsPaperKey means no device of this user that
+	// can rekey the TLF is online, so the rekey is waiting for a
+	// paper key.
+	RekeyStateNeedsPaperKey
+	// RekeyStateFailed means the rekey failed too many times, and
+	// won't be retried until it's requested again.
+	RekeyStateFailed
+)
+
+func (s RekeyState) String() string {
+	switch s {
+	case RekeyStatePending:
+		return "pending"
+	case RekeyStateInProgress:
+		return "in progress"
+	case RekeyStateRetrying:
+		return "retrying"
+	case RekeyStateNeedsPaperKey:
+		return "needs paper key"
+	case RekeyStateFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("RekeyState(%d)", int(s))
+	}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for
+// RekeyState.
+func (s RekeyState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// RekeyStatus describes a TLF that hasn't been fully rekeyed yet.
+type RekeyStatus struct {
+	TlfID tlf.ID
+	State RekeyState
+	// Attempts is the number of rekey attempts that have failed
+	// in a row.
+	Attempts  int
+	LastError string `json:",omitempty"`
+	// LastAttempt is when the last rekey attempt started, if any.
+	LastAttempt time.Time
+	// NextAttempt is when the next rekey attempt will start, if
+	// one is scheduled.
+	NextAttempt time.Time
+}
+
 // InitMode indicates how KBFS should configure itself at runtime.
 type InitMode int
 
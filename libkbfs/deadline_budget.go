@@ -0,0 +1,136 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// opPhase is a stage of an operation that gets its own share of the
+// operation's deadline, so that a slow stage fails fast, and with an
+// error that says which stage it was, instead of using up the whole
+// deadline.
+type opPhase int
+
+const (
+	// opPhaseMDGet is fetching MDs from the server.
+	opPhaseMDGet opPhase = iota
+	// opPhaseBlockFetch is fetching blocks from the server.
+	opPhaseBlockFetch
+	// opPhaseLimiterDelay is waiting on the disk limiter before
+	// putting a block into a journal.
+	opPhaseLimiterDelay
+	// opPhaseJournalWrite is writing a block or MD to a journal.
+	opPhaseJournalWrite
+)
+
+func (p opPhase) String() string {
+	switch p {
+	case opPhaseMDGet:
+		return "MD get"
+	case opPhaseBlockFetch:
+		return "block fetch"
+	case opPhaseLimiterDelay:
+		return "disk limiter delay"
+	case opPhaseJournalWrite:
+		return "journal write"
+	}
+	return fmt.Sprintf("opPhase(%d)", int(p))
+}
+
+// deadlineBudgetSlack is the time before a deadline that no phase
+// gets to use, so that a phase's error still makes it back to the
+// caller in time.
+const deadlineBudgetSlack = 1 * time.Second
+
+// budgetShare returns the fraction of the time left before the
+// deadline, when the phase starts, that the phase may use. Phases
+// that are usually followed by others leave some time for them.
+func (p opPhase) budgetShare() float64 {
+	switch p {
+	case opPhaseMDGet:
+		// Leave time for the block fetches and writes that
+		// usually follow.
+		return 0.5
+	case opPhaseBlockFetch:
+		return 0.75
+	case opPhaseLimiterDelay:
+		// Leave time for the journal write itself.
+		return 0.5
+	}
+	return 1.0
+}
+
+// budget returns how long the phase may take, if it starts with
+// remaining time left before the deadline.
+func (p opPhase) budget(remaining time.Duration) time.Duration {
+	usable := remaining - deadlineBudgetSlack
+	if usable <= 0 {
+		return 0
+	}
+	return time.Duration(p.budgetShare() * float64(usable))
+}
+
+// withPhaseBudget returns a child of ctx whose deadline is the
+// phase's share of the time ctx has left, along with the length of
+// that share. If ctx has no deadline, it's returned as is, with a
+// budget of 0.
+func withPhaseBudget(ctx context.Context, phase opPhase) (
+	phaseCtx context.Context, cancel context.CancelFunc,
+	budget time.Duration) {
+	// Can't use config.Clock() since context doesn't respect it.
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx, func() {}, 0
+	}
+	budget = phase.budget(deadline.Sub(time.Now()))
+	phaseCtx, cancel = context.WithTimeout(ctx, budget)
+	return phaseCtx, cancel, budget
+}
+
+// phaseTimedOut returns whether phaseCtx, as returned by
+// withPhaseBudget, has run out of time while ctx itself isn't done.
+func phaseTimedOut(ctx, phaseCtx context.Context) bool {
+	return phaseCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+}
+
+// phaseBudgetErr turns err into an OpPhaseTimeoutError if it comes
+// from phaseCtx running out of time.
+func phaseBudgetErr(ctx, phaseCtx context.Context, phase opPhase,
+	budget time.Duration, err error) error {
+	if errors.Cause(err) != context.DeadlineExceeded ||
+		!phaseTimedOut(ctx, phaseCtx) {
+		return err
+	}
+	return errors.WithStack(OpPhaseTimeoutError{phase.String(), budget})
+}
+
+// runWithPhaseBudget calls f with a context limited to the phase's
+// share of ctx's deadline, and attributes any timeout of that
+// context to the phase.
+func runWithPhaseBudget(ctx context.Context, phase opPhase,
+	f func(context.Context) error) error {
+	phaseCtx, cancel, budget := withPhaseBudget(ctx, phase)
+	defer cancel()
+	return phaseBudgetErr(ctx, phaseCtx, phase, budget, f(phaseCtx))
+}
+
+// checkPhaseBudget returns an OpPhaseTimeoutError if ctx's deadline
+// leaves no time for the phase. It's for phases that can't be
+// interrupted once they've started.
+func checkPhaseBudget(ctx context.Context, phase opPhase) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	if phase.budget(deadline.Sub(time.Now())) <= 0 {
+		return errors.WithStack(OpPhaseTimeoutError{phase.String(), 0})
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestOpPhaseBudget(t *testing.T) {
+	// (11s - slack of 1s) * (share=0.5) = 5s.
+	require.Equal(t, 5*time.Second, opPhaseMDGet.budget(11*time.Second))
+	require.Equal(t, 10*time.Second,
+		opPhaseJournalWrite.budget(11*time.Second))
+	require.Equal(t, time.Duration(0),
+		opPhaseBlockFetch.budget(500*time.Millisecond))
+}
+
+func TestWithPhaseBudgetNoDeadline(t *testing.T) {
+	ctx := context.Background()
+	phaseCtx, cancel, budget := withPhaseBudget(ctx, opPhaseMDGet)
+	defer cancel()
+	require.Equal(t, ctx, phaseCtx)
+	require.Equal(t, time.Duration(0), budget)
+	require.NoError(t, checkPhaseBudget(ctx, opPhaseJournalWrite))
+
+	err := runWithPhaseBudget(ctx, opPhaseBlockFetch,
+		func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			require.False(t, ok)
+			return nil
+		})
+	require.NoError(t, err)
+}
+
+func TestRunWithPhaseBudgetTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 2*time.Second)
+	defer cancel()
+
+	t.Log("A phase that outlasts its share fails with its name")
+	err := runWithPhaseBudget(ctx, opPhaseBlockFetch,
+		func(phaseCtx context.Context) error {
+			deadline, ok := phaseCtx.Deadline()
+			require.True(t, ok)
+			ctxDeadline, _ := ctx.Deadline()
+			require.True(t, deadline.Before(ctxDeadline))
+			<-phaseCtx.Done()
+			return phaseCtx.Err()
+		})
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	// Unwrap the stack, but not the cause of the timeout error.
+	timeoutErr, ok := err.(interface {
+		Cause() error
+	}).Cause().(OpPhaseTimeoutError)
+	require.True(t, ok)
+	require.Equal(t, opPhaseBlockFetch.String(), timeoutErr.Phase)
+	require.True(t, timeoutErr.Budget > 0)
+	require.NoError(t, ctx.Err())
+
+	t.Log("Other errors go through as is")
+	fakeErr := errors.New("Fake error")
+	err = runWithPhaseBudget(ctx, opPhaseMDGet,
+		func(context.Context) error {
+			return fakeErr
+		})
+	require.Equal(t, fakeErr, err)
+}
+
+func TestRunWithPhaseBudgetParentCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 5*time.Second)
+	err := runWithPhaseBudget(ctx, opPhaseMDGet,
+		func(phaseCtx context.Context) error {
+			cancel()
+			<-phaseCtx.Done()
+			return phaseCtx.Err()
+		})
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestCheckPhaseBudget(t *testing.T) {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, checkPhaseBudget(ctx, opPhaseJournalWrite))
+
+	// Less than the slack is left.
+	shortCtx, shortCancel := context.WithTimeout(
+		context.Background(), 500*time.Millisecond)
+	defer shortCancel()
+	err := checkPhaseBudget(shortCtx, opPhaseJournalWrite)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	require.Contains(t, err.Error(), opPhaseJournalWrite.String())
+}
@@ -7,6 +7,7 @@ package libkbfs
 import (
 	"testing"
 
+	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/go-codec/codec"
 	"github.com/keybase/kbfs/kbfscodec"
 )
@@ -33,6 +34,11 @@ func makeFakeDirEntry(t *testing.T, typ EntryType, size uint64) DirEntry {
 			"fake sym path",
 			101,
 			102,
+			keybase1.MakeTestUID(1),
+			keybase1.KID("fake kid"),
+			103,
+			"fake link id",
+			0,
 		},
 		codec.UnknownFieldSetHandler{},
 	}
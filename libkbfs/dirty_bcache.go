@@ -23,6 +23,7 @@ type dirtyBlockID struct {
 
 type dirtyReq struct {
 	respChan chan<- struct{}
+	tlfID    tlf.ID
 	bytes    int64
 	start    time.Time
 	deadline time.Time
@@ -30,6 +31,10 @@ type dirtyReq struct {
 
 const (
 	resetBufferCapTimeDefault = 5 * time.Minute
+	// maxWaitingDirtyReqs is the number of requests that can be
+	// queued up behind a blocked request, waiting for a chance to be
+	// granted under their TLF's fair share.
+	maxWaitingDirtyReqs = 100
 )
 
 // DirtyBlockCacheStandard implements the DirtyBlockCache interface by
@@ -109,6 +114,18 @@ const (
 // if resetBufferCapTime passes without any large syncs.  TODO: in the
 // future it might make sense to decrease the buffer capacity, rather
 // than resetting it to the minimum?
+//
+// Since waitBuf is shared by all TLFs, one TLF writing a huge file
+// could otherwise block small writes to every other TLF behind the
+// same backpressure.  To prevent that, waitBuf bytes are also
+// accounted per-TLF, and capacity(syncBuf) is divided evenly among
+// all the TLFs that currently have bytes in waitBuf.  While one
+// request is blocked, requests from other TLFs keep being read; any
+// of them from a TLF that is still under its fair share is granted
+// right away, without backpressure.  This lets waitBuf exceed its
+// usual limits by at most capacity(syncBuf).  When the blocked
+// request is finally granted, the next one considered is from the
+// TLF with the fewest waitBuf bytes.
 type DirtyBlockCacheStandard struct {
 	clock Clock
 	log   logger.Logger
@@ -147,10 +164,16 @@ type DirtyBlockCacheStandard struct {
 	cache           map[dirtyBlockID]Block
 	syncBufBytes    int64
 	waitBufBytes    int64
+	tlfWaitBufBytes map[tlf.ID]int64
 	syncBufferCap   int64
 	ignoreSyncBytes int64 // these bytes have "timed out"
 	syncStarted     time.Time
 	resetter        *time.Timer
+
+	// waitingReqs holds any requests that were still queued when
+	// processPermission exited.  Only accessed by processPermission
+	// and, after it has exited, by Shutdown.
+	waitingReqs []dirtyReq
 }
 
 // NewDirtyBlockCacheStandard constructs a new BlockCacheStandard
@@ -167,6 +190,7 @@ func NewDirtyBlockCacheStandard(clock Clock,
 		bytesDecreasedChan: make(chan struct{}, 1),
 		shutdownChan:       make(chan struct{}),
 		cache:              make(map[dirtyBlockID]Block),
+		tlfWaitBufBytes:    make(map[tlf.ID]int64),
 		minSyncBufCap:      minSyncBufCap,
 		maxSyncBufCap:      maxSyncBufCap,
 		syncBufferCap:      startSyncBufCap,
@@ -181,7 +205,8 @@ func NewDirtyBlockCacheStandard(clock Clock,
 // put/get/delete requests; it cannot track dirty bytes.
 func simpleDirtyBlockCacheStandard() *DirtyBlockCacheStandard {
 	return &DirtyBlockCacheStandard{
-		cache: make(map[dirtyBlockID]Block),
+		cache:           make(map[dirtyBlockID]Block),
+		tlfWaitBufBytes: make(map[tlf.ID]int64),
 	}
 }
 
@@ -306,7 +331,35 @@ func (d *DirtyBlockCacheStandard) calcBackpressure(start time.Time,
 	return totalBackpressure - timeSpentSoFar
 }
 
-func (d *DirtyBlockCacheStandard) acceptNewWrite(newBytes int64) bool {
+// updateTlfWaitBufBytesLocked adjusts the waitBuf bytes attributed to
+// the given TLF.  d.lock must be held for writing.
+func (d *DirtyBlockCacheStandard) updateTlfWaitBufBytesLocked(
+	tlfID tlf.ID, delta int64) {
+	newBytes := d.tlfWaitBufBytes[tlfID] + delta
+	if newBytes == 0 {
+		delete(d.tlfWaitBufBytes, tlfID)
+	} else {
+		d.tlfWaitBufBytes[tlfID] = newBytes
+	}
+}
+
+// fairShareLocked returns the number of waitBuf bytes that the given
+// TLF is entitled to, given how many other TLFs are currently using
+// waitBuf.  d.lock must be held.
+func (d *DirtyBlockCacheStandard) fairShareLocked(tlfID tlf.ID) int64 {
+	numActive := int64(0)
+	for id, bytes := range d.tlfWaitBufBytes {
+		if bytes > 0 && id != tlfID {
+			numActive++
+		}
+	}
+	// Count the requesting TLF too.
+	numActive++
+	return d.syncBufferCap / numActive
+}
+
+func (d *DirtyBlockCacheStandard) acceptNewWrite(
+	tlfID tlf.ID, newBytes int64) bool {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 	// Accept any write, as long as we're not already over the limits.
@@ -316,11 +369,64 @@ func (d *DirtyBlockCacheStandard) acceptNewWrite(newBytes int64) bool {
 	canAccept := d.waitBufBytes < d.maxSyncBufCap*2
 	if canAccept {
 		d.waitBufBytes += newBytes
+		d.updateTlfWaitBufBytesLocked(tlfID, newBytes)
 	}
 
 	return canAccept
 }
 
+// grantFairShareRequests grants permission to any of the given
+// waiting requests whose TLF is still under its fair share of
+// waitBuf, ignoring any backpressure.  Requests for `blockedTlf`,
+// which already has a request blocked, are never granted here, so
+// that requests within a single TLF stay in order.  It returns the
+// requests that are still waiting.
+func (d *DirtyBlockCacheStandard) grantFairShareRequests(
+	waiting []dirtyReq, blockedTlf tlf.ID) []dirtyReq {
+	if len(waiting) == 0 {
+		return waiting
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var stillWaiting []dirtyReq
+	skippedTlfs := make(map[tlf.ID]bool)
+	for _, req := range waiting {
+		if req.tlfID == blockedTlf || skippedTlfs[req.tlfID] ||
+			d.tlfWaitBufBytes[req.tlfID] >= d.fairShareLocked(req.tlfID) {
+			skippedTlfs[req.tlfID] = true
+			stillWaiting = append(stillWaiting, req)
+			continue
+		}
+		d.log.CDebugf(context.TODO(), "Granting %d bytes to TLF %s "+
+			"under its fair share", req.bytes, req.tlfID)
+		d.waitBufBytes += req.bytes
+		d.updateTlfWaitBufBytesLocked(req.tlfID, req.bytes)
+		close(req.respChan)
+	}
+	return stillWaiting
+}
+
+// nextWaitingRequest removes and returns the first waiting request
+// from the TLF currently using the fewest waitBuf bytes, along with
+// the remaining waiting requests.
+func (d *DirtyBlockCacheStandard) nextWaitingRequest(
+	waiting []dirtyReq) (dirtyReq, []dirtyReq) {
+	if len(waiting) == 0 {
+		return dirtyReq{}, waiting
+	}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	next := 0
+	for i, req := range waiting {
+		if d.tlfWaitBufBytes[req.tlfID] <
+			d.tlfWaitBufBytes[waiting[next].tlfID] {
+			next = i
+		}
+	}
+	req := waiting[next]
+	return req, append(waiting[:next], waiting[next+1:]...)
+}
+
 func (d *DirtyBlockCacheStandard) maybeDecreaseBuffer(start time.Time,
 	deadline time.Time, soFar float64) (bool, time.Duration, float64) {
 	// Update syncBufferCap if the write has been blocked for more
@@ -385,12 +491,21 @@ func (d *DirtyBlockCacheStandard) processPermission() {
 	decreased := false
 	var fracDeadlineSoFar float64
 	var lastKnownTimeout time.Duration
+	// Requests that arrived while currentReq was blocked.
+	var waiting []dirtyReq
+	defer func() {
+		d.waitingReqs = waiting
+	}()
 	for {
 		reqChan := d.requestsChan
 		if currentReq.respChan != nil {
-			// We are already waiting on a request, so don't bother
-			// trying to read another request from the requests chan.
-			reqChan = nil
+			// We are already waiting on a request.  Keep reading new
+			// requests, so that other TLFs can be granted their fair
+			// share in the meantime, unless too many are already
+			// waiting.
+			if len(waiting) >= maxWaitingDirtyReqs {
+				reqChan = nil
+			}
 
 			// If we haven't decreased the buffer size yet, make sure
 			// we wake up in time to do that.
@@ -403,9 +518,11 @@ func (d *DirtyBlockCacheStandard) processPermission() {
 			backpressure = maxWakeup
 		}
 
-		var bpTimer <-chan time.Time
+		var bpTimer Timer
+		var bpTimerCh <-chan time.Time
 		if backpressure > 0 {
-			bpTimer = time.After(backpressure)
+			bpTimer = d.clock.NewTimer(backpressure)
+			bpTimerCh = bpTimer.C()
 		}
 
 		newReq := false
@@ -413,11 +530,18 @@ func (d *DirtyBlockCacheStandard) processPermission() {
 		case <-d.shutdownChan:
 			return
 		case <-d.bytesDecreasedChan:
-		case <-bpTimer:
+		case <-bpTimerCh:
 		case r := <-reqChan:
-			currentReq = r
-			newReq = true
-			decreased = false
+			if currentReq.respChan != nil {
+				waiting = append(waiting, r)
+			} else {
+				currentReq = r
+				newReq = true
+				decreased = false
+			}
+		}
+		if bpTimer != nil {
+			bpTimer.Stop()
 		}
 
 		if currentReq.respChan != nil || maxWakeup > 0 {
@@ -434,20 +558,25 @@ func (d *DirtyBlockCacheStandard) processPermission() {
 			}
 		}
 
-		if currentReq.respChan != nil {
+		for currentReq.respChan != nil {
 			lastKnownTimeout = currentReq.deadline.Sub(currentReq.start)
 			// Apply any backpressure?
 			backpressure = d.calcBackpressure(currentReq.start,
 				currentReq.deadline)
-			if backpressure == 0 && d.acceptNewWrite(currentReq.bytes) {
+			if backpressure == 0 &&
+				d.acceptNewWrite(currentReq.tlfID, currentReq.bytes) {
 				// If we have an active request, and we have room in
 				// our buffers to deal with it, grant permission to
 				// the requestor by closing the response channel.
 				close(currentReq.respChan)
-				currentReq = dirtyReq{}
 				if d.blockedChanForTesting != nil {
 					d.blockedChanForTesting <- -1
 				}
+				// Move on to the next waiting request, if any.
+				currentReq, waiting = d.nextWaitingRequest(waiting)
+				newReq = currentReq.respChan != nil
+				decreased = false
+				continue
 			} else if d.blockedChanForTesting != nil && newReq {
 				// Otherwise, if this is the first time we've
 				// considered this request, inform any tests that the
@@ -471,6 +600,13 @@ func (d *DirtyBlockCacheStandard) processPermission() {
 					d.log.CDebugf(context.TODO(), "Applying backpressure %s", backpressure)
 				}()
 			}
+			break
+		}
+
+		if currentReq.respChan != nil {
+			// Don't let the blocked request hold up other TLFs that
+			// haven't used up their share of the buffer.
+			waiting = d.grantFairShareRequests(waiting, currentReq.tlfID)
 		}
 	}
 }
@@ -478,7 +614,7 @@ func (d *DirtyBlockCacheStandard) processPermission() {
 // RequestPermissionToDirty implements the DirtyBlockCache interface
 // for DirtyBlockCacheStandard.
 func (d *DirtyBlockCacheStandard) RequestPermissionToDirty(
-	ctx context.Context, _ tlf.ID, estimatedDirtyBytes int64) (
+	ctx context.Context, tlfID tlf.ID, estimatedDirtyBytes int64) (
 	DirtyPermChan, error) {
 	d.shutdownLock.RLock()
 	defer d.shutdownLock.RUnlock()
@@ -498,7 +634,7 @@ func (d *DirtyBlockCacheStandard) RequestPermissionToDirty(
 		// never get close to a timeout in a background task.
 		deadline = defaultDeadline
 	}
-	req := dirtyReq{c, estimatedDirtyBytes, now, deadline}
+	req := dirtyReq{c, tlfID, estimatedDirtyBytes, now, deadline}
 	select {
 	case d.requestsChan <- req:
 		return c, nil
@@ -517,7 +653,7 @@ func (d *DirtyBlockCacheStandard) signalDecreasedBytes() {
 
 // UpdateUnsyncedBytes implements the DirtyBlockCache interface for
 // DirtyBlockCacheStandard.
-func (d *DirtyBlockCacheStandard) UpdateUnsyncedBytes(_ tlf.ID,
+func (d *DirtyBlockCacheStandard) UpdateUnsyncedBytes(tlfID tlf.ID,
 	newUnsyncedBytes int64, wasSyncing bool) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
@@ -525,6 +661,7 @@ func (d *DirtyBlockCacheStandard) UpdateUnsyncedBytes(_ tlf.ID,
 		d.syncBufBytes += newUnsyncedBytes
 	} else {
 		d.waitBufBytes += newUnsyncedBytes
+		d.updateTlfWaitBufBytesLocked(tlfID, newUnsyncedBytes)
 	}
 	if newUnsyncedBytes < 0 {
 		d.signalDecreasedBytes()
@@ -533,17 +670,18 @@ func (d *DirtyBlockCacheStandard) UpdateUnsyncedBytes(_ tlf.ID,
 
 // UpdateSyncingBytes implements the DirtyBlockCache interface for
 // DirtyBlockCacheStandard.
-func (d *DirtyBlockCacheStandard) UpdateSyncingBytes(_ tlf.ID, size int64) {
+func (d *DirtyBlockCacheStandard) UpdateSyncingBytes(tlfID tlf.ID, size int64) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 	d.syncBufBytes += size
 	d.waitBufBytes -= size
+	d.updateTlfWaitBufBytesLocked(tlfID, -size)
 	d.signalDecreasedBytes()
 }
 
 // BlockSyncFinished implements the DirtyBlockCache interface for
 // DirtyBlockCacheStandard.
-func (d *DirtyBlockCacheStandard) BlockSyncFinished(_ tlf.ID, size int64) {
+func (d *DirtyBlockCacheStandard) BlockSyncFinished(tlfID tlf.ID, size int64) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 	if size > 0 {
@@ -551,6 +689,7 @@ func (d *DirtyBlockCacheStandard) BlockSyncFinished(_ tlf.ID, size int64) {
 	} else {
 		// The block will be retried, so put it back on the waitBuf
 		d.waitBufBytes -= size
+		d.updateTlfWaitBufBytesLocked(tlfID, -size)
 	}
 	if size > 0 {
 		d.signalDecreasedBytes()
@@ -640,6 +779,9 @@ func (d *DirtyBlockCacheStandard) Shutdown() error {
 	for req := range d.requestsChan {
 		d.waitBufBytes += req.bytes
 	}
+	for _, req := range d.waitingReqs {
+		d.waitBufBytes += req.bytes
+	}
 	if d.syncBufBytes != 0 || d.waitBufBytes != 0 || d.ignoreSyncBytes != 0 {
 		return fmt.Errorf("Unexpected dirty bytes leftover on shutdown: "+
 			"syncBuf=%d, waitBuf=%d, ignore=%d",
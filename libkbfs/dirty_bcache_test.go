@@ -199,6 +199,77 @@ func TestDirtyBcacheRequestPermission(t *testing.T) {
 	dirtyBcache.SyncFinished(id, 4*bufSize+2)
 }
 
+func TestDirtyBcacheRequestPermissionFairness(t *testing.T) {
+	bufSize := int64(4)
+	dirtyBcache := NewDirtyBlockCacheStandard(&wallClock{}, logger.NewTestLogger(t),
+		bufSize, bufSize*2, bufSize)
+	defer dirtyBcache.Shutdown()
+	blockedChan := make(chan int64, 1)
+	dirtyBcache.blockedChanForTesting = blockedChan
+	ctx := context.Background()
+
+	// Fill up the buffer with a write from the first TLF.
+	id1 := tlf.FakeID(1, false)
+	c1, err := dirtyBcache.RequestPermissionToDirty(ctx, id1, bufSize*2+1)
+	if err != nil {
+		t.Fatalf("Request permission error: %v", err)
+	}
+	<-c1
+	if blockedSize := <-blockedChan; blockedSize != -1 {
+		t.Fatalf("Wrong blocked size: %d", blockedSize)
+	}
+
+	// The next request from that TLF should block.
+	c2, err := dirtyBcache.RequestPermissionToDirty(ctx, id1, bufSize)
+	if err != nil {
+		t.Fatalf("Request permission error: %v", err)
+	}
+	if blockedSize := <-blockedChan; blockedSize != bufSize {
+		t.Fatalf("Wrong blocked size: %d", blockedSize)
+	}
+
+	// But a small write from a second TLF should get through, since
+	// it is under its half of the buffer.
+	id2 := tlf.FakeID(2, false)
+	c3, err := dirtyBcache.RequestPermissionToDirty(ctx, id2, bufSize/2)
+	if err != nil {
+		t.Fatalf("Request permission error: %v", err)
+	}
+	select {
+	case <-c3:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Request from second TLF was not granted")
+	}
+	select {
+	case <-c2:
+		t.Fatalf("Request should be blocked")
+	default:
+	}
+
+	// Now the second TLF has used up its share, so it has to wait
+	// its turn.
+	c4, err := dirtyBcache.RequestPermissionToDirty(ctx, id2, bufSize)
+	if err != nil {
+		t.Fatalf("Request permission error: %v", err)
+	}
+	select {
+	case <-c4:
+		t.Fatalf("Request should be blocked")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Once the first TLF's bytes start syncing, its blocked request
+	// goes through, and the second TLF's request is considered next.
+	dirtyBcache.UpdateSyncingBytes(id1, 2*bufSize+1)
+	if blockedSize := <-blockedChan; blockedSize != -1 {
+		t.Fatalf("Wrong blocked size: %d", blockedSize)
+	}
+	<-c2
+	if blockedSize := <-blockedChan; blockedSize != bufSize {
+		t.Fatalf("Wrong blocked size: %d", blockedSize)
+	}
+}
+
 func TestDirtyBcacheCalcBackpressure(t *testing.T) {
 	bufSize := int64(10)
 	clock, now := newTestClockAndTimeNow()
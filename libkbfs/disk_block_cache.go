@@ -29,12 +29,13 @@ import (
 const (
 	// 10 GB maximum storage by default
 	defaultDiskBlockCacheMaxBytes uint64 = 10 * (1 << 30)
-	evictionConsiderationFactor   int    = 3
+	evictionConsiderationFactor   int    = 10
 	defaultNumBlocksToEvict       int    = 10
+	bulkNumBlocksToEvict          int    = 100
 	maxEvictionsPerPut            int    = 4
-	blockDbFilename               string = "diskCacheBlocks.leveldb"
-	metaDbFilename                string = "diskCacheMetadata.leveldb"
-	tlfDbFilename                 string = "diskCacheTLF.leveldb"
+	blockDbName                   string = "diskCacheBlocks"
+	metaDbName                    string = "diskCacheMetadata"
+	tlfDbName                     string = "diskCacheTLF"
 	versionFilename               string = "version"
 	initialDiskCacheVersion       uint64 = 1
 	currentDiskCacheVersion       uint64 = initialDiskCacheVersion
@@ -48,9 +49,16 @@ type diskBlockCacheConfig interface {
 	logMaker
 	clockGetter
 	diskLimiterGetter
+	diskCacheBackendGetter
 }
 
 // DiskBlockCacheStandard is the standard implementation for DiskBlockCache.
+//
+// Block contents are stored exactly as they were encrypted for the
+// bserver, under the TLF's block keys, and there is no separate local
+// key protecting the cache.  So a change of the device key doesn't
+// invalidate anything in the cache, and there's nothing to re-encrypt
+// when it happens.
 type DiskBlockCacheStandard struct {
 	config     diskBlockCacheConfig
 	log        logger.Logger
@@ -64,9 +72,21 @@ type DiskBlockCacheStandard struct {
 	// This protects the disk caches from being shutdown while they're being
 	// accessed.
 	lock    sync.RWMutex
-	blockDb *leveldb.DB
-	metaDb  *leveldb.DB
-	tlfDb   *leveldb.DB
+	blockDb diskCacheDb
+	metaDb  diskCacheDb
+	tlfDb   diskCacheDb
+	// dirPath is the directory holding the cache, if it's stored on
+	// disk; this process's ownership of it is recorded there.
+	dirPath string
+	// fileStorages must be closed on shutdown to release their
+	// locks, since closing a leveldb.DB doesn't close its storage.
+	// Other backends release their locks when closed.
+	fileStorages []storage.Storage
+	// limitType is the disk limiter partition this cache's blocks
+	// are accounted to.
+	limitType diskLimitTrackerType
+	// evictions records what this cache evicts.
+	evictions *diskBlockCacheEvictionLog
 }
 
 var _ DiskBlockCache = (*DiskBlockCacheStandard)(nil)
@@ -96,14 +116,13 @@ func diskBlockCacheRootFromStorageRoot(storageRoot string) string {
 func newDiskBlockCacheStandardFromStorage(config diskBlockCacheConfig,
 	blockStorage, metadataStorage, tlfStorage storage.Storage) (
 	cache *DiskBlockCacheStandard, err error) {
-	log := config.MakeLogger("KBC")
 	blockDb, err := openLevelDB(blockStorage)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		if err != nil {
-			cache.blockDb.Close()
+			blockDb.Close()
 		}
 	}()
 
@@ -126,20 +145,30 @@ func newDiskBlockCacheStandardFromStorage(config diskBlockCacheConfig,
 			tlfDb.Close()
 		}
 	}()
+	return newDiskBlockCacheStandardFromDbs(config, levelDbDiskCacheDb{blockDb},
+		levelDbDiskCacheDb{metaDb}, levelDbDiskCacheDb{tlfDb})
+}
+
+// newDiskBlockCacheStandardFromDbs creates a new *DiskBlockCacheStandard
+// with the passed-in databases, which it takes ownership of if it
+// succeeds.
+func newDiskBlockCacheStandardFromDbs(config diskBlockCacheConfig,
+	blockDb, metaDb, tlfDb diskCacheDb) (*DiskBlockCacheStandard, error) {
 	maxBlockID, err := kbfshash.HashFromRaw(kbfshash.DefaultHashType,
 		kbfshash.MaxDefaultHash[:])
 	if err != nil {
 		return nil, err
 	}
-	cache = &DiskBlockCacheStandard{
+	cache := &DiskBlockCacheStandard{
 		config:     config,
 		maxBlockID: maxBlockID.Bytes(),
 		tlfCounts:  map[tlf.ID]int{},
 		tlfSizes:   map[tlf.ID]uint64{},
-		log:        log,
+		log:        config.MakeLogger("KBC"),
 		blockDb:    blockDb,
 		metaDb:     metaDb,
 		tlfDb:      tlfDb,
+		evictions:  newDiskBlockCacheEvictionLog(),
 	}
 	err = cache.syncBlockCountsFromDb()
 	if err != nil {
@@ -197,16 +226,27 @@ func getVersionedPathForDiskCache(dirPath string) (versionedDirPath string,
 	return versionPathFromVersion(dirPath, version), nil
 }
 
-// newDiskBlockCacheStandard creates a new *DiskBlockCacheStandard with a
-// specified directory on the filesystem as storage.
-func newDiskBlockCacheStandard(config diskBlockCacheConfig, dirPath string) (
-	cache *DiskBlockCacheStandard, err error) {
-	versionPath, err := getVersionedPathForDiskCache(dirPath)
-	if err != nil {
-		return nil, err
+// openDiskCacheStorage opens the leveldb storage at the given path,
+// translating lock contention with another process into a
+// DiskCacheLockedError.
+func openDiskCacheStorage(dirPath, dbPath string) (storage.Storage, error) {
+	stor, err := storage.OpenFile(dbPath, false)
+	if isDiskCacheLockedErr(err) {
+		return nil, errors.WithStack(DiskCacheLockedError{
+			dirPath, readDiskCacheOwner(dirPath)})
 	}
-	blockDbPath := filepath.Join(versionPath, blockDbFilename)
-	blockStorage, err := storage.OpenFile(blockDbPath, false)
+	return stor, err
+}
+
+// newLevelDBDiskBlockCache opens a *DiskBlockCacheStandard stored in
+// leveldb databases under versionPath.
+func newLevelDBDiskBlockCache(config diskBlockCacheConfig,
+	dirPath, versionPath string) (cache *DiskBlockCacheStandard, err error) {
+	dbPath := func(name string) string {
+		return filepath.Join(
+			versionPath, DiskCacheBackendLevelDB.dbFilename(name))
+	}
+	blockStorage, err := openDiskCacheStorage(dirPath, dbPath(blockDbName))
 	if err != nil {
 		return nil, err
 	}
@@ -215,8 +255,7 @@ func newDiskBlockCacheStandard(config diskBlockCacheConfig, dirPath string) (
 			blockStorage.Close()
 		}
 	}()
-	metaDbPath := filepath.Join(versionPath, metaDbFilename)
-	metadataStorage, err := storage.OpenFile(metaDbPath, false)
+	metadataStorage, err := openDiskCacheStorage(dirPath, dbPath(metaDbName))
 	if err != nil {
 		return nil, err
 	}
@@ -225,8 +264,7 @@ func newDiskBlockCacheStandard(config diskBlockCacheConfig, dirPath string) (
 			metadataStorage.Close()
 		}
 	}()
-	tlfDbPath := filepath.Join(versionPath, tlfDbFilename)
-	tlfStorage, err := storage.OpenFile(tlfDbPath, false)
+	tlfStorage, err := openDiskCacheStorage(dirPath, dbPath(tlfDbName))
 	if err != nil {
 		return nil, err
 	}
@@ -235,8 +273,115 @@ func newDiskBlockCacheStandard(config diskBlockCacheConfig, dirPath string) (
 			tlfStorage.Close()
 		}
 	}()
-	return newDiskBlockCacheStandardFromStorage(config, blockStorage,
+	cache, err = newDiskBlockCacheStandardFromStorage(config, blockStorage,
 		metadataStorage, tlfStorage)
+	if err != nil {
+		return nil, err
+	}
+	cache.fileStorages = []storage.Storage{
+		blockStorage, metadataStorage, tlfStorage}
+	return cache, nil
+}
+
+// newBadgerDiskBlockCache opens a *DiskBlockCacheStandard stored in
+// badger databases under versionPath.
+func newBadgerDiskBlockCache(config diskBlockCacheConfig,
+	dirPath, versionPath string) (cache *DiskBlockCacheStandard, err error) {
+	dbPath := func(name string) string {
+		return filepath.Join(
+			versionPath, DiskCacheBackendBadger.dbFilename(name))
+	}
+	blockDb, err := openBadgerDiskCacheDb(dirPath, dbPath(blockDbName))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			blockDb.Close()
+		}
+	}()
+	metaDb, err := openBadgerDiskCacheDb(dirPath, dbPath(metaDbName))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			metaDb.Close()
+		}
+	}()
+	tlfDb, err := openBadgerDiskCacheDb(dirPath, dbPath(tlfDbName))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tlfDb.Close()
+		}
+	}()
+	return newDiskBlockCacheStandardFromDbs(config, blockDb, metaDb, tlfDb)
+}
+
+// removeOtherDiskCacheBackends removes any databases under versionPath
+// that were left by a backend other than the one in use, since a
+// change of backend starts the cache over.
+func removeOtherDiskCacheBackends(versionPath string,
+	backend DiskCacheBackend) error {
+	for _, other := range []DiskCacheBackend{
+		DiskCacheBackendLevelDB, DiskCacheBackendBadger} {
+		if other == backend {
+			continue
+		}
+		for _, name := range []string{blockDbName, metaDbName, tlfDbName} {
+			err := ioutil.RemoveAll(
+				filepath.Join(versionPath, other.dbFilename(name)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newDiskBlockCacheStandard creates a new *DiskBlockCacheStandard with a
+// specified directory on the filesystem as storage, in the databases
+// of config.DiskCacheBackend().  Only one process
+// at a time may have a given directory open; any other process gets
+// a DiskCacheLockedError naming the current owner, and should carry
+// on without a disk cache.
+func newDiskBlockCacheStandard(config diskBlockCacheConfig, dirPath string) (
+	cache *DiskBlockCacheStandard, err error) {
+	versionPath, err := getVersionedPathForDiskCache(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	backend := config.DiskCacheBackend()
+	switch backend {
+	case DiskCacheBackendLevelDB:
+		cache, err = newLevelDBDiskBlockCache(config, dirPath, versionPath)
+	case DiskCacheBackendBadger:
+		cache, err = newBadgerDiskBlockCache(config, dirPath, versionPath)
+	default:
+		return nil, errors.Errorf("Unknown disk cache backend %s", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Now that we hold all the locks, record ourselves as the owner.
+	cache.dirPath = dirPath
+	err = writeDiskCacheOwner(
+		dirPath, makeDiskCacheOwner(config.Clock().Now()))
+	if err != nil {
+		cache.log.CWarningf(context.TODO(),
+			"Couldn't record disk cache ownership: %+v", err)
+	}
+	err = removeOtherDiskCacheBackends(versionPath, backend)
+	if err != nil {
+		cache.log.CWarningf(context.TODO(),
+			"Couldn't remove the databases of other disk cache "+
+				"backends: %+v", err)
+	}
+	return cache, nil
 }
 
 func (cache *DiskBlockCacheStandard) syncBlockCountsFromDb() error {
@@ -288,12 +433,7 @@ func (*DiskBlockCacheStandard) tlfKey(tlfID tlf.ID, blockKey []byte) []byte {
 // the current time.
 func (cache *DiskBlockCacheStandard) updateMetadataLocked(ctx context.Context,
 	tlfID tlf.ID, blockKey []byte, encodeLen int) error {
-	metadata := diskBlockCacheMetadata{
-		TlfID:     tlfID,
-		LRUTime:   cache.config.Clock().Now(),
-		BlockSize: uint32(encodeLen),
-	}
-	encodedMetadata, err := cache.config.Codec().Encode(&metadata)
+	encodedMetadata, err := cache.encodeMetadata(tlfID, encodeLen)
 	if err != nil {
 		return err
 	}
@@ -304,6 +444,18 @@ func (cache *DiskBlockCacheStandard) updateMetadataLocked(ctx context.Context,
 	return nil
 }
 
+// encodeMetadata encodes the metadata of a block of the given TLF
+// and encoded size, used as of now.
+func (cache *DiskBlockCacheStandard) encodeMetadata(
+	tlfID tlf.ID, encodeLen int) ([]byte, error) {
+	metadata := diskBlockCacheMetadata{
+		TlfID:     tlfID,
+		LRUTime:   cache.config.Clock().Now(),
+		BlockSize: uint32(encodeLen),
+	}
+	return cache.config.Codec().Encode(&metadata)
+}
+
 // getMetadata retrieves the metadata for a block in the cache, or returns
 // leveldb.ErrNotFound and a zero-valued metadata otherwise.
 func (cache *DiskBlockCacheStandard) getMetadata(blockID kbfsblock.ID) (
@@ -328,10 +480,11 @@ func (cache *DiskBlockCacheStandard) getLRU(blockID kbfsblock.ID) (
 }
 
 // decodeBlockCacheEntry decodes a disk block cache entry buffer into an
-// encoded block and server half.
-func (cache *DiskBlockCacheStandard) decodeBlockCacheEntry(buf []byte) ([]byte,
-	kbfscrypto.BlockCryptKeyServerHalf, error) {
-	entry := diskBlockCacheEntry{}
+// encoded block and server half.  The encoded block goes into into's
+// memory if it has room.
+func (cache *DiskBlockCacheStandard) decodeBlockCacheEntry(buf, into []byte) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	entry := diskBlockCacheEntry{Buf: into}
 	err := cache.config.Codec().Decode(buf, &entry)
 	if err != nil {
 		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
@@ -374,7 +527,43 @@ func (cache *DiskBlockCacheStandard) Get(ctx context.Context, tlfID tlf.ID,
 	if err != nil {
 		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
 	}
-	return cache.decodeBlockCacheEntry(entry)
+	return cache.decodeBlockCacheEntry(entry, nil)
+}
+
+// reserveSpaceLocked asks the disk limiter for room for newBytes more
+// bytes in the cache, evicting blocks until there is room.  It
+// returns false if there still isn't room after maxEvictionsPerPut
+// rounds of eviction.  Once it returns true, the caller must call
+// afterDiskBlockCachePut on the limiter with the same number of
+// bytes.
+func (cache *DiskBlockCacheStandard) reserveSpaceLocked(
+	ctx context.Context, newBytes int64) (bool, error) {
+	for i := 0; i < maxEvictionsPerPut; i++ {
+		select {
+		// Ensure we don't loop infinitely
+		case <-ctx.Done():
+			return false, ctx.Err()
+		default:
+		}
+		bytesAvailable, err := cache.config.DiskLimiter().beforeDiskBlockCachePut(ctx,
+			cache.limitType, newBytes)
+		if err != nil {
+			cache.log.CWarningf(ctx, "Error obtaining space for the disk"+
+				" block cache: %+v", err)
+			return false, err
+		}
+		if bytesAvailable >= 0 {
+			return true, nil
+		}
+		numRemoved, _, err := cache.evictLocked(ctx, defaultNumBlocksToEvict)
+		if err != nil {
+			return false, err
+		}
+		if numRemoved == 0 {
+			return false, errors.New("couldn't evict any more blocks from the disk cache")
+		}
+	}
+	return false, nil
 }
 
 // Put implements the DiskBlockCache interface for DiskBlockCacheStandard.
@@ -401,41 +590,21 @@ func (cache *DiskBlockCacheStandard) Put(ctx context.Context, tlfID tlf.ID,
 		return err
 	}
 	if !hasKey {
-		i := 0
-		for ; i < maxEvictionsPerPut; i++ {
-			select {
-			// Ensure we don't loop infinitely
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
-			bytesAvailable, err := cache.config.DiskLimiter().beforeDiskBlockCachePut(ctx,
-				encodedLen)
-			if err != nil {
-				cache.log.CWarningf(ctx, "Error obtaining space for the disk"+
-					" block cache: %+v", err)
-				return err
-			}
-			if bytesAvailable >= 0 {
-				break
-			}
-			numRemoved, _, err := cache.evictLocked(ctx, defaultNumBlocksToEvict)
-			if err != nil {
-				return err
-			}
-			if numRemoved == 0 {
-				return errors.New("couldn't evict any more blocks from the disk cache")
-			}
+		ok, err := cache.reserveSpaceLocked(ctx, encodedLen)
+		if err != nil {
+			return err
 		}
-		if i == maxEvictionsPerPut {
+		if !ok {
 			return cachePutCacheFullError{blockID}
 		}
 		err = cache.blockDb.Put(blockKey, entry, nil)
 		if err != nil {
-			cache.config.DiskLimiter().afterDiskBlockCachePut(ctx, encodedLen, false)
+			cache.config.DiskLimiter().afterDiskBlockCachePut(
+				ctx, cache.limitType, encodedLen, false)
 			return err
 		}
-		cache.config.DiskLimiter().afterDiskBlockCachePut(ctx, encodedLen, true)
+		cache.config.DiskLimiter().afterDiskBlockCachePut(
+			ctx, cache.limitType, encodedLen, true)
 		cache.tlfCounts[tlfID]++
 		cache.numBlocks++
 		encodedLenUint := uint64(encodedLen)
@@ -456,6 +625,168 @@ func (cache *DiskBlockCacheStandard) Put(ctx context.Context, tlfID tlf.ID,
 	return cache.updateMetadataLocked(ctx, tlfID, blockKey, int(encodedLen))
 }
 
+// GetBatch implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.  The blocks are all read from one snapshot
+// of the cache, and their LRU times are updated in one write.
+func (cache *DiskBlockCacheStandard) GetBatch(ctx context.Context,
+	tlfID tlf.ID, blockIDs []kbfsblock.ID) (
+	blocks map[kbfsblock.ID]DiskBlockCacheBlock, err error) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	if cache.blockDb == nil {
+		return nil, errors.WithStack(DiskCacheClosedError{"GetBatch"})
+	}
+	defer func() {
+		cache.log.CDebugf(ctx, "Cache GetBatch tlf=%s numBlocks=%d "+
+			"numFound=%d err=%+v", tlfID, len(blockIDs), len(blocks), err)
+	}()
+	snapshot, err := cache.blockDb.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+
+	blocks = make(map[kbfsblock.ID]DiskBlockCacheBlock, len(blockIDs))
+	metadataBatch := new(leveldb.Batch)
+	for _, blockID := range blockIDs {
+		blockKey := blockID.Bytes()
+		entry, err := snapshot.Get(blockKey, nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		buf, serverHalf, err := cache.decodeBlockCacheEntry(
+			entry, getBlockBuffer(len(entry)))
+		if err != nil {
+			return nil, err
+		}
+		encodedMetadata, err := cache.encodeMetadata(tlfID, len(entry))
+		if err != nil {
+			return nil, err
+		}
+		metadataBatch.Put(blockKey, encodedMetadata)
+		blocks[blockID] = DiskBlockCacheBlock{buf, serverHalf}
+	}
+	err = cache.metaDb.Write(metadataBatch, nil)
+	if err != nil {
+		cache.log.CWarningf(ctx, "Error writing to LRU cache database: %+v", err)
+	}
+	return blocks, nil
+}
+
+// PutBatch implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.  Space for all the new blocks is reserved
+// from the disk limiter at once, and each of the cache's databases is
+// written once.
+func (cache *DiskBlockCacheStandard) PutBatch(ctx context.Context,
+	tlfID tlf.ID, blocks map[kbfsblock.ID]DiskBlockCacheBlock) (err error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.blockDb == nil {
+		return errors.WithStack(DiskCacheClosedError{"PutBatch"})
+	}
+	var newBytes int64
+	defer func() {
+		cache.log.CDebugf(ctx, "Cache PutBatch tlf=%s numBlocks=%d "+
+			"newBytes=%d err=%+v", tlfID, len(blocks), newBytes, err)
+	}()
+
+	blockBatch := new(leveldb.Batch)
+	metadataBatch := new(leveldb.Batch)
+	tlfBatch := new(leveldb.Batch)
+	numNew := 0
+	var newBlockID kbfsblock.ID
+	for blockID, block := range blocks {
+		entry, err := cache.encodeBlockCacheEntry(block.Buf, block.ServerHalf)
+		if err != nil {
+			return err
+		}
+		blockKey := blockID.Bytes()
+		hasKey, err := cache.blockDb.Has(blockKey, nil)
+		if err != nil {
+			return err
+		}
+		if !hasKey {
+			blockBatch.Put(blockKey, entry)
+			newBytes += int64(len(entry))
+			numNew++
+			newBlockID = blockID
+		}
+		encodedMetadata, err := cache.encodeMetadata(tlfID, len(entry))
+		if err != nil {
+			return err
+		}
+		metadataBatch.Put(blockKey, encodedMetadata)
+		tlfBatch.Put(cache.tlfKey(tlfID, blockKey), []byte{})
+	}
+
+	if numNew > 0 {
+		ok, err := cache.reserveSpaceLocked(ctx, newBytes)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return cachePutCacheFullError{newBlockID}
+		}
+		err = cache.blockDb.Write(blockBatch, nil)
+		if err != nil {
+			cache.config.DiskLimiter().afterDiskBlockCachePut(
+				ctx, cache.limitType, newBytes, false)
+			return err
+		}
+		cache.config.DiskLimiter().afterDiskBlockCachePut(
+			ctx, cache.limitType, newBytes, true)
+		cache.tlfCounts[tlfID] += numNew
+		cache.numBlocks += numNew
+		cache.tlfSizes[tlfID] += uint64(newBytes)
+		cache.currBytes += uint64(newBytes)
+	}
+	err = cache.tlfDb.Write(tlfBatch, nil)
+	if err != nil {
+		cache.log.CWarningf(ctx, "Error writing to TLF cache database: %+v", err)
+	}
+	return cache.metaDb.Write(metadataBatch, nil)
+}
+
+// getStatus returns how many blocks and bytes, from how many TLFs,
+// the cache holds.
+func (cache *DiskBlockCacheStandard) getStatus() DiskBlockCachePartitionStatus {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	numTlfs := 0
+	for _, count := range cache.tlfCounts {
+		if count > 0 {
+			numTlfs++
+		}
+	}
+	return DiskBlockCachePartitionStatus{
+		NumBlocks: cache.numBlocks,
+		Bytes:     cache.currBytes,
+		NumTlfs:   numTlfs,
+	}
+}
+
+// RegisterForEvictions implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.
+func (cache *DiskBlockCacheStandard) RegisterForEvictions(
+	obs DiskBlockCacheEvictionObserver) {
+	cache.evictions.register(obs)
+}
+
+// UnregisterFromEvictions implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.
+func (cache *DiskBlockCacheStandard) UnregisterFromEvictions(
+	obs DiskBlockCacheEvictionObserver) {
+	cache.evictions.unregister(obs)
+}
+
+// RecentEvictions implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.
+func (cache *DiskBlockCacheStandard) RecentEvictions() []DiskBlockCacheEviction {
+	return cache.evictions.recent()
+}
+
 // Size implements the DiskBlockCache interface for DiskBlockCacheStandard.
 func (cache *DiskBlockCacheStandard) Size() int64 {
 	cache.lock.RLock()
@@ -514,7 +845,8 @@ func (cache *DiskBlockCacheStandard) deleteLocked(ctx context.Context,
 		cache.tlfSizes[k] -= removalSizes[k]
 		cache.currBytes -= removalSizes[k]
 	}
-	cache.config.DiskLimiter().onDiskBlockCacheDelete(ctx, sizeRemoved)
+	cache.config.DiskLimiter().onDiskBlockCacheDelete(
+		ctx, cache.limitType, sizeRemoved)
 
 	return numRemoved, sizeRemoved, nil
 }
@@ -536,29 +868,93 @@ func (cache *DiskBlockCacheStandard) DeleteByTLF(ctx context.Context, tlfID tlf.
 	return cache.deleteLocked(ctx, deleteEntries)
 }
 
-// getRandomBlockID gives us a pivot block ID for picking a random range of
-// blocks to consider deleting.  We pick a point to start our range based on
-// the proportion of the TLF space taken up by numElements/totalElements. E.g.
-// if we need to consider 100 out of 400 blocks, and we assume that the block
-// IDs are uniformly distributed, then our random start point should be in the
-// [0,0.75) interval on the [0,1.0) block ID space.
-func (*DiskBlockCacheStandard) getRandomBlockID(numElements,
-	totalElements int) (kbfsblock.ID, error) {
-	if totalElements == 0 {
-		return kbfsblock.ID{}, errors.New("")
+// BlockIDsForTLF implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.
+func (cache *DiskBlockCacheStandard) BlockIDsForTLF(ctx context.Context,
+	tlfID tlf.ID) ([]kbfsblock.ID, error) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	if cache.blockDb == nil {
+		return nil, errors.WithStack(DiskCacheClosedError{"BlockIDsForTLF"})
 	}
-	// Return a 0 block ID pivot if we require more elements than the total
-	// number available.
-	if numElements >= totalElements {
-		return kbfsblock.ID{}, nil
+	tlfBytes := tlfID.Bytes()
+	iter := cache.tlfDb.NewIterator(util.BytesPrefix(tlfBytes), nil)
+	defer iter.Release()
+	blockIDs := make([]kbfsblock.ID, 0, cache.tlfCounts[tlfID])
+	for iter.Next() {
+		key := iter.Key()
+		blockID, err := kbfsblock.IDFromBytes(key[len(tlfBytes):])
+		if err != nil {
+			cache.log.CWarningf(ctx, "Error decoding block ID %x", key)
+			continue
+		}
+		blockIDs = append(blockIDs, blockID)
 	}
-	// Generate a random block ID to start the range.
-	pivot := (1.0 - (float64(numElements) / float64(totalElements)))
-	return kbfsblock.MakeRandomIDInRange(0, pivot)
+	return blockIDs, iter.Error()
+}
+
+// evictionSampleRanges returns the ranges of keys to sample blocks
+// for eviction from, in a database whose keys are block IDs after the
+// given prefix.  Block IDs are hashes, so their order has nothing to
+// do with when blocks were used; starting at a random block ID and
+// wrapping around makes a sample of part of the database a uniform
+// one.
+func (cache *DiskBlockCacheStandard) evictionSampleRanges(prefix []byte) (
+	[]*util.Range, error) {
+	pivot, err := kbfsblock.MakeRandomIDInRange(0, 1.0)
+	if err != nil {
+		return nil, err
+	}
+	withPrefix := func(b []byte) []byte {
+		key := make([]byte, 0, len(prefix)+len(b))
+		return append(append(key, prefix...), b...)
+	}
+	start := withPrefix(nil)
+	if len(start) == 0 {
+		start = nil
+	}
+	return []*util.Range{
+		{Start: withPrefix(pivot.Bytes()), Limit: withPrefix(cache.maxBlockID)},
+		{Start: start, Limit: withPrefix(pivot.Bytes())},
+	}, nil
+}
+
+// sampleForEvictionLocked picks numBlocks blocks to evict from up to
+// numBlocks * evictionConsiderationFactor blocks of the given ranges
+// of db.  entryFn returns the LRU entry for a key and value of db, or
+// false if it can't be read.
+func (cache *DiskBlockCacheStandard) sampleForEvictionLocked(
+	db diskCacheDb, ranges []*util.Range, numBlocks int,
+	entryFn func(key, value []byte) (lruEntry, bool)) (
+	blockIDsByTime, error) {
+	reservoir := newEvictionReservoir(numBlocks, cache.config.Clock().Now())
+	sampleSize := numBlocks * evictionConsiderationFactor
+	numSampled := 0
+	for _, rng := range ranges {
+		err := func() error {
+			iter := db.NewIterator(rng, nil)
+			defer iter.Release()
+			for numSampled < sampleSize && iter.Next() {
+				entry, ok := entryFn(iter.Key(), iter.Value())
+				if !ok {
+					continue
+				}
+				reservoir.add(entry)
+				numSampled++
+			}
+			return iter.Error()
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reservoir.blockIDs(), nil
 }
 
 func (cache *DiskBlockCacheStandard) evictSomeBlocks(ctx context.Context,
-	numBlocks int, blockIDs blockIDsByTime) (numRemoved int, sizeRemoved int64, err error) {
+	numBlocks int, blockIDs blockIDsByTime,
+	reason DiskBlockCacheEvictionReason) (
+	numRemoved int, sizeRemoved int64, err error) {
 	if len(blockIDs) <= numBlocks {
 		numBlocks = len(blockIDs)
 	} else {
@@ -567,96 +963,150 @@ func (cache *DiskBlockCacheStandard) evictSomeBlocks(ctx context.Context,
 	}
 
 	blocksToDelete := blockIDs.ToBlockIDSlice(numBlocks)
-	return cache.deleteLocked(ctx, blocksToDelete)
+	numRemoved, sizeRemoved, err = cache.deleteLocked(ctx, blocksToDelete)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := cache.config.Clock().Now()
+	evictions := make([]DiskBlockCacheEviction, 0, numBlocks)
+	for _, entry := range blockIDs[:numBlocks] {
+		evictions = append(evictions, DiskBlockCacheEviction{
+			BlockID:   entry.BlockID,
+			TlfID:     entry.TlfID,
+			Partition: cache.limitType.String(),
+			Reason:    reason,
+			LRUAge:    now.Sub(entry.Time),
+			Time:      now,
+		})
+	}
+	cache.evictions.record(ctx, evictions)
+	return numRemoved, sizeRemoved, nil
 }
 
-// evictFromTLFLocked evicts a number of blocks from the cache for a given TLF.
-// We choose a pivot variable b randomly. Then begin an iterator into
-// cache.tlfDb.Range(tlfID + b, tlfID + MaxBlockID) and iterate from there to
-// get numBlocks * evictionConsiderationFactor block IDs.  We sort the
-// resulting blocks by value (LRU time) and pick the minimum numBlocks. We then
-// call cache.Delete() on that list of block IDs.
+// evictFromTLFLocked evicts a number of blocks from the cache for a
+// given TLF.  It samples up to numBlocks *
+// evictionConsiderationFactor of the TLF's blocks from cache.tlfDb,
+// starting at a random block ID, and picks the ones to evict by
+// weighted reservoir sampling on their LRU times (see
+// evictionReservoir), so that older blocks are much more likely to
+// be evicted, and the most recently used ones almost never are.
 func (cache *DiskBlockCacheStandard) evictFromTLFLocked(ctx context.Context,
 	tlfID tlf.ID, numBlocks int) (numRemoved int, sizeRemoved int64, err error) {
 	tlfBytes := tlfID.Bytes()
-	numElements := numBlocks * evictionConsiderationFactor
-	blockID, err := cache.getRandomBlockID(numElements, cache.tlfCounts[tlfID])
+	ranges, err := cache.evictionSampleRanges(tlfBytes)
 	if err != nil {
 		return 0, 0, err
 	}
-	rng := &util.Range{
-		Start: append(tlfBytes, blockID.Bytes()...),
-		Limit: append(tlfBytes, cache.maxBlockID...),
-	}
-	iter := cache.tlfDb.NewIterator(rng, nil)
-	defer iter.Release()
-
-	blockIDs := make(blockIDsByTime, 0, numElements)
-
-	for i := 0; i < numElements; i++ {
-		if !iter.Next() {
-			break
-		}
-		key := iter.Key()
-
-		blockIDBytes := key[len(tlfBytes):]
-		if err != nil {
-			cache.log.CWarningf(ctx, "Error decoding block ID %x", blockIDBytes)
-			continue
-		}
-		blockID, err := kbfsblock.IDFromBytes(blockIDBytes)
-		lru, err := cache.getLRU(blockID)
-		if err != nil {
-			cache.log.CWarningf(ctx, "Error decoding LRU time for block %s", blockID)
-			continue
-		}
-		blockIDs = append(blockIDs, lruEntry{tlfID, blockID, lru})
+	blockIDs, err := cache.sampleForEvictionLocked(cache.tlfDb, ranges,
+		numBlocks, func(key, _ []byte) (lruEntry, bool) {
+			blockID, err := kbfsblock.IDFromBytes(key[len(tlfBytes):])
+			if err != nil {
+				cache.log.CWarningf(ctx, "Error decoding block ID %x", key)
+				return lruEntry{}, false
+			}
+			lru, err := cache.getLRU(blockID)
+			if err != nil {
+				cache.log.CWarningf(ctx,
+					"Error decoding LRU time for block %s", blockID)
+				return lruEntry{}, false
+			}
+			return lruEntry{tlfID, blockID, lru}, true
+		})
+	if err != nil {
+		return 0, 0, err
 	}
-
-	return cache.evictSomeBlocks(ctx, numBlocks, blockIDs)
+	return cache.evictSomeBlocks(
+		ctx, numBlocks, blockIDs, EvictionReasonTLFLimit)
 }
 
-// evictLocked evicts a number of blocks from the cache.  We choose a pivot
-// variable b randomly. Then begin an iterator into cache.metaDb.Range(b,
-// MaxBlockID) and iterate from there to get numBlocks *
-// evictionConsiderationFactor block IDs.  We sort the resulting blocks by
-// value (LRU time) and pick the minimum numBlocks. We then call cache.Delete()
-// on that list of block IDs.
+// evictLocked evicts a number of blocks from the cache.  It samples
+// up to numBlocks * evictionConsiderationFactor blocks from
+// cache.metaDb, starting at a random block ID, and picks the ones to
+// evict by weighted reservoir sampling on their LRU times (see
+// evictionReservoir), so that older blocks are much more likely to
+// be evicted, and the most recently used ones almost never are.
 func (cache *DiskBlockCacheStandard) evictLocked(ctx context.Context,
 	numBlocks int) (numRemoved int, sizeRemoved int64, err error) {
-	numElements := numBlocks * evictionConsiderationFactor
-	blockID, err := cache.getRandomBlockID(numElements, cache.numBlocks)
+	return cache.evictForReasonLocked(ctx, numBlocks, EvictionReasonCacheFull)
+}
+
+func (cache *DiskBlockCacheStandard) evictForReasonLocked(
+	ctx context.Context, numBlocks int,
+	reason DiskBlockCacheEvictionReason) (
+	numRemoved int, sizeRemoved int64, err error) {
+	ranges, err := cache.evictionSampleRanges(nil)
 	if err != nil {
 		return 0, 0, err
 	}
-	rng := &util.Range{Start: blockID.Bytes(), Limit: cache.maxBlockID}
-	iter := cache.metaDb.NewIterator(rng, nil)
-	defer iter.Release()
+	blockIDs, err := cache.sampleForEvictionLocked(cache.metaDb, ranges,
+		numBlocks, func(key, value []byte) (lruEntry, bool) {
+			blockID, err := kbfsblock.IDFromBytes(key)
+			if err != nil {
+				cache.log.CWarningf(ctx, "Error decoding block ID %x", key)
+				return lruEntry{}, false
+			}
+			metadata := diskBlockCacheMetadata{}
+			err = cache.config.Codec().Decode(value, &metadata)
+			if err != nil {
+				cache.log.CWarningf(ctx,
+					"Error decoding metadata for block %s", blockID)
+				return lruEntry{}, false
+			}
+			return lruEntry{metadata.TlfID, blockID, metadata.LRUTime}, true
+		})
+	if err != nil {
+		return 0, 0, err
+	}
+	return cache.evictSomeBlocks(ctx, numBlocks, blockIDs, reason)
+}
 
-	blockIDs := make(blockIDsByTime, 0, numElements)
+// evictBatch evicts up to bulkNumBlocksToEvict blocks, taking the
+// lock only for that one batch, so that reads can go on in between
+// batches.
+func (cache *DiskBlockCacheStandard) evictBatch(ctx context.Context,
+	reason DiskBlockCacheEvictionReason) (
+	numRemoved int, sizeRemoved int64, err error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.blockDb == nil {
+		return 0, 0, errors.WithStack(DiskCacheClosedError{"evictBatch"})
+	}
+	return cache.evictForReasonLocked(ctx, bulkNumBlocksToEvict, reason)
+}
 
-	for i := 0; i < numElements; i++ {
-		if !iter.Next() {
-			break
+// evictBytes evicts blocks, least recently used first, until at
+// least the given number of bytes have been removed or the cache is
+// empty.
+func (cache *DiskBlockCacheStandard) evictBytes(ctx context.Context,
+	bytes int64, reason DiskBlockCacheEvictionReason) (
+	numRemoved int, sizeRemoved int64, err error) {
+	for sizeRemoved < bytes {
+		select {
+		case <-ctx.Done():
+			return numRemoved, sizeRemoved, ctx.Err()
+		default:
 		}
-		key := iter.Key()
-
+		n, size, err := cache.evictBatch(ctx, reason)
 		if err != nil {
-			cache.log.CWarningf(ctx, "Error decoding block ID %x", key)
-			continue
+			return numRemoved, sizeRemoved, err
 		}
-		blockID, err := kbfsblock.IDFromBytes(key)
-		metadata := diskBlockCacheMetadata{}
-		err = cache.config.Codec().Decode(iter.Value(), &metadata)
-		if err != nil {
-			cache.log.CWarningf(ctx, "Error decoding metadata for block %s", blockID)
-			continue
+		if n == 0 {
+			break
 		}
-		blockIDs = append(blockIDs, lruEntry{metadata.TlfID, blockID,
-			metadata.LRUTime})
+		numRemoved += n
+		sizeRemoved += size
 	}
+	return numRemoved, sizeRemoved, nil
+}
 
-	return cache.evictSomeBlocks(ctx, numBlocks, blockIDs)
+// Shrink implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.
+func (cache *DiskBlockCacheStandard) Shrink(ctx context.Context,
+	targetBytes int64, progressFn func(DiskBlockCacheShrinkProgress)) (
+	DiskBlockCacheShrinkProgress, error) {
+	return shrinkDiskBlockCachePartitions(ctx,
+		[]*DiskBlockCacheStandard{cache}, targetBytes, progressFn)
 }
 
 // Shutdown implements the DiskBlockCache interface for DiskBlockCacheStandard.
@@ -666,6 +1116,27 @@ func (cache *DiskBlockCacheStandard) Shutdown(ctx context.Context) {
 	if cache.blockDb == nil {
 		return
 	}
+	cache.closeLocked(ctx)
+	cache.config.DiskLimiter().onDiskBlockCacheDisable(
+		ctx, cache.limitType, int64(cache.currBytes))
+}
+
+// shutdownUnaccounted shuts down a cache whose blocks were never
+// reported to the disk limiter, i.e. one that was opened but never
+// passed to SetDiskBlockCache.
+func (cache *DiskBlockCacheStandard) shutdownUnaccounted(
+	ctx context.Context) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.blockDb == nil {
+		return
+	}
+	cache.closeLocked(ctx)
+}
+
+// closeLocked closes the cache's databases and gives up its
+// ownership of dirPath.
+func (cache *DiskBlockCacheStandard) closeLocked(ctx context.Context) {
 	err := cache.blockDb.Close()
 	if err != nil {
 		cache.log.CWarningf(ctx, "Error closing blockDb: %+v", err)
@@ -676,5 +1147,22 @@ func (cache *DiskBlockCacheStandard) Shutdown(ctx context.Context) {
 		cache.log.CWarningf(ctx, "Error closing blockDb: %+v", err)
 	}
 	cache.metaDb = nil
-	cache.config.DiskLimiter().onDiskBlockCacheDisable(ctx, int64(cache.currBytes))
+	err = cache.tlfDb.Close()
+	if err != nil {
+		cache.log.CWarningf(ctx, "Error closing tlfDb: %+v", err)
+	}
+	cache.tlfDb = nil
+	for _, stor := range cache.fileStorages {
+		err = stor.Close()
+		if err != nil {
+			cache.log.CWarningf(ctx, "Error closing storage: %+v", err)
+		}
+	}
+	if cache.dirPath != "" {
+		err = ioutil.Remove(diskCacheOwnerPath(cache.dirPath))
+		if err != nil {
+			cache.log.CWarningf(ctx,
+				"Error removing disk cache owner file: %+v", err)
+		}
+	}
 }
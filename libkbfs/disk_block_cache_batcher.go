@@ -0,0 +1,125 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const (
+	// diskBlockCachePutBatchSize is how many pending blocks make
+	// diskBlockCachePutBatcher write them right away.
+	diskBlockCachePutBatchSize = 64
+	// diskBlockCachePutBatchDelay is the longest a block waits in
+	// diskBlockCachePutBatcher before it's written.
+	diskBlockCachePutBatchDelay = 50 * time.Millisecond
+)
+
+// diskBlockCachePutBatcher collects blocks to be put to the disk
+// block cache, and puts them with DiskBlockCache.PutBatch, so that
+// syncing or prefetching many small blocks doesn't cost a write to
+// each of the cache's databases, and a round with the disk limiter,
+// per block.
+// diskBlockCachePutBatcherConfig is the subset of Config needed by
+// diskBlockCachePutBatcher.
+type diskBlockCachePutBatcherConfig interface {
+	diskBlockCacheGetter
+	clockGetter
+}
+
+type diskBlockCachePutBatcher struct {
+	config diskBlockCachePutBatcherConfig
+	log    logger.Logger
+
+	lock           sync.Mutex
+	pending        map[tlf.ID]map[kbfsblock.ID]DiskBlockCacheBlock
+	numPending     int
+	flushScheduled bool
+}
+
+func newDiskBlockCachePutBatcher(
+	config diskBlockCachePutBatcherConfig,
+	log logger.Logger) *diskBlockCachePutBatcher {
+	return &diskBlockCachePutBatcher{
+		config:  config,
+		log:     log,
+		pending: make(map[tlf.ID]map[kbfsblock.ID]DiskBlockCacheBlock),
+	}
+}
+
+// put queues a block to be put to the disk block cache.
+func (b *diskBlockCachePutBatcher) put(tlfID tlf.ID, blockID kbfsblock.ID,
+	buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	blocks := b.pending[tlfID]
+	if blocks == nil {
+		blocks = make(map[kbfsblock.ID]DiskBlockCacheBlock)
+		b.pending[tlfID] = blocks
+	}
+	if _, ok := blocks[blockID]; !ok {
+		b.numPending++
+	}
+	blocks[blockID] = DiskBlockCacheBlock{buf, serverHalf}
+
+	if b.numPending >= diskBlockCachePutBatchSize {
+		go b.write(b.takePendingLocked())
+	} else if !b.flushScheduled {
+		b.flushScheduled = true
+		b.config.Clock().AfterFunc(diskBlockCachePutBatchDelay, b.flush)
+	}
+}
+
+// get returns a block that's waiting to be put, if there is one.
+func (b *diskBlockCachePutBatcher) get(tlfID tlf.ID, blockID kbfsblock.ID) (
+	DiskBlockCacheBlock, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	block, ok := b.pending[tlfID][blockID]
+	return block, ok
+}
+
+func (b *diskBlockCachePutBatcher) takePendingLocked() map[tlf.ID]map[kbfsblock.ID]DiskBlockCacheBlock {
+	pending := b.pending
+	b.pending = make(map[tlf.ID]map[kbfsblock.ID]DiskBlockCacheBlock)
+	b.numPending = 0
+	return pending
+}
+
+// flush writes all pending blocks.
+func (b *diskBlockCachePutBatcher) flush() {
+	pending := func() map[tlf.ID]map[kbfsblock.ID]DiskBlockCacheBlock {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		b.flushScheduled = false
+		return b.takePendingLocked()
+	}()
+	b.write(pending)
+}
+
+func (b *diskBlockCachePutBatcher) write(
+	pending map[tlf.ID]map[kbfsblock.ID]DiskBlockCacheBlock) {
+	dbc := b.config.DiskBlockCache()
+	if dbc == nil {
+		return
+	}
+	// The requests that cached these blocks may be long done, so
+	// don't use their contexts.
+	ctx := context.Background()
+	for tlfID, blocks := range pending {
+		err := dbc.PutBatch(ctx, tlfID, blocks)
+		if err != nil {
+			b.log.CDebugf(ctx, "Couldn't put %d blocks of TLF %s to the "+
+				"disk block cache: %+v", len(blocks), tlfID, err)
+		}
+	}
+}
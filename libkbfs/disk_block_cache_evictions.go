@@ -0,0 +1,164 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// diskBlockCacheEvictionLogSize is how many of the most recent
+// evictions the disk block cache remembers.
+const diskBlockCacheEvictionLogSize = 100
+
+// DiskBlockCacheEvictionReason says why blocks were evicted from the
+// disk block cache.
+type DiskBlockCacheEvictionReason int
+
+const (
+	// EvictionReasonCacheFull means the cache partition was out of
+	// space for a new block.
+	EvictionReasonCacheFull DiskBlockCacheEvictionReason = iota
+	// EvictionReasonTLFLimit means the TLF was taking up too much
+	// of its cache partition.
+	EvictionReasonTLFLimit
+	// EvictionReasonLowDiskSpace means the disk the cache is on was
+	// running out of free space.
+	EvictionReasonLowDiskSpace
+	// EvictionReasonShrink means the cache was shrunk on request,
+	// e.g. by `kbfstool shrink-cache`.
+	EvictionReasonShrink
+)
+
+func (r DiskBlockCacheEvictionReason) String() string {
+	switch r {
+	case EvictionReasonCacheFull:
+		return "cacheFull"
+	case EvictionReasonTLFLimit:
+		return "tlfLimit"
+	case EvictionReasonLowDiskSpace:
+		return "lowDiskSpace"
+	case EvictionReasonShrink:
+		return "shrink"
+	default:
+		return fmt.Sprintf("DiskBlockCacheEvictionReason(%d)", int(r))
+	}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for
+// DiskBlockCacheEvictionReason.
+func (r DiskBlockCacheEvictionReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// DiskBlockCacheEviction describes a block evicted from the disk
+// block cache.
+type DiskBlockCacheEviction struct {
+	BlockID kbfsblock.ID
+	TlfID   tlf.ID
+	// Partition is the cache partition the block was evicted
+	// from.
+	Partition string
+	Reason    DiskBlockCacheEvictionReason
+	// LRUAge is how long it had been since the block was last put
+	// or read.  Evicting recently-used blocks means the cache is
+	// thrashing.
+	LRUAge time.Duration
+	Time   time.Time
+}
+
+// DiskBlockCacheEvictionObserver is notified when blocks are evicted
+// from the disk block cache.
+type DiskBlockCacheEvictionObserver interface {
+	// BlocksEvicted is called after blocks are evicted.  The
+	// cache is still locked, so implementations must return
+	// quickly and must not call back into the cache.
+	BlocksEvicted(ctx context.Context, evictions []DiskBlockCacheEviction)
+}
+
+// diskBlockCacheEvictionLog keeps the most recent evictions from a
+// disk block cache, and the observers to tell about new ones.  It's
+// shared by all partitions of a DiskBlockCachePartitioned.
+type diskBlockCacheEvictionLog struct {
+	lock      sync.Mutex
+	observers []DiskBlockCacheEvictionObserver
+	// evictions is a ring buffer; next is where the next eviction
+	// goes.
+	evictions []DiskBlockCacheEviction
+	next      int
+}
+
+func newDiskBlockCacheEvictionLog() *diskBlockCacheEvictionLog {
+	return &diskBlockCacheEvictionLog{
+		evictions: make(
+			[]DiskBlockCacheEviction, 0, diskBlockCacheEvictionLogSize),
+	}
+}
+
+func (l *diskBlockCacheEvictionLog) register(
+	obs DiskBlockCacheEvictionObserver) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.observers = append(l.observers, obs)
+}
+
+func (l *diskBlockCacheEvictionLog) unregister(
+	obs DiskBlockCacheEvictionObserver) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i, o := range l.observers {
+		if o == obs {
+			// Copy, so record's snapshot of the slice stays
+			// intact.
+			observers := make([]DiskBlockCacheEvictionObserver, 0,
+				len(l.observers)-1)
+			observers = append(observers, l.observers[:i]...)
+			l.observers = append(observers, l.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// record adds the given evictions to the log and tells the
+// observers about them.
+func (l *diskBlockCacheEvictionLog) record(
+	ctx context.Context, evictions []DiskBlockCacheEviction) {
+	if len(evictions) == 0 {
+		return
+	}
+	observers := func() []DiskBlockCacheEvictionObserver {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		for _, e := range evictions {
+			if len(l.evictions) < diskBlockCacheEvictionLogSize {
+				l.evictions = append(l.evictions, e)
+			} else {
+				l.evictions[l.next] = e
+			}
+			l.next = (l.next + 1) % diskBlockCacheEvictionLogSize
+		}
+		return l.observers
+	}()
+	for _, obs := range observers {
+		obs.BlocksEvicted(ctx, evictions)
+	}
+}
+
+// recent returns the logged evictions, oldest first.
+func (l *diskBlockCacheEvictionLog) recent() []DiskBlockCacheEviction {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	recent := make([]DiskBlockCacheEviction, 0, len(l.evictions))
+	if len(l.evictions) == diskBlockCacheEvictionLogSize {
+		recent = append(recent, l.evictions[l.next:]...)
+		return append(recent, l.evictions[:l.next]...)
+	}
+	return append(recent, l.evictions...)
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testDiskBlockCacheEvictionObserver struct {
+	evictions []DiskBlockCacheEviction
+}
+
+func (o *testDiskBlockCacheEvictionObserver) BlocksEvicted(
+	_ context.Context, evictions []DiskBlockCacheEviction) {
+	o.evictions = append(o.evictions, evictions...)
+}
+
+func TestDiskBlockCacheEvictionObserver(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that observers and the log see disk cache evictions.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	clock := config.TestClock()
+	tlf1 := tlf.FakeID(1, false)
+	for i := 0; i < 20; i++ {
+		blockID, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+		err := cache.Put(ctx, tlf1, blockID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		clock.Add(time.Second)
+	}
+
+	obs := &testDiskBlockCacheEvictionObserver{}
+	cache.RegisterForEvictions(obs)
+	numRemoved, _, err := cache.evictLocked(ctx, 5)
+	require.NoError(t, err)
+	require.Len(t, obs.evictions, numRemoved)
+	for _, e := range obs.evictions {
+		require.Equal(t, tlf1, e.TlfID)
+		require.Equal(t, EvictionReasonCacheFull, e.Reason)
+		require.Equal(t, "workingSet", e.Partition)
+		require.True(t, e.LRUAge > 0)
+		_, _, err := cache.Get(ctx, tlf1, e.BlockID)
+		require.IsType(t, NoSuchBlockError{}, err)
+	}
+
+	t.Log("Unregistered observers aren't told, but the log still is.")
+	cache.UnregisterFromEvictions(obs)
+	numRemovedFromTLF, _, err := cache.evictFromTLFLocked(ctx, tlf1, 5)
+	require.NoError(t, err)
+	require.Len(t, obs.evictions, numRemoved)
+	recent := cache.RecentEvictions()
+	require.Len(t, recent, numRemoved+numRemovedFromTLF)
+	require.Equal(t, obs.evictions, recent[:numRemoved])
+	require.Equal(t, EvictionReasonTLFLimit, recent[len(recent)-1].Reason)
+}
+
+func TestDiskBlockCacheEvictionLogBounded(t *testing.T) {
+	t.Parallel()
+	l := newDiskBlockCacheEvictionLog()
+	ctx := context.Background()
+	total := diskBlockCacheEvictionLogSize + diskBlockCacheEvictionLogSize/2
+	for i := 0; i < total; i++ {
+		l.record(ctx, []DiskBlockCacheEviction{
+			{LRUAge: time.Duration(i)}})
+	}
+	recent := l.recent()
+	require.Len(t, recent, diskBlockCacheEvictionLogSize)
+	for i, e := range recent {
+		require.Equal(t, time.Duration(total-len(recent)+i), e.LRUAge)
+	}
+}
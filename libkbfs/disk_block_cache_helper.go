@@ -1,6 +1,9 @@
 package libkbfs
 
 import (
+	"container/heap"
+	"math"
+	"math/rand"
 	"time"
 
 	"github.com/keybase/kbfs/kbfsblock"
@@ -15,6 +18,13 @@ type diskBlockCacheEntry struct {
 	ServerHalf kbfscrypto.BlockCryptKeyServerHalf
 }
 
+// DiskBlockCacheBlock is an encoded block and its server half, as
+// passed to and from the batch methods of DiskBlockCache.
+type DiskBlockCacheBlock struct {
+	Buf        []byte
+	ServerHalf kbfscrypto.BlockCryptKeyServerHalf
+}
+
 // diskBlockCacheDeleteKey specifies a blockID and TLF pair to delete.
 type diskBlockCacheDeleteKey struct {
 	TlfID   tlf.ID
@@ -55,3 +65,89 @@ func (b blockIDsByTime) ToBlockIDSlice(numBlocks int) []diskBlockCacheDeleteKey
 	}
 	return ids
 }
+
+// evictionCandidate is a block in an evictionReservoir, with its
+// sampling key.
+type evictionCandidate struct {
+	lruEntry
+	key float64
+}
+
+// evictionCandidates is a min-heap of candidates by key.
+type evictionCandidates []evictionCandidate
+
+func (c evictionCandidates) Len() int           { return len(c) }
+func (c evictionCandidates) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c evictionCandidates) Less(i, j int) bool { return c[i].key < c[j].key }
+
+func (c *evictionCandidates) Push(x interface{}) {
+	*c = append(*c, x.(evictionCandidate))
+}
+
+func (c *evictionCandidates) Pop() interface{} {
+	old := *c
+	x := old[len(old)-1]
+	*c = old[:len(old)-1]
+	return x
+}
+
+// evictionReservoir picks blocks to evict from a stream of blocks,
+// by weighted reservoir sampling without replacement (Efraimidis and
+// Spirakis' A-Res), with each block weighted by how long ago it was
+// last used.  Each pick is a block with probability proportional to
+// its age, so a block used x times more recently than another is x
+// times less likely to be evicted, and a block used at the current
+// time is only picked if there aren't enough older blocks.  Only the
+// picked blocks are kept, so memory use doesn't depend on how many
+// blocks are sampled.
+type evictionReservoir struct {
+	numBlocks  int
+	now        time.Time
+	randFloat  func() float64
+	candidates evictionCandidates
+}
+
+func newEvictionReservoir(numBlocks int, now time.Time) *evictionReservoir {
+	return &evictionReservoir{
+		numBlocks:  numBlocks,
+		now:        now,
+		randFloat:  rand.Float64,
+		candidates: make(evictionCandidates, 0, numBlocks),
+	}
+}
+
+// add offers a block to the reservoir.
+func (r *evictionReservoir) add(entry lruEntry) {
+	if r.numBlocks <= 0 {
+		return
+	}
+	// The key is log(u^(1/w)) for u uniform in (0, 1), which keeps
+	// the order of A-Res's u^(1/w) without underflowing for large
+	// weights.
+	weight := r.now.Sub(entry.Time).Seconds()
+	u := r.randFloat()
+	for u == 0 {
+		u = r.randFloat()
+	}
+	key := math.Inf(-1)
+	if weight > 0 {
+		key = math.Log(u) / weight
+	}
+	if len(r.candidates) < r.numBlocks {
+		heap.Push(&r.candidates, evictionCandidate{entry, key})
+		return
+	}
+	if key > r.candidates[0].key {
+		r.candidates[0] = evictionCandidate{entry, key}
+		heap.Fix(&r.candidates, 0)
+	}
+}
+
+// blockIDs returns the picked blocks.
+func (r *evictionReservoir) blockIDs() blockIDsByTime {
+	blockIDs := make(blockIDsByTime, 0, len(r.candidates))
+	for _, c := range r.candidates {
+		blockIDs = append(blockIDs, c.lruEntry)
+	}
+	return blockIDs
+}
@@ -0,0 +1,30 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// diskCacheLockErrno returns the system error number underneath err,
+// which may be wrapped in the *os.PathError or *os.SyscallError that
+// failed to open or lock one of the disk cache's files.
+func diskCacheLockErrno(err error) (syscall.Errno, bool) {
+	switch e := errors.Cause(err).(type) {
+	case syscall.Errno:
+		return e, true
+	case *os.PathError:
+		errno, ok := e.Err.(syscall.Errno)
+		return errno, ok
+	case *os.SyscallError:
+		errno, ok := e.Err.(syscall.Errno)
+		return errno, ok
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package libkbfs
+
+import "syscall"
+
+// isDiskCacheLockedErr returns true if the given error, returned
+// when opening one of the disk cache's leveldbs, means another
+// process is holding that leveldb's lock.  Depending on the platform
+// and the kind of lock, that's reported as EWOULDBLOCK or EAGAIN, or
+// as EACCES or EPERM.
+func isDiskCacheLockedErr(err error) bool {
+	errno, ok := diskCacheLockErrno(err)
+	if !ok {
+		return false
+	}
+	// EWOULDBLOCK and EAGAIN are the same on some platforms, so
+	// this can't be a switch.
+	return errno == syscall.EWOULDBLOCK || errno == syscall.EAGAIN ||
+		errno == syscall.EACCES || errno == syscall.EPERM
+}
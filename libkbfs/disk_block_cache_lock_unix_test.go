@@ -0,0 +1,34 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package libkbfs
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsDiskCacheLockedErr(t *testing.T) {
+	for _, errno := range []syscall.Errno{
+		syscall.EWOULDBLOCK, syscall.EAGAIN, syscall.EACCES, syscall.EPERM,
+	} {
+		require.True(t, isDiskCacheLockedErr(errno), "%v", errno)
+		require.True(t, isDiskCacheLockedErr(
+			&os.PathError{Op: "open", Path: "LOCK", Err: errno}), "%v", errno)
+		require.True(t, isDiskCacheLockedErr(
+			errors.WithStack(os.NewSyscallError("flock", errno))), "%v", errno)
+	}
+
+	require.False(t, isDiskCacheLockedErr(nil))
+	require.False(t, isDiskCacheLockedErr(syscall.ENOENT))
+	require.False(t, isDiskCacheLockedErr(
+		&os.PathError{Op: "open", Path: "LOCK", Err: syscall.ENOSPC}))
+	require.False(t, isDiskCacheLockedErr(errors.New("locked")))
+}
@@ -0,0 +1,31 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import "syscall"
+
+const (
+	errorAccessDenied     syscall.Errno = 5
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isDiskCacheLockedErr returns true if the given error, returned
+// when opening one of the disk cache's leveldbs, means another
+// process has that leveldb's lock file open.  Besides sharing and
+// lock violations, that's reported as access denied while the other
+// process is removing the lock file.
+func isDiskCacheLockedErr(err error) bool {
+	errno, ok := diskCacheLockErrno(err)
+	if !ok {
+		return false
+	}
+	switch errno {
+	case errorAccessDenied, errorSharingViolation, errorLockViolation:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+)
+
+const diskCacheOwnerFilename = "owner.json"
+
+// diskCacheOwner records which process currently has a disk block
+// cache directory open.  Exclusive access itself is enforced by the
+// locks on the cache's leveldbs; this is only used to tell any other
+// process (possibly running as a different OS user) that tries to
+// open the same directory who it is contending with.
+type diskCacheOwner struct {
+	PID      int
+	User     string
+	Hostname string
+	Since    time.Time
+}
+
+func (o diskCacheOwner) String() string {
+	return fmt.Sprintf("pid %d (user %q on %s, since %s)",
+		o.PID, o.User, o.Hostname, o.Since.Format(time.RFC3339))
+}
+
+func diskCacheOwnerPath(dirPath string) string {
+	return filepath.Join(dirPath, diskCacheOwnerFilename)
+}
+
+func makeDiskCacheOwner(now time.Time) diskCacheOwner {
+	owner := diskCacheOwner{PID: os.Getpid(), Since: now}
+	if u, err := user.Current(); err == nil {
+		owner.User = u.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		owner.Hostname = hostname
+	}
+	return owner
+}
+
+// readDiskCacheOwner returns a description of the current owner of
+// the disk cache at the given path, or "an unknown process" if it
+// can't be determined.
+func readDiskCacheOwner(dirPath string) string {
+	var owner diskCacheOwner
+	err := ioutil.DeserializeFromJSONFile(diskCacheOwnerPath(dirPath), &owner)
+	if err != nil {
+		return "an unknown process"
+	}
+	return owner.String()
+}
+
+func writeDiskCacheOwner(dirPath string, owner diskCacheOwner) error {
+	return ioutil.SerializeToJSONFile(owner, diskCacheOwnerPath(dirPath))
+}
@@ -0,0 +1,388 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+func publicDiskBlockCacheRootFromStorageRoot(storageRoot string) string {
+	return filepath.Join(storageRoot, "kbfs_public_block_cache")
+}
+
+func syncDiskBlockCacheRootFromStorageRoot(storageRoot string) string {
+	return filepath.Join(storageRoot, "kbfs_sync_block_cache")
+}
+
+// syncedTlfsFilename is the file in the sync partition's directory
+// that lists the TLFs kept synced on this device.
+const syncedTlfsFilename = "synced_tlfs.json"
+
+// DiskBlockCachePartitioned is a DiskBlockCache made of three
+// DiskBlockCacheStandards, each with its own disk limit:
+//
+//   - sync, for the blocks of TLFs the user asked to keep synced on
+//     this device, so that syncing them and caching whatever else
+//     the user looks at never compete for space;
+//   - public, for the blocks of other users' public TLFs, so that
+//     browsing someone else's public folder can only evict blocks of
+//     other public folders;
+//   - workingSet, for all other blocks.
+type DiskBlockCachePartitioned struct {
+	workingSet *DiskBlockCacheStandard
+	public     *DiskBlockCacheStandard
+	sync       *DiskBlockCacheStandard
+
+	// syncedTlfsPath is where syncedTlfs is saved.
+	syncedTlfsPath string
+
+	// tlfLock protects ownPublicTlfs, the public TLFs the current
+	// user can write to, and syncedTlfs.
+	tlfLock       sync.RWMutex
+	ownPublicTlfs map[tlf.ID]bool
+	syncedTlfs    map[tlf.ID]bool
+}
+
+var _ DiskBlockCache = (*DiskBlockCachePartitioned)(nil)
+
+// newDiskBlockCachePartitioned opens the partitions of the disk
+// block cache under storageRoot.  The working set partition is in the
+// same place a plain DiskBlockCacheStandard would be, so its contents
+// carry over.
+func newDiskBlockCachePartitioned(config diskBlockCacheConfig,
+	storageRoot string) (cache *DiskBlockCachePartitioned, err error) {
+	var opened []*DiskBlockCacheStandard
+	defer func() {
+		if err != nil {
+			for _, partition := range opened {
+				partition.shutdownUnaccounted(context.TODO())
+			}
+		}
+	}()
+	open := func(dirPath string, typ diskLimitTrackerType) (
+		*DiskBlockCacheStandard, error) {
+		partition, err := newDiskBlockCacheStandard(config, dirPath)
+		if err != nil {
+			return nil, err
+		}
+		partition.limitType = typ
+		opened = append(opened, partition)
+		return partition, nil
+	}
+	workingSet, err := open(diskBlockCacheRootFromStorageRoot(storageRoot),
+		workingSetCacheLimitTrackerType)
+	if err != nil {
+		return nil, err
+	}
+	public, err := open(publicDiskBlockCacheRootFromStorageRoot(storageRoot),
+		publicCacheLimitTrackerType)
+	if err != nil {
+		return nil, err
+	}
+	syncRoot := syncDiskBlockCacheRootFromStorageRoot(storageRoot)
+	syncPartition, err := open(syncRoot, syncCacheLimitTrackerType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Share one eviction log, so observers see evictions from all
+	// partitions.
+	evictions := newDiskBlockCacheEvictionLog()
+	for _, partition := range opened {
+		partition.evictions = evictions
+	}
+
+	syncedTlfsPath := filepath.Join(syncRoot, syncedTlfsFilename)
+	var syncedList []tlf.ID
+	err = ioutil.DeserializeFromJSONFile(syncedTlfsPath, &syncedList)
+	if err != nil && !ioutil.IsNotExist(err) {
+		return nil, err
+	}
+	syncedTlfs := make(map[tlf.ID]bool, len(syncedList))
+	for _, tlfID := range syncedList {
+		syncedTlfs[tlfID] = true
+	}
+	return &DiskBlockCachePartitioned{
+		workingSet:     workingSet,
+		public:         public,
+		sync:           syncPartition,
+		syncedTlfsPath: syncedTlfsPath,
+		ownPublicTlfs:  make(map[tlf.ID]bool),
+		syncedTlfs:     syncedTlfs,
+	}, nil
+}
+
+func (cache *DiskBlockCachePartitioned) all() []*DiskBlockCacheStandard {
+	return []*DiskBlockCacheStandard{
+		cache.workingSet, cache.public, cache.sync}
+}
+
+// setPublicTlfIsOwn records whether the current user can write to
+// the given public TLF, which decides the partition its blocks are
+// put into from now on.
+func (cache *DiskBlockCachePartitioned) setPublicTlfIsOwn(
+	tlfID tlf.ID, isOwn bool) {
+	cache.tlfLock.Lock()
+	defer cache.tlfLock.Unlock()
+	if isOwn {
+		cache.ownPublicTlfs[tlfID] = true
+	} else {
+		delete(cache.ownPublicTlfs, tlfID)
+	}
+}
+
+// setTlfSynced records whether the given TLF is kept synced on this
+// device, which decides the partition its blocks are put into from
+// now on.  The setting is saved with the cache.  Blocks already
+// cached stay where they are until they're evicted.
+func (cache *DiskBlockCachePartitioned) setTlfSynced(
+	tlfID tlf.ID, synced bool) error {
+	cache.tlfLock.Lock()
+	defer cache.tlfLock.Unlock()
+	if cache.syncedTlfs[tlfID] == synced {
+		return nil
+	}
+	if synced {
+		cache.syncedTlfs[tlfID] = true
+	} else {
+		delete(cache.syncedTlfs, tlfID)
+	}
+	syncedList := make([]tlf.ID, 0, len(cache.syncedTlfs))
+	for id := range cache.syncedTlfs {
+		syncedList = append(syncedList, id)
+	}
+	return ioutil.SerializeToJSONFile(syncedList, cache.syncedTlfsPath)
+}
+
+// isTlfSynced returns whether the given TLF is kept synced on this
+// device.
+func (cache *DiskBlockCachePartitioned) isTlfSynced(tlfID tlf.ID) bool {
+	cache.tlfLock.RLock()
+	defer cache.tlfLock.RUnlock()
+	return cache.syncedTlfs[tlfID]
+}
+
+// partitions returns all partitions, starting with the one that new
+// blocks of the given TLF go into.
+func (cache *DiskBlockCachePartitioned) partitions(
+	tlfID tlf.ID) []*DiskBlockCacheStandard {
+	cache.tlfLock.RLock()
+	defer cache.tlfLock.RUnlock()
+	switch {
+	case cache.syncedTlfs[tlfID]:
+		return []*DiskBlockCacheStandard{
+			cache.sync, cache.workingSet, cache.public}
+	case tlfID.IsPublic() && !cache.ownPublicTlfs[tlfID]:
+		return []*DiskBlockCacheStandard{
+			cache.public, cache.workingSet, cache.sync}
+	}
+	return []*DiskBlockCacheStandard{
+		cache.workingSet, cache.sync, cache.public}
+}
+
+// Get implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.  A block may be in another partition
+// than the one it would be put into now, if the TLF's sync setting
+// or the user's access to it changed since, so all are checked.
+func (cache *DiskBlockCachePartitioned) Get(ctx context.Context,
+	tlfID tlf.ID, blockID kbfsblock.ID) (
+	buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf, err error) {
+	for _, partition := range cache.partitions(tlfID) {
+		buf, serverHalf, err = partition.Get(ctx, tlfID, blockID)
+		if _, ok := err.(NoSuchBlockError); !ok {
+			return buf, serverHalf, err
+		}
+	}
+	return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+}
+
+// Put implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.
+func (cache *DiskBlockCachePartitioned) Put(ctx context.Context,
+	tlfID tlf.ID, blockID kbfsblock.ID, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	return cache.partitions(tlfID)[0].Put(
+		ctx, tlfID, blockID, buf, serverHalf)
+}
+
+// GetBatch implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.  Like Get, it looks in every partition,
+// for the blocks not found in the previous ones.
+func (cache *DiskBlockCachePartitioned) GetBatch(ctx context.Context,
+	tlfID tlf.ID, blockIDs []kbfsblock.ID) (
+	map[kbfsblock.ID]DiskBlockCacheBlock, error) {
+	blocks := make(map[kbfsblock.ID]DiskBlockCacheBlock, len(blockIDs))
+	remaining := blockIDs
+	for _, partition := range cache.partitions(tlfID) {
+		if len(remaining) == 0 {
+			break
+		}
+		found, err := partition.GetBatch(ctx, tlfID, remaining)
+		if err != nil {
+			return nil, err
+		}
+		notFound := make([]kbfsblock.ID, 0, len(remaining)-len(found))
+		for _, blockID := range remaining {
+			if block, ok := found[blockID]; ok {
+				blocks[blockID] = block
+			} else {
+				notFound = append(notFound, blockID)
+			}
+		}
+		remaining = notFound
+	}
+	return blocks, nil
+}
+
+// PutBatch implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.
+func (cache *DiskBlockCachePartitioned) PutBatch(ctx context.Context,
+	tlfID tlf.ID, blocks map[kbfsblock.ID]DiskBlockCacheBlock) error {
+	return cache.partitions(tlfID)[0].PutBatch(ctx, tlfID, blocks)
+}
+
+// DeleteByTLF implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.
+func (cache *DiskBlockCachePartitioned) DeleteByTLF(ctx context.Context,
+	tlfID tlf.ID, blockIDs []kbfsblock.ID) (
+	numRemoved int, sizeRemoved int64, err error) {
+	for _, partition := range cache.all() {
+		n, size, err := partition.DeleteByTLF(ctx, tlfID, blockIDs)
+		if err != nil {
+			return numRemoved, sizeRemoved, err
+		}
+		numRemoved += n
+		sizeRemoved += size
+	}
+	return numRemoved, sizeRemoved, nil
+}
+
+// BlockIDsForTLF implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.
+func (cache *DiskBlockCachePartitioned) BlockIDsForTLF(
+	ctx context.Context, tlfID tlf.ID) ([]kbfsblock.ID, error) {
+	var blockIDs []kbfsblock.ID
+	for _, partition := range cache.all() {
+		ids, err := partition.BlockIDsForTLF(ctx, tlfID)
+		if err != nil {
+			return nil, err
+		}
+		blockIDs = append(blockIDs, ids...)
+	}
+	return blockIDs, nil
+}
+
+// evictBytes evicts blocks until at least the given number of bytes
+// have been removed, or the cache is empty.  Other users' public
+// TLFs go first and synced TLFs last, since the user asked to keep
+// those.
+func (cache *DiskBlockCachePartitioned) evictBytes(ctx context.Context,
+	bytes int64, reason DiskBlockCacheEvictionReason) (
+	numRemoved int, sizeRemoved int64, err error) {
+	for _, partition := range []*DiskBlockCacheStandard{
+		cache.public, cache.workingSet, cache.sync} {
+		if sizeRemoved >= bytes {
+			break
+		}
+		n, size, err := partition.evictBytes(
+			ctx, bytes-sizeRemoved, reason)
+		numRemoved += n
+		sizeRemoved += size
+		if err != nil {
+			return numRemoved, sizeRemoved, err
+		}
+	}
+	return numRemoved, sizeRemoved, nil
+}
+
+// Shrink implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.  Like evictBytes, it evicts from other
+// users' public TLFs first and synced TLFs last.
+func (cache *DiskBlockCachePartitioned) Shrink(ctx context.Context,
+	targetBytes int64, progressFn func(DiskBlockCacheShrinkProgress)) (
+	DiskBlockCacheShrinkProgress, error) {
+	return shrinkDiskBlockCachePartitions(ctx, []*DiskBlockCacheStandard{
+		cache.public, cache.workingSet, cache.sync},
+		targetBytes, progressFn)
+}
+
+// Size implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.
+func (cache *DiskBlockCachePartitioned) Size() (size int64) {
+	for _, partition := range cache.all() {
+		size += partition.Size()
+	}
+	return size
+}
+
+// RegisterForEvictions implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.
+func (cache *DiskBlockCachePartitioned) RegisterForEvictions(
+	obs DiskBlockCacheEvictionObserver) {
+	cache.workingSet.RegisterForEvictions(obs)
+}
+
+// UnregisterFromEvictions implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.
+func (cache *DiskBlockCachePartitioned) UnregisterFromEvictions(
+	obs DiskBlockCacheEvictionObserver) {
+	cache.workingSet.UnregisterFromEvictions(obs)
+}
+
+// RecentEvictions implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.
+func (cache *DiskBlockCachePartitioned) RecentEvictions() []DiskBlockCacheEviction {
+	return cache.workingSet.RecentEvictions()
+}
+
+// Shutdown implements the DiskBlockCache interface for
+// DiskBlockCachePartitioned.
+func (cache *DiskBlockCachePartitioned) Shutdown(ctx context.Context) {
+	for _, partition := range cache.all() {
+		partition.Shutdown(ctx)
+	}
+}
+
+// DiskBlockCachePartitionStatus describes one partition of the disk
+// block cache.
+type DiskBlockCachePartitionStatus struct {
+	NumBlocks int
+	Bytes     uint64
+	NumTlfs   int
+}
+
+// getStatus returns the status of each partition, keyed by its name.
+func (cache *DiskBlockCachePartitioned) getStatus() map[string]DiskBlockCachePartitionStatus {
+	statuses := make(map[string]DiskBlockCachePartitionStatus)
+	for _, partition := range cache.all() {
+		statuses[partition.limitType.String()] = partition.getStatus()
+	}
+	return statuses
+}
+
+// enableDiskBlockCacheLimits tells limiter about the blocks already
+// in dbc, partition by partition.
+func enableDiskBlockCacheLimits(ctx context.Context, limiter DiskLimiter,
+	dbc DiskBlockCache) {
+	switch dbc := dbc.(type) {
+	case *DiskBlockCachePartitioned:
+		for _, partition := range dbc.all() {
+			limiter.onDiskBlockCacheEnable(
+				ctx, partition.limitType, partition.Size())
+		}
+	case *DiskBlockCacheStandard:
+		limiter.onDiskBlockCacheEnable(ctx, dbc.limitType, dbc.Size())
+	default:
+		limiter.onDiskBlockCacheEnable(
+			ctx, workingSetCacheLimitTrackerType, dbc.Size())
+	}
+}
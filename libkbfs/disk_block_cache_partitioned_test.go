@@ -0,0 +1,161 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func initDiskBlockCachePartitionedTest(t *testing.T) (
+	*DiskBlockCachePartitioned, *testDiskBlockCacheConfig, func()) {
+	config := newTestDiskBlockCacheConfig(t)
+	// Set up a limiter for the on-disk partitions to share.
+	memCache, err := newDiskBlockCacheStandardForTest(config,
+		testDiskBlockCacheMaxBytes, nil)
+	require.NoError(t, err)
+
+	storageRoot, err := ioutil.TempDir(os.TempDir(), "disk_cache_partitioned")
+	require.NoError(t, err)
+	cache, err := newDiskBlockCachePartitioned(config, storageRoot)
+	require.NoError(t, err)
+	enableDiskBlockCacheLimits(
+		context.Background(), config.DiskLimiter(), cache)
+	return cache, config, func() {
+		shutdownDiskBlockCacheTest(cache)
+		shutdownDiskBlockCacheTest(memCache)
+		ioutil.RemoveAll(storageRoot)
+	}
+}
+
+func TestDiskBlockCachePartitionedRouting(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that only other users' public TLFs use the public partition.")
+	cache, config, shutdown := initDiskBlockCachePartitionedTest(t)
+	defer shutdown()
+	ctx := context.Background()
+
+	privateTlf := tlf.FakeID(1, false)
+	othersTlf := tlf.FakeID(2, true)
+	ownPublicTlf := tlf.FakeID(3, true)
+	cache.setPublicTlfIsOwn(ownPublicTlf, true)
+
+	for _, tlfID := range []tlf.ID{privateTlf, othersTlf, ownPublicTlf} {
+		blockID, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+		err := cache.Put(ctx, tlfID, blockID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		buf, _, err := cache.Get(ctx, tlfID, blockID)
+		require.NoError(t, err)
+		require.Equal(t, blockEncoded, buf)
+	}
+	require.Equal(t, 2, cache.workingSet.numBlocks)
+	require.Equal(t, 1, cache.public.numBlocks)
+	require.Equal(t, cache.workingSet.Size()+cache.public.Size(), cache.Size())
+
+	t.Log("Blocks put before the user became a writer are still found.")
+	ids, err := cache.BlockIDsForTLF(ctx, othersTlf)
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+	cache.setPublicTlfIsOwn(othersTlf, true)
+	_, _, err = cache.Get(ctx, othersTlf, ids[0])
+	require.NoError(t, err)
+	blocks, err := cache.GetBatch(ctx, othersTlf, ids)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+
+	numRemoved, _, err := cache.DeleteByTLF(ctx, othersTlf, ids)
+	require.NoError(t, err)
+	require.Equal(t, 1, numRemoved)
+	require.Equal(t, 0, cache.public.numBlocks)
+}
+
+func TestDiskBlockCachePartitionedEviction(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that filling the public partition doesn't evict own blocks.")
+	cache, config, shutdown := initDiskBlockCachePartitionedTest(t)
+	defer shutdown()
+	ctx := context.Background()
+
+	numBlocks := 5
+	for i := 0; i < numBlocks; i++ {
+		for _, tlfID := range []tlf.ID{
+			tlf.FakeID(1, false), tlf.FakeID(2, true)} {
+			blockID, blockEncoded, serverHalf :=
+				setupBlockForDiskCache(t, config)
+			err := cache.Put(ctx, tlfID, blockID, blockEncoded, serverHalf)
+			require.NoError(t, err)
+		}
+	}
+
+	t.Log("Cap the public partition at its current size.")
+	limiter := config.DiskLimiter().(*backpressureDiskLimiter)
+	limiter.publicCacheByteTracker.limit = cache.public.Size()
+
+	blockID, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+	err := cache.Put(
+		ctx, tlf.FakeID(2, true), blockID, blockEncoded, serverHalf)
+	require.NoError(t, err)
+	require.True(t, cache.public.numBlocks <= numBlocks)
+	require.Equal(t, numBlocks, cache.workingSet.numBlocks)
+}
+
+func TestDiskBlockCachePartitionedSync(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that filling the sync partition doesn't evict other blocks.")
+	cache, config, shutdown := initDiskBlockCachePartitionedTest(t)
+	defer shutdown()
+	ctx := context.Background()
+
+	syncedTlf := tlf.FakeID(1, false)
+	workingSetTlf := tlf.FakeID(2, false)
+	err := cache.setTlfSynced(syncedTlf, true)
+	require.NoError(t, err)
+	require.True(t, cache.isTlfSynced(syncedTlf))
+
+	numBlocks := 5
+	for i := 0; i < numBlocks; i++ {
+		for _, tlfID := range []tlf.ID{syncedTlf, workingSetTlf} {
+			blockID, blockEncoded, serverHalf :=
+				setupBlockForDiskCache(t, config)
+			err := cache.Put(ctx, tlfID, blockID, blockEncoded, serverHalf)
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, numBlocks, cache.sync.numBlocks)
+	require.Equal(t, numBlocks, cache.workingSet.numBlocks)
+
+	t.Log("Cap the sync partition at its current size.")
+	limiter := config.DiskLimiter().(*backpressureDiskLimiter)
+	limiter.syncCacheByteTracker.limit = cache.sync.Size()
+
+	blockID, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+	err = cache.Put(ctx, syncedTlf, blockID, blockEncoded, serverHalf)
+	require.NoError(t, err)
+	require.True(t, cache.sync.numBlocks <= numBlocks)
+	require.Equal(t, numBlocks, cache.workingSet.numBlocks)
+
+	status := cache.getStatus()
+	require.Equal(t, cache.sync.numBlocks,
+		status[syncCacheLimitTrackerType.String()].NumBlocks)
+	require.Equal(t, 1, status[syncCacheLimitTrackerType.String()].NumTlfs)
+
+	t.Log("The synced TLFs are saved with the cache.")
+	var synced []tlf.ID
+	err = ioutil.DeserializeFromJSONFile(cache.syncedTlfsPath, &synced)
+	require.NoError(t, err)
+	require.Equal(t, []tlf.ID{syncedTlf}, synced)
+
+	err = cache.setTlfSynced(syncedTlf, false)
+	require.NoError(t, err)
+	require.False(t, cache.isTlfSynced(syncedTlf))
+	err = ioutil.DeserializeFromJSONFile(cache.syncedTlfsPath, &synced)
+	require.NoError(t, err)
+	require.Len(t, synced, 0)
+}
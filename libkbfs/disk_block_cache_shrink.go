@@ -0,0 +1,94 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// DiskBlockCacheShrinkProgress describes how far along shrinking the
+// disk block cache is.  It is suitable for encoding directly as JSON.
+type DiskBlockCacheShrinkProgress struct {
+	StartBytes    int64
+	TargetBytes   int64
+	CurrentBytes  int64
+	BlocksEvicted int
+	BytesEvicted  int64
+}
+
+// shrinkDiskBlockCachePartitions evicts from the given partitions, in
+// order, until their total size is at most targetBytes.  Blocks put
+// while this runs count towards the total, so it keeps going until
+// it catches up with them.
+func shrinkDiskBlockCachePartitions(ctx context.Context,
+	partitions []*DiskBlockCacheStandard, targetBytes int64,
+	progressFn func(DiskBlockCacheShrinkProgress)) (
+	progress DiskBlockCacheShrinkProgress, err error) {
+	size := func() (total int64) {
+		for _, partition := range partitions {
+			total += partition.Size()
+		}
+		return total
+	}
+	progress.StartBytes = size()
+	progress.TargetBytes = targetBytes
+	progress.CurrentBytes = progress.StartBytes
+
+	for len(partitions) > 0 && progress.CurrentBytes > targetBytes {
+		select {
+		case <-ctx.Done():
+			return progress, errors.WithStack(ctx.Err())
+		default:
+		}
+		numRemoved, sizeRemoved, err := partitions[0].evictBatch(
+			ctx, EvictionReasonShrink)
+		if err != nil {
+			return progress, err
+		}
+		if numRemoved == 0 {
+			// This partition is empty; move on to the next one.
+			partitions = partitions[1:]
+			continue
+		}
+		progress.BlocksEvicted += numRemoved
+		progress.BytesEvicted += sizeRemoved
+		progress.CurrentBytes = size()
+		if progressFn != nil {
+			progressFn(progress)
+		}
+	}
+	progress.CurrentBytes = size()
+	return progress, nil
+}
+
+// ShrinkDiskBlockCache shrinks the disk block cache of the given
+// config to at most targetBytes right away, for when disk space is
+// needed back urgently, calling progressFn, if non-nil, after each
+// batch of evictions.  The disk limiter is told about the freed space
+// as blocks are evicted, and the free space is checked again at the
+// end, so that journals that stopped growing for lack of space start
+// again without waiting for the next check.  It fails with
+// NoDiskBlockCacheError if the config has no disk block cache, e.g.
+// because a running KBFS owns it.
+func ShrinkDiskBlockCache(ctx context.Context, config Config,
+	targetBytes int64, progressFn func(DiskBlockCacheShrinkProgress)) (
+	DiskBlockCacheShrinkProgress, error) {
+	if targetBytes < 0 {
+		return DiskBlockCacheShrinkProgress{}, errors.Errorf(
+			"Invalid target size %d", targetBytes)
+	}
+	dbc := config.DiskBlockCache()
+	if dbc == nil {
+		return DiskBlockCacheShrinkProgress{},
+			errors.WithStack(NoDiskBlockCacheError{})
+	}
+
+	progress, err := dbc.Shrink(ctx, targetBytes, progressFn)
+	if kbfsOps, ok := config.KBFSOps().(*KBFSOpsStandard); ok {
+		kbfsOps.checkLowDiskSpace(ctx)
+	}
+	return progress, err
+}
@@ -6,12 +6,19 @@ package libkbfs
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/storage"
@@ -27,14 +34,16 @@ type testDiskBlockCacheConfig struct {
 	logMaker
 	*testClockGetter
 	limiter DiskLimiter
+	backend DiskCacheBackend
 }
 
-func newTestDiskBlockCacheConfig(t *testing.T) *testDiskBlockCacheConfig {
+func newTestDiskBlockCacheConfig(t testing.TB) *testDiskBlockCacheConfig {
 	return &testDiskBlockCacheConfig{
 		newTestCodecGetter(),
 		newTestLogMaker(t),
 		newTestClockGetter(),
 		nil,
+		DiskCacheBackendLevelDB,
 	}
 }
 
@@ -42,6 +51,10 @@ func (c testDiskBlockCacheConfig) DiskLimiter() DiskLimiter {
 	return c.limiter
 }
 
+func (c testDiskBlockCacheConfig) DiskCacheBackend() DiskCacheBackend {
+	return c.backend
+}
+
 func newDiskBlockCacheStandardForTest(config *testDiskBlockCacheConfig,
 	maxBytes int64, limiter DiskLimiter) (*DiskBlockCacheStandard, error) {
 	blockStorage := storage.NewMemStorage()
@@ -55,14 +68,19 @@ func newDiskBlockCacheStandardForTest(config *testDiskBlockCacheConfig,
 	}
 	if limiter == nil {
 		params := backpressureDiskLimiterParams{
-			minThreshold:  0.5,
-			maxThreshold:  0.95,
-			journalFrac:   0.25,
-			diskCacheFrac: 0.25,
-			byteLimit:     testDiskBlockCacheMaxBytes,
-			fileLimit:     maxFiles,
-			maxDelay:      time.Second,
-			delayFn:       defaultDoDelay,
+			minThreshold:    0.5,
+			maxThreshold:    0.95,
+			journalFrac:     0.25,
+			journalMDFrac:   0.25,
+			diskCacheFrac:   0.25,
+			publicCacheFrac: 0.25,
+			syncCacheFrac:   0.25,
+			readSpillFrac:   0.25,
+			byteLimit:       testDiskBlockCacheMaxBytes,
+			fileLimit:       maxFiles,
+			maxDelay:        time.Second,
+			clock:           config.Clock(),
+			delayFn:         defaultDoDelay,
 			freeBytesAndFilesFn: func() (int64, int64, error) {
 				// hackity hackeroni: simulate the disk cache taking up space.
 				freeBytes := maxBytes - int64(cache.currBytes)
@@ -194,6 +212,11 @@ func TestDiskBlockCacheDelete(t *testing.T) {
 	require.EqualError(t, err, errors.ErrNotFound.Error())
 	_, err = cache.getLRU(block2Id)
 	require.EqualError(t, err, errors.ErrNotFound.Error())
+
+	t.Log("Verify that only the remaining block is listed for the TLF.")
+	blockIDs, err := cache.BlockIDsForTLF(ctx, tlf1)
+	require.NoError(t, err)
+	require.Equal(t, []kbfsblock.ID{block3Id}, blockIDs)
 }
 
 func TestDiskBlockCacheEvictFromTLF(t *testing.T) {
@@ -351,6 +374,266 @@ func TestDiskBlockCacheEvictOverall(t *testing.T) {
 		"Average overall LRU delta from an eviction: %.2f", averageDifference)
 }
 
+func TestDiskBlockCacheShrink(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that shrinking the disk cache evicts blocks in batches " +
+		"until it's small enough.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	limiter := config.DiskLimiter().(*backpressureDiskLimiter)
+	currTlf := tlf.FakeID(0, false)
+	for i := 0; i < 3*bulkNumBlocksToEvict; i++ {
+		blockID, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+		err := cache.Put(ctx, currTlf, blockID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+	}
+	startBytes := cache.Size()
+	require.Equal(t, startBytes, limiter.diskCacheByteTracker.used)
+
+	t.Log("Shrink the cache to half its size.")
+	var updates []DiskBlockCacheShrinkProgress
+	progress, err := cache.Shrink(ctx, startBytes/2,
+		func(p DiskBlockCacheShrinkProgress) {
+			updates = append(updates, p)
+		})
+	require.NoError(t, err)
+	require.Equal(t, startBytes, progress.StartBytes)
+	require.Equal(t, startBytes/2, progress.TargetBytes)
+	require.True(t, progress.CurrentBytes <= startBytes/2)
+	require.Equal(t, cache.Size(), progress.CurrentBytes)
+	require.Equal(t, startBytes-progress.CurrentBytes, progress.BytesEvicted)
+	require.NotEmpty(t, updates)
+	require.Equal(t, progress, updates[len(updates)-1])
+	require.Equal(t, EvictionReasonShrink, cache.RecentEvictions()[0].Reason)
+
+	t.Log("The limiter was told about the evictions.")
+	require.Equal(t, progress.CurrentBytes,
+		limiter.diskCacheByteTracker.used)
+
+	t.Log("Shrinking to 0 empties the cache.")
+	progress, err = cache.Shrink(ctx, 0, nil)
+	require.NoError(t, err)
+	require.Zero(t, progress.CurrentBytes)
+	require.Zero(t, cache.Size())
+	require.Zero(t, limiter.diskCacheByteTracker.used)
+}
+
+func TestEvictionReservoirBound(t *testing.T) {
+	t.Parallel()
+	t.Log("Test how close weighted reservoir sampling gets to true LRU.")
+	now := time.Now()
+	numEntries := 1000
+	numBlocks := 10
+	numTrials := 2000
+	r := rand.New(rand.NewSource(1))
+
+	// Block i was last used i seconds ago, so a block's age is
+	// also its rank under true LRU, newest first.
+	picks := make([]int, numEntries+1)
+	for trial := 0; trial < numTrials; trial++ {
+		reservoir := newEvictionReservoir(numBlocks, now)
+		reservoir.randFloat = r.Float64
+		for i := 0; i <= numEntries; i++ {
+			reservoir.add(lruEntry{
+				BlockID: kbfsblock.FakeID(byte(i)),
+				Time:    now.Add(-time.Duration(i) * time.Second),
+			})
+		}
+		blockIDs := reservoir.blockIDs()
+		require.Len(t, blockIDs, numBlocks)
+		for _, entry := range blockIDs {
+			picks[int(now.Sub(entry.Time)/time.Second)]++
+		}
+	}
+	require.Equal(t, 0, picks[0], "A block used just now was evicted")
+
+	// Picks are proportional to age, so the newest fraction q of
+	// the blocks gets about q^2 of them.
+	fractionNewerThan := func(age int) float64 {
+		total := 0
+		for _, n := range picks[:age] {
+			total += n
+		}
+		return float64(total) / float64(numBlocks*numTrials)
+	}
+	newestTenth := fractionNewerThan(numEntries / 10)
+	t.Logf("Fraction of evictions from the newest 10%%: %.4f", newestTenth)
+	require.True(t, newestTenth < 0.02)
+	newestHalf := fractionNewerThan(numEntries / 2)
+	t.Logf("Fraction of evictions from the newest 50%%: %.4f", newestHalf)
+	require.True(t, newestHalf < 0.30)
+}
+
+func TestDiskBlockCacheEvictKeepsRecentBlocks(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that eviction never picks blocks used just now.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	clock := config.TestClock()
+	tlf1 := tlf.FakeID(1, false)
+	for i := 0; i < 100; i++ {
+		blockID, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+		err := cache.Put(ctx, tlf1, blockID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		clock.Add(time.Second)
+	}
+	var recentIDs []kbfsblock.ID
+	for i := 0; i < 10; i++ {
+		blockID, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+		err := cache.Put(ctx, tlf1, blockID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		recentIDs = append(recentIDs, blockID)
+	}
+
+	numRemoved, _, err := cache.evictLocked(ctx, 50)
+	require.NoError(t, err)
+	require.Equal(t, 50, numRemoved)
+	numRemoved, _, err = cache.evictFromTLFLocked(ctx, tlf1, 40)
+	require.NoError(t, err)
+	require.Equal(t, 40, numRemoved)
+	for _, blockID := range recentIDs {
+		_, _, err := cache.Get(ctx, tlf1, blockID)
+		require.NoError(t, err)
+	}
+}
+
+// BenchmarkDiskBlockCachePutFull measures Puts into a full cache,
+// where each one first has to evict some blocks.
+func BenchmarkDiskBlockCachePutFull(b *testing.B) {
+	config := newTestDiskBlockCacheConfig(b)
+	config.logMaker = testLogMaker{logger.NewNull()}
+	cache, err := newDiskBlockCacheStandardForTest(config,
+		testDiskBlockCacheMaxBytes, nil)
+	require.NoError(b, err)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	clock := config.TestClock()
+	buf := make([]byte, 1024)
+	_, err = rand.Read(buf)
+	require.NoError(b, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(b, err)
+	put := func(tlfID tlf.ID) {
+		blockID, err := kbfsblock.MakeTemporaryID()
+		require.NoError(b, err)
+		err = cache.Put(ctx, tlfID, blockID, buf, serverHalf)
+		require.NoError(b, err)
+	}
+
+	numTlfs := 10
+	numBlocks := 10000
+	for i := 0; i < numBlocks; i++ {
+		put(tlf.FakeID(byte(i%numTlfs), false))
+		clock.Add(time.Second)
+	}
+	limiter := cache.config.DiskLimiter().(*backpressureDiskLimiter)
+	limiter.diskCacheByteTracker.limit = int64(cache.currBytes)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		put(tlf.FakeID(byte(i%numTlfs), false))
+		clock.Add(time.Second)
+	}
+}
+
+func TestDiskBlockCacheBatch(t *testing.T) {
+	t.Parallel()
+	t.Log("Test putting and getting many blocks at once.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	clock := config.TestClock()
+	tlf1 := tlf.FakeID(1, false)
+	blocks := make(map[kbfsblock.ID]DiskBlockCacheBlock)
+	var blockIDs []kbfsblock.ID
+	for i := 0; i < 10; i++ {
+		blockID, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+		blocks[blockID] = DiskBlockCacheBlock{blockEncoded, serverHalf}
+		blockIDs = append(blockIDs, blockID)
+	}
+	err := cache.PutBatch(ctx, tlf1, blocks)
+	require.NoError(t, err)
+	require.Equal(t, 10, cache.numBlocks)
+	require.Equal(t, 10, cache.tlfCounts[tlf1])
+	require.Equal(t, cache.tlfSizes[tlf1], cache.currBytes)
+
+	t.Log("Putting a block again doesn't count it twice.")
+	size := cache.Size()
+	err = cache.PutBatch(ctx, tlf1, map[kbfsblock.ID]DiskBlockCacheBlock{
+		blockIDs[0]: blocks[blockIDs[0]]})
+	require.NoError(t, err)
+	require.Equal(t, 10, cache.numBlocks)
+	require.Equal(t, size, cache.Size())
+
+	t.Log("Get some of the blocks, and one that isn't cached.")
+	clock.Add(time.Second)
+	missingID, _, _ := setupBlockForDiskCache(t, config)
+	got, err := cache.GetBatch(
+		ctx, tlf1, append([]kbfsblock.ID{missingID}, blockIDs[:5]...))
+	require.NoError(t, err)
+	require.Len(t, got, 5)
+	for _, blockID := range blockIDs[:5] {
+		require.Equal(t, blocks[blockID], got[blockID])
+		lru, err := cache.getLRU(blockID)
+		require.NoError(t, err)
+		require.True(t, clock.Now().Equal(lru))
+	}
+
+	t.Log("Single-block gets see batched puts.")
+	buf, serverHalf, err := cache.Get(ctx, tlf1, blockIDs[9])
+	require.NoError(t, err)
+	require.Equal(t, blocks[blockIDs[9]], DiskBlockCacheBlock{buf, serverHalf})
+	ids, err := cache.BlockIDsForTLF(ctx, tlf1)
+	require.NoError(t, err)
+	require.Len(t, ids, 10)
+}
+
+type testDiskBlockCacheGetter struct {
+	cache DiskBlockCache
+}
+
+func (g testDiskBlockCacheGetter) DiskBlockCache() DiskBlockCache {
+	return g.cache
+}
+
+func (g testDiskBlockCacheGetter) Clock() Clock {
+	return wallClock{}
+}
+
+func TestDiskBlockCachePutBatcher(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that queued puts are readable, then written in a batch.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	tlf1 := tlf.FakeID(1, false)
+	batcher := newDiskBlockCachePutBatcher(
+		testDiskBlockCacheGetter{cache}, config.MakeLogger(""))
+	var blockIDs []kbfsblock.ID
+	for i := 0; i < 3; i++ {
+		blockID, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+		batcher.put(tlf1, blockID, blockEncoded, serverHalf)
+		block, ok := batcher.get(tlf1, blockID)
+		require.True(t, ok)
+		require.Equal(t, blockEncoded, block.Buf)
+		blockIDs = append(blockIDs, blockID)
+	}
+
+	batcher.flush()
+	_, ok := batcher.get(tlf1, blockIDs[0])
+	require.False(t, ok)
+	got, err := cache.GetBatch(ctx, tlf1, blockIDs)
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+}
+
 func TestDiskBlockCacheStaticLimit(t *testing.T) {
 	t.Parallel()
 	t.Log("Test that disk cache eviction works when we hit the static limit.")
@@ -440,3 +723,110 @@ func TestDiskBlockCacheDynamicLimit(t *testing.T) {
 	require.True(t, int64(cache.currBytes) < currBytes)
 	require.Equal(t, start, cache.numBlocks)
 }
+
+func TestDiskBlockCacheOwnership(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that a second opener of a disk cache directory is " +
+		"turned away with a description of the owner.")
+	config := newTestDiskBlockCacheConfig(t)
+	// Set up a limiter for the on-disk caches to share.
+	memCache, err := newDiskBlockCacheStandardForTest(config,
+		testDiskBlockCacheMaxBytes, nil)
+	require.NoError(t, err)
+	defer shutdownDiskBlockCacheTest(memCache)
+
+	dirPath, err := ioutil.TempDir(os.TempDir(), "disk_cache_owner")
+	require.NoError(t, err)
+	defer ioutil.RemoveAll(dirPath)
+
+	cache1, err := newDiskBlockCacheStandard(config, dirPath)
+	require.NoError(t, err)
+	_, err = newDiskBlockCacheStandard(config, dirPath)
+	lockedErr, ok := pkgerrors.Cause(err).(DiskCacheLockedError)
+	require.True(t, ok, "Unexpected error: %+v", err)
+	require.Contains(t, lockedErr.Owner, fmt.Sprintf("pid %d", os.Getpid()))
+
+	t.Log("Once the owner shuts down, the cache can be opened again.")
+	shutdownDiskBlockCacheTest(cache1)
+	cache2, err := newDiskBlockCacheStandard(config, dirPath)
+	require.NoError(t, err)
+	shutdownDiskBlockCacheTest(cache2)
+}
+
+func TestDiskBlockCacheBadger(t *testing.T) {
+	t.Parallel()
+	t.Log("Test a disk cache stored in badger, and switching backends.")
+	config := newTestDiskBlockCacheConfig(t)
+	// Set up a limiter for the on-disk caches to use.
+	memCache, err := newDiskBlockCacheStandardForTest(config,
+		testDiskBlockCacheMaxBytes, nil)
+	require.NoError(t, err)
+	defer shutdownDiskBlockCacheTest(memCache)
+
+	dirPath, err := ioutil.TempDir(os.TempDir(), "disk_cache_badger")
+	require.NoError(t, err)
+	defer ioutil.RemoveAll(dirPath)
+
+	config.backend = DiskCacheBackendBadger
+	cache, err := newDiskBlockCacheStandard(config, dirPath)
+	require.NoError(t, err)
+	_, err = newDiskBlockCacheStandard(config, dirPath)
+	_, ok := pkgerrors.Cause(err).(DiskCacheLockedError)
+	require.True(t, ok, "Unexpected error: %+v", err)
+
+	ctx := context.Background()
+	tlf1 := tlf.FakeID(1, false)
+	blocks := make(map[kbfsblock.ID]DiskBlockCacheBlock)
+	var blockIDs []kbfsblock.ID
+	for i := 0; i < 10; i++ {
+		blockID, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+		blocks[blockID] = DiskBlockCacheBlock{blockEncoded, serverHalf}
+		blockIDs = append(blockIDs, blockID)
+	}
+	err = cache.PutBatch(ctx, tlf1, blocks)
+	require.NoError(t, err)
+	got, err := cache.GetBatch(ctx, tlf1, blockIDs)
+	require.NoError(t, err)
+	require.Equal(t, blocks, got)
+	buf, serverHalf, err := cache.Get(ctx, tlf1, blockIDs[0])
+	require.NoError(t, err)
+	require.Equal(t, blocks[blockIDs[0]], DiskBlockCacheBlock{buf, serverHalf})
+	_, _, err = cache.Get(ctx, tlf1, kbfsblock.FakeID(1))
+	require.EqualError(t, err, NoSuchBlockError{kbfsblock.FakeID(1)}.Error())
+	ids, err := cache.BlockIDsForTLF(ctx, tlf1)
+	require.NoError(t, err)
+	require.Len(t, ids, 10)
+
+	t.Log("Evict and delete some blocks.")
+	numRemoved, _, err := cache.evictFromTLFLocked(ctx, tlf1, 3)
+	require.NoError(t, err)
+	require.Equal(t, 3, numRemoved)
+	numRemoved, _, err = cache.DeleteByTLF(ctx, tlf1, blockIDs)
+	require.NoError(t, err)
+	require.Equal(t, 7, numRemoved)
+	require.Zero(t, cache.numBlocks)
+	err = cache.PutBatch(ctx, tlf1, map[kbfsblock.ID]DiskBlockCacheBlock{
+		blockIDs[0]: blocks[blockIDs[0]]})
+	require.NoError(t, err)
+	size := cache.Size()
+
+	t.Log("The blocks are still there after a restart.")
+	shutdownDiskBlockCacheTest(cache)
+	cache, err = newDiskBlockCacheStandard(config, dirPath)
+	require.NoError(t, err)
+	require.Equal(t, 1, cache.numBlocks)
+	require.Equal(t, size, cache.Size())
+	shutdownDiskBlockCacheTest(cache)
+
+	t.Log("Switching to leveldb starts over, and removes the badger " +
+		"databases.")
+	config.backend = DiskCacheBackendLevelDB
+	cache, err = newDiskBlockCacheStandard(config, dirPath)
+	require.NoError(t, err)
+	defer shutdownDiskBlockCacheTest(cache)
+	require.Zero(t, cache.numBlocks)
+	versionPath := versionPathFromVersion(dirPath, currentDiskCacheVersion)
+	_, err = ioutil.Stat(filepath.Join(
+		versionPath, DiskCacheBackendBadger.dbFilename(blockDbName)))
+	require.True(t, ioutil.IsNotExist(err))
+}
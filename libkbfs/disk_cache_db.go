@@ -0,0 +1,141 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// DiskCacheBackend is the key-value store that the disk block cache
+// keeps its databases in.
+type DiskCacheBackend int
+
+const (
+	// DiskCacheBackendLevelDB stores the disk block cache in
+	// leveldb.
+	DiskCacheBackendLevelDB DiskCacheBackend = iota
+	// DiskCacheBackendBadger stores the disk block cache in badger,
+	// which doesn't stall writes on compactions the way leveldb
+	// does, and recovers from an unclean shutdown by truncating
+	// its log rather than by rebuilding a corrupt manifest.
+	DiskCacheBackendBadger
+)
+
+func (b DiskCacheBackend) String() string {
+	switch b {
+	case DiskCacheBackendLevelDB:
+		return "leveldb"
+	case DiskCacheBackendBadger:
+		return "badger"
+	}
+	return fmt.Sprintf("DiskCacheBackend(%d)", int(b))
+}
+
+// dbFilename returns the filename of the database with the given
+// name, in this backend's format.  Each backend has its own
+// filenames, so that one never tries to open another's databases.
+func (b DiskCacheBackend) dbFilename(name string) string {
+	return name + "." + b.String()
+}
+
+// ParseDiskCacheBackend parses the String() form of a
+// DiskCacheBackend.
+func ParseDiskCacheBackend(s string) (DiskCacheBackend, error) {
+	switch strings.ToLower(s) {
+	case "leveldb", "":
+		return DiskCacheBackendLevelDB, nil
+	case "badger":
+		return DiskCacheBackendBadger, nil
+	}
+	return DiskCacheBackendLevelDB, fmt.Errorf(
+		"Unknown disk cache backend %q; must be leveldb or badger", s)
+}
+
+// diskCacheBackendFlag is for specifying a DiskCacheBackend with the
+// flag package.
+type diskCacheBackendFlag struct {
+	b *DiskCacheBackend
+}
+
+// String for flag interface.
+func (f diskCacheBackendFlag) String() string {
+	if f.b == nil {
+		return DiskCacheBackendLevelDB.String()
+	}
+	return f.b.String()
+}
+
+// Set for flag interface.
+func (f diskCacheBackendFlag) Set(raw string) error {
+	b, err := ParseDiskCacheBackend(raw)
+	if err != nil {
+		return err
+	}
+	*f.b = b
+	return nil
+}
+
+// diskCacheIterator iterates over the keys of a diskCacheDb in
+// order.  The slices returned by Key and Value may only be used
+// until the next call to Next.
+type diskCacheIterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Release()
+}
+
+// diskCacheSnapshot is a consistent, read-only view of a diskCacheDb.
+type diskCacheSnapshot interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	Release()
+}
+
+// diskCacheDb is a key-value database underlying a disk block cache.
+// It's the subset of *leveldb.DB that the cache uses, so every
+// backend follows leveldb's conventions: Get returns
+// leveldb.ErrNotFound for a missing key, writes are grouped into a
+// *leveldb.Batch, and read and write options may be nil.  Backends
+// without an equivalent of an option ignore it.
+type diskCacheDb interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	Has(key []byte, ro *opt.ReadOptions) (bool, error)
+	Put(key, value []byte, wo *opt.WriteOptions) error
+	Write(batch *leveldb.Batch, wo *opt.WriteOptions) error
+	// NewIterator iterates over the keys in slice, or over all of
+	// them if slice is nil.
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) diskCacheIterator
+	GetSnapshot() (diskCacheSnapshot, error)
+	// CompactRange reclaims the space of deleted and overwritten
+	// keys in r.
+	CompactRange(r util.Range) error
+	Close() error
+}
+
+// levelDbDiskCacheDb is a diskCacheDb stored in leveldb.
+type levelDbDiskCacheDb struct {
+	*leveldb.DB
+}
+
+var _ diskCacheDb = levelDbDiskCacheDb{}
+
+func (db levelDbDiskCacheDb) NewIterator(
+	slice *util.Range, ro *opt.ReadOptions) diskCacheIterator {
+	return db.DB.NewIterator(slice, ro)
+}
+
+func (db levelDbDiskCacheDb) GetSnapshot() (diskCacheSnapshot, error) {
+	snapshot, err := db.DB.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,271 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/options"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// badgerValueLogGCDiscardRatio is how much of a badger value log
+// file must be garbage before CompactRange rewrites it.
+const badgerValueLogGCDiscardRatio = 0.5
+
+// badgerDiskCacheDb is a diskCacheDb stored in badger.
+type badgerDiskCacheDb struct {
+	db *badger.DB
+}
+
+var _ diskCacheDb = badgerDiskCacheDb{}
+
+// isBadgerLockedErr returns whether err is badger failing to lock its
+// directory because another process has it open.
+func isBadgerLockedErr(err error) bool {
+	cause := errors.Cause(err)
+	// On Windows, badger's lock is a pid file that it creates
+	// exclusively.
+	return isDiskCacheLockedErr(cause) || os.IsExist(cause)
+}
+
+// openBadgerDiskCacheDb opens or creates the badger database at
+// dbPath, translating lock contention with another process over the
+// disk cache at dirPath into a DiskCacheLockedError.
+func openBadgerDiskCacheDb(dirPath, dbPath string) (
+	badgerDiskCacheDb, error) {
+	err := os.MkdirAll(dbPath, 0700)
+	if err != nil {
+		return badgerDiskCacheDb{}, errors.WithStack(err)
+	}
+	opts := badger.DefaultOptions
+	opts.Dir = dbPath
+	opts.ValueDir = dbPath
+	// The defaults load the tables into RAM and map the value log
+	// into memory, but the cache can be many GB, and most of it is
+	// rarely read.
+	opts.TableLoadingMode = options.FileIO
+	opts.ValueLogLoadingMode = options.FileIO
+	opts.MaxTableSize = 16 << 20
+	opts.NumMemtables = 2
+	// Like leveldbOptions, don't sync every write; losing the end
+	// of the log in a crash only loses some cached blocks, so let
+	// badger truncate it when it next opens instead of failing.
+	opts.SyncWrites = false
+	opts.Truncate = true
+	db, err := badger.Open(opts)
+	if isBadgerLockedErr(err) {
+		return badgerDiskCacheDb{}, errors.WithStack(DiskCacheLockedError{
+			dirPath, readDiskCacheOwner(dirPath)})
+	} else if err != nil {
+		return badgerDiskCacheDb{}, err
+	}
+	return badgerDiskCacheDb{db}, nil
+}
+
+func badgerGet(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, leveldb.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (db badgerDiskCacheDb) Get(key []byte, _ *opt.ReadOptions) (
+	value []byte, err error) {
+	err = db.db.View(func(txn *badger.Txn) error {
+		value, err = badgerGet(txn, key)
+		return err
+	})
+	return value, err
+}
+
+func (db badgerDiskCacheDb) Has(key []byte, ro *opt.ReadOptions) (
+	bool, error) {
+	_, err := db.Get(key, ro)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (db badgerDiskCacheDb) Put(key, value []byte, _ *opt.WriteOptions) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+// badgerBatchReplay writes the operations of a leveldb.Batch to a
+// badger transaction.  Badger limits the size of a transaction, so
+// when a batch doesn't fit it's committed in more than one.
+type badgerBatchReplay struct {
+	db  *badger.DB
+	txn *badger.Txn
+	err error
+}
+
+func (r *badgerBatchReplay) apply(op func(txn *badger.Txn) error) {
+	if r.err != nil {
+		return
+	}
+	err := op(r.txn)
+	if err == badger.ErrTxnTooBig {
+		err = r.txn.Commit(nil)
+		if err != nil {
+			r.err = err
+			return
+		}
+		r.txn = r.db.NewTransaction(true)
+		err = op(r.txn)
+	}
+	r.err = err
+}
+
+func (r *badgerBatchReplay) Put(key, value []byte) {
+	r.apply(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (r *badgerBatchReplay) Delete(key []byte) {
+	r.apply(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+// Write implements the diskCacheDb interface for badgerDiskCacheDb.
+// Unlike with leveldb, a batch too big for one badger transaction
+// isn't written atomically, which the disk block cache tolerates as
+// it does a crash between writes to its separate databases.
+func (db badgerDiskCacheDb) Write(
+	batch *leveldb.Batch, _ *opt.WriteOptions) error {
+	r := &badgerBatchReplay{db: db.db, txn: db.db.NewTransaction(true)}
+	defer func() {
+		r.txn.Discard()
+	}()
+	err := batch.Replay(r)
+	if err != nil {
+		return err
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return r.txn.Commit(nil)
+}
+
+// badgerDiskCacheIterator is a diskCacheIterator over a read-only
+// badger transaction.
+type badgerDiskCacheIterator struct {
+	txn        *badger.Txn
+	iter       *badger.Iterator
+	start      []byte
+	limit      []byte
+	started    bool
+	key, value []byte
+	err        error
+}
+
+func (i *badgerDiskCacheIterator) Next() bool {
+	if i.err != nil {
+		return false
+	}
+	if i.started {
+		i.iter.Next()
+	} else {
+		i.iter.Seek(i.start)
+		i.started = true
+	}
+	if !i.iter.Valid() {
+		return false
+	}
+	item := i.iter.Item()
+	if i.limit != nil && bytes.Compare(item.Key(), i.limit) >= 0 {
+		return false
+	}
+	i.key = item.KeyCopy(nil)
+	i.value, i.err = item.ValueCopy(nil)
+	return i.err == nil
+}
+
+func (i *badgerDiskCacheIterator) Key() []byte {
+	return i.key
+}
+
+func (i *badgerDiskCacheIterator) Value() []byte {
+	return i.value
+}
+
+func (i *badgerDiskCacheIterator) Error() error {
+	return i.err
+}
+
+func (i *badgerDiskCacheIterator) Release() {
+	i.iter.Close()
+	i.txn.Discard()
+}
+
+func (db badgerDiskCacheDb) NewIterator(
+	slice *util.Range, _ *opt.ReadOptions) diskCacheIterator {
+	txn := db.db.NewTransaction(false)
+	iter := &badgerDiskCacheIterator{
+		txn:  txn,
+		iter: txn.NewIterator(badger.DefaultIteratorOptions),
+	}
+	if slice != nil {
+		iter.start = slice.Start
+		iter.limit = slice.Limit
+	}
+	return iter
+}
+
+// badgerDiskCacheSnapshot is a diskCacheSnapshot of a read-only
+// badger transaction.
+type badgerDiskCacheSnapshot struct {
+	txn *badger.Txn
+}
+
+func (s badgerDiskCacheSnapshot) Get(key []byte, _ *opt.ReadOptions) (
+	[]byte, error) {
+	return badgerGet(s.txn, key)
+}
+
+func (s badgerDiskCacheSnapshot) Release() {
+	s.txn.Discard()
+}
+
+func (db badgerDiskCacheDb) GetSnapshot() (diskCacheSnapshot, error) {
+	return badgerDiskCacheSnapshot{db.db.NewTransaction(false)}, nil
+}
+
+// CompactRange implements the diskCacheDb interface for
+// badgerDiskCacheDb.  Badger compacts its keys in the background, so
+// this only garbage collects the value log, whatever the range.
+func (db badgerDiskCacheDb) CompactRange(_ util.Range) error {
+	for {
+		err := db.db.RunValueLogGC(badgerValueLogGCDiscardRatio)
+		switch err {
+		case nil:
+		case badger.ErrNoRewrite, badger.ErrRejected:
+			// Nothing left worth rewriting, or another GC is
+			// already running.
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+func (db badgerDiskCacheDb) Close() error {
+	return db.db.Close()
+}
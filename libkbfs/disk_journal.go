@@ -5,7 +5,10 @@
 package libkbfs
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
@@ -52,7 +55,8 @@ func (o journalOrdinal) String() string {
 // dir/0...fff
 //
 // Each file in dir is named with an ordinal and contains a generic
-// serializable entry object. The files EARLIEST and LATEST point to
+// serializable entry object, after a checksum of it (see
+// journalEntryChecksumMagic). The files EARLIEST and LATEST point to
 // the earliest and latest valid ordinal, respectively.
 //
 // This class is not goroutine-safe; it assumes that all
@@ -241,8 +245,8 @@ func (j *diskJournal) clear() error {
 	j.latest = journalOrdinal(0)
 
 	// j.dir will be recreated on the next call to
-	// writeJournalEntry (via kbfscodec.SerializeToFile), which
-	// must always come before any ordinal write.
+	// writeJournalEntry, which must always come before any
+	// ordinal write.
 	return ioutil.RemoveAll(j.dir)
 }
 
@@ -284,13 +288,68 @@ func (j *diskJournal) removeEarliest() (empty bool, err error) {
 
 // The functions below are for reading and writing journal entries.
 
+// journalEntryChecksumMagic starts every journal entry file that has
+// a checksum.  It's followed by the big-endian CRC-32C of the encoded
+// entry, and then the encoded entry itself.  Entry files written
+// before checksums were added hold just the encoded entry, which
+// never starts with a zero byte, since entries are encoded as maps.
+var journalEntryChecksumMagic = []byte{0, 'k', 'j', 1}
+
+var journalEntryCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+const journalEntryHeaderLen = 8
+
+// checksumJournalEntry returns the contents of a journal entry file
+// for the given encoded entry.
+func checksumJournalEntry(buf []byte) []byte {
+	data := make([]byte, journalEntryHeaderLen, journalEntryHeaderLen+len(buf))
+	copy(data, journalEntryChecksumMagic)
+	binary.BigEndian.PutUint32(
+		data[len(journalEntryChecksumMagic):],
+		crc32.Checksum(buf, journalEntryCRCTable))
+	return append(data, buf...)
+}
+
+// verifyJournalEntry checks the checksum in the contents of a journal
+// entry file, if it has one, and returns the encoded entry.
+func verifyJournalEntry(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, journalEntryChecksumMagic[:1]) {
+		// Written before checksums were added.
+		return data, nil
+	}
+	if len(data) < journalEntryHeaderLen ||
+		!bytes.HasPrefix(data, journalEntryChecksumMagic) {
+		return nil, errors.New("truncated or unknown header")
+	}
+	expected := binary.BigEndian.Uint32(
+		data[len(journalEntryChecksumMagic):journalEntryHeaderLen])
+	buf := data[journalEntryHeaderLen:]
+	if actual := crc32.Checksum(buf, journalEntryCRCTable); actual != expected {
+		return nil, errors.Errorf(
+			"checksum mismatch: expected %08x, got %08x", expected, actual)
+	}
+	return buf, nil
+}
+
+// readJournalEntry returns the entry with the given ordinal.  It
+// returns a JournalEntryCorruptError if the entry fails its checksum
+// or can't be decoded, and may return an error for which
+// ioutil.IsNotExist() returns true.
 func (j diskJournal) readJournalEntry(o journalOrdinal) (interface{}, error) {
 	p := j.journalEntryPath(o)
-	entry := reflect.New(j.entryType)
-	err := kbfscodec.DeserializeFromFile(j.codec, p, entry)
+	data, err := ioutil.ReadFile(p)
 	if err != nil {
 		return nil, err
 	}
+	buf, err := verifyJournalEntry(data)
+	if err != nil {
+		return nil, errors.WithStack(JournalEntryCorruptError{p, err.Error()})
+	}
+	entry := reflect.New(j.entryType)
+	err = j.codec.Decode(buf, entry)
+	if err != nil {
+		return nil, errors.WithStack(JournalEntryCorruptError{p, err.Error()})
+	}
 
 	return entry.Elem().Interface(), nil
 }
@@ -303,7 +362,81 @@ func (j *diskJournal) writeJournalEntry(
 			j.entryType, entryType))
 	}
 
-	return kbfscodec.SerializeToFile(j.codec, entry, j.journalEntryPath(o))
+	buf, err := j.codec.Encode(entry)
+	if err != nil {
+		return err
+	}
+	// j.dir may have been removed by clear().
+	err = ioutil.MkdirAll(j.dir, 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(
+		j.journalEntryPath(o), checksumJournalEntry(buf), 0600)
+}
+
+// verify reads every entry in the journal, and returns the ordinals,
+// in order, of the ones that are corrupt or missing.
+func (j diskJournal) verify() (corrupt []journalOrdinal, err error) {
+	if j.empty() {
+		return nil, nil
+	}
+	for o := j.earliest; o <= j.latest; o++ {
+		_, err := j.readJournalEntry(o)
+		switch errors.Cause(err).(type) {
+		case nil:
+		case JournalEntryCorruptError:
+			corrupt = append(corrupt, o)
+		default:
+			if !ioutil.IsNotExist(err) {
+				return nil, err
+			}
+			corrupt = append(corrupt, o)
+		}
+	}
+	return corrupt, nil
+}
+
+// corruptTailStart returns the first ordinal of the run of the given
+// corrupt ordinals, in order, that ends the journal, if there is one.
+func (j diskJournal) corruptTailStart(corrupt []journalOrdinal) (
+	journalOrdinal, bool) {
+	if j.empty() || len(corrupt) == 0 || corrupt[len(corrupt)-1] != j.latest {
+		return 0, false
+	}
+	start := j.latest
+	for i := len(corrupt) - 2; i >= 0 && corrupt[i] == start-1; i-- {
+		start--
+	}
+	return start, true
+}
+
+// truncate removes the entries from o to the end of the journal. If
+// that's all of them, clear() is called instead.
+func (j *diskJournal) truncate(o journalOrdinal) error {
+	if j.empty() || o > j.latest {
+		return nil
+	}
+	if o <= j.earliest {
+		return j.clear()
+	}
+
+	oldLatest := j.latest
+	err := j.writeLatestOrdinal(o - 1)
+	if err != nil {
+		return err
+	}
+
+	// Garbage-collect the removed entries. If we crash here and
+	// leave some behind, they'll be overwritten by later appends,
+	// or cleaned up the next time clear() is called.
+	for i := o; i <= oldLatest; i++ {
+		err := ioutil.Remove(j.journalEntryPath(i))
+		if err != nil && !ioutil.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
 }
 
 // appendJournalEntry appends the given entry to the journal. If o is
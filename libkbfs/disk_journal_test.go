@@ -12,6 +12,7 @@ import (
 
 	"github.com/keybase/kbfs/ioutil"
 	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -184,3 +185,78 @@ func TestDiskJournalMove(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, testJournalEntry{1}, entry)
 }
+
+func TestDiskJournalChecksums(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "disk_journal")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+
+	codec := kbfscodec.NewMsgpack()
+	j, err := makeDiskJournal(
+		codec, tempdir, reflect.TypeOf(testJournalEntry{}))
+	require.NoError(t, err)
+
+	for i := 1; i <= 5; i++ {
+		_, err := j.appendJournalEntry(nil, testJournalEntry{i})
+		require.NoError(t, err)
+	}
+	corrupt, err := j.verify()
+	require.NoError(t, err)
+	require.Empty(t, corrupt)
+
+	// An entry written before checksums were added can still be
+	// read.
+	legacy := firstValidJournalOrdinal
+	err = kbfscodec.SerializeToFile(
+		codec, testJournalEntry{1}, j.journalEntryPath(legacy))
+	require.NoError(t, err)
+	entry, err := j.readJournalEntry(legacy)
+	require.NoError(t, err)
+	require.Equal(t, testJournalEntry{1}, entry)
+
+	// Flip a bit in one entry, and cut the last two short.
+	p := j.journalEntryPath(firstValidJournalOrdinal + 1)
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 1
+	err = ioutil.WriteFile(p, data, 0600)
+	require.NoError(t, err)
+	_, err = j.readJournalEntry(firstValidJournalOrdinal + 1)
+	_, isCorrupt := errors.Cause(err).(JournalEntryCorruptError)
+	require.True(t, isCorrupt, "Unexpected error: %+v", err)
+	for _, o := range []journalOrdinal{
+		firstValidJournalOrdinal + 3, firstValidJournalOrdinal + 4} {
+		data, err := ioutil.ReadFile(j.journalEntryPath(o))
+		require.NoError(t, err)
+		err = ioutil.WriteFile(j.journalEntryPath(o), data[:3], 0600)
+		require.NoError(t, err)
+	}
+
+	corrupt, err = j.verify()
+	require.NoError(t, err)
+	require.Equal(t, []journalOrdinal{firstValidJournalOrdinal + 1,
+		firstValidJournalOrdinal + 3, firstValidJournalOrdinal + 4}, corrupt)
+	start, ok := j.corruptTailStart(corrupt)
+	require.True(t, ok)
+	require.Equal(t, firstValidJournalOrdinal+3, start)
+
+	err = j.truncate(start)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), j.length())
+	latest, err := j.readLatestOrdinalFromDisk()
+	require.NoError(t, err)
+	require.Equal(t, firstValidJournalOrdinal+2, latest)
+	_, err = ioutil.Stat(j.journalEntryPath(start))
+	require.True(t, ioutil.IsNotExist(err))
+
+	// Appending picks up after the truncation.
+	o, err := j.appendJournalEntry(nil, testJournalEntry{6})
+	require.NoError(t, err)
+	require.Equal(t, start, o)
+	entry, err = j.readJournalEntry(o)
+	require.NoError(t, err)
+	require.Equal(t, testJournalEntry{6}, entry)
+}
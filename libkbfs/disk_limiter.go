@@ -1,31 +1,72 @@
 package libkbfs
 
 import (
+	"fmt"
+
 	"golang.org/x/net/context"
 )
 
+// diskLimitTrackerType says which disk block cache partition a disk
+// block cache limiter call accounts for.
+type diskLimitTrackerType int
+
+const (
+	// workingSetCacheLimitTrackerType is for blocks cached on demand
+	// from private TLFs and from public TLFs the current user can
+	// write to.
+	workingSetCacheLimitTrackerType diskLimitTrackerType = iota
+	// publicCacheLimitTrackerType is for blocks of other users'
+	// public TLFs, which get a smaller cap of their own so that
+	// browsing them doesn't evict the user's own blocks.
+	publicCacheLimitTrackerType
+	// syncCacheLimitTrackerType is for blocks of TLFs marked to be
+	// kept synced on this device, so that syncing them and
+	// caching the working set never compete for space.
+	syncCacheLimitTrackerType
+	// readSpillLimitTrackerType is for the blocks that reads too
+	// big for the in-memory block cache spill to local disk.
+	readSpillLimitTrackerType
+)
+
+func (t diskLimitTrackerType) String() string {
+	switch t {
+	case workingSetCacheLimitTrackerType:
+		return "workingSet"
+	case publicCacheLimitTrackerType:
+		return "public"
+	case syncCacheLimitTrackerType:
+		return "sync"
+	case readSpillLimitTrackerType:
+		return "readSpill"
+	}
+	return fmt.Sprintf("diskLimitTrackerType(%d)", int(t))
+}
+
 type diskBlockCacheLimiter interface {
 	// onDiskBlockCacheDelete is called by the disk block cache after deleting
 	// blocks from the cache.
-	onDiskBlockCacheDelete(ctx context.Context, blockBytes int64)
+	onDiskBlockCacheDelete(ctx context.Context, typ diskLimitTrackerType,
+		blockBytes int64)
 
 	// beforeDiskBlockCachePut is called by the disk block cache before putting
 	// a block into the cache. It returns the total number of available bytes.
-	beforeDiskBlockCachePut(ctx context.Context, blockBytes int64) (
-		availableBytes int64, err error)
+	beforeDiskBlockCachePut(ctx context.Context, typ diskLimitTrackerType,
+		blockBytes int64) (availableBytes int64, err error)
 
 	// afterDiskBlockCachePut is called by the disk block cache after putting
 	// a block into the cache. It returns how many bytes it acquired.
-	afterDiskBlockCachePut(ctx context.Context, blockBytes int64,
-		putData bool)
+	afterDiskBlockCachePut(ctx context.Context, typ diskLimitTrackerType,
+		blockBytes int64, putData bool)
 
 	// onDiskBlockCacheEnable is called when the disk block cache is enabled to
 	// begin accounting for its blocks.
-	onDiskBlockCacheEnable(ctx context.Context, cacheBytes int64)
+	onDiskBlockCacheEnable(ctx context.Context, typ diskLimitTrackerType,
+		cacheBytes int64)
 
 	// onDiskBlockCacheDisable is called when the disk block cache is disabled to
 	// stop accounting for its blocks.
-	onDiskBlockCacheDisable(ctx context.Context, cacheBytes int64)
+	onDiskBlockCacheDisable(ctx context.Context, typ diskLimitTrackerType,
+		cacheBytes int64)
 }
 
 // DiskLimiter is an interface for limiting disk usage.
@@ -68,6 +109,40 @@ type DiskLimiter interface {
 	// happen, but may as well let it go through.)
 	onBlocksDelete(ctx context.Context, blockBytes, blockFiles int64)
 
+	// beforeMDPut is called before putting an MD into a TLF
+	// journal. MDs are accounted separately from blocks, so that
+	// a block backlog never keeps small MD writes out of the
+	// journal. It doesn't block, and returns an error if the
+	// journal MDs are over their limit. It must not fail just
+	// because the disk is low on space, since removing files
+	// takes only MD writes. The available MD byte count must be
+	// returned, even if err is non-nil.
+	beforeMDPut(ctx context.Context) (availableBytes int64, err error)
+
+	// onJournalMDBytesChange is called whenever the bytes used
+	// by the MDs of a TLF journal change from oldBytes to
+	// newBytes, including when the journal is enabled (from 0)
+	// and disabled (to 0). Both must be >= 0.
+	onJournalMDBytesChange(ctx context.Context, oldBytes, newBytes int64)
+
+	// checkLowDiskSpace samples the free bytes on the disk right
+	// away, and returns them along with the number of free bytes
+	// below which journals stop growing, which is 0 if they never
+	// do.
+	checkLowDiskSpace(ctx context.Context) (
+		freeBytes, minFreeBytes int64, err error)
+
+	// lowDiskSpaceCh returns a channel that gets a value whenever
+	// a sample of the free bytes, including the ones taken on
+	// puts, first falls below the minimum.  It may return nil.
+	lowDiskSpaceCh() <-chan struct{}
+
+	// journalPressure returns a number between 0 and 1 for how
+	// close the journals are to their disk limits, where 1 means
+	// that journal writes are being held back as much as they
+	// can be.
+	journalPressure() float64
+
 	// getStatus returns an object that's marshallable into JSON
 	// for use in displaying status.
 	getStatus() interface{}
@@ -0,0 +1,328 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"golang.org/x/net/context"
+)
+
+const (
+	// The number of merged revisions kept on disk for each TLF: the
+	// head and a bit of recent history.
+	diskMDCacheRevisionsPerTLF int    = 10
+	mdDbFilename               string = "diskCacheMD.leveldb"
+)
+
+var (
+	diskMDCacheMDPrefix     = []byte{'m'}
+	diskMDCacheHandlePrefix = []byte{'h'}
+)
+
+// diskMDCacheConfig specifies the interfaces that a
+// DiskMDCacheStandard needs to perform its functions.
+type diskMDCacheConfig interface {
+	codecGetter
+	logMaker
+}
+
+// DiskMDCacheEntry is a merged MD object as stored in the disk MD
+// cache.  The private metadata is still encrypted, exactly as it was
+// on the mdserver.
+type DiskMDCacheEntry struct {
+	Version MetadataVer
+	// Buf is the encoded BareRootMetadata.
+	Buf []byte
+	// WKB and RKB are only set for MDs that keep their key bundles
+	// separately, i.e. since SegregatedKeyBundlesVer.
+	WKB *TLFWriterKeyBundleV3 `codec:",omitempty"`
+	RKB *TLFReaderKeyBundleV3 `codec:",omitempty"`
+	// WriterVerifyingKey is the key that was verified to belong to
+	// the last writer when the MD was first fetched.
+	WriterVerifyingKey kbfscrypto.VerifyingKey
+	// LocalTimestamp is in Unix nanoseconds.
+	LocalTimestamp int64
+	// SigInfo and WriterSigInfo are the signatures the MD had on
+	// the mdserver, so that it can be verified again when it's used
+	// without asking the mdserver first.
+	SigInfo       kbfscrypto.SignatureInfo
+	WriterSigInfo kbfscrypto.SignatureInfo
+}
+
+// makeDiskMDCacheEntry packages up the given MD object and its
+// signatures for storage in the disk MD cache.
+func makeDiskMDCacheEntry(codec kbfscodec.Codec, irmd ImmutableRootMetadata,
+	sigInfo, writerSigInfo kbfscrypto.SignatureInfo) (
+	DiskMDCacheEntry, error) {
+	buf, err := codec.Encode(irmd.bareMd)
+	if err != nil {
+		return DiskMDCacheEntry{}, err
+	}
+	entry := DiskMDCacheEntry{
+		Version:            irmd.Version(),
+		Buf:                buf,
+		WriterVerifyingKey: irmd.LastModifyingWriterVerifyingKey(),
+		LocalTimestamp:     irmd.LocalTimestamp().UnixNano(),
+		SigInfo:            sigInfo,
+		WriterSigInfo:      writerSigInfo,
+	}
+	if extra, ok := irmd.Extra().(*ExtraMetadataV3); ok {
+		wkb := extra.GetWriterKeyBundle()
+		rkb := extra.GetReaderKeyBundle()
+		entry.WKB = &wkb
+		entry.RKB = &rkb
+	}
+	return entry, nil
+}
+
+// DiskMDCacheStandard is the standard implementation for
+// DiskMDCache.  It keeps a bounded number of recent merged revisions
+// for each TLF, along with a mapping from canonical TLF paths to TLF
+// IDs, in a single leveldb.
+type DiskMDCacheStandard struct {
+	config diskMDCacheConfig
+	log    logger.Logger
+
+	// protects mdDb, and makes each Put atomic with respect to its
+	// eviction of older revisions.
+	lock  sync.RWMutex
+	mdDb  *leveldb.DB
+	mdStr storage.Storage
+}
+
+var _ DiskMDCache = (*DiskMDCacheStandard)(nil)
+
+func diskMDCacheRootFromStorageRoot(storageRoot string) string {
+	return filepath.Join(storageRoot, "kbfs_md_cache")
+}
+
+// newDiskMDCacheStandardFromStorage creates a new
+// *DiskMDCacheStandard with the passed-in storage.Storage as its
+// storage layer.
+func newDiskMDCacheStandardFromStorage(config diskMDCacheConfig,
+	mdStorage storage.Storage) (*DiskMDCacheStandard, error) {
+	mdDb, err := openLevelDB(mdStorage)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskMDCacheStandard{
+		config: config,
+		log:    config.MakeLogger("DMC"),
+		mdDb:   mdDb,
+	}, nil
+}
+
+// newDiskMDCacheStandard creates a new *DiskMDCacheStandard with a
+// specified directory on the filesystem as storage.  Like the disk
+// block cache, only one process at a time may have a given directory
+// open.
+func newDiskMDCacheStandard(config diskMDCacheConfig, dirPath string) (
+	cache *DiskMDCacheStandard, err error) {
+	versionPath, err := getVersionedPathForDiskCache(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	mdDbPath := filepath.Join(versionPath, mdDbFilename)
+	mdStorage, err := openDiskCacheStorage(dirPath, mdDbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			mdStorage.Close()
+		}
+	}()
+	cache, err = newDiskMDCacheStandardFromStorage(config, mdStorage)
+	if err != nil {
+		return nil, err
+	}
+	cache.mdStr = mdStorage
+	return cache, nil
+}
+
+func (*DiskMDCacheStandard) tlfPrefix(tlfID tlf.ID) []byte {
+	prefix := append([]byte{}, diskMDCacheMDPrefix...)
+	return append(prefix, tlfID.Bytes()...)
+}
+
+// mdKey generates a cache key that sorts by revision within a TLF.
+func (cache *DiskMDCacheStandard) mdKey(
+	tlfID tlf.ID, rev MetadataRevision) []byte {
+	var revBytes [8]byte
+	binary.BigEndian.PutUint64(revBytes[:], uint64(rev))
+	return append(cache.tlfPrefix(tlfID), revBytes[:]...)
+}
+
+func (*DiskMDCacheStandard) handleKey(p string) []byte {
+	key := append([]byte{}, diskMDCacheHandlePrefix...)
+	return append(key, p...)
+}
+
+func (cache *DiskMDCacheStandard) checkOpenLocked(op string) error {
+	if cache.mdDb == nil {
+		return errors.WithStack(DiskCacheClosedError{op})
+	}
+	return nil
+}
+
+func (cache *DiskMDCacheStandard) decodeEntry(buf []byte) (
+	DiskMDCacheEntry, error) {
+	var entry DiskMDCacheEntry
+	err := cache.config.Codec().Decode(buf, &entry)
+	if err != nil {
+		return DiskMDCacheEntry{}, err
+	}
+	return entry, nil
+}
+
+// Get implements the DiskMDCache interface for DiskMDCacheStandard.
+func (cache *DiskMDCacheStandard) Get(ctx context.Context, tlfID tlf.ID,
+	rev MetadataRevision) (DiskMDCacheEntry, error) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	if err := cache.checkOpenLocked("Get"); err != nil {
+		return DiskMDCacheEntry{}, err
+	}
+	buf, err := cache.mdDb.Get(cache.mdKey(tlfID, rev), nil)
+	if err == leveldb.ErrNotFound {
+		return DiskMDCacheEntry{},
+			errors.WithStack(NoSuchMDError{tlfID, rev, NullBranchID})
+	} else if err != nil {
+		return DiskMDCacheEntry{}, err
+	}
+	return cache.decodeEntry(buf)
+}
+
+// GetHead implements the DiskMDCache interface for DiskMDCacheStandard.
+func (cache *DiskMDCacheStandard) GetHead(ctx context.Context,
+	tlfID tlf.ID) (DiskMDCacheEntry, error) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	if err := cache.checkOpenLocked("GetHead"); err != nil {
+		return DiskMDCacheEntry{}, err
+	}
+	iter := cache.mdDb.NewIterator(util.BytesPrefix(cache.tlfPrefix(tlfID)), nil)
+	defer iter.Release()
+	if !iter.Last() {
+		if err := iter.Error(); err != nil {
+			return DiskMDCacheEntry{}, err
+		}
+		return DiskMDCacheEntry{}, errors.WithStack(
+			NoSuchMDError{tlfID, MetadataRevisionUninitialized, NullBranchID})
+	}
+	return cache.decodeEntry(iter.Value())
+}
+
+// GetTlfID implements the DiskMDCache interface for DiskMDCacheStandard.
+func (cache *DiskMDCacheStandard) GetTlfID(
+	ctx context.Context, p string) (tlf.ID, error) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	if err := cache.checkOpenLocked("GetTlfID"); err != nil {
+		return tlf.NullID, err
+	}
+	buf, err := cache.mdDb.Get(cache.handleKey(p), nil)
+	if err == leveldb.ErrNotFound {
+		return tlf.NullID, errors.WithStack(NoSuchTlfIDError{p})
+	} else if err != nil {
+		return tlf.NullID, err
+	}
+	var id tlf.ID
+	if err := id.UnmarshalBinary(buf); err != nil {
+		return tlf.NullID, err
+	}
+	return id, nil
+}
+
+// Put implements the DiskMDCache interface for DiskMDCacheStandard.
+func (cache *DiskMDCacheStandard) Put(
+	ctx context.Context, p string, irmd ImmutableRootMetadata,
+	sigInfo, writerSigInfo kbfscrypto.SignatureInfo) error {
+	if irmd.MergedStatus() != Merged {
+		return errors.Errorf("Can't cache unmerged MD revision %d for %s",
+			irmd.Revision(), irmd.TlfID())
+	}
+	entry, err := makeDiskMDCacheEntry(
+		cache.config.Codec(), irmd, sigInfo, writerSigInfo)
+	if err != nil {
+		return err
+	}
+	buf, err := cache.config.Codec().Encode(entry)
+	if err != nil {
+		return err
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if err := cache.checkOpenLocked("Put"); err != nil {
+		return err
+	}
+	tlfID := irmd.TlfID()
+	batch := new(leveldb.Batch)
+	if p != "" {
+		batch.Put(cache.handleKey(p), tlfID.Bytes())
+	}
+
+	// Keep only the most recent revisions, counting the one being
+	// put.  Keys sort by revision, so the oldest come first.
+	newKey := cache.mdKey(tlfID, irmd.Revision())
+	var keys []string
+	iter := cache.mdDb.NewIterator(util.BytesPrefix(cache.tlfPrefix(tlfID)), nil)
+	for iter.Next() {
+		if key := string(iter.Key()); key != string(newKey) {
+			keys = append(keys, key)
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	keys = append(keys, string(newKey))
+	sort.Strings(keys)
+	putNew := true
+	for len(keys) > diskMDCacheRevisionsPerTLF {
+		if keys[0] == string(newKey) {
+			// Older than everything we're keeping.
+			putNew = false
+		} else {
+			batch.Delete([]byte(keys[0]))
+		}
+		keys = keys[1:]
+	}
+	if putNew {
+		batch.Put(newKey, buf)
+	}
+	return cache.mdDb.Write(batch, nil)
+}
+
+// Shutdown implements the DiskMDCache interface for DiskMDCacheStandard.
+func (cache *DiskMDCacheStandard) Shutdown(ctx context.Context) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.mdDb == nil {
+		return
+	}
+	if err := cache.mdDb.Close(); err != nil {
+		cache.log.CWarningf(ctx, "Error closing mdDb: %+v", err)
+	}
+	cache.mdDb = nil
+	if cache.mdStr != nil {
+		if err := cache.mdStr.Close(); err != nil {
+			cache.log.CWarningf(ctx, "Error closing storage: %+v", err)
+		}
+	}
+}
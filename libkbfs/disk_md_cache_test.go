@@ -0,0 +1,134 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"golang.org/x/net/context"
+)
+
+type testDiskMDCacheConfig struct {
+	codecGetter
+	logMaker
+}
+
+func newTestDiskMDCache(t *testing.T) *DiskMDCacheStandard {
+	config := testDiskMDCacheConfig{
+		newTestCodecGetter(),
+		newTestLogMaker(t),
+	}
+	cache, err := newDiskMDCacheStandardFromStorage(
+		config, storage.NewMemStorage())
+	require.NoError(t, err)
+	return cache
+}
+
+func makeTestDiskMDCacheMD(t *testing.T, id tlf.ID,
+	rev MetadataRevision) ImmutableRootMetadata {
+	h := testMdcacheMakeHandle(t, 1)
+	rmd, err := makeInitialRootMetadata(defaultClientMetadataVer, id, h)
+	require.NoError(t, err)
+	rmd.SetRevision(rev)
+
+	signingKey := kbfscrypto.MakeFakeSigningKeyOrBust("fake signing key")
+	err = rmd.bareMd.SignWriterMetadataInternally(context.Background(),
+		kbfscodec.NewMsgpack(),
+		kbfscrypto.SigningKeySigner{Key: signingKey})
+	require.NoError(t, err)
+
+	return MakeImmutableRootMetadata(rmd, signingKey.GetVerifyingKey(),
+		fakeMdID(byte(rev)), time.Unix(0, int64(rev)))
+}
+
+func TestDiskMDCachePutAndGet(t *testing.T) {
+	t.Log("Test that basic disk MD cache Put and Get operations work.")
+	cache := newTestDiskMDCache(t)
+	ctx := context.Background()
+	defer cache.Shutdown(ctx)
+
+	id := tlf.FakeID(1, false)
+	irmd := makeTestDiskMDCacheMD(t, id, 5)
+	signingKey := kbfscrypto.MakeFakeSigningKeyOrBust("fake signing key")
+	sigInfo := signingKey.Sign([]byte("root"))
+	writerSigInfo := signingKey.Sign([]byte("writer"))
+	err := cache.Put(ctx, "/keybase/private/fake_user_1", irmd,
+		sigInfo, writerSigInfo)
+	require.NoError(t, err)
+
+	entry, err := cache.Get(ctx, id, 5)
+	require.NoError(t, err)
+	require.Equal(t, irmd.Version(), entry.Version)
+	require.Equal(t, irmd.LastModifyingWriterVerifyingKey(),
+		entry.WriterVerifyingKey)
+	require.Equal(t, irmd.LocalTimestamp().UnixNano(), entry.LocalTimestamp)
+	require.Equal(t, sigInfo, entry.SigInfo)
+	require.Equal(t, writerSigInfo, entry.WriterSigInfo)
+	brmd, err := DecodeRootMetadata(kbfscodec.NewMsgpack(), id,
+		entry.Version, entry.Version, entry.Buf)
+	require.NoError(t, err)
+	require.Equal(t, irmd.Revision(), brmd.RevisionNumber())
+
+	gotID, err := cache.GetTlfID(ctx, "/keybase/private/fake_user_1")
+	require.NoError(t, err)
+	require.Equal(t, id, gotID)
+
+	_, err = cache.Get(ctx, id, 4)
+	require.IsType(t, NoSuchMDError{}, errors.Cause(err))
+	_, err = cache.GetTlfID(ctx, "/keybase/private/fake_user_2")
+	require.IsType(t, NoSuchTlfIDError{}, errors.Cause(err))
+}
+
+func TestDiskMDCacheHeadAndEviction(t *testing.T) {
+	t.Log("Test that only the most recent revisions of a TLF are kept.")
+	cache := newTestDiskMDCache(t)
+	ctx := context.Background()
+	defer cache.Shutdown(ctx)
+
+	id := tlf.FakeID(1, false)
+	otherID := tlf.FakeID(2, false)
+	err := cache.Put(ctx, "", makeTestDiskMDCacheMD(t, otherID, 1),
+		kbfscrypto.SignatureInfo{}, kbfscrypto.SignatureInfo{})
+	require.NoError(t, err)
+
+	numRevs := MetadataRevision(diskMDCacheRevisionsPerTLF + 5)
+	for rev := MetadataRevision(1); rev <= numRevs; rev++ {
+		err := cache.Put(ctx, "", makeTestDiskMDCacheMD(t, id, rev),
+			kbfscrypto.SignatureInfo{}, kbfscrypto.SignatureInfo{})
+		require.NoError(t, err)
+	}
+
+	entry, err := cache.GetHead(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, numRevs.Number(), entry.LocalTimestamp)
+
+	firstKept := numRevs - MetadataRevision(diskMDCacheRevisionsPerTLF) + 1
+	_, err = cache.Get(ctx, id, firstKept-1)
+	require.IsType(t, NoSuchMDError{}, errors.Cause(err))
+	_, err = cache.Get(ctx, id, firstKept)
+	require.NoError(t, err)
+
+	t.Log("Putting an old revision doesn't evict newer ones.")
+	err = cache.Put(ctx, "", makeTestDiskMDCacheMD(t, id, 1),
+		kbfscrypto.SignatureInfo{}, kbfscrypto.SignatureInfo{})
+	require.NoError(t, err)
+	_, err = cache.Get(ctx, id, 1)
+	require.IsType(t, NoSuchMDError{}, errors.Cause(err))
+	_, err = cache.Get(ctx, id, firstKept)
+	require.NoError(t, err)
+
+	t.Log("Other TLFs are unaffected.")
+	_, err = cache.Get(ctx, otherID, 1)
+	require.NoError(t, err)
+	_, err = cache.GetHead(ctx, tlf.FakeID(3, false))
+	require.IsType(t, NoSuchMDError{}, errors.Cause(err))
+}
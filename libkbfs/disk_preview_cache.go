@@ -0,0 +1,299 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"golang.org/x/net/context"
+)
+
+const (
+	// defaultDiskPreviewCacheBytes bounds the total size of the
+	// previews kept on disk.
+	defaultDiskPreviewCacheBytes int64  = 100 << 20
+	previewDbFilename            string = "diskCachePreviews.leveldb"
+)
+
+// diskPreviewCacheConfig specifies the interfaces that a
+// DiskPreviewCacheStandard needs to perform its functions.
+type diskPreviewCacheConfig interface {
+	codecGetter
+	logMaker
+	clockGetter
+}
+
+// DiskPreviewCacheEntry is a preview generated from the contents of
+// a file, as stored in the disk preview cache.
+type DiskPreviewCacheEntry struct {
+	MimeType string
+	Data     []byte
+	// LastUsed is in Unix nanoseconds.  The least recently used
+	// previews are evicted first.
+	LastUsed int64
+}
+
+// DiskPreviewCacheStandard is the standard implementation for
+// DiskPreviewCache.  It keeps previews in a single leveldb, keyed by
+// the ID of the top block of the file they were generated from, so
+// that a preview goes stale as soon as its file changes, and evicts
+// the least recently used ones once they take up too much space.
+type DiskPreviewCacheStandard struct {
+	config   diskPreviewCacheConfig
+	log      logger.Logger
+	maxBytes int64
+
+	// protects previewDb and totalBytes, and makes each Put
+	// atomic with respect to its evictions.
+	lock       sync.Mutex
+	previewDb  *leveldb.DB
+	previewStr storage.Storage
+	// totalBytes is the encoded size of all the cached entries.
+	totalBytes int64
+}
+
+var _ DiskPreviewCache = (*DiskPreviewCacheStandard)(nil)
+
+func diskPreviewCacheRootFromStorageRoot(storageRoot string) string {
+	return filepath.Join(storageRoot, "kbfs_preview_cache")
+}
+
+// newDiskPreviewCacheStandardFromStorage creates a new
+// *DiskPreviewCacheStandard with the passed-in storage.Storage as its
+// storage layer, holding at most maxBytes of previews.
+func newDiskPreviewCacheStandardFromStorage(config diskPreviewCacheConfig,
+	previewStorage storage.Storage, maxBytes int64) (
+	*DiskPreviewCacheStandard, error) {
+	previewDb, err := openLevelDB(previewStorage)
+	if err != nil {
+		return nil, err
+	}
+	var totalBytes int64
+	iter := previewDb.NewIterator(nil, nil)
+	for iter.Next() {
+		totalBytes += int64(len(iter.Value()))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		previewDb.Close()
+		return nil, err
+	}
+	return &DiskPreviewCacheStandard{
+		config:     config,
+		log:        config.MakeLogger("DPC"),
+		maxBytes:   maxBytes,
+		previewDb:  previewDb,
+		totalBytes: totalBytes,
+	}, nil
+}
+
+// newDiskPreviewCacheStandard creates a new *DiskPreviewCacheStandard
+// with a specified directory on the filesystem as storage.  Like the
+// other disk caches, only one process at a time may have a given
+// directory open.
+func newDiskPreviewCacheStandard(config diskPreviewCacheConfig,
+	dirPath string) (cache *DiskPreviewCacheStandard, err error) {
+	versionPath, err := getVersionedPathForDiskCache(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	previewDbPath := filepath.Join(versionPath, previewDbFilename)
+	previewStorage, err := openDiskCacheStorage(dirPath, previewDbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			previewStorage.Close()
+		}
+	}()
+	cache, err = newDiskPreviewCacheStandardFromStorage(
+		config, previewStorage, defaultDiskPreviewCacheBytes)
+	if err != nil {
+		return nil, err
+	}
+	cache.previewStr = previewStorage
+	return cache, nil
+}
+
+func (*DiskPreviewCacheStandard) previewKey(
+	tlfID tlf.ID, fileID kbfsblock.ID, kind string) []byte {
+	key := append([]byte{}, tlfID.Bytes()...)
+	key = append(key, fileID.Bytes()...)
+	return append(key, kind...)
+}
+
+func (cache *DiskPreviewCacheStandard) checkOpenLocked(op string) error {
+	if cache.previewDb == nil {
+		return errors.WithStack(DiskCacheClosedError{op})
+	}
+	return nil
+}
+
+// Get implements the DiskPreviewCache interface for
+// DiskPreviewCacheStandard.
+func (cache *DiskPreviewCacheStandard) Get(ctx context.Context,
+	tlfID tlf.ID, fileID kbfsblock.ID, kind string) (
+	DiskPreviewCacheEntry, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if err := cache.checkOpenLocked("Get"); err != nil {
+		return DiskPreviewCacheEntry{}, err
+	}
+	key := cache.previewKey(tlfID, fileID, kind)
+	buf, err := cache.previewDb.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return DiskPreviewCacheEntry{},
+			errors.WithStack(NoSuchPreviewError{fileID, kind})
+	} else if err != nil {
+		return DiskPreviewCacheEntry{}, err
+	}
+	var entry DiskPreviewCacheEntry
+	if err := cache.config.Codec().Decode(buf, &entry); err != nil {
+		return DiskPreviewCacheEntry{}, err
+	}
+
+	// Failing to record the use only makes eviction less
+	// accurate, so just log it.
+	entry.LastUsed = cache.config.Clock().Now().UnixNano()
+	newBuf, err := cache.config.Codec().Encode(entry)
+	if err == nil {
+		err = cache.previewDb.Put(key, newBuf, nil)
+	}
+	if err != nil {
+		cache.log.CDebugf(ctx, "Couldn't update preview use time: %+v", err)
+	} else {
+		cache.totalBytes += int64(len(newBuf) - len(buf))
+	}
+	return entry, nil
+}
+
+type previewUse struct {
+	key      []byte
+	size     int64
+	lastUsed int64
+}
+
+type previewUsesByTime []previewUse
+
+func (p previewUsesByTime) Len() int           { return len(p) }
+func (p previewUsesByTime) Less(i, j int) bool { return p[i].lastUsed < p[j].lastUsed }
+func (p previewUsesByTime) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// evictLocked deletes the least recently used previews, other than
+// the one at keepKey, until at most maxBytes are left.
+func (cache *DiskPreviewCacheStandard) evictLocked(ctx context.Context,
+	batch *leveldb.Batch, keepKey []byte, maxBytes int64) error {
+	var uses previewUsesByTime
+	iter := cache.previewDb.NewIterator(nil, nil)
+	for iter.Next() {
+		if string(iter.Key()) == string(keepKey) {
+			continue
+		}
+		var entry DiskPreviewCacheEntry
+		if err := cache.config.Codec().Decode(iter.Value(), &entry); err != nil {
+			iter.Release()
+			return err
+		}
+		uses = append(uses, previewUse{
+			append([]byte{}, iter.Key()...), int64(len(iter.Value())),
+			entry.LastUsed})
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	sort.Sort(uses)
+
+	numEvicted := 0
+	for _, u := range uses {
+		if cache.totalBytes <= maxBytes {
+			break
+		}
+		batch.Delete(u.key)
+		cache.totalBytes -= u.size
+		numEvicted++
+	}
+	cache.log.CDebugf(ctx, "Evicting %d previews", numEvicted)
+	return nil
+}
+
+// Put implements the DiskPreviewCache interface for
+// DiskPreviewCacheStandard.
+func (cache *DiskPreviewCacheStandard) Put(ctx context.Context,
+	tlfID tlf.ID, fileID kbfsblock.ID, kind string,
+	mimeType string, data []byte) (err error) {
+	entry := DiskPreviewCacheEntry{
+		MimeType: mimeType,
+		Data:     data,
+		LastUsed: cache.config.Clock().Now().UnixNano(),
+	}
+	buf, err := cache.config.Codec().Encode(entry)
+	if err != nil {
+		return err
+	}
+	if int64(len(buf)) > cache.maxBytes {
+		return errors.Errorf("Preview of %d bytes is too big to cache",
+			len(buf))
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if err := cache.checkOpenLocked("Put"); err != nil {
+		return err
+	}
+	key := cache.previewKey(tlfID, fileID, kind)
+	oldBuf, err := cache.previewDb.Get(key, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+
+	origTotal := cache.totalBytes
+	defer func() {
+		if err != nil {
+			cache.totalBytes = origTotal
+		}
+	}()
+	cache.totalBytes += int64(len(buf) - len(oldBuf))
+	batch := new(leveldb.Batch)
+	if cache.totalBytes > cache.maxBytes {
+		// Evict down to 90% of the limit, so that each Put near
+		// the limit doesn't have to scan the whole cache.
+		err = cache.evictLocked(ctx, batch, key, cache.maxBytes*9/10)
+		if err != nil {
+			return err
+		}
+	}
+	batch.Put(key, buf)
+	err = cache.previewDb.Write(batch, nil)
+	return err
+}
+
+// Shutdown implements the DiskPreviewCache interface for
+// DiskPreviewCacheStandard.
+func (cache *DiskPreviewCacheStandard) Shutdown(ctx context.Context) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.previewDb == nil {
+		return
+	}
+	if err := cache.previewDb.Close(); err != nil {
+		cache.log.CWarningf(ctx, "Error closing previewDb: %+v", err)
+	}
+	cache.previewDb = nil
+	if cache.previewStr != nil {
+		if err := cache.previewStr.Close(); err != nil {
+			cache.log.CWarningf(ctx, "Error closing storage: %+v", err)
+		}
+	}
+}
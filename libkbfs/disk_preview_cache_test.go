@@ -0,0 +1,103 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"golang.org/x/net/context"
+)
+
+type testDiskPreviewCacheConfig struct {
+	codecGetter
+	logMaker
+	*testClockGetter
+}
+
+func newTestDiskPreviewCache(t *testing.T, maxBytes int64) (
+	*DiskPreviewCacheStandard, *TestClock) {
+	config := testDiskPreviewCacheConfig{
+		newTestCodecGetter(),
+		newTestLogMaker(t),
+		newTestClockGetter(),
+	}
+	cache, err := newDiskPreviewCacheStandardFromStorage(
+		config, storage.NewMemStorage(), maxBytes)
+	require.NoError(t, err)
+	return cache, config.TestClock()
+}
+
+func TestDiskPreviewCachePutAndGet(t *testing.T) {
+	cache, _ := newTestDiskPreviewCache(t, defaultDiskPreviewCacheBytes)
+	ctx := context.Background()
+	defer cache.Shutdown(ctx)
+
+	id := tlf.FakeID(1, false)
+	fileID := kbfsblock.FakeID(1)
+	_, err := cache.Get(ctx, id, fileID, "jpeg-256")
+	require.IsType(t, NoSuchPreviewError{}, errors.Cause(err))
+
+	data := []byte{1, 2, 3, 4}
+	err = cache.Put(ctx, id, fileID, "jpeg-256", "image/jpeg", data)
+	require.NoError(t, err)
+	entry, err := cache.Get(ctx, id, fileID, "jpeg-256")
+	require.NoError(t, err)
+	require.Equal(t, "image/jpeg", entry.MimeType)
+	require.Equal(t, data, entry.Data)
+
+	// Other sizes and other versions of the file are separate.
+	_, err = cache.Get(ctx, id, fileID, "jpeg-512")
+	require.IsType(t, NoSuchPreviewError{}, errors.Cause(err))
+	_, err = cache.Get(ctx, id, kbfsblock.FakeID(2), "jpeg-256")
+	require.IsType(t, NoSuchPreviewError{}, errors.Cause(err))
+
+	cache.Shutdown(ctx)
+	_, err = cache.Get(ctx, id, fileID, "jpeg-256")
+	require.IsType(t, DiskCacheClosedError{}, errors.Cause(err))
+}
+
+func TestDiskPreviewCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	data := make([]byte, 100)
+	// Make room for three and a half previews, counting the encoding
+	// overhead.
+	buf, err := newTestCodecGetter().Codec().Encode(DiskPreviewCacheEntry{
+		MimeType: "image/jpeg",
+		Data:     data,
+		LastUsed: time.Now().UnixNano(),
+	})
+	require.NoError(t, err)
+	maxBytes := int64(len(buf)) * 7 / 2
+	cache, clock := newTestDiskPreviewCache(t, maxBytes)
+	ctx := context.Background()
+	defer cache.Shutdown(ctx)
+
+	id := tlf.FakeID(1, false)
+	for i := byte(1); i <= 3; i++ {
+		err = cache.Put(ctx, id, kbfsblock.FakeID(i), "k", "image/jpeg", data)
+		require.NoError(t, err)
+		clock.Add(time.Second)
+	}
+	// Using the first preview makes the second one the least
+	// recently used.
+	_, err = cache.Get(ctx, id, kbfsblock.FakeID(1), "k")
+	require.NoError(t, err)
+	clock.Add(time.Second)
+
+	err = cache.Put(ctx, id, kbfsblock.FakeID(4), "k", "image/jpeg", data)
+	require.NoError(t, err)
+	require.True(t, cache.totalBytes <= maxBytes)
+	_, err = cache.Get(ctx, id, kbfsblock.FakeID(2), "k")
+	require.IsType(t, NoSuchPreviewError{}, errors.Cause(err))
+	for _, i := range []byte{1, 4} {
+		_, err = cache.Get(ctx, id, kbfsblock.FakeID(i), "k")
+		require.NoError(t, err)
+	}
+}
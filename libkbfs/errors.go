@@ -6,12 +6,14 @@ package libkbfs
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
 )
 
 // ErrorFile is the name of the virtual file in KBFS that should
@@ -147,6 +149,28 @@ func (e RenameAcrossDirsError) Error() string {
 	return fmt.Sprintf("Cannot rename across directories")
 }
 
+// CrossTLFMoveMismatchError indicates that the copy made while
+// moving an entry across TLFs doesn't match the source, so the source
+// was left in place.
+type CrossTLFMoveMismatchError struct {
+	Path string
+}
+
+// Error implements the error interface for CrossTLFMoveMismatchError.
+func (e CrossTLFMoveMismatchError) Error() string {
+	return fmt.Sprintf("The copy of %s doesn't match the source", e.Path)
+}
+
+// LinkAcrossTLFsError indicates that the user tried to make a hard
+// link to a file in a different top-level folder, or a different
+// branch of the same one.
+type LinkAcrossTLFsError struct{}
+
+// Error implements the error interface for LinkAcrossTLFsError
+func (e LinkAcrossTLFsError) Error() string {
+	return "Cannot link across top-level folders"
+}
+
 // ErrorFileAccessError indicates that the user tried to perform an
 // operation on the ErrorFile that is not allowed.
 type ErrorFileAccessError struct {
@@ -1054,6 +1078,29 @@ func (e NoSuchTlfHandleError) Error() string {
 	return fmt.Sprintf("Folder handle for %s not found", e.ID)
 }
 
+// NoSuchTlfIDError indicates we were unable to resolve a folder
+// handle to a folder ID.
+type NoSuchTlfIDError struct {
+	Handle string
+}
+
+// Error implements the error interface for NoSuchTlfIDError
+func (e NoSuchTlfIDError) Error() string {
+	return fmt.Sprintf("Folder ID for %s not found", e.Handle)
+}
+
+// NoSuchPreviewError indicates that a disk preview cache doesn't
+// have the requested preview.
+type NoSuchPreviewError struct {
+	FileID kbfsblock.ID
+	Kind   string
+}
+
+// Error implements the error interface for NoSuchPreviewError
+func (e NoSuchPreviewError) Error() string {
+	return fmt.Sprintf("No %s preview for file %s", e.Kind, e.FileID)
+}
+
 // MetadataIsFinalError indicates that we tried to make or set a
 // successor to a finalized folder.
 type MetadataIsFinalError struct {
@@ -1257,3 +1304,379 @@ type DiskCacheClosedError struct {
 func (e DiskCacheClosedError) Error() string {
 	return fmt.Sprintf("Error performing %s operation: the disk cache is closed", e.op)
 }
+
+// DiskCacheLockedError indicates that the disk cache directory is
+// already in use by another process, possibly running as a different
+// user.
+type DiskCacheLockedError struct {
+	Path  string
+	Owner string
+}
+
+// Error implements the error interface for DiskCacheLockedError.
+func (e DiskCacheLockedError) Error() string {
+	return fmt.Sprintf("The disk cache at %s is in use by %s",
+		e.Path, e.Owner)
+}
+
+// StorageInUseError indicates that KBFS storage can't be migrated,
+// because KBFS is running on it, or didn't shut down cleanly the last
+// time it did.
+type StorageInUseError struct {
+	StorageRoot string
+}
+
+// Error implements the error interface for StorageInUseError.
+func (e StorageInUseError) Error() string {
+	return fmt.Sprintf("KBFS is running with storage root %s, or didn't "+
+		"shut down cleanly; start and stop it cleanly before migrating",
+		e.StorageRoot)
+}
+
+// StorageMigrationTargetError indicates that KBFS storage can't be
+// migrated, because there's already something where it would go.
+type StorageMigrationTargetError struct {
+	Path string
+}
+
+// Error implements the error interface for StorageMigrationTargetError.
+func (e StorageMigrationTargetError) Error() string {
+	return fmt.Sprintf("%s already exists and isn't empty", e.Path)
+}
+
+// TlfFrozenError indicates that the user tried to modify a TLF that
+// has been frozen (marked read-only).
+type TlfFrozenError struct {
+	Tlf CanonicalTlfName
+}
+
+// Error implements the error interface for TlfFrozenError.
+func (e TlfFrozenError) Error() string {
+	return fmt.Sprintf("Folder %s is frozen and can't be modified; "+
+		"unfreeze it first", e.Tlf)
+}
+
+// TlfAppendOnlyError indicates that the user tried to modify or
+// remove existing data in a TLF that only allows new files and
+// appends.
+type TlfAppendOnlyError struct {
+	Tlf CanonicalTlfName
+	Op  string
+}
+
+// Error implements the error interface for TlfAppendOnlyError.
+func (e TlfAppendOnlyError) Error() string {
+	return fmt.Sprintf("Folder %s is append-only, so %s isn't allowed",
+		e.Tlf, e.Op)
+}
+
+// TlfNotEmptyError indicates that the user tried to initialize a TLF
+// from a template, but the TLF already has entries in it.
+type TlfNotEmptyError struct {
+	Tlf CanonicalTlfName
+}
+
+// Error implements the error interface for TlfNotEmptyError.
+func (e TlfNotEmptyError) Error() string {
+	return fmt.Sprintf("Folder %s is not empty, so it can't be "+
+		"initialized from a template", e.Tlf)
+}
+
+// TlfTemplateTooBigError indicates that the user tried to initialize
+// a TLF from a template directory whose contents are too big to copy
+// in one batch.
+type TlfTemplateTooBigError struct {
+	Path     string
+	Size     uint64
+	MaxBytes uint64
+}
+
+// Error implements the error interface for TlfTemplateTooBigError.
+func (e TlfTemplateTooBigError) Error() string {
+	return fmt.Sprintf("Template %s holds at least %d bytes, more than "+
+		"the maximum of %d bytes", e.Path, e.Size, e.MaxBytes)
+}
+
+// DeviceRevokedError indicates that the device KBFS is running on has
+// been revoked from the logged-in user's account, so it can no longer
+// read private data or write anything.
+type DeviceRevokedError struct {
+	Name         libkb.NormalizedUsername
+	VerifyingKey kbfscrypto.VerifyingKey
+}
+
+// Error implements the error interface for DeviceRevokedError.
+func (e DeviceRevokedError) Error() string {
+	return fmt.Sprintf("This device (key %s) was revoked from %s's "+
+		"account; log in again on a provisioned device", e.VerifyingKey,
+		e.Name)
+}
+
+// InvalidMembershipChangeError indicates a proposed change to the
+// members of a TLF that can't be made, like removing a user who isn't
+// a member.
+type InvalidMembershipChangeError struct {
+	Tlf    CanonicalTlfName
+	Reason string
+}
+
+// Error implements the error interface for InvalidMembershipChangeError.
+func (e InvalidMembershipChangeError) Error() string {
+	return fmt.Sprintf("Invalid membership change for %s: %s",
+		e.Tlf, e.Reason)
+}
+
+// MerkleUnsupportedError indicates that the MD server doesn't keep a
+// Merkle tree of folder heads.
+type MerkleUnsupportedError struct{}
+
+// Error implements the error interface for MerkleUnsupportedError.
+func (e MerkleUnsupportedError) Error() string {
+	return "The MD server has no Merkle tree"
+}
+
+// MerkleRootTooOldError indicates that the latest Merkle root the MD
+// server gave is too old to show that a folder head is current.
+type MerkleRootTooOldError struct {
+	TreeID keybase1.MerkleTreeID
+	Time   time.Time
+}
+
+// Error implements the error interface for MerkleRootTooOldError.
+func (e MerkleRootTooOldError) Error() string {
+	return fmt.Sprintf("The latest %s Merkle root is from %s, which is "+
+		"too old", e.TreeID, e.Time)
+}
+
+// MerkleStaleHeadError indicates that the MD server gave a folder
+// head older than the revision in its own Merkle tree.
+type MerkleStaleHeadError struct {
+	TlfID        tlf.ID
+	Revision     MetadataRevision
+	TreeRevision MetadataRevision
+}
+
+// Error implements the error interface for MerkleStaleHeadError.
+func (e MerkleStaleHeadError) Error() string {
+	return fmt.Sprintf("The head of %s is revision %d, but the Merkle "+
+		"tree has revision %d", e.TlfID, e.Revision, e.TreeRevision)
+}
+
+// MerkleHashMismatchError indicates that the MD server gave a folder
+// head that's different from the one with the same revision in its
+// Merkle tree.
+type MerkleHashMismatchError struct {
+	TlfID    tlf.ID
+	Revision MetadataRevision
+}
+
+// Error implements the error interface for MerkleHashMismatchError.
+func (e MerkleHashMismatchError) Error() string {
+	return fmt.Sprintf("Revision %d of %s doesn't match the Merkle tree",
+		e.Revision, e.TlfID)
+}
+
+// BlockChallengeFailedError indicates that the block server couldn't
+// return an archived block intact.
+type BlockChallengeFailedError struct {
+	TlfID    tlf.ID
+	Ptr      BlockPointer
+	Revision MetadataRevision
+	Err      error
+}
+
+// Error implements the error interface for BlockChallengeFailedError.
+func (e BlockChallengeFailedError) Error() string {
+	return fmt.Sprintf("Block %v of %s, archived by revision %d, "+
+		"failed its retrievability challenge: %v",
+		e.Ptr, e.TlfID, e.Revision, e.Err)
+}
+
+// InvalidTLFArchiveError indicates that a TLF archive couldn't be
+// read.
+type InvalidTLFArchiveError struct {
+	Reason string
+}
+
+// Error implements the error interface for InvalidTLFArchiveError.
+func (e InvalidTLFArchiveError) Error() string {
+	return fmt.Sprintf("Invalid TLF archive: %s", e.Reason)
+}
+
+// RevisionNotPinnableError indicates that a revision can't be pinned,
+// because it doesn't exist yet or quota reclamation has already
+// deleted some of its blocks.
+type RevisionNotPinnableError struct {
+	TlfID          tlf.ID
+	Revision       MetadataRevision
+	Head           MetadataRevision
+	LastGCRevision MetadataRevision
+}
+
+// Error implements the error interface for RevisionNotPinnableError.
+func (e RevisionNotPinnableError) Error() string {
+	if e.Revision > e.Head {
+		return fmt.Sprintf("Can't pin revision %d of %s, since the head "+
+			"is revision %d", e.Revision, e.TlfID, e.Head)
+	}
+	return fmt.Sprintf("Can't pin revision %d of %s, since history up "+
+		"to revision %d has been reclaimed", e.Revision, e.TlfID,
+		e.LastGCRevision)
+}
+
+// InvalidSnapshotNameError indicates that a snapshot name is empty,
+// too long, or contains a slash or NUL.
+type InvalidSnapshotNameError struct {
+	Name string
+}
+
+// Error implements the error interface for InvalidSnapshotNameError.
+func (e InvalidSnapshotNameError) Error() string {
+	return fmt.Sprintf("Invalid snapshot name %q", e.Name)
+}
+
+// SnapshotExistsError indicates that a folder already has a snapshot
+// with the given name.
+type SnapshotExistsError struct {
+	Name     string
+	Revision MetadataRevision
+}
+
+// Error implements the error interface for SnapshotExistsError.
+func (e SnapshotExistsError) Error() string {
+	return fmt.Sprintf("Snapshot %q already exists, for revision %d",
+		e.Name, e.Revision)
+}
+
+// NoSuchSnapshotError indicates that a folder has no snapshot with
+// the given name.
+type NoSuchSnapshotError struct {
+	Name string
+}
+
+// Error implements the error interface for NoSuchSnapshotError.
+func (e NoSuchSnapshotError) Error() string {
+	return fmt.Sprintf("No snapshot named %q", e.Name)
+}
+
+// UnmergedStateNotSavedError indicates that the local view of a
+// TLF's unmerged updates couldn't be saved to local disk before they
+// were thrown away.
+type UnmergedStateNotSavedError struct {
+	TlfID tlf.ID
+	Err   error
+}
+
+// Error implements the error interface for UnmergedStateNotSavedError.
+func (e UnmergedStateNotSavedError) Error() string {
+	return fmt.Sprintf("Local changes to %s were discarded without "+
+		"being saved: %v", e.TlfID, e.Err)
+}
+
+// InvalidConflictStrategyError indicates that a conflict strategy
+// name isn't recognized.
+type InvalidConflictStrategyError struct {
+	Strategy string
+}
+
+// Error implements the error interface for InvalidConflictStrategyError.
+func (e InvalidConflictStrategyError) Error() string {
+	return fmt.Sprintf("Invalid conflict strategy %q; must be one of "+
+		"manual, ours, theirs or merge", e.Strategy)
+}
+
+// InvalidConflictStrategyPrefixError indicates that a conflict
+// strategy rule was given a path prefix that isn't within the TLF.
+type InvalidConflictStrategyPrefixError struct {
+	Prefix string
+}
+
+// Error implements the error interface for
+// InvalidConflictStrategyPrefixError.
+func (e InvalidConflictStrategyPrefixError) Error() string {
+	return fmt.Sprintf("Conflict strategy prefix %q is outside the folder",
+		e.Prefix)
+}
+
+// DiskSpaceLowError indicates that there's too little free space left
+// on the disk that holds the journals and the disk cache for them to
+// grow any more.
+type DiskSpaceLowError struct {
+	FreeBytes    int64
+	MinFreeBytes int64
+}
+
+// Error implements the error interface for DiskSpaceLowError.
+func (e DiskSpaceLowError) Error() string {
+	return fmt.Sprintf("Only %d bytes are free on disk, below the "+
+		"minimum of %d; free up some space to keep writing", e.FreeBytes,
+		e.MinFreeBytes)
+}
+
+// JournalMDLimitError indicates that the MDs in the journals take up
+// all the disk space allotted to them, so no more MDs can be put
+// until some are flushed.
+type JournalMDLimitError struct {
+	UsedBytes  int64
+	LimitBytes int64
+}
+
+// Error implements the error interface for JournalMDLimitError.
+func (e JournalMDLimitError) Error() string {
+	return fmt.Sprintf("The journal MDs take up %d bytes, at their "+
+		"limit of %d; wait for the journal to flush to keep writing",
+		e.UsedBytes, e.LimitBytes)
+}
+
+// OpPhaseTimeoutError indicates that a phase of an operation ran out
+// of its share of the operation's deadline.
+type OpPhaseTimeoutError struct {
+	Phase  string
+	Budget time.Duration
+}
+
+// Error implements the error interface for OpPhaseTimeoutError.
+func (e OpPhaseTimeoutError) Error() string {
+	return fmt.Sprintf("The %s phase ran out of its time budget of %s",
+		e.Phase, e.Budget)
+}
+
+// Cause makes errors.Cause return context.DeadlineExceeded for an
+// OpPhaseTimeoutError, so code that checks for timeouts still works.
+func (e OpPhaseTimeoutError) Cause() error {
+	return context.DeadlineExceeded
+}
+
+// NoDiskBlockCacheError indicates that an operation on the disk block
+// cache was requested, but there's no disk block cache open.
+type NoDiskBlockCacheError struct{}
+
+// Error implements the error interface for NoDiskBlockCacheError.
+func (e NoDiskBlockCacheError) Error() string {
+	return "The disk block cache isn't open; it may be in use by a " +
+		"running KBFS, or turned off"
+}
+
+// JournalEntryCorruptError indicates that a journal entry on disk
+// failed its checksum or couldn't be decoded, e.g. because of bit rot
+// or a write that was cut short by a crash.
+type JournalEntryCorruptError struct {
+	Path   string
+	Reason string
+}
+
+// Error implements the error interface for JournalEntryCorruptError.
+func (e JournalEntryCorruptError) Error() string {
+	return fmt.Sprintf("Journal entry %s is corrupt: %s", e.Path, e.Reason)
+}
+
+// NotSBSFolderError indicates that a TLF was expected to be shared
+// before signup, but it isn't waiting on any unresolved assertions.
+type NotSBSFolderError struct {
+	Tlf string
+}
+
+// Error implements the error interface for NotSBSFolderError.
+func (e NotSBSFolderError) Error() string {
+	return fmt.Sprintf("%s isn't waiting on any unresolved assertions", e.Tlf)
+}
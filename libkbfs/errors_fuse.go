@@ -139,3 +139,51 @@ func (e NoSuchFolderListError) Errno() fuse.Errno {
 func (e RenameAcrossDirsError) Errno() fuse.Errno {
 	return fuse.Errno(syscall.EXDEV)
 }
+
+var _ fuse.ErrorNumber = LinkAcrossTLFsError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// LinkAcrossTLFsError.
+func (e LinkAcrossTLFsError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EXDEV)
+}
+
+var _ fuse.ErrorNumber = TlfFrozenError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// TlfFrozenError.
+func (e TlfFrozenError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EROFS)
+}
+
+var _ fuse.ErrorNumber = TlfAppendOnlyError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// TlfAppendOnlyError.
+func (e TlfAppendOnlyError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EPERM)
+}
+
+var _ fuse.ErrorNumber = TlfNotEmptyError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// TlfNotEmptyError.
+func (e TlfNotEmptyError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.ENOTEMPTY)
+}
+
+var _ fuse.ErrorNumber = TlfTemplateTooBigError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// TlfTemplateTooBigError.
+func (e TlfTemplateTooBigError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EFBIG)
+}
+
+var _ fuse.ErrorNumber = DeviceRevokedError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// DeviceRevokedError.
+func (e DeviceRevokedError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EACCES)
+}
@@ -0,0 +1,487 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"strconv"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/pkg/errors"
+)
+
+// Status codes for errors returned to front ends through KBFSOps,
+// SimpleFS and the mounts.  Along with each code, the Name field of
+// the exported keybase1.Status holds a stable key that front ends
+// can use to look up a localized message, and the Fields hold the
+// parameters for that message.  Desc is always the untranslated
+// English string from Error().
+const (
+	// StatusCodeKBFSNameExists is the error code for NameExistsError.
+	StatusCodeKBFSNameExists = 2900
+	// StatusCodeKBFSNoSuchName is the error code for NoSuchNameError.
+	StatusCodeKBFSNoSuchName = 2901
+	// StatusCodeKBFSDirNotEmpty is the error code for DirNotEmptyError.
+	StatusCodeKBFSDirNotEmpty = 2902
+	// StatusCodeKBFSReadAccess is the error code for ReadAccessError.
+	StatusCodeKBFSReadAccess = 2903
+	// StatusCodeKBFSWriteAccess is the error code for
+	// WriteAccessError.
+	StatusCodeKBFSWriteAccess = 2904
+	// StatusCodeKBFSWriteUnsupported is the error code for
+	// WriteUnsupportedError.
+	StatusCodeKBFSWriteUnsupported = 2905
+	// StatusCodeKBFSRenameAcrossDirs is the error code for
+	// RenameAcrossDirsError.
+	StatusCodeKBFSRenameAcrossDirs = 2906
+	// StatusCodeKBFSOutdatedVersion is the error code for
+	// OutdatedVersionError, NewMetadataVersionError and
+	// NewDataVersionError.
+	StatusCodeKBFSOutdatedVersion = 2907
+	// StatusCodeKBFSUnverifiableTlfUpdate is the error code for
+	// UnverifiableTlfUpdateError.
+	StatusCodeKBFSUnverifiableTlfUpdate = 2908
+	// StatusCodeKBFSDisallowedPrefix is the error code for
+	// DisallowedPrefixError.
+	StatusCodeKBFSDisallowedPrefix = 2909
+	// StatusCodeKBFSFileTooBig is the error code for FileTooBigError
+	// and FileTooBigForCRError.
+	StatusCodeKBFSFileTooBig = 2910
+	// StatusCodeKBFSNameTooLong is the error code for
+	// NameTooLongError.
+	StatusCodeKBFSNameTooLong = 2911
+	// StatusCodeKBFSDirTooBig is the error code for DirTooBigError.
+	StatusCodeKBFSDirTooBig = 2912
+	// StatusCodeKBFSNoCurrentSession is the error code for
+	// NoCurrentSessionError.
+	StatusCodeKBFSNoCurrentSession = 2913
+	// StatusCodeKBFSNoSuchFolderList is the error code for
+	// NoSuchFolderListError.
+	StatusCodeKBFSNoSuchFolderList = 2914
+	// StatusCodeKBFSMetadataIsFinal is the error code for
+	// MetadataIsFinalError.
+	StatusCodeKBFSMetadataIsFinal = 2915
+	// StatusCodeKBFSOverQuota is the error code for OverQuotaWarning.
+	StatusCodeKBFSOverQuota = 2916
+	// StatusCodeKBFSNoSigChain is the error code for NoSigChainError.
+	StatusCodeKBFSNoSigChain = 2917
+	// StatusCodeKBFSNotFile is the error code for NotFileError.
+	StatusCodeKBFSNotFile = 2918
+	// StatusCodeKBFSNotDir is the error code for NotDirError.
+	StatusCodeKBFSNotDir = 2919
+	// StatusCodeKBFSDiskCacheLocked is the error code for
+	// DiskCacheLockedError.
+	StatusCodeKBFSDiskCacheLocked = 2920
+	// StatusCodeKBFSTlfFrozen is the error code for TlfFrozenError.
+	StatusCodeKBFSTlfFrozen = 2921
+	// StatusCodeKBFSTlfNotEmpty is the error code for
+	// TlfNotEmptyError.
+	StatusCodeKBFSTlfNotEmpty = 2922
+	// StatusCodeKBFSTlfTemplateTooBig is the error code for
+	// TlfTemplateTooBigError.
+	StatusCodeKBFSTlfTemplateTooBig = 2923
+	// StatusCodeKBFSTlfAppendOnly is the error code for
+	// TlfAppendOnlyError.
+	StatusCodeKBFSTlfAppendOnly = 2924
+	// StatusCodeKBFSDeviceRevoked is the error code for
+	// DeviceRevokedError.
+	StatusCodeKBFSDeviceRevoked = 2925
+)
+
+const (
+	errorParamName     = "name"
+	errorParamPath     = "path"
+	errorParamPrefix   = "prefix"
+	errorParamSize     = "size"
+	errorParamMaxBytes = "maxBytes"
+	errorParamPublic   = "public"
+	errorParamOwner    = "owner"
+	errorParamOp       = "op"
+)
+
+func statusFields(params ...string) []keybase1.StringKVPair {
+	fields := make([]keybase1.StringKVPair, 0, len(params)/2)
+	for i := 0; i+1 < len(params); i += 2 {
+		fields = append(fields, keybase1.StringKVPair{
+			Key:   params[i],
+			Value: params[i+1],
+		})
+	}
+	return fields
+}
+
+// ErrorToStatus converts err into a keybase1.Status suitable for
+// showing to the user.  If err, or the error it wraps, knows how to
+// export itself, the result carries its code, localization key and
+// parameters; otherwise a generic status holding just the error
+// string is returned.
+func ErrorToStatus(err error) keybase1.Status {
+	if s, ok := errors.Cause(err).(keybase1.ToStatusAble); ok {
+		return s.ToStatus()
+	}
+	return keybase1.Status{
+		Name: "GENERIC",
+		Code: int(keybase1.StatusCode_SCGeneric),
+		Desc: err.Error(),
+	}
+}
+
+// ToStatusAbleError returns the error wrapped by err if that error
+// can export itself as a keybase1.Status, so that RPC layers that
+// check for keybase1.ToStatusAble see it.  Otherwise err is
+// returned unchanged.
+func ToStatusAbleError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if cause := errors.Cause(err); cause != err {
+		if _, ok := cause.(keybase1.ToStatusAble); ok {
+			return cause
+		}
+	}
+	return err
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// NameExistsError.
+func (e NameExistsError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code:   StatusCodeKBFSNameExists,
+		Name:   "KBFS_NAME_EXISTS",
+		Desc:   e.Error(),
+		Fields: statusFields(errorParamName, e.Name),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// NoSuchNameError.
+func (e NoSuchNameError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code:   StatusCodeKBFSNoSuchName,
+		Name:   "KBFS_NO_SUCH_NAME",
+		Desc:   e.Error(),
+		Fields: statusFields(errorParamName, e.Name),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// DirNotEmptyError.
+func (e DirNotEmptyError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code:   StatusCodeKBFSDirNotEmpty,
+		Name:   "KBFS_DIR_NOT_EMPTY",
+		Desc:   e.Error(),
+		Fields: statusFields(errorParamName, e.Name),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// ReadAccessError.
+func (e ReadAccessError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSReadAccess,
+		Name: "KBFS_READ_ACCESS",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamUsername, e.User.String(),
+			errorParamTlf, string(e.Tlf),
+			errorParamPublic, strconv.FormatBool(e.Public),
+			errorParamPath, e.Filename),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// WriteAccessError.
+func (e WriteAccessError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSWriteAccess,
+		Name: "KBFS_WRITE_ACCESS",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamUsername, e.User.String(),
+			errorParamTlf, string(e.Tlf),
+			errorParamPublic, strconv.FormatBool(e.Public),
+			errorParamPath, e.Filename),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// WriteUnsupportedError.
+func (e WriteUnsupportedError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code:   StatusCodeKBFSWriteUnsupported,
+		Name:   "KBFS_WRITE_UNSUPPORTED",
+		Desc:   e.Error(),
+		Fields: statusFields(errorParamPath, e.Filename),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// RenameAcrossDirsError.
+func (e RenameAcrossDirsError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSRenameAcrossDirs,
+		Name: "KBFS_RENAME_ACROSS_DIRS",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamApplicationExecPath, e.ApplicationExecPath),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// OutdatedVersionError.
+func (e OutdatedVersionError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSOutdatedVersion,
+		Name: "KBFS_OUTDATED_VERSION",
+		Desc: e.Error(),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// NewMetadataVersionError.  To the user this is the same as an
+// OutdatedVersionError.
+func (e NewMetadataVersionError) ToStatus() keybase1.Status {
+	return OutdatedVersionError{}.ToStatus()
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// NewDataVersionError.  To the user this is the same as an
+// OutdatedVersionError.
+func (e NewDataVersionError) ToStatus() keybase1.Status {
+	return OutdatedVersionError{}.ToStatus()
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// UnverifiableTlfUpdateError.
+func (e UnverifiableTlfUpdateError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSUnverifiableTlfUpdate,
+		Name: "KBFS_UNVERIFIABLE_TLF_UPDATE",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamTlf, e.Tlf,
+			errorParamUsername, e.User.String()),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// DisallowedPrefixError.
+func (e DisallowedPrefixError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSDisallowedPrefix,
+		Name: "KBFS_DISALLOWED_PREFIX",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamName, e.name,
+			errorParamPrefix, e.prefix),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// FileTooBigError.
+func (e FileTooBigError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSFileTooBig,
+		Name: "KBFS_FILE_TOO_BIG",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamPath, e.p.String(),
+			errorParamSize, strconv.FormatInt(e.size, 10),
+			errorParamMaxBytes, strconv.FormatUint(e.maxAllowedBytes, 10)),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// FileTooBigForCRError.
+func (e FileTooBigForCRError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code:   StatusCodeKBFSFileTooBig,
+		Name:   "KBFS_FILE_TOO_BIG",
+		Desc:   e.Error(),
+		Fields: statusFields(errorParamPath, e.p.String()),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// NameTooLongError.
+func (e NameTooLongError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSNameTooLong,
+		Name: "KBFS_NAME_TOO_LONG",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamName, e.name,
+			errorParamMaxBytes,
+			strconv.FormatUint(uint64(e.maxAllowedBytes), 10)),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// DirTooBigError.
+func (e DirTooBigError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSDirTooBig,
+		Name: "KBFS_DIR_TOO_BIG",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamPath, e.p.String(),
+			errorParamSize, strconv.FormatUint(e.size, 10),
+			errorParamMaxBytes, strconv.FormatUint(e.maxAllowedBytes, 10)),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// NoCurrentSessionError.
+func (e NoCurrentSessionError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSNoCurrentSession,
+		Name: "KBFS_NO_CURRENT_SESSION",
+		Desc: e.Error(),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// NoSuchFolderListError.
+func (e NoSuchFolderListError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code:   StatusCodeKBFSNoSuchFolderList,
+		Name:   "KBFS_NO_SUCH_FOLDER_LIST",
+		Desc:   e.Error(),
+		Fields: statusFields(errorParamName, e.Name),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// MetadataIsFinalError.
+func (e MetadataIsFinalError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSMetadataIsFinal,
+		Name: "KBFS_METADATA_IS_FINAL",
+		Desc: e.Error(),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// OverQuotaWarning.
+func (w OverQuotaWarning) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSOverQuota,
+		Name: "KBFS_OVER_QUOTA",
+		Desc: w.Error(),
+		Fields: statusFields(
+			errorParamUsageBytes, strconv.FormatInt(w.UsageBytes, 10),
+			errorParamLimitBytes, strconv.FormatInt(w.LimitBytes, 10)),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// NoSigChainError.
+func (e NoSigChainError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code:   StatusCodeKBFSNoSigChain,
+		Name:   "KBFS_NO_SIG_CHAIN",
+		Desc:   e.Error(),
+		Fields: statusFields(errorParamUsername, e.User.String()),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// NotFileError.
+func (e NotFileError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSNotFile,
+		Name: "KBFS_NOT_FILE",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamPath, e.path.String(),
+			errorParamTlf, e.path.Tlf.String()),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// NotDirError.
+func (e NotDirError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSNotDir,
+		Name: "KBFS_NOT_DIR",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamPath, e.path.String(),
+			errorParamTlf, e.path.Tlf.String()),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// DiskCacheLockedError.
+func (e DiskCacheLockedError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSDiskCacheLocked,
+		Name: "KBFS_DISK_CACHE_LOCKED",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamPath, e.Path,
+			errorParamOwner, e.Owner),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// TlfFrozenError.
+func (e TlfFrozenError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code:   StatusCodeKBFSTlfFrozen,
+		Name:   "KBFS_TLF_FROZEN",
+		Desc:   e.Error(),
+		Fields: statusFields(errorParamTlf, string(e.Tlf)),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// TlfNotEmptyError.
+func (e TlfNotEmptyError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code:   StatusCodeKBFSTlfNotEmpty,
+		Name:   "KBFS_TLF_NOT_EMPTY",
+		Desc:   e.Error(),
+		Fields: statusFields(errorParamTlf, string(e.Tlf)),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// TlfTemplateTooBigError.
+func (e TlfTemplateTooBigError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSTlfTemplateTooBig,
+		Name: "KBFS_TLF_TEMPLATE_TOO_BIG",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamPath, e.Path,
+			errorParamSize, strconv.FormatUint(e.Size, 10),
+			errorParamMaxBytes, strconv.FormatUint(e.MaxBytes, 10)),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// TlfAppendOnlyError.
+func (e TlfAppendOnlyError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code: StatusCodeKBFSTlfAppendOnly,
+		Name: "KBFS_TLF_APPEND_ONLY",
+		Desc: e.Error(),
+		Fields: statusFields(
+			errorParamTlf, string(e.Tlf),
+			errorParamOp, e.Op),
+	}
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// DeviceRevokedError.
+func (e DeviceRevokedError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Code:   StatusCodeKBFSDeviceRevoked,
+		Name:   "KBFS_DEVICE_REVOKED",
+		Desc:   e.Error(),
+		Fields: statusFields(errorParamName, e.Name.String()),
+	}
+}
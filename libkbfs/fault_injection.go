@@ -0,0 +1,508 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// FaultConfig describes how often the fault-injecting block and MD
+// servers make calls fail, and how.  Each rate is the probability,
+// between 0 and 1, that a call fails in that particular way; a call
+// that can't fail in the chosen way goes through untouched.
+type FaultConfig struct {
+	// ThrottleRate is the rate of calls that fail with a
+	// throttle error, as if the server wanted the client to back
+	// off.
+	ThrottleRate float64
+	// TimeoutRate is the rate of calls that fail with
+	// context.DeadlineExceeded, as if the RPC had timed out.
+	TimeoutRate float64
+	// FailureRate is the rate of calls that fail with a generic
+	// server error, without reaching the server.
+	FailureRate float64
+	// PartialFailureRate is the rate of writes that reach the
+	// server, but still fail with a generic server error, as if
+	// the reply had been lost.
+	PartialFailureRate float64
+	// CorruptRate is the rate of reads that return data that has
+	// been tampered with.
+	CorruptRate float64
+	// Seed seeds the random choice of faults, so that a failing
+	// run can be reproduced.  Zero picks a seed from the clock.
+	Seed int64
+}
+
+const (
+	faultThrottleKey       = "throttle"
+	faultTimeoutKey        = "timeout"
+	faultFailureKey        = "fail"
+	faultPartialFailureKey = "partial"
+	faultCorruptKey        = "corrupt"
+	faultSeedKey           = "seed"
+)
+
+// ParseFaultConfig parses a comma-separated list of key=value pairs,
+// e.g. "throttle=0.05,timeout=0.01,corrupt=0.001,seed=42", into a
+// FaultConfig.  The keys are throttle, timeout, fail, partial,
+// corrupt and seed.  The empty string disables fault injection.
+func ParseFaultConfig(s string) (FaultConfig, error) {
+	var fc FaultConfig
+	if s == "" {
+		return fc, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return FaultConfig{}, errors.Errorf(
+				"Fault %q is not of the form key=value", pair)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if key == faultSeedKey {
+			seed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return FaultConfig{}, errors.Errorf(
+					"Invalid fault seed %q", value)
+			}
+			fc.Seed = seed
+			continue
+		}
+
+		var rate *float64
+		switch key {
+		case faultThrottleKey:
+			rate = &fc.ThrottleRate
+		case faultTimeoutKey:
+			rate = &fc.TimeoutRate
+		case faultFailureKey:
+			rate = &fc.FailureRate
+		case faultPartialFailureKey:
+			rate = &fc.PartialFailureRate
+		case faultCorruptKey:
+			rate = &fc.CorruptRate
+		default:
+			return FaultConfig{}, errors.Errorf("Unknown fault %q", key)
+		}
+		r, err := strconv.ParseFloat(value, 64)
+		if err != nil || r < 0 || r > 1 {
+			return FaultConfig{}, errors.Errorf(
+				"Fault rate %q for %s is not between 0 and 1", value, key)
+		}
+		*rate = r
+	}
+	if fc.ThrottleRate+fc.TimeoutRate+fc.FailureRate+
+		fc.PartialFailureRate+fc.CorruptRate > 1 {
+		return FaultConfig{}, errors.Errorf(
+			"Fault rates in %q add up to more than 1", s)
+	}
+	return fc, nil
+}
+
+// IsEnabled returns true if any faults will be injected.
+func (fc FaultConfig) IsEnabled() bool {
+	return fc.ThrottleRate > 0 || fc.TimeoutRate > 0 ||
+		fc.FailureRate > 0 || fc.PartialFailureRate > 0 ||
+		fc.CorruptRate > 0
+}
+
+// String returns fc in the format accepted by ParseFaultConfig.
+func (fc FaultConfig) String() string {
+	var pairs []string
+	add := func(key string, rate float64) {
+		if rate > 0 {
+			pairs = append(pairs, fmt.Sprintf("%s=%g", key, rate))
+		}
+	}
+	add(faultThrottleKey, fc.ThrottleRate)
+	add(faultTimeoutKey, fc.TimeoutRate)
+	add(faultFailureKey, fc.FailureRate)
+	add(faultPartialFailureKey, fc.PartialFailureRate)
+	add(faultCorruptKey, fc.CorruptRate)
+	if fc.Seed != 0 {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", faultSeedKey, fc.Seed))
+	}
+	return strings.Join(pairs, ",")
+}
+
+type faultKind int
+
+const (
+	faultNone faultKind = iota
+	faultThrottle
+	faultTimeout
+	faultFailure
+	faultPartialFailure
+	faultCorrupt
+)
+
+func (k faultKind) String() string {
+	switch k {
+	case faultNone:
+		return "none"
+	case faultThrottle:
+		return faultThrottleKey
+	case faultTimeout:
+		return faultTimeoutKey
+	case faultFailure:
+		return faultFailureKey
+	case faultPartialFailure:
+		return faultPartialFailureKey
+	case faultCorrupt:
+		return faultCorruptKey
+	default:
+		return fmt.Sprintf("faultKind(%d)", int(k))
+	}
+}
+
+// injectedFaultMsg is the message of every error made up by a
+// fault-injecting server.
+const injectedFaultMsg = "injected fault"
+
+// faultInjector picks the faults for a fault-injecting server.
+type faultInjector struct {
+	log    logger.Logger
+	config FaultConfig
+
+	lock sync.Mutex
+	rng  *rand.Rand
+}
+
+func newFaultInjector(log logger.Logger, config FaultConfig) *faultInjector {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Debug("Injecting faults (%s) with seed %d", config, seed)
+	return &faultInjector{
+		log:    log,
+		config: config,
+		rng:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// pick returns the fault to inject into the given call, which can
+// only fail in the given ways.
+func (fi *faultInjector) pick(
+	ctx context.Context, call string, allowed ...faultKind) faultKind {
+	fi.lock.Lock()
+	r := fi.rng.Float64()
+	fi.lock.Unlock()
+
+	kind := faultNone
+	for _, c := range []struct {
+		kind faultKind
+		rate float64
+	}{
+		{faultThrottle, fi.config.ThrottleRate},
+		{faultTimeout, fi.config.TimeoutRate},
+		{faultFailure, fi.config.FailureRate},
+		{faultPartialFailure, fi.config.PartialFailureRate},
+		{faultCorrupt, fi.config.CorruptRate},
+	} {
+		if r < c.rate {
+			kind = c.kind
+			break
+		}
+		r -= c.rate
+	}
+	if kind == faultNone {
+		return faultNone
+	}
+	for _, a := range allowed {
+		if a == kind {
+			fi.log.CDebugf(ctx, "Injecting a %s fault into %s", kind, call)
+			return kind
+		}
+	}
+	return faultNone
+}
+
+// corrupt flips a random byte of a copy of buf.
+func (fi *faultInjector) corrupt(buf []byte) []byte {
+	if len(buf) == 0 {
+		return buf
+	}
+	fi.lock.Lock()
+	i := fi.rng.Intn(len(buf))
+	fi.lock.Unlock()
+	c := make([]byte, len(buf))
+	copy(c, buf)
+	c[i] ^= 0xff
+	return c
+}
+
+// Every write can fail in any way but corruption; every read can
+// fail in any way but a partial failure.
+var (
+	faultsForWrites = []faultKind{
+		faultThrottle, faultTimeout, faultFailure, faultPartialFailure}
+	faultsForReads = []faultKind{
+		faultThrottle, faultTimeout, faultFailure, faultCorrupt}
+)
+
+// BlockServerFaulty delegates to another BlockServer, but makes some
+// of the calls fail as described by a FaultConfig.  It's meant for
+// tests and staging clients, to exercise the retry and error paths.
+type BlockServerFaulty struct {
+	BlockServer
+	faults *faultInjector
+}
+
+var _ BlockServer = (*BlockServerFaulty)(nil)
+
+// NewBlockServerFaulty creates and returns a new BlockServerFaulty
+// instance with the given delegate and fault config.
+func NewBlockServerFaulty(log logger.Logger, delegate BlockServer,
+	config FaultConfig) *BlockServerFaulty {
+	return &BlockServerFaulty{
+		BlockServer: delegate,
+		faults:      newFaultInjector(log, config),
+	}
+}
+
+// blockFaultErr returns the error to return for the given fault, if
+// it's one that happens before the call reaches the server.
+func blockFaultErr(kind faultKind) error {
+	switch kind {
+	case faultThrottle:
+		return kbfsblock.BServerErrorThrottle{Msg: injectedFaultMsg}
+	case faultTimeout:
+		return context.DeadlineExceeded
+	case faultFailure:
+		return kbfsblock.BServerError{Msg: injectedFaultMsg}
+	default:
+		return nil
+	}
+}
+
+// Get implements the BlockServer interface for BlockServerFaulty.
+func (b *BlockServerFaulty) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	kind := b.faults.pick(ctx, "BlockServer.Get", faultsForReads...)
+	if err := blockFaultErr(kind); err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	buf, serverHalf, err := b.BlockServer.Get(ctx, tlfID, id, context)
+	if err == nil && kind == faultCorrupt {
+		buf = b.faults.corrupt(buf)
+	}
+	return buf, serverHalf, err
+}
+
+// Put implements the BlockServer interface for BlockServerFaulty.
+func (b *BlockServerFaulty) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	kind := b.faults.pick(ctx, "BlockServer.Put", faultsForWrites...)
+	if err := blockFaultErr(kind); err != nil {
+		return err
+	}
+	err := b.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
+	if err == nil && kind == faultPartialFailure {
+		return kbfsblock.BServerError{Msg: injectedFaultMsg}
+	}
+	return err
+}
+
+// AddBlockReference implements the BlockServer interface for
+// BlockServerFaulty.
+func (b *BlockServerFaulty) AddBlockReference(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID, context kbfsblock.Context) error {
+	kind := b.faults.pick(
+		ctx, "BlockServer.AddBlockReference", faultsForWrites...)
+	if err := blockFaultErr(kind); err != nil {
+		return err
+	}
+	err := b.BlockServer.AddBlockReference(ctx, tlfID, id, context)
+	if err == nil && kind == faultPartialFailure {
+		return kbfsblock.BServerError{Msg: injectedFaultMsg}
+	}
+	return err
+}
+
+// RemoveBlockReferences implements the BlockServer interface for
+// BlockServerFaulty.
+func (b *BlockServerFaulty) RemoveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) (
+	map[kbfsblock.ID]int, error) {
+	kind := b.faults.pick(
+		ctx, "BlockServer.RemoveBlockReferences", faultsForWrites...)
+	if err := blockFaultErr(kind); err != nil {
+		return nil, err
+	}
+	liveCounts, err := b.BlockServer.RemoveBlockReferences(
+		ctx, tlfID, contexts)
+	if err == nil && kind == faultPartialFailure {
+		return nil, kbfsblock.BServerError{Msg: injectedFaultMsg}
+	}
+	return liveCounts, err
+}
+
+// ArchiveBlockReferences implements the BlockServer interface for
+// BlockServerFaulty.
+func (b *BlockServerFaulty) ArchiveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) error {
+	kind := b.faults.pick(
+		ctx, "BlockServer.ArchiveBlockReferences", faultsForWrites...)
+	if err := blockFaultErr(kind); err != nil {
+		return err
+	}
+	err := b.BlockServer.ArchiveBlockReferences(ctx, tlfID, contexts)
+	if err == nil && kind == faultPartialFailure {
+		return kbfsblock.BServerError{Msg: injectedFaultMsg}
+	}
+	return err
+}
+
+// MDServerFaulty delegates to another MDServer, but makes some of
+// the calls fail as described by a FaultConfig.  It's meant for tests
+// and staging clients, to exercise the retry and error paths.
+type MDServerFaulty struct {
+	MDServer
+	faults *faultInjector
+}
+
+var _ MDServer = (*MDServerFaulty)(nil)
+
+// NewMDServerFaulty creates and returns a new MDServerFaulty
+// instance with the given delegate and fault config.
+func NewMDServerFaulty(log logger.Logger, delegate MDServer,
+	config FaultConfig) *MDServerFaulty {
+	return &MDServerFaulty{
+		MDServer: delegate,
+		faults:   newFaultInjector(log, config),
+	}
+}
+
+// mdFaultErr returns the error to return for the given fault, if
+// it's one that happens before the call reaches the server.
+func mdFaultErr(kind faultKind) error {
+	switch kind {
+	case faultThrottle:
+		return MDServerErrorThrottle{Err: errors.New(injectedFaultMsg)}
+	case faultTimeout:
+		return context.DeadlineExceeded
+	case faultFailure:
+		return MDServerError{Err: errors.New(injectedFaultMsg)}
+	default:
+		return nil
+	}
+}
+
+// corruptRMDS returns a copy of rmds with a broken signature.
+func (md *MDServerFaulty) corruptRMDS(
+	rmds *RootMetadataSigned) *RootMetadataSigned {
+	if rmds == nil {
+		return nil
+	}
+	c := *rmds
+	c.SigInfo.Signature = md.faults.corrupt(c.SigInfo.Signature)
+	return &c
+}
+
+// GetForHandle implements the MDServer interface for MDServerFaulty.
+func (md *MDServerFaulty) GetForHandle(ctx context.Context,
+	handle tlf.Handle, mStatus MergeStatus) (
+	tlf.ID, *RootMetadataSigned, error) {
+	kind := md.faults.pick(ctx, "MDServer.GetForHandle", faultsForReads...)
+	if err := mdFaultErr(kind); err != nil {
+		return tlf.NullID, nil, err
+	}
+	id, rmds, err := md.MDServer.GetForHandle(ctx, handle, mStatus)
+	if err == nil && kind == faultCorrupt {
+		rmds = md.corruptRMDS(rmds)
+	}
+	return id, rmds, err
+}
+
+// GetForTLF implements the MDServer interface for MDServerFaulty.
+func (md *MDServerFaulty) GetForTLF(ctx context.Context, id tlf.ID,
+	bid BranchID, mStatus MergeStatus) (*RootMetadataSigned, error) {
+	kind := md.faults.pick(ctx, "MDServer.GetForTLF", faultsForReads...)
+	if err := mdFaultErr(kind); err != nil {
+		return nil, err
+	}
+	rmds, err := md.MDServer.GetForTLF(ctx, id, bid, mStatus)
+	if err == nil && kind == faultCorrupt {
+		rmds = md.corruptRMDS(rmds)
+	}
+	return rmds, err
+}
+
+// GetRange implements the MDServer interface for MDServerFaulty.
+func (md *MDServerFaulty) GetRange(ctx context.Context, id tlf.ID,
+	bid BranchID, mStatus MergeStatus, start, stop MetadataRevision) (
+	[]*RootMetadataSigned, error) {
+	kind := md.faults.pick(ctx, "MDServer.GetRange", faultsForReads...)
+	if err := mdFaultErr(kind); err != nil {
+		return nil, err
+	}
+	rmdses, err := md.MDServer.GetRange(ctx, id, bid, mStatus, start, stop)
+	if err == nil && kind == faultCorrupt && len(rmdses) > 0 {
+		// Only corrupt the last one, so that the earlier ones
+		// still get processed.
+		corrupted := make([]*RootMetadataSigned, len(rmdses))
+		copy(corrupted, rmdses)
+		last := len(corrupted) - 1
+		corrupted[last] = md.corruptRMDS(corrupted[last])
+		rmdses = corrupted
+	}
+	return rmdses, err
+}
+
+// Put implements the MDServer interface for MDServerFaulty.
+func (md *MDServerFaulty) Put(ctx context.Context,
+	rmds *RootMetadataSigned, extra ExtraMetadata) error {
+	kind := md.faults.pick(ctx, "MDServer.Put", faultsForWrites...)
+	if err := mdFaultErr(kind); err != nil {
+		return err
+	}
+	err := md.MDServer.Put(ctx, rmds, extra)
+	if err == nil && kind == faultPartialFailure {
+		return MDServerError{Err: errors.New(injectedFaultMsg)}
+	}
+	return err
+}
+
+// PruneBranch implements the MDServer interface for MDServerFaulty.
+func (md *MDServerFaulty) PruneBranch(
+	ctx context.Context, id tlf.ID, bid BranchID) error {
+	kind := md.faults.pick(ctx, "MDServer.PruneBranch", faultsForWrites...)
+	if err := mdFaultErr(kind); err != nil {
+		return err
+	}
+	err := md.MDServer.PruneBranch(ctx, id, bid)
+	if err == nil && kind == faultPartialFailure {
+		return MDServerError{Err: errors.New(injectedFaultMsg)}
+	}
+	return err
+}
+
+// GetKeyBundles implements the MDServer interface for MDServerFaulty.
+func (md *MDServerFaulty) GetKeyBundles(ctx context.Context,
+	tlfID tlf.ID, wkbID TLFWriterKeyBundleID, rkbID TLFReaderKeyBundleID) (
+	*TLFWriterKeyBundleV3, *TLFReaderKeyBundleV3, error) {
+	// Corrupted key bundles are caught by their IDs not matching,
+	// which isn't very interesting, so only fail outright.
+	kind := md.faults.pick(ctx, "MDServer.GetKeyBundles",
+		faultThrottle, faultTimeout, faultFailure)
+	if err := mdFaultErr(kind); err != nil {
+		return nil, nil, err
+	}
+	return md.MDServer.GetKeyBundles(ctx, tlfID, wkbID, rkbID)
+}
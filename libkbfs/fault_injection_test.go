@@ -0,0 +1,150 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestParseFaultConfig(t *testing.T) {
+	fc, err := ParseFaultConfig("")
+	require.NoError(t, err)
+	require.False(t, fc.IsEnabled())
+
+	s := "throttle=0.05,timeout=0.01,fail=0.02,partial=0.03,corrupt=0.001,seed=7"
+	fc, err = ParseFaultConfig(s)
+	require.NoError(t, err)
+	require.True(t, fc.IsEnabled())
+	require.Equal(t, FaultConfig{
+		ThrottleRate:       0.05,
+		TimeoutRate:        0.01,
+		FailureRate:        0.02,
+		PartialFailureRate: 0.03,
+		CorruptRate:        0.001,
+		Seed:               7,
+	}, fc)
+	require.Equal(t, s, fc.String())
+
+	for _, bad := range []string{
+		"throttle", "bogus=0.1", "fail=2", "fail=-0.1", "seed=x",
+		"fail=0.6,partial=0.6",
+	} {
+		_, err = ParseFaultConfig(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestBlockServerFaulty(t *testing.T) {
+	log := logger.NewTestLogger(t)
+	delegate := NewBlockServerMemory(log)
+	ctx := context.Background()
+	tlfID := tlf.FakeID(1, false)
+	uid := keybase1.MakeTestUID(1)
+	bCtx := kbfsblock.MakeFirstContext(uid, keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+
+	b := NewBlockServerFaulty(log, delegate, FaultConfig{ThrottleRate: 1})
+	err = b.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.IsType(t, kbfsblock.BServerErrorThrottle{}, err)
+	_, _, err = delegate.Get(ctx, tlfID, bID, bCtx)
+	require.IsType(t, kbfsblock.BServerErrorBlockNonExistent{}, err)
+
+	// A partial failure still writes the block.
+	b = NewBlockServerFaulty(
+		log, delegate, FaultConfig{PartialFailureRate: 1})
+	err = b.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.IsType(t, kbfsblock.BServerError{}, err)
+	buf, _, err := b.Get(ctx, tlfID, bID, bCtx)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+
+	b = NewBlockServerFaulty(log, delegate, FaultConfig{CorruptRate: 1})
+	buf, _, err = b.Get(ctx, tlfID, bID, bCtx)
+	require.NoError(t, err)
+	require.Len(t, buf, len(data))
+	require.NotEqual(t, data, buf)
+	// The stored block is untouched.
+	buf, _, err = delegate.Get(ctx, tlfID, bID, bCtx)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+
+	b = NewBlockServerFaulty(log, delegate, FaultConfig{TimeoutRate: 1})
+	_, _, err = b.Get(ctx, tlfID, bID, bCtx)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+}
+
+func TestFaultInjectorRates(t *testing.T) {
+	log := logger.NewTestLogger(t)
+	fi := newFaultInjector(log, FaultConfig{FailureRate: 0.5, Seed: 1})
+	ctx := context.Background()
+	failures := 0
+	const calls = 1000
+	for i := 0; i < calls; i++ {
+		if fi.pick(ctx, "test", faultsForReads...) == faultFailure {
+			failures++
+		}
+	}
+	require.True(t, failures > calls/4 && failures < 3*calls/4,
+		"%d failures out of %d calls", failures, calls)
+
+	// The same seed picks the same faults.
+	fi1 := newFaultInjector(log, FaultConfig{FailureRate: 0.5, Seed: 2})
+	fi2 := newFaultInjector(log, FaultConfig{FailureRate: 0.5, Seed: 2})
+	for i := 0; i < 100; i++ {
+		require.Equal(t, fi1.pick(ctx, "test", faultsForReads...),
+			fi2.pick(ctx, "test", faultsForReads...))
+	}
+}
+
+func TestMDServerFaulty(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	delegate := NewMockMDServer(mockCtrl)
+	log := logger.NewTestLogger(t)
+	ctx := context.Background()
+	tlfID := tlf.FakeID(1, false)
+
+	md := NewMDServerFaulty(log, delegate, FaultConfig{FailureRate: 1})
+	_, err := md.GetForTLF(ctx, tlfID, NullBranchID, Merged)
+	require.IsType(t, MDServerError{}, err)
+
+	md = NewMDServerFaulty(log, delegate, FaultConfig{ThrottleRate: 1})
+	err = md.PruneBranch(ctx, tlfID, NullBranchID)
+	require.IsType(t, MDServerErrorThrottle{}, err)
+
+	// A partial failure still reaches the server.
+	delegate.EXPECT().PruneBranch(ctx, tlfID, NullBranchID).Return(nil)
+	md = NewMDServerFaulty(
+		log, delegate, FaultConfig{PartialFailureRate: 1})
+	err = md.PruneBranch(ctx, tlfID, NullBranchID)
+	require.IsType(t, MDServerError{}, err)
+
+	sig := []byte{1, 2, 3}
+	rmds := &RootMetadataSigned{
+		SigInfo: kbfscrypto.SignatureInfo{Signature: sig},
+	}
+	delegate.EXPECT().GetForTLF(ctx, tlfID, NullBranchID, Merged).Return(
+		rmds, nil)
+	md = NewMDServerFaulty(log, delegate, FaultConfig{CorruptRate: 1})
+	corrupted, err := md.GetForTLF(ctx, tlfID, NullBranchID, Merged)
+	require.NoError(t, err)
+	require.NotEqual(t, sig, corrupted.SigInfo.Signature)
+	// The original is untouched.
+	require.Equal(t, []byte{1, 2, 3}, rmds.SigInfo.Signature)
+}
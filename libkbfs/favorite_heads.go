@@ -0,0 +1,167 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// defaultFavoriteHeadFetchParallelism is the default number of
+// favorite TLFs whose MD heads are fetched at once during startup.
+const defaultFavoriteHeadFetchParallelism = 8
+
+// FavoriteHeadFetchStatus describes the progress of fetching the MD
+// heads of the current user's favorite TLFs in the background.
+type FavoriteHeadFetchStatus struct {
+	Total   int
+	Fetched int
+	Failed  int
+}
+
+// fetchFavoriteHead fetches the MD head of the TLF for the given
+// favorite and initializes its folderBranchOps with it, so that the
+// first access to the folder doesn't have to wait on the server.
+// Unlike a real access, it never creates a TLF that doesn't exist
+// yet.
+func (fs *KBFSOpsStandard) fetchFavoriteHead(
+	ctx context.Context, fav Favorite) error {
+	h, err := ParseTlfHandle(ctx, fs.config.KBPKI(), fav.Name, fav.Public)
+	if err != nil {
+		return err
+	}
+
+	mdops := fs.config.MDOps()
+	_, md, err := mdops.GetForHandle(ctx, h, Unmerged)
+	if err != nil {
+		return err
+	}
+	if md == (ImmutableRootMetadata{}) {
+		_, md, _, err = fs.getOrInitializeNewMDMaster(
+			ctx, mdops, h, false, FavoritesOpNoChange)
+		if err != nil {
+			return err
+		}
+		if md == (ImmutableRootMetadata{}) {
+			// Nothing has been written to this TLF yet.
+			return nil
+		}
+	}
+
+	// Leave unreadable folders alone; accessing them for real
+	// triggers the rekey prompt.
+	if err := isReadableOrError(ctx, fs.config.KBPKI(), md.ReadOnly()); err != nil {
+		return err
+	}
+
+	fb := FolderBranch{Tlf: md.TlfID(), Branch: MasterBranch}
+	ops := fs.getOpsByHandle(ctx, h, fb, FavoritesOpNoChange)
+	return ops.SetInitialHeadFromServer(ctx, md)
+}
+
+func (fs *KBFSOpsStandard) updateFavoriteHeadFetchStatus(
+	fn func(status *FavoriteHeadFetchStatus)) {
+	fs.favHeadFetchLock.Lock()
+	defer fs.favHeadFetchLock.Unlock()
+	if fs.favHeadFetch != nil {
+		fn(fs.favHeadFetch)
+	}
+}
+
+func (fs *KBFSOpsStandard) getFavoriteHeadFetchStatus() *FavoriteHeadFetchStatus {
+	fs.favHeadFetchLock.Lock()
+	defer fs.favHeadFetchLock.Unlock()
+	if fs.favHeadFetch == nil {
+		return nil
+	}
+	status := *fs.favHeadFetch
+	return &status
+}
+
+// FetchFavoriteHeads fetches the MD heads of all the current user's
+// favorite TLFs, at most `parallelism` at a time, and initializes
+// their folders with them.  Failures for individual folders are
+// logged and counted in the status, but don't stop the others.  It
+// returns once every favorite has been tried or `ctx` is canceled,
+// so callers at startup should run it in the background.
+func (fs *KBFSOpsStandard) FetchFavoriteHeads(
+	ctx context.Context, parallelism int) (err error) {
+	fs.log.CDebugf(ctx, "FetchFavoriteHeads(%d)", parallelism)
+	defer func() { fs.deferLog.CDebugf(ctx, "Done: %+v", err) }()
+
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	favs, err := fs.GetFavorites(ctx)
+	if err != nil {
+		return err
+	}
+
+	func() {
+		fs.favHeadFetchLock.Lock()
+		defer fs.favHeadFetchLock.Unlock()
+		fs.favHeadFetch = &FavoriteHeadFetchStatus{Total: len(favs)}
+	}()
+	defer func() {
+		fs.favHeadFetchLock.Lock()
+		defer fs.favHeadFetchLock.Unlock()
+		fs.favHeadFetch = nil
+	}()
+
+	favChan := make(chan Favorite, len(favs))
+	for _, fav := range favs {
+		favChan <- fav
+	}
+	close(favChan)
+
+	var wg sync.WaitGroup
+	worker := func() {
+		defer wg.Done()
+		for fav := range favChan {
+			if ctx.Err() != nil {
+				return
+			}
+			err := fs.fetchFavoriteHead(ctx, fav)
+			if err != nil {
+				fs.log.CDebugf(ctx, "Couldn't fetch the head for %s: %+v",
+					fav.Name, err)
+			}
+			fs.updateFavoriteHeadFetchStatus(
+				func(status *FavoriteHeadFetchStatus) {
+					if err != nil {
+						status.Failed++
+					} else {
+						status.Fetched++
+					}
+				})
+		}
+	}
+	if parallelism > len(favs) {
+		parallelism = len(favs)
+	}
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go worker()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// startFetchingFavoriteHeads runs FetchFavoriteHeads in the
+// background.  It is canceled when KBFSOpsStandard shuts down.
+func (fs *KBFSOpsStandard) startFetchingFavoriteHeads(parallelism int) {
+	ctx, cancel := context.WithCancel(ctxWithRandomIDReplayable(
+		context.Background(), CtxKBFSOpsFavHeadsIDKey,
+		CtxKBFSOpsFavHeadsOpID, fs.log))
+	fs.favHeadFetchLock.Lock()
+	fs.favHeadFetchCancel = cancel
+	fs.favHeadFetchLock.Unlock()
+	go func() {
+		defer cancel()
+		_ = fs.FetchFavoriteHeads(ctx, parallelism)
+	}()
+}
@@ -0,0 +1,240 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+)
+
+const (
+	// favoriteUsageFilename is the name of the file in the storage
+	// root that records when each favorite was last accessed.
+	favoriteUsageFilename = "kbfs_favorite_usage.json"
+	// favoriteUsageResolution is how precisely access times are
+	// recorded.  Accesses that come sooner than this after the
+	// recorded one are only noted in memory, so that busy folders
+	// don't rewrite the file on every operation.
+	favoriteUsageResolution = time.Hour
+)
+
+// FavoriteUsage is how a favorite TLF has been used on this device.
+type FavoriteUsage struct {
+	Favorite
+	// LastAccess is when the TLF was last accessed, to within an
+	// hour.  It's zero if the TLF hasn't been accessed since usage
+	// tracking started.
+	LastAccess time.Time
+	// DiskUsage is the size of the TLF in bytes, as of the last
+	// access.
+	DiskUsage uint64
+}
+
+// FavoriteCleanupParams says which favorites to suggest cleaning up.
+type FavoriteCleanupParams struct {
+	// StaleAfter is how long a favorite must have gone without
+	// being accessed on this device to be suggested.
+	StaleAfter time.Duration
+	// ArchiveBytes is the size at which a stale favorite is
+	// suggested for archiving, rather than just unfavoriting.  If
+	// it's 0, no favorites are suggested for archiving.
+	ArchiveBytes uint64
+}
+
+// FavoriteSuggestion is a favorite that may be worth cleaning up.
+type FavoriteSuggestion struct {
+	FavoriteUsage
+	// Archive is whether the TLF holds enough data that it's worth
+	// exporting, e.g. with ExportTLFArchive, before unfavoriting it.
+	Archive bool
+}
+
+// favoriteUsageFile is the format of the favorite usage file.
+type favoriteUsageFile struct {
+	// Since is when usage tracking started.
+	Since time.Time
+	Usage []FavoriteUsage
+}
+
+// favoriteUsageTracker records how each favorite is used, saved
+// under the storage root if there is one, and only in memory
+// otherwise.
+type favoriteUsageTracker struct {
+	config clockGetter
+	// filePath is empty if the usage isn't saved.
+	filePath string
+
+	lock   sync.Mutex
+	loaded bool
+	since  time.Time
+	usage  map[Favorite]FavoriteUsage
+	// dirty is the set of favorites whose usage has changed since
+	// it was last saved.
+	dirty map[Favorite]bool
+}
+
+func newFavoriteUsageTracker(
+	storageRoot string, config clockGetter) *favoriteUsageTracker {
+	t := &favoriteUsageTracker{
+		config: config,
+		usage:  make(map[Favorite]FavoriteUsage),
+		dirty:  make(map[Favorite]bool),
+	}
+	if storageRoot != "" {
+		t.filePath = filepath.Join(storageRoot, favoriteUsageFilename)
+	}
+	return t
+}
+
+// loadLocked reads the saved usage, keeping any unsaved changes.
+// It's only called when saving or listing, so that changes made by
+// other processes sharing the storage root aren't clobbered.
+func (t *favoriteUsageTracker) loadLocked() error {
+	var file favoriteUsageFile
+	if t.filePath != "" {
+		err := ioutil.DeserializeFromJSONFile(t.filePath, &file)
+		if err != nil && !ioutil.IsNotExist(err) {
+			return err
+		}
+	}
+	switch {
+	case !file.Since.IsZero() &&
+		(t.since.IsZero() || file.Since.Before(t.since)):
+		t.since = file.Since
+	case t.since.IsZero():
+		t.since = t.config.Clock().Now()
+	}
+	for _, u := range file.Usage {
+		if t.dirty[u.Favorite] &&
+			!t.usage[u.Favorite].LastAccess.Before(u.LastAccess) {
+			continue
+		}
+		t.usage[u.Favorite] = u
+		delete(t.dirty, u.Favorite)
+	}
+	t.loaded = true
+	return nil
+}
+
+func (t *favoriteUsageTracker) saveLocked() error {
+	err := t.loadLocked()
+	if err != nil {
+		return err
+	}
+	if t.filePath == "" {
+		t.dirty = make(map[Favorite]bool)
+		return nil
+	}
+	file := favoriteUsageFile{
+		Since: t.since,
+		Usage: make([]FavoriteUsage, 0, len(t.usage)),
+	}
+	for _, u := range t.usage {
+		file.Usage = append(file.Usage, u)
+	}
+	sort.Slice(file.Usage, func(i, j int) bool {
+		return favoriteLess(file.Usage[i].Favorite, file.Usage[j].Favorite)
+	})
+	err = ioutil.SerializeToJSONFile(file, t.filePath)
+	if err != nil {
+		return err
+	}
+	t.dirty = make(map[Favorite]bool)
+	return nil
+}
+
+// noteAccess records that fav, which has the given size, was just
+// accessed.  It only saves the usage once the recorded access is
+// more than favoriteUsageResolution old.
+func (t *favoriteUsageTracker) noteAccess(
+	fav Favorite, diskUsage uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.loaded {
+		err := t.loadLocked()
+		if err != nil {
+			return err
+		}
+	}
+
+	now := t.config.Clock().Now()
+	u, ok := t.usage[fav]
+	stale := !ok || now.Sub(u.LastAccess) >= favoriteUsageResolution
+	if !stale && u.DiskUsage == diskUsage {
+		return nil
+	}
+	u.Favorite = fav
+	u.DiskUsage = diskUsage
+	if stale {
+		u.LastAccess = now
+	}
+	t.usage[fav] = u
+	t.dirty[fav] = true
+	if !stale {
+		return nil
+	}
+	return t.saveLocked()
+}
+
+// flush saves any usage that has only been noted in memory.
+func (t *favoriteUsageTracker) flush() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.dirty) == 0 {
+		return nil
+	}
+	return t.saveLocked()
+}
+
+// suggest returns the favorites in favs that params says are worth
+// cleaning up, least recently accessed first.
+func (t *favoriteUsageTracker) suggest(favs []Favorite,
+	params FavoriteCleanupParams) ([]FavoriteSuggestion, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	err := t.loadLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := t.config.Clock().Now().Add(-params.StaleAfter)
+	if !t.since.Before(cutoff) {
+		// Nothing can be known to be stale yet.
+		return nil, nil
+	}
+	var suggestions []FavoriteSuggestion
+	for _, fav := range favs {
+		u, ok := t.usage[fav]
+		if !ok {
+			u = FavoriteUsage{Favorite: fav}
+		} else if !u.LastAccess.Before(cutoff) {
+			continue
+		}
+		suggestions = append(suggestions, FavoriteSuggestion{
+			FavoriteUsage: u,
+			Archive: params.ArchiveBytes > 0 &&
+				u.DiskUsage >= params.ArchiveBytes,
+		})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if !a.LastAccess.Equal(b.LastAccess) {
+			return a.LastAccess.Before(b.LastAccess)
+		}
+		return favoriteLess(a.Favorite, b.Favorite)
+	})
+	return suggestions, nil
+}
+
+func favoriteLess(a, b Favorite) bool {
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return !a.Public && b.Public
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFavoriteUsageTrackerSuggest(t *testing.T) {
+	cg := newTestClockGetter()
+	clock := cg.TestClock()
+	t0 := clock.Now()
+	tracker := newFavoriteUsageTracker("", cg)
+
+	a := Favorite{"alice,bob", false}
+	b := Favorite{"alice,charlie", false}
+	c := Favorite{"alice,dave", true}
+	require.NoError(t, tracker.noteAccess(a, 10))
+	require.NoError(t, tracker.noteAccess(b, 1000))
+
+	params := FavoriteCleanupParams{StaleAfter: 2 * time.Hour, ArchiveBytes: 100}
+	suggestions, err := tracker.suggest([]Favorite{a, b, c}, params)
+	require.NoError(t, err)
+	// Nothing has been tracked for long enough yet.
+	require.Len(t, suggestions, 0)
+
+	clock.Add(2 * time.Hour)
+	require.NoError(t, tracker.noteAccess(a, 20))
+	clock.Add(time.Hour)
+	suggestions, err = tracker.suggest([]Favorite{a, b, c}, params)
+	require.NoError(t, err)
+	require.Equal(t, []FavoriteSuggestion{
+		{FavoriteUsage: FavoriteUsage{Favorite: c}},
+		{
+			FavoriteUsage: FavoriteUsage{
+				Favorite: b, LastAccess: t0, DiskUsage: 1000},
+			Archive: true,
+		},
+	}, suggestions)
+
+	params.ArchiveBytes = 0
+	suggestions, err = tracker.suggest([]Favorite{b}, params)
+	require.NoError(t, err)
+	require.Len(t, suggestions, 1)
+	require.False(t, suggestions[0].Archive)
+}
+
+func TestFavoriteUsageTrackerPersistence(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "favorite_usage")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, ioutil.RemoveAll(tempdir))
+	}()
+	filePath := filepath.Join(tempdir, favoriteUsageFilename)
+
+	cg := newTestClockGetter()
+	clock := cg.TestClock()
+	t0 := clock.Now()
+	tracker := newFavoriteUsageTracker(tempdir, cg)
+	a := Favorite{"alice,bob", false}
+	require.NoError(t, tracker.noteAccess(a, 10))
+	var file favoriteUsageFile
+	require.NoError(t, ioutil.DeserializeFromJSONFile(filePath, &file))
+	require.Len(t, file.Usage, 1)
+	require.Equal(t, uint64(10), file.Usage[0].DiskUsage)
+
+	// A new size within the resolution is only saved on flush.
+	clock.Add(time.Minute)
+	require.NoError(t, tracker.noteAccess(a, 20))
+	require.NoError(t, ioutil.DeserializeFromJSONFile(filePath, &file))
+	require.Equal(t, uint64(10), file.Usage[0].DiskUsage)
+	require.NoError(t, tracker.flush())
+	require.NoError(t, ioutil.DeserializeFromJSONFile(filePath, &file))
+	require.Equal(t, uint64(20), file.Usage[0].DiskUsage)
+	require.True(t, t0.Equal(file.Usage[0].LastAccess))
+
+	// A second tracker sharing the storage root sees the usage,
+	// and knows when tracking started.
+	clock.Add(2 * time.Hour)
+	tracker2 := newFavoriteUsageTracker(tempdir, cg)
+	suggestions, err := tracker2.suggest(
+		[]Favorite{a}, FavoriteCleanupParams{StaleAfter: time.Hour})
+	require.NoError(t, err)
+	require.Len(t, suggestions, 1)
+	require.True(t, t0.Equal(suggestions[0].LastAccess))
+	require.Equal(t, uint64(20), suggestions[0].DiskUsage)
+}
+
+func TestKBFSOpsFavoriteCleanup(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock := newTestClockNow()
+	config.SetClock(clock)
+	t0 := clock.Now()
+
+	kbfsOps := config.KBFSOps()
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice,bob", false)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	// Note the size after the sync.
+	_, err = kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	GetRootNodeOrBust(ctx, t, config, "alice,bob", true)
+
+	clock.Add(48 * time.Hour)
+	GetRootNodeOrBust(ctx, t, config, "alice,bob", true)
+
+	params := FavoriteCleanupParams{StaleAfter: 24 * time.Hour, ArchiveBytes: 1}
+	suggestions, err := kbfsOps.GetFavoriteCleanupSuggestions(ctx, params)
+	require.NoError(t, err)
+	require.Len(t, suggestions, 1)
+	private := Favorite{"alice,bob", false}
+	require.Equal(t, private, suggestions[0].Favorite)
+	require.True(t, t0.Equal(suggestions[0].LastAccess))
+	require.NotZero(t, suggestions[0].DiskUsage)
+	require.True(t, suggestions[0].Archive)
+
+	err = kbfsOps.DeleteFavorites(ctx, []Favorite{private})
+	require.NoError(t, err)
+	favs, err := kbfsOps.GetFavorites(ctx)
+	require.NoError(t, err)
+	require.NotContains(t, favs, private)
+	require.Contains(t, favs, Favorite{"alice,bob", true})
+	suggestions, err = kbfsOps.GetFavoriteCleanupSuggestions(ctx, params)
+	require.NoError(t, err)
+	require.Len(t, suggestions, 0)
+}
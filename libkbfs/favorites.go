@@ -65,6 +65,9 @@ type Favorites struct {
 	inFlightLock sync.Mutex
 	inFlightAdds map[favToAdd]*favReq
 
+	// usage tracks how each favorite is used on this device.
+	usage *favoriteUsageTracker
+
 	muShutdown sync.RWMutex
 	shutdown   bool
 }
@@ -74,6 +77,7 @@ func newFavoritesWithChan(config Config, reqChan chan *favReq) *Favorites {
 		config:       config,
 		reqChan:      reqChan,
 		inFlightAdds: make(map[favToAdd]*favReq),
+		usage:        newFavoriteUsageTracker(config.StorageRoot(), config),
 	}
 	go f.loop()
 	return f
@@ -169,7 +173,11 @@ func (f *Favorites) Shutdown() error {
 	defer f.muShutdown.Unlock()
 	f.shutdown = true
 	close(f.reqChan)
-	return f.wg.Wait(context.Background())
+	err := f.wg.Wait(context.Background())
+	if err != nil {
+		return err
+	}
+	return f.usage.flush()
 }
 
 func (f *Favorites) hasShutdown() bool {
@@ -290,6 +298,22 @@ func (f *Favorites) Delete(ctx context.Context, fav Favorite) error {
 	})
 }
 
+// DeleteAll deletes several favorites from the favorites list at
+// once.  It is idempotent.
+func (f *Favorites) DeleteAll(ctx context.Context, favs []Favorite) error {
+	if f.hasShutdown() {
+		return ShutdownHappenedError{}
+	}
+	if len(favs) == 0 {
+		return nil
+	}
+	return f.sendReq(ctx, &favReq{
+		ctx:   ctx,
+		toDel: favs,
+		done:  make(chan struct{}),
+	})
+}
+
 // RefreshCache refreshes the cached list of favorites.
 func (f *Favorites) RefreshCache(ctx context.Context) {
 	if f.hasShutdown() {
@@ -329,3 +353,32 @@ func (f *Favorites) Get(ctx context.Context) ([]Favorite, error) {
 	}
 	return <-favChan, nil
 }
+
+// NoteAccess records that the given favorite, which holds diskUsage
+// bytes, was just accessed on this device.
+func (f *Favorites) NoteAccess(fav Favorite, diskUsage uint64) error {
+	return f.usage.noteAccess(fav, diskUsage)
+}
+
+// Suggest returns the favorites that params says are worth cleaning
+// up, least recently accessed first.  The current user's own folders
+// are never suggested, since they can't be unfavorited.
+func (f *Favorites) Suggest(ctx context.Context,
+	params FavoriteCleanupParams) ([]FavoriteSuggestion, error) {
+	favs, err := f.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := f.config.KBPKI().GetCurrentSession(ctx)
+	if err == nil {
+		n := 0
+		for _, fav := range favs {
+			if fav.Name != string(session.Name) {
+				favs[n] = fav
+				n++
+			}
+		}
+		favs = favs[:n]
+	}
+	return f.usage.suggest(favs, params)
+}
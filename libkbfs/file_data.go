@@ -12,6 +12,7 @@ import (
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
 )
@@ -342,8 +343,9 @@ func (fd *fileData) getBlocksForOffsetRange(ctx context.Context,
 
 	err = eg.Wait()
 	// If we are ok with just getting the prefix, don't treat a
-	// deadline exceeded error as fatal.
-	if prefixOk && err == context.DeadlineExceeded {
+	// deadline exceeded error (including a block fetch running out
+	// of its share of the deadline) as fatal.
+	if prefixOk && errors.Cause(err) == context.DeadlineExceeded {
 		err = nil
 	}
 	if err != nil {
@@ -600,6 +602,33 @@ func (fd *fileData) read(ctx context.Context, dest []byte, startOff int64) (
 	return currLen, nil
 }
 
+// readInWindows is like read, but reads at most window bytes at a
+// time, so that only the blocks of one window are held in memory at
+// once.  It stops at the first window it can't read completely.
+func (fd *fileData) readInWindows(ctx context.Context, dest []byte,
+	startOff int64, window int64) (int64, error) {
+	if window <= 0 {
+		window = int64(len(dest))
+	}
+	n := int64(0)
+	for n < int64(len(dest)) {
+		end := n + window
+		if end > int64(len(dest)) {
+			end = int64(len(dest))
+		}
+		nRead, err := fd.read(ctx, dest[n:end], startOff+n)
+		if err != nil {
+			return 0, err
+		}
+		n += nRead
+		if n < end {
+			// Either the end of the file, or the deadline.
+			break
+		}
+	}
+	return n, nil
+}
+
 // getBytes returns a buffer containing data from the file, in the
 // half-inclusive range `[startOff, endOff)`.  If `endOff` == -1, it
 // returns data until the end of the file.
@@ -38,6 +38,10 @@ const (
 	// The delay to wait for before trying a failed block deletion
 	// again. Used by enqueueBlocksToDeleteAfterShortDelay().
 	deleteBlocksRetryDelay = 10 * time.Millisecond
+
+	// How long a revision read through an archived view keeps the
+	// blocks it needs in the disk cache.
+	viewedRevisionKeepTime = 1 * time.Hour
 )
 
 type blockDeleteType int
@@ -114,6 +118,58 @@ type folderBlockManager struct {
 	lastQROldEnoughRev  MetadataRevision
 	wasLastQRComplete   bool
 	lastReclamationTime time.Time
+	lastQRUnrefAge      time.Duration
+
+	// Controls for quota reclamation, and what it has done so far,
+	// for the folder status.  Also protected by lastQRLock.
+	qrPaused              bool
+	qrMinHeadAge          time.Duration
+	qrInProgress          bool
+	lastQRErr             error
+	lastQRBytesReclaimed  uint64
+	lastQRPtrsDeleted     int
+	totalQRBytesReclaimed uint64
+	totalQRPtrsDeleted    int
+
+	// challenger checks that the block server still has the blocks
+	// this folder archived.
+	challenger *blockChallenger
+
+	// The IDs of blocks unreferenced by merged revisions this device
+	// has applied, waiting to be dropped from the disk cache, along
+	// with the revision that unreferenced each one.  Also the
+	// earliest revision pinned as of the latest of those revisions,
+	// and the revisions recently read through archived views, along
+	// with when they were last read.
+	diskCacheDropLock sync.Mutex
+	unrefsToDrop      map[kbfsblock.ID]MetadataRevision
+	earliestPinned    MetadataRevision
+	viewedRevs        map[MetadataRevision]time.Time
+}
+
+// QuotaReclamationStatus describes the quota reclamation (QR) for a
+// particular folder-branch.  It is suitable for encoding directly as
+// JSON.
+type QuotaReclamationStatus struct {
+	// Paused is true if periodic QR is turned off for this folder.
+	// Forced QRs still run.
+	Paused     bool
+	InProgress bool
+	// MinHeadAge is how old the head must be, if it was written by
+	// another device, before QR runs.
+	MinHeadAge time.Duration
+	// LastRun is when QR last reclaimed anything, and
+	// LastRunRevision is the most recent revision it covered.
+	LastRun         time.Time
+	LastRunRevision MetadataRevision
+	LastErr         string `json:",omitempty"`
+	// LastBytesReclaimed and LastPointersDeleted describe the most
+	// recent QR run, and the totals cover every run since this
+	// folder was loaded.
+	LastBytesReclaimed   uint64
+	LastPointersDeleted  int
+	TotalBytesReclaimed  uint64
+	TotalPointersDeleted int
 }
 
 func newFolderBlockManager(config Config, fb FolderBranch,
@@ -132,6 +188,9 @@ func newFolderBlockManager(config Config, fb FolderBranch,
 		blocksToDeletePauseChan:   make(chan (<-chan struct{})),
 		forceReclamationChan:      make(chan struct{}, 1),
 		helper:                    helper,
+		challenger:                newBlockChallenger(config, fb.Tlf),
+		unrefsToDrop:              make(map[kbfsblock.ID]MetadataRevision),
+		viewedRevs:                make(map[MetadataRevision]time.Time),
 	}
 	// Pass in the BlockOps here so that the archive goroutine
 	// doesn't do possibly-racy-in-tests access to
@@ -149,6 +208,8 @@ func newFolderBlockManager(config Config, fb FolderBranch,
 	go fbm.deleteBlocksInBackground()
 	if fb.Branch == MasterBranch {
 		go fbm.reclaimQuotaInBackground()
+		go fbm.verifyDiskCacheInBackground()
+		go fbm.challengeBlocksInBackground()
 	}
 	return fbm
 }
@@ -267,7 +328,7 @@ func (fbm *folderBlockManager) enqueueBlocksToDeleteAfterShortDelay(
 		panic(fmt.Sprintf("Backoff stopped while checking whether we "+
 			"should delete revision %d", toDelete.md.Revision()))
 	}
-	time.AfterFunc(duration,
+	fbm.config.Clock().AfterFunc(duration,
 		func() {
 			select {
 			case fbm.blocksToDeleteChan <- toDelete:
@@ -393,6 +454,57 @@ func (fbm *folderBlockManager) waitForQuotaReclamations(
 	return fbm.reclamationGroup.Wait(ctx)
 }
 
+// setQuotaReclamationPaused turns periodic quota reclamation off or
+// back on.  Forced reclamations run either way.
+func (fbm *folderBlockManager) setQuotaReclamationPaused(paused bool) {
+	fbm.lastQRLock.Lock()
+	defer fbm.lastQRLock.Unlock()
+	fbm.qrPaused = paused
+}
+
+func (fbm *folderBlockManager) isQuotaReclamationPaused() bool {
+	fbm.lastQRLock.Lock()
+	defer fbm.lastQRLock.Unlock()
+	return fbm.qrPaused
+}
+
+// setQuotaReclamationMinHeadAge overrides
+// Config.QuotaReclamationMinHeadAge() for this folder.  An age of 0
+// goes back to the config value.
+func (fbm *folderBlockManager) setQuotaReclamationMinHeadAge(
+	age time.Duration) {
+	fbm.lastQRLock.Lock()
+	defer fbm.lastQRLock.Unlock()
+	fbm.qrMinHeadAge = age
+}
+
+func (fbm *folderBlockManager) getMinHeadAgeLocked() time.Duration {
+	if fbm.qrMinHeadAge > 0 {
+		return fbm.qrMinHeadAge
+	}
+	return fbm.config.QuotaReclamationMinHeadAge()
+}
+
+func (fbm *folderBlockManager) getQuotaReclamationStatus() QuotaReclamationStatus {
+	fbm.lastQRLock.Lock()
+	defer fbm.lastQRLock.Unlock()
+	status := QuotaReclamationStatus{
+		Paused:               fbm.qrPaused,
+		InProgress:           fbm.qrInProgress,
+		MinHeadAge:           fbm.getMinHeadAgeLocked(),
+		LastRun:              fbm.lastReclamationTime,
+		LastRunRevision:      fbm.lastQROldEnoughRev,
+		LastBytesReclaimed:   fbm.lastQRBytesReclaimed,
+		LastPointersDeleted:  fbm.lastQRPtrsDeleted,
+		TotalBytesReclaimed:  fbm.totalQRBytesReclaimed,
+		TotalPointersDeleted: fbm.totalQRPtrsDeleted,
+	}
+	if fbm.lastQRErr != nil {
+		status.LastErr = fbm.lastQRErr.Error()
+	}
+	return status
+}
+
 func (fbm *folderBlockManager) forceQuotaReclamation() {
 	fbm.reclamationGroup.Add(1)
 	select {
@@ -491,6 +603,9 @@ func (fbm *folderBlockManager) doChunkedDowngrades(ctx context.Context,
 // no longer have any references.
 func (fbm *folderBlockManager) deleteBlockRefs(ctx context.Context,
 	tlfID tlf.ID, ptrs []BlockPointer) ([]kbfsblock.ID, error) {
+	// Stop challenging these even if the delete fails partway, since
+	// some of them may be gone already.
+	fbm.challenger.removeDeleted(ptrs)
 	return fbm.doChunkedDowngrades(ctx, tlfID, ptrs, false)
 }
 
@@ -672,6 +787,7 @@ func (fbm *folderBlockManager) archiveBlocksInBackground() {
 					fbm.log.CWarningf(ctx, "Couldn't archive blocks: %v", err)
 					return err
 				}
+				fbm.challenger.addArchived(ptrs, md.Revision())
 
 				return nil
 			})
@@ -726,10 +842,20 @@ func (fbm *folderBlockManager) deleteBlocksInBackground() {
 	}
 }
 
-func (fbm *folderBlockManager) isOldEnough(rmd ImmutableRootMetadata) bool {
+// getMinUnrefAge returns how long blocks must stay unreferenced before
+// they can be reclaimed, according to the history retention setting
+// in the given head.  keepAll is true if they must never be
+// reclaimed.
+func (fbm *folderBlockManager) getMinUnrefAge(head ReadOnlyRootMetadata) (
+	unrefAge time.Duration, keepAll bool) {
+	return head.HistoryRetention().minUnrefAge(
+		fbm.config.QuotaReclamationMinUnrefAge())
+}
+
+func (fbm *folderBlockManager) isOldEnough(
+	rmd ImmutableRootMetadata, unrefAge time.Duration) bool {
 	// Trust the server's timestamp on this MD.
 	mtime := rmd.localTimestamp
-	unrefAge := fbm.config.QuotaReclamationMinUnrefAge()
 	return mtime.Add(unrefAge).Before(fbm.config.Clock().Now())
 }
 
@@ -746,6 +872,7 @@ func (fbm *folderBlockManager) getMostRecentOldEnoughAndGCRevisions(
 	currHead := head.Revision()
 	mostRecentOldEnoughRev = MetadataRevisionUninitialized
 	lastGCRev = MetadataRevisionUninitialized
+	unrefAge, _ := fbm.getMinUnrefAge(head)
 	if head.data.LastGCRevision >= MetadataRevisionInitial {
 		fbm.log.CDebugf(ctx, "Found last gc revision %d in "+
 			"head MD revision %d", head.data.LastGCRevision,
@@ -770,10 +897,9 @@ func (fbm *folderBlockManager) getMostRecentOldEnoughAndGCRevisions(
 		for i := len(rmds) - 1; i >= 0; i-- {
 			rmd := rmds[i]
 			if mostRecentOldEnoughRev == MetadataRevisionUninitialized &&
-				fbm.isOldEnough(rmd) {
+				fbm.isOldEnough(rmd, unrefAge) {
 				fbm.log.CDebugf(ctx, "Revision %d is older than the unref "+
-					"age %s", rmd.Revision(),
-					fbm.config.QuotaReclamationMinUnrefAge())
+					"age %s", rmd.Revision(), unrefAge)
 				mostRecentOldEnoughRev = rmd.Revision()
 			}
 
@@ -837,7 +963,7 @@ func (fbm *folderBlockManager) getMostRecentOldEnoughAndGCRevisions(
 func (fbm *folderBlockManager) getUnreferencedBlocks(
 	ctx context.Context, latestRev, earliestRev MetadataRevision) (
 	ptrs []BlockPointer, lastRevConsidered MetadataRevision,
-	complete bool, err error) {
+	unrefBytes uint64, complete bool, err error) {
 	fbm.log.CDebugf(ctx, "Getting unreferenced blocks between revisions "+
 		"%d and %d", earliestRev, latestRev)
 	defer func() {
@@ -851,13 +977,14 @@ func (fbm *folderBlockManager) getUnreferencedBlocks(
 		// Nothing to do.
 		fbm.log.CDebugf(ctx, "Latest rev %d is included in the previous "+
 			"gc op (%d)", latestRev, earliestRev)
-		return nil, MetadataRevisionUninitialized, true, nil
+		return nil, MetadataRevisionUninitialized, 0, true, nil
 	}
 
 	// Walk backward, starting from latestRev, until just after
 	// earliestRev, gathering block pointers.
 	currHead := latestRev
 	revStartPositions := make(map[MetadataRevision]int)
+	revUnrefBytes := make(map[MetadataRevision]uint64)
 outer:
 	for {
 		startRev := currHead - maxMDsAtATime + 1 // (MetadataRevision is signed)
@@ -868,7 +995,7 @@ outer:
 		rmds, err := getMDRange(ctx, fbm.config, fbm.id, NullBranchID, startRev,
 			currHead, Merged)
 		if err != nil {
-			return nil, MetadataRevisionUninitialized, false, err
+			return nil, MetadataRevisionUninitialized, 0, false, err
 		}
 
 		numNew := len(rmds)
@@ -879,6 +1006,7 @@ outer:
 			}
 			// Save the latest revision starting at this position:
 			revStartPositions[rmd.Revision()] = len(ptrs)
+			revUnrefBytes[rmd.Revision()] = rmd.UnrefBytes()
 			for _, op := range rmd.data.Changes.Ops {
 				if _, ok := op.(*GCOp); ok {
 					continue
@@ -942,7 +1070,12 @@ outer:
 		}
 	}
 
-	return ptrs, latestRev, complete, nil
+	for rev, bytes := range revUnrefBytes {
+		if rev <= latestRev {
+			unrefBytes += bytes
+		}
+	}
+	return ptrs, latestRev, unrefBytes, complete, nil
 }
 
 func (fbm *folderBlockManager) finalizeReclamation(ctx context.Context,
@@ -983,12 +1116,18 @@ func (fbm *folderBlockManager) isQRNecessary(
 	}
 	selfWroteHead := session.VerifyingKey == head.LastModifyingWriterVerifyingKey()
 
+	// Don't do reclamation at all if the TLF keeps all its history.
+	unrefAge, keepAll := fbm.getMinUnrefAge(head.ReadOnly())
+	if keepAll {
+		return false
+	}
+
 	// Don't do reclamation if the head isn't old enough and it wasn't
 	// written by this device.  We want to avoid fighting with other
 	// active writers whenever possible.
 	if !selfWroteHead {
 		headAge := fbm.config.Clock().Now().Sub(head.localTimestamp)
-		if headAge < fbm.config.QuotaReclamationMinHeadAge() {
+		if headAge < fbm.getMinHeadAgeLocked() {
 			return false
 		}
 	}
@@ -1012,10 +1151,10 @@ func (fbm *folderBlockManager) isQRNecessary(
 	// Do QR if the head was not reclaimable at the last QR time, but
 	// is old enough now.
 	return fbm.lastQRHeadRev > fbm.lastQROldEnoughRev &&
-		fbm.isOldEnough(head)
+		fbm.isOldEnough(head, unrefAge)
 }
 
-func (fbm *folderBlockManager) doReclamation(timer *time.Timer) (err error) {
+func (fbm *folderBlockManager) doReclamation(timer Timer) (err error) {
 	ctx, cancel := context.WithCancel(fbm.ctxWithFBMID(context.Background()))
 	fbm.setReclamationCancel(cancel)
 	defer fbm.cancelReclamation()
@@ -1060,9 +1199,18 @@ func (fbm *folderBlockManager) doReclamation(timer *time.Timer) (err error) {
 	var mostRecentOldEnoughRev MetadataRevision
 	var complete bool
 	var reclamationTime time.Time
+	var unrefBytes uint64
+	var ptrs []BlockPointer
+	func() {
+		fbm.lastQRLock.Lock()
+		defer fbm.lastQRLock.Unlock()
+		fbm.qrInProgress = true
+	}()
 	defer func() {
 		fbm.lastQRLock.Lock()
 		defer fbm.lastQRLock.Unlock()
+		fbm.qrInProgress = false
+		fbm.lastQRErr = err
 		// Remember the QR we just performed.
 		if err == nil && head != (ImmutableRootMetadata{}) {
 			fbm.lastQRHeadRev = head.Revision()
@@ -1071,6 +1219,13 @@ func (fbm *folderBlockManager) doReclamation(timer *time.Timer) (err error) {
 		}
 		if reclamationTime != (time.Time{}) {
 			fbm.lastReclamationTime = reclamationTime
+			fbm.lastQRUnrefAge, _ = fbm.getMinUnrefAge(head.ReadOnly())
+			if err == nil {
+				fbm.lastQRBytesReclaimed = unrefBytes
+				fbm.lastQRPtrsDeleted = len(ptrs)
+				fbm.totalQRBytesReclaimed += unrefBytes
+				fbm.totalQRPtrsDeleted += len(ptrs)
+			}
 		}
 	}()
 
@@ -1101,7 +1256,15 @@ func (fbm *folderBlockManager) doReclamation(timer *time.Timer) (err error) {
 	if err != nil {
 		return err
 	}
-	if mostRecentOldEnoughRev == MetadataRevisionUninitialized ||
+	// Blocks unreferenced after a pinned revision are needed to
+	// reconstruct it, so stop there.
+	if pinned := head.EarliestPinnedRevision(); pinned !=
+		MetadataRevisionUninitialized && mostRecentOldEnoughRev > pinned {
+		fbm.log.CDebugf(ctx, "Limiting reclamation to pinned revision %d",
+			pinned)
+		mostRecentOldEnoughRev = pinned
+	}
+		if mostRecentOldEnoughRev == MetadataRevisionUninitialized ||
 		mostRecentOldEnoughRev <= lastGCRev {
 		// TODO: need a log level more fine-grained than Debug to
 		// print out that we're not doing reclamation.
@@ -1124,7 +1287,7 @@ func (fbm *folderBlockManager) doReclamation(timer *time.Timer) (err error) {
 		reclamationTime = fbm.config.Clock().Now()
 	}()
 
-	ptrs, latestRev, complete, err :=
+	ptrs, latestRev, unrefBytes, complete, err :=
 		fbm.getUnreferencedBlocks(ctx, mostRecentOldEnoughRev, lastGCRev)
 	if err != nil {
 		return err
@@ -1146,8 +1309,8 @@ func (fbm *folderBlockManager) doReclamation(timer *time.Timer) (err error) {
 }
 
 func (fbm *folderBlockManager) reclaimQuotaInBackground() {
-	timer := time.NewTimer(fbm.config.QuotaReclamationPeriod())
-	timerChan := timer.C
+	timer := fbm.config.Clock().NewTimer(fbm.config.QuotaReclamationPeriod())
+	timerChan := timer.C()
 	for {
 		// Don't let the timer fire if auto-reclamation is turned off.
 		if fbm.config.QuotaReclamationPeriod().Seconds() == 0 {
@@ -1159,6 +1322,10 @@ func (fbm *folderBlockManager) reclaimQuotaInBackground() {
 		case <-fbm.shutdownChan:
 			return
 		case <-timerChan:
+			if fbm.isQuotaReclamationPaused() {
+				timer.Reset(fbm.config.QuotaReclamationPeriod())
+				continue
+			}
 			fbm.reclamationGroup.Add(1)
 		case <-fbm.forceReclamationChan:
 		}
@@ -1178,10 +1345,12 @@ func (fbm *folderBlockManager) reclaimQuotaInBackground() {
 	}
 }
 
-func (fbm *folderBlockManager) getLastQRData() (time.Time, MetadataRevision) {
+func (fbm *folderBlockManager) getLastQRData() (
+	time.Time, time.Duration, MetadataRevision) {
 	fbm.lastQRLock.Lock()
 	defer fbm.lastQRLock.Unlock()
-	return fbm.lastReclamationTime, fbm.lastQROldEnoughRev
+	return fbm.lastReclamationTime, fbm.lastQRUnrefAge,
+		fbm.lastQROldEnoughRev
 }
 
 func (fbm *folderBlockManager) clearLastQRData() {
@@ -1191,4 +1360,181 @@ func (fbm *folderBlockManager) clearLastQRData() {
 	fbm.lastQROldEnoughRev = MetadataRevisionUninitialized
 	fbm.wasLastQRComplete = false
 	fbm.lastReclamationTime = time.Time{}
+	fbm.lastQRUnrefAge = 0
+}
+
+// noteUnrefsForDiskCache remembers the blocks unreferenced by the
+// given merged revision, so they can be dropped from the disk block
+// cache by the next verifyDiskCache.  Blocks that are still live in a
+// pinned revision are never dropped, so they aren't remembered.
+func (fbm *folderBlockManager) noteUnrefsForDiskCache(
+	md ReadOnlyRootMetadata) {
+	if md.MergedStatus() != Merged {
+		return
+	}
+
+	rev := md.Revision()
+	earliestPinned := md.EarliestPinnedRevision()
+	keep := earliestPinned != MetadataRevisionUninitialized &&
+		earliestPinned < rev
+
+	fbm.diskCacheDropLock.Lock()
+	defer fbm.diskCacheDropLock.Unlock()
+	fbm.earliestPinned = earliestPinned
+	for _, op := range md.data.Changes.Ops {
+		for _, ptr := range op.Refs() {
+			delete(fbm.unrefsToDrop, ptr.ID)
+		}
+		for _, update := range op.allUpdates() {
+			if update.Ref != update.Unref {
+				delete(fbm.unrefsToDrop, update.Ref.ID)
+			}
+		}
+		if keep {
+			continue
+		}
+		for _, ptr := range op.Unrefs() {
+			if ptr != zeroPtr {
+				fbm.unrefsToDrop[ptr.ID] = rev
+			}
+		}
+		for _, update := range op.allUpdates() {
+			if update.Ref != update.Unref {
+				fbm.unrefsToDrop[update.Unref.ID] = rev
+			}
+		}
+	}
+}
+
+// noteRevisionViewed keeps the blocks that the given revision needs
+// in the disk cache for a while, since the revision is being read
+// through an archived view.
+func (fbm *folderBlockManager) noteRevisionViewed(rev MetadataRevision) {
+	fbm.diskCacheDropLock.Lock()
+	defer fbm.diskCacheDropLock.Unlock()
+	fbm.viewedRevs[rev] = fbm.config.Clock().Now()
+}
+
+// getUnrefsToDrop returns the IDs of the remembered unreferenced
+// blocks that no pinned or recently-viewed revision needs anymore,
+// and forgets them.  A block unreferenced by revision r is still
+// needed by every revision before r.
+func (fbm *folderBlockManager) getUnrefsToDrop() []kbfsblock.ID {
+	fbm.diskCacheDropLock.Lock()
+	defer fbm.diskCacheDropLock.Unlock()
+
+	earliest := fbm.earliestPinned
+	now := fbm.config.Clock().Now()
+	for rev, viewed := range fbm.viewedRevs {
+		if now.Sub(viewed) > viewedRevisionKeepTime {
+			delete(fbm.viewedRevs, rev)
+			continue
+		}
+		if earliest == MetadataRevisionUninitialized || rev < earliest {
+			earliest = rev
+		}
+	}
+
+	var ids []kbfsblock.ID
+	for id, rev := range fbm.unrefsToDrop {
+		if earliest != MetadataRevisionUninitialized && rev > earliest {
+			continue
+		}
+		ids = append(ids, id)
+		delete(fbm.unrefsToDrop, id)
+	}
+	return ids
+}
+
+// verifyDiskCache drops this TLF's blocks from the disk block cache
+// once the revisions this device has applied have unreferenced them,
+// so the space can be used for more useful blocks.  Blocks still
+// live in a pinned revision, or in a revision recently read through
+// an archived view, are kept.  It returns the number of blocks
+// dropped.
+func (fbm *folderBlockManager) verifyDiskCache(ctx context.Context) (
+	numRemoved int, err error) {
+	dbc := fbm.config.DiskBlockCache()
+	if dbc == nil {
+		return 0, nil
+	}
+
+	ids := fbm.getUnrefsToDrop()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	numRemoved, _, err = dbc.DeleteByTLF(ctx, fbm.id, ids)
+	if err != nil {
+		return 0, err
+	}
+	fbm.log.CDebugf(ctx, "Dropped %d unreferenced blocks (out of %d) "+
+		"from the disk cache", numRemoved, len(ids))
+	return numRemoved, nil
+}
+
+func (fbm *folderBlockManager) verifyDiskCacheInBackground() {
+	period := fbm.config.DiskCacheVerificationPeriod()
+	if period.Seconds() == 0 {
+		return
+	}
+	ticker := fbm.config.Clock().NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fbm.shutdownChan:
+			return
+		case <-ticker.C():
+		}
+
+		fbm.runUnlessShutdown(func(ctx context.Context) (err error) {
+			ctx, cancel := context.WithTimeout(ctx, backgroundTaskTimeout)
+			defer cancel()
+			_, err = fbm.verifyDiskCache(ctx)
+			if err != nil {
+				fbm.log.CDebugf(ctx, "Couldn't verify disk cache: %+v", err)
+			}
+			return err
+		})
+	}
+}
+
+// challengeBlocks issues retrievability challenges for some of the
+// blocks this folder has archived.  It returns the pointers of the
+// blocks that the block server lost or corrupted.
+func (fbm *folderBlockManager) challengeBlocks(ctx context.Context) (
+	[]BlockPointer, error) {
+	return fbm.challenger.challengeRound(ctx,
+		func(ctx context.Context) (MetadataRevision, error) {
+			head, err := fbm.helper.getMostRecentFullyMergedMD(ctx)
+			if err != nil {
+				return MetadataRevisionUninitialized, err
+			}
+			return head.data.LastGCRevision, nil
+		})
+}
+
+func (fbm *folderBlockManager) challengeBlocksInBackground() {
+	period := fbm.config.BlockChallengePeriod()
+	if period.Seconds() == 0 {
+		return
+	}
+	ticker := fbm.config.Clock().NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fbm.shutdownChan:
+			return
+		case <-ticker.C():
+		}
+
+		fbm.runUnlessShutdown(func(ctx context.Context) (err error) {
+			ctx, cancel := context.WithTimeout(ctx, backgroundTaskTimeout)
+			defer cancel()
+			_, err = fbm.challengeBlocks(ctx)
+			if err != nil {
+				fbm.log.CDebugf(ctx, "Couldn't challenge blocks: %+v", err)
+			}
+			return err
+		})
+	}
 }
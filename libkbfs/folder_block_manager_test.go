@@ -12,6 +12,9 @@ import (
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
 
@@ -502,7 +505,8 @@ func TestQuotaReclamationFailAfterRekeyRequest(t *testing.T) {
 
 	// Make sure QR returns an error.
 	ops := config2Dev2.KBFSOps().(*KBFSOpsStandard).getOpsByNode(ctx, rootNode1)
-	timer := time.NewTimer(config2Dev2.QuotaReclamationPeriod())
+	timer := config2Dev2.Clock().NewTimer(
+		config2Dev2.QuotaReclamationPeriod())
 	ops.fbm.reclamationGroup.Add(1)
 	err = ops.fbm.doReclamation(timer)
 	if _, ok := err.(NeedSelfRekeyError); !ok {
@@ -755,3 +759,324 @@ func TestQuotaReclamationGCOpsForGCOps(t *testing.T) {
 		t.Fatalf("Last GCOp revision was unexpected: %d vs %d", g, e)
 	}
 }
+
+func TestFolderBlockManagerVerifyDiskCache(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	cache, cacheConfig := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+	config.diskBlockCache = cache
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	fb := rootNode.GetFolderBranch()
+	ops := getOps(config, fb.Tlf)
+
+	_, buf, serverHalf := setupBlockForDiskCache(t, cacheConfig)
+	cacheADir := func() kbfsblock.ID {
+		id := ops.nodeCache.PathFromNode(aNode).tailPointer().ID
+		err := cache.Put(ctx, fb.Tlf, id, buf, serverHalf)
+		require.NoError(t, err)
+		return id
+	}
+	checkCached := func(expected ...kbfsblock.ID) {
+		blockIDs, err := cache.BlockIDsForTLF(ctx, fb.Tlf)
+		require.NoError(t, err)
+		require.Len(t, blockIDs, len(expected))
+		for _, id := range expected {
+			require.Contains(t, blockIDs, id)
+		}
+	}
+
+	// Nothing has been unreferenced yet, so nothing is dropped.
+	oldID := cacheADir()
+	numRemoved, err := ops.fbm.verifyDiskCache(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, numRemoved)
+
+	// After a new revision, `a` has a new block ID, so its old
+	// block is dropped, but the new one is kept.
+	_, _, err = kbfsOps.CreateFile(ctx, aNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	liveID := cacheADir()
+	numRemoved, err = ops.fbm.verifyDiskCache(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, numRemoved)
+	checkCached(liveID)
+	require.NotEqual(t, oldID, liveID)
+
+	// Blocks still needed by a pinned revision are kept.
+	head, _ := ops.getHead(makeFBOLockState())
+	err = kbfsOps.PinRevision(ctx, fb, head.Revision())
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, aNode, "c", false, NoExcl)
+	require.NoError(t, err)
+	numRemoved, err = ops.fbm.verifyDiskCache(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, numRemoved)
+	checkCached(liveID)
+	err = kbfsOps.UnpinRevision(ctx, fb, head.Revision())
+	require.NoError(t, err)
+
+	// So are blocks needed by a revision read through an archived
+	// view, until the view is old.
+	head, _ = ops.getHead(makeFBOLockState())
+	_, err = GetArchivedRevision(ctx, config, fb.Tlf, head.Revision())
+	require.NoError(t, err)
+	viewedID := cacheADir()
+	_, _, err = kbfsOps.CreateFile(ctx, aNode, "d", false, NoExcl)
+	require.NoError(t, err)
+	numRemoved, err = ops.fbm.verifyDiskCache(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, numRemoved)
+	checkCached(liveID, viewedID)
+
+	clock.Set(now.Add(2 * viewedRevisionKeepTime))
+	numRemoved, err = ops.fbm.verifyDiskCache(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, numRemoved)
+	// The block unreferenced while its revision was pinned is left
+	// to the cache's own eviction.
+	checkCached(liveID)
+}
+
+// Test that the quota reclamation controls take effect, and that the
+// status reflects what the last reclamation did.
+func TestQuotaReclamationControlsAndStatus(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+
+	// Pausing shows up in the status, and the min head age can be
+	// overridden and reset.
+	err := kbfsOps.SetQuotaReclamationPaused(ctx, fb, true)
+	require.NoError(t, err)
+	err = kbfsOps.SetQuotaReclamationMinHeadAge(ctx, fb, time.Minute)
+	require.NoError(t, err)
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.NotNil(t, status.QuotaReclamation)
+	require.True(t, status.QuotaReclamation.Paused)
+	require.Equal(t, time.Minute, status.QuotaReclamation.MinHeadAge)
+	err = kbfsOps.SetQuotaReclamationMinHeadAge(ctx, fb, -time.Minute)
+	require.Error(t, err)
+	err = kbfsOps.SetQuotaReclamationMinHeadAge(ctx, fb, 0)
+	require.NoError(t, err)
+	require.Equal(t, config.QuotaReclamationMinHeadAge(),
+		ops.fbm.getQuotaReclamationStatus().MinHeadAge)
+
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	clock.Set(now.Add(2 * config.QuotaReclamationMinUnrefAge()))
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	err = kbfsOps.SyncFromServerForTesting(ctx, fb)
+	require.NoError(t, err)
+
+	// A forced reclamation still runs while paused.
+	err = kbfsOps.ForceQuotaReclamation(ctx, fb)
+	require.NoError(t, err)
+	err = ops.fbm.waitForQuotaReclamations(ctx)
+	require.NoError(t, err)
+
+	qrStatus := ops.fbm.getQuotaReclamationStatus()
+	require.True(t, qrStatus.Paused)
+	require.False(t, qrStatus.InProgress)
+	require.Equal(t, "", qrStatus.LastErr)
+	require.Equal(t, clock.Now(), qrStatus.LastRun)
+	require.NotEqual(t, 0, qrStatus.LastPointersDeleted)
+	require.NotEqual(t, uint64(0), qrStatus.LastBytesReclaimed)
+	require.Equal(t,
+		qrStatus.LastPointersDeleted, qrStatus.TotalPointersDeleted)
+	require.Equal(t,
+		qrStatus.LastBytesReclaimed, qrStatus.TotalBytesReclaimed)
+
+	// Nothing is left to reclaim, so the next reclamation is skipped
+	// and the status still describes the last real run.
+	err = kbfsOps.SetQuotaReclamationPaused(ctx, fb, false)
+	require.NoError(t, err)
+	err = kbfsOps.ForceQuotaReclamation(ctx, fb)
+	require.NoError(t, err)
+	err = ops.fbm.waitForQuotaReclamations(ctx)
+	require.NoError(t, err)
+	qrStatus2 := ops.fbm.getQuotaReclamationStatus()
+	require.False(t, qrStatus2.Paused)
+	qrStatus2.Paused = true
+	require.Equal(t, qrStatus, qrStatus2)
+}
+
+// corruptingBlockServer returns the wrong data for every block.
+type corruptingBlockServer struct {
+	BlockServer
+}
+
+func (b corruptingBlockServer) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	buf, serverHalf, err := b.BlockServer.Get(ctx, tlfID, id, context)
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	corrupted := append([]byte(nil), buf...)
+	corrupted[0]++
+	return corrupted, serverHalf, nil
+}
+
+// losingBlockServer doesn't have any blocks.
+type losingBlockServer struct {
+	BlockServer
+}
+
+func (b losingBlockServer) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	return nil, kbfscrypto.BlockCryptKeyServerHalf{},
+		kbfsblock.BServerErrorBlockNonExistent{Msg: id.String()}
+}
+
+// Test that retrievability challenges catch archived blocks that the
+// block server corrupted or lost, but not ones that were reclaimed.
+func TestFolderBlockManagerChallengeBlocks(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	_, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	// Wait for outstanding archives.
+	err = kbfsOps.SyncFromServerForTesting(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	tlfID := rootNode.GetFolderBranch().Tlf
+	ops := getOps(config, tlfID)
+	numArchived := ops.fbm.challenger.getStatus().Candidates
+	require.True(t, numArchived > 0)
+	require.True(t, numArchived <= blockChallengesPerRound)
+
+	failed, err := ops.fbm.challengeBlocks(ctx)
+	require.NoError(t, err)
+	require.Len(t, failed, 0)
+	status := ops.fbm.challenger.getStatus()
+	require.Equal(t, numArchived, status.TotalVerified)
+
+	bserver := config.BlockServer()
+	config.SetBlockServer(corruptingBlockServer{bserver})
+	failed, err = ops.fbm.challengeBlocks(ctx)
+	require.NoError(t, err)
+	require.Len(t, failed, numArchived)
+	config.SetBlockServer(bserver)
+
+	// Lose the archived blocks behind the client's back.
+	config.SetBlockServer(losingBlockServer{bserver})
+	defer config.SetBlockServer(bserver)
+	failed, err = ops.fbm.challengeBlocks(ctx)
+	require.NoError(t, err)
+	require.Len(t, failed, numArchived)
+	status = ops.fbm.challenger.getStatus()
+	require.Equal(t, 2*numArchived, status.TotalFailed)
+	require.NotEqual(t, "", status.LastFailure)
+
+	// Once quota reclamation covers them, missing blocks are
+	// expected, and are no longer challenged.
+	failed, err = ops.fbm.challenger.challengeRound(ctx,
+		func(context.Context) (MetadataRevision, error) {
+			return MetadataRevisionInitial + 100, nil
+		})
+	require.NoError(t, err)
+	require.Len(t, failed, 0)
+	status = ops.fbm.challenger.getStatus()
+	require.Equal(t, numArchived, status.TotalReclaimed)
+	require.Equal(t, 0, status.Candidates)
+}
+
+// Test that quota reclamation keeps the blocks of pinned revisions,
+// and that only revisions it hasn't covered can be pinned.
+func TestQuotaReclamationPinnedRevisions(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock := newTestClockNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+
+	err := kbfsOps.SetHistoryRetention(ctx, fb,
+		HistoryRetention{Policy: HistoryRetentionKeepLatest})
+	require.NoError(t, err)
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	aPtr := ops.nodeCache.PathFromNode(aNode).tailPointer()
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	pinned := status.Revision
+
+	err = kbfsOps.PinRevision(ctx, fb, pinned)
+	require.NoError(t, err)
+	_, isNotPinnable := kbfsOps.PinRevision(ctx, fb, pinned+10).(
+		RevisionNotPinnableError)
+	require.True(t, isNotPinnable)
+	revs, err := kbfsOps.GetPinnedRevisions(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, []MetadataRevision{pinned}, revs)
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, []MetadataRevision{pinned}, status.PinnedRevisions)
+
+	// Removing the directory unreferences its block after the pinned
+	// revision, so reclamation has to stop there.
+	err = kbfsOps.RemoveDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	clock.Add(time.Second)
+	err = kbfsOps.ForceQuotaReclamation(ctx, fb)
+	require.NoError(t, err)
+	err = ops.fbm.waitForQuotaReclamations(ctx)
+	require.NoError(t, err)
+	require.Equal(t, pinned,
+		ops.fbm.getQuotaReclamationStatus().LastRunRevision)
+
+	bserverLocal, ok := config.BlockServer().(blockServerLocal)
+	require.True(t, ok)
+	refs, err := bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+	require.NoError(t, err)
+	require.Contains(t, refs, aPtr.ID)
+
+	// Unpinning lets reclamation catch up, after which the old
+	// revision can't be pinned again.
+	clock.Add(time.Second)
+	err = kbfsOps.UnpinRevision(ctx, fb, pinned)
+	require.NoError(t, err)
+	err = ops.fbm.waitForQuotaReclamations(ctx)
+	require.NoError(t, err)
+	revs, err = kbfsOps.GetPinnedRevisions(ctx, fb)
+	require.NoError(t, err)
+	require.Len(t, revs, 0)
+	refs, err = bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+	require.NoError(t, err)
+	require.NotContains(t, refs, aPtr.ID)
+
+	_, isNotPinnable = kbfsOps.PinRevision(ctx, fb, pinned).(
+		RevisionNotPinnableError)
+	require.True(t, isNotPinnable)
+}
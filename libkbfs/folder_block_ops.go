@@ -216,6 +216,11 @@ type folderBlockOps struct {
 	// set to true if this write or truncate should be deferred
 	doDeferWrite bool
 
+	// Small sequential writes that haven't yet been applied to the
+	// file's blocks, keyed by the node being written.  See
+	// Config.WriteCoalescingBytes().
+	coalescedWrites map[NodeID]*coalescedWrite
+
 	// nodeCache itself is goroutine-safe, but write/truncate must
 	// call PathFromNode() only under blockLock (see nodeCache
 	// comments in folder_branch_ops.go).
@@ -240,7 +245,7 @@ func (fbo *folderBlockOps) branch() BranchName {
 func (fbo *folderBlockOps) GetState(lState *lockState) overallBlockState {
 	fbo.blockLock.RLock(lState)
 	defer fbo.blockLock.RUnlock(lState)
-	if len(fbo.deCache) == 0 {
+	if len(fbo.deCache) == 0 && len(fbo.coalescedWrites) == 0 {
 		return cleanState
 	}
 	return dirtyState
@@ -336,7 +341,7 @@ func (fbo *folderBlockOps) getBlockHelperLocked(ctx context.Context,
 		// an on-demand request so that its downstream prefetches are triggered
 		// correctly according to the new on-demand fetch priority.
 		fbo.config.BlockOps().Prefetcher().PrefetchAfterBlockRetrieved(
-			block, ptr, kmd, defaultOnDemandRequestPriority, lifetime,
+			block, ptr, kmd, blockRequestPriorityFromContext(ctx), lifetime,
 			hasPrefetched)
 		return block, nil
 	}
@@ -1189,8 +1194,13 @@ func (fbo *folderBlockOps) PrepRename(
 					return nil, nil, DirEntry{}, nil, err
 				}
 				if de, ok := b.Children[oldParent.tailName()]; ok {
+					session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+					if err != nil {
+						return nil, nil, DirEntry{}, nil, err
+					}
 					de.Ctime = now
 					de.Mtime = now
+					de.setLastWriter(session, md.Revision())
 					b.Children[oldParent.tailName()] = de
 					// Put this block back into the local cache as dirty
 					lbc[oldGrandparent.tailPointer()] = b
@@ -1248,9 +1258,83 @@ func (fbo *folderBlockOps) newFileDataWithCache(lState *lockState,
 		}, fbo.log)
 }
 
+// getFileBlockForSpilledReadLocked is like getFileBlockLocked for a
+// read too big for the in-memory block cache.  Blocks already in
+// memory are used as they are, but others are looked for in spill,
+// and once fetched are put there instead of in the block cache.
+func (fbo *folderBlockOps) getFileBlockForSpilledReadLocked(
+	ctx context.Context, lState *lockState, kmd KeyMetadata,
+	ptr BlockPointer, file path, rtype blockReqType,
+	spill BlockSpillCache) (*FileBlock, error) {
+	if block, err := fbo.config.DirtyBlockCache().Get(
+		fbo.id(), ptr, file.Branch); err == nil {
+		if fblock, ok := block.(*FileBlock); ok {
+			return fblock, nil
+		}
+	}
+	if block, err := fbo.config.BlockCache().Get(ptr); err == nil {
+		if fblock, ok := block.(*FileBlock); ok {
+			return fblock, nil
+		}
+	}
+
+	fblock := NewFileBlock().(*FileBlock)
+	err := spill.Get(ctx, ptr, fblock)
+	switch err.(type) {
+	case nil:
+		return fblock, nil
+	case NoSuchBlockError:
+	default:
+		fbo.log.CDebugf(ctx, "Couldn't get spilled block %v: %+v", ptr, err)
+	}
+
+	block, err := fbo.getBlockHelperLocked(ctx, lState, kmd, ptr,
+		file.Branch, NewFileBlock, NoCacheEntry, file, rtype)
+	if err != nil {
+		return nil, err
+	}
+	fblock, ok := block.(*FileBlock)
+	if !ok {
+		return nil, NotFileBlockError{ptr, file.Branch, file}
+	}
+	err = spill.Put(ctx, ptr, fblock)
+	if err != nil {
+		// The read can go on, it just won't find the block
+		// spilled next time.
+		fbo.log.CDebugf(ctx, "Couldn't spill block %v: %+v", ptr, err)
+	}
+	return fblock, nil
+}
+
+func (fbo *folderBlockOps) newFileDataForSpilledRead(lState *lockState,
+	file path, uid keybase1.UID, kmd KeyMetadata,
+	spill BlockSpillCache) *fileData {
+	fbo.blockLock.AssertRLocked(lState)
+	return newFileData(file, uid, fbo.config.Crypto(),
+		fbo.config.BlockSplitter(), kmd,
+		func(ctx context.Context, kmd KeyMetadata, ptr BlockPointer,
+			file path, rtype blockReqType) (*FileBlock, bool, error) {
+			lState := lState
+			if rtype == blockReadParallel {
+				lState = nil
+			}
+			fblock, err := fbo.getFileBlockForSpilledReadLocked(
+				ctx, lState, kmd, ptr, file, rtype, spill)
+			return fblock, false, err
+		},
+		func(ptr BlockPointer, block Block) error {
+			return errors.New("blocks can't be dirtied by a spilled read")
+		}, fbo.log)
+}
+
 // Read reads from the given file into the given buffer at the given
 // offset. It returns the number of bytes read and nil, or 0 and the
 // error if there was one.
+//
+// If there's a block spill cache, and the read is bigger than the
+// in-memory block cache, the file is read in windows of half the
+// cache's size, and its blocks are spilled to disk rather than
+// cached in memory.
 func (fbo *folderBlockOps) Read(
 	ctx context.Context, lState *lockState, kmd KeyMetadata, file path,
 	dest []byte, off int64) (int64, error) {
@@ -1260,6 +1344,15 @@ func (fbo *folderBlockOps) Read(
 	fbo.log.CDebugf(ctx, "Reading from %v", file.tailPointer())
 
 	var uid keybase1.UID // Data reads don't depend on the uid.
+	if spill := fbo.config.BlockSpillCache(); spill != nil {
+		capacity := int64(fbo.config.BlockCache().GetCleanBytesCapacity())
+		if int64(len(dest)) > capacity {
+			fbo.log.CDebugf(ctx, "Spilling the blocks of a %d-byte read "+
+				"to disk", len(dest))
+			fd := fbo.newFileDataForSpilledRead(lState, file, uid, kmd, spill)
+			return fd.readInWindows(ctx, dest, off, capacity/2)
+		}
+	}
 	fd := fbo.newFileData(lState, file, uid, kmd)
 	return fd.read(ctx, dest, off)
 }
@@ -1456,6 +1549,24 @@ func (fbo *folderBlockOps) Write(
 		return err
 	}
 
+	coalesced, err := fbo.maybeCoalesceWriteLocked(
+		ctx, lState, kmd, file, data, off)
+	if err != nil || coalesced {
+		return err
+	}
+
+	return fbo.writeDataAndNotifyLocked(
+		ctx, lState, kmd, file, filePath, data, off)
+}
+
+// writeDataAndNotifyLocked writes the given data to the given file,
+// notifies observers, and arranges for the write to be replayed if it
+// collided with an ongoing sync.
+func (fbo *folderBlockOps) writeDataAndNotifyLocked(
+	ctx context.Context, lState *lockState, kmd KeyMetadata,
+	file Node, filePath path, data []byte, off int64) error {
+	fbo.blockLock.AssertLocked(lState)
+
 	defer func() {
 		fbo.doDeferWrite = false
 	}()
@@ -1502,6 +1613,129 @@ func (fbo *folderBlockOps) Write(
 	return nil
 }
 
+// coalescedWrite is a run of small, sequential writes to a single
+// file that haven't been applied to the file's blocks yet.
+type coalescedWrite struct {
+	file Node
+	kmd  KeyMetadata
+	off  int64
+	data []byte
+}
+
+// maybeCoalesceWriteLocked buffers `data` if it is small enough to be
+// coalesced with other writes to `file`, and returns true in that
+// case.  Any buffered data that can't be merged with this write is
+// applied first.
+func (fbo *folderBlockOps) maybeCoalesceWriteLocked(
+	ctx context.Context, lState *lockState, kmd KeyMetadata,
+	file Node, data []byte, off int64) (coalesced bool, err error) {
+	fbo.blockLock.AssertLocked(lState)
+
+	limit := fbo.config.WriteCoalescingBytes()
+	small := limit > 0 && int64(len(data)) < limit
+	cw := fbo.coalescedWrites[file.GetID()]
+	if small && cw != nil && cw.off+int64(len(cw.data)) == off &&
+		int64(len(cw.data)+len(data)) <= limit {
+		cw.data = append(cw.data, data...)
+		cw.kmd = kmd
+		if int64(len(cw.data)) >= limit {
+			err := fbo.flushCoalescedWriteLocked(ctx, lState, file.GetID())
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	// This write isn't contiguous with what's buffered, so the
+	// buffered data must land first.
+	if cw != nil {
+		err := fbo.flushCoalescedWriteLocked(ctx, lState, file.GetID())
+		if err != nil {
+			return false, err
+		}
+	}
+	if !small {
+		return false, nil
+	}
+
+	// Make sure we'd be allowed to write this data before
+	// acknowledging it; otherwise the error would only surface at
+	// flush time.
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !kmd.GetTlfHandle().IsWriter(session.UID) {
+		return false, nil
+	}
+
+	if fbo.coalescedWrites == nil {
+		fbo.coalescedWrites = make(map[NodeID]*coalescedWrite)
+	}
+	buf := make([]byte, len(data), limit)
+	copy(buf, data)
+	fbo.coalescedWrites[file.GetID()] = &coalescedWrite{
+		file: file,
+		kmd:  kmd,
+		off:  off,
+		data: buf,
+	}
+	return true, nil
+}
+
+func (fbo *folderBlockOps) flushCoalescedWriteLocked(
+	ctx context.Context, lState *lockState, id NodeID) error {
+	fbo.blockLock.AssertLocked(lState)
+	cw, ok := fbo.coalescedWrites[id]
+	if !ok {
+		return nil
+	}
+	delete(fbo.coalescedWrites, id)
+
+	filePath, err := fbo.pathFromNodeForBlockWriteLocked(lState, cw.file)
+	if err != nil {
+		return err
+	}
+	fbo.log.CDebugf(ctx, "Applying %d coalesced bytes at off=%d to %v",
+		len(cw.data), cw.off, filePath.tailPointer())
+	return fbo.writeDataAndNotifyLocked(
+		ctx, lState, cw.kmd, cw.file, filePath, cw.data, cw.off)
+}
+
+// FlushCoalescedWrites applies any buffered small writes to the
+// blocks of the given file, or of every file in this folder if `file`
+// is nil.  It must be called before any operation that needs to
+// observe the effects of previous writes.
+func (fbo *folderBlockOps) FlushCoalescedWrites(
+	ctx context.Context, lState *lockState, file Node) error {
+	needFlush := func() bool {
+		fbo.blockLock.RLock(lState)
+		defer fbo.blockLock.RUnlock(lState)
+		if file == nil {
+			return len(fbo.coalescedWrites) > 0
+		}
+		_, ok := fbo.coalescedWrites[file.GetID()]
+		return ok
+	}()
+	if !needFlush {
+		return nil
+	}
+
+	fbo.blockLock.Lock(lState)
+	defer fbo.blockLock.Unlock(lState)
+	if file != nil {
+		return fbo.flushCoalescedWriteLocked(ctx, lState, file.GetID())
+	}
+	for id := range fbo.coalescedWrites {
+		err := fbo.flushCoalescedWriteLocked(ctx, lState, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // truncateExtendLocked is called by truncateLocked to extend a file and
 // creates a hole.
 func (fbo *folderBlockOps) truncateExtendLocked(
@@ -1656,6 +1890,11 @@ func (fbo *folderBlockOps) Truncate(
 	fbo.blockLock.Lock(lState)
 	defer fbo.blockLock.Unlock(lState)
 
+	err = fbo.flushCoalescedWriteLocked(ctx, lState, file.GetID())
+	if err != nil {
+		return err
+	}
+
 	filePath, err := fbo.pathFromNodeForBlockWriteLocked(lState, file)
 	if err != nil {
 		return err
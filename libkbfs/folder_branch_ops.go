@@ -5,6 +5,7 @@
 package libkbfs
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"reflect"
@@ -22,6 +23,7 @@ import (
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
 )
 
 // mdReadType indicates whether a read needs identifies.
@@ -243,7 +245,18 @@ type folderBranchOps struct {
 
 	// these locks, when locked concurrently by the same goroutine,
 	// should only be taken in the following order to avoid deadlock:
-	mdWriterLock leveledMutex // taken by any method making MD modifications
+	//
+	// mdWriterLock is taken by any method making MD modifications.
+	// Writes and truncates only dirty blocks under blockLock, so
+	// writers of disjoint parts of the TLF only serialize here,
+	// when they sync or change the directory structure.  That
+	// can't easily be made per-path: every MD revision is a new
+	// root for the whole TLF, and syncing a file rewrites the
+	// blocks of all its ancestors up to that root, so two
+	// concurrent MD writes always overlap at the root directory
+	// and at the revision number.  Finer-grained writes would
+	// need to sync several files into a single revision instead.
+	mdWriterLock leveledMutex
 
 	// protects access to head, headStatus, latestMergedRevision,
 	// and hasBeenCleared.
@@ -285,6 +298,9 @@ type folderBranchOps struct {
 	identifyLock sync.Mutex
 	identifyDone bool
 	identifyTime time.Time
+	// Whether an identify is running in the background, under
+	// IdentifyInBackground.
+	identifyInBackground bool
 
 	// The current status summary for this folder
 	status *folderBranchStatusKeeper
@@ -324,6 +340,39 @@ type folderBranchOps struct {
 	branchChanges      kbfssync.RepeatedWaitGroup
 	mdFlushes          kbfssync.RepeatedWaitGroup
 	forcedFastForwards kbfssync.RepeatedWaitGroup
+
+	// updateRegisterer, if non-nil, is used instead of the
+	// MDServer to register for MD updates.  It must be set before
+	// the head is first set.
+	updateRegisterer mdUpdateRegisterer
+
+	// deviceRevoked, if non-nil, returns an error once this device
+	// has been revoked, which fails all writes and private reads.
+	deviceRevoked func() error
+
+	// protects lastAccess, the last time this folder was handed out
+	// by KBFSOpsStandard.  Used to decide when an idle folder can be
+	// evicted from memory.
+	lastAccessLock sync.Mutex
+	lastAccess     time.Time
+
+	// protects fsyncDurability, the durability level requested for
+	// Syncs in this folder, if it differs from the global one.
+	fsyncDurabilityLock sync.Mutex
+	fsyncDurability     FsyncDurability
+
+	// protects streamFSEvents, which is set when the service has
+	// subscribed to FSEvents for this folder.
+	fsEventsLock   sync.Mutex
+	streamFSEvents bool
+
+	// protects pendingAttrs, the setattr changes waiting to be
+	// written when Config.MetadataOpDelay is set, and
+	// attrFlushScheduled, which is set while a flush of them is
+	// scheduled.
+	pendingAttrsLock   sync.Mutex
+	pendingAttrs       map[NodeID]*pendingAttr
+	attrFlushScheduled bool
 }
 
 var _ KBFSOps = (*folderBranchOps)(nil)
@@ -356,9 +405,16 @@ func newFolderBranchOps(config Config, fb FolderBranch,
 
 	observers := newObserverList()
 
-	mdWriterLock := makeLeveledMutex(mutexLevel(fboMDWriter), &sync.Mutex{})
+	// Keep an eye on the two locks that long-running operations
+	// hold, so that a hung folder can be diagnosed.
+	mdWriterMu := newWatchedRWMutex(fboMDWriter.String(), config.Clock(),
+		log, config.MetricsRegistry())
+	blockMu := newWatchedRWMutex(fboBlock.String(), config.Clock(),
+		log, config.MetricsRegistry())
+
+	mdWriterLock := makeLeveledMutex(mutexLevel(fboMDWriter), mdWriterMu)
 	headLock := makeLeveledRWMutex(mutexLevel(fboHead), &sync.RWMutex{})
-	blockLockMu := makeLeveledRWMutex(mutexLevel(fboBlock), &sync.RWMutex{})
+	blockLockMu := makeLeveledRWMutex(mutexLevel(fboBlock), blockMu)
 
 	forceSyncChan := make(chan struct{})
 
@@ -391,6 +447,7 @@ func newFolderBranchOps(config Config, fb FolderBranch,
 		shutdownChan:    make(chan struct{}),
 		updatePauseChan: make(chan (<-chan struct{})),
 		forceSyncChan:   forceSyncChan,
+		lastAccess:      config.Clock().Now(),
 	}
 	fbo.cr = NewConflictResolver(config, fbo)
 	fbo.fbm = newFolderBlockManager(config, fb, fbo)
@@ -399,6 +456,7 @@ func newFolderBranchOps(config Config, fb FolderBranch,
 	if config.DoBackgroundFlushes() {
 		go fbo.backgroundFlusher(secondsBetweenBackgroundFlushes * time.Second)
 	}
+	go fbo.lockWatchdog([]*watchedRWMutex{mdWriterMu, blockMu})
 
 	return fbo
 }
@@ -414,9 +472,57 @@ func (fbo *folderBranchOps) markForReIdentifyIfNeeded(now time.Time, maxValid ti
 	}
 }
 
+// markAccessed records that this folder is in use as of `now`.
+func (fbo *folderBranchOps) markAccessed(now time.Time) {
+	fbo.lastAccessLock.Lock()
+	defer fbo.lastAccessLock.Unlock()
+	if now.After(fbo.lastAccess) {
+		fbo.lastAccess = now
+	}
+}
+
+// isIdleAndEvictable returns true if this folder hasn't been accessed
+// in at least `idleTimeout`, and it holds no state that would be lost
+// (or would need to be reconstructed by a caller) if this
+// folderBranchOps were shut down and later recreated from the server.
+func (fbo *folderBranchOps) isIdleAndEvictable(
+	lState *lockState, now time.Time, idleTimeout time.Duration) bool {
+	fbo.lastAccessLock.Lock()
+	lastAccess := fbo.lastAccess
+	fbo.lastAccessLock.Unlock()
+	if now.Sub(lastAccess) < idleTimeout {
+		return false
+	}
+
+	// Any outstanding nodes mean some caller (e.g., a mounted
+	// filesystem) is still holding a reference into this folder.
+	if fbo.nodeCache != nil && len(fbo.nodeCache.AllNodes()) > 0 {
+		return false
+	}
+
+	if fbo.blocks.GetState(lState) != cleanState || fbo.hasPendingAttrs() {
+		return false
+	}
+
+	// Don't forget a durability level the user explicitly asked
+	// for.
+	if fbo.getFolderFsyncDurability() != FsyncDurabilityDefault {
+		return false
+	}
+
+	// Unmerged branches are waiting on conflict resolution, so
+	// keep them around.
+	return fbo.isMasterBranch(lState)
+}
+
 // Shutdown safely shuts down any background goroutines that may have
 // been launched by folderBranchOps.
 func (fbo *folderBranchOps) Shutdown(ctx context.Context) error {
+	// KBFSOpsStandard.Shutdown writes any pending setattrs first.
+	// Otherwise the folder is going away with its user, e.g. on a
+	// user switch or a revoke, so the changes can't be written.
+	fbo.discardPendingAttrs(ctx)
+
 	if fbo.config.CheckStateOnShutdown() {
 		lState := makeFBOLockState()
 
@@ -473,6 +579,18 @@ func (fbo *folderBranchOps) DeleteFavorite(ctx context.Context,
 	return errors.New("DeleteFavorite is not supported by folderBranchOps")
 }
 
+func (fbo *folderBranchOps) DeleteFavorites(ctx context.Context,
+	favs []Favorite) error {
+	return errors.New("DeleteFavorites is not supported by folderBranchOps")
+}
+
+func (fbo *folderBranchOps) GetFavoriteCleanupSuggestions(
+	ctx context.Context, params FavoriteCleanupParams) (
+	[]FavoriteSuggestion, error) {
+	return nil, errors.New(
+		"GetFavoriteCleanupSuggestions is not supported by folderBranchOps")
+}
+
 func (fbo *folderBranchOps) AddFavorite(ctx context.Context,
 	fav Favorite) error {
 	return errors.New("AddFavorite is not supported by folderBranchOps")
@@ -496,6 +614,18 @@ func (fbo *folderBranchOps) addToFavoritesByHandle(ctx context.Context,
 		return nil
 	}
 
+	var diskUsage uint64
+	if head := fbo.getTrustedHead(makeFBOLockState()); head !=
+		(ImmutableRootMetadata{}) {
+		diskUsage = head.DiskUsage()
+	}
+	err = favorites.NoteAccess(handle.ToFavorite(), diskUsage)
+	if err != nil {
+		// Usage is only used for suggestions, so don't fail the
+		// operation.
+		fbo.log.CDebugf(ctx, "Couldn't note favorite access: %+v", err)
+	}
+
 	favorites.AddAsync(ctx, handle.toFavToAdd(created))
 	return nil
 }
@@ -680,6 +810,16 @@ func (fbo *folderBranchOps) setHeadLocked(
 		return err
 	}
 
+	dbc, ok := fbo.config.DiskBlockCache().(*DiskBlockCachePartitioned)
+	if ok && md.TlfID().IsPublic() {
+		// Only public TLFs the user can write to share the disk
+		// cache with the private ones; the writers may have
+		// changed, so check on every head.
+		session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+		dbc.setPublicTlfIsOwn(md.TlfID(),
+			err == nil && md.GetTlfHandle().IsWriter(session.UID))
+	}
+
 	// If this is the first time the MD is being set, and we are
 	// operating on unmerged data, initialize the state properly and
 	// kick off conflict resolution.
@@ -754,6 +894,9 @@ func (fbo *folderBranchOps) setHeadLocked(
 	if isFirstHead && headStatus == headTrusted {
 		fbo.headStatus = headTrusted
 	}
+	if !isFirstHead {
+		fbo.fbm.noteUnrefsForDiskCache(md.ReadOnly())
+	}
 	fbo.status.setRootMetadata(md)
 	if isFirstHead {
 		// Start registering for updates right away, using this MD
@@ -869,6 +1012,15 @@ func (fbo *folderBranchOps) setHeadSuccessorLocked(ctx context.Context,
 
 		// If the handle has changed, send out a notification.
 		fbo.observers.tlfHandleChange(ctx, fbo.head.GetTlfHandle())
+		// Let the GUI know if a folder that was shared before
+		// signup can now be read by more of its members.
+		if len(newHandle.UnresolvedWriters())+
+			len(newHandle.UnresolvedReaders()) <
+			len(oldHandle.UnresolvedWriters())+
+				len(oldHandle.UnresolvedReaders()) {
+			fbo.config.Reporter().Notify(ctx,
+				sbsResolvedNotification(oldHandle, newHandle))
+		}
 		// Also the folder should be re-identified given the
 		// newly-resolved assertions.
 		func() {
@@ -943,13 +1095,22 @@ func (fbo *folderBranchOps) identifyOnce(
 	defer fbo.identifyLock.Unlock()
 
 	ei := getExtendedIdentify(ctx)
-	if fbo.identifyDone && !ei.behavior.AlwaysRunIdentify() {
+	h := md.GetTlfHandle()
+	mode := fbo.config.IdentifyPolicy().ModeFor(h.IsPublic())
+	switch {
+	case ei.behavior.AlwaysRunIdentify() || mode == IdentifyAlways:
+	case fbo.identifyDone:
 		// TODO: provide a way for the service to break this cache when identify
 		// state changes on a TLF. For now, we do it this way to make chat work.
 		return nil
+	case mode == IdentifyInBackground:
+		if !fbo.identifyInBackground {
+			fbo.identifyInBackground = true
+			go fbo.identifyInBackgroundOnce(h)
+		}
+		return nil
 	}
 
-	h := md.GetTlfHandle()
 	fbo.log.CDebugf(ctx, "Running identifies on %s", h.GetCanonicalPath())
 	kbpki := fbo.config.KBPKI()
 	err := identifyHandle(ctx, kbpki, kbpki, h)
@@ -972,6 +1133,40 @@ func (fbo *folderBranchOps) identifyOnce(
 	return nil
 }
 
+// identifyInBackgroundOnce identifies the users of h without
+// blocking any operations, and reports any failure.  Either way, the
+// TLF then counts as identified until the identify expires.
+func (fbo *folderBranchOps) identifyInBackgroundOnce(h *TlfHandle) {
+	ctx, cancel := context.WithCancel(
+		fbo.ctxWithFBOID(context.Background()))
+	defer cancel()
+	go func() {
+		select {
+		case <-fbo.shutdownChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	fbo.log.CDebugf(ctx, "Running background identifies on %s",
+		h.GetCanonicalPath())
+	kbpki := fbo.config.KBPKI()
+	err := identifyHandle(ctx, kbpki, kbpki, h)
+	if err != nil {
+		fbo.log.CWarningf(ctx, "Background identify failed: %+v", err)
+		fbo.config.Reporter().ReportErr(ctx, h.GetCanonicalName(),
+			h.IsPublic(), ReadMode, err)
+	} else {
+		fbo.log.CDebugf(ctx, "Background identify finished successfully")
+	}
+
+	fbo.identifyLock.Lock()
+	defer fbo.identifyLock.Unlock()
+	fbo.identifyInBackground = false
+	fbo.identifyDone = true
+	fbo.identifyTime = fbo.config.Clock().Now()
+}
+
 // getMDForReadLocked returns an existing md for a read
 // operation. Note that mds will not be fetched here.
 func (fbo *folderBranchOps) getMDForReadLocked(
@@ -1080,6 +1275,9 @@ func (fbo *folderBranchOps) getMDForReadHelper(
 		return ImmutableRootMetadata{}, err
 	}
 	if !md.TlfID().IsPublic() {
+		if err := fbo.checkDeviceNotRevoked(); err != nil {
+			return ImmutableRootMetadata{}, err
+		}
 		session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
 		if err != nil {
 			return ImmutableRootMetadata{}, err
@@ -1181,8 +1379,78 @@ func (fbo *folderBranchOps) getMDForWriteLocked(
 
 func (fbo *folderBranchOps) getMDForWriteLockedForFilename(
 	ctx context.Context, lState *lockState, filename string) (*RootMetadata, error) {
+	return fbo.getSuccessorMDForWriteLocked(ctx, lState, filename, false)
+}
+
+// checkDeviceNotRevoked returns a DeviceRevokedError if this device
+// has been revoked since the last login.
+func (fbo *folderBranchOps) checkDeviceNotRevoked() error {
+	if fbo.deviceRevoked == nil {
+		return nil
+	}
+	return fbo.deviceRevoked()
+}
+
+// checkTlfNotFrozen returns a TlfFrozenError if md marks the folder
+// as frozen.
+func checkTlfNotFrozen(md *RootMetadata) error {
+	if md.IsFrozen() {
+		return TlfFrozenError{md.GetTlfHandle().GetCanonicalName()}
+	}
+	return nil
+}
+
+// checkTlfNotAppendOnly returns a TlfAppendOnlyError for the given
+// operation if md marks the folder as append-only.
+func checkTlfNotAppendOnly(md *RootMetadata, op string) error {
+	if md.IsAppendOnly() {
+		return TlfAppendOnlyError{md.GetTlfHandle().GetCanonicalName(), op}
+	}
+	return nil
+}
+
+// checkAppendOnlyWrite returns a TlfAppendOnlyError if md marks the
+// folder as append-only, and changing file at offset off would touch
+// data that the file already holds.
+func (fbo *folderBranchOps) checkAppendOnlyWrite(ctx context.Context,
+	lState *lockState, md ImmutableRootMetadata, file Node, off uint64,
+	op string) error {
+	if !md.IsAppendOnly() {
+		return nil
+	}
+	// Buffered small writes aren't reflected in the file size
+	// until they're applied.
+	err := fbo.blocks.FlushCoalescedWrites(ctx, lState, file)
+	if err != nil {
+		return err
+	}
+	filePath, err := fbo.pathFromNodeForRead(file)
+	if err != nil {
+		return err
+	}
+	de, err := fbo.blocks.GetDirtyEntry(ctx, lState, md.ReadOnly(), filePath)
+	if err != nil {
+		return err
+	}
+	if off < de.Size {
+		return TlfAppendOnlyError{md.GetTlfHandle().GetCanonicalName(), op}
+	}
+	return nil
+}
+
+// getSuccessorMDForWriteLocked is like getMDForWriteLockedForFilename,
+// but if allowFrozen is true it doesn't fail when the folder is
+// frozen.  Only writes that leave the folder's data untouched, like
+// GC and (un)freezing itself, should pass true.
+func (fbo *folderBranchOps) getSuccessorMDForWriteLocked(
+	ctx context.Context, lState *lockState, filename string,
+	allowFrozen bool) (*RootMetadata, error) {
 	fbo.mdWriterLock.AssertLocked(lState)
 
+	if err := fbo.checkDeviceNotRevoked(); err != nil {
+		return nil, err
+	}
+
 	md, err := fbo.getMDForWriteOrRekeyLocked(ctx, lState, mdWrite)
 	if err != nil {
 		return nil, err
@@ -1197,6 +1465,12 @@ func (fbo *folderBranchOps) getMDForWriteLockedForFilename(
 			md.GetTlfHandle(), session.Name, filename)
 	}
 
+	if !allowFrozen {
+		if err := checkTlfNotFrozen(md.RootMetadata); err != nil {
+			return nil, err
+		}
+	}
+
 	// Make a new successor of the current MD to hold the coming
 	// writes.  The caller must pass this into
 	// syncBlockAndCheckEmbedLocked or the changes will be lost.
@@ -1216,6 +1490,10 @@ func (fbo *folderBranchOps) getMDForRekeyWriteLocked(
 	wasRekeySet bool, err error) {
 	fbo.mdWriterLock.AssertLocked(lState)
 
+	if err := fbo.checkDeviceNotRevoked(); err != nil {
+		return nil, kbfscrypto.VerifyingKey{}, false, err
+	}
+
 	md, err := fbo.getMDForWriteOrRekeyLocked(ctx, lState, mdRekey)
 	if err != nil {
 		return nil, kbfscrypto.VerifyingKey{}, false, err
@@ -1680,6 +1958,7 @@ func (fbo *folderBranchOps) GetDirChildren(ctx context.Context, dir Node) (
 		fbo.deferLog.CDebugf(ctx, "GetDirChildren %s done: %+v",
 			getNodeIDStr(dir), err)
 	}()
+	ctx = maybeNewContextWithBlockRequestOp(ctx, BlockRequestOpReaddir)
 
 	err = fbo.checkNode(dir)
 	if err != nil {
@@ -1690,6 +1969,13 @@ func (fbo *folderBranchOps) GetDirChildren(ctx context.Context, dir Node) (
 		var err error
 		lState := makeFBOLockState()
 
+		// Make sure the sizes of any recently-written children are
+		// up to date.
+		err = fbo.blocks.FlushCoalescedWrites(ctx, lState, nil)
+		if err != nil {
+			return err
+		}
+
 		md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
 		if err != nil {
 			return err
@@ -1718,6 +2004,13 @@ func (fbo *folderBranchOps) GetDirChildren(ctx context.Context, dir Node) (
 		if err != nil {
 			return err
 		}
+		for name, ei := range children {
+			if ei.LinkID != "" {
+				applyLinkCount(md.ReadOnly(), &ei)
+				children[name] = ei
+			}
+		}
+		fbo.applyPendingAttrsToChildren(dirPath, children)
 		return nil
 	})
 	if err != nil {
@@ -1733,6 +2026,7 @@ func (fbo *folderBranchOps) Lookup(ctx context.Context, dir Node, name string) (
 		fbo.deferLog.CDebugf(ctx, "Lookup %s %s done: %v %+v",
 			getNodeIDStr(dir), name, getNodeIDStr(node), err)
 	}()
+	ctx = maybeNewContextWithBlockRequestOp(ctx, BlockRequestOpReaddir)
 
 	err = fbo.checkNode(dir)
 	if err != nil {
@@ -1743,20 +2037,40 @@ func (fbo *folderBranchOps) Lookup(ctx context.Context, dir Node, name string) (
 	err = runUnlessCanceled(ctx, func() error {
 		lState := makeFBOLockState()
 
+		err := fbo.blocks.FlushCoalescedWrites(ctx, lState, nil)
+		if err != nil {
+			return err
+		}
+
 		md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
 		if err != nil {
 			return err
 		}
 
 		node, de, err = fbo.blocks.Lookup(ctx, lState, md.ReadOnly(), dir, name)
+		if _, ok := err.(NoSuchNameError); ok {
+			// The entry may have been created under another
+			// unicode normalization of the name.
+			for _, other := range otherNormalizations(name) {
+				node, de, err = fbo.blocks.Lookup(
+					ctx, lState, md.ReadOnly(), dir, other)
+				if _, ok := err.(NoSuchNameError); !ok {
+					break
+				}
+			}
+		}
 		if err != nil {
 			return err
 		}
+		applyLinkCount(md.ReadOnly(), &de.EntryInfo)
 		return nil
 	})
 	if err != nil {
 		return nil, EntryInfo{}, err
 	}
+	if node != nil {
+		fbo.applyPendingAttr(node, &de.EntryInfo)
+	}
 	return node, de.EntryInfo, nil
 }
 
@@ -1771,6 +2085,11 @@ func (fbo *folderBranchOps) statEntry(ctx context.Context, node Node) (
 
 	lState := makeFBOLockState()
 
+	err = fbo.blocks.FlushCoalescedWrites(ctx, lState, node)
+	if err != nil {
+		return DirEntry{}, err
+	}
+
 	nodePath, err := fbo.pathFromNodeForRead(node)
 	if err != nil {
 		return DirEntry{}, err
@@ -1794,7 +2113,8 @@ func (fbo *folderBranchOps) statEntry(ctx context.Context, node Node) (
 		if err != nil {
 			return DirEntry{}, err
 		}
-
+		applyLinkCount(md.ReadOnly(), &de.EntryInfo)
+		fbo.applyPendingAttr(node, &de.EntryInfo)
 	} else {
 		// nodePath is just the root.
 		de = md.data.Dir
@@ -2046,6 +2366,10 @@ func (fbo *folderBranchOps) syncBlock(
 	var newDe DirEntry
 	doSetTime := true
 	now := fbo.nowUnixNano()
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return path{}, DirEntry{}, nil, err
+	}
 	for len(newPath.path) < len(dir.path)+1 {
 		info, plainSize, err := fbo.readyBlockMultiple(
 			ctx, md.ReadOnly(), currBlock, uid, bps, keybase1.BlockType_DATA)
@@ -2145,13 +2469,14 @@ func (fbo *folderBranchOps) syncBlock(
 		}
 		de.BlockInfo = info
 
-		if doSetTime {
+		if doSetTime && (mtime || ctime) {
 			if mtime {
 				de.Mtime = now
 			}
 			if ctime {
 				de.Ctime = now
 			}
+			de.setLastWriter(session, md.Revision())
 		}
 		if !newDe.IsInitialized() {
 			newDe = de
@@ -2183,6 +2508,18 @@ func (fbo *folderBranchOps) syncBlock(
 }
 
 // syncBlockLock calls syncBlock under mdWriterLock.
+// setLastWriter records the current user and device as the last
+// writer of ei, in the revision md is about to become.
+func (fbo *folderBranchOps) setLastWriter(
+	ctx context.Context, md *RootMetadata, ei *EntryInfo) error {
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return err
+	}
+	ei.setLastWriter(session, md.Revision())
+	return nil
+}
+
 func (fbo *folderBranchOps) syncBlockLocked(
 	ctx context.Context, lState *lockState, uid keybase1.UID,
 	md *RootMetadata, newBlock Block, dir path, name string,
@@ -2229,6 +2566,7 @@ func (fbo *folderBranchOps) syncBlockAndCheckEmbedLocked(ctx context.Context,
 	// Do the block changes need their own blocks?  Unembed only if
 	// this is the final call to this function with this MD.
 	if stopAt == zeroPtr {
+		updateCoalescedSetAttrDirs(md)
 		bsplit := fbo.config.BlockSplitter()
 		if !bsplit.ShouldEmbedBlockChanges(&md.data.Changes) {
 			err = fbo.unembedBlockChanges(
@@ -2561,7 +2899,9 @@ func (fbo *folderBranchOps) finalizeGCOp(ctx context.Context, gco *GCOp) (
 	fbo.mdWriterLock.Lock(lState)
 	defer fbo.mdWriterLock.Unlock(lState)
 
-	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	// GC doesn't change the folder's data, so it's allowed even
+	// when the folder is frozen.
+	md, err := fbo.getSuccessorMDForWriteLocked(ctx, lState, "", true)
 	if err != nil {
 		return err
 	}
@@ -2576,6 +2916,19 @@ func (fbo *folderBranchOps) finalizeGCOp(ctx context.Context, gco *GCOp) (
 	// `gco.LatestRev+1`.
 	md.SetLastGCRevision(gco.LatestRev)
 
+	// Don't allow garbage collection to put us into a conflicting
+	// state; just wait for the next period.
+	return fbo.finalizeMergedOnlyMDWriteLocked(ctx, lState, md)
+}
+
+// finalizeMergedOnlyMDWriteLocked writes out md, which must not
+// contain any changes to the folder's data, directly to the merged
+// branch.  Unlike finalizeMDWriteLocked, it never falls back to an
+// unmerged put; a conflict is just returned as an error.
+func (fbo *folderBranchOps) finalizeMergedOnlyMDWriteLocked(
+	ctx context.Context, lState *lockState, md *RootMetadata) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
 	bps, err := fbo.maybeUnembedAndPutBlocks(ctx, md)
 	if err != nil {
 		return err
@@ -2595,8 +2948,6 @@ func (fbo *folderBranchOps) finalizeGCOp(ctx context.Context, gco *GCOp) (
 	// finally, write out the new metadata
 	mdID, err := fbo.config.MDOps().Put(ctx, md)
 	if err != nil {
-		// Don't allow garbage collection to put us into a conflicting
-		// state; just wait for the next period.
 		return err
 	}
 
@@ -2743,6 +3094,12 @@ func (fbo *folderBranchOps) createEntryLocked(
 		return nil, DirEntry{}, err
 	}
 
+	name = md.NameNormalization().newEntryName(dblock.Children, name)
+	if uint32(len(name)) > fbo.config.MaxNameBytes() {
+		return nil, DirEntry{},
+			NameTooLongError{name, fbo.config.MaxNameBytes()}
+	}
+
 	// does name already exist?
 	if _, ok := dblock.Children[name]; ok {
 		return nil, DirEntry{}, NameExistsError{name}
@@ -3004,6 +3361,12 @@ func (fbo *folderBranchOps) createLinkLocked(
 
 	// TODO: validate inputs
 
+	fromName = md.NameNormalization().newEntryName(dblock.Children, fromName)
+	if uint32(len(fromName)) > fbo.config.MaxNameBytes() {
+		return DirEntry{},
+			NameTooLongError{fromName, fbo.config.MaxNameBytes()}
+	}
+
 	// does name already exist?
 	if _, ok := dblock.Children[fromName]; ok {
 		return DirEntry{}, NameExistsError{fromName}
@@ -3072,100 +3435,377 @@ func (fbo *folderBranchOps) CreateLink(
 	return retEntryInfo, nil
 }
 
-// unrefEntry modifies md to unreference all relevant blocks for the
-// given entry.
-func (fbo *folderBranchOps) unrefEntry(ctx context.Context,
-	lState *lockState, md *RootMetadata, dir path, de DirEntry,
-	name string) error {
-	md.AddUnrefBlock(de.BlockInfo)
-	// construct a path for the child so we can unlink with it.
-	childPath := dir.ChildPath(name, de.BlockPointer)
+// copyFileBlocksForLinkLocked makes a copy of the blocks of the file
+// at the given path for a new link to it, adding new references to
+// all the file's leaf blocks rather than copying their data (except
+// when journaling, which doesn't support new references yet).  It
+// returns the block info of the copy's top block, and the blocks to
+// put for the copy.
+func (fbo *folderBranchOps) copyFileBlocksForLinkLocked(
+	ctx context.Context, lState *lockState, md *RootMetadata, file path,
+	de DirEntry) (BlockInfo, *blockPutState, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
 
-	// If this is an indirect block, we need to delete all of its
-	// children as well. NOTE: non-empty directories can't be
-	// removed, so no need to check for indirect directory blocks
-	// here.
-	if de.Type == File || de.Type == Exec {
-		blockInfos, err := fbo.blocks.GetIndirectFileBlockInfos(
-			ctx, lState, md.ReadOnly(), childPath)
-		if isRecoverableBlockErrorForRemoval(err) {
-			msg := fmt.Sprintf("Recoverable block error encountered for unrefEntry(%v); continuing", childPath)
-			fbo.log.CWarningf(ctx, "%s", msg)
-			fbo.log.CDebugf(ctx, "%s (err=%v)", msg, err)
-		} else if err != nil {
-			return err
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return BlockInfo{}, nil, err
+	}
+
+	dirtyBcache := simpleDirtyBlockCacheStandard()
+	// Simple dirty bcaches don't need to be shut down.
+	newPtr, _, err := fbo.blocks.DeepCopyFile(
+		ctx, lState, md.ReadOnly(), file, dirtyBcache,
+		fbo.config.DataVersion())
+	if err != nil {
+		return BlockInfo{}, nil, err
+	}
+	block, err := dirtyBcache.Get(fbo.id(), newPtr, fbo.branch())
+	if err != nil {
+		return BlockInfo{}, nil, err
+	}
+	fblock, ok := block.(*FileBlock)
+	if !ok {
+		return BlockInfo{}, nil, NotFileBlockError{newPtr, fbo.branch(), file}
+	}
+
+	bps := newBlockPutState(1)
+	journaled := TLFJournalEnabled(fbo.config, fbo.id())
+	if !fblock.IsInd && !journaled {
+		// The copy's top block is just a new reference to the
+		// original's.
+		info := BlockInfo{BlockPointer: newPtr, EncodedSize: de.EncodedSize}
+		bps.addNewBlock(newPtr, nil, ReadyBlockData{}, nil)
+		md.AddRefBlock(info)
+		return info, bps, nil
+	}
+
+	if fblock.IsInd {
+		var infos []BlockInfo
+		if journaled {
+			infos, err = fbo.blocks.UndupChildrenInCopy(
+				ctx, lState, md.ReadOnly(), file, bps, dirtyBcache, fblock)
+			if err != nil {
+				return BlockInfo{}, nil, err
+			}
+		} else {
+			_, err = fbo.blocks.ReadyNonLeafBlocksInCopy(
+				ctx, lState, md.ReadOnly(), file, bps, dirtyBcache, fblock)
+			if err != nil {
+				return BlockInfo{}, nil, err
+			}
+
+			infos, err = fbo.blocks.GetIndirectFileBlockInfosWithTopBlock(
+				ctx, lState, md.ReadOnly(), file, fblock)
+			if err != nil {
+				return BlockInfo{}, nil, err
+			}
+			for _, info := range infos {
+				// The indirect blocks were already added to bps,
+				// so only add the new references to leaf blocks.
+				if info.RefNonce != kbfsblock.ZeroRefNonce {
+					bps.addNewBlock(
+						info.BlockPointer, nil, ReadyBlockData{}, nil)
+				}
+			}
 		}
-		for _, blockInfo := range blockInfos {
-			md.AddUnrefBlock(blockInfo)
+		for _, info := range infos {
+			md.AddRefBlock(info)
 		}
 	}
-	return nil
+
+	// The copy's top block has new contents (or, when journaling,
+	// can't be a new reference), so it's a brand new block.
+	info, _, err := fbo.readyBlockMultiple(
+		ctx, md.ReadOnly(), fblock, session.UID, bps,
+		keybase1.BlockType_DATA)
+	if err != nil {
+		return BlockInfo{}, nil, err
+	}
+	md.AddRefBlock(info)
+	return info, bps, nil
 }
 
-func (fbo *folderBranchOps) removeEntryLocked(ctx context.Context,
-	lState *lockState, md *RootMetadata, dir path, name string) error {
+func (fbo *folderBranchOps) linkLocked(
+	ctx context.Context, lState *lockState, file Node, dir Node,
+	name string) (DirEntry, error) {
 	fbo.mdWriterLock.AssertLocked(lState)
 
-	pblock, err := fbo.blocks.GetDir(
-		ctx, lState, md.ReadOnly(), dir, blockWrite)
-	if err != nil {
-		return err
+	if err := checkDisallowedPrefixes(name); err != nil {
+		return DirEntry{}, err
 	}
 
-	// make sure the entry exists
-	de, ok := pblock.Children[name]
-	if !ok {
-		return NoSuchNameError{name}
+	if uint32(len(name)) > fbo.config.MaxNameBytes() {
+		return DirEntry{}, NameTooLongError{name, fbo.config.MaxNameBytes()}
 	}
 
-	ro, err := newRmOp(name, dir.tailPointer())
+	filePath, err := fbo.pathFromNodeForMDWriteLocked(lState, file)
 	if err != nil {
-		return err
-	}
-	ro.setFinalPath(dir)
-	md.AddOp(ro)
-	err = fbo.unrefEntry(ctx, lState, md, dir, de, name)
-	if err != nil {
-		return err
+		return DirEntry{}, err
 	}
 
-	// the actual unlink
-	delete(pblock.Children, name)
-
-	// sync the parent directory
-	_, err = fbo.syncBlockAndFinalizeLocked(
-		ctx, lState, md, pblock, *dir.parentPath(), dir.tailName(),
-		Dir, true, true, zeroPtr, NoExcl)
+	// The copy is made from the file's synced blocks, so first
+	// sync any outstanding writes to it.
+	stillDirty, err := fbo.syncLocked(ctx, lState, filePath)
 	if err != nil {
-		return err
+		return DirEntry{}, err
 	}
-	return nil
-}
-
-func (fbo *folderBranchOps) removeDirLocked(ctx context.Context,
-	lState *lockState, dir Node, dirName string) (err error) {
-	fbo.mdWriterLock.AssertLocked(lState)
+	if stillDirty {
+		return DirEntry{}, errors.Errorf(
+			"%s was written to while being linked", filePath)
+	}
+	fbo.status.rmDirtyNode(file)
 
 	// verify we have permission to write
 	md, err := fbo.getMDForWriteLocked(ctx, lState)
 	if err != nil {
-		return err
+		return DirEntry{}, err
 	}
 
+	// The sync may have changed the paths.
+	filePath, err = fbo.pathFromNodeForMDWriteLocked(lState, file)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	fileParent := *filePath.parentPath()
 	dirPath, err := fbo.pathFromNodeForMDWriteLocked(lState, dir)
 	if err != nil {
-		return err
+		return DirEntry{}, err
 	}
 
-	pblock, err := fbo.blocks.GetDir(
-		ctx, lState, md.ReadOnly(), dirPath, blockRead)
-	de, ok := pblock.Children[dirName]
+	fileDblock, err := fbo.blocks.GetDir(
+		ctx, lState, md.ReadOnly(), fileParent, blockWrite)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	fileDe, ok := fileDblock.Children[filePath.tailName()]
 	if !ok {
-		return NoSuchNameError{dirName}
+		return DirEntry{}, NoSuchNameError{filePath.tailName()}
+	}
+	if fileDe.Type != File && fileDe.Type != Exec {
+		return DirEntry{}, NotFileError{filePath}
 	}
 
-	// construct a path for the child so we can check for an empty dir
-	childPath := dirPath.ChildPath(dirName, de.BlockPointer)
+	dblock := fileDblock
+	if dirPath.tailPointer().ID != fileParent.tailPointer().ID {
+		dblock, err = fbo.blocks.GetDir(
+			ctx, lState, md.ReadOnly(), dirPath, blockWrite)
+		if err != nil {
+			return DirEntry{}, err
+		}
+	}
+
+	name = md.NameNormalization().newEntryName(dblock.Children, name)
+	if uint32(len(name)) > fbo.config.MaxNameBytes() {
+		return DirEntry{}, NameTooLongError{name, fbo.config.MaxNameBytes()}
+	}
+
+	// does name already exist?
+	if _, ok := dblock.Children[name]; ok {
+		return DirEntry{}, NameExistsError{name}
+	}
+
+	if err := fbo.checkNewDirSize(
+		ctx, lState, md.ReadOnly(), dirPath, name); err != nil {
+		return DirEntry{}, err
+	}
+
+	co, err := newCreateOp(name, dirPath.tailPointer(), fileDe.Type)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	co.setFinalPath(dirPath)
+	md.AddOp(co)
+
+	info, bps, err := fbo.copyFileBlocksForLinkLocked(
+		ctx, lState, md, filePath, fileDe)
+	if err != nil {
+		return DirEntry{}, err
+	}
+
+	// The new entry joins the file's link group, which starts out
+	// with just the file itself.  Only the file's ctime changes.
+	if fileDe.LinkID == "" {
+		fileDe.LinkID = fileDe.ID.String()
+	}
+	md.addLinks(fileDe.LinkID, 1)
+	now := fbo.nowUnixNano()
+	fileDe.Ctime = now
+	err = fbo.setLastWriter(ctx, md, &fileDe.EntryInfo)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	fileDblock.Children[filePath.tailName()] = fileDe
+
+	newDe := fileDe
+	newDe.BlockInfo = info
+	dblock.Children[name] = newDe
+
+	err = fbo.syncParentsAndFinalizeLocked(
+		ctx, lState, md, fileParent, fileDblock, dirPath, dblock,
+		make(localBcache), bps)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	applyLinkCount(md.ReadOnly(), &newDe.EntryInfo)
+	return newDe, nil
+}
+
+// applyLinkCount fills in ei.Nlink from md's count for ei's link
+// group.
+func applyLinkCount(md ReadOnlyRootMetadata, ei *EntryInfo) {
+	if ei.LinkID != "" {
+		ei.Nlink = md.LinkCount(ei.LinkID)
+	}
+}
+
+func (fbo *folderBranchOps) Link(
+	ctx context.Context, file Node, dir Node, name string) (
+	ei EntryInfo, err error) {
+	fbo.log.CDebugf(ctx, "Link %s -> %s/%s",
+		getNodeIDStr(file), getNodeIDStr(dir), name)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "Link %s -> %s/%s done: %+v",
+			getNodeIDStr(file), getNodeIDStr(dir), name, err)
+	}()
+
+	err = fbo.checkNode(file)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	err = fbo.checkNode(dir)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+
+	err = fbo.blocks.FlushCoalescedWrites(ctx, makeFBOLockState(), file)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	err = fbo.flushPendingAttrs(ctx, file)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+
+	var retEntryInfo EntryInfo
+	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			// Don't set ei directly, as that can cause a race when
+			// the Link is canceled.
+			de, err := fbo.linkLocked(ctx, lState, file, dir, name)
+			retEntryInfo = de.EntryInfo
+			return err
+		})
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	return retEntryInfo, nil
+}
+
+// unrefEntry modifies md to unreference all relevant blocks for the
+// given entry.
+func (fbo *folderBranchOps) unrefEntry(ctx context.Context,
+	lState *lockState, md *RootMetadata, dir path, de DirEntry,
+	name string) error {
+	md.AddUnrefBlock(de.BlockInfo)
+	if de.LinkID != "" {
+		md.addLinks(de.LinkID, -1)
+	}
+	// construct a path for the child so we can unlink with it.
+	childPath := dir.ChildPath(name, de.BlockPointer)
+
+	// If this is an indirect block, we need to delete all of its
+	// children as well. NOTE: non-empty directories can't be
+	// removed, so no need to check for indirect directory blocks
+	// here.
+	if de.Type == File || de.Type == Exec {
+		blockInfos, err := fbo.blocks.GetIndirectFileBlockInfos(
+			ctx, lState, md.ReadOnly(), childPath)
+		if isRecoverableBlockErrorForRemoval(err) {
+			msg := fmt.Sprintf("Recoverable block error encountered for unrefEntry(%v); continuing", childPath)
+			fbo.log.CWarningf(ctx, "%s", msg)
+			fbo.log.CDebugf(ctx, "%s (err=%v)", msg, err)
+		} else if err != nil {
+			return err
+		}
+		for _, blockInfo := range blockInfos {
+			md.AddUnrefBlock(blockInfo)
+		}
+	}
+	return nil
+}
+
+func (fbo *folderBranchOps) removeEntryLocked(ctx context.Context,
+	lState *lockState, md *RootMetadata, dir path, name string) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if err := checkTlfNotAppendOnly(md, "removing entries"); err != nil {
+		return err
+	}
+
+	pblock, err := fbo.blocks.GetDir(
+		ctx, lState, md.ReadOnly(), dir, blockWrite)
+	if err != nil {
+		return err
+	}
+
+	// make sure the entry exists
+	existing, ok := existingEntryName(pblock.Children, name)
+	if !ok {
+		return NoSuchNameError{name}
+	}
+	name = existing
+	de := pblock.Children[name]
+
+	ro, err := newRmOp(name, dir.tailPointer())
+	if err != nil {
+		return err
+	}
+	ro.setFinalPath(dir)
+	md.AddOp(ro)
+	err = fbo.unrefEntry(ctx, lState, md, dir, de, name)
+	if err != nil {
+		return err
+	}
+
+	// the actual unlink
+	delete(pblock.Children, name)
+
+	// sync the parent directory
+	_, err = fbo.syncBlockAndFinalizeLocked(
+		ctx, lState, md, pblock, *dir.parentPath(), dir.tailName(),
+		Dir, true, true, zeroPtr, NoExcl)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (fbo *folderBranchOps) removeDirLocked(ctx context.Context,
+	lState *lockState, dir Node, dirName string) (err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	// verify we have permission to write
+	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return err
+	}
+
+	dirPath, err := fbo.pathFromNodeForMDWriteLocked(lState, dir)
+	if err != nil {
+		return err
+	}
+
+	pblock, err := fbo.blocks.GetDir(
+		ctx, lState, md.ReadOnly(), dirPath, blockRead)
+	existing, ok := existingEntryName(pblock.Children, dirName)
+	if !ok {
+		return NoSuchNameError{dirName}
+	}
+	dirName = existing
+	de := pblock.Children[dirName]
+
+	// construct a path for the child so we can check for an empty dir
+	childPath := dirPath.ChildPath(dirName, de.BlockPointer)
 
 	childBlock, err := fbo.blocks.GetDir(
 		ctx, lState, md.ReadOnly(), childPath, blockRead)
@@ -3231,59 +3871,364 @@ func (fbo *folderBranchOps) RemoveEntry(ctx context.Context, dir Node,
 		})
 }
 
-func (fbo *folderBranchOps) renameLocked(
-	ctx context.Context, lState *lockState, oldParent path,
-	oldName string, newParent path, newName string) (err error) {
+// unrefDirTree modifies md to remove everything in the directory
+// tree rooted at the given entry, but not the entry itself.  Every
+// entry in the tree gets its own rmOp, deepest entries first, which
+// unreferences the entry's blocks; that way, conflict resolution and
+// notifications see each removed entry, like they would if the tree
+// was removed one entry at a time.  The directory and file blocks of
+// the tree are fetched in parallel, up to maxParallelBlockGets at a
+// time.  It returns true, without changing md, if any file in the
+// tree has unsynced writes.
+func (fbo *folderBranchOps) unrefDirTree(ctx context.Context,
+	lState *lockState, md *RootMetadata, dir path, de DirEntry,
+	name string) (hasDirty bool, err error) {
+	dirtyRefs := make(map[BlockRef]bool)
+	for _, ref := range fbo.blocks.GetDirtyRefs(lState) {
+		dirtyRefs[ref] = true
+	}
+
+	// removedEntry is an entry in the tree, along with the blocks
+	// its rmOp unreferences.
+	type removedEntry struct {
+		dir    path
+		name   string
+		linkID string
+		infos  []BlockInfo
+	}
+	type treeEntry struct {
+		p       path
+		isDir   bool
+		removed *removedEntry
+	}
+	// The entries in breadth-first order, so children always come
+	// after their parents.
+	var removed []*removedEntry
+	level := []treeEntry{{dir.ChildPath(name, de.BlockPointer), true, nil}}
+	for len(level) > 0 {
+		entryChan := make(chan treeEntry, len(level))
+		for _, e := range level {
+			entryChan <- e
+		}
+		close(entryChan)
+
+		var resultsLock sync.Mutex
+		var next []treeEntry
+		eg, groupCtx := errgroup.WithContext(ctx)
+		worker := func() error {
+			// Each worker needs its own lock state, since lock
+			// states aren't goroutine-safe.  The block lock is only
+			// ever taken for reading here.
+			workerLState := makeFBOLockState()
+			for e := range entryChan {
+				if !e.isDir {
+					blockInfos, err := fbo.blocks.GetIndirectFileBlockInfos(
+						groupCtx, workerLState, md.ReadOnly(), e.p)
+					if isRecoverableBlockErrorForRemoval(err) {
+						fbo.log.CWarningf(groupCtx, "Recoverable block error "+
+							"encountered for unrefDirTree(%v); continuing", e.p)
+					} else if err != nil {
+						return err
+					}
+					resultsLock.Lock()
+					e.removed.infos = append(e.removed.infos, blockInfos...)
+					resultsLock.Unlock()
+					continue
+				}
+
+				dblock, err := fbo.blocks.GetDir(
+					groupCtx, workerLState, md.ReadOnly(), e.p, blockRead)
+				if isRecoverableBlockErrorForRemoval(err) {
+					fbo.log.CWarningf(groupCtx, "Recoverable block error "+
+						"encountered for unrefDirTree(%v); continuing", e.p)
+					continue
+				} else if err != nil {
+					return err
+				}
+				resultsLock.Lock()
+				for childName, childDE := range dblock.Children {
+					childPath := e.p.ChildPath(childName, childDE.BlockPointer)
+					re := &removedEntry{
+						dir:    e.p,
+						name:   childName,
+						linkID: childDE.LinkID,
+						infos:  []BlockInfo{childDE.BlockInfo},
+					}
+					removed = append(removed, re)
+					switch childDE.Type {
+					case Dir:
+						next = append(next, treeEntry{childPath, true, re})
+					case File, Exec:
+						if dirtyRefs[childDE.Ref()] {
+							hasDirty = true
+						}
+						next = append(next, treeEntry{childPath, false, re})
+					}
+				}
+				resultsLock.Unlock()
+			}
+			return nil
+		}
+		numWorkers := maxParallelBlockGets
+		if numWorkers > len(level) {
+			numWorkers = len(level)
+		}
+		for i := 0; i < numWorkers; i++ {
+			eg.Go(worker)
+		}
+		if err := eg.Wait(); err != nil {
+			return false, err
+		}
+		if hasDirty {
+			return true, nil
+		}
+		level = next
+	}
+
+	for i := len(removed) - 1; i >= 0; i-- {
+		re := removed[i]
+		ro, err := newRmOp(re.name, re.dir.tailPointer())
+		if err != nil {
+			return false, err
+		}
+		// The directory is removed along with the entry, in this
+		// same revision, so it never gets a new pointer.
+		ro.AddUpdate(re.dir.tailPointer(), re.dir.tailPointer())
+		ro.setFinalPath(re.dir)
+		md.AddOp(ro)
+		for _, info := range re.infos {
+			md.AddUnrefBlock(info)
+		}
+		if re.linkID != "" {
+			md.addLinks(re.linkID, -1)
+		}
+	}
+	return false, nil
+}
+
+// removeDirTreeLocked removes the directory named dirName under dir,
+// along with everything in it, in a single MD revision.  If any file
+// in the tree has unsynced writes, it returns true without making
+// any changes.
+func (fbo *folderBranchOps) removeDirTreeLocked(ctx context.Context,
+	lState *lockState, dir Node, dirName string) (hasDirty bool, err error) {
 	fbo.mdWriterLock.AssertLocked(lState)
 
 	// verify we have permission to write
 	md, err := fbo.getMDForWriteLocked(ctx, lState)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if err := checkTlfNotAppendOnly(md, "removing entries"); err != nil {
+		return false, err
 	}
 
-	oldPBlock, newPBlock, newDe, lbc, err := fbo.blocks.PrepRename(
-		ctx, lState, md, oldParent, oldName, newParent, newName)
-
+	dirPath, err := fbo.pathFromNodeForMDWriteLocked(lState, dir)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	// does name exist?
-	if de, ok := newPBlock.Children[newName]; ok {
-		// Usually higher-level programs check these, but just in case.
-		if de.Type == Dir && newDe.Type != Dir {
-			return NotDirError{newParent.ChildPathNoPtr(newName)}
-		} else if de.Type != Dir && newDe.Type == Dir {
-			return NotFileError{newParent.ChildPathNoPtr(newName)}
-		}
+	pblock, err := fbo.blocks.GetDir(
+		ctx, lState, md.ReadOnly(), dirPath, blockWrite)
+	if err != nil {
+		return false, err
+	}
+	existing, ok := existingEntryName(pblock.Children, dirName)
+	if !ok {
+		return false, NoSuchNameError{dirName}
+	}
+	dirName = existing
+	de := pblock.Children[dirName]
+	if de.Type != Dir {
+		return false, NotDirError{dirPath.ChildPathNoPtr(dirName)}
+	}
 
-		if de.Type == Dir {
-			// The directory must be empty.
-			oldTargetDir, err := fbo.blocks.GetDirBlockForReading(ctx, lState,
-				md.ReadOnly(), de.BlockPointer, newParent.Branch,
-				newParent.ChildPathNoPtr(newName))
-			if err != nil {
-				return err
-			}
-			if len(oldTargetDir.Children) != 0 {
-				fbo.log.CWarningf(ctx, "Renaming over a non-empty directory "+
-					" (%s/%s) not allowed.", newParent, newName)
-				return DirNotEmptyError{newName}
-			}
-		}
+	hasDirty, err = fbo.unrefDirTree(ctx, lState, md, dirPath, de, dirName)
+	if err != nil || hasDirty {
+		return hasDirty, err
+	}
 
-		// Delete the old block pointed to by this direntry.
-		err := fbo.unrefEntry(ctx, lState, md, newParent, de, newName)
-		if err != nil {
-			return err
-		}
+	// The directory's own rmOp comes last, so that it picks up the
+	// updates from syncing the parent directory.
+	ro, err := newRmOp(dirName, dirPath.tailPointer())
+	if err != nil {
+		return false, err
 	}
+	ro.setFinalPath(dirPath)
+	md.AddOp(ro)
+	md.AddUnrefBlock(de.BlockInfo)
 
-	// only the ctime changes
-	newDe.Ctime = fbo.nowUnixNano()
-	newPBlock.Children[newName] = newDe
-	delete(oldPBlock.Children, oldName)
+	// the actual unlink
+	delete(pblock.Children, dirName)
+
+	// sync the parent directory
+	_, err = fbo.syncBlockAndFinalizeLocked(
+		ctx, lState, md, pblock, *dirPath.parentPath(), dirPath.tailName(),
+		Dir, true, true, zeroPtr, NoExcl)
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// removeDirTreeEntries removes the directory named dirName under
+// dir by removing its children one at a time, and then the directory
+// itself.  Child directories are still removed as whole trees when
+// possible.
+func (fbo *folderBranchOps) removeDirTreeEntries(
+	ctx context.Context, dir Node, dirName string) error {
+	node, _, err := fbo.Lookup(ctx, dir, dirName)
+	if err != nil {
+		return err
+	}
+	children, err := fbo.GetDirChildren(ctx, node)
+	if err != nil {
+		return err
+	}
+	for childName, childEI := range children {
+		if childEI.Type == Dir {
+			err = fbo.RemoveDirTree(ctx, node, childName)
+		} else {
+			err = fbo.RemoveEntry(ctx, node, childName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return fbo.RemoveDir(ctx, dir, dirName)
+}
+
+func (fbo *folderBranchOps) RemoveDirTree(
+	ctx context.Context, dir Node, dirName string) (err error) {
+	fbo.log.CDebugf(ctx, "RemoveDirTree %s %s", getNodeIDStr(dir), dirName)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "RemoveDirTree %s %s done: %+v",
+			getNodeIDStr(dir), dirName, err)
+	}()
+
+	err = fbo.checkNode(dir)
+	if err != nil {
+		return err
+	}
+
+	var hasDirty bool
+	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			var err error
+			hasDirty, err = fbo.removeDirTreeLocked(ctx, lState, dir, dirName)
+			return err
+		})
+	if err != nil || !hasDirty {
+		return err
+	}
+
+	// Files with unsynced writes need to be unlinked individually,
+	// so that their open nodes notice the removal.
+	fbo.log.CDebugf(ctx, "%s has dirty files; removing it entry by entry",
+		dirName)
+	return fbo.removeDirTreeEntries(ctx, dir, dirName)
+}
+
+func (fbo *folderBranchOps) renameLocked(
+	ctx context.Context, lState *lockState, oldParent path,
+	oldName string, newParent path, newName string) (err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	// verify we have permission to write
+	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return err
+	}
+	if err := checkTlfNotAppendOnly(md, "renaming entries"); err != nil {
+		return err
+	}
+
+	// Match both names to any existing entries that differ only in
+	// unicode normalization, and put a brand new name in the
+	// folder's canonical form.
+	oldDblock, err := fbo.blocks.GetDir(
+		ctx, lState, md.ReadOnly(), oldParent, blockRead)
+	if err != nil {
+		return err
+	}
+	if existing, ok := existingEntryName(oldDblock.Children, oldName); ok {
+		oldName = existing
+	}
+	newDblock, err := fbo.blocks.GetDir(
+		ctx, lState, md.ReadOnly(), newParent, blockRead)
+	if err != nil {
+		return err
+	}
+	newName = md.NameNormalization().newEntryName(
+		newDblock.Children, newName)
+	if oldParent.tailPointer() == newParent.tailPointer() &&
+		oldName == newName {
+		// Renaming an entry onto itself, e.g. into another
+		// normalization of its own name, is a no-op.
+		return nil
+	}
+
+	oldPBlock, newPBlock, newDe, lbc, err := fbo.blocks.PrepRename(
+		ctx, lState, md, oldParent, oldName, newParent, newName)
+
+	if err != nil {
+		return err
+	}
+
+	// does name exist?
+	if de, ok := newPBlock.Children[newName]; ok {
+		// Usually higher-level programs check these, but just in case.
+		if de.Type == Dir && newDe.Type != Dir {
+			return NotDirError{newParent.ChildPathNoPtr(newName)}
+		} else if de.Type != Dir && newDe.Type == Dir {
+			return NotFileError{newParent.ChildPathNoPtr(newName)}
+		}
+
+		if de.Type == Dir {
+			// The directory must be empty.
+			oldTargetDir, err := fbo.blocks.GetDirBlockForReading(ctx, lState,
+				md.ReadOnly(), de.BlockPointer, newParent.Branch,
+				newParent.ChildPathNoPtr(newName))
+			if err != nil {
+				return err
+			}
+			if len(oldTargetDir.Children) != 0 {
+				fbo.log.CWarningf(ctx, "Renaming over a non-empty directory "+
+					" (%s/%s) not allowed.", newParent, newName)
+				return DirNotEmptyError{newName}
+			}
+		}
+
+		// Delete the old block pointed to by this direntry.
+		err := fbo.unrefEntry(ctx, lState, md, newParent, de, newName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// only the ctime changes
+	newDe.Ctime = fbo.nowUnixNano()
+	err = fbo.setLastWriter(ctx, md, &newDe.EntryInfo)
+	if err != nil {
+		return err
+	}
+	newPBlock.Children[newName] = newDe
+	delete(oldPBlock.Children, oldName)
+
+	return fbo.syncParentsAndFinalizeLocked(
+		ctx, lState, md, oldParent, oldPBlock, newParent, newPBlock, lbc, nil)
+}
+
+// syncParentsAndFinalizeLocked syncs the modified blocks of two
+// parent directories, which may be the same, up to their common
+// ancestor and finalizes the MD write.  Any blocks in extraBps are
+// put along with the directory blocks.
+func (fbo *folderBranchOps) syncParentsAndFinalizeLocked(
+	ctx context.Context, lState *lockState, md *RootMetadata,
+	oldParent path, oldPBlock *DirBlock, newParent path,
+	newPBlock *DirBlock, lbc localBcache,
+	extraBps *blockPutState) (err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
 
 	// find the common ancestor
 	var i int
@@ -3356,6 +4301,9 @@ func (fbo *folderBranchOps) renameLocked(
 	if oldBps != nil {
 		newBps.mergeOtherBps(oldBps)
 	}
+	if extraBps != nil {
+		newBps.mergeOtherBps(extraBps)
+	}
 
 	defer func() {
 		if err != nil {
@@ -3421,12 +4369,18 @@ func (fbo *folderBranchOps) Read(
 		fbo.deferLog.CDebugf(ctx, "Read %s %d %d done: %+v",
 			getNodeIDStr(file), len(dest), off, err)
 	}()
+	ctx = maybeNewContextWithBlockRequestOp(ctx, BlockRequestOpUserRead)
 
 	err = fbo.checkNode(file)
 	if err != nil {
 		return 0, err
 	}
 
+	err = fbo.blocks.FlushCoalescedWrites(ctx, makeFBOLockState(), file)
+	if err != nil {
+		return 0, err
+	}
+
 	filePath, err := fbo.pathFromNodeForRead(file)
 	if err != nil {
 		return 0, err
@@ -3491,9 +4445,20 @@ func (fbo *folderBranchOps) Write(
 		return err
 	}
 
+	// A pending setattr has to land first, or it would clobber
+	// the mtime this sets.
+	err = fbo.flushPendingAttrs(ctx, file)
+	if err != nil {
+		return err
+	}
+
 	return runUnlessCanceled(ctx, func() error {
 		lState := makeFBOLockState()
 
+		if err := fbo.checkDeviceNotRevoked(); err != nil {
+			return err
+		}
+
 		// Get the MD for reading.  We won't modify it; we'll track the
 		// unref changes on the side, and put them into the MD during the
 		// sync.
@@ -3501,6 +4466,14 @@ func (fbo *folderBranchOps) Write(
 		if err != nil {
 			return err
 		}
+		if err := checkTlfNotFrozen(md.RootMetadata); err != nil {
+			return err
+		}
+		err = fbo.checkAppendOnlyWrite(
+			ctx, lState, md, file, uint64(off), "overwriting file data")
+		if err != nil {
+			return err
+		}
 
 		err = fbo.blocks.Write(
 			ctx, lState, md.ReadOnly(), file, data, off)
@@ -3526,9 +4499,20 @@ func (fbo *folderBranchOps) Truncate(
 		return err
 	}
 
+	// A pending setattr has to land first, or it would clobber
+	// the mtime this sets.
+	err = fbo.flushPendingAttrs(ctx, file)
+	if err != nil {
+		return err
+	}
+
 	return runUnlessCanceled(ctx, func() error {
 		lState := makeFBOLockState()
 
+		if err := fbo.checkDeviceNotRevoked(); err != nil {
+			return err
+		}
+
 		// Get the MD for reading.  We won't modify it; we'll track the
 		// unref changes on the side, and put them into the MD during the
 		// sync.
@@ -3536,6 +4520,14 @@ func (fbo *folderBranchOps) Truncate(
 		if err != nil {
 			return err
 		}
+		if err := checkTlfNotFrozen(md.RootMetadata); err != nil {
+			return err
+		}
+		err = fbo.checkAppendOnlyWrite(
+			ctx, lState, md, file, size, "shrinking files")
+		if err != nil {
+			return err
+		}
 
 		err = fbo.blocks.Truncate(
 			ctx, lState, md.ReadOnly(), file, size)
@@ -3558,6 +4550,9 @@ func (fbo *folderBranchOps) setExLocked(
 	if err != nil {
 		return
 	}
+	if err := checkTlfNotAppendOnly(md, "changing file modes"); err != nil {
+		return err
+	}
 
 	dblock, de, err := fbo.blocks.GetDirtyParentAndEntry(
 		ctx, lState, md.ReadOnly(), file)
@@ -3585,6 +4580,10 @@ func (fbo *folderBranchOps) setExLocked(
 	}
 
 	de.Ctime = fbo.nowUnixNano()
+	err = fbo.setLastWriter(ctx, md, &de.EntryInfo)
+	if err != nil {
+		return err
+	}
 
 	parentPath := file.parentPath()
 	sao, err := newSetAttrOp(file.tailName(), parentPath.tailPointer(),
@@ -3627,6 +4626,15 @@ func (fbo *folderBranchOps) SetEx(
 		return
 	}
 
+	err = fbo.blocks.FlushCoalescedWrites(ctx, makeFBOLockState(), file)
+	if err != nil {
+		return err
+	}
+
+	if fbo.config.MetadataOpDelay() > 0 {
+		return fbo.queuePendingAttr(ctx, file, &ex, nil)
+	}
+
 	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
 		func(lState *lockState) error {
 			filePath, err := fbo.pathFromNodeForMDWriteLocked(lState, file)
@@ -3657,6 +4665,10 @@ func (fbo *folderBranchOps) setMtimeLocked(
 	de.Mtime = mtime.UnixNano()
 	// setting the mtime counts as changing the file MD, so must set ctime too
 	de.Ctime = fbo.nowUnixNano()
+	err = fbo.setLastWriter(ctx, md, &de.EntryInfo)
+	if err != nil {
+		return err
+	}
 
 	parentPath := file.parentPath()
 	sao, err := newSetAttrOp(file.tailName(), parentPath.tailPointer(),
@@ -3687,152 +4699,1489 @@ func (fbo *folderBranchOps) setMtimeLocked(
 	return err
 }
 
-func (fbo *folderBranchOps) SetMtime(
-	ctx context.Context, file Node, mtime *time.Time) (err error) {
-	fbo.log.CDebugf(ctx, "SetMtime %s %v", getNodeIDStr(file), mtime)
-	defer func() {
-		fbo.deferLog.CDebugf(ctx, "SetMtime %s %v done: %+v",
-			getNodeIDStr(file), mtime, err)
-	}()
+func (fbo *folderBranchOps) SetMtime(
+	ctx context.Context, file Node, mtime *time.Time) (err error) {
+	fbo.log.CDebugf(ctx, "SetMtime %s %v", getNodeIDStr(file), mtime)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetMtime %s %v done: %+v",
+			getNodeIDStr(file), mtime, err)
+	}()
+
+	if mtime == nil {
+		// Can happen on some OSes (e.g. OSX) when trying to set the atime only
+		return nil
+	}
+
+	err = fbo.checkNode(file)
+	if err != nil {
+		return
+	}
+
+	err = fbo.blocks.FlushCoalescedWrites(ctx, makeFBOLockState(), file)
+	if err != nil {
+		return err
+	}
+
+	if fbo.config.MetadataOpDelay() > 0 {
+		return fbo.queuePendingAttr(ctx, file, nil, mtime)
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			filePath, err := fbo.pathFromNodeForMDWriteLocked(lState, file)
+			if err != nil {
+				return err
+			}
+
+			return fbo.setMtimeLocked(ctx, lState, filePath, mtime)
+		})
+}
+
+// pendingAttr is a setattr change to a file that has been
+// acknowledged, but not yet written to a revision.  See
+// Config.MetadataOpDelay.
+type pendingAttr struct {
+	file  Node
+	ex    *bool
+	mtime *time.Time
+	ctime int64
+}
+
+// apply makes the pending changes to ei, and returns the attributes
+// that actually changed.  Like setExLocked, it ignores exec changes
+// to anything but regular files.
+func (pa *pendingAttr) apply(ei *EntryInfo) (attrs []attrChange) {
+	if pa.ex != nil {
+		if *pa.ex && ei.Type == File {
+			ei.Type = Exec
+			attrs = append(attrs, exAttr)
+		} else if !*pa.ex && ei.Type == Exec {
+			ei.Type = File
+			attrs = append(attrs, exAttr)
+		}
+	}
+	if pa.mtime != nil {
+		ei.Mtime = pa.mtime.UnixNano()
+		attrs = append(attrs, mtimeAttr)
+	}
+	if len(attrs) > 0 {
+		ei.Ctime = pa.ctime
+	}
+	return attrs
+}
+
+// queuePendingAttr checks that the given setattr change to file is
+// allowed, and queues it to be written, in one revision with any
+// other changes to files in the same directory, within
+// Config.MetadataOpDelay.  Until then, the change is only visible
+// locally.
+func (fbo *folderBranchOps) queuePendingAttr(
+	ctx context.Context, file Node, ex *bool, mtime *time.Time) error {
+	lState := makeFBOLockState()
+	if err := fbo.checkDeviceNotRevoked(); err != nil {
+		return err
+	}
+	md, err := fbo.getMDForReadLocked(ctx, lState, mdReadNeedIdentify)
+	if err != nil {
+		return err
+	}
+	if err := checkTlfNotFrozen(md.RootMetadata); err != nil {
+		return err
+	}
+	if ex != nil {
+		err := checkTlfNotAppendOnly(md.RootMetadata, "changing file modes")
+		if err != nil {
+			return err
+		}
+	}
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return err
+	}
+	if !md.GetTlfHandle().IsWriter(session.UID) {
+		return NewWriteAccessError(md.GetTlfHandle(), session.Name, "")
+	}
+	filePath, err := fbo.pathFromNodeForRead(file)
+	if err != nil {
+		return err
+	}
+	if !filePath.hasValidParent() {
+		return InvalidParentPathError{filePath}
+	}
+
+	fbo.pendingAttrsLock.Lock()
+	defer fbo.pendingAttrsLock.Unlock()
+	if fbo.pendingAttrs == nil {
+		fbo.pendingAttrs = make(map[NodeID]*pendingAttr)
+	}
+	// Queued changes are never modified in place, since a flush may
+	// be writing them right now.
+	pa := &pendingAttr{file: file}
+	if oldPA, ok := fbo.pendingAttrs[file.GetID()]; ok {
+		*pa = *oldPA
+	}
+	if ex != nil {
+		exCopy := *ex
+		pa.ex = &exCopy
+	}
+	if mtime != nil {
+		mtimeCopy := *mtime
+		pa.mtime = &mtimeCopy
+	}
+	pa.ctime = fbo.nowUnixNano()
+	fbo.pendingAttrs[file.GetID()] = pa
+	fbo.log.CDebugf(ctx, "Queued setattr for %s", getNodeIDStr(file))
+
+	// The first queued change starts the clock, so no change waits
+	// longer than the delay.
+	if !fbo.attrFlushScheduled {
+		fbo.attrFlushScheduled = true
+		fbo.config.Clock().AfterFunc(fbo.config.MetadataOpDelay(), func() {
+			err := fbo.runUnlessShutdown(func(ctx context.Context) error {
+				return fbo.flushPendingAttrs(ctx, nil)
+			})
+			if err != nil {
+				fbo.log.CDebugf(nil, "Couldn't write pending setattrs: %+v",
+					err)
+			}
+		})
+	}
+	return nil
+}
+
+// applyPendingAttr makes any pending setattr changes to file to ei.
+func (fbo *folderBranchOps) applyPendingAttr(file Node, ei *EntryInfo) {
+	fbo.pendingAttrsLock.Lock()
+	defer fbo.pendingAttrsLock.Unlock()
+	if pa, ok := fbo.pendingAttrs[file.GetID()]; ok {
+		pa.apply(ei)
+	}
+}
+
+// applyPendingAttrsToChildren makes any pending setattr changes to
+// the children of dir to children.
+func (fbo *folderBranchOps) applyPendingAttrsToChildren(
+	dir path, children map[string]EntryInfo) {
+	fbo.pendingAttrsLock.Lock()
+	defer fbo.pendingAttrsLock.Unlock()
+	for _, pa := range fbo.pendingAttrs {
+		filePath := fbo.nodeCache.PathFromNode(pa.file)
+		if !filePath.hasValidParent() ||
+			filePath.parentPath().tailPointer() != dir.tailPointer() {
+			continue
+		}
+		ei, ok := children[filePath.tailName()]
+		if !ok {
+			continue
+		}
+		pa.apply(&ei)
+		children[filePath.tailName()] = ei
+	}
+}
+
+// hasPendingAttrs returns true if any setattr changes are waiting
+// to be written.
+func (fbo *folderBranchOps) hasPendingAttrs() bool {
+	fbo.pendingAttrsLock.Lock()
+	defer fbo.pendingAttrsLock.Unlock()
+	return len(fbo.pendingAttrs) > 0
+}
+
+// discardPendingAttrs forgets any setattr changes that haven't been
+// written yet.
+func (fbo *folderBranchOps) discardPendingAttrs(ctx context.Context) {
+	fbo.pendingAttrsLock.Lock()
+	defer fbo.pendingAttrsLock.Unlock()
+	if len(fbo.pendingAttrs) > 0 {
+		fbo.log.CWarningf(ctx, "Dropping %d unwritten setattrs",
+			len(fbo.pendingAttrs))
+	}
+	fbo.pendingAttrs = nil
+}
+
+// removePendingAttrs forgets the given changes once they've been
+// written, unless they've been replaced by newer ones since.
+func (fbo *folderBranchOps) removePendingAttrs(written []*pendingAttr) {
+	fbo.pendingAttrsLock.Lock()
+	defer fbo.pendingAttrsLock.Unlock()
+	for _, pa := range written {
+		id := pa.file.GetID()
+		if fbo.pendingAttrs[id] == pa {
+			delete(fbo.pendingAttrs, id)
+		}
+	}
+}
+
+// flushPendingAttrs writes any pending setattr changes to file, or
+// to every file in this folder if file is nil.  Changes to files in
+// the same directory share a revision.  Changes stay pending until
+// they've been written, so any that fail are written by the next
+// flush.
+func (fbo *folderBranchOps) flushPendingAttrs(
+	ctx context.Context, file Node) error {
+	var attrs []*pendingAttr
+	func() {
+		fbo.pendingAttrsLock.Lock()
+		defer fbo.pendingAttrsLock.Unlock()
+		if file == nil {
+			for _, pa := range fbo.pendingAttrs {
+				attrs = append(attrs, pa)
+			}
+			// Changes queued from now on need another flush.
+			fbo.attrFlushScheduled = false
+		} else if pa, ok := fbo.pendingAttrs[file.GetID()]; ok {
+			attrs = append(attrs, pa)
+		}
+	}()
+
+	for len(attrs) > 0 {
+		var written, rest []*pendingAttr
+		err := fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+			func(lState *lockState) (err error) {
+				written, rest, err = fbo.setPendingAttrsLocked(
+					ctx, lState, attrs)
+				return err
+			})
+		if err != nil {
+			return err
+		}
+		fbo.removePendingAttrs(written)
+		attrs = rest
+	}
+	return nil
+}
+
+// setPendingAttrsLocked writes the changes in attrs to the files in
+// the directory of the first one, all in one revision.  It returns
+// the changes it took care of, and the changes to files in other
+// directories.
+func (fbo *folderBranchOps) setPendingAttrsLocked(ctx context.Context,
+	lState *lockState, attrs []*pendingAttr) (
+	written, rest []*pendingAttr, err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var parentPath *path
+	var dblock *DirBlock
+	numOps := 0
+	for _, pa := range attrs {
+		filePath, err := fbo.pathFromNodeForMDWriteLocked(lState, pa.file)
+		if err != nil {
+			return nil, nil, err
+		}
+		if parentPath != nil &&
+			filePath.parentPath().tailPointer() != parentPath.tailPointer() {
+			rest = append(rest, pa)
+			continue
+		}
+		written = append(written, pa)
+
+		fileDblock, de, err := fbo.blocks.GetDirtyParentAndEntry(
+			ctx, lState, md.ReadOnly(), filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		attrChanges := pa.apply(&de.EntryInfo)
+		if len(attrChanges) == 0 {
+			continue
+		}
+
+		// As in setExLocked, a path that doesn't match the MD
+		// belongs to a file that has been unlinked.
+		removed := md.data.Dir.BlockPointer.ID != filePath.path[0].BlockPointer.ID
+		if !removed && dblock == nil {
+			dblock = fileDblock
+			parentPath = filePath.parentPath()
+		}
+		for _, attr := range attrChanges {
+			sao, err := newSetAttrOp(filePath.tailName(),
+				filePath.parentPath().tailPointer(), attr,
+				filePath.tailPointer())
+			if err != nil {
+				return nil, nil, err
+			}
+			if removed {
+				fbo.log.CDebugf(ctx, "Skipping setattr for a removed "+
+					"file %v", filePath.tailPointer())
+				fbo.blocks.UpdateCachedEntryAttributesOnRemovedFile(
+					ctx, lState, sao, de)
+				continue
+			}
+			sao.setFinalPath(filePath)
+			md.AddOp(sao)
+			numOps++
+		}
+		if !removed {
+			dblock.Children[filePath.tailName()] = de
+		}
+	}
+	if numOps == 0 {
+		return written, rest, nil
+	}
+
+	fbo.log.CDebugf(ctx, "Writing %d coalesced setattrs in %v",
+		numOps, parentPath.tailPointer())
+	_, err = fbo.syncBlockAndFinalizeLocked(
+		ctx, lState, md, dblock, *parentPath.parentPath(),
+		parentPath.tailName(), Dir, false, false, zeroPtr, NoExcl)
+	if err != nil {
+		return nil, nil, err
+	}
+	return written, rest, nil
+}
+
+// updateCoalescedSetAttrDirs gives every setattr op in md the new
+// pointer of its directory.  Syncing a block only updates the last
+// op, but setPendingAttrsLocked writes several setattrs on the same
+// directory in one revision.
+func updateCoalescedSetAttrDirs(md *RootMetadata) {
+	ops := md.data.Changes.Ops
+	if len(ops) < 2 {
+		return
+	}
+	last, ok := ops[len(ops)-1].(*setAttrOp)
+	if !ok {
+		return
+	}
+	for _, op := range ops[:len(ops)-1] {
+		if sao, ok := op.(*setAttrOp); ok && sao.Dir.Unref == last.Dir.Unref {
+			sao.AddUpdate(sao.Dir.Unref, last.Dir.Ref)
+		}
+	}
+}
+
+func (fbo *folderBranchOps) syncLocked(ctx context.Context,
+	lState *lockState, file path) (stillDirty bool, err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	// if the cache for this file isn't dirty, we're done
+	if !fbo.blocks.IsDirty(lState, file) {
+		return false, nil
+	}
+
+	// Verify we have permission to write.  We do this after the dirty
+	// check because otherwise readers who sync clean files on close
+	// would get an error.
+	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return true, err
+	}
+
+	// If the MD doesn't match the MD expected by the path, that
+	// implies we are using a cached path, which implies the node has
+	// been unlinked.  In that case, we can safely ignore this sync.
+	if md.data.Dir.BlockPointer.ID != file.path[0].BlockPointer.ID {
+		fbo.log.CDebugf(ctx, "Skipping sync for a removed file %v",
+			file.tailPointer())
+		// Removing the cached info here is a little sketchy,
+		// since there's no guarantee that this sync comes
+		// from closing the file, and we still want to serve
+		// stat calls accurately if the user still has an open
+		// handle to this file.
+		//
+		// Note in particular that if a file just had a dirty
+		// directory entry cached (due to an attribute change on a
+		// removed file, for example), this will clear that attribute
+		// change.  If there's still an open file handle, the user
+		// won't be able to see the change anymore.
+		//
+		// TODO: Hook this in with the node cache GC logic to be
+		// perfectly accurate (but at the same time, we'd then have to
+		// fix up the intentional panic in the background flusher to
+		// be more tolerant of long-lived dirty, removed files).
+		return true, fbo.blocks.ClearCacheInfo(lState, file)
+	}
+
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return true, err
+	}
+
+	if file.isValidForNotification() {
+		// notify the daemon that a write is being performed
+		fbo.config.Reporter().Notify(ctx, writeNotification(file, false))
+		defer fbo.config.Reporter().Notify(ctx, writeNotification(file, true))
+	}
+
+	// Filled in by doBlockPuts below.
+	var blocksToRemove []BlockPointer
+	fblock, bps, lbc, syncState, err :=
+		fbo.blocks.StartSync(ctx, lState, md, session.UID, file)
+	defer func() {
+		fbo.blocks.CleanupSyncState(
+			ctx, lState, md.ReadOnly(), file, blocksToRemove, syncState, err)
+	}()
+	if err != nil {
+		return true, err
+	}
+
+	newPath, _, newBps, err :=
+		fbo.syncBlockAndCheckEmbedLocked(
+			ctx, lState, md, fblock, *file.parentPath(),
+			file.tailName(), File, true, true, zeroPtr, lbc)
+	if err != nil {
+		return true, err
+	}
+
+	bps.mergeOtherBps(newBps)
+
+	// Note: We explicitly don't call fbo.fbm.cleanUpBlockState here
+	// when there's an error, because it's possible some of the blocks
+	// will be reused in a future attempt at this same sync, and we
+	// don't want them cleaned up in that case.  Instead, the
+	// FinishSync call below will take care of that.
+
+	blocksToRemove, err = doBlockPuts(ctx, fbo.config.BlockServer(),
+		fbo.config.BlockCache(), fbo.config.Reporter(), fbo.log, md.TlfID(),
+		md.GetTlfHandle().GetCanonicalName(), *bps)
+	if err != nil {
+		return true, err
+	}
+
+	// Call this under the same blockLock as when the pointers are
+	// updated, so there's never any point in time where a read or
+	// write might slip in after the pointers are updated, but before
+	// the deferred writes are re-applied.
+	afterUpdateFn := func() error {
+		stillDirty, err = fbo.blocks.FinishSyncLocked(
+			ctx, lState, file, newPath, md.ReadOnly(), syncState, fbo.fbm)
+		return err
+	}
+
+	err = fbo.finalizeMDWriteLocked(ctx, lState, md, bps, NoExcl, afterUpdateFn)
+	if err != nil {
+		return true, err
+	}
+	return stillDirty, err
+}
+
+func (fbo *folderBranchOps) getFolderFsyncDurability() FsyncDurability {
+	fbo.fsyncDurabilityLock.Lock()
+	defer fbo.fsyncDurabilityLock.Unlock()
+	return fbo.fsyncDurability
+}
+
+// getFsyncDurability returns the durability level for a Sync done
+// with the given context, falling back from the context to the
+// folder to the config.
+func (fbo *folderBranchOps) getFsyncDurability(
+	ctx context.Context) FsyncDurability {
+	if d := fsyncDurabilityFromContext(ctx); d != FsyncDurabilityDefault {
+		return d
+	}
+	if d := fbo.getFolderFsyncDurability(); d != FsyncDurabilityDefault {
+		return d
+	}
+	return fbo.config.FsyncDurability()
+}
+
+// SetFsyncDurability implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) SetFsyncDurability(ctx context.Context,
+	folderBranch FolderBranch, durability FsyncDurability) error {
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	fbo.log.CDebugf(ctx, "Setting fsync durability to %s", durability)
+	fbo.fsyncDurabilityLock.Lock()
+	defer fbo.fsyncDurabilityLock.Unlock()
+	fbo.fsyncDurability = durability
+	return nil
+}
+
+func (fbo *folderBranchOps) isStreamingFSEvents() bool {
+	fbo.fsEventsLock.Lock()
+	defer fbo.fsEventsLock.Unlock()
+	return fbo.streamFSEvents
+}
+
+// SetFSEventStreaming implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) SetFSEventStreaming(ctx context.Context,
+	folderBranch FolderBranch, enabled bool) error {
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	fbo.log.CDebugf(ctx, "Setting FS event streaming to %t", enabled)
+	fbo.fsEventsLock.Lock()
+	defer fbo.fsEventsLock.Unlock()
+	fbo.streamFSEvents = enabled
+	return nil
+}
+
+func (fbo *folderBranchOps) setTlfFrozenLocked(
+	ctx context.Context, lState *lockState, frozen bool) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	// Any dirty data would be stuck if we froze the folder
+	// underneath it, so make the caller sync it first.
+	if fbo.blocks.GetState(lState) != cleanState {
+		return NotPermittedWhileDirtyError{}
+	}
+	if !fbo.isMasterBranchLocked(lState) {
+		return UnmergedError{}
+	}
+
+	md, err := fbo.getSuccessorMDForWriteLocked(ctx, lState, "", true)
+	if err != nil {
+		return err
+	}
+	if md.IsFrozen() == frozen {
+		fbo.log.CDebugf(ctx, "Folder frozen status is already %t", frozen)
+		return nil
+	}
+
+	md.SetFrozen(frozen)
+	md.AddOp(newSettingsOp())
+	return fbo.finalizeMergedOnlyMDWriteLocked(ctx, lState, md)
+}
+
+// SetTlfFrozen implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) SetTlfFrozen(ctx context.Context,
+	folderBranch FolderBranch, frozen bool) (err error) {
+	fbo.log.CDebugf(ctx, "SetTlfFrozen %t", frozen)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetTlfFrozen %t done: %+v", frozen, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setTlfFrozenLocked(ctx, lState, frozen)
+		})
+}
+
+func (fbo *folderBranchOps) setTlfAppendOnlyLocked(
+	ctx context.Context, lState *lockState, appendOnly bool) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if !fbo.isMasterBranchLocked(lState) {
+		return UnmergedError{}
+	}
+
+	md, err := fbo.getSuccessorMDForWriteLocked(ctx, lState, "", true)
+	if err != nil {
+		return err
+	}
+	if md.IsAppendOnly() == appendOnly {
+		fbo.log.CDebugf(ctx, "Folder append-only status is already %t",
+			appendOnly)
+		return nil
+	}
+
+	md.SetAppendOnly(appendOnly)
+	md.AddOp(newSettingsOp())
+	return fbo.finalizeMergedOnlyMDWriteLocked(ctx, lState, md)
+}
+
+// SetTlfAppendOnly implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) SetTlfAppendOnly(ctx context.Context,
+	folderBranch FolderBranch, appendOnly bool) (err error) {
+	fbo.log.CDebugf(ctx, "SetTlfAppendOnly %t", appendOnly)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetTlfAppendOnly %t done: %+v",
+			appendOnly, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setTlfAppendOnlyLocked(ctx, lState, appendOnly)
+		})
+}
+
+func (fbo *folderBranchOps) setTlfNameNormalizationLocked(
+	ctx context.Context, lState *lockState, n NameNormalization) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if !fbo.isMasterBranchLocked(lState) {
+		return UnmergedError{}
+	}
+
+	md, err := fbo.getSuccessorMDForWriteLocked(ctx, lState, "", true)
+	if err != nil {
+		return err
+	}
+	if md.NameNormalization() == n {
+		fbo.log.CDebugf(ctx, "Folder name normalization is already %s", n)
+		return nil
+	}
+
+	md.SetNameNormalization(n)
+	md.AddOp(newSettingsOp())
+	return fbo.finalizeMergedOnlyMDWriteLocked(ctx, lState, md)
+}
+
+// SetTlfNameNormalization implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) SetTlfNameNormalization(ctx context.Context,
+	folderBranch FolderBranch, n NameNormalization) (err error) {
+	fbo.log.CDebugf(ctx, "SetTlfNameNormalization %s", n)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetTlfNameNormalization %s done: %+v",
+			n, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setTlfNameNormalizationLocked(ctx, lState, n)
+		})
+}
+
+// GetHistoryRetention implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetHistoryRetention(ctx context.Context,
+	folderBranch FolderBranch) (HistoryRetention, error) {
+	if folderBranch != fbo.folderBranch {
+		return HistoryRetention{}, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	lState := makeFBOLockState()
+	md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return HistoryRetention{}, err
+	}
+	return md.HistoryRetention(), nil
+}
+
+func (fbo *folderBranchOps) setHistoryRetentionLocked(
+	ctx context.Context, lState *lockState,
+	retention HistoryRetention) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if !fbo.isMasterBranchLocked(lState) {
+		return UnmergedError{}
+	}
+
+	md, err := fbo.getSuccessorMDForWriteLocked(ctx, lState, "", true)
+	if err != nil {
+		return err
+	}
+	if old := md.HistoryRetention(); old.Policy == retention.Policy &&
+		old.Days == retention.Days {
+		fbo.log.CDebugf(ctx, "History retention is already %s", retention)
+		return nil
+	}
+
+	md.SetHistoryRetention(retention)
+	md.AddOp(newSettingsOp())
+	return fbo.finalizeMergedOnlyMDWriteLocked(ctx, lState, md)
+}
+
+// SetHistoryRetention implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) SetHistoryRetention(ctx context.Context,
+	folderBranch FolderBranch, retention HistoryRetention) (err error) {
+	fbo.log.CDebugf(ctx, "SetHistoryRetention %s", retention)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetHistoryRetention %s done: %+v",
+			retention, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	if err := retention.checkValid(); err != nil {
+		return err
+	}
+
+	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setHistoryRetentionLocked(ctx, lState, retention)
+		})
+	if err != nil {
+		return err
+	}
+	// The new setting may make more history reclaimable right away.
+	fbo.fbm.forceQuotaReclamation()
+	return nil
+}
+
+// GetPinnedRevisions implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetPinnedRevisions(ctx context.Context,
+	folderBranch FolderBranch) ([]MetadataRevision, error) {
+	if folderBranch != fbo.folderBranch {
+		return nil, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	lState := makeFBOLockState()
+	md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return nil, err
+	}
+	return md.PinnedRevisions(), nil
+}
+
+// checkRevisionPinnable returns an error if rev can't be pinned in
+// md, the successor of the current head.
+func (fbo *folderBranchOps) checkRevisionPinnable(
+	md *RootMetadata, rev MetadataRevision) error {
+	// Blocks unreferenced after the last GC revision are all still
+	// around, so any revision from then on can be reconstructed.
+	head := md.Revision() - 1
+	if rev < MetadataRevisionInitial || rev > head ||
+		rev < md.data.LastGCRevision {
+		return RevisionNotPinnableError{
+			fbo.id(), rev, head, md.data.LastGCRevision}
+	}
+	return nil
+}
+
+func (fbo *folderBranchOps) setRevisionPinnedLocked(
+	ctx context.Context, lState *lockState, rev MetadataRevision,
+	pinned bool) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if !fbo.isMasterBranchLocked(lState) {
+		return UnmergedError{}
+	}
+
+	md, err := fbo.getSuccessorMDForWriteLocked(ctx, lState, "", true)
+	if err != nil {
+		return err
+	}
+	if md.IsRevisionPinned(rev) == pinned {
+		fbo.log.CDebugf(ctx, "Revision %d already has pinned=%t",
+			rev, pinned)
+		return nil
+	}
+	if pinned {
+		if err := fbo.checkRevisionPinnable(md, rev); err != nil {
+			return err
+		}
+	}
+
+	md.SetRevisionPinned(rev, pinned)
+	md.AddOp(newSettingsOp())
+	return fbo.finalizeMergedOnlyMDWriteLocked(ctx, lState, md)
+}
+
+// PinRevision implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) PinRevision(ctx context.Context,
+	folderBranch FolderBranch, rev MetadataRevision) (err error) {
+	fbo.log.CDebugf(ctx, "PinRevision %d", rev)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "PinRevision %d done: %+v", rev, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setRevisionPinnedLocked(ctx, lState, rev, true)
+		})
+}
+
+// UnpinRevision implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) UnpinRevision(ctx context.Context,
+	folderBranch FolderBranch, rev MetadataRevision) (err error) {
+	fbo.log.CDebugf(ctx, "UnpinRevision %d", rev)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "UnpinRevision %d done: %+v", rev, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setRevisionPinnedLocked(ctx, lState, rev, false)
+		})
+	if err != nil {
+		return err
+	}
+	// History kept only for this pin may be reclaimable now.
+	fbo.fbm.forceQuotaReclamation()
+	return nil
+}
+
+// GetSnapshots implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) GetSnapshots(ctx context.Context,
+	folderBranch FolderBranch) ([]TLFSnapshot, error) {
+	if folderBranch != fbo.folderBranch {
+		return nil, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	lState := makeFBOLockState()
+	md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return nil, err
+	}
+	return md.Snapshots(), nil
+}
+
+func (fbo *folderBranchOps) createSnapshotLocked(
+	ctx context.Context, lState *lockState, name string,
+	rev MetadataRevision) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if !fbo.isMasterBranchLocked(lState) {
+		return UnmergedError{}
+	}
+
+	md, err := fbo.getSuccessorMDForWriteLocked(ctx, lState, "", true)
+	if err != nil {
+		return err
+	}
+	if rev == MetadataRevisionUninitialized {
+		rev = md.Revision() - 1
+	}
+	if snap, ok := md.GetSnapshot(name); ok {
+		return SnapshotExistsError{name, snap.Revision}
+	}
+	if err := fbo.checkRevisionPinnable(md, rev); err != nil {
+		return err
+	}
+
+	md.SetSnapshot(TLFSnapshot{
+		Name:     name,
+		Revision: rev,
+		Ctime:    fbo.nowUnixNano(),
+	})
+	md.AddOp(newSettingsOp())
+	return fbo.finalizeMergedOnlyMDWriteLocked(ctx, lState, md)
+}
+
+// CreateSnapshot implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) CreateSnapshot(ctx context.Context,
+	folderBranch FolderBranch, name string, rev MetadataRevision) (
+	err error) {
+	fbo.log.CDebugf(ctx, "CreateSnapshot %q at revision %d", name, rev)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "CreateSnapshot %q done: %+v", name, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	if err := checkSnapshotName(name); err != nil {
+		return err
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.createSnapshotLocked(ctx, lState, name, rev)
+		})
+}
+
+func (fbo *folderBranchOps) deleteSnapshotLocked(
+	ctx context.Context, lState *lockState, name string) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if !fbo.isMasterBranchLocked(lState) {
+		return UnmergedError{}
+	}
+
+	md, err := fbo.getSuccessorMDForWriteLocked(ctx, lState, "", true)
+	if err != nil {
+		return err
+	}
+	if _, ok := md.GetSnapshot(name); !ok {
+		return NoSuchSnapshotError{name}
+	}
+
+	md.RemoveSnapshot(name)
+	md.AddOp(newSettingsOp())
+	return fbo.finalizeMergedOnlyMDWriteLocked(ctx, lState, md)
+}
+
+// DeleteSnapshot implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) DeleteSnapshot(ctx context.Context,
+	folderBranch FolderBranch, name string) (err error) {
+	fbo.log.CDebugf(ctx, "DeleteSnapshot %q", name)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "DeleteSnapshot %q done: %+v", name, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.deleteSnapshotLocked(ctx, lState, name)
+		})
+	if err != nil {
+		return err
+	}
+	// History kept only for this snapshot may be reclaimable now.
+	fbo.fbm.forceQuotaReclamation()
+	return nil
+}
+
+// restoreFileCompareSize is how many bytes RestoreFile compares at a
+// time between the old and current contents of a file.  Only windows
+// that differ are written, so it's small enough that most unchanged
+// blocks are never dirtied.
+const restoreFileCompareSize = 64 << 10
+
+func (fbo *folderBranchOps) RestoreFile(ctx context.Context, dir Node,
+	name string, rev MetadataRevision) (node Node, err error) {
+	fbo.log.CDebugf(ctx, "RestoreFile %s %s to revision %d",
+		getNodeIDStr(dir), name, rev)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "RestoreFile %s %s done: %+v",
+			getNodeIDStr(dir), name, err)
+	}()
+
+	err = fbo.checkNode(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirPath, err := fbo.pathFromNodeForRead(dir)
+	if err != nil {
+		return nil, err
+	}
+	components := make([]string, 0, len(dirPath.path))
+	for _, n := range dirPath.path[1:] {
+		components = append(components, n.Name)
+	}
+	components = append(components, name)
+
+	ar, err := GetArchivedRevision(ctx, fbo.config, fbo.id(), rev)
+	if err != nil {
+		return nil, err
+	}
+	old, err := ar.OpenFile(ctx, components)
+	if err != nil {
+		return nil, err
+	}
+	oldIsExec := old.de.Type == Exec
+
+	node, de, err := fbo.Lookup(ctx, dir, name)
+	switch errors.Cause(err).(type) {
+	case nil:
+		if de.Type != File && de.Type != Exec {
+			return nil, NotFileError{dirPath.ChildPathNoPtr(name)}
+		}
+		curDE, err := fbo.statEntry(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		if curDE.BlockPointer == old.de.BlockPointer &&
+			curDE.Type == old.de.Type {
+			fbo.log.CDebugf(ctx, "File is unchanged since revision %d", rev)
+			return node, nil
+		}
+	case NoSuchNameError:
+		node, de, err = fbo.CreateFile(ctx, dir, name, oldIsExec, NoExcl)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	oldSize := int64(old.de.Size)
+	oldBuf := make([]byte, restoreFileCompareSize)
+	curBuf := make([]byte, restoreFileCompareSize)
+	for off := int64(0); off < oldSize; off += restoreFileCompareSize {
+		n, err := old.Read(ctx, oldBuf, off)
+		if err != nil {
+			return nil, err
+		}
+		m, err := fbo.Read(ctx, node, curBuf[:n], off)
+		if err != nil {
+			return nil, err
+		}
+		if m == n && bytes.Equal(oldBuf[:n], curBuf[:n]) {
+			continue
+		}
+		err = fbo.Write(ctx, node, oldBuf[:n], off)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if de.Size != old.de.Size {
+		err = fbo.Truncate(ctx, node, old.de.Size)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if (de.Type == Exec) != oldIsExec {
+		err = fbo.SetEx(ctx, node, oldIsExec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = fbo.Sync(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// GetConflictStrategies implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetConflictStrategies(ctx context.Context,
+	folderBranch FolderBranch) ([]ConflictStrategyRule, error) {
+	if folderBranch != fbo.folderBranch {
+		return nil, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	return fbo.cr.strategies.getRules()
+}
+
+// SetConflictStrategy implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) SetConflictStrategy(ctx context.Context,
+	folderBranch FolderBranch, prefix string,
+	strategy ConflictStrategy) (err error) {
+	fbo.log.CDebugf(ctx, "SetConflictStrategy %q %s", prefix, strategy)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetConflictStrategy %q done: %+v",
+			prefix, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	return fbo.cr.strategies.set(prefix, strategy)
+}
+
+// ClearConflictStrategy implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) ClearConflictStrategy(ctx context.Context,
+	folderBranch FolderBranch, prefix string) (err error) {
+	fbo.log.CDebugf(ctx, "ClearConflictStrategy %q", prefix)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "ClearConflictStrategy %q done: %+v",
+			prefix, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	return fbo.cr.strategies.clear(prefix)
+}
+
+// ForceQuotaReclamation implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) ForceQuotaReclamation(ctx context.Context,
+	folderBranch FolderBranch) error {
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	fbo.log.CDebugf(ctx, "Forcing quota reclamation")
+	fbo.fbm.forceQuotaReclamation()
+	return nil
+}
+
+// SetQuotaReclamationPaused implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) SetQuotaReclamationPaused(ctx context.Context,
+	folderBranch FolderBranch, paused bool) error {
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	fbo.log.CDebugf(ctx, "Setting quota reclamation paused to %t", paused)
+	fbo.fbm.setQuotaReclamationPaused(paused)
+	return nil
+}
+
+// SetTlfSynced implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) SetTlfSynced(ctx context.Context,
+	folderBranch FolderBranch, synced bool) error {
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	dbc, ok := fbo.config.DiskBlockCache().(*DiskBlockCachePartitioned)
+	if !ok {
+		return errors.New("The disk block cache has no sync partition")
+	}
+	fbo.log.CDebugf(ctx, "Setting synced to %t", synced)
+	return dbc.setTlfSynced(fbo.id(), synced)
+}
+
+// SetQuotaReclamationMinHeadAge implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) SetQuotaReclamationMinHeadAge(
+	ctx context.Context, folderBranch FolderBranch,
+	age time.Duration) error {
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	if age < 0 {
+		return fmt.Errorf("Negative quota reclamation head age %s", age)
+	}
+	fbo.log.CDebugf(ctx, "Setting quota reclamation min head age to %s",
+		age)
+	fbo.fbm.setQuotaReclamationMinHeadAge(age)
+	return nil
+}
+
+// readyTemplateFileLocked builds the blocks for a new file holding
+// data, readies them all into bps, and returns the info for the
+// file's top block.  All the blocks other than the top one are
+// added to md as new refs.
+func (fbo *folderBranchOps) readyTemplateFileLocked(ctx context.Context,
+	lState *lockState, md *RootMetadata, uid keybase1.UID,
+	bps *blockPutState, name string, data []byte) (BlockInfo, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	// Build the file up in a private dirty cache, the same way
+	// unembedBlockChanges does, so the normal splitting code
+	// decides on the indirect blocks.
+	block := NewFileBlock().(*FileBlock)
+	bid, err := fbo.config.Crypto().MakeTemporaryBlockID()
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	ptr := BlockPointer{
+		ID:         bid,
+		KeyGen:     md.LatestKeyGeneration(),
+		DataVer:    fbo.config.DataVersion(),
+		DirectType: DirectBlock,
+		Context:    kbfsblock.MakeFirstContext(uid, keybase1.BlockType_DATA),
+	}
+	file := path{fbo.folderBranch, []pathNode{{ptr, name}}}
+
+	dirtyBcache := simpleDirtyBlockCacheStandard()
+	// Simple dirty bcaches don't need to be shut down.
+
+	getter := func(_ context.Context, _ KeyMetadata, ptr BlockPointer,
+		_ path, _ blockReqType) (*FileBlock, bool, error) {
+		block, err := dirtyBcache.Get(fbo.id(), ptr, fbo.branch())
+		if err != nil {
+			return nil, false, err
+		}
+		fblock, ok := block.(*FileBlock)
+		if !ok {
+			return nil, false,
+				errors.Errorf("Block for %s is not a file block", ptr)
+		}
+		return fblock, true, nil
+	}
+	cacher := func(ptr BlockPointer, block Block) error {
+		return dirtyBcache.Put(fbo.id(), ptr, fbo.branch(), block)
+	}
+	err = cacher(ptr, block)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+
+	df := newDirtyFile(file, dirtyBcache)
+	fd := newFileData(file, uid, fbo.config.Crypto(),
+		fbo.config.BlockSplitter(), md.ReadOnly(), getter, cacher, fbo.log)
+	if len(data) > 0 {
+		_, _, _, _, _, err = fd.write(ctx, data, 0, block, DirEntry{}, df)
+		if err != nil {
+			return BlockInfo{}, err
+		}
+	}
+
+	// There might be a new top block.
+	topBlock, err := dirtyBcache.Get(fbo.id(), ptr, fbo.branch())
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	block, ok := topBlock.(*FileBlock)
+	if !ok {
+		return BlockInfo{}, errors.New("Top block no longer a file block")
+	}
+
+	infos, err := fd.ready(ctx, fbo.id(), fbo.config.BlockCache(),
+		dirtyBcache, fbo.config.BlockOps(), bps, block, df)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	for info := range infos {
+		md.AddRefBlock(info)
+	}
+
+	info, _, err := fbo.readyBlockMultiple(
+		ctx, md.ReadOnly(), block, uid, bps, keybase1.BlockType_DATA)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	return info, nil
+}
+
+// readyTemplateEntryLocked readies all the blocks needed for entry,
+// which will be named name in its new parent directory, into bps,
+// and returns the new directory entry for it.  All the new blocks
+// are added to md as new refs.
+func (fbo *folderBranchOps) readyTemplateEntryLocked(ctx context.Context,
+	lState *lockState, md *RootMetadata, uid keybase1.UID,
+	bps *blockPutState, name string, entry *tlfTemplateEntry,
+	now int64) (DirEntry, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
 
-	if mtime == nil {
-		// Can happen on some OSes (e.g. OSX) when trying to set the atime only
-		return nil
+	if err := checkDisallowedPrefixes(name); err != nil {
+		return DirEntry{}, err
+	}
+	if uint32(len(name)) > fbo.config.MaxNameBytes() {
+		return DirEntry{}, NameTooLongError{name, fbo.config.MaxNameBytes()}
 	}
 
-	err = fbo.checkNode(file)
-	if err != nil {
-		return
+	de := DirEntry{
+		EntryInfo: EntryInfo{
+			Type:  entry.Type,
+			Mtime: entry.Mtime,
+			Ctime: now,
+		},
+	}
+	switch entry.Type {
+	case Sym:
+		de.Size = uint64(len(entry.SymPath))
+		de.SymPath = entry.SymPath
+		return de, nil
+	case File, Exec:
+		info, err := fbo.readyTemplateFileLocked(
+			ctx, lState, md, uid, bps, name, entry.Data)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		de.BlockInfo = info
+		de.Size = uint64(len(entry.Data))
+	case Dir:
+		info, size, err := fbo.readyTemplateDirLocked(
+			ctx, lState, md, uid, bps, entry, now)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		de.BlockInfo = info
+		de.Size = size
+	default:
+		return DirEntry{}, fmt.Errorf(
+			"Unexpected entry type %s for %s", entry.Type, name)
 	}
+	md.AddRefBlock(de.BlockInfo)
+	return de, nil
+}
 
-	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
-		func(lState *lockState) error {
-			filePath, err := fbo.pathFromNodeForMDWriteLocked(lState, file)
-			if err != nil {
-				return err
-			}
+// readyTemplateDirLocked readies the blocks for all of dir's
+// children, and then dir's own block, into bps.  It returns the info
+// and plaintext size of the directory block, which the caller must
+// add to md itself.
+func (fbo *folderBranchOps) readyTemplateDirLocked(ctx context.Context,
+	lState *lockState, md *RootMetadata, uid keybase1.UID,
+	bps *blockPutState, dir *tlfTemplateEntry, now int64) (
+	BlockInfo, uint64, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
 
-			return fbo.setMtimeLocked(ctx, lState, filePath, mtime)
-		})
+	dblock := &DirBlock{
+		Children: make(map[string]DirEntry, len(dir.Children)),
+	}
+	for name, child := range dir.Children {
+		de, err := fbo.readyTemplateEntryLocked(
+			ctx, lState, md, uid, bps, name, child, now)
+		if err != nil {
+			return BlockInfo{}, 0, err
+		}
+		dblock.Children[name] = de
+	}
+	info, plainSize, err := fbo.readyBlockMultiple(
+		ctx, md.ReadOnly(), dblock, uid, bps, keybase1.BlockType_DATA)
+	if err != nil {
+		return BlockInfo{}, 0, err
+	}
+	return info, uint64(plainSize), nil
 }
 
-func (fbo *folderBranchOps) syncLocked(ctx context.Context,
-	lState *lockState, file path) (stillDirty bool, err error) {
+// initFromTemplateLocked copies the whole template tree into the
+// root directory of this folder, which must be empty, in a single MD
+// update.
+func (fbo *folderBranchOps) initFromTemplateLocked(ctx context.Context,
+	lState *lockState, root Node, tmpl *tlfTemplateEntry) (err error) {
 	fbo.mdWriterLock.AssertLocked(lState)
 
-	// if the cache for this file isn't dirty, we're done
-	if !fbo.blocks.IsDirty(lState, file) {
-		return false, nil
+	if fbo.blocks.GetState(lState) != cleanState {
+		return NotPermittedWhileDirtyError{}
+	}
+	if !fbo.isMasterBranchLocked(lState) {
+		return UnmergedError{}
 	}
 
-	// Verify we have permission to write.  We do this after the dirty
-	// check because otherwise readers who sync clean files on close
-	// would get an error.
 	md, err := fbo.getMDForWriteLocked(ctx, lState)
 	if err != nil {
-		return true, err
+		return err
 	}
 
-	// If the MD doesn't match the MD expected by the path, that
-	// implies we are using a cached path, which implies the node has
-	// been unlinked.  In that case, we can safely ignore this sync.
-	if md.data.Dir.BlockPointer.ID != file.path[0].BlockPointer.ID {
-		fbo.log.CDebugf(ctx, "Skipping sync for a removed file %v",
-			file.tailPointer())
-		// Removing the cached info here is a little sketchy,
-		// since there's no guarantee that this sync comes
-		// from closing the file, and we still want to serve
-		// stat calls accurately if the user still has an open
-		// handle to this file.
-		//
-		// Note in particular that if a file just had a dirty
-		// directory entry cached (due to an attribute change on a
-		// removed file, for example), this will clear that attribute
-		// change.  If there's still an open file handle, the user
-		// won't be able to see the change anymore.
-		//
-		// TODO: Hook this in with the node cache GC logic to be
-		// perfectly accurate (but at the same time, we'd then have to
-		// fix up the intentional panic in the background flusher to
-		// be more tolerant of long-lived dirty, removed files).
-		return true, fbo.blocks.ClearCacheInfo(lState, file)
+	rootPath, err := fbo.pathFromNodeForMDWriteLocked(lState, root)
+	if err != nil {
+		return err
 	}
-
-	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	dblock, err := fbo.blocks.GetDir(
+		ctx, lState, md.ReadOnly(), rootPath, blockRead)
 	if err != nil {
-		return true, err
+		return err
+	}
+	if len(dblock.Children) > 0 {
+		return TlfNotEmptyError{md.GetTlfHandle().GetCanonicalName()}
+	}
+	if len(tmpl.Children) == 0 {
+		fbo.log.CDebugf(ctx, "Empty template; nothing to do")
+		return nil
 	}
 
-	if file.isValidForNotification() {
-		// notify the daemon that a write is being performed
-		fbo.config.Reporter().Notify(ctx, writeNotification(file, false))
-		defer fbo.config.Reporter().Notify(ctx, writeNotification(file, true))
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return err
 	}
 
-	// Filled in by doBlockPuts below.
-	var blocksToRemove []BlockPointer
-	fblock, bps, lbc, syncState, err :=
-		fbo.blocks.StartSync(ctx, lState, md, session.UID, file)
+	// Each top-level entry gets its own createOp, which carries the
+	// refs for everything under it.  The new root block replaces the
+	// old, empty one in all of them.
+	now := fbo.nowUnixNano()
+	bps := newBlockPutState(len(tmpl.Children))
 	defer func() {
-		fbo.blocks.CleanupSyncState(
-			ctx, lState, md.ReadOnly(), file, blocksToRemove, syncState, err)
+		if err != nil {
+			fbo.fbm.cleanUpBlockState(
+				md.ReadOnly(), bps, blockDeleteOnMDFail)
+		}
 	}()
-	if err != nil {
-		return true, err
+	newRoot := &DirBlock{
+		Children: make(map[string]DirEntry, len(tmpl.Children)),
 	}
+	var cops []*createOp
+	for name, child := range tmpl.Children {
+		co, err := newCreateOp(name, rootPath.tailPointer(), child.Type)
+		if err != nil {
+			return err
+		}
+		co.setFinalPath(rootPath)
+		md.AddOp(co)
+		cops = append(cops, co)
 
-	newPath, _, newBps, err :=
-		fbo.syncBlockAndCheckEmbedLocked(
-			ctx, lState, md, fblock, *file.parentPath(),
-			file.tailName(), File, true, true, zeroPtr, lbc)
-	if err != nil {
-		return true, err
+		de, err := fbo.readyTemplateEntryLocked(
+			ctx, lState, md, session.UID, bps, name, child, now)
+		if err != nil {
+			return err
+		}
+		newRoot.Children[name] = de
 	}
 
-	bps.mergeOtherBps(newBps)
+	info, plainSize, err := fbo.readyBlockMultiple(
+		ctx, md.ReadOnly(), newRoot, session.UID, bps,
+		keybase1.BlockType_DATA)
+	if err != nil {
+		return err
+	}
+	md.AddUpdate(md.data.Dir.BlockInfo, info)
+	for _, co := range cops[:len(cops)-1] {
+		co.AddUpdate(md.data.Dir.BlockPointer, info.BlockPointer)
+	}
+	md.data.Dir.BlockInfo = info
+	md.data.Dir.Size = uint64(plainSize)
+	md.data.Dir.Mtime = now
+	md.data.Dir.Ctime = now
 
-	// Note: We explicitly don't call fbo.fbm.cleanUpBlockState here
-	// when there's an error, because it's possible some of the blocks
-	// will be reused in a future attempt at this same sync, and we
-	// don't want them cleaned up in that case.  Instead, the
-	// FinishSync call below will take care of that.
+	bsplit := fbo.config.BlockSplitter()
+	if !bsplit.ShouldEmbedBlockChanges(&md.data.Changes) {
+		err = fbo.unembedBlockChanges(
+			ctx, bps, md, &md.data.Changes, session.UID)
+		if err != nil {
+			return err
+		}
+	}
 
-	blocksToRemove, err = doBlockPuts(ctx, fbo.config.BlockServer(),
+	_, err = doBlockPuts(ctx, fbo.config.BlockServer(),
 		fbo.config.BlockCache(), fbo.config.Reporter(), fbo.log, md.TlfID(),
 		md.GetTlfHandle().GetCanonicalName(), *bps)
 	if err != nil {
-		return true, err
-	}
-
-	// Call this under the same blockLock as when the pointers are
-	// updated, so there's never any point in time where a read or
-	// write might slip in after the pointers are updated, but before
-	// the deferred writes are re-applied.
-	afterUpdateFn := func() error {
-		stillDirty, err = fbo.blocks.FinishSyncLocked(
-			ctx, lState, file, newPath, md.ReadOnly(), syncState, fbo.fbm)
 		return err
 	}
 
-	err = fbo.finalizeMDWriteLocked(ctx, lState, md, bps, NoExcl, afterUpdateFn)
+	// Use an exclusive put, since the folder has to still be empty
+	// when this lands; if someone beat us to it, the retry will
+	// see their entries and fail.
+	return fbo.finalizeMDWriteLocked(ctx, lState, md, bps, WithExcl, nil)
+}
+
+func (fbo *folderBranchOps) MoveAcrossTLFs(
+	ctx context.Context, srcParent Node, srcName string, dstParent Node,
+	dstName string) error {
+	return errors.New("MoveAcrossTLFs is not supported by folderBranchOps")
+}
+
+// checkWritable returns an error if the current user couldn't write
+// to filename in this folder right now.
+func (fbo *folderBranchOps) checkWritable(
+	ctx context.Context, filename string) error {
+	lState := makeFBOLockState()
+	fbo.mdWriterLock.Lock(lState)
+	defer fbo.mdWriterLock.Unlock(lState)
+	_, err := fbo.getMDForWriteLockedForFilename(ctx, lState, filename)
+	return err
+}
+
+func (fbo *folderBranchOps) CreateTLFFrom(
+	ctx context.Context, h *TlfHandle, src Node) (Node, EntryInfo, error) {
+	return nil, EntryInfo{}, errors.New("CreateTLFFrom is not supported by folderBranchOps")
+}
+
+// initFromTemplate initializes this folder, whose root directory is
+// root, with a copy of tmpl.
+func (fbo *folderBranchOps) initFromTemplate(ctx context.Context,
+	root Node, tmpl *tlfTemplateEntry) (err error) {
+	fbo.log.CDebugf(ctx, "initFromTemplate %d entries", len(tmpl.Children))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "initFromTemplate done: %+v", err)
+	}()
+
+	err = fbo.checkNode(root)
 	if err != nil {
-		return true, err
+		return err
 	}
-	return stillDirty, err
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.initFromTemplateLocked(ctx, lState, root, tmpl)
+		})
 }
 
 func (fbo *folderBranchOps) Sync(ctx context.Context, file Node) (err error) {
-	fbo.log.CDebugf(ctx, "Sync %s", getNodeIDStr(file))
+	durability := fbo.getFsyncDurability(ctx)
+	fbo.log.CDebugf(ctx, "Sync %s (durability=%s)",
+		getNodeIDStr(file), durability)
 	defer func() {
 		fbo.deferLog.CDebugf(ctx, "Sync %s done: %+v",
 			getNodeIDStr(file), err)
 	}()
+	ctx = maybeNewContextWithBlockRequestOp(ctx, BlockRequestOpSync)
 
 	err = fbo.checkNode(file)
 	if err != nil {
 		return
 	}
 
+	if durability == FsyncDurabilityNone {
+		// Leave everything dirty for the background flusher.
+		return nil
+	}
+
+	err = fbo.blocks.FlushCoalescedWrites(ctx, makeFBOLockState(), file)
+	if err != nil {
+		return err
+	}
+
+	err = fbo.flushPendingAttrs(ctx, file)
+	if err != nil {
+		return err
+	}
+
 	var stillDirty bool
 	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
 		func(lState *lockState) error {
@@ -3852,6 +6201,14 @@ func (fbo *folderBranchOps) Sync(ctx context.Context, file Node) (err error) {
 		fbo.status.rmDirtyNode(file)
 	}
 
+	if durability == FsyncDurabilityServer {
+		if jServer, err := GetJournalServer(fbo.config); err == nil {
+			// Wait (rather than Flush) so that a canceled Sync
+			// doesn't leave the journal partially flushed.
+			return jServer.Wait(ctx, fbo.id())
+		}
+	}
+
 	return nil
 }
 
@@ -3866,7 +6223,18 @@ func (fbo *folderBranchOps) FolderStatus(
 			WrongOpsError{fbo.folderBranch, folderBranch}
 	}
 
-	return fbo.status.getStatus(ctx, &fbo.blocks)
+	fbs, updateChan, err = fbo.status.getStatus(ctx, &fbo.blocks)
+	if err != nil {
+		return FolderBranchStatus{}, nil, err
+	}
+	if fbo.folderBranch.Branch == MasterBranch &&
+		fbo.config.Mode() != InitMinimal {
+		qrStatus := fbo.fbm.getQuotaReclamationStatus()
+		fbs.QuotaReclamation = &qrStatus
+		bcStatus := fbo.fbm.challenger.getStatus()
+		fbs.BlockChallenges = &bcStatus
+	}
+	return fbs, updateChan, nil
 }
 
 func (fbo *folderBranchOps) Status(
@@ -3892,13 +6260,28 @@ func (fbo *folderBranchOps) UnregisterFromChanges(obs Observer) error {
 }
 
 // notifyBatchLocked sends out a notification for the most recent op
-// in md.
+// in md.  If md removes a directory tree (see removeDirTreeLocked),
+// it first sends out one for each entry removed under the tree, so
+// that their nodes get unlinked too.
 func (fbo *folderBranchOps) notifyBatchLocked(
 	ctx context.Context, lState *lockState, md ImmutableRootMetadata,
 	afterUpdateFn func() error) error {
 	fbo.headLock.AssertLocked(lState)
 
-	lastOp := md.data.Changes.Ops[len(md.data.Changes.Ops)-1]
+	ops := md.data.Changes.Ops
+	lastOp := ops[len(ops)-1]
+	if _, ok := lastOp.(*rmOp); ok {
+		// Only a directory tree removal has more than one rmOp.
+		for _, op := range ops[:len(ops)-1] {
+			if _, ok := op.(*rmOp); !ok {
+				continue
+			}
+			err := fbo.notifyOneOpLocked(ctx, lState, op, md, false, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	err := fbo.notifyOneOpLocked(ctx, lState, lastOp, md, false, afterUpdateFn)
 	if err != nil {
 		return err
@@ -4148,6 +6531,8 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 		changes = append(changes, NodeChange{
 			Node: childNode,
 		})
+	case *unknownOp:
+		fbo.log.CDebugf(ctx, "notifyOneOp: %s", realOp)
 	case *GCOp:
 		// Unreferenced blocks in a GCOp mean that we shouldn't cache
 		// them anymore
@@ -4438,12 +6823,29 @@ func (fbo *folderBranchOps) setLatestMergedRevisionLocked(ctx context.Context, l
 	}
 }
 
+// getMDUpdatesStart returns the first merged revision that hasn't
+// been applied yet.  That's usually the one after the latest merged
+// revision, but a merged head can be behind that if updates were
+// ignored while our own MDs were still in the journal; they have to
+// be fetched again once the journal is flushed.
+func (fbo *folderBranchOps) getMDUpdatesStart(
+	lState *lockState) MetadataRevision {
+	fbo.headLock.RLock(lState)
+	defer fbo.headLock.RUnlock(lState)
+	if fbo.head != (ImmutableRootMetadata{}) &&
+		fbo.head.MergedStatus() == Merged &&
+		fbo.head.Revision() < fbo.latestMergedRevision {
+		return fbo.head.Revision() + 1
+	}
+	return fbo.latestMergedRevision + 1
+}
+
 // Assumes all necessary locking is either already done by caller, or
 // is done by applyFunc.
 func (fbo *folderBranchOps) getAndApplyMDUpdates(ctx context.Context,
 	lState *lockState, applyFunc applyMDUpdatesFunc) error {
 	// first look up all MD revisions newer than my current head
-	start := fbo.getLatestMergedRevision(lState) + 1
+	start := fbo.getMDUpdatesStart(lState)
 	rmds, err := getMergedMDUpdates(ctx, fbo.config, fbo.id(), start)
 	if err != nil {
 		return err
@@ -4527,6 +6929,46 @@ func (fbo *folderBranchOps) getUnmergedMDUpdatesLocked(
 		fbo.bid, fbo.getCurrMDRevision(lState))
 }
 
+// saveUnmergedStateLocked saves the unmerged view of every file that
+// the given unmerged MDs changed since the branch point to local
+// disk, before they're thrown away.  Failing to save doesn't stop
+// the unmerged MDs from being thrown away, but it's reported to the
+// user.
+func (fbo *folderBranchOps) saveUnmergedStateLocked(ctx context.Context,
+	lState *lockState, branchPoint MetadataRevision,
+	unmergedRmds []ImmutableRootMetadata) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	storageRoot := fbo.config.StorageRoot()
+	if storageRoot == "" || len(unmergedRmds) == 0 {
+		return
+	}
+
+	err := func() error {
+		branchPointMD, err := getSingleMD(ctx, fbo.config, fbo.id(),
+			NullBranchID, branchPoint, Merged)
+		if err != nil {
+			return err
+		}
+		state, err := saveUnmergedState(ctx, fbo.config, fbo.log,
+			storageRoot, fbo.bid, branchPointMD,
+			unmergedRmds[len(unmergedRmds)-1])
+		if err != nil {
+			return err
+		}
+		fbo.log.CInfof(ctx, "Saved the unmerged state of %d files "+
+			"(%d bytes) as %s", len(state.Files), state.Bytes, state.ID)
+		return nil
+	}()
+	if err != nil {
+		fbo.log.CWarningf(ctx, "Couldn't save the unmerged state: %+v", err)
+		handle := unmergedRmds[0].GetTlfHandle()
+		fbo.config.Reporter().ReportErr(ctx, handle.GetCanonicalName(),
+			handle.IsPublic(), WriteMode,
+			UnmergedStateNotSavedError{fbo.id(), err})
+	}
+}
+
 // Returns a list of block pointers that were created during the
 // staged era.
 func (fbo *folderBranchOps) undoUnmergedMDUpdatesLocked(
@@ -4538,6 +6980,8 @@ func (fbo *folderBranchOps) undoUnmergedMDUpdatesLocked(
 		return nil, err
 	}
 
+	fbo.saveUnmergedStateLocked(ctx, lState, currHead, unmergedRmds)
+
 	err = fbo.undoMDUpdatesLocked(ctx, lState, unmergedRmds)
 	if err != nil {
 		return nil, err
@@ -4868,6 +7312,10 @@ func (fbo *folderBranchOps) SyncFromServerForTesting(
 
 	lState := makeFBOLockState()
 
+	if err := fbo.flushPendingAttrs(ctx, nil); err != nil {
+		return err
+	}
+
 	// A journal flush before CR, if needed.
 	if err := WaitForTLFJournal(ctx, fbo.config, fbo.id(),
 		fbo.log); err != nil {
@@ -5262,7 +7710,14 @@ func (fbo *folderBranchOps) registerForUpdates(ctx context.Context) (
 			currRev, err)
 	}()
 	// RegisterForUpdate will itself retry on connectivity issues
-	return fbo.config.MDServer().RegisterForUpdate(ctx, fbo.id(), currRev)
+	return fbo.getUpdateRegisterer().RegisterForUpdate(ctx, fbo.id(), currRev)
+}
+
+func (fbo *folderBranchOps) getUpdateRegisterer() mdUpdateRegisterer {
+	if fbo.updateRegisterer != nil {
+		return fbo.updateRegisterer
+	}
+	return fbo.config.MDServer()
 }
 
 func (fbo *folderBranchOps) waitForAndProcessUpdates(
@@ -5321,7 +7776,7 @@ func (fbo *folderBranchOps) waitForAndProcessUpdates(
 }
 
 func (fbo *folderBranchOps) backgroundFlusher(betweenFlushes time.Duration) {
-	ticker := time.NewTicker(betweenFlushes)
+	ticker := fbo.config.Clock().NewTicker(betweenFlushes)
 	defer ticker.Stop()
 	lState := makeFBOLockState()
 	var prevDirtyRefMap map[BlockRef]bool
@@ -5339,13 +7794,22 @@ func (fbo *folderBranchOps) backgroundFlusher(betweenFlushes time.Duration) {
 
 		if doSelect {
 			select {
-			case <-ticker.C:
+			case <-ticker.C():
 			case <-fbo.forceSyncChan:
 			case <-fbo.shutdownChan:
 				return
 			}
 		}
 
+		// Apply any buffered small writes, so that the files they
+		// touch are picked up as dirty below.
+		err := fbo.runUnlessShutdown(func(ctx context.Context) error {
+			return fbo.blocks.FlushCoalescedWrites(ctx, lState, nil)
+		})
+		if err != nil {
+			fbo.log.CDebugf(nil, "Couldn't apply coalesced writes: %+v", err)
+		}
+
 		dirtyRefs := fbo.blocks.GetDirtyRefs(lState)
 		if len(dirtyRefs) == 0 {
 			sameDirtyRefCount = 0
@@ -5773,7 +8237,7 @@ func (fbo *folderBranchOps) ClearPrivateFolderMD(ctx context.Context) {
 				ctx, "Context canceled before updater was canceled")
 			return
 		}
-		fbo.config.MDServer().CancelRegistration(ctx, fbo.id())
+		fbo.getUpdateRegisterer().CancelRegistration(ctx, fbo.id())
 	}
 
 	fbo.head = ImmutableRootMetadata{}
@@ -5838,3 +8302,36 @@ func (fbo *folderBranchOps) ForceFastForward(ctx context.Context) {
 func (fbo *folderBranchOps) PushConnectionStatusChange(service string, newStatus error) {
 	fbo.config.KBFSOps().PushConnectionStatusChange(service, newStatus)
 }
+
+// SetSessionUser implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) SetSessionUser(
+	ctx context.Context, uid keybase1.UID) bool {
+	return fbo.config.KBFSOps().SetSessionUser(ctx, uid)
+}
+
+// DeviceRevoked implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) DeviceRevoked(
+	ctx context.Context, err DeviceRevokedError) {
+	fbo.config.KBFSOps().DeviceRevoked(ctx, err)
+}
+
+// PauseBackgroundWork implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) PauseBackgroundWork(ctx context.Context) {
+	fbo.config.KBFSOps().PauseBackgroundWork(ctx)
+}
+
+// ResumeBackgroundWork implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) ResumeBackgroundWork(ctx context.Context) {
+	fbo.config.KBFSOps().ResumeBackgroundWork(ctx)
+}
+
+// OverrideBandwidthCaps implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) OverrideBandwidthCaps(
+	ctx context.Context, caps *BandwidthCaps) {
+	fbo.config.KBFSOps().OverrideBandwidthCaps(ctx, caps)
+}
@@ -22,6 +22,12 @@ type FolderBranchStatus struct {
 	HeadWriter          libkb.NormalizedUsername
 	DiskUsage           uint64
 	RekeyPending        bool
+	Frozen              bool
+	AppendOnly          bool
+	NameNormalization   string
+	HistoryRetention    string
+	PinnedRevisions     []MetadataRevision `json:",omitempty"`
+	Snapshots           []TLFSnapshot      `json:",omitempty"`
 	LatestKeyGeneration KeyGen
 	FolderID            string
 	Revision            MetadataRevision
@@ -38,6 +44,13 @@ type FolderBranchStatus struct {
 
 	Journal *TLFJournalStatus `json:",omitempty"`
 
+	QuotaReclamation *QuotaReclamationStatus `json:",omitempty"`
+	BlockChallenges  *BlockChallengeStatus   `json:",omitempty"`
+
+	// Usage is the server traffic this folder has caused since
+	// KBFS started.
+	Usage *TLFUsageStatus `json:",omitempty"`
+
 	PermanentErr string `json:",omitempty"`
 }
 
@@ -50,7 +63,32 @@ type KBFSStatus struct {
 	UsageBytes      int64
 	LimitBytes      int64
 	FailingServices map[string]error
-	JournalServer   *JournalServerStatus `json:",omitempty"`
+	JournalServer   *JournalServerStatus     `json:",omitempty"`
+	CrossTLFMoves   []CrossTLFMoveStatus     `json:",omitempty"`
+	FavoriteHeads   *FavoriteHeadFetchStatus `json:",omitempty"`
+	// Recovery describes the last unclean shutdown, if the one
+	// before this process started wasn't clean.
+	Recovery *RecoveryReport `json:",omitempty"`
+	// TLFUsage is the server traffic each folder has caused since
+	// KBFS started, keyed by folder ID.
+	TLFUsage map[string]*TLFUsageStatus `json:",omitempty"`
+	// DiskCache describes each partition of the disk block cache,
+	// keyed by partition name, if the cache is partitioned.
+	DiskCache map[string]DiskBlockCachePartitionStatus `json:",omitempty"`
+	// DiskCacheEvictions are the most recent evictions from the
+	// disk block cache, oldest first.
+	DiskCacheEvictions []DiskBlockCacheEviction `json:",omitempty"`
+	// PendingRekeys lists the folders that haven't been fully
+	// rekeyed yet, e.g. for a newly-added device.
+	PendingRekeys []RekeyStatus `json:",omitempty"`
+	// MerkleChecks are the results of the last check of each
+	// folder's head against the MD server's Merkle tree.
+	MerkleChecks []MerkleCheckStatus `json:",omitempty"`
+	// BackgroundWork says whether journal flushing and
+	// prefetching are paused, and why.
+	BackgroundWork BackgroundWorkStatus
+	// Bandwidth says which caps block server traffic is held to.
+	Bandwidth BandwidthStatus
 }
 
 // StatusUpdate is a dummy type used to indicate status has been updated.
@@ -70,6 +108,11 @@ type folderBranchStatusKeeper struct {
 	merged     []*crChainSummary
 	dataMutex  sync.Mutex
 
+	// dirtyListener, if non-nil, is called with dataMutex held
+	// whenever the folder-branch goes from having no dirty nodes
+	// to having some, or back.
+	dirtyListener func(dirty bool)
+
 	updateChan  chan StatusUpdate
 	updateMutex sync.Mutex
 }
@@ -124,6 +167,19 @@ func (fbsk *folderBranchStatusKeeper) setPermErr(err error) {
 	fbsk.signalChangeLocked()
 }
 
+func (fbsk *folderBranchStatusKeeper) setDirtyListener(fn func(dirty bool)) {
+	fbsk.dataMutex.Lock()
+	defer fbsk.dataMutex.Unlock()
+	fbsk.dirtyListener = fn
+}
+
+// dataMutex should be taken by the caller
+func (fbsk *folderBranchStatusKeeper) notifyDirtyLocked() {
+	if fbsk.dirtyListener != nil {
+		fbsk.dirtyListener(len(fbsk.dirtyNodes) > 0)
+	}
+}
+
 func (fbsk *folderBranchStatusKeeper) addNode(m map[NodeID]Node, n Node) {
 	fbsk.dataMutex.Lock()
 	defer fbsk.dataMutex.Unlock()
@@ -133,6 +189,9 @@ func (fbsk *folderBranchStatusKeeper) addNode(m map[NodeID]Node, n Node) {
 		return
 	}
 	m[id] = n
+	if len(fbsk.dirtyNodes) == 1 {
+		fbsk.notifyDirtyLocked()
+	}
 	fbsk.signalChangeLocked()
 }
 
@@ -145,6 +204,9 @@ func (fbsk *folderBranchStatusKeeper) rmNode(m map[NodeID]Node, n Node) {
 		return
 	}
 	delete(m, id)
+	if len(fbsk.dirtyNodes) == 0 {
+		fbsk.notifyDirtyLocked()
+	}
 	fbsk.signalChangeLocked()
 }
 
@@ -190,6 +252,12 @@ func (fbsk *folderBranchStatusKeeper) getStatus(ctx context.Context,
 		fbs.HeadWriter = name
 		fbs.DiskUsage = fbsk.md.DiskUsage()
 		fbs.RekeyPending = fbsk.config.RekeyQueue().IsRekeyPending(fbsk.md.TlfID())
+		fbs.Frozen = fbsk.md.IsFrozen()
+		fbs.AppendOnly = fbsk.md.IsAppendOnly()
+		fbs.NameNormalization = fbsk.md.NameNormalization().String()
+		fbs.HistoryRetention = fbsk.md.HistoryRetention().String()
+		fbs.PinnedRevisions = fbsk.md.PinnedRevisions()
+		fbs.Snapshots = fbsk.md.Snapshots()
 		fbs.LatestKeyGeneration = fbsk.md.LatestKeyGeneration()
 		fbs.FolderID = fbsk.md.TlfID().String()
 		fbs.Revision = fbsk.md.Revision()
@@ -0,0 +1,272 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"golang.org/x/net/context"
+)
+
+// FSEventType is the kind of change described by an FSEvent.
+type FSEventType int
+
+const (
+	// FSEventCreate is the creation of a file, directory or symlink.
+	FSEventCreate FSEventType = iota
+	// FSEventModify is a write to a file.
+	FSEventModify
+	// FSEventRemove is the removal of an entry.
+	FSEventRemove
+	// FSEventRename is the renaming of an entry.
+	FSEventRename
+	// FSEventSetAttr is a change to an entry's attributes.
+	FSEventSetAttr
+)
+
+func (t FSEventType) String() string {
+	switch t {
+	case FSEventCreate:
+		return "create"
+	case FSEventModify:
+		return "modify"
+	case FSEventRemove:
+		return "remove"
+	case FSEventRename:
+		return "rename"
+	case FSEventSetAttr:
+		return "setattr"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	fsEventParamRevision = "revision"
+	fsEventParamOp       = "op"
+)
+
+// FSEvent describes a single change to a TLF that was streamed to
+// subscribers, whichever device made it.
+type FSEvent struct {
+	Type FSEventType
+	// Path and OldPath (for renames) are canonical paths,
+	// e.g. /keybase/private/alice/foo.
+	Path      string
+	OldPath   string
+	Writer    keybase1.UID
+	Revision  MetadataRevision
+	LocalTime time.Time
+
+	public bool
+}
+
+// toNotification converts the event into the notification that's
+// sent to the service, which passes it on to its NotifyFS listeners.
+func (e FSEvent) toNotification() *keybase1.FSNotification {
+	var nType keybase1.FSNotificationType
+	switch e.Type {
+	case FSEventCreate:
+		nType = keybase1.FSNotificationType_FILE_CREATED
+	case FSEventRemove:
+		nType = keybase1.FSNotificationType_FILE_DELETED
+	case FSEventRename:
+		nType = keybase1.FSNotificationType_FILE_RENAMED
+	default:
+		nType = keybase1.FSNotificationType_FILE_MODIFIED
+	}
+	params := map[string]string{
+		fsEventParamRevision: strconv.FormatInt(int64(e.Revision), 10),
+		fsEventParamOp:       e.Type.String(),
+	}
+	if e.Type == FSEventRename {
+		params[errorParamRenameOldFilename] = e.OldPath
+	}
+	return &keybase1.FSNotification{
+		PublicTopLevelFolder: e.public,
+		Filename:             e.Path,
+		StatusCode:           keybase1.FSStatusCode_FINISH,
+		NotificationType:     nType,
+		Params:               params,
+		WriterUid:            e.Writer,
+		LocalTime:            keybase1.ToTime(e.LocalTime),
+	}
+}
+
+// fsEventList is a list of events that sorts by revision, and then by
+// path.
+type fsEventList []FSEvent
+
+func (l fsEventList) Len() int {
+	return len(l)
+}
+
+func (l fsEventList) Less(i, j int) bool {
+	if l[i].Revision != l[j].Revision {
+		return l[i].Revision < l[j].Revision
+	}
+	return l[i].Path < l[j].Path
+}
+
+func (l fsEventList) Swap(i, j int) {
+	l[j], l[i] = l[i], l[j]
+}
+
+func makeFSEvent(t FSEventType, p path, o op) FSEvent {
+	wi := o.getWriterInfo()
+	return FSEvent{
+		Type:      t,
+		Path:      p.CanonicalPathString(),
+		Writer:    wi.uid,
+		Revision:  wi.revision,
+		LocalTime: o.getLocalTimestamp(),
+		public:    p.Tlf.IsPublic(),
+	}
+}
+
+// getFSEvents returns the events for all the changes in the given
+// MDs, ordered by revision.  Changes that cancel each other out
+// within the MDs (like a create followed by a remove) are collapsed
+// away.
+func (teh *TlfEditHistory) getFSEvents(ctx context.Context,
+	rmds []ImmutableRootMetadata) ([]FSEvent, error) {
+	chains, err := newCRChainsForIRMDs(
+		ctx, teh.config.Codec(), rmds, &teh.fbo.blocks, false)
+	if err != nil {
+		return nil, err
+	}
+	_, err = chains.getPaths(ctx, &teh.fbo.blocks, teh.log, teh.fbo.nodeCache,
+		true)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []FSEvent
+	renamedFrom := make(map[string]bool)
+	for original, ri := range chains.renamedOriginals {
+		oldParentChain, ok := chains.byOriginal[ri.originalOldParent]
+		if !ok || len(oldParentChain.ops) == 0 {
+			teh.log.CDebugf(ctx, "Couldn't find old parent to a rename "+
+				"op for original ptr %v", original)
+			continue
+		}
+		newParentChain, ok := chains.byOriginal[ri.originalNewParent]
+		if !ok {
+			teh.log.CDebugf(ctx, "Couldn't find new parent to a rename "+
+				"op for original ptr %v", original)
+			continue
+		}
+		for _, o := range newParentChain.ops {
+			cop, ok := o.(*createOp)
+			if !ok || !cop.renamed || cop.NewName != ri.newName {
+				continue
+			}
+			oldPath := oldParentChain.ops[0].getFinalPath().
+				ChildPathNoPtr(ri.oldName)
+			e := makeFSEvent(FSEventRename,
+				cop.getFinalPath().ChildPathNoPtr(ri.newName), cop)
+			e.OldPath = oldPath.CanonicalPathString()
+			renamedFrom[e.OldPath] = true
+			events = append(events, e)
+			break
+		}
+	}
+
+	for _, chain := range chains.byOriginal {
+		for _, o := range chain.ops {
+			switch realOp := o.(type) {
+			case *createOp:
+				if realOp.renamed {
+					continue
+				}
+				events = append(events, makeFSEvent(FSEventCreate,
+					o.getFinalPath().ChildPathNoPtr(realOp.NewName), o))
+			case *rmOp:
+				p := o.getFinalPath().ChildPathNoPtr(realOp.OldName)
+				if renamedFrom[p.CanonicalPathString()] {
+					continue
+				}
+				events = append(events, makeFSEvent(FSEventRemove, p, o))
+			case *syncOp:
+				events = append(events, makeFSEvent(FSEventModify,
+					o.getFinalPath(), o))
+			case *setAttrOp:
+				// Like syncOps, these are in the chain of the file
+				// itself.
+				events = append(events, makeFSEvent(FSEventSetAttr,
+					o.getFinalPath(), o))
+			}
+		}
+	}
+
+	sort.Stable(fsEventList(events))
+	return events, nil
+}
+
+// streamFSEvents sends notifications for all the changes in the
+// given MDs, if anyone has subscribed to events for this TLF.
+func (teh *TlfEditHistory) streamFSEvents(ctx context.Context,
+	rmds []ImmutableRootMetadata) {
+	if !teh.fbo.isStreamingFSEvents() {
+		return
+	}
+	events, err := teh.getFSEvents(ctx, rmds)
+	if err != nil {
+		teh.log.CWarningf(ctx, "Couldn't get FS events: %+v", err)
+		return
+	}
+	for _, e := range events {
+		teh.config.Reporter().Notify(ctx, e.toNotification())
+	}
+}
+
+// FSEventsInterface is the service-facing RPC interface that
+// controls which TLFs stream FSEvents.  The events themselves are
+// sent to the service as FS notifications, with the revision and op
+// in their params.
+type FSEventsInterface interface {
+	FSEventsSubscribe(context.Context, keybase1.Folder) error
+	FSEventsUnsubscribe(context.Context, keybase1.Folder) error
+}
+
+// FSEventsSubscribeArg is the argument to both FSEventsInterface
+// methods.
+type FSEventsSubscribeArg struct {
+	Folder keybase1.Folder `codec:"folder" json:"folder"`
+}
+
+// FSEventsProtocol returns the RPC protocol that serves the given
+// FSEventsInterface.
+func FSEventsProtocol(i FSEventsInterface) rpc.Protocol {
+	handler := func(fn func(context.Context, keybase1.Folder) error) rpc.ServeHandlerDescription {
+		return rpc.ServeHandlerDescription{
+			MakeArg: func() interface{} {
+				ret := make([]FSEventsSubscribeArg, 1)
+				return &ret
+			},
+			Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+				typedArgs, ok := args.(*[]FSEventsSubscribeArg)
+				if !ok {
+					err = rpc.NewTypeError((*[]FSEventsSubscribeArg)(nil), args)
+					return
+				}
+				err = fn(ctx, (*typedArgs)[0].Folder)
+				return
+			},
+			MethodType: rpc.MethodCall,
+		}
+	}
+	return rpc.Protocol{
+		Name: "keybase.1.kbfsEvents",
+		Methods: map[string]rpc.ServeHandlerDescription{
+			"subscribe":   handler(i.FSEventsSubscribe),
+			"unsubscribe": handler(i.FSEventsUnsubscribe),
+		},
+	}
+}
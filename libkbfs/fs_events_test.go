@@ -0,0 +1,123 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type fsEventsTestReporter struct {
+	*ReporterSimple
+
+	lock          sync.Mutex
+	notifications []*keybase1.FSNotification
+}
+
+func (r *fsEventsTestReporter) Notify(
+	_ context.Context, n *keybase1.FSNotification) {
+	if _, ok := n.Params[fsEventParamOp]; !ok {
+		// Not an FSEvent.
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.notifications = append(r.notifications, n)
+}
+
+func (r *fsEventsTestReporter) take() []*keybase1.FSNotification {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ns := r.notifications
+	r.notifications = nil
+	return ns
+}
+
+func TestFSEventsStreamRemoteChanges(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, u1, u2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, u2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	reporter := &fsEventsTestReporter{
+		ReporterSimple: NewReporterSimple(config2.Clock(), 10),
+	}
+	config2.SetReporter(reporter)
+
+	name := u1.String() + "," + u2.String()
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	kbfsOps2 := config2.KBFSOps()
+	fb := rootNode2.GetFolderBranch()
+
+	// Nothing is streamed before subscribing.
+	_, _, err := kbfsOps1.CreateDir(ctx, rootNode1, "ignored")
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServerForTesting(ctx, fb)
+	require.NoError(t, err)
+	require.Len(t, reporter.take(), 0)
+
+	err = kbfsOps2.SetFSEventStreaming(ctx, fb, true)
+	require.NoError(t, err)
+
+	checkEvent := func(
+		nType keybase1.FSNotificationType, op FSEventType, p string) {
+		err := kbfsOps2.SyncFromServerForTesting(ctx, fb)
+		require.NoError(t, err)
+		ns := reporter.take()
+		require.Len(t, ns, 1)
+		n := ns[0]
+		require.Equal(t, nType, n.NotificationType)
+		require.Equal(t, "/keybase/private/"+name+"/"+p, n.Filename)
+		require.Equal(t, op.String(), n.Params[fsEventParamOp])
+		head, _, err := kbfsOps2.FolderStatus(ctx, fb)
+		require.NoError(t, err)
+		require.Equal(t,
+			strconv.FormatInt(int64(head.Revision), 10),
+			n.Params[fsEventParamRevision])
+		session1, err := config1.KBPKI().GetCurrentSession(ctx)
+		require.NoError(t, err)
+		require.Equal(t, session1.UID, n.WriterUid)
+	}
+
+	fileNode1, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	checkEvent(keybase1.FSNotificationType_FILE_CREATED, FSEventCreate, "a")
+
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, fileNode1)
+	require.NoError(t, err)
+	checkEvent(keybase1.FSNotificationType_FILE_MODIFIED, FSEventModify, "a")
+
+	err = kbfsOps1.SetEx(ctx, fileNode1, true)
+	require.NoError(t, err)
+	checkEvent(keybase1.FSNotificationType_FILE_MODIFIED, FSEventSetAttr, "a")
+
+	err = kbfsOps1.Rename(ctx, rootNode1, "a", rootNode1, "b")
+	require.NoError(t, err)
+	checkEvent(keybase1.FSNotificationType_FILE_RENAMED, FSEventRename, "b")
+
+	err = kbfsOps1.RemoveEntry(ctx, rootNode1, "b")
+	require.NoError(t, err)
+	checkEvent(keybase1.FSNotificationType_FILE_DELETED, FSEventRemove, "b")
+
+	// Nothing more is streamed after unsubscribing.
+	err = kbfsOps2.SetFSEventStreaming(ctx, fb, false)
+	require.NoError(t, err)
+	_, _, err = kbfsOps1.CreateDir(ctx, rootNode1, "ignored2")
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServerForTesting(ctx, fb)
+	require.NoError(t, err)
+	require.Len(t, reporter.take(), 0)
+}
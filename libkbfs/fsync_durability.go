@@ -0,0 +1,118 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// FsyncDurability describes what a call to KBFSOps.Sync guarantees
+// by the time it returns.
+type FsyncDurability int
+
+const (
+	// FsyncDurabilityDefault means the durability level should be
+	// inherited from the next-most-general setting: a per-handle
+	// setting falls back to the TLF's setting, which falls back to
+	// Config.FsyncDurability().
+	FsyncDurabilityDefault FsyncDurability = iota
+	// FsyncDurabilityJournal means Sync returns once the file's
+	// changes have been made into a new revision, which has been
+	// persisted to the local journal if journaling is enabled for
+	// the TLF.  This is the behavior when nothing else is set.
+	FsyncDurabilityJournal
+	// FsyncDurabilityServer means Sync returns only once the file's
+	// changes have been flushed all the way to the servers, waiting
+	// on the journal if necessary.
+	FsyncDurabilityServer
+	// FsyncDurabilityNone means Sync is a no-op, and dirty data is
+	// left for the background flusher to sync later.
+	FsyncDurabilityNone
+)
+
+func (d FsyncDurability) String() string {
+	switch d {
+	case FsyncDurabilityDefault:
+		return "default"
+	case FsyncDurabilityJournal:
+		return "journal"
+	case FsyncDurabilityServer:
+		return "server"
+	case FsyncDurabilityNone:
+		return "none"
+	}
+	return fmt.Sprintf("FsyncDurability(%d)", int(d))
+}
+
+// ParseFsyncDurability parses the String() form of an
+// FsyncDurability.
+func ParseFsyncDurability(s string) (FsyncDurability, error) {
+	switch strings.ToLower(s) {
+	case "default", "":
+		return FsyncDurabilityDefault, nil
+	case "journal":
+		return FsyncDurabilityJournal, nil
+	case "server":
+		return FsyncDurabilityServer, nil
+	case "none":
+		return FsyncDurabilityNone, nil
+	}
+	return FsyncDurabilityDefault, fmt.Errorf(
+		"Unknown fsync durability %q; must be journal, server or none", s)
+}
+
+// fsyncDurabilityFlag is for specifying an FsyncDurability with the
+// flag package.
+type fsyncDurabilityFlag struct {
+	d *FsyncDurability
+}
+
+// String for flag interface.
+func (f fsyncDurabilityFlag) String() string {
+	if f.d == nil {
+		return FsyncDurabilityDefault.String()
+	}
+	return f.d.String()
+}
+
+// Set for flag interface.
+func (f fsyncDurabilityFlag) Set(raw string) error {
+	d, err := ParseFsyncDurability(raw)
+	if err != nil {
+		return err
+	}
+	*f.d = d
+	return nil
+}
+
+// CtxFsyncDurabilityKeyType is the type for the context key holding
+// a per-handle FsyncDurability.
+type CtxFsyncDurabilityKeyType int
+
+const (
+	// CtxFsyncDurabilityKey is set in the context of a Sync call to
+	// override the durability level for just that call, e.g. for a
+	// file handle that was opened with its own durability
+	// requirements.
+	CtxFsyncDurabilityKey CtxFsyncDurabilityKeyType = iota
+)
+
+// NewContextWithFsyncDurability returns a context that makes any
+// Sync done with it use the given durability level, overriding the
+// TLF and global settings.
+func NewContextWithFsyncDurability(
+	ctx context.Context, d FsyncDurability) context.Context {
+	return context.WithValue(ctx, CtxFsyncDurabilityKey, d)
+}
+
+func fsyncDurabilityFromContext(ctx context.Context) FsyncDurability {
+	if d, ok := ctx.Value(CtxFsyncDurabilityKey).(FsyncDurability); ok {
+		return d
+	}
+	return FsyncDurabilityDefault
+}
@@ -0,0 +1,25 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFsyncDurability(t *testing.T) {
+	for _, d := range []FsyncDurability{
+		FsyncDurabilityDefault, FsyncDurabilityJournal,
+		FsyncDurabilityServer, FsyncDurabilityNone,
+	} {
+		parsed, err := ParseFsyncDurability(d.String())
+		require.NoError(t, err)
+		require.Equal(t, d, parsed)
+	}
+
+	_, err := ParseFsyncDurability("sometimes")
+	require.Error(t, err)
+}
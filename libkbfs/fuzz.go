@@ -0,0 +1,147 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build gofuzz
+
+package libkbfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/tlf"
+)
+
+// The fuzz* functions below are self-contained entry points that
+// decode and validate data that KBFS gets from the servers or reads
+// back from its own journals, the same way KBFS does.  They must
+// never panic, whatever their input.  Like go-fuzz's Fuzz functions,
+// they return 1 if the input was decoded and validated successfully
+// (which makes it a good candidate for further mutation), and 0
+// otherwise.  See fuzz_gofuzz.go for how to run them with go-fuzz.
+// They're only built with the gofuzz tag, so their seed tests in
+// fuzz_test.go run with:
+//
+//   go test -tags gofuzz -run Fuzz github.com/keybase/kbfs/libkbfs
+
+// numFuzzMetadataVers is the number of metadata versions that the
+// first byte of a fuzzed MD input selects between.
+const numFuzzMetadataVers = int(SegregatedKeyBundlesVer-
+	FirstValidMetadataVer) + 1
+
+// fuzzMetadataVer splits the given fuzz input into a valid metadata
+// version and the rest of the input.
+func fuzzMetadataVer(data []byte) (MetadataVer, []byte, bool) {
+	if len(data) == 0 {
+		return 0, nil, false
+	}
+	ver := FirstValidMetadataVer +
+		MetadataVer(int(data[0])%numFuzzMetadataVers)
+	return ver, data[1:], true
+}
+
+// fuzzRootMetadataSigned decodes a signed MD object, prefixed with a
+// byte that selects its metadata version, as returned by the MD
+// server, and checks its validity and signatures.
+func fuzzRootMetadataSigned(data []byte) int {
+	ver, buf, ok := fuzzMetadataVer(data)
+	if !ok {
+		return 0
+	}
+	codec := kbfscodec.NewMsgpack()
+	rmds, err := DecodeRootMetadataSigned(
+		codec, tlf.NullID, ver, SegregatedKeyBundlesVer, buf, time.Time{})
+	if err != nil {
+		return 0
+	}
+	crypto := MakeCryptoCommon(codec)
+	if err := rmds.IsValidAndSigned(codec, crypto, nil); err != nil {
+		return 0
+	}
+	if _, err := rmds.MD.MakeBareTlfHandle(nil); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// fuzzBareRootMetadata decodes an MD object, prefixed with a byte
+// that selects its metadata version, as stored in an MD journal, and
+// checks its validity.
+func fuzzBareRootMetadata(data []byte) int {
+	ver, buf, ok := fuzzMetadataVer(data)
+	if !ok {
+		return 0
+	}
+	codec := kbfscodec.NewMsgpack()
+	brmd, err := DecodeRootMetadata(
+		codec, tlf.NullID, ver, SegregatedKeyBundlesVer, buf)
+	if err != nil {
+		return 0
+	}
+	crypto := MakeCryptoCommon(codec)
+	if err := brmd.IsValidAndSigned(codec, crypto, nil); err != nil {
+		return 0
+	}
+	if _, err := brmd.MakeBareTlfHandle(nil); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// fuzzBlock decodes block data as returned by the block server, and
+// block contents as they are after decryption, both as a file block
+// and as a directory block.
+func fuzzBlock(data []byte) int {
+	codec := kbfscodec.NewMsgpack()
+	ret := 0
+	var encryptedBlock EncryptedBlock
+	if err := codec.Decode(data, &encryptedBlock); err == nil {
+		ret = 1
+	}
+
+	crypto := MakeCryptoCommon(codec)
+	encodedBlock, err := crypto.depadBlock(data)
+	if err != nil {
+		// Also try the data as unpadded block contents.
+		encodedBlock = data
+	}
+	var fblock FileBlock
+	if err := codec.Decode(encodedBlock, &fblock); err == nil {
+		ret = 1
+		for _, ptr := range fblock.IPtrs {
+			_ = ptr.BlockPointer.IsValid()
+		}
+	}
+	var dblock DirBlock
+	if err := codec.Decode(encodedBlock, &dblock); err == nil {
+		ret = 1
+		for _, de := range dblock.Children {
+			_ = de.Type.String()
+			_ = de.BlockPointer.IsValid()
+		}
+	}
+	return ret
+}
+
+// fuzzJournalEntry decodes a block journal entry and an MD ID
+// journal entry, as read back from disk.
+func fuzzJournalEntry(data []byte) int {
+	codec := kbfscodec.NewMsgpack()
+	ret := 0
+	var bEntry blockJournalEntry
+	if err := codec.Decode(data, &bEntry); err == nil {
+		ret = 1
+		switch bEntry.Op {
+		case blockPutOp, addRefOp:
+			if _, _, err := bEntry.getSingleContext(); err != nil {
+				ret = 0
+			}
+		}
+	}
+	var mdEntry mdIDJournalEntry
+	if err := codec.Decode(data, &mdEntry); err == nil {
+		ret = 1
+	}
+	return ret
+}
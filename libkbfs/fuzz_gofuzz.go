@@ -0,0 +1,38 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build gofuzz
+
+// This file exports the fuzzing entry points in fuzz.go for go-fuzz,
+// e.g.:
+//
+//   go-fuzz-build -func FuzzRootMetadataSigned \
+//       github.com/keybase/kbfs/libkbfs
+//   go-fuzz -bin=libkbfs-fuzz.zip -workdir=/tmp/fuzz-rmds
+//
+// or, for libFuzzer, add -libfuzzer to go-fuzz-build.
+
+package libkbfs
+
+// FuzzRootMetadataSigned is the go-fuzz entry point for
+// fuzzRootMetadataSigned.
+func FuzzRootMetadataSigned(data []byte) int {
+	return fuzzRootMetadataSigned(data)
+}
+
+// FuzzBareRootMetadata is the go-fuzz entry point for
+// fuzzBareRootMetadata.
+func FuzzBareRootMetadata(data []byte) int {
+	return fuzzBareRootMetadata(data)
+}
+
+// FuzzBlock is the go-fuzz entry point for fuzzBlock.
+func FuzzBlock(data []byte) int {
+	return fuzzBlock(data)
+}
+
+// FuzzJournalEntry is the go-fuzz entry point for fuzzJournalEntry.
+func FuzzJournalEntry(data []byte) int {
+	return fuzzJournalEntry(data)
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build gofuzz
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// makeFuzzMDSeeds returns, for each metadata version, a valid
+// encoded signed MD object and a valid encoded bare MD object for a
+// public TLF, both prefixed with the version byte that the fuzz
+// entry points expect.
+func makeFuzzMDSeeds(t *testing.T) (rmdsSeeds, brmdSeeds [][]byte) {
+	ctx := context.Background()
+	codec := kbfscodec.NewMsgpack()
+	signer := kbfscrypto.SigningKeySigner{
+		Key: kbfscrypto.MakeFakeSigningKeyOrBust("key"),
+	}
+	uid := keybase1.MakeTestUID(1)
+	bh, err := tlf.MakeHandle(
+		[]keybase1.UID{uid}, []keybase1.UID{keybase1.PublicUID},
+		nil, nil, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < numFuzzMetadataVers; i++ {
+		ver := FirstValidMetadataVer + MetadataVer(i)
+		brmd, err := MakeInitialBareRootMetadata(
+			ver, tlf.FakeID(1, true), bh)
+		require.NoError(t, err)
+		brmd.SetLastModifyingWriter(uid)
+		brmd.SetLastModifyingUser(uid)
+		brmd.SetSerializedPrivateMetadata([]byte{42})
+		err = brmd.SignWriterMetadataInternally(ctx, codec, signer)
+		require.NoError(t, err)
+
+		rmds, err := SignBareRootMetadata(
+			ctx, codec, signer, signer, brmd, time.Time{})
+		require.NoError(t, err)
+
+		buf, err := EncodeRootMetadataSigned(codec, rmds)
+		require.NoError(t, err)
+		rmdsSeeds = append(rmdsSeeds, append([]byte{byte(i)}, buf...))
+
+		buf, err = codec.Encode(brmd)
+		require.NoError(t, err)
+		brmdSeeds = append(brmdSeeds, append([]byte{byte(i)}, buf...))
+	}
+	return rmdsSeeds, brmdSeeds
+}
+
+func makeFuzzBlockSeeds(t *testing.T) [][]byte {
+	codec := kbfscodec.NewMsgpack()
+	crypto := MakeCryptoCommon(codec)
+
+	fblock := NewFileBlock().(*FileBlock)
+	fblock.Contents = []byte{1, 2, 3, 4}
+	ifblock := NewFileBlock().(*FileBlock)
+	ifblock.IsInd = true
+	ifblock.IPtrs = []IndirectFilePtr{{
+		BlockInfo: BlockInfo{
+			BlockPointer: BlockPointer{
+				ID:      kbfsblock.FakeID(1),
+				KeyGen:  1,
+				DataVer: FirstValidDataVer,
+				Context: kbfsblock.MakeFirstContext(
+					keybase1.MakeTestUID(1), keybase1.BlockType_DATA),
+			},
+			EncodedSize: 10,
+		},
+		Off: 0,
+	}}
+	dblock := NewDirBlock().(*DirBlock)
+	dblock.Children["a"] = DirEntry{
+		BlockInfo: ifblock.IPtrs[0].BlockInfo,
+		EntryInfo: EntryInfo{Type: File, Size: 4},
+	}
+
+	var seeds [][]byte
+	for _, b := range []Block{fblock, ifblock, dblock} {
+		buf, err := codec.Encode(b)
+		require.NoError(t, err)
+		seeds = append(seeds, buf)
+		padded, err := crypto.padBlock(buf)
+		require.NoError(t, err)
+		seeds = append(seeds, padded)
+	}
+	return seeds
+}
+
+func makeFuzzJournalSeeds(t *testing.T) [][]byte {
+	codec := kbfscodec.NewMsgpack()
+	bContext := kbfsblock.MakeFirstContext(
+		keybase1.MakeTestUID(1), keybase1.BlockType_DATA)
+	bEntry := blockJournalEntry{
+		Op: blockPutOp,
+		Contexts: map[kbfsblock.ID][]kbfsblock.Context{
+			kbfsblock.FakeID(1): {bContext},
+		},
+	}
+	bBuf, err := codec.Encode(bEntry)
+	require.NoError(t, err)
+	mdBuf, err := codec.Encode(mdIDJournalEntry{ID: fakeMdID(1)})
+	require.NoError(t, err)
+	return [][]byte{bBuf, mdBuf}
+}
+
+// testFuzzEntryPoint checks that fn accepts all the given seeds, and
+// that it doesn't panic on any truncation or single bit flip of
+// them.
+func testFuzzEntryPoint(
+	t *testing.T, fn func([]byte) int, seeds [][]byte) {
+	check := func(data []byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("Panic on input %x: %v", data, r)
+			}
+		}()
+		fn(data)
+	}
+
+	check(nil)
+	for i, seed := range seeds {
+		require.Equal(t, 1, fn(seed), "seed %d", i)
+		for n := 0; n < len(seed); n++ {
+			check(seed[:n])
+		}
+		for bit := 0; bit < 8*len(seed); bit++ {
+			data := append([]byte(nil), seed...)
+			data[bit/8] ^= 1 << uint(bit%8)
+			check(data)
+		}
+	}
+}
+
+func TestFuzzRootMetadataSigned(t *testing.T) {
+	rmdsSeeds, _ := makeFuzzMDSeeds(t)
+	testFuzzEntryPoint(t, fuzzRootMetadataSigned, rmdsSeeds)
+}
+
+func TestFuzzBareRootMetadata(t *testing.T) {
+	_, brmdSeeds := makeFuzzMDSeeds(t)
+	testFuzzEntryPoint(t, fuzzBareRootMetadata, brmdSeeds)
+}
+
+func TestFuzzBlock(t *testing.T) {
+	testFuzzEntryPoint(t, fuzzBlock, makeFuzzBlockSeeds(t))
+}
+
+func TestFuzzJournalEntry(t *testing.T) {
+	testFuzzEntryPoint(t, fuzzJournalEntry, makeFuzzJournalSeeds(t))
+}
@@ -0,0 +1,227 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// HealthSeverity says how much a HealthFinding matters.
+type HealthSeverity int
+
+const (
+	// HealthOK means the check passed.
+	HealthOK HealthSeverity = iota
+	// HealthWarning means KBFS works, but worse than it should.
+	HealthWarning
+	// HealthError means KBFS, or some part of it, doesn't work.
+	HealthError
+)
+
+func (s HealthSeverity) String() string {
+	switch s {
+	case HealthOK:
+		return "ok"
+	case HealthWarning:
+		return "warning"
+	case HealthError:
+		return "error"
+	}
+	return fmt.Sprintf("HealthSeverity(%d)", int(s))
+}
+
+// HealthFinding is the result of a single health check.
+type HealthFinding struct {
+	// Check names what was checked, e.g. "session".
+	Check    string
+	Severity HealthSeverity
+	// Problem describes what was found.  For passing checks, it
+	// may just describe what was checked.
+	Problem string
+	// Advice, if not empty, says what the user can do about
+	// Problem.
+	Advice string
+}
+
+// HealthCheck checks one aspect of a running KBFS.
+type HealthCheck func(ctx context.Context, config Config) HealthFinding
+
+// CheckHealth runs the standard health checks against config, plus
+// any extra ones (e.g., for the mount's driver), and returns their
+// findings in order.  The checks don't change any state other than
+// temporary files, so it's safe to run them against a live KBFS.
+func CheckHealth(ctx context.Context, config Config,
+	extra ...HealthCheck) []HealthFinding {
+	checks := append([]HealthCheck{
+		checkSessionHealth,
+		checkJournalDirHealth,
+		checkDiskLimiterHealth,
+	}, extra...)
+	findings := make([]HealthFinding, 0, len(checks))
+	for _, check := range checks {
+		findings = append(findings, check(ctx, config))
+	}
+	return findings
+}
+
+// HealthWorstSeverity returns the most severe severity among
+// findings, or HealthOK if there are none.
+func HealthWorstSeverity(findings []HealthFinding) HealthSeverity {
+	worst := HealthOK
+	for _, f := range findings {
+		if f.Severity > worst {
+			worst = f.Severity
+		}
+	}
+	return worst
+}
+
+// checkSessionHealth checks that the service is reachable, and that
+// it has a logged-in user on a provisioned device.
+func checkSessionHealth(ctx context.Context, config Config) HealthFinding {
+	const check = "session"
+	session, err := config.KeybaseService().CurrentSession(ctx, 0)
+	switch errors.Cause(err).(type) {
+	case nil:
+	case NoCurrentSessionError:
+		return HealthFinding{
+			Check:    check,
+			Severity: HealthError,
+			Problem:  "Not logged in",
+			Advice:   "Run `keybase login`",
+		}
+	default:
+		return HealthFinding{
+			Check:    check,
+			Severity: HealthError,
+			Problem:  fmt.Sprintf("Can't reach the Keybase service: %v", err),
+			Advice:   "Make sure the service is running, e.g. with `keybase service`",
+		}
+	}
+	if session.VerifyingKey == (kbfscrypto.VerifyingKey{}) {
+		return HealthFinding{
+			Check:    check,
+			Severity: HealthError,
+			Problem: fmt.Sprintf(
+				"Logged in as %s, but this device has no keys", session.Name),
+			Advice: "Run `keybase device add` to provision this device",
+		}
+	}
+	return HealthFinding{
+		Check:    check,
+		Severity: HealthOK,
+		Problem:  fmt.Sprintf("Logged in as %s", session.Name),
+	}
+}
+
+// checkJournalDirHealth checks that the journal directory can be
+// written to, by creating and removing a temporary directory in it.
+func checkJournalDirHealth(ctx context.Context, config Config) HealthFinding {
+	const check = "journal directory"
+	if config.StorageRoot() == "" {
+		return HealthFinding{
+			Check:    check,
+			Severity: HealthOK,
+			Problem:  "No storage root, so no journal",
+		}
+	}
+	dir := filepath.Join(config.StorageRoot(), "kbfs_journal")
+	fail := func(err error) HealthFinding {
+		return HealthFinding{
+			Check:    check,
+			Severity: HealthError,
+			Problem:  fmt.Sprintf("%s isn't writable: %v", dir, err),
+			Advice:   "Fix the permissions of the directory, or free up space on its disk",
+		}
+	}
+	err := ioutil.MkdirAll(dir, 0700)
+	if err != nil {
+		return fail(err)
+	}
+	tempDir, err := ioutil.TempDir(dir, "health_check")
+	if err != nil {
+		return fail(err)
+	}
+	err = ioutil.RemoveAll(tempDir)
+	if err != nil {
+		return fail(err)
+	}
+	return HealthFinding{
+		Check:    check,
+		Severity: HealthOK,
+		Problem:  fmt.Sprintf("%s is writable", dir),
+	}
+}
+
+// checkDiskLimiterHealth checks how close the journal is to the
+// thresholds at which the disk limiter starts delaying, and then
+// refusing, writes.
+func checkDiskLimiterHealth(
+	ctx context.Context, config Config) HealthFinding {
+	const check = "disk space"
+	limiter := config.DiskLimiter()
+	if limiter == nil {
+		return HealthFinding{
+			Check:    check,
+			Severity: HealthOK,
+			Problem:  "No disk limiter in use",
+		}
+	}
+	status, ok := limiter.getStatus().(backpressureDiskLimiterStatus)
+	if !ok {
+		return HealthFinding{
+			Check:    check,
+			Severity: HealthOK,
+			Problem:  "Disk limiter has no thresholds",
+		}
+	}
+
+	const advice = "Free up space on the disk holding " +
+		"the KBFS storage root, or wait for the journal to flush"
+	trackers := []struct {
+		name   string
+		status backpressureTrackerStatus
+	}{
+		{"bytes", status.ByteTrackerStatus},
+		{"files", status.FileTrackerStatus},
+	}
+	for _, t := range trackers {
+		if t.status.UsedFrac >= t.status.MaxThreshold {
+			return HealthFinding{
+				Check:    check,
+				Severity: HealthError,
+				Problem: fmt.Sprintf(
+					"The journal uses %.0f%% of the available %s; "+
+						"writes are blocked", 100*t.status.UsedFrac, t.name),
+				Advice: advice,
+			}
+		}
+	}
+	for _, t := range trackers {
+		if t.status.UsedFrac >= t.status.MinThreshold {
+			return HealthFinding{
+				Check:    check,
+				Severity: HealthWarning,
+				Problem: fmt.Sprintf(
+					"The journal uses %.0f%% of the available %s; "+
+						"writes are delayed by %.1fs",
+					100*t.status.UsedFrac, t.name, status.CurrentDelaySec),
+				Advice: advice,
+			}
+		}
+	}
+	return HealthFinding{
+		Check:    check,
+		Severity: HealthOK,
+		Problem: fmt.Sprintf("%d bytes and %d files free on disk",
+			status.ByteTrackerStatus.Free, status.FileTrackerStatus.Free),
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestCheckHealth(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	ctx := context.Background()
+
+	storageRoot, err := ioutil.TempDir(os.TempDir(), "health_check")
+	require.NoError(t, err)
+	defer os.RemoveAll(storageRoot)
+	config.storageRoot = storageRoot
+
+	driverCheck := func(context.Context, Config) HealthFinding {
+		return HealthFinding{Check: "driver", Severity: HealthWarning}
+	}
+	findings := CheckHealth(ctx, config, driverCheck)
+	require.Len(t, findings, 4)
+	for _, f := range findings[:3] {
+		require.Equal(t, HealthOK, f.Severity, "%+v", f)
+	}
+	require.Equal(t, "driver", findings[3].Check)
+	require.Equal(t, HealthWarning, HealthWorstSeverity(findings))
+
+	// The check leaves nothing behind in the journal directory.
+	fileInfos, err := ioutil.ReadDir(
+		filepath.Join(storageRoot, "kbfs_journal"))
+	require.NoError(t, err)
+	require.Len(t, fileInfos, 0)
+}
+
+func TestCheckHealthErrors(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	ctx := context.Background()
+
+	storageRoot, err := ioutil.TempDir(os.TempDir(), "health_check")
+	require.NoError(t, err)
+	defer os.RemoveAll(storageRoot)
+	config.storageRoot = storageRoot
+	// A file in the way of the journal directory.
+	err = ioutil.WriteFile(
+		filepath.Join(storageRoot, "kbfs_journal"), nil, 0600)
+	require.NoError(t, err)
+	f := checkJournalDirHealth(ctx, config)
+	require.Equal(t, HealthError, f.Severity)
+	require.NotEmpty(t, f.Advice)
+
+	daemon := config.KeybaseService().(*KeybaseDaemonLocal)
+	session, err := daemon.CurrentSession(ctx, 0)
+	require.NoError(t, err)
+	daemon.setCurrentUID(keybase1.UID(""))
+	f = checkSessionHealth(ctx, config)
+	require.Equal(t, HealthError, f.Severity)
+	require.NotEmpty(t, f.Advice)
+	daemon.setCurrentUID(session.UID)
+}
+
+func TestCheckDiskLimiterHealth(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	ctx := context.Background()
+
+	log := logger.NewTestLogger(t)
+	params := makeTestBackpressureDiskLimiterParams()
+	bdl, err := newBackpressureDiskLimiter(log, params)
+	require.NoError(t, err)
+	config.diskLimiter = bdl
+	require.Equal(t, HealthOK, checkDiskLimiterHealth(ctx, config).Severity)
+
+	// (byteLimit=400) * (journalFrac=0.25) = 100 bytes for the
+	// journal; using 50 is past minThreshold=0.1.
+	_, _, err = bdl.beforeBlockPut(ctx, 50, 1)
+	require.NoError(t, err)
+	bdl.afterBlockPut(ctx, 50, 1, true)
+	require.Equal(t, HealthWarning,
+		checkDiskLimiterHealth(ctx, config).Severity)
+
+	// 95 is past maxThreshold=0.9.
+	_, _, err = bdl.beforeBlockPut(ctx, 45, 1)
+	require.NoError(t, err)
+	bdl.afterBlockPut(ctx, 45, 1, true)
+	require.Equal(t, HealthError, checkDiskLimiterHealth(ctx, config).Severity)
+}
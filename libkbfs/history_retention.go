@@ -0,0 +1,114 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-codec/codec"
+)
+
+// HistoryRetentionPolicy says how much of a TLF's history is kept
+// around once it's no longer referenced by the head.
+type HistoryRetentionPolicy int
+
+const (
+	// HistoryRetentionDefault keeps unreferenced history for
+	// Config.QuotaReclamationMinUnrefAge().
+	HistoryRetentionDefault HistoryRetentionPolicy = iota
+	// HistoryRetentionKeepAll keeps all history forever; quota
+	// reclamation never runs for the TLF.
+	HistoryRetentionKeepAll
+	// HistoryRetentionKeepDays keeps unreferenced history for a
+	// given number of days.
+	HistoryRetentionKeepDays
+	// HistoryRetentionKeepLatest keeps only the latest revision;
+	// anything unreferenced is reclaimed at the next opportunity.
+	HistoryRetentionKeepLatest
+)
+
+// HistoryRetention is a TLF's history retention setting, as stored in
+// its private metadata.  It can only be changed by writers.
+type HistoryRetention struct {
+	Policy HistoryRetentionPolicy `codec:"p"`
+	// Days is only used with HistoryRetentionKeepDays.
+	Days int `codec:"d,omitempty"`
+
+	codec.UnknownFieldSetHandler
+}
+
+func (r HistoryRetention) String() string {
+	switch r.Policy {
+	case HistoryRetentionDefault:
+		return "default"
+	case HistoryRetentionKeepAll:
+		return "all"
+	case HistoryRetentionKeepDays:
+		return fmt.Sprintf("%dd", r.Days)
+	case HistoryRetentionKeepLatest:
+		return "latest"
+	default:
+		return fmt.Sprintf("HistoryRetentionPolicy(%d)", r.Policy)
+	}
+}
+
+func (r HistoryRetention) checkValid() error {
+	switch r.Policy {
+	case HistoryRetentionDefault, HistoryRetentionKeepAll,
+		HistoryRetentionKeepLatest:
+		if r.Days != 0 {
+			return fmt.Errorf("Days set for history retention %s", r)
+		}
+		return nil
+	case HistoryRetentionKeepDays:
+		if r.Days <= 0 {
+			return fmt.Errorf("Invalid history retention %s", r)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown history retention policy %d", r.Policy)
+	}
+}
+
+// ParseHistoryRetention parses a history retention setting: "all",
+// "latest", "default", or a number of days like "30d".
+func ParseHistoryRetention(s string) (HistoryRetention, error) {
+	switch strings.ToLower(s) {
+	case "default", "":
+		return HistoryRetention{Policy: HistoryRetentionDefault}, nil
+	case "all":
+		return HistoryRetention{Policy: HistoryRetentionKeepAll}, nil
+	case "latest":
+		return HistoryRetention{Policy: HistoryRetentionKeepLatest}, nil
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(s), "d"))
+	if err != nil || days <= 0 {
+		return HistoryRetention{}, fmt.Errorf(
+			"Unknown history retention %q; must be all, latest, "+
+				"default, or a number of days like 30d", s)
+	}
+	return HistoryRetention{Policy: HistoryRetentionKeepDays, Days: days},
+		nil
+}
+
+// minUnrefAge returns how long unreferenced blocks must be kept under
+// this setting, given the configured default.  keepAll is true if
+// they must never be reclaimed.
+func (r HistoryRetention) minUnrefAge(defaultAge time.Duration) (
+	age time.Duration, keepAll bool) {
+	switch r.Policy {
+	case HistoryRetentionKeepAll:
+		return 0, true
+	case HistoryRetentionKeepDays:
+		return time.Duration(r.Days) * 24 * time.Hour, false
+	case HistoryRetentionKeepLatest:
+		return 0, false
+	default:
+		return defaultAge, false
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHistoryRetention(t *testing.T) {
+	for _, r := range []HistoryRetention{
+		{Policy: HistoryRetentionDefault},
+		{Policy: HistoryRetentionKeepAll},
+		{Policy: HistoryRetentionKeepDays, Days: 30},
+		{Policy: HistoryRetentionKeepLatest},
+	} {
+		parsed, err := ParseHistoryRetention(r.String())
+		require.NoError(t, err)
+		require.Equal(t, r, parsed)
+		require.NoError(t, parsed.checkValid())
+	}
+
+	parsed, err := ParseHistoryRetention("7")
+	require.NoError(t, err)
+	require.Equal(t,
+		HistoryRetention{Policy: HistoryRetentionKeepDays, Days: 7}, parsed)
+
+	for _, s := range []string{"forever", "0d", "-1d"} {
+		_, err := ParseHistoryRetention(s)
+		require.Error(t, err, s)
+	}
+}
+
+// Test that the history retention setting of a TLF decides whether
+// and when quota reclamation deletes its unreferenced blocks.
+func TestHistoryRetentionQuotaReclamation(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock := newTestClockNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+
+	retention, err := kbfsOps.GetHistoryRetention(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, HistoryRetentionDefault, retention.Policy)
+
+	err = kbfsOps.SetHistoryRetention(ctx, fb,
+		HistoryRetention{Policy: HistoryRetentionKeepDays})
+	require.Error(t, err)
+
+	// Keeping everything means nothing gets reclaimed, no matter how
+	// old it is.
+	keepAll := HistoryRetention{Policy: HistoryRetentionKeepAll}
+	err = kbfsOps.SetHistoryRetention(ctx, fb, keepAll)
+	require.NoError(t, err)
+	retention, err = kbfsOps.GetHistoryRetention(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, keepAll, retention)
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, "all", status.HistoryRetention)
+
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	clock.Add(2 * config.QuotaReclamationMinUnrefAge())
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	err = kbfsOps.SyncFromServerForTesting(ctx, fb)
+	require.NoError(t, err)
+
+	bserverLocal, ok := config.BlockServer().(blockServerLocal)
+	require.True(t, ok)
+	preQR1Blocks, err := bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+	require.NoError(t, err)
+
+	err = ops.fbm.waitForQuotaReclamations(ctx)
+	require.NoError(t, err)
+	ops.fbm.forceQuotaReclamation()
+	err = ops.fbm.waitForQuotaReclamations(ctx)
+	require.NoError(t, err)
+
+	postQR1Blocks, err := bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+	require.NoError(t, err)
+	require.Equal(t, preQR1Blocks, postQR1Blocks)
+
+	// Keeping only the latest revision reclaims the old blocks right
+	// away, without waiting for the default unref age.
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "c")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveDir(ctx, rootNode, "c")
+	require.NoError(t, err)
+	clock.Add(time.Second)
+	err = kbfsOps.SetHistoryRetention(ctx, fb,
+		HistoryRetention{Policy: HistoryRetentionKeepLatest})
+	require.NoError(t, err)
+	err = ops.fbm.waitForQuotaReclamations(ctx)
+	require.NoError(t, err)
+
+	postQR2Blocks, err := bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+	require.NoError(t, err)
+	if pre, post := totalBlockRefs(postQR1Blocks),
+		totalBlockRefs(postQR2Blocks); post >= pre {
+		t.Errorf("Blocks didn't shrink after reclamation: pre: %d, post %d",
+			pre, post)
+	}
+}
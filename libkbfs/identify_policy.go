@@ -0,0 +1,146 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IdentifyMode says when the users of a TLF are identified, which
+// can pop up tracker windows and fail operations on broken proofs.
+type IdentifyMode int
+
+const (
+	// IdentifyOnFirstAccess means the users are identified the first
+	// time the TLF is accessed, and again once the identify is older
+	// than the TLF valid duration.  Operations wait for the identify,
+	// and fail if it does.
+	IdentifyOnFirstAccess IdentifyMode = iota
+	// IdentifyAlways means the users are identified by every
+	// operation that gets the TLF's metadata.  That's the strictest
+	// mode, but also the slowest.
+	IdentifyAlways
+	// IdentifyInBackground means the first access to the TLF starts
+	// identifying its users, without waiting for the result.
+	// Failures are logged and reported as errors, but don't fail any
+	// operations, and aren't retried until the identify would have
+	// expired in IdentifyOnFirstAccess mode.
+	IdentifyInBackground
+)
+
+func (m IdentifyMode) String() string {
+	switch m {
+	case IdentifyOnFirstAccess:
+		return "first"
+	case IdentifyAlways:
+		return "always"
+	case IdentifyInBackground:
+		return "background"
+	}
+	return fmt.Sprintf("IdentifyMode(%d)", int(m))
+}
+
+// ParseIdentifyMode parses the String() form of an IdentifyMode.
+func ParseIdentifyMode(s string) (IdentifyMode, error) {
+	switch strings.ToLower(s) {
+	case "first", "":
+		return IdentifyOnFirstAccess, nil
+	case "always":
+		return IdentifyAlways, nil
+	case "background":
+		return IdentifyInBackground, nil
+	}
+	return IdentifyOnFirstAccess, fmt.Errorf(
+		"Unknown identify mode %q; must be first, always or background", s)
+}
+
+// IdentifyPolicy is the IdentifyMode for each class of TLF.  The
+// zero value identifies the users of every TLF on first access.
+// Callers that ask for identify results through their context, like
+// the GUI, always get a fresh identify regardless.
+type IdentifyPolicy struct {
+	Private IdentifyMode
+	Public  IdentifyMode
+}
+
+// ModeFor returns the mode for a private or a public TLF.
+func (p IdentifyPolicy) ModeFor(public bool) IdentifyMode {
+	if public {
+		return p.Public
+	}
+	return p.Private
+}
+
+// String returns the policy in the form parsed by ParseIdentifyPolicy.
+func (p IdentifyPolicy) String() string {
+	if p.Private == p.Public {
+		return p.Private.String()
+	}
+	return fmt.Sprintf("private=%s,public=%s", p.Private, p.Public)
+}
+
+// ParseIdentifyPolicy parses a policy that's either a single
+// IdentifyMode for all TLFs, or a comma-separated list of
+// class=mode settings for the private and public classes, e.g.
+// "private=first,public=background".  Classes that aren't listed
+// identify on first access.
+func ParseIdentifyPolicy(s string) (IdentifyPolicy, error) {
+	if !strings.Contains(s, "=") {
+		m, err := ParseIdentifyMode(s)
+		if err != nil {
+			return IdentifyPolicy{}, err
+		}
+		return IdentifyPolicy{Private: m, Public: m}, nil
+	}
+
+	var p IdentifyPolicy
+	for _, setting := range strings.Split(s, ",") {
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return IdentifyPolicy{}, fmt.Errorf(
+				"Invalid identify policy setting %q", setting)
+		}
+		m, err := ParseIdentifyMode(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return IdentifyPolicy{}, err
+		}
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "private":
+			p.Private = m
+		case "public":
+			p.Public = m
+		default:
+			return IdentifyPolicy{}, fmt.Errorf(
+				"Unknown folder class %q; must be private or public",
+				parts[0])
+		}
+	}
+	return p, nil
+}
+
+// identifyPolicyFlag is for specifying an IdentifyPolicy with the
+// flag package.
+type identifyPolicyFlag struct {
+	p *IdentifyPolicy
+}
+
+// String for flag interface.
+func (f identifyPolicyFlag) String() string {
+	if f.p == nil {
+		return IdentifyPolicy{}.String()
+	}
+	return f.p.String()
+}
+
+// Set for flag interface.
+func (f identifyPolicyFlag) Set(raw string) error {
+	p, err := ParseIdentifyPolicy(raw)
+	if err != nil {
+		return err
+	}
+	*f.p = p
+	return nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIdentifyPolicy(t *testing.T) {
+	for _, p := range []IdentifyPolicy{
+		{},
+		{Private: IdentifyAlways, Public: IdentifyAlways},
+		{Private: IdentifyOnFirstAccess, Public: IdentifyInBackground},
+		{Private: IdentifyAlways, Public: IdentifyOnFirstAccess},
+	} {
+		parsed, err := ParseIdentifyPolicy(p.String())
+		require.NoError(t, err)
+		require.Equal(t, p, parsed)
+	}
+
+	p, err := ParseIdentifyPolicy("public=background")
+	require.NoError(t, err)
+	require.Equal(t, IdentifyPolicy{Public: IdentifyInBackground}, p)
+	require.Equal(t, IdentifyInBackground, p.ModeFor(true))
+	require.Equal(t, IdentifyOnFirstAccess, p.ModeFor(false))
+
+	for _, s := range []string{"bogus", "private=bogus", "team=first",
+		"private", "private=first,public"} {
+		_, err := ParseIdentifyPolicy(s)
+		require.Error(t, err, s)
+	}
+}
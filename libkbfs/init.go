@@ -6,7 +6,6 @@ package libkbfs
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -18,6 +17,7 @@ import (
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/pkg/errors"
 	gocontext "golang.org/x/net/context"
 )
 
@@ -42,6 +42,21 @@ type InitParams struct {
 	// "dir:/path/to/dir" for an on-disk test server.
 	BServerAddr string
 
+	// Proxy is how connections to the KBFS servers are made,
+	// unless overridden by MDServerProxy or BServerProxy: directly,
+	// or through an HTTP or SOCKS5 proxy.
+	Proxy ProxySpec
+	// MDServerProxy, unless it's the default, is how connections
+	// to the metadata server are made.
+	MDServerProxy ProxySpec
+	// BServerProxy, unless it's the default, is how connections to
+	// the block server are made.
+	BServerProxy ProxySpec
+
+	// BandwidthPolicy is the caps that block server traffic is
+	// held to on each type of network.
+	BandwidthPolicy BandwidthPolicy
+
 	// If non-empty the host:port of the metadata server. If
 	// empty, a default value is used depending on the run mode.
 	// Can also be "memory" for an in-memory test server or
@@ -64,6 +79,35 @@ type InitParams struct {
 	// before marked for lazy revalidation.
 	TLFValidDuration time.Duration
 
+	// TLFIdleTimeout is how long a TLF can go unaccessed before
+	// its in-memory state is evicted.  Zero disables eviction.
+	TLFIdleTimeout time.Duration
+
+	// FavoriteHeadFetchParallelism is how many favorite TLFs have
+	// their MD heads fetched at once in the background at startup.
+	// Zero disables the startup fetch.
+	FavoriteHeadFetchParallelism int
+
+	// WriteCoalescingBytes, if non-zero, is the size of the per-file
+	// buffer used to merge small sequential writes.
+	WriteCoalescingBytes int64
+
+	// MetadataOpDelay, if non-zero, is how long setattr
+	// operations may wait to be written together.
+	MetadataOpDelay time.Duration
+
+	// FsyncDurability is what an fsync guarantees by default:
+	// journal-persisted, flushed to the server, or nothing.
+	FsyncDurability FsyncDurability
+
+	// MerkleCheckMode is whether merged MD heads are checked
+	// against the MD server's Merkle tree: off, warn, or strict.
+	MerkleCheckMode MerkleCheckMode
+
+	// IdentifyPolicy is when the users of private and public TLFs
+	// are identified.
+	IdentifyPolicy IdentifyPolicy
+
 	// MetadataVersion is the default version of metadata to use
 	// when creating new metadata.
 	MetadataVersion MetadataVer
@@ -90,12 +134,48 @@ type InitParams struct {
 	// StorageRoot data directory.
 	EnableDiskCache bool
 
+	// DiskCacheBackend is the key-value store the disk cache is
+	// kept in: leveldb or badger.
+	DiskCacheBackend DiskCacheBackend
+
+	// EnablePreviews toggles whether previews of files, like image
+	// thumbnails, can be generated and cached in the StorageRoot
+	// data directory.
+	EnablePreviews bool
+
+	// EnableReadSpill toggles whether the blocks of reads too big
+	// for the in-memory block cache are spilled, encrypted, to a
+	// temporary area in the StorageRoot data directory.
+	EnableReadSpill bool
+
 	// StorageRoot, if non-empty, points to a local directory to put its local
 	// databases for things like the journal or disk cache.
 	StorageRoot string
 
+	// TrackCleanShutdown, if true, keeps a marker in StorageRoot
+	// while KBFS runs, so that the next startup can report on an
+	// unclean shutdown.  Tools that run alongside the main KBFS
+	// process must leave it off.
+	TrackCleanShutdown bool
+
+	// ServeSettings, if true, applies the runtime settings saved in
+	// StorageRoot, and serves them on a socket there so that they
+	// can be changed without a restart.  Tools that run alongside
+	// the main KBFS process must leave it off.
+	ServeSettings bool
+
 	// Mode describes how KBFS should initialize itself.
 	Mode string
+
+	// Faults, if non-empty, makes the block and MD servers fail
+	// some calls on purpose, as described by ParseFaultConfig.
+	// Not allowed in production.
+	Faults string
+
+	// TLFUsageByProcess, if true, breaks down the per-TLF server
+	// usage in the status by the name of the process that caused
+	// it, for mounts that support it.
+	TLFUsageByProcess bool
 }
 
 // defaultBServer returns the default value for the -bserver flag.
@@ -147,11 +227,17 @@ func defaultLogPath(ctx Context) string {
 // DefaultInitParams returns default init params
 func DefaultInitParams(ctx Context) InitParams {
 	return InitParams{
-		Debug:            BoolForString(os.Getenv("KBFS_DEBUG")),
-		BServerAddr:      defaultBServer(ctx),
-		MDServerAddr:     defaultMDServer(ctx),
-		TLFValidDuration: tlfValidDurationDefault,
-		MetadataVersion:  defaultMetadataVersion(ctx),
+		Debug:                        BoolForString(os.Getenv("KBFS_DEBUG")),
+		BServerAddr:                  defaultBServer(ctx),
+		MDServerAddr:                 defaultMDServer(ctx),
+		BandwidthPolicy:              DefaultBandwidthPolicy(),
+		TLFValidDuration:             tlfValidDurationDefault,
+		TLFIdleTimeout:               tlfIdleTimeoutDefault,
+		FsyncDurability:              FsyncDurabilityJournal,
+		MetadataVersion:              defaultMetadataVersion(ctx),
+		FavoriteHeadFetchParallelism: defaultFavoriteHeadFetchParallelism,
+		TrackCleanShutdown:           true,
+		ServeSettings:                true,
 		LogFileConfig: logger.LogFileConfig{
 			MaxAge:       30 * 24 * time.Hour,
 			MaxSize:      128 * 1024 * 1024,
@@ -160,6 +246,9 @@ func DefaultInitParams(ctx Context) InitParams {
 		TLFJournalBackgroundWorkStatus: TLFJournalBackgroundWorkEnabled,
 		StorageRoot:                    ctx.GetDataDir(),
 		Mode:                           InitDefaultString,
+		Faults:                         os.Getenv("KBFS_FAULTS"),
+		TLFUsageByProcess: BoolForString(
+			os.Getenv("KBFS_TLF_USAGE_BY_PROCESS")),
 	}
 }
 
@@ -174,6 +263,20 @@ func AddFlags(flags *flag.FlagSet, ctx Context) *InitParams {
 
 	flags.StringVar(&params.BServerAddr, "bserver", defaultParams.BServerAddr,
 		"host:port of the block server, 'memory', or 'dir:/path/to/dir'")
+	flags.Var(proxySpecFlag{&params.Proxy}, "proxy",
+		"how to reach the KBFS servers: direct, "+
+			"http://[user:password@]host:port to tunnel through an HTTP "+
+			"proxy, or socks5://[user:password@]host:port.")
+	flags.Var(proxySpecFlag{&params.MDServerProxy}, "mdserver-proxy",
+		"how to reach the metadata server, if not as -proxy says.")
+	flags.Var(proxySpecFlag{&params.BServerProxy}, "bserver-proxy",
+		"how to reach the block server, if not as -proxy says.")
+	params.BandwidthPolicy = defaultParams.BandwidthPolicy
+	flags.Var(bandwidthPolicyFlag{&params.BandwidthPolicy},
+		"bandwidth-policy", "caps on block server traffic for each "+
+			"type of network, as network=upload/download entries in "+
+			"bytes per second with an optional K or M suffix, e.g. "+
+			"tethered=256K/1M,wifi=0/0, where 0 is uncapped; or none.")
 	flags.StringVar(&params.MDServerAddr, "mdserver",
 		defaultParams.MDServerAddr,
 		"host:port of the metadata server, 'memory', or 'dir:/path/to/dir'")
@@ -186,6 +289,38 @@ func AddFlags(flags *flag.FlagSet, ctx Context) *InitParams {
 	flags.DurationVar(&params.TLFValidDuration, "tlf-valid",
 		defaultParams.TLFValidDuration,
 		"time tlfs are valid before redoing identification")
+	flags.DurationVar(&params.TLFIdleTimeout, "tlf-idle-timeout",
+		defaultParams.TLFIdleTimeout,
+		"time a tlf can go unaccessed before its state is evicted "+
+			"from memory; 0 disables eviction")
+	flags.IntVar(&params.FavoriteHeadFetchParallelism,
+		"favorite-head-fetch-parallelism",
+		defaultParams.FavoriteHeadFetchParallelism,
+		"number of favorite tlfs whose heads are fetched at once in the "+
+			"background at startup; 0 disables the fetch")
+	flags.Var(SizeFlag{&params.WriteCoalescingBytes}, "write-coalescing-size",
+		"buffer sequential writes smaller than this many bytes and "+
+			"merge them before dirtying blocks; 0 disables coalescing")
+	flags.DurationVar(&params.MetadataOpDelay, "md-op-delay",
+		defaultParams.MetadataOpDelay,
+		"write mtime and exec bit changes made within this long of each "+
+			"other, to files in the same directory, as one revision; "+
+			"0 writes each one right away")
+	params.FsyncDurability = defaultParams.FsyncDurability
+	flags.Var(fsyncDurabilityFlag{&params.FsyncDurability}, "fsync-durability",
+		"what fsync guarantees: journal (persisted locally), "+
+			"server (flushed to the server), or none")
+	params.MerkleCheckMode = defaultParams.MerkleCheckMode
+	flags.Var(merkleCheckModeFlag{&params.MerkleCheckMode}, "merkle-check",
+		"check folder heads fetched from the MD server against its "+
+			"Merkle tree: off, warn (log failures), or strict "+
+			"(reject heads that fail)")
+	params.IdentifyPolicy = defaultParams.IdentifyPolicy
+	flags.Var(identifyPolicyFlag{&params.IdentifyPolicy}, "identify",
+		"when to identify the users of folders: first (on first access), "+
+			"always (on every operation), or background (without "+
+			"waiting); or per folder class, e.g. "+
+			"private=first,public=background")
 	flags.BoolVar(&params.LogToFile, "log-to-file", false,
 		fmt.Sprintf("Log to default file: %s", defaultLogPath(ctx)))
 	flags.StringVar(&params.LogFileConfig.Path, "log-file", "",
@@ -210,6 +345,18 @@ func AddFlags(flags *flag.FlagSet, ctx Context) *InitParams {
 	flags.BoolVar(&params.EnableDiskCache, "enable-disk-cache", false,
 		"(EXPERIMENTAL) Enables the disk cache for the directory specified "+
 			"by -storage-root.")
+	params.DiskCacheBackend = defaultParams.DiskCacheBackend
+	flags.Var(diskCacheBackendFlag{&params.DiskCacheBackend},
+		"disk-cache-backend", "what the disk cache is stored in: "+
+			"leveldb or badger.  Changing it empties the cache.")
+	flags.BoolVar(&params.EnablePreviews, "enable-previews", false,
+		"Enables generating previews of files, like image thumbnails, and "+
+			"caching them locally in the directory specified by "+
+			"-storage-root.")
+	flags.BoolVar(&params.EnableReadSpill, "enable-read-spill", false,
+		"Spills the blocks of reads too big for the memory cache to "+
+			"encrypted temporary files in the directory specified by "+
+			"-storage-root, for machines with little memory.")
 	flags.BoolVar(&params.EnableJournal, "enable-journal", true, "Enables "+
 		"write journaling for TLFs.")
 
@@ -225,6 +372,14 @@ func AddFlags(flags *flag.FlagSet, ctx Context) *InitParams {
 		fmt.Sprintf("Overall initialization mode for KBFS, indicating how "+
 			"heavy-weight it can be (%s or %s)", InitDefaultString,
 			InitMinimalString))
+	flags.StringVar(&params.Faults, "faults", defaultParams.Faults,
+		"Make the block and MD servers fail some calls on purpose, "+
+			"e.g. throttle=0.05,timeout=0.01,fail=0.01,partial=0.01,"+
+			"corrupt=0.001,seed=1 (not allowed in production)")
+	flags.BoolVar(&params.TLFUsageByProcess, "tlf-usage-by-process",
+		defaultParams.TLFUsageByProcess,
+		"Break down each folder's server usage in its status by the "+
+			"process that caused it")
 
 	return &params
 }
@@ -234,6 +389,8 @@ func AddFlags(flags *flag.FlagSet, ctx Context) *InitParams {
 func GetRemoteUsageString() string {
 	return `    [-debug]
     [-bserver=host:port] [-mdserver=host:port]
+    [-proxy=(direct | http://host:port | socks5://host:port)]
+    [-mdserver-proxy=...] [-bserver-proxy=...]
     [-log-to-file] [-log-file=path/to/file] [-clean-bcache-cap=0]`
 }
 
@@ -522,6 +679,20 @@ func doInit(ctx Context, params InitParams, keybaseServiceCn KeybaseServiceCn,
 
 	config.SetMetadataVersion(MetadataVer(params.MetadataVersion))
 	config.SetTLFValidDuration(params.TLFValidDuration)
+	config.SetTLFIdleTimeout(params.TLFIdleTimeout)
+	config.SetWriteCoalescingBytes(params.WriteCoalescingBytes)
+	config.SetMetadataOpDelay(params.MetadataOpDelay)
+	config.SetFsyncDurability(params.FsyncDurability)
+	config.SetMerkleCheckMode(params.MerkleCheckMode)
+	config.SetIdentifyPolicy(params.IdentifyPolicy)
+	config.SetDiskCacheBackend(params.DiskCacheBackend)
+	proxies := MakeServerProxies(
+		params.Proxy, params.MDServerProxy, params.BServerProxy)
+	if proxies != (ServerProxies{}) {
+		log.Debug("Server proxies: mdserver=%s bserver=%s",
+			proxies.MDServer, proxies.BServer)
+	}
+	config.SetServerProxies(proxies)
 
 	kbfsOps := NewKBFSOpsStandard(config)
 	config.SetKBFSOps(kbfsOps)
@@ -564,11 +735,24 @@ func doInit(ctx Context, params InitParams, keybaseServiceCn KeybaseServiceCn,
 
 	config.SetCrypto(crypto)
 
+	faults, err := ParseFaultConfig(params.Faults)
+	if err != nil {
+		return nil, err
+	}
+	if faults.IsEnabled() && ctx.GetRunMode() == libkb.ProductionRunMode {
+		return nil, errors.New("Fault injection is not allowed in production")
+	}
+
 	mdServer, err := makeMDServer(
 		config, params.MDServerAddr, ctx.NewRPCLogFactory(), log)
 	if err != nil {
 		return nil, fmt.Errorf("problem creating MD server: %+v", err)
 	}
+	if faults.IsEnabled() {
+		log.Warning("Injecting MD server faults: %s", faults)
+		mdServer = NewMDServerFaulty(
+			config.MakeLogger("MDF"), mdServer, faults)
+	}
 	config.SetMDServer(mdServer)
 
 	// note: the mdserver is the keyserver at the moment.
@@ -577,6 +761,14 @@ func doInit(ctx Context, params InitParams, keybaseServiceCn KeybaseServiceCn,
 		return nil, fmt.Errorf("problem creating key server: %+v", err)
 	}
 
+	// Wrap the MD server only after the key server has been made
+	// from it.  Local servers don't use any bandwidth, and need to
+	// stay unwrapped for the shutdown checks.
+	config.SetTLFUsageByProcess(params.TLFUsageByProcess)
+	if _, ok := mdServer.(mdServerLocal); !ok {
+		config.SetMDServer(newMDServerAccounted(mdServer, kbfsOps.usage))
+	}
+
 	if registry := config.MetricsRegistry(); registry != nil {
 		keyServer = NewKeyServerMeasured(keyServer, registry)
 	}
@@ -589,9 +781,20 @@ func doInit(ctx Context, params InitParams, keybaseServiceCn KeybaseServiceCn,
 		return nil, fmt.Errorf("cannot open block database: %+v", err)
 	}
 
+	if faults.IsEnabled() {
+		log.Warning("Injecting block server faults: %s", faults)
+		bserv = NewBlockServerFaulty(config.MakeLogger("BSF"), bserv, faults)
+	}
+
 	if registry := config.MetricsRegistry(); registry != nil {
 		bserv = NewBlockServerMeasured(bserv, registry)
 	}
+	if params.BandwidthPolicy != nil {
+		kbfsOps.bandwidth.setPolicy(
+			context.Background(), params.BandwidthPolicy)
+	}
+	bserv = newBlockServerBandwidthLimited(bserv, kbfsOps.bandwidth)
+	bserv = newBlockServerAccounted(bserv, kbfsOps.usage)
 
 	config.SetBlockServer(bserv)
 
@@ -613,15 +816,101 @@ func doInit(ctx Context, params InitParams, keybaseServiceCn KeybaseServiceCn,
 	}
 	session, err := k.GetCurrentSession(gocontext.TODO())
 	if params.EnableDiskCache || (err == nil && adminFeatureList[session.UID]) {
-		dbc, err := newDiskBlockCacheStandard(config,
-			diskBlockCacheRootFromStorageRoot(params.StorageRoot))
-		if err != nil {
+		dbc, err := newDiskBlockCachePartitioned(config, params.StorageRoot)
+		switch errors.Cause(err).(type) {
+		case nil:
+			config.SetDiskBlockCache(dbc)
+			log.Debug("Disk cache enabled")
+		case DiskCacheLockedError:
+			// Another instance owns the cache; run without one
+			// rather than fighting over it.
+			log.Warning("Disabling disk cache: %v", err)
+		default:
 			log.Warning("Could not initialize disk cache: %+v", err)
 			// TODO: Make this error less fatal later.
 			return nil, err
 		}
-		config.SetDiskBlockCache(dbc)
-		log.Debug("Disk cache enabled")
+
+		dmc, err := newDiskMDCacheStandard(config,
+			diskMDCacheRootFromStorageRoot(params.StorageRoot))
+		if err == nil {
+			config.SetDiskMDCache(dmc)
+			log.Debug("Disk MD cache enabled")
+		} else {
+			// The MD cache is only an optimization for startup,
+			// so just carry on without it.
+			log.Warning("Disabling disk MD cache: %+v", err)
+		}
+	}
+
+	if params.EnablePreviews && params.StorageRoot != "" {
+		dpc, err := newDiskPreviewCacheStandard(config,
+			diskPreviewCacheRootFromStorageRoot(params.StorageRoot))
+		if err == nil {
+			config.SetDiskPreviewCache(dpc)
+			log.Debug("Disk preview cache enabled")
+		} else {
+			// Previews are only a convenience, so just carry on
+			// without them.
+			log.Warning("Disabling disk preview cache: %+v", err)
+		}
+	}
+
+	if params.EnableReadSpill && params.StorageRoot != "" {
+		bsc, err := newBlockSpillCacheStandard(config,
+			blockSpillCacheRootFromStorageRoot(params.StorageRoot))
+		if err == nil {
+			config.SetBlockSpillCache(bsc)
+			log.Debug("Block spill cache enabled")
+		} else {
+			// Big reads still work without it, just with more
+			// memory.
+			log.Warning("Disabling block spill cache: %+v", err)
+		}
+	}
+
+	if params.TrackCleanShutdown && params.StorageRoot != "" &&
+		config.Mode() != InitMinimal {
+		jServer, _ := GetJournalServer(config)
+		rt := newRecoveryTracker(
+			config.MakeLogger("REC"), params.StorageRoot)
+		startErr := rt.start(
+			context.Background(), jServer, config.Clock().Now())
+		if startErr != nil {
+			log.Warning("Could not check for an unclean shutdown: %+v",
+				startErr)
+		} else {
+			kbfsOps.setRecoveryTracker(rt)
+		}
+	}
+
+	if params.ServeSettings && params.StorageRoot != "" {
+		s := newSettings(config, kbfsOps, params.StorageRoot)
+		loadErr := s.load(context.Background())
+		if loadErr != nil {
+			log.Warning("Could not load the saved settings: %+v", loadErr)
+		}
+		ss, listenErr := listenForSettings(s, params.StorageRoot)
+		if listenErr != nil {
+			log.Warning("Could not serve the settings: %+v", listenErr)
+		} else {
+			kbfsOps.setSettingsServer(ss)
+		}
+	}
+
+	// Remember who's logged in, so that a later switch to another
+	// account knows what to tear down.
+	if err == nil {
+		kbfsOps.SetSessionUser(context.Background(), session.UID)
+	}
+
+	// If logged in, warm up the favorite folders in the background,
+	// so the first listing of them doesn't wait on the server for
+	// each one in turn.
+	if err == nil && params.FavoriteHeadFetchParallelism > 0 &&
+		config.Mode() != InitMinimal {
+		kbfsOps.startFetchingFavoriteHeads(
+			params.FavoriteHeadFetchParallelism)
 	}
 
 	return config, nil
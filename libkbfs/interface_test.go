@@ -27,7 +27,7 @@ type testLogMaker struct {
 	log logger.Logger
 }
 
-func newTestLogMaker(t *testing.T) testLogMaker {
+func newTestLogMaker(t testing.TB) testLogMaker {
 	return testLogMaker{logger.NewTestLogger(t)}
 }
 
@@ -10,6 +10,7 @@ import (
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
+	merkle "github.com/keybase/go-merkle-tree"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscodec"
 	"github.com/keybase/kbfs/kbfscrypto"
@@ -67,6 +68,30 @@ type diskBlockCacheSetter interface {
 	SetDiskBlockCache(DiskBlockCache)
 }
 
+type diskMDCacheGetter interface {
+	DiskMDCache() DiskMDCache
+}
+
+type diskMDCacheSetter interface {
+	SetDiskMDCache(DiskMDCache)
+}
+
+type diskPreviewCacheGetter interface {
+	DiskPreviewCache() DiskPreviewCache
+}
+
+type diskPreviewCacheSetter interface {
+	SetDiskPreviewCache(DiskPreviewCache)
+}
+
+type blockSpillCacheGetter interface {
+	BlockSpillCache() BlockSpillCache
+}
+
+type blockSpillCacheSetter interface {
+	SetBlockSpillCache(BlockSpillCache)
+}
+
 type clockGetter interface {
 	Clock() Clock
 }
@@ -75,6 +100,14 @@ type diskLimiterGetter interface {
 	DiskLimiter() DiskLimiter
 }
 
+type diskCacheBackendGetter interface {
+	DiskCacheBackend() DiskCacheBackend
+}
+
+type serverProxiesGetter interface {
+	ServerProxies() ServerProxies
+}
+
 // Block just needs to be (de)serialized using msgpack
 type Block interface {
 	dataVersioner
@@ -165,6 +198,15 @@ type KBFSOps interface {
 	// the local cache.  Idempotent, so it succeeds even if the folder
 	// isn't favorited.
 	DeleteFavorite(ctx context.Context, fav Favorite) error
+	// DeleteFavorites deletes several favorites from both the server
+	// and the local cache at once.  Idempotent, like DeleteFavorite.
+	DeleteFavorites(ctx context.Context, favs []Favorite) error
+	// GetFavoriteCleanupSuggestions returns the favorites that
+	// haven't been accessed on this device for long enough that
+	// params says they're worth unfavoriting or archiving, least
+	// recently accessed first.
+	GetFavoriteCleanupSuggestions(ctx context.Context,
+		params FavoriteCleanupParams) ([]FavoriteSuggestion, error)
 
 	// GetTLFCryptKeys gets crypt key of all generations as well as
 	// TLF ID for tlfHandle. The returned keys (the keys slice) are ordered by
@@ -226,6 +268,18 @@ type KBFSOps interface {
 	// is a remote-sync operation.
 	CreateLink(ctx context.Context, dir Node, fromName string, toPath string) (
 		EntryInfo, error)
+	// Link creates a new entry named name under dir for the file
+	// at the given node, if the logged-in user has write
+	// permission to the top-level folder.  The new entry shares
+	// the file's data blocks, by reference, as of the time of the
+	// link; after that, writes to either entry don't affect the
+	// other.  The new entry joins the file's link group, and every
+	// entry in the group reports the group's size as its Nlink.
+	// Returns the new entry info, or a LinkAcrossTLFsError if the
+	// two nodes are from different top-level folders.  This is a
+	// remote-sync operation.
+	Link(ctx context.Context, file Node, dir Node, name string) (
+		EntryInfo, error)
 	// RemoveDir removes the subdirectory represented by the given
 	// node, if the logged-in user has write permission to the
 	// top-level folder.  Will return an error if the subdirectory is
@@ -235,15 +289,32 @@ type KBFSOps interface {
 	// given node, if the logged-in user has write permission to the
 	// top-level folder.  This is a remote-sync operation.
 	RemoveEntry(ctx context.Context, dir Node, name string) error
+	// RemoveDirTree removes the subdirectory represented by the
+	// given node along with everything under it, if the logged-in
+	// user has write permission to the top-level folder.  The whole
+	// tree is usually removed in a single MD revision.  This is a
+	// remote-sync operation.
+	RemoveDirTree(ctx context.Context, dir Node, dirName string) error
 	// Rename performs an atomic rename operation with a given
 	// top-level folder if the logged-in user has write permission to
-	// that folder, and will return an error if nodes from different
-	// folders are passed in.  Also returns an error if the new name
-	// already has an entry corresponding to an existing directory
-	// (only non-dir types may be renamed over).  This is a
-	// remote-sync operation.
+	// that folder.  If nodes from different folders are passed in,
+	// it falls back to the non-atomic MoveAcrossTLFs, and it returns
+	// an error for nodes from different branches of the same folder.
+	// Also returns an error if the new name already has an entry
+	// corresponding to an existing directory (only non-dir types may
+	// be renamed over).  This is a remote-sync operation.
 	Rename(ctx context.Context, oldParent Node, oldName string, newParent Node,
 		newName string) error
+	// MoveAcrossTLFs moves the entry named srcName in srcParent to
+	// be named dstName in dstParent, where the two parents are in
+	// different TLFs.  All of the data is copied into the
+	// destination TLF, re-encrypting it under that TLF's keys, and
+	// the source is removed once the copy is complete.  The copy is
+	// made under a temporary name next to dstName; if the move is
+	// interrupted, repeating it resumes from that partial copy.
+	// Progress is reported in the Status.
+	MoveAcrossTLFs(ctx context.Context, srcParent Node, srcName string,
+		dstParent Node, dstName string) error
 	// Read fills in the given buffer with data from the file at the
 	// given node starting at the given offset, if the logged-in user
 	// has read permission to the top-level folder.  The read data
@@ -291,6 +362,134 @@ type KBFSOps interface {
 	// system interface, this may include modifications done via
 	// multiple file handles.  This is a remote-sync operation.
 	Sync(ctx context.Context, file Node) error
+	// SetFsyncDurability sets what future calls to Sync guarantee for
+	// files in the given folder, unless overridden in the context
+	// passed to Sync (see NewContextWithFsyncDurability).  Passing
+	// FsyncDurabilityDefault reverts the folder to the global
+	// setting.
+	SetFsyncDurability(ctx context.Context, folderBranch FolderBranch,
+		durability FsyncDurability) error
+	// SetTlfFrozen marks the given folder as read-only (frozen) for
+	// all devices, or clears that mark.  While a folder is frozen,
+	// any attempt to modify its data fails with TlfFrozenError.
+	// The folder must not have any unsynced or unmerged changes.
+	SetTlfFrozen(ctx context.Context, folderBranch FolderBranch,
+		frozen bool) error
+	// SetTlfAppendOnly marks the given folder as append-only for
+	// all devices, or clears that mark.  While a folder is
+	// append-only, new entries can be created and files can be
+	// appended to, but any attempt to modify or remove existing
+	// data fails with TlfAppendOnlyError.
+	SetTlfAppendOnly(ctx context.Context, folderBranch FolderBranch,
+		appendOnly bool) error
+	// SetTlfNameNormalization sets the unicode normalization form
+	// in which the given folder stores new entry names, for all
+	// devices.  Existing entries keep their names, and can still
+	// be looked up in either form.
+	SetTlfNameNormalization(ctx context.Context, folderBranch FolderBranch,
+		n NameNormalization) error
+	// SetFSEventStreaming turns on or off the streaming of FSEvents,
+	// describing every change made to the given folder by any
+	// device, as notifications to the service.
+	SetFSEventStreaming(ctx context.Context, folderBranch FolderBranch,
+		enabled bool) error
+	// GetHistoryRetention returns the history retention setting of
+	// the given folder.
+	GetHistoryRetention(ctx context.Context, folderBranch FolderBranch) (
+		HistoryRetention, error)
+	// SetHistoryRetention changes how long the given folder keeps
+	// history that is no longer referenced, which decides when
+	// quota reclamation deletes it.  Only writers may change it.
+	SetHistoryRetention(ctx context.Context, folderBranch FolderBranch,
+		retention HistoryRetention) error
+	// GetPinnedRevisions returns the pinned revisions of the given
+	// folder, in increasing order.
+	GetPinnedRevisions(ctx context.Context, folderBranch FolderBranch) (
+		[]MetadataRevision, error)
+	// PinRevision pins the given revision of the given folder for
+	// all devices, so that quota reclamation keeps every block
+	// needed to reconstruct it, whatever the history retention
+	// setting.  Since history is reclaimed in revision order,
+	// nothing unreferenced after the earliest pinned revision is
+	// reclaimed until it's unpinned.  Revisions that quota
+	// reclamation has already covered can't be pinned.
+	PinRevision(ctx context.Context, folderBranch FolderBranch,
+		rev MetadataRevision) error
+	// UnpinRevision unpins the given revision of the given folder,
+	// letting quota reclamation delete its history again.
+	UnpinRevision(ctx context.Context, folderBranch FolderBranch,
+		rev MetadataRevision) error
+	// GetSnapshots returns the named snapshots of the given folder,
+	// sorted by name.
+	GetSnapshots(ctx context.Context, folderBranch FolderBranch) (
+		[]TLFSnapshot, error)
+	// CreateSnapshot gives the given revision of the given folder a
+	// name, for all devices, and pins it like PinRevision does
+	// until the snapshot is deleted.  If rev is
+	// MetadataRevisionUninitialized, the current head is used.
+	CreateSnapshot(ctx context.Context, folderBranch FolderBranch,
+		name string, rev MetadataRevision) error
+	// DeleteSnapshot deletes the named snapshot of the given
+	// folder.  Its revision stays pinned only if it's pinned
+	// separately, or by another snapshot.
+	DeleteSnapshot(ctx context.Context, folderBranch FolderBranch,
+		name string) error
+	// RestoreFile rewrites the contents of the file with the given
+	// name in the given directory to what they were in the given
+	// revision, creating the file if it's been removed since, and
+	// returns its node.  The file is found at the same path in the
+	// old revision.  Only the parts of the file that differ are
+	// rewritten, so unchanged blocks keep their pointers, and all of
+	// it is synced in one revision; recreating the file or changing
+	// its executable bit take one more each.  This is a remote-sync
+	// operation.
+	RestoreFile(ctx context.Context, dir Node, name string,
+		rev MetadataRevision) (Node, error)
+	// GetConflictStrategies returns the conflict strategy rules this
+	// device uses for the given folder, sorted by path prefix.
+	GetConflictStrategies(ctx context.Context, folderBranch FolderBranch) (
+		[]ConflictStrategyRule, error)
+	// SetConflictStrategy sets the strategy this device uses to
+	// resolve conflicting changes to files under the given path
+	// prefix of the given folder.  An empty prefix covers the whole
+	// folder.  The rules are saved in the local storage root, if
+	// there is one.
+	SetConflictStrategy(ctx context.Context, folderBranch FolderBranch,
+		prefix string, strategy ConflictStrategy) error
+	// ClearConflictStrategy removes the conflict strategy rule for
+	// the given path prefix of the given folder, if any.
+	ClearConflictStrategy(ctx context.Context, folderBranch FolderBranch,
+		prefix string) error
+	// ForceQuotaReclamation starts quota reclamation for the given
+	// folder in the background, even if it's paused, without
+	// waiting for it to finish.
+	ForceQuotaReclamation(ctx context.Context,
+		folderBranch FolderBranch) error
+	// SetQuotaReclamationPaused turns periodic quota reclamation
+	// for the given folder off or back on.
+	SetQuotaReclamationPaused(ctx context.Context,
+		folderBranch FolderBranch, paused bool) error
+	// SetTlfSynced sets whether the given folder is kept synced on
+	// this device.  Blocks of synced folders go into their own
+	// partition of the disk block cache, so they don't compete for
+	// space with other cached blocks.  It fails if the disk block
+	// cache isn't partitioned.
+	SetTlfSynced(ctx context.Context,
+		folderBranch FolderBranch, synced bool) error
+	// SetQuotaReclamationMinHeadAge sets how old the head of the
+	// given folder must be, when another device wrote it, before
+	// quota reclamation runs.  Passing 0 reverts the folder to
+	// Config.QuotaReclamationMinHeadAge().
+	SetQuotaReclamationMinHeadAge(ctx context.Context,
+		folderBranch FolderBranch, age time.Duration) error
+	// CreateTLFFrom initializes the TLF named by h, creating it if
+	// needed, with a copy of everything under the directory src,
+	// and returns the new root node.  The copy is written in a
+	// single update, and fails with TlfNotEmptyError if the TLF
+	// already has entries in its root directory.  Since the whole
+	// template is held in memory, its file data is limited in size.
+	CreateTLFFrom(ctx context.Context, h *TlfHandle, src Node) (
+		Node, EntryInfo, error)
 	// FolderStatus returns the status of a particular folder/branch, along
 	// with a channel that will be closed when the status has been
 	// updated (to eliminate the need for polling this method).
@@ -353,6 +552,32 @@ type KBFSOps interface {
 	// newest version.  It works asynchronously, so no error is
 	// returned.
 	ForceFastForward(ctx context.Context)
+	// SetSessionUser records that uid is the logged-in user.  If
+	// the per-user state held by this KBFSOps was loaded for a
+	// different user, it's torn down first: every private folder
+	// is shut down and forgotten, and the cached quota usage is
+	// dropped, so that nothing cached for one account is visible
+	// to the next.  It returns whether that happened.
+	SetSessionUser(ctx context.Context, uid keybase1.UID) (switched bool)
+	// DeviceRevoked records that this device was revoked from the
+	// logged-in user's account.  Every private folder is shut down
+	// and forgotten, and until the next SetSessionUser, all writes
+	// and private reads fail with err, which is also reported as a
+	// failing service in the status.
+	DeviceRevoked(ctx context.Context, err DeviceRevokedError)
+	// PauseBackgroundWork pauses the journal flushing of every
+	// folder, and prefetching, until ResumeBackgroundWork is
+	// called.  Writes still go to the journals.
+	PauseBackgroundWork(ctx context.Context)
+	// ResumeBackgroundWork undoes PauseBackgroundWork.  Background
+	// work stays paused while the platform hooks report that
+	// battery saver is on or the network is metered.
+	ResumeBackgroundWork(ctx context.Context)
+	// OverrideBandwidthCaps holds block server traffic to caps,
+	// whatever the bandwidth policy says for the current network
+	// type, until it's called again.  A nil caps goes back to the
+	// policy.
+	OverrideBandwidthCaps(ctx context.Context, caps *BandwidthCaps)
 }
 
 // KeybaseService is an interface for communicating with the keybase
@@ -659,7 +884,8 @@ type MDCache interface {
 	// Get gets the metadata object associated with the given TLF ID,
 	// revision number, and branch ID (NullBranchID for merged MD).
 	Get(tlf tlf.ID, rev MetadataRevision, bid BranchID) (ImmutableRootMetadata, error)
-	// Put stores the metadata object.
+	// Put stores the metadata object, replacing any that's already
+	// cached for the same revision and branch.
 	Put(md ImmutableRootMetadata) error
 	// Delete removes the given metadata object from the cache if it exists.
 	Delete(tlf tlf.ID, rev MetadataRevision, bid BranchID)
@@ -840,14 +1066,98 @@ type DiskBlockCache interface {
 	// Put puts a block to the disk cache.
 	Put(ctx context.Context, tlfID tlf.ID, blockID kbfsblock.ID, buf []byte,
 		serverHalf kbfscrypto.BlockCryptKeyServerHalf) error
+	// GetBatch gets the given blocks from the disk cache, all at
+	// once.  Blocks that aren't in the cache are left out of the
+	// result.  The returned buffers belong to the caller, which may
+	// recycle them with putBlockBuffer once it's done with them.
+	GetBatch(ctx context.Context, tlfID tlf.ID, blockIDs []kbfsblock.ID) (
+		map[kbfsblock.ID]DiskBlockCacheBlock, error)
+	// PutBatch puts the given blocks to the disk cache, all at once.
+	PutBatch(ctx context.Context, tlfID tlf.ID,
+		blocks map[kbfsblock.ID]DiskBlockCacheBlock) error
 	// DeleteByTLF deletes some blocks from the disk cache.
 	DeleteByTLF(ctx context.Context, tlfID tlf.ID, blockIDs []kbfsblock.ID) (numRemoved int, sizeRemoved int64, err error)
+	// BlockIDsForTLF returns the IDs of all the blocks currently in
+	// the disk cache for the given TLF.
+	BlockIDsForTLF(ctx context.Context, tlfID tlf.ID) ([]kbfsblock.ID, error)
 	// Size returns the size in bytes of the disk cache.
 	Size() int64
+	// RegisterForEvictions adds an observer to be told whenever
+	// blocks are evicted from the disk cache.
+	RegisterForEvictions(obs DiskBlockCacheEvictionObserver)
+	// UnregisterFromEvictions removes an observer added by
+	// RegisterForEvictions.
+	UnregisterFromEvictions(obs DiskBlockCacheEvictionObserver)
+	// RecentEvictions returns the most recent evictions from the
+	// disk cache, oldest first.
+	RecentEvictions() []DiskBlockCacheEviction
+	// Shrink evicts blocks, least recently used first, until the
+	// disk cache takes up at most targetBytes, and returns how far
+	// it got.  It calls progressFn, if non-nil, after each batch of
+	// evictions.
+	Shrink(ctx context.Context, targetBytes int64,
+		progressFn func(DiskBlockCacheShrinkProgress)) (
+		DiskBlockCacheShrinkProgress, error)
 	// Shutdown cleanly shuts down the disk block cache.
 	Shutdown(ctx context.Context)
 }
 
+// DiskMDCache caches recent verified merged MD objects on disk, so
+// that they don't all need to be fetched and verified again when
+// KBFS restarts.
+type DiskMDCache interface {
+	// Get gets the given revision of a TLF from the disk cache.
+	Get(ctx context.Context, tlfID tlf.ID, rev MetadataRevision) (
+		DiskMDCacheEntry, error)
+	// GetHead gets the most recent revision of a TLF from the disk
+	// cache.
+	GetHead(ctx context.Context, tlfID tlf.ID) (DiskMDCacheEntry, error)
+	// GetTlfID returns the ID of the TLF with the given canonical
+	// path, if it's been recorded by Put.
+	GetTlfID(ctx context.Context, p string) (tlf.ID, error)
+	// Put puts a merged MD object into the disk cache, along with
+	// the signatures it had on the mdserver, and records that its
+	// TLF has the canonical path p, unless p is empty.  Only the
+	// most recent few revisions of each TLF are kept.
+	Put(ctx context.Context, p string, irmd ImmutableRootMetadata,
+		sigInfo, writerSigInfo kbfscrypto.SignatureInfo) error
+	// Shutdown cleanly shuts down the disk MD cache.
+	Shutdown(ctx context.Context)
+}
+
+// DiskPreviewCache keeps previews generated from the contents of
+// files, like image thumbnails, on local disk.  Previews are never
+// uploaded.
+type DiskPreviewCache interface {
+	// Get gets the preview of the given kind for the file whose
+	// top block has the given ID.
+	Get(ctx context.Context, tlfID tlf.ID, fileID kbfsblock.ID,
+		kind string) (DiskPreviewCacheEntry, error)
+	// Put puts a preview of the given kind for the file whose top
+	// block has the given ID into the cache, evicting the least
+	// recently used previews if the cache is full.
+	Put(ctx context.Context, tlfID tlf.ID, fileID kbfsblock.ID,
+		kind string, mimeType string, data []byte) error
+	// Shutdown cleanly shuts down the disk preview cache.
+	Shutdown(ctx context.Context)
+}
+
+// BlockSpillCache keeps the decoded blocks of reads too big for the
+// in-memory block cache in an encrypted temporary area on local disk,
+// so that such reads neither evict the working set from memory nor
+// refetch blocks they've already decoded.  Nothing in it outlives
+// the process.
+type BlockSpillCache interface {
+	// Get fills in block with the spilled block for ptr, or
+	// returns NoSuchBlockError if it isn't spilled.
+	Get(ctx context.Context, ptr BlockPointer, block Block) error
+	// Put spills block under ptr, evicting the least recently
+	// used spilled blocks if the disk limiter has no room for it.
+	Put(ctx context.Context, ptr BlockPointer, block Block) error
+	// Shutdown deletes all the spilled blocks.
+	Shutdown(ctx context.Context)
+}
+
 // cryptoPure contains all methods of Crypto that don't depend on
 // implicit state, i.e. they're pure functions of the input.
 type cryptoPure interface {
@@ -1026,7 +1336,9 @@ type MDOps interface {
 		ImmutableRootMetadata, error)
 
 	// GetRange returns a range of metadata objects corresponding to
-	// the passed revision numbers (inclusive).
+	// the passed revision numbers (inclusive).  Unless the TLF has
+	// a journal, revisions that are already in the MD cache aren't
+	// fetched or verified again, and the rest are added to the cache.
 	GetRange(ctx context.Context, id tlf.ID, start, stop MetadataRevision) (
 		[]ImmutableRootMetadata, error)
 
@@ -1059,6 +1371,11 @@ type MDOps interface {
 	// entered into a conflicting state.
 	GetLatestHandleForTLF(ctx context.Context, id tlf.ID) (
 		tlf.Handle, error)
+
+	// GetMerkleCheckStatus returns the result of the last check of
+	// each TLF's head against the MD server's Merkle tree, sorted
+	// by TLF ID.  It's empty if checks are off.
+	GetMerkleCheckStatus() []MerkleCheckStatus
 }
 
 // KeyOps fetches server-side key halves from the key server.
@@ -1269,6 +1586,16 @@ type MDServer interface {
 	GetKeyBundles(ctx context.Context, tlfID tlf.ID,
 		wkbID TLFWriterKeyBundleID, rkbID TLFReaderKeyBundleID) (
 		*TLFWriterKeyBundleV3, *TLFReaderKeyBundleV3, error)
+
+	// GetMerkleRootLatest returns the latest root of the given KBFS
+	// Merkle tree.  It returns a MerkleUnsupportedError if the
+	// server doesn't keep one.
+	GetMerkleRootLatest(ctx context.Context,
+		treeID keybase1.MerkleTreeID) (*MerkleRoot, error)
+
+	// GetMerkleNode returns the encoded Merkle tree node with the
+	// given hash.  The caller must check the hash.
+	GetMerkleNode(ctx context.Context, hash merkle.Hash) ([]byte, error)
 }
 
 type mdServerLocal interface {
@@ -1470,10 +1797,47 @@ type Notifier interface {
 	UnregisterFromChanges(folderBranches []FolderBranch, obs Observer) error
 }
 
-// Clock is an interface for getting the current time
+// Timer is a single event scheduled by a Clock; it behaves like a
+// *time.Timer, except that its channel is accessed via a method.
+type Timer interface {
+	// C returns the channel on which the time is delivered when
+	// the timer fires.  It is nil for timers made by
+	// Clock.AfterFunc.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing.  It returns true if
+	// the call stops the timer, and false if the timer has
+	// already fired or been stopped.
+	Stop() bool
+	// Reset changes the timer to fire after duration d.  It
+	// returns true if the timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a repeating event scheduled by a Clock; it behaves like
+// a *time.Ticker, except that its channel is accessed via a method.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// Clock is an interface for getting the current time, and for
+// scheduling events relative to it.  All time-based background
+// work should go through a Clock, so that tests can drive it
+// deterministically.
 type Clock interface {
 	// Now returns the current time.
 	Now() time.Time
+	// NewTimer creates a Timer that will send the current time on
+	// its channel after at least duration d.
+	NewTimer(d time.Duration) Timer
+	// NewTicker returns a Ticker that sends the current time on
+	// its channel every period d.  d must be greater than zero.
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc waits for the duration to elapse and then calls f
+	// in its own goroutine.
+	AfterFunc(d time.Duration, f func()) Timer
 }
 
 // ConflictRenamer deals with names for conflicting directory entries.
@@ -1499,8 +1863,16 @@ type Config interface {
 	currentSessionGetterGetter
 	diskBlockCacheGetter
 	diskBlockCacheSetter
+	diskMDCacheGetter
+	diskMDCacheSetter
+	diskPreviewCacheGetter
+	diskPreviewCacheSetter
+	blockSpillCacheGetter
+	blockSpillCacheSetter
 	clockGetter
 	diskLimiterGetter
+	diskCacheBackendGetter
+	serverProxiesGetter
 	KBFSOps() KBFSOps
 	SetKBFSOps(KBFSOps)
 	KBPKI() KBPKI
@@ -1558,6 +1930,11 @@ type Config interface {
 	// be true except for during some testing.
 	DoBackgroundFlushes() bool
 	SetDoBackgroundFlushes(bool)
+	// TLFUsageByProcess says whether mounts should tag each
+	// request with the name of the process that made it, so that
+	// the TLF usage status can be broken down by process.
+	TLFUsageByProcess() bool
+	SetTLFUsageByProcess(bool)
 	// RekeyWithPromptWaitTime indicates how long to wait, after
 	// setting the rekey bit, before prompting for a paper key.
 	RekeyWithPromptWaitTime() time.Duration
@@ -1586,6 +1963,16 @@ type Config interface {
 	// should check for quota to reclaim.  If the Duration.Seconds()
 	// == 0, quota reclamation should not run automatically.
 	QuotaReclamationPeriod() time.Duration
+	// DiskCacheVerificationPeriod indicates how often each TLF
+	// should drop blocks that its current head no longer references
+	// from the disk block cache.  If the Duration.Seconds() == 0,
+	// this should not run automatically.
+	DiskCacheVerificationPeriod() time.Duration
+	// BlockChallengePeriod indicates how often each TLF should check
+	// that the block server can still return a random sample of the
+	// blocks it archived.  If the Duration.Seconds() == 0, this
+	// should not run automatically.
+	BlockChallengePeriod() time.Duration
 	// QuotaReclamationMinUnrefAge indicates the minimum time a block
 	// must have been unreferenced before it can be reclaimed.
 	QuotaReclamationMinUnrefAge() time.Duration
@@ -1610,6 +1997,65 @@ type Config interface {
 	TLFValidDuration() time.Duration
 	// SetTLFValidDuration sets TLFValidDuration.
 	SetTLFValidDuration(time.Duration)
+
+	// TLFIdleTimeout is how long a TLF may go unaccessed before its
+	// in-memory state is torn down, to be lazily recreated on the
+	// next access.  A zero value disables idle eviction.
+	TLFIdleTimeout() time.Duration
+	// SetTLFIdleTimeout sets TLFIdleTimeout.
+	SetTLFIdleTimeout(time.Duration)
+
+	// WriteCoalescingBytes is the size below which sequential writes
+	// to a file are buffered and merged before being applied to the
+	// file's blocks.  A zero value disables write coalescing.
+	WriteCoalescingBytes() int64
+	// SetWriteCoalescingBytes sets WriteCoalescingBytes.
+	SetWriteCoalescingBytes(int64)
+
+	// MetadataOpDelay is how long setattr operations, like mtime
+	// and exec bit changes, may wait so that a burst of them to
+	// files in the same directory is written as one revision.  A
+	// zero value writes each one right away.
+	MetadataOpDelay() time.Duration
+	// SetMetadataOpDelay sets MetadataOpDelay.
+	SetMetadataOpDelay(time.Duration)
+
+	// FsyncDurability is the durability level used by Sync calls
+	// that don't have a more specific level set, either for their
+	// TLF or in their context.
+	FsyncDurability() FsyncDurability
+	// SetFsyncDurability sets FsyncDurability.
+	SetFsyncDurability(FsyncDurability)
+
+	// MerkleCheckMode says whether merged MD heads fetched from the
+	// server are checked against its Merkle tree, and what happens
+	// when the check fails.
+	MerkleCheckMode() MerkleCheckMode
+	// SetMerkleCheckMode sets MerkleCheckMode.
+	SetMerkleCheckMode(MerkleCheckMode)
+
+	// IdentifyPolicy says when the users of each class of TLF are
+	// identified.
+	IdentifyPolicy() IdentifyPolicy
+	// SetIdentifyPolicy sets IdentifyPolicy.
+	SetIdentifyPolicy(IdentifyPolicy)
+
+	// SetDiskCacheBackend sets DiskCacheBackend, which is the
+	// key-value store that disk block caches opened afterwards keep
+	// their databases in.
+	SetDiskCacheBackend(DiskCacheBackend)
+
+	// SetServerProxies sets ServerProxies, which says how
+	// connections to the MD and block servers made afterwards
+	// reach them.
+	SetServerProxies(ServerProxies)
+
+	// PlatformHooks reports the power and network state of the
+	// device, so that background work can be paused on battery
+	// saver or a metered network.  It may be nil.
+	PlatformHooks() PlatformHooks
+	// SetPlatformHooks sets PlatformHooks.
+	SetPlatformHooks(PlatformHooks)
 	// Shutdown is called to free config resources.
 	Shutdown(context.Context) error
 	// CheckStateOnShutdown tells the caller whether or not it is safe
@@ -1617,6 +2063,14 @@ type Config interface {
 	CheckStateOnShutdown() bool
 }
 
+// PlatformHooks reports OS state that KBFS uses to be polite about
+// its background work, e.g. on laptops and tethered connections.
+type PlatformHooks interface {
+	// State returns the current power and network state of the
+	// device.
+	State(ctx context.Context) (PlatformState, error)
+}
+
 // NodeCache holds Nodes, and allows libkbfs to update them when
 // things change about the underlying KBFS blocks.  It is probably
 // most useful to instantiate this on a per-folder-branch basis, so
@@ -1712,6 +2166,10 @@ type RekeyQueue interface {
 	// IsRekeyPending returns true if the given folder is in the rekey queue.
 	// Note that an ongoing rekey doesn't count as "pending".
 	IsRekeyPending(tlf.ID) bool
+	// GetStatus returns the status of each folder that has been
+	// enqueued but isn't fully rekeyed yet, including ones whose
+	// rekeys are in progress, being retried, or have failed.
+	GetStatus() []RekeyStatus
 	// Shutdown cancels all pending rekey actions and clears the queue. It
 	// doesn't cancel ongoing rekeys. After Shutdown() is called, the same
 	// RekeyQueue shouldn't be used anymore.
@@ -1824,6 +2282,17 @@ type BareRootMetadata interface {
 	GetPrevRoot() MdID
 	// IsUnmergedSet returns true if the unmerged bit is set.
 	IsUnmergedSet() bool
+	// IsFrozen returns true if the frozen bit is set, meaning that
+	// clients must not make any further changes to the folder's
+	// data until it's cleared.
+	IsFrozen() bool
+	// IsAppendOnly returns true if the append-only bit is set,
+	// meaning that clients may only add new entries and append
+	// to existing files.
+	IsAppendOnly() bool
+	// NameNormalization returns the canonical form of new entry
+	// names in the folder.
+	NameNormalization() NameNormalization
 	// GetSerializedPrivateMetadata returns the serialized private metadata as a byte slice.
 	GetSerializedPrivateMetadata() []byte
 	// GetSerializedWriterMetadata serializes the underlying writer metadata and returns the result.
@@ -1885,6 +2354,13 @@ type MutableBareRootMetadata interface {
 	ClearFinalBit()
 	// SetUnmerged sets the unmerged bit.
 	SetUnmerged()
+	// SetFrozen sets or clears the frozen bit.
+	SetFrozen(frozen bool)
+	// SetAppendOnly sets or clears the append-only bit.
+	SetAppendOnly(appendOnly bool)
+	// SetNameNormalization sets the canonical form of new entry
+	// names.
+	SetNameNormalization(n NameNormalization)
 	// SetBranchID sets the branch ID for this metadata revision.
 	SetBranchID(bid BranchID)
 	// SetPrevRoot sets the hash of the previous metadata revision.
@@ -0,0 +1,334 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+// BlockJournalDumpBlock is a block named by an entry of a block
+// journal.
+type BlockJournalDumpBlock struct {
+	ID       kbfsblock.ID
+	Contexts []kbfsblock.Context
+	// Size is the size of the block's data in the journal, for
+	// puts.  It's -1 if the data is missing.
+	Size int64 `json:",omitempty"`
+}
+
+// BlockJournalDumpEntry is an entry of a block journal, as dumped by
+// DumpTLFJournal.
+type BlockJournalDumpEntry struct {
+	Ordinal uint64
+	// Op is the operation waiting to be sent to the block server,
+	// or "mdRevisionMarker" for the end of the blocks of an MD
+	// revision.
+	Op     string `json:",omitempty"`
+	Blocks []BlockJournalDumpBlock
+	// Revision is the MD revision that a marker ends the blocks
+	// of.
+	Revision      MetadataRevision `json:",omitempty"`
+	Ignored       bool             `json:",omitempty"`
+	IsLocalSquash bool             `json:",omitempty"`
+	// Err says why the entry couldn't be read, if it couldn't.
+	Err string `json:",omitempty"`
+}
+
+// MDJournalDumpEntry is a revision in an MD journal, as dumped by
+// DumpTLFJournal.
+type MDJournalDumpEntry struct {
+	Revision      MetadataRevision
+	ID            MdID
+	IsLocalSquash bool `json:",omitempty"`
+	WKBNew        bool `json:",omitempty"`
+	RKBNew        bool `json:",omitempty"`
+
+	// The fields below are only filled in when decoding.
+
+	Timestamp   time.Time    `json:",omitempty"`
+	BranchID    BranchID     `json:",omitempty"`
+	MergeStatus string       `json:",omitempty"`
+	Writer      keybase1.UID `json:",omitempty"`
+	PrevRoot    MdID         `json:",omitempty"`
+	RefBytes    uint64       `json:",omitempty"`
+	UnrefBytes  uint64       `json:",omitempty"`
+	DiskUsage   uint64       `json:",omitempty"`
+	// Flags lists what's special about the revision: "rekey",
+	// "final", "writerMetadataCopied" or "unmerged".
+	Flags []string `json:",omitempty"`
+	// Ops describes the changes the revision makes.  It's only
+	// filled in for public folders, since the changes of a
+	// private folder are encrypted with its keys.
+	Ops []string `json:",omitempty"`
+	// OpsErr says why Ops couldn't be filled in.
+	OpsErr string `json:",omitempty"`
+
+	// Err says why the revision couldn't be read, if it couldn't.
+	Err string `json:",omitempty"`
+}
+
+// TLFJournalDump is everything waiting to be uploaded from a TLF's
+// journal.
+type TLFJournalDump struct {
+	Dir          string
+	UID          keybase1.UID
+	VerifyingKey kbfscrypto.VerifyingKey
+	TlfID        tlf.ID
+
+	// UnflushedBytes is how much block data is waiting to be
+	// uploaded, and StoredBytes how much is on disk.
+	UnflushedBytes int64
+	StoredBytes    int64
+
+	Blocks     []BlockJournalDumpEntry
+	DeferredGC []BlockJournalDumpEntry
+	MDs        []MDJournalDumpEntry
+}
+
+func dumpBlockJournal(codec kbfscodec.Codec, dir string,
+	store *blockDiskStore) ([]BlockJournalDumpEntry, error) {
+	j, err := makeDiskJournal(codec, dir, reflect.TypeOf(blockJournalEntry{}))
+	if err != nil {
+		return nil, err
+	}
+	if j.empty() {
+		return nil, nil
+	}
+	var entries []BlockJournalDumpEntry
+	for o := j.earliest; o <= j.latest; o++ {
+		dumpEntry := BlockJournalDumpEntry{Ordinal: uint64(o)}
+		e, err := j.readJournalEntry(o)
+		if err != nil {
+			dumpEntry.Err = err.Error()
+			entries = append(entries, dumpEntry)
+			continue
+		}
+		entry := e.(blockJournalEntry)
+		dumpEntry.Op = entry.Op.String()
+		dumpEntry.Ignored = entry.Ignore
+		dumpEntry.IsLocalSquash = entry.IsLocalSquash
+		if entry.Op == mdRevMarkerOp {
+			dumpEntry.Revision = entry.Revision
+		}
+		for id, contexts := range entry.Contexts {
+			block := BlockJournalDumpBlock{ID: id, Contexts: contexts}
+			if entry.Op == blockPutOp {
+				block.Size = -1
+				hasData, err := store.hasData(id)
+				if err == nil && hasData {
+					block.Size, err = store.getDataSize(id)
+					if err != nil {
+						block.Size = -1
+					}
+				}
+			}
+			dumpEntry.Blocks = append(dumpEntry.Blocks, block)
+		}
+		sort.Slice(dumpEntry.Blocks, func(i, j int) bool {
+			return dumpEntry.Blocks[i].ID.String() <
+				dumpEntry.Blocks[j].ID.String()
+		})
+		entries = append(entries, dumpEntry)
+	}
+	return entries, nil
+}
+
+// decodeMDForDump fills in the fields of entry that come from
+// decoding the MD itself.
+func decodeMDForDump(codec kbfscodec.Codec, mdJournal mdJournal,
+	entry *MDJournalDumpEntry) error {
+	timestamp, version, err := mdJournal.getMDInfo(entry.ID)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(mdJournal.mdDataPath(entry.ID))
+	if err != nil {
+		return err
+	}
+	rmd, err := DecodeRootMetadata(
+		codec, mdJournal.tlfID, version, SegregatedKeyBundlesVer, data)
+	if err != nil {
+		return err
+	}
+
+	entry.Timestamp = timestamp
+	entry.BranchID = rmd.BID()
+	entry.MergeStatus = rmd.MergedStatus().String()
+	entry.Writer = rmd.LastModifyingWriter()
+	entry.PrevRoot = rmd.GetPrevRoot()
+	entry.RefBytes = rmd.RefBytes()
+	entry.UnrefBytes = rmd.UnrefBytes()
+	entry.DiskUsage = rmd.DiskUsage()
+	if rmd.IsRekeySet() {
+		entry.Flags = append(entry.Flags, "rekey")
+	}
+	if rmd.IsFinal() {
+		entry.Flags = append(entry.Flags, "final")
+	}
+	if rmd.IsWriterMetadataCopiedSet() {
+		entry.Flags = append(entry.Flags, "writerMetadataCopied")
+	}
+	if rmd.IsUnmergedSet() {
+		entry.Flags = append(entry.Flags, "unmerged")
+	}
+
+	if !mdJournal.tlfID.IsPublic() {
+		entry.OpsErr = "the changes of a private folder are encrypted"
+		return nil
+	}
+	var pmd PrivateMetadata
+	err = codec.Decode(rmd.GetSerializedPrivateMetadata(), &pmd)
+	if err != nil {
+		entry.OpsErr = err.Error()
+		return nil
+	}
+	if pmd.Changes.Info.BlockPointer != zeroPtr {
+		entry.OpsErr = fmt.Sprintf("the changes are stored in block %v",
+			pmd.Changes.Info.BlockPointer)
+		return nil
+	}
+	for _, op := range pmd.Changes.Ops {
+		entry.Ops = append(entry.Ops, op.String())
+	}
+	return nil
+}
+
+func dumpMDJournal(codec kbfscodec.Codec, dir string, tlfID tlf.ID,
+	decode bool) ([]MDJournalDumpEntry, error) {
+	idJournal, err := makeMdIDJournal(codec, mdJournalPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	j := idJournal.j
+	if j.empty() {
+		return nil, nil
+	}
+	// Only the fields needed to find the MDs are filled in.
+	mdJournal := mdJournal{codec: codec, tlfID: tlfID, dir: dir}
+	var entries []MDJournalDumpEntry
+	for o := j.earliest; o <= j.latest; o++ {
+		rev, err := ordinalToRevision(o)
+		if err != nil {
+			return nil, err
+		}
+		dumpEntry := MDJournalDumpEntry{Revision: rev}
+		entry, err := idJournal.readJournalEntry(rev)
+		if err != nil {
+			dumpEntry.Err = err.Error()
+			entries = append(entries, dumpEntry)
+			continue
+		}
+		dumpEntry.ID = entry.ID
+		dumpEntry.IsLocalSquash = entry.IsLocalSquash
+		dumpEntry.WKBNew = entry.WKBNew
+		dumpEntry.RKBNew = entry.RKBNew
+		if decode {
+			err := decodeMDForDump(codec, mdJournal, &dumpEntry)
+			if err != nil {
+				dumpEntry.Err = err.Error()
+			}
+		}
+		entries = append(entries, dumpEntry)
+	}
+	return entries, nil
+}
+
+// DumpTLFJournal reads the journal of a single TLF in dir, and
+// returns what's waiting in it to be uploaded.  It only reads local
+// files, and never contacts the servers, so it works on a journal
+// copied from another machine.  Only if decode is true are the MDs
+// themselves read, to describe each revision; otherwise just their
+// IDs are listed.
+//
+// The journal must not be in use by a running KBFS.
+func DumpTLFJournal(codec kbfscodec.Codec, dir string, decode bool) (
+	dump TLFJournalDump, err error) {
+	uid, key, tlfID, err := readTLFJournalInfoFile(dir)
+	if err != nil {
+		return TLFJournalDump{}, err
+	}
+	dump = TLFJournalDump{
+		Dir:          dir,
+		UID:          uid,
+		VerifyingKey: key,
+		TlfID:        tlfID,
+	}
+
+	var aggregateInfo blockAggregateInfo
+	err = kbfscodec.DeserializeFromFile(
+		codec, aggregateInfoPath(dir), &aggregateInfo)
+	if err != nil && !ioutil.IsNotExist(err) {
+		return TLFJournalDump{}, err
+	}
+	dump.UnflushedBytes = aggregateInfo.UnflushedBytes
+	dump.StoredBytes = aggregateInfo.StoredBytes
+
+	store := makeBlockDiskStore(codec, blockJournalStoreDir(dir))
+	dump.Blocks, err = dumpBlockJournal(codec, blockJournalDir(dir), store)
+	if err != nil {
+		return TLFJournalDump{}, err
+	}
+	dump.DeferredGC, err = dumpBlockJournal(
+		codec, deferredGCBlockJournalDir(dir), store)
+	if err != nil {
+		return TLFJournalDump{}, err
+	}
+	dump.MDs, err = dumpMDJournal(codec, dir, tlfID, decode)
+	if err != nil {
+		return TLFJournalDump{}, err
+	}
+	return dump, nil
+}
+
+// DumpJournals dumps the journals of every TLF under the storage root
+// with DumpTLFJournal.  If tlfID isn't zero, only that TLF's journals
+// are dumped; a device can have one for each of its users.
+func DumpJournals(storageRoot string, tlfID tlf.ID, decode bool) (
+	[]TLFJournalDump, error) {
+	root := filepath.Join(storageRoot, "kbfs_journal", "v1")
+	fileInfos, err := ioutil.ReadDir(root)
+	if ioutil.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	codec := kbfscodec.NewMsgpack()
+	var dumps []TLFJournalDump
+	for _, fi := range fileInfos {
+		if !fi.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, fi.Name())
+		_, _, dirTlfID, err := readTLFJournalInfoFile(dir)
+		if ioutil.IsNotExist(errors.Cause(err)) {
+			// Not a TLF journal.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if tlfID != tlf.NullID && dirTlfID != tlfID {
+			continue
+		}
+		dump, err := DumpTLFJournal(codec, dir, decode)
+		if err != nil {
+			return nil, err
+		}
+		dumps = append(dumps, dump)
+	}
+	return dumps, nil
+}
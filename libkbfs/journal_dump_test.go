@@ -0,0 +1,108 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpJournals(t *testing.T) {
+	codec, _, tlfID, signer, ekg, bsplit, tempdir, j :=
+		setupMDJournalTest(t, SegregatedKeyBundlesVer)
+	defer teardownMDJournalTest(t, tempdir)
+
+	// The TLF journal directory is the MD journal's, as in
+	// makeTLFJournal.
+	dir := j.dir
+	key := kbfscrypto.MakeFakeSigningKeyOrBust("fake seed").GetVerifyingKey()
+	err := writeTLFJournalInfoFile(dir, j.uid, key, tlfID)
+	require.NoError(t, err)
+
+	mds, _ := putMDRange(t, SegregatedKeyBundlesVer, tlfID, signer, ekg,
+		bsplit, MetadataRevisionInitial, MdID{}, 2, j)
+
+	bj, err := makeDiskJournal(codec, blockJournalDir(dir),
+		reflect.TypeOf(blockJournalEntry{}))
+	require.NoError(t, err)
+	bID := kbfsblock.FakeID(1)
+	for _, e := range []blockJournalEntry{
+		{
+			Op: blockPutOp,
+			Contexts: kbfsblock.ContextMap{
+				bID: {kbfsblock.MakeFirstContext(
+					j.uid, keybase1.BlockType_DATA)},
+			},
+		},
+		{Op: mdRevMarkerOp, Revision: 2},
+		{Op: blockPutOp},
+	} {
+		_, err := bj.appendJournalEntry(nil, e)
+		require.NoError(t, err)
+	}
+	corruptJournalEntryForTest(t, bj, 3)
+
+	// Move the journal to where the journal server would keep it.
+	storageRoot, err := ioutil.TempDir(os.TempDir(), "journal_dump")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(storageRoot)
+		assert.NoError(t, err)
+	}()
+	root := filepath.Join(storageRoot, "kbfs_journal", "v1")
+	err = ioutil.MkdirAll(root, 0700)
+	require.NoError(t, err)
+	dir = filepath.Join(root, "tlf")
+	err = ioutil.Rename(j.dir, dir)
+	require.NoError(t, err)
+
+	dumps, err := DumpJournals(storageRoot, tlf.FakeID(2, false), true)
+	require.NoError(t, err)
+	require.Len(t, dumps, 0)
+
+	dumps, err = DumpJournals(storageRoot, tlf.NullID, true)
+	require.NoError(t, err)
+	require.Len(t, dumps, 1)
+	dump := dumps[0]
+	require.Equal(t, dir, dump.Dir)
+	require.Equal(t, tlfID, dump.TlfID)
+	require.Equal(t, j.uid, dump.UID)
+	require.Equal(t, key, dump.VerifyingKey)
+
+	require.Len(t, dump.Blocks, 3)
+	require.Equal(t, "blockPut", dump.Blocks[0].Op)
+	require.Len(t, dump.Blocks[0].Blocks, 1)
+	require.Equal(t, bID, dump.Blocks[0].Blocks[0].ID)
+	// The block's data was never written.
+	require.Equal(t, int64(-1), dump.Blocks[0].Blocks[0].Size)
+	require.Equal(t, "mdRevisionMarker", dump.Blocks[1].Op)
+	require.Equal(t, MetadataRevision(2), dump.Blocks[1].Revision)
+	require.NotEqual(t, "", dump.Blocks[2].Err)
+	require.Len(t, dump.DeferredGC, 0)
+
+	require.Len(t, dump.MDs, len(mds))
+	prevRoot := MdID{}
+	for i, e := range dump.MDs {
+		require.Equal(t, "", e.Err)
+		require.Equal(t, mds[i].Revision(), e.Revision)
+		require.Equal(t, prevRoot, e.PrevRoot)
+		require.Equal(t, Merged.String(), e.MergeStatus)
+		require.Equal(t, j.uid, e.Writer)
+		// The test TLF is private, so its ops are encrypted.
+		require.NotEqual(t, "", e.OpsErr)
+		require.Len(t, e.Ops, 0)
+		prevRoot = e.ID
+	}
+}
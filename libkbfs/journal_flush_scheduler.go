@@ -0,0 +1,208 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfssync"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const (
+	// minJournalFlushWorkers is the number of TLF journals that
+	// can flush a batch at the same time while the journals are
+	// well under their disk limits.
+	minJournalFlushWorkers = 4
+	// maxJournalFlushWorkers is the number of TLF journals that
+	// can flush a batch at the same time once the disk limiter is
+	// fully backpressuring journal writes.
+	maxJournalFlushWorkers = 16
+	// maxJournalFlushBlockOps is the number of block puts and
+	// reference additions that can be in flight across all
+	// journal flushes.
+	maxJournalFlushBlockOps = 2 * maxParallelBlockPuts
+)
+
+// journalFlushScheduler is shared by all the TLF journals of a
+// JournalServer, so that journals with a backlog flush concurrently
+// without each of them putting maxParallelBlockPuts blocks at once.
+//
+// A journal takes a worker for each batch it flushes, rather than for
+// its whole backlog, so journals take turns once more of them have
+// work than there are workers. The number of workers grows with the
+// journal pressure reported by the disk limiter, to drain the
+// journals faster as they approach their disk limits. Every block
+// operation of a flush also takes one unit of a shared budget.
+type journalFlushScheduler struct {
+	minWorkers int64
+	maxWorkers int64
+	maxOps     int64
+
+	workers *kbfssync.Semaphore
+	ops     *kbfssync.Semaphore
+
+	// lock protects the fields below, and the (implicit) maximum
+	// value of workers, but not the semaphore itself.
+	lock           sync.Mutex
+	workerLimit    int64
+	activeWorkers  int64
+	waitingWorkers int64
+	opsInFlight    int64
+}
+
+func newJournalFlushScheduler(
+	minWorkers, maxWorkers, maxOps int64) *journalFlushScheduler {
+	if minWorkers <= 0 || maxWorkers < minWorkers || maxOps <= 0 {
+		panic("Invalid journal flush scheduler limits")
+	}
+	s := &journalFlushScheduler{
+		minWorkers:  minWorkers,
+		maxWorkers:  maxWorkers,
+		maxOps:      maxOps,
+		workers:     kbfssync.NewSemaphore(),
+		ops:         kbfssync.NewSemaphore(),
+		workerLimit: minWorkers,
+	}
+	s.workers.Release(minWorkers)
+	s.ops.Release(maxOps)
+	return s
+}
+
+func newDefaultJournalFlushScheduler() *journalFlushScheduler {
+	return newJournalFlushScheduler(minJournalFlushWorkers,
+		maxJournalFlushWorkers, maxJournalFlushBlockOps)
+}
+
+// updateWorkerLimitLocked adjusts the number of workers to the
+// journal pressure reported by diskLimiter, which may be nil.
+func (s *journalFlushScheduler) updateWorkerLimitLocked(
+	diskLimiter DiskLimiter) {
+	var pressure float64
+	if diskLimiter != nil {
+		pressure = diskLimiter.journalPressure()
+	}
+	newLimit := s.minWorkers +
+		int64(pressure*float64(s.maxWorkers-s.minWorkers))
+	delta := newLimit - s.workerLimit
+	// These operations are adjusting the *maximum* value of
+	// s.workers.
+	if delta > 0 {
+		s.workers.Release(delta)
+	} else if delta < 0 {
+		s.workers.ForceAcquire(-delta)
+	}
+	s.workerLimit = newLimit
+}
+
+// acquireWorker blocks until a flush worker is free, or ctx is
+// canceled. On success, the caller must call the returned function
+// once it's done with the worker.
+func (s *journalFlushScheduler) acquireWorker(
+	ctx context.Context, diskLimiter DiskLimiter) (
+	release func(), err error) {
+	func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.updateWorkerLimitLocked(diskLimiter)
+		s.waitingWorkers++
+	}()
+
+	_, err = s.workers.Acquire(ctx, 1)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.waitingWorkers--
+	if err != nil {
+		return nil, err
+	}
+	s.activeWorkers++
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.activeWorkers--
+		s.workers.Release(1)
+		s.updateWorkerLimitLocked(diskLimiter)
+	}, nil
+}
+
+// acquireBlockOp blocks until there's room in the budget for
+// another block operation, or ctx is canceled. On success, the
+// caller must call the returned function once the operation is
+// done.
+func (s *journalFlushScheduler) acquireBlockOp(ctx context.Context) (
+	release func(), err error) {
+	_, err = s.ops.Acquire(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.opsInFlight++
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.opsInFlight--
+		s.ops.Release(1)
+	}, nil
+}
+
+type journalFlushSchedulerStatus struct {
+	WorkerLimit      int64
+	ActiveWorkers    int64
+	WaitingWorkers   int64
+	MaxBlockOps      int64
+	BlockOpsInFlight int64
+}
+
+func (s *journalFlushScheduler) getStatus() journalFlushSchedulerStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return journalFlushSchedulerStatus{
+		WorkerLimit:      s.workerLimit,
+		ActiveWorkers:    s.activeWorkers,
+		WaitingWorkers:   s.waitingWorkers,
+		MaxBlockOps:      s.maxOps,
+		BlockOpsInFlight: s.opsInFlight,
+	}
+}
+
+// journalFlushBlockServer charges the block puts and reference
+// additions made while flushing a journal against the budget of a
+// journalFlushScheduler.
+type journalFlushBlockServer struct {
+	BlockServer
+	scheduler *journalFlushScheduler
+}
+
+var _ BlockServer = journalFlushBlockServer{}
+
+// Put implements the BlockServer interface for
+// journalFlushBlockServer.
+func (b journalFlushBlockServer) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	release, err := b.scheduler.acquireBlockOp(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return b.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
+}
+
+// AddBlockReference implements the BlockServer interface for
+// journalFlushBlockServer.
+func (b journalFlushBlockServer) AddBlockReference(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID, context kbfsblock.Context) error {
+	release, err := b.scheduler.acquireBlockOp(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return b.BlockServer.AddBlockReference(ctx, tlfID, id, context)
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestJournalFlushSchedulerWorkers(t *testing.T) {
+	ctx := context.Background()
+	s := newJournalFlushScheduler(2, 4, 1)
+	diskLimiter := newSemaphoreDiskLimiter(100, 100)
+
+	t.Log("Without any journal pressure, only the minimum can flush")
+	release1, err := s.acquireWorker(ctx, diskLimiter)
+	require.NoError(t, err)
+	release2, err := s.acquireWorker(ctx, diskLimiter)
+	require.NoError(t, err)
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = s.acquireWorker(shortCtx, diskLimiter)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	require.Equal(t, journalFlushSchedulerStatus{
+		WorkerLimit:   2,
+		ActiveWorkers: 2,
+		MaxBlockOps:   1,
+	}, s.getStatus())
+
+	t.Log("A waiting worker gets a released one")
+	acquired := make(chan func())
+	go func() {
+		release, err := s.acquireWorker(ctx, diskLimiter)
+		if err == nil {
+			acquired <- release
+		}
+	}()
+	release1()
+	select {
+	case release1 = <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a worker")
+	}
+
+	t.Log("Once the journals fill up, more of them can flush")
+	diskLimiter.onJournalEnable(ctx, 100, 0)
+	release3, err := s.acquireWorker(ctx, diskLimiter)
+	require.NoError(t, err)
+	release4, err := s.acquireWorker(ctx, diskLimiter)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), s.getStatus().WorkerLimit)
+
+	t.Log("And the limit goes back down as they drain")
+	diskLimiter.onJournalDisable(ctx, 100, 0)
+	release4()
+	release3()
+	require.Equal(t, int64(2), s.getStatus().WorkerLimit)
+	shortCtx2, cancel2 := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel2()
+	_, err = s.acquireWorker(shortCtx2, diskLimiter)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	release2()
+	release1()
+	require.Zero(t, s.getStatus().ActiveWorkers)
+}
+
+func TestJournalFlushBlockServerBudget(t *testing.T) {
+	ctx := context.Background()
+	s := newJournalFlushScheduler(1, 1, 1)
+	bserv := journalFlushBlockServer{
+		NewBlockServerMemory(newTestLogMaker(t).MakeLogger("")), s}
+
+	t.Log("With the budget used up, block puts have to wait")
+	release, err := s.acquireBlockOp(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), s.getStatus().BlockOpsInFlight)
+	tlfID := tlf.FakeID(1, false)
+	buf := []byte{1}
+	id, err := kbfsblock.MakePermanentID(buf)
+	require.NoError(t, err)
+	bCtx := kbfsblock.MakeFirstContext(
+		keybase1.MakeTestUID(1), keybase1.BlockType_DATA)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err = bserv.Put(shortCtx, tlfID, id, bCtx, buf, serverHalf)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	release()
+	err = bserv.Put(ctx, tlfID, id, bCtx, buf, serverHalf)
+	require.NoError(t, err)
+	require.Zero(t, s.getStatus().BlockOpsInFlight)
+}
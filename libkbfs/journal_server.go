@@ -58,6 +58,8 @@ type JournalServerStatus struct {
 	CurrentVerifyingKey kbfscrypto.VerifyingKey
 	EnableAuto          bool
 	EnableAutoSetByUser bool
+	NetworkMetered      bool
+	AllPaused           bool
 	JournalCount        int
 	// The byte counters below are signed because
 	// os.FileInfo.Size() is signed. The file counter is signed
@@ -67,6 +69,7 @@ type JournalServerStatus struct {
 	UnflushedBytes    int64
 	UnflushedPaths    []string
 	DiskLimiterStatus interface{}
+	FlushScheduler    journalFlushSchedulerStatus
 }
 
 // branchChangeListener describes a caller that will get updates via
@@ -117,6 +120,7 @@ type JournalServer struct {
 	delegateMDOps           MDOps
 	onBranchChange          branchChangeListener
 	onMDFlush               mdFlushListener
+	flushScheduler          *journalFlushScheduler
 
 	// Protects all fields below.
 	lock                sync.RWMutex
@@ -126,6 +130,8 @@ type JournalServer struct {
 	dirtyOps            uint
 	dirtyOpsDone        *sync.Cond
 	serverConfig        journalServerConfig
+	networkMetered      bool
+	allPaused           bool
 }
 
 func makeJournalServer(
@@ -147,6 +153,7 @@ func makeJournalServer(
 		delegateMDOps:           mdOps,
 		onBranchChange:          onBranchChange,
 		onMDFlush:               onMDFlush,
+		flushScheduler:          newDefaultJournalFlushScheduler(),
 		tlfJournals:             make(map[tlf.ID]*tlfJournal),
 	}
 	jServer.dirtyOpsDone = sync.NewCond(&jServer.lock)
@@ -411,10 +418,15 @@ func (j *JournalServer) enableLocked(
 	tlfJournal, err := makeTLFJournal(
 		ctx, j.currentUID, j.currentVerifyingKey, tlfDir,
 		tlfID, tlfJournalConfigAdapter{j.config}, j.delegateBlockServer,
-		bws, nil, j.onBranchChange, j.onMDFlush, j.config.DiskLimiter())
+		bws, nil, j.onBranchChange, j.onMDFlush, j.config.DiskLimiter(),
+		j.flushScheduler)
 	if err != nil {
 		return err
 	}
+	tlfJournal.setNetworkMetered(j.networkMetered)
+	if j.allPaused {
+		tlfJournal.pause(journalPauseAll)
+	}
 
 	j.tlfJournals[tlfID] = tlfJournal
 	return nil
@@ -507,6 +519,45 @@ func (j *JournalServer) ResumeBackgroundWork(ctx context.Context, tlfID tlf.ID)
 		tlfID)
 }
 
+// PauseAllBackgroundWork pauses the background work of every
+// journal, including ones enabled later, until
+// ResumeAllBackgroundWork is called.  It's independent of the pauses
+// of individual journals.
+func (j *JournalServer) PauseAllBackgroundWork(ctx context.Context) {
+	j.log.CDebugf(ctx, "Signaling pause for all journals")
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.allPaused = true
+	for _, tlfJournal := range j.tlfJournals {
+		tlfJournal.pause(journalPauseAll)
+	}
+}
+
+// ResumeAllBackgroundWork undoes PauseAllBackgroundWork.  Journals
+// that were paused individually stay paused.
+func (j *JournalServer) ResumeAllBackgroundWork(ctx context.Context) {
+	j.log.CDebugf(ctx, "Signaling resume for all journals")
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.allPaused = false
+	for _, tlfJournal := range j.tlfJournals {
+		tlfJournal.resume(journalPauseAll)
+	}
+}
+
+// SetNetworkMetered tells the journals whether the current network
+// connection is metered.  Idle journals only trickle flush their
+// backlogs on unmetered networks.
+func (j *JournalServer) SetNetworkMetered(ctx context.Context, metered bool) {
+	j.log.CDebugf(ctx, "Setting network metered to %t", metered)
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.networkMetered = metered
+	for _, tlfJournal := range j.tlfJournals {
+		tlfJournal.setNetworkMetered(metered)
+	}
+}
+
 // Flush flushes the write journal for the given TLF.
 func (j *JournalServer) Flush(ctx context.Context, tlfID tlf.ID) (err error) {
 	j.log.CDebugf(ctx, "Flushing journal for %s", tlfID)
@@ -623,11 +674,14 @@ func (j *JournalServer) Status(
 		CurrentVerifyingKey: j.currentVerifyingKey,
 		EnableAuto:          enableAuto,
 		EnableAutoSetByUser: enableAutoSetByUser,
+		NetworkMetered:      j.networkMetered,
+		AllPaused:           j.allPaused,
 		JournalCount:        len(tlfIDs),
 		StoredBytes:         totalStoredBytes,
 		StoredFiles:         totalStoredFiles,
 		UnflushedBytes:      totalUnflushedBytes,
 		DiskLimiterStatus:   j.config.DiskLimiter().getStatus(),
+		FlushScheduler:      j.flushScheduler.getStatus(),
 	}, tlfIDs
 }
 
@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -540,6 +541,71 @@ func TestBasicCRNoConflictWithUnembeddedBlockChanges(t *testing.T) {
 	testBasicCRNoConflict(t, true)
 }
 
+// Tests that conflict resolution adds up the hard links made to the
+// same file on both branches.
+func TestCRLinkCounts(t *testing.T) {
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := userName1.String() + "," + userName2.String()
+
+	// user1 creates a file in a shared dir
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	fileNode1, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, fileNode1)
+	require.NoError(t, err)
+
+	// look it up on user2
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	fileNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+
+	// disable updates on user 2
+	c, err := DisableUpdatesForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = DisableCRForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+
+	// Each user links the file under a different name.
+	_, err = kbfsOps1.Link(ctx, fileNode1, rootNode1, "b")
+	require.NoError(t, err)
+	_, err = kbfsOps2.Link(ctx, fileNode2, rootNode2, "c")
+	require.NoError(t, err)
+
+	// re-enable updates, and wait for CR to complete
+	c <- struct{}{}
+	err = RestartCRForTesting(
+		BackgroundContextWithCancellationDelayer(), config2,
+		rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServerForTesting(ctx, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps1.SyncFromServerForTesting(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	for _, kbfsOps := range []KBFSOps{kbfsOps1, kbfsOps2} {
+		rootNode := rootNode1
+		if kbfsOps == kbfsOps2 {
+			rootNode = rootNode2
+		}
+		children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+		require.NoError(t, err)
+		require.Len(t, children, 3)
+		for _, child := range []string{"a", "b", "c"} {
+			require.Equal(t, uint32(3), children[child].Nlink, child)
+		}
+	}
+}
+
 type registerForUpdateRecord struct {
 	id       tlf.ID
 	currHead MetadataRevision
@@ -1665,3 +1731,186 @@ func TestUnmergedPutAfterCanceledUnmergedPut(t *testing.T) {
 		assert.True(t, ok)
 	}
 }
+
+// Test that unstaging saves the unmerged view of the changed files to
+// local disk before throwing it away.
+func TestUnstageSavesUnmergedState(t *testing.T) {
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+	storageRoot, err := ioutil.TempDir(os.TempDir(), "cr_saved_state")
+	require.NoError(t, err)
+	defer ioutil.RemoveAll(storageRoot)
+	config1.storageRoot = storageRoot
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := userName1.String() + "," + userName2.String()
+
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	fileNode1, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	fb := rootNode1.GetFolderBranch()
+	_, err = DisableUpdatesForTesting(config1, fb)
+	require.NoError(t, err)
+	DisableCRForTesting(config1, fb)
+
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	fileNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	err = kbfsOps2.Write(ctx, fileNode2, []byte{2}, 0)
+	require.NoError(t, err)
+	err = kbfsOps2.Sync(ctx, fileNode2)
+	require.NoError(t, err)
+
+	// User 1's conflicting write makes it unmerged.
+	data1 := []byte("unmerged")
+	err = kbfsOps1.Write(ctx, fileNode1, data1, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, fileNode1)
+	require.NoError(t, err)
+	_, err = kbfsOps1.CreateLink(ctx, rootNode1, "b", "a")
+	require.NoError(t, err)
+
+	err = kbfsOps1.UnstageForTesting(ctx, fb)
+	require.NoError(t, err)
+
+	states, err := ListCRSavedStates(storageRoot)
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	state := states[0]
+	require.Equal(t, fb.Tlf, state.TlfID)
+	require.Equal(t, int64(len(data1)), state.Bytes)
+	require.Equal(t, []CRSavedFile{
+		{Path: "a", Type: File, Size: uint64(len(data1))},
+		{Path: "b", Type: Sym, SymPath: "a"},
+	}, state.Files)
+	saved, err := ioutil.ReadFile(
+		filepath.Join(CRSavedStatePath(storageRoot, state.ID), "a"))
+	require.NoError(t, err)
+	require.Equal(t, data1, saved)
+
+	err = DeleteCRSavedState(storageRoot, state.ID)
+	require.NoError(t, err)
+	states, err = ListCRSavedStates(storageRoot)
+	require.NoError(t, err)
+	require.Len(t, states, 0)
+}
+
+// Tests that conflicting writes to files are resolved according to
+// the conflict strategy set for their path.
+func TestCRConflictStrategies(t *testing.T) {
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	storageRoot, err := ioutil.TempDir(os.TempDir(), "cr_strategies")
+	require.NoError(t, err)
+	defer ioutil.RemoveAll(storageRoot)
+	config2.storageRoot = storageRoot
+
+	name := userName1.String() + "," + userName2.String()
+	dirNames := []string{"manual", "ours", "theirs", "merge"}
+
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	dirs1 := make(map[string]Node)
+	files1 := make(map[string]Node)
+	for _, dirName := range dirNames {
+		dirs1[dirName], _, err = kbfsOps1.CreateDir(ctx, rootNode1, dirName)
+		require.NoError(t, err)
+		files1[dirName], _, err = kbfsOps1.CreateFile(
+			ctx, dirs1[dirName], "f", false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps1.Write(ctx, files1[dirName], []byte("0123456789"), 0)
+		require.NoError(t, err)
+		err = kbfsOps1.Sync(ctx, files1[dirName])
+		require.NoError(t, err)
+	}
+
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	fb := rootNode2.GetFolderBranch()
+	strategies := map[string]ConflictStrategy{
+		"ours":   ConflictStrategyLocal,
+		"theirs": ConflictStrategyRemote,
+		"merge":  ConflictStrategyMerge,
+	}
+	for dirName, strategy := range strategies {
+		err = kbfsOps2.SetConflictStrategy(ctx, fb, "/"+dirName+"/", strategy)
+		require.NoError(t, err)
+	}
+	err = kbfsOps2.SetConflictStrategy(ctx, fb, "../x", ConflictStrategyLocal)
+	require.IsType(t, InvalidConflictStrategyPrefixError{}, err)
+	rules, err := kbfsOps2.GetConflictStrategies(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, []ConflictStrategyRule{
+		{"merge", ConflictStrategyMerge},
+		{"ours", ConflictStrategyLocal},
+		{"theirs", ConflictStrategyRemote},
+	}, rules)
+
+	files2 := make(map[string]Node)
+	dirs2 := make(map[string]Node)
+	for _, dirName := range dirNames {
+		dirs2[dirName], _, err = kbfsOps2.Lookup(ctx, rootNode2, dirName)
+		require.NoError(t, err)
+		files2[dirName], _, err = kbfsOps2.Lookup(ctx, dirs2[dirName], "f")
+		require.NoError(t, err)
+	}
+
+	c, err := DisableUpdatesForTesting(config2, fb)
+	require.NoError(t, err)
+	err = DisableCRForTesting(config2, fb)
+	require.NoError(t, err)
+
+	// User 1 writes the start of each file, and user 2 writes the
+	// middle.
+	for _, dirName := range dirNames {
+		err = kbfsOps1.Write(ctx, files1[dirName], []byte("AA"), 0)
+		require.NoError(t, err)
+		err = kbfsOps1.Sync(ctx, files1[dirName])
+		require.NoError(t, err)
+		err = kbfsOps2.Write(ctx, files2[dirName], []byte("BB"), 5)
+		require.NoError(t, err)
+		err = kbfsOps2.Sync(ctx, files2[dirName])
+		require.NoError(t, err)
+	}
+
+	c <- struct{}{}
+	err = RestartCRForTesting(
+		BackgroundContextWithCancellationDelayer(), config2, fb)
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServerForTesting(ctx, fb)
+	require.NoError(t, err)
+	ops := kbfsOps2.(*KBFSOpsStandard).getOpsNoAdd(fb)
+	err = ops.cr.mergeGroup.Wait(ctx)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncFromServerForTesting(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	expected := map[string]string{
+		"ours":   "01234BB789",
+		"theirs": "AA23456789",
+		"merge":  "AA234BB789",
+	}
+	for _, dirName := range dirNames {
+		children, err := kbfsOps1.GetDirChildren(ctx, dirs1[dirName])
+		require.NoError(t, err)
+		if dirName == "manual" {
+			require.Len(t, children, 2)
+			continue
+		}
+		require.Len(t, children, 1, dirName)
+
+		buf := make([]byte, 10)
+		n, err := kbfsOps1.Read(ctx, files1[dirName], buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, expected[dirName], string(buf[:n]), dirName)
+	}
+}
@@ -11,11 +11,58 @@ import (
 	"time"
 
 	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
 	"golang.org/x/net/context"
 )
 
+// How often to check for folderBranchOps that have been idle for
+// longer than Config.TLFIdleTimeout().
+const tlfIdleCheckPeriod = 5 * time.Minute
+
+// CtxKBFSOpsTagKey is the type used for unique context tags within
+// KBFSOpsStandard.
+type CtxKBFSOpsTagKey int
+
+const (
+	// CtxKBFSOpsEvictIDKey is the type of the tag for unique
+	// operation IDs used while evicting idle folders.
+	CtxKBFSOpsEvictIDKey CtxKBFSOpsTagKey = iota
+	// CtxKBFSOpsFavHeadsIDKey is the type of the tag for unique
+	// operation IDs used while fetching favorite heads at startup.
+	CtxKBFSOpsFavHeadsIDKey
+	// CtxKBFSOpsBackgroundWorkIDKey is the type of the tag for
+	// unique operation IDs used while polling the platform state.
+	CtxKBFSOpsBackgroundWorkIDKey
+	// CtxKBFSOpsLowDiskSpaceIDKey is the type of the tag for unique
+	// operation IDs used while checking the free disk space.
+	CtxKBFSOpsLowDiskSpaceIDKey
+	// CtxKBFSOpsSettingsIDKey is the type of the tag for unique
+	// operation IDs used while serving settings requests.
+	CtxKBFSOpsSettingsIDKey
+)
+
+// CtxKBFSOpsEvictOpID is the display name for the unique operation
+// ID tag used while evicting idle folders.
+const CtxKBFSOpsEvictOpID = "KBFSOPSEVICTID"
+
+// CtxKBFSOpsFavHeadsOpID is the display name for the unique operation
+// ID tag used while fetching favorite heads at startup.
+const CtxKBFSOpsFavHeadsOpID = "KBFSOPSFAVHEADSID"
+
+// CtxKBFSOpsBackgroundWorkOpID is the display name for the unique
+// operation ID tag used while polling the platform state.
+const CtxKBFSOpsBackgroundWorkOpID = "KBFSOPSBGWORKID"
+
+// CtxKBFSOpsLowDiskSpaceOpID is the display name for the unique
+// operation ID tag used while checking the free disk space.
+const CtxKBFSOpsLowDiskSpaceOpID = "KBFSOPSLOWDISKID"
+
+// CtxKBFSOpsSettingsOpID is the display name for the unique operation
+// ID tag used while serving settings requests.
+const CtxKBFSOpsSettingsOpID = "KBFSOPSSETTINGSID"
+
 // KBFSOpsStandard implements the KBFSOps interface, and is go-routine
 // safe by forwarding requests to individual per-folder-branch
 // handlers that are go-routine-safe.
@@ -32,11 +79,72 @@ type KBFSOpsStandard struct {
 	// Closing this channel will shutdown the reidentification
 	// watcher.
 	reIdentifyControlChan chan chan<- struct{}
+	// evictIdleShutdownChan is closed to shut down the background
+	// goroutine that evicts idle folderBranchOps.
+	evictIdleShutdownChan chan struct{}
 
 	favs *Favorites
 
+	// mdUpdates multiplexes the MD update registrations of all
+	// the folders.
+	mdUpdates *mdUpdateMultiplexer
+
 	currentStatus kbfsCurrentStatus
 	quotaUsage    *EventuallyConsistentQuotaUsage
+
+	// crossTLFMoves holds the moves between TLFs that are in
+	// progress, so their progress can be reported in the status.
+	crossTLFMovesLock sync.Mutex
+	crossTLFMoves     map[*crossTLFMove]bool
+
+	// favHeadFetch is the progress of the background fetch of
+	// favorite heads, or nil if none is running.
+	favHeadFetchLock   sync.Mutex
+	favHeadFetch       *FavoriteHeadFetchStatus
+	favHeadFetchCancel context.CancelFunc
+
+	// recovery tracks which folders have unsynced writes, for the
+	// report after an unclean shutdown.  It's nil if tracking is
+	// off.
+	recoveryLock sync.Mutex
+	recovery     *recoveryTracker
+
+	// settingsServer serves the runtime settings to other local
+	// processes.  It's nil if they aren't served.
+	settingsLock   sync.Mutex
+	settingsServer *settingsServer
+
+	// usage attributes the traffic of the server wrappers made by
+	// Init to TLFs, for the status.
+	usage *tlfUsageTracker
+
+	// sessionUID is the user that the per-user state (private
+	// folders, quota usage) was loaded for.  It isn't cleared on
+	// logout, so that logging in as someone else still counts as
+	// a switch.
+	sessionLock sync.Mutex
+	sessionUID  keybase1.UID
+	// revoked is set once this device is revoked, until the next
+	// login.
+	revoked *DeviceRevokedError
+
+	// bgWork pauses journal flushing and prefetching at the
+	// user's request, or while the platform hooks say so.
+	bgWork *backgroundWorkPauser
+	// bandwidth holds block server traffic to the caps for the
+	// network type the platform hooks report.
+	bandwidth *bandwidthLimiter
+	// platformPollShutdownChan is closed to shut down the
+	// background goroutine that polls the platform hooks.
+	platformPollShutdownChan chan struct{}
+
+	// lowDiskSpace is whether the last check found the free disk
+	// space below the disk limiter's minimum.
+	lowDiskSpaceLock sync.Mutex
+	lowDiskSpace     bool
+	// lowDiskSpaceShutdownChan is closed to shut down the
+	// background goroutine that checks the free disk space.
+	lowDiskSpaceShutdownChan chan struct{}
 }
 
 var _ KBFSOps = (*KBFSOpsStandard)(nil)
@@ -51,11 +159,23 @@ func NewKBFSOpsStandard(config Config) *KBFSOpsStandard {
 		ops:                   make(map[FolderBranch]*folderBranchOps),
 		opsByFav:              make(map[Favorite]*folderBranchOps),
 		reIdentifyControlChan: make(chan chan<- struct{}),
-		favs:       NewFavorites(config),
-		quotaUsage: NewEventuallyConsistentQuotaUsage(config, "KBFSOps"),
+		evictIdleShutdownChan: make(chan struct{}),
+		favs:                  NewFavorites(config),
+		mdUpdates:             newMDUpdateMultiplexer(config),
+		quotaUsage:            NewEventuallyConsistentQuotaUsage(config, "KBFSOps"),
+		crossTLFMoves:         make(map[*crossTLFMove]bool),
+		usage:                 newTLFUsageTracker(),
+		bgWork:                newBackgroundWorkPauser(config, log),
+		bandwidth:             newBandwidthLimiter(log),
+
+		platformPollShutdownChan: make(chan struct{}),
+		lowDiskSpaceShutdownChan: make(chan struct{}),
 	}
 	kops.currentStatus.Init()
 	go kops.markForReIdentifyIfNeededLoop()
+	go kops.evictIdleOpsLoop()
+	go kops.pollPlatformLoop()
+	go kops.lowDiskSpaceLoop()
 	return kops
 }
 
@@ -101,20 +221,128 @@ func (fs *KBFSOpsStandard) markForReIdentifyIfNeeded(
 	}
 }
 
+func (fs *KBFSOpsStandard) evictIdleOpsLoop() {
+	ticker := fs.config.Clock().NewTicker(tlfIdleCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+		case <-fs.evictIdleShutdownChan:
+			return
+		}
+		idleTimeout := fs.config.TLFIdleTimeout()
+		if idleTimeout <= 0 {
+			continue
+		}
+		ctx := ctxWithRandomIDReplayable(context.Background(),
+			CtxKBFSOpsEvictIDKey, CtxKBFSOpsEvictOpID, fs.log)
+		fs.evictIdleOps(ctx, fs.config.Clock().Now(), idleTimeout)
+	}
+}
+
+// pollPlatform applies the current platform state to the background
+// work and the bandwidth caps.
+func (fs *KBFSOpsStandard) pollPlatform(ctx context.Context) {
+	fs.bgWork.pollPlatform(ctx)
+	fs.bandwidth.setNetworkType(
+		ctx, fs.bgWork.getStatus().Platform.NetworkType)
+}
+
+func (fs *KBFSOpsStandard) pollPlatformLoop() {
+	ticker := fs.config.Clock().NewTicker(platformStatePollPeriod)
+	defer ticker.Stop()
+	for {
+		ctx := ctxWithRandomIDReplayable(context.Background(),
+			CtxKBFSOpsBackgroundWorkIDKey, CtxKBFSOpsBackgroundWorkOpID,
+			fs.log)
+		fs.pollPlatform(ctx)
+		select {
+		case <-ticker.C():
+		case <-fs.platformPollShutdownChan:
+			return
+		}
+	}
+}
+
+// evictIdleOps shuts down and forgets every folderBranchOps that
+// hasn't been accessed within `idleTimeout` of `now`, and that has no
+// outstanding local state.  The next access to an evicted folder
+// will transparently create a fresh folderBranchOps for it.
+func (fs *KBFSOpsStandard) evictIdleOps(
+	ctx context.Context, now time.Time, idleTimeout time.Duration) {
+	var toEvict []*folderBranchOps
+	func() {
+		fs.opsLock.Lock()
+		defer fs.opsLock.Unlock()
+		lState := makeFBOLockState()
+		for fb, ops := range fs.ops {
+			if !ops.isIdleAndEvictable(lState, now, idleTimeout) {
+				continue
+			}
+			// Only the favorites observer installed by
+			// getOpsByHandle may be registered; anyone else
+			// expects to keep hearing about changes.
+			numExternal := ops.observers.countIf(func(o Observer) bool {
+				_, isFav := o.(*kbfsOpsFavoriteObserver)
+				return !isFav
+			})
+			if numExternal > 0 {
+				continue
+			}
+			delete(fs.ops, fb)
+			for fav, favOps := range fs.opsByFav {
+				if favOps == ops {
+					delete(fs.opsByFav, fav)
+				}
+			}
+			toEvict = append(toEvict, ops)
+		}
+	}()
+
+	for _, ops := range toEvict {
+		fs.log.CDebugf(ctx, "Evicting idle folder %s", ops.folderBranch)
+		if err := ops.Shutdown(ctx); err != nil {
+			fs.log.CDebugf(ctx, "Couldn't shut down idle folder %s: %+v",
+				ops.folderBranch, err)
+		}
+	}
+}
+
 // Shutdown safely shuts down any background goroutines that may have
 // been launched by KBFSOpsStandard.
 func (fs *KBFSOpsStandard) Shutdown(ctx context.Context) error {
 	close(fs.reIdentifyControlChan)
+	close(fs.evictIdleShutdownChan)
+	close(fs.platformPollShutdownChan)
+	close(fs.lowDiskSpaceShutdownChan)
+	func() {
+		fs.favHeadFetchLock.Lock()
+		defer fs.favHeadFetchLock.Unlock()
+		if fs.favHeadFetchCancel != nil {
+			fs.favHeadFetchCancel()
+		}
+	}()
 	var errors []error
 	if err := fs.favs.Shutdown(); err != nil {
 		errors = append(errors, err)
 	}
 	for _, ops := range fs.ops {
+		// Write any acknowledged setattrs first; folders shut down
+		// any other way (like on a user switch) just drop them.
+		if err := ops.flushPendingAttrs(ctx, nil); err != nil {
+			fs.log.CWarningf(ctx, "Couldn't write pending setattrs "+
+				"for %s: %+v", ops.folderBranch, err)
+		}
 		if err := ops.Shutdown(ctx); err != nil {
 			errors = append(errors, err)
 			// Continue on and try to shut down the other FBOs.
 		}
 	}
+	fs.mdUpdates.Shutdown()
+	if rt := fs.getRecoveryTracker(); rt != nil {
+		rt.shutdown(ctx)
+	}
+	fs.stopServingSettings()
 	if len(errors) == 1 {
 		return errors[0]
 	} else if len(errors) > 1 {
@@ -162,6 +390,147 @@ func (fs *KBFSOpsStandard) ForceFastForward(ctx context.Context) {
 	}
 }
 
+// SetSessionUser implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetSessionUser(
+	ctx context.Context, uid keybase1.UID) (switched bool) {
+	fs.sessionLock.Lock()
+	defer fs.sessionLock.Unlock()
+	if fs.revoked != nil {
+		// Any successful login must be from a device that's
+		// still provisioned.
+		fs.revoked = nil
+		fs.PushConnectionStatusChange(DeviceServiceName, nil)
+	}
+	prevUID := fs.sessionUID
+	fs.sessionUID = uid
+	if prevUID == keybase1.UID("") || prevUID == uid {
+		return false
+	}
+
+	fs.log.CDebugf(ctx, "Switching user from %s to %s", prevUID, uid)
+	fs.dropPerUserStateLocked(ctx)
+	return true
+}
+
+// DeviceRevoked implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) DeviceRevoked(
+	ctx context.Context, err DeviceRevokedError) {
+	func() {
+		fs.sessionLock.Lock()
+		defer fs.sessionLock.Unlock()
+		fs.revoked = &err
+		fs.log.CWarningf(ctx, "%v", err)
+		fs.dropPerUserStateLocked(ctx)
+	}()
+	fs.PushConnectionStatusChange(DeviceServiceName, err)
+}
+
+// PauseBackgroundWork implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) PauseBackgroundWork(ctx context.Context) {
+	fs.bgWork.setUserPaused(ctx, true)
+}
+
+// ResumeBackgroundWork implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) ResumeBackgroundWork(ctx context.Context) {
+	fs.bgWork.setUserPaused(ctx, false)
+}
+
+// OverrideBandwidthCaps implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) OverrideBandwidthCaps(
+	ctx context.Context, caps *BandwidthCaps) {
+	fs.bandwidth.setOverride(ctx, caps)
+}
+
+// checkDeviceNotRevoked returns a DeviceRevokedError if this device
+// has been revoked since the last login.
+func (fs *KBFSOpsStandard) checkDeviceNotRevoked() error {
+	fs.sessionLock.Lock()
+	defer fs.sessionLock.Unlock()
+	if fs.revoked != nil {
+		return *fs.revoked
+	}
+	return nil
+}
+
+// dropPerUserStateLocked stops the favorite head fetch, shuts down
+// all private folders and drops the cached quota usage.
+// fs.sessionLock must be held by the caller.
+func (fs *KBFSOpsStandard) dropPerUserStateLocked(ctx context.Context) {
+	func() {
+		fs.favHeadFetchLock.Lock()
+		defer fs.favHeadFetchLock.Unlock()
+		if fs.favHeadFetchCancel != nil {
+			fs.favHeadFetchCancel()
+		}
+	}()
+	fs.shutdownPrivateOps(ctx)
+	fs.quotaUsage.clear()
+}
+
+// noteRevisionViewed tells the given TLF's master branch, if this
+// device has it open, that the given revision is being read through
+// an archived view, so the blocks it needs stay in the disk cache.
+func (fs *KBFSOpsStandard) noteRevisionViewed(
+	tlfID tlf.ID, rev MetadataRevision) {
+	fs.opsLock.RLock()
+	ops, ok := fs.ops[FolderBranch{tlfID, MasterBranch}]
+	fs.opsLock.RUnlock()
+	if ok {
+		ops.fbm.noteRevisionViewed(rev)
+	}
+}
+
+// shutdownPrivateOps shuts down and forgets every private
+// folderBranchOps, even ones that are in use, since their nodes,
+// blocks and edit histories all came from the previous user's keys.
+// The next access to any of those folders creates a fresh
+// folderBranchOps, as the user who's logged in by then.
+func (fs *KBFSOpsStandard) shutdownPrivateOps(ctx context.Context) {
+	var toShutdown []*folderBranchOps
+	func() {
+		fs.opsLock.RLock()
+		defer fs.opsLock.RUnlock()
+		for fb, ops := range fs.ops {
+			if !fb.Tlf.IsPublic() {
+				toShutdown = append(toShutdown, ops)
+			}
+		}
+	}()
+
+	// Shut down before forgetting, so that anything looking up the
+	// folder in the meantime (like the state checker in tests)
+	// gets the old ops rather than making new ones.
+	lState := makeFBOLockState()
+	for _, ops := range toShutdown {
+		if ops.blocks.GetState(lState) != cleanState {
+			fs.log.CWarningf(ctx, "Dropping unsynced writes in %s "+
+				"of the previous user", ops.folderBranch)
+		}
+		if err := ops.Shutdown(ctx); err != nil {
+			fs.log.CDebugf(ctx, "Couldn't shut down private folder %s: %+v",
+				ops.folderBranch, err)
+		}
+	}
+
+	fs.opsLock.Lock()
+	defer fs.opsLock.Unlock()
+	for _, ops := range toShutdown {
+		if fs.ops[ops.folderBranch] == ops {
+			delete(fs.ops, ops.folderBranch)
+		}
+		for fav, favOps := range fs.opsByFav {
+			if favOps == ops {
+				delete(fs.opsByFav, fav)
+			}
+		}
+	}
+}
+
 // GetFavorites implements the KBFSOps interface for
 // KBFSOpsStandard.
 func (fs *KBFSOpsStandard) GetFavorites(ctx context.Context) (
@@ -228,6 +597,26 @@ func (fs *KBFSOpsStandard) DeleteFavorite(ctx context.Context,
 	return nil
 }
 
+// DeleteFavorites implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) DeleteFavorites(ctx context.Context,
+	favs []Favorite) error {
+	kbpki := fs.config.KBPKI()
+	if _, err := kbpki.GetCurrentSession(ctx); err != nil {
+		// Can't unfavorite while not logged in.
+		return nil
+	}
+	return fs.favs.DeleteAll(ctx, favs)
+}
+
+// GetFavoriteCleanupSuggestions implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetFavoriteCleanupSuggestions(
+	ctx context.Context, params FavoriteCleanupParams) (
+	[]FavoriteSuggestion, error) {
+	return fs.favs.Suggest(ctx, params)
+}
+
 func (fs *KBFSOpsStandard) getOpsNoAdd(fb FolderBranch) *folderBranchOps {
 	if fb == (FolderBranch{}) {
 		panic("zero FolderBranch in getOps")
@@ -235,6 +624,9 @@ func (fs *KBFSOpsStandard) getOpsNoAdd(fb FolderBranch) *folderBranchOps {
 
 	fs.opsLock.RLock()
 	if ops, ok := fs.ops[fb]; ok {
+		// Mark it while still holding the lock, so that
+		// evictIdleOps can't evict it before the caller uses it.
+		ops.markAccessed(fs.config.Clock().Now())
 		fs.opsLock.RUnlock()
 		return ops
 	}
@@ -248,6 +640,11 @@ func (fs *KBFSOpsStandard) getOpsNoAdd(fb FolderBranch) *folderBranchOps {
 		// TODO: add some interface for specifying the type of the
 		// branch; for now assume online and read-write.
 		ops = newFolderBranchOps(fs.config, fb, standard)
+		ops.updateRegisterer = fs.mdUpdates
+		ops.deviceRevoked = fs.checkDeviceNotRevoked
+		ops.status.setDirtyListener(func(dirty bool) {
+			fs.setTlfDirty(fb.Tlf, dirty)
+		})
 		fs.ops[fb] = ops
 	}
 	return ops
@@ -568,6 +965,17 @@ func (fs *KBFSOpsStandard) CreateLink(
 	return ops.CreateLink(ctx, dir, fromName, toPath)
 }
 
+// Link implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) Link(
+	ctx context.Context, file Node, dir Node, name string) (
+	EntryInfo, error) {
+	if file.GetFolderBranch() != dir.GetFolderBranch() {
+		return EntryInfo{}, LinkAcrossTLFsError{}
+	}
+	ops := fs.getOpsByNode(ctx, dir)
+	return ops.Link(ctx, file, dir, name)
+}
+
 // RemoveDir implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) RemoveDir(
 	ctx context.Context, dir Node, name string) error {
@@ -582,6 +990,13 @@ func (fs *KBFSOpsStandard) RemoveEntry(
 	return ops.RemoveEntry(ctx, dir, name)
 }
 
+// RemoveDirTree implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) RemoveDirTree(
+	ctx context.Context, dir Node, dirName string) error {
+	ops := fs.getOpsByNode(ctx, dir)
+	return ops.RemoveDirTree(ctx, dir, dirName)
+}
+
 // Rename implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Rename(
 	ctx context.Context, oldParent Node, oldName string, newParent Node,
@@ -589,8 +1004,12 @@ func (fs *KBFSOpsStandard) Rename(
 	oldFB := oldParent.GetFolderBranch()
 	newFB := newParent.GetFolderBranch()
 
-	// only works for nodes within the same topdir
-	if oldFB != newFB {
+	// Moving between two different TLFs means copying the data,
+	// since it has to be re-encrypted.  Other renames only work
+	// for nodes within the same topdir.
+	if oldFB.Tlf != newFB.Tlf {
+		return fs.MoveAcrossTLFs(ctx, oldParent, oldName, newParent, newName)
+	} else if oldFB != newFB {
 		return RenameAcrossDirsError{}
 	}
 
@@ -640,25 +1059,240 @@ func (fs *KBFSOpsStandard) Sync(ctx context.Context, file Node) error {
 	return ops.Sync(ctx, file)
 }
 
+// SetFsyncDurability implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetFsyncDurability(ctx context.Context,
+	folderBranch FolderBranch, durability FsyncDurability) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.SetFsyncDurability(ctx, folderBranch, durability)
+}
+
+// SetFSEventStreaming implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetFSEventStreaming(ctx context.Context,
+	folderBranch FolderBranch, enabled bool) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.SetFSEventStreaming(ctx, folderBranch, enabled)
+}
+
+// GetHistoryRetention implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetHistoryRetention(ctx context.Context,
+	folderBranch FolderBranch) (HistoryRetention, error) {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetHistoryRetention(ctx, folderBranch)
+}
+
+// SetHistoryRetention implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetHistoryRetention(ctx context.Context,
+	folderBranch FolderBranch, retention HistoryRetention) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.SetHistoryRetention(ctx, folderBranch, retention)
+}
+
+// GetPinnedRevisions implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetPinnedRevisions(ctx context.Context,
+	folderBranch FolderBranch) ([]MetadataRevision, error) {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetPinnedRevisions(ctx, folderBranch)
+}
+
+// PinRevision implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) PinRevision(ctx context.Context,
+	folderBranch FolderBranch, rev MetadataRevision) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.PinRevision(ctx, folderBranch, rev)
+}
+
+// UnpinRevision implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) UnpinRevision(ctx context.Context,
+	folderBranch FolderBranch, rev MetadataRevision) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.UnpinRevision(ctx, folderBranch, rev)
+}
+
+// GetSnapshots implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetSnapshots(ctx context.Context,
+	folderBranch FolderBranch) ([]TLFSnapshot, error) {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetSnapshots(ctx, folderBranch)
+}
+
+// CreateSnapshot implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) CreateSnapshot(ctx context.Context,
+	folderBranch FolderBranch, name string, rev MetadataRevision) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.CreateSnapshot(ctx, folderBranch, name, rev)
+}
+
+// DeleteSnapshot implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) DeleteSnapshot(ctx context.Context,
+	folderBranch FolderBranch, name string) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.DeleteSnapshot(ctx, folderBranch, name)
+}
+
+// RestoreFile implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) RestoreFile(ctx context.Context, dir Node,
+	name string, rev MetadataRevision) (Node, error) {
+	ops := fs.getOpsByNode(ctx, dir)
+	return ops.RestoreFile(ctx, dir, name, rev)
+}
+
+// GetConflictStrategies implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetConflictStrategies(ctx context.Context,
+	folderBranch FolderBranch) ([]ConflictStrategyRule, error) {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetConflictStrategies(ctx, folderBranch)
+}
+
+// SetConflictStrategy implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetConflictStrategy(ctx context.Context,
+	folderBranch FolderBranch, prefix string,
+	strategy ConflictStrategy) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.SetConflictStrategy(ctx, folderBranch, prefix, strategy)
+}
+
+// ClearConflictStrategy implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) ClearConflictStrategy(ctx context.Context,
+	folderBranch FolderBranch, prefix string) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.ClearConflictStrategy(ctx, folderBranch, prefix)
+}
+
+// ForceQuotaReclamation implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) ForceQuotaReclamation(ctx context.Context,
+	folderBranch FolderBranch) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.ForceQuotaReclamation(ctx, folderBranch)
+}
+
+// SetQuotaReclamationPaused implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetQuotaReclamationPaused(ctx context.Context,
+	folderBranch FolderBranch, paused bool) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.SetQuotaReclamationPaused(ctx, folderBranch, paused)
+}
+
+// SetTlfSynced implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetTlfSynced(ctx context.Context,
+	folderBranch FolderBranch, synced bool) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.SetTlfSynced(ctx, folderBranch, synced)
+}
+
+// SetQuotaReclamationMinHeadAge implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetQuotaReclamationMinHeadAge(
+	ctx context.Context, folderBranch FolderBranch,
+	age time.Duration) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.SetQuotaReclamationMinHeadAge(ctx, folderBranch, age)
+}
+
+// SetTlfFrozen implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetTlfFrozen(ctx context.Context,
+	folderBranch FolderBranch, frozen bool) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.SetTlfFrozen(ctx, folderBranch, frozen)
+}
+
+// SetTlfAppendOnly implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetTlfAppendOnly(ctx context.Context,
+	folderBranch FolderBranch, appendOnly bool) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.SetTlfAppendOnly(ctx, folderBranch, appendOnly)
+}
+
+// SetTlfNameNormalization implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) SetTlfNameNormalization(ctx context.Context,
+	folderBranch FolderBranch, n NameNormalization) error {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.SetTlfNameNormalization(ctx, folderBranch, n)
+}
+
+// CreateTLFFrom implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) CreateTLFFrom(
+	ctx context.Context, h *TlfHandle, src Node) (
+	node Node, ei EntryInfo, err error) {
+	fs.log.CDebugf(ctx, "CreateTLFFrom(%s, %s)",
+		h.GetCanonicalPath(), getNodeIDStr(src))
+	defer func() { fs.deferLog.CDebugf(ctx, "Done: %+v", err) }()
+
+	srcOps := fs.getOpsByNode(ctx, src)
+	srcPath, err := srcOps.pathFromNodeForRead(src)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	srcEI, err := srcOps.Stat(ctx, src)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	// Blocks are encrypted with their TLF's keys, so they can't be
+	// shared with the new TLF; copy the contents instead.
+	tmpl, err := readTLFTemplate(
+		ctx, fs, src, srcPath, srcEI, maxTLFTemplateBytes)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+
+	root, _, err := fs.GetOrCreateRootNode(ctx, h, MasterBranch)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	ops := fs.getOpsByNode(ctx, root)
+	err = ops.initFromTemplate(ctx, root, tmpl)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	node, ei, _, err = ops.getRootNode(ctx)
+	return node, ei, err
+}
+
 // FolderStatus implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) FolderStatus(
 	ctx context.Context, folderBranch FolderBranch) (
 	FolderBranchStatus, <-chan StatusUpdate, error) {
 	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
-	return ops.FolderStatus(ctx, folderBranch)
+	status, ch, err := ops.FolderStatus(ctx, folderBranch)
+	if err != nil {
+		return FolderBranchStatus{}, nil, err
+	}
+	status.Usage = fs.usage.getStatus(folderBranch.Tlf)
+	return status, ch, nil
 }
 
 // Status implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Status(ctx context.Context) (
 	KBFSStatus, <-chan StatusUpdate, error) {
 	session, err := fs.config.KBPKI().GetCurrentSession(ctx)
+	if revokedErr := fs.checkDeviceNotRevoked(); err == nil &&
+		revokedErr != nil {
+		// A revoked device acts as if nobody is logged in, so
+		// that mounts stop showing the private folders; the
+		// reason shows up in FailingServices.
+		session = SessionInfo{}
+	}
 	var usageBytes int64 = -1
 	var limitBytes int64 = -1
 	// Don't request the quota info until we're sure we've
 	// authenticated with our password.  TODO: fix this in the
 	// service/GUI by handling multiple simultaneous passphrase
 	// requests at once.
-	if err == nil && fs.config.MDServer().IsConnected() {
+	if err == nil && session.UID != keybase1.UID("") &&
+		fs.config.MDServer().IsConnected() {
 		var quErr error
 		usageBytes, limitBytes, quErr = fs.quotaUsage.Get(ctx, 0)
 		if quErr != nil {
@@ -680,13 +1314,32 @@ func (fs *KBFSOpsStandard) Status(ctx context.Context) (
 		}
 	}
 
+	var diskCacheStatus map[string]DiskBlockCachePartitionStatus
+	var diskCacheEvictions []DiskBlockCacheEviction
+	if dbc := fs.config.DiskBlockCache(); dbc != nil {
+		if partitioned, ok := dbc.(*DiskBlockCachePartitioned); ok {
+			diskCacheStatus = partitioned.getStatus()
+		}
+		diskCacheEvictions = dbc.RecentEvictions()
+	}
+
 	return KBFSStatus{
-		CurrentUser:     session.Name.String(),
-		IsConnected:     fs.config.MDServer().IsConnected(),
-		UsageBytes:      usageBytes,
-		LimitBytes:      limitBytes,
-		FailingServices: failures,
-		JournalServer:   jServerStatus,
+		CurrentUser:        session.Name.String(),
+		IsConnected:        fs.config.MDServer().IsConnected(),
+		UsageBytes:         usageBytes,
+		LimitBytes:         limitBytes,
+		FailingServices:    failures,
+		JournalServer:      jServerStatus,
+		CrossTLFMoves:      fs.getCrossTLFMoveStatuses(),
+		FavoriteHeads:      fs.getFavoriteHeadFetchStatus(),
+		Recovery:           fs.getRecoveryReport(),
+		TLFUsage:           fs.usage.getAllStatuses(),
+		DiskCache:          diskCacheStatus,
+		DiskCacheEvictions: diskCacheEvictions,
+		PendingRekeys:      fs.config.RekeyQueue().GetStatus(),
+		MerkleChecks:       fs.config.MDOps().GetMerkleCheckStatus(),
+		BackgroundWork:     fs.bgWork.getStatus(),
+		Bandwidth:          fs.bandwidth.getStatus(),
 	}, ch, err
 }
 
@@ -168,7 +168,7 @@ func kbfsTestShutdown(mockCtrl *gomock.Controller, config *ConfigMock,
 
 // kbfsOpsInitNoMocks returns a config that doesn't use any mocks. The
 // shutdown call is kbfsTestShutdownNoMocks.
-func kbfsOpsInitNoMocks(t *testing.T, users ...libkb.NormalizedUsername) (
+func kbfsOpsInitNoMocks(t testing.TB, users ...libkb.NormalizedUsername) (
 	*ConfigLocal, keybase1.UID, context.Context, context.CancelFunc) {
 	config := MakeTestConfigOrBust(t, users...)
 	config.SetRekeyWithPromptWaitTime(individualTestTimeout)
@@ -199,7 +199,7 @@ func kbfsOpsInitNoMocks(t *testing.T, users ...libkb.NormalizedUsername) (
 	return config, session.UID, ctx, cancel
 }
 
-func kbfsTestShutdownNoMocks(t *testing.T, config *ConfigLocal,
+func kbfsTestShutdownNoMocks(t testing.TB, config *ConfigLocal,
 	ctx context.Context, cancel context.CancelFunc) {
 	CheckConfigAndShutdown(ctx, t, config)
 	cancel()
@@ -475,6 +475,95 @@ func TestKBFSOpsGetRootNodeCacheIdentifyFail(t *testing.T) {
 	assert.False(t, fboIdentityDone(ops))
 }
 
+func TestKBFSOpsGetMDUpdatesStart(t *testing.T) {
+	mockCtrl, config, ctx, cancel := kbfsOpsInit(t, false)
+	defer kbfsTestShutdown(mockCtrl, config, ctx, cancel)
+
+	_, id, rmd := injectNewRMD(t, config)
+	ops := getOps(config, id)
+	lState := makeFBOLockState()
+
+	// Updates normally start right after the latest merged revision.
+	rmd.SetRevision(5)
+	ops.head = makeImmutableRMDForTest(t, config, rmd, fakeMdID(1))
+	ops.latestMergedRevision = 5
+	assert.Equal(t, MetadataRevision(6), ops.getMDUpdatesStart(lState))
+
+	// If updates were ignored while our own MDs were in the journal,
+	// the merged head is behind the latest merged revision, and the
+	// ignored updates have to be fetched again.
+	ops.latestMergedRevision = 8
+	assert.Equal(t, MetadataRevision(6), ops.getMDUpdatesStart(lState))
+
+	// An unmerged head doesn't say anything about the merged
+	// revisions that have been applied.
+	rmd.SetUnmerged()
+	ops.head = makeImmutableRMDForTest(t, config, rmd, fakeMdID(2))
+	assert.Equal(t, MetadataRevision(9), ops.getMDUpdatesStart(lState))
+}
+
+func TestKBFSOpsIdentifyPolicyAlways(t *testing.T) {
+	mockCtrl, config, ctx, cancel := kbfsOpsInit(t, false)
+	defer kbfsTestShutdown(mockCtrl, config, ctx, cancel)
+
+	_, id, rmd := injectNewRMD(t, config)
+	rmd.data.Dir.BlockPointer.ID = kbfsblock.FakeID(1)
+	rmd.data.Dir.Type = Dir
+
+	ops := getOps(config, id)
+	config.SetIdentifyPolicy(IdentifyPolicy{Private: IdentifyAlways})
+	kbpki := &identifyCountingKBPKI{KBPKI: config.KBPKI()}
+	config.SetKBPKI(kbpki)
+
+	// Every read identifies again.
+	lState := makeFBOLockState()
+	_, err := ops.getMDForReadLocked(ctx, lState, mdReadNeedIdentify)
+	require.NoError(t, err)
+	calls := kbpki.getIdentifyCalls()
+	require.NotZero(t, calls)
+	_, err = ops.getMDForReadLocked(ctx, lState, mdReadNeedIdentify)
+	require.NoError(t, err)
+	assert.Equal(t, 2*calls, kbpki.getIdentifyCalls())
+}
+
+func TestKBFSOpsIdentifyPolicyInBackground(t *testing.T) {
+	mockCtrl, config, ctx, cancel := kbfsOpsInit(t, false)
+	defer kbfsTestShutdown(mockCtrl, config, ctx, cancel)
+
+	_, id, rmd := injectNewRMD(t, config)
+	rmd.data.Dir.BlockPointer.ID = kbfsblock.FakeID(1)
+	rmd.data.Dir.Type = Dir
+
+	ops := getOps(config, id)
+	config.SetIdentifyPolicy(IdentifyPolicy{Private: IdentifyInBackground})
+	expectedErr := errors.New("Identify failure")
+	config.SetKBPKI(failIdentifyKBPKI{config.KBPKI(), expectedErr})
+	reported := make(chan struct{})
+	config.mockRep.EXPECT().ReportErr(gomock.Any(), gomock.Any(), false,
+		ReadMode, expectedErr).Do(func(context.Context, CanonicalTlfName,
+		bool, ErrorModeType, error) {
+		close(reported)
+	})
+
+	// The read doesn't wait for the failing identify.
+	lState := makeFBOLockState()
+	_, err := ops.getMDForReadLocked(ctx, lState, mdReadNeedIdentify)
+	require.NoError(t, err)
+
+	select {
+	case <-reported:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	for !fboIdentityDone(ops) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Now that it's done, it isn't run again.
+	_, err = ops.getMDForReadLocked(ctx, lState, mdReadNeedIdentify)
+	require.NoError(t, err)
+}
+
 func expectBlock(config *ConfigMock, kmd KeyMetadata, blockPtr BlockPointer, block Block, err error) {
 	config.mockBops.EXPECT().Get(gomock.Any(), kmdMatcher{kmd},
 		ptrMatcher{blockPtr}, gomock.Any(), gomock.Any()).
@@ -2792,48 +2881,6 @@ func TestRenameAcrossOtherPrefixSuccess(t *testing.T) {
 		append(blocks2, rootID, aID, dID, blocks1[2]), nil)
 }
 
-func TestRenameFailAcrossTopLevelFolders(t *testing.T) {
-	mockCtrl, config, ctx, cancel := kbfsOpsInit(t, false)
-	defer kbfsTestShutdown(mockCtrl, config, ctx, cancel)
-
-	id1 := tlf.FakeID(1, false)
-	h1 := parseTlfHandleOrBust(t, config, "alice,bob", false)
-	rmd1, err := makeInitialRootMetadata(config.MetadataVersion(), id1, h1)
-	require.NoError(t, err)
-
-	id2 := tlf.FakeID(2, false)
-	h2 := parseTlfHandleOrBust(t, config, "alice,bob,charlie", false)
-	rmd2, err := makeInitialRootMetadata(config.MetadataVersion(), id2, h2)
-	require.NoError(t, err)
-
-	uid1 := h2.ResolvedWriters()[0]
-	uid2 := h2.ResolvedWriters()[2]
-
-	rootID1 := kbfsblock.FakeID(41)
-	aID1 := kbfsblock.FakeID(42)
-	node1 := pathNode{makeBP(rootID1, rmd1, config, uid1), "p"}
-	aNode1 := pathNode{makeBP(aID1, rmd1, config, uid1), "a"}
-	p1 := path{FolderBranch{Tlf: id1}, []pathNode{node1, aNode1}}
-	ops1 := getOps(config, id1)
-	n1 := nodeFromPath(t, ops1, p1)
-
-	rootID2 := kbfsblock.FakeID(38)
-	aID2 := kbfsblock.FakeID(39)
-	node2 := pathNode{makeBP(rootID2, rmd2, config, uid2), "p"}
-	aNode2 := pathNode{makeBP(aID2, rmd2, config, uid2), "a"}
-	p2 := path{FolderBranch{Tlf: id2}, []pathNode{node2, aNode2}}
-	ops2 := getOps(config, id2)
-	n2 := nodeFromPath(t, ops2, p2)
-
-	expectedErr := RenameAcrossDirsError{}
-
-	if err := config.KBFSOps().Rename(ctx, n1, "b", n2, "c"); err == nil {
-		t.Errorf("Got no expected error on rename")
-	} else if err.Error() != expectedErr.Error() {
-		t.Errorf("Got unexpected error on rename: %+v", err)
-	}
-}
-
 func TestRenameFailAcrossBranches(t *testing.T) {
 	mockCtrl, config, ctx, cancel := kbfsOpsInit(t, false)
 	defer kbfsTestShutdown(mockCtrl, config, ctx, cancel)
@@ -5289,6 +5336,8 @@ func TestKBFSOpsBackgroundFlush(t *testing.T) {
 		observer)
 
 	// start the background flusher
+	config.mockClock.EXPECT().NewTicker(1 * time.Millisecond).Return(
+		wallClock{}.NewTicker(1 * time.Millisecond))
 	go ops.backgroundFlusher(1 * time.Millisecond)
 
 	// Make sure we get the notification
@@ -5691,3 +5740,1216 @@ func TestForceFastForwardOnEmptyTLF(t *testing.T) {
 		t.Fatalf("Couldn't wait for fast forward: %+v", err)
 	}
 }
+
+func TestKBFSOpsEvictIdleFolder(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	fb := FolderBranch{Tlf: tlf.FakeID(1, false), Branch: MasterBranch}
+	ops := kbfsOps.getOpsNoAdd(fb)
+	currOps := func() *folderBranchOps {
+		kbfsOps.opsLock.RLock()
+		defer kbfsOps.opsLock.RUnlock()
+		return kbfsOps.ops[fb]
+	}
+
+	// A freshly-used folder shouldn't be evicted.
+	now := config.Clock().Now()
+	idleTimeout := 1 * time.Hour
+	kbfsOps.evictIdleOps(ctx, now, idleTimeout)
+	if currOps() != ops {
+		t.Fatal("Folder was evicted before it became idle")
+	}
+
+	// An external observer keeps the folder alive.
+	obs := &kbfsOpsFavoriteObserver{}
+	testObs := &testIdleObserver{}
+	ops.RegisterForChanges(obs)
+	ops.RegisterForChanges(testObs)
+	kbfsOps.evictIdleOps(ctx, now.Add(idleTimeout), idleTimeout)
+	if currOps() != ops {
+		t.Fatal("Folder with an observer was evicted")
+	}
+
+	// Once only the favorites observer is left, it can be evicted.
+	ops.UnregisterFromChanges(testObs)
+	kbfsOps.evictIdleOps(ctx, now.Add(idleTimeout), idleTimeout)
+	if currOps() != nil {
+		t.Fatal("Idle folder wasn't evicted")
+	}
+
+	// The next access creates a new folder.
+	newOps := kbfsOps.getOpsNoAdd(fb)
+	if newOps == ops {
+		t.Fatal("Evicted folder was reused")
+	}
+}
+
+type testIdleObserver struct{}
+
+func (*testIdleObserver) LocalChange(_ context.Context, _ Node, _ WriteRange) {
+}
+
+func (*testIdleObserver) BatchChanges(_ context.Context, _ []NodeChange) {
+}
+
+func (*testIdleObserver) TlfHandleChange(_ context.Context, _ *TlfHandle) {
+}
+
+func TestKBFSOpsCoalesceSmallWrites(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	config.SetWriteCoalescingBytes(1024)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		data := []byte{byte(i), byte(i + 1), byte(i + 2)}
+		err = kbfsOps.Write(ctx, fileNode, data, int64(len(expected)))
+		require.NoError(t, err)
+		expected = append(expected, data...)
+	}
+
+	// All the writes should still be buffered.
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	lState := makeFBOLockState()
+	ops.blocks.blockLock.RLock(lState)
+	cw := ops.blocks.coalescedWrites[fileNode.GetID()]
+	ops.blocks.blockLock.RUnlock(lState)
+	require.NotNil(t, cw)
+	require.Equal(t, expected, cw.data)
+
+	// Stat and Read see the buffered data.
+	ei, err := kbfsOps.Stat(ctx, fileNode)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(expected)), ei.Size)
+	buf := make([]byte, len(expected))
+	n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(expected)), n)
+	require.Equal(t, expected, buf)
+
+	// A non-sequential write is applied after the buffered data.
+	err = kbfsOps.Write(ctx, fileNode, []byte{1}, 10000)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte{2}, 0)
+	require.NoError(t, err)
+	expected[0] = 2
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	require.Equal(t, cleanState, ops.blocks.GetState(lState))
+
+	buf = make([]byte, len(expected))
+	_, err = kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, expected, buf)
+}
+
+func TestKBFSOpsCoalesceSetAttrs(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	// Long enough that only explicit flushes write anything.
+	config.SetMetadataOpDelay(time.Hour)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", false)
+	kbfsOps := config.KBFSOps()
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	aNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	cNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "c", false, NoExcl)
+	require.NoError(t, err)
+
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	lState := makeFBOLockState()
+	revBefore := ops.getCurrMDRevision(lState)
+
+	mtime := time.Unix(1, 0)
+	for _, n := range []Node{aNode, bNode, cNode} {
+		err = kbfsOps.SetMtime(ctx, n, &mtime)
+		require.NoError(t, err)
+	}
+	err = kbfsOps.SetEx(ctx, aNode, true)
+	require.NoError(t, err)
+	require.Equal(t, revBefore, ops.getCurrMDRevision(lState))
+
+	// The changes are visible before they're written.
+	ei, err := kbfsOps.Stat(ctx, aNode)
+	require.NoError(t, err)
+	require.Equal(t, mtime.UnixNano(), ei.Mtime)
+	require.Equal(t, Exec, ei.Type)
+	children, err := kbfsOps.GetDirChildren(ctx, dirNode)
+	require.NoError(t, err)
+	require.Equal(t, mtime.UnixNano(), children["b"].Mtime)
+
+	// One revision for each directory.
+	err = kbfsOps.SyncFromServerForTesting(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	require.Equal(t, revBefore+2, ops.getCurrMDRevision(lState))
+	for _, n := range []Node{aNode, bNode, cNode} {
+		ei, err := kbfsOps.Stat(ctx, n)
+		require.NoError(t, err)
+		require.Equal(t, mtime.UnixNano(), ei.Mtime)
+	}
+	ops.pendingAttrsLock.Lock()
+	require.Len(t, ops.pendingAttrs, 0)
+	ops.pendingAttrsLock.Unlock()
+
+	// A write lands after any pending setattr on the same file.
+	err = kbfsOps.SetMtime(ctx, bNode, &mtime)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, bNode, []byte{1}, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, bNode)
+	require.NoError(t, err)
+	ei, err = kbfsOps.Stat(ctx, bNode)
+	require.NoError(t, err)
+	require.NotEqual(t, mtime.UnixNano(), ei.Mtime)
+}
+
+func TestKBFSOpsSetAttrsFlushedAfterDelay(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock := newTestClockNow()
+	config.SetClock(clock)
+	config.SetMetadataOpDelay(time.Second)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", false)
+	kbfsOps := config.KBFSOps()
+	aNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	lState := makeFBOLockState()
+	revBefore := ops.getCurrMDRevision(lState)
+
+	mtime := time.Unix(1, 0)
+	err = kbfsOps.SetMtime(ctx, aNode, &mtime)
+	require.NoError(t, err)
+	require.Equal(t, revBefore, ops.getCurrMDRevision(lState))
+
+	clock.Add(time.Second)
+	for ops.hasPendingAttrs() {
+		select {
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	require.Equal(t, revBefore+1, ops.getCurrMDRevision(lState))
+}
+
+func TestKBFSOpsSwitchUserDropsPendingAttrs(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	config.SetMetadataOpDelay(time.Hour)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	serviceLoggedIn(ctx, config, u1.String(), TLFJournalBackgroundWorkPaused)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), false)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	tlfID := rootNode.GetFolderBranch().Tlf
+	ops := getOps(config, tlfID)
+	lState := makeFBOLockState()
+	rev := ops.getCurrMDRevision(lState)
+	mtime := time.Unix(1, 0)
+	err = kbfsOps.SetMtime(ctx, fileNode, &mtime)
+	require.NoError(t, err)
+
+	// Switching users shuts the folder down under the session lock,
+	// and mustn't try to write the setattr.
+	daemon := config.KeybaseService().(*KeybaseDaemonLocal)
+	_, uid2, err := config.KBPKI().Resolve(ctx, u2.String())
+	require.NoError(t, err)
+	daemon.setCurrentUID(uid2)
+	switched := make(chan struct{})
+	go func() {
+		serviceLoggedIn(
+			ctx, config, u2.String(), TLFJournalBackgroundWorkPaused)
+		close(switched)
+	}()
+	select {
+	case <-switched:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	require.False(t, ops.hasPendingAttrs())
+	require.Equal(t, rev, ops.getCurrMDRevision(lState))
+}
+
+func TestKBFSOpsFsyncDurability(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	// With a no-op durability for the folder, Sync leaves the
+	// write dirty.
+	err = kbfsOps.SetFsyncDurability(
+		ctx, rootNode.GetFolderBranch(), FsyncDurabilityNone)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	lState := makeFBOLockState()
+	require.Equal(t, dirtyState, ops.blocks.GetState(lState))
+
+	// A per-call override in the context takes precedence.
+	err = kbfsOps.Sync(
+		NewContextWithFsyncDurability(ctx, FsyncDurabilityServer),
+		fileNode)
+	require.NoError(t, err)
+	require.Equal(t, cleanState, ops.blocks.GetState(lState))
+
+	// Resetting the folder falls back to the global default.
+	err = kbfsOps.SetFsyncDurability(
+		ctx, rootNode.GetFolderBranch(), FsyncDurabilityDefault)
+	require.NoError(t, err)
+	require.Equal(t, FsyncDurabilityJournal, ops.getFsyncDurability(ctx))
+}
+
+func TestKBFSOpsFreezeTlf(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, u2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := u1.String() + "," + u2.String()
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	fileNode1, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+
+	// Can't freeze while there are dirty writes.
+	fb := rootNode1.GetFolderBranch()
+	err = kbfsOps1.SetTlfFrozen(ctx, fb, true)
+	require.IsType(t, NotPermittedWhileDirtyError{}, err)
+	err = kbfsOps1.Sync(ctx, fileNode1)
+	require.NoError(t, err)
+	err = kbfsOps1.SetTlfFrozen(ctx, fb, true)
+	require.NoError(t, err)
+
+	status, _, err := kbfsOps1.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.True(t, status.Frozen)
+
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{4}, 0)
+	require.IsType(t, TlfFrozenError{}, err)
+	_, _, err = kbfsOps1.CreateDir(ctx, rootNode1, "b")
+	require.IsType(t, TlfFrozenError{}, errors.Cause(err))
+
+	// The other writer sees the freeze too.
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	fileNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	err = kbfsOps2.Truncate(ctx, fileNode2, 0)
+	require.IsType(t, TlfFrozenError{}, err)
+
+	// And unfreezing makes it writable again.
+	err = kbfsOps2.SetTlfFrozen(ctx, rootNode2.GetFolderBranch(), false)
+	require.NoError(t, err)
+	err = kbfsOps2.Truncate(ctx, fileNode2, 0)
+	require.NoError(t, err)
+	err = kbfsOps2.Sync(ctx, fileNode2)
+	require.NoError(t, err)
+}
+
+func TestKBFSOpsAppendOnlyTlf(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, u2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := u1.String() + "," + u2.String()
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	fileNode1, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, fileNode1)
+	require.NoError(t, err)
+
+	fb := rootNode1.GetFolderBranch()
+	err = kbfsOps1.SetTlfAppendOnly(ctx, fb, true)
+	require.NoError(t, err)
+	status, _, err := kbfsOps1.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.True(t, status.AppendOnly)
+
+	// Appends and new entries are fine.
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{4, 5}, 3)
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{6}, 5)
+	require.NoError(t, err)
+	_, _, err = kbfsOps1.CreateFile(ctx, rootNode1, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, fileNode1)
+	require.NoError(t, err)
+
+	// Modifying or removing existing data isn't.
+	err = kbfsOps1.Write(ctx, fileNode1, []byte{7}, 5)
+	require.IsType(t, TlfAppendOnlyError{}, err)
+	err = kbfsOps1.Truncate(ctx, fileNode1, 2)
+	require.IsType(t, TlfAppendOnlyError{}, err)
+	err = kbfsOps1.SetEx(ctx, fileNode1, true)
+	require.IsType(t, TlfAppendOnlyError{}, errors.Cause(err))
+	err = kbfsOps1.Rename(ctx, rootNode1, "a", rootNode1, "c")
+	require.IsType(t, TlfAppendOnlyError{}, errors.Cause(err))
+	err = kbfsOps1.RemoveEntry(ctx, rootNode1, "b")
+	require.IsType(t, TlfAppendOnlyError{}, errors.Cause(err))
+
+	// The other writer is held to the same rules.
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	fileNode2, ei, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), ei.Size)
+	err = kbfsOps2.Write(ctx, fileNode2, []byte{8}, 0)
+	require.IsType(t, TlfAppendOnlyError{}, err)
+	err = kbfsOps2.RemoveEntry(ctx, rootNode2, "a")
+	require.IsType(t, TlfAppendOnlyError{}, errors.Cause(err))
+
+	// Until the mark is cleared.
+	err = kbfsOps2.SetTlfAppendOnly(ctx, rootNode2.GetFolderBranch(), false)
+	require.NoError(t, err)
+	err = kbfsOps2.RemoveEntry(ctx, rootNode2, "a")
+	require.NoError(t, err)
+}
+
+func TestKBFSOpsNameNormalization(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, u2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	const nfc, nfd = "caf\u00e9", "cafe\u0301"
+	name := u1.String() + "," + u2.String()
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+
+	// New names are stored in NFC by default, and can be looked
+	// up in either form.
+	_, _, err := kbfsOps1.CreateFile(ctx, rootNode1, nfd, false, NoExcl)
+	require.NoError(t, err)
+	children, err := kbfsOps1.GetDirChildren(ctx, rootNode1)
+	require.NoError(t, err)
+	require.Contains(t, children, nfc)
+	require.NotContains(t, children, nfd)
+	_, _, err = kbfsOps1.Lookup(ctx, rootNode1, nfd)
+	require.NoError(t, err)
+	_, _, err = kbfsOps1.CreateFile(ctx, rootNode1, nfd, false, WithExcl)
+	require.IsType(t, NameExistsError{}, errors.Cause(err))
+
+	fb := rootNode1.GetFolderBranch()
+	err = kbfsOps1.SetTlfNameNormalization(ctx, fb, NameNormalizationNFD)
+	require.NoError(t, err)
+	status, _, err := kbfsOps1.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, "nfd", status.NameNormalization)
+
+	// The other device sees the new canonical form, and still
+	// finds the existing entry under its NFC name.
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	err = kbfsOps2.SyncFromServerForTesting(ctx, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	_, _, err = kbfsOps2.CreateDir(ctx, rootNode2, "na\u00efve")
+	require.NoError(t, err)
+	err = kbfsOps2.Rename(ctx, rootNode2, nfd, rootNode2, "r\u00e9sum\u00e9")
+	require.NoError(t, err)
+	children, err = kbfsOps2.GetDirChildren(ctx, rootNode2)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	require.Contains(t, children, "nai\u0308ve")
+	require.Contains(t, children, "re\u0301sume\u0301")
+
+	// Removes match across forms too.
+	err = kbfsOps2.RemoveDir(ctx, rootNode2, "na\u00efve")
+	require.NoError(t, err)
+	err = kbfsOps2.RemoveEntry(ctx, rootNode2, "r\u00e9sum\u00e9")
+	require.NoError(t, err)
+	children, err = kbfsOps2.GetDirChildren(ctx, rootNode2)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+}
+
+// Test that MDs that only change folder-wide settings can still be
+// decoded by clients that only know about the original op types.
+func TestKBFSOpsSettingsReadableByOldClients(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), false)
+	kbfsOps := config.KBFSOps()
+	_, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+
+	fb := rootNode.GetFolderBranch()
+	require.NoError(t, kbfsOps.SetTlfFrozen(ctx, fb, true))
+	require.NoError(t, kbfsOps.SetTlfFrozen(ctx, fb, false))
+	require.NoError(t, kbfsOps.SetTlfAppendOnly(ctx, fb, true))
+	require.NoError(t, kbfsOps.SetTlfAppendOnly(ctx, fb, false))
+	require.NoError(t, kbfsOps.SetTlfNameNormalization(
+		ctx, fb, NameNormalizationNFD))
+	require.NoError(t, kbfsOps.SetHistoryRetention(ctx, fb, HistoryRetention{
+		Policy: HistoryRetentionKeepDays,
+		Days:   30,
+	}))
+	require.NoError(t, kbfsOps.PinRevision(ctx, fb, MetadataRevisionInitial))
+	require.NoError(t, kbfsOps.UnpinRevision(ctx, fb, MetadataRevisionInitial))
+	require.NoError(t, kbfsOps.CreateSnapshot(
+		ctx, fb, "v1", MetadataRevisionUninitialized))
+	require.NoError(t, kbfsOps.DeleteSnapshot(ctx, fb, "v1"))
+
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	rmds, err := config.MDOps().GetRange(
+		ctx, fb.Tlf, MetadataRevisionInitial, status.Revision)
+	require.NoError(t, err)
+	require.Len(t, rmds, int(status.Revision))
+
+	oldCodec := kbfscodec.NewMsgpack()
+	registerOpsBaseline(oldCodec)
+	for _, rmd := range rmds {
+		buf, err := config.Codec().Encode(rmd.data)
+		require.NoError(t, err)
+		var pmd PrivateMetadata
+		err = oldCodec.Decode(buf, &pmd)
+		require.NoError(t, err, "Revision %d", rmd.Revision())
+		require.NotEmpty(t, pmd.Changes.Ops)
+	}
+}
+
+func TestKBFSOpsCreateTLFFrom(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+
+	// Make the blocks small so the copied file needs indirect
+	// blocks.
+	bsplit := &BlockSplitterSimple{5, 2, 100 * 1024}
+	config1.SetBlockSplitter(bsplit)
+
+	config2 := ConfigAsUser(config1, u2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	// Build a template in u1's private folder.
+	kbfsOps1 := config1.KBFSOps()
+	srcRoot := GetRootNodeOrBust(ctx, t, config1, u1.String(), false)
+	tmplNode, _, err := kbfsOps1.CreateDir(ctx, srcRoot, "tmpl")
+	require.NoError(t, err)
+	data := []byte("template file contents")
+	aNode, _, err := kbfsOps1.CreateFile(ctx, tmplNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, aNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, aNode)
+	require.NoError(t, err)
+	_, _, err = kbfsOps1.CreateFile(ctx, tmplNode, "b", true, NoExcl)
+	require.NoError(t, err)
+	cNode, _, err := kbfsOps1.CreateDir(ctx, tmplNode, "c")
+	require.NoError(t, err)
+	_, _, err = kbfsOps1.CreateFile(ctx, cNode, "d", false, NoExcl)
+	require.NoError(t, err)
+	_, err = kbfsOps1.CreateLink(ctx, cNode, "e", "../a")
+	require.NoError(t, err)
+
+	name := u1.String() + "," + u2.String()
+	h, err := ParseTlfHandle(
+		ctx, config1.KBPKI(), name, false)
+	require.NoError(t, err)
+	rootNode1, _, err := kbfsOps1.CreateTLFFrom(ctx, h, tmplNode)
+	require.NoError(t, err)
+
+	// The whole copy went into one revision after the initial one.
+	ops1 := kbfsOps1.(*KBFSOpsStandard).getOpsNoAdd(
+		rootNode1.GetFolderBranch())
+	require.Equal(t, MetadataRevisionInitial+1,
+		ops1.getCurrMDRevision(makeFBOLockState()))
+
+	// The other writer sees the copy.
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	children, err := kbfsOps2.GetDirChildren(ctx, rootNode2)
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+	require.Equal(t, File, children["a"].Type)
+	require.Equal(t, Exec, children["b"].Type)
+	require.Equal(t, Dir, children["c"].Type)
+
+	aNode2, ei, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(data)), ei.Size)
+	buf := make([]byte, len(data))
+	n, err := kbfsOps2.Read(ctx, aNode2, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+
+	cNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "c")
+	require.NoError(t, err)
+	children, err = kbfsOps2.GetDirChildren(ctx, cNode2)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	require.Equal(t, File, children["d"].Type)
+	require.Equal(t, Sym, children["e"].Type)
+	require.Equal(t, "../a", children["e"].SymPath)
+
+	// The destination now has entries, so it can't be initialized
+	// again.
+	_, _, err = kbfsOps1.CreateTLFFrom(ctx, h, tmplNode)
+	require.IsType(t, TlfNotEmptyError{}, errors.Cause(err))
+}
+
+func TestKBFSOpsMoveAcrossTLFs(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, u2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	kbfsOps1 := config1.KBFSOps()
+	srcRoot := GetRootNodeOrBust(ctx, t, config1, u1.String(), false)
+	dNode, _, err := kbfsOps1.CreateDir(ctx, srcRoot, "d")
+	require.NoError(t, err)
+	data := []byte("file contents to move")
+	fNode, _, err := kbfsOps1.CreateFile(ctx, dNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, fNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, fNode)
+	require.NoError(t, err)
+	_, _, err = kbfsOps1.CreateFile(ctx, dNode, "x", true, NoExcl)
+	require.NoError(t, err)
+	_, err = kbfsOps1.CreateLink(ctx, dNode, "l", "f")
+	require.NoError(t, err)
+
+	// Leave a partial copy in the destination, as if an earlier
+	// attempt at the move had been interrupted, but without a
+	// record of the source it was copied from.  Its contents don't
+	// match the source, so reusing it would corrupt the copy.
+	name := u1.String() + "," + u2.String()
+	dstRoot := GetRootNodeOrBust(ctx, t, config1, name, false)
+	partialNode, _, err := kbfsOps1.CreateDir(
+		ctx, dstRoot, "e"+crossTLFMovePartialSuffix)
+	require.NoError(t, err)
+	partialFNode, _, err := kbfsOps1.CreateFile(
+		ctx, partialNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, partialFNode, []byte("stale"), 0)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, partialFNode)
+	require.NoError(t, err)
+
+	err = kbfsOps1.Rename(ctx, srcRoot, "d", dstRoot, "e")
+	require.NoError(t, err)
+
+	children, err := kbfsOps1.GetDirChildren(ctx, srcRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+	children, err = kbfsOps1.GetDirChildren(ctx, dstRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Equal(t, Dir, children["e"].Type)
+
+	status, _, err := kbfsOps1.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, status.CrossTLFMoves, 0)
+
+	// The other writer sees the moved data.
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	eNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "e")
+	require.NoError(t, err)
+	children, err = kbfsOps2.GetDirChildren(ctx, eNode2)
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+	require.Equal(t, File, children["f"].Type)
+	require.Equal(t, Exec, children["x"].Type)
+	require.Equal(t, Sym, children["l"].Type)
+	require.Equal(t, "f", children["l"].SymPath)
+
+	fNode2, _, err := kbfsOps2.Lookup(ctx, eNode2, "f")
+	require.NoError(t, err)
+	buf := make([]byte, 2*len(data))
+	n, err := kbfsOps2.Read(ctx, fNode2, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+}
+
+// makeCrossTLFMoveSource makes a file named "f" containing data in
+// the private TLF of u1, and returns the root node of that TLF along
+// with the source version a move of "f" would record.
+func makeCrossTLFMoveSource(ctx context.Context, t *testing.T,
+	config Config, u1 libkb.NormalizedUsername, data []byte) (
+	Node, string) {
+	kbfsOps := config.KBFSOps()
+	srcRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), false)
+	fNode, _, err := kbfsOps.CreateFile(ctx, srcRoot, "f", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fNode)
+	require.NoError(t, err)
+	md, err := kbfsOps.GetNodeMetadata(ctx, fNode)
+	require.NoError(t, err)
+	return srcRoot, md.BlockInfo.ID.String()
+}
+
+func writeCrossTLFMoveFile(ctx context.Context, t *testing.T,
+	config Config, dir Node, name string, data []byte) {
+	kbfsOps := config.KBFSOps()
+	node, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, node, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, node)
+	require.NoError(t, err)
+}
+
+func TestKBFSOpsMoveAcrossTLFsResume(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	data := []byte("file contents to move")
+	srcRoot, version := makeCrossTLFMoveSource(ctx, t, config, u1, data)
+	dstRoot := GetRootNodeOrBust(
+		ctx, t, config, u1.String()+","+u2.String(), false)
+
+	// An interrupted attempt at moving the same version of the
+	// source left a prefix of it behind, which gets finished off.
+	writeCrossTLFMoveFile(ctx, t, config, dstRoot,
+		"g"+crossTLFMoveSourceSuffix, []byte(version))
+	writeCrossTLFMoveFile(ctx, t, config, dstRoot,
+		"g"+crossTLFMovePartialSuffix, data[:5])
+
+	kbfsOps := config.KBFSOps()
+	err := kbfsOps.Rename(ctx, srcRoot, "f", dstRoot, "g")
+	require.NoError(t, err)
+
+	children, err := kbfsOps.GetDirChildren(ctx, srcRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+	children, err = kbfsOps.GetDirChildren(ctx, dstRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	gNode, _, err := kbfsOps.Lookup(ctx, dstRoot, "g")
+	require.NoError(t, err)
+	buf := make([]byte, 2*len(data))
+	n, err := kbfsOps.Read(ctx, gNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+}
+
+func TestKBFSOpsMoveAcrossTLFsMismatch(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	data := []byte("file contents to move")
+	srcRoot, version := makeCrossTLFMoveSource(ctx, t, config, u1, data)
+	dstRoot := GetRootNodeOrBust(
+		ctx, t, config, u1.String()+","+u2.String(), false)
+
+	// The partial copy claims to be from the current source, but
+	// its prefix is wrong, so the copy fails verification and the
+	// source stays put.
+	writeCrossTLFMoveFile(ctx, t, config, dstRoot,
+		"g"+crossTLFMoveSourceSuffix, []byte(version))
+	writeCrossTLFMoveFile(ctx, t, config, dstRoot,
+		"g"+crossTLFMovePartialSuffix, []byte("stale"))
+
+	kbfsOps := config.KBFSOps()
+	err := kbfsOps.Rename(ctx, srcRoot, "f", dstRoot, "g")
+	require.IsType(t, CrossTLFMoveMismatchError{}, err)
+	children, err := kbfsOps.GetDirChildren(ctx, srcRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	children, err = kbfsOps.GetDirChildren(ctx, dstRoot)
+	require.NoError(t, err)
+	require.NotContains(t, children, "g")
+	require.NotContains(t, children, "g"+crossTLFMoveSourceSuffix)
+
+	// Without the record, the next attempt starts over.
+	err = kbfsOps.Rename(ctx, srcRoot, "f", dstRoot, "g")
+	require.NoError(t, err)
+	gNode, _, err := kbfsOps.Lookup(ctx, dstRoot, "g")
+	require.NoError(t, err)
+	buf := make([]byte, 2*len(data))
+	n, err := kbfsOps.Read(ctx, gNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+	children, err = kbfsOps.GetDirChildren(ctx, dstRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+}
+
+func TestKBFSOpsMoveAcrossTLFsMissingSource(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	data := []byte("file contents to move")
+	srcRoot, version := makeCrossTLFMoveSource(ctx, t, config, u1, data)
+	dstRoot := GetRootNodeOrBust(
+		ctx, t, config, u1.String()+","+u2.String(), false)
+	kbfsOps := config.KBFSOps()
+
+	// A partial copy is never put in place without its source.
+	writeCrossTLFMoveFile(ctx, t, config, dstRoot,
+		"g"+crossTLFMoveSourceSuffix, []byte(version))
+	writeCrossTLFMoveFile(ctx, t, config, dstRoot,
+		"g"+crossTLFMovePartialSuffix, data[:5])
+	err := kbfsOps.Rename(ctx, srcRoot, "missing", dstRoot, "g")
+	require.IsType(t, NoSuchNameError{}, err)
+	children, err := kbfsOps.GetDirChildren(ctx, dstRoot)
+	require.NoError(t, err)
+	require.NotContains(t, children, "g")
+
+	// But if the copy was already put in place before the source
+	// was removed, only the record is left to clean up.
+	err = kbfsOps.RemoveEntry(ctx, dstRoot, "g"+crossTLFMovePartialSuffix)
+	require.NoError(t, err)
+	writeCrossTLFMoveFile(ctx, t, config, dstRoot, "g", data)
+	err = kbfsOps.Rename(ctx, srcRoot, "missing", dstRoot, "g")
+	require.NoError(t, err)
+	children, err = kbfsOps.GetDirChildren(ctx, dstRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Contains(t, children, "g")
+}
+
+func TestKBFSOpsFetchFavoriteHeads(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+
+	name := u1.String() + "," + u2.String()
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	_, _, err := kbfsOps1.CreateDir(ctx, rootNode1, "a")
+	require.NoError(t, err)
+
+	config2 := ConfigAsUser(config1, u2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	kbfsOps2 := config2.KBFSOps().(*KBFSOpsStandard)
+	err = kbfsOps2.AddFavorite(ctx, Favorite{name, false})
+	require.NoError(t, err)
+	// This one hasn't been created yet, and shouldn't be.
+	err = kbfsOps2.AddFavorite(ctx, Favorite{u2.String(), true})
+	require.NoError(t, err)
+
+	err = kbfsOps2.FetchFavoriteHeads(ctx, 2)
+	require.NoError(t, err)
+	status, _, err := kbfsOps2.Status(ctx)
+	require.NoError(t, err)
+	require.Nil(t, status.FavoriteHeads)
+
+	// The head is already set for the created folder.
+	fb := rootNode1.GetFolderBranch()
+	ops := func() *folderBranchOps {
+		kbfsOps2.opsLock.RLock()
+		defer kbfsOps2.opsLock.RUnlock()
+		return kbfsOps2.ops[fb]
+	}()
+	require.NotNil(t, ops)
+	lState := makeFBOLockState()
+	head, _ := ops.getHead(lState)
+	require.NotEqual(t, ImmutableRootMetadata{}, head)
+
+	h, err := ParseTlfHandle(
+		ctx, config2.KBPKI(), u2.String(), true)
+	require.NoError(t, err)
+	_, md, err := config2.MDOps().GetForHandle(ctx, h, Merged)
+	require.NoError(t, err)
+	require.Equal(t, ImmutableRootMetadata{}, md)
+}
+
+func TestKBFSOpsRemoveDirTree(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+
+	// Make the blocks small so the files need indirect blocks.
+	bsplit := &BlockSplitterSimple{5, 2, 100 * 1024}
+	config1.SetBlockSplitter(bsplit)
+
+	config2 := ConfigAsUser(config1, u2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := u1.String() + "," + u2.String()
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	makeFile := func(dir Node, name string) Node {
+		node, _, err := kbfsOps1.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps1.Write(ctx, node, data, 0)
+		require.NoError(t, err)
+		err = kbfsOps1.Sync(ctx, node)
+		require.NoError(t, err)
+		return node
+	}
+	aNode, _, err := kbfsOps1.CreateDir(ctx, rootNode1, "a")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps1.CreateDir(ctx, aNode, "b")
+	require.NoError(t, err)
+	_, _, err = kbfsOps1.CreateDir(ctx, bNode, "c")
+	require.NoError(t, err)
+	dNode := makeFile(bNode, "d")
+	makeFile(aNode, "e")
+	_, err = kbfsOps1.CreateLink(ctx, aNode, "l", "e")
+	require.NoError(t, err)
+
+	// The whole tree goes away in one revision.
+	fb := rootNode1.GetFolderBranch()
+	ops1 := getOps(config1, fb.Tlf)
+	c := make(chan struct{}, 100)
+	cro := &testCRObserver{c, nil}
+	err = config1.Notifier().RegisterForChanges(
+		[]FolderBranch{fb}, cro)
+	require.NoError(t, err)
+	lState := makeFBOLockState()
+	revBefore := ops1.getCurrMDRevision(lState)
+	err = kbfsOps1.RemoveDirTree(ctx, rootNode1, "a")
+	require.NoError(t, err)
+	require.Equal(t, revBefore+1, ops1.getCurrMDRevision(lState))
+	children, err := kbfsOps1.GetDirChildren(ctx, rootNode1)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+
+	// Every entry has its own rmOp, deepest first, ending with the
+	// removed directory itself.
+	head, _ := ops1.getHead(lState)
+	var removed []string
+	for _, op := range head.data.Changes.Ops {
+		ro, ok := op.(*rmOp)
+		require.True(t, ok, "Unexpected op %s", op)
+		removed = append(removed, ro.OldName)
+	}
+	require.Len(t, removed, 6)
+	require.Contains(t, removed[:2], "c")
+	require.Contains(t, removed[:2], "d")
+	require.Equal(t, "a", removed[5])
+
+	// Observers hear about every removed entry.
+	var notified []string
+	for _, change := range cro.changes {
+		notified = append(notified, change.DirUpdated...)
+	}
+	require.Len(t, notified, 6)
+	for _, name := range removed {
+		require.Contains(t, notified, name)
+	}
+
+	// Open nodes in the tree are unlinked, and can still be used.
+	for _, n := range []Node{aNode, bNode, dNode} {
+		require.Equal(t, "", n.GetBasename())
+	}
+	err = kbfsOps1.Write(ctx, dNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, dNode)
+	require.NoError(t, err)
+
+	// Only directories can be removed this way.
+	makeFile(rootNode1, "h")
+	err = kbfsOps1.RemoveDirTree(ctx, rootNode1, "h")
+	require.IsType(t, NotDirError{}, errors.Cause(err))
+
+	// The other writer sees the removals.
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	children, err = config2.KBFSOps().GetDirChildren(ctx, rootNode2)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+}
+
+func TestKBFSOpsSwitchUser(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	serviceLoggedIn(ctx, config, u1.String(), TLFJournalBackgroundWorkPaused)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), false)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	data := []byte{1, 2, 3}
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	GetRootNodeOrBust(ctx, t, config, u1.String(), true)
+
+	tlfID := rootNode.GetFolderBranch().Tlf
+	head, err := config.MDOps().GetForTLF(ctx, tlfID)
+	require.NoError(t, err)
+	_, err = config.MDCache().Get(tlfID, head.Revision(), NullBranchID)
+	require.NoError(t, err)
+	_, err = config.KeyCache().GetTLFCryptKey(tlfID, FirstValidKeyGen)
+	require.NoError(t, err)
+
+	// Switch to u2 without logging u1 out first.
+	daemon := config.KeybaseService().(*KeybaseDaemonLocal)
+	_, uid2, err := config.KBPKI().Resolve(ctx, u2.String())
+	require.NoError(t, err)
+	daemon.setCurrentUID(uid2)
+	serviceLoggedIn(ctx, config, u2.String(), TLFJournalBackgroundWorkPaused)
+
+	// Nothing of u1's private folder is left in memory.
+	kbfsOps.opsLock.RLock()
+	for fb := range kbfsOps.ops {
+		require.True(t, fb.Tlf.IsPublic(), "%s", fb.Tlf)
+	}
+	kbfsOps.opsLock.RUnlock()
+	_, err = config.KeyCache().GetTLFCryptKey(tlfID, FirstValidKeyGen)
+	require.IsType(t, KeyCacheMissError{}, err)
+	_, err = config.MDCache().Get(tlfID, head.Revision(), NullBranchID)
+	require.Error(t, err)
+	_, err = ParseTlfHandle(ctx, config.KBPKI(), u1.String(), false)
+	require.Error(t, err)
+
+	// Switching back makes the folder available again.
+	_, uid1, err := config.KBPKI().Resolve(ctx, u1.String())
+	require.NoError(t, err)
+	daemon.setCurrentUID(uid1)
+	serviceLoggedIn(ctx, config, u1.String(), TLFJournalBackgroundWorkPaused)
+	rootNode = GetRootNodeOrBust(ctx, t, config, u1.String(), false)
+	fileNode, _, err = kbfsOps.Lookup(ctx, rootNode, "a")
+	require.NoError(t, err)
+	buf := make([]byte, len(data))
+	n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, buf)
+}
+
+func TestKBFSOpsDeviceRevoked(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	serviceLoggedIn(ctx, config, u1.String(), TLFJournalBackgroundWorkPaused)
+
+	privRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), false)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, privRoot, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	pubRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), true)
+	tlfID := privRoot.GetFolderBranch().Tlf
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	userInfo, err := config.KeybaseService().LoadUserPlusKeys(
+		ctx, session.UID, "")
+	require.NoError(t, err)
+	require.False(t, serviceCheckDeviceRevoked(ctx, config, session, userInfo))
+
+	userInfo.RevokedVerifyingKeys = map[kbfscrypto.VerifyingKey]keybase1.KeybaseTime{
+		session.VerifyingKey: {},
+	}
+	require.True(t, serviceCheckDeviceRevoked(ctx, config, session, userInfo))
+
+	// The private folder and its keys are gone, and writes anywhere
+	// fail with a clear error.
+	kbfsOps.opsLock.RLock()
+	for fb := range kbfsOps.ops {
+		require.True(t, fb.Tlf.IsPublic(), "%s", fb.Tlf)
+	}
+	kbfsOps.opsLock.RUnlock()
+	_, err = config.KeyCache().GetTLFCryptKey(tlfID, FirstValidKeyGen)
+	require.IsType(t, KeyCacheMissError{}, err)
+	_, _, err = kbfsOps.CreateFile(ctx, pubRoot, "b", false, NoExcl)
+	require.IsType(t, DeviceRevokedError{}, errors.Cause(err))
+
+	status, _, err := kbfsOps.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "", status.CurrentUser)
+	require.Len(t, status.FailingServices, 1)
+	require.IsType(t, DeviceRevokedError{},
+		status.FailingServices[DeviceServiceName])
+
+	// Logging in again lifts the restriction.
+	serviceLoggedIn(ctx, config, u1.String(), TLFJournalBackgroundWorkPaused)
+	_, _, err = kbfsOps.CreateFile(ctx, pubRoot, "b", false, NoExcl)
+	require.NoError(t, err)
+	status, _, err = kbfsOps.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, u1.String(), status.CurrentUser)
+	require.Len(t, status.FailingServices, 0)
+}
+
+func testKBFSOpsLink(t *testing.T, bsplit BlockSplitter) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	if bsplit != nil {
+		config.SetBlockSplitter(bsplit)
+	}
+	kbfsOps := config.KBFSOps()
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), false)
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	data := []byte("some data that gets linked, long enough to split")
+	// Left unsynced, so the link has to sync it first.
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+
+	ei, err := kbfsOps.Link(ctx, fileNode, rootNode, "b")
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), ei.Nlink)
+	require.Equal(t, uint64(len(data)), ei.Size)
+	ei, err = kbfsOps.Link(ctx, fileNode, dirNode, "c")
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), ei.Nlink)
+	ei, err = kbfsOps.Stat(ctx, fileNode)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), ei.Nlink)
+
+	checkData := func(dir Node, name string, expected []byte) {
+		n, _, err := kbfsOps.Lookup(ctx, dir, name)
+		require.NoError(t, err)
+		buf := make([]byte, len(expected)+1)
+		nr, err := kbfsOps.Read(ctx, n, buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, expected, buf[:nr])
+	}
+	checkData(rootNode, "b", data)
+	checkData(dirNode, "c", data)
+
+	_, err = kbfsOps.Link(ctx, fileNode, rootNode, "b")
+	require.IsType(t, NameExistsError{}, err)
+	_, err = kbfsOps.Link(ctx, dirNode, rootNode, "e")
+	require.IsType(t, NotFileError{}, err)
+	pubRoot := GetRootNodeOrBust(ctx, t, config, u1.String(), true)
+	_, err = kbfsOps.Link(ctx, fileNode, pubRoot, "b")
+	require.IsType(t, LinkAcrossTLFsError{}, err)
+
+	t.Log("Writing to one link doesn't change the others")
+	bNode, _, err := kbfsOps.Lookup(ctx, rootNode, "b")
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, bNode, []byte("new"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, bNode)
+	require.NoError(t, err)
+	checkData(rootNode, "a", data)
+	checkData(rootNode, "b", append([]byte("new"), data[3:]...))
+
+	t.Log("Removing the original leaves the links readable")
+	err = kbfsOps.RemoveEntry(ctx, rootNode, "a")
+	require.NoError(t, err)
+	checkData(dirNode, "c", data)
+	ei, err = kbfsOps.Stat(ctx, bNode)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), ei.Nlink)
+	err = kbfsOps.SyncFromServerForTesting(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+}
+
+func TestKBFSOpsLink(t *testing.T) {
+	testKBFSOpsLink(t, nil)
+}
+
+func TestKBFSOpsLinkIndirect(t *testing.T) {
+	bsplit, err := NewBlockSplitterSimple(20, 8*1024, kbfscodec.NewMsgpack())
+	require.NoError(t, err)
+	testKBFSOpsLink(t, bsplit)
+}
+
+func TestKBFSOpsLinkCounts(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	kbfsOps := config.KBFSOps()
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), false)
+	makeFile := func(name string) Node {
+		n, _, err := kbfsOps.CreateFile(ctx, rootNode, name, false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, []byte(name), 0)
+		require.NoError(t, err)
+		err = kbfsOps.Sync(ctx, n)
+		require.NoError(t, err)
+		return n
+	}
+	checkNlinks := func(expected map[string]uint32) {
+		children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+		require.NoError(t, err)
+		for name, nlink := range expected {
+			require.Equal(t, nlink, children[name].Nlink, name)
+			_, ei, err := kbfsOps.Lookup(ctx, rootNode, name)
+			require.NoError(t, err)
+			require.Equal(t, nlink, ei.Nlink, name)
+		}
+	}
+
+	t.Log("Chained links all share one count")
+	aNode := makeFile("a")
+	_, err := kbfsOps.Link(ctx, aNode, rootNode, "b")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.Lookup(ctx, rootNode, "b")
+	require.NoError(t, err)
+	ei, err := kbfsOps.Link(ctx, bNode, rootNode, "c")
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), ei.Nlink)
+	checkNlinks(map[string]uint32{"a": 3, "b": 3, "c": 3})
+
+	t.Log("Unlinking any entry updates the rest")
+	err = kbfsOps.RemoveEntry(ctx, rootNode, "c")
+	require.NoError(t, err)
+	checkNlinks(map[string]uint32{"a": 2, "b": 2})
+
+	t.Log("Renaming over a link unlinks it")
+	makeFile("d")
+	err = kbfsOps.Rename(ctx, rootNode, "d", rootNode, "b")
+	require.NoError(t, err)
+	checkNlinks(map[string]uint32{"a": 1, "b": 0})
+
+	t.Log("Renaming a link keeps it in the group")
+	_, err = kbfsOps.Link(ctx, aNode, rootNode, "e")
+	require.NoError(t, err)
+	err = kbfsOps.Rename(ctx, rootNode, "e", rootNode, "f")
+	require.NoError(t, err)
+	checkNlinks(map[string]uint32{"a": 2, "f": 2})
+
+	t.Log("The counts are in the MD other devices read")
+	config2 := ConfigAsUser(config, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), false)
+	children, err := config2.KBFSOps().GetDirChildren(ctx, rootNode2)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), children["a"].Nlink)
+	require.Equal(t, uint32(2), children["f"].Nlink)
+}
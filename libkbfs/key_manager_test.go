@@ -993,6 +993,13 @@ func testKeyManagerRekeyAddAndRevokeDevice(t *testing.T, ver MetadataVer) {
 	devIndex = AddDeviceForLocalUserOrBust(t, config2Dev3, uid2)
 	SwitchDeviceForLocalUserOrBust(t, config2Dev3, devIndex)
 
+	// Cancel the recheck scheduled by user 1's rekey, so that
+	// moving the clock forward doesn't start it in the middle of
+	// the rekeys below.
+	kbfsOps1.(*KBFSOpsStandard).getOpsNoAdd(
+		rootNode1.GetFolderBranch()).rekeyFSM.Event(
+		newRekeyCancelEventForTest())
+
 	// Now revoke the original user 2 device (the last writer)
 	clock.Add(1 * time.Minute)
 	RevokeDeviceForLocalUserOrBust(t, config1, uid2, 0)
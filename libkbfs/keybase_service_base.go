@@ -260,10 +260,24 @@ func (k *KeybaseServiceBase) KeyfamilyChanged(ctx context.Context,
 	k.setCachedUserInfo(uid, UserInfo{})
 	k.clearCachedUnverifiedKeys(uid)
 
-	if k.getCachedCurrentSession().UID == uid {
+	if session := k.getCachedCurrentSession(); session.UID == uid {
 		// Ignore any errors for now, we don't want to block this
 		// notification and it's not worth spawning a goroutine for.
 		k.config.MDServer().CheckForRekeys(context.Background())
+
+		// The change might be the revocation of this very
+		// device.  Loading the user can take a while, so don't
+		// block the notification on it.
+		go func() {
+			ctx := context.Background()
+			userInfo, err := k.LoadUserPlusKeys(ctx, uid, "")
+			if err != nil {
+				k.log.CDebugf(ctx,
+					"Couldn't check for revocation of this device: %+v", err)
+				return
+			}
+			serviceCheckDeviceRevoked(ctx, k.config, session, userInfo)
+		}()
 	}
 
 	return nil
@@ -633,6 +647,41 @@ func (k *KeybaseServiceBase) FSSyncStatusRequest(ctx context.Context,
 	return k.kbfsClient.FSSyncStatus(ctx, resp)
 }
 
+func (k *KeybaseServiceBase) setFSEventStreaming(ctx context.Context,
+	folder keybase1.Folder, enabled bool) error {
+	ctx = ctxWithRandomIDReplayable(ctx, CtxKeybaseServiceIDKey, CtxKeybaseServiceOpID,
+		k.log)
+	k.log.CDebugf(ctx, "FS event streaming for %s (public: %t): %t",
+		folder.Name, !folder.Private, enabled)
+	tlfHandle, err := k.getHandleFromFolderName(ctx, folder.Name,
+		!folder.Private)
+	if err != nil {
+		return err
+	}
+
+	rootNode, _, err := k.config.KBFSOps().
+		GetOrCreateRootNode(ctx, tlfHandle, MasterBranch)
+	if err != nil {
+		return err
+	}
+	return k.config.KBFSOps().SetFSEventStreaming(
+		ctx, rootNode.GetFolderBranch(), enabled)
+}
+
+// FSEventsSubscribe implements FSEventsInterface for
+// KeybaseServiceBase.
+func (k *KeybaseServiceBase) FSEventsSubscribe(ctx context.Context,
+	folder keybase1.Folder) error {
+	return k.setFSEventStreaming(ctx, folder, true)
+}
+
+// FSEventsUnsubscribe implements FSEventsInterface for
+// KeybaseServiceBase.
+func (k *KeybaseServiceBase) FSEventsUnsubscribe(ctx context.Context,
+	folder keybase1.Folder) error {
+	return k.setFSEventStreaming(ctx, folder, false)
+}
+
 // GetTLFCryptKeys implements the TlfKeysInterface interface for
 // KeybaseServiceBase.
 func (k *KeybaseServiceBase) GetTLFCryptKeys(ctx context.Context,
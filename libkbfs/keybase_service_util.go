@@ -32,8 +32,10 @@ var adminFeatureList = map[keybase1.UID]bool{
 	"eb08cb06e608ea41bd893946445d7919": true, // Miles Steele, "mlsteele"
 }
 
-// serviceLoggedIn should be called when a new user logs in. It
-// shouldn't be called again until after serviceLoggedOut is called.
+// serviceLoggedIn should be called when a new user logs in.  It's
+// safe to call it for a different user without serviceLoggedOut
+// being called first, e.g. when the service switches accounts; the
+// previous user's state is torn down as if they had logged out.
 func serviceLoggedIn(ctx context.Context, config Config, name string,
 	bws TLFJournalBackgroundWorkStatus) {
 	log := config.MakeLogger("")
@@ -46,6 +48,17 @@ func serviceLoggedIn(ctx context.Context, config Config, name string,
 		return
 	}
 
+	if config.KBFSOps().SetSessionUser(ctx, session.UID) {
+		// Someone else was logged in before, possibly without
+		// ever logging out, so make sure none of their journals
+		// or cached data survive into this session.
+		log.CDebugf(ctx, "Switched users to %s", name)
+		if jServer, err := GetJournalServer(config); err == nil {
+			jServer.shutdownExistingJournals(ctx)
+		}
+		config.ResetCaches()
+	}
+
 	if jServer, err := GetJournalServer(config); err == nil {
 		err := jServer.EnableExistingJournals(
 			ctx, session.UID, session.VerifyingKey, bws)
@@ -55,10 +68,11 @@ func serviceLoggedIn(ctx context.Context, config Config, name string,
 		}
 	}
 	if config.DiskBlockCache() == nil && adminFeatureList[session.UID] {
-		dbc, err := newDiskBlockCacheStandard(config,
-			diskBlockCacheRootFromStorageRoot(config.StorageRoot()))
+		dbc, err := newDiskBlockCachePartitioned(config, config.StorageRoot())
 		if err == nil {
 			config.SetDiskBlockCache(dbc)
+		} else {
+			log.CDebugf(ctx, "Could not initialize disk cache: %v", err)
 		}
 	}
 
@@ -84,3 +98,35 @@ func serviceLoggedOut(ctx context.Context, config Config) {
 	// call always comes before a logged-in call.
 	config.KBFSOps().ClearPrivateFolderMD(ctx)
 }
+
+// serviceDeviceRevoked should be called when the device of the
+// current session has been revoked.  Rather than letting every
+// operation fail on its own as the servers reject the old key, it
+// shuts down the private folders, locks away the journals (they're
+// keyed to the revoked device, so they stay on disk until it's
+// provisioned again), purges all cached keys and data, and makes
+// further writes fail with err.
+func serviceDeviceRevoked(
+	ctx context.Context, config Config, err DeviceRevokedError) {
+	config.KBFSOps().DeviceRevoked(ctx, err)
+	if jServer, jErr := GetJournalServer(config); jErr == nil {
+		jServer.shutdownExistingJournals(ctx)
+	}
+	config.ResetCaches()
+	config.KBFSOps().PushStatusChange()
+}
+
+// serviceCheckDeviceRevoked calls serviceDeviceRevoked if userInfo,
+// freshly loaded for the user of session, lists the session's device
+// key as revoked.  It returns whether it did.
+func serviceCheckDeviceRevoked(ctx context.Context, config Config,
+	session SessionInfo, userInfo UserInfo) bool {
+	if _, ok := userInfo.RevokedVerifyingKeys[session.VerifyingKey]; !ok {
+		return false
+	}
+	serviceDeviceRevoked(ctx, config, DeviceRevokedError{
+		Name:         session.Name,
+		VerifyingKey: session.VerifyingKey,
+	})
+	return true
+}
@@ -0,0 +1,251 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+const (
+	// lockContentionThreshold is how long a lock acquisition has
+	// to wait before it counts as contended.
+	lockContentionThreshold = 100 * time.Millisecond
+	// lockLongHoldThreshold is how long a lock may be held
+	// exclusively before its release is logged, along with the
+	// stack that took it.
+	lockLongHoldThreshold = 5 * time.Second
+	// lockProbableDeadlockThreshold is how long a lock may be held
+	// before the watchdog assumes something is stuck, and logs
+	// all goroutines.
+	lockProbableDeadlockThreshold = 2 * time.Minute
+	// lockWatchdogInterval is how often the watchdog checks the
+	// locks of a folder-branch.
+	lockWatchdogInterval = 30 * time.Second
+	// lockStackDepth is the maximum number of frames kept for the
+	// stack that took a lock.
+	lockStackDepth = 32
+)
+
+// lockHolder describes the current exclusive holder of a
+// watchedRWMutex.
+type lockHolder struct {
+	since time.Time
+	pcs   []uintptr
+	// reported is set once the watchdog has logged this holder,
+	// so that it only logs a stuck lock once.
+	reported bool
+}
+
+func formatLockStack(pcs []uintptr) string {
+	var buf bytes.Buffer
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&buf, "\t%s\n\t\t%s:%d\n",
+			frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return buf.String()
+}
+
+// watchedRWMutex is a sync.RWMutex that keeps track of how long it's
+// waited on and held, and by whom, so that a stuck folder can be
+// diagnosed from the logs and metrics.  It can be used anywhere a
+// sync.Locker or an rwLocker is expected.
+type watchedRWMutex struct {
+	name  string
+	clock Clock
+	log   logger.Logger
+
+	waitTimer         metrics.Timer
+	contended         metrics.Counter
+	longHolds         metrics.Counter
+	probableDeadlocks metrics.Counter
+
+	mu sync.RWMutex
+
+	// holderLock protects the fields below.
+	holderLock sync.Mutex
+	holder     *lockHolder
+	readers    int
+	// readersSince is when the current unbroken run of readers
+	// started.
+	readersSince    time.Time
+	readersReported bool
+}
+
+var _ rwLocker = (*watchedRWMutex)(nil)
+
+// newWatchedRWMutex returns a new watchedRWMutex with the given
+// name, which is used in log messages and in the names of its
+// metrics.  Metrics for locks with the same name are shared, and
+// aren't kept at all if registry is nil.
+func newWatchedRWMutex(name string, clock Clock, log logger.Logger,
+	registry metrics.Registry) *watchedRWMutex {
+	w := &watchedRWMutex{
+		name:              name,
+		clock:             clock,
+		log:               log,
+		waitTimer:         metrics.NilTimer{},
+		contended:         metrics.NilCounter{},
+		longHolds:         metrics.NilCounter{},
+		probableDeadlocks: metrics.NilCounter{},
+	}
+	if registry != nil {
+		prefix := "folderBranchOps." + name + "."
+		w.waitTimer = metrics.GetOrRegisterTimer(prefix+"Wait", registry)
+		w.contended = metrics.GetOrRegisterCounter(
+			prefix+"Contended", registry)
+		w.longHolds = metrics.GetOrRegisterCounter(
+			prefix+"LongHolds", registry)
+		w.probableDeadlocks = metrics.GetOrRegisterCounter(
+			prefix+"ProbableDeadlocks", registry)
+	}
+	return w
+}
+
+func (w *watchedRWMutex) recordWait(start time.Time) time.Time {
+	now := w.clock.Now()
+	wait := now.Sub(start)
+	w.waitTimer.Update(wait)
+	if wait > lockContentionThreshold {
+		w.contended.Inc(1)
+	}
+	return now
+}
+
+// Lock implements the sync.Locker interface for watchedRWMutex.
+func (w *watchedRWMutex) Lock() {
+	start := w.clock.Now()
+	w.mu.Lock()
+	now := w.recordWait(start)
+
+	pcs := make([]uintptr, lockStackDepth)
+	// Skip runtime.Callers, this function, and the leveled
+	// mutex plumbing that called it.
+	n := runtime.Callers(4, pcs)
+	w.holderLock.Lock()
+	defer w.holderLock.Unlock()
+	w.holder = &lockHolder{since: now, pcs: pcs[:n]}
+}
+
+// Unlock implements the sync.Locker interface for watchedRWMutex.
+func (w *watchedRWMutex) Unlock() {
+	w.holderLock.Lock()
+	holder := w.holder
+	w.holder = nil
+	w.holderLock.Unlock()
+
+	w.mu.Unlock()
+
+	if holder == nil {
+		return
+	}
+	held := w.clock.Now().Sub(holder.since)
+	if held > lockLongHoldThreshold {
+		w.longHolds.Inc(1)
+		w.log.CWarningf(nil, "%s was held for %s by:\n%s",
+			w.name, held, formatLockStack(holder.pcs))
+	}
+}
+
+// RLock implements the rwLocker interface for watchedRWMutex.
+func (w *watchedRWMutex) RLock() {
+	start := w.clock.Now()
+	w.mu.RLock()
+	now := w.recordWait(start)
+
+	w.holderLock.Lock()
+	defer w.holderLock.Unlock()
+	w.readers++
+	if w.readers == 1 {
+		w.readersSince = now
+		w.readersReported = false
+	}
+}
+
+// RUnlock implements the rwLocker interface for watchedRWMutex.
+func (w *watchedRWMutex) RUnlock() {
+	w.holderLock.Lock()
+	w.readers--
+	w.holderLock.Unlock()
+
+	w.mu.RUnlock()
+}
+
+type watchedRLocker watchedRWMutex
+
+func (r *watchedRLocker) Lock() {
+	(*watchedRWMutex)(r).RLock()
+}
+
+func (r *watchedRLocker) Unlock() {
+	(*watchedRWMutex)(r).RUnlock()
+}
+
+// RLocker implements the rwLocker interface for watchedRWMutex.
+func (w *watchedRWMutex) RLocker() sync.Locker {
+	return (*watchedRLocker)(w)
+}
+
+// checkForDeadlock logs a warning, with the stack that took the lock
+// and the stacks of all goroutines, the first time it finds that the
+// lock has been held for longer than
+// lockProbableDeadlockThreshold.  It returns true if it logged one.
+func (w *watchedRWMutex) checkForDeadlock(now time.Time) bool {
+	w.holderLock.Lock()
+	var msg string
+	switch {
+	case w.holder != nil && !w.holder.reported &&
+		now.Sub(w.holder.since) > lockProbableDeadlockThreshold:
+		w.holder.reported = true
+		msg = fmt.Sprintf("%s has been held for %s by:\n%s",
+			w.name, now.Sub(w.holder.since),
+			formatLockStack(w.holder.pcs))
+	case w.readers > 0 && !w.readersReported &&
+		now.Sub(w.readersSince) > lockProbableDeadlockThreshold:
+		w.readersReported = true
+		msg = fmt.Sprintf("%s has been r-locked by %d readers for %s",
+			w.name, w.readers, now.Sub(w.readersSince))
+	}
+	w.holderLock.Unlock()
+
+	if msg == "" {
+		return false
+	}
+	w.probableDeadlocks.Inc(1)
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	w.log.CWarningf(nil, "Probable deadlock: %s\nAll goroutines:\n%s",
+		msg, buf)
+	return true
+}
+
+// lockWatchdog periodically checks whether any of fbo's locks look
+// stuck, until fbo is shut down.
+func (fbo *folderBranchOps) lockWatchdog(locks []*watchedRWMutex) {
+	ticker := fbo.config.Clock().NewTicker(lockWatchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			now := fbo.config.Clock().Now()
+			for _, l := range locks {
+				l.checkForDeadlock(now)
+			}
+		case <-fbo.shutdownChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchedRWMutexLongHold(t *testing.T) {
+	clock := newTestClockNow()
+	registry := metrics.NewRegistry()
+	w := newWatchedRWMutex("testLock", clock, logger.NewTestLogger(t),
+		registry)
+	longHolds := metrics.GetOrRegisterCounter(
+		"folderBranchOps.testLock.LongHolds", registry)
+
+	w.Lock()
+	w.Unlock()
+	require.Equal(t, int64(0), longHolds.Count())
+
+	w.Lock()
+	clock.Add(2 * lockLongHoldThreshold)
+	w.Unlock()
+	require.Equal(t, int64(1), longHolds.Count())
+
+	// Reader holds aren't attributed to anyone.
+	w.RLock()
+	clock.Add(2 * lockLongHoldThreshold)
+	w.RUnlock()
+	require.Equal(t, int64(1), longHolds.Count())
+}
+
+func TestWatchedRWMutexProbableDeadlock(t *testing.T) {
+	clock := newTestClockNow()
+	registry := metrics.NewRegistry()
+	w := newWatchedRWMutex("testLock", clock, logger.NewTestLogger(t),
+		registry)
+	deadlocks := metrics.GetOrRegisterCounter(
+		"folderBranchOps.testLock.ProbableDeadlocks", registry)
+
+	w.Lock()
+	require.False(t, w.checkForDeadlock(clock.Now()))
+	clock.Add(2 * lockProbableDeadlockThreshold)
+	require.True(t, w.checkForDeadlock(clock.Now()))
+	// Only reported once per holder.
+	require.False(t, w.checkForDeadlock(clock.Now()))
+	w.Unlock()
+	require.Equal(t, int64(1), deadlocks.Count())
+
+	w.RLock()
+	w.RLock()
+	w.RUnlock()
+	clock.Add(2 * lockProbableDeadlockThreshold)
+	require.True(t, w.checkForDeadlock(clock.Now()))
+	w.RUnlock()
+	require.False(t, w.checkForDeadlock(clock.Now()))
+	require.Equal(t, int64(2), deadlocks.Count())
+}
+
+func TestWatchedRWMutexLeveled(t *testing.T) {
+	w := newWatchedRWMutex("testLock", newTestClockNow(),
+		logger.NewTestLogger(t), nil)
+	m := makeLeveledRWMutex(mutexLevel(fboBlock), w)
+	lState := makeFBOLockState()
+
+	m.Lock(lState)
+	m.AssertLocked(lState)
+	// The stack should start at the caller of the leveled mutex.
+	w.holderLock.Lock()
+	stack := formatLockStack(w.holder.pcs)
+	w.holderLock.Unlock()
+	require.Contains(t, stack, "TestWatchedRWMutexLeveled")
+	m.Unlock(lState)
+
+	m.RLock(lState)
+	m.AssertRLocked(lState)
+	m.RUnlock(lState)
+}
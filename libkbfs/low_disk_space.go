@@ -0,0 +1,96 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// lowDiskSpaceCheckPeriod is how often the free space on disk is
+// sampled, on top of the samples the disk limiter takes on puts.
+// It's short so that space used up by other programs is noticed
+// even when KBFS isn't writing anything.
+const lowDiskSpaceCheckPeriod = 10 * time.Second
+
+// diskBlockCacheBulkEvicter is implemented by the disk block caches
+// that can evict many blocks at once.
+type diskBlockCacheBulkEvicter interface {
+	evictBytes(ctx context.Context, bytes int64,
+		reason DiskBlockCacheEvictionReason) (
+		numRemoved int, sizeRemoved int64, err error)
+}
+
+func (fs *KBFSOpsStandard) lowDiskSpaceLoop() {
+	ticker := fs.config.Clock().NewTicker(lowDiskSpaceCheckPeriod)
+	defer ticker.Stop()
+	for {
+		// The disk limiter can change when journaling is
+		// enabled, so look it up each time.
+		var lowCh <-chan struct{}
+		if dl := fs.config.DiskLimiter(); dl != nil {
+			lowCh = dl.lowDiskSpaceCh()
+		}
+		select {
+		case <-ticker.C():
+		case <-lowCh:
+		case <-fs.lowDiskSpaceShutdownChan:
+			return
+		}
+		ctx := ctxWithRandomIDReplayable(context.Background(),
+			CtxKBFSOpsLowDiskSpaceIDKey, CtxKBFSOpsLowDiskSpaceOpID, fs.log)
+		fs.checkLowDiskSpace(ctx)
+	}
+}
+
+// checkLowDiskSpace samples the free space on disk.  While it's below
+// the disk limiter's minimum, block puts to the journals fail, and
+// this evicts blocks from the disk cache to get back to twice the
+// minimum, pauses prefetching, and reports the disk space service as
+// failing.
+func (fs *KBFSOpsStandard) checkLowDiskSpace(ctx context.Context) {
+	dl := fs.config.DiskLimiter()
+	if dl == nil {
+		return
+	}
+	freeBytes, minFreeBytes, err := dl.checkLowDiskSpace(ctx)
+	if err != nil {
+		fs.log.CDebugf(ctx, "Couldn't check the free disk space: %+v", err)
+		return
+	}
+	low := minFreeBytes > 0 && freeBytes < minFreeBytes
+
+	fs.lowDiskSpaceLock.Lock()
+	wasLow := fs.lowDiskSpace
+	fs.lowDiskSpace = low
+	fs.lowDiskSpaceLock.Unlock()
+
+	if low != wasLow {
+		fs.bgWork.setLowDiskSpace(ctx, low)
+	}
+	if !low {
+		if wasLow {
+			fs.log.CDebugf(ctx, "%d bytes are free on disk again", freeBytes)
+			fs.PushConnectionStatusChange(DiskSpaceServiceName, nil)
+		}
+		return
+	}
+
+	fs.PushConnectionStatusChange(DiskSpaceServiceName, DiskSpaceLowError{
+		FreeBytes: freeBytes, MinFreeBytes: minFreeBytes})
+	evicter, ok := fs.config.DiskBlockCache().(diskBlockCacheBulkEvicter)
+	if !ok {
+		return
+	}
+	numRemoved, sizeRemoved, err := evicter.evictBytes(
+		ctx, 2*minFreeBytes-freeBytes, EvictionReasonLowDiskSpace)
+	fs.log.CWarningf(ctx, "Only %d bytes are free on disk; evicted %d "+
+		"blocks (%d bytes) from the disk cache", freeBytes, numRemoved,
+		sizeRemoved)
+	if err != nil {
+		fs.log.CDebugf(ctx, "Couldn't evict from the disk cache: %+v", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKBFSOpsLowDiskSpace(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	const minFreeBytes = 1 << 20
+	freeBytes := int64(10 * minFreeBytes)
+	cacheConfig := newTestDiskBlockCacheConfig(t)
+	limiter, err := newBackpressureDiskLimiter(cacheConfig.MakeLogger(""),
+		backpressureDiskLimiterParams{
+			minThreshold:    0.5,
+			maxThreshold:    0.95,
+			journalFrac:     0.25,
+			journalMDFrac:   0.25,
+			diskCacheFrac:   0.25,
+			publicCacheFrac: 0.25,
+			syncCacheFrac:   0.25,
+			readSpillFrac:   0.25,
+			byteLimit:       testDiskBlockCacheMaxBytes,
+			fileLimit:       10000,
+			maxDelay:        time.Second,
+			clock:           cacheConfig.Clock(),
+			delayFn:         defaultDoDelay,
+			freeBytesAndFilesFn: func() (int64, int64, error) {
+				return atomic.LoadInt64(&freeBytes), 10000, nil
+			},
+			minFreeBytes: minFreeBytes,
+		})
+	require.NoError(t, err)
+	cache, err := newDiskBlockCacheStandardForTest(
+		cacheConfig, testDiskBlockCacheMaxBytes, limiter)
+	require.NoError(t, err)
+	// The low disk space loop reads the limiter in the background.
+	config.lock.Lock()
+	config.diskLimiter = limiter
+	config.lock.Unlock()
+	config.SetDiskBlockCache(cache)
+
+	tlfID := tlf.FakeID(1, false)
+	for i := 0; i < 20; i++ {
+		blockID, buf, serverHalf := setupBlockForDiskCache(t, cacheConfig)
+		err := cache.Put(ctx, tlfID, blockID, buf, serverHalf)
+		require.NoError(t, err)
+	}
+	require.NotZero(t, cache.Size())
+
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	kbfsOps.checkLowDiskSpace(ctx)
+	status, _, err := kbfsOps.Status(ctx)
+	require.NoError(t, err)
+	require.Nil(t, status.FailingServices[DiskSpaceServiceName])
+	require.False(t, status.BackgroundWork.LowDiskSpace)
+
+	t.Log("Running low on space evicts the whole cache, since it's " +
+		"smaller than the space needed")
+	atomic.StoreInt64(&freeBytes, minFreeBytes/2)
+	kbfsOps.checkLowDiskSpace(ctx)
+	require.Zero(t, cache.Size())
+	evictions := cache.RecentEvictions()
+	require.Len(t, evictions, 20)
+	require.Equal(t, EvictionReasonLowDiskSpace, evictions[0].Reason)
+	status, _, err = kbfsOps.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, DiskSpaceLowError{minFreeBytes / 2, minFreeBytes},
+		status.FailingServices[DiskSpaceServiceName])
+	require.True(t, status.BackgroundWork.LowDiskSpace)
+	require.True(t, status.BackgroundWork.PrefetchingPaused)
+
+	t.Log("Getting the space back clears the status")
+	atomic.StoreInt64(&freeBytes, 2*minFreeBytes)
+	kbfsOps.checkLowDiskSpace(ctx)
+	status, _, err = kbfsOps.Status(ctx)
+	require.NoError(t, err)
+	require.Nil(t, status.FailingServices[DiskSpaceServiceName])
+	require.False(t, status.BackgroundWork.LowDiskSpace)
+	require.False(t, status.BackgroundWork.PrefetchingPaused)
+}
@@ -5,7 +5,9 @@
 package libkbfs
 
 import (
+	"os"
 	"path/filepath"
+	"runtime"
 	"time"
 
 	"github.com/keybase/client/go/logger"
@@ -16,6 +18,7 @@ import (
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
 )
 
 // ImmutableBareRootMetadata is a thin wrapper around a
@@ -122,7 +125,11 @@ func (ibrmd ImmutableBareRootMetadata) MakeBareTlfHandleWithExtra() (
 type mdJournal struct {
 	// key is assumed to be the VerifyingKey of a device owned by
 	// uid, and both uid and key are assumed constant for the
-	// lifetime of this object.
+	// lifetime of this object.  The journal isn't encrypted
+	// with a device-local key; MDs are stored as signed and
+	// encrypted for the server, so a device key change only
+	// requires the existing journal to be flushed (or cleared)
+	// by the old device, not re-encrypted.
 	uid keybase1.UID
 	key kbfscrypto.VerifyingKey
 
@@ -153,6 +160,16 @@ type mdJournal struct {
 	// flushing. This doesn't need to be persisted for the same
 	// reason as branchID.
 	lastMdID MdID
+
+	// verified remembers which MDs in the journal have already
+	// been verified, since they're read back many times while
+	// flushing.
+	verified *mdVerifyCache
+
+	// storedBytes is the number of bytes taken up by the MD
+	// directories under mdsPath(). It doesn't count the V3 key
+	// bundles, which are few and never garbage-collected.
+	storedBytes int64
 }
 
 func makeMDJournalWithIDJournal(
@@ -180,6 +197,7 @@ func makeMDJournalWithIDJournal(
 		log:      log,
 		deferLog: deferLog,
 		j:        idJournal,
+		verified: newMDVerifyCache(defaultMDVerifyCacheCapacity),
 	}
 
 	_, earliest, _, _, err := journal.getEarliestWithExtra(false)
@@ -187,6 +205,11 @@ func makeMDJournalWithIDJournal(
 		return nil, err
 	}
 
+	journal.storedBytes, err = getMDDirSize(journal.mdsPath())
+	if err != nil {
+		return nil, err
+	}
+
 	latest, err := journal.getLatest(false)
 	if err != nil {
 		return nil, err
@@ -285,6 +308,28 @@ func (j mdJournal) mdInfoPath(id MdID) string {
 	return filepath.Join(j.mdPath(id), "info.json")
 }
 
+// getMDDirSize returns the total size of the files under dir, which
+// may not exist.
+func getMDDirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(
+		path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	if ioutil.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
 // mdInfo is the structure stored in mdInfoPath(id).
 //
 // TODO: Handle unknown fields? We'd have to build a handler for this,
@@ -468,9 +513,14 @@ func (j mdJournal) getMDAndExtra(entry mdIDJournalEntry, verifyBranchID bool) (
 		return nil, nil, time.Time{}, err
 	}
 
-	err = rmd.IsValidAndSigned(j.codec, j.crypto, extra)
-	if err != nil {
-		return nil, nil, time.Time{}, err
+	// The extra metadata was checked against its IDs above, and
+	// the MD ID covers the rest.
+	if !j.verified.isVerified(mdID, kbfscrypto.SignatureInfo{}) {
+		err = rmd.IsValidAndSigned(j.codec, j.crypto, extra)
+		if err != nil {
+			return nil, nil, time.Time{}, err
+		}
+		j.verified.setVerified(mdID, kbfscrypto.SignatureInfo{})
 	}
 
 	if verifyBranchID && rmd.BID() != j.branchID &&
@@ -486,7 +536,7 @@ func (j mdJournal) getMDAndExtra(entry mdIDJournalEntry, verifyBranchID bool) (
 // putMD stores the given metadata under its ID, if it's not already
 // stored. The extra metadata is put separately, since sometimes,
 // (e.g., when converting to a branch) we don't need to put it.
-func (j mdJournal) putMD(rmd BareRootMetadata) (MdID, error) {
+func (j *mdJournal) putMD(rmd BareRootMetadata) (MdID, error) {
 	// TODO: Make crypto and RMD wrap errors.
 
 	err := rmd.IsLastModifiedBy(j.uid, j.key)
@@ -520,16 +570,27 @@ func (j mdJournal) putMD(rmd BareRootMetadata) (MdID, error) {
 		return MdID{}, err
 	}
 
+	size, err := getMDDirSize(j.mdPath(id))
+	if err != nil {
+		return MdID{}, err
+	}
+	j.storedBytes += size
+
 	return id, nil
 }
 
 // removeMD removes the metadata (which must exist) with the given ID.
 func (j *mdJournal) removeMD(id MdID) error {
 	path := j.mdPath(id)
-	err := ioutil.RemoveAll(path)
+	size, err := getMDDirSize(path)
+	if err != nil {
+		return err
+	}
+	err = ioutil.RemoveAll(path)
 	if err != nil {
 		return err
 	}
+	j.storedBytes -= size
 
 	// Remove the parent (splayed) directory (which should exist)
 	// if it's empty.
@@ -877,6 +938,7 @@ func (j *mdJournal) removeFlushedEntry(
 				return err
 			}
 		}
+		j.storedBytes = 0
 	} else {
 		// Garbage-collect the old entry. If we crash here and
 		// leave behind an entry, it'll be cleaned up the next
@@ -999,6 +1061,10 @@ func (j mdJournal) readLatestRevision() (MetadataRevision, error) {
 	return j.j.readLatestRevision()
 }
 
+func (j mdJournal) getStoredBytes() int64 {
+	return j.storedBytes
+}
+
 func (j mdJournal) length() uint64 {
 	return j.j.length()
 }
@@ -1105,7 +1171,8 @@ func (j mdJournal) getRange(bid BranchID, start, stop MetadataRevision) (
 	if err != nil {
 		return nil, err
 	}
-	var ibrmds []ImmutableBareRootMetadata
+	var revs []MetadataRevision
+	var toGet []mdIDJournalEntry
 	for i, entry := range entries {
 		if getLocalSquashPrefix && !entry.IsLocalSquash {
 			// We only need the prefix up to the first non-local-squash.
@@ -1114,22 +1181,47 @@ func (j mdJournal) getRange(bid BranchID, start, stop MetadataRevision) (
 			// Ignore the local squash prefix of this journal.
 			continue
 		}
-
-		expectedRevision := realStart + MetadataRevision(i)
-		brmd, extra, ts, err := j.getMDAndExtra(entry, true)
-		if err != nil {
-			return nil, err
-		}
-
-		if expectedRevision != brmd.RevisionNumber() {
-			panic(errors.Errorf("expected revision %v, got %v",
-				expectedRevision, brmd.RevisionNumber()))
-		}
-		ibrmd := MakeImmutableBareRootMetadata(
-			brmd, extra, entry.ID, ts)
-		ibrmds = append(ibrmds, ibrmd)
+		revs = append(revs, realStart+MetadataRevision(i))
+		toGet = append(toGet, entry)
+	}
+
+	// Verifying MDs is CPU-bound, so spread a long range over all
+	// the cores.
+	ibrmds := make([]ImmutableBareRootMetadata, len(toGet))
+	var eg errgroup.Group
+	indices := make(chan int, len(toGet))
+	for i := range toGet {
+		indices <- i
+	}
+	close(indices)
+	numWorkers := runtime.NumCPU()
+	if numWorkers > len(toGet) {
+		numWorkers = len(toGet)
+	}
+	for w := 0; w < numWorkers; w++ {
+		eg.Go(func() error {
+			for i := range indices {
+				entry := toGet[i]
+				brmd, extra, ts, err := j.getMDAndExtra(entry, true)
+				if err != nil {
+					return err
+				}
+				if revs[i] != brmd.RevisionNumber() {
+					panic(errors.Errorf("expected revision %v, got %v",
+						revs[i], brmd.RevisionNumber()))
+				}
+				ibrmds[i] = MakeImmutableBareRootMetadata(
+					brmd, extra, entry.ID, ts)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	if len(ibrmds) == 0 {
+		return nil, nil
 	}
-
 	return ibrmds, nil
 }
 
@@ -1521,7 +1613,11 @@ func (j *mdJournal) resolveAndClear(
 	// Transform the other journal into the old journal and clear
 	// it out.
 	*j, *otherJournal = *otherJournal, *j
+	storedBytes := otherJournal.storedBytes
 	err = otherJournal.clearHelper(ctx, bid, earliestBranchRevision)
+	// Both journals share the same MD directories, so the MDs
+	// cleared from the other journal come out of this one's count.
+	j.storedBytes -= storedBytes - otherJournal.storedBytes
 	if err != nil {
 		return MdID{}, err
 	}
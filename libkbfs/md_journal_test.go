@@ -532,10 +532,20 @@ func testMDJournalGCd(t *testing.T, j *mdJournal) {
 		_, err := ioutil.Stat(dir)
 		require.True(t, ioutil.IsNotExist(err))
 	}
+	require.Zero(t, j.getStoredBytes())
+}
+
+// checkMDJournalStoredBytes checks that the stored bytes tracked by j
+// match what's actually on disk.
+func checkMDJournalStoredBytes(t *testing.T, j *mdJournal) {
+	size, err := getMDDirSize(j.mdsPath())
+	require.NoError(t, err)
+	require.Equal(t, size, j.getStoredBytes())
 }
 
 func flushAllMDs(
 	t *testing.T, ctx context.Context, signer kbfscrypto.Signer, j *mdJournal) {
+	checkMDJournalStoredBytes(t, j)
 	end, err := j.end()
 	require.NoError(t, err)
 	for {
@@ -545,6 +555,7 @@ func flushAllMDs(
 			break
 		}
 		j.removeFlushedEntry(ctx, mdID, rmds)
+		checkMDJournalStoredBytes(t, j)
 	}
 	testMDJournalGCd(t, j)
 }
@@ -678,6 +689,7 @@ func testMDJournalResolveAndClear(t *testing.T, ver MetadataVer, bid BranchID) {
 	resolveMdID, err := j.resolveAndClear(
 		ctx, signer, ekg, bsplit, mdcache, bid, md)
 	require.NoError(t, err)
+	checkMDJournalStoredBytes(t, j)
 
 	require.Equal(t, uint64(1), j.length())
 	head, err := j.getHead(NullBranchID)
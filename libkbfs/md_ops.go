@@ -15,6 +15,7 @@ import (
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfssync"
 	"github.com/keybase/kbfs/tlf"
 	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
@@ -24,15 +25,47 @@ import (
 // layers, and processes RootMetadataSigned objects (encrypted and
 // signed) suitable for passing to/from the MDServer backend.
 type MDOpsStandard struct {
-	config Config
-	log    logger.Logger
+	config   Config
+	log      logger.Logger
+	verified *mdVerifyCache
+	merkle   *merkleChecker
+
+	// protects headsLoaded
+	headsLoadedLock sync.Mutex
+	// headsLoaded holds the TLFs whose merged heads have been
+	// loaded since startup.  Only the first load of each TLF may
+	// use a head from the disk MD cache.
+	headsLoaded map[tlf.ID]bool
+	// refreshGroup tracks the outstanding background refreshes of
+	// heads from the disk MD cache.
+	refreshGroup kbfssync.RepeatedWaitGroup
 }
 
 // NewMDOpsStandard returns a new MDOpsStandard
 func NewMDOpsStandard(config Config) *MDOpsStandard {
-	return &MDOpsStandard{config, config.MakeLogger("")}
+	return &MDOpsStandard{
+		config:      config,
+		log:         config.MakeLogger(""),
+		verified:    newMDVerifyCache(defaultMDVerifyCacheCapacity),
+		merkle:      newMerkleChecker(config),
+		headsLoaded: make(map[tlf.ID]bool),
+	}
 }
 
+// CtxMDOpsTagKey is the type used for unique context tags within
+// MDOpsStandard.
+type CtxMDOpsTagKey int
+
+const (
+	// CtxMDOpsIDKey is the type of the tag for unique operation IDs
+	// within MDOpsStandard.
+	CtxMDOpsIDKey CtxMDOpsTagKey = iota
+)
+
+// CtxMDOpsOpID is the display name for the unique operation
+// MDOpsStandard ID tag.
+const CtxMDOpsOpID = "MDOPSID"
+
 // convertVerifyingKeyError gives a better error when the TLF was
 // signed by a key that is no longer associated with the last writer.
 func (md *MDOpsStandard) convertVerifyingKeyError(ctx context.Context,
@@ -166,16 +199,31 @@ func (md *MDOpsStandard) verifyWriterKey(ctx context.Context,
 	}
 }
 
-// processMetadata converts the given rmds to an
-// ImmutableRootMetadata. After this function is called, rmds
-// shouldn't be used.
-func (md *MDOpsStandard) processMetadata(ctx context.Context,
+// verifyMetadata checks the validity and signatures of rmds, and
+// that the keys it was signed with belonged to its writer and last
+// modifying user.
+func (md *MDOpsStandard) verifyMetadata(ctx context.Context,
 	handle *TlfHandle, rmds *RootMetadataSigned, extra ExtraMetadata,
-	getRangeLock *sync.Mutex) (ImmutableRootMetadata, error) {
-	// First, verify validity and signatures.
-	err := rmds.IsValidAndSigned(md.config.Codec(), md.config.Crypto(), extra)
+	getRangeLock *sync.Mutex) error {
+	// First, verify validity and signatures, unless this exact MD
+	// has been verified before.
+	mdID, err := md.config.Crypto().MakeMdID(rmds.MD)
 	if err != nil {
-		return ImmutableRootMetadata{}, MDMismatchError{
+		return err
+	}
+	if md.verified.isVerified(mdID, rmds.SigInfo) {
+		// The key bundles come separately from the MD, so they
+		// still need checking.
+		err = checkExtraMetadataIDs(md.config.Crypto(), rmds.MD, extra)
+	} else {
+		err = rmds.IsValidAndSigned(
+			md.config.Codec(), md.config.Crypto(), extra)
+		if err == nil {
+			md.verified.setVerified(mdID, rmds.SigInfo)
+		}
+	}
+	if err != nil {
+		return MDMismatchError{
 			rmds.MD.RevisionNumber(), handle.GetCanonicalPath(),
 			rmds.MD.TlfID(), err,
 		}
@@ -183,7 +231,7 @@ func (md *MDOpsStandard) processMetadata(ctx context.Context,
 
 	// Then, verify the verifying keys.
 	if err := md.verifyWriterKey(ctx, rmds, handle, getRangeLock); err != nil {
-		return ImmutableRootMetadata{}, err
+		return err
 	}
 
 	if handle.IsFinal() {
@@ -197,9 +245,46 @@ func (md *MDOpsStandard) processMetadata(ctx context.Context,
 			rmds.untrustedServerTimestamp)
 	}
 	if err != nil {
-		return ImmutableRootMetadata{}, md.convertVerifyingKeyError(ctx, rmds, handle, err)
+		return md.convertVerifyingKeyError(ctx, rmds, handle, err)
+	}
+	return nil
+}
+
+// processMetadata converts the given rmds to an
+// ImmutableRootMetadata. After this function is called, rmds
+// shouldn't be used.
+func (md *MDOpsStandard) processMetadata(ctx context.Context,
+	handle *TlfHandle, rmds *RootMetadataSigned, extra ExtraMetadata,
+	getRangeLock *sync.Mutex) (ImmutableRootMetadata, error) {
+	err := md.verifyMetadata(ctx, handle, rmds, extra, getRangeLock)
+	if err != nil {
+		return ImmutableRootMetadata{}, err
+	}
+
+	// TODO: Avoid having to do this type assertion.
+	brmd, ok := rmds.MD.(MutableBareRootMetadata)
+	if !ok {
+		return ImmutableRootMetadata{}, MutableBareRootMetadataNoImplError{}
+	}
+
+	localTimestamp := rmds.untrustedServerTimestamp
+	if offset, ok := md.config.MDServer().OffsetFromServerTime(); ok {
+		localTimestamp = localTimestamp.Add(offset)
 	}
 
+	key := rmds.GetWriterMetadataSigInfo().VerifyingKey
+	*rmds = RootMetadataSigned{}
+	return md.makeImmutableRootMetadata(
+		ctx, handle, brmd, extra, key, localTimestamp)
+}
+
+// makeImmutableRootMetadata decrypts the private metadata of the
+// given already-verified MD, and wraps it up in an
+// ImmutableRootMetadata.
+func (md *MDOpsStandard) makeImmutableRootMetadata(ctx context.Context,
+	handle *TlfHandle, brmd MutableBareRootMetadata, extra ExtraMetadata,
+	key kbfscrypto.VerifyingKey, localTimestamp time.Time) (
+	ImmutableRootMetadata, error) {
 	// Get the UID unless this is a public tlf - then proceed with empty uid.
 	var uid keybase1.UID
 	if !handle.IsPublic() {
@@ -210,12 +295,6 @@ func (md *MDOpsStandard) processMetadata(ctx context.Context,
 		uid = session.UID
 	}
 
-	// TODO: Avoid having to do this type assertion.
-	brmd, ok := rmds.MD.(MutableBareRootMetadata)
-	if !ok {
-		return ImmutableRootMetadata{}, MutableBareRootMetadataNoImplError{}
-	}
-
 	rmd := makeRootMetadata(brmd, extra, handle)
 	// Try to decrypt using the keys available in this md.  If that
 	// doesn't work, a future MD may contain more keys and will be
@@ -235,14 +314,163 @@ func (md *MDOpsStandard) processMetadata(ctx context.Context,
 		return ImmutableRootMetadata{}, err
 	}
 
-	localTimestamp := rmds.untrustedServerTimestamp
-	if offset, ok := md.config.MDServer().OffsetFromServerTime(); ok {
-		localTimestamp = localTimestamp.Add(offset)
+	return MakeImmutableRootMetadata(rmd, key, mdID, localTimestamp), nil
+}
+
+// putToDiskCache records a verified merged MD and its signatures in
+// the disk MD cache, if there is one, along with the canonical path p
+// of its TLF if p isn't empty.  Failures are only logged, since the
+// cache is just an optimization.
+func (md *MDOpsStandard) putToDiskCache(ctx context.Context, p string,
+	irmd ImmutableRootMetadata,
+	sigInfo, writerSigInfo kbfscrypto.SignatureInfo) {
+	dmc := md.config.DiskMDCache()
+	if dmc == nil || irmd.MergedStatus() != Merged {
+		return
+	}
+	if err := dmc.Put(ctx, p, irmd, sigInfo, writerSigInfo); err != nil {
+		md.log.CDebugf(ctx, "Couldn't put revision %d of %s into the "+
+			"disk MD cache: %+v", irmd.Revision(), irmd.TlfID(), err)
 	}
+}
 
-	key := rmds.GetWriterMetadataSigInfo().VerifyingKey
-	*rmds = RootMetadataSigned{}
-	return MakeImmutableRootMetadata(rmd, key, mdID, localTimestamp), nil
+// decodeDiskMDCacheEntry decodes an MD from the disk MD cache, along
+// with its key bundles and handle.
+func (md *MDOpsStandard) decodeDiskMDCacheEntry(ctx context.Context,
+	id tlf.ID, entry DiskMDCacheEntry) (
+	MutableBareRootMetadata, ExtraMetadata, *TlfHandle, error) {
+	brmd, err := DecodeRootMetadata(md.config.Codec(), id, entry.Version,
+		md.config.MetadataVersion(), entry.Buf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if brmd.TlfID() != id {
+		return nil, nil, nil, fmt.Errorf(
+			"Cached MD has TLF ID %s, expected %s", brmd.TlfID(), id)
+	}
+	var extra ExtraMetadata
+	if entry.WKB != nil && entry.RKB != nil {
+		extra = NewExtraMetadataV3(*entry.WKB, *entry.RKB, false, false)
+	}
+	bareHandle, err := brmd.MakeBareTlfHandle(extra)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	handle, err := MakeTlfHandle(ctx, bareHandle, md.config.KBPKI())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return brmd, extra, handle, nil
+}
+
+// loadDiskMDCacheEntry turns an MD from the disk MD cache back into
+// an ImmutableRootMetadata.  The MD was verified before it was put
+// into the cache, so only its private metadata needs to be
+// decrypted again.
+func (md *MDOpsStandard) loadDiskMDCacheEntry(ctx context.Context,
+	id tlf.ID, entry DiskMDCacheEntry) (ImmutableRootMetadata, error) {
+	brmd, extra, handle, err := md.decodeDiskMDCacheEntry(ctx, id, entry)
+	if err != nil {
+		return ImmutableRootMetadata{}, err
+	}
+	return md.makeImmutableRootMetadata(ctx, handle, brmd, extra,
+		entry.WriterVerifyingKey, time.Unix(0, entry.LocalTimestamp))
+}
+
+// getFromDiskCache returns the given merged revision from the disk MD
+// cache.
+func (md *MDOpsStandard) getFromDiskCache(ctx context.Context, id tlf.ID,
+	rev MetadataRevision) (ImmutableRootMetadata, error) {
+	dmc := md.config.DiskMDCache()
+	if dmc == nil {
+		return ImmutableRootMetadata{}, NoSuchMDError{id, rev, NullBranchID}
+	}
+	entry, err := dmc.Get(ctx, id, rev)
+	if err != nil {
+		return ImmutableRootMetadata{}, err
+	}
+	return md.loadDiskMDCacheEntry(ctx, id, entry)
+}
+
+// markHeadLoaded records that the merged head of the given TLF has
+// been loaded, and returns whether it had been loaded before.
+func (md *MDOpsStandard) markHeadLoaded(id tlf.ID) (loadedBefore bool) {
+	md.headsLoadedLock.Lock()
+	defer md.headsLoadedLock.Unlock()
+	loadedBefore = md.headsLoaded[id]
+	md.headsLoaded[id] = true
+	return loadedBefore
+}
+
+// getHeadForHandleFromDiskCache returns the most recent merged MD
+// cached on disk for the given handle, or an empty
+// ImmutableRootMetadata if there isn't one.  The MD is only used for
+// the first load of its TLF, and its signatures and keys are
+// verified again first, since it doesn't come from the server.
+func (md *MDOpsStandard) getHeadForHandleFromDiskCache(
+	ctx context.Context, handle *TlfHandle) (
+	tlf.ID, ImmutableRootMetadata) {
+	dmc := md.config.DiskMDCache()
+	if dmc == nil {
+		return tlf.NullID, ImmutableRootMetadata{}
+	}
+	p := handle.GetCanonicalPath()
+	id, err := dmc.GetTlfID(ctx, p)
+	if err != nil {
+		return tlf.NullID, ImmutableRootMetadata{}
+	}
+	if md.markHeadLoaded(id) {
+		return tlf.NullID, ImmutableRootMetadata{}
+	}
+	entry, err := dmc.GetHead(ctx, id)
+	if err != nil {
+		return tlf.NullID, ImmutableRootMetadata{}
+	}
+	brmd, extra, mdHandle, err := md.decodeDiskMDCacheEntry(ctx, id, entry)
+	if err != nil {
+		md.log.CDebugf(ctx, "Couldn't decode cached head for %s: %+v",
+			p, err)
+		return tlf.NullID, ImmutableRootMetadata{}
+	}
+	if mdHandle.GetCanonicalPath() != p {
+		// The handle has changed since the MD was cached, so
+		// let the server sort it out.
+		return tlf.NullID, ImmutableRootMetadata{}
+	}
+	rmds, err := makeRootMetadataSigned(
+		entry.SigInfo, entry.WriterSigInfo, brmd, time.Time{})
+	if err == nil {
+		err = md.verifyMetadata(ctx, mdHandle, rmds, extra, nil)
+	}
+	if err != nil {
+		md.log.CWarningf(ctx, "Couldn't verify cached head for %s: %+v",
+			p, err)
+		return tlf.NullID, ImmutableRootMetadata{}
+	}
+	irmd, err := md.makeImmutableRootMetadata(ctx, mdHandle, brmd, extra,
+		rmds.GetWriterMetadataSigInfo().VerifyingKey,
+		time.Unix(0, entry.LocalTimestamp))
+	if err != nil {
+		md.log.CDebugf(ctx, "Couldn't load cached head for %s: %+v", p, err)
+		return tlf.NullID, ImmutableRootMetadata{}
+	}
+	return id, irmd
+}
+
+// refreshHeadForHandle gets the merged head for handle from the
+// server in the background, after a head from the disk MD cache has
+// been used in its place.  Any newer revisions reach the folder
+// through its update registration; this makes sure the server's head
+// checks out, and brings the disk MD cache up to date.
+func (md *MDOpsStandard) refreshHeadForHandle(handle *TlfHandle) {
+	defer md.refreshGroup.Done()
+	ctx := ctxWithRandomIDReplayable(
+		context.Background(), CtxMDOpsIDKey, CtxMDOpsOpID, md.log)
+	_, _, err := md.getForHandleFromServer(ctx, handle, Merged)
+	if err != nil {
+		md.log.CWarningf(ctx, "Couldn't refresh the cached head for "+
+			"%s from the server: %+v", handle.GetCanonicalPath(), err)
+	}
 }
 
 // GetForHandle implements the MDOps interface for MDOpsStandard.
@@ -262,13 +490,38 @@ func (md *MDOpsStandard) GetForHandle(ctx context.Context, handle *TlfHandle,
 		}
 	}()
 
+	if mStatus == Merged {
+		// On a cold start, use the head from the last run if we
+		// have it.  It might be stale, but any newer revisions will
+		// be fetched when the folder registers for updates.
+		id, rmd = md.getHeadForHandleFromDiskCache(ctx, handle)
+		if rmd != (ImmutableRootMetadata{}) {
+			md.log.CDebugf(ctx, "Using head from the disk MD cache")
+			md.refreshGroup.Add(1)
+			go md.refreshHeadForHandle(handle)
+			return id, rmd, nil
+		}
+	}
+
+	return md.getForHandleFromServer(ctx, handle, mStatus)
+}
+
+// getForHandleFromServer gets the head for handle from the server.
+func (md *MDOpsStandard) getForHandleFromServer(ctx context.Context,
+	handle *TlfHandle, mStatus MergeStatus) (
+	id tlf.ID, rmd ImmutableRootMetadata, err error) {
 	mdserv := md.config.MDServer()
 	bh, err := handle.ToBareHandle()
 	if err != nil {
 		return tlf.ID{}, ImmutableRootMetadata{}, err
 	}
 
-	id, rmds, err := mdserv.GetForHandle(ctx, bh, mStatus)
+	var rmds *RootMetadataSigned
+	err = runWithPhaseBudget(ctx, opPhaseMDGet,
+		func(ctx context.Context) (err error) {
+			id, rmds, err = mdserv.GetForHandle(ctx, bh, mStatus)
+			return err
+		})
 	if err != nil {
 		return tlf.ID{}, ImmutableRootMetadata{}, err
 	}
@@ -308,11 +561,24 @@ func (md *MDOpsStandard) GetForHandle(ctx context.Context, handle *TlfHandle,
 	// consistency. In the future, we'd want to eventually notify
 	// the upper layers of the new name, either directly, or
 	// through a rekey.
+	sigInfo, writerSigInfo := rmds.SigInfo, rmds.WriterSigInfo
 	rmd, err = md.processMetadata(ctx, mdHandle, rmds, extra, nil)
 	if err != nil {
 		return tlf.ID{}, ImmutableRootMetadata{}, err
 	}
 
+	if mStatus == Merged {
+		err = md.merkle.check(ctx, rmds, rmd)
+		if err != nil {
+			return tlf.ID{}, ImmutableRootMetadata{}, err
+		}
+	}
+
+	if mStatus == Merged {
+		md.markHeadLoaded(rmd.TlfID())
+	}
+	md.putToDiskCache(
+		ctx, handle.GetCanonicalPath(), rmd, sigInfo, writerSigInfo)
 	return id, rmd, nil
 }
 
@@ -341,7 +607,12 @@ func (md *MDOpsStandard) processMetadataWithID(ctx context.Context,
 
 func (md *MDOpsStandard) getForTLF(ctx context.Context, id tlf.ID,
 	bid BranchID, mStatus MergeStatus) (ImmutableRootMetadata, error) {
-	rmds, err := md.config.MDServer().GetForTLF(ctx, id, bid, mStatus)
+	var rmds *RootMetadataSigned
+	err := runWithPhaseBudget(ctx, opPhaseMDGet,
+		func(ctx context.Context) (err error) {
+			rmds, err = md.config.MDServer().GetForTLF(ctx, id, bid, mStatus)
+			return err
+		})
 	if err != nil {
 		return ImmutableRootMetadata{}, err
 	}
@@ -361,10 +632,19 @@ func (md *MDOpsStandard) getForTLF(ctx context.Context, id tlf.ID,
 	if err != nil {
 		return ImmutableRootMetadata{}, err
 	}
+	sigInfo, writerSigInfo := rmds.SigInfo, rmds.WriterSigInfo
 	rmd, err := md.processMetadataWithID(ctx, id, bid, handle, rmds, extra, nil)
 	if err != nil {
 		return ImmutableRootMetadata{}, err
 	}
+	if mStatus == Merged {
+		err = md.merkle.check(ctx, rmds, rmd)
+		if err != nil {
+			return ImmutableRootMetadata{}, err
+		}
+		md.markHeadLoaded(id)
+	}
+	md.putToDiskCache(ctx, "", rmd, sigInfo, writerSigInfo)
 	return rmd, nil
 }
 
@@ -458,21 +738,19 @@ func (md *MDOpsStandard) processRange(ctx context.Context, id tlf.ID,
 		irmds[i] = irmd
 	}
 
-	// Now that we have all the immutable RootMetadatas, verify that
-	// the given MD objects form a valid sequence.
+	return irmds, nil
+}
+
+// checkMDSequence verifies that the given MD objects, sorted by
+// revision, form a valid sequence.
+func checkMDSequence(irmds []ImmutableRootMetadata) error {
 	var prevIRMD ImmutableRootMetadata
 	for _, irmd := range irmds {
 		if prevIRMD != (ImmutableRootMetadata{}) {
-			// Ideally, we'd call
-			// ReadOnlyRootMetadata.CheckValidSuccessor()
-			// instead. However, we only convert r.MD to
-			// an ImmutableRootMetadata in
-			// processMetadataWithID below, and we want to
-			// do this check before then.
-			err = prevIRMD.bareMd.CheckValidSuccessor(
+			err := prevIRMD.bareMd.CheckValidSuccessor(
 				prevIRMD.mdID, irmd.bareMd)
 			if err != nil {
-				return nil, MDMismatchError{
+				return MDMismatchError{
 					prevIRMD.Revision(),
 					irmd.GetTlfHandle().GetCanonicalPath(),
 					prevIRMD.TlfID(), err,
@@ -488,22 +766,127 @@ func (md *MDOpsStandard) processRange(ctx context.Context, id tlf.ID,
 	// indeed valid, this probably isn't a huge deal, but it may let
 	// the server rollback or truncate unmerged history...
 
-	return irmds, nil
+	return nil
+}
+
+// getCachedRange looks up the first maxMDsAtATime revisions of
+// [start, stop] in the MD cache, falling back to the disk MD cache
+// for merged revisions.  It returns the ones it finds, and the
+// ranges of revisions that still need to be fetched from the server.
+//
+// Cached revisions are only used for merged ranges of TLFs without a
+// journal.  Otherwise the cache may hold revisions that the journal
+// later replaces, when it flushes or squashes them.
+func (md *MDOpsStandard) getCachedRange(ctx context.Context, id tlf.ID,
+	bid BranchID, start, stop MetadataRevision) (
+	cached map[MetadataRevision]ImmutableRootMetadata, toFetch []mdRange) {
+	cached = make(map[MetadataRevision]ImmutableRootMetadata)
+	if bid != NullBranchID || TLFJournalEnabled(md.config, id) {
+		return cached, []mdRange{{start, stop}}
+	}
+	end := stop
+	if end-start >= maxMDsAtATime {
+		end = start + maxMDsAtATime - 1
+	}
+	mdcache := md.config.MDCache()
+	for rev := start; rev <= end; rev++ {
+		irmd, err := mdcache.Get(id, rev, bid)
+		if err != nil && bid == NullBranchID {
+			irmd, err = md.getFromDiskCache(ctx, id, rev)
+			if err == nil {
+				err = mdcache.Put(irmd)
+			}
+		}
+		if err == nil {
+			cached[rev] = irmd
+			continue
+		}
+		if len(toFetch) == 0 || toFetch[len(toFetch)-1].end != rev-1 {
+			toFetch = append(toFetch, mdRange{rev, rev})
+		}
+		toFetch[len(toFetch)-1].end = rev
+	}
+	if end < stop {
+		if len(toFetch) != 0 && toFetch[len(toFetch)-1].end == end {
+			toFetch[len(toFetch)-1].end = stop
+		} else {
+			toFetch = append(toFetch, mdRange{end + 1, stop})
+		}
+	}
+	return cached, toFetch
 }
 
 func (md *MDOpsStandard) getRange(ctx context.Context, id tlf.ID,
 	bid BranchID, mStatus MergeStatus, start, stop MetadataRevision) (
 	[]ImmutableRootMetadata, error) {
-	rmds, err := md.config.MDServer().GetRange(
-		ctx, id, bid, mStatus, start, stop)
-	if err != nil {
-		return nil, err
+	if start < MetadataRevisionInitial {
+		start = MetadataRevisionInitial
 	}
-	rmd, err := md.processRange(ctx, id, bid, rmds)
-	if err != nil {
+	if stop < start {
+		return nil, nil
+	}
+
+	// Merged revisions on the server never change, so any that are
+	// already cached have been verified and don't need to be
+	// fetched again.
+	irmdsByRev, toFetch := md.getCachedRange(ctx, id, bid, start, stop)
+	for _, r := range toFetch {
+		var rmdses []*RootMetadataSigned
+		err := runWithPhaseBudget(ctx, opPhaseMDGet,
+			func(ctx context.Context) (err error) {
+				rmdses, err = md.config.MDServer().GetRange(
+					ctx, id, bid, mStatus, r.start, r.end)
+				return err
+			})
+		if err != nil {
+			return nil, err
+		}
+		// Do this first, since processRange consumes rmdses.
+		sigs := make(map[MetadataRevision]RootMetadataSigned, len(rmdses))
+		for _, rmds := range rmdses {
+			sigs[rmds.MD.RevisionNumber()] = RootMetadataSigned{
+				SigInfo:       rmds.SigInfo,
+				WriterSigInfo: rmds.WriterSigInfo,
+			}
+		}
+		fetched, err := md.processRange(ctx, id, bid, rmdses)
+		if err != nil {
+			return nil, err
+		}
+		for _, irmd := range fetched {
+			if err := md.config.MDCache().Put(irmd); err != nil {
+				return nil, err
+			}
+			rmdsSigs := sigs[irmd.Revision()]
+			md.putToDiskCache(ctx, "", irmd,
+				rmdsSigs.SigInfo, rmdsSigs.WriterSigInfo)
+			irmdsByRev[irmd.Revision()] = irmd
+		}
+	}
+
+	// Return the contiguous run of revisions starting from the
+	// first one that exists.
+	if len(irmdsByRev) == 0 {
+		return nil, nil
+	}
+	first := stop
+	for rev := range irmdsByRev {
+		if rev < first {
+			first = rev
+		}
+	}
+	var irmds []ImmutableRootMetadata
+	for rev := first; rev <= stop; rev++ {
+		irmd, ok := irmdsByRev[rev]
+		if !ok {
+			break
+		}
+		irmds = append(irmds, irmd)
+	}
+	if err := checkMDSequence(irmds); err != nil {
 		return nil, err
 	}
-	return rmd, nil
+	return irmds, nil
 }
 
 // GetRange implements the MDOps interface for MDOpsStandard.
@@ -557,6 +940,11 @@ func (md *MDOpsStandard) put(
 		return MdID{}, err
 	}
 
+	if md.config.DiskMDCache() != nil && rmd.MergedStatus() == Merged {
+		md.putToDiskCache(ctx, "", MakeImmutableRootMetadata(
+			rmd, rmds.GetWriterMetadataSigInfo().VerifyingKey, mdID,
+			md.config.Clock().Now()), rmds.SigInfo, rmds.WriterSigInfo)
+	}
 	return mdID, nil
 }
 
@@ -659,3 +1047,9 @@ func (md *MDOpsStandard) getExtraMD(ctx context.Context, brmd BareRootMetadata)
 	kbcache.PutTLFReaderKeyBundle(tlf, rkbID, *rkb)
 	return NewExtraMetadataV3(*wkb, *rkb, false, false), nil
 }
+
+// GetMerkleCheckStatus implements the MDOps interface for
+// MDOpsStandard.
+func (md *MDOpsStandard) GetMerkleCheckStatus() []MerkleCheckStatus {
+	return md.merkle.getStatus()
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 	"golang.org/x/net/context"
 )
 
@@ -74,6 +75,7 @@ func mdOpsInit(t *testing.T, ver MetadataVer) (mockCtrl *gomock.Controller,
 	config.SetMDOps(mdops)
 	config.SetCodec(kbfscodec.NewMsgpack())
 	config.SetKeyBundleCache(NewKeyBundleCacheStandard(1))
+	config.SetMDCache(NewMDCacheStandard(defaultMDCacheCapacity))
 	config.mockMdserv.EXPECT().OffsetFromServerTime().
 		Return(time.Duration(0), true).AnyTimes()
 	injectShimCrypto(config)
@@ -226,6 +228,63 @@ func testMDOpsGetForHandlePublicSuccess(t *testing.T, ver MetadataVer) {
 	require.Equal(t, expectedMD, rmd2.bareMd)
 }
 
+func testMDOpsGetForHandleFromDiskCache(t *testing.T, ver MetadataVer) {
+	mockCtrl, config, ctx := mdOpsInit(t, ver)
+	defer mdOpsShutdown(mockCtrl, config)
+
+	dmc, err := newDiskMDCacheStandardFromStorage(
+		config, storage.NewMemStorage())
+	require.NoError(t, err)
+	defer dmc.Shutdown(ctx)
+	config.SetDiskMDCache(dmc)
+
+	h := parseTlfHandleOrBust(t, config, "alice,bob", true)
+	rmds, _ := newRMDS(t, config, h)
+
+	verifyMDForPublic(config, rmds, nil)
+
+	config.mockMdserv.EXPECT().GetForHandle(ctx, h.ToBareHandleOrBust(), Merged).Return(tlf.NullID, rmds, nil)
+
+	// Do this first, since rmds is consumed.
+	expectedMD := rmds.MD
+	_, rmd, err := config.MDOps().GetForHandle(ctx, h, Merged)
+	require.NoError(t, err)
+	require.Equal(t, expectedMD, rmd.bareMd)
+
+	// Later gets still go to the server.
+	rmds2, _ := newRMDS(t, config, h)
+	config.mockMdserv.EXPECT().GetForHandle(ctx, h.ToBareHandleOrBust(), Merged).Return(tlf.NullID, rmds2, nil)
+	_, _, err = config.MDOps().GetForHandle(ctx, h, Merged)
+	require.NoError(t, err)
+
+	// After a restart, the first get uses the verified head from
+	// the disk cache, and refreshes it from the server in the
+	// background.
+	config.SetMDOps(NewMDOpsStandard(config))
+	rmds3, _ := newRMDS(t, config, h)
+	config.mockMdserv.EXPECT().GetForHandle(gomock.Any(), h.ToBareHandleOrBust(), Merged).Return(tlf.NullID, rmds3, nil)
+	mdOps := config.MDOps().(*MDOpsStandard)
+	id2, rmd2, err := mdOps.GetForHandle(ctx, h, Merged)
+	require.NoError(t, err)
+	require.Equal(t, rmd.TlfID(), id2)
+	require.Equal(t, rmd.Revision(), rmd2.Revision())
+	require.Equal(t, rmd.MdID(), rmd2.MdID())
+	require.Equal(t, rmd.LastModifyingWriterVerifyingKey(),
+		rmd2.LastModifyingWriterVerifyingKey())
+	err = mdOps.refreshGroup.Wait(ctx)
+	require.NoError(t, err)
+
+	// A cached head that doesn't verify isn't used.
+	err = dmc.Put(ctx, h.GetCanonicalPath(), rmd,
+		kbfscrypto.SignatureInfo{}, kbfscrypto.SignatureInfo{})
+	require.NoError(t, err)
+	config.SetMDOps(NewMDOpsStandard(config))
+	rmds4, _ := newRMDS(t, config, h)
+	config.mockMdserv.EXPECT().GetForHandle(ctx, h.ToBareHandleOrBust(), Merged).Return(tlf.NullID, rmds4, nil)
+	_, _, err = config.MDOps().GetForHandle(ctx, h, Merged)
+	require.NoError(t, err)
+}
+
 func expectGetKeyBundles(ctx context.Context, config *ConfigMock, extra ExtraMetadata) {
 	if extraV3, ok := extra.(*ExtraMetadataV3); ok {
 		config.mockMdserv.EXPECT().GetKeyBundles(
@@ -648,6 +707,44 @@ func testMDOpsGetRangeFromStartSuccess(t *testing.T, ver MetadataVer) {
 	testMDOpsGetRangeSuccessHelper(t, ver, true)
 }
 
+func testMDOpsGetRangeCached(t *testing.T, ver MetadataVer) {
+	mockCtrl, config, ctx := mdOpsInit(t, ver)
+	defer mdOpsShutdown(mockCtrl, config)
+
+	rmdses, extras := makeRMDSRange(t, config, 100, 5, fakeMdID(1))
+
+	start := MetadataRevision(100)
+	stop := start + MetadataRevision(len(rmdses)) - 1
+
+	// Each MD should only be verified once, by the first GetRange.
+	for _, rmds := range rmdses {
+		verifyMDForPrivate(config, rmds)
+	}
+
+	mdServer := makeKeyBundleMDServer(config.MDServer())
+	config.SetMDServer(mdServer)
+
+	mdServer.nextGetRange = rmdses
+	for i, e := range extras {
+		mdServer.processRMDSes(rmdses[i], e)
+	}
+
+	id := rmdses[0].MD.TlfID()
+	irmds, err := config.MDOps().GetRange(ctx, id, start, stop)
+	require.NoError(t, err)
+	require.Len(t, irmds, len(rmdses))
+
+	// The server doesn't return anything this time, so these must
+	// come out of the cache.
+	irmds2, err := config.MDOps().GetRange(ctx, id, start, stop+2)
+	require.NoError(t, err)
+	require.Equal(t, irmds, irmds2)
+
+	irmds3, err := config.MDOps().GetRange(ctx, id, start+1, start+2)
+	require.NoError(t, err)
+	require.Equal(t, irmds[1:3], irmds3)
+}
+
 func testMDOpsGetRangeFailBadPrevRoot(t *testing.T, ver MetadataVer) {
 	mockCtrl, config, ctx := mdOpsInit(t, ver)
 	defer mdOpsShutdown(mockCtrl, config)
@@ -851,6 +948,7 @@ func TestMDOps(t *testing.T) {
 	tests := []func(*testing.T, MetadataVer){
 		testMDOpsGetForHandlePublicSuccess,
 		testMDOpsGetForHandlePrivateSuccess,
+		testMDOpsGetForHandleFromDiskCache,
 		testMDOpsGetForUnresolvedHandlePublicSuccess,
 		testMDOpsGetForUnresolvedMdHandlePublicSuccess,
 		testMDOpsGetForUnresolvedHandlePublicFailure,
@@ -864,6 +962,7 @@ func TestMDOps(t *testing.T) {
 		testMDOpsGetFailIDCheck,
 		testMDOpsGetRangeSuccess,
 		testMDOpsGetRangeFromStartSuccess,
+		testMDOpsGetRangeCached,
 		testMDOpsGetRangeFailBadPrevRoot,
 		testMDOpsPutPublicSuccess,
 		testMDOpsPutPrivateSuccess,
@@ -0,0 +1,331 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// mdUpdateRegisterer is the subset of the MDServer interface needed
+// to register for, and cancel registrations for, MD updates.
+type mdUpdateRegisterer interface {
+	RegisterForUpdate(ctx context.Context, id tlf.ID,
+		currHead MetadataRevision) (<-chan error, error)
+	CancelRegistration(ctx context.Context, id tlf.ID)
+}
+
+var _ mdUpdateRegisterer = (MDServer)(nil)
+
+// mdUpdateRegistration tracks one outstanding registration with the
+// MD server, along with the subscribers that are waiting on it.
+type mdUpdateRegistration struct {
+	currHead MetadataRevision
+	// registered is closed once the server registration has
+	// finished, after which serverCh and err are set.
+	registered chan struct{}
+	serverCh   <-chan error
+	err        error
+	subChs     []chan error
+	// canceled is set if the registration was canceled before it
+	// finished.  It stays in the multiplexer until then, so that no
+	// one else registers with the server for the same TLF at the
+	// same time.
+	canceled bool
+}
+
+// mdUpdateMultiplexer implements mdUpdateRegisterer by watching the
+// MD update registrations of all TLFs from a single goroutine, which
+// forwards each update to the subscribers for that TLF.  There is at
+// most one server registration per TLF at a time: concurrent
+// subscribers for the same TLF and revision share one registration,
+// as does a TLF re-subscribing while its previous server
+// registration is still outstanding (e.g., after its updater
+// goroutine was canceled).  A subscriber with a different current
+// revision replaces the outstanding registration instead, since the
+// server decides what counts as an update from that revision.
+//
+// The MD server only takes registrations one TLF at a time, so this
+// still makes one RegisterForUpdate call per TLF, all over the same
+// connection.  Registering for many TLFs in one call, or streaming
+// their updates, would need a new MD server RPC.
+type mdUpdateMultiplexer struct {
+	config Config
+	log    logger.Logger
+
+	lock sync.Mutex
+	regs map[tlf.ID]*mdUpdateRegistration
+
+	// changedCh is signaled whenever regs changes, so the watcher
+	// goroutine can rebuild the set of channels it watches.
+	changedCh    chan struct{}
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	doneCh       chan struct{}
+}
+
+var _ mdUpdateRegisterer = (*mdUpdateMultiplexer)(nil)
+
+func newMDUpdateMultiplexer(config Config) *mdUpdateMultiplexer {
+	m := &mdUpdateMultiplexer{
+		config:     config,
+		log:        config.MakeLogger("MDU"),
+		regs:       make(map[tlf.ID]*mdUpdateRegistration),
+		changedCh:  make(chan struct{}, 1),
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	go m.watch()
+	return m
+}
+
+func (m *mdUpdateMultiplexer) signalChanged() {
+	select {
+	case m.changedCh <- struct{}{}:
+	default:
+	}
+}
+
+// notifySubsLocked sends `err` to all the subscribers of `reg`, and
+// closes their channels.  m.lock must be held.
+func (reg *mdUpdateRegistration) notifySubsLocked(err error) {
+	for _, subCh := range reg.subChs {
+		subCh <- err
+		close(subCh)
+	}
+	reg.subChs = nil
+}
+
+// RegisterForUpdate implements the mdUpdateRegisterer interface for
+// mdUpdateMultiplexer.  The returned channel has the same semantics
+// as the one returned by MDServer.RegisterForUpdate: it receives
+// exactly one value (nil on an update, or an error) and is then
+// closed.
+func (m *mdUpdateMultiplexer) RegisterForUpdate(ctx context.Context,
+	id tlf.ID, currHead MetadataRevision) (<-chan error, error) {
+	subCh := make(chan error, 1)
+	for {
+		m.lock.Lock()
+		select {
+		case <-m.shutdownCh:
+			m.lock.Unlock()
+			return nil, ShutdownHappenedError{}
+		default:
+		}
+
+		reg, ok := m.regs[id]
+		if !ok {
+			break
+		}
+
+		select {
+		case <-reg.registered:
+		default:
+			// Wait for the outstanding registration to finish
+			// before deciding what to do with it.
+			m.lock.Unlock()
+			select {
+			case <-reg.registered:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			continue
+		}
+
+		if reg.currHead == currHead {
+			reg.subChs = append(reg.subChs, subCh)
+			m.lock.Unlock()
+			m.log.CDebugf(ctx, "Reusing outstanding registration for %s",
+				id)
+			return subCh, nil
+		}
+
+		// The outstanding registration is for a different
+		// revision, so replace it.  Its subscribers should fetch
+		// whatever has changed, and then re-register.
+		m.log.CDebugf(ctx, "Replacing registration for %s at "+
+			"revision %d with one at revision %d", id, reg.currHead,
+			currHead)
+		delete(m.regs, id)
+		reg.notifySubsLocked(nil)
+		m.signalChanged()
+		m.lock.Unlock()
+		m.config.MDServer().CancelRegistration(ctx, id)
+	}
+
+	reg := &mdUpdateRegistration{
+		currHead:   currHead,
+		registered: make(chan struct{}),
+		subChs:     []chan error{subCh},
+	}
+	m.regs[id] = reg
+	m.lock.Unlock()
+
+	// Register outside of the lock, since this may block on the
+	// network.
+	serverCh, err := m.config.MDServer().RegisterForUpdate(ctx, id, currHead)
+
+	shutdown, canceled := func() (shutdown, canceled bool) {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		defer close(reg.registered)
+		reg.serverCh = serverCh
+		reg.err = err
+		select {
+		case <-m.shutdownCh:
+			shutdown = true
+		default:
+		}
+		if err != nil || reg.canceled || shutdown {
+			if m.regs[id] == reg {
+				delete(m.regs, id)
+			}
+			reg.notifySubsLocked(err)
+			return shutdown, err == nil
+		}
+		m.signalChanged()
+		return false, false
+	}()
+	if canceled {
+		// Canceled or shut down in the meantime, which already
+		// notified the subscribers.
+		m.config.MDServer().CancelRegistration(ctx, id)
+	}
+	switch {
+	case shutdown:
+		return nil, ShutdownHappenedError{}
+	case err != nil:
+		return nil, err
+	default:
+		return subCh, nil
+	}
+}
+
+// CancelRegistration implements the mdUpdateRegisterer interface for
+// mdUpdateMultiplexer.
+func (m *mdUpdateMultiplexer) CancelRegistration(
+	ctx context.Context, id tlf.ID) {
+	cancelWithServer := func() bool {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		reg, ok := m.regs[id]
+		if !ok {
+			return true
+		}
+		for _, subCh := range reg.subChs {
+			close(subCh)
+		}
+		reg.subChs = nil
+		select {
+		case <-reg.registered:
+		default:
+			// The registering goroutine cancels with the server
+			// once the registration finishes.
+			reg.canceled = true
+			return false
+		}
+		delete(m.regs, id)
+		m.signalChanged()
+		return true
+	}()
+	if cancelWithServer {
+		m.config.MDServer().CancelRegistration(ctx, id)
+	}
+}
+
+// numRegistrations returns the number of TLFs with outstanding
+// server registrations.
+func (m *mdUpdateMultiplexer) numRegistrations() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.regs)
+}
+
+// deliver forwards the result of a fired server registration to the
+// current subscriber for `id`, if any.
+func (m *mdUpdateMultiplexer) deliver(
+	id tlf.ID, serverCh <-chan error, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	reg, ok := m.regs[id]
+	if !ok || reg.serverCh != serverCh {
+		// Canceled or replaced in the meantime.
+		return
+	}
+	delete(m.regs, id)
+	reg.notifySubsLocked(err)
+}
+
+func (m *mdUpdateMultiplexer) watch() {
+	defer close(m.doneCh)
+	for {
+		// The first two cases are always shutdown and changed;
+		// the rest correspond, in order, to `ids`.
+		cases := []reflect.SelectCase{
+			{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(m.shutdownCh),
+			},
+			{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(m.changedCh),
+			},
+		}
+		var ids []tlf.ID
+		var serverChs []<-chan error
+		func() {
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			for id, reg := range m.regs {
+				if reg.serverCh == nil {
+					// Still registering with the server.
+					continue
+				}
+				ids = append(ids, id)
+				serverChs = append(serverChs, reg.serverCh)
+				cases = append(cases, reflect.SelectCase{
+					Dir:  reflect.SelectRecv,
+					Chan: reflect.ValueOf(reg.serverCh),
+				})
+			}
+		}()
+
+		chosen, val, ok := reflect.Select(cases)
+		switch chosen {
+		case 0:
+			return
+		case 1:
+			continue
+		}
+
+		i := chosen - 2
+		var err error
+		if ok && !val.IsNil() {
+			err = val.Interface().(error)
+		}
+		m.deliver(ids[i], serverChs[i], err)
+	}
+}
+
+// Shutdown stops the watcher goroutine and closes the channels of any
+// waiting subscribers.
+func (m *mdUpdateMultiplexer) Shutdown() {
+	m.shutdownOnce.Do(func() {
+		func() {
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			close(m.shutdownCh)
+			for id, reg := range m.regs {
+				reg.notifySubsLocked(ShutdownHappenedError{})
+				delete(m.regs, id)
+			}
+		}()
+		<-m.doneCh
+	})
+}
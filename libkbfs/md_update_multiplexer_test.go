@@ -0,0 +1,173 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func mdUpdateMultiplexerInit(t *testing.T) (
+	*gomock.Controller, *ConfigMock, *mdUpdateMultiplexer) {
+	ctr := NewSafeTestReporter(t)
+	mockCtrl := gomock.NewController(ctr)
+	config := NewConfigMock(mockCtrl, ctr)
+	return mockCtrl, config, newMDUpdateMultiplexer(config)
+}
+
+func TestMDUpdateMultiplexerDeliversUpdates(t *testing.T) {
+	mockCtrl, config, m := mdUpdateMultiplexerInit(t)
+	defer mockCtrl.Finish()
+	defer m.Shutdown()
+	ctx := context.Background()
+
+	id1 := tlf.FakeID(1, false)
+	id2 := tlf.FakeID(2, false)
+	serverCh1 := make(chan error, 1)
+	serverCh2 := make(chan error, 1)
+	config.mockMdserv.EXPECT().RegisterForUpdate(gomock.Any(), id1,
+		MetadataRevision(5)).Return((<-chan error)(serverCh1), nil)
+	config.mockMdserv.EXPECT().RegisterForUpdate(gomock.Any(), id2,
+		MetadataRevision(7)).Return((<-chan error)(serverCh2), nil)
+
+	subCh1, err := m.RegisterForUpdate(ctx, id1, MetadataRevision(5))
+	require.NoError(t, err)
+	subCh2, err := m.RegisterForUpdate(ctx, id2, MetadataRevision(7))
+	require.NoError(t, err)
+	require.Equal(t, 2, m.numRegistrations())
+
+	expectedErr := errors.New("fake disconnect")
+	serverCh2 <- expectedErr
+	close(serverCh2)
+	require.Equal(t, expectedErr, <-subCh2)
+
+	serverCh1 <- nil
+	close(serverCh1)
+	require.NoError(t, <-subCh1)
+	require.Equal(t, 0, m.numRegistrations())
+}
+
+func TestMDUpdateMultiplexerReusesRegistration(t *testing.T) {
+	mockCtrl, config, m := mdUpdateMultiplexerInit(t)
+	defer mockCtrl.Finish()
+	defer m.Shutdown()
+	ctx := context.Background()
+
+	id := tlf.FakeID(1, false)
+	serverCh := make(chan error, 1)
+	// Only one server registration should happen.
+	config.mockMdserv.EXPECT().RegisterForUpdate(gomock.Any(), id,
+		MetadataRevision(5)).Return((<-chan error)(serverCh), nil)
+
+	_, err := m.RegisterForUpdate(ctx, id, MetadataRevision(5))
+	require.NoError(t, err)
+	// Simulate a canceled updater re-registering.
+	subCh, err := m.RegisterForUpdate(ctx, id, MetadataRevision(5))
+	require.NoError(t, err)
+
+	serverCh <- nil
+	close(serverCh)
+	require.NoError(t, <-subCh)
+}
+
+func TestMDUpdateMultiplexerConcurrentRegistrations(t *testing.T) {
+	mockCtrl, config, m := mdUpdateMultiplexerInit(t)
+	defer mockCtrl.Finish()
+	defer m.Shutdown()
+	ctx := context.Background()
+
+	id := tlf.FakeID(1, false)
+	serverCh := make(chan error, 1)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	// Only one server registration should happen, even while the
+	// first one is still in flight.
+	config.mockMdserv.EXPECT().RegisterForUpdate(gomock.Any(), id,
+		MetadataRevision(5)).Do(func(
+		context.Context, tlf.ID, MetadataRevision) {
+		close(started)
+		<-release
+	}).Return((<-chan error)(serverCh), nil)
+
+	subChCh := make(chan (<-chan error), 1)
+	go func() {
+		subCh, err := m.RegisterForUpdate(ctx, id, MetadataRevision(5))
+		require.NoError(t, err)
+		subChCh <- subCh
+	}()
+	<-started
+	go func() {
+		subCh, err := m.RegisterForUpdate(ctx, id, MetadataRevision(5))
+		require.NoError(t, err)
+		subChCh <- subCh
+	}()
+	close(release)
+	subCh1 := <-subChCh
+	subCh2 := <-subChCh
+
+	// Both subscribers get the update.
+	serverCh <- nil
+	close(serverCh)
+	require.NoError(t, <-subCh1)
+	require.NoError(t, <-subCh2)
+}
+
+func TestMDUpdateMultiplexerNewHead(t *testing.T) {
+	mockCtrl, config, m := mdUpdateMultiplexerInit(t)
+	defer mockCtrl.Finish()
+	defer m.Shutdown()
+	ctx := context.Background()
+
+	id := tlf.FakeID(1, false)
+	serverCh1 := make(chan error, 1)
+	serverCh2 := make(chan error, 1)
+	gomock.InOrder(
+		config.mockMdserv.EXPECT().RegisterForUpdate(gomock.Any(), id,
+			MetadataRevision(5)).Return((<-chan error)(serverCh1), nil),
+		config.mockMdserv.EXPECT().CancelRegistration(gomock.Any(), id),
+		config.mockMdserv.EXPECT().RegisterForUpdate(gomock.Any(), id,
+			MetadataRevision(7)).Return((<-chan error)(serverCh2), nil),
+	)
+
+	subCh1, err := m.RegisterForUpdate(ctx, id, MetadataRevision(5))
+	require.NoError(t, err)
+	// A subscriber at a different revision replaces the
+	// registration, and the old subscriber is told to refresh.
+	subCh2, err := m.RegisterForUpdate(ctx, id, MetadataRevision(7))
+	require.NoError(t, err)
+	require.NoError(t, <-subCh1)
+	require.Equal(t, 1, m.numRegistrations())
+
+	serverCh1 <- errors.New("Registration canceled")
+	close(serverCh1)
+	serverCh2 <- nil
+	close(serverCh2)
+	require.NoError(t, <-subCh2)
+}
+
+func TestMDUpdateMultiplexerCancel(t *testing.T) {
+	mockCtrl, config, m := mdUpdateMultiplexerInit(t)
+	defer mockCtrl.Finish()
+	defer m.Shutdown()
+	ctx := context.Background()
+
+	id := tlf.FakeID(1, false)
+	serverCh := make(chan error, 1)
+	config.mockMdserv.EXPECT().RegisterForUpdate(gomock.Any(), id,
+		MetadataRevision(5)).Return((<-chan error)(serverCh), nil)
+	config.mockMdserv.EXPECT().CancelRegistration(gomock.Any(), id)
+
+	subCh, err := m.RegisterForUpdate(ctx, id, MetadataRevision(5))
+	require.NoError(t, err)
+	m.CancelRegistration(ctx, id)
+	_, ok := <-subCh
+	require.False(t, ok)
+	require.Equal(t, 0, m.numRegistrations())
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/keybase/kbfs/kbfscrypto"
+)
+
+// defaultMDVerifyCacheCapacity is the number of verified MD
+// signatures to remember.
+const defaultMDVerifyCacheCapacity = 5000
+
+type mdVerifyKey struct {
+	id MdID
+	// sig is the root signature of the MD, if any.  The writer
+	// signature is part of the MD, so it's covered by id.
+	sig string
+	kid string
+}
+
+// mdVerifyCache remembers which MDs have already passed
+// IsValidAndSigned, so that walking the same history again, say
+// during conflict resolution or after the MD cache evicted it,
+// doesn't verify the same signatures again.  It only remembers
+// successes, and is safe for concurrent use.
+type mdVerifyCache struct {
+	verified *lru.Cache
+}
+
+func newMDVerifyCache(capacity int) *mdVerifyCache {
+	verified, err := lru.New(capacity)
+	if err != nil {
+		panic(err.Error())
+	}
+	return &mdVerifyCache{verified}
+}
+
+func makeMDVerifyKey(id MdID, sigInfo kbfscrypto.SignatureInfo) mdVerifyKey {
+	key := mdVerifyKey{id: id, sig: string(sigInfo.Signature)}
+	if !sigInfo.VerifyingKey.IsNil() {
+		key.kid = sigInfo.VerifyingKey.KID().String()
+	}
+	return key
+}
+
+// isVerified returns whether the MD with the given ID and root
+// signature has already been verified.  Pass an empty sigInfo for an
+// MD without a root signature.
+func (c *mdVerifyCache) isVerified(
+	id MdID, sigInfo kbfscrypto.SignatureInfo) bool {
+	_, ok := c.verified.Get(makeMDVerifyKey(id, sigInfo))
+	return ok
+}
+
+// setVerified records that the MD with the given ID and root
+// signature has been verified.
+func (c *mdVerifyCache) setVerified(
+	id MdID, sigInfo kbfscrypto.SignatureInfo) {
+	c.verified.Add(makeMDVerifyKey(id, sigInfo), true)
+}
+
+// checkExtraMetadataIDs checks that the key bundles in extra, if any,
+// are the ones brmd refers to.  IsValidAndSigned does this too, but
+// an MD found in an mdVerifyCache can be paired with bundles that
+// haven't been checked yet.
+func checkExtraMetadataIDs(
+	crypto cryptoPure, brmd BareRootMetadata, extra ExtraMetadata) error {
+	extraV3, ok := extra.(*ExtraMetadataV3)
+	if !ok {
+		return nil
+	}
+	err := checkWKBID(crypto, brmd.GetTLFWriterKeyBundleID(), extraV3.wkb)
+	if err != nil {
+		return err
+	}
+	return checkRKBID(crypto, brmd.GetTLFReaderKeyBundleID(), extraV3.rkb)
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDVerifyCache(t *testing.T) {
+	c := newMDVerifyCache(2)
+	key1 := kbfscrypto.MakeFakeSigningKeyOrBust("key1")
+	key2 := kbfscrypto.MakeFakeSigningKeyOrBust("key2")
+	sig1 := key1.Sign([]byte("md"))
+	sig2 := key2.Sign([]byte("md"))
+
+	id1, id2, id3 := fakeMdID(1), fakeMdID(2), fakeMdID(3)
+	require.False(t, c.isVerified(id1, sig1))
+	c.setVerified(id1, sig1)
+	require.True(t, c.isVerified(id1, sig1))
+	// The same MD with a different root signature hasn't been
+	// verified.
+	require.False(t, c.isVerified(id1, sig2))
+	require.False(t, c.isVerified(id1, kbfscrypto.SignatureInfo{}))
+	require.False(t, c.isVerified(id2, sig1))
+
+	c.setVerified(id2, kbfscrypto.SignatureInfo{})
+	require.True(t, c.isVerified(id2, kbfscrypto.SignatureInfo{}))
+
+	// The least recently used entry is forgotten first.
+	c.setVerified(id3, sig1)
+	require.False(t, c.isVerified(id1, sig1))
+	require.True(t, c.isVerified(id2, kbfscrypto.SignatureInfo{}))
+	require.True(t, c.isVerified(id3, sig1))
+}
@@ -30,7 +30,7 @@ func NewMDCacheStandard(capacity int) *MDCacheStandard {
 	if err != nil {
 		return nil
 	}
-	return &MDCacheStandard{tmp}
+	return &MDCacheStandard{lru: tmp}
 }
 
 // Get implements the MDCache interface for MDCacheStandard.
@@ -48,9 +48,9 @@ func (md *MDCacheStandard) Get(tlf tlf.ID, rev MetadataRevision, bid BranchID) (
 
 // Put implements the MDCache interface for MDCacheStandard.
 func (md *MDCacheStandard) Put(rmd ImmutableRootMetadata) error {
-	// TODO: is it worth the extra lookup here to make sure if there's
-	// one already in the cache that it has the same MdID?
 	key := mdCacheKey{rmd.TlfID(), rmd.Revision(), rmd.BID()}
+	// The journal may legitimately replace a revision, e.g. when
+	// it's flushed or squashed, so the newest MD always wins.
 	md.lru.Add(key, rmd)
 	return nil
 }
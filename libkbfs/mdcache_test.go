@@ -116,3 +116,27 @@ func TestMdcacheReplace(t *testing.T) {
 	_, err = mdcache.Get(id, 1, bid)
 	require.NoError(t, err)
 }
+
+func TestMdcachePutReplaces(t *testing.T) {
+	id := tlf.FakeID(1, false)
+	h := testMdcacheMakeHandle(t, 1)
+
+	mdcache := NewMDCacheStandard(100)
+	testMdcachePut(t, id, 1, NullBranchID, h, mdcache)
+
+	irmd, err := mdcache.Get(id, 1, NullBranchID)
+	require.NoError(t, err)
+
+	// A different MD for the same revision, e.g. after the journal
+	// squashes it, replaces the cached one.
+	newRmd, err := irmd.deepCopy(kbfscodec.NewMsgpack())
+	require.NoError(t, err)
+	newIrmd := MakeImmutableRootMetadata(newRmd,
+		irmd.LastModifyingWriterVerifyingKey(), fakeMdID(2), time.Now())
+	err = mdcache.Put(newIrmd)
+	require.NoError(t, err)
+
+	irmd2, err := mdcache.Get(id, 1, NullBranchID)
+	require.NoError(t, err)
+	require.Equal(t, newIrmd, irmd2)
+}
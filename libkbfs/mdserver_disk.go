@@ -14,6 +14,7 @@ import (
 
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
+	merkle "github.com/keybase/go-merkle-tree"
 	"github.com/keybase/kbfs/ioutil"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
@@ -693,3 +694,16 @@ func (md *MDServerDisk) GetKeyBundles(ctx context.Context,
 
 	return tlfStorage.getKeyBundles(tlfID, wkbID, rkbID)
 }
+
+// GetMerkleRootLatest implements the MDServer interface for
+// MDServerDisk.  There's no Merkle tree.
+func (md *MDServerDisk) GetMerkleRootLatest(ctx context.Context,
+	treeID keybase1.MerkleTreeID) (*MerkleRoot, error) {
+	return nil, MerkleUnsupportedError{}
+}
+
+// GetMerkleNode implements the MDServer interface for MDServerDisk.
+func (md *MDServerDisk) GetMerkleNode(
+	ctx context.Context, hash merkle.Hash) ([]byte, error) {
+	return nil, MerkleUnsupportedError{}
+}
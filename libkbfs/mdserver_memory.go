@@ -12,6 +12,7 @@ import (
 
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
+	merkle "github.com/keybase/go-merkle-tree"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
@@ -876,3 +877,16 @@ func (md *MDServerMemory) GetKeyBundles(ctx context.Context,
 	}
 	return wkb, rkb, nil
 }
+
+// GetMerkleRootLatest implements the MDServer interface for
+// MDServerMemory.  There's no Merkle tree.
+func (md *MDServerMemory) GetMerkleRootLatest(ctx context.Context,
+	treeID keybase1.MerkleTreeID) (*MerkleRoot, error) {
+	return nil, MerkleUnsupportedError{}
+}
+
+// GetMerkleNode implements the MDServer interface for MDServerMemory.
+func (md *MDServerMemory) GetMerkleNode(
+	ctx context.Context, hash merkle.Hash) ([]byte, error) {
+	return nil, MerkleUnsupportedError{}
+}
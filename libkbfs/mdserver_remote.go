@@ -5,6 +5,7 @@
 package libkbfs
 
 import (
+	"encoding/hex"
 	"fmt"
 	"sync"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	merkle "github.com/keybase/go-merkle-tree"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
@@ -64,7 +66,7 @@ type MDServerRemote struct {
 	tickerMu     sync.Mutex // protects the ticker cancel function
 
 	rekeyCancel context.CancelFunc
-	rekeyTimer  *time.Timer
+	rekeyTimer  Timer
 
 	serverOffsetMu    sync.RWMutex
 	serverOffsetKnown bool
@@ -92,7 +94,7 @@ func NewMDServerRemote(config Config, srvAddr string,
 		log:           config.MakeLogger(""),
 		mdSrvAddr:     srvAddr,
 		rpcLogFactory: rpcLogFactory,
-		rekeyTimer:    time.NewTimer(MdServerBackgroundRekeyPeriod),
+		rekeyTimer:    config.Clock().NewTimer(MdServerBackgroundRekeyPeriod),
 	}
 
 	mdServer.pinger = pinger{
@@ -129,8 +131,9 @@ func (md *MDServerRemote) initNewConnection() {
 		md.conn.Shutdown()
 	}
 
-	md.conn = rpc.NewTLSConnection(
-		md.mdSrvAddr, kbfscrypto.GetRootCerts(md.mdSrvAddr),
+	md.conn = newTLSConnectionViaProxy(
+		md.mdSrvAddr, md.config.ServerProxies().MDServer,
+		kbfscrypto.GetRootCerts(md.mdSrvAddr),
 		MDServerErrorUnwrapper{}, md, md.rpcLogFactory,
 		md.config.MakeLogger(""), md.connOpts)
 	md.client = keybase1.MetadataClient{Cli: md.conn.GetClient()}
@@ -764,7 +767,7 @@ func (md *MDServerRemote) CheckForRekeys(ctx context.Context) <-chan error {
 	// is using the same value.  TODO: the server should tell us what
 	// value it is using.
 	c := make(chan error, 1)
-	time.AfterFunc(5*time.Second, func() {
+	md.config.Clock().AfterFunc(5*time.Second, func() {
 		md.log.CInfof(ctx, "CheckForRekeys: checking for rekeys")
 		select {
 		case <-ctx.Done():
@@ -931,7 +934,7 @@ const CtxMDSROpID = "MDSRID"
 func (md *MDServerRemote) backgroundRekeyChecker(ctx context.Context) {
 	for {
 		select {
-		case <-md.rekeyTimer.C:
+		case <-md.rekeyTimer.C():
 			if !md.getConn().IsConnected() {
 				md.rekeyTimer.Reset(MdServerBackgroundRekeyPeriod)
 				continue
@@ -1018,3 +1021,32 @@ func (md *MDServerRemote) GetKeyBundles(ctx context.Context,
 
 	return wkb, rkb, nil
 }
+
+// GetMerkleRootLatest implements the MDServer interface for
+// MDServerRemote.
+func (md *MDServerRemote) GetMerkleRootLatest(ctx context.Context,
+	treeID keybase1.MerkleTreeID) (*MerkleRoot, error) {
+	res, err := md.getClient().GetMerkleRootLatest(ctx, treeID)
+	if err != nil {
+		return nil, err
+	}
+	if res.Version != MerkleRootVersion {
+		return nil, fmt.Errorf("Unsupported Merkle root version: %d",
+			res.Version)
+	}
+	var root MerkleRoot
+	if err := md.config.Codec().Decode(res.Root, &root); err != nil {
+		return nil, err
+	}
+	if root.TreeID != treeID {
+		return nil, fmt.Errorf("Expected a root of Merkle tree %s, got %s",
+			treeID, root.TreeID)
+	}
+	return &root, nil
+}
+
+// GetMerkleNode implements the MDServer interface for MDServerRemote.
+func (md *MDServerRemote) GetMerkleNode(
+	ctx context.Context, hash merkle.Hash) ([]byte, error) {
+	return md.getClient().GetMerkleNode(ctx, hex.EncodeToString(hash))
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// MembershipChange is a proposed change to the members of a TLF.
+type MembershipChange struct {
+	AddWriters []keybase1.UID
+	AddReaders []keybase1.UID
+	// Remove lists writers or readers to take out of the TLF.
+	Remove []keybase1.UID
+}
+
+// MembershipImpact describes the rekey and quota work that would
+// follow a MembershipChange, as of a given revision of the TLF.
+type MembershipImpact struct {
+	TlfID    tlf.ID
+	Revision MetadataRevision
+	// LatestKeyGeneration is the key generation that revisions up
+	// to and including Revision are encrypted with.
+	LatestKeyGeneration KeyGen
+	// NewKeyGeneration is true if the change needs a new key
+	// generation, which happens whenever anyone is removed; only
+	// data written after the change is encrypted with it.
+	NewKeyGeneration bool
+	// DevicesToKey maps each added user to the number of their
+	// devices, including paper keys, that the rekey must give keys
+	// to.  Added users get every key generation, so all of history
+	// is readable to them.
+	DevicesToKey map[keybase1.UID]int
+	// RemovedKeepHistory lists the removed users who can still read
+	// the revisions up to Revision, since keys for old generations
+	// can't be taken back, and any copies they've made of them
+	// can't be either.
+	RemovedKeepHistory []keybase1.UID
+	// DiskUsage is the number of bytes the TLF holds.  A membership
+	// change doesn't move or rewrite any blocks, so this stays
+	// charged as it is; only the new MD revision is added.
+	DiskUsage uint64
+}
+
+// PreviewMembershipChange reports what would happen to the TLF whose
+// head is md if change were made, without changing anything.  It's
+// meant for admins deciding whether to make a change.
+func PreviewMembershipChange(ctx context.Context, kbpki KBPKI,
+	md ReadOnlyRootMetadata, change MembershipChange) (
+	MembershipImpact, error) {
+	h := md.GetTlfHandle()
+	invalid := func(format string, args ...interface{}) error {
+		return InvalidMembershipChangeError{
+			h.GetCanonicalName(), fmt.Sprintf(format, args...)}
+	}
+	if h.IsPublic() && len(change.AddReaders) > 0 {
+		return MembershipImpact{}, invalid(
+			"everyone can already read a public folder")
+	}
+
+	impact := MembershipImpact{
+		TlfID:               md.TlfID(),
+		Revision:            md.Revision(),
+		LatestKeyGeneration: md.LatestKeyGeneration(),
+		DevicesToKey:        make(map[keybase1.UID]int),
+		DiskUsage:           md.DiskUsage(),
+	}
+
+	removed := make(map[keybase1.UID]bool, len(change.Remove))
+	writersLeft := len(h.ResolvedWriters())
+	for _, uid := range change.Remove {
+		// Everyone is a reader of a public folder, but only the
+		// writers are members.
+		isWriter := h.IsWriter(uid)
+		if !isWriter && (h.IsPublic() || !h.IsReader(uid)) {
+			return MembershipImpact{}, invalid("%s isn't a member", uid)
+		}
+		if removed[uid] {
+			continue
+		}
+		removed[uid] = true
+		if isWriter {
+			writersLeft--
+		}
+		impact.RemovedKeepHistory = append(impact.RemovedKeepHistory, uid)
+	}
+	if len(change.AddWriters) == 0 && writersLeft <= 0 {
+		return MembershipImpact{}, invalid("no writers would be left")
+	}
+	// Public folders aren't encrypted, so there are no keys to
+	// change.
+	impact.NewKeyGeneration = len(removed) > 0 && !h.IsPublic()
+
+	added := append(append([]keybase1.UID(nil), change.AddWriters...),
+		change.AddReaders...)
+	for _, uid := range added {
+		if removed[uid] {
+			return MembershipImpact{}, invalid(
+				"%s is both added and removed", uid)
+		}
+		if h.IsWriter(uid) || (h.IsReader(uid) &&
+			!containsUID(change.AddWriters, uid)) {
+			return MembershipImpact{}, invalid(
+				"%s is already a member", uid)
+		}
+		if h.IsPublic() {
+			continue
+		}
+		keys, err := kbpki.GetCryptPublicKeys(ctx, uid)
+		if err != nil {
+			return MembershipImpact{}, err
+		}
+		impact.DevicesToKey[uid] = len(keys)
+	}
+	return impact, nil
+}
+
+func containsUID(uids []keybase1.UID, uid keybase1.UID) bool {
+	for _, u := range uids {
+		if u == uid {
+			return true
+		}
+	}
+	return false
+}
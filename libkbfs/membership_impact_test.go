@@ -0,0 +1,67 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestPreviewMembershipChange(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "alice", "bob", "charlie", "dave")
+	ctx := context.Background()
+	defer config.Shutdown(ctx)
+
+	uid := func(name string) keybase1.UID {
+		_, uid, err := config.KBPKI().Resolve(ctx, name)
+		require.NoError(t, err)
+		return uid
+	}
+	alice, bob, charlie, dave :=
+		uid("alice"), uid("bob"), uid("charlie"), uid("dave")
+
+	h := parseTlfHandleOrBust(t, config, "alice,bob#charlie", false)
+	rmd, err := makeInitialRootMetadata(
+		config.MetadataVersion(), tlf.FakeID(1, false), h)
+	require.NoError(t, err)
+	rmd.SetDiskUsage(12345)
+	md := rmd.ReadOnly()
+
+	// Adding someone doesn't need a new key generation.
+	impact, err := PreviewMembershipChange(ctx, config.KBPKI(), md,
+		MembershipChange{AddReaders: []keybase1.UID{dave}})
+	require.NoError(t, err)
+	require.False(t, impact.NewKeyGeneration)
+	require.Equal(t, map[keybase1.UID]int{dave: 1}, impact.DevicesToKey)
+	require.Len(t, impact.RemovedKeepHistory, 0)
+	require.Equal(t, uint64(12345), impact.DiskUsage)
+
+	// Removing someone does, and they keep the history.
+	impact, err = PreviewMembershipChange(ctx, config.KBPKI(), md,
+		MembershipChange{
+			AddWriters: []keybase1.UID{charlie},
+			Remove:     []keybase1.UID{bob},
+		})
+	require.NoError(t, err)
+	require.True(t, impact.NewKeyGeneration)
+	require.Equal(t, map[keybase1.UID]int{charlie: 1}, impact.DevicesToKey)
+	require.Equal(t, []keybase1.UID{bob}, impact.RemovedKeepHistory)
+
+	for _, change := range []MembershipChange{
+		{Remove: []keybase1.UID{dave}},
+		{AddReaders: []keybase1.UID{charlie}},
+		{AddWriters: []keybase1.UID{bob}},
+		{Remove: []keybase1.UID{alice, bob}},
+	} {
+		_, err = PreviewMembershipChange(ctx, config.KBPKI(), md, change)
+		require.IsType(t, InvalidMembershipChangeError{},
+			errors.Cause(err))
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	merkle "github.com/keybase/go-merkle-tree"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// MerkleCheckMode says what to do when a merged MD head fetched from
+// the server can't be shown to be in the server's Merkle tree.
+type MerkleCheckMode int
+
+const (
+	// MerkleCheckOff means heads aren't checked at all.
+	MerkleCheckOff MerkleCheckMode = iota
+	// MerkleCheckWarn means heads are checked, and failures are
+	// logged and reported in the status, but the head is used
+	// anyway.
+	MerkleCheckWarn
+	// MerkleCheckStrict means a head that fails the check is
+	// rejected.  Heads that are newer than the tree, which happens
+	// for a while after every write, are still accepted.
+	MerkleCheckStrict
+)
+
+func (m MerkleCheckMode) String() string {
+	switch m {
+	case MerkleCheckOff:
+		return "off"
+	case MerkleCheckWarn:
+		return "warn"
+	case MerkleCheckStrict:
+		return "strict"
+	}
+	return fmt.Sprintf("MerkleCheckMode(%d)", int(m))
+}
+
+// ParseMerkleCheckMode parses the String() form of a MerkleCheckMode.
+func ParseMerkleCheckMode(s string) (MerkleCheckMode, error) {
+	switch strings.ToLower(s) {
+	case "off", "":
+		return MerkleCheckOff, nil
+	case "warn":
+		return MerkleCheckWarn, nil
+	case "strict":
+		return MerkleCheckStrict, nil
+	}
+	return MerkleCheckOff, fmt.Errorf(
+		"Unknown Merkle check mode %q; must be off, warn or strict", s)
+}
+
+// merkleCheckModeFlag is for specifying a MerkleCheckMode with the
+// flag package.
+type merkleCheckModeFlag struct {
+	m *MerkleCheckMode
+}
+
+// String for flag interface.
+func (f merkleCheckModeFlag) String() string {
+	if f.m == nil {
+		return MerkleCheckOff.String()
+	}
+	return f.m.String()
+}
+
+// Set for flag interface.
+func (f merkleCheckModeFlag) Set(raw string) error {
+	m, err := ParseMerkleCheckMode(raw)
+	if err != nil {
+		return err
+	}
+	*f.m = m
+	return nil
+}
+
+// MerkleCheckState is the outcome of checking a TLF's head against
+// the Merkle tree.
+type MerkleCheckState int
+
+const (
+	// MerkleCheckVerified means the head is the one in the tree.
+	MerkleCheckVerified MerkleCheckState = iota
+	// MerkleCheckPending means the head is newer than the tree, or
+	// the TLF isn't in the tree yet.  The tree is only rebuilt
+	// every so often, so this is expected right after a write.
+	MerkleCheckPending
+	// MerkleCheckUnsupported means the MD server has no Merkle tree,
+	// like the local servers used for testing.
+	MerkleCheckUnsupported
+	// MerkleCheckFailed means the head couldn't be checked, or the
+	// tree shows that it's stale or wrong.
+	MerkleCheckFailed
+)
+
+func (s MerkleCheckState) String() string {
+	switch s {
+	case MerkleCheckVerified:
+		return "verified"
+	case MerkleCheckPending:
+		return "pending"
+	case MerkleCheckUnsupported:
+		return "unsupported"
+	case MerkleCheckFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("MerkleCheckState(%d)", int(s))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for
+// MerkleCheckState.
+func (s MerkleCheckState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// MerkleCheckStatus is the result of the last Merkle check of a TLF's
+// head.
+type MerkleCheckStatus struct {
+	TlfID tlf.ID
+	State MerkleCheckState
+	// Revision is the revision of the head that was checked.
+	Revision MetadataRevision
+	// TreeRevision is the TLF's revision in the tree, if it's there.
+	TreeRevision MetadataRevision `json:",omitempty"`
+	// RootSeqNo is the sequence number of the tree root used.
+	RootSeqNo   int64 `json:",omitempty"`
+	LastChecked time.Time
+	Error       string `json:",omitempty"`
+}
+
+const (
+	// merkleTreeFanout is the number of children of each interior
+	// node of the KBFS Merkle trees.  It has to match the server's
+	// tree for lookups to find the right leaves.
+	merkleTreeFanout = 256
+	// merkleTreeMaxLeaves is the most entries a leaf node holds
+	// before it's split.  It only matters when building a tree.
+	merkleTreeMaxLeaves = 512
+	// merkleRootMaxAge is how old the latest root may be.  An older
+	// one could hide newer revisions than the head it vouches for.
+	merkleRootMaxAge = 24 * time.Hour
+)
+
+// merkleNodeFetcher is a read-only merkle.StorageEngine that fetches
+// nodes from the MD server.  Find verifies every node against its
+// parent's hash, so the server can't forge any of them without also
+// forging the root.
+type merkleNodeFetcher struct {
+	ctx    context.Context
+	mdserv MDServer
+	root   merkle.Hash
+}
+
+var _ merkle.StorageEngine = merkleNodeFetcher{}
+
+var errMerkleReadOnly = errors.New("The Merkle tree is read-only")
+
+func (f merkleNodeFetcher) StoreNode(merkle.Hash, []byte) error {
+	return errMerkleReadOnly
+}
+
+func (f merkleNodeFetcher) CommitRoot(
+	prev merkle.Hash, curr merkle.Hash, txinfo merkle.TxInfo) error {
+	return errMerkleReadOnly
+}
+
+func (f merkleNodeFetcher) LookupNode(h merkle.Hash) ([]byte, error) {
+	return f.mdserv.GetMerkleNode(f.ctx, h)
+}
+
+func (f merkleNodeFetcher) LookupRoot() (merkle.Hash, error) {
+	return f.root, nil
+}
+
+// merkleChecker checks merged MD heads against the MD server's Merkle
+// tree, and remembers the result for each TLF.
+//
+// The tree root itself is taken from the MD server, and is only
+// checked for freshness; anchoring it in the global Keybase Merkle
+// tree needs support from the Keybase service that it doesn't have
+// yet.  Until then, this catches heads that are stale compared to
+// what the server has published, e.g. from an out-of-date cache or
+// replica, but not a server that lies consistently.
+type merkleChecker struct {
+	config Config
+	log    logger.Logger
+
+	lock     sync.Mutex
+	statuses map[tlf.ID]MerkleCheckStatus
+}
+
+func newMerkleChecker(config Config) *merkleChecker {
+	return &merkleChecker{
+		config:   config,
+		log:      config.MakeLogger(""),
+		statuses: make(map[tlf.ID]MerkleCheckStatus),
+	}
+}
+
+func merkleTreeIDForTlf(id tlf.ID) keybase1.MerkleTreeID {
+	if id.IsPublic() {
+		return keybase1.MerkleTreeID_KBFS_PUBLIC
+	}
+	return keybase1.MerkleTreeID_KBFS_PRIVATE
+}
+
+// findLeaf looks up the leaf for a TLF in the latest tree.  It
+// returns a nil leaf if the TLF isn't in the tree.
+func (mc *merkleChecker) findLeaf(ctx context.Context,
+	rmd ImmutableRootMetadata) (*MerkleLeaf, *MerkleRoot, error) {
+	mdserv := mc.config.MDServer()
+	root, err := mdserv.GetMerkleRootLatest(
+		ctx, merkleTreeIDForTlf(rmd.TlfID()))
+	if err != nil {
+		return nil, nil, err
+	}
+	rootTime := time.Unix(root.Timestamp, 0)
+	if mc.config.Clock().Now().Sub(rootTime) > merkleRootMaxAge {
+		return nil, root, MerkleRootTooOldError{root.TreeID, rootTime}
+	}
+
+	tree := merkle.NewTree(
+		merkleNodeFetcher{ctx, mdserv, root.Hash},
+		merkle.NewConfig(merkle.SHA512Hasher{}, merkleTreeFanout,
+			merkleTreeMaxLeaves, MerkleLeaf{}))
+	val, _, err := tree.Find(merkle.Hash(rmd.TlfID().Bytes()))
+	if err != nil {
+		return nil, root, err
+	}
+	// Depending on how the leaf was encoded, it may be decoded as
+	// either bytes or a string.
+	var buf []byte
+	switch v := val.(type) {
+	case []byte:
+		buf = v
+	case string:
+		buf = []byte(v)
+	}
+	if len(buf) == 0 {
+		return nil, root, nil
+	}
+
+	codec := mc.config.Codec()
+	if rmd.TlfID().IsPublic() {
+		var leaf MerkleLeaf
+		if err := codec.Decode(buf, &leaf); err != nil {
+			return nil, root, err
+		}
+		return &leaf, root, nil
+	}
+
+	// Private leaves are encrypted for the TLF's key.
+	var eLeaf EncryptedMerkleLeaf
+	if err := codec.Decode(buf, &eLeaf); err != nil {
+		return nil, root, err
+	}
+	if root.EPubKey == nil || root.Nonce == nil {
+		return nil, root, errors.New(
+			"Private Merkle root has no ephemeral key")
+	}
+	leaf, err := mc.config.Crypto().DecryptMerkleLeaf(
+		eLeaf, rmd.data.TLFPrivateKey, root.Nonce, *root.EPubKey)
+	if err != nil {
+		return nil, root, err
+	}
+	return leaf, root, nil
+}
+
+func (mc *merkleChecker) checkHead(ctx context.Context,
+	rmds *RootMetadataSigned, rmd ImmutableRootMetadata) (
+	MerkleCheckStatus, error) {
+	status := MerkleCheckStatus{
+		TlfID:       rmd.TlfID(),
+		Revision:    rmd.Revision(),
+		LastChecked: mc.config.Clock().Now(),
+	}
+	if !rmd.TlfID().IsPublic() &&
+		rmd.data.TLFPrivateKey == (kbfscrypto.TLFPrivateKey{}) {
+		// Without the TLF private key the leaf can't be
+		// decrypted, e.g. for a folder that hasn't been keyed
+		// for this device yet.
+		status.State = MerkleCheckPending
+		return status, nil
+	}
+
+	leaf, root, err := mc.findLeaf(ctx, rmd)
+	if root != nil {
+		status.RootSeqNo = root.SeqNo
+	}
+	if _, ok := err.(MerkleUnsupportedError); ok {
+		status.State = MerkleCheckUnsupported
+		return status, nil
+	} else if err != nil {
+		status.State = MerkleCheckFailed
+		return status, err
+	}
+	if leaf == nil {
+		status.State = MerkleCheckPending
+		return status, nil
+	}
+	status.TreeRevision = leaf.Revision
+
+	switch {
+	case leaf.Revision < rmd.Revision():
+		status.State = MerkleCheckPending
+		return status, nil
+	case leaf.Revision > rmd.Revision():
+		status.State = MerkleCheckFailed
+		return status, MerkleStaleHeadError{
+			rmd.TlfID(), rmd.Revision(), leaf.Revision}
+	}
+
+	hash, err := rmds.MerkleHash(mc.config.Crypto())
+	if err != nil {
+		status.State = MerkleCheckFailed
+		return status, err
+	}
+	if hash != leaf.Hash {
+		status.State = MerkleCheckFailed
+		return status, MerkleHashMismatchError{rmd.TlfID(), rmd.Revision()}
+	}
+	status.State = MerkleCheckVerified
+	return status, nil
+}
+
+// check checks a merged head that was just fetched from the server,
+// according to the configured mode.  It only returns an error in
+// strict mode.
+func (mc *merkleChecker) check(ctx context.Context,
+	rmds *RootMetadataSigned, rmd ImmutableRootMetadata) error {
+	mode := mc.config.MerkleCheckMode()
+	if mode == MerkleCheckOff {
+		return nil
+	}
+
+	status, err := mc.checkHead(ctx, rmds, rmd)
+	if err != nil {
+		status.Error = err.Error()
+	}
+	mc.lock.Lock()
+	mc.statuses[status.TlfID] = status
+	mc.lock.Unlock()
+
+	if err == nil {
+		mc.log.CDebugf(ctx, "Merkle check of %s at revision %d: %s",
+			status.TlfID, status.Revision, status.State)
+		return nil
+	}
+	if mode == MerkleCheckStrict {
+		return err
+	}
+	mc.log.CWarningf(ctx, "Merkle check of %s at revision %d failed: %+v",
+		status.TlfID, status.Revision, err)
+	return nil
+}
+
+type merkleCheckStatusesByTlfID []MerkleCheckStatus
+
+func (s merkleCheckStatusesByTlfID) Len() int { return len(s) }
+
+func (s merkleCheckStatusesByTlfID) Less(i, j int) bool {
+	return s[i].TlfID.String() < s[j].TlfID.String()
+}
+
+func (s merkleCheckStatusesByTlfID) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// getStatus returns the result of the last check of each TLF.
+func (mc *merkleChecker) getStatus() []MerkleCheckStatus {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	statuses := make([]MerkleCheckStatus, 0, len(mc.statuses))
+	for _, s := range mc.statuses {
+		statuses = append(statuses, s)
+	}
+	sort.Sort(merkleCheckStatusesByTlfID(statuses))
+	return statuses
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	merkle "github.com/keybase/go-merkle-tree"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// testMerkleMDServer serves a public KBFS Merkle tree kept in memory.
+type testMerkleMDServer struct {
+	MDServer
+	leaves map[tlf.ID]MerkleLeaf
+	eng    *merkle.MemEngine
+	root   MerkleRoot
+}
+
+func (s *testMerkleMDServer) GetMerkleRootLatest(ctx context.Context,
+	treeID keybase1.MerkleTreeID) (*MerkleRoot, error) {
+	root := s.root
+	return &root, nil
+}
+
+func (s *testMerkleMDServer) GetMerkleNode(
+	ctx context.Context, hash merkle.Hash) ([]byte, error) {
+	return s.eng.LookupNode(hash)
+}
+
+// putLeaf publishes a new root with the given leaf.  The tree is
+// rebuilt from scratch, since upserting a key that's already in a
+// leaf node can leave its old value findable.
+func (s *testMerkleMDServer) putLeaf(t *testing.T, config Config,
+	id tlf.ID, leaf MerkleLeaf) {
+	if s.leaves == nil {
+		s.leaves = make(map[tlf.ID]MerkleLeaf)
+	}
+	s.leaves[id] = leaf
+	s.eng = merkle.NewMemEngine()
+	tree := merkle.NewTree(s.eng, merkle.NewConfig(merkle.SHA512Hasher{},
+		merkleTreeFanout, merkleTreeMaxLeaves, MerkleLeaf{}))
+	for id, leaf := range s.leaves {
+		buf, err := config.Codec().Encode(leaf)
+		require.NoError(t, err)
+		err = tree.Upsert(
+			merkle.KeyValuePair{Key: id.Bytes(), Value: buf}, nil)
+		require.NoError(t, err)
+	}
+	hash, err := s.eng.LookupRoot()
+	require.NoError(t, err)
+	s.root = MerkleRoot{
+		Version:   MerkleRootVersion,
+		TreeID:    keybase1.MerkleTreeID_KBFS_PUBLIC,
+		SeqNo:     s.root.SeqNo + 1,
+		Timestamp: config.Clock().Now().Unix(),
+		Hash:      hash,
+	}
+}
+
+func TestMerkleCheck(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "alice")
+	ctx := context.Background()
+	defer CheckConfigAndShutdown(ctx, t, config)
+
+	rootNode, err := GetRootNodeForTest(ctx, config, "alice", true)
+	require.NoError(t, err)
+	id := rootNode.GetFolderBranch().Tlf
+	rmds, err := config.MDServer().GetForTLF(ctx, id, NullBranchID, Merged)
+	require.NoError(t, err)
+	rmd, err := config.MDOps().GetForTLF(ctx, id)
+	require.NoError(t, err)
+
+	// The local servers have no tree.
+	mc := newMerkleChecker(config)
+	status, err := mc.checkHead(ctx, rmds, rmd)
+	require.NoError(t, err)
+	require.Equal(t, MerkleCheckUnsupported, status.State)
+
+	s := &testMerkleMDServer{MDServer: config.MDServer()}
+	config.SetMDServer(s)
+	defer config.SetMDServer(s.MDServer)
+
+	// A TLF that's not in the tree yet.
+	s.putLeaf(t, config, tlf.FakeID(1, true), MerkleLeaf{Revision: 1})
+	status, err = mc.checkHead(ctx, rmds, rmd)
+	require.NoError(t, err)
+	require.Equal(t, MerkleCheckPending, status.State)
+
+	hash, err := rmds.MerkleHash(config.Crypto())
+	require.NoError(t, err)
+	s.putLeaf(t, config, id, MerkleLeaf{Revision: rmd.Revision(), Hash: hash})
+	status, err = mc.checkHead(ctx, rmds, rmd)
+	require.NoError(t, err)
+	require.Equal(t, MerkleCheckVerified, status.State)
+	require.Equal(t, rmd.Revision(), status.TreeRevision)
+
+	// A different MD with the same revision.
+	s.putLeaf(t, config, id, MerkleLeaf{Revision: rmd.Revision()})
+	_, err = mc.checkHead(ctx, rmds, rmd)
+	require.IsType(t, MerkleHashMismatchError{}, errors.Cause(err))
+
+	// A newer revision than the head.
+	s.putLeaf(t, config, id, MerkleLeaf{Revision: rmd.Revision() + 1})
+	status, err = mc.checkHead(ctx, rmds, rmd)
+	require.IsType(t, MerkleStaleHeadError{}, errors.Cause(err))
+	require.Equal(t, MerkleCheckFailed, status.State)
+
+	// Warn mode records the failure but doesn't return it.
+	config.SetMerkleCheckMode(MerkleCheckWarn)
+	require.NoError(t, mc.check(ctx, rmds, rmd))
+	statuses := mc.getStatus()
+	require.Len(t, statuses, 1)
+	require.Equal(t, MerkleCheckFailed, statuses[0].State)
+	require.NotEqual(t, "", statuses[0].Error)
+	config.SetMerkleCheckMode(MerkleCheckStrict)
+	require.Error(t, mc.check(ctx, rmds, rmd))
+
+	// An old root can't vouch for the head.
+	s.root.Timestamp = config.Clock().Now().Add(
+		-2 * merkleRootMaxAge).Unix()
+	_, err = mc.checkHead(ctx, rmds, rmd)
+	require.IsType(t, MerkleRootTooOldError{}, errors.Cause(err))
+
+	_, err = ParseMerkleCheckMode("bogus")
+	require.Error(t, err)
+	mode, err := ParseMerkleCheckMode("Strict")
+	require.NoError(t, err)
+	require.Equal(t, MerkleCheckStrict, mode)
+}
@@ -8,6 +8,7 @@ import (
 	libkb "github.com/keybase/client/go/libkb"
 	logger "github.com/keybase/client/go/logger"
 	keybase1 "github.com/keybase/client/go/protocol/keybase1"
+	go_merkle_tree "github.com/keybase/go-merkle-tree"
 	kbfsblock "github.com/keybase/kbfs/kbfsblock"
 	kbfscodec "github.com/keybase/kbfs/kbfscodec"
 	kbfscrypto "github.com/keybase/kbfs/kbfscrypto"
@@ -577,6 +578,27 @@ func (_mr *_MockKBFSOpsRecorder) DeleteFavorite(arg0, arg1 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteFavorite", arg0, arg1)
 }
 
+func (_m *MockKBFSOps) DeleteFavorites(ctx context.Context, favs []Favorite) error {
+	ret := _m.ctrl.Call(_m, "DeleteFavorites", ctx, favs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) DeleteFavorites(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteFavorites", arg0, arg1)
+}
+
+func (_m *MockKBFSOps) GetFavoriteCleanupSuggestions(ctx context.Context, params FavoriteCleanupParams) ([]FavoriteSuggestion, error) {
+	ret := _m.ctrl.Call(_m, "GetFavoriteCleanupSuggestions", ctx, params)
+	ret0, _ := ret[0].([]FavoriteSuggestion)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) GetFavoriteCleanupSuggestions(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetFavoriteCleanupSuggestions", arg0, arg1)
+}
+
 func (_m *MockKBFSOps) GetTLFCryptKeys(ctx context.Context, tlfHandle *TlfHandle) ([]kbfscrypto.TLFCryptKey, tlf.ID, error) {
 	ret := _m.ctrl.Call(_m, "GetTLFCryptKeys", ctx, tlfHandle)
 	ret0, _ := ret[0].([]kbfscrypto.TLFCryptKey)
@@ -693,6 +715,17 @@ func (_mr *_MockKBFSOpsRecorder) CreateLink(arg0, arg1, arg2, arg3 interface{})
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateLink", arg0, arg1, arg2, arg3)
 }
 
+func (_m *MockKBFSOps) Link(ctx context.Context, file Node, dir Node, name string) (EntryInfo, error) {
+	ret := _m.ctrl.Call(_m, "Link", ctx, file, dir, name)
+	ret0, _ := ret[0].(EntryInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) Link(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Link", arg0, arg1, arg2, arg3)
+}
+
 func (_m *MockKBFSOps) RemoveDir(ctx context.Context, dir Node, dirName string) error {
 	ret := _m.ctrl.Call(_m, "RemoveDir", ctx, dir, dirName)
 	ret0, _ := ret[0].(error)
@@ -703,6 +736,16 @@ func (_mr *_MockKBFSOpsRecorder) RemoveDir(arg0, arg1, arg2 interface{}) *gomock
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoveDir", arg0, arg1, arg2)
 }
 
+func (_m *MockKBFSOps) RemoveDirTree(ctx context.Context, dir Node, dirName string) error {
+	ret := _m.ctrl.Call(_m, "RemoveDirTree", ctx, dir, dirName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) RemoveDirTree(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoveDirTree", arg0, arg1, arg2)
+}
+
 func (_m *MockKBFSOps) RemoveEntry(ctx context.Context, dir Node, name string) error {
 	ret := _m.ctrl.Call(_m, "RemoveEntry", ctx, dir, name)
 	ret0, _ := ret[0].(error)
@@ -723,6 +766,16 @@ func (_mr *_MockKBFSOpsRecorder) Rename(arg0, arg1, arg2, arg3, arg4 interface{}
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Rename", arg0, arg1, arg2, arg3, arg4)
 }
 
+func (_m *MockKBFSOps) MoveAcrossTLFs(ctx context.Context, srcParent Node, srcName string, dstParent Node, dstName string) error {
+	ret := _m.ctrl.Call(_m, "MoveAcrossTLFs", ctx, srcParent, srcName, dstParent, dstName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) MoveAcrossTLFs(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MoveAcrossTLFs", arg0, arg1, arg2, arg3, arg4)
+}
+
 func (_m *MockKBFSOps) Read(ctx context.Context, file Node, dest []byte, off int64) (int64, error) {
 	ret := _m.ctrl.Call(_m, "Read", ctx, file, dest, off)
 	ret0, _ := ret[0].(int64)
@@ -784,6 +837,233 @@ func (_mr *_MockKBFSOpsRecorder) Sync(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Sync", arg0, arg1)
 }
 
+func (_m *MockKBFSOps) SetFsyncDurability(ctx context.Context, folderBranch FolderBranch, durability FsyncDurability) error {
+	ret := _m.ctrl.Call(_m, "SetFsyncDurability", ctx, folderBranch, durability)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetFsyncDurability(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetFsyncDurability", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) SetFSEventStreaming(ctx context.Context, folderBranch FolderBranch, enabled bool) error {
+	ret := _m.ctrl.Call(_m, "SetFSEventStreaming", ctx, folderBranch, enabled)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetFSEventStreaming(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetFSEventStreaming", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) GetHistoryRetention(ctx context.Context, folderBranch FolderBranch) (HistoryRetention, error) {
+	ret := _m.ctrl.Call(_m, "GetHistoryRetention", ctx, folderBranch)
+	ret0, _ := ret[0].(HistoryRetention)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) GetHistoryRetention(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetHistoryRetention", arg0, arg1)
+}
+
+func (_m *MockKBFSOps) SetHistoryRetention(ctx context.Context, folderBranch FolderBranch, retention HistoryRetention) error {
+	ret := _m.ctrl.Call(_m, "SetHistoryRetention", ctx, folderBranch, retention)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetHistoryRetention(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetHistoryRetention", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) GetPinnedRevisions(ctx context.Context, folderBranch FolderBranch) ([]MetadataRevision, error) {
+	ret := _m.ctrl.Call(_m, "GetPinnedRevisions", ctx, folderBranch)
+	ret0, _ := ret[0].([]MetadataRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) GetPinnedRevisions(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetPinnedRevisions", arg0, arg1)
+}
+
+func (_m *MockKBFSOps) PinRevision(ctx context.Context, folderBranch FolderBranch, rev MetadataRevision) error {
+	ret := _m.ctrl.Call(_m, "PinRevision", ctx, folderBranch, rev)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) PinRevision(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PinRevision", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) UnpinRevision(ctx context.Context, folderBranch FolderBranch, rev MetadataRevision) error {
+	ret := _m.ctrl.Call(_m, "UnpinRevision", ctx, folderBranch, rev)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) UnpinRevision(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UnpinRevision", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) GetSnapshots(ctx context.Context, folderBranch FolderBranch) ([]TLFSnapshot, error) {
+	ret := _m.ctrl.Call(_m, "GetSnapshots", ctx, folderBranch)
+	ret0, _ := ret[0].([]TLFSnapshot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) GetSnapshots(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetSnapshots", arg0, arg1)
+}
+
+func (_m *MockKBFSOps) CreateSnapshot(ctx context.Context, folderBranch FolderBranch, name string, rev MetadataRevision) error {
+	ret := _m.ctrl.Call(_m, "CreateSnapshot", ctx, folderBranch, name, rev)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) CreateSnapshot(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateSnapshot", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockKBFSOps) DeleteSnapshot(ctx context.Context, folderBranch FolderBranch, name string) error {
+	ret := _m.ctrl.Call(_m, "DeleteSnapshot", ctx, folderBranch, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) DeleteSnapshot(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteSnapshot", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) RestoreFile(ctx context.Context, dir Node, name string, rev MetadataRevision) (Node, error) {
+	ret := _m.ctrl.Call(_m, "RestoreFile", ctx, dir, name, rev)
+	ret0, _ := ret[0].(Node)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) RestoreFile(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RestoreFile", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockKBFSOps) GetConflictStrategies(ctx context.Context, folderBranch FolderBranch) ([]ConflictStrategyRule, error) {
+	ret := _m.ctrl.Call(_m, "GetConflictStrategies", ctx, folderBranch)
+	ret0, _ := ret[0].([]ConflictStrategyRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) GetConflictStrategies(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetConflictStrategies", arg0, arg1)
+}
+
+func (_m *MockKBFSOps) SetConflictStrategy(ctx context.Context, folderBranch FolderBranch, prefix string, strategy ConflictStrategy) error {
+	ret := _m.ctrl.Call(_m, "SetConflictStrategy", ctx, folderBranch, prefix, strategy)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetConflictStrategy(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetConflictStrategy", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockKBFSOps) ClearConflictStrategy(ctx context.Context, folderBranch FolderBranch, prefix string) error {
+	ret := _m.ctrl.Call(_m, "ClearConflictStrategy", ctx, folderBranch, prefix)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) ClearConflictStrategy(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ClearConflictStrategy", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) ForceQuotaReclamation(ctx context.Context, folderBranch FolderBranch) error {
+	ret := _m.ctrl.Call(_m, "ForceQuotaReclamation", ctx, folderBranch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) ForceQuotaReclamation(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ForceQuotaReclamation", arg0, arg1)
+}
+
+func (_m *MockKBFSOps) SetQuotaReclamationPaused(ctx context.Context, folderBranch FolderBranch, paused bool) error {
+	ret := _m.ctrl.Call(_m, "SetQuotaReclamationPaused", ctx, folderBranch, paused)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetQuotaReclamationPaused(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetQuotaReclamationPaused", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) SetTlfSynced(ctx context.Context, folderBranch FolderBranch, synced bool) error {
+	ret := _m.ctrl.Call(_m, "SetTlfSynced", ctx, folderBranch, synced)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetTlfSynced(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetTlfSynced", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) SetQuotaReclamationMinHeadAge(ctx context.Context, folderBranch FolderBranch, age time.Duration) error {
+	ret := _m.ctrl.Call(_m, "SetQuotaReclamationMinHeadAge", ctx, folderBranch, age)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetQuotaReclamationMinHeadAge(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetQuotaReclamationMinHeadAge", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) SetTlfFrozen(ctx context.Context, folderBranch FolderBranch, frozen bool) error {
+	ret := _m.ctrl.Call(_m, "SetTlfFrozen", ctx, folderBranch, frozen)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetTlfFrozen(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetTlfFrozen", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) SetTlfAppendOnly(ctx context.Context, folderBranch FolderBranch, appendOnly bool) error {
+	ret := _m.ctrl.Call(_m, "SetTlfAppendOnly", ctx, folderBranch, appendOnly)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetTlfAppendOnly(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetTlfAppendOnly", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) SetTlfNameNormalization(ctx context.Context, folderBranch FolderBranch, n NameNormalization) error {
+	ret := _m.ctrl.Call(_m, "SetTlfNameNormalization", ctx, folderBranch, n)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetTlfNameNormalization(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetTlfNameNormalization", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) CreateTLFFrom(ctx context.Context, h *TlfHandle, src Node) (Node, EntryInfo, error) {
+	ret := _m.ctrl.Call(_m, "CreateTLFFrom", ctx, h, src)
+	ret0, _ := ret[0].(Node)
+	ret1, _ := ret[1].(EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockKBFSOpsRecorder) CreateTLFFrom(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateTLFFrom", arg0, arg1, arg2)
+}
+
 func (_m *MockKBFSOps) FolderStatus(ctx context.Context, folderBranch FolderBranch) (FolderBranchStatus, <-chan StatusUpdate, error) {
 	ret := _m.ctrl.Call(_m, "FolderStatus", ctx, folderBranch)
 	ret0, _ := ret[0].(FolderBranchStatus)
@@ -895,6 +1175,48 @@ func (_mr *_MockKBFSOpsRecorder) PushStatusChange() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "PushStatusChange")
 }
 
+func (_m *MockKBFSOps) SetSessionUser(ctx context.Context, uid keybase1.UID) bool {
+	ret := _m.ctrl.Call(_m, "SetSessionUser", ctx, uid)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetSessionUser(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetSessionUser", arg0, arg1)
+}
+
+func (_m *MockKBFSOps) DeviceRevoked(ctx context.Context, err DeviceRevokedError) {
+	_m.ctrl.Call(_m, "DeviceRevoked", ctx, err)
+}
+
+func (_mr *_MockKBFSOpsRecorder) DeviceRevoked(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeviceRevoked", arg0, arg1)
+}
+
+func (_m *MockKBFSOps) PauseBackgroundWork(ctx context.Context) {
+	_m.ctrl.Call(_m, "PauseBackgroundWork", ctx)
+}
+
+func (_mr *_MockKBFSOpsRecorder) PauseBackgroundWork(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PauseBackgroundWork", arg0)
+}
+
+func (_m *MockKBFSOps) ResumeBackgroundWork(ctx context.Context) {
+	_m.ctrl.Call(_m, "ResumeBackgroundWork", ctx)
+}
+
+func (_mr *_MockKBFSOpsRecorder) ResumeBackgroundWork(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ResumeBackgroundWork", arg0)
+}
+
+func (_m *MockKBFSOps) OverrideBandwidthCaps(ctx context.Context, caps *BandwidthCaps) {
+	_m.ctrl.Call(_m, "OverrideBandwidthCaps", ctx, caps)
+}
+
+func (_mr *_MockKBFSOpsRecorder) OverrideBandwidthCaps(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "OverrideBandwidthCaps", arg0, arg1)
+}
+
 func (_m *MockKBFSOps) ClearPrivateFolderMD(ctx context.Context) {
 	_m.ctrl.Call(_m, "ClearPrivateFolderMD", ctx)
 }
@@ -2971,6 +3293,16 @@ func (_mr *_MockMDOpsRecorder) GetLatestHandleForTLF(arg0, arg1 interface{}) *go
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetLatestHandleForTLF", arg0, arg1)
 }
 
+func (_m *MockMDOps) GetMerkleCheckStatus() []MerkleCheckStatus {
+	ret := _m.ctrl.Call(_m, "GetMerkleCheckStatus")
+	ret0, _ := ret[0].([]MerkleCheckStatus)
+	return ret0
+}
+
+func (_mr *_MockMDOpsRecorder) GetMerkleCheckStatus() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetMerkleCheckStatus")
+}
+
 // Mock of KeyOps interface
 type MockKeyOps struct {
 	ctrl     *gomock.Controller
@@ -3399,6 +3731,28 @@ func (_mr *_MockMDServerRecorder) GetKeyBundles(arg0, arg1, arg2, arg3 interface
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetKeyBundles", arg0, arg1, arg2, arg3)
 }
 
+func (_m *MockMDServer) GetMerkleRootLatest(ctx context.Context, treeID keybase1.MerkleTreeID) (*MerkleRoot, error) {
+	ret := _m.ctrl.Call(_m, "GetMerkleRootLatest", ctx, treeID)
+	ret0, _ := ret[0].(*MerkleRoot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockMDServerRecorder) GetMerkleRootLatest(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetMerkleRootLatest", arg0, arg1)
+}
+
+func (_m *MockMDServer) GetMerkleNode(ctx context.Context, hash go_merkle_tree.Hash) ([]byte, error) {
+	ret := _m.ctrl.Call(_m, "GetMerkleNode", ctx, hash)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockMDServerRecorder) GetMerkleNode(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetMerkleNode", arg0, arg1)
+}
+
 // Mock of mdServerLocal interface
 type MockmdServerLocal struct {
 	ctrl     *gomock.Controller
@@ -3593,6 +3947,28 @@ func (_mr *_MockmdServerLocalRecorder) GetKeyBundles(arg0, arg1, arg2, arg3 inte
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetKeyBundles", arg0, arg1, arg2, arg3)
 }
 
+func (_m *MockmdServerLocal) GetMerkleRootLatest(ctx context.Context, treeID keybase1.MerkleTreeID) (*MerkleRoot, error) {
+	ret := _m.ctrl.Call(_m, "GetMerkleRootLatest", ctx, treeID)
+	ret0, _ := ret[0].(*MerkleRoot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockmdServerLocalRecorder) GetMerkleRootLatest(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetMerkleRootLatest", arg0, arg1)
+}
+
+func (_m *MockmdServerLocal) GetMerkleNode(ctx context.Context, hash go_merkle_tree.Hash) ([]byte, error) {
+	ret := _m.ctrl.Call(_m, "GetMerkleNode", ctx, hash)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockmdServerLocalRecorder) GetMerkleNode(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetMerkleNode", arg0, arg1)
+}
+
 func (_m *MockmdServerLocal) addNewAssertionForTest(uid keybase1.UID, newAssertion keybase1.SocialAssertion) error {
 	ret := _m.ctrl.Call(_m, "addNewAssertionForTest", uid, newAssertion)
 	ret0, _ := ret[0].(error)
@@ -4107,6 +4483,36 @@ func (_mr *_MockClockRecorder) Now() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Now")
 }
 
+func (_m *MockClock) NewTimer(_param0 time.Duration) Timer {
+	ret := _m.ctrl.Call(_m, "NewTimer", _param0)
+	ret0, _ := ret[0].(Timer)
+	return ret0
+}
+
+func (_mr *_MockClockRecorder) NewTimer(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "NewTimer", arg0)
+}
+
+func (_m *MockClock) NewTicker(_param0 time.Duration) Ticker {
+	ret := _m.ctrl.Call(_m, "NewTicker", _param0)
+	ret0, _ := ret[0].(Ticker)
+	return ret0
+}
+
+func (_mr *_MockClockRecorder) NewTicker(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "NewTicker", arg0)
+}
+
+func (_m *MockClock) AfterFunc(_param0 time.Duration, _param1 func()) Timer {
+	ret := _m.ctrl.Call(_m, "AfterFunc", _param0, _param1)
+	ret0, _ := ret[0].(Timer)
+	return ret0
+}
+
+func (_mr *_MockClockRecorder) AfterFunc(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AfterFunc", arg0, arg1)
+}
+
 // Mock of ConflictRenamer interface
 type MockConflictRenamer struct {
 	ctrl     *gomock.Controller
@@ -4270,6 +4676,60 @@ func (_mr *_MockConfigRecorder) DiskBlockCache() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "DiskBlockCache")
 }
 
+func (_m *MockConfig) DiskMDCache() DiskMDCache {
+	ret := _m.ctrl.Call(_m, "DiskMDCache")
+	ret0, _ := ret[0].(DiskMDCache)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) DiskMDCache() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DiskMDCache")
+}
+
+func (_m *MockConfig) SetDiskMDCache(_param0 DiskMDCache) {
+	_m.ctrl.Call(_m, "SetDiskMDCache", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetDiskMDCache(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetDiskMDCache", arg0)
+}
+
+func (_m *MockConfig) DiskPreviewCache() DiskPreviewCache {
+	ret := _m.ctrl.Call(_m, "DiskPreviewCache")
+	ret0, _ := ret[0].(DiskPreviewCache)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) DiskPreviewCache() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DiskPreviewCache")
+}
+
+func (_m *MockConfig) SetDiskPreviewCache(_param0 DiskPreviewCache) {
+	_m.ctrl.Call(_m, "SetDiskPreviewCache", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetDiskPreviewCache(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetDiskPreviewCache", arg0)
+}
+
+func (_m *MockConfig) BlockSpillCache() BlockSpillCache {
+	ret := _m.ctrl.Call(_m, "BlockSpillCache")
+	ret0, _ := ret[0].(BlockSpillCache)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) BlockSpillCache() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "BlockSpillCache")
+}
+
+func (_m *MockConfig) SetBlockSpillCache(_param0 BlockSpillCache) {
+	_m.ctrl.Call(_m, "SetBlockSpillCache", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetBlockSpillCache(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetBlockSpillCache", arg0)
+}
+
 func (_m *MockConfig) KBFSOps() KBFSOps {
 	ret := _m.ctrl.Call(_m, "KBFSOps")
 	ret0, _ := ret[0].(KBFSOps)
@@ -4756,6 +5216,26 @@ func (_mr *_MockConfigRecorder) QuotaReclamationPeriod() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "QuotaReclamationPeriod")
 }
 
+func (_m *MockConfig) DiskCacheVerificationPeriod() time.Duration {
+	ret := _m.ctrl.Call(_m, "DiskCacheVerificationPeriod")
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) DiskCacheVerificationPeriod() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DiskCacheVerificationPeriod")
+}
+
+func (_m *MockConfig) BlockChallengePeriod() time.Duration {
+	ret := _m.ctrl.Call(_m, "BlockChallengePeriod")
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) BlockChallengePeriod() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "BlockChallengePeriod")
+}
+
 func (_m *MockConfig) QuotaReclamationMinUnrefAge() time.Duration {
 	ret := _m.ctrl.Call(_m, "QuotaReclamationMinUnrefAge")
 	ret0, _ := ret[0].(time.Duration)
@@ -4820,6 +5300,168 @@ func (_mr *_MockConfigRecorder) SetTLFValidDuration(arg0 interface{}) *gomock.Ca
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetTLFValidDuration", arg0)
 }
 
+func (_m *MockConfig) TLFIdleTimeout() time.Duration {
+	ret := _m.ctrl.Call(_m, "TLFIdleTimeout")
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) TLFIdleTimeout() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "TLFIdleTimeout")
+}
+
+func (_m *MockConfig) SetTLFIdleTimeout(_param0 time.Duration) {
+	_m.ctrl.Call(_m, "SetTLFIdleTimeout", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetTLFIdleTimeout(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetTLFIdleTimeout", arg0)
+}
+
+func (_m *MockConfig) WriteCoalescingBytes() int64 {
+	ret := _m.ctrl.Call(_m, "WriteCoalescingBytes")
+	ret0, _ := ret[0].(int64)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) WriteCoalescingBytes() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "WriteCoalescingBytes")
+}
+
+func (_m *MockConfig) SetWriteCoalescingBytes(_param0 int64) {
+	_m.ctrl.Call(_m, "SetWriteCoalescingBytes", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetWriteCoalescingBytes(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetWriteCoalescingBytes", arg0)
+}
+
+func (_m *MockConfig) MetadataOpDelay() time.Duration {
+	ret := _m.ctrl.Call(_m, "MetadataOpDelay")
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) MetadataOpDelay() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MetadataOpDelay")
+}
+
+func (_m *MockConfig) SetMetadataOpDelay(_param0 time.Duration) {
+	_m.ctrl.Call(_m, "SetMetadataOpDelay", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetMetadataOpDelay(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetMetadataOpDelay", arg0)
+}
+
+func (_m *MockConfig) FsyncDurability() FsyncDurability {
+	ret := _m.ctrl.Call(_m, "FsyncDurability")
+	ret0, _ := ret[0].(FsyncDurability)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) FsyncDurability() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "FsyncDurability")
+}
+
+func (_m *MockConfig) SetFsyncDurability(_param0 FsyncDurability) {
+	_m.ctrl.Call(_m, "SetFsyncDurability", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetFsyncDurability(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetFsyncDurability", arg0)
+}
+
+func (_m *MockConfig) MerkleCheckMode() MerkleCheckMode {
+	ret := _m.ctrl.Call(_m, "MerkleCheckMode")
+	ret0, _ := ret[0].(MerkleCheckMode)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) MerkleCheckMode() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MerkleCheckMode")
+}
+
+func (_m *MockConfig) SetMerkleCheckMode(_param0 MerkleCheckMode) {
+	_m.ctrl.Call(_m, "SetMerkleCheckMode", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetMerkleCheckMode(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetMerkleCheckMode", arg0)
+}
+
+func (_m *MockConfig) IdentifyPolicy() IdentifyPolicy {
+	ret := _m.ctrl.Call(_m, "IdentifyPolicy")
+	ret0, _ := ret[0].(IdentifyPolicy)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) IdentifyPolicy() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "IdentifyPolicy")
+}
+
+func (_m *MockConfig) SetIdentifyPolicy(_param0 IdentifyPolicy) {
+	_m.ctrl.Call(_m, "SetIdentifyPolicy", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetIdentifyPolicy(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetIdentifyPolicy", arg0)
+}
+
+func (_m *MockConfig) DiskCacheBackend() DiskCacheBackend {
+	ret := _m.ctrl.Call(_m, "DiskCacheBackend")
+	ret0, _ := ret[0].(DiskCacheBackend)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) DiskCacheBackend() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DiskCacheBackend")
+}
+
+func (_m *MockConfig) SetDiskCacheBackend(_param0 DiskCacheBackend) {
+	_m.ctrl.Call(_m, "SetDiskCacheBackend", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetDiskCacheBackend(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetDiskCacheBackend", arg0)
+}
+
+func (_m *MockConfig) ServerProxies() ServerProxies {
+	ret := _m.ctrl.Call(_m, "ServerProxies")
+	ret0, _ := ret[0].(ServerProxies)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) ServerProxies() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ServerProxies")
+}
+
+func (_m *MockConfig) SetServerProxies(_param0 ServerProxies) {
+	_m.ctrl.Call(_m, "SetServerProxies", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetServerProxies(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetServerProxies", arg0)
+}
+
+func (_m *MockConfig) PlatformHooks() PlatformHooks {
+	ret := _m.ctrl.Call(_m, "PlatformHooks")
+	ret0, _ := ret[0].(PlatformHooks)
+	return ret0
+}
+
+func (_mr *_MockConfigRecorder) PlatformHooks() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PlatformHooks")
+}
+
+func (_m *MockConfig) SetPlatformHooks(_param0 PlatformHooks) {
+	_m.ctrl.Call(_m, "SetPlatformHooks", _param0)
+}
+
+func (_mr *_MockConfigRecorder) SetPlatformHooks(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetPlatformHooks", arg0)
+}
+
 func (_m *MockConfig) Shutdown(_param0 context.Context) error {
 	ret := _m.ctrl.Call(_m, "Shutdown", _param0)
 	ret0, _ := ret[0].(error)
@@ -4840,6 +5482,38 @@ func (_mr *_MockConfigRecorder) CheckStateOnShutdown() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CheckStateOnShutdown")
 }
 
+// Mock of PlatformHooks interface
+type MockPlatformHooks struct {
+	ctrl     *gomock.Controller
+	recorder *_MockPlatformHooksRecorder
+}
+
+// Recorder for MockPlatformHooks (not exported)
+type _MockPlatformHooksRecorder struct {
+	mock *MockPlatformHooks
+}
+
+func NewMockPlatformHooks(ctrl *gomock.Controller) *MockPlatformHooks {
+	mock := &MockPlatformHooks{ctrl: ctrl}
+	mock.recorder = &_MockPlatformHooksRecorder{mock}
+	return mock
+}
+
+func (_m *MockPlatformHooks) EXPECT() *_MockPlatformHooksRecorder {
+	return _m.recorder
+}
+
+func (_m *MockPlatformHooks) State(ctx context.Context) (PlatformState, error) {
+	ret := _m.ctrl.Call(_m, "State", ctx)
+	ret0, _ := ret[0].(PlatformState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockPlatformHooksRecorder) State(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "State", arg0)
+}
+
 // Mock of NodeCache interface
 type MockNodeCache struct {
 	ctrl     *gomock.Controller
@@ -5034,6 +5708,16 @@ func (_mr *_MockRekeyQueueRecorder) IsRekeyPending(arg0 interface{}) *gomock.Cal
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsRekeyPending", arg0)
 }
 
+func (_m *MockRekeyQueue) GetStatus() []RekeyStatus {
+	ret := _m.ctrl.Call(_m, "GetStatus")
+	ret0, _ := ret[0].([]RekeyStatus)
+	return ret0
+}
+
+func (_mr *_MockRekeyQueueRecorder) GetStatus() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetStatus")
+}
+
 func (_m *MockRekeyQueue) Shutdown() {
 	_m.ctrl.Call(_m, "Shutdown")
 }
@@ -5385,6 +6069,36 @@ func (_mr *_MockBareRootMetadataRecorder) IsUnmergedSet() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsUnmergedSet")
 }
 
+func (_m *MockBareRootMetadata) IsFrozen() bool {
+	ret := _m.ctrl.Call(_m, "IsFrozen")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+func (_mr *_MockBareRootMetadataRecorder) IsFrozen() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsFrozen")
+}
+
+func (_m *MockBareRootMetadata) IsAppendOnly() bool {
+	ret := _m.ctrl.Call(_m, "IsAppendOnly")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+func (_mr *_MockBareRootMetadataRecorder) IsAppendOnly() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsAppendOnly")
+}
+
+func (_m *MockBareRootMetadata) NameNormalization() NameNormalization {
+	ret := _m.ctrl.Call(_m, "NameNormalization")
+	ret0, _ := ret[0].(NameNormalization)
+	return ret0
+}
+
+func (_mr *_MockBareRootMetadataRecorder) NameNormalization() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "NameNormalization")
+}
+
 func (_m *MockBareRootMetadata) GetSerializedPrivateMetadata() []byte {
 	ret := _m.ctrl.Call(_m, "GetSerializedPrivateMetadata")
 	ret0, _ := ret[0].([]byte)
@@ -5821,6 +6535,36 @@ func (_mr *_MockMutableBareRootMetadataRecorder) IsUnmergedSet() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsUnmergedSet")
 }
 
+func (_m *MockMutableBareRootMetadata) IsFrozen() bool {
+	ret := _m.ctrl.Call(_m, "IsFrozen")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+func (_mr *_MockMutableBareRootMetadataRecorder) IsFrozen() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsFrozen")
+}
+
+func (_m *MockMutableBareRootMetadata) IsAppendOnly() bool {
+	ret := _m.ctrl.Call(_m, "IsAppendOnly")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+func (_mr *_MockMutableBareRootMetadataRecorder) IsAppendOnly() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsAppendOnly")
+}
+
+func (_m *MockMutableBareRootMetadata) NameNormalization() NameNormalization {
+	ret := _m.ctrl.Call(_m, "NameNormalization")
+	ret0, _ := ret[0].(NameNormalization)
+	return ret0
+}
+
+func (_mr *_MockMutableBareRootMetadataRecorder) NameNormalization() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "NameNormalization")
+}
+
 func (_m *MockMutableBareRootMetadata) GetSerializedPrivateMetadata() []byte {
 	ret := _m.ctrl.Call(_m, "GetSerializedPrivateMetadata")
 	ret0, _ := ret[0].([]byte)
@@ -6026,6 +6770,30 @@ func (_mr *_MockMutableBareRootMetadataRecorder) SetUnmerged() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetUnmerged")
 }
 
+func (_m *MockMutableBareRootMetadata) SetFrozen(frozen bool) {
+	_m.ctrl.Call(_m, "SetFrozen", frozen)
+}
+
+func (_mr *_MockMutableBareRootMetadataRecorder) SetFrozen(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetFrozen", arg0)
+}
+
+func (_m *MockMutableBareRootMetadata) SetAppendOnly(appendOnly bool) {
+	_m.ctrl.Call(_m, "SetAppendOnly", appendOnly)
+}
+
+func (_mr *_MockMutableBareRootMetadataRecorder) SetAppendOnly(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetAppendOnly", arg0)
+}
+
+func (_m *MockMutableBareRootMetadata) SetNameNormalization(n NameNormalization) {
+	_m.ctrl.Call(_m, "SetNameNormalization", n)
+}
+
+func (_mr *_MockMutableBareRootMetadataRecorder) SetNameNormalization(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetNameNormalization", arg0)
+}
+
 func (_m *MockMutableBareRootMetadata) SetBranchID(bid BranchID) {
 	_m.ctrl.Call(_m, "SetBranchID", bid)
 }
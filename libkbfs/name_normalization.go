@@ -0,0 +1,113 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NameNormalization is the unicode normalization form in which a TLF
+// stores the names of new entries.  macOS hands KBFS names in NFD,
+// while Linux and Windows applications usually use NFC, so without a
+// canonical form the same name typed on two devices could name two
+// different entries.
+//
+// The form also depends on the Unicode version of the normalization
+// tables, since newer versions can normalize newly-assigned
+// characters differently.  So every client uses the same tables,
+// nameNormalizationUnicodeVersion, whatever Go version it's built
+// with.
+type NameNormalization int
+
+// nameNormalizationUnicodeVersion is the Unicode version of the
+// normalization tables used for entry names.  Changing it changes
+// the canonical form of some names, so it needs a new metadata
+// version.
+const nameNormalizationUnicodeVersion = "17.0.0"
+
+const (
+	// NameNormalizationNFC stores new names in NFC.  This is the
+	// default for every TLF.
+	NameNormalizationNFC NameNormalization = iota
+	// NameNormalizationNFD stores new names in NFD.
+	NameNormalizationNFD
+)
+
+func (n NameNormalization) String() string {
+	switch n {
+	case NameNormalizationNFC:
+		return "nfc"
+	case NameNormalizationNFD:
+		return "nfd"
+	}
+	return fmt.Sprintf("NameNormalization(%d)", int(n))
+}
+
+// ParseNameNormalization parses the String() form of a
+// NameNormalization.
+func ParseNameNormalization(s string) (NameNormalization, error) {
+	switch s {
+	case "nfc":
+		return NameNormalizationNFC, nil
+	case "nfd":
+		return NameNormalizationNFD, nil
+	}
+	return NameNormalizationNFC, fmt.Errorf(
+		"Unknown name normalization %q", s)
+}
+
+func (n NameNormalization) form() norm.Form {
+	if n == NameNormalizationNFD {
+		return norm.NFD
+	}
+	return norm.NFC
+}
+
+// otherNormalizations returns the spellings of name in NFC and NFD
+// that differ from name itself.  Entries written before a TLF had a
+// canonical form, or by a device that didn't normalize, may be under
+// any of them.
+func otherNormalizations(name string) []string {
+	var others []string
+	for _, form := range []norm.Form{norm.NFC, norm.NFD} {
+		other := form.String(name)
+		if other != name {
+			others = append(others, other)
+		}
+	}
+	return others
+}
+
+// existingEntryName returns the name of the entry in children that
+// name refers to: the entry by exactly that name if there is one, or
+// else one whose name differs only in unicode normalization.
+func existingEntryName(
+	children map[string]DirEntry, name string) (string, bool) {
+	if _, ok := children[name]; ok {
+		return name, true
+	}
+	for _, other := range otherNormalizations(name) {
+		if _, ok := children[other]; ok {
+			return other, true
+		}
+	}
+	return "", false
+}
+
+// newEntryName returns the name under which to store an entry called
+// name in a directory with the given children.  That's the name of
+// the existing entry it refers to, if any, so that creates and
+// renames conflict with and replace it as they would on a
+// normalization-insensitive file system; otherwise it's name in the
+// canonical form n.
+func (n NameNormalization) newEntryName(
+	children map[string]DirEntry, name string) string {
+	if existing, ok := existingEntryName(children, name); ok {
+		return existing
+	}
+	return n.form().String(name)
+}
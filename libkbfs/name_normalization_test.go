@@ -0,0 +1,48 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestNameNormalizationUnicodeVersion(t *testing.T) {
+	require.Equal(t, nameNormalizationUnicodeVersion, norm.Version)
+}
+
+func TestNameNormalizationNewEntryName(t *testing.T) {
+	const nfc, nfd = "caf\u00e9", "cafe\u0301"
+	children := map[string]DirEntry{nfd: {}, "plain": {}}
+
+	// Existing entries win, in any form.
+	require.Equal(t, nfd, NameNormalizationNFC.newEntryName(children, nfc))
+	require.Equal(t, nfd, NameNormalizationNFC.newEntryName(children, nfd))
+	require.Equal(t, "plain",
+		NameNormalizationNFD.newEntryName(children, "plain"))
+
+	// New names take the canonical form.
+	require.Equal(t, "na\u00efve",
+		NameNormalizationNFC.newEntryName(children, "nai\u0308ve"))
+	require.Equal(t, "nai\u0308ve",
+		NameNormalizationNFD.newEntryName(children, "na\u00efve"))
+
+	_, ok := existingEntryName(children, "other")
+	require.False(t, ok)
+	require.Empty(t, otherNormalizations("plain"))
+}
+
+func TestParseNameNormalization(t *testing.T) {
+	for _, n := range []NameNormalization{
+		NameNormalizationNFC, NameNormalizationNFD} {
+		parsed, err := ParseNameNormalization(n.String())
+		require.NoError(t, err)
+		require.Equal(t, n, parsed)
+	}
+	_, err := ParseNameNormalization("nfkc")
+	require.Error(t, err)
+}
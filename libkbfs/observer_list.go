@@ -39,6 +39,18 @@ func (ol *observerList) remove(o Observer) {
 	}
 }
 
+// countIf returns the number of observers for which `f` returns true.
+func (ol *observerList) countIf(f func(Observer) bool) (count int) {
+	ol.lock.RLock()
+	defer ol.lock.RUnlock()
+	for _, o := range ol.observers {
+		if f(o) {
+			count++
+		}
+	}
+	return count
+}
+
 func (ol *observerList) localChange(
 	ctx context.Context, node Node, write WriteRange) {
 	ol.lock.RLock()
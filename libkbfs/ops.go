@@ -1113,6 +1113,17 @@ func newRekeyOp() *rekeyOp {
 	return ro
 }
 
+// newSettingsOp returns the op to record in an MD that only changes
+// folder-wide settings (e.g., freezing the folder or pinning a
+// revision).  The settings themselves are kept in the writer flags
+// and the private metadata, where older clients ignore them but keep
+// them intact.  An op of a new type would instead make the MD
+// undecodable for those clients, so this is just an empty rekeyOp,
+// which every client knows to skip.
+func newSettingsOp() *rekeyOp {
+	return newRekeyOp()
+}
+
 func (ro *rekeyOp) SizeExceptUpdates() uint64 {
 	return 0
 }
@@ -1203,6 +1214,70 @@ func (gco *GCOp) getDefaultAction(mergedPath path) crAction {
 	return nil
 }
 
+// unknownOp is an op with an op code that this client doesn't
+// recognize, presumably because it was written by a newer client.
+// It is decoded from the raw extension data and treated as a no-op
+// that doesn't change any data, though its block changes are still
+// honored.  It encodes back into the original extension, so the op
+// list of an MD that's re-embedded or re-encoded is left intact.
+type unknownOp struct {
+	OpCommon
+
+	raw codec.RawExt
+}
+
+func newUnknownOp(raw codec.RawExt) *unknownOp {
+	uo := &unknownOp{raw: raw}
+	// Every op embeds OpCommon, so pull out the block changes if
+	// possible.  If not, just leave them empty.
+	_ = kbfscodec.NewMsgpack().Decode(raw.Data, &uo.OpCommon)
+	return uo
+}
+
+// CodecEncodeSelf implements the codec.Selfer interface for
+// unknownOp.
+func (uo *unknownOp) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(&uo.raw)
+}
+
+// CodecDecodeSelf implements the codec.Selfer interface for
+// unknownOp.
+func (uo *unknownOp) CodecDecodeSelf(d *codec.Decoder) {
+	d.MustDecode(&uo.raw)
+}
+
+func (uo *unknownOp) SizeExceptUpdates() uint64 {
+	return uint64(len(uo.raw.Data))
+}
+
+func (uo *unknownOp) allUpdates() []blockUpdate {
+	return uo.Updates
+}
+
+func (uo *unknownOp) checkValid() error {
+	return uo.checkUpdatesValid()
+}
+
+func (uo *unknownOp) String() string {
+	return fmt.Sprintf("unknown op %d", uo.raw.Tag)
+}
+
+func (uo *unknownOp) StringWithRefs(numRefIndents int) string {
+	res := uo.String() + "\n"
+	res += uo.stringWithRefs(numRefIndents)
+	return res
+}
+
+func (uo *unknownOp) checkConflict(
+	ctx context.Context, renamer ConflictRenamer, mergedOp op,
+	isFile bool) (crAction, error) {
+	return nil, nil
+}
+
+func (uo *unknownOp) getDefaultAction(mergedPath path) crAction {
+	return nil
+}
+
 // invertOpForLocalNotifications returns an operation that represents
 // an undoing of the effect of the given op.  These are intended to be
 // used for local notifications only, and would not be useful for
@@ -1252,6 +1327,10 @@ func invertOpForLocalNotifications(oldOp op) (newOp op, err error) {
 		}
 	case *GCOp:
 		newOp = op
+	case *rekeyOp:
+		newOp = newRekeyOp()
+	case *unknownOp:
+		newOp = &unknownOp{raw: op.raw}
 	}
 
 	// Now reverse all the block updates.  Don't bother with bare Refs
@@ -1291,6 +1370,9 @@ func opPointerizer(iface interface{}) reflect.Value {
 		return reflect.ValueOf(&op)
 	case GCOp:
 		return reflect.ValueOf(&op)
+	case codec.RawExt:
+		// An op code we don't know about.
+		return reflect.ValueOf(newUnknownOp(op))
 	}
 }
 
@@ -195,6 +195,47 @@ func registerOpsFuture(codec kbfscodec.Codec) {
 		opPointerizerFuture)
 }
 
+// opPointerizerBaseline and registerOpsBaseline only know about the
+// op types that clients in the field know about, so they can be used
+// to check that new MDs remain readable by those clients.  They
+// should never change.
+
+func opPointerizerBaseline(iface interface{}) reflect.Value {
+	switch op := iface.(type) {
+	default:
+		return reflect.ValueOf(iface)
+	case createOp:
+		return reflect.ValueOf(&op)
+	case rmOp:
+		return reflect.ValueOf(&op)
+	case renameOp:
+		return reflect.ValueOf(&op)
+	case syncOp:
+		return reflect.ValueOf(&op)
+	case setAttrOp:
+		return reflect.ValueOf(&op)
+	case resolutionOp:
+		return reflect.ValueOf(&op)
+	case rekeyOp:
+		return reflect.ValueOf(&op)
+	case GCOp:
+		return reflect.ValueOf(&op)
+	}
+}
+
+func registerOpsBaseline(codec kbfscodec.Codec) {
+	codec.RegisterType(reflect.TypeOf(createOp{}), createOpCode)
+	codec.RegisterType(reflect.TypeOf(rmOp{}), rmOpCode)
+	codec.RegisterType(reflect.TypeOf(renameOp{}), renameOpCode)
+	codec.RegisterType(reflect.TypeOf(syncOp{}), syncOpCode)
+	codec.RegisterType(reflect.TypeOf(setAttrOp{}), setAttrOpCode)
+	codec.RegisterType(reflect.TypeOf(resolutionOp{}), resolutionOpCode)
+	codec.RegisterType(reflect.TypeOf(rekeyOp{}), rekeyOpCode)
+	codec.RegisterType(reflect.TypeOf(GCOp{}), gcOpCode)
+	codec.RegisterIfaceSliceType(reflect.TypeOf(opsList{}), opsListCode,
+		opPointerizerBaseline)
+}
+
 type createOpFuture struct {
 	createOp
 	kbfscodec.Extra
@@ -470,6 +511,54 @@ func TestGcOpUnknownFields(t *testing.T) {
 	testStructUnknownFields(t, makeFakeGcOpFuture(t))
 }
 
+// newOpFuture stands in for an op type added by a future client,
+// with an op code that the current client doesn't know about.
+type newOpFuture struct {
+	rekeyOp
+	Data string `codec:"d"`
+}
+
+const newOpFutureCode = gcOpCode + 1
+
+// Tests that ops with unknown op codes are decoded as unknownOps,
+// which keep their block changes and re-encode identically.
+func TestUnknownOpSerialization(t *testing.T) {
+	cFuture := kbfscodec.NewMsgpack()
+	registerOpsFuture(cFuture)
+	cFuture.RegisterType(reflect.TypeOf(newOpFuture{}), newOpFutureCode)
+
+	cCurrent := kbfscodec.NewMsgpack()
+	RegisterOps(cCurrent)
+
+	rof := makeFakeRmOpFuture(t)
+	nof := &newOpFuture{
+		rekeyOp: rekeyOp{makeFakeOpCommon(t, true)},
+		Data:    "future data",
+	}
+	type testOpsList struct {
+		Ops opsList
+	}
+	buf, err := cFuture.Encode(testOpsList{opsList{&rof, nof}})
+	require.NoError(t, err)
+
+	var ops testOpsList
+	err = cCurrent.Decode(buf, &ops)
+	require.NoError(t, err)
+	require.Len(t, ops.Ops, 2)
+	require.IsType(t, &rmOp{}, ops.Ops[0])
+	uo, ok := ops.Ops[1].(*unknownOp)
+	require.True(t, ok)
+	require.Equal(t, uint64(newOpFutureCode), uo.raw.Tag)
+	require.Equal(t, nof.Refs(), uo.Refs())
+	require.Equal(t, nof.Unrefs(), uo.Unrefs())
+	require.Equal(t, nof.allUpdates(), uo.allUpdates())
+	require.NoError(t, uo.checkValid())
+
+	buf2, err := cCurrent.Encode(ops)
+	require.NoError(t, err)
+	require.Equal(t, buf, buf2)
+}
+
 type testOps struct {
 	Ops []interface{}
 }
@@ -0,0 +1,129 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+type platformHooksDarwin struct{}
+
+func newPlatformHooks() PlatformHooks {
+	return platformHooksDarwin{}
+}
+
+// lowPowerMode returns whether Low Power Mode is on, according to
+// `pmset -g`.  Older versions of macOS don't have it, and don't list
+// it.
+func (platformHooksDarwin) lowPowerMode(ctx context.Context) (
+	bool, error) {
+	out, err := exec.CommandContext(ctx, "/usr/bin/pmset", "-g").Output()
+	if err != nil {
+		return false, errors.Wrap(err, "pmset failed")
+	}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == "lowpowermode" {
+			return fields[1] == "1", nil
+		}
+	}
+	return false, nil
+}
+
+// defaultInterface returns the name of the network interface of the
+// default route, or "" if there isn't one.
+func (platformHooksDarwin) defaultInterface(ctx context.Context) (
+	string, error) {
+	out, err := exec.CommandContext(
+		ctx, "/sbin/route", "-n", "get", "default").Output()
+	if err != nil {
+		// route fails when there's no default route.
+		return "", nil
+	}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == "interface:" {
+			return fields[1], nil
+		}
+	}
+	return "", nil
+}
+
+// defaultHardwarePort returns the name of the hardware port, like
+// "Wi-Fi" or "iPhone USB", of the default route's interface, or ""
+// if there's no default route.
+func (h platformHooksDarwin) defaultHardwarePort(ctx context.Context) (
+	string, error) {
+	iface, err := h.defaultInterface(ctx)
+	if err != nil || iface == "" {
+		return "", err
+	}
+	out, err := exec.CommandContext(ctx, "/usr/sbin/networksetup",
+		"-listallhardwareports").Output()
+	if err != nil {
+		return "", errors.Wrap(err, "networksetup failed")
+	}
+	// The output is a list of stanzas like:
+	//   Hardware Port: iPhone USB
+	//   Device: en8
+	var port string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "Hardware Port: ") {
+			port = strings.TrimPrefix(line, "Hardware Port: ")
+		} else if line == "Device: "+iface {
+			return port, nil
+		}
+	}
+	return "", nil
+}
+
+// networkTypeForHardwarePort returns the type of network that goes
+// through the given hardware port.  macOS only exposes whether a
+// connection is expensive through the Network framework, so
+// tethering is detected from the hardware ports that tethering over
+// USB or Bluetooth creates.  Tethering over Wi-Fi looks like Wi-Fi.
+func networkTypeForHardwarePort(port string) NetworkType {
+	switch {
+	case strings.Contains(port, "iPhone"),
+		strings.Contains(port, "Bluetooth PAN"):
+		return NetworkTypeTethered
+	case strings.Contains(port, "Wi-Fi"), strings.Contains(port, "AirPort"):
+		return NetworkTypeWiFi
+	case strings.Contains(port, "Ethernet"), strings.Contains(port, "LAN"),
+		strings.Contains(port, "Thunderbolt"):
+		return NetworkTypeEthernet
+	}
+	return NetworkTypeUnknown
+}
+
+// State implements the PlatformHooks interface for
+// platformHooksDarwin.
+func (h platformHooksDarwin) State(ctx context.Context) (
+	PlatformState, error) {
+	lowPower, err := h.lowPowerMode(ctx)
+	if err != nil {
+		return PlatformState{}, err
+	}
+	port, err := h.defaultHardwarePort(ctx)
+	if err != nil {
+		return PlatformState{}, err
+	}
+	networkType := networkTypeForHardwarePort(port)
+	return PlatformState{
+		BatterySaver:   lowPower,
+		NetworkMetered: networkType == NetworkTypeTethered,
+		NetworkType:    networkType,
+	}, nil
+}
@@ -0,0 +1,13 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !windows,!darwin
+
+package libkbfs
+
+// newPlatformHooks returns nil, since there's no standard way to
+// detect battery saver or metered connections on other platforms.
+func newPlatformHooks() PlatformHooks {
+	return nil
+}
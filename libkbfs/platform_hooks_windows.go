@@ -0,0 +1,116 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os/exec"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/windows"
+)
+
+// systemPowerStatus mirrors SYSTEM_POWER_STATUS.
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// The cost and adapter type of the current connection are only
+// exposed through WinRT, so ask PowerShell for them.  The first line
+// of output is the cost, one of Unrestricted, Fixed, Variable or
+// Unknown; Fixed and Variable are metered.  The second is the IANA
+// interface type of the adapter.
+const windowsNetworkCostScript = `[Windows.Networking.Connectivity.NetworkInformation,Windows.Networking.Connectivity,ContentType=WindowsRuntime] | Out-Null; ` +
+	`$p = [Windows.Networking.Connectivity.NetworkInformation]::GetInternetConnectionProfile(); ` +
+	`if ($p) { $p.GetConnectionCost().NetworkCostType; $p.NetworkAdapter.IanaInterfaceType }`
+
+// IANA interface types, from
+// https://www.iana.org/assignments/ianaiftype-mib.
+const (
+	ianaIfTypeEthernet = "6"
+	ianaIfTypeWiFi     = "71"
+	ianaIfTypeWWANPP   = "243"
+	ianaIfTypeWWANPP2  = "244"
+)
+
+type platformHooksWindows struct{}
+
+func newPlatformHooks() PlatformHooks {
+	return platformHooksWindows{}
+}
+
+func (platformHooksWindows) batterySaver() (bool, error) {
+	var status systemPowerStatus
+	dll := windows.NewLazySystemDLL("kernel32.dll")
+	proc := dll.NewProc("GetSystemPowerStatus")
+	r1, _, err := proc.Call(uintptr(unsafe.Pointer(&status)))
+	// err is always non-nil, but meaningful only when r1 == 0
+	// (which signifies function failure).
+	if r1 == 0 {
+		return false, errors.WithStack(err)
+	}
+	// SystemStatusFlag is 1 when battery saver is on.
+	return status.SystemStatusFlag == 1, nil
+}
+
+func (platformHooksWindows) network(ctx context.Context) (
+	metered bool, networkType NetworkType, err error) {
+	out, err := exec.CommandContext(ctx, "powershell.exe",
+		"-NoProfile", "-NonInteractive", "-Command",
+		windowsNetworkCostScript).Output()
+	if err != nil {
+		return false, NetworkTypeUnknown,
+			errors.Wrap(err, "powershell failed")
+	}
+	lines := strings.Fields(string(out))
+	if len(lines) > 0 {
+		switch lines[0] {
+		case "Fixed", "Variable":
+			metered = true
+		}
+	}
+	networkType = NetworkTypeUnknown
+	if len(lines) > 1 {
+		switch lines[1] {
+		case ianaIfTypeEthernet:
+			networkType = NetworkTypeEthernet
+		case ianaIfTypeWiFi:
+			networkType = NetworkTypeWiFi
+		case ianaIfTypeWWANPP, ianaIfTypeWWANPP2:
+			networkType = NetworkTypeTethered
+		}
+	}
+	// A metered Wi-Fi network is most likely a phone's hotspot.
+	if metered && networkType == NetworkTypeWiFi {
+		networkType = NetworkTypeTethered
+	}
+	return metered, networkType, nil
+}
+
+// State implements the PlatformHooks interface for
+// platformHooksWindows.
+func (h platformHooksWindows) State(ctx context.Context) (
+	PlatformState, error) {
+	batterySaver, err := h.batterySaver()
+	if err != nil {
+		return PlatformState{}, err
+	}
+	metered, networkType, err := h.network(ctx)
+	if err != nil {
+		return PlatformState{}, err
+	}
+	return PlatformState{
+		BatterySaver:   batterySaver,
+		NetworkMetered: metered,
+		NetworkType:    networkType,
+	}, nil
+}
@@ -28,6 +28,12 @@ type prefetcherConfig interface {
 	blockCacher
 }
 
+// indirectPrefetchCounter is implemented by configs that let the
+// number of indirect pointers to prefetch change at runtime.
+type indirectPrefetchCounter interface {
+	indirectPrefetchCount() int
+}
+
 type prefetchRequest struct {
 	priority int
 	kmd      KeyMetadata
@@ -45,6 +51,9 @@ type blockPrefetcher struct {
 	log    logger.Logger
 	// blockRetriever to retrieve blocks from the server
 	retriever blockRetriever
+	// cachedGetter, if set, gets many blocks from the disk block
+	// cache at once, without going through the retriever.
+	cachedGetter cachedBlocksGetter
 	// channel to synchronize prefetch requests with the prefetcher shutdown
 	progressCh chan prefetchRequest
 	// channel that is idempotently closed when a shutdown occurs
@@ -69,6 +78,9 @@ func newBlockPrefetcher(retriever blockRetriever, config prefetcherConfig) *bloc
 	} else {
 		p.log = logger.NewNull()
 	}
+	if rc, ok := config.(blockRetrievalConfig); ok {
+		p.cachedGetter, _ = rc.blockGetter().(cachedBlocksGetter)
+	}
 	if retriever == nil {
 		// If we pass in a nil retriever, this prefetcher shouldn't do
 		// anything. Treat it as already shut down.
@@ -128,31 +140,91 @@ func (p *blockPrefetcher) request(priority int, kmd KeyMetadata, ptr BlockPointe
 	}
 }
 
+// requestBatch prefetches the given blocks.  Those in the disk block
+// cache are read from it all at once, and put straight into the block
+// cache; the rest are requested from the retriever.
+func (p *blockPrefetcher) requestBatch(kmd KeyMetadata,
+	reqs []prefetchRequest) {
+	if p.cachedGetter == nil || len(reqs) < 2 {
+		for _, req := range reqs {
+			_ = p.request(req.priority, kmd, req.ptr, req.block, "")
+		}
+		return
+	}
+
+	toGet := make([]prefetchRequest, 0, len(reqs))
+	for _, req := range reqs {
+		if _, err := p.config.BlockCache().Get(req.ptr); err == nil {
+			continue
+		}
+		if err := checkDataVersion(p.config, path{}, req.ptr); err != nil {
+			continue
+		}
+		toGet = append(toGet, req)
+	}
+	ptrs := make([]BlockPointer, 0, len(toGet))
+	blocks := make([]Block, 0, len(toGet))
+	for _, req := range toGet {
+		ptrs = append(ptrs, req.ptr)
+		blocks = append(blocks, req.block.NewEmpty())
+	}
+	found := p.cachedGetter.getCachedBlocks(context.TODO(), kmd, ptrs, blocks)
+	for i, req := range toGet {
+		if found[i] {
+			// Like any block retrieved for a prefetch, this one
+			// doesn't trigger prefetches of its own yet.
+			_ = p.config.BlockCache().PutWithPrefetch(req.ptr, kmd.TlfID(),
+				blocks[i], TransientEntry, false)
+			continue
+		}
+		_ = p.request(req.priority, kmd, req.ptr, req.block, "")
+	}
+}
+
+// indirectPrefetchCount returns how many of the pointers of an
+// indirect block to prefetch.
+func (p *blockPrefetcher) indirectPrefetchCount() int {
+	if c, ok := p.config.(indirectPrefetchCounter); ok {
+		return c.indirectPrefetchCount()
+	}
+	return defaultIndirectPointerPrefetchCount
+}
+
 func (p *blockPrefetcher) prefetchIndirectFileBlock(b *FileBlock, kmd KeyMetadata) {
 	// Prefetch the first <n> indirect block pointers.
 	// TODO: do something smart with subsequent blocks.
 	numIPtrs := len(b.IPtrs)
-	if numIPtrs > defaultIndirectPointerPrefetchCount {
-		numIPtrs = defaultIndirectPointerPrefetchCount
+	if n := p.indirectPrefetchCount(); numIPtrs > n {
+		numIPtrs = n
+	}
+	if numIPtrs == 0 {
+		return
 	}
 	p.log.CDebugf(context.TODO(), "Prefetching pointers for indirect file block. Num pointers to prefetch: %d", numIPtrs)
+	reqs := make([]prefetchRequest, 0, numIPtrs)
 	for _, ptr := range b.IPtrs[:numIPtrs] {
-		p.request(fileIndirectBlockPrefetchPriority, kmd,
-			ptr.BlockPointer, b.NewEmpty(), "")
+		reqs = append(reqs, prefetchRequest{fileIndirectBlockPrefetchPriority,
+			kmd, ptr.BlockPointer, b.NewEmpty()})
 	}
+	p.requestBatch(kmd, reqs)
 }
 
 func (p *blockPrefetcher) prefetchIndirectDirBlock(b *DirBlock, kmd KeyMetadata) {
 	// Prefetch the first <n> indirect block pointers.
 	numIPtrs := len(b.IPtrs)
-	if numIPtrs > defaultIndirectPointerPrefetchCount {
-		numIPtrs = defaultIndirectPointerPrefetchCount
+	if n := p.indirectPrefetchCount(); numIPtrs > n {
+		numIPtrs = n
+	}
+	if numIPtrs == 0 {
+		return
 	}
 	p.log.CDebugf(context.TODO(), "Prefetching pointers for indirect dir block. Num pointers to prefetch: %d", numIPtrs)
+	reqs := make([]prefetchRequest, 0, numIPtrs)
 	for _, ptr := range b.IPtrs[:numIPtrs] {
-		_ = p.request(fileIndirectBlockPrefetchPriority, kmd,
-			ptr.BlockPointer, b.NewEmpty(), "")
+		reqs = append(reqs, prefetchRequest{fileIndirectBlockPrefetchPriority,
+			kmd, ptr.BlockPointer, b.NewEmpty()})
 	}
+	p.requestBatch(kmd, reqs)
 }
 
 func (p *blockPrefetcher) prefetchDirectDirBlock(ptr BlockPointer, b *DirBlock, kmd KeyMetadata) {
@@ -160,6 +232,7 @@ func (p *blockPrefetcher) prefetchDirectDirBlock(ptr BlockPointer, b *DirBlock,
 	// Prefetch all DirEntry root blocks.
 	dirEntries := dirEntriesBySizeAsc{dirEntryMapToDirEntries(b.Children)}
 	sort.Sort(dirEntries)
+	reqs := make([]prefetchRequest, 0, len(dirEntries.dirEntries))
 	for i, entry := range dirEntries.dirEntries {
 		// Prioritize small files
 		priority := dirEntryPrefetchPriority - i
@@ -175,8 +248,10 @@ func (p *blockPrefetcher) prefetchDirectDirBlock(ptr BlockPointer, b *DirBlock,
 			p.log.CDebugf(context.TODO(), "Skipping prefetch for entry of unknown type %d", entry.Type)
 			continue
 		}
-		p.request(priority, kmd, entry.BlockPointer, block, entry.entryName)
+		reqs = append(reqs,
+			prefetchRequest{priority, kmd, entry.BlockPointer, block})
 	}
+	p.requestBatch(kmd, reqs)
 }
 
 // PrefetchBlock implements the Prefetcher interface for blockPrefetcher.
@@ -28,6 +28,11 @@ func makeRandomDirEntry(t *testing.T, typ EntryType, size uint64, path string) D
 			path,
 			101,
 			102,
+			"",
+			"",
+			0,
+			"",
+			0,
 		},
 		codec.UnknownFieldSetHandler{},
 	}
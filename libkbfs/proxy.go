@@ -0,0 +1,507 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ProxyType is the kind of proxy that connections to a server go
+// through.
+type ProxyType int
+
+const (
+	// ProxyTypeDefault means no proxy was given for the server, so
+	// the default one is used, if any; otherwise it connects
+	// directly.
+	ProxyTypeDefault ProxyType = iota
+	// ProxyTypeDirect connects directly, even if there's a default
+	// proxy.
+	ProxyTypeDirect
+	// ProxyTypeHTTP tunnels connections through an HTTP proxy,
+	// with CONNECT.
+	ProxyTypeHTTP
+	// ProxyTypeSOCKS5 tunnels connections through a SOCKS5 proxy.
+	ProxyTypeSOCKS5
+)
+
+func (t ProxyType) String() string {
+	switch t {
+	case ProxyTypeDefault:
+		return "default"
+	case ProxyTypeDirect:
+		return "direct"
+	case ProxyTypeHTTP:
+		return "http"
+	case ProxyTypeSOCKS5:
+		return "socks5"
+	}
+	return fmt.Sprintf("ProxyType(%d)", int(t))
+}
+
+// ProxySpec says how to connect to a server: directly, or through a
+// proxy, optionally with a username and password.
+type ProxySpec struct {
+	Type ProxyType
+	// Addr is the host:port of the proxy.
+	Addr     string
+	Username string
+	Password string
+}
+
+// String returns the spec in the form ParseProxySpec takes, with any
+// password redacted, so that it can be logged.
+func (p ProxySpec) String() string {
+	switch p.Type {
+	case ProxyTypeHTTP, ProxyTypeSOCKS5:
+	default:
+		return p.Type.String()
+	}
+	u := url.URL{Scheme: p.Type.String(), Host: p.Addr}
+	if p.Username != "" {
+		if p.Password != "" {
+			u.User = url.UserPassword(p.Username, "xxxxx")
+		} else {
+			u.User = url.User(p.Username)
+		}
+	}
+	return u.String()
+}
+
+// ParseProxySpec parses a proxy given as "direct",
+// "http://[user:password@]host:port" or
+// "socks5://[user:password@]host:port".  The empty string means the
+// default.
+func ParseProxySpec(s string) (ProxySpec, error) {
+	switch s {
+	case "", "default":
+		return ProxySpec{}, nil
+	case "direct":
+		return ProxySpec{Type: ProxyTypeDirect}, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ProxySpec{}, err
+	}
+	var spec ProxySpec
+	var defaultPort string
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		spec.Type = ProxyTypeHTTP
+		defaultPort = "80"
+	case "socks5":
+		spec.Type = ProxyTypeSOCKS5
+		defaultPort = "1080"
+	default:
+		return ProxySpec{}, errors.Errorf(
+			"Unknown proxy %q; must be direct, http://host:port or "+
+				"socks5://host:port", s)
+	}
+	if u.Hostname() == "" {
+		return ProxySpec{}, errors.Errorf("No proxy host in %q", s)
+	}
+	spec.Addr = u.Host
+	if u.Port() == "" {
+		spec.Addr = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	if u.User != nil {
+		spec.Username = u.User.Username()
+		spec.Password, _ = u.User.Password()
+	}
+	return spec, nil
+}
+
+// proxySpecFlag is for specifying a ProxySpec with the flag package.
+type proxySpecFlag struct {
+	p *ProxySpec
+}
+
+// String for flag interface.
+func (f proxySpecFlag) String() string {
+	if f.p == nil {
+		return ProxySpec{}.String()
+	}
+	return f.p.String()
+}
+
+// Set for flag interface.
+func (f proxySpecFlag) Set(raw string) error {
+	p, err := ParseProxySpec(raw)
+	if err != nil {
+		return err
+	}
+	*f.p = p
+	return nil
+}
+
+// ServerProxies is how to connect to each of the KBFS servers.
+type ServerProxies struct {
+	MDServer ProxySpec
+	BServer  ProxySpec
+}
+
+// MakeServerProxies returns the proxies to use for each server,
+// given a default proxy and the per-server ones, which override it
+// unless they're ProxyTypeDefault.
+func MakeServerProxies(
+	defaultProxy, mdServer, bServer ProxySpec) ServerProxies {
+	resolve := func(p ProxySpec) ProxySpec {
+		if p.Type == ProxyTypeDefault {
+			return defaultProxy
+		}
+		return p
+	}
+	return ServerProxies{
+		MDServer: resolve(mdServer),
+		BServer:  resolve(bServer),
+	}
+}
+
+// isDirect returns whether connections using this spec skip the
+// proxy.
+func (p ProxySpec) isDirect() bool {
+	return p.Type == ProxyTypeDefault || p.Type == ProxyTypeDirect
+}
+
+const proxyDialKeepAlive = 10 * time.Second
+
+// dial connects to the given address through the proxy.  The
+// returned connection hasn't done anything beyond setting up the
+// tunnel.
+func (p ProxySpec) dial(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{KeepAlive: proxyDialKeepAlive}
+	if p.isDirect() {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't connect to proxy %s", p)
+	}
+	// Don't let a stuck proxy hang the handshake past the
+	// context.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	tunnel := conn
+	switch p.Type {
+	case ProxyTypeHTTP:
+		tunnel, err = p.connectHTTP(conn, addr)
+	case ProxyTypeSOCKS5:
+		err = p.connectSOCKS5(conn, addr)
+	default:
+		err = errors.Errorf("Unknown proxy type %s", p.Type)
+	}
+	close(done)
+	<-watcherDone
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrapf(err, "couldn't connect to %s via %s", addr, p)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// bufferedConn is a net.Conn whose first reads come out of a buffer
+// that may already hold some of what the server sent.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (p ProxySpec) connectHTTP(conn net.Conn, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if p.Username != "" {
+		creds := base64.StdEncoding.EncodeToString(
+			[]byte(p.Username + ":" + p.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	err := req.Write(conn)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("proxy said %s", resp.Status)
+	}
+	if r.Buffered() > 0 {
+		return bufferedConn{conn, r}, nil
+	}
+	return conn, nil
+}
+
+// SOCKS5 constants, from RFC 1928 and RFC 1929.
+const (
+	socks5Version          = 5
+	socks5AuthNone         = 0
+	socks5AuthPassword     = 2
+	socks5AuthNoAcceptable = 0xff
+	socks5PasswordVersion  = 1
+	socks5CmdConnect       = 1
+	socks5AddrIPv4         = 1
+	socks5AddrDomain       = 3
+	socks5AddrIPv6         = 4
+)
+
+var socks5ReplyMessages = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+func (p ProxySpec) connectSOCKS5(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return errors.Errorf("Bad port in %s", addr)
+	}
+	if len(host) > 255 {
+		return errors.Errorf("Host name %s is too long", host)
+	}
+
+	methods := []byte{socks5AuthNone}
+	if p.Username != "" {
+		methods = append(methods, socks5AuthPassword)
+	}
+	_, err = conn.Write(append(
+		[]byte{socks5Version, byte(len(methods))}, methods...))
+	if err != nil {
+		return err
+	}
+	var buf [2]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return errors.Errorf("proxy speaks SOCKS version %d", buf[0])
+	}
+	switch buf[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if p.Username == "" {
+			return errors.New("proxy wants a username and password")
+		}
+		if len(p.Username) > 255 || len(p.Password) > 255 {
+			return errors.New("proxy username or password is too long")
+		}
+		req := []byte{socks5PasswordVersion, byte(len(p.Username))}
+		req = append(req, p.Username...)
+		req = append(req, byte(len(p.Password)))
+		req = append(req, p.Password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf[:]); err != nil {
+			return err
+		}
+		if buf[1] != 0 {
+			return errors.New("proxy rejected the username and password")
+		}
+	case socks5AuthNoAcceptable:
+		return errors.New("proxy accepted none of our authentication methods")
+	default:
+		return errors.Errorf("proxy chose unknown authentication %d", buf[1])
+	}
+
+	// Leave name resolution to the proxy, since the client might
+	// not be able to resolve outside names itself.
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5AddrIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5AddrIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		msg, ok := socks5ReplyMessages[reply[1]]
+		if !ok {
+			msg = fmt.Sprintf("unknown error %d", reply[1])
+		}
+		return errors.Errorf("proxy couldn't connect: %s", msg)
+	}
+	// Skip over the address the proxy bound.
+	var addrLen int
+	switch reply[3] {
+	case socks5AddrIPv4:
+		addrLen = net.IPv4len
+	case socks5AddrIPv6:
+		addrLen = net.IPv6len
+	case socks5AddrDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		addrLen = int(buf[0])
+	default:
+		return errors.Errorf("proxy sent unknown address type %d", reply[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
+
+// proxyConnectionTransport is an rpc.ConnectionTransport that makes
+// TLS connections to a server through a proxy.
+type proxyConnectionTransport struct {
+	srvAddr    string
+	rootCerts  []byte
+	proxy      ProxySpec
+	logFactory rpc.LogFactory
+	wef        rpc.WrapErrorFunc
+
+	// Protects everything below.
+	lock            sync.Mutex
+	transport       rpc.Transporter
+	stagedTransport rpc.Transporter
+	conn            net.Conn
+}
+
+var _ rpc.ConnectionTransport = (*proxyConnectionTransport)(nil)
+
+// Dial implements the rpc.ConnectionTransport interface for
+// proxyConnectionTransport.
+func (ct *proxyConnectionTransport) Dial(ctx context.Context) (
+	rpc.Transporter, error) {
+	certs := x509.NewCertPool()
+	if !certs.AppendCertsFromPEM(ct.rootCerts) {
+		return nil, errors.New("Unable to load root certificates")
+	}
+	host, _, err := net.SplitHostPort(ct.srvAddr)
+	if err != nil {
+		return nil, err
+	}
+	rawConn, err := ct.proxy.dial(ctx, ct.srvAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(rawConn, &tls.Config{RootCAs: certs, ServerName: host})
+	err = conn.HandshakeContext(ctx)
+	if err != nil {
+		_ = rawConn.Close()
+		return nil, err
+	}
+
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	transport := rpc.NewTransport(conn, ct.logFactory, ct.wef)
+	ct.conn = conn
+	ct.stagedTransport = transport
+	return transport, nil
+}
+
+// IsConnected implements the rpc.ConnectionTransport interface for
+// proxyConnectionTransport.
+func (ct *proxyConnectionTransport) IsConnected() bool {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	return ct.transport != nil && ct.transport.IsConnected()
+}
+
+// Finalize implements the rpc.ConnectionTransport interface for
+// proxyConnectionTransport.
+func (ct *proxyConnectionTransport) Finalize() {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	ct.transport = ct.stagedTransport
+	ct.stagedTransport = nil
+}
+
+// Close implements the rpc.ConnectionTransport interface for
+// proxyConnectionTransport.
+func (ct *proxyConnectionTransport) Close() {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	if ct.conn != nil {
+		ct.conn.Close()
+	}
+}
+
+// newTLSConnectionViaProxy returns an RPC connection to the given
+// server address with TLS, through the given proxy if it isn't
+// direct.
+func newTLSConnectionViaProxy(srvAddr string, proxy ProxySpec,
+	rootCerts []byte, errorUnwrapper rpc.ErrorUnwrapper,
+	handler rpc.ConnectionHandler, logFactory rpc.LogFactory,
+	logOutput rpc.LogOutput, opts rpc.ConnectionOpts) *rpc.Connection {
+	if proxy.isDirect() {
+		return rpc.NewTLSConnection(srvAddr, rootCerts, errorUnwrapper,
+			handler, logFactory, logOutput, opts)
+	}
+	transport := &proxyConnectionTransport{
+		srvAddr:    srvAddr,
+		rootCerts:  rootCerts,
+		proxy:      proxy,
+		logFactory: logFactory,
+		wef:        opts.WrapErrorFunc,
+	}
+	return rpc.NewConnectionWithTransport(
+		handler, transport, errorUnwrapper, logOutput, opts)
+}
@@ -77,6 +77,15 @@ func (q *EventuallyConsistentQuotaUsage) getAndCache(
 	return usage, nil
 }
 
+// clear forgets the cached usage, so that the next Get blocks on a
+// fresh RPC; e.g., because the cached usage was for a different
+// user.
+func (q *EventuallyConsistentQuotaUsage) clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.cached = cachedQuotaUsage{}
+}
+
 // Get returns KBFS bytes used and limit for user. To help avoid having too
 // frequent calls into bserver, caller can provide a positive tolerance, to
 // accept stale LimitBytes and UsageBytes data. If tolerance is 0 or negative,
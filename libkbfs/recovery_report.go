@@ -0,0 +1,294 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const (
+	// runningMarkerFilename is the name of the file that exists in
+	// the storage root for as long as KBFS is running.
+	runningMarkerFilename = "kbfs_running.json"
+	// recoveryReportFilename is the name of the file in the
+	// storage root that holds the report about the last unclean
+	// shutdown.
+	recoveryReportFilename = "kbfs_recovery_report.json"
+)
+
+// runningMarker is the content of the running marker file.
+type runningMarker struct {
+	PID   int
+	Start time.Time
+	// DirtyTlfs are the TLFs that have writes that haven't been
+	// synced yet, and would be lost if the process died.
+	DirtyTlfs []tlf.ID
+}
+
+// TlfRecoveryReport describes what happened to a single TLF's local
+// changes because of an unclean shutdown.
+type TlfRecoveryReport struct {
+	TlfID tlf.ID
+	// RecoveredRevisions is the number of MD revisions that were
+	// still in the TLF's journal at startup.  They survived, and
+	// will be flushed as usual.
+	RecoveredRevisions int64
+	UnflushedBytes     int64
+	// DirtyWritesLost is true if the TLF had writes that were
+	// never synced, and so were only in memory and are gone.
+	DirtyWritesLost bool
+	// ConflictLikely is true if the recovered revisions are on a
+	// conflict branch, so conflict resolution will have to run
+	// before they show up for other devices.
+	ConflictLikely bool
+}
+
+// RecoveryReport describes the last unclean shutdown of KBFS, as
+// found on the following startup.
+type RecoveryReport struct {
+	// PreviousPID and PreviousStart identify the process that
+	// didn't shut down cleanly.
+	PreviousPID   int
+	PreviousStart time.Time
+	DetectedAt    time.Time
+	Tlfs          []TlfRecoveryReport `json:",omitempty"`
+}
+
+func runningMarkerPath(storageRoot string) string {
+	return filepath.Join(storageRoot, runningMarkerFilename)
+}
+
+func recoveryReportPath(storageRoot string) string {
+	return filepath.Join(storageRoot, recoveryReportFilename)
+}
+
+// ReadRecoveryReport returns the report about the last unclean
+// shutdown of KBFS using the given storage root, or nil if KBFS shut
+// down cleanly the last time it ran.
+func ReadRecoveryReport(storageRoot string) (*RecoveryReport, error) {
+	var report RecoveryReport
+	err := ioutil.DeserializeFromJSONFile(
+		recoveryReportPath(storageRoot), &report)
+	if ioutil.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// recoveryTracker keeps the running marker up to date while KBFS
+// runs, so that the next startup can tell whether this one shut down
+// cleanly and, if not, which TLFs lost unsynced writes.
+type recoveryTracker struct {
+	log         logger.Logger
+	storageRoot string
+
+	lock   sync.Mutex
+	marker runningMarker
+	dirty  map[tlf.ID]bool
+	report *RecoveryReport
+}
+
+func newRecoveryTracker(
+	log logger.Logger, storageRoot string) *recoveryTracker {
+	return &recoveryTracker{
+		log:         log,
+		storageRoot: storageRoot,
+		dirty:       make(map[tlf.ID]bool),
+	}
+}
+
+// makeRecoveryReport builds the report for an unclean shutdown of
+// the process described by prev, from the state of the journals that
+// were found on startup.
+func makeRecoveryReport(ctx context.Context, log logger.Logger,
+	prev runningMarker, jServer *JournalServer,
+	now time.Time) *RecoveryReport {
+	tlfReports := make(map[tlf.ID]*TlfRecoveryReport)
+	getTlfReport := func(tlfID tlf.ID) *TlfRecoveryReport {
+		r, ok := tlfReports[tlfID]
+		if !ok {
+			r = &TlfRecoveryReport{TlfID: tlfID}
+			tlfReports[tlfID] = r
+		}
+		return r
+	}
+	for _, tlfID := range prev.DirtyTlfs {
+		getTlfReport(tlfID).DirtyWritesLost = true
+	}
+	if jServer != nil {
+		_, tlfIDs := jServer.Status(ctx)
+		for _, tlfID := range tlfIDs {
+			status, err := jServer.JournalStatus(tlfID)
+			if err != nil {
+				log.CDebugf(ctx, "Couldn't get journal status for %s: %+v",
+					tlfID, err)
+				continue
+			}
+			if status.RevisionEnd == MetadataRevisionUninitialized {
+				continue
+			}
+			r := getTlfReport(tlfID)
+			r.RecoveredRevisions = int64(
+				status.RevisionEnd - status.RevisionStart + 1)
+			r.UnflushedBytes = status.UnflushedBytes
+			r.ConflictLikely = status.BranchID != NullBranchID.String()
+		}
+	}
+
+	report := &RecoveryReport{
+		PreviousPID:   prev.PID,
+		PreviousStart: prev.Start,
+		DetectedAt:    now,
+	}
+	for _, r := range tlfReports {
+		report.Tlfs = append(report.Tlfs, *r)
+	}
+	sort.Sort(tlfRecoveryReportList(report.Tlfs))
+	return report
+}
+
+type tlfRecoveryReportList []TlfRecoveryReport
+
+func (l tlfRecoveryReportList) Len() int {
+	return len(l)
+}
+
+func (l tlfRecoveryReportList) Less(i, j int) bool {
+	return l[i].TlfID.String() < l[j].TlfID.String()
+}
+
+func (l tlfRecoveryReportList) Swap(i, j int) {
+	l[j], l[i] = l[i], l[j]
+}
+
+// start checks whether the previous KBFS process shut down cleanly,
+// writes a recovery report if it didn't, and marks this process as
+// running.  jServer, which may be nil, must have its existing
+// journals enabled already.
+func (rt *recoveryTracker) start(ctx context.Context,
+	jServer *JournalServer, now time.Time) error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	var prev runningMarker
+	err := ioutil.DeserializeFromJSONFile(
+		runningMarkerPath(rt.storageRoot), &prev)
+	switch {
+	case ioutil.IsNotExist(err):
+		// The last shutdown was clean, so the old report (if
+		// any) is stale.
+		err := ioutil.Remove(recoveryReportPath(rt.storageRoot))
+		if err != nil && !ioutil.IsNotExist(err) {
+			return err
+		}
+	default:
+		if err != nil {
+			// The marker was probably cut short by the crash.
+			rt.log.CDebugf(ctx, "Couldn't read the running marker: %+v",
+				err)
+			prev = runningMarker{}
+		}
+		rt.report = makeRecoveryReport(ctx, rt.log, prev, jServer, now)
+		rt.log.CWarningf(ctx, "KBFS (pid %d) didn't shut down cleanly; "+
+			"%d folders affected", prev.PID, len(rt.report.Tlfs))
+		err := ioutil.SerializeToJSONFile(
+			rt.report, recoveryReportPath(rt.storageRoot))
+		if err != nil {
+			return err
+		}
+	}
+
+	rt.marker = runningMarker{PID: os.Getpid(), Start: now}
+	return rt.writeMarkerLocked()
+}
+
+func (rt *recoveryTracker) writeMarkerLocked() error {
+	return ioutil.SerializeToJSONFile(
+		rt.marker, runningMarkerPath(rt.storageRoot))
+}
+
+// setTlfDirty records whether the given TLF has unsynced writes.
+func (rt *recoveryTracker) setTlfDirty(tlfID tlf.ID, dirty bool) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	if rt.dirty[tlfID] == dirty {
+		return
+	}
+	if dirty {
+		rt.dirty[tlfID] = true
+	} else {
+		delete(rt.dirty, tlfID)
+	}
+	rt.marker.DirtyTlfs = make([]tlf.ID, 0, len(rt.dirty))
+	for id := range rt.dirty {
+		rt.marker.DirtyTlfs = append(rt.marker.DirtyTlfs, id)
+	}
+	if err := rt.writeMarkerLocked(); err != nil {
+		rt.log.Warning("Couldn't update the running marker: %+v", err)
+	}
+}
+
+func (rt *recoveryTracker) getReport() *RecoveryReport {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	if rt.report == nil {
+		return nil
+	}
+	report := *rt.report
+	return &report
+}
+
+// shutdown marks this process as shut down cleanly, unless there
+// are still unsynced writes that are about to be lost.
+func (rt *recoveryTracker) shutdown(ctx context.Context) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	if len(rt.dirty) > 0 {
+		rt.log.CWarningf(ctx, "Shutting down with unsynced writes in "+
+			"%d folders", len(rt.dirty))
+		return
+	}
+	err := ioutil.Remove(runningMarkerPath(rt.storageRoot))
+	if err != nil && !ioutil.IsNotExist(err) {
+		rt.log.CWarningf(ctx, "Couldn't remove the running marker: %+v",
+			err)
+	}
+}
+
+func (fs *KBFSOpsStandard) setRecoveryTracker(rt *recoveryTracker) {
+	fs.recoveryLock.Lock()
+	defer fs.recoveryLock.Unlock()
+	fs.recovery = rt
+}
+
+func (fs *KBFSOpsStandard) getRecoveryTracker() *recoveryTracker {
+	fs.recoveryLock.Lock()
+	defer fs.recoveryLock.Unlock()
+	return fs.recovery
+}
+
+func (fs *KBFSOpsStandard) setTlfDirty(tlfID tlf.ID, dirty bool) {
+	if rt := fs.getRecoveryTracker(); rt != nil {
+		rt.setTlfDirty(tlfID, dirty)
+	}
+}
+
+func (fs *KBFSOpsStandard) getRecoveryReport() *RecoveryReport {
+	if rt := fs.getRecoveryTracker(); rt != nil {
+		return rt.getReport()
+	}
+	return nil
+}
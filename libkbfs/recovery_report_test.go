@@ -0,0 +1,86 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestRecoveryTrackerUncleanShutdown(t *testing.T) {
+	storageRoot, err := ioutil.TempDir(os.TempDir(), "recovery_tracker")
+	require.NoError(t, err)
+	defer os.RemoveAll(storageRoot)
+	log := logger.NewTestLogger(t)
+	ctx := context.Background()
+	start := time.Unix(1, 0)
+
+	rt := newRecoveryTracker(log, storageRoot)
+	require.NoError(t, rt.start(ctx, nil, start))
+	require.Nil(t, rt.getReport())
+
+	tlfID1 := tlf.FakeID(1, false)
+	tlfID2 := tlf.FakeID(2, false)
+	rt.setTlfDirty(tlfID1, true)
+	rt.setTlfDirty(tlfID2, true)
+	rt.setTlfDirty(tlfID2, false)
+
+	// Simulate a crash by starting again without shutting down.
+	rt2 := newRecoveryTracker(log, storageRoot)
+	detected := time.Unix(2, 0)
+	require.NoError(t, rt2.start(ctx, nil, detected))
+	report := rt2.getReport()
+	require.NotNil(t, report)
+	require.Equal(t, os.Getpid(), report.PreviousPID)
+	require.True(t, start.Equal(report.PreviousStart))
+	require.True(t, detected.Equal(report.DetectedAt))
+	require.Equal(t, []TlfRecoveryReport{
+		{TlfID: tlfID1, DirtyWritesLost: true},
+	}, report.Tlfs)
+
+	readReport, err := ReadRecoveryReport(storageRoot)
+	require.NoError(t, err)
+	require.NotNil(t, readReport)
+	require.Equal(t, report.Tlfs, readReport.Tlfs)
+
+	// A clean shutdown clears the report on the next start.
+	rt2.shutdown(ctx)
+	rt3 := newRecoveryTracker(log, storageRoot)
+	require.NoError(t, rt3.start(ctx, nil, time.Unix(3, 0)))
+	require.Nil(t, rt3.getReport())
+	readReport, err = ReadRecoveryReport(storageRoot)
+	require.NoError(t, err)
+	require.Nil(t, readReport)
+}
+
+func TestRecoveryTrackerShutdownWhileDirty(t *testing.T) {
+	storageRoot, err := ioutil.TempDir(os.TempDir(), "recovery_tracker")
+	require.NoError(t, err)
+	defer os.RemoveAll(storageRoot)
+	log := logger.NewTestLogger(t)
+	ctx := context.Background()
+
+	rt := newRecoveryTracker(log, storageRoot)
+	require.NoError(t, rt.start(ctx, nil, time.Unix(1, 0)))
+	tlfID := tlf.FakeID(1, false)
+	rt.setTlfDirty(tlfID, true)
+
+	// Shutting down with unsynced writes still counts as unclean.
+	rt.shutdown(ctx)
+	rt2 := newRecoveryTracker(log, storageRoot)
+	require.NoError(t, rt2.start(ctx, nil, time.Unix(2, 0)))
+	report := rt2.getReport()
+	require.NotNil(t, report)
+	require.Equal(t, []TlfRecoveryReport{
+		{TlfID: tlfID, DirtyWritesLost: true},
+	}, report.Tlfs)
+}
@@ -92,6 +92,9 @@ type rekeyTask struct {
 	timeout     *time.Duration
 	ttl         int
 	promptPaper bool
+	// attempts is the number of rekeys of this task that have
+	// failed in a row.
+	attempts int
 
 	ctx *protectedContext
 }
@@ -237,7 +240,7 @@ func (r *rekeyStateIdle) reactToEvent(event RekeyEvent) rekeyState {
 type rekeyStateScheduled struct {
 	fsm *rekeyFSM
 
-	timer    *time.Timer
+	timer    Timer
 	deadline time.Time
 
 	task rekeyTask
@@ -246,12 +249,13 @@ type rekeyStateScheduled struct {
 func newRekeyStateScheduled(
 	fsm *rekeyFSM, delay time.Duration, task rekeyTask) *rekeyStateScheduled {
 	task.ctx.setLogger(fsm.log)
+	clock := fsm.fbo.config.Clock()
 	return &rekeyStateScheduled{
 		fsm: fsm,
-		timer: time.AfterFunc(delay, func() {
+		timer: clock.AfterFunc(delay, func() {
 			fsm.Event(newRekeyTimeupEvent())
 		}),
-		deadline: time.Now().Add(delay),
+		deadline: clock.Now().Add(delay),
 		task:     task,
 	}
 }
@@ -268,7 +272,8 @@ func (r *rekeyStateScheduled) reactToEvent(event RekeyEvent) rekeyState {
 		}
 		task.ttl = event.request.ttl
 		task.ctx.maybeReplaceContext(event.request.ctx.context())
-		if !r.deadline.After(time.Now().Add(event.request.delay)) {
+		if !r.deadline.After(
+			r.fsm.fbo.config.Clock().Now().Add(event.request.delay)) {
 			r.fsm.log.CDebugf(task.ctx.context(), "Reusing existing timer")
 			r.task = task
 			return r
@@ -276,7 +281,7 @@ func (r *rekeyStateScheduled) reactToEvent(event RekeyEvent) rekeyState {
 		r.timer.Stop()
 		return newRekeyStateScheduled(r.fsm, event.request.delay, task)
 	case rekeyKickoffEventForTest:
-		r.timer.Reset(time.Millisecond)
+		r.timer.Reset(0)
 		return r
 	case rekeyCancelEventForTest:
 		r.timer.Stop()
@@ -290,11 +295,18 @@ func (r *rekeyStateScheduled) reactToEvent(event RekeyEvent) rekeyState {
 }
 
 type rekeyStateStarted struct {
-	fsm  *rekeyFSM
-	task rekeyTask
+	fsm     *rekeyFSM
+	task    rekeyTask
+	started time.Time
 }
 
 func newRekeyStateStarted(fsm *rekeyFSM, task rekeyTask) *rekeyStateStarted {
+	started := fsm.fbo.config.Clock().Now()
+	fsm.recordStatus(RekeyStatus{
+		State:       RekeyStateInProgress,
+		Attempts:    task.attempts,
+		LastAttempt: started,
+	})
 	ctx := task.ctx.context()
 	var cancel context.CancelFunc
 	if task.timeout != nil {
@@ -315,9 +327,23 @@ func newRekeyStateStarted(fsm *rekeyFSM, task rekeyTask) *rekeyStateStarted {
 		fsm.Event(newRekeyFinishedEvent(res, err))
 	}()
 	return &rekeyStateStarted{
-		fsm:  fsm,
-		task: task,
+		fsm:     fsm,
+		task:    task,
+		started: started,
+	}
+}
+
+// rekeyRetryDelay returns how long to wait before retrying a rekey
+// that has failed the given number of times in a row.
+func rekeyRetryDelay(attempts int) time.Duration {
+	d := rekeyRecheckInterval
+	for i := 1; i < attempts && d < rekeyMaxRetryInterval; i++ {
+		d *= 2
 	}
+	if d > rekeyMaxRetryInterval {
+		d = rekeyMaxRetryInterval
+	}
+	return d
 }
 
 func (r *rekeyStateStarted) reactToEvent(event RekeyEvent) rekeyState {
@@ -327,21 +353,46 @@ func (r *rekeyStateStarted) reactToEvent(event RekeyEvent) rekeyState {
 		r.fsm.log.CDebugf(r.task.ctx.context(),
 			"Rekey finished, ttl: %d -> %d", r.task.ttl, ttl)
 
+		status := RekeyStatus{
+			Attempts:    r.task.attempts,
+			LastAttempt: r.started,
+		}
+		if err := event.finished.err; err != nil {
+			status.Attempts++
+			status.LastError = err.Error()
+		}
+
 		if ttl <= 0 {
 			r.fsm.log.CDebugf(r.task.ctx.context(),
 				"Not scheduling new rekey because TTL expired")
+			switch {
+			case event.finished.err != nil:
+				status.State = RekeyStateFailed
+				r.fsm.recordStatus(status)
+			case event.finished.NeedsPaperKey:
+				status.State = RekeyStateNeedsPaperKey
+				r.fsm.recordStatus(status)
+			default:
+				r.fsm.clearStatus()
+			}
 			return newRekeyStateIdle(r.fsm)
 		}
 
+		now := r.fsm.fbo.config.Clock().Now()
 		switch event.finished.err {
 		case nil:
 		default:
+			d := rekeyRetryDelay(status.Attempts)
 			r.fsm.log.CDebugf(r.task.ctx.context(),
-				"Rekey errored; scheduling new rekey in %s", rekeyRecheckInterval)
-			return newRekeyStateScheduled(r.fsm, rekeyRecheckInterval, rekeyTask{
+				"Rekey errored; scheduling new rekey in %s", d)
+			status.State = RekeyStateRetrying
+			status.NextAttempt = now.Add(d)
+			r.fsm.recordStatus(status)
+			return newRekeyStateScheduled(r.fsm, d, rekeyTask{
 				timeout:     r.task.timeout,
 				promptPaper: r.task.promptPaper,
 				ttl:         ttl,
+				attempts:    status.Attempts,
 				ctx:         r.task.ctx,
 			})
 		}
@@ -350,6 +401,9 @@ func (r *rekeyStateStarted) reactToEvent(event RekeyEvent) rekeyState {
 		if event.finished.NeedsPaperKey {
 			r.fsm.log.CDebugf(r.task.ctx.context(),
 				"Scheduling rekey due to NeedsPaperKey==true")
+			status.State = RekeyStateNeedsPaperKey
+			status.NextAttempt = now.Add(d)
+			r.fsm.recordStatus(status)
 			return newRekeyStateScheduled(r.fsm, d, rekeyTask{
 				timeout:     &d,
 				promptPaper: true,
@@ -369,6 +423,7 @@ func (r *rekeyStateStarted) reactToEvent(event RekeyEvent) rekeyState {
 			// scan.
 			r.fsm.log.CDebugf(r.task.ctx.context(),
 				"Scheduling rekey (recheck) due to DidRekey==true")
+			r.fsm.clearStatus()
 			return newRekeyStateScheduled(r.fsm, rekeyRecheckInterval, rekeyTask{
 				timeout:     nil,
 				promptPaper: false,
@@ -379,6 +434,7 @@ func (r *rekeyStateStarted) reactToEvent(event RekeyEvent) rekeyState {
 
 		r.fsm.log.CDebugf(r.task.ctx.context(),
 			"Not scheduling rekey because no more rekeys or rechecks are needed")
+		r.fsm.clearStatus()
 		return newRekeyStateIdle(r.fsm)
 	default:
 		return r
@@ -456,6 +512,23 @@ func (m *rekeyFSM) Event(event RekeyEvent) {
 	}()
 }
 
+// recordStatus reports the status of this FSM's folder to the rekey
+// queue, if the queue keeps track of it.
+func (m *rekeyFSM) recordStatus(status RekeyStatus) {
+	if r, ok := m.fbo.config.RekeyQueue().(rekeyStatusRecorder); ok {
+		status.TlfID = m.fbo.id()
+		r.recordRekeyStatus(status)
+	}
+}
+
+// clearStatus reports to the rekey queue that this FSM's folder is
+// fully rekeyed.
+func (m *rekeyFSM) clearStatus() {
+	if r, ok := m.fbo.config.RekeyQueue().(rekeyStatusRecorder); ok {
+		r.clearRekeyStatus(m.fbo.id())
+	}
+}
+
 // Shutdown implements RekeyFSM interface for rekeyFSM.
 func (m *rekeyFSM) Shutdown() {
 	m.Event(newRekeyShutdownEvent())
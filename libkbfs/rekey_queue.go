@@ -5,7 +5,9 @@
 package libkbfs
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/kbfs/tlf"
@@ -50,11 +52,25 @@ type RekeyQueueStandard struct {
 
 	mu       sync.RWMutex // guards everything below
 	pendings map[tlf.ID]bool
+	// statuses holds the folders that aren't fully rekeyed yet.
+	statuses map[tlf.ID]RekeyStatus
 }
 
 // Test that RekeyQueueStandard fully implements the RekeyQueue interface.
 var _ RekeyQueue = (*RekeyQueueStandard)(nil)
 
+// rekeyStatusRecorder is implemented by rekey queues that keep track
+// of how the rekeys of each folder are going.  The rekey FSMs report
+// to it, whether or not the rekeys went through the queue.
+type rekeyStatusRecorder interface {
+	// recordRekeyStatus records the latest status of a folder.
+	recordRekeyStatus(status RekeyStatus)
+	// clearRekeyStatus records that a folder is fully rekeyed.
+	clearRekeyStatus(id tlf.ID)
+}
+
+var _ rekeyStatusRecorder = (*RekeyQueueStandard)(nil)
+
 // NewRekeyQueueStandard creates a new rekey queue.
 func NewRekeyQueueStandard(config Config) (rkq *RekeyQueueStandard) {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -64,6 +80,7 @@ func NewRekeyQueueStandard(config Config) (rkq *RekeyQueueStandard) {
 		queue:    make(chan tlf.ID, rekeyQueueSize),
 		limiter:  rate.NewLimiter(rekeysPerSecond, numConcurrentRekeys),
 		pendings: make(map[tlf.ID]bool),
+		statuses: make(map[tlf.ID]RekeyStatus),
 		cancel:   cancel,
 	}
 	rkq.start(ctx)
@@ -94,6 +111,15 @@ func (rkq *RekeyQueueStandard) Enqueue(id tlf.ID) {
 	rkq.mu.Lock()
 	defer rkq.mu.Unlock()
 	rkq.pendings[id] = true
+	// Keep the failure count of a retrying rekey, but not its
+	// schedule, since the FSM will now start it right away.
+	status := rkq.statuses[id]
+	if status.State != RekeyStateInProgress {
+		status.TlfID = id
+		status.State = RekeyStatePending
+		status.NextAttempt = time.Time{}
+		rkq.statuses[id] = status
+	}
 
 	select {
 	case rkq.queue <- id:
@@ -111,6 +137,44 @@ func (rkq *RekeyQueueStandard) IsRekeyPending(id tlf.ID) bool {
 	return rkq.pendings[id]
 }
 
+type rekeyStatusesByTlfID []RekeyStatus
+
+func (r rekeyStatusesByTlfID) Len() int {
+	return len(r)
+}
+
+func (r rekeyStatusesByTlfID) Less(i, j int) bool {
+	return r[i].TlfID.String() < r[j].TlfID.String()
+}
+
+func (r rekeyStatusesByTlfID) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+// GetStatus implements the RekeyQueue interface for RekeyQueueStandard.
+func (rkq *RekeyQueueStandard) GetStatus() []RekeyStatus {
+	rkq.mu.RLock()
+	defer rkq.mu.RUnlock()
+	statuses := make(rekeyStatusesByTlfID, 0, len(rkq.statuses))
+	for _, status := range rkq.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Sort(statuses)
+	return statuses
+}
+
+func (rkq *RekeyQueueStandard) recordRekeyStatus(status RekeyStatus) {
+	rkq.mu.Lock()
+	defer rkq.mu.Unlock()
+	rkq.statuses[status.TlfID] = status
+}
+
+func (rkq *RekeyQueueStandard) clearRekeyStatus(id tlf.ID) {
+	rkq.mu.Lock()
+	defer rkq.mu.Unlock()
+	delete(rkq.statuses, id)
+}
+
 // Shutdown implements the RekeyQueue interface for RekeyQueueStandard.
 func (rkq *RekeyQueueStandard) Shutdown() {
 	rkq.mu.Lock()
@@ -7,8 +7,11 @@ package libkbfs
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
 
@@ -104,3 +107,47 @@ func TestRekeyQueueBasic(t *testing.T) {
 		_ = GetRootNodeOrBust(ctx, t, config2Dev2, name, false)
 	}
 }
+
+func TestRekeyQueueStatus(t *testing.T) {
+	rkq := &RekeyQueueStandard{
+		queue:    make(chan tlf.ID, 3),
+		pendings: make(map[tlf.ID]bool),
+		statuses: make(map[tlf.ID]RekeyStatus),
+	}
+	id1 := tlf.FakeID(1, false)
+	id2 := tlf.FakeID(2, false)
+	rkq.Enqueue(id2)
+	rkq.Enqueue(id1)
+	require.Equal(t, []RekeyStatus{
+		{TlfID: id1, State: RekeyStatePending},
+		{TlfID: id2, State: RekeyStatePending},
+	}, rkq.GetStatus())
+
+	now := time.Now()
+	rkq.recordRekeyStatus(RekeyStatus{
+		TlfID:       id1,
+		State:       RekeyStateRetrying,
+		Attempts:    2,
+		LastError:   "oops",
+		LastAttempt: now,
+		NextAttempt: now.Add(time.Minute),
+	})
+	rkq.clearRekeyStatus(id2)
+	// Enqueuing a retrying folder again keeps its failure count.
+	rkq.Enqueue(id1)
+	require.Equal(t, []RekeyStatus{{
+		TlfID:       id1,
+		State:       RekeyStatePending,
+		Attempts:    2,
+		LastError:   "oops",
+		LastAttempt: now,
+	}}, rkq.GetStatus())
+}
+
+func TestRekeyRetryDelay(t *testing.T) {
+	require.Equal(t, rekeyRecheckInterval, rekeyRetryDelay(0))
+	require.Equal(t, rekeyRecheckInterval, rekeyRetryDelay(1))
+	require.Equal(t, 2*rekeyRecheckInterval, rekeyRetryDelay(2))
+	require.Equal(t, 4*rekeyRecheckInterval, rekeyRetryDelay(3))
+	require.Equal(t, rekeyMaxRetryInterval, rekeyRetryDelay(100))
+}
@@ -281,6 +281,22 @@ func rekeyNotification(ctx context.Context, config Config, handle *TlfHandle, fi
 	}
 }
 
+// sbsResolvedNotification creates FSNotifications for TLFs that were
+// shared before signup and have been rekeyed for some of the
+// assertions they were waiting on, which also renames them.
+func sbsResolvedNotification(
+	oldHandle, newHandle *TlfHandle) *keybase1.FSNotification {
+	return &keybase1.FSNotification{
+		PublicTopLevelFolder: newHandle.IsPublic(),
+		Filename:             newHandle.GetCanonicalPath(),
+		StatusCode:           keybase1.FSStatusCode_FINISH,
+		NotificationType:     keybase1.FSNotificationType_REKEYING,
+		Params: map[string]string{
+			errorParamRenameOldFilename: oldHandle.GetCanonicalPath(),
+		},
+	}
+}
+
 func baseFileEditNotification(file path, writer keybase1.UID,
 	localTime time.Time) *keybase1.FSNotification {
 	n := baseNotification(file, true)
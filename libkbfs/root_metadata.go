@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
 
@@ -35,6 +36,19 @@ type WriterFlags byte
 // Possible flags set in the WriterFlags bitfield.
 const (
 	MetadataFlagUnmerged WriterFlags = 1 << iota
+	// MetadataFlagFrozen marks a TLF as read-only for all
+	// devices.  It's only enforced by clients, and is carried
+	// forward into every successor until a writer clears it.
+	MetadataFlagFrozen
+	// MetadataFlagAppendOnly marks a TLF as append-only: devices
+	// may create new entries and append to files, but may not
+	// modify or remove anything that already exists.  Like
+	// MetadataFlagFrozen, it's only enforced by clients.
+	MetadataFlagAppendOnly
+	// MetadataFlagNFDNames makes NFD, rather than NFC, the
+	// canonical form of new entry names in a TLF (see
+	// NameNormalization).
+	MetadataFlagNFDNames
 )
 
 // MetadataRevision is the type for the revision number.
@@ -75,6 +89,24 @@ type PrivateMetadata struct {
 	// was performed on this TLF.
 	LastGCRevision MetadataRevision `codec:"lgc"`
 
+	// The history retention setting for this TLF, if it's been
+	// changed from the default.
+	Retention *HistoryRetention `codec:"ret,omitempty"`
+
+	// The revisions of this TLF that quota reclamation must keep
+	// reconstructible, in increasing order.
+	PinnedRevisions []MetadataRevision `codec:"pin,omitempty"`
+
+	// The named snapshots of this TLF, sorted by name.  Their
+	// revisions are pinned too.
+	Snapshots []TLFSnapshot `codec:"snap,omitempty"`
+
+	// The number of hard links made in each group of linked
+	// entries, on top of the group's first entry, keyed by the
+	// entries' LinkID.  Counting only the added links lets
+	// conflict resolution add up the links made on each branch.
+	LinkCounts map[string]uint32 `codec:"links,omitempty"`
+
 	codec.UnknownFieldSetHandler
 
 	// When the above Changes field gets unembedded into its own
@@ -391,6 +423,162 @@ func (md *RootMetadata) SetLastGCRevision(rev MetadataRevision) {
 	md.data.LastGCRevision = rev
 }
 
+// HistoryRetention returns the history retention setting of this
+// TLF.
+func (md *RootMetadata) HistoryRetention() HistoryRetention {
+	if md.data.Retention == nil {
+		return HistoryRetention{Policy: HistoryRetentionDefault}
+	}
+	return *md.data.Retention
+}
+
+// SetHistoryRetention sets the history retention setting of this TLF.
+func (md *RootMetadata) SetHistoryRetention(retention HistoryRetention) {
+	if retention.Policy == HistoryRetentionDefault {
+		md.data.Retention = nil
+		return
+	}
+	md.data.Retention = &retention
+}
+
+type revisionsAscending []MetadataRevision
+
+func (r revisionsAscending) Len() int           { return len(r) }
+func (r revisionsAscending) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r revisionsAscending) Less(i, j int) bool { return r[i] < r[j] }
+
+// PinnedRevisions returns the revisions of this TLF that are pinned,
+// in increasing order, or nil if there are none.
+func (md *RootMetadata) PinnedRevisions() []MetadataRevision {
+	if len(md.data.PinnedRevisions) == 0 {
+		return nil
+	}
+	pinned := make([]MetadataRevision, len(md.data.PinnedRevisions))
+	copy(pinned, md.data.PinnedRevisions)
+	return pinned
+}
+
+// IsRevisionPinned returns whether the given revision of this TLF is
+// pinned.
+func (md *RootMetadata) IsRevisionPinned(rev MetadataRevision) bool {
+	for _, pinned := range md.data.PinnedRevisions {
+		if pinned == rev {
+			return true
+		}
+	}
+	return false
+}
+
+// EarliestPinnedRevision returns the earliest revision of this TLF
+// that's pinned, either on its own or by a snapshot, or
+// MetadataRevisionUninitialized if none are pinned.
+func (md *RootMetadata) EarliestPinnedRevision() MetadataRevision {
+	earliest := MetadataRevisionUninitialized
+	if len(md.data.PinnedRevisions) > 0 {
+		earliest = md.data.PinnedRevisions[0]
+	}
+	for _, snap := range md.data.Snapshots {
+		if earliest == MetadataRevisionUninitialized ||
+			snap.Revision < earliest {
+			earliest = snap.Revision
+		}
+	}
+	return earliest
+}
+
+// SetRevisionPinned pins or unpins the given revision of this TLF.
+func (md *RootMetadata) SetRevisionPinned(
+	rev MetadataRevision, pinned bool) {
+	// Always make a new slice, since the old one may be shared with
+	// the previous MD.
+	revs := make([]MetadataRevision, 0, len(md.data.PinnedRevisions)+1)
+	for _, r := range md.data.PinnedRevisions {
+		if r != rev {
+			revs = append(revs, r)
+		}
+	}
+	if pinned {
+		revs = append(revs, rev)
+		sort.Sort(revisionsAscending(revs))
+	}
+	if len(revs) == 0 {
+		revs = nil
+	}
+	md.data.PinnedRevisions = revs
+}
+
+// Snapshots returns the named snapshots of this TLF, sorted by name,
+// or nil if there are none.
+func (md *RootMetadata) Snapshots() []TLFSnapshot {
+	if len(md.data.Snapshots) == 0 {
+		return nil
+	}
+	snaps := make([]TLFSnapshot, len(md.data.Snapshots))
+	copy(snaps, md.data.Snapshots)
+	return snaps
+}
+
+// GetSnapshot returns the snapshot of this TLF with the given name,
+// if there is one.
+func (md *RootMetadata) GetSnapshot(name string) (TLFSnapshot, bool) {
+	for _, snap := range md.data.Snapshots {
+		if snap.Name == name {
+			return snap, true
+		}
+	}
+	return TLFSnapshot{}, false
+}
+
+// SetSnapshot adds the given snapshot to this TLF, replacing any
+// existing one with the same name.
+func (md *RootMetadata) SetSnapshot(snap TLFSnapshot) {
+	md.RemoveSnapshot(snap.Name)
+	snaps := make([]TLFSnapshot, 0, len(md.data.Snapshots)+1)
+	snaps = append(snaps, md.data.Snapshots...)
+	snaps = append(snaps, snap)
+	sort.Sort(snapshotsByName(snaps))
+	md.data.Snapshots = snaps
+}
+
+// RemoveSnapshot removes the snapshot with the given name from this
+// TLF, if there is one.
+func (md *RootMetadata) RemoveSnapshot(name string) {
+	// Always make a new slice, since the old one may be shared with
+	// the previous MD.
+	var snaps []TLFSnapshot
+	for _, snap := range md.data.Snapshots {
+		if snap.Name != name {
+			snaps = append(snaps, snap)
+		}
+	}
+	md.data.Snapshots = snaps
+}
+
+// LinkCount returns the number of entries in the link group with the
+// given ID.  It's one for a group that has no other entries left.
+func (md *RootMetadata) LinkCount(id string) uint32 {
+	return md.data.LinkCounts[id] + 1
+}
+
+// addLinks changes the number of added links in the link group with
+// the given ID by delta, and drops the group once it has none.
+func (md *RootMetadata) addLinks(id string, delta int) {
+	counts := make(map[string]uint32, len(md.data.LinkCounts)+1)
+	for k, v := range md.data.LinkCounts {
+		counts[k] = v
+	}
+	n := int(counts[id]) + delta
+	if n > 0 {
+		counts[id] = uint32(n)
+	} else {
+		delete(counts, id)
+	}
+	if len(counts) == 0 {
+		counts = nil
+	}
+	md.data.LinkCounts = counts
+}
+
 // updateFromTlfHandle updates the current RootMetadata's fields to
 // reflect the given handle, which must be the result of running the
 // current handle with ResolveAgain().
@@ -667,6 +855,36 @@ func (md *RootMetadata) SetUnmerged() {
 	md.bareMd.SetUnmerged()
 }
 
+// IsFrozen wraps the respective method of the underlying BareRootMetadata for convenience.
+func (md *RootMetadata) IsFrozen() bool {
+	return md.bareMd.IsFrozen()
+}
+
+// SetFrozen wraps the respective method of the underlying BareRootMetadata for convenience.
+func (md *RootMetadata) SetFrozen(frozen bool) {
+	md.bareMd.SetFrozen(frozen)
+}
+
+// IsAppendOnly wraps the respective method of the underlying BareRootMetadata for convenience.
+func (md *RootMetadata) IsAppendOnly() bool {
+	return md.bareMd.IsAppendOnly()
+}
+
+// SetAppendOnly wraps the respective method of the underlying BareRootMetadata for convenience.
+func (md *RootMetadata) SetAppendOnly(appendOnly bool) {
+	md.bareMd.SetAppendOnly(appendOnly)
+}
+
+// NameNormalization wraps the respective method of the underlying BareRootMetadata for convenience.
+func (md *RootMetadata) NameNormalization() NameNormalization {
+	return md.bareMd.NameNormalization()
+}
+
+// SetNameNormalization wraps the respective method of the underlying BareRootMetadata for convenience.
+func (md *RootMetadata) SetNameNormalization(n NameNormalization) {
+	md.bareMd.SetNameNormalization(n)
+}
+
 // SetBranchID wraps the respective method of the underlying BareRootMetadata for convenience.
 func (md *RootMetadata) SetBranchID(bid BranchID) {
 	md.bareMd.SetBranchID(bid)
@@ -76,6 +76,13 @@ func makeFakePrivateMetadataFuture(t *testing.T) privateMetadataFuture {
 				0,
 			},
 			0,
+			&HistoryRetention{
+				Policy: HistoryRetentionKeepDays,
+				Days:   7,
+			},
+			[]MetadataRevision{3, 5},
+			[]TLFSnapshot{{Name: "v1.0", Revision: 4, Ctime: 1}},
+			map[string]uint32{"fake link id": 2},
 			codec.UnknownFieldSetHandler{},
 			BlockChanges{},
 		},
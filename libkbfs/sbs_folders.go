@@ -0,0 +1,208 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+
+	"github.com/keybase/client/go/externals"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"golang.org/x/net/context"
+)
+
+// SBSFolder is a TLF that was shared before signup: its name has
+// social assertions that didn't resolve to Keybase users when it was
+// last keyed, so those people can't read it yet.  It is suitable for
+// encoding directly as JSON.
+type SBSFolder struct {
+	Name   CanonicalTlfName
+	Public bool
+	// Assertions are the social assertions in the name.  Those
+	// that have since resolved to a Keybase user are waiting for
+	// one of the writers to rekey the folder.
+	Assertions []TlfAssertionResolution
+}
+
+// getSBSFolder returns the SBSFolder for fav, and whether fav is an
+// SBS folder that uid can write to.
+func getSBSFolder(ctx context.Context, kbpki KBPKI, fav Favorite,
+	uid keybase1.UID) (SBSFolder, bool, error) {
+	// Only resolve the names that have social assertions.
+	writerNames, readerNames, _, err := splitTLFName(fav.Name)
+	if err != nil {
+		return SBSFolder{}, false, err
+	}
+	hasAssertion := false
+	for _, n := range append(writerNames, readerNames...) {
+		if _, ok := externals.NormalizeSocialAssertion(n); ok {
+			hasAssertion = true
+			break
+		}
+	}
+	if !hasAssertion {
+		return SBSFolder{}, false, nil
+	}
+
+	p, err := PreflightTlfName(ctx, kbpki, fav.Name, fav.Public)
+	if err != nil {
+		return SBSFolder{}, false, err
+	}
+	f := SBSFolder{Name: CanonicalTlfName(fav.Name), Public: fav.Public}
+	isWriter := false
+	for _, a := range p.Assertions {
+		if !a.Reader && a.UID == uid {
+			isWriter = true
+		}
+		if _, ok := externals.NormalizeSocialAssertion(a.Assertion); ok {
+			f.Assertions = append(f.Assertions, a)
+		}
+	}
+	return f, isWriter, nil
+}
+
+// GetSBSFolders returns the favorite TLFs that the current user can
+// write to and that are waiting on social assertions, sorted by
+// name.  Otherwise they only show up in the favorites of their other
+// members once those members sign up.
+func GetSBSFolders(ctx context.Context, config Config) ([]SBSFolder, error) {
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	favs, err := config.KBFSOps().GetFavorites(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []SBSFolder
+	for _, fav := range favs {
+		f, ok, err := getSBSFolder(ctx, config.KBPKI(), fav, session.UID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			folders = append(folders, f)
+		}
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].Name != folders[j].Name {
+			return folders[i].Name < folders[j].Name
+		}
+		return !folders[i].Public && folders[j].Public
+	})
+	return folders, nil
+}
+
+// getSBSRootNode returns the root node of the SBS folder with the
+// given name, which may list its writers and readers in any order,
+// or nil if it has never been written to.  It fails unless the
+// current user can write to the folder and it's still waiting on an
+// unresolved assertion.
+func getSBSRootNode(ctx context.Context, config Config, name string,
+	public bool) (*TlfHandle, Node, error) {
+	h, err := parseTlfHandleLoose(ctx, config.KBPKI(), name, public)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !h.IsWriter(session.UID) {
+		return nil, nil, NewWriteAccessError(
+			h, session.Name, h.GetCanonicalPath())
+	}
+	if len(h.UnresolvedWriters())+len(h.UnresolvedReaders()) == 0 {
+		return nil, nil, NotSBSFolderError{h.GetCanonicalPath()}
+	}
+	root, _, err := config.KBFSOps().GetRootNode(ctx, h, MasterBranch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, root, nil
+}
+
+// CancelSBSFolder removes everything in the SBS folder with the given
+// name and drops it from the current user's favorites, so that the
+// people it's waiting on find it empty once they sign up.  The folder
+// itself can't be deleted, since its name is its membership.
+func CancelSBSFolder(ctx context.Context, config Config, name string,
+	public bool) error {
+	h, root, err := getSBSRootNode(ctx, config, name, public)
+	if err != nil {
+		return err
+	}
+	kbfsOps := config.KBFSOps()
+	if root != nil {
+		children, err := kbfsOps.GetDirChildren(ctx, root)
+		if err != nil {
+			return err
+		}
+		for childName, ei := range children {
+			if ei.Type == Dir {
+				err = kbfsOps.RemoveDirTree(ctx, root, childName)
+			} else {
+				err = kbfsOps.RemoveEntry(ctx, root, childName)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return kbfsOps.DeleteFavorite(ctx, h.ToFavorite())
+}
+
+// ModifySBSFolder changes who the SBS folder with the given name is
+// shared with, e.g. to fix a mistyped assertion.  A TLF's
+// membership is fixed by its name, so this moves the folder's
+// contents into the TLF named newName, which may be any name the
+// current user can write to, and then cancels the old folder.  It
+// returns the canonical name of the new TLF.
+func ModifySBSFolder(ctx context.Context, config Config, name string,
+	public bool, newName string) (CanonicalTlfName, error) {
+	_, root, err := getSBSRootNode(ctx, config, name, public)
+	if err != nil {
+		return "", err
+	}
+	p, err := PreflightTlfName(ctx, config.KBPKI(), newName, public)
+	if err != nil {
+		return "", err
+	}
+	newH, err := ParseTlfHandle(
+		ctx, config.KBPKI(), string(p.CanonicalName), public)
+	if err != nil {
+		return "", err
+	}
+	kbfsOps := config.KBFSOps()
+	newRoot, _, err := kbfsOps.GetOrCreateRootNode(ctx, newH, MasterBranch)
+	if err != nil {
+		return "", err
+	}
+
+	if root != nil {
+		children, err := kbfsOps.GetDirChildren(ctx, root)
+		if err != nil {
+			return "", err
+		}
+		names := make([]string, 0, len(children))
+		for childName := range children {
+			names = append(names, childName)
+		}
+		sort.Strings(names)
+		for _, childName := range names {
+			err := kbfsOps.MoveAcrossTLFs(
+				ctx, root, childName, newRoot, childName)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	err = CancelSBSFolder(ctx, config, name, public)
+	if err != nil {
+		return "", err
+	}
+	return newH.GetCanonicalName(), nil
+}
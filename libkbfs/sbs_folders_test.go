@@ -0,0 +1,129 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type sbsTestReporter struct {
+	*ReporterSimple
+
+	lock          sync.Mutex
+	notifications []*keybase1.FSNotification
+}
+
+func (r *sbsTestReporter) Notify(
+	_ context.Context, n *keybase1.FSNotification) {
+	if n.NotificationType != keybase1.FSNotificationType_REKEYING ||
+		n.Params[errorParamRenameOldFilename] == "" {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.notifications = append(r.notifications, n)
+}
+
+func (r *sbsTestReporter) take() []*keybase1.FSNotification {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ns := r.notifications
+	r.notifications = nil
+	return ns
+}
+
+func TestSBSFolders(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	kbfsOps := config.KBFSOps()
+	GetRootNodeOrBust(ctx, t, config, "alice,bob", false)
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice,bob@twitter", false)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	folders, err := GetSBSFolders(ctx, config)
+	require.NoError(t, err)
+	require.Len(t, folders, 1)
+	require.Equal(t, CanonicalTlfName("alice,bob@twitter"), folders[0].Name)
+	require.False(t, folders[0].Public)
+	require.Len(t, folders[0].Assertions, 1)
+	require.Equal(t, "bob@twitter", folders[0].Assertions[0].Assertion)
+	require.Equal(t, "bob@twitter",
+		folders[0].Assertions[0].Unresolved.String())
+
+	_, err = ModifySBSFolder(ctx, config, "alice,bob", false, "alice")
+	require.IsType(t, NotSBSFolderError{}, err)
+
+	// Fix the assertion.
+	newName, err := ModifySBSFolder(
+		ctx, config, "alice,bob@twitter", false, "bob@github,alice")
+	require.NoError(t, err)
+	require.Equal(t, CanonicalTlfName("alice,bob@github"), newName)
+	newRoot := GetRootNodeOrBust(ctx, t, config, string(newName), false)
+	children, err := kbfsOps.GetDirChildren(ctx, newRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Equal(t, uint64(3), children["a"].Size)
+	folders, err = GetSBSFolders(ctx, config)
+	require.NoError(t, err)
+	require.Len(t, folders, 1)
+	require.Equal(t, newName, folders[0].Name)
+	children, err = kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+
+	err = CancelSBSFolder(ctx, config, string(newName), false)
+	require.NoError(t, err)
+	// Only the old folder is left, since reading it above made it a
+	// favorite again.
+	folders, err = GetSBSFolders(ctx, config)
+	require.NoError(t, err)
+	require.Len(t, folders, 1)
+	require.Equal(t, CanonicalTlfName("alice,bob@twitter"), folders[0].Name)
+	children, err = kbfsOps.GetDirChildren(ctx, newRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+}
+
+func TestSBSFolderResolvedNotification(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	reporter := &sbsTestReporter{
+		ReporterSimple: NewReporterSimple(config.Clock(), 10),
+	}
+	config.SetReporter(reporter)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice,bob@twitter", false)
+	kbfsOps := config.KBFSOps()
+	_, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	require.Len(t, reporter.take(), 0)
+
+	AddNewAssertionForTestOrBust(t, config, "bob", "bob@twitter")
+	folders, err := GetSBSFolders(ctx, config)
+	require.NoError(t, err)
+	require.Len(t, folders, 1)
+	require.Equal(t, "bob",
+		folders[0].Assertions[0].Username.String())
+
+	_, err = RequestRekeyAndWaitForOneFinishEvent(
+		ctx, kbfsOps, rootNode.GetFolderBranch().Tlf)
+	require.NoError(t, err)
+	ns := reporter.take()
+	require.Len(t, ns, 1)
+	require.Equal(t, "/keybase/private/alice,bob", ns[0].Filename)
+	require.Equal(t, "/keybase/private/alice,bob@twitter",
+		ns[0].Params[errorParamRenameOldFilename])
+}
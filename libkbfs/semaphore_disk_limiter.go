@@ -5,18 +5,22 @@
 package libkbfs
 
 import (
+	"math"
+
 	"github.com/keybase/kbfs/kbfssync"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
 // semaphoreDiskLimiter is an implementation of diskLimiter that uses
-// semaphores to limit the byte and file usage.
+// semaphores to limit the byte and file usage. Journal MDs get a
+// byte budget of their own, of the same size as the one for blocks.
 type semaphoreDiskLimiter struct {
-	byteLimit     int64
-	byteSemaphore *kbfssync.Semaphore
-	fileLimit     int64
-	fileSemaphore *kbfssync.Semaphore
+	byteLimit       int64
+	byteSemaphore   *kbfssync.Semaphore
+	fileLimit       int64
+	fileSemaphore   *kbfssync.Semaphore
+	mdByteSemaphore *kbfssync.Semaphore
 }
 
 var _ DiskLimiter = semaphoreDiskLimiter{}
@@ -26,8 +30,11 @@ func newSemaphoreDiskLimiter(byteLimit, fileLimit int64) semaphoreDiskLimiter {
 	byteSemaphore.Release(byteLimit)
 	fileSemaphore := kbfssync.NewSemaphore()
 	fileSemaphore.Release(fileLimit)
+	mdByteSemaphore := kbfssync.NewSemaphore()
+	mdByteSemaphore.Release(byteLimit)
 	return semaphoreDiskLimiter{
 		byteLimit, byteSemaphore, fileLimit, fileSemaphore,
+		mdByteSemaphore,
 	}
 }
 
@@ -58,14 +65,14 @@ func (sdl semaphoreDiskLimiter) onJournalDisable(
 }
 
 func (sdl semaphoreDiskLimiter) onDiskBlockCacheEnable(
-	ctx context.Context, diskCacheBytes int64) {
+	ctx context.Context, typ diskLimitTrackerType, diskCacheBytes int64) {
 	if diskCacheBytes != 0 {
 		sdl.byteSemaphore.ForceAcquire(diskCacheBytes)
 	}
 }
 
 func (sdl semaphoreDiskLimiter) onDiskBlockCacheDisable(
-	ctx context.Context, diskCacheBytes int64) {
+	ctx context.Context, typ diskLimitTrackerType, diskCacheBytes int64) {
 	if diskCacheBytes != 0 {
 		sdl.byteSemaphore.Release(diskCacheBytes)
 	}
@@ -117,13 +124,35 @@ func (sdl semaphoreDiskLimiter) onBlocksDelete(
 	}
 }
 
+func (sdl semaphoreDiskLimiter) beforeMDPut(ctx context.Context) (
+	availableBytes int64, err error) {
+	availableBytes = sdl.mdByteSemaphore.Count()
+	if availableBytes <= 0 {
+		return availableBytes, errors.WithStack(JournalMDLimitError{
+			UsedBytes:  sdl.byteLimit - availableBytes,
+			LimitBytes: sdl.byteLimit,
+		})
+	}
+	return availableBytes, nil
+}
+
+func (sdl semaphoreDiskLimiter) onJournalMDBytesChange(
+	ctx context.Context, oldBytes, newBytes int64) {
+	if newBytes > oldBytes {
+		sdl.mdByteSemaphore.ForceAcquire(newBytes - oldBytes)
+	} else if newBytes < oldBytes {
+		sdl.mdByteSemaphore.Release(oldBytes - newBytes)
+	}
+}
+
 func (sdl semaphoreDiskLimiter) onDiskBlockCacheDelete(ctx context.Context,
-	blockBytes int64) {
+	typ diskLimitTrackerType, blockBytes int64) {
 	sdl.onBlocksDelete(ctx, blockBytes, 0)
 }
 
 func (sdl semaphoreDiskLimiter) beforeDiskBlockCachePut(ctx context.Context,
-	blockBytes int64) (availableBytes int64, err error) {
+	typ diskLimitTrackerType, blockBytes int64) (
+	availableBytes int64, err error) {
 	if blockBytes == 0 {
 		return 0, errors.New("semaphoreDiskLimiter.beforeDiskBlockCachePut" +
 			" called with 0 blockBytes")
@@ -132,12 +161,31 @@ func (sdl semaphoreDiskLimiter) beforeDiskBlockCachePut(ctx context.Context,
 }
 
 func (sdl semaphoreDiskLimiter) afterDiskBlockCachePut(ctx context.Context,
-	blockBytes int64, putData bool) {
+	typ diskLimitTrackerType, blockBytes int64, putData bool) {
 	if !putData {
 		sdl.byteSemaphore.Release(blockBytes)
 	}
 }
 
+func (sdl semaphoreDiskLimiter) checkLowDiskSpace(ctx context.Context) (
+	freeBytes, minFreeBytes int64, err error) {
+	return sdl.byteSemaphore.Count(), 0, nil
+}
+
+func (sdl semaphoreDiskLimiter) lowDiskSpaceCh() <-chan struct{} {
+	return nil
+}
+
+func (sdl semaphoreDiskLimiter) journalPressure() float64 {
+	// The semaphores only block once they run out, so use the
+	// fraction of each limit that's used.
+	byteUsageFrac := 1 - float64(sdl.byteSemaphore.Count())/
+		float64(sdl.byteLimit)
+	fileUsageFrac := 1 - float64(sdl.fileSemaphore.Count())/
+		float64(sdl.fileLimit)
+	return math.Min(1, math.Max(0, math.Max(byteUsageFrac, fileUsageFrac)))
+}
+
 type semaphoreDiskLimiterStatus struct {
 	Type string
 
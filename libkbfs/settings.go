@@ -0,0 +1,567 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	// settingsFilename is the name of the file in the storage root
+	// that holds the settings changed at runtime.
+	settingsFilename = "kbfs_settings.json"
+	// settingsSocketName is the name of the Unix socket, in the
+	// storage root, on which a running KBFS serves its settings.
+	settingsSocketName = "kbfs.settings"
+	// settingsTimeout bounds how long a settings request may take.
+	settingsTimeout = 30 * time.Second
+	// maxIndirectPrefetchCount bounds the prefetch-indirect-blocks
+	// setting, so that one big file can't flood the retrieval
+	// queue.
+	maxIndirectPrefetchCount = 1000
+)
+
+// Setting is a runtime setting of a running KBFS, and its value.
+type Setting struct {
+	Name  string
+	Value string
+	// Persisted is whether the value was set at runtime, and is
+	// saved in the storage root to be applied on every startup,
+	// rather than coming from the flags or the defaults.
+	Persisted bool
+	Doc       string
+}
+
+// settingDef defines a runtime setting.  set must check the value
+// before applying any of it.
+type settingDef struct {
+	name string
+	doc  string
+	get  func(s *settings) (string, error)
+	set  func(ctx context.Context, s *settings, value string) error
+}
+
+func (s *settings) diskLimiter() (*backpressureDiskLimiter, error) {
+	bdl, ok := s.config.DiskLimiter().(*backpressureDiskLimiter)
+	if !ok {
+		return nil, errors.New("There's no backpressure disk limiter")
+	}
+	return bdl, nil
+}
+
+func formatThreshold(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var settingDefs = []settingDef{
+	{
+		name: "clean-bcache-cap",
+		doc:  "capacity of the in-memory block cache, in bytes",
+		get: func(s *settings) (string, error) {
+			return strconv.FormatUint(
+				s.config.BlockCache().GetCleanBytesCapacity(), 10), nil
+		},
+		set: func(_ context.Context, s *settings, value string) error {
+			capacity, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || capacity == 0 {
+				return errors.Errorf(
+					"%q isn't a positive number of bytes", value)
+			}
+			s.config.BlockCache().SetCleanBytesCapacity(capacity)
+			return nil
+		},
+	},
+	{
+		name: "journal-backpressure-min",
+		doc: "fraction of the journal's disk limit at which writes " +
+			"start to be slowed down",
+		get: func(s *settings) (string, error) {
+			bdl, err := s.diskLimiter()
+			if err != nil {
+				return "", err
+			}
+			minThreshold, _ := bdl.getJournalThresholds()
+			return formatThreshold(minThreshold), nil
+		},
+		set: func(_ context.Context, s *settings, value string) error {
+			bdl, err := s.diskLimiter()
+			if err != nil {
+				return err
+			}
+			minThreshold, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return errors.Errorf("%q isn't a fraction", value)
+			}
+			_, maxThreshold := bdl.getJournalThresholds()
+			return bdl.setJournalThresholds(minThreshold, maxThreshold)
+		},
+	},
+	{
+		name: "journal-backpressure-max",
+		doc: "fraction of the journal's disk limit at which writes " +
+			"are slowed down the most",
+		get: func(s *settings) (string, error) {
+			bdl, err := s.diskLimiter()
+			if err != nil {
+				return "", err
+			}
+			_, maxThreshold := bdl.getJournalThresholds()
+			return formatThreshold(maxThreshold), nil
+		},
+		set: func(_ context.Context, s *settings, value string) error {
+			bdl, err := s.diskLimiter()
+			if err != nil {
+				return err
+			}
+			maxThreshold, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return errors.Errorf("%q isn't a fraction", value)
+			}
+			minThreshold, _ := bdl.getJournalThresholds()
+			return bdl.setJournalThresholds(minThreshold, maxThreshold)
+		},
+	},
+	{
+		name: "min-free-disk-bytes",
+		doc: "free disk space below which the journals and disk " +
+			"caches stop growing, in bytes",
+		get: func(s *settings) (string, error) {
+			bdl, err := s.diskLimiter()
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatInt(bdl.getMinFreeBytes(), 10), nil
+		},
+		set: func(_ context.Context, s *settings, value string) error {
+			bdl, err := s.diskLimiter()
+			if err != nil {
+				return err
+			}
+			minFreeBytes, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.Errorf("%q isn't a number of bytes", value)
+			}
+			return bdl.setMinFreeBytes(minFreeBytes)
+		},
+	},
+	{
+		name: "prefetch-indirect-blocks",
+		doc: "how many of the children of a big file or directory " +
+			"are prefetched when it's read; 0 turns that off",
+		get: func(s *settings) (string, error) {
+			return strconv.Itoa(s.config.indirectPrefetchCount()), nil
+		},
+		set: func(_ context.Context, s *settings, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > maxIndirectPrefetchCount {
+				return errors.Errorf(
+					"%q isn't a number from 0 to %d",
+					value, maxIndirectPrefetchCount)
+			}
+			s.config.setIndirectPrefetchCount(n)
+			return nil
+		},
+	},
+	{
+		name: "bandwidth-policy",
+		doc: "block server bandwidth caps for each network type, " +
+			"like tethered=256K/1M,wifi=0/0",
+		get: func(s *settings) (string, error) {
+			return s.kbfsOps.bandwidth.getPolicy().String(), nil
+		},
+		set: func(ctx context.Context, s *settings, value string) error {
+			policy, err := ParseBandwidthPolicy(value)
+			if err != nil {
+				return err
+			}
+			s.kbfsOps.bandwidth.setPolicy(ctx, policy)
+			return nil
+		},
+	},
+}
+
+func getSettingDef(name string) (settingDef, error) {
+	for _, def := range settingDefs {
+		if def.name == name {
+			return def, nil
+		}
+	}
+	return settingDef{}, errors.Errorf("Unknown setting %q", name)
+}
+
+// settings lets the settings in settingDefs be changed while KBFS
+// runs.  The changed values are saved in the storage root, and
+// applied again on the next startup, on top of the flags.
+type settings struct {
+	config  *ConfigLocal
+	kbfsOps *KBFSOpsStandard
+	log     logger.Logger
+	// path is empty if changed values aren't saved.
+	path string
+
+	lock sync.Mutex
+	// initial holds the values from before any were changed, so
+	// that they can be reset.
+	initial map[string]string
+	// persisted holds the values that were changed.
+	persisted map[string]string
+}
+
+func newSettings(config *ConfigLocal, kbfsOps *KBFSOpsStandard,
+	storageRoot string) *settings {
+	s := &settings{
+		config:    config,
+		kbfsOps:   kbfsOps,
+		log:       config.MakeLogger("SET"),
+		initial:   make(map[string]string),
+		persisted: make(map[string]string),
+	}
+	if storageRoot != "" {
+		s.path = filepath.Join(storageRoot, settingsFilename)
+	}
+	for _, def := range settingDefs {
+		value, err := def.get(s)
+		if err == nil {
+			s.initial[def.name] = value
+		}
+	}
+	return s
+}
+
+// load applies the values saved by an earlier run.  Values that are
+// no longer valid are logged and dropped.
+func (s *settings) load(ctx context.Context) error {
+	if s.path == "" {
+		return nil
+	}
+	var persisted map[string]string
+	err := ioutil.DeserializeFromJSONFile(s.path, &persisted)
+	if ioutil.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for name, value := range persisted {
+		def, err := getSettingDef(name)
+		if err == nil {
+			err = def.set(ctx, s, value)
+		}
+		if err != nil {
+			s.log.CWarningf(ctx, "Dropping saved setting %s=%s: %+v",
+				name, value, err)
+			continue
+		}
+		s.log.CDebugf(ctx, "Applied saved setting %s=%s", name, value)
+		s.persisted[name] = value
+	}
+	return nil
+}
+
+func (s *settings) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	return ioutil.SerializeToJSONFile(s.persisted, s.path)
+}
+
+func (s *settings) getLocked(def settingDef) (Setting, error) {
+	value, err := def.get(s)
+	if err != nil {
+		return Setting{}, err
+	}
+	_, persisted := s.persisted[def.name]
+	return Setting{
+		Name:      def.name,
+		Value:     value,
+		Persisted: persisted,
+		Doc:       def.doc,
+	}, nil
+}
+
+// list returns all the settings that apply to this instance, by
+// name.
+func (s *settings) list() []Setting {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	settings := make([]Setting, 0, len(settingDefs))
+	for _, def := range settingDefs {
+		setting, err := s.getLocked(def)
+		if err != nil {
+			continue
+		}
+		settings = append(settings, setting)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Name < settings[j].Name
+	})
+	return settings
+}
+
+func (s *settings) get(name string) (Setting, error) {
+	def, err := getSettingDef(name)
+	if err != nil {
+		return Setting{}, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.getLocked(def)
+}
+
+// set checks and applies the given value, and saves it.
+func (s *settings) set(ctx context.Context, name, value string) (
+	Setting, error) {
+	def, err := getSettingDef(name)
+	if err != nil {
+		return Setting{}, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err = def.set(ctx, s, value)
+	if err != nil {
+		return Setting{}, err
+	}
+	s.log.CDebugf(ctx, "Set %s=%s", name, value)
+	s.persisted[name] = value
+	err = s.saveLocked()
+	if err != nil {
+		return Setting{}, err
+	}
+	return s.getLocked(def)
+}
+
+// reset goes back to the value the setting had at startup, before
+// any saved value was applied, and forgets the saved value.
+func (s *settings) reset(ctx context.Context, name string) (
+	Setting, error) {
+	def, err := getSettingDef(name)
+	if err != nil {
+		return Setting{}, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if initial, ok := s.initial[name]; ok {
+		err = def.set(ctx, s, initial)
+		if err != nil {
+			return Setting{}, err
+		}
+	}
+	s.log.CDebugf(ctx, "Reset %s", name)
+	delete(s.persisted, name)
+	err = s.saveLocked()
+	if err != nil {
+		return Setting{}, err
+	}
+	return s.getLocked(def)
+}
+
+// settingsRequest is sent to a running KBFS to list, get, set or
+// reset its settings.
+type settingsRequest struct {
+	// Op is "list", "get", "set" or "reset".
+	Op    string
+	Name  string `json:",omitempty"`
+	Value string `json:",omitempty"`
+}
+
+type settingsReply struct {
+	Settings []Setting
+	Error    string `json:",omitempty"`
+}
+
+func settingsSocketPath(storageRoot string) string {
+	return filepath.Join(storageRoot, settingsSocketName)
+}
+
+func (s *settings) handle(ctx context.Context, req settingsRequest) (
+	[]Setting, error) {
+	var setting Setting
+	var err error
+	switch req.Op {
+	case "list":
+		return s.list(), nil
+	case "get":
+		setting, err = s.get(req.Name)
+	case "set":
+		setting, err = s.set(ctx, req.Name, req.Value)
+	case "reset":
+		setting, err = s.reset(ctx, req.Name)
+	default:
+		return nil, errors.Errorf("Unknown settings op %q", req.Op)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []Setting{setting}, nil
+}
+
+// settingsServer serves settings requests, one per connection, on a
+// Unix socket in the storage root.  Only the user running KBFS can
+// connect to it.
+type settingsServer struct {
+	s        *settings
+	listener net.Listener
+	done     chan struct{}
+}
+
+func listenForSettings(s *settings, storageRoot string) (
+	*settingsServer, error) {
+	path := settingsSocketPath(storageRoot)
+	// A socket left behind by an instance that didn't shut down
+	// cleanly would keep the listen from working.
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	ss := &settingsServer{s: s, listener: l, done: make(chan struct{})}
+	go ss.serve()
+	return ss, nil
+}
+
+func (ss *settingsServer) serve() {
+	defer close(ss.done)
+	for {
+		conn, err := ss.listener.Accept()
+		if err != nil {
+			return
+		}
+		go ss.serveConn(conn)
+	}
+}
+
+func (ss *settingsServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	err := conn.SetDeadline(time.Now().Add(settingsTimeout))
+	if err != nil {
+		return
+	}
+	ctx := ctxWithRandomIDReplayable(context.Background(),
+		CtxKBFSOpsSettingsIDKey, CtxKBFSOpsSettingsOpID, ss.s.log)
+	var req settingsRequest
+	err = json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		ss.s.log.CDebugf(ctx, "Bad settings request: %v", err)
+		return
+	}
+	var reply settingsReply
+	reply.Settings, err = ss.s.handle(ctx, req)
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	err = json.NewEncoder(conn).Encode(reply)
+	if err != nil {
+		ss.s.log.CDebugf(ctx, "Couldn't reply to settings request: %v", err)
+	}
+}
+
+func (ss *settingsServer) shutdown() {
+	ss.listener.Close()
+	<-ss.done
+}
+
+func (fs *KBFSOpsStandard) setSettingsServer(ss *settingsServer) {
+	fs.settingsLock.Lock()
+	defer fs.settingsLock.Unlock()
+	fs.settingsServer = ss
+}
+
+func (fs *KBFSOpsStandard) stopServingSettings() {
+	fs.settingsLock.Lock()
+	defer fs.settingsLock.Unlock()
+	if fs.settingsServer != nil {
+		fs.settingsServer.shutdown()
+		fs.settingsServer = nil
+	}
+}
+
+// callSettings sends req to the KBFS running with the given storage
+// root.
+func callSettings(storageRoot string, req settingsRequest) (
+	[]Setting, error) {
+	conn, err := net.Dial("unix", settingsSocketPath(storageRoot))
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't reach a running KBFS")
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(settingsTimeout))
+	if err != nil {
+		return nil, err
+	}
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return nil, err
+	}
+	var reply settingsReply
+	err = json.NewDecoder(conn).Decode(&reply)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	return reply.Settings, nil
+}
+
+func callSetting(storageRoot string, req settingsRequest) (
+	Setting, error) {
+	settings, err := callSettings(storageRoot, req)
+	if err != nil {
+		return Setting{}, err
+	}
+	if len(settings) != 1 {
+		return Setting{}, errors.Errorf(
+			"Expected 1 setting, got %d", len(settings))
+	}
+	return settings[0], nil
+}
+
+// ListSettings returns the runtime settings of the KBFS running with
+// the given storage root.
+func ListSettings(storageRoot string) ([]Setting, error) {
+	return callSettings(storageRoot, settingsRequest{Op: "list"})
+}
+
+// GetSetting returns the given runtime setting of the KBFS running
+// with the given storage root.
+func GetSetting(storageRoot, name string) (Setting, error) {
+	return callSetting(storageRoot, settingsRequest{Op: "get", Name: name})
+}
+
+// SetSetting changes the given runtime setting of the KBFS running
+// with the given storage root, and saves it for later runs.
+func SetSetting(storageRoot, name, value string) (Setting, error) {
+	return callSetting(storageRoot, settingsRequest{
+		Op: "set", Name: name, Value: value})
+}
+
+// ResetSetting changes the given runtime setting of the KBFS running
+// with the given storage root back to its value from the flags or the
+// defaults, and stops saving it.
+func ResetSetting(storageRoot, name string) (Setting, error) {
+	return callSetting(storageRoot, settingsRequest{
+		Op: "reset", Name: name})
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestSettings(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "kbfs_settings")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, ioutil.RemoveAll(tempdir))
+	}()
+	ctx := context.Background()
+
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(ctx, t, config)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	s := newSettings(config, kbfsOps, tempdir)
+	// Without a disk limiter, its settings don't apply.
+	require.Len(t, s.list(), 3)
+	_, err = s.get("min-free-disk-bytes")
+	require.Error(t, err)
+
+	_, err = config.MakeDiskLimiter(tempdir)
+	require.NoError(t, err)
+	s = newSettings(config, kbfsOps, tempdir)
+	require.Len(t, s.list(), len(settingDefs))
+	_, err = s.get("no-such-setting")
+	require.Error(t, err)
+
+	setting, err := s.set(ctx, "clean-bcache-cap", "12345")
+	require.NoError(t, err)
+	require.Equal(t, "12345", setting.Value)
+	require.True(t, setting.Persisted)
+	require.Equal(t, uint64(12345),
+		config.BlockCache().GetCleanBytesCapacity())
+	_, err = s.set(ctx, "clean-bcache-cap", "0")
+	require.Error(t, err)
+	require.Equal(t, uint64(12345),
+		config.BlockCache().GetCleanBytesCapacity())
+
+	_, err = s.set(ctx, "prefetch-indirect-blocks", "0")
+	require.NoError(t, err)
+	require.Equal(t, 0, config.indirectPrefetchCount())
+	_, err = s.set(ctx, "prefetch-indirect-blocks", "1001")
+	require.Error(t, err)
+
+	_, err = s.set(ctx, "bandwidth-policy", "wifi=1M/0")
+	require.NoError(t, err)
+	require.Equal(t, BandwidthPolicy{
+		NetworkTypeWiFi: {UploadBytesPerSecond: 1024 * 1024},
+	}, kbfsOps.bandwidth.getPolicy())
+	_, err = s.set(ctx, "bandwidth-policy", "wifi=fast")
+	require.Error(t, err)
+
+	// The thresholds are checked against each other.
+	_, err = s.set(ctx, "journal-backpressure-max", "0.4")
+	require.Error(t, err)
+	_, err = s.set(ctx, "journal-backpressure-min", "0.3")
+	require.NoError(t, err)
+	_, err = s.set(ctx, "journal-backpressure-max", "0.4")
+	require.NoError(t, err)
+	bdl := config.DiskLimiter().(*backpressureDiskLimiter)
+	minThreshold, maxThreshold := bdl.getJournalThresholds()
+	require.Equal(t, 0.3, minThreshold)
+	require.Equal(t, 0.4, maxThreshold)
+	require.Equal(t, 0.4, bdl.journalFileTracker.maxThreshold)
+
+	setting, err = s.reset(ctx, "prefetch-indirect-blocks")
+	require.NoError(t, err)
+	require.False(t, setting.Persisted)
+	require.Equal(t, defaultIndirectPointerPrefetchCount,
+		config.indirectPrefetchCount())
+
+	// Another instance with the same storage root picks up the
+	// saved settings, and drops ones that are no longer valid.
+	var persisted map[string]string
+	err = ioutil.DeserializeFromJSONFile(
+		filepath.Join(tempdir, settingsFilename), &persisted)
+	require.NoError(t, err)
+	require.NotContains(t, persisted, "prefetch-indirect-blocks")
+	persisted["no-such-setting"] = "1"
+	err = ioutil.SerializeToJSONFile(
+		persisted, filepath.Join(tempdir, settingsFilename))
+	require.NoError(t, err)
+
+	config2 := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	s2 := newSettings(
+		config2, config2.KBFSOps().(*KBFSOpsStandard), tempdir)
+	require.NoError(t, s2.load(ctx))
+	require.Equal(t, uint64(12345),
+		config2.BlockCache().GetCleanBytesCapacity())
+	require.Equal(t, BandwidthPolicy{
+		NetworkTypeWiFi: {UploadBytesPerSecond: 1024 * 1024},
+	}, config2.KBFSOps().(*KBFSOpsStandard).bandwidth.getPolicy())
+	_, err = s2.get("no-such-setting")
+	require.Error(t, err)
+}
+
+func TestSettingsServer(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "kbfs_settings")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, ioutil.RemoveAll(tempdir))
+	}()
+	ctx := context.Background()
+
+	// Nothing is running yet.
+	_, err = ListSettings(tempdir)
+	require.Error(t, err)
+
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(ctx, t, config)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	initial := config.BlockCache().GetCleanBytesCapacity()
+	ss, err := listenForSettings(
+		newSettings(config, kbfsOps, tempdir), tempdir)
+	require.NoError(t, err)
+	kbfsOps.setSettingsServer(ss)
+
+	list, err := ListSettings(tempdir)
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+
+	setting, err := SetSetting(tempdir, "clean-bcache-cap", "4096")
+	require.NoError(t, err)
+	require.Equal(t, Setting{
+		Name:      "clean-bcache-cap",
+		Value:     "4096",
+		Persisted: true,
+		Doc:       settingDefs[0].doc,
+	}, setting)
+	require.Equal(t, uint64(4096),
+		config.BlockCache().GetCleanBytesCapacity())
+	_, err = SetSetting(tempdir, "clean-bcache-cap", "lots")
+	require.Error(t, err)
+
+	setting, err = GetSetting(tempdir, "clean-bcache-cap")
+	require.NoError(t, err)
+	require.Equal(t, "4096", setting.Value)
+
+	setting, err = ResetSetting(tempdir, "clean-bcache-cap")
+	require.NoError(t, err)
+	require.False(t, setting.Persisted)
+	require.Equal(t, initial, config.BlockCache().GetCleanBytesCapacity())
+
+	// Shutting down stops serving.
+	kbfsOps.stopServingSettings()
+	_, err = ListSettings(tempdir)
+	require.Error(t, err)
+}
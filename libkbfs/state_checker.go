@@ -91,13 +91,15 @@ func (sc *StateChecker) getLastGCData(ctx context.Context,
 	}
 
 	var latestTime time.Time
+	var latestUnrefAge time.Duration
 	var latestRev MetadataRevision
 	for _, c := range *config.allKnownConfigsForTesting {
 		ops := c.KBFSOps().(*KBFSOpsStandard).getOps(context.Background(),
 			FolderBranch{tlf, MasterBranch}, FavoritesOpNoChange)
-		rt, rev := ops.fbm.getLastQRData()
+		rt, unrefAge, rev := ops.fbm.getLastQRData()
 		if rt.After(latestTime) && rev > latestRev {
 			latestTime = rt
+			latestUnrefAge = unrefAge
 			latestRev = rev
 		}
 	}
@@ -107,7 +109,7 @@ func (sc *StateChecker) getLastGCData(ctx context.Context,
 
 	sc.log.CDebugf(ctx, "Last qr data for TLF %s: revTime=%s, rev=%d",
 		tlf, latestTime, latestRev)
-	return latestTime.Add(-sc.config.QuotaReclamationMinUnrefAge()), latestRev
+	return latestTime.Add(-latestUnrefAge), latestRev
 }
 
 // CheckMergedState verifies that the state for the given tlf is
@@ -0,0 +1,537 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"golang.org/x/net/context"
+)
+
+// storageMigrationBatchSize is how many blocks are copied between
+// disk block caches at a time.
+const storageMigrationBatchSize = 256
+
+// DiskCacheMigrationReport describes the migration of one disk block
+// cache directory.
+type DiskCacheMigrationReport struct {
+	Dir         string
+	FromVersion uint64
+	ToVersion   uint64
+	NumBlocks   int
+	Bytes       int64
+	// NumCorrupt is the number of blocks left behind because their
+	// contents didn't match their IDs, or couldn't be read.
+	NumCorrupt int
+}
+
+// StorageMigrationReport describes what MigrateStorage did.
+type StorageMigrationReport struct {
+	OldRoot    string
+	NewRoot    string
+	DiskCaches []DiskCacheMigrationReport
+	// NumFiles and Bytes count the other files moved, such as the
+	// journals.
+	NumFiles int
+	Bytes    int64
+}
+
+// storageMigrationConfig is the diskBlockCacheConfig used to open disk
+// block caches for migration.  Its limiter never refuses a put, since
+// the caches being written are the same size as the ones being read.
+type storageMigrationConfig struct {
+	codec   kbfscodec.Codec
+	log     logger.Logger
+	limiter DiskLimiter
+}
+
+var _ diskBlockCacheConfig = storageMigrationConfig{}
+
+func (c storageMigrationConfig) Codec() kbfscodec.Codec {
+	return c.codec
+}
+
+func (c storageMigrationConfig) MakeLogger(_ string) logger.Logger {
+	return c.log
+}
+
+func (c storageMigrationConfig) Clock() Clock {
+	return wallClock{}
+}
+
+func (c storageMigrationConfig) DiskLimiter() DiskLimiter {
+	return c.limiter
+}
+
+// DiskCacheBackend implements the diskBlockCacheConfig interface for
+// storageMigrationConfig.  Only leveldb caches are migrated; a cache
+// in another backend is emptied by the migration, and refilled.
+func (c storageMigrationConfig) DiskCacheBackend() DiskCacheBackend {
+	return DiskCacheBackendLevelDB
+}
+
+// readDiskCacheVersion returns the format version of the disk block
+// cache at dirPath.  A cache without a version file is taken to be
+// of the current version, as newDiskBlockCacheStandard does.
+func readDiskCacheVersion(dirPath string) (uint64, error) {
+	versionBytes, err := ioutil.ReadFile(
+		filepath.Join(dirPath, versionFilename))
+	if ioutil.IsNotExist(err) {
+		return currentDiskCacheVersion, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(versionBytes), 10, strconv.IntSize)
+}
+
+// openDiskBlockCacheForMigration opens the disk block cache at
+// dirPath, which is in the given format version, for reading.  This
+// is where support for reading older formats goes when the format
+// changes.
+func openDiskBlockCacheForMigration(config diskBlockCacheConfig,
+	dirPath string, version uint64) (*DiskBlockCacheStandard, error) {
+	switch {
+	case version == currentDiskCacheVersion:
+		return newDiskBlockCacheStandard(config, dirPath)
+	case version > currentDiskCacheVersion:
+		return nil, errors.WithStack(OutdatedVersionError{})
+	default:
+		return nil, errors.WithStack(InvalidVersionError{fmt.Sprintf(
+			"Can't migrate the disk cache at %s from version %d",
+			dirPath, version)})
+	}
+}
+
+// migratedBlock is a block being copied between disk block caches.
+type migratedBlock struct {
+	tlfID   tlf.ID
+	blockID kbfsblock.ID
+	block   DiskBlockCacheBlock
+	lruTime diskBlockCacheMetadata
+}
+
+// copyBlocksTo copies every intact block in the cache to dst.
+func (cache *DiskBlockCacheStandard) copyBlocksTo(ctx context.Context,
+	dst *DiskBlockCacheStandard, report *DiskCacheMigrationReport) error {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	iter := cache.metaDb.NewIterator(nil, nil)
+	defer iter.Release()
+
+	pending := make([]migratedBlock, 0, storageMigrationBatchSize)
+	flush := func() error {
+		bytes, err := dst.putMigratedBlocks(ctx, pending)
+		if err != nil {
+			return err
+		}
+		report.NumBlocks += len(pending)
+		report.Bytes += bytes
+		pending = pending[:0]
+		return nil
+	}
+	for iter.Next() {
+		blockID, err := kbfsblock.IDFromBytes(iter.Key())
+		if err != nil {
+			report.NumCorrupt++
+			continue
+		}
+		var metadata diskBlockCacheMetadata
+		err = cache.config.Codec().Decode(iter.Value(), &metadata)
+		if err != nil {
+			cache.log.CDebugf(ctx, "Skipping block %s with unreadable "+
+				"metadata: %+v", blockID, err)
+			report.NumCorrupt++
+			continue
+		}
+		entry, err := cache.blockDb.Get(iter.Key(), nil)
+		if err != nil {
+			cache.log.CDebugf(ctx, "Skipping unreadable block %s: %+v",
+				blockID, err)
+			report.NumCorrupt++
+			continue
+		}
+		buf, serverHalf, err := cache.decodeBlockCacheEntry(entry, nil)
+		if err == nil {
+			err = kbfsblock.VerifyID(buf, blockID)
+		}
+		if err != nil {
+			cache.log.CDebugf(ctx, "Skipping corrupt block %s: %+v",
+				blockID, err)
+			report.NumCorrupt++
+			continue
+		}
+		pending = append(pending, migratedBlock{metadata.TlfID, blockID,
+			DiskBlockCacheBlock{buf, serverHalf}, metadata})
+		if len(pending) == storageMigrationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// putMigratedBlocks puts the given blocks into the cache, checks that
+// they read back the same, and restores their LRU times.  It returns
+// the number of bytes the blocks take up in the cache.
+func (cache *DiskBlockCacheStandard) putMigratedBlocks(ctx context.Context,
+	blocks []migratedBlock) (int64, error) {
+	byTlf := make(map[tlf.ID]map[kbfsblock.ID]DiskBlockCacheBlock)
+	for _, b := range blocks {
+		if byTlf[b.tlfID] == nil {
+			byTlf[b.tlfID] = make(map[kbfsblock.ID]DiskBlockCacheBlock)
+		}
+		byTlf[b.tlfID][b.blockID] = b.block
+	}
+	for tlfID, tlfBlocks := range byTlf {
+		err := cache.PutBatch(ctx, tlfID, tlfBlocks)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	var total int64
+	metadataBatch := new(leveldb.Batch)
+	for _, b := range blocks {
+		blockKey := b.blockID.Bytes()
+		entry, err := cache.blockDb.Get(blockKey, nil)
+		if err != nil {
+			return 0, err
+		}
+		buf, serverHalf, err := cache.decodeBlockCacheEntry(
+			entry, getBlockBuffer(len(entry)))
+		if err != nil {
+			return 0, err
+		}
+		matches := bytes.Equal(buf, b.block.Buf) &&
+			serverHalf == b.block.ServerHalf
+		putBlockBuffer(buf)
+		if !matches {
+			return 0, errors.Errorf(
+				"Block %s doesn't match after being migrated", b.blockID)
+		}
+		metadata := b.lruTime
+		metadata.BlockSize = uint32(len(entry))
+		encodedMetadata, err := cache.config.Codec().Encode(&metadata)
+		if err != nil {
+			return 0, err
+		}
+		metadataBatch.Put(blockKey, encodedMetadata)
+		total += int64(len(entry))
+	}
+	return total, cache.metaDb.Write(metadataBatch, nil)
+}
+
+// migrateDiskBlockCache copies the intact blocks of the disk block
+// cache at srcDir into a new cache, of the current format version, at
+// dstDir.
+func migrateDiskBlockCache(ctx context.Context,
+	config diskBlockCacheConfig, srcDir, dstDir string) (
+	report DiskCacheMigrationReport, err error) {
+	report.Dir = filepath.Base(srcDir)
+	report.ToVersion = currentDiskCacheVersion
+	report.FromVersion, err = readDiskCacheVersion(srcDir)
+	if err != nil {
+		return DiskCacheMigrationReport{}, err
+	}
+	src, err := openDiskBlockCacheForMigration(
+		config, srcDir, report.FromVersion)
+	if err != nil {
+		return DiskCacheMigrationReport{}, err
+	}
+	defer src.shutdownUnaccounted(ctx)
+	dst, err := newDiskBlockCacheStandard(config, dstDir)
+	if err != nil {
+		return DiskCacheMigrationReport{}, err
+	}
+	defer dst.shutdownUnaccounted(ctx)
+
+	err = src.copyBlocksTo(ctx, dst, &report)
+	if err != nil {
+		return DiskCacheMigrationReport{}, err
+	}
+
+	// Carry over the list of synced TLFs, if this is the sync
+	// partition.
+	syncedTlfsPath := filepath.Join(srcDir, syncedTlfsFilename)
+	if _, err := ioutil.Stat(syncedTlfsPath); err == nil {
+		_, err := copyFileVerified(
+			syncedTlfsPath, filepath.Join(dstDir, syncedTlfsFilename))
+		if err != nil {
+			return DiskCacheMigrationReport{}, err
+		}
+	} else if !ioutil.IsNotExist(err) {
+		return DiskCacheMigrationReport{}, err
+	}
+	return report, nil
+}
+
+// copyFileVerified copies the regular file at src to a new file at
+// dst, and checks that dst reads back the same as src.  It returns
+// the number of bytes copied.
+func copyFileVerified(src, dst string) (n int64, err error) {
+	hashFile := func(f io.Reader, w io.Writer) ([]byte, int64, error) {
+		h := sha256.New()
+		if w != nil {
+			w = io.MultiWriter(w, h)
+		} else {
+			w = h
+		}
+		n, err := io.Copy(w, f)
+		return h.Sum(nil), n, err
+	}
+
+	in, err := ioutil.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	out, err := ioutil.OpenFile(
+		dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	srcHash, n, err := hashFile(in, out)
+	if err != nil {
+		out.Close()
+		return 0, errors.WithStack(err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return 0, errors.WithStack(err)
+	}
+	if err := out.Close(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	check, err := ioutil.OpenFile(dst, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer check.Close()
+	dstHash, _, err := hashFile(check, nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if !bytes.Equal(srcHash, dstHash) {
+		return 0, errors.Errorf("%s doesn't match %s after copying", dst, src)
+	}
+	return n, nil
+}
+
+// copyTreeVerified copies the file or directory tree at src to dst,
+// verifying each file with copyFileVerified.
+func copyTreeVerified(src, dst string) (numFiles int, numBytes int64,
+	err error) {
+	err = filepath.Walk(src, func(path string, fi os.FileInfo,
+		err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return ioutil.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode().IsRegular():
+			n, err := copyFileVerified(path, target)
+			if err != nil {
+				return err
+			}
+			numFiles++
+			numBytes += n
+			return nil
+		default:
+			return errors.Errorf("Can't migrate %s, which isn't a "+
+				"regular file or directory", path)
+		}
+	})
+	return numFiles, numBytes, err
+}
+
+// checkStorageMigrationTarget returns an error if there's anything
+// at path other than an empty directory.
+func checkStorageMigrationTarget(path string) error {
+	fi, err := ioutil.Stat(path)
+	if ioutil.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+	}
+	return errors.WithStack(StorageMigrationTargetError{path})
+}
+
+// MigrateStorage moves the disk block caches, journals and other
+// local state of KBFS from the storage root oldRoot to newRoot, which
+// may be the same, converting the disk block caches to the current
+// format along the way.  Every block is checked against its ID, and
+// every other file against its original after it's copied; blocks
+// that fail the check are left behind.  Only once everything has
+// been copied are the originals removed.
+//
+// KBFS must not be running on either storage root.
+func MigrateStorage(ctx context.Context, log logger.Logger,
+	oldRoot, newRoot string) (report StorageMigrationReport, err error) {
+	oldRoot, err = filepath.Abs(oldRoot)
+	if err != nil {
+		return StorageMigrationReport{}, errors.WithStack(err)
+	}
+	newRoot, err = filepath.Abs(newRoot)
+	if err != nil {
+		return StorageMigrationReport{}, errors.WithStack(err)
+	}
+	report.OldRoot = oldRoot
+	report.NewRoot = newRoot
+	inPlace := oldRoot == newRoot
+
+	for _, root := range []string{oldRoot, newRoot} {
+		_, err := ioutil.Stat(runningMarkerPath(root))
+		if err == nil {
+			return StorageMigrationReport{},
+				errors.WithStack(StorageInUseError{root})
+		} else if !ioutil.IsNotExist(err) {
+			return StorageMigrationReport{}, err
+		}
+	}
+
+	config := storageMigrationConfig{
+		codec:   kbfscodec.NewMsgpack(),
+		log:     log,
+		limiter: newSemaphoreDiskLimiter(math.MaxInt64, math.MaxInt64),
+	}
+
+	// Copies are made to the paths in created, and moved to their
+	// final path, if different, once everything is copied.
+	type copied struct {
+		src, created, final string
+	}
+	var copies []copied
+	defer func() {
+		if err != nil {
+			for _, c := range copies {
+				if rmErr := ioutil.RemoveAll(c.created); rmErr != nil {
+					log.CWarningf(ctx, "Couldn't remove %s: %+v",
+						c.created, rmErr)
+				}
+			}
+		}
+	}()
+
+	for _, rootFn := range []func(string) string{
+		diskBlockCacheRootFromStorageRoot,
+		publicDiskBlockCacheRootFromStorageRoot,
+		syncDiskBlockCacheRootFromStorageRoot,
+	} {
+		src := rootFn(oldRoot)
+		if _, err := ioutil.Stat(src); ioutil.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return StorageMigrationReport{}, err
+		}
+		c := copied{src, rootFn(newRoot), rootFn(newRoot)}
+		if inPlace {
+			version, err := readDiskCacheVersion(src)
+			if err != nil {
+				return StorageMigrationReport{}, err
+			}
+			if version == currentDiskCacheVersion {
+				continue
+			}
+			c.created = src + ".migrating"
+		}
+		if err := checkStorageMigrationTarget(c.created); err != nil {
+			return StorageMigrationReport{}, err
+		}
+		log.CDebugf(ctx, "Migrating disk block cache %s to %s", src, c.final)
+		copies = append(copies, c)
+		cacheReport, err := migrateDiskBlockCache(ctx, config, src, c.created)
+		if err != nil {
+			return StorageMigrationReport{}, err
+		}
+		report.DiskCaches = append(report.DiskCaches, cacheReport)
+	}
+
+	if !inPlace {
+		for _, name := range []string{
+			"kbfs_journal",
+			filepath.Base(diskMDCacheRootFromStorageRoot(oldRoot)),
+			filepath.Base(diskPreviewCacheRootFromStorageRoot(oldRoot)),
+			recoveryReportFilename,
+		} {
+			src := filepath.Join(oldRoot, name)
+			if _, err := ioutil.Stat(src); ioutil.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return StorageMigrationReport{}, err
+			}
+			dst := filepath.Join(newRoot, name)
+			if err := checkStorageMigrationTarget(dst); err != nil {
+				return StorageMigrationReport{}, err
+			}
+			log.CDebugf(ctx, "Moving %s to %s", src, dst)
+			copies = append(copies, copied{src, dst, dst})
+			numFiles, numBytes, err := copyTreeVerified(src, dst)
+			if err != nil {
+				return StorageMigrationReport{}, err
+			}
+			report.NumFiles += numFiles
+			report.Bytes += numBytes
+		}
+	}
+
+	// Everything has been copied and checked, so the originals can
+	// go.  Past this point, a failure leaves both copies in place
+	// for the user to sort out, rather than deleting either.
+	finished := copies
+	copies = nil
+	for _, c := range finished {
+		err := ioutil.RemoveAll(c.src)
+		if err != nil {
+			return StorageMigrationReport{}, err
+		}
+		if c.created != c.final {
+			err := ioutil.Rename(c.created, c.final)
+			if err != nil {
+				return StorageMigrationReport{}, err
+			}
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestMigrateStorage(t *testing.T) {
+	ctx := context.Background()
+	tempdir, err := ioutil.TempDir(os.TempDir(), "storage_migration")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, ioutil.RemoveAll(tempdir))
+	}()
+	oldRoot := filepath.Join(tempdir, "old")
+	newRoot := filepath.Join(tempdir, "new")
+
+	config := newTestDiskBlockCacheConfig(t)
+	config.limiter = newSemaphoreDiskLimiter(math.MaxInt64, math.MaxInt64)
+	cache, err := newDiskBlockCacheStandard(
+		config, diskBlockCacheRootFromStorageRoot(oldRoot))
+	require.NoError(t, err)
+
+	t.Log("Put some intact blocks, each at a different time.")
+	tlfID := tlf.FakeID(1, false)
+	type cachedBlock struct {
+		buf        []byte
+		serverHalf kbfscrypto.BlockCryptKeyServerHalf
+		lruTime    time.Time
+	}
+	blocks := make(map[kbfsblock.ID]cachedBlock)
+	for i := 0; i < 10; i++ {
+		_, buf, serverHalf := setupBlockForDiskCache(t, config)
+		blockID, err := kbfsblock.MakePermanentID(buf)
+		require.NoError(t, err)
+		config.TestClock().Add(time.Minute)
+		require.NoError(t, cache.Put(ctx, tlfID, blockID, buf, serverHalf))
+		blocks[blockID] = cachedBlock{buf, serverHalf, config.Clock().Now()}
+	}
+	t.Log("Put a block whose contents don't match its ID.")
+	corruptID, buf, serverHalf := setupBlockForDiskCache(t, config)
+	require.NoError(t, cache.Put(ctx, tlfID, corruptID, buf, serverHalf))
+	cache.shutdownUnaccounted(ctx)
+
+	journalFile := filepath.Join("kbfs_journal", "v1", "journal.json")
+	require.NoError(t, ioutil.MkdirAll(
+		filepath.Dir(filepath.Join(oldRoot, journalFile)), 0700))
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(oldRoot, journalFile), []byte("journal"), 0600))
+
+	t.Log("Refuse to migrate while KBFS is running.")
+	marker := runningMarkerPath(oldRoot)
+	require.NoError(t, ioutil.WriteFile(marker, []byte("{}"), 0600))
+	_, err = MigrateStorage(ctx, config.MakeLogger(""), oldRoot, newRoot)
+	require.IsType(t, StorageInUseError{}, errors.Cause(err))
+	require.NoError(t, ioutil.Remove(marker))
+
+	report, err := MigrateStorage(ctx, config.MakeLogger(""), oldRoot, newRoot)
+	require.NoError(t, err)
+	require.Len(t, report.DiskCaches, 1)
+	require.Equal(t, len(blocks), report.DiskCaches[0].NumBlocks)
+	require.Equal(t, 1, report.DiskCaches[0].NumCorrupt)
+	require.Equal(t, 1, report.NumFiles)
+
+	t.Log("The old copies are gone, and the new ones are intact.")
+	_, err = ioutil.Stat(diskBlockCacheRootFromStorageRoot(oldRoot))
+	require.True(t, ioutil.IsNotExist(err))
+	_, err = ioutil.Stat(filepath.Join(oldRoot, "kbfs_journal"))
+	require.True(t, ioutil.IsNotExist(err))
+	journal, err := ioutil.ReadFile(filepath.Join(newRoot, journalFile))
+	require.NoError(t, err)
+	require.Equal(t, []byte("journal"), journal)
+
+	cache, err = newDiskBlockCacheStandard(
+		config, diskBlockCacheRootFromStorageRoot(newRoot))
+	require.NoError(t, err)
+	defer cache.shutdownUnaccounted(ctx)
+	for blockID, block := range blocks {
+		lru, err := cache.getLRU(blockID)
+		require.NoError(t, err)
+		require.True(t, block.lruTime.Equal(lru))
+		buf, serverHalf, err := cache.Get(ctx, tlfID, blockID)
+		require.NoError(t, err)
+		require.Equal(t, block.buf, buf)
+		require.Equal(t, block.serverHalf, serverHalf)
+	}
+	_, _, err = cache.Get(ctx, tlfID, corruptID)
+	require.IsType(t, NoSuchBlockError{}, errors.Cause(err))
+
+	t.Log("Migrating to a root that's already in use fails.")
+	require.NoError(t, ioutil.MkdirAll(
+		filepath.Dir(filepath.Join(oldRoot, journalFile)), 0700))
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(oldRoot, journalFile), []byte("journal"), 0600))
+	_, err = MigrateStorage(ctx, config.MakeLogger(""), oldRoot, newRoot)
+	require.IsType(t, StorageMigrationTargetError{}, errors.Cause(err))
+	_, err = ioutil.Stat(filepath.Join(oldRoot, journalFile))
+	require.NoError(t, err)
+}
@@ -183,6 +183,8 @@ func MakeTestConfigOrBust(t logger.TestLogBackend,
 
 	// no auto reclamation
 	config.qrPeriod = 0 * time.Second
+	config.diskCacheVerifyPeriod = 0
+	config.blockChallengePeriod = 0
 
 	// no min head age
 	config.qrMinHeadAge = 0 * time.Second
@@ -226,6 +228,9 @@ func configAsUserWithMode(config *ConfigLocal,
 	crypto := NewCryptoLocal(config.Codec(), signingKey, cryptPrivateKey)
 	c.SetCrypto(crypto)
 	c.noBGFlush = config.noBGFlush
+	c.qrPeriod = config.qrPeriod
+	c.diskCacheVerifyPeriod = config.diskCacheVerifyPeriod
+	c.blockChallengePeriod = config.blockChallengePeriod
 
 	if s, ok := config.BlockServer().(*BlockServerRemote); ok {
 		blockServer := NewBlockServerRemote(c, s.RemoteAddress(),
@@ -502,10 +507,140 @@ func ForceQuotaReclamationForTesting(config Config,
 	return nil
 }
 
-// TestClock returns a set time as the current time.
+// TestClock returns a set time as the current time.  Timers, tickers
+// and AfterFunc callbacks made by a TestClock only fire when the
+// clock is moved past their deadline with Set or Add.
 type TestClock struct {
-	l sync.Mutex
-	t time.Time
+	l      sync.Mutex
+	t      time.Time
+	timers map[*testClockTimer]bool
+}
+
+// testClockTimer is a pending event on a TestClock.  Exactly one of
+// c and f is set.
+type testClockTimer struct {
+	tc     *TestClock
+	c      chan time.Time
+	f      func()
+	period time.Duration // non-zero for tickers
+
+	// when is protected by tc.l.
+	when time.Time
+}
+
+var _ Timer = (*testClockTimer)(nil)
+var _ Ticker = (*testClockTicker)(nil)
+
+// C implements the Timer interface for testClockTimer.
+func (tct *testClockTimer) C() <-chan time.Time {
+	return tct.c
+}
+
+// Stop implements the Timer interface for testClockTimer.
+func (tct *testClockTimer) Stop() bool {
+	tct.tc.l.Lock()
+	defer tct.tc.l.Unlock()
+	return tct.tc.removeTimerLocked(tct)
+}
+
+// Reset implements the Timer interface for testClockTimer.
+func (tct *testClockTimer) Reset(d time.Duration) bool {
+	tct.tc.l.Lock()
+	defer tct.tc.l.Unlock()
+	wasActive := tct.tc.removeTimerLocked(tct)
+	tct.tc.scheduleLocked(tct, d)
+	return wasActive
+}
+
+type testClockTicker struct {
+	*testClockTimer
+}
+
+// Stop implements the Ticker interface for testClockTicker.
+func (tct testClockTicker) Stop() {
+	tct.testClockTimer.Stop()
+}
+
+func (tc *TestClock) removeTimerLocked(tct *testClockTimer) bool {
+	if !tc.timers[tct] {
+		return false
+	}
+	delete(tc.timers, tct)
+	return true
+}
+
+func (tct *testClockTimer) fire(now time.Time) {
+	if tct.f != nil {
+		go tct.f()
+		return
+	}
+	// Like the time package, drop the event if the last one
+	// hasn't been received yet.
+	select {
+	case tct.c <- now:
+	default:
+	}
+}
+
+func (tc *TestClock) scheduleLocked(tct *testClockTimer, d time.Duration) {
+	tct.when = tc.t.Add(d)
+	if d <= 0 && tct.period == 0 {
+		tct.fire(tc.t)
+		return
+	}
+	if tc.timers == nil {
+		tc.timers = make(map[*testClockTimer]bool)
+	}
+	tc.timers[tct] = true
+}
+
+// fireTimersLocked fires every pending event that is due at the
+// current time, and re-arms any tickers among them.
+func (tc *TestClock) fireTimersLocked() {
+	for tct := range tc.timers {
+		if tct.when.After(tc.t) {
+			continue
+		}
+		tct.fire(tc.t)
+		if tct.period == 0 {
+			delete(tc.timers, tct)
+			continue
+		}
+		for !tct.when.After(tc.t) {
+			tct.when = tct.when.Add(tct.period)
+		}
+	}
+}
+
+// NewTimer implements the Clock interface for TestClock.
+func (tc *TestClock) NewTimer(d time.Duration) Timer {
+	tct := &testClockTimer{tc: tc, c: make(chan time.Time, 1)}
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	tc.scheduleLocked(tct, d)
+	return tct
+}
+
+// NewTicker implements the Clock interface for TestClock.
+func (tc *TestClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	tct := &testClockTimer{
+		tc: tc, c: make(chan time.Time, 1), period: d}
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	tc.scheduleLocked(tct, d)
+	return testClockTicker{tct}
+}
+
+// AfterFunc implements the Clock interface for TestClock.
+func (tc *TestClock) AfterFunc(d time.Duration, f func()) Timer {
+	tct := &testClockTimer{tc: tc, f: f}
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	tc.scheduleLocked(tct, d)
+	return tct
 }
 
 func newTestClockNow() *TestClock {
@@ -524,18 +659,20 @@ func (tc *TestClock) Now() time.Time {
 	return tc.t
 }
 
-// Set sets the test clock time.
+// Set sets the test clock time, and fires any events that are due.
 func (tc *TestClock) Set(t time.Time) {
 	tc.l.Lock()
 	defer tc.l.Unlock()
 	tc.t = t
+	tc.fireTimersLocked()
 }
 
-// Add adds to the test clock time.
+// Add adds to the test clock time, and fires any events that are due.
 func (tc *TestClock) Add(d time.Duration) {
 	tc.l.Lock()
 	defer tc.l.Unlock()
 	tc.t = tc.t.Add(d)
+	tc.fireTimersLocked()
 }
 
 // CheckConfigAndShutdown shuts down the given config, but fails the
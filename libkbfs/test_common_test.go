@@ -0,0 +1,83 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireNoTick(t *testing.T, c <-chan time.Time) {
+	select {
+	case <-c:
+		t.Fatal("Unexpected tick")
+	default:
+	}
+}
+
+func TestTestClockTimer(t *testing.T) {
+	clock, now := newTestClockAndTimeNow()
+
+	timer := clock.NewTimer(time.Minute)
+	clock.Add(time.Minute - 1)
+	requireNoTick(t, timer.C())
+	clock.Add(1)
+	require.Equal(t, now.Add(time.Minute), <-timer.C())
+	require.False(t, timer.Stop())
+
+	// A reset timer fires again, relative to the current time.
+	require.False(t, timer.Reset(time.Second))
+	require.True(t, timer.Reset(time.Hour))
+	clock.Add(time.Second)
+	requireNoTick(t, timer.C())
+	require.True(t, timer.Stop())
+	clock.Add(2 * time.Hour)
+	requireNoTick(t, timer.C())
+
+	// Non-positive durations fire right away.
+	timer = clock.NewTimer(0)
+	<-timer.C()
+}
+
+func TestTestClockTicker(t *testing.T) {
+	clock := newTestClockNow()
+
+	ticker := clock.NewTicker(time.Second)
+	requireNoTick(t, ticker.C())
+	clock.Add(time.Second)
+	<-ticker.C()
+
+	// Like a real ticker, ticks that aren't received are dropped.
+	clock.Add(time.Second)
+	clock.Add(time.Second)
+	<-ticker.C()
+	requireNoTick(t, ticker.C())
+
+	ticker.Stop()
+	clock.Add(time.Second)
+	requireNoTick(t, ticker.C())
+}
+
+func TestTestClockAfterFunc(t *testing.T) {
+	clock := newTestClockNow()
+
+	c := make(chan struct{}, 1)
+	timer := clock.AfterFunc(time.Minute, func() {
+		c <- struct{}{}
+	})
+	require.Nil(t, timer.C())
+	clock.Add(time.Minute)
+	<-c
+
+	// The zero value works too, and a stopped callback never runs.
+	var clock2 TestClock
+	timer = clock2.AfterFunc(time.Minute, func() {
+		t.Error("Stopped AfterFunc ran")
+	})
+	require.True(t, timer.Stop())
+	clock2.Add(time.Hour)
+}
@@ -514,3 +514,7 @@ func (m *stallingMDOps) ResolveBranch(
 	})
 	return mdID, err
 }
+
+func (m *stallingMDOps) GetMerkleCheckStatus() []MerkleCheckStatus {
+	return m.delegate.GetMerkleCheckStatus()
+}
@@ -0,0 +1,626 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// A TLF archive is a sequence of frames, each a big-endian uint32
+// length followed by that many bytes.  The first frame is an
+// unencrypted tlfArchiveHeader; every following frame is an
+// encryptedData holding a tlfArchiveRecord, and the last of those
+// holds the trailer.  The records contain the signed MDs and the
+// encrypted blocks exactly as the servers returned them, along with
+// the block server halves, so the archive key protects the block
+// keys in the same way the block server does.
+const (
+	tlfArchiveVersion = 1
+	// Frames bigger than this can't be valid, so don't allocate
+	// them when reading a corrupted archive.
+	tlfArchiveMaxFrameSize = 2 * MaxBlockSizeBytesDefault
+)
+
+// TLFArchiveKey is the secret key that encrypts a TLF archive.  It
+// isn't derived from any other key, so an archive can be restored
+// without the Keybase servers, as long as the key is kept safe
+// somewhere else.
+type TLFArchiveKey [32]byte
+
+// MakeRandomTLFArchiveKey returns a new random archive key.
+func MakeRandomTLFArchiveKey() (TLFArchiveKey, error) {
+	var key TLFArchiveKey
+	err := kbfscrypto.RandRead(key[:])
+	if err != nil {
+		return TLFArchiveKey{}, err
+	}
+	return key, nil
+}
+
+// ParseTLFArchiveKey parses a key in the format returned by
+// TLFArchiveKey.String.
+func ParseTLFArchiveKey(s string) (TLFArchiveKey, error) {
+	var key TLFArchiveKey
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return TLFArchiveKey{}, errors.WithStack(err)
+	}
+	if len(buf) != len(key) {
+		return TLFArchiveKey{}, errors.Errorf(
+			"Archive key has %d bytes instead of %d", len(buf), len(key))
+	}
+	copy(key[:], buf)
+	return key, nil
+}
+
+func (k TLFArchiveKey) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+// TLFArchiveSummary describes what a TLF archive export or import
+// did.
+type TLFArchiveSummary struct {
+	TlfID tlf.ID
+	Start MetadataRevision
+	End   MetadataRevision
+	// MDs and Blocks count what's in the archive.
+	MDs    int
+	Blocks int
+	// MissingBlocks were referenced by the exported range, but the
+	// block server had already deleted them, normally because quota
+	// reclamation reclaimed them.
+	MissingBlocks int `json:",omitempty"`
+	// RestoredMDs and RestoredBlocks count what an import had to put
+	// back on the servers.
+	RestoredMDs    int `json:",omitempty"`
+	RestoredBlocks int `json:",omitempty"`
+}
+
+func (s TLFArchiveSummary) String() string {
+	return fmt.Sprintf("TLF %s, revisions %d to %d: %d MDs, %d blocks "+
+		"(%d missing); restored %d MDs and %d blocks", s.TlfID, s.Start,
+		s.End, s.MDs, s.Blocks, s.MissingBlocks, s.RestoredMDs,
+		s.RestoredBlocks)
+}
+
+type tlfArchiveHeader struct {
+	Version int
+	TlfID   tlf.ID
+	Start   MetadataRevision
+	End     MetadataRevision
+	// KeyCheck is the encrypted TLF ID, to detect a wrong key before
+	// reading any records.
+	KeyCheck encryptedData
+}
+
+type tlfArchiveMD struct {
+	RMDS            serializedRMDS
+	WriterKeyBundle *TLFWriterKeyBundleV3 `codec:",omitempty"`
+	ReaderKeyBundle *TLFReaderKeyBundleV3 `codec:",omitempty"`
+}
+
+type tlfArchiveBlock struct {
+	ID         kbfsblock.ID
+	Contexts   []kbfsblock.Context
+	Buf        []byte
+	ServerHalf kbfscrypto.BlockCryptKeyServerHalf
+}
+
+type tlfArchiveTrailer struct {
+	MDs    int
+	Blocks int
+}
+
+// Exactly one field of a tlfArchiveRecord is set.
+type tlfArchiveRecord struct {
+	MD      *tlfArchiveMD      `codec:",omitempty"`
+	Block   *tlfArchiveBlock   `codec:",omitempty"`
+	Trailer *tlfArchiveTrailer `codec:",omitempty"`
+}
+
+type tlfArchiveWriter struct {
+	codec  kbfscodec.Codec
+	crypto CryptoCommon
+	key    TLFArchiveKey
+	w      io.Writer
+}
+
+func (aw tlfArchiveWriter) writeFrame(buf []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(buf)))
+	if _, err := aw.w.Write(size[:]); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := aw.w.Write(buf); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (aw tlfArchiveWriter) encrypt(obj interface{}) (encryptedData, error) {
+	buf, err := aw.codec.Encode(obj)
+	if err != nil {
+		return encryptedData{}, err
+	}
+	return aw.crypto.encryptData(buf, aw.key)
+}
+
+func (aw tlfArchiveWriter) writeHeader(
+	id tlf.ID, start, end MetadataRevision) error {
+	keyCheck, err := aw.encrypt(id)
+	if err != nil {
+		return err
+	}
+	buf, err := aw.codec.Encode(tlfArchiveHeader{
+		Version:  tlfArchiveVersion,
+		TlfID:    id,
+		Start:    start,
+		End:      end,
+		KeyCheck: keyCheck,
+	})
+	if err != nil {
+		return err
+	}
+	return aw.writeFrame(buf)
+}
+
+func (aw tlfArchiveWriter) writeRecord(record tlfArchiveRecord) error {
+	ed, err := aw.encrypt(record)
+	if err != nil {
+		return err
+	}
+	buf, err := aw.codec.Encode(ed)
+	if err != nil {
+		return err
+	}
+	return aw.writeFrame(buf)
+}
+
+type tlfArchiveReader struct {
+	codec  kbfscodec.Codec
+	crypto CryptoCommon
+	key    TLFArchiveKey
+	r      io.Reader
+}
+
+func (ar tlfArchiveReader) readFrame() ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(ar.r, size[:]); err == io.EOF {
+		return nil, InvalidTLFArchiveError{"the archive is truncated"}
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > tlfArchiveMaxFrameSize {
+		return nil, InvalidTLFArchiveError{
+			fmt.Sprintf("a frame has %d bytes", n)}
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(ar.r, buf); err == io.ErrUnexpectedEOF {
+		return nil, InvalidTLFArchiveError{"the archive is truncated"}
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf, nil
+}
+
+func (ar tlfArchiveReader) decrypt(ed encryptedData, obj interface{}) error {
+	buf, err := ar.crypto.decryptData(ed, ar.key)
+	if err != nil {
+		return InvalidTLFArchiveError{
+			fmt.Sprintf("couldn't decrypt: %v", err)}
+	}
+	return ar.codec.Decode(buf, obj)
+}
+
+func (ar tlfArchiveReader) readHeader() (tlfArchiveHeader, error) {
+	buf, err := ar.readFrame()
+	if err != nil {
+		return tlfArchiveHeader{}, err
+	}
+	var header tlfArchiveHeader
+	err = ar.codec.Decode(buf, &header)
+	if err != nil {
+		return tlfArchiveHeader{}, err
+	}
+	if header.Version != tlfArchiveVersion {
+		return tlfArchiveHeader{}, InvalidTLFArchiveError{
+			fmt.Sprintf("unknown version %d", header.Version)}
+	}
+	var id tlf.ID
+	err = ar.decrypt(header.KeyCheck, &id)
+	if err != nil || id != header.TlfID {
+		return tlfArchiveHeader{}, InvalidTLFArchiveError{
+			"the key doesn't match"}
+	}
+	return header, nil
+}
+
+func (ar tlfArchiveReader) readRecord() (tlfArchiveRecord, error) {
+	buf, err := ar.readFrame()
+	if err != nil {
+		return tlfArchiveRecord{}, err
+	}
+	var ed encryptedData
+	err = ar.codec.Decode(buf, &ed)
+	if err != nil {
+		return tlfArchiveRecord{}, err
+	}
+	var record tlfArchiveRecord
+	err = ar.decrypt(ed, &record)
+	if err != nil {
+		return tlfArchiveRecord{}, err
+	}
+	return record, nil
+}
+
+type archivedMD struct {
+	rmds  *RootMetadataSigned
+	extra ExtraMetadata
+}
+
+// readRecords reads all the records after the header.  It checks the
+// signatures and the order of the MDs, and returns them, and checks
+// the IDs of the blocks before passing each one to blockFn.
+func (ar tlfArchiveReader) readRecords(config Config,
+	header tlfArchiveHeader, blockFn func(*tlfArchiveBlock) error) (
+	mds []archivedMD, err error) {
+	var prevID MdID
+	numBlocks := 0
+	for {
+		record, err := ar.readRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		if record.MD != nil {
+			rmds, extra, err := record.MD.decode(config.Codec(), header.TlfID)
+			if err != nil {
+				return nil, err
+			}
+			expectedRev := header.Start + MetadataRevision(len(mds))
+			if rmds.MD.TlfID() != header.TlfID ||
+				rmds.MD.RevisionNumber() != expectedRev {
+				return nil, InvalidTLFArchiveError{
+					fmt.Sprintf("expected revision %d of %s, got "+
+						"revision %d of %s", expectedRev, header.TlfID,
+						rmds.MD.RevisionNumber(), rmds.MD.TlfID())}
+			}
+			err = rmds.IsValidAndSigned(
+				config.Codec(), config.Crypto(), extra)
+			if err != nil {
+				return nil, err
+			}
+			if len(mds) > 0 {
+				err = mds[len(mds)-1].rmds.MD.CheckValidSuccessor(
+					prevID, rmds.MD)
+				if err != nil {
+					return nil, err
+				}
+			}
+			prevID, err = config.Crypto().MakeMdID(rmds.MD)
+			if err != nil {
+				return nil, err
+			}
+			mds = append(mds, archivedMD{rmds, extra})
+		} else if record.Block != nil {
+			err = kbfsblock.VerifyID(record.Block.Buf, record.Block.ID)
+			if err != nil {
+				return nil, err
+			}
+			if len(record.Block.Contexts) == 0 {
+				return nil, InvalidTLFArchiveError{
+					fmt.Sprintf("block %s has no references",
+						record.Block.ID)}
+			}
+			err = blockFn(record.Block)
+			if err != nil {
+				return nil, err
+			}
+			numBlocks++
+		} else if record.Trailer != nil {
+			if record.Trailer.MDs != len(mds) ||
+				record.Trailer.Blocks != numBlocks ||
+				header.End != header.Start+MetadataRevision(len(mds))-1 {
+				return nil, InvalidTLFArchiveError{
+					"the trailer doesn't match the records"}
+			}
+			return mds, nil
+		}
+	}
+}
+
+func makeTLFArchiveMD(codec kbfscodec.Codec, rmds *RootMetadataSigned,
+	extra ExtraMetadata) (*tlfArchiveMD, error) {
+	encodedRMDS, err := EncodeRootMetadataSigned(codec, rmds)
+	if err != nil {
+		return nil, err
+	}
+	amd := &tlfArchiveMD{
+		RMDS: serializedRMDS{
+			EncodedRMDS: encodedRMDS,
+			Timestamp:   rmds.untrustedServerTimestamp,
+			Version:     rmds.MD.Version(),
+		},
+	}
+	if extraV3, ok := extra.(*ExtraMetadataV3); ok {
+		wkb, rkb := extraV3.GetWriterKeyBundle(), extraV3.GetReaderKeyBundle()
+		amd.WriterKeyBundle, amd.ReaderKeyBundle = &wkb, &rkb
+	}
+	return amd, nil
+}
+
+func (amd tlfArchiveMD) decode(codec kbfscodec.Codec, id tlf.ID) (
+	*RootMetadataSigned, ExtraMetadata, error) {
+	rmds, err := DecodeRootMetadataSigned(codec, id, amd.RMDS.Version,
+		amd.RMDS.Version, amd.RMDS.EncodedRMDS, amd.RMDS.Timestamp)
+	if err != nil {
+		return nil, nil, err
+	}
+	var extra ExtraMetadata
+	if amd.WriterKeyBundle != nil && amd.ReaderKeyBundle != nil {
+		extra = NewExtraMetadataV3(
+			*amd.WriterKeyBundle, *amd.ReaderKeyBundle, false, false)
+	}
+	return rmds, extra, nil
+}
+
+// blockPtrsForArchive returns every block pointer the given MD
+// references, which, for a range starting at the first revision,
+// covers every block the TLF ever had.
+func blockPtrsForArchive(rmd ImmutableRootMetadata) []BlockPointer {
+	var ptrs []BlockPointer
+	if info := rmd.data.cachedChanges.Info; info.BlockPointer != zeroPtr {
+		ptrs = append(ptrs, info.BlockPointer)
+	}
+	for _, op := range rmd.data.Changes.Ops {
+		for _, ptr := range op.Refs() {
+			if ptr != zeroPtr {
+				ptrs = append(ptrs, ptr)
+			}
+		}
+		for _, update := range op.allUpdates() {
+			if update.Ref != zeroPtr {
+				ptrs = append(ptrs, update.Ref)
+			}
+		}
+	}
+	return ptrs
+}
+
+// ExportTLFArchive writes the merged MDs of the given TLF from start
+// to end (inclusive), and all the blocks they reference, to w as an
+// archive encrypted with key.  If end is MetadataRevisionUninitialized,
+// the range ends at the current head.  Exporting from
+// MetadataRevisionInitial gives a complete backup; later ranges give
+// incremental backups of the blocks written since.
+func ExportTLFArchive(ctx context.Context, config Config, tlfID tlf.ID,
+	start, end MetadataRevision, key TLFArchiveKey, w io.Writer) (
+	summary TLFArchiveSummary, err error) {
+	if start < MetadataRevisionInitial {
+		return TLFArchiveSummary{}, errors.Errorf(
+			"Invalid start revision %d", start)
+	}
+	if end == MetadataRevisionUninitialized {
+		head, err := config.MDOps().GetForTLF(ctx, tlfID)
+		if err != nil {
+			return TLFArchiveSummary{}, err
+		}
+		if head == (ImmutableRootMetadata{}) {
+			return TLFArchiveSummary{}, errors.Errorf(
+				"TLF %s has no revisions", tlfID)
+		}
+		end = head.Revision()
+	}
+	if end < start {
+		return TLFArchiveSummary{}, errors.Errorf(
+			"End revision %d is before start revision %d", end, start)
+	}
+	summary = TLFArchiveSummary{TlfID: tlfID, Start: start, End: end}
+
+	aw := tlfArchiveWriter{config.Codec(), MakeCryptoCommon(config.Codec()),
+		key, w}
+	err = aw.writeHeader(tlfID, start, end)
+	if err != nil {
+		return TLFArchiveSummary{}, err
+	}
+
+	// Write the MDs, and remember all the contexts of each block
+	// they reference.
+	contexts := make(map[kbfsblock.ID][]kbfsblock.Context)
+	var ids []kbfsblock.ID
+	for rev := start; rev <= end; rev += maxMDsAtATime {
+		stop := rev + maxMDsAtATime - 1
+		if stop > end {
+			stop = end
+		}
+		rmdses, err := config.MDServer().GetRange(
+			ctx, tlfID, NullBranchID, Merged, rev, stop)
+		if err != nil {
+			return TLFArchiveSummary{}, err
+		}
+		rmds, err := config.MDOps().GetRange(ctx, tlfID, rev, stop)
+		if err != nil {
+			return TLFArchiveSummary{}, err
+		}
+		if len(rmdses) != int(stop-rev+1) || len(rmds) != len(rmdses) {
+			return TLFArchiveSummary{}, errors.Errorf(
+				"Expected %d MDs from revision %d, got %d and %d",
+				stop-rev+1, rev, len(rmdses), len(rmds))
+		}
+		for i, rmd := range rmds {
+			amd, err := makeTLFArchiveMD(
+				config.Codec(), rmdses[i], rmd.Extra())
+			if err != nil {
+				return TLFArchiveSummary{}, err
+			}
+			err = aw.writeRecord(tlfArchiveRecord{MD: amd})
+			if err != nil {
+				return TLFArchiveSummary{}, err
+			}
+			summary.MDs++
+
+			for _, ptr := range blockPtrsForArchive(rmd) {
+				if _, ok := contexts[ptr.ID]; !ok {
+					ids = append(ids, ptr.ID)
+				}
+				contexts[ptr.ID] = append(contexts[ptr.ID], ptr.Context)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		buf, serverHalf, err := config.BlockServer().Get(
+			ctx, tlfID, id, contexts[id][0])
+		if isMissingBlockError(err) {
+			summary.MissingBlocks++
+			continue
+		} else if err != nil {
+			return TLFArchiveSummary{}, err
+		}
+		err = aw.writeRecord(tlfArchiveRecord{Block: &tlfArchiveBlock{
+			ID:         id,
+			Contexts:   contexts[id],
+			Buf:        buf,
+			ServerHalf: serverHalf,
+		}})
+		if err != nil {
+			return TLFArchiveSummary{}, err
+		}
+		summary.Blocks++
+	}
+
+	err = aw.writeRecord(tlfArchiveRecord{Trailer: &tlfArchiveTrailer{
+		MDs:    summary.MDs,
+		Blocks: summary.Blocks,
+	}})
+	if err != nil {
+		return TLFArchiveSummary{}, err
+	}
+	return summary, nil
+}
+
+// restoreArchivedBlock puts the given block back on the block
+// server, unless the block server still has it.
+func restoreArchivedBlock(ctx context.Context, bserver BlockServer,
+	tlfID tlf.ID, block *tlfArchiveBlock) (restored bool, err error) {
+	_, _, err = bserver.Get(ctx, tlfID, block.ID, block.Contexts[0])
+	if err == nil {
+		return false, nil
+	} else if !isMissingBlockError(err) {
+		return false, err
+	}
+
+	first := kbfsblock.MakeFirstContext(block.Contexts[0].GetCreator(),
+		block.Contexts[0].GetBlockType())
+	err = bserver.Put(
+		ctx, tlfID, block.ID, first, block.Buf, block.ServerHalf)
+	if err != nil {
+		return false, err
+	}
+	for _, context := range block.Contexts {
+		if context.GetRefNonce() == kbfsblock.ZeroRefNonce {
+			continue
+		}
+		err = bserver.AddBlockReference(ctx, tlfID, block.ID, context)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// ImportTLFArchive reads an archive written by ExportTLFArchive,
+// checks the signatures and the order of all of its MDs, and puts
+// back whatever the servers are missing: blocks the block server
+// lost, and MDs that continue on from the MD server's current head
+// (for example, when restoring into an empty local server root, where
+// the restored TLF is then known by its ID rather than its handle).
+// Restored block references are live; running a block reference
+// audit afterwards archives the ones that should be archived.
+func ImportTLFArchive(ctx context.Context, config Config,
+	key TLFArchiveKey, r io.Reader) (summary TLFArchiveSummary, err error) {
+	ar := tlfArchiveReader{config.Codec(), MakeCryptoCommon(config.Codec()),
+		key, r}
+	header, err := ar.readHeader()
+	if err != nil {
+		return TLFArchiveSummary{}, err
+	}
+	summary = TLFArchiveSummary{
+		TlfID: header.TlfID,
+		Start: header.Start,
+		End:   header.End,
+	}
+
+	mds, err := ar.readRecords(config, header,
+		func(block *tlfArchiveBlock) error {
+			restored, err := restoreArchivedBlock(
+				ctx, config.BlockServer(), header.TlfID, block)
+			if err != nil {
+				return err
+			}
+			if restored {
+				summary.RestoredBlocks++
+			}
+			summary.Blocks++
+			return nil
+		})
+	if err != nil {
+		return TLFArchiveSummary{}, err
+	}
+	summary.MDs = len(mds)
+
+	// Put the MDs after their blocks, starting right after the
+	// server's head.
+	head, err := config.MDServer().GetForTLF(
+		ctx, header.TlfID, NullBranchID, Merged)
+	if err != nil {
+		return TLFArchiveSummary{}, err
+	}
+	headRev := MetadataRevisionUninitialized
+	var wkbID TLFWriterKeyBundleID
+	var rkbID TLFReaderKeyBundleID
+	if head != nil {
+		headRev = head.MD.RevisionNumber()
+		wkbID = head.MD.GetTLFWriterKeyBundleID()
+		rkbID = head.MD.GetTLFReaderKeyBundleID()
+	}
+	for _, md := range mds {
+		rev := md.rmds.MD.RevisionNumber()
+		if rev <= headRev {
+			continue
+		}
+		if rev != headRev+1 {
+			return TLFArchiveSummary{}, errors.Errorf(
+				"The archive starts at revision %d, but the MD server's "+
+					"head is revision %d", rev, headRev)
+		}
+		// The server needs any key bundles it hasn't seen yet.
+		if extraV3, ok := md.extra.(*ExtraMetadataV3); ok {
+			extraV3.updateNew(
+				md.rmds.MD.GetTLFWriterKeyBundleID() != wkbID,
+				md.rmds.MD.GetTLFReaderKeyBundleID() != rkbID)
+		}
+		wkbID = md.rmds.MD.GetTLFWriterKeyBundleID()
+		rkbID = md.rmds.MD.GetTLFReaderKeyBundleID()
+		err = config.MDServer().Put(ctx, md.rmds, md.extra)
+		if err != nil {
+			return TLFArchiveSummary{}, err
+		}
+		headRev = rev
+		summary.RestoredMDs++
+	}
+	return summary, nil
+}
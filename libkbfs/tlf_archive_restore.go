@@ -0,0 +1,263 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// How much file data to copy at a time during a restore.
+const tlfArchiveRestoreChunkSize = 1 << 20
+
+// TLFArchiveRestoreSummary describes what a restore from a TLF
+// archive created.
+type TLFArchiveRestoreSummary struct {
+	// TlfID and Revision identify the archived folder and the
+	// revision of it that was restored.
+	TlfID    tlf.ID
+	Revision MetadataRevision
+	Dirs     int
+	Files    int
+	Symlinks int
+	Bytes    int64
+}
+
+func (s TLFArchiveRestoreSummary) String() string {
+	return fmt.Sprintf("revision %d of TLF %s: %d directories, %d files "+
+		"(%d bytes), %d symlinks", s.Revision, s.TlfID, s.Dirs, s.Files,
+		s.Bytes, s.Symlinks)
+}
+
+// tlfArchiveRestorer copies the tree of an archived revision into a
+// directory through KBFSOps.
+type tlfArchiveRestorer struct {
+	config  Config
+	log     logger.Logger
+	kmd     *RootMetadata
+	blocks  map[kbfsblock.ID]*tlfArchiveBlock
+	summary TLFArchiveRestoreSummary
+}
+
+// decryptArchivedMD decrypts the private metadata of the given
+// archived MD, with the keys of the current device.
+func decryptArchivedMD(ctx context.Context, config Config, log logger.Logger,
+	md archivedMD) (*RootMetadata, error) {
+	bareHandle, err := md.rmds.MD.MakeBareTlfHandle(md.extra)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := MakeTlfHandle(ctx, bareHandle, config.KBPKI())
+	if err != nil {
+		return nil, err
+	}
+	brmd, ok := md.rmds.MD.(MutableBareRootMetadata)
+	if !ok {
+		return nil, MutableBareRootMetadataNoImplError{}
+	}
+
+	var uid keybase1.UID
+	if !handle.IsPublic() {
+		session, err := config.KBPKI().GetCurrentSession(ctx)
+		if err != nil {
+			return nil, err
+		}
+		uid = session.UID
+	}
+
+	rmd := makeRootMetadata(brmd, md.extra, handle)
+	// Leave any unembedded block changes alone, since only the
+	// directory tree is needed, and the block server may not have
+	// them anymore.
+	pmd, err := decryptMDPrivateData(
+		ctx, config.Codec(), config.Crypto(), nil, nil,
+		config.KeyManager(), InitMinimal, uid,
+		rmd.GetSerializedPrivateMetadata(), rmd, rmd, log)
+	if err != nil {
+		return nil, err
+	}
+	rmd.data = pmd
+	return rmd, nil
+}
+
+func (r *tlfArchiveRestorer) getBlock(
+	ctx context.Context, ptr BlockPointer, block Block) error {
+	b, ok := r.blocks[ptr.ID]
+	if !ok {
+		return errors.Errorf("The archive doesn't have block %s; it "+
+			"must start at the first revision to be restored", ptr.ID)
+	}
+	return assembleBlock(ctx, r.config.KeyManager(), r.config.Codec(),
+		r.config.Crypto(), r.kmd, ptr, block, b.Buf, b.ServerHalf)
+}
+
+func (r *tlfArchiveRestorer) restoreFile(ctx context.Context,
+	name string, de DirEntry, dir Node) (Node, error) {
+	kbfsOps := r.config.KBFSOps()
+	node, _, err := kbfsOps.CreateFile(ctx, dir, name, de.Type == Exec, NoExcl)
+	if err != nil {
+		return nil, err
+	}
+
+	file := path{FolderBranch{r.kmd.TlfID(), MasterBranch},
+		[]pathNode{{de.BlockPointer, name}}}
+	getter := func(ctx context.Context, kmd KeyMetadata, ptr BlockPointer,
+		p path, rtype blockReqType) (*FileBlock, bool, error) {
+		block := NewFileBlock().(*FileBlock)
+		err := r.getBlock(ctx, ptr, block)
+		if err != nil {
+			return nil, false, err
+		}
+		return block, false, nil
+	}
+	cacher := func(ptr BlockPointer, block Block) error {
+		return nil
+	}
+	// Reading doesn't use crypto, the block splitter or the UID.
+	fd := newFileData(
+		file, keybase1.UID(""), nil, nil, r.kmd, getter, cacher, r.log)
+
+	size := int64(de.Size)
+	for off := int64(0); off < size; off += tlfArchiveRestoreChunkSize {
+		end := off + tlfArchiveRestoreChunkSize
+		if end > size {
+			end = size
+		}
+		buf, err := fd.getBytes(ctx, off, end)
+		if err != nil {
+			return nil, err
+		}
+		err = kbfsOps.Write(ctx, node, buf, off)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Sync each file, so that the dirty data of the whole tree
+	// isn't buffered at once.
+	err = kbfsOps.Sync(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	r.summary.Files++
+	r.summary.Bytes += size
+	return node, nil
+}
+
+func (r *tlfArchiveRestorer) restoreDir(
+	ctx context.Context, ptr BlockPointer, dir Node) error {
+	dblock := NewDirBlock().(*DirBlock)
+	err := r.getBlock(ctx, ptr, dblock)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(dblock.Children))
+	for name := range dblock.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	kbfsOps := r.config.KBFSOps()
+	for _, name := range names {
+		de := dblock.Children[name]
+		var node Node
+		switch de.Type {
+		case Sym:
+			_, err = kbfsOps.CreateLink(ctx, dir, name, de.SymPath)
+			if err != nil {
+				return err
+			}
+			r.summary.Symlinks++
+			continue
+		case Dir:
+			node, _, err = kbfsOps.CreateDir(ctx, dir, name)
+			if err != nil {
+				return err
+			}
+			err = r.restoreDir(ctx, de.BlockPointer, node)
+			if err != nil {
+				return err
+			}
+			r.summary.Dirs++
+		default:
+			node, err = r.restoreFile(ctx, name, de, dir)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Set the mtime last, since writing the contents changes it.
+		mtime := time.Unix(0, de.Mtime)
+		err = kbfsOps.SetMtime(ctx, node, &mtime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreTLFArchive recreates the newest revision in an archive
+// written by ExportTLFArchive inside dir, which is normally the root
+// of a new or reset TLF.  Everything is written through KBFSOps, so
+// it's encrypted under the current keys of dir's TLF, which may
+// belong to a different user than the archived one, as long as the
+// current device could read the archived revision.  The archive is
+// read into memory, and must start at the first revision unless the
+// archived revision only references blocks from its own range.
+func RestoreTLFArchive(ctx context.Context, config Config,
+	key TLFArchiveKey, r io.Reader, dir Node) (
+	TLFArchiveRestoreSummary, error) {
+	ar := tlfArchiveReader{config.Codec(), MakeCryptoCommon(config.Codec()),
+		key, r}
+	header, err := ar.readHeader()
+	if err != nil {
+		return TLFArchiveRestoreSummary{}, err
+	}
+	blocks := make(map[kbfsblock.ID]*tlfArchiveBlock)
+	mds, err := ar.readRecords(config, header,
+		func(block *tlfArchiveBlock) error {
+			blocks[block.ID] = block
+			return nil
+		})
+	if err != nil {
+		return TLFArchiveRestoreSummary{}, err
+	}
+	if len(mds) == 0 {
+		return TLFArchiveRestoreSummary{}, InvalidTLFArchiveError{
+			"there are no revisions"}
+	}
+
+	log := config.MakeLogger("")
+	head := mds[len(mds)-1]
+	kmd, err := decryptArchivedMD(ctx, config, log, head)
+	if err != nil {
+		return TLFArchiveRestoreSummary{}, err
+	}
+
+	restorer := &tlfArchiveRestorer{
+		config: config,
+		log:    log,
+		kmd:    kmd,
+		blocks: blocks,
+		summary: TLFArchiveRestoreSummary{
+			TlfID:    header.TlfID,
+			Revision: kmd.Revision(),
+		},
+	}
+	err = restorer.restoreDir(ctx, kmd.data.Dir.BlockPointer, dir)
+	if err != nil {
+		return TLFArchiveRestoreSummary{}, err
+	}
+	return restorer.summary, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLFArchiveExportImport(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte{1, 2, 3, 4, 5}, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	tlfID := rootNode.GetFolderBranch().Tlf
+
+	key, err := MakeRandomTLFArchiveKey()
+	require.NoError(t, err)
+	parsedKey, err := ParseTLFArchiveKey(key.String())
+	require.NoError(t, err)
+	require.Equal(t, key, parsedKey)
+
+	var buf bytes.Buffer
+	exported, err := ExportTLFArchive(ctx, config, tlfID,
+		MetadataRevisionInitial, MetadataRevisionUninitialized, key, &buf)
+	require.NoError(t, err)
+	require.Equal(t, MetadataRevisionInitial, exported.Start)
+	require.Equal(t, int(exported.End), exported.MDs)
+	require.True(t, exported.Blocks > 0)
+	archive := buf.Bytes()
+
+	// Restoring into the same servers doesn't need to put anything.
+	imported, err := ImportTLFArchive(
+		ctx, config, key, bytes.NewReader(archive))
+	require.NoError(t, err)
+	require.Equal(t, exported.MDs, imported.MDs)
+	require.Equal(t, exported.Blocks, imported.Blocks)
+	require.Equal(t, 0, imported.RestoredMDs)
+	require.Equal(t, 0, imported.RestoredBlocks)
+
+	// Restore into empty servers, and read the file back.
+	config2 := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	imported, err = ImportTLFArchive(
+		ctx, config2, key, bytes.NewReader(archive))
+	require.NoError(t, err)
+	require.Equal(t, exported.MDs, imported.RestoredMDs)
+	require.Equal(t, exported.Blocks, imported.RestoredBlocks)
+
+	head, err := config.MDOps().GetForTLF(ctx, tlfID)
+	require.NoError(t, err)
+	// config2 doesn't have the key server halves, so it can't
+	// decrypt the MD, but it has the same one.
+	rmds2, err := config2.MDServer().GetForTLF(
+		ctx, tlfID, NullBranchID, Merged)
+	require.NoError(t, err)
+	mdID2, err := config2.Crypto().MakeMdID(rmds2.MD)
+	require.NoError(t, err)
+	require.Equal(t, head.MdID(), mdID2)
+	ptr := head.data.Dir.BlockPointer
+	_, _, err = config2.BlockServer().Get(ctx, tlfID, ptr.ID, ptr.Context)
+	require.NoError(t, err)
+
+	// A different key can't read the archive.
+	wrongKey, err := MakeRandomTLFArchiveKey()
+	require.NoError(t, err)
+	_, err = ImportTLFArchive(ctx, config, wrongKey, bytes.NewReader(archive))
+	require.IsType(t, InvalidTLFArchiveError{}, errors.Cause(err))
+
+	// Neither can a truncated archive.
+	_, err = ImportTLFArchive(
+		ctx, config, key, bytes.NewReader(archive[:len(archive)-1]))
+	require.IsType(t, InvalidTLFArchiveError{}, errors.Cause(err))
+}
+
+func TestTLFArchiveRestore(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", false)
+	kbfsOps := config.KBFSOps()
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	data := []byte{1, 2, 3, 4, 5}
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "x", true, NoExcl)
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "l", "d/f")
+	require.NoError(t, err)
+
+	key, err := MakeRandomTLFArchiveKey()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = ExportTLFArchive(ctx, config, rootNode.GetFolderBranch().Tlf,
+		MetadataRevisionInitial, MetadataRevisionUninitialized, key, &buf)
+	require.NoError(t, err)
+
+	// Restore into a different folder.
+	pubRootNode := GetRootNodeOrBust(ctx, t, config, "alice", true)
+	summary, err := RestoreTLFArchive(
+		ctx, config, key, bytes.NewReader(buf.Bytes()), pubRootNode)
+	require.NoError(t, err)
+	require.Equal(t, 1, summary.Dirs)
+	require.Equal(t, 2, summary.Files)
+	require.Equal(t, 1, summary.Symlinks)
+	require.Equal(t, int64(len(data)), summary.Bytes)
+
+	dirNode2, _, err := kbfsOps.Lookup(ctx, pubRootNode, "d")
+	require.NoError(t, err)
+	fileNode2, _, err := kbfsOps.Lookup(ctx, dirNode2, "f")
+	require.NoError(t, err)
+	readData := make([]byte, len(data))
+	n, err := kbfsOps.Read(ctx, fileNode2, readData, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, readData)
+	_, ei, err := kbfsOps.Lookup(ctx, pubRootNode, "x")
+	require.NoError(t, err)
+	require.Equal(t, Exec, ei.Type)
+	_, ei, err = kbfsOps.Lookup(ctx, pubRootNode, "l")
+	require.NoError(t, err)
+	require.Equal(t, Sym, ei.Type)
+	require.Equal(t, "d/f", ei.SymPath)
+
+	// Restoring again collides with the restored entries.
+	_, err = RestoreTLFArchive(
+		ctx, config, key, bytes.NewReader(buf.Bytes()), pubRootNode)
+	require.Error(t, err)
+}
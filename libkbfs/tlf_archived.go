@@ -0,0 +1,204 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// ArchivedRevision is a read-only view of a TLF as of one of its
+// past merged revisions.  Like GetDirChildrenAtRevision, it reads
+// only the revision's MD and blocks, never the folder's current
+// state, so a revision can be browsed as long as quota reclamation
+// has kept its blocks.
+type ArchivedRevision struct {
+	config Config
+	log    logger.Logger
+	rmd    ImmutableRootMetadata
+}
+
+// GetRecentRevisions returns the numbers of the latest n merged
+// revisions of the given TLF, newest first.  It only looks up the
+// head, so older revisions in the list may have had their blocks
+// reclaimed already.
+func GetRecentRevisions(ctx context.Context, config Config, tlfID tlf.ID,
+	n int) ([]MetadataRevision, error) {
+	head, err := config.MDOps().GetForTLF(ctx, tlfID)
+	if err != nil {
+		return nil, err
+	}
+	if head == (ImmutableRootMetadata{}) {
+		return nil, nil
+	}
+	var revs []MetadataRevision
+	for rev := head.Revision(); rev >= MetadataRevisionInitial &&
+		len(revs) < n; rev-- {
+		revs = append(revs, rev)
+	}
+	return revs, nil
+}
+
+// GetArchivedRevision returns a view of the given merged revision of
+// the given TLF.
+func GetArchivedRevision(ctx context.Context, config Config, tlfID tlf.ID,
+	rev MetadataRevision) (*ArchivedRevision, error) {
+	rmd, err := getArchivedMD(ctx, config, tlfID, rev)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchivedRevision{config, config.MakeLogger(""), rmd}, nil
+}
+
+// getArchivedMD returns the given merged revision of the given TLF,
+// and makes sure the blocks it needs stay in the disk cache while
+// it's being read.
+func getArchivedMD(ctx context.Context, config Config, tlfID tlf.ID,
+	rev MetadataRevision) (ImmutableRootMetadata, error) {
+	rmd, err := getSingleMD(ctx, config, tlfID, NullBranchID, rev, Merged)
+	if err != nil {
+		return ImmutableRootMetadata{}, err
+	}
+	if kbfsOps, ok := config.KBFSOps().(*KBFSOpsStandard); ok {
+		kbfsOps.noteRevisionViewed(tlfID, rev)
+	}
+	return rmd, nil
+}
+
+// Revision returns the number of the revision.
+func (ar *ArchivedRevision) Revision() MetadataRevision {
+	return ar.rmd.Revision()
+}
+
+// Time returns when the revision was made, as far as this device
+// knows.
+func (ar *ArchivedRevision) Time() time.Time {
+	return ar.rmd.LocalTimestamp()
+}
+
+// lookup returns the entry at the given path, relative to the root
+// of the TLF.  An empty path is the root itself.
+func (ar *ArchivedRevision) lookup(ctx context.Context,
+	components []string) (DirEntry, error) {
+	if kbfsOps, ok := ar.config.KBFSOps().(*KBFSOpsStandard); ok {
+		kbfsOps.noteRevisionViewed(ar.rmd.TlfID(), ar.rmd.Revision())
+	}
+	if len(components) == 0 {
+		return ar.rmd.data.Dir, nil
+	}
+	last := len(components) - 1
+	dblock, err := getDirBlockAtRevision(
+		ctx, ar.config, ar.rmd, components[:last])
+	if err != nil {
+		return DirEntry{}, err
+	}
+	de, ok := dblock.Children[components[last]]
+	if !ok {
+		return DirEntry{}, NoSuchNameError{components[last]}
+	}
+	return de, nil
+}
+
+// Lookup returns the entry at the given path, relative to the root of
+// the TLF, as of the revision.
+func (ar *ArchivedRevision) Lookup(ctx context.Context,
+	components []string) (EntryInfo, error) {
+	de, err := ar.lookup(ctx, components)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	return de.EntryInfo, nil
+}
+
+// GetDirChildren returns the entries of the directory at the given
+// path, relative to the root of the TLF, as of the revision.
+func (ar *ArchivedRevision) GetDirChildren(ctx context.Context,
+	components []string) (map[string]EntryInfo, error) {
+	dblock, err := getDirBlockAtRevision(ctx, ar.config, ar.rmd, components)
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[string]EntryInfo, len(dblock.Children))
+	for name, de := range dblock.Children {
+		children[name] = de.EntryInfo
+	}
+	return children, nil
+}
+
+// ArchivedFile is a file as of a past revision of a TLF.
+type ArchivedFile struct {
+	de DirEntry
+	fd *fileData
+}
+
+// newFileDataAtRevision returns a fileData for reading the file
+// described by de, as of kmd.  Nothing read through it is cached.
+func newFileDataAtRevision(config Config, log logger.Logger,
+	kmd ImmutableRootMetadata, de DirEntry, name string) *fileData {
+	file := path{FolderBranch{kmd.TlfID(), MasterBranch},
+		[]pathNode{{de.BlockPointer, name}}}
+	getter := func(ctx context.Context, kmd KeyMetadata, ptr BlockPointer,
+		p path, rtype blockReqType) (*FileBlock, bool, error) {
+		block := NewFileBlock().(*FileBlock)
+		err := config.BlockOps().Get(ctx, kmd, ptr, block, TransientEntry)
+		if err != nil {
+			return nil, false, err
+		}
+		return block, false, nil
+	}
+	cacher := func(ptr BlockPointer, block Block) error {
+		return nil
+	}
+	// Reading doesn't use crypto, the block splitter or the UID.
+	return newFileData(
+		file, keybase1.UID(""), nil, nil, kmd, getter, cacher, log)
+}
+
+// OpenFile returns the file at the given path, relative to the root
+// of the TLF, as of the revision.
+func (ar *ArchivedRevision) OpenFile(ctx context.Context,
+	components []string) (*ArchivedFile, error) {
+	de, err := ar.lookup(ctx, components)
+	if err != nil {
+		return nil, err
+	}
+	if de.Type != File && de.Type != Exec {
+		return nil, NotFileError{path{FolderBranch{ar.rmd.TlfID(),
+			MasterBranch}, []pathNode{{de.BlockPointer,
+			components[len(components)-1]}}}}
+	}
+	fd := newFileDataAtRevision(ar.config, ar.log, ar.rmd, de,
+		components[len(components)-1])
+	return &ArchivedFile{de, fd}, nil
+}
+
+// EntryInfo returns the file's entry as of its revision.
+func (af *ArchivedFile) EntryInfo() EntryInfo {
+	return af.de.EntryInfo
+}
+
+// Read reads up to len(dest) bytes of the file, starting at off, and
+// returns how many it read.  Reading at or past the end of the file
+// returns 0.
+func (af *ArchivedFile) Read(ctx context.Context, dest []byte, off int64) (
+	int64, error) {
+	size := int64(af.de.Size)
+	if off >= size {
+		return 0, nil
+	}
+	end := off + int64(len(dest))
+	if end > size {
+		end = size
+	}
+	buf, err := af.fd.getBytes(ctx, off, end)
+	if err != nil {
+		return 0, err
+	}
+	return int64(copy(dest, buf)), nil
+}
@@ -0,0 +1,392 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// A TLF audit log is a sequence of JSON lines, each holding either a
+// TLFAuditRecord or a TLFAuditSeal, along with the hash of the line
+// before it.  Every export ends with a seal that covers the revisions
+// it exported, signed by the exporting device.  A later export of
+// the revisions after those appends to the same log, so a verified
+// log can't have had any of its lines changed, removed or reordered
+// without breaking the hash chain or a signature.
+
+// TLFAuditRecord is one operation in the history of a TLF.
+type TLFAuditRecord struct {
+	Revision MetadataRevision
+	// Time is when the revision was written, according to the
+	// server's clock.
+	Time       time.Time
+	Writer     keybase1.UID
+	WriterName libkb.NormalizedUsername
+	// Device is the verifying key of the device that wrote the
+	// revision.
+	Device kbfscrypto.VerifyingKey
+	Op     string
+	// Path is relative to the root of the TLF, and OldPath is the
+	// old path of a renamed entry.  They're empty for operations
+	// that don't act on a path, or whose directory is no longer
+	// readable, e.g. because quota reclamation deleted its blocks.
+	Path    string `json:",omitempty"`
+	OldPath string `json:",omitempty"`
+}
+
+// TLFAuditSeal ends the part of a TLF audit log exported from the
+// revisions Start to End (inclusive).
+type TLFAuditSeal struct {
+	TlfID     tlf.ID
+	Start     MetadataRevision
+	End       MetadataRevision
+	Signature kbfscrypto.SignatureInfo
+}
+
+func (s TLFAuditSeal) signedMsg(prevHash string) []byte {
+	return []byte(fmt.Sprintf("Keybase KBFS audit log seal: %s %d %d %s",
+		s.TlfID, s.Start, s.End, prevHash))
+}
+
+type tlfAuditLine struct {
+	PrevHash string
+	Record   *TLFAuditRecord `json:",omitempty"`
+	Seal     *TLFAuditSeal   `json:",omitempty"`
+}
+
+// TLFAuditLogHead is where a TLF audit log ends, which is where the
+// next export to it has to start from.  The zero value starts a new
+// log.
+type TLFAuditLogHead struct {
+	TlfID tlf.ID
+	// Revision is the last revision in the log.
+	Revision MetadataRevision
+	// Hash is the hash of the last line of the log.
+	Hash string
+}
+
+func hashTLFAuditLine(line []byte) string {
+	hash := sha256.Sum256(line)
+	return hex.EncodeToString(hash[:])
+}
+
+type tlfAuditLogWriter struct {
+	w    io.Writer
+	hash string
+}
+
+func (aw *tlfAuditLogWriter) writeLine(line tlfAuditLine) error {
+	line.PrevHash = aw.hash
+	buf, err := json.Marshal(line)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	aw.hash = hashTLFAuditLine(buf)
+	_, err = aw.w.Write(append(buf, '\n'))
+	return errors.WithStack(err)
+}
+
+// auditPathResolver finds the paths of the entries that the ops of
+// one revision touched.
+type auditPathResolver struct {
+	config Config
+	rmd    ImmutableRootMetadata
+	paths  map[BlockPointer]string
+}
+
+// resolve walks the directories that the revision changed, and
+// records the paths of every updated entry in them.  Blocks are
+// never modified in place, so only those directories can hold the
+// entries that the ops refer to.
+func (r *auditPathResolver) resolve(ctx context.Context) error {
+	updated := make(map[BlockPointer]bool)
+	for _, op := range r.rmd.data.Changes.Ops {
+		for _, update := range op.allUpdates() {
+			updated[update.Ref] = true
+		}
+	}
+	root := r.rmd.data.Dir.BlockPointer
+	r.paths = map[BlockPointer]string{root: ""}
+	return r.walk(ctx, root, "", updated)
+}
+
+func (r *auditPathResolver) walk(ctx context.Context, ptr BlockPointer,
+	p string, updated map[BlockPointer]bool) error {
+	dblock := NewDirBlock().(*DirBlock)
+	err := r.config.BlockOps().Get(ctx, r.rmd, ptr, dblock, TransientEntry)
+	if isRecoverableBlockError(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for name, de := range dblock.Children {
+		if !updated[de.BlockPointer] {
+			continue
+		}
+		childPath := name
+		if p != "" {
+			childPath = p + "/" + name
+		}
+		r.paths[de.BlockPointer] = childPath
+		if de.Type == Dir {
+			err := r.walk(ctx, de.BlockPointer, childPath, updated)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// childPath returns the path of the given entry in the directory
+// with the given pointer, or "" if the directory wasn't found.
+func (r *auditPathResolver) childPath(dir BlockPointer, name string) string {
+	p, ok := r.paths[dir]
+	if !ok {
+		return ""
+	}
+	if p == "" {
+		return name
+	}
+	return p + "/" + name
+}
+
+// record fills in the op and paths of rec from the given op.
+func (r *auditPathResolver) record(o op, rec *TLFAuditRecord) {
+	switch realOp := o.(type) {
+	case *createOp:
+		switch realOp.Type {
+		case Dir:
+			rec.Op = "mkdir"
+		case Sym:
+			rec.Op = "symlink"
+		default:
+			rec.Op = "create"
+		}
+		rec.Path = r.childPath(realOp.Dir.Ref, realOp.NewName)
+	case *rmOp:
+		rec.Op = "remove"
+		rec.Path = r.childPath(realOp.Dir.Ref, realOp.OldName)
+	case *renameOp:
+		rec.Op = "rename"
+		rec.OldPath = r.childPath(realOp.OldDir.Ref, realOp.OldName)
+		newDir := realOp.NewDir.Ref
+		if newDir == zeroPtr {
+			newDir = realOp.OldDir.Ref
+		}
+		rec.Path = r.childPath(newDir, realOp.NewName)
+	case *syncOp:
+		rec.Op = "write"
+		rec.Path = r.paths[realOp.File.Ref]
+	case *setAttrOp:
+		rec.Op = "setattr " + realOp.Attr.String()
+		rec.Path = r.childPath(realOp.Dir.Ref, realOp.Name)
+	default:
+		rec.Op = o.String()
+	}
+}
+
+// ExportTLFAuditLog appends to w a record of every operation in the
+// merged revisions of the given TLF from start to end (inclusive),
+// followed by a seal signed by the current device.  If end is
+// MetadataRevisionUninitialized, the range ends at the current head.
+// To append to an existing log, pass the head returned by
+// VerifyTLFAuditLog for it, and start from the revision after it.
+// It returns the new head of the log.
+func ExportTLFAuditLog(ctx context.Context, config Config, tlfID tlf.ID,
+	start, end MetadataRevision, prev TLFAuditLogHead, w io.Writer) (
+	TLFAuditLogHead, error) {
+	if prev != (TLFAuditLogHead{}) {
+		if prev.TlfID != tlfID {
+			return TLFAuditLogHead{}, errors.Errorf(
+				"Audit log is for TLF %s, not %s", prev.TlfID, tlfID)
+		}
+		if start != prev.Revision+1 {
+			return TLFAuditLogHead{}, errors.Errorf(
+				"Audit log ends at revision %d, so it can't be "+
+					"continued from revision %d", prev.Revision, start)
+		}
+	}
+	if start < MetadataRevisionInitial {
+		return TLFAuditLogHead{}, errors.Errorf(
+			"Invalid start revision %d", start)
+	}
+	if end == MetadataRevisionUninitialized {
+		head, err := config.MDOps().GetForTLF(ctx, tlfID)
+		if err != nil {
+			return TLFAuditLogHead{}, err
+		}
+		if head == (ImmutableRootMetadata{}) {
+			return TLFAuditLogHead{}, errors.Errorf(
+				"TLF %s has no revisions", tlfID)
+		}
+		end = head.Revision()
+	}
+	if end < start {
+		return TLFAuditLogHead{}, errors.Errorf(
+			"End revision %d is before start revision %d", end, start)
+	}
+
+	aw := &tlfAuditLogWriter{w: w, hash: prev.Hash}
+	names := make(map[keybase1.UID]libkb.NormalizedUsername)
+	for rev := start; rev <= end; rev += maxMDsAtATime {
+		stop := rev + maxMDsAtATime - 1
+		if stop > end {
+			stop = end
+		}
+		rmds, err := config.MDOps().GetRange(ctx, tlfID, rev, stop)
+		if err != nil {
+			return TLFAuditLogHead{}, err
+		}
+		if len(rmds) != int(stop-rev+1) {
+			return TLFAuditLogHead{}, errors.Errorf(
+				"Expected %d MDs from revision %d, got %d",
+				stop-rev+1, rev, len(rmds))
+		}
+		for _, rmd := range rmds {
+			writer := rmd.LastModifyingWriter()
+			name, ok := names[writer]
+			if !ok {
+				name, err = config.KBPKI().GetNormalizedUsername(ctx, writer)
+				if err != nil {
+					return TLFAuditLogHead{}, err
+				}
+				names[writer] = name
+			}
+
+			r := &auditPathResolver{config: config, rmd: rmd}
+			err := r.resolve(ctx)
+			if err != nil {
+				return TLFAuditLogHead{}, err
+			}
+			for _, o := range rmd.data.Changes.Ops {
+				rec := TLFAuditRecord{
+					Revision:   rmd.Revision(),
+					Time:       rmd.LocalTimestamp(),
+					Writer:     writer,
+					WriterName: name,
+					Device:     rmd.LastModifyingWriterVerifyingKey(),
+				}
+				r.record(o, &rec)
+				err = aw.writeLine(tlfAuditLine{Record: &rec})
+				if err != nil {
+					return TLFAuditLogHead{}, err
+				}
+			}
+		}
+	}
+
+	seal := TLFAuditSeal{TlfID: tlfID, Start: start, End: end}
+	sigInfo, err := config.Crypto().SignForKBFS(ctx, seal.signedMsg(aw.hash))
+	if err != nil {
+		return TLFAuditLogHead{}, err
+	}
+	seal.Signature = sigInfo
+	err = aw.writeLine(tlfAuditLine{Seal: &seal})
+	if err != nil {
+		return TLFAuditLogHead{}, err
+	}
+	return TLFAuditLogHead{TlfID: tlfID, Revision: end, Hash: aw.hash}, nil
+}
+
+// VerifyTLFAuditLog checks that the audit log read from r is intact:
+// that its hash chain is unbroken, that each seal's signature is
+// valid, and that the sealed parts cover consecutive revision ranges
+// with nothing after the last seal.  It returns the head of the log
+// and the keys that signed its seals, in order.  It doesn't check
+// which users the keys belong to.
+func VerifyTLFAuditLog(r io.Reader) (
+	head TLFAuditLogHead, signers []kbfscrypto.VerifyingKey, err error) {
+	br := bufio.NewReader(r)
+	// The revisions of the records since the last seal.
+	unsealed := 0
+	var first, last MetadataRevision
+	for lineNum := 1; ; lineNum++ {
+		buf, err := br.ReadBytes('\n')
+		if err == io.EOF && len(buf) == 0 {
+			break
+		} else if err == io.EOF {
+			return TLFAuditLogHead{}, nil, errors.Errorf(
+				"Audit log line %d is truncated", lineNum)
+		} else if err != nil {
+			return TLFAuditLogHead{}, nil, errors.WithStack(err)
+		}
+		buf = bytes.TrimSuffix(buf, []byte{'\n'})
+
+		var line tlfAuditLine
+		err = json.Unmarshal(buf, &line)
+		if err != nil {
+			return TLFAuditLogHead{}, nil, errors.Wrapf(
+				err, "Audit log line %d", lineNum)
+		}
+		if line.PrevHash != head.Hash {
+			return TLFAuditLogHead{}, nil, errors.Errorf(
+				"Audit log line %d doesn't follow the line before it",
+				lineNum)
+		}
+
+		switch {
+		case line.Record != nil && line.Seal == nil:
+			rev := line.Record.Revision
+			if rev <= head.Revision || rev < last {
+				return TLFAuditLogHead{}, nil, errors.Errorf(
+					"Audit log line %d is out of order, at revision %d",
+					lineNum, rev)
+			}
+			if unsealed == 0 {
+				first = rev
+			}
+			last = rev
+			unsealed++
+		case line.Seal != nil && line.Record == nil:
+			seal := *line.Seal
+			if head.TlfID != (tlf.ID{}) && seal.TlfID != head.TlfID {
+				return TLFAuditLogHead{}, nil, errors.Errorf(
+					"Audit log line %d seals TLF %s instead of %s",
+					lineNum, seal.TlfID, head.TlfID)
+			}
+			if (head.Revision != 0 && seal.Start != head.Revision+1) ||
+				seal.End < seal.Start ||
+				(unsealed > 0 && (first < seal.Start || last > seal.End)) {
+				return TLFAuditLogHead{}, nil, errors.Errorf(
+					"Audit log line %d seals revisions %d to %d, after "+
+						"revision %d", lineNum, seal.Start, seal.End,
+					head.Revision)
+			}
+			err = kbfscrypto.Verify(seal.signedMsg(head.Hash), seal.Signature)
+			if err != nil {
+				return TLFAuditLogHead{}, nil, errors.Wrapf(
+					err, "Audit log line %d", lineNum)
+			}
+			head.TlfID = seal.TlfID
+			head.Revision = seal.End
+			signers = append(signers, seal.Signature.VerifyingKey)
+			unsealed = 0
+		default:
+			return TLFAuditLogHead{}, nil, errors.Errorf(
+				"Audit log line %d is neither a record nor a seal", lineNum)
+		}
+		head.Hash = hashTLFAuditLine(buf)
+	}
+	if unsealed > 0 {
+		return TLFAuditLogHead{}, nil, errors.Errorf(
+			"Audit log ends with %d unsealed records", unsealed)
+	}
+	return head, signers, nil
+}
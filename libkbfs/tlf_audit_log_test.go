@@ -0,0 +1,103 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLFAuditLog(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", false)
+	kbfsOps := config.KBFSOps()
+	tlfID := rootNode.GetFolderBranch().Tlf
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	head, err := ExportTLFAuditLog(ctx, config, tlfID,
+		MetadataRevisionInitial, MetadataRevisionUninitialized,
+		TLFAuditLogHead{}, &buf)
+	require.NoError(t, err)
+	verified, signers, err := VerifyTLFAuditLog(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, head, verified)
+	require.Len(t, signers, 1)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	require.Equal(t, session.VerifyingKey, signers[0])
+
+	// Append the next revisions to the same log.
+	err = kbfsOps.Rename(ctx, dirNode, "a", rootNode, "b")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveEntry(ctx, rootNode, "b")
+	require.NoError(t, err)
+	head2, err := ExportTLFAuditLog(ctx, config, tlfID,
+		head.Revision+1, MetadataRevisionUninitialized, head, &buf)
+	require.NoError(t, err)
+	require.Equal(t, head.Revision+2, head2.Revision)
+	verified, signers, err = VerifyTLFAuditLog(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, head2, verified)
+	require.Len(t, signers, 2)
+
+	_, err = ExportTLFAuditLog(ctx, config, tlfID,
+		head.Revision, MetadataRevisionUninitialized, head2, &bytes.Buffer{})
+	require.Error(t, err)
+
+	// Check the records, skipping the initial revision.
+	var records []TLFAuditRecord
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}),
+		[]byte{'\n'})
+	for _, line := range lines {
+		var l tlfAuditLine
+		err := json.Unmarshal(line, &l)
+		require.NoError(t, err)
+		if l.Record != nil && l.Record.Revision > MetadataRevisionInitial {
+			records = append(records, *l.Record)
+		}
+	}
+	type opAndPaths struct {
+		op, path, oldPath string
+	}
+	var ops []opAndPaths
+	for _, r := range records {
+		require.Equal(t, session.UID, r.Writer)
+		require.Equal(t, libkb.NormalizedUsername("alice"), r.WriterName)
+		require.Equal(t, session.VerifyingKey, r.Device)
+		require.False(t, r.Time.IsZero())
+		ops = append(ops, opAndPaths{r.Op, r.Path, r.OldPath})
+	}
+	require.Equal(t, []opAndPaths{
+		{"mkdir", "d", ""},
+		{"create", "d/a", ""},
+		{"write", "d/a", ""},
+		{"rename", "b", "d/a"},
+		{"remove", "b", ""},
+	}, ops)
+
+	// Changing any line breaks the log.
+	tampered := bytes.Replace(buf.Bytes(), []byte(`"d/a"`), []byte(`"d/x"`), 1)
+	_, _, err = VerifyTLFAuditLog(bytes.NewReader(tampered))
+	require.Error(t, err)
+	// So does dropping the last seal.
+	truncated := bytes.Join(lines[:len(lines)-1], []byte{'\n'})
+	truncated = append(truncated, '\n')
+	_, _, err = VerifyTLFAuditLog(bytes.NewReader(truncated))
+	require.Error(t, err)
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// FileChunk is a range of a file's data that is stored in a single
+// block.  It is suitable for encoding directly as JSON.
+type FileChunk struct {
+	Offset int64
+	Length int64
+	// Hash identifies the contents of the chunk: it's the ID of
+	// the block holding them.  Writes to a file only replace the
+	// blocks they touch, so comparing the hashes of the chunks of
+	// two revisions of a file finds the ranges that changed between
+	// them.  Chunks whose hashes differ may still have the same
+	// contents, if they were rewritten with the same data.
+	Hash kbfsblock.ID
+}
+
+// Chunks returns the chunks making up the file's data, in order.
+func (af *ArchivedFile) Chunks(ctx context.Context) ([]FileChunk, error) {
+	size := int64(af.de.Size)
+	if size == 0 {
+		return nil, nil
+	}
+	topBlock, _, err := af.fd.getter(ctx, af.fd.kmd,
+		af.fd.rootBlockPointer(), af.fd.file, blockRead)
+	if err != nil {
+		return nil, err
+	}
+	if !topBlock.IsInd {
+		return []FileChunk{{0, size, af.de.ID}}, nil
+	}
+
+	pfr, err := af.fd.getIndirectBlocksForOffsetRange(ctx, topBlock, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]FileChunk, 0, len(pfr))
+	for _, p := range pfr {
+		iptr := p[len(p)-1].childIPtr()
+		if iptr.Off >= size {
+			break
+		}
+		if len(chunks) > 0 {
+			last := &chunks[len(chunks)-1]
+			last.Length = iptr.Off - last.Offset
+		}
+		chunks = append(chunks, FileChunk{Offset: iptr.Off, Hash: iptr.ID})
+	}
+	if len(chunks) > 0 {
+		last := &chunks[len(chunks)-1]
+		last.Length = size - last.Offset
+	}
+	return chunks, nil
+}
+
+// GetTLFChangesSince returns the entries of the given TLF that differ
+// between the given merged revision and the latest one, which is the
+// diff's NewRevision.  Backup tools can use it to back up only what
+// changed since their last run, along with the chunks of the modified
+// files.
+func GetTLFChangesSince(ctx context.Context, config Config, tlfID tlf.ID,
+	rev MetadataRevision) (TLFRevisionDiff, error) {
+	head, err := config.MDOps().GetForTLF(ctx, tlfID)
+	if err != nil {
+		return TLFRevisionDiff{}, err
+	}
+	if head == (ImmutableRootMetadata{}) {
+		return TLFRevisionDiff{}, NoSuchTlfHandleError{tlfID}
+	}
+	return DiffTLFRevisions(ctx, config, tlfID, rev, head.Revision())
+}
@@ -464,6 +464,8 @@ func (teh *TlfEditHistory) updateHistory(ctx context.Context,
 	teh.log.CDebugf(ctx, "Processing %d MDs for notifications "+
 		"(most recent revision: %d)", len(rmds), rmds[len(rmds)-1].Revision())
 
+	teh.streamFSEvents(ctx, rmds)
+
 	currEdits := teh.getEditsCopy()
 	if currEdits == nil {
 		teh.log.CDebugf(ctx, "No history to update; ignoring")
@@ -87,6 +87,18 @@ const (
 	// Maximum number of blocks to delete from the local saved block
 	// journal at a time while holding the lock.
 	maxSavedBlockRemovalsAtATime = uint64(500)
+	// flushConflictCheckBytesDefault is the minimum number of
+	// unflushed block bytes in the journal for which a flush first
+	// checks whether the server's MD head has diverged, before
+	// uploading blocks that conflict resolution would supersede.
+	flushConflictCheckBytesDefault = int64(10 << 20) // 10 MB
+	// How often an idle journal with unflushed entries flushes a
+	// single batch, when nothing else has triggered a flush (for
+	// example, after a failing flush has given up retrying).
+	journalTrickleFlushInterval = 1 * time.Minute
+	// How long a journal must go without new writes before it starts
+	// trickle flushing.
+	journalTrickleFlushIdleTime = 5 * time.Minute
 )
 
 // TLFJournalStatus represents the status of a TLF's journal for
@@ -126,6 +138,9 @@ type tlfJournalPauseType int
 const (
 	journalPauseConflict tlfJournalPauseType = 1 << iota
 	journalPauseCommand
+	// journalPauseAll is for pauses of every journal at once, which
+	// shouldn't undo or be undone by pauses of a single journal.
+	journalPauseAll
 )
 
 func (bws TLFJournalBackgroundWorkStatus) String() string {
@@ -191,12 +206,20 @@ type tlfJournal struct {
 	onBranchChange      branchChangeListener
 	onMDFlush           mdFlushListener
 	forcedSquashByBytes uint64
+	conflictCheckBytes  int64
+
+	trickleFlushInterval time.Duration
+	trickleFlushIdleTime time.Duration
 
 	// Invariant: this tlfJournal acquires exactly
 	// blockJournal.getStoredBytes() and
 	// blockJournal.getStoredFiles() until shutdown.
 	diskLimiter DiskLimiter
 
+	// Shared with the other journals of the same journal server,
+	// to limit how many of them flush at once.
+	flushScheduler *journalFlushScheduler
+
 	// All the channels below are used as simple on/off
 	// signals. They're buffered for one object, and all sends are
 	// asynchronous, so multiple sends get collapsed into one
@@ -206,6 +229,7 @@ type tlfJournal struct {
 	needResumeCh      chan struct{}
 	needShutdownCh    chan struct{}
 	needBranchCheckCh chan struct{}
+	needTrickleCh     chan struct{}
 
 	// Track the ways in which the journal is paused.  We don't allow
 	// work to resume unless a resume has come in corresponding to
@@ -238,6 +262,14 @@ type tlfJournal struct {
 	// squash.
 	unsquashedBytes uint64
 	flushingBlocks  map[kbfsblock.ID]bool
+	// The MD journal's stored bytes, as last reported to the disk
+	// limiter.
+	reportedMDBytes int64
+	// The last time a block or MD was put into the journal.
+	lastWriteTime time.Time
+	// Whether the network is metered, in which case the journal
+	// doesn't trickle flush.
+	networkMetered bool
 
 	bwDelegate tlfJournalBWDelegate
 }
@@ -277,8 +309,8 @@ func makeTLFJournal(
 	dir string, tlfID tlf.ID, config tlfJournalConfig,
 	delegateBlockServer BlockServer, bws TLFJournalBackgroundWorkStatus,
 	bwDelegate tlfJournalBWDelegate, onBranchChange branchChangeListener,
-	onMDFlush mdFlushListener, diskLimiter DiskLimiter) (
-	*tlfJournal, error) {
+	onMDFlush mdFlushListener, diskLimiter DiskLimiter,
+	flushScheduler *journalFlushScheduler) (*tlfJournal, error) {
 	if uid == keybase1.UID("") {
 		return nil, errors.New("Empty user")
 	}
@@ -323,6 +355,11 @@ func makeTLFJournal(
 
 	log := config.MakeLogger("TLFJ")
 
+	_, err = verifyTLFJournalEntries(ctx, config.Codec(), dir, log)
+	if err != nil {
+		return nil, err
+	}
+
 	blockJournal, err := makeBlockJournal(ctx, config.Codec(), dir, log)
 	if err != nil {
 		return nil, err
@@ -347,16 +384,22 @@ func makeTLFJournal(
 		onBranchChange:       onBranchChange,
 		onMDFlush:            onMDFlush,
 		forcedSquashByBytes:  ForcedBranchSquashBytesThresholdDefault,
+		conflictCheckBytes:   flushConflictCheckBytesDefault,
+		trickleFlushInterval: journalTrickleFlushInterval,
+		trickleFlushIdleTime: journalTrickleFlushIdleTime,
 		diskLimiter:          diskLimiter,
+		flushScheduler:       flushScheduler,
 		hasWorkCh:            make(chan struct{}, 1),
 		needPauseCh:          make(chan struct{}, 1),
 		needResumeCh:         make(chan struct{}, 1),
 		needShutdownCh:       make(chan struct{}, 1),
 		needBranchCheckCh:    make(chan struct{}, 1),
+		needTrickleCh:        make(chan struct{}, 1),
 		backgroundShutdownCh: make(chan struct{}),
 		blockJournal:         blockJournal,
 		mdJournal:            mdJournal,
 		flushingBlocks:       make(map[kbfsblock.ID]bool),
+		lastWriteTime:        config.Clock().Now(),
 		bwDelegate:           bwDelegate,
 	}
 
@@ -385,6 +428,7 @@ func makeTLFJournal(
 	storedFiles := j.blockJournal.getStoredFiles()
 	availableBytes, availableFiles := j.diskLimiter.onJournalEnable(
 		ctx, storedBytes, storedFiles)
+	j.reportMDBytesLocked(ctx)
 
 	go j.doBackgroundWorkLoop(bws, backoff.NewExponentialBackOff())
 
@@ -397,6 +441,17 @@ func makeTLFJournal(
 	return j, nil
 }
 
+// reportMDBytesLocked tells the disk limiter about any change in the
+// bytes stored by the MD journal since the last report.
+func (j *tlfJournal) reportMDBytesLocked(ctx context.Context) {
+	mdBytes := j.mdJournal.getStoredBytes()
+	if mdBytes == j.reportedMDBytes {
+		return
+	}
+	j.diskLimiter.onJournalMDBytesChange(ctx, j.reportedMDBytes, mdBytes)
+	j.reportedMDBytes = mdBytes
+}
+
 func (j *tlfJournal) signalWork() {
 	j.wg.Add(1)
 	select {
@@ -406,6 +461,40 @@ func (j *tlfJournal) signalWork() {
 	}
 }
 
+func (j *tlfJournal) signalTrickle() {
+	select {
+	case j.needTrickleCh <- struct{}{}:
+	default:
+	}
+}
+
+func (j *tlfJournal) hasUnflushedEntries() bool {
+	blockEntryCount, mdEntryCount, err := j.getJournalEntryCounts()
+	return err == nil && (blockEntryCount > 0 || mdEntryCount > 0)
+}
+
+// canTrickleFlush returns whether the journal has unflushed entries,
+// but hasn't been written to in a while, and the network isn't
+// metered.
+func (j *tlfJournal) canTrickleFlush() bool {
+	j.journalLock.RLock()
+	defer j.journalLock.RUnlock()
+	if j.checkEnabledLocked() != nil || j.networkMetered {
+		return false
+	}
+	if j.config.Clock().Now().Sub(j.lastWriteTime) <
+		j.trickleFlushIdleTime {
+		return false
+	}
+	return j.blockJournal.length() > 0 || j.mdJournal.length() > 0
+}
+
+func (j *tlfJournal) setNetworkMetered(metered bool) {
+	j.journalLock.Lock()
+	defer j.journalLock.Unlock()
+	j.networkMetered = metered
+}
+
 // CtxJournalTagKey is the type used for unique context tags within
 // background journal work.
 type CtxJournalTagKey int
@@ -432,7 +521,10 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 	}
 
 	// Non-nil when a retry has been scheduled for the future.
-	var retryTimer *time.Timer
+	var retryTimer Timer
+	// Non-nil when a trickle flush has been scheduled for the
+	// future.
+	var trickleTimer Timer
 	defer func() {
 		close(j.backgroundShutdownCh)
 		if j.bwDelegate != nil {
@@ -441,11 +533,15 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 		if retryTimer != nil {
 			retryTimer.Stop()
 		}
+		if trickleTimer != nil {
+			trickleTimer.Stop()
+		}
 	}()
 
 	// Below we have a state machine with three states:
 	//
-	// 1) Idle, where we wait for new work or to be paused;
+	// 1) Idle, where we wait for new work, a trickle flush, or to
+	//    be paused;
 	// 2) Busy, where we wait for the worker goroutine to
 	//    finish, or to be paused;
 	// 3) Paused, where we wait to be resumed.
@@ -478,6 +574,14 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 			if j.bwDelegate != nil {
 				j.bwDelegate.OnNewState(ctx, bwIdle)
 			}
+			// Without a pending retry, nothing else will flush
+			// a backlog until the next write, so drain it slowly
+			// in the meantime.
+			if retryTimer == nil && trickleTimer == nil &&
+				j.hasUnflushedEntries() {
+				trickleTimer = j.config.Clock().AfterFunc(
+					j.trickleFlushInterval, j.signalTrickle)
+			}
 			j.log.CDebugf(
 				ctx, "Waiting for the work signal for %s",
 				j.tlfID)
@@ -489,7 +593,20 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 					retryTimer = nil
 				}
 				bwCtx, cancel := context.WithCancel(ctx)
-				errCh = j.doBackgroundWork(bwCtx)
+				errCh = j.doBackgroundWork(bwCtx, 0)
+				bwCancel = cancel
+
+			case <-j.needTrickleCh:
+				trickleTimer = nil
+				if !j.canTrickleFlush() {
+					j.log.CDebugf(ctx,
+						"Not trickle flushing %s yet", j.tlfID)
+					break
+				}
+				j.log.CDebugf(ctx, "Trickle flushing %s", j.tlfID)
+				j.wg.Add(1)
+				bwCtx, cancel := context.WithCancel(ctx)
+				errCh = j.doBackgroundWork(bwCtx, 1)
 				bwCancel = cancel
 
 			case <-j.needPauseCh:
@@ -518,7 +635,15 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 					panic("Retry timer should be nil after work is done")
 				}
 
-				if err != nil {
+				_, corrupt := errors.Cause(err).(JournalEntryCorruptError)
+				if corrupt {
+					// Retrying would only read the same
+					// corrupt entry again.
+					j.log.CErrorf(ctx,
+						"Not retrying background work for %s, "+
+							"since its journal is corrupt: %+v",
+						j.tlfID, err)
+				} else if err != nil {
 					j.log.CWarningf(ctx,
 						"Background work error for %s: %+v",
 						j.tlfID, err)
@@ -526,7 +651,8 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 					bTime := retry.NextBackOff()
 					if bTime != backoff.Stop {
 						j.log.CWarningf(ctx, "Retrying in %s", bTime)
-						retryTimer = time.AfterFunc(bTime, j.signalWork)
+						retryTimer = j.config.Clock().AfterFunc(
+							bTime, j.signalWork)
 					}
 				} else {
 					retry.Reset()
@@ -589,16 +715,18 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 	}
 }
 
-// doBackgroundWork currently only does auto-flushing. It assumes that
-// ctx is canceled when the background processing should stop.
+// doBackgroundWork currently only does auto-flushing, of at most
+// maxBatches batches, or everything if maxBatches is 0. It assumes
+// that ctx is canceled when the background processing should stop.
 //
 // TODO: Handle garbage collection too.
-func (j *tlfJournal) doBackgroundWork(ctx context.Context) <-chan error {
+func (j *tlfJournal) doBackgroundWork(
+	ctx context.Context, maxBatches int) <-chan error {
 	errCh := make(chan error, 1)
 	// TODO: Handle panics.
 	go func() {
 		defer j.wg.Done()
-		errCh <- j.flush(ctx)
+		errCh <- j.flushBatches(ctx, maxBatches)
 		close(errCh)
 	}()
 	return errCh
@@ -687,6 +815,14 @@ func (j *tlfJournal) getJournalEnds(ctx context.Context) (
 }
 
 func (j *tlfJournal) flush(ctx context.Context) (err error) {
+	return j.flushBatches(ctx, 0)
+}
+
+// flushBatches flushes at most maxBatches batches of block entries,
+// each followed by the MD entries they allow, or everything if
+// maxBatches is 0.
+func (j *tlfJournal) flushBatches(
+	ctx context.Context, maxBatches int) (err error) {
 	j.flushLock.Lock()
 	defer j.flushLock.Unlock()
 
@@ -708,7 +844,7 @@ func (j *tlfJournal) flush(ctx context.Context) (err error) {
 	// TODO: Avoid starving flushing MD ops if there are many
 	// block ops. See KBFS-1502.
 
-	for {
+	for batches := 0; maxBatches == 0 || batches < maxBatches; batches++ {
 		select {
 		case <-ctx.Done():
 			j.log.CDebugf(ctx, "Flush canceled: %+v", ctx.Err())
@@ -716,83 +852,124 @@ func (j *tlfJournal) flush(ctx context.Context) (err error) {
 		default:
 		}
 
-		isConflict, err := j.isOnConflictBranch()
-		if err != nil {
-			return err
-		}
-		if isConflict {
-			j.log.CDebugf(ctx, "Ignoring flush while on conflict branch")
-			// It's safe to send a pause signal here, because even if
-			// CR has already resolved the conflict and send the
-			// resume signal, we know the background work loop is
-			// still waiting for this flush() loop to finish before it
-			// processes either the pause or the resume channel.
-			j.pause(journalPauseConflict)
+		// Take a worker for each batch, so that other journals
+		// get a turn while this one has a big backlog.
+		release, acquireErr := j.flushScheduler.acquireWorker(
+			ctx, j.diskLimiter)
+		if acquireErr != nil {
+			j.log.CDebugf(ctx,
+				"Flush canceled while waiting for a worker: %+v",
+				acquireErr)
 			return nil
 		}
-
-		converted, err := j.convertMDsToBranchIfOverThreshold(ctx, true)
-		if err != nil {
-			return err
+		numBlocks, numMDs, done, batchErr := j.flushOneBatch(ctx)
+		release()
+		flushedBlockEntries += numBlocks
+		flushedMDEntries += numMDs
+		if batchErr != nil {
+			return batchErr
 		}
-		if converted {
-			return nil
+		if done {
+			break
 		}
+	}
 
-		blockEnd, mdEnd, err := j.getJournalEnds(ctx)
-		if err != nil {
-			return err
-		}
+	j.log.CDebugf(ctx, "Flushed %d block entries and %d MD entries for %s",
+		flushedBlockEntries, flushedMDEntries, j.tlfID)
+	return nil
+}
 
-		if blockEnd == 0 && mdEnd == MetadataRevisionUninitialized {
-			j.log.CDebugf(ctx, "Nothing else to flush")
-			break
-		}
+// flushOneBatch flushes a single batch of block entries, followed by
+// the MD entries they allow. done is true if there's nothing left
+// for flushBatches to do for now.
+func (j *tlfJournal) flushOneBatch(ctx context.Context) (
+	flushedBlockEntries, flushedMDEntries int, done bool, err error) {
+	isConflict, err := j.isOnConflictBranch()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if isConflict {
+		j.log.CDebugf(ctx, "Ignoring flush while on conflict branch")
+		// It's safe to send a pause signal here, because even if
+		// CR has already resolved the conflict and send the
+		// resume signal, we know the background work loop is
+		// still waiting for this flush() loop to finish before it
+		// processes either the pause or the resume channel.
+		j.pause(journalPauseConflict)
+		return 0, 0, true, nil
+	}
+
+	converted, err := j.convertMDsToBranchIfOverThreshold(ctx, true)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if converted {
+		return 0, 0, true, nil
+	}
 
-		j.log.CDebugf(ctx, "Flushing up to blockEnd=%d and mdEnd=%d",
-			blockEnd, mdEnd)
+	blockEnd, mdEnd, err := j.getJournalEnds(ctx)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	if blockEnd == 0 && mdEnd == MetadataRevisionUninitialized {
+		j.log.CDebugf(ctx, "Nothing else to flush")
+		return 0, 0, true, nil
+	}
 
-		// Flush the block journal ops in parallel.
-		numFlushed, maxMDRevToFlush, converted, err :=
-			j.flushBlockEntries(ctx, blockEnd)
+	if blockEnd > 0 && mdEnd != MetadataRevisionUninitialized {
+		diverged, err := j.checkServerHeadDiverged(ctx)
 		if err != nil {
-			return err
+			// The MD put will catch any conflict anyway, so
+			// don't hold up the flush.
+			j.log.CDebugf(ctx,
+				"Couldn't check the server head before flushing: %+v",
+				err)
+		} else if diverged {
+			return 0, 0, true, nil
 		}
-		flushedBlockEntries += numFlushed
+	}
 
-		// If we ever switched branches while flushing block entries,
-		// we need to make sure `mdEnd` still reflects reality, since
-		// the number of md entries could have shrunk.
-		if converted {
-			_, mdEnd, err = j.getJournalEnds(ctx)
-			if err != nil {
-				return err
-			}
-		}
+	j.log.CDebugf(ctx, "Flushing up to blockEnd=%d and mdEnd=%d",
+		blockEnd, mdEnd)
+
+	// Flush the block journal ops in parallel.
+	numFlushed, maxMDRevToFlush, converted, err :=
+		j.flushBlockEntries(ctx, blockEnd)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	flushedBlockEntries = numFlushed
 
-		if numFlushed == 0 {
-			// There were no blocks to flush, so we can flush all of
-			// the remaining MDs.
-			maxMDRevToFlush = mdEnd
+	// If we ever switched branches while flushing block entries,
+	// we need to make sure `mdEnd` still reflects reality, since
+	// the number of md entries could have shrunk.
+	if converted {
+		_, mdEnd, err = j.getJournalEnds(ctx)
+		if err != nil {
+			return flushedBlockEntries, flushedMDEntries, false, err
 		}
+	}
 
-		// TODO: Flush MDs in batch.
+	if numFlushed == 0 {
+		// There were no blocks to flush, so we can flush all of
+		// the remaining MDs.
+		maxMDRevToFlush = mdEnd
+	}
 
-		for {
-			flushed, err := j.flushOneMDOp(ctx, mdEnd, maxMDRevToFlush)
-			if err != nil {
-				return err
-			}
-			if !flushed {
-				break
-			}
-			flushedMDEntries++
+	// TODO: Flush MDs in batch.
+
+	for {
+		flushed, err := j.flushOneMDOp(ctx, mdEnd, maxMDRevToFlush)
+		if err != nil {
+			return flushedBlockEntries, flushedMDEntries, false, err
 		}
+		if !flushed {
+			break
+		}
+		flushedMDEntries++
 	}
-
-	j.log.CDebugf(ctx, "Flushed %d block entries and %d MD entries for %s",
-		flushedBlockEntries, flushedMDEntries, j.tlfID)
-	return nil
+	return flushedBlockEntries, flushedMDEntries, false, nil
 }
 
 type errTLFJournalShutdown struct{}
@@ -906,7 +1083,9 @@ func (j *tlfJournal) flushBlockEntries(
 	// end, and we need to make sure `maxMDRevToFlush` is still valid.
 	eg.Go(func() error {
 		defer convertCancel()
-		return flushBlockEntries(groupCtx, j.log, j.delegateBlockServer,
+		bserver := journalFlushBlockServer{
+			j.delegateBlockServer, j.flushScheduler}
+		return flushBlockEntries(groupCtx, j.log, bserver,
 			j.config.BlockCache(), j.config.Reporter(),
 			j.tlfID, tlfName, entries)
 	})
@@ -981,6 +1160,7 @@ func (j *tlfJournal) getNextMDEntryToFlush(ctx context.Context,
 
 func (j *tlfJournal) convertMDsToBranchLocked(
 	ctx context.Context, bid BranchID, doSignal bool) error {
+	defer j.reportMDBytesLocked(ctx)
 	err := j.mdJournal.convertToBranch(
 		ctx, bid, j.config.Crypto(), j.config.Codec(), j.tlfID,
 		j.config.MDCache())
@@ -1016,6 +1196,91 @@ func (j *tlfJournal) convertMDsToBranch(ctx context.Context) error {
 	return j.convertMDsToBranchLocked(ctx, bid, true)
 }
 
+// getEarliestMDIfLarge returns the earliest MD in the journal, if
+// the block journal has at least j.conflictCheckBytes unflushed
+// bytes, and nil otherwise.
+func (j *tlfJournal) getEarliestMDIfLarge() (
+	unflushedBytes int64, mdID MdID, rmd BareRootMetadata, err error) {
+	j.journalLock.RLock()
+	defer j.journalLock.RUnlock()
+	if err := j.checkEnabledLocked(); err != nil {
+		return 0, MdID{}, nil, err
+	}
+
+	unflushedBytes = j.blockJournal.getUnflushedBytes()
+	if unflushedBytes < j.conflictCheckBytes {
+		return unflushedBytes, MdID{}, nil, nil
+	}
+	mdID, rmd, _, _, err = j.mdJournal.getEarliestWithExtra(true)
+	if err != nil {
+		return 0, MdID{}, nil, err
+	}
+	return unflushedBytes, mdID, rmd, nil
+}
+
+// checkServerHeadDiverged checks whether the merged MD head on the
+// server has diverged from the earliest MD in the journal, when
+// there are enough unflushed block bytes to make it worth the round
+// trip. If it has, it converts the journal to a branch, so that
+// conflict resolution starts before any of those blocks are
+// uploaded, and returns true. Otherwise the conflict would only be
+// detected when putting the MD, after all the blocks it needs.
+func (j *tlfJournal) checkServerHeadDiverged(ctx context.Context) (
+	diverged bool, err error) {
+	unflushedBytes, mdID, earliest, err := j.getEarliestMDIfLarge()
+	if err != nil {
+		return false, err
+	}
+	if earliest == nil || earliest.MergedStatus() != Merged {
+		return false, nil
+	}
+
+	mdServer := j.config.MDServer()
+	head, err := mdServer.GetForTLF(ctx, j.tlfID, NullBranchID, Merged)
+	if err != nil {
+		return false, err
+	}
+	if head == nil {
+		return false, nil
+	}
+
+	rev := earliest.RevisionNumber()
+	headRev := head.MD.RevisionNumber()
+	switch {
+	case headRev == rev-1:
+		headID, err := j.config.Crypto().MakeMdID(head.MD)
+		if err != nil {
+			return false, err
+		}
+		diverged = headID != earliest.GetPrevRoot()
+	case headRev >= rev:
+		// That's fine only if the server's MD at rev is the one
+		// from the journal, and it's just waiting to be removed.
+		serverID, err := getMdID(ctx, mdServer, j.config.Crypto(),
+			j.tlfID, NullBranchID, Merged, rev)
+		if err != nil {
+			return false, err
+		}
+		diverged = serverID != mdID
+	default:
+		// The server is behind the journal, which the MD puts
+		// will have to sort out.
+		return false, nil
+	}
+	if !diverged {
+		return false, nil
+	}
+
+	j.log.CDebugf(ctx, "Server head for %s is at rev %d and has diverged "+
+		"from journal rev %d; converting to a branch instead of flushing "+
+		"%d block bytes", j.tlfID, headRev, rev, unflushedBytes)
+	err = j.convertMDsToBranch(ctx)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (j *tlfJournal) convertMDsToBranchIfOverThreshold(ctx context.Context,
 	doSignal bool) (
 	bool, error) {
@@ -1177,6 +1442,7 @@ func (j *tlfJournal) removeFlushedMDEntry(ctx context.Context,
 	if err := j.checkEnabledLocked(); err != nil {
 		return err
 	}
+	defer j.reportMDBytesLocked(ctx)
 
 	if err := j.mdJournal.removeFlushedEntry(ctx, mdID, rmds); err != nil {
 		return err
@@ -1551,6 +1817,8 @@ func (j *tlfJournal) shutdown(ctx context.Context) {
 	storedBytes := j.blockJournal.getStoredBytes()
 	storedFiles := j.blockJournal.getStoredFiles()
 	j.diskLimiter.onJournalDisable(ctx, storedBytes, storedFiles)
+	j.diskLimiter.onJournalMDBytesChange(ctx, j.reportedMDBytes, 0)
+	j.reportedMDBytes = 0
 
 	// Make further accesses error out.
 	j.blockJournal = nil
@@ -1666,8 +1934,11 @@ func (j *tlfJournal) putBlockData(
 	// Since Acquire can block, it should happen outside of the
 	// journal lock.
 
+	phaseCtx, cancelPhase, budget := withPhaseBudget(
+		ctx, opPhaseLimiterDelay)
+	defer cancelPhase()
 	timeout := j.config.diskLimitTimeout()
-	acquireCtx, cancel := context.WithTimeout(ctx, timeout)
+	acquireCtx, cancel := context.WithTimeout(phaseCtx, timeout)
 	defer cancel()
 
 	bufLen := int64(len(buf))
@@ -1677,6 +1948,10 @@ func (j *tlfJournal) putBlockData(
 	case nil:
 		// Continue.
 	case context.DeadlineExceeded:
+		if phaseTimedOut(ctx, phaseCtx) {
+			return errors.WithStack(OpPhaseTimeoutError{
+				opPhaseLimiterDelay.String(), budget})
+		}
 		return errors.WithStack(ErrDiskLimitTimeout{
 			timeout, bufLen, filesPerBlockMax,
 			availableBytes, availableFiles, err,
@@ -1685,6 +1960,10 @@ func (j *tlfJournal) putBlockData(
 		return err
 	}
 
+	if err := checkPhaseBudget(ctx, opPhaseJournalWrite); err != nil {
+		return err
+	}
+
 	var putData bool
 	defer func() {
 		j.diskLimiter.afterBlockPut(
@@ -1720,6 +1999,7 @@ func (j *tlfJournal) putBlockData(
 	if putData && j.mdJournal.branchID == NullBranchID {
 		j.unsquashedBytes += uint64(bufLen)
 	}
+	j.lastWriteTime = j.config.Clock().Now()
 
 	j.config.Reporter().NotifySyncStatus(ctx, &keybase1.FSPathSyncStatus{
 		PublicTopLevelFolder: j.tlfID.IsPublic(),
@@ -1882,6 +2162,7 @@ func (j *tlfJournal) doPutMD(ctx context.Context, rmd *RootMetadata,
 	// TODO: remove the revision from the cache on any errors below?
 	// Tricky when the append is only queued.
 
+	defer j.reportMDBytesLocked(ctx)
 	mdID, err = j.mdJournal.put(ctx, j.config.Crypto(),
 		j.config.encryptionKeyGetter(), j.config.BlockSplitter(),
 		rmd, isFirstRev)
@@ -1893,6 +2174,7 @@ func (j *tlfJournal) doPutMD(ctx context.Context, rmd *RootMetadata,
 	if err != nil {
 		return MdID{}, false, err
 	}
+	j.lastWriteTime = j.config.Clock().Now()
 
 	j.signalWork()
 
@@ -1955,8 +2237,19 @@ func (j *tlfJournal) prepAndAddRMDWithRetry(ctx context.Context,
 
 func (j *tlfJournal) putMD(ctx context.Context, rmd *RootMetadata) (
 	MdID, error) {
+	// MDs don't wait on the disk limiter like blocks do, since
+	// they're small and have their own budget.
+	_, err := j.diskLimiter.beforeMDPut(ctx)
+	if err != nil {
+		return MdID{}, err
+	}
+	err = checkPhaseBudget(ctx, opPhaseJournalWrite)
+	if err != nil {
+		return MdID{}, err
+	}
+
 	var mdID MdID
-	err := j.prepAndAddRMDWithRetry(ctx, rmd,
+	err = j.prepAndAddRMDWithRetry(ctx, rmd,
 		func(mdInfo unflushedPathMDInfo, perRevMap unflushedPathsPerRevMap) (
 			retry bool, err error) {
 			mdID, retry, err = j.doPutMD(ctx, rmd, mdInfo, perRevMap)
@@ -1979,6 +2272,7 @@ func (j *tlfJournal) clearMDs(ctx context.Context, bid BranchID) error {
 		return err
 	}
 
+	defer j.reportMDBytesLocked(ctx)
 	err := j.mdJournal.clear(ctx, bid)
 	if err != nil {
 		return err
@@ -2008,6 +2302,7 @@ func (j *tlfJournal) doResolveBranch(ctx context.Context,
 
 	// First write the resolution to a new branch, and swap it with
 	// the existing branch, then clear the existing branch.
+	defer j.reportMDBytesLocked(ctx)
 	mdID, err = j.mdJournal.resolveAndClear(
 		ctx, j.config.Crypto(), j.config.encryptionKeyGetter(),
 		j.config.BlockSplitter(), j.config.MDCache(), bid, rmd)
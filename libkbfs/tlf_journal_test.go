@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/keybase/backoff"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/ioutil"
 	"github.com/keybase/kbfs/kbfsblock"
@@ -258,7 +259,8 @@ func setupTLFJournalTest(
 		math.MaxInt64, math.MaxInt64)
 	tlfJournal, err = makeTLFJournal(ctx, uid, verifyingKey,
 		tempdir, config.tlfID, config, delegateBlockServer,
-		bwStatus, delegate, nil, nil, diskLimitSemaphore)
+		bwStatus, delegate, nil, nil, diskLimitSemaphore,
+		newDefaultJournalFlushScheduler())
 	require.NoError(t, err)
 
 	switch bwStatus {
@@ -609,6 +611,29 @@ func testTLFJournalBlockOpDiskLimitTimeout(t *testing.T, ver MetadataVer) {
 	}, timeoutErr)
 }
 
+func testTLFJournalBlockOpDiskLimitPhaseTimeout(
+	t *testing.T, ver MetadataVer) {
+	tempdir, config, ctx, cancel, tlfJournal, delegate :=
+		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkPaused)
+	defer teardownTLFJournalTest(
+		tempdir, config, ctx, cancel, tlfJournal, delegate)
+
+	tlfJournal.diskLimiter.onJournalEnable(
+		ctx, math.MaxInt64, math.MaxInt64-1)
+	config.dlTimeout = time.Minute
+
+	// The disk limiter gets only part of the deadline, and says
+	// so when it runs out.
+	ctx2, cancel2 := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel2()
+	data := []byte{1, 2, 3, 4}
+	id, bCtx, serverHalf := config.makeBlock(data)
+	err := tlfJournal.putBlockData(ctx2, id, bCtx, data, serverHalf)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	require.Contains(t, err.Error(), opPhaseLimiterDelay.String())
+	require.NoError(t, ctx2.Err())
+}
+
 func testTLFJournalBlockOpDiskLimitPutFailure(t *testing.T, ver MetadataVer) {
 	tempdir, config, ctx, cancel, tlfJournal, delegate :=
 		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkPaused)
@@ -629,6 +654,40 @@ func testTLFJournalBlockOpDiskLimitPutFailure(t *testing.T, ver MetadataVer) {
 	require.NoError(t, err)
 }
 
+func testTLFJournalMDDiskLimit(t *testing.T, ver MetadataVer) {
+	tempdir, config, ctx, cancel, tlfJournal, delegate :=
+		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkPaused)
+	defer teardownTLFJournalTest(
+		tempdir, config, ctx, cancel, tlfJournal, delegate)
+
+	// A full block budget doesn't keep MDs out.
+	tlfJournal.diskLimiter.onJournalEnable(
+		ctx, math.MaxInt64, math.MaxInt64)
+	defer tlfJournal.diskLimiter.onJournalDisable(
+		ctx, math.MaxInt64, math.MaxInt64)
+	md := config.makeMD(MetadataRevisionInitial, MdID{})
+	mdID, err := tlfJournal.putMD(ctx, md)
+	require.NoError(t, err)
+
+	sdl := tlfJournal.diskLimiter.(semaphoreDiskLimiter)
+	mdBytes := tlfJournal.mdJournal.getStoredBytes()
+	require.True(t, mdBytes > 0)
+	require.Equal(t, math.MaxInt64-mdBytes, sdl.mdByteSemaphore.Count())
+
+	// But a full MD budget does.
+	availableBytes := sdl.mdByteSemaphore.Count()
+	sdl.onJournalMDBytesChange(ctx, 0, availableBytes)
+	md2 := config.makeMD(MetadataRevisionInitial+1, mdID)
+	_, err = tlfJournal.putMD(ctx, md2)
+	require.IsType(t, JournalMDLimitError{}, errors.Cause(err))
+	sdl.onJournalMDBytesChange(ctx, availableBytes, 0)
+
+	_, err = tlfJournal.putMD(ctx, md2)
+	require.NoError(t, err)
+	require.Equal(t, math.MaxInt64-tlfJournal.mdJournal.getStoredBytes(),
+		sdl.mdByteSemaphore.Count())
+}
+
 type hangingMDServer struct {
 	MDServer
 	// Closed on put.
@@ -722,6 +781,13 @@ type shimMDServer struct {
 	rmdses       []rmdsWithExtra
 	nextGetRange []*RootMetadataSigned
 	nextErr      error
+	head         *RootMetadataSigned
+}
+
+func (s *shimMDServer) GetForTLF(
+	ctx context.Context, id tlf.ID, bid BranchID, mStatus MergeStatus) (
+	*RootMetadataSigned, error) {
+	return s.head, nil
 }
 
 func (s *shimMDServer) GetRange(
@@ -864,6 +930,60 @@ func testTLFJournalFlushMDConflict(t *testing.T, ver MetadataVer) {
 	requireJournalEntryCounts(t, tlfJournal, uint64(mdCount), uint64(mdCount))
 }
 
+// testTLFJournalFlushServerHeadDiverged tests that a flush checks the
+// server's MD head before uploading any blocks, and converts the
+// journal to a branch right away if the head has diverged.
+func testTLFJournalFlushServerHeadDiverged(t *testing.T, ver MetadataVer) {
+	tempdir, config, ctx, cancel, tlfJournal, delegate :=
+		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkPaused)
+	defer teardownTLFJournalTest(
+		tempdir, config, ctx, cancel, tlfJournal, delegate)
+	tlfJournal.conflictCheckBytes = 1
+
+	var mdserver shimMDServer
+	config.mdserver = &mdserver
+	signHead := func(revision MetadataRevision, prevRoot MdID) (
+		*RootMetadataSigned, MdID) {
+		bare := config.makeMD(revision, prevRoot).bareMd
+		bare.SetSerializedPrivateMetadata([]byte{1})
+		rmds, err := SignBareRootMetadata(
+			ctx, config.Codec(), config.Crypto(), config.Crypto(),
+			bare, time.Now())
+		require.NoError(t, err)
+		id, err := config.Crypto().MakeMdID(rmds.MD)
+		require.NoError(t, err)
+		return rmds, id
+	}
+
+	t.Log("A journal that follows the server head flushes normally")
+	head, headID := signHead(MetadataRevision(9), fakeMdID(1))
+	mdserver.head = head
+	putBlock(ctx, t, config, tlfJournal, []byte{1, 2, 3, 4})
+	md := config.makeMD(MetadataRevision(10), headID)
+	mdID, err := tlfJournal.putMD(ctx, md)
+	require.NoError(t, err)
+	err = tlfJournal.flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, NullBranchID, tlfJournal.mdJournal.getBranchID())
+	require.Len(t, mdserver.rmdses, 1)
+	requireJournalEntryCounts(t, tlfJournal, 0, 0)
+
+	t.Log("Once another device puts its own revision, nothing is flushed")
+	head, _ = signHead(MetadataRevision(11), mdID)
+	mdserver.head = head
+	mdserver.nextGetRange = []*RootMetadataSigned{head}
+	putBlock(ctx, t, config, tlfJournal, []byte{5, 6, 7, 8})
+	md = config.makeMD(MetadataRevision(11), mdID)
+	_, err = tlfJournal.putMD(ctx, md)
+	require.NoError(t, err)
+	err = tlfJournal.flush(ctx)
+	require.NoError(t, err)
+	require.NotEqual(t, NullBranchID, tlfJournal.mdJournal.getBranchID())
+	require.Len(t, mdserver.rmdses, 1)
+	// The block and its MD marker are still waiting to be flushed.
+	requireJournalEntryCounts(t, tlfJournal, 2, 1)
+}
+
 // orderedBlockServer and orderedMDServer appends onto their shared
 // puts slice when their Put() methods are called.
 
@@ -1429,6 +1549,77 @@ func testTLFJournalFlushRetry(t *testing.T, ver MetadataVer) {
 	testMDJournalGCd(t, tlfJournal.mdJournal)
 }
 
+// failOnceBlockServer fails its first put.
+type failOnceBlockServer struct {
+	BlockServer
+	failCh chan struct{}
+}
+
+func (bs failOnceBlockServer) Put(
+	ctx context.Context, tlfID tlf.ID, id kbfsblock.ID, context kbfsblock.Context,
+	buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	select {
+	case <-bs.failCh:
+		return errors.New("Error to stop flushing")
+	default:
+	}
+	return bs.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
+}
+
+func testTLFJournalTrickleFlush(t *testing.T, ver MetadataVer) {
+	tempdir, config, ctx, cancel, tlfJournal, delegate :=
+		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkPaused)
+	defer teardownTLFJournalTest(
+		tempdir, config, ctx, cancel, tlfJournal, delegate)
+
+	// Stop the current background loop, so the new one gives up
+	// after the first error, and trickle flushes right away.
+	tlfJournal.needShutdownCh <- struct{}{}
+	<-tlfJournal.backgroundShutdownCh
+	select {
+	case <-delegate.shutdownCh:
+	case <-ctx.Done():
+		assert.Fail(config.t, ctx.Err().Error())
+	}
+
+	for i := 0; i <= maxJournalBlockFlushBatchSize; i++ {
+		putBlock(ctx, t, config, tlfJournal, []byte{byte(i)})
+	}
+
+	// Trickle flushing waits until the journal is idle, on an
+	// unmetered network.
+	tlfJournal.trickleFlushIdleTime = time.Hour
+	require.False(t, tlfJournal.canTrickleFlush())
+	tlfJournal.trickleFlushIdleTime = 0
+	tlfJournal.trickleFlushInterval = time.Millisecond
+	tlfJournal.setNetworkMetered(true)
+	require.False(t, tlfJournal.canTrickleFlush())
+	tlfJournal.setNetworkMetered(false)
+	require.True(t, tlfJournal.canTrickleFlush())
+
+	tlfJournal.delegateBlockServer = failOnceBlockServer{
+		tlfJournal.delegateBlockServer, make(chan struct{}, 1)}
+	tlfJournal.delegateBlockServer.(failOnceBlockServer).failCh <- struct{}{}
+	tlfJournal.backgroundShutdownCh = make(chan struct{})
+	go tlfJournal.doBackgroundWorkLoop(
+		TLFJournalBackgroundWorkPaused, &backoff.StopBackOff{})
+	delegate.requireNextState(ctx, bwPaused)
+	tlfJournal.resumeBackgroundWork()
+
+	// The regular flush fails without a retry, and then each trickle
+	// flush flushes one batch.
+	delegate.requireNextState(ctx, bwIdle)
+	delegate.requireNextState(ctx, bwBusy)
+	delegate.requireNextState(ctx, bwIdle)
+	delegate.requireNextState(ctx, bwBusy)
+	delegate.requireNextState(ctx, bwIdle)
+	delegate.requireNextState(ctx, bwBusy)
+	delegate.requireNextState(ctx, bwIdle)
+
+	requireJournalEntryCounts(t, tlfJournal, 0, 0)
+	require.False(t, tlfJournal.canTrickleFlush())
+}
+
 func testTLFJournalResolveBranch(t *testing.T, ver MetadataVer) {
 	tempdir, config, ctx, cancel, tlfJournal, delegate :=
 		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkPaused)
@@ -1600,15 +1791,19 @@ func TestTLFJournal(t *testing.T) {
 		testTLFJournalBlockOpDiskLimitDuplicate,
 		testTLFJournalBlockOpDiskLimitCancel,
 		testTLFJournalBlockOpDiskLimitTimeout,
+		testTLFJournalBlockOpDiskLimitPhaseTimeout,
 		testTLFJournalBlockOpDiskLimitPutFailure,
+		testTLFJournalMDDiskLimit,
 		testTLFJournalFlushMDBasic,
 		testTLFJournalFlushMDConflict,
+		testTLFJournalFlushServerHeadDiverged,
 		testTLFJournalFlushOrdering,
 		testTLFJournalFlushOrderingAfterSquashAndCR,
 		testTLFJournalFlushInterleaving,
 		testTLFJournalConvertWhileFlushing,
 		testTLFJournalSquashWhileFlushing,
 		testTLFJournalFlushRetry,
+		testTLFJournalTrickleFlush,
 		testTLFJournalResolveBranch,
 		testTLFJournalSquashByBytes,
 		testTLFJournalFirstRevNoSquash,
@@ -0,0 +1,145 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"reflect"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// tlfJournalVerifyResult is what verifyTLFJournalEntries found in the
+// entries of a TLF's journals.
+type tlfJournalVerifyResult struct {
+	mdCorrupt, mdTruncated                 int
+	blockCorrupt, blockTruncated           int
+	deferredGCCorrupt, deferredGCTruncated int
+}
+
+// verifyAndTruncate checks every entry of j, and removes the run of
+// corrupt entries at the end of it, if there is one and canTruncate
+// returns true for the ordinal that run starts at.  It returns the
+// numbers of corrupt and removed entries.
+func verifyAndTruncate(ctx context.Context, log logger.Logger,
+	j *diskJournal, canTruncate func(start journalOrdinal) (bool, error)) (
+	corrupt, truncated int, err error) {
+	corruptOrdinals, err := j.verify()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(corruptOrdinals) == 0 {
+		return 0, 0, nil
+	}
+	log.CWarningf(ctx, "Journal %s has corrupt entries %v",
+		j.dir, corruptOrdinals)
+	start, ok := j.corruptTailStart(corruptOrdinals)
+	if !ok {
+		return len(corruptOrdinals), 0, nil
+	}
+	ok, err = canTruncate(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !ok {
+		log.CWarningf(ctx, "Not truncating journal %s at %s, since "+
+			"later MDs may depend on it", j.dir, start)
+		return len(corruptOrdinals), 0, nil
+	}
+	truncated = int(j.latest - start + 1)
+	log.CWarningf(ctx, "Truncating journal %s at %s, removing %d entries",
+		j.dir, start, truncated)
+	err = j.truncate(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(corruptOrdinals), truncated, nil
+}
+
+// verifyTLFJournalEntries is the verification pass run over the
+// block and MD journals in dir before they're opened.
+//
+// A run of corrupt entries at the end of a journal is most likely a
+// write that was cut short by a crash, and is removed.  The MD
+// journal's always can be, since the blocks the removed MDs point to
+// are just left unreferenced.  The block journal's can only be if
+// it's sure to leave behind the blocks of every MD in the MD journal;
+// since the blocks of an MD go in the block journal before the MD
+// does, and its revision marker goes in after, that's when the MD
+// journal is empty, or a marker for its latest revision survives.
+//
+// Corrupt entries anywhere else are left alone; reading them returns
+// a JournalEntryCorruptError, which stops the flusher rather than
+// feeding it corrupt data.  The block counts in the block journal's
+// aggregate info may be too high after a truncation, which is fixed
+// once the journal goes empty.
+func verifyTLFJournalEntries(ctx context.Context, codec kbfscodec.Codec,
+	dir string, log logger.Logger) (
+	result tlfJournalVerifyResult, err error) {
+	mdJournal, err := makeMdIDJournal(codec, mdJournalPath(dir))
+	if err != nil {
+		return tlfJournalVerifyResult{}, err
+	}
+	mdj := mdJournal.j
+	alwaysTruncate := func(journalOrdinal) (bool, error) {
+		return true, nil
+	}
+	result.mdCorrupt, result.mdTruncated, err = verifyAndTruncate(
+		ctx, log, mdj, alwaysTruncate)
+	if err != nil {
+		return tlfJournalVerifyResult{}, err
+	}
+
+	blockJournal, err := makeDiskJournal(codec, blockJournalDir(dir),
+		reflect.TypeOf(blockJournalEntry{}))
+	if err != nil {
+		return tlfJournalVerifyResult{}, err
+	}
+	leavesMDBlocks := func(start journalOrdinal) (bool, error) {
+		if mdj.empty() {
+			return true, nil
+		}
+		latestRev, err := ordinalToRevision(mdj.latest)
+		if err != nil {
+			return false, err
+		}
+		for o := start - 1; o >= blockJournal.earliest && o < start; o-- {
+			e, err := blockJournal.readJournalEntry(o)
+			_, corrupt := errors.Cause(err).(JournalEntryCorruptError)
+			if corrupt || ioutil.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return false, err
+			}
+			entry := e.(blockJournalEntry)
+			if entry.Op == mdRevMarkerOp {
+				return entry.Revision >= latestRev, nil
+			}
+		}
+		return false, nil
+	}
+	result.blockCorrupt, result.blockTruncated, err = verifyAndTruncate(
+		ctx, log, blockJournal, leavesMDBlocks)
+	if err != nil {
+		return tlfJournalVerifyResult{}, err
+	}
+
+	// The deferred GC journal only lists blocks that have already
+	// been flushed, to be removed locally.
+	gcJournal, err := makeDiskJournal(codec, deferredGCBlockJournalDir(dir),
+		reflect.TypeOf(blockJournalEntry{}))
+	if err != nil {
+		return tlfJournalVerifyResult{}, err
+	}
+	result.deferredGCCorrupt, result.deferredGCTruncated, err =
+		verifyAndTruncate(ctx, log, gcJournal, alwaysTruncate)
+	if err != nil {
+		return tlfJournalVerifyResult{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func corruptJournalEntryForTest(
+	t *testing.T, j *diskJournal, o journalOrdinal) {
+	err := ioutil.WriteFile(j.journalEntryPath(o), []byte{0, 'k'}, 0600)
+	require.NoError(t, err)
+}
+
+func TestVerifyTLFJournalEntries(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "tlf_journal_verify")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+
+	ctx := context.Background()
+	codec := kbfscodec.NewMsgpack()
+	log := logger.NewTestLogger(t)
+
+	// Blocks for revisions 1 and 2, each followed by its marker, in
+	// the block journal, and revisions 1 and 2 in the MD journal.
+	bj, err := makeDiskJournal(codec, blockJournalDir(tempdir),
+		reflect.TypeOf(blockJournalEntry{}))
+	require.NoError(t, err)
+	for _, e := range []blockJournalEntry{
+		{Op: blockPutOp},
+		{Op: mdRevMarkerOp, Revision: 1},
+		{Op: blockPutOp},
+		{Op: mdRevMarkerOp, Revision: 2},
+		{Op: blockPutOp},
+	} {
+		_, err := bj.appendJournalEntry(nil, e)
+		require.NoError(t, err)
+	}
+	mdIDJournal, err := makeMdIDJournal(codec, mdJournalPath(tempdir))
+	require.NoError(t, err)
+	for r := MetadataRevisionInitial; r <= 2; r++ {
+		err := mdIDJournal.append(r, mdIDJournalEntry{ID: fakeMdID(byte(r))})
+		require.NoError(t, err)
+	}
+
+	result, err := verifyTLFJournalEntries(ctx, codec, tempdir, log)
+	require.NoError(t, err)
+	require.Equal(t, tlfJournalVerifyResult{}, result)
+
+	t.Log("A corrupt block journal entry after the last marker is " +
+		"truncated away.")
+	corruptJournalEntryForTest(t, bj, 5)
+	result, err = verifyTLFJournalEntries(ctx, codec, tempdir, log)
+	require.NoError(t, err)
+	require.Equal(t, tlfJournalVerifyResult{
+		blockCorrupt: 1, blockTruncated: 1}, result)
+
+	t.Log("Truncating the marker for the latest MD would lose its " +
+		"blocks, so it's left alone.")
+	corruptJournalEntryForTest(t, bj, 4)
+	result, err = verifyTLFJournalEntries(ctx, codec, tempdir, log)
+	require.NoError(t, err)
+	require.Equal(t, tlfJournalVerifyResult{blockCorrupt: 1}, result)
+
+	t.Log("A corrupt latest MD can always be truncated, and then so " +
+		"can the block journal's tail.")
+	mdIDJournal, err = makeMdIDJournal(codec, mdJournalPath(tempdir))
+	require.NoError(t, err)
+	corruptJournalEntryForTest(t, mdIDJournal.j, 2)
+	result, err = verifyTLFJournalEntries(ctx, codec, tempdir, log)
+	require.NoError(t, err)
+	require.Equal(t, tlfJournalVerifyResult{
+		mdCorrupt: 1, mdTruncated: 1,
+		blockCorrupt: 1, blockTruncated: 1}, result)
+
+	t.Log("Corruption in the middle of a journal is left to be " +
+		"reported when it's read.")
+	corruptJournalEntryForTest(t, bj, 2)
+	result, err = verifyTLFJournalEntries(ctx, codec, tempdir, log)
+	require.NoError(t, err)
+	require.Equal(t, tlfJournalVerifyResult{blockCorrupt: 1}, result)
+	bj, err = makeDiskJournal(codec, blockJournalDir(tempdir),
+		reflect.TypeOf(blockJournalEntry{}))
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), bj.length())
+}
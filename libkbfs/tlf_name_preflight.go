@@ -0,0 +1,140 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// TlfAssertionResolution is what one assertion in a proposed TLF
+// name resolves to.
+type TlfAssertionResolution struct {
+	// Assertion is the assertion as given in the proposed name.
+	Assertion string
+	// Reader is whether the assertion is for a reader, rather than
+	// a writer.
+	Reader bool
+	// Username and UID are set if the assertion resolves to a
+	// Keybase user.
+	Username libkb.NormalizedUsername
+	UID      keybase1.UID
+	// Unresolved is set instead if the assertion doesn't resolve to
+	// a Keybase user yet.
+	Unresolved keybase1.SocialAssertion
+}
+
+// TlfNamePreflight describes the TLF that a proposed name would
+// create.  It is suitable for encoding directly as JSON.
+type TlfNamePreflight struct {
+	// CanonicalName is the name the TLF would have.  Assertions
+	// for the same user are merged into one.
+	CanonicalName CanonicalTlfName
+	Public        bool
+	// Assertions are in the order they appear in the proposed
+	// name, writers first.
+	Assertions []TlfAssertionResolution
+	// PendingSignup is whether any of the assertions are
+	// unresolved.  Those people can't read the TLF until they join
+	// Keybase and prove the assertion, after which the TLF is
+	// rekeyed for them, and its canonical name changes.
+	PendingSignup bool
+}
+
+// recordingResolvableAssertion saves what its assertion resolved to.
+type recordingResolvableAssertion struct {
+	resolvableAssertion
+	result *TlfAssertionResolution
+}
+
+func (ra recordingResolvableAssertion) resolve(ctx context.Context) (
+	nameUIDPair, keybase1.SocialAssertion, error) {
+	nuid, sa, err := ra.resolvableAssertion.resolve(ctx)
+	if err != nil {
+		return nameUIDPair{}, keybase1.SocialAssertion{}, err
+	}
+	ra.result.Username = nuid.name
+	ra.result.UID = nuid.uid
+	ra.result.Unresolved = sa
+	return nuid, sa, nil
+}
+
+// PreflightTlfName reports which Keybase users each assertion in the
+// proposed TLF name maps to, so that the user can confirm who a new
+// TLF will be shared with before creating it.  Unlike ParseTlfHandle,
+// it accepts names that aren't canonical, and it only resolves the
+// assertions, without identifying the users or looking up the TLF.
+func PreflightTlfName(ctx context.Context, kbpki KBPKI, name string,
+	public bool) (TlfNamePreflight, error) {
+	writerNames, readerNames, extensionSuffix, err := splitTLFName(name)
+	if err != nil {
+		return TlfNamePreflight{}, err
+	}
+	if public && len(readerNames) > 0 {
+		return TlfNamePreflight{}, NoSuchNameError{Name: name}
+	}
+
+	var extensions []tlf.HandleExtension
+	if extensionSuffix != "" {
+		extensions, err = tlf.ParseHandleExtensionSuffix(extensionSuffix)
+		if err != nil {
+			return TlfNamePreflight{}, err
+		}
+	}
+
+	results := make([]TlfAssertionResolution,
+		len(writerNames)+len(readerNames))
+	users := func(names []string, offset int, reader bool) (
+		[]resolvableUser, error) {
+		users := make([]resolvableUser, len(names))
+		for i, n := range names {
+			assertion, err := normalizeAssertionOrName(n)
+			if err != nil {
+				return nil, err
+			}
+			result := &results[offset+i]
+			result.Assertion = n
+			result.Reader = reader
+			users[i] = recordingResolvableAssertion{
+				resolvableAssertion{kbpki, assertion, keybase1.UID("")},
+				result}
+		}
+		return users, nil
+	}
+	writers, err := users(writerNames, 0, false)
+	if err != nil {
+		return TlfNamePreflight{}, err
+	}
+	readers, err := users(readerNames, len(writerNames), true)
+	if err != nil {
+		return TlfNamePreflight{}, err
+	}
+
+	h, err := makeTlfHandleHelper(ctx, public, writers, readers, extensions)
+	if err != nil {
+		return TlfNamePreflight{}, err
+	}
+
+	if !public {
+		session, err := kbpki.GetCurrentSession(ctx)
+		if err != nil {
+			return TlfNamePreflight{}, err
+		}
+		if !h.IsReader(session.UID) {
+			return TlfNamePreflight{}, NewReadAccessError(
+				h, session.Name, h.GetCanonicalPath())
+		}
+	}
+
+	return TlfNamePreflight{
+		CanonicalName: h.GetCanonicalName(),
+		Public:        public,
+		Assertions:    results,
+		PendingSignup: len(h.UnresolvedWriters())+
+			len(h.UnresolvedReaders()) > 0,
+	}, nil
+}
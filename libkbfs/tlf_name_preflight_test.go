@@ -0,0 +1,71 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/externals"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestPreflightTlfName(t *testing.T) {
+	ctx := context.Background()
+
+	localUsers := MakeLocalUsers([]libkb.NormalizedUsername{"u1", "u2", "u3"})
+	localUsers[2].Asserts = []string{"u3@twitter"}
+	currentUID := localUsers[0].UID
+	daemon := NewKeybaseDaemonMemory(
+		currentUID, localUsers, kbfscodec.NewMsgpack())
+
+	kbpki := &identifyCountingKBPKI{
+		KBPKI: &daemonKBPKI{
+			daemon: daemon,
+		},
+	}
+
+	// The name doesn't need to be canonical.
+	p, err := PreflightTlfName(
+		ctx, kbpki, "U2,u1,u3@twitter,u9@github#u4@Twitter,u3", false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, kbpki.getIdentifyCalls())
+
+	u9, ok := externals.NormalizeSocialAssertion("u9@github")
+	require.True(t, ok)
+	u4, ok := externals.NormalizeSocialAssertion("u4@twitter")
+	require.True(t, ok)
+	assert.Equal(t, TlfNamePreflight{
+		CanonicalName: "u1,u2,u3,u9@github#u4@twitter",
+		Assertions: []TlfAssertionResolution{
+			{Assertion: "U2", Username: "u2", UID: localUsers[1].UID},
+			{Assertion: "u1", Username: "u1", UID: localUsers[0].UID},
+			{Assertion: "u3@twitter", Username: "u3",
+				UID: localUsers[2].UID},
+			{Assertion: "u9@github", Unresolved: u9},
+			{Assertion: "u4@Twitter", Reader: true, Unresolved: u4},
+			{Assertion: "u3", Reader: true, Username: "u3",
+				UID: localUsers[2].UID},
+		},
+		PendingSignup: true,
+	}, p)
+
+	p, err = PreflightTlfName(ctx, kbpki, "u2,u3@twitter", true)
+	require.NoError(t, err)
+	assert.Equal(t, CanonicalTlfName("u2,u3"), p.CanonicalName)
+	assert.True(t, p.Public)
+	assert.False(t, p.PendingSignup)
+
+	// The current user must be in a private TLF.
+	_, err = PreflightTlfName(ctx, kbpki, "u2,u3", false)
+	assert.IsType(t, ReadAccessError{}, err)
+	_, err = PreflightTlfName(ctx, kbpki, "u1#u2", true)
+	assert.IsType(t, NoSuchNameError{}, err)
+	_, err = PreflightTlfName(ctx, kbpki, "u1,", false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// The longest allowed snapshot name, in bytes.
+const maxSnapshotNameBytes = 255
+
+// TLFSnapshot is a named, pinned revision of a TLF.
+type TLFSnapshot struct {
+	Name     string           `codec:"n"`
+	Revision MetadataRevision `codec:"r"`
+	// Ctime is when the snapshot was created, in unix nanoseconds.
+	Ctime int64 `codec:"c"`
+
+	codec.UnknownFieldSetHandler
+}
+
+func (s TLFSnapshot) String() string {
+	return fmt.Sprintf("%s (revision %d)", s.Name, s.Revision)
+}
+
+type snapshotsByName []TLFSnapshot
+
+func (s snapshotsByName) Len() int           { return len(s) }
+func (s snapshotsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s snapshotsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+func checkSnapshotName(name string) error {
+	if name == "" || len(name) > maxSnapshotNameBytes ||
+		strings.ContainsAny(name, "/\x00") {
+		return InvalidSnapshotNameError{name}
+	}
+	return nil
+}
+
+// GetDirChildrenAtRevision returns the entries of the directory at
+// the given path, relative to the root of the given TLF, as of the
+// given revision.  Nothing is read through the folder's current
+// state, so the revision can be browsed however far the folder has
+// moved on, as long as quota reclamation kept its blocks, for
+// example because it's pinned.
+func GetDirChildrenAtRevision(ctx context.Context, config Config,
+	tlfID tlf.ID, rev MetadataRevision, components []string) (
+	map[string]EntryInfo, error) {
+	rmd, err := getArchivedMD(ctx, config, tlfID, rev)
+	if err != nil {
+		return nil, err
+	}
+	dblock, err := getDirBlockAtRevision(ctx, config, rmd, components)
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[string]EntryInfo, len(dblock.Children))
+	for name, de := range dblock.Children {
+		children[name] = de.EntryInfo
+	}
+	return children, nil
+}
+
+// getDirBlockAtRevision walks from the root of rmd down the given
+// path, and returns the directory block at the end of it.
+func getDirBlockAtRevision(ctx context.Context, config Config,
+	rmd ImmutableRootMetadata, components []string) (*DirBlock, error) {
+	p := path{
+		FolderBranch{rmd.TlfID(), MasterBranch},
+		[]pathNode{{rmd.data.Dir.BlockPointer,
+			string(rmd.GetTlfHandle().GetCanonicalName())}},
+	}
+	for _, name := range components {
+		dblock := NewDirBlock().(*DirBlock)
+		err := config.BlockOps().Get(
+			ctx, rmd, p.tailPointer(), dblock, TransientEntry)
+		if err != nil {
+			return nil, err
+		}
+		de, ok := dblock.Children[name]
+		if !ok {
+			return nil, NoSuchNameError{name}
+		}
+		p = p.ChildPath(name, de.BlockPointer)
+		if de.Type != Dir {
+			return nil, NotDirError{p}
+		}
+	}
+	dblock := NewDirBlock().(*DirBlock)
+	err := config.BlockOps().Get(
+		ctx, rmd, p.tailPointer(), dblock, TransientEntry)
+	if err != nil {
+		return nil, err
+	}
+	return dblock, nil
+}
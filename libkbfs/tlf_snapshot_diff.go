@@ -0,0 +1,345 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// RevisionDiffChange says how an entry differs between two revisions.
+type RevisionDiffChange int
+
+const (
+	// RevisionDiffAdded means the entry only exists in the newer
+	// revision.
+	RevisionDiffAdded RevisionDiffChange = iota
+	// RevisionDiffRemoved means the entry only exists in the older
+	// revision.
+	RevisionDiffRemoved
+	// RevisionDiffModified means the entry exists in both revisions,
+	// with different contents.
+	RevisionDiffModified
+	// RevisionDiffRenamed means the entry moved to a different path,
+	// without its contents changing.
+	RevisionDiffRenamed
+)
+
+// String implements the fmt.Stringer interface for RevisionDiffChange.
+func (c RevisionDiffChange) String() string {
+	switch c {
+	case RevisionDiffAdded:
+		return "added"
+	case RevisionDiffRemoved:
+		return "removed"
+	case RevisionDiffModified:
+		return "modified"
+	case RevisionDiffRenamed:
+		return "renamed"
+	}
+	return "<invalid RevisionDiffChange>"
+}
+
+// RevisionDiffEntry describes one entry that differs between two
+// revisions of a TLF.
+type RevisionDiffEntry struct {
+	// Path is relative to the root of the TLF.
+	Path string
+	// OldPath is where a renamed entry was in the older revision.
+	OldPath string
+	Change  RevisionDiffChange
+	// Type is the type in the newer revision, unless the entry was
+	// removed.
+	Type EntryType
+	// OldSize and NewSize are the sizes of files, and are 0 for
+	// directories, symlinks, and revisions without the entry.
+	OldSize uint64
+	NewSize uint64
+}
+
+// ByteDelta returns how many bytes of file data the change added,
+// which is negative if it removed some.
+func (e RevisionDiffEntry) ByteDelta() int64 {
+	return int64(e.NewSize) - int64(e.OldSize)
+}
+
+// TLFRevisionDiff is the entry-level difference between two
+// revisions of a TLF.  It is suitable for encoding directly as JSON.
+type TLFRevisionDiff struct {
+	TlfID       tlf.ID
+	OldRevision MetadataRevision
+	NewRevision MetadataRevision
+	// Entries are sorted by path, with each directory before its
+	// contents.  Every entry under an added or removed directory is
+	// listed too, but not those under a renamed one.
+	Entries []RevisionDiffEntry
+}
+
+// ByteDelta returns how many bytes of file data were added between
+// the two revisions, which is negative if more were removed.
+func (d TLFRevisionDiff) ByteDelta() (delta int64) {
+	for _, e := range d.Entries {
+		delta += e.ByteDelta()
+	}
+	return delta
+}
+
+// diffTree is the range of entries listing a removed or added
+// subtree.
+type diffTree struct {
+	start, end int
+}
+
+// revisionDiffer walks two revisions of the same TLF side by side.
+type revisionDiffer struct {
+	config  Config
+	oldMD   ImmutableRootMetadata
+	newMD   ImmutableRootMetadata
+	entries []RevisionDiffEntry
+
+	// removed and added map the pointers of removed and added
+	// entries to the subtrees they head, if renames are being
+	// detected.
+	removed map[BlockPointer]diffTree
+	added   map[BlockPointer]diffTree
+}
+
+func (d *revisionDiffer) getChildren(ctx context.Context,
+	kmd ImmutableRootMetadata, ptr BlockPointer) (map[string]DirEntry, error) {
+	dblock := NewDirBlock().(*DirBlock)
+	err := d.config.BlockOps().Get(ctx, kmd, ptr, dblock, TransientEntry)
+	if err != nil {
+		return nil, err
+	}
+	return dblock.Children, nil
+}
+
+func fileSize(de DirEntry) uint64 {
+	if de.Type == Dir || de.Type == Sym {
+		return 0
+	}
+	return de.Size
+}
+
+// addTree records p, and everything under it if it's a directory, as
+// added to or removed from the revision that kmd belongs to.
+func (d *revisionDiffer) addTree(ctx context.Context,
+	kmd ImmutableRootMetadata, p string, de DirEntry,
+	change RevisionDiffChange) error {
+	e := RevisionDiffEntry{Path: p, Change: change, Type: de.Type}
+	if change == RevisionDiffAdded {
+		e.NewSize = fileSize(de)
+	} else {
+		e.OldSize = fileSize(de)
+	}
+	start := len(d.entries)
+	d.entries = append(d.entries, e)
+	if de.Type == Dir {
+		children, err := d.getChildren(ctx, kmd, de.BlockPointer)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(children))
+		for name := range children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			err := d.addTree(ctx, kmd, p+"/"+name, children[name], change)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	trees := d.added
+	if change == RevisionDiffRemoved {
+		trees = d.removed
+	}
+	// Symlinks have no blocks to match.
+	if trees != nil && de.BlockPointer.IsInitialized() {
+		trees[de.BlockPointer] = diffTree{start, len(d.entries)}
+	}
+	return nil
+}
+
+// collapseRenames replaces each removed subtree whose root has the
+// same block pointer as the root of an added one with a single
+// renamed entry.  Blocks are never shared between entries, so a
+// matching pointer means the entry was moved, along with everything
+// under it, without being changed.  The entries under a renamed
+// directory match too, but are dropped along with it.
+func (d *revisionDiffer) collapseRenames() {
+	drop := make([]bool, len(d.entries))
+	renames := 0
+	for ptr, rt := range d.removed {
+		at, ok := d.added[ptr]
+		if !ok {
+			continue
+		}
+		for i := rt.start; i < rt.end; i++ {
+			drop[i] = true
+		}
+		for i := at.start + 1; i < at.end; i++ {
+			drop[i] = true
+		}
+		e := &d.entries[at.start]
+		e.Change = RevisionDiffRenamed
+		e.OldPath = d.entries[rt.start].Path
+		e.OldSize = d.entries[rt.start].OldSize
+		renames++
+	}
+	if renames == 0 {
+		return
+	}
+
+	entries := d.entries[:0]
+	for i, e := range d.entries {
+		if !drop[i] {
+			entries = append(entries, e)
+		}
+	}
+	d.entries = entries
+}
+
+// sameKind returns whether an entry that changed from type a to type
+// b was modified, rather than replaced.  Setting or clearing the
+// executable bit counts as a modification.
+func sameKind(a, b EntryType) bool {
+	isFile := func(t EntryType) bool { return t == File || t == Exec }
+	return a == b || (isFile(a) && isFile(b))
+}
+
+// diffDirs compares the directory at p in the two revisions.
+func (d *revisionDiffer) diffDirs(
+	ctx context.Context, p string, oldPtr, newPtr BlockPointer) error {
+	if oldPtr == newPtr {
+		return nil
+	}
+	oldChildren, err := d.getChildren(ctx, d.oldMD, oldPtr)
+	if err != nil {
+		return err
+	}
+	newChildren, err := d.getChildren(ctx, d.newMD, newPtr)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(oldChildren)+len(newChildren))
+	for name := range oldChildren {
+		names = append(names, name)
+	}
+	for name := range newChildren {
+		if _, ok := oldChildren[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath := name
+		if p != "" {
+			childPath = p + "/" + name
+		}
+		oldDE, inOld := oldChildren[name]
+		newDE, inNew := newChildren[name]
+		switch {
+		case inOld && inNew && sameKind(oldDE.Type, newDE.Type):
+			// Blocks are never modified in place, so an unchanged
+			// pointer means an unchanged subtree.
+			if oldDE.Type == newDE.Type &&
+				oldDE.BlockPointer == newDE.BlockPointer &&
+				oldDE.SymPath == newDE.SymPath {
+				continue
+			}
+			if oldDE.Type == Dir {
+				err = d.diffDirs(
+					ctx, childPath, oldDE.BlockPointer, newDE.BlockPointer)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			d.entries = append(d.entries, RevisionDiffEntry{
+				Path:    childPath,
+				Change:  RevisionDiffModified,
+				Type:    newDE.Type,
+				OldSize: fileSize(oldDE),
+				NewSize: fileSize(newDE),
+			})
+		default:
+			// A change of type is a removal followed by an
+			// addition.
+			if inOld {
+				err = d.addTree(
+					ctx, d.oldMD, childPath, oldDE, RevisionDiffRemoved)
+				if err != nil {
+					return err
+				}
+			}
+			if inNew {
+				err = d.addTree(
+					ctx, d.newMD, childPath, newDE, RevisionDiffAdded)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// diffMDs returns the entries that differ between the trees of the
+// two given MDs of the same TLF.  If detectRenames is false, each
+// renamed entry is listed as removed from its old path and added at
+// its new one, along with everything under it.
+func diffMDs(ctx context.Context, config Config,
+	oldMD, newMD ImmutableRootMetadata, detectRenames bool) (
+	[]RevisionDiffEntry, error) {
+	d := &revisionDiffer{config: config, oldMD: oldMD, newMD: newMD}
+	if detectRenames {
+		d.removed = make(map[BlockPointer]diffTree)
+		d.added = make(map[BlockPointer]diffTree)
+	}
+	err := d.diffDirs(ctx, "", oldMD.data.Dir.BlockPointer,
+		newMD.data.Dir.BlockPointer)
+	if err != nil {
+		return nil, err
+	}
+	if detectRenames {
+		d.collapseRenames()
+	}
+	return d.entries, nil
+}
+
+// DiffTLFRevisions returns the entry-level difference between two
+// revisions of the given TLF, such as two pinned snapshots.  Entries
+// that were moved without being changed are listed as renamed.  Like
+// GetDirChildrenAtRevision, it only needs the blocks of the two
+// revisions, and only reads directories that changed between them.
+func DiffTLFRevisions(ctx context.Context, config Config, tlfID tlf.ID,
+	oldRev, newRev MetadataRevision) (TLFRevisionDiff, error) {
+	oldMD, err := getSingleMD(ctx, config, tlfID, NullBranchID, oldRev, Merged)
+	if err != nil {
+		return TLFRevisionDiff{}, err
+	}
+	newMD, err := getSingleMD(ctx, config, tlfID, NullBranchID, newRev, Merged)
+	if err != nil {
+		return TLFRevisionDiff{}, err
+	}
+
+	entries, err := diffMDs(ctx, config, oldMD, newMD, true)
+	if err != nil {
+		return TLFRevisionDiff{}, err
+	}
+	return TLFRevisionDiff{
+		TlfID:       tlfID,
+		OldRevision: oldRev,
+		NewRevision: newRev,
+		Entries:     entries,
+	}, nil
+}
@@ -0,0 +1,438 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that a named snapshot keeps its revision browsable after the
+// folder moves on and quota reclamation runs.
+func TestTLFSnapshots(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock := newTestClockNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+
+	err := kbfsOps.SetHistoryRetention(ctx, fb,
+		HistoryRetention{Policy: HistoryRetentionKeepLatest})
+	require.NoError(t, err)
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, aNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	snapRev := status.Revision
+
+	err = kbfsOps.CreateSnapshot(
+		ctx, fb, "pre-migration", MetadataRevisionUninitialized)
+	require.NoError(t, err)
+	err = kbfsOps.CreateSnapshot(ctx, fb, "pre-migration", snapRev)
+	require.IsType(t, SnapshotExistsError{}, err)
+	err = kbfsOps.CreateSnapshot(ctx, fb, "a/b", snapRev)
+	require.IsType(t, InvalidSnapshotNameError{}, err)
+	snaps, err := kbfsOps.GetSnapshots(ctx, fb)
+	require.NoError(t, err)
+	require.Len(t, snaps, 1)
+	require.Equal(t, "pre-migration", snaps[0].Name)
+	require.Equal(t, snapRev, snaps[0].Revision)
+	require.Equal(t, clock.Now().UnixNano(), snaps[0].Ctime)
+
+	err = kbfsOps.RemoveEntry(ctx, aNode, "b")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	clock.Add(time.Second)
+	err = kbfsOps.ForceQuotaReclamation(ctx, fb)
+	require.NoError(t, err)
+	err = ops.fbm.waitForQuotaReclamations(ctx)
+	require.NoError(t, err)
+
+	children, err := GetDirChildrenAtRevision(
+		ctx, config, fb.Tlf, snapRev, nil)
+	require.NoError(t, err)
+	require.Contains(t, children, "a")
+	children, err = GetDirChildrenAtRevision(
+		ctx, config, fb.Tlf, snapRev, []string{"a"})
+	require.NoError(t, err)
+	require.Contains(t, children, "b")
+	_, err = GetDirChildrenAtRevision(
+		ctx, config, fb.Tlf, snapRev, []string{"a", "b"})
+	require.IsType(t, NotDirError{}, err)
+
+	err = kbfsOps.DeleteSnapshot(ctx, fb, "pre-migration")
+	require.NoError(t, err)
+	err = kbfsOps.DeleteSnapshot(ctx, fb, "pre-migration")
+	require.IsType(t, NoSuchSnapshotError{}, err)
+	snaps, err = kbfsOps.GetSnapshots(ctx, fb)
+	require.NoError(t, err)
+	require.Len(t, snaps, 0)
+}
+
+// Test that the difference between two snapshots lists every added,
+// removed and modified entry, with its byte delta.
+func TestDiffTLFRevisions(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.CreateFile(ctx, aNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, bNode, []byte("hello"), 0)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, aNode, "c", false, NoExcl)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "unchanged", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, bNode)
+	require.NoError(t, err)
+	err = kbfsOps.CreateSnapshot(
+		ctx, fb, "before", MetadataRevisionUninitialized)
+	require.NoError(t, err)
+
+	err = kbfsOps.Write(ctx, bNode, []byte(" world"), 5)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, bNode)
+	require.NoError(t, err)
+	err = kbfsOps.RemoveEntry(ctx, aNode, "c")
+	require.NoError(t, err)
+	dNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, dNode, "e", "../a/b")
+	require.NoError(t, err)
+	err = kbfsOps.CreateSnapshot(
+		ctx, fb, "after", MetadataRevisionUninitialized)
+	require.NoError(t, err)
+
+	snaps, err := kbfsOps.GetSnapshots(ctx, fb)
+	require.NoError(t, err)
+	require.Len(t, snaps, 2)
+	after, before := snaps[0], snaps[1]
+	diff, err := DiffTLFRevisions(
+		ctx, config, fb.Tlf, before.Revision, after.Revision)
+	require.NoError(t, err)
+	require.Equal(t, []RevisionDiffEntry{
+		{Path: "a/b", Change: RevisionDiffModified, Type: File,
+			OldSize: 5, NewSize: 11},
+		{Path: "a/c", Change: RevisionDiffRemoved, Type: File},
+		{Path: "d", Change: RevisionDiffAdded, Type: Dir},
+		{Path: "d/e", Change: RevisionDiffAdded, Type: Sym},
+	}, diff.Entries)
+	require.Equal(t, int64(6), diff.ByteDelta())
+
+	diff, err = DiffTLFRevisions(
+		ctx, config, fb.Tlf, after.Revision, after.Revision)
+	require.NoError(t, err)
+	require.Len(t, diff.Entries, 0)
+}
+
+// Test that entries moved without being changed are listed as
+// renamed, rather than as removed and added.
+func TestDiffTLFRevisionsRenames(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.CreateFile(ctx, aNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, bNode, []byte("hello"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, bNode)
+	require.NoError(t, err)
+	cNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "c", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, cNode, []byte("abc"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, cNode)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "d", false, NoExcl)
+	require.NoError(t, err)
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	oldRev := status.Revision
+
+	// A renamed directory, a file moved into a new directory, and a
+	// file that's renamed and then written to.
+	err = kbfsOps.Rename(ctx, rootNode, "a", rootNode, "z")
+	require.NoError(t, err)
+	eNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "e")
+	require.NoError(t, err)
+	err = kbfsOps.Rename(ctx, rootNode, "c", eNode, "c2")
+	require.NoError(t, err)
+	err = kbfsOps.Rename(ctx, rootNode, "d", rootNode, "d2")
+	require.NoError(t, err)
+	d2Node, _, err := kbfsOps.Lookup(ctx, rootNode, "d2")
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, d2Node, []byte("x"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, d2Node)
+	require.NoError(t, err)
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+
+	diff, err := DiffTLFRevisions(
+		ctx, config, fb.Tlf, oldRev, status.Revision)
+	require.NoError(t, err)
+	require.Equal(t, []RevisionDiffEntry{
+		{Path: "d", Change: RevisionDiffRemoved, Type: File},
+		{Path: "d2", Change: RevisionDiffAdded, Type: File, NewSize: 1},
+		{Path: "e", Change: RevisionDiffAdded, Type: Dir},
+		{Path: "e/c2", OldPath: "c", Change: RevisionDiffRenamed,
+			Type: File, OldSize: 3, NewSize: 3},
+		{Path: "z", OldPath: "a", Change: RevisionDiffRenamed, Type: Dir},
+	}, diff.Entries)
+}
+
+// Test that an archived revision reads a file's old contents after
+// the file has been overwritten and removed.
+func TestArchivedRevision(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.CreateFile(ctx, aNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, bNode, []byte("hello"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, bNode)
+	require.NoError(t, err)
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	oldRev := status.Revision
+
+	err = kbfsOps.Write(ctx, bNode, []byte("HELLO world"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, bNode)
+	require.NoError(t, err)
+	err = kbfsOps.RemoveEntry(ctx, aNode, "b")
+	require.NoError(t, err)
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+
+	revs, err := GetRecentRevisions(ctx, config, fb.Tlf, 2)
+	require.NoError(t, err)
+	require.Equal(t, []MetadataRevision{
+		status.Revision, status.Revision - 1}, revs)
+	revs, err = GetRecentRevisions(ctx, config, fb.Tlf, 100)
+	require.NoError(t, err)
+	require.Len(t, revs, int(status.Revision))
+
+	ar, err := GetArchivedRevision(ctx, config, fb.Tlf, oldRev)
+	require.NoError(t, err)
+	require.Equal(t, oldRev, ar.Revision())
+	children, err := ar.GetDirChildren(ctx, []string{"a"})
+	require.NoError(t, err)
+	require.Contains(t, children, "b")
+	ei, err := ar.Lookup(ctx, []string{"a", "b"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), ei.Size)
+	_, err = ar.Lookup(ctx, []string{"a", "c"})
+	require.IsType(t, NoSuchNameError{}, err)
+	_, err = ar.OpenFile(ctx, []string{"a"})
+	require.IsType(t, NotFileError{}, err)
+
+	f, err := ar.OpenFile(ctx, []string{"a", "b"})
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	n, err := f.Read(ctx, buf, 1)
+	require.NoError(t, err)
+	require.Equal(t, "ello", string(buf[:n]))
+	n, err = f.Read(ctx, buf, 5)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), n)
+}
+
+// Test that restoring a file rewrites only the blocks that changed,
+// in one revision.
+func TestRestoreFile(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	// One block per compared window.
+	config.SetBlockSplitter(
+		&BlockSplitterSimple{restoreFileCompareSize, 2, 100 * 1024})
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	data := make([]byte, 4*restoreFileCompareSize)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	oldRev := status.Revision
+
+	err = kbfsOps.Write(ctx, fileNode, []byte("changed"),
+		2*restoreFileCompareSize+5)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	changedRev := status.Revision
+
+	_, err = kbfsOps.RestoreFile(ctx, rootNode, "a", oldRev)
+	require.NoError(t, err)
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	restoredRev := status.Revision
+	require.Equal(t, changedRev+1, restoredRev)
+	buf := make([]byte, len(data)+1)
+	n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+
+	leafPtrs := func(rev MetadataRevision) map[BlockPointer]bool {
+		ar, err := GetArchivedRevision(ctx, config, fb.Tlf, rev)
+		require.NoError(t, err)
+		f, err := ar.OpenFile(ctx, []string{"a"})
+		require.NoError(t, err)
+		infos, err := f.fd.getIndirectFileBlockInfos(ctx)
+		require.NoError(t, err)
+		ptrs := make(map[BlockPointer]bool)
+		for _, info := range infos {
+			if info.DirectType == DirectBlock {
+				ptrs[info.BlockPointer] = true
+			}
+		}
+		return ptrs
+	}
+	changedPtrs := leafPtrs(changedRev)
+	restoredPtrs := leafPtrs(restoredRev)
+	require.Len(t, restoredPtrs, 4)
+	reused := 0
+	for ptr := range restoredPtrs {
+		if changedPtrs[ptr] {
+			reused++
+		}
+	}
+	require.Equal(t, 3, reused)
+
+	// Restoring a file that hasn't changed does nothing.
+	_, err = kbfsOps.RestoreFile(ctx, rootNode, "a", restoredRev)
+	require.NoError(t, err)
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, restoredRev, status.Revision)
+}
+
+// Test that the chunks of a file show which ranges changed between
+// revisions, and that the changes since a revision are listed.
+func TestTLFChangesSinceAndChunks(t *testing.T) {
+	var userName libkb.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	config.SetBlockSplitter(
+		&BlockSplitterSimple{restoreFileCompareSize, 2, 100 * 1024})
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, userName.String(), false)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+
+	data := make([]byte, 3*restoreFileCompareSize+10)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	oldRev := status.Revision
+
+	err = kbfsOps.Write(ctx, fileNode, []byte("changed"),
+		restoreFileCompareSize+5)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+
+	diff, err := GetTLFChangesSince(ctx, config, fb.Tlf, oldRev)
+	require.NoError(t, err)
+	require.Equal(t, status.Revision, diff.NewRevision)
+	require.Equal(t, []RevisionDiffEntry{
+		{"a", "", RevisionDiffModified, File,
+			uint64(len(data)), uint64(len(data))},
+		{"b", "", RevisionDiffAdded, File, 0, 0},
+	}, diff.Entries)
+
+	chunks := func(rev MetadataRevision) []FileChunk {
+		ar, err := GetArchivedRevision(ctx, config, fb.Tlf, rev)
+		require.NoError(t, err)
+		f, err := ar.OpenFile(ctx, []string{"a"})
+		require.NoError(t, err)
+		chunks, err := f.Chunks(ctx)
+		require.NoError(t, err)
+		return chunks
+	}
+	oldChunks := chunks(oldRev)
+	newChunks := chunks(status.Revision)
+	require.Len(t, oldChunks, 4)
+	require.Len(t, newChunks, 4)
+	var off int64
+	for i, c := range newChunks {
+		require.Equal(t, off, c.Offset)
+		require.Equal(t, oldChunks[i].Offset, c.Offset)
+		require.Equal(t, oldChunks[i].Length, c.Length)
+		off += c.Length
+		if i == 1 {
+			require.NotEqual(t, oldChunks[i].Hash, c.Hash)
+		} else {
+			require.Equal(t, oldChunks[i].Hash, c.Hash)
+		}
+	}
+	require.Equal(t, int64(len(data)), off)
+
+	ar, err := GetArchivedRevision(ctx, config, fb.Tlf, status.Revision)
+	require.NoError(t, err)
+	f, err := ar.OpenFile(ctx, []string{"b"})
+	require.NoError(t, err)
+	emptyChunks, err := f.Chunks(ctx)
+	require.NoError(t, err)
+	require.Len(t, emptyChunks, 0)
+}
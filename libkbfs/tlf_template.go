@@ -0,0 +1,117 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// maxTLFTemplateBytes is the most file data that CreateTLFFrom will
+// copy.  The whole template is held in memory until it's written
+// out in a single MD update, so it needs to stay small.
+const maxTLFTemplateBytes = 64 << 20
+
+// tlfTemplateEntry is an entry in a directory tree that's been read
+// out of a template directory, to be copied into a new TLF.
+type tlfTemplateEntry struct {
+	Type  EntryType
+	Mtime int64
+	// Data holds the contents of a File or Exec entry.
+	Data []byte
+	// SymPath holds the target of a Sym entry.
+	SymPath string
+	// Children holds the entries of a Dir entry.
+	Children map[string]*tlfTemplateEntry
+}
+
+// tlfTemplateReader reads a template directory tree through
+// KBFSOps, keeping track of how much file data it has read so far.
+type tlfTemplateReader struct {
+	kbfsOps  KBFSOps
+	maxBytes uint64
+	size     uint64
+}
+
+func (r *tlfTemplateReader) readFile(
+	ctx context.Context, node Node, p string, size uint64) ([]byte, error) {
+	r.size += size
+	if r.size > r.maxBytes {
+		return nil, TlfTemplateTooBigError{p, r.size, r.maxBytes}
+	}
+
+	data := make([]byte, size)
+	for off := int64(0); off < int64(size); {
+		n, err := r.kbfsOps.Read(ctx, node, data[off:], off)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			// The file shrank since we looked it up.
+			break
+		}
+		off += n
+	}
+	return data, nil
+}
+
+func (r *tlfTemplateReader) readDir(
+	ctx context.Context, dir Node, p string, ei EntryInfo) (
+	*tlfTemplateEntry, error) {
+	children, err := r.kbfsOps.GetDirChildren(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &tlfTemplateEntry{
+		Type:     Dir,
+		Mtime:    ei.Mtime,
+		Children: make(map[string]*tlfTemplateEntry, len(children)),
+	}
+	for name, childEI := range children {
+		childPath := p + "/" + name
+		child := &tlfTemplateEntry{
+			Type:  childEI.Type,
+			Mtime: childEI.Mtime,
+		}
+		switch childEI.Type {
+		case Sym:
+			child.SymPath = childEI.SymPath
+		case File, Exec, Dir:
+			node, _, err := r.kbfsOps.Lookup(ctx, dir, name)
+			if err != nil {
+				return nil, err
+			}
+			if childEI.Type == Dir {
+				child, err = r.readDir(ctx, node, childPath, childEI)
+			} else {
+				child.Data, err = r.readFile(
+					ctx, node, childPath, childEI.Size)
+			}
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("Unexpected entry type %s for %s",
+				childEI.Type, childPath)
+		}
+		entry.Children[name] = child
+	}
+	return entry, nil
+}
+
+// readTLFTemplate reads the whole directory tree under dir, which is
+// at path p and has entry info ei, into memory.  It fails with
+// TlfTemplateTooBigError if the tree holds more than maxBytes of file
+// data.
+func readTLFTemplate(ctx context.Context, kbfsOps KBFSOps, dir Node,
+	p path, ei EntryInfo, maxBytes uint64) (*tlfTemplateEntry, error) {
+	if ei.Type != Dir {
+		return nil, NotDirError{p}
+	}
+	r := &tlfTemplateReader{kbfsOps: kbfsOps, maxBytes: maxBytes}
+	return r.readDir(ctx, dir, p.String(), ei)
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// TLFUsageStats counts the server traffic attributed to a TLF, or to
+// one process's use of a TLF.
+type TLFUsageStats struct {
+	// BytesUploaded and BytesDownloaded count block data only;
+	// MD traffic shows up in RPCs.
+	BytesUploaded   int64
+	BytesDownloaded int64
+	// RPCs counts the server calls made, keyed by method name
+	// (e.g., "BlockServer.Put").
+	RPCs map[string]int64
+}
+
+func (s *TLFUsageStats) add(rpc string, uploaded, downloaded int64) {
+	s.BytesUploaded += uploaded
+	s.BytesDownloaded += downloaded
+	if s.RPCs == nil {
+		s.RPCs = make(map[string]int64)
+	}
+	s.RPCs[rpc]++
+}
+
+func (s TLFUsageStats) deepCopy() TLFUsageStats {
+	rpcs := make(map[string]int64, len(s.RPCs))
+	for rpc, n := range s.RPCs {
+		rpcs[rpc] = n
+	}
+	s.RPCs = rpcs
+	return s
+}
+
+// TLFUsageStatus is the server traffic attributed to a TLF since
+// KBFS started.  It is suitable for encoding directly as JSON.
+type TLFUsageStatus struct {
+	TLFUsageStats
+	// ByProcess breaks the usage down by the name of the process
+	// that caused it, if the mount tagged the request with one
+	// (see NewContextWithProcessName).  Background work, like
+	// journal flushes, isn't attributed to any process.
+	ByProcess map[string]TLFUsageStats `json:",omitempty"`
+}
+
+// CtxProcessNameKeyType is the type for the context key holding the
+// name of the process that made a request.
+type CtxProcessNameKeyType int
+
+const (
+	// CtxProcessNameKey is set by mounts in the context of a
+	// request, so that the server traffic it causes can be
+	// attributed to the process that made it.
+	CtxProcessNameKey CtxProcessNameKeyType = iota
+)
+
+// NewContextWithProcessName returns a context whose server traffic
+// is also attributed to the given process name in the TLF usage
+// status.
+func NewContextWithProcessName(
+	ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, CtxProcessNameKey, name)
+}
+
+func processNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(CtxProcessNameKey).(string)
+	return name
+}
+
+// tlfUsageTracker attributes server traffic to TLFs, for cost
+// analysis.
+type tlfUsageTracker struct {
+	lock  sync.Mutex
+	usage map[tlf.ID]*TLFUsageStatus
+}
+
+func newTLFUsageTracker() *tlfUsageTracker {
+	return &tlfUsageTracker{
+		usage: make(map[tlf.ID]*TLFUsageStatus),
+	}
+}
+
+// record attributes one server call, and the block bytes it
+// transferred, to the given TLF, and to the process named in ctx if
+// there is one.
+func (t *tlfUsageTracker) record(ctx context.Context, tlfID tlf.ID,
+	rpc string, uploaded, downloaded int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	status, ok := t.usage[tlfID]
+	if !ok {
+		status = &TLFUsageStatus{}
+		t.usage[tlfID] = status
+	}
+	status.add(rpc, uploaded, downloaded)
+
+	name := processNameFromContext(ctx)
+	if name == "" {
+		return
+	}
+	if status.ByProcess == nil {
+		status.ByProcess = make(map[string]TLFUsageStats)
+	}
+	stats := status.ByProcess[name]
+	stats.add(rpc, uploaded, downloaded)
+	status.ByProcess[name] = stats
+}
+
+func (t *tlfUsageTracker) copyStatusLocked(
+	status *TLFUsageStatus) *TLFUsageStatus {
+	c := &TLFUsageStatus{TLFUsageStats: status.TLFUsageStats.deepCopy()}
+	if status.ByProcess != nil {
+		c.ByProcess = make(map[string]TLFUsageStats, len(status.ByProcess))
+		for name, stats := range status.ByProcess {
+			c.ByProcess[name] = stats.deepCopy()
+		}
+	}
+	return c
+}
+
+// getStatus returns a copy of the usage of the given TLF, or nil if
+// it hasn't caused any server traffic.
+func (t *tlfUsageTracker) getStatus(tlfID tlf.ID) *TLFUsageStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	status, ok := t.usage[tlfID]
+	if !ok {
+		return nil
+	}
+	return t.copyStatusLocked(status)
+}
+
+// getAllStatuses returns a copy of the usage of every TLF that has
+// caused server traffic, keyed by TLF ID.
+func (t *tlfUsageTracker) getAllStatuses() map[string]*TLFUsageStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.usage) == 0 {
+		return nil
+	}
+	statuses := make(map[string]*TLFUsageStatus, len(t.usage))
+	for tlfID, status := range t.usage {
+		statuses[tlfID.String()] = t.copyStatusLocked(status)
+	}
+	return statuses
+}
+
+// blockServerAccounted delegates to another BlockServer, and
+// attributes the traffic of each call to its TLF.
+type blockServerAccounted struct {
+	BlockServer
+	usage *tlfUsageTracker
+}
+
+var _ BlockServer = blockServerAccounted{}
+
+func newBlockServerAccounted(
+	delegate BlockServer, usage *tlfUsageTracker) blockServerAccounted {
+	return blockServerAccounted{delegate, usage}
+}
+
+// Get implements the BlockServer interface for blockServerAccounted.
+func (b blockServerAccounted) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	buf, serverHalf, err := b.BlockServer.Get(ctx, tlfID, id, context)
+	b.usage.record(ctx, tlfID, "BlockServer.Get", 0, int64(len(buf)))
+	return buf, serverHalf, err
+}
+
+// Put implements the BlockServer interface for blockServerAccounted.
+func (b blockServerAccounted) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	err := b.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
+	var uploaded int64
+	if err == nil {
+		uploaded = int64(len(buf))
+	}
+	b.usage.record(ctx, tlfID, "BlockServer.Put", uploaded, 0)
+	return err
+}
+
+// AddBlockReference implements the BlockServer interface for
+// blockServerAccounted.
+func (b blockServerAccounted) AddBlockReference(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID, context kbfsblock.Context) error {
+	b.usage.record(ctx, tlfID, "BlockServer.AddBlockReference", 0, 0)
+	return b.BlockServer.AddBlockReference(ctx, tlfID, id, context)
+}
+
+// RemoveBlockReferences implements the BlockServer interface for
+// blockServerAccounted.
+func (b blockServerAccounted) RemoveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) (
+	map[kbfsblock.ID]int, error) {
+	b.usage.record(ctx, tlfID, "BlockServer.RemoveBlockReferences", 0, 0)
+	return b.BlockServer.RemoveBlockReferences(ctx, tlfID, contexts)
+}
+
+// ArchiveBlockReferences implements the BlockServer interface for
+// blockServerAccounted.
+func (b blockServerAccounted) ArchiveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) error {
+	b.usage.record(ctx, tlfID, "BlockServer.ArchiveBlockReferences", 0, 0)
+	return b.BlockServer.ArchiveBlockReferences(ctx, tlfID, contexts)
+}
+
+// mdServerAccounted delegates to another MDServer, and counts the
+// calls made for each TLF.
+type mdServerAccounted struct {
+	MDServer
+	usage *tlfUsageTracker
+}
+
+var _ MDServer = mdServerAccounted{}
+
+func newMDServerAccounted(
+	delegate MDServer, usage *tlfUsageTracker) mdServerAccounted {
+	return mdServerAccounted{delegate, usage}
+}
+
+// GetForHandle implements the MDServer interface for
+// mdServerAccounted.
+func (md mdServerAccounted) GetForHandle(ctx context.Context,
+	handle tlf.Handle, mStatus MergeStatus) (
+	tlf.ID, *RootMetadataSigned, error) {
+	id, rmds, err := md.MDServer.GetForHandle(ctx, handle, mStatus)
+	if id != tlf.NullID {
+		md.usage.record(ctx, id, "MDServer.GetForHandle", 0, 0)
+	}
+	return id, rmds, err
+}
+
+// GetForTLF implements the MDServer interface for mdServerAccounted.
+func (md mdServerAccounted) GetForTLF(ctx context.Context, id tlf.ID,
+	bid BranchID, mStatus MergeStatus) (*RootMetadataSigned, error) {
+	md.usage.record(ctx, id, "MDServer.GetForTLF", 0, 0)
+	return md.MDServer.GetForTLF(ctx, id, bid, mStatus)
+}
+
+// GetRange implements the MDServer interface for mdServerAccounted.
+func (md mdServerAccounted) GetRange(ctx context.Context, id tlf.ID,
+	bid BranchID, mStatus MergeStatus, start, stop MetadataRevision) (
+	[]*RootMetadataSigned, error) {
+	md.usage.record(ctx, id, "MDServer.GetRange", 0, 0)
+	return md.MDServer.GetRange(ctx, id, bid, mStatus, start, stop)
+}
+
+// Put implements the MDServer interface for mdServerAccounted.
+func (md mdServerAccounted) Put(ctx context.Context,
+	rmds *RootMetadataSigned, extra ExtraMetadata) error {
+	md.usage.record(ctx, rmds.MD.TlfID(), "MDServer.Put", 0, 0)
+	return md.MDServer.Put(ctx, rmds, extra)
+}
+
+// PruneBranch implements the MDServer interface for
+// mdServerAccounted.
+func (md mdServerAccounted) PruneBranch(
+	ctx context.Context, id tlf.ID, bid BranchID) error {
+	md.usage.record(ctx, id, "MDServer.PruneBranch", 0, 0)
+	return md.MDServer.PruneBranch(ctx, id, bid)
+}
+
+// GetKeyBundles implements the MDServer interface for
+// mdServerAccounted.
+func (md mdServerAccounted) GetKeyBundles(ctx context.Context,
+	tlfID tlf.ID, wkbID TLFWriterKeyBundleID, rkbID TLFReaderKeyBundleID) (
+	*TLFWriterKeyBundleV3, *TLFReaderKeyBundleV3, error) {
+	md.usage.record(ctx, tlfID, "MDServer.GetKeyBundles", 0, 0)
+	return md.MDServer.GetKeyBundles(ctx, tlfID, wkbID, rkbID)
+}
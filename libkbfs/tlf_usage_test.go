@@ -0,0 +1,108 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestBlockServerAccounted(t *testing.T) {
+	log := logger.NewTestLogger(t)
+	usage := newTLFUsageTracker()
+	b := newBlockServerAccounted(NewBlockServerMemory(log), usage)
+	tlfID1 := tlf.FakeID(1, false)
+	tlfID2 := tlf.FakeID(2, false)
+	uid := keybase1.MakeTestUID(1)
+	bCtx := kbfsblock.MakeFirstContext(uid, keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	err = b.Put(ctx, tlfID1, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+	catCtx := NewContextWithProcessName(ctx, "cat")
+	_, _, err = b.Get(catCtx, tlfID1, bID, bCtx)
+	require.NoError(t, err)
+	_, _, err = b.Get(catCtx, tlfID1, bID, bCtx)
+	require.NoError(t, err)
+
+	require.Equal(t, &TLFUsageStatus{
+		TLFUsageStats: TLFUsageStats{
+			BytesUploaded:   4,
+			BytesDownloaded: 8,
+			RPCs: map[string]int64{
+				"BlockServer.Put": 1,
+				"BlockServer.Get": 2,
+			},
+		},
+		ByProcess: map[string]TLFUsageStats{
+			"cat": {
+				BytesDownloaded: 8,
+				RPCs:            map[string]int64{"BlockServer.Get": 2},
+			},
+		},
+	}, usage.getStatus(tlfID1))
+	require.Nil(t, usage.getStatus(tlfID2))
+
+	// A failed get transfers nothing, but still counts as an RPC
+	// to the right TLF.
+	_, _, err = b.Get(ctx, tlfID2, kbfsblock.FakeID(1), bCtx)
+	require.Error(t, err)
+	status2 := usage.getStatus(tlfID2)
+	require.Equal(t, int64(0), status2.BytesDownloaded)
+	require.Equal(t, int64(1), status2.RPCs["BlockServer.Get"])
+
+	// Statuses are copies.
+	status2.RPCs["BlockServer.Get"] = 100
+	require.Equal(t, int64(1),
+		usage.getStatus(tlfID2).RPCs["BlockServer.Get"])
+
+	all := usage.getAllStatuses()
+	require.Len(t, all, 2)
+	require.Equal(t, usage.getStatus(tlfID1), all[tlfID1.String()])
+}
+
+func TestKBFSOpsFolderStatusUsage(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	config.SetBlockServer(
+		newBlockServerAccounted(config.BlockServer(), kbfsOps.usage))
+	config.SetMDServer(
+		newMDServerAccounted(config.MDServer(), kbfsOps.usage))
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	fileNode, _, err := kbfsOps.CreateFile(
+		ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	data := []byte{1, 2, 3, 4, 5}
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	status, _, err := kbfsOps.FolderStatus(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	require.NotNil(t, status.Usage)
+	require.True(t, status.Usage.BytesUploaded >= int64(len(data)))
+	require.NotZero(t, status.Usage.RPCs["BlockServer.Put"])
+	require.NotZero(t, status.Usage.RPCs["MDServer.Put"])
+
+	kbfsStatus, _, err := kbfsOps.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, status.Usage,
+		kbfsStatus.TLFUsage[rootNode.GetFolderBranch().Tlf.String()])
+}
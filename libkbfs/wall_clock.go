@@ -13,3 +13,36 @@ type wallClock struct {
 func (wc wallClock) Now() time.Time {
 	return time.Now()
 }
+
+// NewTimer implements the Clock interface for wallClock.
+func (wc wallClock) NewTimer(d time.Duration) Timer {
+	return wallTimer{time.NewTimer(d)}
+}
+
+// NewTicker implements the Clock interface for wallClock.
+func (wc wallClock) NewTicker(d time.Duration) Ticker {
+	return wallTicker{time.NewTicker(d)}
+}
+
+// AfterFunc implements the Clock interface for wallClock.
+func (wc wallClock) AfterFunc(d time.Duration, f func()) Timer {
+	return wallTimer{time.AfterFunc(d, f)}
+}
+
+type wallTimer struct {
+	*time.Timer
+}
+
+// C implements the Timer interface for wallTimer.
+func (wt wallTimer) C() <-chan time.Time {
+	return wt.Timer.C
+}
+
+type wallTicker struct {
+	*time.Ticker
+}
+
+// C implements the Ticker interface for wallTicker.
+func (wt wallTicker) C() <-chan time.Time {
+	return wt.Ticker.C
+}
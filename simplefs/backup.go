@@ -0,0 +1,156 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"fmt"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// The endpoints in this file let backup tools back up a folder
+// incrementally: list what changed since the revision of their last
+// run, compare the content hashes of the modified files with the ones
+// they stored, and read just the byte ranges whose hashes differ.
+// Everything is read as of a fixed revision, so a run sees a
+// consistent folder even while it's being written to.
+
+var errNotFolderRoot = simpleFSError{"Path is not the root of a folder"}
+
+// SimpleFSChangesSinceArg is the argument to SimpleFSChangesSince.
+type SimpleFSChangesSinceArg struct {
+	// Path is the root of the folder.
+	Path     keybase1.Path
+	Revision int64
+}
+
+func (a SimpleFSChangesSinceArg) String() string {
+	return fmt.Sprintf("%s (since revision %d)", a.Path.Kbfs(), a.Revision)
+}
+
+// SimpleFSRevisionPathArg is the argument to SimpleFSContentHashes.
+type SimpleFSRevisionPathArg struct {
+	Path     keybase1.Path
+	Revision int64
+}
+
+func (a SimpleFSRevisionPathArg) String() string {
+	return fmt.Sprintf("%s (revision %d)", a.Path.Kbfs(), a.Revision)
+}
+
+// SimpleFSReadRevisionArg is the argument to SimpleFSReadRevision.
+type SimpleFSReadRevisionArg struct {
+	Path     keybase1.Path
+	Revision int64
+	Offset   int64
+	Size     int
+}
+
+func (a SimpleFSReadRevisionArg) String() string {
+	return fmt.Sprintf("%s (revision %d, %d bytes at %d)",
+		a.Path.Kbfs(), a.Revision, a.Size, a.Offset)
+}
+
+// openArchivedFile returns the file at the given remote path, as of
+// the given revision of its folder.
+func (k *SimpleFS) openArchivedFile(ctx context.Context, path keybase1.Path,
+	rev int64) (*libkbfs.ArchivedFile, error) {
+	pt, err := path.PathType()
+	if err != nil {
+		return nil, err
+	}
+	if pt != keybase1.PathType_KBFS {
+		return nil, errOnlyRemotePathSupported
+	}
+	root, _, ps, err := k.getRemoteRootNode(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(ps) == 0 {
+		return nil, errInvalidRemotePath
+	}
+	ar, err := libkbfs.GetArchivedRevision(ctx, k.config,
+		root.GetFolderBranch().Tlf, libkbfs.MetadataRevision(rev))
+	if err != nil {
+		return nil, err
+	}
+	return ar.OpenFile(ctx, ps)
+}
+
+// SimpleFSChangesSince - List the entries of the folder at path that
+// changed between the given revision and the latest one, which is
+// returned as the diff's NewRevision.  Passing the NewRevision of the
+// previous call lists what changed since.
+func (k *SimpleFS) SimpleFSChangesSince(ctx context.Context,
+	arg SimpleFSChangesSinceArg) (_ libkbfs.TLFRevisionDiff, err error) {
+	ctx, err = k.startSyncOp(ctx, "ChangesSince", arg)
+	if err != nil {
+		return libkbfs.TLFRevisionDiff{}, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	pt, err := arg.Path.PathType()
+	if err != nil {
+		return libkbfs.TLFRevisionDiff{}, err
+	}
+	if pt != keybase1.PathType_KBFS {
+		return libkbfs.TLFRevisionDiff{}, errOnlyRemotePathSupported
+	}
+	root, _, ps, err := k.getRemoteRootNode(ctx, arg.Path)
+	if err != nil {
+		return libkbfs.TLFRevisionDiff{}, err
+	}
+	if len(ps) != 0 {
+		return libkbfs.TLFRevisionDiff{}, errNotFolderRoot
+	}
+	return libkbfs.GetTLFChangesSince(ctx, k.config,
+		root.GetFolderBranch().Tlf, libkbfs.MetadataRevision(arg.Revision))
+}
+
+// SimpleFSContentHashes - Return the chunks making up the file at
+// path as of the given revision of its folder, each with a hash of
+// its contents.  Only the chunks whose hashes changed since a
+// previous revision need to be read again.
+func (k *SimpleFS) SimpleFSContentHashes(ctx context.Context,
+	arg SimpleFSRevisionPathArg) (_ []libkbfs.FileChunk, err error) {
+	ctx, err = k.startSyncOp(ctx, "ContentHashes", arg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	f, err := k.openArchivedFile(ctx, arg.Path, arg.Revision)
+	if err != nil {
+		return nil, err
+	}
+	return f.Chunks(ctx)
+}
+
+// SimpleFSReadRevision - Read up to size bytes at offset of the file
+// at path, as of the given revision of its folder.  Reading at or past
+// the end of the file returns no data.
+func (k *SimpleFS) SimpleFSReadRevision(ctx context.Context,
+	arg SimpleFSReadRevisionArg) (_ keybase1.FileContent, err error) {
+	ctx, err = k.startSyncOp(ctx, "ReadRevision", arg)
+	if err != nil {
+		return keybase1.FileContent{}, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	f, err := k.openArchivedFile(ctx, arg.Path, arg.Revision)
+	if err != nil {
+		return keybase1.FileContent{}, err
+	}
+	bs := make([]byte, arg.Size)
+	n, err := f.Read(ctx, bs, arg.Offset)
+	if err != nil {
+		return keybase1.FileContent{}, err
+	}
+	return keybase1.FileContent{
+		Data: bs[:n],
+	}, nil
+}
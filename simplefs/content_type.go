@@ -0,0 +1,134 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"mime"
+	"net/http"
+	stdpath "path"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const (
+	// contentTypeCacheSize is how many detected content types are
+	// remembered.
+	contentTypeCacheSize = 10000
+	// sniffLen is how much of the start of a file is read to
+	// detect its content type; it's all that
+	// http.DetectContentType looks at, and always within the first
+	// block.
+	sniffLen = 512
+	// genericContentType is what http.DetectContentType returns
+	// when it doesn't recognize the data.
+	genericContentType = "application/octet-stream"
+)
+
+// DirentMetadata is a keybase1.Dirent along with metadata that takes
+// more work to find.
+type DirentMetadata struct {
+	keybase1.Dirent
+	// ContentType is the MIME type of a file, like "image/png", or
+	// empty for anything that isn't a file.
+	ContentType string
+	// LastWriter is the username of whoever last changed the entry,
+	// LastWriterDevice is the KID of the device they used, and
+	// LastWriterRevision is the folder revision of the change.  All
+	// are empty for entries last changed before writers were
+	// recorded.
+	LastWriter         string
+	LastWriterDevice   keybase1.KID
+	LastWriterRevision int64
+}
+
+// detectContentType returns the MIME type of a file, given the start
+// of its contents and its name.  The contents take priority, and the
+// extension of the name is only used when they aren't recognized.
+func detectContentType(data []byte, name string) string {
+	ct := http.DetectContentType(data)
+	if ct != genericContentType {
+		return ct
+	}
+	if byExt := mime.TypeByExtension(stdpath.Ext(name)); byExt != "" {
+		return byExt
+	}
+	return ct
+}
+
+// getContentType returns the MIME type of the file at node, reading
+// the start of it the first time the file is seen.  The detected type
+// is cached by the ID of the file's top block, which changes whenever
+// the file is synced, so types aren't cached for files with unsynced
+// writes.
+func (k *SimpleFS) getContentType(ctx context.Context, node libkbfs.Node,
+	ei libkbfs.EntryInfo, name string) (string, error) {
+	if ei.Type != libkbfs.File && ei.Type != libkbfs.Exec {
+		return "", nil
+	}
+	md, err := k.config.KBFSOps().GetNodeMetadata(ctx, node)
+	if err != nil {
+		return "", err
+	}
+	fileID := md.BlockInfo.ID
+	fb := node.GetFolderBranch()
+	dirty := k.config.DirtyBlockCache().IsDirty(
+		fb.Tlf, md.BlockInfo.BlockPointer, fb.Branch)
+	if !dirty {
+		if tmp, ok := k.contentTypes.Get(fileID); ok {
+			if ct, ok := tmp.(string); ok {
+				return ct, nil
+			}
+		}
+	}
+
+	buf := make([]byte, sniffLen)
+	n, err := k.config.KBFSOps().Read(ctx, node, buf, 0)
+	if err != nil {
+		return "", err
+	}
+	ct := detectContentType(buf[:n], name)
+	if !dirty {
+		k.contentTypes.Add(fileID, ct)
+	}
+	return ct, nil
+}
+
+// SimpleFSStatMetadata - Get info about file, including its content
+// type, which is detected from its contents and cached, and who last
+// changed it.  Unlike
+// SimpleFSStat, this may read from the file.
+func (k *SimpleFS) SimpleFSStatMetadata(ctx context.Context,
+	path keybase1.Path) (_ DirentMetadata, err error) {
+	ctx, err = k.startSyncOp(ctx, "StatMetadata", path)
+	if err != nil {
+		return DirentMetadata{}, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	node, ei, err := k.getRemoteNode(ctx, path)
+	de, err := wrapStat(ei, err)
+	if err != nil {
+		return DirentMetadata{}, err
+	}
+	de.Name = stdpath.Base(path.Kbfs())
+	ct, err := k.getContentType(ctx, node, ei, de.Name)
+	if err != nil {
+		return DirentMetadata{}, err
+	}
+	res := DirentMetadata{Dirent: de, ContentType: ct}
+	if ei.LastWriterUID != "" {
+		name, err := k.config.KBPKI().GetNormalizedUsername(
+			ctx, ei.LastWriterUID)
+		if err != nil {
+			return DirentMetadata{}, err
+		}
+		res.LastWriter = name.String()
+		res.LastWriterDevice = ei.LastWriterKID
+		res.LastWriterRevision = int64(ei.LastWriterRevision)
+	}
+	return res, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SimpleFSSuggestFavoriteCleanupArg is the argument to
+// SimpleFSSuggestFavoriteCleanup.
+type SimpleFSSuggestFavoriteCleanupArg struct {
+	// StaleAfter is how long a folder must have gone without being
+	// accessed on this device to be suggested.
+	StaleAfter time.Duration
+	// ArchiveBytes is the size at which a stale folder is suggested
+	// for archiving before it's unfavorited.  If it's 0, no folders
+	// are suggested for archiving.
+	ArchiveBytes uint64
+}
+
+func (a SimpleFSSuggestFavoriteCleanupArg) String() string {
+	return fmt.Sprintf("stale after %s, archive at %d bytes",
+		a.StaleAfter, a.ArchiveBytes)
+}
+
+// SimpleFSDeleteFavoritesArg is the argument to SimpleFSDeleteFavorites.
+type SimpleFSDeleteFavoritesArg struct {
+	// Paths are the roots of the folders to unfavorite.
+	Paths []keybase1.Path
+}
+
+func (a SimpleFSDeleteFavoritesArg) String() string {
+	ps := make([]string, 0, len(a.Paths))
+	for _, p := range a.Paths {
+		ps = append(ps, p.Kbfs())
+	}
+	return strings.Join(ps, ", ")
+}
+
+// SimpleFSSuggestFavoriteCleanup - List the favorite folders that
+// haven't been accessed on this device for a while, least recently
+// accessed first, and which of them are big enough to archive
+// before unfavoriting.
+func (k *SimpleFS) SimpleFSSuggestFavoriteCleanup(ctx context.Context,
+	arg SimpleFSSuggestFavoriteCleanupArg) (
+	_ []libkbfs.FavoriteSuggestion, err error) {
+	ctx, err = k.startSyncOp(ctx, "SuggestFavoriteCleanup", arg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	return k.config.KBFSOps().GetFavoriteCleanupSuggestions(
+		ctx, libkbfs.FavoriteCleanupParams{
+			StaleAfter:   arg.StaleAfter,
+			ArchiveBytes: arg.ArchiveBytes,
+		})
+}
+
+// SimpleFSDeleteFavorites - Unfavorite all the folders at the given
+// paths at once.
+func (k *SimpleFS) SimpleFSDeleteFavorites(ctx context.Context,
+	arg SimpleFSDeleteFavoritesArg) (err error) {
+	ctx, err = k.startSyncOp(ctx, "DeleteFavorites", arg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	favs := make([]libkbfs.Favorite, 0, len(arg.Paths))
+	for _, path := range arg.Paths {
+		name, public, err := remoteFolderName(path)
+		if err != nil {
+			return err
+		}
+		// Favorites are stored under their canonical names.
+		p, err := libkbfs.PreflightTlfName(
+			ctx, k.config.KBPKI(), name, public)
+		if err != nil {
+			return err
+		}
+		favs = append(favs, libkbfs.Favorite{
+			Name:   string(p.CanonicalName),
+			Public: public,
+		})
+	}
+	return k.config.KBFSOps().DeleteFavorites(ctx, favs)
+}
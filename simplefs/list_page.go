@@ -0,0 +1,235 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"golang.org/x/net/context"
+)
+
+const (
+	// defaultListPageSize is the number of entries in a page when
+	// the caller doesn't ask for a number.
+	defaultListPageSize = 1000
+	// maxListPageSize is the most entries a page may have.
+	maxListPageSize = 10000
+)
+
+// ListSortOrder says how the entries of a listing are ordered.
+// Entries that compare equal are ordered by name.
+type ListSortOrder int
+
+const (
+	// ListSortByName orders entries by name.
+	ListSortByName ListSortOrder = iota
+	// ListSortByMtime orders entries by modification time.
+	ListSortByMtime
+	// ListSortBySize orders entries by size.
+	ListSortBySize
+)
+
+// SimpleFSListPageArg is the argument to SimpleFSListPage.
+type SimpleFSListPageArg struct {
+	Path       keybase1.Path
+	SortBy     ListSortOrder
+	Descending bool
+	// Cursor is empty for the first page, and the NextCursor of
+	// the previous page otherwise.
+	Cursor string
+	// Limit is the most entries to return.  If zero, a default is
+	// used.
+	Limit int
+}
+
+// SimpleFSListPageResult is one page of a listing.
+type SimpleFSListPageResult struct {
+	Entries []keybase1.Dirent
+	// NextCursor is empty if this is the last page.
+	NextCursor string
+}
+
+var errBadListCursor = simpleFSError{"Invalid list cursor"}
+
+// listCursor is the last entry of a page, encoded into an opaque
+// string.  The next page starts right after it, so entries that are
+// added or removed between pages don't make the listing skip or
+// repeat the others.
+type listCursor struct {
+	SortBy     ListSortOrder
+	Descending bool
+	Name       string
+	Mtime      int64
+	Size       uint64
+}
+
+func (c listCursor) encode() (string, error) {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func decodeListCursor(s string) (c listCursor, err error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return listCursor{}, errBadListCursor
+	}
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return listCursor{}, errBadListCursor
+	}
+	return c, nil
+}
+
+type listEntry struct {
+	name  string
+	mtime int64
+	size  uint64
+}
+
+// listOrder compares listEntries.
+type listOrder struct {
+	sortBy     ListSortOrder
+	descending bool
+}
+
+func (o listOrder) less(a, b listEntry) bool {
+	if o.descending {
+		a, b = b, a
+	}
+	switch o.sortBy {
+	case ListSortByMtime:
+		if a.mtime != b.mtime {
+			return a.mtime < b.mtime
+		}
+	case ListSortBySize:
+		if a.size != b.size {
+			return a.size < b.size
+		}
+	}
+	return a.name < b.name
+}
+
+// listWindow keeps the first n entries, by the given order, of the
+// entries added to it.  It's a heap with the last of them on top, so
+// that a listing only ever holds a page's worth of entries.
+type listWindow struct {
+	order   listOrder
+	n       int
+	entries []listEntry
+}
+
+func (w *listWindow) Len() int           { return len(w.entries) }
+func (w *listWindow) Less(i, j int) bool { return w.order.less(w.entries[j], w.entries[i]) }
+func (w *listWindow) Swap(i, j int)      { w.entries[i], w.entries[j] = w.entries[j], w.entries[i] }
+
+func (w *listWindow) Push(x interface{}) {
+	w.entries = append(w.entries, x.(listEntry))
+}
+
+func (w *listWindow) Pop() interface{} {
+	last := w.entries[len(w.entries)-1]
+	w.entries = w.entries[:len(w.entries)-1]
+	return last
+}
+
+func (w *listWindow) add(e listEntry) {
+	if len(w.entries) < w.n {
+		heap.Push(w, e)
+	} else if w.order.less(e, w.entries[0]) {
+		w.entries[0] = e
+		heap.Fix(w, 0)
+	}
+}
+
+// sorted returns the kept entries in order.  Since the heap order is
+// the reverse of the listing order, that's a reverse sort.
+func (w *listWindow) sorted() []listEntry {
+	sort.Sort(sort.Reverse(w))
+	return w.entries
+}
+
+func (a SimpleFSListPageArg) String() string {
+	return fmt.Sprintf("%s (sort %d, descending %t, limit %d)",
+		a.Path.Kbfs(), a.SortBy, a.Descending, a.Limit)
+}
+
+// SimpleFSListPage - Get one page of the items in the directory at
+// path, in the given order.  Only one page of entries is kept at a
+// time, so large directories can be listed a piece at a time.
+func (k *SimpleFS) SimpleFSListPage(ctx context.Context,
+	arg SimpleFSListPageArg) (_ SimpleFSListPageResult, err error) {
+	ctx, err = k.startSyncOp(ctx, "ListPage", arg)
+	if err != nil {
+		return SimpleFSListPageResult{}, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	switch arg.SortBy {
+	case ListSortByName, ListSortByMtime, ListSortBySize:
+	default:
+		return SimpleFSListPageResult{}, simpleFSError{
+			fmt.Sprintf("Unknown sort order %d", arg.SortBy)}
+	}
+	limit := arg.Limit
+	if limit <= 0 {
+		limit = defaultListPageSize
+	} else if limit > maxListPageSize {
+		limit = maxListPageSize
+	}
+	order := listOrder{arg.SortBy, arg.Descending}
+	var after *listEntry
+	if arg.Cursor != "" {
+		c, err := decodeListCursor(arg.Cursor)
+		if err != nil {
+			return SimpleFSListPageResult{}, err
+		}
+		if c.SortBy != arg.SortBy || c.Descending != arg.Descending {
+			return SimpleFSListPageResult{}, errBadListCursor
+		}
+		after = &listEntry{c.Name, c.Mtime, c.Size}
+	}
+
+	children, err := k.listChildren(ctx, arg.Path)
+	if err != nil {
+		return SimpleFSListPageResult{}, err
+	}
+
+	// Keep one extra entry, to tell whether there's another page.
+	w := &listWindow{order: order, n: limit + 1}
+	for name, ei := range children {
+		e := listEntry{name, ei.Mtime, ei.Size}
+		if after != nil && !order.less(*after, e) {
+			continue
+		}
+		w.add(e)
+	}
+	entries := w.sorted()
+
+	var res SimpleFSListPageResult
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[limit-1]
+		res.NextCursor, err = listCursor{
+			arg.SortBy, arg.Descending, last.name, last.mtime, last.size,
+		}.encode()
+		if err != nil {
+			return SimpleFSListPageResult{}, err
+		}
+	}
+	res.Entries = make([]keybase1.Dirent, len(entries))
+	for i, e := range entries {
+		ei := children[e.name]
+		setStat(&res.Entries[i], &ei)
+		res.Entries[i].Name = e.name
+	}
+	return res, nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SimpleFSPreflightFolderArg is the argument to SimpleFSPreflightFolder.
+type SimpleFSPreflightFolderArg struct {
+	// Path is the root of the proposed folder.
+	Path keybase1.Path
+}
+
+func (a SimpleFSPreflightFolderArg) String() string {
+	return a.Path.Kbfs()
+}
+
+// SimpleFSPreflightFolder - Report which Keybase users the assertions
+// in the name of the folder at path resolve to, without creating it,
+// so the GUI can have the user confirm who they're sharing with.
+func (k *SimpleFS) SimpleFSPreflightFolder(ctx context.Context,
+	arg SimpleFSPreflightFolderArg) (_ libkbfs.TlfNamePreflight, err error) {
+	ctx, err = k.startSyncOp(ctx, "PreflightFolder", arg)
+	if err != nil {
+		return libkbfs.TlfNamePreflight{}, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	name, public, err := remoteFolderName(arg.Path)
+	if err != nil {
+		return libkbfs.TlfNamePreflight{}, err
+	}
+	return libkbfs.PreflightTlfName(ctx, k.config.KBPKI(), name, public)
+}
@@ -0,0 +1,294 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	stdpath "path"
+	"strconv"
+	"strings"
+
+	// Register the decoders for image.Decode.
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	// defaultPreviewDimension is the default bound on the width
+	// and height of a preview, in pixels.
+	defaultPreviewDimension = 256
+	// maxPreviewDimension is the largest bound a caller may ask
+	// for.
+	maxPreviewDimension = 1024
+	previewMimeType     = "image/jpeg"
+	previewQuality      = 80
+)
+
+// SimpleFSGetPreviewArg is the argument to SimpleFSGetPreview.
+type SimpleFSGetPreviewArg struct {
+	Path keybase1.Path
+	// MaxDimension bounds the width and height of the preview, in
+	// pixels.  If zero, a default is used.
+	MaxDimension int
+}
+
+// Preview is a small image showing what a file looks like.
+type Preview struct {
+	MimeType string
+	Data     []byte
+}
+
+var errPreviewsDisabled = simpleFSError{"Previews are not enabled"}
+var errNoPreview = simpleFSError{"No preview available for this file"}
+
+// previewGenerator makes a frame image out of a kind of file, to be
+// scaled down into a preview.
+type previewGenerator struct {
+	// maxSourceBytes is the size of the largest file the generator
+	// will read.
+	maxSourceBytes uint64
+	frame          func(ctx context.Context, r io.Reader) (image.Image, error)
+}
+
+// decodeImageFrame decodes any image format registered with the
+// image package.
+func decodeImageFrame(_ context.Context, r io.Reader) (image.Image, error) {
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// commandFrame returns a frame function that copies the file to a
+// temporary file, and runs an external program on it that writes an
+// image to stdout.  The program isn't bundled, so if it can't be
+// found, there's no preview.
+func commandFrame(name string, args ...string) func(
+	context.Context, io.Reader) (image.Image, error) {
+	return func(ctx context.Context, r io.Reader) (image.Image, error) {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return nil, errNoPreview
+		}
+		f, err := ioutil.TempFile("", "kbfs_preview")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		_, err = io.Copy(f, r)
+		closeErr := f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if closeErr != nil {
+			return nil, closeErr
+		}
+
+		cmdArgs := make([]string, len(args))
+		for i, arg := range args {
+			if arg == "{}" {
+				arg = f.Name()
+			}
+			cmdArgs[i] = arg
+		}
+		out, err := exec.CommandContext(ctx, path, cmdArgs...).Output()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s failed", name)
+		}
+		return decodeImageFrame(ctx, bytes.NewReader(out))
+	}
+}
+
+var (
+	imagePreviews = previewGenerator{32 << 20, decodeImageFrame}
+	pdfPreviews   = previewGenerator{64 << 20, commandFrame(
+		"pdftoppm", "-png", "-singlefile", "-f", "1", "-l", "1", "{}")}
+	videoPreviews = previewGenerator{256 << 20, commandFrame(
+		"ffmpeg", "-v", "error", "-i", "{}", "-frames:v", "1",
+		"-f", "image2pipe", "-vcodec", "png", "-")}
+
+	previewGenerators = map[string]previewGenerator{
+		".gif":  imagePreviews,
+		".jpeg": imagePreviews,
+		".jpg":  imagePreviews,
+		".png":  imagePreviews,
+		".pdf":  pdfPreviews,
+		".avi":  videoPreviews,
+		".m4v":  videoPreviews,
+		".mkv":  videoPreviews,
+		".mov":  videoPreviews,
+		".mp4":  videoPreviews,
+		".webm": videoPreviews,
+	}
+)
+
+// scaleImage returns src scaled down, keeping its aspect ratio, to
+// fit in a maxDim by maxDim square, and drawn over white so that it
+// can be encoded without an alpha channel.  Each pixel is the average
+// of the source pixels it covers.
+func scaleImage(src image.Image, maxDim int) image.Image {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dw, dh := sw, sh
+	if sw > maxDim || sh > maxDim {
+		if sw >= sh {
+			dw, dh = maxDim, sh*maxDim/sw
+		} else {
+			dw, dh = sw*maxDim/sh, maxDim
+		}
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	flat := image.NewRGBA(sb)
+	draw.Draw(flat, sb, image.White, image.ZP, draw.Src)
+	draw.Draw(flat, sb, src, sb.Min, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := sb.Min.Y+y*sh/dh, sb.Min.Y+(y+1)*sh/dh
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := sb.Min.X+x*sw/dw, sb.Min.X+(x+1)*sw/dw
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, b, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := flat.RGBAAt(sx, sy)
+					r += uint32(c.R)
+					g += uint32(c.G)
+					b += uint32(c.B)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				uint8(r / n), uint8(g / n), uint8(b / n), 0xff})
+		}
+	}
+	return dst
+}
+
+// nodeReader reads a file sequentially through KBFSOps.
+type nodeReader struct {
+	ctx    context.Context
+	ops    libkbfs.KBFSOps
+	node   libkbfs.Node
+	offset int64
+}
+
+func (r *nodeReader) Read(bs []byte) (int, error) {
+	n, err := r.ops.Read(r.ctx, r.node, bs, r.offset)
+	if n == 0 && err == nil {
+		return 0, io.EOF
+	}
+	r.offset += n
+	return int(n), err
+}
+
+// SimpleFSGetPreview - Get a preview image of a file, like a
+// thumbnail of an image, video or PDF.  Previews are generated on
+// first use and cached on local disk, never uploaded, until their
+// file changes.
+func (k *SimpleFS) SimpleFSGetPreview(ctx context.Context,
+	arg SimpleFSGetPreviewArg) (_ Preview, err error) {
+	ctx, err = k.startSyncOp(ctx, "GetPreview", arg)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	cache := k.config.DiskPreviewCache()
+	if cache == nil {
+		return Preview{}, errPreviewsDisabled
+	}
+	dim := arg.MaxDimension
+	if dim <= 0 {
+		dim = defaultPreviewDimension
+	} else if dim > maxPreviewDimension {
+		dim = maxPreviewDimension
+	}
+
+	pt, err := arg.Path.PathType()
+	if err != nil {
+		return Preview{}, err
+	}
+	if pt != keybase1.PathType_KBFS {
+		return Preview{}, errOnlyRemotePathSupported
+	}
+	gen, ok := previewGenerators[strings.ToLower(
+		stdpath.Ext(arg.Path.Kbfs()))]
+	if !ok {
+		return Preview{}, errNoPreview
+	}
+	node, ei, err := k.getRemoteNode(ctx, arg.Path)
+	if err != nil {
+		return Preview{}, err
+	}
+	if ei.Type != libkbfs.File && ei.Type != libkbfs.Exec {
+		return Preview{}, errNoPreview
+	}
+	md, err := k.config.KBFSOps().GetNodeMetadata(ctx, node)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	tlfID := node.GetFolderBranch().Tlf
+	fileID := md.BlockInfo.ID
+	kind := previewMimeType + "-" + strconv.Itoa(dim)
+	entry, err := cache.Get(ctx, tlfID, fileID, kind)
+	switch errors.Cause(err).(type) {
+	case nil:
+		return Preview{MimeType: entry.MimeType, Data: entry.Data}, nil
+	case libkbfs.NoSuchPreviewError:
+	default:
+		return Preview{}, err
+	}
+
+	if ei.Size > gen.maxSourceBytes {
+		return Preview{}, errNoPreview
+	}
+	frame, err := gen.frame(
+		ctx, &nodeReader{ctx, k.config.KBFSOps(), node, 0})
+	if err != nil {
+		return Preview{}, err
+	}
+	var buf bytes.Buffer
+	err = jpeg.Encode(
+		&buf, scaleImage(frame, dim), &jpeg.Options{Quality: previewQuality})
+	if err != nil {
+		return Preview{}, err
+	}
+
+	// The preview is still good even if it couldn't be cached.
+	err = cache.Put(ctx, tlfID, fileID, kind, previewMimeType, buf.Bytes())
+	if err != nil {
+		k.log.CDebugf(ctx, "Couldn't cache preview: %+v", err)
+	}
+	return Preview{MimeType: previewMimeType, Data: buf.Bytes()}, nil
+}
+
+func (a SimpleFSGetPreviewArg) String() string {
+	return fmt.Sprintf("%s (max %d)", a.Path.Kbfs(), a.MaxDimension)
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"fmt"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+var errCantRestoreRoot = simpleFSError{"A folder's root can't be restored"}
+
+// SimpleFSRestoreFileArg is the argument to SimpleFSRestoreFile.
+type SimpleFSRestoreFileArg struct {
+	Path     keybase1.Path
+	Revision int64
+}
+
+func (a SimpleFSRestoreFileArg) String() string {
+	return fmt.Sprintf("%s (revision %d)", a.Path.Kbfs(), a.Revision)
+}
+
+// SimpleFSRestoreFile - Rewrite the file at path to its contents as
+// of the given revision of its folder, recreating it if it's been
+// removed since, and return its new stat.
+func (k *SimpleFS) SimpleFSRestoreFile(ctx context.Context,
+	arg SimpleFSRestoreFileArg) (_ keybase1.Dirent, err error) {
+	ctx, err = k.startSyncOp(ctx, "RestoreFile", arg)
+	if err != nil {
+		return keybase1.Dirent{}, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	pt, err := arg.Path.PathType()
+	if err != nil {
+		return keybase1.Dirent{}, err
+	}
+	if pt != keybase1.PathType_KBFS {
+		return keybase1.Dirent{}, errOnlyRemotePathSupported
+	}
+	dir, name, err := k.getRemoteNodeParent(ctx, arg.Path)
+	if err != nil {
+		return keybase1.Dirent{}, err
+	}
+	if name == "" {
+		return keybase1.Dirent{}, errCantRestoreRoot
+	}
+	node, err := k.config.KBFSOps().RestoreFile(
+		ctx, dir, name, libkbfs.MetadataRevision(arg.Revision))
+	if err != nil {
+		return keybase1.Dirent{}, err
+	}
+	return wrapStat(k.config.KBFSOps().Stat(ctx, node))
+}
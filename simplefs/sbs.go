@@ -0,0 +1,93 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"fmt"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// The endpoints in this file manage folders that were shared before
+// signup (SBS), i.e. with social assertions for people who aren't
+// Keybase users yet.  When the folder is rekeyed for them, a
+// REKEYING notification is sent under the folder's new name, with
+// its old path in the "oldFilename" parameter.
+
+// SimpleFSModifySBSFolderArg is the argument to SimpleFSModifySBSFolder.
+type SimpleFSModifySBSFolderArg struct {
+	// Path is the root of the SBS folder.
+	Path keybase1.Path
+	// NewName is the name of the folder to move its contents to.
+	NewName string
+}
+
+func (a SimpleFSModifySBSFolderArg) String() string {
+	return fmt.Sprintf("%s -> %s", a.Path.Kbfs(), a.NewName)
+}
+
+// remoteFolderName returns the name of the folder whose root is at
+// the given remote path.
+func remoteFolderName(path keybase1.Path) (
+	name string, public bool, err error) {
+	ps, public, err := remotePath(path)
+	if err != nil {
+		return "", false, err
+	}
+	if len(ps) != 1 {
+		return "", false, errNotFolderRoot
+	}
+	return ps[0], public, nil
+}
+
+// SimpleFSListSBSFolders - List the favorite folders the current user
+// can write to that are waiting on people to sign up.
+func (k *SimpleFS) SimpleFSListSBSFolders(ctx context.Context) (
+	_ []libkbfs.SBSFolder, err error) {
+	ctx, err = k.startSyncOp(ctx, "ListSBSFolders", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	return libkbfs.GetSBSFolders(ctx, k.config)
+}
+
+// SimpleFSCancelSBSFolder - Remove the contents of the SBS folder at
+// path, and drop it from the favorites.
+func (k *SimpleFS) SimpleFSCancelSBSFolder(ctx context.Context,
+	path keybase1.Path) (err error) {
+	ctx, err = k.startSyncOp(ctx, "CancelSBSFolder", path)
+	if err != nil {
+		return err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	name, public, err := remoteFolderName(path)
+	if err != nil {
+		return err
+	}
+	return libkbfs.CancelSBSFolder(ctx, k.config, name, public)
+}
+
+// SimpleFSModifySBSFolder - Move the contents of the SBS folder at
+// path to the folder with the given new name, and cancel the old
+// one.  Returns the canonical name of the new folder.
+func (k *SimpleFS) SimpleFSModifySBSFolder(ctx context.Context,
+	arg SimpleFSModifySBSFolderArg) (_ libkbfs.CanonicalTlfName, err error) {
+	ctx, err = k.startSyncOp(ctx, "ModifySBSFolder", arg)
+	if err != nil {
+		return "", err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	name, public, err := remoteFolderName(arg.Path)
+	if err != nil {
+		return "", err
+	}
+	return libkbfs.ModifySBSFolder(ctx, k.config, name, public, arg.NewName)
+}
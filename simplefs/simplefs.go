@@ -14,6 +14,7 @@ import (
 
 	"golang.org/x/net/context"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfscrypto"
@@ -27,6 +28,10 @@ type SimpleFS struct {
 	handles    map[keybase1.OpID]*handle
 	inProgress map[keybase1.OpID]*inprogress
 	log        logger.Logger
+
+	// contentTypes maps the ID of a file's top block to its
+	// detected content type.
+	contentTypes *lru.Cache
 }
 
 type inprogress struct {
@@ -39,6 +44,8 @@ type handle struct {
 	node  libkbfs.Node
 	async interface{}
 	path  keybase1.Path
+	// stream is set for write streams.
+	stream *writeStream
 }
 
 // make sure the interface is implemented
@@ -51,11 +58,16 @@ func NewSimpleFS(config libkbfs.Config) keybase1.SimpleFSInterface {
 
 func newSimpleFS(config libkbfs.Config) *SimpleFS {
 	log := config.MakeLogger("simplefs")
+	contentTypes, err := lru.New(contentTypeCacheSize)
+	if err != nil {
+		panic(err.Error())
+	}
 	return &SimpleFS{
-		config:     config,
-		handles:    map[keybase1.OpID]*handle{},
-		inProgress: map[keybase1.OpID]*inprogress{},
-		log:        log,
+		config:       config,
+		handles:      map[keybase1.OpID]*handle{},
+		inProgress:   map[keybase1.OpID]*inprogress{},
+		log:          log,
+		contentTypes: contentTypes,
 	}
 }
 
@@ -68,28 +80,7 @@ func (k *SimpleFS) SimpleFSList(_ context.Context, arg keybase1.SimpleFSListArg)
 		keybase1.ListArgs{
 			OpID: arg.OpID, Path: arg.Path,
 		}), func(ctx context.Context) (err error) {
-		var children map[string]libkbfs.EntryInfo
-
-		rawPath := arg.Path.Kbfs()
-		wantPublic := false
-		switch {
-		case rawPath == `/public`:
-			wantPublic = true
-			fallthrough
-		case rawPath == `/private`:
-			children, err = k.favoriteList(ctx, arg.Path, wantPublic)
-		default:
-			node, ei, err := k.getRemoteNode(ctx, arg.Path)
-			if err != nil {
-				return err
-			}
-			switch ei.Type {
-			case libkbfs.Dir:
-				children, err = k.config.KBFSOps().GetDirChildren(ctx, node)
-			default:
-				children = map[string]libkbfs.EntryInfo{stdpath.Base(arg.Path.Kbfs()): ei}
-			}
-		}
+		children, err := k.listChildren(ctx, arg.Path)
 		if err != nil {
 			return err
 		}
@@ -106,6 +97,32 @@ func (k *SimpleFS) SimpleFSList(_ context.Context, arg keybase1.SimpleFSListArg)
 	})
 }
 
+// listChildren returns the entries of the directory at path, or just
+// the entry of path itself if it isn't a directory.
+func (k *SimpleFS) listChildren(ctx context.Context, path keybase1.Path) (
+	map[string]libkbfs.EntryInfo, error) {
+	rawPath := path.Kbfs()
+	wantPublic := false
+	switch {
+	case rawPath == `/public`:
+		wantPublic = true
+		fallthrough
+	case rawPath == `/private`:
+		return k.favoriteList(ctx, path, wantPublic)
+	default:
+		node, ei, err := k.getRemoteNode(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		switch ei.Type {
+		case libkbfs.Dir:
+			return k.config.KBFSOps().GetDirChildren(ctx, node)
+		default:
+			return map[string]libkbfs.EntryInfo{stdpath.Base(rawPath): ei}, nil
+		}
+	}
+}
+
 func (k *SimpleFS) favoriteList(ctx context.Context, path keybase1.Path, wantPublic bool) (map[string]libkbfs.EntryInfo, error) {
 	session, err := k.config.KBPKI().GetCurrentSession(ctx)
 	// Return empty directory listing if we are not logged in.
@@ -348,7 +365,7 @@ func (k *SimpleFS) SimpleFSRename(ctx context.Context, arg keybase1.SimpleFSRena
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	snode, sleaf, err := k.getRemoteNodeParent(ctx, arg.Src)
 	if err != nil {
@@ -370,7 +387,7 @@ func (k *SimpleFS) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenAr
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	node, _, err := k.open(ctx, arg.Dest, arg.Flags)
 
@@ -391,7 +408,7 @@ func (k *SimpleFS) SimpleFSSetStat(ctx context.Context, arg keybase1.SimpleFSSet
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	node, _, err := k.getRemoteNode(ctx, arg.Dest)
 	if err != nil {
@@ -434,7 +451,7 @@ func (k *SimpleFS) SimpleFSRead(ctx context.Context,
 	if err != nil {
 		return keybase1.FileContent{}, err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	bs := make([]byte, arg.Size)
 	n, err := k.config.KBFSOps().Read(ctx, h.node, bs, arg.Offset)
@@ -446,7 +463,7 @@ func (k *SimpleFS) SimpleFSRead(ctx context.Context,
 
 // SimpleFSWrite - Append content to opened file.
 // May be repeated until OpID is closed.
-func (k *SimpleFS) SimpleFSWrite(ctx context.Context, arg keybase1.SimpleFSWriteArg) error {
+func (k *SimpleFS) SimpleFSWrite(ctx context.Context, arg keybase1.SimpleFSWriteArg) (err error) {
 	k.lock.RLock()
 	h, ok := k.handles[arg.OpID]
 	k.lock.RUnlock()
@@ -454,14 +471,14 @@ func (k *SimpleFS) SimpleFSWrite(ctx context.Context, arg keybase1.SimpleFSWrite
 		return errNoSuchHandle
 	}
 
-	ctx, err := k.startSyncOp(ctx, "Write", keybase1.NewOpDescriptionWithWrite(
+	ctx, err = k.startSyncOp(ctx, "Write", keybase1.NewOpDescriptionWithWrite(
 		keybase1.WriteArgs{
 			OpID: arg.OpID, Path: h.path, Offset: arg.Offset,
 		}))
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	err = k.config.KBFSOps().Write(ctx, h.node, arg.Content, arg.Offset)
 	return err
@@ -494,7 +511,9 @@ func (k *SimpleFS) doRemove(ctx context.Context, path keybase1.Path) error {
 	}
 	switch ei.Type {
 	case libkbfs.Dir:
-		err = k.config.KBFSOps().RemoveDir(ctx, node, leaf)
+		// SimpleFS removals are recursive, and the whole tree goes
+		// away in a single revision.
+		err = k.config.KBFSOps().RemoveDirTree(ctx, node, leaf)
 	default:
 		err = k.config.KBFSOps().RemoveEntry(ctx, node, leaf)
 	}
@@ -507,7 +526,7 @@ func (k *SimpleFS) SimpleFSStat(ctx context.Context, path keybase1.Path) (_ keyb
 	if err != nil {
 		return keybase1.Dirent{}, err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	_, ei, err := k.getRemoteNode(ctx, path)
 	return wrapStat(ei, err)
@@ -527,7 +546,7 @@ func (k *SimpleFS) SimpleFSClose(ctx context.Context, opid keybase1.OpID) (err e
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	k.lock.Lock()
 	defer k.lock.Unlock()
@@ -536,6 +555,10 @@ func (k *SimpleFS) SimpleFSClose(ctx context.Context, opid keybase1.OpID) (err e
 		return errNoSuchHandle
 	}
 	delete(k.handles, opid)
+	if h.stream != nil {
+		// Closing a stream without committing it discards it.
+		return k.abortStream(ctx, h)
+	}
 	if h.node != nil {
 		err = k.config.KBFSOps().Sync(ctx, h.node)
 	}
@@ -603,7 +626,7 @@ func (k *SimpleFS) SimpleFSWait(ctx context.Context, opid keybase1.OpID) error {
 	if !ok {
 		return errNoResult
 	}
-	return err
+	return libkbfs.ToStatusAbleError(err)
 }
 
 // remotePath decodes a remote path for us.
@@ -943,11 +966,14 @@ func (k *SimpleFS) doneOp(ctx context.Context, opid keybase1.OpID, err error) {
 	}
 }
 
-func (k *SimpleFS) doneSyncOp(ctx context.Context, err error) {
+// doneSyncOp cleans up after a synchronous operation, and returns
+// err in a form that exports its status code to the caller.
+func (k *SimpleFS) doneSyncOp(ctx context.Context, err error) error {
 	k.log.CDebugf(ctx, "done sync op, status=%v", err)
 	if ctx != nil {
 		libkbfs.CleanupCancellationDelayer(ctx)
 	}
+	return libkbfs.ToStatusAbleError(err)
 }
 
 func (k *SimpleFS) setResult(opid keybase1.OpID, val interface{}) {
@@ -5,19 +5,28 @@
 package simplefs
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	netcontext "golang.org/x/net/context"
 )
 
 func closeSimpleFS(ctx context.Context, t *testing.T, fs *SimpleFS) {
@@ -292,3 +301,511 @@ func readRemoteFile(ctx context.Context, t *testing.T, sfs *SimpleFS, path keyba
 
 	return data.Data
 }
+
+func TestStatusErrors(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	_, err := sfs.SimpleFSStat(ctx,
+		keybase1.NewPathWithKbfs(`/private/jdoe/nonexistent`))
+	require.Error(t, err)
+	s, ok := err.(keybase1.ToStatusAble)
+	require.True(t, ok, "Error %v doesn't export a status", err)
+	status := s.ToStatus()
+	require.Equal(t, libkbfs.StatusCodeKBFSNoSuchName, status.Code)
+	require.Equal(t, "KBFS_NO_SUCH_NAME", status.Name)
+	require.Equal(t, []keybase1.StringKVPair{{Key: "name", Value: "nonexistent"}},
+		status.Fields)
+}
+
+type testPreviewCache struct {
+	entries map[string]libkbfs.DiskPreviewCacheEntry
+	puts    int
+}
+
+func (c *testPreviewCache) key(
+	tlfID tlf.ID, fileID kbfsblock.ID, kind string) string {
+	return tlfID.String() + fileID.String() + kind
+}
+
+func (c *testPreviewCache) Get(_ netcontext.Context, tlfID tlf.ID,
+	fileID kbfsblock.ID, kind string) (libkbfs.DiskPreviewCacheEntry, error) {
+	entry, ok := c.entries[c.key(tlfID, fileID, kind)]
+	if !ok {
+		return libkbfs.DiskPreviewCacheEntry{},
+			libkbfs.NoSuchPreviewError{FileID: fileID, Kind: kind}
+	}
+	return entry, nil
+}
+
+func (c *testPreviewCache) Put(_ netcontext.Context, tlfID tlf.ID,
+	fileID kbfsblock.ID, kind string, mimeType string, data []byte) error {
+	c.puts++
+	c.entries[c.key(tlfID, fileID, kind)] = libkbfs.DiskPreviewCacheEntry{
+		MimeType: mimeType,
+		Data:     data,
+	}
+	return nil
+}
+
+func (c *testPreviewCache) Shutdown(netcontext.Context) {}
+
+func TestGetPreview(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	sfs := newSimpleFS(config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path1 := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	imagePath := pathAppend(path1, `test.png`)
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	draw.Draw(img, img.Bounds(), image.Black, image.ZP, draw.Src)
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	require.NoError(t, err)
+	writeRemoteFile(ctx, t, sfs, imagePath, buf.Bytes())
+	writeRemoteFile(ctx, t, sfs, pathAppend(path1, `test.txt`), []byte(`foo`))
+
+	_, err = sfs.SimpleFSGetPreview(
+		ctx, SimpleFSGetPreviewArg{Path: imagePath})
+	require.Equal(t, errPreviewsDisabled, err)
+
+	cache := &testPreviewCache{
+		entries: make(map[string]libkbfs.DiskPreviewCacheEntry),
+	}
+	config.SetDiskPreviewCache(cache)
+	preview, err := sfs.SimpleFSGetPreview(
+		ctx, SimpleFSGetPreviewArg{Path: imagePath, MaxDimension: 100})
+	require.NoError(t, err)
+	require.Equal(t, "image/jpeg", preview.MimeType)
+	thumb, err := jpeg.Decode(bytes.NewReader(preview.Data))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 100, 50), thumb.Bounds())
+	require.Equal(t, 1, cache.puts)
+
+	// The second request is served from the cache.
+	preview2, err := sfs.SimpleFSGetPreview(
+		ctx, SimpleFSGetPreviewArg{Path: imagePath, MaxDimension: 100})
+	require.NoError(t, err)
+	require.Equal(t, preview, preview2)
+	require.Equal(t, 1, cache.puts)
+
+	_, err = sfs.SimpleFSGetPreview(ctx,
+		SimpleFSGetPreviewArg{Path: pathAppend(path1, `test.txt`)})
+	require.Equal(t, errNoPreview, err)
+}
+
+func TestStatMetadata(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path1 := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	de, err := sfs.SimpleFSStatMetadata(ctx, path1)
+	require.NoError(t, err)
+	require.Equal(t, keybase1.DirentType_DIR, de.DirentType)
+	require.Equal(t, "", de.ContentType)
+
+	// The contents are sniffed, whatever the name says.
+	filePath := pathAppend(path1, `test.txt`)
+	writeRemoteFile(ctx, t, sfs, filePath, []byte("\x89PNG\x0D\x0A\x1A\x0A"))
+	de, err = sfs.SimpleFSStatMetadata(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, "test.txt", de.Name)
+	require.Equal(t, "image/png", de.ContentType)
+	require.Equal(t, "jdoe", de.LastWriter)
+	require.NotEqual(t, keybase1.KID(""), de.LastWriterDevice)
+	require.True(t, de.LastWriterRevision > 0)
+
+	// A new version of the file gets its type detected again.
+	writeRemoteFile(ctx, t, sfs, filePath, []byte(`<html><body>`))
+	de, err = sfs.SimpleFSStatMetadata(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, "text/html; charset=utf-8", de.ContentType)
+
+	// Unrecognized contents fall back on the extension.
+	assert.Equal(t, "application/pdf",
+		detectContentType([]byte{0, 1, 2}, "test.pdf"))
+	assert.Equal(t, genericContentType,
+		detectContentType([]byte{0, 1, 2}, "test"))
+}
+
+func listAllPages(ctx context.Context, t *testing.T, sfs *SimpleFS,
+	arg SimpleFSListPageArg) (names []string) {
+	for {
+		res, err := sfs.SimpleFSListPage(ctx, arg)
+		require.NoError(t, err)
+		require.True(t, len(res.Entries) <= arg.Limit)
+		for _, de := range res.Entries {
+			names = append(names, de.Name)
+		}
+		if res.NextCursor == "" {
+			return names
+		}
+		arg.Cursor = res.NextCursor
+	}
+}
+
+func TestListPage(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path1 := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	sizes := map[string]int{"a": 3, "b": 5, "c": 1, "d": 4, "e": 2}
+	for name, size := range sizes {
+		writeRemoteFile(ctx, t, sfs, pathAppend(path1, name),
+			make([]byte, size))
+	}
+
+	names := listAllPages(ctx, t, sfs, SimpleFSListPageArg{
+		Path:  path1,
+		Limit: 2,
+	})
+	require.Equal(t, []string{"a", "b", "c", "d", "e"}, names)
+	names = listAllPages(ctx, t, sfs, SimpleFSListPageArg{
+		Path:       path1,
+		Descending: true,
+		Limit:      2,
+	})
+	require.Equal(t, []string{"e", "d", "c", "b", "a"}, names)
+	names = listAllPages(ctx, t, sfs, SimpleFSListPageArg{
+		Path:   path1,
+		SortBy: ListSortBySize,
+		Limit:  3,
+	})
+	require.Equal(t, []string{"c", "e", "a", "d", "b"}, names)
+
+	// A page that exactly fits the rest has no cursor.
+	res, err := sfs.SimpleFSListPage(ctx, SimpleFSListPageArg{
+		Path:  path1,
+		Limit: 5,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 5)
+	require.Equal(t, "", res.NextCursor)
+
+	// A cursor only works with the order it came from.
+	res, err = sfs.SimpleFSListPage(ctx, SimpleFSListPageArg{
+		Path:  path1,
+		Limit: 1,
+	})
+	require.NoError(t, err)
+	_, err = sfs.SimpleFSListPage(ctx, SimpleFSListPageArg{
+		Path:   path1,
+		SortBy: ListSortByMtime,
+		Cursor: res.NextCursor,
+	})
+	require.Equal(t, errBadListCursor, err)
+	_, err = sfs.SimpleFSListPage(ctx, SimpleFSListPageArg{
+		Path:   path1,
+		Cursor: "not a cursor",
+	})
+	require.Equal(t, errBadListCursor, err)
+}
+
+func TestRestoreFile(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path1 := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	filePath := pathAppend(path1, `test.txt`)
+	writeRemoteFile(ctx, t, sfs, filePath, []byte("original contents"))
+	de, err := sfs.SimpleFSStatMetadata(ctx, filePath)
+	require.NoError(t, err)
+	rev := de.LastWriterRevision
+
+	writeRemoteFile(ctx, t, sfs, filePath, []byte("new"))
+	restored, err := sfs.SimpleFSRestoreFile(ctx, SimpleFSRestoreFileArg{
+		Path:     filePath,
+		Revision: rev,
+	})
+	require.NoError(t, err)
+	require.Equal(t, len("original contents"), restored.Size)
+	require.Equal(t, "original contents",
+		string(readRemoteFile(ctx, t, sfs, filePath)))
+
+	// A removed file is recreated.
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	// SimpleFSRemove starts the op in the background, so it might
+	// not be registered in time to wait for it.
+	err = sfs.simpleFSRemove(ctx, keybase1.SimpleFSRemoveArg{
+		OpID: opid,
+		Path: filePath,
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+	_, err = sfs.SimpleFSRestoreFile(ctx, SimpleFSRestoreFileArg{
+		Path:     filePath,
+		Revision: rev,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "original contents",
+		string(readRemoteFile(ctx, t, sfs, filePath)))
+
+	_, err = sfs.SimpleFSRestoreFile(ctx, SimpleFSRestoreFileArg{
+		Path:     pathAppend(path1, `missing.txt`),
+		Revision: rev,
+	})
+	require.IsType(t, libkbfs.NoSuchNameError{}, err)
+	_, err = sfs.SimpleFSRestoreFile(ctx, SimpleFSRestoreFileArg{
+		Path:     path1,
+		Revision: rev,
+	})
+	require.Equal(t, errCantRestoreRoot, err)
+}
+
+func TestRemoveDirTree(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path1 := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	dirPath := pathAppend(path1, `a`)
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  dirPath,
+		Flags: keybase1.OpenFlags_DIRECTORY,
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSClose(ctx, opid)
+	require.NoError(t, err)
+	writeRemoteFile(ctx, t, sfs, pathAppend(dirPath, `test.txt`), []byte("foo"))
+
+	opid, err = sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	// SimpleFSRemove starts the op in the background, so it might
+	// not be registered in time to wait for it.
+	err = sfs.simpleFSRemove(ctx, keybase1.SimpleFSRemoveArg{
+		OpID: opid,
+		Path: dirPath,
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+	_, err = sfs.SimpleFSStat(ctx, dirPath)
+	require.IsType(t, libkbfs.NoSuchNameError{}, err)
+}
+
+func TestWriteStream(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path1 := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	filePath := pathAppend(path1, `test.txt`)
+	writeRemoteFile(ctx, t, sfs, filePath, []byte("old contents"))
+
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSStreamOpen(ctx, SimpleFSStreamOpenArg{
+		OpID: opid,
+		Dest: filePath,
+	})
+	require.NoError(t, err)
+	for _, chunk := range []string{"streamed ", "new ", "contents"} {
+		err = sfs.SimpleFSStreamAppend(ctx, SimpleFSStreamAppendArg{
+			OpID:    opid,
+			Content: []byte(chunk),
+		})
+		require.NoError(t, err)
+	}
+
+	// Nothing changes until the stream is committed.
+	require.Equal(t, "old contents",
+		string(readRemoteFile(ctx, t, sfs, filePath)))
+	de, err := sfs.SimpleFSStreamCommit(ctx, opid)
+	require.NoError(t, err)
+	require.Equal(t, len("streamed new contents"), de.Size)
+	require.Equal(t, "streamed new contents",
+		string(readRemoteFile(ctx, t, sfs, filePath)))
+	err = sfs.SimpleFSStreamAppend(ctx, SimpleFSStreamAppendArg{
+		OpID:    opid,
+		Content: []byte("more"),
+	})
+	require.Equal(t, errNoSuchHandle, err)
+
+	t.Log("Closing an uncommitted stream discards it")
+	opid, err = sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSStreamOpen(ctx, SimpleFSStreamOpenArg{
+		OpID: opid,
+		Dest: pathAppend(path1, `discarded.txt`),
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSStreamAppend(ctx, SimpleFSStreamAppendArg{
+		OpID:    opid,
+		Content: []byte("discarded"),
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSClose(ctx, opid)
+	require.NoError(t, err)
+	children, err := sfs.listChildren(ctx, path1)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Contains(t, children, "test.txt")
+
+	t.Log("Other handles aren't streams")
+	opid, err = sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  filePath,
+		Flags: keybase1.OpenFlags_READ | keybase1.OpenFlags_EXISTING,
+	})
+	require.NoError(t, err)
+	defer sfs.SimpleFSClose(ctx, opid)
+	_, err = sfs.SimpleFSStreamCommit(ctx, opid)
+	require.Equal(t, errNotStream, err)
+}
+
+func TestBackupEndpoints(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path1 := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	filePath := pathAppend(path1, `test.txt`)
+	writeRemoteFile(ctx, t, sfs, filePath, []byte("original contents"))
+	de, err := sfs.SimpleFSStatMetadata(ctx, filePath)
+	require.NoError(t, err)
+	rev := de.LastWriterRevision
+
+	writeRemoteFile(ctx, t, sfs, filePath, []byte("new"))
+	diff, err := sfs.SimpleFSChangesSince(ctx, SimpleFSChangesSinceArg{
+		Path:     path1,
+		Revision: rev,
+	})
+	require.NoError(t, err)
+	require.True(t, diff.NewRevision > libkbfs.MetadataRevision(rev))
+	require.Len(t, diff.Entries, 1)
+	require.Equal(t, "test.txt", diff.Entries[0].Path)
+	require.Equal(t, libkbfs.RevisionDiffModified, diff.Entries[0].Change)
+
+	oldChunks, err := sfs.SimpleFSContentHashes(ctx, SimpleFSRevisionPathArg{
+		Path:     filePath,
+		Revision: rev,
+	})
+	require.NoError(t, err)
+	require.Len(t, oldChunks, 1)
+	require.Equal(t, int64(len("original contents")), oldChunks[0].Length)
+	newChunks, err := sfs.SimpleFSContentHashes(ctx, SimpleFSRevisionPathArg{
+		Path:     filePath,
+		Revision: int64(diff.NewRevision),
+	})
+	require.NoError(t, err)
+	require.Len(t, newChunks, 1)
+	require.NotEqual(t, oldChunks[0].Hash, newChunks[0].Hash)
+
+	content, err := sfs.SimpleFSReadRevision(ctx, SimpleFSReadRevisionArg{
+		Path:     filePath,
+		Revision: rev,
+		Offset:   9,
+		Size:     100,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "contents", string(content.Data))
+
+	_, err = sfs.SimpleFSChangesSince(ctx, SimpleFSChangesSinceArg{
+		Path:     filePath,
+		Revision: rev,
+	})
+	require.Equal(t, errNotFolderRoot, err)
+	_, err = sfs.SimpleFSContentHashes(ctx, SimpleFSRevisionPathArg{
+		Path:     path1,
+		Revision: rev,
+	})
+	require.Equal(t, errInvalidRemotePath, err)
+}
+
+func TestPreflightFolder(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	p, err := sfs.SimpleFSPreflightFolder(ctx, SimpleFSPreflightFolderArg{
+		Path: keybase1.NewPathWithKbfs(`/private/bob@twitter,JDoe`),
+	})
+	require.NoError(t, err)
+	require.Equal(t,
+		libkbfs.CanonicalTlfName("bob@twitter,jdoe"), p.CanonicalName)
+	require.Len(t, p.Assertions, 2)
+	require.Equal(t, "bob@twitter", p.Assertions[0].Unresolved.String())
+	require.Equal(t, "jdoe", p.Assertions[1].Username.String())
+	require.True(t, p.PendingSignup)
+
+	_, err = sfs.SimpleFSPreflightFolder(ctx, SimpleFSPreflightFolderArg{
+		Path: keybase1.NewPathWithKbfs(`/private/jdoe/test.txt`),
+	})
+	require.Equal(t, errNotFolderRoot, err)
+}
+
+func TestSBSFolderEndpoints(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path1 := keybase1.NewPathWithKbfs(`/private/jdoe,bob@twitter`)
+	writeRemoteFile(ctx, t, sfs, pathAppend(path1, `test.txt`), []byte("foo"))
+
+	folders, err := sfs.SimpleFSListSBSFolders(ctx)
+	require.NoError(t, err)
+	require.Len(t, folders, 1)
+	require.Equal(t,
+		libkbfs.CanonicalTlfName("bob@twitter,jdoe"), folders[0].Name)
+
+	newName, err := sfs.SimpleFSModifySBSFolder(ctx,
+		SimpleFSModifySBSFolderArg{Path: path1, NewName: "jdoe,bob@github"})
+	require.NoError(t, err)
+	require.Equal(t, libkbfs.CanonicalTlfName("bob@github,jdoe"), newName)
+	path2 := keybase1.NewPathWithKbfs(`/private/jdoe,bob@github`)
+	require.Equal(t, []byte("foo"),
+		readRemoteFile(ctx, t, sfs, pathAppend(path2, `test.txt`)))
+
+	err = sfs.SimpleFSCancelSBSFolder(ctx, path2)
+	require.NoError(t, err)
+	folders, err = sfs.SimpleFSListSBSFolders(ctx)
+	require.NoError(t, err)
+	require.Len(t, folders, 0)
+
+	err = sfs.SimpleFSCancelSBSFolder(ctx, pathAppend(path2, `test.txt`))
+	require.Equal(t, errNotFolderRoot, err)
+}
+
+func TestFavoriteCleanupEndpoints(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	clock := &libkbfs.TestClock{}
+	clock.Set(time.Now())
+	config.SetClock(clock)
+	sfs := newSimpleFS(config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path1 := keybase1.NewPathWithKbfs(`/private/jdoe,bob@twitter`)
+	writeRemoteFile(ctx, t, sfs, pathAppend(path1, `test.txt`), []byte("foo"))
+	clock.Add(48 * time.Hour)
+
+	arg := SimpleFSSuggestFavoriteCleanupArg{StaleAfter: 24 * time.Hour}
+	suggestions, err := sfs.SimpleFSSuggestFavoriteCleanup(ctx, arg)
+	require.NoError(t, err)
+	require.Len(t, suggestions, 1)
+	require.Equal(t, "bob@twitter,jdoe", suggestions[0].Name)
+	require.False(t, suggestions[0].Public)
+
+	err = sfs.SimpleFSDeleteFavorites(ctx,
+		SimpleFSDeleteFavoritesArg{Paths: []keybase1.Path{path1}})
+	require.NoError(t, err)
+	suggestions, err = sfs.SimpleFSSuggestFavoriteCleanup(ctx, arg)
+	require.NoError(t, err)
+	require.Len(t, suggestions, 0)
+
+	err = sfs.SimpleFSDeleteFavorites(ctx, SimpleFSDeleteFavoritesArg{
+		Paths: []keybase1.Path{pathAppend(path1, `test.txt`)}})
+	require.Equal(t, errNotFolderRoot, err)
+}
@@ -0,0 +1,199 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const (
+	// streamSyncBytes is how much data a write stream appends
+	// between syncs.  Syncing moves the appended data out of
+	// memory, and into the journal when journaling is on, so a
+	// stream can be of any length without being staged anywhere
+	// else first.
+	streamSyncBytes = 8 * 1024 * 1024
+	// streamTempPrefix starts the names of the files holding the
+	// data of write streams until they're committed.
+	streamTempPrefix = ".simplefs_stream_"
+)
+
+var errNotStream = simpleFSError{"Handle is not a write stream"}
+
+// writeStream is a write session of data of unknown length.  The data
+// is appended to a temporary file next to the destination, which is
+// renamed onto the destination when the stream is committed.
+type writeStream struct {
+	lock     sync.Mutex
+	parent   libkbfs.Node
+	name     string
+	tempName string
+	offset   int64
+	unsynced int64
+}
+
+// SimpleFSStreamOpenArg is the argument to SimpleFSStreamOpen.
+type SimpleFSStreamOpenArg struct {
+	OpID keybase1.OpID
+	Dest keybase1.Path
+}
+
+// SimpleFSStreamAppendArg is the argument to SimpleFSStreamAppend.
+type SimpleFSStreamAppendArg struct {
+	OpID    keybase1.OpID
+	Content []byte
+}
+
+func (a SimpleFSStreamAppendArg) String() string {
+	return fmt.Sprintf("%X (%d bytes)", a.OpID, len(a.Content))
+}
+
+func (k *SimpleFS) getStream(opid keybase1.OpID) (*handle, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	h, ok := k.handles[opid]
+	if !ok {
+		return nil, errNoSuchHandle
+	}
+	if h.stream == nil {
+		return nil, errNotStream
+	}
+	return h, nil
+}
+
+// SimpleFSStreamOpen - Start a write stream to the file at Dest,
+// which is replaced by the streamed data once the stream is committed
+// with SimpleFSStreamCommit.  Until then, the data is kept in a hidden
+// file next to Dest.  Closing the stream without committing it
+// discards the data.
+func (k *SimpleFS) SimpleFSStreamOpen(ctx context.Context,
+	arg SimpleFSStreamOpenArg) (err error) {
+	ctx, err = k.startSyncOp(ctx, "StreamOpen", arg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	parent, name, err := k.getRemoteNodeParent(ctx, arg.Dest)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errInvalidRemotePath
+	}
+
+	tempName := streamTempPrefix + hex.EncodeToString(arg.OpID[:])
+	node, _, err := k.config.KBFSOps().CreateFile(
+		ctx, parent, tempName, false, libkbfs.WithExcl)
+	if err != nil {
+		return err
+	}
+
+	k.lock.Lock()
+	k.handles[arg.OpID] = &handle{
+		node: node,
+		path: arg.Dest,
+		stream: &writeStream{
+			parent:   parent,
+			name:     name,
+			tempName: tempName,
+		},
+	}
+	k.lock.Unlock()
+	return nil
+}
+
+// SimpleFSStreamAppend - Append content to a write stream.
+func (k *SimpleFS) SimpleFSStreamAppend(ctx context.Context,
+	arg SimpleFSStreamAppendArg) (err error) {
+	h, err := k.getStream(arg.OpID)
+	if err != nil {
+		return err
+	}
+	ctx, err = k.startSyncOp(ctx, "StreamAppend", arg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	s := h.stream
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err = k.config.KBFSOps().Write(ctx, h.node, arg.Content, s.offset)
+	if err != nil {
+		return err
+	}
+	s.offset += int64(len(arg.Content))
+	s.unsynced += int64(len(arg.Content))
+	if s.unsynced < streamSyncBytes {
+		return nil
+	}
+	err = k.config.KBFSOps().Sync(ctx, h.node)
+	if err != nil {
+		return err
+	}
+	s.unsynced = 0
+	return nil
+}
+
+// SimpleFSStreamCommit - Finish a write stream, replacing its
+// destination with the streamed data, and return the destination's
+// new stat.  If committing fails, the stream stays open.
+func (k *SimpleFS) SimpleFSStreamCommit(ctx context.Context,
+	opid keybase1.OpID) (_ keybase1.Dirent, err error) {
+	h, err := k.getStream(opid)
+	if err != nil {
+		return keybase1.Dirent{}, err
+	}
+	ctx, err = k.startSyncOp(ctx, "StreamCommit", opid)
+	if err != nil {
+		return keybase1.Dirent{}, err
+	}
+	defer func() { err = k.doneSyncOp(ctx, err) }()
+
+	err = k.commitStream(ctx, h)
+	if err != nil {
+		return keybase1.Dirent{}, err
+	}
+
+	k.lock.Lock()
+	delete(k.handles, opid)
+	k.lock.Unlock()
+	return wrapStat(k.config.KBFSOps().Stat(ctx, h.node))
+}
+
+// commitStream syncs the data of a write stream and renames it onto
+// the stream's destination.
+func (k *SimpleFS) commitStream(ctx context.Context, h *handle) error {
+	s := h.stream
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := k.config.KBFSOps().Sync(ctx, h.node)
+	if err != nil {
+		return err
+	}
+	return k.config.KBFSOps().Rename(
+		ctx, s.parent, s.tempName, s.parent, s.name)
+}
+
+// abortStream removes the data of an uncommitted write stream.
+func (k *SimpleFS) abortStream(ctx context.Context, h *handle) error {
+	s := h.stream
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// Removing a file with unsynced writes would leave the bytes
+	// they take up in memory counted as dirty, so sync it first.
+	err := k.config.KBFSOps().Sync(ctx, h.node)
+	if err != nil {
+		return err
+	}
+	return k.config.KBFSOps().RemoveEntry(ctx, s.parent, s.tempName)
+}
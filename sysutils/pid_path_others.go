@@ -2,11 +2,11 @@
 // Use of this source code is governed by a BSD
 // license that can be found in the LICENSE file.
 
-// +build !darwin,!linux
+// +build !darwin,!linux,!windows
 
 package sysutils
 
 // GetExecPathFromPID returns the process's executable path for given PID.
-func GetExecPathFromPID(pid int) (string, error) {
+func GetExecPathFromPID(pid uint32) (string, error) {
 	return "", NotImplementedError{}
 }
@@ -0,0 +1,42 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package sysutils
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	maxLongPath                    = 32768
+)
+
+var procQueryFullProcessImageName = windows.NewLazySystemDLL(
+	"kernel32.dll").NewProc("QueryFullProcessImageNameW")
+
+// GetExecPathFromPID returns the process's executable path for given PID.
+func GetExecPathFromPID(pid uint32) (string, error) {
+	h, err := windows.OpenProcess(
+		processQueryLimitedInformation, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(h)
+
+	buf := make([]uint16, maxLongPath)
+	size := uint32(len(buf))
+	r1, _, e1 := syscall.Syscall6(procQueryFullProcessImageName.Addr(), 4,
+		uintptr(h), 0, uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(unsafe.Pointer(&size)), 0, 0)
+	if r1 == 0 {
+		return "", e1
+	}
+	return syscall.UTF16ToString(buf[:size]), nil
+}
@@ -0,0 +1,378 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// These tests drive several devices through a random, but
+// reproducible, interleaving of operations, network partitions and
+// delayed journal flushes, and then check that all the devices
+// converge on the same state without losing any non-conflicting
+// changes.
+
+package test
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var simSeed = flag.Int64("sim-seed", 0,
+	"If non-zero, run the simulation tests with only this seed")
+
+var simFlushDelays = flag.Bool("sim-flush-delays", true,
+	"Also simulate devices that get updates but whose journal "+
+		"flushes are delayed")
+
+// simDefaultSeeds are the seeds the simulation tests run with by
+// default.  When a simulation finds a bug, add its seed here once
+// it's fixed, or to the simulation's knownFailures until then.
+var simDefaultSeeds = []int64{1, 2, 3, 4, 5, 6, 11, 14}
+
+// simKnownFailuresIssue tracks the conflict resolution bugs that the
+// knownFailures seeds still hit.
+const simKnownFailuresIssue = "maniacs-oss/kbfs#synth-385"
+
+// simDeviceState is the network state of a simulated device.
+type simDeviceState int
+
+const (
+	// simOnline devices flush their journals right away and get
+	// updates from the server.
+	simOnline simDeviceState = iota
+	// simFlushDelayed devices still get updates from the server,
+	// but their journals are paused, so their changes stay local.
+	simFlushDelayed
+	// simPartitioned devices can't reach the server at all: their
+	// journals are paused and they don't get updates.
+	simPartitioned
+)
+
+// simDevice is a simulated device.  Each device belongs to a
+// different user, all of whom share the TLF under test.
+type simDevice struct {
+	user  username
+	state simDeviceState
+	// files are the live files in the device's own directory,
+	// which no other device touches.
+	files    []string
+	nextFile int
+}
+
+// simConfig describes the shape of a simulation.
+type simConfig struct {
+	devices     []username
+	steps       int
+	sharedFiles int
+	// knownFailures maps the default seeds that this simulation
+	// still fails with to the bug they hit.  They're skipped
+	// unless they're asked for with -sim-seed.
+	knownFailures map[int64]string
+}
+
+// simulation generates a script of DSL operations from a seeded
+// random number generator, along with the expected contents of the
+// devices' own directories.
+type simulation struct {
+	config  simConfig
+	rng     *rand.Rand
+	devices []*simDevice
+	// model maps the path of every file in the devices' own
+	// directories to its expected contents.  The shared files
+	// may legitimately end up renamed by conflict resolution, so
+	// they're only checked for convergence.
+	model     map[string]string
+	script    []optionOp
+	nextWrite int
+}
+
+func newSimulation(config simConfig, seed int64) *simulation {
+	s := &simulation{
+		config: config,
+		rng:    rand.New(rand.NewSource(seed)),
+		model:  make(map[string]string),
+	}
+	for _, u := range config.devices {
+		s.devices = append(s.devices, &simDevice{user: u})
+	}
+	return s
+}
+
+func (s *simulation) contents(d *simDevice) string {
+	s.nextWrite++
+	return fmt.Sprintf("%s-%d", d.user, s.nextWrite)
+}
+
+// do adds the given operations, as d, to the script.
+func (s *simulation) do(d *simDevice, fops ...fileOp) {
+	if d.state == simOnline {
+		// Online devices flush right away, so that the other
+		// devices see their changes before making their own.
+		fops = append(fops, flushJournal())
+	} else {
+		// Syncing from the server would wait for the paused
+		// journal to flush.
+		fops = append([]fileOp{noSync()}, fops...)
+	}
+	s.script = append(s.script, as(d.user, fops...))
+}
+
+func (s *simulation) heal(d *simDevice) {
+	switch d.state {
+	case simFlushDelayed:
+		s.do(d, resumeJournal(), flushJournal())
+	case simPartitioned:
+		s.do(d, resumeJournal(), flushJournal(), reenableUpdates())
+	}
+	d.state = simOnline
+}
+
+func (s *simulation) pickFile(d *simDevice) (int, string) {
+	i := s.rng.Intn(len(d.files))
+	return i, d.files[i]
+}
+
+func (s *simulation) newFileName(d *simDevice) string {
+	d.nextFile++
+	return path.Join(string(d.user), fmt.Sprintf("f%d", d.nextFile))
+}
+
+// step adds one randomly-chosen operation, by a randomly-chosen
+// device, to the script.
+func (s *simulation) step() {
+	d := s.devices[s.rng.Intn(len(s.devices))]
+	r := s.rng.Intn(20)
+
+	if d.state != simOnline {
+		if r < 4 {
+			s.heal(d)
+			return
+		}
+	} else if r < 2 {
+		if r == 0 && *simFlushDelays {
+			s.do(d, pauseJournal())
+			d.state = simFlushDelayed
+		} else {
+			s.do(d, disableUpdates(), pauseJournal())
+			d.state = simPartitioned
+		}
+		return
+	}
+
+	r = s.rng.Intn(10)
+	if len(d.files) == 0 && r < 7 {
+		r = 0
+	}
+	switch {
+	case r < 3:
+		name := s.newFileName(d)
+		contents := s.contents(d)
+		s.do(d, mkfile(name, contents))
+		d.files = append(d.files, name)
+		s.model[name] = contents
+	case r < 5:
+		_, name := s.pickFile(d)
+		contents := s.contents(d)
+		s.do(d, write(name, contents))
+		s.model[name] = contents
+	case r < 6:
+		i, name := s.pickFile(d)
+		s.do(d, rm(name))
+		d.files = append(d.files[:i], d.files[i+1:]...)
+		delete(s.model, name)
+	case r < 7:
+		i, name := s.pickFile(d)
+		newName := s.newFileName(d)
+		s.do(d, rename(name, newName))
+		d.files[i] = newName
+		s.model[newName] = s.model[name]
+		delete(s.model, name)
+	default:
+		name := fmt.Sprintf("shared/f%d", s.rng.Intn(s.config.sharedFiles))
+		s.do(d, write(name, s.contents(d)))
+	}
+}
+
+// generate returns the whole simulation as a single DSL operation.
+func (s *simulation) generate() optionOp {
+	var dirs []fileOp
+	dirs = append(dirs, mkdir("shared"))
+	for _, d := range s.devices {
+		dirs = append(dirs, mkdir(string(d.user)))
+	}
+	s.script = append(s.script, as(s.devices[0].user, dirs...))
+	for _, d := range s.devices {
+		s.script = append(s.script, as(d.user, enableJournal()))
+	}
+
+	for i := 0; i < s.config.steps; i++ {
+		s.step()
+	}
+
+	for _, d := range s.devices {
+		s.heal(d)
+	}
+	return sequential(append(s.script, expectConvergence(s.model,
+		s.config.devices...))...)
+}
+
+// snapshotDir adds the contents of every file and symlink under dir
+// to snap, keyed by path, and marks every directory.
+func snapshotDir(c *ctx, dir Node, dirPath string,
+	snap map[string]string) error {
+	children, err := c.engine.GetDirChildrenTypes(c.user, dir)
+	if err != nil {
+		return err
+	}
+	for name, ty := range children {
+		p := path.Join(dirPath, name)
+		node, symPath, err := c.engine.Lookup(c.user, dir, name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case symPath != "":
+			snap[p] = "SYM:" + symPath
+		case ty == "DIR":
+			snap[p] = "DIR"
+			if err := snapshotDir(c, node, p, snap); err != nil {
+				return err
+			}
+		default:
+			var contents []byte
+			buf := make([]byte, 4096)
+			for {
+				n, err := c.engine.ReadFile(
+					c.user, node, int64(len(contents)), buf)
+				if err != nil {
+					return err
+				}
+				contents = append(contents, buf[:n]...)
+				if n < len(buf) {
+					break
+				}
+			}
+			snap[p] = string(contents)
+		}
+	}
+	return nil
+}
+
+func snapshotInto(snap map[string]string) fileOp {
+	return fileOp{func(c *ctx) error {
+		return snapshotDir(c, c.rootNode, "", snap)
+	}, Defaults, "snapshotInto()"}
+}
+
+func syncFromServer() fileOp {
+	return fileOp{func(c *ctx) error {
+		return c.engine.SyncFromServerForTesting(
+			c.user, c.tlfName, c.tlfIsPublic)
+	}, Defaults, "syncFromServer()"}
+}
+
+func sortedKeys(snap map[string]string) []string {
+	keys := make([]string, 0, len(snap))
+	for k := range snap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expectConvergence checks that all the given users see exactly the
+// same TLF contents, and that every file in model (and no other file)
+// exists with the given contents under the users' own directories.
+func expectConvergence(model map[string]string,
+	users ...username) optionOp {
+	return func(o *opt) {
+		// Conflict resolution on one device can make new
+		// revisions that the others haven't seen yet, so sync
+		// everyone twice.
+		for i := 0; i < 2; i++ {
+			for _, u := range users {
+				as(u, syncFromServer())(o)
+			}
+		}
+
+		var first map[string]string
+		for _, u := range users {
+			snap := make(map[string]string)
+			as(u, snapshotInto(snap))(o)
+			if first == nil {
+				first = snap
+				continue
+			}
+			if !reflect.DeepEqual(first, snap) {
+				o.tb.Fatalf("%s and %s diverged:\n%v\nvs.\n%v",
+					users[0], u, first, snap)
+			}
+		}
+
+		own := make(map[string]string)
+		for _, u := range users {
+			for p, contents := range first {
+				if path.Dir(p) == string(u) {
+					own[p] = contents
+				}
+			}
+		}
+		if !reflect.DeepEqual(model, own) {
+			o.tb.Fatalf("Lost non-conflicting changes: expected files %v, "+
+				"got %v", sortedKeys(model), sortedKeys(own))
+		}
+	}
+}
+
+func runSimulation(t *testing.T, config simConfig) {
+	seeds := simDefaultSeeds
+	if *simSeed != 0 {
+		seeds = []int64{*simSeed}
+	}
+	for _, seed := range seeds {
+		seed := seed // capture range variable.
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			if bug, ok := config.knownFailures[seed]; ok && *simSeed == 0 {
+				t.Skipf("Known failure, see %s: %s",
+					simKnownFailuresIssue, bug)
+			}
+			t.Logf("Rerun with -sim-seed=%d", seed)
+			test(t, journal(),
+				users(config.devices...),
+				newSimulation(config, seed).generate(),
+			)
+		})
+	}
+}
+
+// Two devices, with lots of contention on a single shared file.
+func TestSimulationTwoDevices(t *testing.T) {
+	runSimulation(t, simConfig{
+		devices:     []username{alice, bob},
+		steps:       40,
+		sharedFiles: 1,
+		knownFailures: map[int64]string{
+			// Fails intermittently.
+			2: "a resolution that's replayed after its journal " +
+				"conflicts again keeps a stale update from the old " +
+				"resolution op, so the next resolution fails with " +
+				"\"No chain found\"",
+		},
+	})
+}
+
+// Four devices, each going through several partitions.
+func TestSimulationFourDevices(t *testing.T) {
+	runSimulation(t, simConfig{
+		devices:     []username{alice, bob, charlie, eve},
+		steps:       60,
+		sharedFiles: 3,
+		knownFailures: map[int64]string{
+			4: "conflict resolution drops a live block",
+			6: "conflict resolution drops a live block",
+		},
+	})
+}